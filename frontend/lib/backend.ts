@@ -865,11 +865,18 @@ export async function validateCSV({
   }
 }
 
+// Preprocessing applied by the train job to the text or source column.
+export interface TextPreprocessing {
+  lowercase?: boolean;
+  strip_html?: boolean;
+}
+
 interface TokenOptions {
   target_labels: string[];
   source_column: string;
   target_column: string;
   default_tag: string;
+  preprocessing?: TextPreprocessing;
 }
 
 interface TrainTokenClassifierParams {
@@ -1036,6 +1043,79 @@ export function create_enterprise_search_workflow({
   });
 }
 
+export interface WorkflowTemplateInput {
+  name: string;
+  description: string;
+  required: boolean;
+}
+
+export interface WorkflowTemplate {
+  name: string;
+  title: string;
+  description: string;
+  inputs: WorkflowTemplateInput[];
+  model_types: string[];
+}
+
+export interface WorkflowTemplateModel {
+  model_id: string;
+  model_name: string;
+  model_type: string;
+}
+
+export interface WorkflowTemplateResponse {
+  template: string;
+  model_id: string;
+  models: WorkflowTemplateModel[];
+}
+
+export function listWorkflowTemplates(): Promise<WorkflowTemplate[]> {
+  const accessToken = getAccessToken();
+  axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
+
+  return new Promise((resolve, reject) => {
+    axios
+      .get<WorkflowTemplate[]>(`${thirdaiPlatformBaseUrl}/api/v2/workflow/templates`)
+      .then((res) => {
+        resolve(res.data);
+      })
+      .catch((err) => {
+        if (err.response && err.response.data) {
+          reject(new Error(err.response.data.message || 'Failed to list workflow templates'));
+        } else {
+          reject(new Error('Failed to list workflow templates'));
+        }
+      });
+  });
+}
+
+// The inputs depend on the template, see the inputs listed for the template.
+export function instantiateWorkflowTemplate(
+  template: string,
+  inputs: { model_name: string; [input: string]: any }
+): Promise<WorkflowTemplateResponse> {
+  const accessToken = getAccessToken();
+  axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
+
+  return new Promise((resolve, reject) => {
+    axios
+      .post<WorkflowTemplateResponse>(
+        `${thirdaiPlatformBaseUrl}/api/v2/workflow/templates/${encodeURIComponent(template)}`,
+        inputs
+      )
+      .then((res) => {
+        resolve(res.data);
+      })
+      .catch((err) => {
+        if (err.response && err.response.data) {
+          reject(new Error(err.response.data.message || 'Failed to create workflow from template'));
+        } else {
+          reject(new Error('Failed to create workflow from template'));
+        }
+      });
+  });
+}
+
 export interface Attributes {
   llm_provider?: string;
   default_mode?: string;
@@ -1120,22 +1200,26 @@ interface StartWorkflowResponse {
 
 interface StartWorkflowRequest {
   deployment_name?: string;
-  autoscaling_enabled: boolean;
+  preset?: string;
+  autoscaling_enabled?: boolean;
   autoscaling_min?: number;
   autoscaling_max?: number;
   memory?: number;
 }
 
+// If a preset is given the deployment settings are taken from the preset, so
+// autoscaling cannot also be specified.
 export function start_workflow(
   model_id: string,
-  autoscalingEnabled: boolean
+  autoscalingEnabled: boolean,
+  preset?: string
 ): Promise<StartWorkflowResponse> {
   const accessToken = getAccessToken();
   axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
 
-  const requestBody: StartWorkflowRequest = {
-    autoscaling_enabled: autoscalingEnabled,
-  };
+  const requestBody: StartWorkflowRequest = preset
+    ? { preset }
+    : { autoscaling_enabled: autoscalingEnabled };
 
   return new Promise((resolve, reject) => {
     axios
@@ -1156,6 +1240,42 @@ export function start_workflow(
   });
 }
 
+export interface DeploymentPreset {
+  name: string;
+  description: string;
+  settings: {
+    autoscaling_enabled: boolean;
+    autoscaling_min: number;
+    autoscaling_max: number;
+    autoscaling_target_cpu: number;
+    scale_to_zero_idle_seconds: number;
+    memory: number;
+    llm_provider: string;
+  };
+  created_at: string;
+  updated_at: string;
+}
+
+export function listDeploymentPresets(): Promise<DeploymentPreset[]> {
+  const accessToken = getAccessToken();
+  axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
+
+  return new Promise((resolve, reject) => {
+    axios
+      .get<DeploymentPreset[]>(`${thirdaiPlatformBaseUrl}/api/v2/deploy/presets`)
+      .then((res) => {
+        resolve(res.data);
+      })
+      .catch((err) => {
+        if (err.response && err.response.data) {
+          reject(new Error(err.response.data.detail || 'Failed to list deployment presets'));
+        } else {
+          reject(new Error('Failed to list deployment presets'));
+        }
+      });
+  });
+}
+
 interface StopWorkflowResponse {
   status_code: number;
   message: string;
@@ -1881,6 +2001,26 @@ interface PredictionResult {
     prediction_results: {
       query_text: string;
       predicted_classes: PredictionClass[];
+      abstained?: boolean;
+    };
+    time_taken: number;
+  };
+}
+
+export interface TokenAttribution {
+  token: string;
+  score: number;
+}
+
+export interface ExplanationResult {
+  status: string;
+  message: string;
+  data: {
+    explanation: {
+      query_text: string;
+      class_name: string;
+      score: number;
+      attributions: TokenAttribution[];
     };
     time_taken: number;
   };
@@ -1923,6 +2063,22 @@ export function useTextClassificationEndpoints() {
     }
   };
 
+  const explain = async (query: string, className?: string): Promise<ExplanationResult> => {
+    axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
+
+    try {
+      const response = await axios.post<ExplanationResult>(`${deploymentUrl}/explain`, {
+        text: query,
+        class_name: className,
+      });
+      return response.data;
+    } catch (error) {
+      console.error('Error explaining prediction:', error);
+      alert('Error explaining prediction:' + error);
+      throw new Error('Failed to explain prediction');
+    }
+  };
+
   const getTextFromFile = async (file: File): Promise<string[]> => {
     axios.defaults.headers.common.Authorization = `Bearer ${accessToken}`;
     const formData = new FormData();
@@ -1946,6 +2102,7 @@ export function useTextClassificationEndpoints() {
     workflowName,
     getTextFromFile,
     predict,
+    explain,
   };
 }
 
@@ -2573,6 +2730,7 @@ interface ModelOptions {
   label_column: string;
   n_target_classes: number;
   delimiter?: string;
+  preprocessing?: TextPreprocessing;
 }
 
 interface DataFile {
@@ -2586,6 +2744,12 @@ interface TrainOptions {
   batch_size?: number;
   max_in_memory_batches?: number;
   test_split?: number;
+  test_split_seed?: number;
+  test_split_strategy?: 'random' | 'stratified';
+  class_weighting?: 'none' | 'balanced' | 'custom';
+  class_weights?: Record<string, number>;
+  oversampling?: 'none' | 'random';
+  oversampling_ratio?: number;
 }
 
 interface NLPTextTrainRequest {
@@ -2610,6 +2774,7 @@ export async function trainNLPTextModel(params: {
   uploadId: string;
   textColumn?: string;
   labelColumn?: string;
+  preprocessing?: TextPreprocessing;
   nTargetClasses: number;
   baseModelId?: string;
   trainOptions?: TrainOptions;
@@ -2626,6 +2791,7 @@ export async function trainNLPTextModel(params: {
       label_column: params.doc_classification ? 'label' : params.labelColumn || 'label',
       n_target_classes: params.nTargetClasses,
       delimiter: ',',
+      preprocessing: params.preprocessing,
     },
     data: {
       supervised_files: [
@@ -2667,3 +2833,52 @@ export async function trainNLPTextModel(params: {
     throw new Error('Failed to train NLP model');
   }
 }
+
+export type SearchResultType = 'model' | 'team' | 'user' | 'upload';
+
+export interface SearchResult {
+  type: SearchResultType;
+  id: string;
+  name: string;
+  field: string;
+  match: string;
+  score: number;
+  username?: string;
+}
+
+export interface SearchResponse {
+  query: string;
+  results: SearchResult[];
+}
+
+export async function search(
+  query: string,
+  types?: SearchResultType[],
+  limit?: number
+): Promise<SearchResponse> {
+  const accessToken = getAccessToken();
+
+  const params: Record<string, string> = { q: query };
+  if (types && types.length > 0) {
+    params.types = types.join(',');
+  }
+  if (limit) {
+    params.limit = limit.toString();
+  }
+
+  try {
+    const response = await axios.get<SearchResponse>(`${thirdaiPlatformBaseUrl}/api/v2/search`, {
+      params,
+      headers: {
+        Authorization: `Bearer ${accessToken}`,
+      },
+    });
+
+    return response.data;
+  } catch (error) {
+    if (axios.isAxiosError(error) && error.response?.data) {
+      throw new Error(error.response.data || 'Failed to search');
+    }
+    throw new Error('Failed to search');
+  }
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime/multipart"
+	"os"
 	"time"
 )
 
@@ -116,6 +118,22 @@ func (c *KnowledgeExtractionClient) ListReports() ([]Report, error) {
 	return res.Data, err
 }
 
+// ExportReport downloads the report as a json, docx, or pdf document.
+func (c *KnowledgeExtractionClient) ExportReport(reportId, format, dstPath string) error {
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return c.Get(fmt.Sprintf("/%v/report/%v/export", c.deploymentId(), reportId)).Param("format", format).Process(
+		func(body io.Reader) error {
+			_, err := io.Copy(dst, body)
+			return err
+		},
+	)
+}
+
 type Question struct {
 	QuestionId   string   `json:"question_id"`
 	QuestionText string   `json:"question_text"`
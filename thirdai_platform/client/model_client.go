@@ -1,13 +1,21 @@
 package client
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strconv"
+	"sync"
 	"thirdai_platform/model_bazaar/services"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
 )
 
 type ModelClient struct {
@@ -32,7 +40,7 @@ func (c *ModelClient) deploymentId() string {
 }
 
 func (c *ModelClient) DeploymentHealthy() bool {
-	err := c.Get(fmt.Sprintf("/%v/health", c.deploymentId())).Do(nil)
+	err := c.Get(fmt.Sprintf("/%v/health", c.deploymentId())).NoRetry().Do(nil)
 	return err == nil
 }
 
@@ -134,7 +142,11 @@ func (c *ModelClient) Download(dstPath string) error {
 		return err
 	}
 
-	return c.Get(fmt.Sprintf("/api/v2/model/%v/download", c.modelId)).Process(
+	defer dst.Close()
+
+	// Models stored with zstd are sent compressed, and are decompressed as they
+	// are received.
+	return c.Get(fmt.Sprintf("/api/v2/model/%v/download", c.modelId)).Header("Accept-Encoding", "zstd").Process(
 		func(body io.Reader) error {
 			_, err := io.Copy(dst, body)
 			return err
@@ -142,6 +154,188 @@ func (c *ModelClient) Download(dstPath string) error {
 	)
 }
 
+func (c *ModelClient) DownloadManifest(partSize int64) (services.DownloadManifest, error) {
+	req := c.Get(fmt.Sprintf("/api/v2/model/%v/download/manifest", c.modelId))
+	if partSize > 0 {
+		req = req.Param("part_size", strconv.FormatInt(partSize, 10))
+	}
+	var res services.DownloadManifest
+	err := req.Do(&res)
+	return res, err
+}
+
+// downloadProgress records the parts of an archive that have been downloaded,
+// so that an interrupted download can be resumed.
+type downloadProgress struct {
+	ETag      string `json:"etag"`
+	PartSize  int64  `json:"part_size"`
+	Completed []int  `json:"completed"`
+}
+
+func loadDownloadProgress(path string, manifest services.DownloadManifest) map[int]bool {
+	completed := make(map[int]bool)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return completed
+	}
+	var progress downloadProgress
+	if err := json.Unmarshal(data, &progress); err != nil || progress.ETag != manifest.ETag || progress.PartSize != manifest.PartSize {
+		return completed
+	}
+	for _, part := range progress.Completed {
+		completed[part] = true
+	}
+	return completed
+}
+
+func saveDownloadProgress(path string, manifest services.DownloadManifest, completed map[int]bool) error {
+	progress := downloadProgress{ETag: manifest.ETag, PartSize: manifest.PartSize}
+	for part := range completed {
+		progress.Completed = append(progress.Completed, part)
+	}
+	slices.Sort(progress.Completed)
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// DownloadParallel downloads the model archive in parts of partSize bytes, with
+// up to parallelism range requests at a time. A partSize of 0 uses the default
+// part size of the server. The parts that are downloaded are recorded next to
+// dstPath, so calling DownloadParallel again after it fails only downloads the
+// missing parts, unless the archive changed in the meantime. The archive is
+// verified against its checksum once all parts are downloaded.
+func (c *ModelClient) DownloadParallel(dstPath string, parallelism int, partSize int64) error {
+	manifest, err := c.DownloadManifest(partSize)
+	if err != nil {
+		return err
+	}
+
+	archivePath, progressPath := dstPath+".partial", dstPath+".progress"
+
+	completed := loadDownloadProgress(progressPath, manifest)
+	if len(completed) == 0 {
+		// Data from a download of a different archive is discarded.
+		if err := os.Remove(archivePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	archive, err := os.OpenFile(archivePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	if err := archive.Truncate(manifest.Size); err != nil {
+		return err
+	}
+
+	parts := make(chan services.DownloadPart)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for i := 0; i < max(parallelism, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				req := c.Get(fmt.Sprintf("/api/v2/model/%v/download", c.modelId)).Range(part.Offset, part.Length, manifest.ETag)
+				if manifest.ContentEncoding != "" {
+					req = req.Header("Accept-Encoding", manifest.ContentEncoding)
+				}
+				err := req.Process(func(body io.Reader) error {
+					n, err := io.Copy(io.NewOffsetWriter(archive, part.Offset), io.LimitReader(body, part.Length))
+					if err != nil {
+						return err
+					}
+					if n != part.Length {
+						return fmt.Errorf("expected %d bytes for part %d, got %d", part.Length, part.Index, n)
+					}
+					return nil
+				})
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error downloading part %d: %w", part.Index, err))
+				} else {
+					completed[part.Index] = true
+					if err := saveDownloadProgress(progressPath, manifest, completed); err != nil {
+						errs = append(errs, fmt.Errorf("error saving download progress: %w", err))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, part := range manifest.Parts {
+		if !completed[part.Index] {
+			parts <- part
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, archive); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != manifest.Sha256 {
+		// The parts cannot be trusted, so the next attempt starts over.
+		os.Remove(progressPath)
+		return fmt.Errorf("downloaded archive has checksum %v, expected %v", checksum, manifest.Sha256)
+	}
+
+	if manifest.ContentEncoding == "zstd" {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := decompressZstdFile(archive, dstPath); err != nil {
+			return err
+		}
+		archive.Close()
+		os.Remove(archivePath)
+	} else {
+		archive.Close()
+		if err := os.Rename(archivePath, dstPath); err != nil {
+			return err
+		}
+	}
+
+	os.Remove(progressPath)
+	return nil
+}
+
+func decompressZstdFile(src io.Reader, dstPath string) error {
+	decoder, err := zstd.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("error decompressing model archive: %w", err)
+	}
+	defer decoder.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, decoder); err != nil {
+		return fmt.Errorf("error decompressing model archive: %w", err)
+	}
+	return nil
+}
+
 func (c *ModelClient) GetPermissions() (services.ModelPermissions, error) {
 	var res services.ModelPermissions
 	err := c.Get(fmt.Sprintf("/api/v2/model/%v/permissions", c.modelId)).Do(&res)
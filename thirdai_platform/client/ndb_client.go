@@ -36,7 +36,7 @@ func (c *NdbClient) Search(query string, topk int) ([]NdbSearchResult, error) {
 	body := ndbSearchParams{Query: query, Topk: topk}
 
 	var res wrappedData[ndbSearchResults]
-	err := c.Post(fmt.Sprintf("/%v/search", c.deploymentId())).Json(body).Do(&res)
+	err := c.Post(fmt.Sprintf("/%v/search", c.deploymentId())).Json(body).Idempotent().Do(&res)
 	if err != nil {
 		return nil, err
 	}
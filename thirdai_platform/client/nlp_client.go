@@ -29,7 +29,7 @@ func (c *NlpTokenClient) Predict(text string, topk int) (NlpTokenPredictions, er
 	body := nlpPredictParams{Text: text, Topk: topk}
 
 	var res nlpPredictResults[NlpTokenPredictions]
-	err := c.Post(fmt.Sprintf("/%v/predict", c.deploymentId())).Json(body).Do(&res)
+	err := c.Post(fmt.Sprintf("/%v/predict", c.deploymentId())).Json(body).Idempotent().Do(&res)
 	if err != nil {
 		return NlpTokenPredictions{}, err
 	}
@@ -94,10 +94,44 @@ func (c *NlpTextClient) Predict(text string, topk int) (NlpTextPredictions, erro
 	body := nlpPredictParams{Text: text, Topk: topk}
 
 	var res nlpPredictResults[NlpTextPredictions]
-	err := c.Post(fmt.Sprintf("/%v/predict", c.deploymentId())).Json(body).Do(&res)
+	err := c.Post(fmt.Sprintf("/%v/predict", c.deploymentId())).Json(body).Idempotent().Do(&res)
 	if err != nil {
 		return NlpTextPredictions{}, err
 	}
 
 	return res.Data.PredictionResults, nil
 }
+
+type TokenAttribution struct {
+	Token string  `json:"token"`
+	Score float32 `json:"score"`
+}
+
+type NlpTextExplanation struct {
+	ClassName    string             `json:"class_name"`
+	Score        float32            `json:"score"`
+	Attributions []TokenAttribution `json:"attributions"`
+}
+
+type nlpExplainParams struct {
+	Text      string `json:"text"`
+	ClassName string `json:"class_name,omitempty"`
+}
+
+// Explain returns the attribution of each token of the text to the score of
+// the class. If className is empty the top predicted class is explained.
+func (c *NlpTextClient) Explain(text, className string) (NlpTextExplanation, error) {
+	body := nlpExplainParams{Text: text, ClassName: className}
+
+	var res struct {
+		Data struct {
+			Explanation NlpTextExplanation `json:"explanation"`
+		} `json:"data"`
+	}
+	err := c.Post(fmt.Sprintf("/%v/explain", c.deploymentId())).Json(body).Idempotent().Do(&res)
+	if err != nil {
+		return NlpTextExplanation{}, err
+	}
+
+	return res.Data.Explanation, nil
+}
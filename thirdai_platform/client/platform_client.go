@@ -2,6 +2,8 @@ package client
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
 )
 
 type PlatformClient struct {
@@ -20,8 +23,10 @@ type PlatformClient struct {
 	userId string
 }
 
+// New creates a client that retries requests with DefaultRetryPolicy, use
+// SetRetryPolicy to change or disable the retries.
 func New(baseUrl string) *PlatformClient {
-	return &PlatformClient{BaseClient: BaseClient{baseUrl: baseUrl}}
+	return &PlatformClient{BaseClient: BaseClient{baseUrl: baseUrl, retryPolicy: DefaultRetryPolicy}}
 }
 
 func (c *PlatformClient) Signup(username, email, password string) error {
@@ -442,6 +447,32 @@ func (c *PlatformClient) CreateEnterpriseSearchWorkflow(modelName string, retrie
 	}, nil
 }
 
+func (c *PlatformClient) CreateEnsembleWorkflow(modelName string, models []*NlpTextClient, strategy string) (*NlpTextClient, error) {
+	modelIds := make([]uuid.UUID, 0, len(models))
+	for _, model := range models {
+		modelIds = append(modelIds, model.modelId)
+	}
+
+	body := services.EnsembleRequest{
+		ModelName: modelName,
+		ModelIds:  modelIds,
+		Strategy:  strategy,
+	}
+
+	var res newModelResponse
+	err := c.Post("/api/v2/workflow/ensemble").Json(body).Do(&res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NlpTextClient{
+		ModelClient{
+			BaseClient: c.BaseClient,
+			modelId:    res.ModelId,
+		},
+	}, nil
+}
+
 func (c *PlatformClient) CreateKnowledgeExtractionWorkflow(modelName string, questions []string, llmProvider string, generateAnswers bool) (*KnowledgeExtractionClient, error) {
 	questionKeywords := make([]services.QuestionKeywords, 0, len(questions))
 	for _, q := range questions {
@@ -488,17 +519,31 @@ func (c *PlatformClient) UploadModel(modelName, path string) (interface{}, error
 		return nil, fmt.Errorf("error starting model upload: %w", err)
 	}
 
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd encoder: %w", err)
+	}
+	defer encoder.Close()
+
 	chunkIdx := 0
 	chunk := make([]byte, 10*1024*1024)
+	fileHash := sha256.New()
 	for {
 		n, rerr := modelFile.Read(chunk)
 		if rerr != nil && rerr != io.EOF {
 			return nil, fmt.Errorf("error reading from model file: %w", err)
 		}
 
+		chunkHash := sha256.Sum256(chunk[:n])
+		fileHash.Write(chunk[:n])
+
+		// Chunks are compressed to reduce transfer times, the checksum is of the
+		// uncompressed chunk.
 		err := c.Post(fmt.Sprintf("/api/v2/model/upload/%d", chunkIdx)).
 			Auth(uploadToken["token"]).
-			Body(bytes.NewReader(chunk[:n])).Do(nil)
+			Header("X-Chunk-Sha256", hex.EncodeToString(chunkHash[:])).
+			Header("Content-Encoding", "zstd").
+			Body(bytes.NewReader(encoder.EncodeAll(chunk[:n], nil))).Do(nil)
 		if err != nil {
 			return nil, fmt.Errorf("error sending chunk %d: %w", chunkIdx, err)
 		}
@@ -508,8 +553,9 @@ func (c *PlatformClient) UploadModel(modelName, path string) (interface{}, error
 		chunkIdx++
 	}
 
+	commit := map[string]interface{}{"total_chunks": chunkIdx + 1, "sha256": hex.EncodeToString(fileHash.Sum(nil))}
 	var res uploadResponse
-	err = c.Post("/api/v2/model/upload/commit").Auth(uploadToken["token"]).Do(&res)
+	err = c.Post("/api/v2/model/upload/commit").Auth(uploadToken["token"]).Json(commit).Do(&res)
 	if err != nil {
 		return nil, fmt.Errorf("error committing model upload: %w", err)
 	}
@@ -530,6 +576,38 @@ func (c *PlatformClient) UploadModel(modelName, path string) (interface{}, error
 	}
 }
 
+// ExportModels saves an archive of the models and their dependencies to
+// dstPath, which can be imported into another platform with ImportModels.
+func (c *PlatformClient) ExportModels(modelIds []uuid.UUID, dstPath string) error {
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return c.Post("/api/v2/model/export").Json(services.ExportRequest{ModelIds: modelIds}).Idempotent().Process(
+		func(body io.Reader) error {
+			_, err := io.Copy(dst, body)
+			return err
+		},
+	)
+}
+
+func (c *PlatformClient) ImportModels(path string) ([]services.ImportedModel, error) {
+	archive, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading export archive %v: %w", path, err)
+	}
+	defer archive.Close()
+
+	var res services.ImportResponse
+	err = c.Post("/api/v2/model/import").Header("Content-Type", "application/zip").Body(archive).NoRetry().Do(&res)
+	if err != nil {
+		return nil, fmt.Errorf("error importing models: %w", err)
+	}
+	return res.Models, nil
+}
+
 func (c *PlatformClient) Backup(config services.BackupRequest) error {
 	return c.Post("/api/v2/recovery/backup").Json(config).Do(nil)
 }
@@ -539,3 +617,15 @@ func (c *PlatformClient) LocalBackups() ([]string, error) {
 	err := c.Get("/api/v2/recovery/backups").Do(&backups)
 	return backups, err
 }
+
+func (c *PlatformClient) Restore(params services.RestoreRequest) (uuid.UUID, error) {
+	var res services.RestoreResponse
+	err := c.Post("/api/v2/recovery/restore").Json(params).Do(&res)
+	return res.RestoreId, err
+}
+
+func (c *PlatformClient) RestoreStatus(restoreId uuid.UUID) (services.RestoreStatus, error) {
+	var res services.RestoreStatus
+	err := c.Get(fmt.Sprintf("/api/v2/recovery/restore/%v", restoreId)).Do(&res)
+	return res, err
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how requests are retried when the platform is briefly
+// unavailable, for example while model bazaar or a deployment is restarted.
+// Only requests that are safe to send again are retried: GET requests, and
+// POST requests that are marked with Idempotent, such as search queries.
+// Requests with a body other than json are never retried.
+type RetryPolicy struct {
+	// The number of times a request is retried, 0 disables retries.
+	MaxRetries int
+	// The delay before the first retry, which doubles for each later retry.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is used by clients created with New, and rides out a
+// restart of model bazaar of about half a minute.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// The statuses returned by the proxies in front of the platform while a
+// service is restarting, or by the platform when a rate limit is reached.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int, retryAfter string) time.Duration {
+	delay := p.MaxBackoff
+	if attempt < 32 {
+		delay = min(p.InitialBackoff<<attempt, p.MaxBackoff)
+	}
+	// Jitter keeps clients that failed at the same time from retrying at the
+	// same time.
+	if delay > 0 {
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		delay = max(delay, time.Duration(seconds)*time.Second)
+	}
+	return delay
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// flakyServer fails the first failures requests with the given status.
+func flakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	attempts := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			http.Error(w, "restarting", status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "ok"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, attempts
+}
+
+func TestRetryGet(t *testing.T) {
+	server, attempts := flakyServer(t, 2, http.StatusBadGateway)
+
+	c := NewBaseClient(server.URL, "")
+	c.SetRetryPolicy(testRetryPolicy)
+
+	var res map[string]string
+	if err := c.Get("/status").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res["status"] != "ok" || attempts.Load() != 3 {
+		t.Fatalf("request should succeed on the third attempt: %v %d", res, attempts.Load())
+	}
+}
+
+func TestRetryPost(t *testing.T) {
+	server, attempts := flakyServer(t, 1, http.StatusServiceUnavailable)
+
+	c := NewBaseClient(server.URL, "")
+	c.SetRetryPolicy(testRetryPolicy)
+
+	err := c.Post("/train").Json(map[string]string{"a": "b"}).Do(nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || attempts.Load() != 1 {
+		t.Fatalf("post requests should not be retried: %v %d", err, attempts.Load())
+	}
+
+	if err := c.Post("/search").Json(map[string]string{"a": "b"}).Idempotent().Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("idempotent post should be sent once more, got %d attempts", attempts.Load())
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	server, attempts := flakyServer(t, 10, http.StatusGatewayTimeout)
+
+	c := NewBaseClient(server.URL, "")
+	c.SetRetryPolicy(testRetryPolicy)
+
+	err := c.Get("/status").Do(nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected gateway timeout error, got %v", err)
+	}
+	if attempts.Load() != int32(testRetryPolicy.MaxRetries)+1 {
+		t.Fatalf("expected %d attempts, got %d", testRetryPolicy.MaxRetries+1, attempts.Load())
+	}
+
+	// Other errors are returned immediately.
+	server, attempts = flakyServer(t, 10, http.StatusNotFound)
+	c = NewBaseClient(server.URL, "")
+	c.SetRetryPolicy(testRetryPolicy)
+	if err := c.Get("/status").Do(nil); err == nil || attempts.Load() != 1 {
+		t.Fatalf("not found should not be retried: %v %d", err, attempts.Load())
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	server, attempts := flakyServer(t, 10, http.StatusBadGateway)
+
+	c := NewBaseClient(server.URL, "")
+	c.SetRetryPolicy(RetryPolicy{MaxRetries: 10, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.Get("/status").DoContext(ctx, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || attempts.Load() != 1 {
+		t.Fatalf("retries should stop when the context is done: %v %d", err, attempts.Load())
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 100, InitialBackoff: time.Second, MaxBackoff: 8 * time.Second}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		delay := policy.backoff(attempt, "")
+		expected := min(time.Second<<min(attempt, 10), 8*time.Second)
+		if delay < expected/2 || delay > expected {
+			t.Fatalf("attempt %d: delay %v should be between %v and %v", attempt, delay, expected/2, expected)
+		}
+	}
+
+	if delay := policy.backoff(0, "30"); delay != 30*time.Second {
+		t.Fatalf("retry after should be respected, got %v", delay)
+	}
+}
@@ -2,7 +2,9 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,9 +13,65 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// The client may be used from outside the platform network, so requests go
+// through the outbound proxy if one is configured.
+var httpClient = &http.Client{Transport: utils.OutboundTransport()}
+
+// APIError is returned for requests that fail with a non 200 status. Code is
+// one of the utils.ErrorCode* values, or empty if the error does not have one.
+// Fields lists the invalid fields of requests rejected with invalid_request.
+type APIError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	Code       string
+	Message    string
+	Fields     []validation.FieldError
+	// The Retry-After header of the response, if any, which is set when a
+	// request is rate limited.
+	RetryAfter string
+
+	content string
+}
+
+func newAPIError(r *httpRequest, res *http.Response, content []byte) *APIError {
+	apiErr := &APIError{
+		Method:     r.method,
+		Endpoint:   r.endpoint,
+		StatusCode: res.StatusCode,
+		Code:       res.Header.Get(utils.ErrorCodeHeader),
+		Message:    string(content),
+		RetryAfter: res.Header.Get("Retry-After"),
+		content:    string(content),
+	}
+	var body utils.ErrorResponse
+	if err := json.Unmarshal(content, &body); err == nil && body.Message != "" {
+		apiErr.Message = body.Message
+		if body.Code != "" {
+			apiErr.Code = body.Code
+		}
+		apiErr.Fields = body.Fields
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v request to endpoint %v returned status %d, content '%v'", e.Method, e.Endpoint, e.StatusCode, e.content)
+}
+
+// HasErrorCode reports if err is an APIError with the given error code.
+func HasErrorCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
 type loginInfo struct {
 	email, password string
 }
@@ -27,6 +85,10 @@ type httpRequest struct {
 	json        interface{}
 	body        io.Reader
 	login       *loginInfo
+	retry       RetryPolicy
+	idempotent  bool
+	// Set for range requests, whose responses are partial and are not decoded.
+	byteRange bool
 }
 
 func newHttpRequest(method, baseUrl, endpoint string) *httpRequest {
@@ -68,6 +130,32 @@ func (r *httpRequest) Body(body io.Reader) *httpRequest {
 	return r
 }
 
+// Idempotent marks a POST request as safe to retry, because sending it more
+// than once has the same effect as sending it once.
+func (r *httpRequest) Idempotent() *httpRequest {
+	r.idempotent = true
+	return r
+}
+
+// NoRetry disables retries for the request, for requests where the caller
+// handles failures itself, such as health checks that are polled.
+func (r *httpRequest) NoRetry() *httpRequest {
+	r.retry = RetryPolicy{}
+	return r
+}
+
+// Range requests length bytes of the response starting at offset. The bytes are
+// of the response as it is sent, so compressed responses are not decompressed.
+// If etag is not empty the request fails with ErrRangeNotApplied if the
+// resource no longer has the etag, instead of returning the whole resource.
+func (r *httpRequest) Range(offset, length int64, etag string) *httpRequest {
+	r.byteRange = true
+	if etag != "" {
+		r.Header("If-Range", etag)
+	}
+	return r.Header("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+}
+
 func (r *httpRequest) Param(key, value string) *httpRequest {
 	if r.queryParams == nil {
 		r.queryParams = make(map[string]string)
@@ -77,23 +165,70 @@ func (r *httpRequest) Param(key, value string) *httpRequest {
 }
 
 func (r *httpRequest) Process(resultHandler func(io.Reader) error) error {
+	return r.ProcessContext(context.Background(), resultHandler)
+}
+
+// ProcessContext sends the request, retrying it according to the retry policy
+// of the client, and passes the body of the response to resultHandler.
+func (r *httpRequest) ProcessContext(ctx context.Context, resultHandler func(io.Reader) error) error {
 	fullEndpoint, err := url.JoinPath(r.baseUrl, r.endpoint)
 	if err != nil {
 		return fmt.Errorf("error formatting url for endpoint %v: %w", r.endpoint, err)
 	}
 
+	// The json body is encoded once so that it can be sent again on retries.
+	var jsonBody []byte
 	if r.json != nil {
 		body := new(bytes.Buffer)
 		err := json.NewEncoder(body).Encode(r.json)
 		if err != nil {
 			return fmt.Errorf("error encoding json body for endpoint %v: %w", r.endpoint, err)
 		}
-		r.body = body
+		jsonBody = body.Bytes()
+	}
+
+	canRetry := r.retry.MaxRetries > 0 && (r.method == http.MethodGet || r.idempotent) && (r.body == nil || r.json != nil)
+
+	for attempt := 0; ; attempt++ {
+		retry := canRetry && attempt < r.retry.MaxRetries
+
+		res, err := r.send(ctx, fullEndpoint, jsonBody)
+		if err != nil {
+			if retry && ctx.Err() == nil {
+				delay := r.retry.backoff(attempt, "")
+				slog.Info("thirdai platform client: retrying request", "method", r.method, "endpoint", r.endpoint, "attempt", attempt+1, "delay", delay.String(), "error", err)
+				if sleepContext(ctx, delay) == nil {
+					continue
+				}
+			}
+			return fmt.Errorf("error sending %v request to endpoint %v: %w", r.method, r.endpoint, err)
+		}
+
+		if retry && retryableStatus(res.StatusCode) {
+			delay := r.retry.backoff(attempt, res.Header.Get("Retry-After"))
+			slog.Info("thirdai platform client: retrying request", "method", r.method, "endpoint", r.endpoint, "attempt", attempt+1, "delay", delay.String(), "status", res.StatusCode)
+			// The body is drained so that the connection can be reused.
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if err := sleepContext(ctx, delay); err != nil {
+				return fmt.Errorf("%v request to endpoint %v returned status %d, not retried: %w", r.method, r.endpoint, res.StatusCode, err)
+			}
+			continue
+		}
+
+		return r.handleResponse(res, resultHandler)
+	}
+}
+
+func (r *httpRequest) send(ctx context.Context, fullEndpoint string, jsonBody []byte) (*http.Response, error) {
+	body := r.body
+	if jsonBody != nil {
+		body = bytes.NewReader(jsonBody)
 	}
 
-	req, err := http.NewRequest(r.method, fullEndpoint, r.body)
+	req, err := http.NewRequestWithContext(ctx, r.method, fullEndpoint, body)
 	if err != nil {
-		return fmt.Errorf("error creating %v request for endpoint %v: %w", r.method, r.endpoint, err)
+		return nil, fmt.Errorf("error creating %v request for endpoint %v: %w", r.method, r.endpoint, err)
 	}
 
 	if r.headers != nil {
@@ -116,26 +251,59 @@ func (r *httpRequest) Process(resultHandler func(io.Reader) error) error {
 
 	start := time.Now()
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending %v request to endpoint %v: %w", r.method, r.endpoint, err)
+		return nil, err
 	}
-	defer res.Body.Close()
 
 	end := time.Now()
 
 	slog.Debug("thirdai platform client", "method", r.method, "endpoint", r.endpoint, "status", res.StatusCode, "duration", end.Sub(start).String())
 
+	return res, nil
+}
+
+// ErrRangeNotApplied is returned for range requests that were answered with
+// the whole resource, because it changed or does not support ranges.
+var ErrRangeNotApplied = errors.New("range request was not applied")
+
+func (r *httpRequest) handleResponse(res *http.Response, resultHandler func(io.Reader) error) error {
+	defer res.Body.Close()
+
+	if r.byteRange {
+		if res.StatusCode == http.StatusOK {
+			return fmt.Errorf("%v request to endpoint %v returned the whole resource: %w", r.method, r.endpoint, ErrRangeNotApplied)
+		}
+		if res.StatusCode == http.StatusPartialContent {
+			if resultHandler != nil {
+				if err := resultHandler(res.Body); err != nil {
+					return fmt.Errorf("error processing %v response from endpoint %v: %w", r.method, r.endpoint, err)
+				}
+			}
+			return nil
+		}
+	}
+
 	if res.StatusCode != http.StatusOK {
 		content, err := io.ReadAll(res.Body)
 		if err != nil {
 			return fmt.Errorf("%v request to endpoint %v returned status %d", r.method, r.endpoint, res.StatusCode)
 		}
-		return fmt.Errorf("%v request to endpoint %v returned status %d, content '%v'", r.method, r.endpoint, res.StatusCode, string(content))
+		return newAPIError(r, res, content)
+	}
+
+	var body io.Reader = res.Body
+	if res.Header.Get("Content-Encoding") == "zstd" {
+		decoder, err := zstd.NewReader(res.Body)
+		if err != nil {
+			return fmt.Errorf("error decompressing %v response from endpoint %v: %w", r.method, r.endpoint, err)
+		}
+		defer decoder.Close()
+		body = decoder
 	}
 
 	if resultHandler != nil {
-		err := resultHandler(res.Body)
+		err := resultHandler(body)
 		if err != nil {
 			return fmt.Errorf("error processing %v response from endpoint %v: %w", r.method, r.endpoint, err)
 		}
@@ -145,7 +313,12 @@ func (r *httpRequest) Process(resultHandler func(io.Reader) error) error {
 }
 
 func (r *httpRequest) Do(result interface{}) error {
-	return r.Process(func(body io.Reader) error {
+	return r.DoContext(context.Background(), result)
+}
+
+// DoContext is Do with a context that cancels the request and any retries.
+func (r *httpRequest) DoContext(ctx context.Context, result interface{}) error {
+	return r.ProcessContext(ctx, func(body io.Reader) error {
 		if result != nil {
 			err := json.NewDecoder(body).Decode(result)
 			if err != nil {
@@ -157,11 +330,13 @@ func (r *httpRequest) Do(result interface{}) error {
 }
 
 type BaseClient struct {
-	baseUrl   string
-	authToken string
-	apiKey    string
+	baseUrl     string
+	authToken   string
+	apiKey      string
+	retryPolicy RetryPolicy
 }
 
+// NewBaseClient creates a client that does not retry requests.
 func NewBaseClient(baseUrl string, authToken string) BaseClient {
 	return BaseClient{baseUrl: baseUrl, authToken: authToken}
 }
@@ -176,19 +351,29 @@ func (c *BaseClient) addAuthHeaders(r *httpRequest) *httpRequest {
 	return r
 }
 
-func (c *BaseClient) Get(endpoint string) *httpRequest {
-	r := newHttpRequest("GET", c.baseUrl, endpoint)
+// SetRetryPolicy sets the retry policy for requests sent by the client. Clients
+// for models created from this client, for example by training or deploying a
+// model, use the same policy.
+func (c *BaseClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+func (c *BaseClient) newRequest(method, endpoint string) *httpRequest {
+	r := newHttpRequest(method, c.baseUrl, endpoint)
+	r.retry = c.retryPolicy
 	return c.addAuthHeaders(r)
 }
 
+func (c *BaseClient) Get(endpoint string) *httpRequest {
+	return c.newRequest("GET", endpoint)
+}
+
 func (c *BaseClient) Post(endpoint string) *httpRequest {
-	r := newHttpRequest("POST", c.baseUrl, endpoint)
-	return c.addAuthHeaders(r)
+	return c.newRequest("POST", endpoint)
 }
 
 func (c *BaseClient) Delete(endpoint string) *httpRequest {
-	r := newHttpRequest("DELETE", c.baseUrl, endpoint)
-	return c.addAuthHeaders(r)
+	return c.newRequest("DELETE", endpoint)
 }
 
 func (c *BaseClient) UseApiKey(api_key string) error {
@@ -59,6 +59,31 @@ func main() {
 			// Rollback is not supported for this migration since the migration is more
 			// complicated and not intended to be reversed
 		},
+		{
+			ID:       "1",
+			Migrate:  versions.Migration_1_unique_names,
+			Rollback: versions.Rollback_1_unique_names,
+		},
+		{
+			ID:       "2",
+			Migrate:  versions.Migration_2_search_indexes,
+			Rollback: versions.Rollback_2_search_indexes,
+		},
+		{
+			ID:       "3",
+			Migrate:  versions.Migration_3_verified_emails,
+			Rollback: versions.Rollback_3_verified_emails,
+		},
+		{
+			ID:       "4",
+			Migrate:  versions.Migration_4_delivered_events,
+			Rollback: versions.Rollback_4_delivered_events,
+		},
+		{
+			ID:       "5",
+			Migrate:  versions.Migration_5_job_log_attempts,
+			Rollback: versions.Rollback_5_job_log_attempts,
+		},
 	}
 
 	if *printLatestVersion {
@@ -87,11 +112,23 @@ func main() {
 	migrator.InitSchema(func(txn *gorm.DB) error {
 		log.Println("clean database detected, running full schema initialization")
 
-		return db.AutoMigrate(
+		err := db.AutoMigrate(
 			&schema.Model{}, &schema.ModelAttribute{}, &schema.ModelDependency{},
-			&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.JobLog{},
-			&schema.Upload{},
+			&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.Organization{}, &schema.JobLog{},
+			&schema.Upload{}, &schema.PlatformEvent{}, &schema.EventPublisherCursor{},
+			&schema.JobLogArchive{}, &schema.JobProgress{}, &schema.HoldoutEvaluation{}, &schema.Sweep{},
+			&schema.SweepTrial{}, &schema.SharedReport{}, &schema.DeploymentVersion{}, &schema.ImageScan{},
+			&schema.JobEnvVar{}, &schema.TrustedCertificate{}, &schema.StatusTransition{}, &schema.JobRun{},
+			&schema.ModelUploadSession{}, &schema.ModelUploadChunk{}, &schema.ModelUploadPresignedChunk{}, &schema.OidcIdentity{},
+			&schema.QueuedTrainJob{}, &schema.Webhook{}, &schema.BatchPredictJob{}, &schema.UserJobLimit{},
+			&schema.DeploymentPreset{}, &schema.TrainSchedule{}, &schema.TrainScheduleRun{}, &schema.RateLimitSetting{}, &schema.HealthReportRun{}, &schema.LegalHold{}, &schema.StorageQuotaOverride{},
+			&schema.AuditLogEntry{}, &schema.Dataset{}, &schema.DatasetVersion{}, &schema.ModelDataset{}, &schema.Experiment{}, &schema.ExperimentArm{}, &schema.DeploymentSlo{}, &schema.EntityDictionaryVersion{}, &schema.UnredactGrant{}, &schema.UserToken{}, &schema.InactiveDeployment{}, &schema.ModelStorageTier{}, &schema.ModelGovernance{},
 		)
+		if err != nil {
+			return err
+		}
+
+		return versions.CreateSearchIndexes(db)
 	})
 
 	if *rollbackTo != "" {
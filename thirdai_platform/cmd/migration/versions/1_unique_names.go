@@ -0,0 +1,85 @@
+package versions
+
+import (
+	"fmt"
+	"log"
+	"thirdai_platform/model_bazaar/schema"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type namedRow struct {
+	Id    uuid.UUID
+	Name  string
+	Owner uuid.UUID
+}
+
+// renameDuplicates gives every row after the first with the same owner and name
+// a new name with a suffix from its id, so that the unique index can be created.
+func renameDuplicates(txn *gorm.DB, table, ownerColumn, orderColumn string, maxLen int) error {
+	var rows []namedRow
+	result := txn.Table(table).
+		Select(fmt.Sprintf("id, name, %v AS owner", ownerColumn)).
+		Order(orderColumn).Order("id").
+		Find(&rows)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	type key struct {
+		owner uuid.UUID
+		name  string
+	}
+	used := make(map[key]bool, len(rows))
+	for _, row := range rows {
+		k := key{owner: row.Owner, name: row.Name}
+		if !used[k] {
+			used[k] = true
+			continue
+		}
+
+		// The name is truncated by characters rather than bytes, since the column
+		// length counts characters and cutting a multibyte character would leave
+		// an invalid string.
+		suffix := "-" + row.Id.String()[:8]
+		name := []rune(row.Name)
+		if len(name)+len(suffix) > maxLen {
+			name = name[:maxLen-len(suffix)]
+		}
+		newName := string(name) + suffix
+		used[key{owner: row.Owner, name: newName}] = true
+
+		log.Printf("renaming %v %v from '%v' to '%v' since the name is duplicated", table, row.Id, row.Name, newName)
+		if err := txn.Table(table).Where("id = ?", row.Id).Update("name", newName).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Migration_1_unique_names(txn *gorm.DB) error {
+	if err := renameDuplicates(txn, "models", "user_id", "published_date", 100); err != nil {
+		return fmt.Errorf("error renaming duplicate models: %w", err)
+	}
+	if err := txn.Migrator().CreateIndex(&schema.Model{}, "idx_models_user_name"); err != nil {
+		return fmt.Errorf("error creating model name index: %w", err)
+	}
+
+	if err := renameDuplicates(txn, "user_api_keys", "created_by", "generated_time", 500); err != nil {
+		return fmt.Errorf("error renaming duplicate api keys: %w", err)
+	}
+	if err := txn.Migrator().CreateIndex(&schema.UserAPIKey{}, "idx_user_api_keys_creator_name"); err != nil {
+		return fmt.Errorf("error creating api key name index: %w", err)
+	}
+
+	return nil
+}
+
+func Rollback_1_unique_names(txn *gorm.DB) error {
+	if err := dropIndexes(&schema.Model{}, txn, "idx_models_user_name"); err != nil {
+		return err
+	}
+	return dropIndexes(&schema.UserAPIKey{}, txn, "idx_user_api_keys_creator_name")
+}
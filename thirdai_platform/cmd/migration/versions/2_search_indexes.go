@@ -0,0 +1,54 @@
+package versions
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// The columns that are searched by /api/v2/search. The search matches
+// substrings of the lowercase values, which can use a trigram index instead of
+// scanning the table.
+var searchIndexes = []struct {
+	name   string
+	table  string
+	column string
+}{
+	{name: "idx_models_name_trgm", table: "models", column: "name"},
+	{name: "idx_model_attributes_value_trgm", table: "model_attributes", column: "value"},
+	{name: "idx_teams_name_trgm", table: "teams", column: "name"},
+	{name: "idx_users_username_trgm", table: "users", column: "username"},
+	{name: "idx_uploads_files_trgm", table: "uploads", column: "files"},
+}
+
+// CreateSearchIndexes is also called when a clean database is initialized,
+// since the indexes are not part of the gorm schema.
+func CreateSearchIndexes(txn *gorm.DB) error {
+	if err := txn.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return fmt.Errorf("error creating pg_trgm extension: %w", err)
+	}
+
+	for _, idx := range searchIndexes {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v USING GIN (LOWER(%v) gin_trgm_ops)", idx.name, idx.table, idx.column)
+		if err := txn.Exec(sql).Error; err != nil {
+			return fmt.Errorf("error creating search index %v: %w", idx.name, err)
+		}
+	}
+
+	return nil
+}
+
+func Migration_2_search_indexes(txn *gorm.DB) error {
+	return CreateSearchIndexes(txn)
+}
+
+// The pg_trgm extension is not dropped in the rollback since other databases
+// or indexes could be using it.
+func Rollback_2_search_indexes(txn *gorm.DB) error {
+	for _, idx := range searchIndexes {
+		if err := txn.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %v", idx.name)).Error; err != nil {
+			return fmt.Errorf("error dropping search index %v: %w", idx.name, err)
+		}
+	}
+	return nil
+}
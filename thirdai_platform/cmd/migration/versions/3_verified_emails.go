@@ -0,0 +1,27 @@
+package versions
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migration_3_verified_emails marks existing users as verified. The basic
+// identity provider requires a verified email to log in, and users created
+// before verification was added were never sent a verification email, so they
+// would otherwise be locked out. The column is added here if model bazaar has
+// not already added it.
+func Migration_3_verified_emails(txn *gorm.DB) error {
+	if err := txn.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false").Error; err != nil {
+		return fmt.Errorf("error adding email_verified column: %w", err)
+	}
+	if err := txn.Exec("UPDATE users SET email_verified = true").Error; err != nil {
+		return fmt.Errorf("error marking existing users as verified: %w", err)
+	}
+	return nil
+}
+
+// The column is kept in the rollback since the previous schema ignores it.
+func Rollback_3_verified_emails(txn *gorm.DB) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package versions
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migration_4_delivered_events marks the events that the event relay already
+// published as delivered. The relay used to track its position with a cursor,
+// and now marks each event it publishes, so without this every event in the
+// log would be published again. The column is added here if model bazaar has
+// not already added it. Databases upgraded from before the event log was added
+// have no events to mark, model bazaar creates the tables with the column.
+func Migration_4_delivered_events(txn *gorm.DB) error {
+	if !txn.Migrator().HasTable("platform_events") {
+		return nil
+	}
+	if err := txn.Exec("ALTER TABLE platform_events ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ").Error; err != nil {
+		return fmt.Errorf("error adding delivered_at column: %w", err)
+	}
+	if !txn.Migrator().HasTable("event_publisher_cursors") {
+		return nil
+	}
+	err := txn.Exec(`UPDATE platform_events SET delivered_at = NOW() WHERE delivered_at IS NULL AND id <= (
+		SELECT MAX("cursor") FROM event_publisher_cursors WHERE publisher <> 'webhooks'
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("error marking relayed events as delivered: %w", err)
+	}
+	return nil
+}
+
+// The column is kept in the rollback since the previous schema ignores it.
+func Rollback_4_delivered_events(txn *gorm.DB) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package versions
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Migration_5_job_log_attempts adds the attempt of the job run to the key of
+// job log archives, so that running a job again does not replace the archived
+// logs of its previous run. Existing archives are kept with attempt 0, which
+// is read from the path they were written to before attempts were added.
+// Databases upgraded from before logs were archived have no archives, model
+// bazaar creates the table with the new key.
+func Migration_5_job_log_attempts(txn *gorm.DB) error {
+	if !txn.Migrator().HasTable("job_log_archives") {
+		return nil
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives ADD COLUMN IF NOT EXISTS attempt BIGINT NOT NULL DEFAULT 0").Error; err != nil {
+		return fmt.Errorf("error adding attempt column: %w", err)
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives DROP CONSTRAINT IF EXISTS job_log_archives_pkey").Error; err != nil {
+		return fmt.Errorf("error dropping job log archive primary key: %w", err)
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives ADD PRIMARY KEY (model_id, job, attempt)").Error; err != nil {
+		return fmt.Errorf("error adding job log archive primary key: %w", err)
+	}
+	return nil
+}
+
+// The rollback keeps the latest archive of each job, since the previous schema
+// only has one archive per job. The files of the archives are left in storage,
+// the previous version only finds the ones written before attempts were added.
+func Rollback_5_job_log_attempts(txn *gorm.DB) error {
+	if !txn.Migrator().HasTable("job_log_archives") {
+		return nil
+	}
+	err := txn.Exec(`DELETE FROM job_log_archives a USING job_log_archives b
+		WHERE a.model_id = b.model_id AND a.job = b.job AND a.attempt < b.attempt`).Error
+	if err != nil {
+		return fmt.Errorf("error deleting previous job log archives: %w", err)
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives DROP CONSTRAINT IF EXISTS job_log_archives_pkey").Error; err != nil {
+		return fmt.Errorf("error dropping job log archive primary key: %w", err)
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives ADD PRIMARY KEY (model_id, job)").Error; err != nil {
+		return fmt.Errorf("error adding job log archive primary key: %w", err)
+	}
+	if err := txn.Exec("ALTER TABLE job_log_archives DROP COLUMN attempt").Error; err != nil {
+		return fmt.Errorf("error dropping attempt column: %w", err)
+	}
+	return nil
+}
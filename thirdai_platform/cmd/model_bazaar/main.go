@@ -1,23 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/events"
+	"thirdai_platform/model_bazaar/imagescan"
 	"thirdai_platform/model_bazaar/jobs"
 	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/mail"
 	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/orchestrator/kubernetes"
 	"thirdai_platform/model_bazaar/orchestrator/nomad"
+	"thirdai_platform/model_bazaar/ratelimit"
 	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/secrets"
 	"thirdai_platform/model_bazaar/services"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
@@ -36,10 +44,15 @@ type modelBazaarEnv struct {
 	LicensePath                string
 	NomadEndpoint              string
 	NomadToken                 string
+	NomadNamespace             string
+	NomadTeamNamespaces        bool
 	Kubernetes                 string
 	ShareDir                   string
 	JwtSecret                  string
 
+	StorageBackend string
+	S3             storage.S3Config
+
 	AdminUsername string
 	AdminEmail    string
 	AdminPassword string
@@ -52,9 +65,50 @@ type modelBazaarEnv struct {
 	UseSslInLogin         bool
 	KeycloakAdminUsername string
 	keycloakAdminPassword string
+	KeycloakRealm         auth.RealmSettings
+
+	// Only used by the default identity provider.
+	RequireEmailVerification bool
+	LoginLockout             auth.LockoutPolicy
+
+	OidcIssuerUrl    string
+	OidcClientId     string
+	oidcClientSecret string
+	OidcAudience     string
+	OidcGroupsClaim  string
+	OidcGroupTeams   string
+	OidcAdminGroup   string
 
 	MajorityCriticalServiceNodes int
 
+	JobLogRetentionDays int
+	AuditRetentionDays  int
+
+	InactiveDeploymentDays    int
+	InactiveUndeployGraceDays int
+
+	// Artifacts of models that are not used for ColdStorageAfterDays are moved
+	// to either ColdStorageDir or the ColdStorageS3 bucket.
+	ColdStorageAfterDays int
+	ColdStorageDir       string
+	ColdStorageS3        storage.S3Config
+
+	EnableProfiling bool
+
+	QueueTrainJobs bool
+
+	UserJobLimits services.JobLimits
+
+	RateLimits ratelimit.Config
+
+	ArtifactCompression string
+
+	StorageQuotas string
+
+	SMTP mail.SMTPConfig
+
+	MetricsEndpoint string
+
 	DockerRegistry string
 	DockerUsername string
 	DockerPassword string
@@ -69,7 +123,18 @@ type modelBazaarEnv struct {
 	DatabaseUri  string
 	GrafanaDbUri string
 
+	EventPublisher      string
+	EventPublisherUrl   string
+	EventPublisherTopic string
+
+	ImageScanner      string
+	ImageScannerUrl   string
+	ImageScanMode     string
+	ImageScanSeverity string
+
 	CloudCredentials orchestrator.CloudCredentials
+
+	SecretsRefreshSeconds int
 }
 
 func optionalEnv(key string) string {
@@ -111,6 +176,9 @@ func loadEnv() modelBazaarEnv {
 		NomadEndpoint: optionalEnv("NOMAD_ENDPOINT"),
 		NomadToken:    optionalEnv("TASK_RUNNER_TOKEN"),
 
+		NomadNamespace:      utils.OptionalEnv("NOMAD_NAMESPACE"),
+		NomadTeamNamespaces: utils.BoolEnvVar("NOMAD_TEAM_NAMESPACES"),
+
 		LicensePath: optionalEnv("LICENSE_PATH"),
 
 		Kubernetes: optionalEnv("KUBERNETES"),
@@ -118,6 +186,20 @@ func loadEnv() modelBazaarEnv {
 		ShareDir:  requiredEnv("SHARE_DIR"),
 		JwtSecret: requiredEnv("JWT_SECRET"),
 
+		StorageBackend: utils.OptionalEnv("STORAGE_BACKEND"),
+		S3: storage.S3Config{
+			Bucket:          utils.OptionalEnv("S3_BUCKET"),
+			Prefix:          utils.OptionalEnv("S3_PREFIX"),
+			Region:          utils.OptionalEnv("S3_REGION"),
+			Endpoint:        utils.OptionalEnv("S3_ENDPOINT"),
+			AccessKeyId:     utils.OptionalEnv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: utils.OptionalEnv("S3_SECRET_ACCESS_KEY"),
+			SessionToken:    utils.OptionalEnv("S3_SESSION_TOKEN"),
+			PathStyle:       utils.BoolEnvVar("S3_FORCE_PATH_STYLE"),
+			MountPath:       utils.OptionalEnv("S3_MOUNT_PATH"),
+			CapacityBytes:   uint64(utils.IntEnvVar("S3_CAPACITY_GB", 0)) * 1024 * 1024 * 1024,
+		},
+
 		AdminUsername: requiredEnv("ADMIN_USERNAME"),
 		AdminEmail:    requiredEnv("ADMIN_MAIL"),
 		AdminPassword: requiredEnv("ADMIN_PASSWORD"),
@@ -129,9 +211,80 @@ func loadEnv() modelBazaarEnv {
 		UseSslInLogin:         utils.BoolEnvVar("USE_SSL_IN_LOGIN"),
 		KeycloakAdminUsername: utils.OptionalEnv("KEYCLOAK_ADMIN_USER"),
 		keycloakAdminPassword: utils.OptionalEnv("KEYCLOAK_ADMIN_PASSWORD"),
+		KeycloakRealm:         loadKeycloakRealmSettings(),
+
+		RequireEmailVerification: utils.BoolEnvVar("REQUIRE_EMAIL_VERIFICATION"),
+		LoginLockout: auth.LockoutPolicy{
+			MaxFailures: utils.IntEnvVar("LOGIN_LOCKOUT_MAX_FAILURES", 5),
+			Duration:    time.Duration(utils.IntEnvVar("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		},
+
+		OidcIssuerUrl:    utils.OptionalEnv("OIDC_ISSUER_URL"),
+		OidcClientId:     utils.OptionalEnv("OIDC_CLIENT_ID"),
+		oidcClientSecret: utils.OptionalEnv("OIDC_CLIENT_SECRET"),
+		OidcAudience:     utils.OptionalEnv("OIDC_AUDIENCE"),
+		OidcGroupsClaim:  utils.OptionalEnv("OIDC_GROUPS_CLAIM"),
+		OidcGroupTeams:   utils.OptionalEnv("OIDC_GROUP_TEAMS"),
+		OidcAdminGroup:   utils.OptionalEnv("OIDC_ADMIN_GROUP"),
 
 		MajorityCriticalServiceNodes: utils.IntEnvVar("MAJORITY_CRITICAL_SERVICE_NODES", 1),
 
+		JobLogRetentionDays: utils.IntEnvVar("JOB_LOG_RETENTION_DAYS", 30),
+		AuditRetentionDays:  utils.IntEnvVar("AUDIT_RETENTION_DAYS", 0),
+
+		InactiveDeploymentDays:    utils.IntEnvVar("INACTIVE_DEPLOYMENT_DAYS", 0),
+		InactiveUndeployGraceDays: utils.IntEnvVar("INACTIVE_UNDEPLOY_GRACE_DAYS", 0),
+
+		ColdStorageAfterDays: utils.IntEnvVar("COLD_STORAGE_AFTER_DAYS", 0),
+		ColdStorageDir:       utils.OptionalEnv("COLD_STORAGE_DIR"),
+		// The cold storage bucket is accessed with the same credentials as the
+		// storage backend.
+		ColdStorageS3: storage.S3Config{
+			Bucket:          utils.OptionalEnv("COLD_STORAGE_S3_BUCKET"),
+			Prefix:          utils.OptionalEnv("COLD_STORAGE_S3_PREFIX"),
+			StorageClass:    utils.OptionalEnv("COLD_STORAGE_S3_CLASS"),
+			Region:          utils.OptionalEnv("S3_REGION"),
+			Endpoint:        utils.OptionalEnv("S3_ENDPOINT"),
+			AccessKeyId:     utils.OptionalEnv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: utils.OptionalEnv("S3_SECRET_ACCESS_KEY"),
+			SessionToken:    utils.OptionalEnv("S3_SESSION_TOKEN"),
+			PathStyle:       utils.BoolEnvVar("S3_FORCE_PATH_STYLE"),
+		},
+
+		EnableProfiling: utils.BoolEnvVar("ENABLE_PROFILING"),
+
+		QueueTrainJobs: utils.BoolEnvVar("QUEUE_TRAIN_JOBS"),
+
+		UserJobLimits: services.JobLimits{
+			MaxTrainJobs:   utils.IntEnvVar("USER_MAX_TRAIN_JOBS", 0),
+			MaxDeployments: utils.IntEnvVar("USER_MAX_DEPLOYMENTS", 0),
+		},
+
+		RateLimits: ratelimit.Config{
+			Global:    utils.IntEnvVar("RATE_LIMIT_GLOBAL", 0),
+			PerUser:   utils.IntEnvVar("RATE_LIMIT_PER_USER", 0),
+			PerApiKey: utils.IntEnvVar("RATE_LIMIT_PER_API_KEY", 0),
+			Query:     utils.IntEnvVar("RATE_LIMIT_QUERY", 0),
+			Upload:    utils.IntEnvVar("RATE_LIMIT_UPLOAD", 0),
+			Train:     utils.IntEnvVar("RATE_LIMIT_TRAIN", 0),
+			Deploy:    utils.IntEnvVar("RATE_LIMIT_DEPLOY", 0),
+			Download:  utils.IntEnvVar("RATE_LIMIT_DOWNLOAD", 0),
+		},
+
+		ArtifactCompression: utils.OptionalEnv("MODEL_ARTIFACT_COMPRESSION"),
+
+		StorageQuotas: utils.OptionalEnv("MODEL_STORAGE_QUOTAS_GB"),
+
+		SMTP: mail.SMTPConfig{
+			Host:     utils.OptionalEnv("SMTP_HOST"),
+			Port:     utils.IntEnvVar("SMTP_PORT", 587),
+			Username: utils.OptionalEnv("SMTP_USERNAME"),
+			Password: utils.OptionalEnv("SMTP_PASSWORD"),
+			From:     utils.OptionalEnv("SMTP_FROM"),
+		},
+
+		MetricsEndpoint: utils.OptionalEnv("METRICS_ENDPOINT"),
+
 		DockerRegistry: requiredEnv("DOCKER_REGISTRY"),
 		DockerUsername: requiredEnv("DOCKER_USERNAME"),
 		DockerPassword: requiredEnv("DOCKER_PASSWORD"),
@@ -145,6 +298,15 @@ func loadEnv() modelBazaarEnv {
 		DatabaseUri:  requiredEnv("DATABASE_URI"),
 		GrafanaDbUri: requiredEnv("GRAFANA_DB_URL"),
 
+		EventPublisher:      utils.OptionalEnv("EVENT_PUBLISHER"),
+		EventPublisherUrl:   utils.OptionalEnv("EVENT_PUBLISHER_URL"),
+		EventPublisherTopic: utils.OptionalEnv("EVENT_PUBLISHER_TOPIC"),
+
+		ImageScanner:      utils.OptionalEnv("IMAGE_SCANNER"),
+		ImageScannerUrl:   utils.OptionalEnv("IMAGE_SCANNER_URL"),
+		ImageScanMode:     utils.OptionalEnv("IMAGE_SCAN_MODE"),
+		ImageScanSeverity: utils.OptionalEnv("IMAGE_SCAN_SEVERITY"),
+
 		CloudCredentials: orchestrator.CloudCredentials{
 			AwsAccessKey:       optionalEnv("AWS_ACCESS_KEY"),
 			AwsAccessSecret:    optionalEnv("AWS_ACCESS_SECRET"),
@@ -153,6 +315,8 @@ func loadEnv() modelBazaarEnv {
 			AzureAccountKey:    optionalEnv("AZURE_ACCOUNT_KEY"),
 			GcpCredentialsFile: optionalEnv("GCP_CREDENTIALS_FILE"),
 		},
+
+		SecretsRefreshSeconds: utils.IntEnvVar("SECRETS_REFRESH_SECONDS", 300),
 	}
 
 	if len(missingEnvs) > 0 {
@@ -171,10 +335,147 @@ func loadEnv() modelBazaarEnv {
 	if env.NomadEndpoint != "" && env.NomadToken == "" {
 		log.Fatal("Must specify TASK_RUNNER_TOKEN when using NOMAD_ENDPOINT")
 	}
+	if env.NomadEndpoint == "" && (env.NomadNamespace != "" || env.NomadTeamNamespaces) {
+		log.Fatal("NOMAD_NAMESPACE and NOMAD_TEAM_NAMESPACES can only be used with NOMAD_ENDPOINT")
+	}
+	if strings.HasPrefix(env.NomadNamespace, orchestrator.TeamNamespacePrefix) {
+		log.Fatalf("NOMAD_NAMESPACE cannot start with '%v', which is used for team namespaces", orchestrator.TeamNamespacePrefix)
+	}
+
+	switch env.ArtifactCompression {
+	case "":
+		env.ArtifactCompression = services.ArtifactCompressionZip
+	case services.ArtifactCompressionZip, services.ArtifactCompressionZstd:
+	default:
+		log.Fatalf("MODEL_ARTIFACT_COMPRESSION must be %v or %v", services.ArtifactCompressionZip, services.ArtifactCompressionZstd)
+	}
+
+	if _, err := services.ParseStorageQuotas(env.StorageQuotas); err != nil {
+		log.Fatalf("invalid MODEL_STORAGE_QUOTAS_GB: %v", err)
+	}
+
+	if env.EventPublisher != "" && env.EventPublisherTopic == "" {
+		env.EventPublisherTopic = "thirdai-platform-events"
+	}
+
+	switch env.StorageBackend {
+	case "", "shared_disk":
+	case "s3":
+		if env.S3.Bucket == "" || env.S3.AccessKeyId == "" || env.S3.SecretAccessKey == "" {
+			log.Fatal("Must specify S3_BUCKET, S3_ACCESS_KEY_ID, and S3_SECRET_ACCESS_KEY when using the s3 STORAGE_BACKEND")
+		}
+	default:
+		log.Fatalf("invalid STORAGE_BACKEND '%v', must be 'shared_disk' or 's3'", env.StorageBackend)
+	}
+
+	if env.IdentityProvider == "oidc" {
+		if env.OidcIssuerUrl == "" || env.OidcClientId == "" {
+			log.Fatal("Must specify OIDC_ISSUER_URL and OIDC_CLIENT_ID when using the oidc IDENTITY_PROVIDER")
+		}
+		if _, err := env.oidcGroupTeams(); err != nil {
+			log.Fatalf("invalid OIDC_GROUP_TEAMS: %v", err)
+		}
+	}
+
+	if env.IdentityProvider == "keycloak" {
+		if err := env.KeycloakRealm.Validate(); err != nil {
+			log.Fatalf("invalid keycloak realm settings: %v", err)
+		}
+	}
+
+	if err := env.RateLimits.Validate(); err != nil {
+		log.Fatalf("invalid rate limits: %v", err)
+	}
+
+	if env.SMTP.Host != "" {
+		if err := env.SMTP.Validate(); err != nil {
+			log.Fatalf("invalid smtp config: %v", err)
+		}
+	}
+
+	if env.RequireEmailVerification && env.SMTP.Host == "" {
+		log.Fatal("Must specify SMTP_HOST when using REQUIRE_EMAIL_VERIFICATION so that verification emails can be sent")
+	}
+	if env.LoginLockout.MaxFailures < 0 || (env.LoginLockout.MaxFailures > 0 && env.LoginLockout.Duration <= 0) {
+		log.Fatal("LOGIN_LOCKOUT_MAX_FAILURES must be >= 0, and LOGIN_LOCKOUT_MINUTES must be > 0 if lockouts are enabled")
+	}
+
+	if env.InactiveDeploymentDays < 0 || env.InactiveUndeployGraceDays < 0 {
+		log.Fatal("INACTIVE_DEPLOYMENT_DAYS and INACTIVE_UNDEPLOY_GRACE_DAYS must be >= 0")
+	}
+	if env.InactiveUndeployGraceDays > 0 && env.InactiveDeploymentDays == 0 {
+		log.Fatal("Must specify INACTIVE_DEPLOYMENT_DAYS when using INACTIVE_UNDEPLOY_GRACE_DAYS")
+	}
+
+	if env.ColdStorageAfterDays < 0 {
+		log.Fatal("COLD_STORAGE_AFTER_DAYS must be >= 0")
+	}
+	if env.ColdStorageDir != "" && env.ColdStorageS3.Bucket != "" {
+		log.Fatal("Must specify at most one of COLD_STORAGE_DIR or COLD_STORAGE_S3_BUCKET")
+	}
+	if env.ColdStorageAfterDays > 0 && env.ColdStorageDir == "" && env.ColdStorageS3.Bucket == "" {
+		log.Fatal("Must specify COLD_STORAGE_DIR or COLD_STORAGE_S3_BUCKET when using COLD_STORAGE_AFTER_DAYS")
+	}
+	if env.ColdStorageS3.Bucket != "" {
+		if env.ColdStorageS3.AccessKeyId == "" || env.ColdStorageS3.SecretAccessKey == "" {
+			log.Fatal("Must specify S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY when using COLD_STORAGE_S3_BUCKET")
+		}
+		if env.ColdStorageS3.StorageClass == "" {
+			env.ColdStorageS3.StorageClass = "GLACIER"
+		}
+	}
+
+	if env.ImageScanner != "" {
+		if env.ImageScannerUrl == "" {
+			log.Fatal("Must specify IMAGE_SCANNER_URL when using IMAGE_SCANNER")
+		}
+		if env.ImageScanMode == "" {
+			env.ImageScanMode = imagescan.ModeWarn
+		}
+		if env.ImageScanSeverity == "" {
+			env.ImageScanSeverity = imagescan.SeverityCritical
+		}
+		if err := env.imageScanPolicy().Validate(); err != nil {
+			log.Fatalf("invalid image scan policy: %v", err)
+		}
+	}
 
 	return env
 }
 
+// loadKeycloakRealmSettings loads the settings used to create the keycloak
+// realm. Keycloak does not send emails unless KEYCLOAK_SMTP_HOST is set.
+func loadKeycloakRealmSettings() auth.RealmSettings {
+	settings := auth.RealmSettings{
+		PasswordPolicy: utils.OptionalEnv("KEYCLOAK_PASSWORD_POLICY"),
+		BruteForce: auth.BruteForcePolicy{
+			MaxFailures:          utils.IntEnvVar("KEYCLOAK_BRUTE_FORCE_MAX_FAILURES", 30),
+			WaitIncrementSeconds: utils.IntEnvVar("KEYCLOAK_BRUTE_FORCE_WAIT_INCREMENT_SECONDS", 60),
+			MaxWaitSeconds:       utils.IntEnvVar("KEYCLOAK_BRUTE_FORCE_MAX_WAIT_SECONDS", 900),
+			FailureResetSeconds:  utils.IntEnvVar("KEYCLOAK_BRUTE_FORCE_FAILURE_RESET_SECONDS", 43200),
+		},
+	}
+	if settings.PasswordPolicy == "" {
+		settings.PasswordPolicy = auth.DefaultPasswordPolicy
+	}
+
+	if host := utils.OptionalEnv("KEYCLOAK_SMTP_HOST"); host != "" {
+		settings.SMTP = &auth.RealmSMTP{
+			Host:     host,
+			Port:     utils.IntEnvVar("KEYCLOAK_SMTP_PORT", 587),
+			Username: utils.OptionalEnv("KEYCLOAK_SMTP_USERNAME"),
+			Password: utils.OptionalEnv("KEYCLOAK_SMTP_PASSWORD"),
+			From:     utils.OptionalEnv("KEYCLOAK_SMTP_FROM"),
+			Tls:      utils.OptionalEnv("KEYCLOAK_SMTP_TLS"),
+		}
+		if settings.SMTP.Tls == "" {
+			settings.SMTP.Tls = auth.SmtpTlsStartTls
+		}
+	}
+
+	return settings
+}
+
 func (env *modelBazaarEnv) postgresDsn() string {
 	parts, err := url.Parse(env.DatabaseUri)
 	if err != nil {
@@ -218,6 +519,92 @@ func (env *modelBazaarEnv) FrontendDriver() orchestrator.DockerDriver {
 	}
 }
 
+// storage returns the storage for model artifacts, uploads, and configs. The
+// path is where the share dir is accessible to model bazaar, and is also where
+// jobs access the s3 bucket unless S3_MOUNT_PATH is specified.
+func (env *modelBazaarEnv) storage(modelBazaarPath string) storage.Storage {
+	if env.StorageBackend != "s3" {
+		return storage.NewSharedDisk(modelBazaarPath)
+	}
+
+	config := env.S3
+	if config.MountPath == "" {
+		config.MountPath = modelBazaarPath
+	}
+	store, err := storage.NewS3(config, &http.Client{Transport: utils.OutboundTransport()})
+	if err != nil {
+		log.Fatalf("error creating s3 storage: %v", err)
+	}
+	return store
+}
+
+// coldStorage returns the storage that artifacts of unused models are moved to,
+// or nil if cold storage is not configured. It is configured even if
+// COLD_STORAGE_AFTER_DAYS is 0 so that models that were already moved can be
+// rehydrated.
+func (env *modelBazaarEnv) coldStorage() storage.Storage {
+	if env.ColdStorageDir != "" {
+		return storage.NewSharedDisk(env.ColdStorageDir)
+	}
+	if env.ColdStorageS3.Bucket == "" {
+		return nil
+	}
+	store, err := storage.NewS3(env.ColdStorageS3, &http.Client{Transport: utils.OutboundTransport()})
+	if err != nil {
+		log.Fatalf("error creating s3 cold storage: %v", err)
+	}
+	return store
+}
+
+func (env *modelBazaarEnv) imageScanPolicy() imagescan.Policy {
+	return imagescan.Policy{Mode: env.ImageScanMode, Threshold: env.ImageScanSeverity}
+}
+
+func (env *modelBazaarEnv) imageScanner() imagescan.Scanner {
+	switch env.ImageScanner {
+	case "trivy":
+		return imagescan.NewTrivyScanner(env.ImageScannerUrl)
+	case "harbor":
+		return imagescan.NewHarborScanner(env.ImageScannerUrl, env.DockerUsername, env.DockerPassword)
+	default:
+		log.Fatalf("invalid IMAGE_SCANNER '%v', must be 'trivy' or 'harbor'", env.ImageScanner)
+		return nil
+	}
+}
+
+// mailer returns nil if SMTP_HOST is not set, in which case no emails are sent.
+func (env *modelBazaarEnv) mailer() mail.Sender {
+	if env.SMTP.Host == "" {
+		return nil
+	}
+	return mail.NewSMTPSender(env.SMTP)
+}
+
+// publicUrl is the url users access the platform with, which is used for the
+// links in emails.
+func (env *modelBazaarEnv) publicUrl() string {
+	if env.UseSslInLogin {
+		return "https://" + env.IngressHostname
+	}
+	return "http://" + env.IngressHostname
+}
+
+// oidcGroupTeams parses OIDC_GROUP_TEAMS, which has the format "group:team,...".
+func (env *modelBazaarEnv) oidcGroupTeams() (map[string]string, error) {
+	groupTeams := map[string]string{}
+	if env.OidcGroupTeams == "" {
+		return groupTeams, nil
+	}
+	for _, entry := range strings.Split(env.OidcGroupTeams, ",") {
+		group, team, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || group == "" || team == "" {
+			return nil, fmt.Errorf("invalid entry '%v', expected 'group:team'", entry)
+		}
+		groupTeams[group] = team
+	}
+	return groupTeams, nil
+}
+
 func (env *modelBazaarEnv) llmProviders() map[string]string {
 	providers := map[string]string{}
 	if strings.HasPrefix(env.GenAiKey, "sk-") {
@@ -233,15 +620,22 @@ func initLogging(logFile *os.File) {
 }
 
 func initDb(dsn string) *gorm.DB {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("error opening database connection: %v", err)
 	}
 
 	err = db.AutoMigrate(
 		&schema.Model{}, &schema.ModelAttribute{}, &schema.ModelDependency{},
-		&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.JobLog{},
-		&schema.Upload{},
+		&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.Organization{}, &schema.JobLog{},
+		&schema.Upload{}, &schema.PlatformEvent{}, &schema.EventPublisherCursor{},
+		&schema.JobLogArchive{}, &schema.JobProgress{}, &schema.HoldoutEvaluation{}, &schema.Sweep{},
+		&schema.SweepTrial{}, &schema.SharedReport{}, &schema.DeploymentVersion{}, &schema.ImageScan{},
+		&schema.JobEnvVar{}, &schema.TrustedCertificate{}, &schema.StatusTransition{}, &schema.JobRun{},
+		&schema.ModelUploadSession{}, &schema.ModelUploadChunk{}, &schema.ModelUploadPresignedChunk{}, &schema.OidcIdentity{},
+		&schema.QueuedTrainJob{}, &schema.Webhook{}, &schema.BatchPredictJob{}, &schema.UserJobLimit{},
+		&schema.DeploymentPreset{}, &schema.TrainSchedule{}, &schema.TrainScheduleRun{}, &schema.RateLimitSetting{}, &schema.HealthReportRun{}, &schema.LegalHold{}, &schema.StorageQuotaOverride{},
+		&schema.AuditLogEntry{}, &schema.Dataset{}, &schema.DatasetVersion{}, &schema.ModelDataset{}, &schema.Experiment{}, &schema.ExperimentArm{}, &schema.DeploymentSlo{}, &schema.EntityDictionaryVersion{}, &schema.UnredactGrant{}, &schema.UserToken{}, &schema.InactiveDeployment{}, &schema.ModelStorageTier{}, &schema.ModelGovernance{},
 	)
 	if err != nil {
 		log.Fatalf("error migrating db schema: %v", err)
@@ -274,9 +668,22 @@ func main() {
 	if *envFile != "" {
 		loadEnvFile(*envFile)
 	}
+
+	// The outbound proxy is configured first since it is used by the secret providers.
+	outbound := utils.OutboundConfigFromEnv()
+	if err := utils.ConfigureOutbound(outbound); err != nil {
+		log.Fatalf("invalid outbound proxy config: %v", err)
+	}
+
+	secretResolver := secrets.NewResolver(secrets.ConfigFromEnv().NewProvider)
+	resolvedSecrets, err := secretResolver.ResolveEnv()
+	if err != nil {
+		log.Fatalf("error resolving secret references in env: %v", err)
+	}
+
 	env := loadEnv()
 
-	err := os.MkdirAll(filepath.Join(env.ShareDir, "logs/"), 0777)
+	err = os.MkdirAll(filepath.Join(env.ShareDir, "logs/"), 0777)
 	if err != nil {
 		log.Fatalf("error creating log dir: %v", err)
 	}
@@ -287,7 +694,8 @@ func main() {
 	}
 	defer logFile.Close()
 
-	auditLog, err := os.OpenFile(filepath.Join(env.ShareDir, "logs/audit.log"), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	auditLogPath := filepath.Join(env.ShareDir, "logs/audit.log")
+	auditLog, err := os.OpenFile(auditLogPath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
 	if err != nil {
 		log.Fatalf("error opening audit log file: %v", err)
 	}
@@ -295,16 +703,29 @@ func main() {
 
 	initLogging(logFile)
 
+	if outbound.HttpProxy != "" || outbound.HttpsProxy != "" {
+		slog.Info("using outbound proxy", "http_proxy", outbound.HttpProxy, "https_proxy", outbound.HttpsProxy, "no_proxy", outbound.NoProxy)
+	}
+	outboundJobEnv := outbound.JobEnv(getHostname(env.PrivateModelBazaarEndpoint), getHostname(env.IngressHostname))
+
+	// The quotas were validated when the env was loaded.
+	storageQuotas, _ := services.ParseStorageQuotas(env.StorageQuotas)
+
 	db := initDb(env.postgresDsn())
 
 	var orchestratorClient orchestrator.Client
 
 	if env.NomadEndpoint != "" {
-		orchestratorClient = nomad.NewNomadClient(env.NomadEndpoint, env.NomadToken, env.IngressHostname)
+		orchestratorClient = nomad.NewNomadClient(env.NomadEndpoint, env.NomadToken, env.IngressHostname, env.NomadNamespace)
 	} else if env.Kubernetes != "" {
 		orchestratorClient = kubernetes.NewKubernetesClient(env.IngressHostname)
 	}
 
+	if env.ImageScanner != "" {
+		slog.Info("image scanning enabled", "scanner", env.ImageScanner, "mode", env.ImageScanMode, "severity", env.ImageScanSeverity)
+		orchestratorClient = imagescan.NewClient(orchestratorClient, env.imageScanner(), env.imageScanPolicy(), db)
+	}
+
 	licenseVerifier := licensing.NewVerifier(env.LicensePath)
 
 	var modelBazaarPath string
@@ -315,7 +736,11 @@ func main() {
 		modelBazaarPath = env.ShareDir
 	}
 
-	sharedStorage := storage.NewSharedDisk(modelBazaarPath)
+	sharedStorage := env.storage(modelBazaarPath)
+
+	if err := services.LoadTrustedCerts(db, sharedStorage); err != nil {
+		log.Fatalf("error loading trusted certificates: %v", err)
+	}
 
 	variables := services.Variables{
 		BackendDriver: env.BackendDriver(),
@@ -328,13 +753,34 @@ func main() {
 		ModelBazaarEndpoint: env.PrivateModelBazaarEndpoint,
 		CloudCredentials:    env.CloudCredentials,
 		LlmProviders:        env.llmProviders(),
+		JobLogRetention:     time.Duration(env.JobLogRetentionDays) * 24 * time.Hour,
+		AuditRetention:      time.Duration(env.AuditRetentionDays) * 24 * time.Hour,
+		AuditLogPath:        auditLogPath,
+		EnableProfiling:     env.EnableProfiling,
+		OutboundJobEnv:      outboundJobEnv,
+		RateLimits:          env.RateLimits,
+		QueueTrainJobs:      env.QueueTrainJobs,
+		UserJobLimits:       env.UserJobLimits,
+		ArtifactCompression: env.ArtifactCompression,
+		StorageQuotas:       storageQuotas,
+		TeamNamespaces:      env.NomadTeamNamespaces,
+		Mailer:              env.mailer(),
+		MetricsEndpoint:     env.MetricsEndpoint,
+		InactiveDeployments: services.InactivityPolicy{
+			After:         time.Duration(env.InactiveDeploymentDays) * 24 * time.Hour,
+			UndeployGrace: time.Duration(env.InactiveUndeployGraceDays) * 24 * time.Hour,
+		},
+		StorageTiering: services.TieringPolicy{
+			After: time.Duration(env.ColdStorageAfterDays) * 24 * time.Hour,
+			Cold:  env.coldStorage(),
+		},
 	}
 
 	var identityProvider auth.IdentityProvider
 	if env.IdentityProvider == "keycloak" {
 		identityProvider, err = auth.NewKeycloakIdentityProvider(
 			db,
-			auth.NewAuditLogger(auditLog),
+			auth.NewAuditLogger(auditLog, db),
 			auth.KeycloakArgs{
 				KeycloakServerUrl:     env.KeycloakServerUrl,
 				KeycloakAdminUsername: env.KeycloakAdminUsername,
@@ -345,20 +791,47 @@ func main() {
 				PublicHostname:        env.IngressHostname,
 				PrivateHostname:       getHostname(env.PrivateModelBazaarEndpoint),
 				SslLogin:              env.UseSslInLogin,
+				RealmSettings:         env.KeycloakRealm,
 			},
 		)
 		if err != nil {
 			log.Fatalf("error creating keycloak identity provider: %v", err)
 		}
+	} else if env.IdentityProvider == "oidc" {
+		groupTeams, _ := env.oidcGroupTeams()
+		identityProvider, err = auth.NewOidcIdentityProvider(
+			db,
+			auth.NewAuditLogger(auditLog, db),
+			auth.OidcArgs{
+				IssuerUrl:     env.OidcIssuerUrl,
+				ClientId:      env.OidcClientId,
+				ClientSecret:  env.oidcClientSecret,
+				Audience:      env.OidcAudience,
+				GroupsClaim:   env.OidcGroupsClaim,
+				GroupTeams:    groupTeams,
+				AdminGroup:    env.OidcAdminGroup,
+				AdminUsername: env.AdminUsername,
+				AdminEmail:    env.AdminEmail,
+				Client:        &http.Client{Transport: utils.OutboundTransport(), Timeout: 10 * time.Second},
+			},
+		)
+		if err != nil {
+			log.Fatalf("error creating oidc identity provider: %v", err)
+		}
 	} else {
 		identityProvider, err = auth.NewBasicIdentityProvider(
 			db,
-			auth.NewAuditLogger(auditLog),
+			auth.NewAuditLogger(auditLog, db),
 			auth.BasicProviderArgs{
 				Secret:        []byte(env.JwtSecret),
 				AdminUsername: env.AdminUsername,
 				AdminEmail:    env.AdminEmail,
 				AdminPassword: env.AdminPassword,
+
+				Mailer:                   env.mailer(),
+				PublicUrl:                env.publicUrl(),
+				RequireEmailVerification: env.RequireEmailVerification,
+				Lockout:                  env.LoginLockout,
 			},
 		)
 		if err != nil {
@@ -384,7 +857,13 @@ func main() {
 	}
 
 	if !*skipAll && !*skipDispatch {
-		err = jobs.StartLlmDispatchJob(orchestratorClient, env.BackendDriver(), env.PrivateModelBazaarEndpoint, env.ShareDir)
+		certsEnv, err := services.TrustedCertsJobEnv(db, sharedStorage)
+		if err != nil {
+			log.Fatalf("error loading trusted certificates env: %v", err)
+		}
+		maps.Copy(certsEnv, outboundJobEnv)
+
+		err = jobs.StartLlmDispatchJob(orchestratorClient, env.BackendDriver(), env.PrivateModelBazaarEndpoint, env.ShareDir, certsEnv)
 		if err != nil {
 			log.Fatalf("failed to start llm dispatch job: %v", err)
 		}
@@ -427,6 +906,21 @@ func main() {
 	}
 
 	go model_bazaar.JobStatusSync(5 * time.Second)
+	go model_bazaar.StorageTieringSync(30 * time.Second)
+
+	if env.EventPublisher != "" {
+		publisher, err := events.NewPublisher(events.PublisherArgs{
+			Kind:  env.EventPublisher,
+			Url:   env.EventPublisherUrl,
+			Topic: env.EventPublisherTopic,
+		})
+		if err != nil {
+			log.Fatalf("error creating event publisher: %v", err)
+		}
+		eventRelay := services.NewEventRelay(db, publisher)
+		go eventRelay.Run(time.Second)
+		defer eventRelay.Stop()
+	}
 
 	r := chi.NewRouter()
 
@@ -440,10 +934,42 @@ func main() {
 	}))
 	r.Mount("/api/v2", model_bazaar.Routes())
 
+	// The probes are outside of /api/v2 so that they are not rate limited.
+	r.Get("/healthz", model_bazaar.Healthz)
+	r.Get("/readyz", model_bazaar.Readyz)
+
+	srv := utils.NewServer(fmt.Sprintf(":%d", *port), r, utils.ServerTimeoutsFromEnv())
+
+	// The secrets are copied into the config of many components and jobs, so
+	// when one is rotated model bazaar stops and is restarted by its orchestrator
+	// to load the new values everywhere.
+	rotated := make(chan struct{})
+	if len(resolvedSecrets) > 0 && env.SecretsRefreshSeconds > 0 {
+		slog.Info("watching secrets for rotation", "keys", resolvedSecrets, "interval_seconds", env.SecretsRefreshSeconds)
+		go func() {
+			changed := secretResolver.WaitForRotation(time.Duration(env.SecretsRefreshSeconds) * time.Second)
+			slog.Warn("secrets were rotated, stopping server to restart with the new values", "keys", changed)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error("error shutting down server", "error", err)
+			}
+			close(rotated)
+		}()
+	}
+
 	slog.Info("starting server", "port", *port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), r)
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-rotated
+		model_bazaar.StopJobStatusSync()
+		model_bazaar.StopStorageTieringSync()
+		log.Fatal("exiting to reload rotated secrets")
+	}
 	if err != nil {
 		log.Fatalf("listen and serve returned error: %v", err.Error())
 	}
 	model_bazaar.StopJobStatusSync()
+	model_bazaar.StopStorageTieringSync()
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"thirdai_platform/model_bazaar/services"
+)
+
+// Generates the OpenAPI spec for the model bazaar api, this is run with
+// go generate in the services package.
+func main() {
+	out := flag.String("out", "openapi.json", "Path to write the spec to")
+	flag.Parse()
+
+	spec, err := services.OpenAPISpec()
+	if err != nil {
+		log.Fatalf("error generating api spec: %v", err)
+	}
+
+	if err := os.WriteFile(*out, spec, 0644); err != nil {
+		log.Fatalf("error writing api spec: %v", err)
+	}
+}
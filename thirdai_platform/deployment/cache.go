@@ -1,6 +1,8 @@
 package deployment
 
 import (
+	"container/list"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"path/filepath"
@@ -8,33 +10,207 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/search/ndb"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	llmCacheEntriesMetric   = promauto.NewGauge(prometheus.GaugeOpts{Name: "llm_cache_entries", Help: "Number of responses in the LLM cache"})
+	llmCacheEvictionsMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "llm_cache_evictions_total", Help: "Responses removed from the LLM cache"}, []string{"reason"})
+)
+
+const (
+	evictedExpired     = "expired"
+	evictedMaxEntries  = "max_entries"
+	evictedStale       = "stale"
+	evictedInvalidated = "invalidated"
 )
 
+type llmCacheEntry struct {
+	query        string
+	insertedAt   time.Time
+	referenceIds []uint64
+	sourceIds    []string
+}
+
+// LLMCache stores llm responses in an ndb, with the query as the chunk text and
+// doc id of each response. The entries are also tracked in memory, oldest
+// first, so that expired entries and entries over the limit can be evicted,
+// and so that the entries that used a document or reference can be found when
+// it changes.
 type LLMCache struct {
 	Ndb       ndb.NeuralDB
 	Threshold float64
+
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
 }
 
 const CacheScoreThreshold = 0.95
 
-func NewLLMCache(modelBazaarDir, modelId string) (*LLMCache, error) {
+// NewLLMCache opens the cache of the model, opts can be nil to use the default
+// limits.
+func NewLLMCache(modelBazaarDir, modelId string, opts *config.LLMCacheOptions) (*LLMCache, error) {
+	if opts == nil {
+		opts = &config.LLMCacheOptions{}
+	}
+
 	cachePath := filepath.Join(modelBazaarDir, "models", modelId, "llm_cache", "llm_cache.ndb")
 	ndb, err := ndb.New(cachePath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct LLM Cache: %v", err)
 	}
 
-	return &LLMCache{Ndb: ndb, Threshold: CacheScoreThreshold}, nil
+	cache := &LLMCache{
+		Ndb:        ndb,
+		Threshold:  CacheScoreThreshold,
+		maxEntries: opts.Limit(),
+		ttl:        opts.Ttl(),
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+
+	if err := cache.loadEntries(); err != nil {
+		ndb.Free()
+		return nil, fmt.Errorf("unable to load LLM Cache entries: %v", err)
+	}
+
+	return cache, nil
+}
+
+// loadEntries rebuilds the in memory index from the entries already in the
+// cache ndb. Entries whose metadata cannot be read are deleted, and entries
+// from before insertion times were recorded are treated as inserted now.
+func (c *LLMCache) loadEntries() error {
+	sources, err := c.Ndb.Sources()
+	if err != nil {
+		return fmt.Errorf("error listing cache entries: %v", err)
+	}
+
+	now := time.Now()
+	entries := make([]*llmCacheEntry, 0, len(sources))
+	for _, source := range sources {
+		entry, err := c.readEntry(source.DocId, now)
+		if err != nil {
+			slog.Warn("deleting unreadable cache entry", "query", source.DocId, "error", err)
+			if err := c.Ndb.Delete(source.DocId, false); err != nil {
+				return fmt.Errorf("failed to delete cache entry: %v", err)
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].insertedAt.Before(entries[j].insertedAt)
+	})
+	for _, entry := range entries {
+		c.entries[entry.query] = c.order.PushFront(entry)
+	}
+
+	llmCacheEntriesMetric.Set(float64(c.order.Len()))
+	c.evictExpired()
+	c.evictOverLimit()
+
+	return nil
+}
+
+func (c *LLMCache) readEntry(query string, defaultInsertedAt time.Time) (*llmCacheEntry, error) {
+	chunks, err := c.Ndb.Query(query, 5, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ndb query error: %v", err)
+	}
+
+	for _, chunk := range chunks {
+		if chunk.DocId != query {
+			continue
+		}
+		_, referenceIds, err := getChunkMetadata(chunk)
+		if err != nil {
+			return nil, err
+		}
+		entry := &llmCacheEntry{query: query, insertedAt: defaultInsertedAt, referenceIds: referenceIds}
+		if insertedAt, ok := chunk.Metadata["inserted_at"].(string); ok {
+			if entry.insertedAt, err = time.Parse(time.RFC3339, insertedAt); err != nil {
+				return nil, fmt.Errorf("could not parse inserted_at from metadata %s", insertedAt)
+			}
+		}
+		if sourceIds, ok := chunk.Metadata["source_ids"].(string); ok {
+			if err := json.Unmarshal([]byte(sourceIds), &entry.sourceIds); err != nil {
+				return nil, fmt.Errorf("could not parse source ids from metadata %s", sourceIds)
+			}
+		}
+		return entry, nil
+	}
+
+	return nil, fmt.Errorf("chunk not found")
 }
 
 func (c *LLMCache) Close() {
 	c.Ndb.Free()
 }
 
+func (c *LLMCache) expired(entry *llmCacheEntry) bool {
+	return time.Since(entry.insertedAt) >= c.ttl
+}
+
+// evict deletes the entry from the cache ndb and the index. The caller must
+// hold the lock.
+func (c *LLMCache) evict(elem *list.Element, reason string) error {
+	entry := elem.Value.(*llmCacheEntry)
+	if err := c.Ndb.Delete(entry.query, false); err != nil {
+		return fmt.Errorf("failed to delete cache entry: %v", err)
+	}
+	c.order.Remove(elem)
+	delete(c.entries, entry.query)
+	llmCacheEvictionsMetric.WithLabelValues(reason).Inc()
+	llmCacheEntriesMetric.Set(float64(c.order.Len()))
+	return nil
+}
+
+// evictExpired removes expired entries, which are at the back of the list
+// since entries are ordered by insertion time.
+func (c *LLMCache) evictExpired() {
+	for elem := c.order.Back(); elem != nil && c.expired(elem.Value.(*llmCacheEntry)); elem = c.order.Back() {
+		if err := c.evict(elem, evictedExpired); err != nil {
+			slog.Error("failed to evict expired cache entry", "error", err)
+			return
+		}
+	}
+}
+
+func (c *LLMCache) evictOverLimit() {
+	for c.order.Len() > c.maxEntries {
+		if err := c.evict(c.order.Back(), evictedMaxEntries); err != nil {
+			slog.Error("failed to evict cache entry", "error", err)
+			return
+		}
+	}
+}
+
+// live returns if the query has an entry that has not expired. The caller must
+// hold the lock.
+func (c *LLMCache) live(query string) bool {
+	elem, ok := c.entries[query]
+	return ok && !c.expired(elem.Value.(*llmCacheEntry))
+}
+
 func (c *LLMCache) Suggestions(query string) ([]string, error) {
 	slog.Info("fetching cache suggestions", "query", query)
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired()
+
 	chunks, err := c.Ndb.Query(query, 5, nil)
 	if err != nil {
 		return []string{}, fmt.Errorf("ndb query error: %v", err)
@@ -144,6 +320,11 @@ func getChunkMetadata(chunk ndb.Chunk) (string, []uint64, error) {
 func (c *LLMCache) Query(query string, expectedReferenceIds []uint64) (string, error) {
 	slog.Info("executing cache request", "query", query)
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired()
+
 	chunks, err := c.Ndb.Query(query, 5, nil)
 	if err != nil {
 		return "", fmt.Errorf("ndb query error: %v", err)
@@ -177,8 +358,11 @@ func (c *LLMCache) Query(query string, expectedReferenceIds []uint64) (string, e
 	// if the references have changed for the same query, delete it from the cache
 	// since the underlying neuraldb has changed and the response might not be valid
 	if query == topChunk.Text {
-		err := c.Ndb.Delete(query, false)
-		if err != nil {
+		if elem, ok := c.entries[query]; ok {
+			if err := c.evict(elem, evictedStale); err != nil {
+				return "", err
+			}
+		} else if err := c.Ndb.Delete(query, false); err != nil {
 			return "", fmt.Errorf("failed to delete cache entry: %v", err)
 		}
 	}
@@ -187,18 +371,78 @@ func (c *LLMCache) Query(query string, expectedReferenceIds []uint64) (string, e
 	return "", nil
 }
 
-func (c *LLMCache) Insert(query, llmRes string, referenceIds []uint64) error {
+// Insert caches the llm response for the query, replacing any previous
+// response. The reference ids and the doc ids of the references are stored so
+// that the response can be invalidated when they change.
+func (c *LLMCache) Insert(query, llmRes string, referenceIds []uint64, sourceIds []string) error {
 	slog.Info("inserting to cache", "query", query, "llm_res", llmRes)
 
-	err := c.Ndb.Insert(
+	sourceIdsJson, err := json.Marshal(sourceIds)
+	if err != nil {
+		return fmt.Errorf("error encoding source ids: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired()
+
+	if elem, ok := c.entries[query]; ok {
+		if err := c.Ndb.Delete(query, false); err != nil {
+			return fmt.Errorf("failed to replace cache entry: %v", err)
+		}
+		c.order.Remove(elem)
+		delete(c.entries, query)
+	}
+
+	entry := &llmCacheEntry{query: query, insertedAt: time.Now().UTC(), referenceIds: referenceIds, sourceIds: sourceIds}
+
+	err = c.Ndb.Insert(
 		"cache_query", query, // use the query as the docId so we can easily delete
 		[]string{query},
-		[]map[string]interface{}{{"llm_res": llmRes, "reference_ids": referenceIdsToString(referenceIds)}},
+		[]map[string]interface{}{{
+			"llm_res":       llmRes,
+			"reference_ids": referenceIdsToString(referenceIds),
+			"source_ids":    string(sourceIdsJson),
+			"inserted_at":   entry.insertedAt.Format(time.RFC3339),
+		}},
 		nil)
 
 	if err != nil {
 		return fmt.Errorf("failed insertion to cache")
 	}
 
+	c.entries[query] = c.order.PushFront(entry)
+	llmCacheEntriesMetric.Set(float64(c.order.Len()))
+	c.evictOverLimit()
+
 	return nil
 }
+
+// Invalidate deletes the cached responses that used any of the documents or
+// references, and returns the number of responses deleted. Responses cached
+// without the doc ids of their references are only matched by reference id.
+func (c *LLMCache) Invalidate(sourceIds []string, referenceIds []uint64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	invalidated := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*llmCacheEntry)
+		if slices.ContainsFunc(entry.sourceIds, func(id string) bool { return slices.Contains(sourceIds, id) }) ||
+			slices.ContainsFunc(entry.referenceIds, func(id uint64) bool { return slices.Contains(referenceIds, id) }) {
+			if err := c.evict(elem, evictedInvalidated); err != nil {
+				return invalidated, err
+			}
+			invalidated++
+		}
+		elem = next
+	}
+
+	if invalidated > 0 {
+		slog.Info("invalidated cache entries", "source_ids", sourceIds, "reference_ids", referenceIds, "invalidated", invalidated)
+	}
+
+	return invalidated, nil
+}
@@ -15,6 +15,7 @@ import (
 	"thirdai_platform/deployment"
 	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/utils"
 
 	"time"
 
@@ -31,9 +32,10 @@ type CloudCredentials struct {
 }
 
 type DeploymentEnv struct {
-	ConfigPath       string           `env:"CONFIG_PATH,required"`
-	JobToken         string           `env:"JOB_TOKEN,required"`
-	CloudCredentials CloudCredentials `env:""`
+	ConfigPath       string               `env:"CONFIG_PATH,required"`
+	JobToken         string               `env:"JOB_TOKEN,required"`
+	CloudCredentials CloudCredentials     `env:""`
+	ServerTimeouts   utils.ServerTimeouts `env:""`
 }
 
 /**
@@ -100,6 +102,8 @@ func runApp() error {
 
 	r := ndbrouter.Routes()
 
+	go ndbrouter.Warmup()
+
 	/* If we report the server is complete before traefik updates, a user might
 	fire a request to this deployment before traefik is ready, and that request
 	will fail. Since traefik updates are every 5 seconds, this should be a safeguard.
@@ -114,10 +118,7 @@ func runApp() error {
 		}
 	}()
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", *port),
-		Handler: r,
-	}
+	srv := utils.NewServer(fmt.Sprintf(":%d", *port), r, env.ServerTimeouts)
 
 	/* We need to listen for an interrupt in this way to ensure the defer calls
 	go through correctly in case of a shutdown and so we can update the job
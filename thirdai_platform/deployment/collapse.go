@@ -0,0 +1,121 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"thirdai_platform/search/ndb"
+	"unicode"
+)
+
+// When results are collapsed more chunks than top_k are searched, so that top_k
+// results are still returned when some of them are collapsed.
+const (
+	collapseOverfetch    = 4
+	maxCollapseOverfetch = 1000
+)
+
+type CollapseOptions struct {
+	// SameDocument collapses chunks from the same document.
+	SameDocument bool `json:"same_document,omitempty"`
+	// SectionKey collapses chunks from the same document that have the same
+	// value for this metadata key. Chunks without the key are not collapsed.
+	SectionKey string `json:"section_key,omitempty"`
+	// MinOverlap collapses chunks whose words overlap by at least this fraction,
+	// measured as the jaccard similarity of the sets of words in the chunks.
+	MinOverlap float64 `json:"min_overlap,omitempty"`
+}
+
+func (opts *CollapseOptions) validate() error {
+	if opts.MinOverlap < 0 || opts.MinOverlap > 1 {
+		return fmt.Errorf("min_overlap must be between 0 and 1, got %v", opts.MinOverlap)
+	}
+	if !opts.SameDocument && opts.SectionKey == "" && opts.MinOverlap == 0 {
+		return errors.New("collapse must set at least one of same_document, section_key, or min_overlap")
+	}
+	return nil
+}
+
+func (opts *CollapseOptions) searchTopk(topk int) int {
+	return max(topk, min(topk*collapseOverfetch, maxCollapseOverfetch))
+}
+
+type collapseGroup struct {
+	chunk   ndb.Chunk
+	words   map[string]struct{}
+	results int
+}
+
+func chunkWords(text string) map[string]struct{} {
+	words := make(map[string]struct{})
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = struct{}{}
+	}
+	return words
+}
+
+func wordOverlap(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	shared := 0
+	for word := range a {
+		if _, ok := b[word]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func (opts *CollapseOptions) matches(group *collapseGroup, chunk ndb.Chunk, words map[string]struct{}) bool {
+	if chunk.DocId == group.chunk.DocId {
+		if opts.SameDocument {
+			return true
+		}
+		if opts.SectionKey != "" {
+			section, ok := chunk.Metadata[opts.SectionKey]
+			groupSection, groupOk := group.chunk.Metadata[opts.SectionKey]
+			if ok && groupOk && section == groupSection {
+				return true
+			}
+		}
+	}
+	return opts.MinOverlap > 0 && wordOverlap(group.words, words) >= opts.MinOverlap
+}
+
+// Collapse groups the chunks, which must be ordered by score, into near
+// duplicates and returns the highest scoring chunk of each group, with the
+// number of other chunks in its group. At most topk chunks are returned.
+func (opts *CollapseOptions) Collapse(chunks []ndb.Chunk, topk int) ([]ndb.Chunk, []int) {
+	groups := make([]*collapseGroup, 0)
+	for _, chunk := range chunks {
+		var words map[string]struct{}
+		if opts.MinOverlap > 0 {
+			words = chunkWords(chunk.Text)
+		}
+
+		collapsed := false
+		for _, group := range groups {
+			if opts.matches(group, chunk, words) {
+				group.results++
+				collapsed = true
+				break
+			}
+		}
+		if !collapsed {
+			groups = append(groups, &collapseGroup{chunk: chunk, words: words})
+		}
+	}
+
+	groups = groups[:min(len(groups), topk)]
+
+	representatives := make([]ndb.Chunk, len(groups))
+	counts := make([]int, len(groups))
+	for i, group := range groups {
+		representatives[i] = group.chunk
+		counts[i] = group.results
+	}
+	return representatives, counts
+}
@@ -0,0 +1,128 @@
+package deployment
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"thirdai_platform/model_bazaar/config"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	routeInFlightMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deployment_route_in_flight", Help: "Requests currently being processed for a concurrency limited route",
+	}, []string{"route"})
+	routeSaturationMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deployment_route_saturation", Help: "Fraction of the concurrency limit in use for a route",
+	}, []string{"route"})
+	routeQueuedMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "deployment_route_queued", Help: "Requests waiting for a concurrency limited route",
+	}, []string{"route"})
+	routeQueueWaitMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "deployment_route_queue_wait_seconds", Help: "Time requests spent waiting for a concurrency limited route",
+	}, []string{"route"})
+	routeRejectedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deployment_route_rejected_total", Help: "Requests rejected because a concurrency limited route was saturated",
+	}, []string{"route"})
+)
+
+type concurrencyLimiter struct {
+	route     string
+	limit     config.ConcurrencyLimit
+	slots     chan struct{}
+	inFlight  atomic.Int64
+	queued    atomic.Int64
+	maxQueued int64
+}
+
+// ConcurrencyLimitMiddleware limits the number of requests to the route that
+// are processed at once. Requests over the limit are queued, and 429 is
+// returned if the queue is full or a request times out waiting in the queue.
+func ConcurrencyLimitMiddleware(route string, limit config.ConcurrencyLimit) func(http.Handler) http.Handler {
+	if limit.MaxConcurrent <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	l := &concurrencyLimiter{
+		route:     route,
+		limit:     limit,
+		slots:     make(chan struct{}, limit.MaxConcurrent),
+		maxQueued: int64(limit.MaxQueued),
+	}
+
+	return l.middleware
+}
+
+func (l *concurrencyLimiter) reject(w http.ResponseWriter, reason string) {
+	routeRejectedMetric.WithLabelValues(l.route).Inc()
+	slog.Warn("rejected request for saturated route", "route", l.route, "reason", reason)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(l.limit.QueueTimeout().Seconds())))
+	http.Error(w, fmt.Sprintf("too many concurrent requests for /%v: %v", l.route, reason), http.StatusTooManyRequests)
+}
+
+// acquire returns true once the request holds a slot, and false if the request
+// was rejected or cancelled while waiting.
+func (l *concurrencyLimiter) acquire(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueued {
+		l.queued.Add(-1)
+		l.reject(w, "queue is full")
+		return false
+	}
+	routeQueuedMetric.WithLabelValues(l.route).Inc()
+	defer func() {
+		l.queued.Add(-1)
+		routeQueuedMetric.WithLabelValues(l.route).Dec()
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(l.limit.QueueTimeout())
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		routeQueueWaitMetric.WithLabelValues(l.route).Observe(time.Since(start).Seconds())
+		return true
+	case <-timer.C:
+		routeQueueWaitMetric.WithLabelValues(l.route).Observe(time.Since(start).Seconds())
+		l.reject(w, "timed out waiting in queue")
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) updateInFlight(delta int64) {
+	inFlight := l.inFlight.Add(delta)
+	routeInFlightMetric.WithLabelValues(l.route).Set(float64(inFlight))
+	routeSaturationMetric.WithLabelValues(l.route).Set(float64(inFlight) / float64(l.limit.MaxConcurrent))
+}
+
+func (l *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(w, r) {
+			return
+		}
+
+		l.updateInFlight(1)
+		defer func() {
+			<-l.slots
+			l.updateInFlight(-1)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
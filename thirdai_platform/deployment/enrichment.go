@@ -0,0 +1,213 @@
+package deployment
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/logging"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	enrichmentMetric         = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_enrichment", Help: "NDB result enrichments"})
+	enrichmentCacheHitMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ndb_enrichment_cache_hits_total", Help: "Source ids whose enrichment fields were found in the cache",
+	})
+	enrichmentFailuresMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ndb_enrichment_failures_total", Help: "Source ids that were returned without enrichment fields because the enrichment endpoint failed or timed out",
+	})
+)
+
+// The largest response that is read from the enrichment endpoint.
+const maxEnrichmentResponseBytes = 1 << 20
+
+type enrichmentEntry struct {
+	key     string
+	fields  map[string]interface{}
+	expires time.Time
+}
+
+// Enricher adds fields from an external service to the results of queries. The
+// fields of each source are cached with a TTL, and the oldest entries are
+// evicted once the cache is full.
+type Enricher struct {
+	opts   config.EnrichmentOptions
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func NewEnricher(opts config.EnrichmentOptions) *Enricher {
+	return &Enricher{
+		opts:    opts,
+		client:  &http.Client{Transport: utils.OutboundTransport(), Timeout: opts.Timeout()},
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (e *Enricher) get(key string) (map[string]interface{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	elem, ok := e.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*enrichmentEntry)
+	if time.Now().After(entry.expires) {
+		e.order.Remove(elem)
+		delete(e.entries, key)
+		return nil, false
+	}
+	e.order.MoveToFront(elem)
+	return entry.fields, true
+}
+
+func (e *Enricher) put(key string, fields map[string]interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry := &enrichmentEntry{key: key, fields: fields, expires: time.Now().Add(e.opts.CacheTtl())}
+	if elem, ok := e.entries[key]; ok {
+		elem.Value = entry
+		e.order.MoveToFront(elem)
+		return
+	}
+	e.entries[key] = e.order.PushFront(entry)
+
+	for e.order.Len() > e.opts.CacheLimit() {
+		oldest := e.order.Back()
+		e.order.Remove(oldest)
+		delete(e.entries, oldest.Value.(*enrichmentEntry).key)
+	}
+}
+
+// fetch returns the fields of the source from the enrichment endpoint. Sources
+// the endpoint does not know about have no fields. The team is passed to the
+// endpoint in deployments with team isolation, since teams can use the same
+// source ids for different documents.
+func (e *Enricher) fetch(ctx context.Context, team, sourceId string) (map[string]interface{}, error) {
+	endpoint, err := url.Parse(strings.ReplaceAll(e.opts.Endpoint, config.EnrichmentSourceIdPlaceholder, url.PathEscape(sourceId)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrichment url for source '%v': %w", sourceId, err)
+	}
+	if team != "" {
+		query := endpoint.Query()
+		query.Set("team_id", team)
+		endpoint.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating enrichment request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return map[string]interface{}{}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("enrichment endpoint returned status %d", res.StatusCode)
+	}
+
+	var fields map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxEnrichmentResponseBytes)).Decode(&fields); err != nil {
+		return nil, fmt.Errorf("enrichment endpoint returned invalid json object: %w", err)
+	}
+
+	if len(e.opts.Fields) > 0 {
+		selected := make(map[string]interface{}, len(e.opts.Fields))
+		for _, field := range e.opts.Fields {
+			if value, ok := fields[field]; ok {
+				selected[field] = value
+			}
+		}
+		fields = selected
+	}
+	return fields, nil
+}
+
+// Enrich returns a copy of the results with the fields of their sources. The
+// sources that are not cached are fetched concurrently, and results whose
+// source cannot be fetched within the timeout are returned without fields, so
+// that a slow or unavailable endpoint does not fail the query.
+func (e *Enricher) Enrich(ctx context.Context, team string, results []SearchResult) []SearchResult {
+	timer := prometheus.NewTimer(enrichmentMetric)
+	defer timer.ObserveDuration()
+
+	fields := make(map[string]map[string]interface{})
+	var missing []string
+	for _, result := range results {
+		if _, ok := fields[result.SourceId]; ok {
+			continue
+		}
+		if cached, ok := e.get(teamDocId(team, result.SourceId)); ok {
+			enrichmentCacheHitMetric.Inc()
+			fields[result.SourceId] = cached
+		} else {
+			// Marks the source as seen, it is replaced once it is fetched.
+			fields[result.SourceId] = nil
+			missing = append(missing, result.SourceId)
+		}
+	}
+
+	if len(missing) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout())
+		defer cancel()
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, e.opts.ConcurrencyLimit())
+		for _, sourceId := range missing {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+
+				sourceFields, err := e.fetch(ctx, team, sourceId)
+				if err != nil {
+					enrichmentFailuresMetric.Inc()
+					slog.Warn("error enriching query result", "source_id", sourceId, "error", err, "code", logging.MODEL_SEARCH)
+					return
+				}
+				e.put(teamDocId(team, sourceId), sourceFields)
+
+				mu.Lock()
+				fields[sourceId] = sourceFields
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+	}
+
+	enriched := make([]SearchResult, len(results))
+	for i, result := range results {
+		enriched[i] = result
+		if len(fields[result.SourceId]) > 0 {
+			enriched[i].Enrichment = fields[result.SourceId]
+		}
+	}
+	return enriched
+}
@@ -0,0 +1,130 @@
+package deployment
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultFacetMaxResults = 1000
+	maxFacetMaxResults     = 10000
+	defaultFacetMaxValues  = 20
+	maxFacetKeys           = 20
+)
+
+type FacetsRequest struct {
+	Query       string                     `json:"query"`
+	Keys        []string                   `json:"keys"`
+	Constraints map[string]ConstraintInput `json:"constraints,omitempty"`
+	// MaxResults is the number of chunks that are searched for the query and
+	// counted.
+	MaxResults int `json:"max_results,omitempty"`
+	// MaxValues is the number of values returned for each key.
+	MaxValues int `json:"max_values,omitempty"`
+}
+
+type FacetValue struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+type FacetsResults struct {
+	// Facets are the values of each key in the matching chunks, ordered by the
+	// number of chunks with each value.
+	Facets map[string][]FacetValue `json:"facets"`
+	// Matched is the number of chunks that matched the query and were counted.
+	Matched int `json:"matched"`
+}
+
+func (req *FacetsRequest) validate() error {
+	if req.Query == "" {
+		return fmt.Errorf("query must not be empty")
+	}
+	if len(req.Keys) == 0 || len(req.Keys) > maxFacetKeys {
+		return fmt.Errorf("keys must have between 1 and %d metadata keys", maxFacetKeys)
+	}
+	if req.MaxResults < 0 || req.MaxResults > maxFacetMaxResults {
+		return fmt.Errorf("max_results must be between 1 and %d", maxFacetMaxResults)
+	}
+	if req.MaxValues < 0 {
+		return fmt.Errorf("max_values cannot be negative")
+	}
+	return nil
+}
+
+func compareFacetValues(a, b interface{}) int {
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Facets counts the values of metadata keys in the chunks that match a query,
+// so that clients can show filters for the results without searching all of
+// them. The ndb cannot aggregate metadata, so the top max_results chunks for
+// the query are counted.
+func (s *NdbRouter) Facets(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(facetsMetric)
+	defer timer.ObserveDuration()
+
+	var req FacetsRequest
+	if !utils.ParseRequestBody(w, r, &req) {
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MaxResults == 0 {
+		req.MaxResults = defaultFacetMaxResults
+	}
+	if req.MaxValues == 0 {
+		req.MaxValues = defaultFacetMaxValues
+	}
+
+	constraints, err := parseConstraints(withTeamConstraint(teamFromContext(r.Context()), req.Constraints))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	chunks, err := s.Ndb.Query(req.Query, req.MaxResults, constraints)
+	if err != nil {
+		slog.Error("ndb query error", "error", err, "code", logging.MODEL_SEARCH)
+		http.Error(w, "could not process query", http.StatusInternalServerError)
+		return
+	}
+
+	results := FacetsResults{Facets: make(map[string][]FacetValue, len(req.Keys)), Matched: len(chunks)}
+	for _, key := range req.Keys {
+		// Metadata values are always bools, numbers, or strings, so they can be
+		// used as map keys.
+		counts := make(map[interface{}]int)
+		for _, chunk := range chunks {
+			if value, ok := chunk.Metadata[key]; ok {
+				counts[value]++
+			}
+		}
+
+		values := make([]FacetValue, 0, len(counts))
+		for value, count := range counts {
+			values = append(values, FacetValue{Value: value, Count: count})
+		}
+		slices.SortFunc(values, func(a, b FacetValue) int {
+			if a.Count != b.Count {
+				return cmp.Compare(b.Count, a.Count)
+			}
+			return compareFacetValues(a.Value, b.Value)
+		})
+
+		results.Facets[key] = values[:min(len(values), req.MaxValues)]
+	}
+
+	utils.WriteJsonResponse(w, results)
+	slog.Debug("computed facets", "query", req.Query, "keys", req.Keys, "matched", len(chunks), "code", logging.MODEL_SEARCH)
+}
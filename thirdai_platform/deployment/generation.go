@@ -0,0 +1,255 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var generationMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "llm_generations_total", Help: "LLM generations by final status (completed, failed, or cancelled)",
+}, []string{"status"})
+
+const (
+	defaultKeepAliveInterval   = 15 * time.Second
+	defaultResumeGracePeriod   = 30 * time.Second
+	defaultGenerationRetention = 5 * time.Minute
+)
+
+// generation is an llm response that is generated independently of the request
+// that started it, so that a client that loses its connection can resume the
+// stream from the last event it received.
+type generation struct {
+	id     string
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	chunks      []string
+	done        bool
+	err         error
+	finishedAt  time.Time
+	subscribers int
+	// updated is closed and replaced whenever a chunk is added or the generation
+	// finishes, to wake up any requests that are streaming the generation.
+	updated chan struct{}
+}
+
+func (g *generation) notify() {
+	close(g.updated)
+	g.updated = make(chan struct{})
+}
+
+func (g *generation) append(chunk string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.chunks = append(g.chunks, chunk)
+	g.notify()
+}
+
+func (g *generation) finish(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.done = true
+	g.err = err
+	g.finishedAt = time.Now()
+	g.notify()
+}
+
+// next returns the chunks starting at index from, whether the generation is
+// done, and a channel that is closed on the next update.
+func (g *generation) next(from int) ([]string, bool, error, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var chunks []string
+	if from < len(g.chunks) {
+		chunks = g.chunks[from:]
+	}
+	return chunks, g.done, g.err, g.updated
+}
+
+type GenerationStore struct {
+	KeepAliveInterval time.Duration
+	// The upstream llm call is aborted if no client is streaming the generation
+	// for this long, since the client is not going to resume it.
+	ResumeGracePeriod time.Duration
+	// Finished generations are kept for this long so that they can be resumed.
+	Retention time.Duration
+
+	mu          sync.Mutex
+	generations map[string]*generation
+}
+
+func NewGenerationStore() *GenerationStore {
+	return &GenerationStore{
+		KeepAliveInterval: defaultKeepAliveInterval,
+		ResumeGracePeriod: defaultResumeGracePeriod,
+		Retention:         defaultGenerationRetention,
+		generations:       make(map[string]*generation),
+	}
+}
+
+// Start runs generate in the background. The chunks passed to the callback are
+// stored so they can be streamed to clients, and onComplete is called with the
+// full response if the generation succeeds.
+func (s *GenerationStore) Start(
+	generate func(ctx context.Context, onChunk func(string)) (string, error), onComplete func(string),
+) *generation {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gen := &generation{id: uuid.NewString(), cancel: cancel, updated: make(chan struct{})}
+
+	s.mu.Lock()
+	now := time.Now()
+	for id, g := range s.generations {
+		g.mu.Lock()
+		expired := g.done && now.Sub(g.finishedAt) > s.Retention
+		g.mu.Unlock()
+		if expired {
+			delete(s.generations, id)
+		}
+	}
+	s.generations[gen.id] = gen
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+
+		res, err := generate(ctx, gen.append)
+		switch {
+		case err == nil:
+			generationMetric.WithLabelValues("completed").Inc()
+			onComplete(res)
+		case ctx.Err() != nil:
+			// The llm returns whatever error the client produced when the request
+			// was aborted, so the context is checked to detect cancellation.
+			generationMetric.WithLabelValues("cancelled").Inc()
+			err = fmt.Errorf("generation cancelled since the client disconnected")
+		default:
+			generationMetric.WithLabelValues("failed").Inc()
+		}
+		gen.finish(err)
+	}()
+
+	return gen
+}
+
+func (s *GenerationStore) get(id string) *generation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generations[id]
+}
+
+func (s *GenerationStore) subscribe(gen *generation) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+	gen.subscribers++
+}
+
+func (s *GenerationStore) unsubscribe(gen *generation) {
+	gen.mu.Lock()
+	defer gen.mu.Unlock()
+
+	gen.subscribers--
+	if gen.subscribers > 0 || gen.done {
+		return
+	}
+
+	time.AfterFunc(s.ResumeGracePeriod, func() {
+		gen.mu.Lock()
+		abort := gen.subscribers == 0 && !gen.done
+		gen.mu.Unlock()
+
+		if abort {
+			slog.Info("aborting upstream llm call since client disconnected and did not resume", "generation_id", gen.id)
+			gen.cancel()
+		}
+	})
+}
+
+func eventId(genId string, index int) string {
+	return fmt.Sprintf("%s:%d", genId, index)
+}
+
+// parseEventId returns the generation id and the index of the chunk after the
+// given event id.
+func parseEventId(id string) (string, int, error) {
+	genId, index, found := strings.Cut(id, ":")
+	if !found {
+		return "", 0, fmt.Errorf("invalid event id '%v'", id)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 {
+		return "", 0, fmt.Errorf("invalid event id '%v'", id)
+	}
+	return genId, i + 1, nil
+}
+
+// Stream writes the chunks of the generation starting at index from as server
+// sent events. Comments are sent periodically while waiting for the llm so that
+// proxies do not close the connection as idle. Returns once the generation is
+// finished, or the client disconnects.
+func (s *GenerationStore) Stream(w http.ResponseWriter, r *http.Request, gen *generation, from int) {
+	s.subscribe(gen)
+	defer s.unsubscribe(gen)
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(s.KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	write := func(event string) bool {
+		if _, err := fmt.Fprint(w, event); err != nil {
+			slog.Warn("error writing generation event, client likely disconnected", "generation_id", gen.id, "error", err)
+			return false
+		}
+		if err := rc.Flush(); err != nil {
+			slog.Warn("error flushing generation event, client likely disconnected", "generation_id", gen.id, "error", err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		chunks, done, err, updated := gen.next(from)
+
+		for _, chunk := range chunks {
+			if !write(fmt.Sprintf("id: %s\ndata: %s\n\n", eventId(gen.id, from), chunk)) {
+				return
+			}
+			from++
+		}
+
+		if done {
+			if err != nil {
+				write(fmt.Sprintf("event: error\ndata: %s\n\n", err.Error()))
+			}
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-keepAlive.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package deployment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/search/ndb"
+	"thirdai_platform/search/parsing"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/logging"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var insertFilesMetric = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_insert_files", Help: "NDB Inserts From Files"})
+
+// The files in a request are held in memory while they are parsed, so the total
+// size of the files is limited. Larger datasets should be used to retrain the
+// model instead.
+const maxInsertFilesBytes = 200 * 1024 * 1024
+
+type InsertFilesOptions struct {
+	parsing.ChunkOptions
+	// Metadata that is added to every chunk of the documents.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// The doc id can only be specified if a single file is inserted, otherwise
+	// each document is assigned a random doc id.
+	DocId   string `json:"doc_id,omitempty"`
+	Version *uint  `json:"version,omitempty"`
+}
+
+type InsertFilesRequest struct {
+	UploadId uuid.UUID          `json:"upload_id"`
+	Options  InsertFilesOptions `json:"options"`
+}
+
+type InsertedDocument struct {
+	Document string `json:"document"`
+	DocId    string `json:"doc_id"`
+	Chunks   int    `json:"chunks"`
+}
+
+type InsertFilesResponse struct {
+	Documents []InsertedDocument `json:"documents"`
+}
+
+type parsedDocument struct {
+	name   string
+	chunks []parsing.Chunk
+}
+
+// InsertFiles parses and chunks documents, and inserts them into the ndb. The
+// files are either sent in a multipart request, or are in an upload created
+// with the train/upload-data endpoint of model bazaar. All files are parsed
+// before any are inserted, so a file that cannot be parsed does not leave the
+// other files partially inserted.
+func (s *NdbRouter) InsertFiles(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(insertFilesMetric)
+	defer timer.ObserveDuration()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInsertFilesBytes)
+
+	var opts InsertFilesOptions
+	var docs []parsedDocument
+	var err error
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		docs, err = parseMultipartFiles(r, params["boundary"], &opts)
+	} else {
+		var req InsertFilesRequest
+		if !utils.ParseRequestBody(w, r, &req) {
+			return
+		}
+		opts = req.Options
+		docs, err = s.parseUploadFiles(jwtauth.TokenFromHeader(r), req.UploadId, &opts)
+	}
+	if err != nil {
+		code := services.GetResponseCode(err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if len(docs) == 0 {
+		http.Error(w, "no files were provided", http.StatusBadRequest)
+		return
+	}
+	if opts.DocId != "" && len(docs) > 1 {
+		http.Error(w, "doc_id can only be specified when a single file is inserted", http.StatusUnprocessableEntity)
+		return
+	}
+
+	defer s.invalidateQueryCache()
+
+	team := teamFromContext(r.Context())
+	res := InsertFilesResponse{Documents: make([]InsertedDocument, 0, len(docs))}
+	for _, doc := range docs {
+		docId := opts.DocId
+		if docId == "" {
+			docId = uuid.NewString()
+		}
+
+		chunks := make([]string, len(doc.chunks))
+		metadata := make([]map[string]interface{}, len(doc.chunks))
+		for i, chunk := range doc.chunks {
+			chunks[i] = chunk.Text
+			metadata[i] = maps.Clone(opts.Metadata)
+			if metadata[i] == nil {
+				metadata[i] = make(map[string]interface{})
+			}
+			if chunk.Page > 0 {
+				metadata[i]["page"] = chunk.Page
+			}
+		}
+
+		metadata = withTeamMetadata(team, metadata, len(chunks))
+
+		if err := s.Ndb.Insert(doc.name, teamDocId(team, docId), chunks, metadata, opts.Version); err != nil {
+			slog.Error("insert error", "error", err, "document", doc.name, "code", logging.MODEL_INSERT)
+			http.Error(w, fmt.Sprintf("insert error for document '%s': %v", doc.name, err), http.StatusInternalServerError)
+			return
+		}
+		if s.Spelling != nil {
+			s.Spelling.AddChunks(chunks)
+		}
+		res.Documents = append(res.Documents, InsertedDocument{Document: doc.name, DocId: docId, Chunks: len(chunks)})
+	}
+
+	if opts.DocId != "" {
+		// Documents with new doc ids cannot have been used by cached responses.
+		s.invalidateLLMCache([]string{opts.DocId})
+	}
+
+	utils.WriteJsonResponse(w, res)
+	slog.Info("inserted documents from files", "documents", len(res.Documents), "code", logging.MODEL_INSERT)
+}
+
+func validateInsertFilesOptions(opts *InsertFilesOptions) error {
+	if err := opts.ChunkOptions.Validate(); err != nil {
+		return err
+	}
+	// The doc id and metadata are checked before the files are parsed, so the
+	// document name, doc id, and chunks are placeholders if not specified.
+	docId := opts.DocId
+	if docId == "" {
+		docId = "doc_id"
+	}
+	return ndb.CheckInsertArgs("document", docId, []string{""}, []map[string]interface{}{opts.Metadata})
+}
+
+// parseDocument parses and chunks a single file.
+func parseDocument(name string, data []byte, opts InsertFilesOptions) (parsedDocument, error) {
+	blocks, err := parsing.Parse(name, data)
+	if err != nil {
+		return parsedDocument{}, services.CodedError(err, http.StatusUnprocessableEntity)
+	}
+	chunks := parsing.ChunkBlocks(blocks, opts.ChunkOptions)
+	if len(chunks) == 0 {
+		return parsedDocument{}, services.CodedError(fmt.Errorf("no text found in '%s'", name), http.StatusUnprocessableEntity)
+	}
+	return parsedDocument{name: name, chunks: chunks}, nil
+}
+
+// parseMultipartFiles parses the files in the request as they are read. The
+// options field must be sent before the files so that they can be chunked as
+// they are parsed.
+func parseMultipartFiles(r *http.Request, boundary string, opts *InsertFilesOptions) ([]parsedDocument, error) {
+	if boundary == "" {
+		return nil, services.CodedError(fmt.Errorf("missing 'boundary' parameter in 'Content-Type' header"), http.StatusBadRequest)
+	}
+
+	if err := validateInsertFilesOptions(opts); err != nil {
+		return nil, services.CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	docs := make([]parsedDocument, 0)
+	reader := multipart.NewReader(r.Body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, services.CodedError(fmt.Errorf("error parsing multipart request: %w", err), http.StatusBadRequest)
+		}
+
+		switch part.FormName() {
+		case "options":
+			if len(docs) > 0 {
+				return nil, services.CodedError(fmt.Errorf("options must be sent before the files"), http.StatusBadRequest)
+			}
+			*opts = InsertFilesOptions{}
+			if err := json.NewDecoder(part).Decode(opts); err != nil {
+				return nil, services.CodedError(fmt.Errorf("error parsing options: %w", err), http.StatusBadRequest)
+			}
+			if err := validateInsertFilesOptions(opts); err != nil {
+				return nil, services.CodedError(err, http.StatusUnprocessableEntity)
+			}
+		case "files":
+			name := filepath.Base(part.FileName())
+			if part.FileName() == "" {
+				return nil, services.CodedError(fmt.Errorf("invalid filename detected in files: filename cannot be empty"), http.StatusUnprocessableEntity)
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return nil, services.CodedError(fmt.Errorf("error reading file '%s': %w", name, err), http.StatusBadRequest)
+			}
+			doc, err := parseDocument(name, data, *opts)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+		part.Close()
+	}
+}
+
+// parseUploadFiles parses the files in an upload. Uploads can only be read by
+// the user that created them, which is checked with model bazaar since the
+// deployment does not have access to the database.
+func (s *NdbRouter) parseUploadFiles(token string, uploadId uuid.UUID, opts *InsertFilesOptions) ([]parsedDocument, error) {
+	if uploadId == uuid.Nil {
+		return nil, services.CodedError(fmt.Errorf("upload_id must be specified for json requests"), http.StatusBadRequest)
+	}
+
+	if err := validateInsertFilesOptions(opts); err != nil {
+		return nil, services.CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	if err := s.Permissions.CheckUploadAccess(token, uploadId); err != nil {
+		slog.Error("error checking upload access", "upload_id", uploadId, "error", err, "code", logging.MODEL_INSERT)
+		return nil, services.CodedError(fmt.Errorf("unable to access upload %v", uploadId), http.StatusForbidden)
+	}
+
+	uploadDir := filepath.Join(s.Config.ModelBazaarDir, storage.UploadPath(uploadId))
+
+	paths := make([]string, 0)
+	totalSize := int64(0)
+	err := filepath.WalkDir(uploadDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		totalSize += info.Size()
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		slog.Error("error listing upload files", "upload_id", uploadId, "error", err, "code", logging.MODEL_INSERT)
+		return nil, services.CodedError(fmt.Errorf("error listing files in upload %v", uploadId), http.StatusInternalServerError)
+	}
+	if totalSize > maxInsertFilesBytes {
+		return nil, services.CodedError(fmt.Errorf("upload %v is larger than the limit of %dMb for inserting files into a deployment, retrain the model instead", uploadId, maxInsertFilesBytes/1024/1024), http.StatusRequestEntityTooLarge)
+	}
+
+	docs := make([]parsedDocument, 0, len(paths))
+	for _, path := range paths {
+		// Files in subdirectories of the upload are named by their relative path.
+		name, err := filepath.Rel(uploadDir, path)
+		if err != nil {
+			name = filepath.Base(path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("error reading upload file", "upload_id", uploadId, "file", name, "error", err, "code", logging.MODEL_INSERT)
+			return nil, services.CodedError(fmt.Errorf("error reading file '%s' in upload", name), http.StatusInternalServerError)
+		}
+
+		doc, err := parseDocument(name, data, *opts)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
@@ -1,6 +1,8 @@
 package deployment
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"thirdai_platform/client"
@@ -15,12 +17,15 @@ type PermissionType string
 const (
 	ReadPermission  PermissionType = "read"
 	WritePermission PermissionType = "write"
+	OwnerPermission PermissionType = "owner"
+	AdminPermission PermissionType = "admin"
 )
 
 // Use an interface so we can mock it for unit tests
 type PermissionsInterface interface {
 	GetModelPermissions(token string) (services.ModelPermissions, error)
 	ModelPermissionsCheck(permissionType PermissionType) func(http.Handler) http.Handler
+	CheckUploadAccess(token string, uploadId uuid.UUID) error
 }
 
 type Permissions struct {
@@ -33,25 +38,43 @@ func (p *Permissions) GetModelPermissions(token string) (services.ModelPermissio
 	return client.GetPermissions()
 }
 
+func (p *Permissions) CheckUploadAccess(token string, uploadId uuid.UUID) error {
+	client := client.NewBaseClient(p.ModelBazaarEndpoint, token)
+	return client.Get(fmt.Sprintf("/api/v2/train/upload/%v", uploadId)).Do(nil)
+}
+
 func (p *Permissions) ModelPermissionsCheck(permission_type PermissionType) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		hfn := func(w http.ResponseWriter, r *http.Request) {
 			token := jwtauth.TokenFromHeader(r)
-			if token != "" {
+			if token == "" {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
 			modelPermissions, err := p.GetModelPermissions(token)
 			if err != nil {
+				// Model bazaar rate limits the permission checks, which is how
+				// the query rate limits apply to deployments.
+				var apiErr *client.APIError
+				if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+					if apiErr.RetryAfter != "" {
+						w.Header().Set("Retry-After", apiErr.RetryAfter)
+					}
+					http.Error(w, "rate limit exceeded, retry after the time in the Retry-After header", http.StatusTooManyRequests)
+					return
+				}
 				http.Error(w, "Failed to retrieve permissions", http.StatusInternalServerError)
 				return
 			}
 
-			hasPermission := (permission_type == ReadPermission && modelPermissions.Read) || (permission_type == WritePermission && modelPermissions.Write)
+			hasPermission := (permission_type == ReadPermission && modelPermissions.Read) ||
+				(permission_type == WritePermission && modelPermissions.Write) ||
+				(permission_type == OwnerPermission && modelPermissions.Owner) ||
+				(permission_type == AdminPermission && modelPermissions.Admin)
 
 			if hasPermission {
-				next.ServeHTTP(w, r)
+				next.ServeHTTP(w, r.WithContext(WithModelPermissions(r.Context(), modelPermissions)))
 				return
 			}
 
@@ -60,3 +83,16 @@ func (p *Permissions) ModelPermissionsCheck(permission_type PermissionType) func
 		return http.HandlerFunc(hfn)
 	}
 }
+
+type permissionsContextKey struct{}
+
+// WithModelPermissions adds the permissions of the caller to the context, so
+// that handlers can use the caller's identity after the permission check.
+func WithModelPermissions(ctx context.Context, permissions services.ModelPermissions) context.Context {
+	return context.WithValue(ctx, permissionsContextKey{}, permissions)
+}
+
+func ModelPermissionsFromContext(ctx context.Context) (services.ModelPermissions, bool) {
+	permissions, ok := ctx.Value(permissionsContextKey{}).(services.ModelPermissions)
+	return permissions, ok
+}
@@ -0,0 +1,113 @@
+package deployment
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"thirdai_platform/model_bazaar/config"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryCacheHitMetric     = promauto.NewCounter(prometheus.CounterOpts{Name: "ndb_query_cache_hits", Help: "NDB queries served from the query cache"})
+	queryCacheMissMetric    = promauto.NewCounter(prometheus.CounterOpts{Name: "ndb_query_cache_misses", Help: "NDB queries not found in the query cache"})
+	queryCacheEntriesMetric = promauto.NewGauge(prometheus.GaugeOpts{Name: "ndb_query_cache_entries", Help: "Number of entries in the NDB query cache"})
+)
+
+type queryCacheEntry struct {
+	key     string
+	results SearchResults
+	expires time.Time
+}
+
+// QueryCache is an LRU cache of query results with a TTL on each entry. The
+// cache is invalidated whenever the ndb is modified, and each invalidation
+// increments the generation so that results computed before the modification
+// are not added to the cache after it.
+type QueryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+	generation uint64
+}
+
+func NewQueryCache(opts config.QueryCacheOptions) *QueryCache {
+	return &QueryCache{
+		maxEntries: opts.MaxEntries,
+		ttl:        opts.Ttl(),
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func queryCacheKey(req SearchRequest) (string, error) {
+	// Map keys are sorted when serialized, so equivalent constraints produce the same key.
+	key, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+func (c *QueryCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*queryCacheEntry).key)
+}
+
+// Get returns the cached results for the key, and the current generation of the
+// cache, which must be passed to Put if the results are not cached.
+func (c *QueryCache) Get(key string) (*SearchResults, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*queryCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			queryCacheHitMetric.Inc()
+			return &entry.results, c.generation
+		}
+		c.removeElement(elem)
+		queryCacheEntriesMetric.Set(float64(c.order.Len()))
+	}
+
+	queryCacheMissMetric.Inc()
+	return nil, c.generation
+}
+
+func (c *QueryCache) Put(key string, results SearchResults, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+
+	c.entries[key] = c.order.PushFront(&queryCacheEntry{key: key, results: results, expires: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+
+	queryCacheEntriesMetric.Set(float64(c.order.Len()))
+}
+
+func (c *QueryCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+
+	queryCacheEntriesMetric.Set(0)
+}
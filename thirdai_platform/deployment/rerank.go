@@ -0,0 +1,48 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/search/ndb"
+	"thirdai_platform/utils/llm_generation"
+	"thirdai_platform/utils/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rerankMetric         = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_rerank", Help: "NDB Reranks"})
+	rerankFailuresMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ndb_rerank_failures_total", Help: "NDB queries that returned the lexical results because reranking failed",
+	})
+)
+
+func newRerankEmbedder(cfg *config.DeployConfig) (ndb.Embedder, error) {
+	switch cfg.Rerank.Provider {
+	case config.RerankLocal:
+		return llm_generation.NewOpenAIEmbedder(cfg.Rerank.Endpoint, "", cfg.Rerank.Model), nil
+	case config.RerankLlmDispatch:
+		return llm_generation.NewDispatchEmbedder(cfg.ModelBazaarEndpoint, llm_generation.LLMProvider(cfg.Rerank.LlmProvider), cfg.Options["rerank_genai_key"], cfg.Rerank.Model)
+	default:
+		return nil, fmt.Errorf("invalid rerank provider '%v'", cfg.Rerank.Provider)
+	}
+}
+
+// rerank reorders the chunks by the similarity of their embeddings to the
+// query. The lexical order is kept if the embeddings cannot be computed, so
+// that queries still succeed when the embedding model is unavailable.
+func (s *NdbRouter) rerank(ctx context.Context, query string, chunks []ndb.Chunk) []ndb.Chunk {
+	timer := prometheus.NewTimer(rerankMetric)
+	defer timer.ObserveDuration()
+
+	reranked, err := ndb.Rerank(ctx, s.Embedder, query, chunks, s.Config.Rerank.SimilarityWeight())
+	if err != nil {
+		slog.Warn("rerank failed, returning lexical results", "error", err, "code", logging.MODEL_SEARCH)
+		rerankFailuresMetric.Inc()
+		return chunks
+	}
+	return reranked
+}
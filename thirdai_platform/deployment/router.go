@@ -1,19 +1,24 @@
 package deployment
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"sync"
 	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/search/ndb"
 	"thirdai_platform/utils"
 	"thirdai_platform/utils/llm_generation"
 	"thirdai_platform/utils/logging"
+	"time"
 
 	slogmulti "github.com/samber/slog-multi"
 
@@ -28,10 +33,12 @@ import (
 var (
 	topKSelectionsToTrack = 5
 	queryMetric           = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_query", Help: "NDB Queries"})
+	queryErrorsMetric     = promauto.NewCounter(prometheus.CounterOpts{Name: "ndb_query_errors", Help: "NDB Queries that failed with a server error"})
 	upvoteMetric          = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_upvote", Help: "NDB Upvotes"})
 	associateMetric       = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_associate", Help: "NDB Associations"})
 	insertMetric          = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_insert", Help: "NDB Inserts"})
 	deleteMetric          = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_delete", Help: "NDB Deletes"})
+	facetsMetric          = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_facets", Help: "NDB Facets"})
 
 	implicitFeedbackMetric = promauto.NewSummary(prometheus.SummaryOpts{Name: "ndb_implicit_feedback", Help: "NDB Implicit Feedback"})
 
@@ -56,6 +63,24 @@ type NdbRouter struct {
 	Permissions PermissionsInterface
 	LLMCache    *LLMCache
 	LLM         llm_generation.LLM
+	QueryCache  *QueryCache
+	Generations *GenerationStore
+	Scrolls     *ScrollStore
+	Shadow      *Shadow
+	Spelling    *SpellChecker
+	// Embedder computes the embeddings used to rerank query results if rerank
+	// is enabled for the deployment.
+	Embedder ndb.Embedder
+	// Enricher adds fields from an external service to query results if
+	// enrichment is enabled for the deployment.
+	Enricher *Enricher
+
+	// ndbLock is held for writing while the ndb is replaced by a snapshot, and
+	// while a snapshot is saved so that it does not contain a partial update.
+	ndbLock sync.RWMutex
+	// ndbAlias is the directory of the symlink the ndb was opened through after
+	// a snapshot was restored, see openNdbAlias.
+	ndbAlias string
 }
 
 func InitLogging(logFile *os.File, config *config.DeployConfig) {
@@ -76,8 +101,24 @@ func InitLogging(logFile *os.File, config *config.DeployConfig) {
 	slog.SetDefault(logger)
 }
 
+func ndbPath(deployConfig *config.DeployConfig) string {
+	return filepath.Join(deployConfig.ModelBazaarDir, "models", deployConfig.ModelId.String(), "model", "model.ndb")
+}
+
 func NewNdbRouter(config *config.DeployConfig, reporter Reporter) (*NdbRouter, error) {
-	ndbPath := filepath.Join(config.ModelBazaarDir, "models", config.ModelId.String(), "model", "model.ndb")
+	ndbPath := ndbPath(config)
+
+	if config.NdbLoad != nil && config.NdbLoad.Preload() {
+		start := time.Now()
+		bytes, err := preloadNdb(ndbPath)
+		if err != nil {
+			// Preloading is only an optimization, so the ndb can still be opened.
+			slog.Warn("error preloading ndb", "error", err, "code", logging.MODEL_INIT)
+		} else {
+			slog.Info("preloaded ndb", "bytes", bytes, "duration", time.Since(start).String(), "code", logging.MODEL_INIT)
+		}
+	}
+
 	ndb, err := ndb.New(ndbPath)
 	if err != nil {
 		slog.Error("failed to open ndb", "error", err, "code", logging.MODEL_INIT)
@@ -87,6 +128,8 @@ func NewNdbRouter(config *config.DeployConfig, reporter Reporter) (*NdbRouter, e
 	var llmCache *LLMCache
 	var llm llm_generation.LLM
 
+	// The llm cache and spelling vocabulary are shared by all teams, so they are
+	// not used with team isolation since they could reveal other teams' documents.
 	if provider, exists := config.Options["llm_provider"]; exists {
 		// TODO api key should be passed as environment variable based on provider
 		// rather than passing it in the /generate endpoint from the frontend
@@ -96,29 +139,114 @@ func NewNdbRouter(config *config.DeployConfig, reporter Reporter) (*NdbRouter, e
 			return nil, err
 		}
 
-		llmCache, err = NewLLMCache(config.ModelBazaarDir, config.ModelId.String())
-		if err != nil {
-			return nil, err
+		if !config.TeamIsolation {
+			llmCache, err = NewLLMCache(config.ModelBazaarDir, config.ModelId.String(), config.LLMCache)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return &NdbRouter{
+	var queryCache *QueryCache
+	if config.QueryCache != nil {
+		queryCache = NewQueryCache(*config.QueryCache)
+	}
+
+	var shadow *Shadow
+	if config.Shadow != nil {
+		shadow = NewShadow(config.ModelBazaarEndpoint, *config.Shadow)
+		slog.Info("mirroring queries to shadow model", "shadow_model_id", config.Shadow.ModelId, "percentage", config.Shadow.Percentage, "code", logging.MODEL_INIT)
+	}
+
+	var enricher *Enricher
+	if config.Enrichment != nil {
+		enricher = NewEnricher(*config.Enrichment)
+		slog.Info("enriching query results", "endpoint", config.Enrichment.Endpoint, "code", logging.MODEL_INIT)
+	}
+
+	var spelling *SpellChecker
+	if config.SpellCorrection != nil && !config.TeamIsolation {
+		spelling = NewSpellChecker(config.ModelBazaarDir, config.ModelId.String(), *config.SpellCorrection)
+	}
+
+	router := &NdbRouter{
 		Ndb:         ndb,
 		Config:      config,
 		Reporter:    reporter,
 		Permissions: &Permissions{config.ModelBazaarEndpoint, config.ModelId},
 		LLMCache:    llmCache,
 		LLM:         llm,
-	}, nil
+		QueryCache:  queryCache,
+		Generations: NewGenerationStore(),
+		Scrolls:     NewScrollStore(),
+		Shadow:      shadow,
+		Spelling:    spelling,
+		Enricher:    enricher,
+	}
+
+	if config.Rerank != nil {
+		router.Embedder, err = newRerankEmbedder(config)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("reranking query results with embeddings", "provider", config.Rerank.Provider, "model", config.Rerank.Model, "code", logging.MODEL_INIT)
+	}
+
+	return router, nil
 }
 
 func (s *NdbRouter) Close() {
 	s.Ndb.Free()
+	if s.ndbAlias != "" {
+		if err := os.RemoveAll(s.ndbAlias); err != nil {
+			slog.Error("error removing ndb alias", "error", err)
+		}
+	}
 	if s.LLMCache != nil {
 		s.LLMCache.Close()
 	}
+	if s.Spelling != nil {
+		if err := s.Spelling.Save(); err != nil {
+			slog.Error("error saving spelling vocabulary", "error", err)
+		}
+	}
 }
 
+func (s *NdbRouter) concurrencyLimit(route string) func(http.Handler) http.Handler {
+	var limit config.ConcurrencyLimit
+	if s.Config != nil {
+		limit = s.Config.ConcurrencyLimits[route]
+	}
+	return ConcurrencyLimitMiddleware(route, limit)
+}
+
+// invalidateQueryCache must be called after any change to the ndb that could
+// change the results of a query.
+func (s *NdbRouter) invalidateQueryCache() {
+	if s.QueryCache != nil {
+		s.QueryCache.Invalidate()
+	}
+}
+
+// invalidateLLMCache must be called after documents are inserted or deleted, so
+// that cached llm responses that used an old version of them are not returned.
+func (s *NdbRouter) invalidateLLMCache(docIds []string) {
+	if s.llmCache() != nil {
+		if _, err := s.llmCache().Invalidate(docIds, nil); err != nil {
+			slog.Error("error invalidating llm cache", "error", err, "doc_ids", docIds)
+		}
+	}
+}
+
+var (
+	// Generations are streamed as the llm responds, and the stream sends keep
+	// alive events, so the limit only needs to bound abandoned connections.
+	generateTimeouts = utils.WithTimeouts(2*time.Minute, time.Hour)
+
+	// Inserts can have large request bodies and take a while to index.
+	insertTimeouts = utils.WithTimeouts(30*time.Minute, 30*time.Minute)
+)
+
 func (s *NdbRouter) Routes() chi.Router {
 	r := chi.NewRouter()
 
@@ -129,30 +257,77 @@ func (s *NdbRouter) Routes() chi.Router {
 
 	r.Group(func(r chi.Router) {
 		r.Use(s.Permissions.ModelPermissionsCheck(WritePermission))
+		r.Use(s.teamScope)
+
+		// Inserts from files share the concurrency limit of inserts since both
+		// are limited by indexing the chunks.
+		insertLimit := s.concurrencyLimit("insert")
+		r.With(insertLimit, insertTimeouts, s.ndbAccess).Post("/insert", s.Insert)
+		r.With(insertLimit, insertTimeouts, s.ndbAccess).Post("/insert-files", s.InsertFiles)
+		r.With(s.ndbAccess).Post("/delete", s.Delete)
+		r.With(s.ndbAccess).Post("/upvote", s.Upvote)
+		r.With(s.ndbAccess).Post("/associate", s.Associate)
+
+		if s.llmCache() != nil {
+			r.Post("/cache-invalidate", s.CacheInvalidate)
+		}
+	})
 
-		r.Post("/insert", s.Insert)
-		r.Post("/delete", s.Delete)
-		r.Post("/upvote", s.Upvote)
-		r.Post("/associate", s.Associate)
+	r.Group(func(r chi.Router) {
+		r.Use(s.Permissions.ModelPermissionsCheck(WritePermission))
+
+		// The snapshot handlers lock the ndb themselves, since restoring a
+		// snapshot replaces it.
+		r.Get("/snapshots", s.ListSnapshots)
+		r.Post("/snapshots", s.CreateSnapshot)
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(s.Permissions.ModelPermissionsCheck(ReadPermission))
+		// Restoring or deleting a snapshot discards changes made by every user
+		// with write access, so only the owner can do it.
+		r.Use(s.Permissions.ModelPermissionsCheck(OwnerPermission))
+
+		r.Post("/snapshots/{name}/restore", s.RestoreSnapshot)
+		r.Delete("/snapshots/{name}", s.DeleteSnapshot)
+	})
 
-		r.Post("/query", s.Search)
-		r.Get("/sources", s.Sources)
+	r.Group(func(r chi.Router) {
+		r.Use(s.Permissions.ModelPermissionsCheck(ReadPermission))
+		r.Use(s.teamScope)
+
+		// Facets share the concurrency limit of queries since both search the ndb.
+		queryLimit := s.concurrencyLimit("query")
+		r.With(queryLimit, countQueryErrors, s.ndbAccess).Post("/query", s.Search)
+		r.With(queryLimit, s.ndbAccess).Post("/facets", s.Facets)
+		// Pages of a scroll are read from memory so they are not limited.
+		if s.Scrolls == nil {
+			s.Scrolls = NewScrollStore()
+		}
+		r.Post("/query/scroll", s.Scroll)
+		r.With(s.ndbAccess).Get("/sources", s.Sources)
 		// r.Post("/implicit-feedback", s.ImplicitFeedback)
 		// r.Get("/highlighted-pdf", s.HighlightedPdf)
 
 		if s.LLM != nil {
-			r.Post("/generate", s.GenerateFromReferences)
+			if s.Generations == nil {
+				s.Generations = NewGenerationStore()
+			}
+			r.With(s.concurrencyLimit("generate"), generateTimeouts).Post("/generate", s.GenerateFromReferences)
 		}
 
-		if s.LLMCache != nil {
+		if s.llmCache() != nil {
 			r.Post("/cache-suggestions", s.CacheSuggestions)
 		}
 	})
 
+	if s.Config != nil && s.Config.EnableProfiling {
+		r.Group(func(r chi.Router) {
+			r.Use(s.Permissions.ModelPermissionsCheck(AdminPermission))
+
+			r.Mount("/debug", middleware.Profiler())
+		})
+	}
+
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		utils.WriteSuccess(w)
 	})
@@ -166,23 +341,149 @@ func (s *NdbRouter) Routes() chi.Router {
 type ConstraintInput struct {
 	Op    string      `json:"op"`
 	Value interface{} `json:"value"`
+	// The constraints combined by the "and" and "or" operators.
+	Constraints []ConstraintInput `json:"constraints,omitempty"`
+}
+
+func checkConstraintValue(value interface{}) error {
+	switch value.(type) {
+	case bool, float64, string:
+		return nil
+	default:
+		return fmt.Errorf("value must be a bool, number, or string, found %v", value)
+	}
+}
+
+func parseConstraint(c ConstraintInput) (ndb.Constraint, error) {
+	switch c.Op {
+	case "and", "or":
+		if len(c.Constraints) == 0 {
+			return nil, fmt.Errorf("operator '%v' requires a list of constraints", c.Op)
+		}
+		constraints := make([]ndb.Constraint, 0, len(c.Constraints))
+		for _, nested := range c.Constraints {
+			constraint, err := parseConstraint(nested)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, constraint)
+		}
+		if c.Op == "and" {
+			return ndb.AllOf(constraints...), nil
+		}
+		return ndb.AnyOf(constraints...), nil
+
+	case "in":
+		values, ok := c.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("operator 'in' requires a list of values")
+		}
+		for _, value := range values {
+			if err := checkConstraintValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return ndb.In(values...), nil
+
+	case "contains", "prefix":
+		value, ok := c.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("operator '%v' requires a string value", c.Op)
+		}
+		if c.Op == "contains" {
+			return ndb.Contains(value), nil
+		}
+		return ndb.HasPrefix(value), nil
+	}
+
+	if err := checkConstraintValue(c.Value); err != nil {
+		return nil, err
+	}
+	switch c.Op {
+	case "eq":
+		return ndb.EqualTo(c.Value), nil
+	case "lt":
+		return ndb.LessThan(c.Value), nil
+	case "gt":
+		return ndb.GreaterThan(c.Value), nil
+	case "lte":
+		return ndb.LessThanOrEqualTo(c.Value), nil
+	case "gte":
+		return ndb.GreaterThanOrEqualTo(c.Value), nil
+	default:
+		return nil, fmt.Errorf("invalid constraint operator '%s'", c.Op)
+	}
+}
+
+func parseConstraints(inputs map[string]ConstraintInput) (ndb.Constraints, error) {
+	constraints := make(ndb.Constraints)
+	for key, c := range inputs {
+		constraint, err := parseConstraint(c)
+		if err != nil {
+			slog.Error("invalid constraint", "error", err, "key", key, "code", logging.MODEL_SEARCH)
+			return nil, fmt.Errorf("invalid constraint for key '%s': %w", key, err)
+		}
+		constraints[key] = constraint
+	}
+	return constraints, nil
 }
 
 type SearchRequest struct {
 	Query       string                     `json:"query"`
 	Topk        int                        `json:"top_k"`
 	Constraints map[string]ConstraintInput `json:"constraints,omitempty"`
+	// Spell correction is used if it is enabled for the deployment, unless this
+	// is set to false.
+	SpellCorrection *bool `json:"spell_correction,omitempty"`
+	// Collapse returns one result for each group of near duplicate chunks if it
+	// is set.
+	Collapse *CollapseOptions `json:"collapse,omitempty"`
+	// Rerank reorders the results by the similarity of their embeddings to the
+	// query, it requires rerank to be enabled for the deployment.
+	Rerank bool `json:"rerank,omitempty"`
+	// Scroll returns the results in pages of top_k results if it is set, the
+	// next pages are retrieved from /query/scroll with the returned cursor.
+	Scroll *ScrollOptions `json:"scroll,omitempty"`
 }
 
 type SearchResult struct {
-	Id     int     `json:"id"`
-	Text   string  `json:"text"`
-	Source string  `json:"source"`
-	Score  float32 `json:"score"`
+	Id       int     `json:"id"`
+	Text     string  `json:"text"`
+	Source   string  `json:"source"`
+	SourceId string  `json:"source_id"`
+	Score    float32 `json:"score"`
+	// Collapsed is the number of near duplicate chunks that were collapsed into
+	// this result.
+	Collapsed int `json:"collapsed,omitempty"`
+	// Enrichment are the fields of the source from the enrichment endpoint of
+	// the deployment, if it has one.
+	Enrichment map[string]interface{} `json:"enrichment,omitempty"`
 }
 
 type SearchResults struct {
 	References []SearchResult `json:"references"`
+	// CorrectedQuery is the query that was searched if the query was corrected
+	// automatically.
+	CorrectedQuery string `json:"corrected_query,omitempty"`
+	// DidYouMean are corrections of the query that were not searched.
+	DidYouMean []string `json:"did_you_mean,omitempty"`
+	// Cursor retrieves the next page of results of a scroll, it is empty on the
+	// last page.
+	Cursor string `json:"cursor,omitempty"`
+	// Total is the number of results that can be retrieved by a scroll.
+	Total int `json:"total,omitempty"`
+}
+
+// countQueryErrors counts the queries that fail with a server error, which is
+// used with the query latency to monitor the SLOs of the deployment.
+func countQueryErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if ww.Status() >= http.StatusInternalServerError {
+			queryErrorsMetric.Inc()
+		}
+	})
 }
 
 func (s *NdbRouter) Search(w http.ResponseWriter, r *http.Request) {
@@ -200,43 +501,174 @@ func (s *NdbRouter) Search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	constraints := make(ndb.Constraints)
-	for key, c := range req.Constraints {
-		switch c.Op {
-		case "eq":
-			constraints[key] = ndb.EqualTo(c.Value)
-		case "lt":
-			constraints[key] = ndb.LessThan(c.Value)
-		case "gt":
-			constraints[key] = ndb.GreaterThan(c.Value)
-		default:
-			slog.Error("invalid constraint operator", "operator", c.Op, "key", key, "code", logging.MODEL_SEARCH)
-			http.Error(w, fmt.Sprintf("invalid constraint operator '%s' for key '%s'", c.Op, key), http.StatusUnprocessableEntity)
+	if req.Rerank && (s.Embedder == nil || s.Config == nil || s.Config.Rerank == nil) {
+		http.Error(w, "rerank is not enabled for this deployment", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if req.Collapse != nil {
+		if err := req.Collapse.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid collapse options: %v", err), http.StatusUnprocessableEntity)
 			return
 		}
 	}
 
-	chunks, err := s.Ndb.Query(req.Query, req.Topk, constraints)
+	if req.Scroll != nil {
+		if err := req.Scroll.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("invalid scroll options: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	team := teamFromContext(r.Context())
+	req.Constraints = withTeamConstraint(team, req.Constraints)
+
+	constraints, err := parseConstraints(req.Constraints)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The results of a scroll are not cached since each scroll has its own cursor.
+	var cacheKey string
+	var cacheGeneration uint64
+	if s.QueryCache != nil && req.Scroll == nil {
+		key, err := queryCacheKey(req)
+		if err != nil {
+			slog.Error("error creating query cache key", "error", err, "code", logging.MODEL_SEARCH)
+		} else if cached, generation := s.QueryCache.Get(key); cached != nil {
+			utils.WriteJsonResponse(w, s.enrich(r, *cached))
+			s.mirrorQuery(r, req, *cached)
+			slog.Debug("searched ndb from cache", "query", req.Query, "top_k", req.Topk, "code", logging.MODEL_SEARCH)
+			return
+		} else {
+			cacheKey, cacheGeneration = key, generation
+		}
+	}
+
+	query := req.Query
+	var corrected string
+	var suggestions []string
+	if s.Spelling != nil && (req.SpellCorrection == nil || *req.SpellCorrection) {
+		corrected, suggestions = s.Spelling.Correct(req.Query)
+		if corrected != "" && s.Spelling.AutoCorrect() {
+			query = corrected
+			suggestions = suggestions[1:]
+		} else {
+			corrected = ""
+		}
+	}
+
+	// A scroll ranks all of the results that can be retrieved across its pages.
+	limit := req.Topk
+	if req.Scroll != nil {
+		limit = max(req.Topk, req.Scroll.maxResults())
+	}
+
+	topk := limit
+	if req.Collapse != nil {
+		topk = req.Collapse.searchTopk(limit)
+	}
+	if req.Rerank {
+		// Rerank the top candidates of the lexical search, so that results that
+		// are ranked lower by their words can move into the top k.
+		topk = max(topk, s.Config.Rerank.CandidateCount())
+	}
+
+	chunks, err := s.Ndb.Query(query, topk, constraints)
 	if err != nil {
 		slog.Error("ndb query error", "error", err, "code", logging.MODEL_SEARCH)
 		http.Error(w, "could not process query", http.StatusInternalServerError)
 		return
 	}
 
-	results := SearchResults{References: make([]SearchResult, len(chunks))}
+	if req.Rerank {
+		chunks = s.rerank(r.Context(), query, chunks)
+	}
+
+	var collapsed []int
+	if req.Collapse != nil {
+		chunks, collapsed = req.Collapse.Collapse(chunks, limit)
+	} else {
+		chunks = chunks[:min(len(chunks), limit)]
+	}
+
+	results := SearchResults{References: make([]SearchResult, len(chunks)), CorrectedQuery: corrected}
+	if len(suggestions) > 0 {
+		results.DidYouMean = suggestions
+	}
 	for i, chunk := range chunks {
 		results.References[i] = SearchResult{
-			Id:     int(chunk.Id),
-			Text:   chunk.Text,
-			Source: chunk.Document,
-			Score:  chunk.Score,
+			Id:       int(chunk.Id),
+			Text:     chunk.Text,
+			Source:   chunk.Document,
+			SourceId: userDocId(team, chunk.DocId),
+			Score:    chunk.Score,
 		}
+		if collapsed != nil {
+			results.References[i].Collapsed = collapsed[i]
+		}
+	}
+
+	if req.Scroll != nil {
+		results.Total = len(results.References)
+		results.References, results.Cursor = s.Scrolls.Start(results.References, req.Topk, team)
+	}
+
+	if s.Spelling != nil {
+		s.Spelling.AddResults(results.References)
+	}
+
+	if cacheKey != "" {
+		s.QueryCache.Put(cacheKey, results, cacheGeneration)
 	}
 
-	utils.WriteJsonResponse(w, &results)
+	utils.WriteJsonResponse(w, s.enrich(r, results))
+	s.mirrorQuery(r, req, results)
 	slog.Debug("searched ndb", "query", req.Query, "top_k", req.Topk, "code", logging.MODEL_SEARCH)
 }
 
+type ScrollRequest struct {
+	Cursor string `json:"cursor"`
+}
+
+// Scroll returns the next page of results of a query that was started with
+// scroll options.
+func (s *NdbRouter) Scroll(w http.ResponseWriter, r *http.Request) {
+	var req ScrollRequest
+	if !utils.ParseRequestBody(w, r, &req) {
+		return
+	}
+
+	page, next, total, err := s.Scrolls.Page(req.Cursor, teamFromContext(r.Context()))
+	if err != nil {
+		if errors.Is(err, ErrScrollNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utils.WriteJsonResponse(w, s.enrich(r, SearchResults{References: page, Cursor: next, Total: total}))
+	slog.Debug("scrolled ndb query", "cursor", req.Cursor, "code", logging.MODEL_SEARCH)
+}
+
+// enrich adds the enrichment fields to the results. The cached results are not
+// enriched, so that the fields are as recent as the enrichment cache allows.
+func (s *NdbRouter) enrich(r *http.Request, results SearchResults) *SearchResults {
+	if s.Enricher != nil {
+		results.References = s.Enricher.Enrich(r.Context(), teamFromContext(r.Context()), results.References)
+	}
+	return &results
+}
+
+func (s *NdbRouter) mirrorQuery(r *http.Request, req SearchRequest, results SearchResults) {
+	if s.Shadow != nil {
+		s.Shadow.Mirror(r.Header.Get("Authorization"), req, results)
+	}
+}
+
 type InsertRequest struct {
 	Document string                   `json:"document"`
 	DocId    string                   `json:"doc_id"`
@@ -245,8 +677,8 @@ type InsertRequest struct {
 	Version  *uint                    `json:"version,omitempty"`
 }
 
-// TODO how to do insert from files that already have been uploaded?
-// do we need go bindings for documents or to parse them with a service beforehand?
+// Insert inserts a document that is already chunked, see InsertFiles to insert
+// documents that need to be parsed.
 func (s *NdbRouter) Insert(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(insertMetric)
 	defer timer.ObserveDuration()
@@ -256,11 +688,20 @@ func (s *NdbRouter) Insert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.Ndb.Insert(req.Document, req.DocId, req.Chunks, req.Metadata, req.Version); err != nil {
+	team := teamFromContext(r.Context())
+	metadata := withTeamMetadata(team, req.Metadata, len(req.Chunks))
+
+	err := s.Ndb.Insert(req.Document, teamDocId(team, req.DocId), req.Chunks, metadata, req.Version)
+	s.invalidateQueryCache()
+	s.invalidateLLMCache([]string{req.DocId})
+	if err != nil {
 		slog.Error("insert error", "error", err, "code", logging.MODEL_INSERT)
 		http.Error(w, fmt.Sprintf("insert error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if s.Spelling != nil {
+		s.Spelling.AddChunks(req.Chunks)
+	}
 
 	utils.WriteSuccess(w)
 	slog.Info("inserted document", "doc_id", req.DocId, "code", logging.MODEL_INSERT)
@@ -282,8 +723,12 @@ func (s *NdbRouter) Delete(w http.ResponseWriter, r *http.Request) {
 
 	keepLatest := req.KeepLatestVersion
 
+	defer s.invalidateQueryCache()
+	defer s.invalidateLLMCache(req.DocIds)
+
+	team := teamFromContext(r.Context())
 	for _, docID := range req.DocIds {
-		if err := s.Ndb.Delete(docID, keepLatest); err != nil {
+		if err := s.Ndb.Delete(teamDocId(team, docID), keepLatest); err != nil {
 			slog.Error("delete error", "error", err, "doc_id", docID, "code", logging.MODEL_DELETE)
 			http.Error(w, fmt.Sprintf("delete error for doc '%s': %v", docID, err), http.StatusInternalServerError)
 			return
@@ -308,6 +753,12 @@ func (s *NdbRouter) Upvote(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(upvoteMetric)
 	defer timer.ObserveDuration()
 
+	// Finetuning changes the results of every team.
+	if s.teamIsolation() {
+		http.Error(w, "upvote is not supported for deployments with team isolation", http.StatusUnprocessableEntity)
+		return
+	}
+
 	var req UpvoteInput
 	if !utils.ParseRequestBody(w, r, &req) {
 		return
@@ -320,7 +771,9 @@ func (s *NdbRouter) Upvote(w http.ResponseWriter, r *http.Request) {
 		labels[i] = uint64(pair.ReferenceId)
 	}
 
-	if err := s.Ndb.Finetune(queries, labels); err != nil {
+	err := s.Ndb.Finetune(queries, labels)
+	s.invalidateQueryCache()
+	if err != nil {
 		slog.Error("upvote error", "error", err, "code", logging.MODEL_RLHF)
 		http.Error(w, fmt.Sprintf("upvote error: %v", err), http.StatusInternalServerError)
 		return
@@ -344,6 +797,12 @@ func (s *NdbRouter) Associate(w http.ResponseWriter, r *http.Request) {
 	timer := prometheus.NewTimer(associateMetric)
 	defer timer.ObserveDuration()
 
+	// Associations change the results of every team.
+	if s.teamIsolation() {
+		http.Error(w, "associate is not supported for deployments with team isolation", http.StatusUnprocessableEntity)
+		return
+	}
+
 	var req AssociateInput
 	if !utils.ParseRequestBody(w, r, &req) {
 		return
@@ -361,7 +820,9 @@ func (s *NdbRouter) Associate(w http.ResponseWriter, r *http.Request) {
 		targets[i] = pair.Target
 	}
 
-	if err := s.Ndb.Associate(sources, targets, strength); err != nil {
+	err := s.Ndb.Associate(sources, targets, strength)
+	s.invalidateQueryCache()
+	if err != nil {
 		slog.Error("associate error", "error", err, "code", logging.MODEL_RLHF)
 		http.Error(w, fmt.Sprintf("associate error: %v", err), http.StatusInternalServerError)
 		return
@@ -394,13 +855,17 @@ func (s *NdbRouter) Sources(w http.ResponseWriter, r *http.Request) {
 		return srcs[i].Document < srcs[j].Document
 	})
 
-	results := Sources{Sources: make([]Source, len(srcs))}
-	for i, doc := range srcs {
-		results.Sources[i] = Source{
+	team := teamFromContext(r.Context())
+	results := Sources{Sources: make([]Source, 0, len(srcs))}
+	for _, doc := range srcs {
+		if !isTeamDocId(team, doc.DocId) {
+			continue
+		}
+		results.Sources = append(results.Sources, Source{
 			Source:   doc.Document,
-			SourceID: doc.DocId,
+			SourceID: userDocId(team, doc.DocId),
 			Version:  doc.DocVersion,
-		}
+		})
 	}
 
 	utils.WriteJsonResponse(w, results)
@@ -426,7 +891,9 @@ func (s *NdbRouter) ImplicitFeedback(w http.ResponseWriter, r *http.Request) {
 	var queries = ([]string{req.QueryText})
 	var labels = []uint64{uint64(req.ReferenceId)}
 
-	if err := s.Ndb.Finetune(queries, labels); err != nil {
+	err := s.Ndb.Finetune(queries, labels)
+	s.invalidateQueryCache()
+	if err != nil {
 		slog.Error("implicit feedback error", "error", err, "code", logging.MODEL_RLHF)
 		http.Error(w, fmt.Sprintf("implicit feedback error: %v", err), http.StatusInternalServerError)
 		return
@@ -442,15 +909,45 @@ func (s *NdbRouter) ImplicitFeedback(w http.ResponseWriter, r *http.Request) {
 func (s *NdbRouter) HighlightedPdf(w http.ResponseWriter, r *http.Request) {
 }
 
+type generateRequest struct {
+	llm_generation.GenerateRequest
+
+	// The id of the last event received by the client, to resume a generation
+	// after the connection is lost. The Last-Event-ID header can be used instead.
+	ResumeFrom string `json:"resume_from"`
+}
+
 func (s *NdbRouter) GenerateFromReferences(w http.ResponseWriter, r *http.Request) {
-	slog.Info("generating from references")
-	var req llm_generation.GenerateRequest
-	if !utils.ParseRequestBody(w, r, &req) {
+	var params generateRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	req := params.GenerateRequest
+
+	resumeFrom := r.Header.Get("Last-Event-ID")
+	if resumeFrom == "" {
+		resumeFrom = params.ResumeFrom
+	}
+	if resumeFrom != "" {
+		genId, from, err := parseEventId(resumeFrom)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gen := s.Generations.get(genId)
+		if gen == nil {
+			http.Error(w, fmt.Sprintf("generation %v not found, it may have expired", genId), http.StatusNotFound)
+			return
+		}
+		slog.Info("resuming generation", "generation_id", genId, "from", from)
+		s.Generations.Stream(w, r, gen, from)
 		return
 	}
 
+	slog.Info("generating from references")
+
 	// query the cache first
-	if s.LLMCache != nil {
+	if s.llmCache() != nil {
 		cachedResult, err := s.FindCachedResult(req)
 		if err != nil {
 			slog.Error("cache error", "error", err)
@@ -479,27 +976,35 @@ func (s *NdbRouter) GenerateFromReferences(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	slog.Info("started generation", "query", req.Query)
-
-	llmRes, err := s.LLM.StreamResponse(req, w, r)
-	if err != nil {
-		// Any error has already been sent to the client, just return
-		return
-	}
-
-	slog.Info("completed generation", "query", req.Query, "llmRes", llmRes)
-
 	referenceIds := make([]uint64, len(req.References))
+	sourceIds := make([]string, 0, len(req.References))
 	for i, ref := range req.References {
 		referenceIds[i] = ref.Id
-	}
-
-	if s.LLMCache != nil {
-		err = s.LLMCache.Insert(req.Query, llmRes, referenceIds)
-		if err != nil {
-			slog.Error("failed cache insertion", "error", err)
+		if ref.SourceId != "" && !slices.Contains(sourceIds, ref.SourceId) {
+			sourceIds = append(sourceIds, ref.SourceId)
 		}
 	}
+
+	// The generation runs independently of this request so that the client can
+	// resume it if the connection is lost.
+	gen := s.Generations.Start(
+		func(ctx context.Context, onChunk func(string)) (string, error) {
+			return s.LLM.StreamResponse(ctx, req, onChunk)
+		},
+		func(llmRes string) {
+			slog.Info("completed generation", "query", req.Query, "llmRes", llmRes)
+
+			if s.llmCache() != nil {
+				if err := s.llmCache().Insert(req.Query, llmRes, referenceIds, sourceIds); err != nil {
+					slog.Error("failed cache insertion", "error", err)
+				}
+			}
+		},
+	)
+
+	slog.Info("started generation", "query", req.Query, "generation_id", gen.id)
+
+	s.Generations.Stream(w, r, gen, 0)
 }
 
 type CacheSuggestionsQuery struct {
@@ -513,12 +1018,12 @@ func (s *NdbRouter) CacheSuggestions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.LLMCache == nil {
+	if s.llmCache() == nil {
 		http.Error(w, "LLM cache is not initialized", http.StatusInternalServerError)
 		return
 	}
 
-	suggestions, err := s.LLMCache.Suggestions(req.Query)
+	suggestions, err := s.llmCache().Suggestions(req.Query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("cache suggestions error: %v", err), http.StatusInternalServerError)
 		return
@@ -531,8 +1036,39 @@ func (s *NdbRouter) CacheSuggestions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type CacheInvalidateRequest struct {
+	SourceIds    []string `json:"source_ids"`
+	ReferenceIds []uint64 `json:"reference_ids"`
+}
+
+type CacheInvalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// CacheInvalidate deletes the cached llm responses that used any of the given
+// documents or references.
+func (s *NdbRouter) CacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	var req CacheInvalidateRequest
+	if !utils.ParseRequestBody(w, r, &req) {
+		return
+	}
+
+	if len(req.SourceIds) == 0 && len(req.ReferenceIds) == 0 {
+		http.Error(w, "source_ids or reference_ids must be specified", http.StatusUnprocessableEntity)
+		return
+	}
+
+	invalidated, err := s.llmCache().Invalidate(req.SourceIds, req.ReferenceIds)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cache invalidation error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, CacheInvalidateResponse{Invalidated: invalidated})
+}
+
 func (s *NdbRouter) FindCachedResult(generateRequest llm_generation.GenerateRequest) (string, error) {
-	if s.LLMCache == nil {
+	if s.llmCache() == nil {
 		return "", fmt.Errorf("LLM cache is not initialized")
 	}
 
@@ -541,7 +1077,7 @@ func (s *NdbRouter) FindCachedResult(generateRequest llm_generation.GenerateRequ
 		referenceIds[i] = ref.Id
 	}
 
-	result, err := s.LLMCache.Query(generateRequest.Query, referenceIds)
+	result, err := s.llmCache().Query(generateRequest.Query, referenceIds)
 	if err != nil {
 		return "", fmt.Errorf("cache query error: %v", err)
 	}
@@ -0,0 +1,172 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var scrollsMetric = promauto.NewGauge(prometheus.GaugeOpts{Name: "ndb_scrolls", Help: "Number of open NDB query scrolls"})
+
+const (
+	defaultScrollMaxResults = 1000
+	maxScrollMaxResults     = 10000
+	defaultScrollRetention  = 5 * time.Minute
+	defaultMaxScrolls       = 100
+)
+
+var ErrScrollNotFound = errors.New("scroll not found, it may have expired")
+
+// ScrollOptions returns the results of a query in pages of top_k results. The
+// results are ranked once when the scroll starts, so the pages are consistent
+// with each other even if the ndb is modified while the pages are retrieved.
+type ScrollOptions struct {
+	// MaxResults is the number of results that are ranked for the query and
+	// can be retrieved across all pages.
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+func (o *ScrollOptions) validate() error {
+	if o.MaxResults < 0 || o.MaxResults > maxScrollMaxResults {
+		return fmt.Errorf("max_results must be between 0 and %d", maxScrollMaxResults)
+	}
+	return nil
+}
+
+func (o *ScrollOptions) maxResults() int {
+	if o.MaxResults == 0 {
+		return defaultScrollMaxResults
+	}
+	return o.MaxResults
+}
+
+type scroll struct {
+	results    []SearchResult
+	pageSize   int
+	team       string
+	lastAccess time.Time
+}
+
+// ScrollStore keeps the ranked results of queries that are retrieved in pages.
+type ScrollStore struct {
+	// Scrolls are removed once they have not been accessed for this long.
+	Retention time.Duration
+	// The least recently accessed scroll is removed when a scroll is started
+	// and there are already this many scrolls.
+	MaxScrolls int
+
+	mu      sync.Mutex
+	scrolls map[string]*scroll
+}
+
+func NewScrollStore() *ScrollStore {
+	return &ScrollStore{
+		Retention:  defaultScrollRetention,
+		MaxScrolls: defaultMaxScrolls,
+		scrolls:    make(map[string]*scroll),
+	}
+}
+
+// Start stores the results of a query, and returns the first page of results
+// and the cursor of the next page. The cursor is empty if there is only one
+// page of results. With team isolation the next pages can only be retrieved by
+// the team that started the scroll.
+func (s *ScrollStore) Start(results []SearchResult, pageSize int, team string) ([]SearchResult, string) {
+	if len(results) <= pageSize {
+		return results, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.removeExpired(now)
+	for len(s.scrolls) > 0 && len(s.scrolls) >= s.MaxScrolls {
+		s.removeOldest()
+	}
+
+	id := uuid.NewString()
+	s.scrolls[id] = &scroll{results: results, pageSize: pageSize, team: team, lastAccess: now}
+	scrollsMetric.Set(float64(len(s.scrolls)))
+
+	return results[:pageSize], scrollCursor(id, pageSize)
+}
+
+// Page returns the page of results at the cursor, the cursor of the next page,
+// and the total number of results of the scroll. The cursor of the next page is
+// empty if this is the last page. Retrieving the same cursor again returns the
+// same page, so a page can be retried if the response is lost.
+func (s *ScrollStore) Page(cursor, team string) ([]SearchResult, string, int, error) {
+	id, offset, err := parseScrollCursor(cursor)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.removeExpired(now)
+
+	scroll, ok := s.scrolls[id]
+	if !ok || scroll.team != team {
+		return nil, "", 0, ErrScrollNotFound
+	}
+	scroll.lastAccess = now
+
+	if offset > len(scroll.results) {
+		return nil, "", 0, fmt.Errorf("invalid cursor '%v'", cursor)
+	}
+
+	end := min(offset+scroll.pageSize, len(scroll.results))
+	next := ""
+	if end < len(scroll.results) {
+		next = scrollCursor(id, end)
+	}
+
+	return scroll.results[offset:end], next, len(scroll.results), nil
+}
+
+func (s *ScrollStore) removeExpired(now time.Time) {
+	for id, scroll := range s.scrolls {
+		if now.Sub(scroll.lastAccess) > s.Retention {
+			delete(s.scrolls, id)
+		}
+	}
+	scrollsMetric.Set(float64(len(s.scrolls)))
+}
+
+func (s *ScrollStore) removeOldest() {
+	oldest := ""
+	for id, scroll := range s.scrolls {
+		if oldest == "" || scroll.lastAccess.Before(s.scrolls[oldest].lastAccess) {
+			oldest = id
+		}
+	}
+	delete(s.scrolls, oldest)
+}
+
+func scrollCursor(id string, offset int) string {
+	return fmt.Sprintf("%s:%d", id, offset)
+}
+
+// parseScrollCursor returns the scroll id and the offset of the page in the
+// results of the scroll.
+func parseScrollCursor(cursor string) (string, int, error) {
+	id, offset, found := strings.Cut(cursor, ":")
+	if !found {
+		return "", 0, fmt.Errorf("invalid cursor '%v'", cursor)
+	}
+	i, err := strconv.Atoi(offset)
+	if err != nil || i < 0 {
+		return "", 0, fmt.Errorf("invalid cursor '%v'", cursor)
+	}
+	return id, i, nil
+}
@@ -0,0 +1,143 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"thirdai_platform/client"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/utils/logging"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	shadowRequestsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ndb_shadow_requests_total", Help: "Queries mirrored to the shadow deployment, by outcome",
+	}, []string{"outcome"})
+	shadowLatencyMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "ndb_shadow_latency_seconds", Help: "Latency of queries to the shadow deployment",
+	})
+	shadowOverlapMetric = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "ndb_shadow_overlap", Help: "Fraction of the results of a query that are also returned by the shadow deployment",
+		Buckets: prometheus.LinearBuckets(0, 0.1, 11),
+	})
+	shadowTop1MatchesMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ndb_shadow_top1_matches_total", Help: "Shadow queries where the shadow deployment returned the same top result",
+	})
+)
+
+const (
+	shadowSucceeded = "success"
+	shadowFailed    = "error"
+	// The query was sampled, but there were too many shadow queries in flight.
+	shadowDropped = "dropped"
+
+	shadowTimeout = 30 * time.Second
+)
+
+// Shadow mirrors queries to the deployment of another model and records how
+// much its results diverge from the results of this deployment.
+type Shadow struct {
+	endpoint string
+	opts     config.ShadowOptions
+	slots    chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewShadow(endpoint string, opts config.ShadowOptions) *Shadow {
+	return &Shadow{endpoint: endpoint, opts: opts, slots: make(chan struct{}, opts.InFlightLimit())}
+}
+
+// Mirror sends the query to the shadow deployment in the background if it is
+// sampled, and compares the response with results once it is received. The
+// authorization header of the original request is used so that the query is
+// only mirrored for users who can read the shadow model.
+func (s *Shadow) Mirror(authorization string, req SearchRequest, results SearchResults) {
+	if rand.Float64()*100 >= s.opts.Percentage {
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		shadowRequestsMetric.WithLabelValues(shadowDropped).Inc()
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.slots }()
+
+		shadowResults, err := s.query(authorization, req)
+		if err != nil {
+			shadowRequestsMetric.WithLabelValues(shadowFailed).Inc()
+			slog.Warn("shadow query failed", "shadow_model_id", s.opts.ModelId, "error", err, "code", logging.MODEL_SEARCH)
+			return
+		}
+		shadowRequestsMetric.WithLabelValues(shadowSucceeded).Inc()
+
+		overlap, top1Match := compareResults(results.References, shadowResults.References)
+		shadowOverlapMetric.Observe(overlap)
+		if top1Match {
+			shadowTop1MatchesMetric.Inc()
+		}
+		slog.Debug("shadow query completed", "query", req.Query, "overlap", overlap, "top1_match", top1Match, "code", logging.MODEL_SEARCH)
+	}()
+}
+
+func (s *Shadow) query(authorization string, req SearchRequest) (SearchResults, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+
+	timer := prometheus.NewTimer(shadowLatencyMetric)
+	defer timer.ObserveDuration()
+
+	base := client.NewBaseClient(s.endpoint, "")
+
+	var res SearchResults
+	err := base.Post(fmt.Sprintf("/%v/query", s.opts.ModelId)).Header("Authorization", authorization).Json(req).NoRetry().DoContext(ctx, &res)
+	return res, err
+}
+
+// Wait waits for the shadow queries in flight to complete.
+func (s *Shadow) Wait() {
+	s.wg.Wait()
+}
+
+// compareResults returns the fraction of the primary results that are in the
+// shadow results, and whether the top results are the same. Results are
+// compared by their source and text rather than their ids, since the chunk ids
+// of different models are not related.
+func compareResults(primary, shadow []SearchResult) (float64, bool) {
+	if len(primary) == 0 {
+		if len(shadow) == 0 {
+			return 1, true
+		}
+		return 0, false
+	}
+
+	key := func(r SearchResult) string {
+		return r.Source + "\x00" + r.Text
+	}
+
+	inShadow := make(map[string]bool, len(shadow))
+	for _, result := range shadow {
+		inShadow[key(result)] = true
+	}
+
+	found := 0
+	for _, result := range primary {
+		if inShadow[key(result)] {
+			found++
+		}
+	}
+
+	top1Match := len(shadow) > 0 && key(primary[0]) == key(shadow[0])
+	return float64(found) / float64(len(primary)), top1Match
+}
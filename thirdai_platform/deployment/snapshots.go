@@ -0,0 +1,407 @@
+package deployment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"thirdai_platform/search/ndb"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/logging"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var snapshotNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// The metadata file is written after the ndb is saved, so snapshots that were
+// not completely saved are not listed or restored.
+const snapshotMetadataFile = "snapshot.json"
+
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+type SnapshotList struct {
+	Snapshots []SnapshotInfo `json:"snapshots"`
+}
+
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// Snapshots are stored next to the model so that they are kept if the model is
+// redeployed.
+func (s *NdbRouter) snapshotsDir() string {
+	return filepath.Join(s.Config.ModelBazaarDir, "models", s.Config.ModelId.String(), "snapshots")
+}
+
+func (s *NdbRouter) snapshotDir(name string) string {
+	return filepath.Join(s.snapshotsDir(), name)
+}
+
+// ndbAccess must wrap every handler that uses the ndb, so that the ndb is not
+// replaced while the handler is using it.
+func (s *NdbRouter) ndbAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ndbLock.RLock()
+		defer s.ndbLock.RUnlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readSnapshotInfo(dir string) (SnapshotInfo, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotMetadataFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return SnapshotInfo{}, ErrSnapshotNotFound
+		}
+		return SnapshotInfo{}, fmt.Errorf("error reading snapshot metadata: %w", err)
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("error parsing snapshot metadata: %w", err)
+	}
+	return info, nil
+}
+
+func (s *NdbRouter) checkSnapshots(w http.ResponseWriter) bool {
+	// Restoring a snapshot changes the documents of every team.
+	if s.teamIsolation() {
+		http.Error(w, "snapshots are not supported for deployments with team isolation", http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// snapshotName returns the name from the url, names are validated since they
+// are used as directory names.
+func snapshotName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "name")
+	if !snapshotNameRe.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid snapshot name '%v'", name), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// CreateSnapshot saves the current state of the ndb so that the deployment can
+// be rolled back to it later.
+func (s *NdbRouter) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSnapshots(w) {
+		return
+	}
+
+	var req CreateSnapshotRequest
+	if !utils.ParseRequestBody(w, r, &req) {
+		return
+	}
+
+	if !snapshotNameRe.MatchString(req.Name) {
+		http.Error(w, "snapshot names must be at most 100 letters, digits, '_', '.', or '-', and start with a letter or digit", http.StatusUnprocessableEntity)
+		return
+	}
+
+	info := SnapshotInfo{Name: req.Name, CreatedAt: time.Now().UTC()}
+	if permissions, ok := ModelPermissionsFromContext(r.Context()); ok {
+		info.CreatedBy = permissions.Username
+	}
+
+	// Changes are blocked while the ndb is saved so that the snapshot does not
+	// contain part of an insert or delete.
+	s.ndbLock.Lock()
+	defer s.ndbLock.Unlock()
+
+	dir := s.snapshotDir(req.Name)
+	if _, err := os.Stat(dir); err == nil {
+		http.Error(w, fmt.Sprintf("snapshot '%v' already exists", req.Name), http.StatusConflict)
+		return
+	}
+
+	if err := s.saveSnapshot(dir, info); err != nil {
+		if cleanupErr := os.RemoveAll(dir); cleanupErr != nil {
+			slog.Error("error removing incomplete snapshot", "snapshot", req.Name, "error", cleanupErr, "code", logging.MODEL_SAVE)
+		}
+		slog.Error("error creating snapshot", "snapshot", req.Name, "error", err, "code", logging.MODEL_SAVE)
+		http.Error(w, fmt.Sprintf("error creating snapshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, info)
+	slog.Info("created ndb snapshot", "snapshot", req.Name, "code", logging.MODEL_SAVE)
+}
+
+func (s *NdbRouter) saveSnapshot(dir string, info SnapshotInfo) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("error creating snapshot directory: %w", err)
+	}
+
+	// Saving the ndb creates a checkpoint of its storage, which links the data
+	// files when possible rather than copying them.
+	if err := s.Ndb.Save(filepath.Join(dir, "model.ndb")); err != nil {
+		return fmt.Errorf("error saving ndb: %w", err)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("error serializing snapshot metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotMetadataFile), data, 0666); err != nil {
+		return fmt.Errorf("error writing snapshot metadata: %w", err)
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots of the ndb, oldest first.
+func (s *NdbRouter) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSnapshots(w) {
+		return
+	}
+
+	s.ndbLock.RLock()
+	defer s.ndbLock.RUnlock()
+
+	entries, err := os.ReadDir(s.snapshotsDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("error listing snapshots", "error", err, "code", logging.MODEL_INFO)
+		http.Error(w, fmt.Sprintf("error listing snapshots: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	snapshots := make([]SnapshotInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := readSnapshotInfo(s.snapshotDir(entry.Name()))
+		if err != nil {
+			if !errors.Is(err, ErrSnapshotNotFound) {
+				slog.Error("error reading snapshot", "snapshot", entry.Name(), "error", err, "code", logging.MODEL_INFO)
+			}
+			continue
+		}
+		snapshots = append(snapshots, info)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+
+	utils.WriteJsonResponse(w, SnapshotList{Snapshots: snapshots})
+}
+
+// RestoreSnapshot replaces the ndb with the snapshot. The snapshot is kept so
+// that it can be restored again.
+func (s *NdbRouter) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSnapshots(w) {
+		return
+	}
+
+	// Other replicas would keep serving, and writing to, the ndb they loaded
+	// while it is replaced. Autoscaled deployments can add replicas at any time,
+	// so only deployments without autoscaling, which have a single replica, can
+	// be restored.
+	if s.Config != nil && s.Config.Autoscaling {
+		http.Error(w, "snapshots cannot be restored for deployments with autoscaling, redeploy the model without autoscaling to restore a snapshot", http.StatusUnprocessableEntity)
+		return
+	}
+
+	name, ok := snapshotName(w, r)
+	if !ok {
+		return
+	}
+
+	s.ndbLock.Lock()
+	defer s.ndbLock.Unlock()
+
+	dir := s.snapshotDir(name)
+	info, err := readSnapshotInfo(dir)
+	if err != nil {
+		if errors.Is(err, ErrSnapshotNotFound) {
+			http.Error(w, fmt.Sprintf("snapshot '%v' not found", name), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The documents in either version of the ndb may have changed, so llm
+	// responses that used any of them are invalidated.
+	var changedDocs []string
+	if s.llmCache() != nil {
+		changedDocs = s.sourceIds()
+	}
+
+	if err := s.restoreSnapshot(filepath.Join(dir, "model.ndb")); err != nil {
+		slog.Error("error restoring snapshot", "snapshot", name, "error", err, "code", logging.MODEL_LOAD)
+		http.Error(w, fmt.Sprintf("error restoring snapshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.invalidateQueryCache()
+	if s.llmCache() != nil {
+		s.invalidateLLMCache(append(changedDocs, s.sourceIds()...))
+	}
+
+	utils.WriteJsonResponse(w, info)
+	slog.Info("restored ndb snapshot", "snapshot", name, "created_at", info.CreatedAt, "code", logging.MODEL_LOAD)
+}
+
+func (s *NdbRouter) sourceIds() []string {
+	sources, err := s.Ndb.Sources()
+	if err != nil {
+		slog.Error("error listing sources", "error", err)
+		return nil
+	}
+	ids := make([]string, len(sources))
+	for i, source := range sources {
+		ids[i] = source.DocId
+	}
+	return ids
+}
+
+// openNdbAlias opens the ndb at the path through a new symlink to it. The ndb
+// library does not release the lock of an ndb when it is freed, so an ndb cannot
+// be opened again with the same path in this process. Returns the directory of
+// the symlink, which must be kept until the ndb is freed.
+func openNdbAlias(path string) (ndb.NeuralDB, string, error) {
+	target, err := filepath.Abs(path)
+	if err != nil {
+		return ndb.NeuralDB{}, "", err
+	}
+
+	aliasDir, err := os.MkdirTemp("", "ndb-")
+	if err != nil {
+		return ndb.NeuralDB{}, "", fmt.Errorf("error creating ndb alias: %w", err)
+	}
+	alias := filepath.Join(aliasDir, "model.ndb")
+	if err := os.Symlink(target, alias); err != nil {
+		return ndb.NeuralDB{}, "", errors.Join(fmt.Errorf("error creating ndb alias: %w", err), os.RemoveAll(aliasDir))
+	}
+
+	db, err := ndb.New(alias)
+	if err != nil {
+		return ndb.NeuralDB{}, "", errors.Join(err, os.RemoveAll(aliasDir))
+	}
+	return db, aliasDir, nil
+}
+
+// restoreSnapshot copies the snapshot to the path of the ndb and reopens it.
+// The snapshot is copied rather than opened directly so that changes after the
+// restore do not modify the snapshot, and the ndb is reloaded from the restored
+// state if the deployment restarts. The caller must hold the ndb lock.
+func (s *NdbRouter) restoreSnapshot(snapshotPath string) error {
+	current := ndbPath(s.Config)
+	restored := current + ".restore"
+	previous := current + ".previous"
+
+	for _, path := range []string{restored, previous} {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error removing '%v': %w", path, err)
+		}
+	}
+
+	// A snapshot can be restored several times, so it is also opened through
+	// an alias.
+	snapshot, snapshotAlias, err := openNdbAlias(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("error opening snapshot: %w", err)
+	}
+	err = snapshot.Save(restored)
+	snapshot.Free()
+	if removeErr := os.RemoveAll(snapshotAlias); removeErr != nil {
+		slog.Warn("error removing snapshot alias", "path", snapshotAlias, "error", removeErr, "code", logging.MODEL_LOAD)
+	}
+	if err != nil {
+		return fmt.Errorf("error copying snapshot: %w", err)
+	}
+
+	s.Ndb.Free()
+	previousAlias := s.ndbAlias
+
+	reopen := func() error {
+		db, aliasDir, err := openNdbAlias(current)
+		if err != nil {
+			return err
+		}
+		s.Ndb, s.ndbAlias = db, aliasDir
+		return nil
+	}
+
+	// The current ndb is moved aside until the restored ndb is opened, so that
+	// it can be reopened if the restore fails.
+	if err := os.Rename(current, previous); err != nil {
+		return errors.Join(fmt.Errorf("error moving current ndb: %w", err), reopen())
+	}
+	if err := os.Rename(restored, current); err != nil {
+		err = fmt.Errorf("error moving restored ndb: %w", err)
+		if renameErr := os.Rename(previous, current); renameErr != nil {
+			return errors.Join(err, renameErr)
+		}
+		return errors.Join(err, reopen())
+	}
+	if err := reopen(); err != nil {
+		err = fmt.Errorf("error opening restored ndb: %w", err)
+		if removeErr := os.RemoveAll(current); removeErr != nil {
+			return errors.Join(err, removeErr)
+		}
+		if renameErr := os.Rename(previous, current); renameErr != nil {
+			return errors.Join(err, renameErr)
+		}
+		return errors.Join(err, reopen())
+	}
+
+	for _, path := range []string{previous, previousAlias} {
+		if path == "" {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			slog.Warn("error cleaning up after restore", "path", path, "error", err, "code", logging.MODEL_LOAD)
+		}
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the snapshot, this does not change the ndb.
+func (s *NdbRouter) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSnapshots(w) {
+		return
+	}
+
+	name, ok := snapshotName(w, r)
+	if !ok {
+		return
+	}
+
+	s.ndbLock.Lock()
+	defer s.ndbLock.Unlock()
+
+	dir := s.snapshotDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, fmt.Sprintf("snapshot '%v' not found", name), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error deleting snapshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Error("error deleting snapshot", "snapshot", name, "error", err, "code", logging.MODEL_DELETE)
+		http.Error(w, fmt.Sprintf("error deleting snapshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteSuccess(w)
+	slog.Info("deleted ndb snapshot", "snapshot", name, "code", logging.MODEL_DELETE)
+}
@@ -0,0 +1,388 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/config"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Words that appear at most rareWordCount times are treated as possible
+	// misspellings with high aggressiveness, if a candidate is at least
+	// rareWordRatio times as common.
+	rareWordCount = 2
+	rareWordRatio = 20
+)
+
+type spellingVocabulary struct {
+	Words    map[string]int `json:"words"`
+	ChunkIds []uint64       `json:"chunk_ids"`
+}
+
+// SpellChecker corrects the words of queries to words in the vocabulary of the
+// deployment's documents. The ndb cannot list its chunks, so the vocabulary is
+// built from the chunks that are inserted through the deployment and the chunks
+// that are returned by queries, and is saved with the model so that it persists
+// across restarts. Chunks returned by queries are only counted once.
+type SpellChecker struct {
+	mu       sync.RWMutex
+	opts     config.SpellCorrectionOptions
+	path     string
+	words    map[string]int
+	byLength map[int][]string
+	chunks   map[uint64]struct{}
+}
+
+func NewSpellChecker(modelBazaarDir, modelId string, opts config.SpellCorrectionOptions) *SpellChecker {
+	checker := &SpellChecker{
+		opts:     opts,
+		path:     filepath.Join(modelBazaarDir, "models", modelId, "spelling", "vocabulary.json"),
+		words:    make(map[string]int),
+		byLength: make(map[int][]string),
+		chunks:   make(map[uint64]struct{}),
+	}
+
+	if err := checker.load(); err != nil {
+		slog.Warn("error loading spelling vocabulary, starting with an empty vocabulary", "error", err)
+	}
+
+	return checker
+}
+
+func (c *SpellChecker) load() error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading spelling vocabulary: %w", err)
+	}
+
+	var vocab spellingVocabulary
+	if err := json.Unmarshal(data, &vocab); err != nil {
+		return fmt.Errorf("error parsing spelling vocabulary: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for word, count := range vocab.Words {
+		c.addWord(word, count)
+	}
+	for _, id := range vocab.ChunkIds {
+		c.chunks[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// Save writes the vocabulary to the model directory. The file is replaced
+// atomically since replicas of the deployment share it.
+func (c *SpellChecker) Save() error {
+	c.mu.RLock()
+	vocab := spellingVocabulary{Words: c.words, ChunkIds: make([]uint64, 0, len(c.chunks))}
+	for id := range c.chunks {
+		vocab.ChunkIds = append(vocab.ChunkIds, id)
+	}
+	data, err := json.Marshal(vocab)
+	c.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("error serializing spelling vocabulary: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0777); err != nil {
+		return fmt.Errorf("error creating spelling vocabulary directory: %w", err)
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return fmt.Errorf("error writing spelling vocabulary: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("error writing spelling vocabulary: %w", err)
+	}
+
+	return nil
+}
+
+// AutoCorrect returns if queries should be searched with their correction
+// instead of only suggesting it.
+func (c *SpellChecker) AutoCorrect() bool {
+	return c.opts.AutoCorrect
+}
+
+type wordToken struct {
+	start, end int
+	word       string
+}
+
+// tokenize returns the words of the text, which are runs of letters. Words that
+// contain digits are skipped, since they are usually identifiers.
+func tokenize(text string) []wordToken {
+	tokens := []wordToken{}
+	start := -1
+	hasDigit := false
+	for i, r := range text + " " {
+		switch {
+		case unicode.IsLetter(r):
+			if start < 0 {
+				start = i
+			}
+		case unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+			hasDigit = true
+		default:
+			if start >= 0 && !hasDigit {
+				tokens = append(tokens, wordToken{start: start, end: i, word: strings.ToLower(text[start:i])})
+			}
+			start = -1
+			hasDigit = false
+		}
+	}
+	return tokens
+}
+
+func (c *SpellChecker) addWord(word string, count int) {
+	if _, ok := c.words[word]; !ok {
+		length := utf8.RuneCountInString(word)
+		c.byLength[length] = append(c.byLength[length], word)
+	}
+	c.words[word] += count
+}
+
+func (c *SpellChecker) addText(text string) {
+	for _, token := range tokenize(text) {
+		if utf8.RuneCountInString(token.word) >= 2 {
+			c.addWord(token.word, 1)
+		}
+	}
+}
+
+// AddChunks adds the words of inserted chunks to the vocabulary.
+func (c *SpellChecker) AddChunks(chunks []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, chunk := range chunks {
+		c.addText(chunk)
+	}
+}
+
+// AddResults adds the words of the chunks returned by a query to the
+// vocabulary, if they have not been added before.
+func (c *SpellChecker) AddResults(results []SearchResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, result := range results {
+		if _, ok := c.chunks[uint64(result.Id)]; ok {
+			continue
+		}
+		c.chunks[uint64(result.Id)] = struct{}{}
+		c.addText(result.Text)
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// which counts transpositions of adjacent characters as one edit. It returns
+// limit+1 as soon as the distance is known to be greater than limit.
+func editDistance(a, b []rune, limit int) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(b)]
+}
+
+// maxEdits returns the number of edits allowed to correct a word of the given
+// length, or 0 if the word should not be corrected.
+func (c *SpellChecker) maxEdits(length int) int {
+	switch c.opts.Aggressiveness {
+	case config.SpellCorrectionLow:
+		if length < 4 {
+			return 0
+		}
+		return 1
+	case config.SpellCorrectionHigh:
+		if length < 3 {
+			return 0
+		}
+		if length >= 5 {
+			return 2
+		}
+		return 1
+	default:
+		if length < 3 {
+			return 0
+		}
+		if length >= 7 {
+			return 2
+		}
+		return 1
+	}
+}
+
+type spellingCandidate struct {
+	word     string
+	distance int
+	count    int
+}
+
+// candidates returns the corrections for the word, best first, or nil if the
+// word should not be corrected.
+func (c *SpellChecker) candidates(word string, limit int) []spellingCandidate {
+	runes := []rune(word)
+	edits := c.maxEdits(len(runes))
+	if edits == 0 {
+		return nil
+	}
+
+	count, known := c.words[word]
+	minCount := 1
+	if known {
+		if c.opts.Aggressiveness != config.SpellCorrectionHigh || count > rareWordCount {
+			return nil
+		}
+		minCount = count * rareWordRatio
+	}
+
+	candidates := []spellingCandidate{}
+	for length := len(runes) - edits; length <= len(runes)+edits; length++ {
+		for _, other := range c.byLength[length] {
+			if other == word || c.words[other] < minCount {
+				continue
+			}
+			if distance := editDistance(runes, []rune(other), edits); distance <= edits {
+				candidates = append(candidates, spellingCandidate{word: other, distance: distance, count: c.words[other]})
+			}
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b spellingCandidate) int {
+		if a.distance != b.distance {
+			return a.distance - b.distance
+		}
+		if a.count != b.count {
+			return b.count - a.count
+		}
+		return strings.Compare(a.word, b.word)
+	})
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// matchCase returns the correction with the same capitalization as the
+// original word, if it was capitalized or all upper case.
+func matchCase(original, correction string) string {
+	first, _ := utf8.DecodeRuneInString(original)
+	if !unicode.IsUpper(first) {
+		return correction
+	}
+	if strings.ToUpper(original) == original && utf8.RuneCountInString(original) > 1 {
+		return strings.ToUpper(correction)
+	}
+	r, size := utf8.DecodeRuneInString(correction)
+	return string(unicode.ToUpper(r)) + correction[size:]
+}
+
+// Correct returns the corrected query and up to MaxSuggestions alternative
+// queries, the first of which is the corrected query. The corrected query is
+// empty if no words of the query were corrected.
+func (c *SpellChecker) Correct(query string) (string, []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	limit := c.opts.Suggestions()
+
+	tokens := tokenize(query)
+	corrections := make([][]spellingCandidate, len(tokens))
+	corrected := false
+	for i, token := range tokens {
+		corrections[i] = c.candidates(token.word, limit)
+		if len(corrections[i]) > 0 {
+			corrected = true
+		}
+	}
+	if !corrected {
+		return "", nil
+	}
+
+	// choice is the index of the candidate used for each token, or -1 to keep
+	// the original word.
+	build := func(choice []int) string {
+		var out strings.Builder
+		last := 0
+		for i, token := range tokens {
+			if choice[i] < 0 {
+				continue
+			}
+			out.WriteString(query[last:token.start])
+			out.WriteString(matchCase(query[token.start:token.end], corrections[i][choice[i]].word))
+			last = token.end
+		}
+		out.WriteString(query[last:])
+		return out.String()
+	}
+
+	best := make([]int, len(tokens))
+	for i := range tokens {
+		best[i] = -1
+		if len(corrections[i]) > 0 {
+			best[i] = 0
+		}
+	}
+
+	suggestions := []string{build(best)}
+	// The alternatives replace one word of the best correction with its next
+	// candidate, so that each alternative is close to the best correction.
+	for rank := 1; rank < limit && len(suggestions) < limit; rank++ {
+		for i := range tokens {
+			if rank >= len(corrections[i]) || len(suggestions) >= limit {
+				continue
+			}
+			choice := slices.Clone(best)
+			choice[i] = rank
+			if suggestion := build(choice); !slices.Contains(suggestions, suggestion) {
+				suggestions = append(suggestions, suggestion)
+			}
+		}
+	}
+
+	return suggestions[0], suggestions
+}
@@ -0,0 +1,149 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// TeamMetadataKey is the metadata key that documents are tagged with in
+	// deployments with team isolation.
+	TeamMetadataKey = "_team_id"
+
+	// TeamIdHeader selects the team of the caller in deployments with team
+	// isolation, it is only required if the caller is a member of several teams.
+	TeamIdHeader = "X-Team-Id"
+)
+
+type teamContextKey struct{}
+
+func (s *NdbRouter) teamIsolation() bool {
+	return s.Config != nil && s.Config.TeamIsolation
+}
+
+// llmCache returns the llm cache of the deployment. It is nil with team
+// isolation, since cached answers and suggestions are shared by all teams and
+// could reveal the documents of other teams.
+func (s *NdbRouter) llmCache() *LLMCache {
+	if s.teamIsolation() {
+		return nil
+	}
+	return s.LLMCache
+}
+
+// callerTeam returns the team whose documents the caller can access. Admins can
+// select any team, other users can only select one of their teams.
+func callerTeam(permissions services.ModelPermissions, header string) (string, error) {
+	if header != "" {
+		teamId, err := uuid.Parse(header)
+		if err != nil {
+			return "", services.CodedError(fmt.Errorf("invalid %v header '%v'", TeamIdHeader, header), http.StatusBadRequest)
+		}
+		if !permissions.Admin && !slices.Contains(permissions.TeamIds, teamId) {
+			return "", services.CodedError(fmt.Errorf("user is not a member of team %v", teamId), http.StatusForbidden)
+		}
+		return teamId.String(), nil
+	}
+
+	switch len(permissions.TeamIds) {
+	case 0:
+		return "", services.CodedError(errors.New("users must be a member of a team to access a deployment with team isolation"), http.StatusForbidden)
+	case 1:
+		return permissions.TeamIds[0].String(), nil
+	default:
+		return "", services.CodedError(fmt.Errorf("user is a member of multiple teams, the team must be specified with the %v header", TeamIdHeader), http.StatusBadRequest)
+	}
+}
+
+// teamScope resolves the team of the caller from the permissions added to the
+// context by the permission check, so that the handlers only access the
+// documents of the team. It does nothing if team isolation is not enabled.
+func (s *NdbRouter) teamScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.teamIsolation() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		permissions, ok := ModelPermissionsFromContext(r.Context())
+		if !ok {
+			http.Error(w, "unable to determine the team of the user", http.StatusInternalServerError)
+			return
+		}
+
+		team, err := callerTeam(permissions, r.Header.Get(TeamIdHeader))
+		if err != nil {
+			http.Error(w, err.Error(), services.GetResponseCode(err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), teamContextKey{}, team)))
+	})
+}
+
+// teamFromContext returns the team of the caller, or an empty string if team
+// isolation is not enabled.
+func teamFromContext(ctx context.Context) string {
+	team, _ := ctx.Value(teamContextKey{}).(string)
+	return team
+}
+
+// The doc ids of each team are prefixed with the team id, so that teams can use
+// the same doc ids without inserting versions of, or deleting, the documents of
+// other teams.
+func teamDocId(team, docId string) string {
+	if team == "" {
+		return docId
+	}
+	return team + ":" + docId
+}
+
+func userDocId(team, docId string) string {
+	if team == "" {
+		return docId
+	}
+	return strings.TrimPrefix(docId, team+":")
+}
+
+func isTeamDocId(team, docId string) bool {
+	return team == "" || strings.HasPrefix(docId, team+":")
+}
+
+// withTeamMetadata tags each chunk with the team. The metadata is created if it
+// is not specified.
+func withTeamMetadata(team string, metadata []map[string]interface{}, chunks int) []map[string]interface{} {
+	if team == "" {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = make([]map[string]interface{}, chunks)
+	}
+	for i := range metadata {
+		if metadata[i] == nil {
+			metadata[i] = make(map[string]interface{})
+		}
+		metadata[i][TeamMetadataKey] = team
+	}
+	return metadata
+}
+
+// withTeamConstraint constrains a query to the documents of the team. It
+// replaces any constraint on the team key in the request, and is added before
+// the query cache key is computed so that each team has its own cache entries.
+func withTeamConstraint(team string, constraints map[string]ConstraintInput) map[string]ConstraintInput {
+	if team == "" {
+		return constraints
+	}
+	if constraints == nil {
+		constraints = make(map[string]ConstraintInput)
+	}
+	constraints[TeamMetadataKey] = ConstraintInput{Op: "eq", Value: team}
+	return constraints
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/search/ndb"
+
+	"github.com/google/uuid"
+)
+
+func TestCollapseChunks(t *testing.T) {
+	chunks := []ndb.Chunk{
+		{Id: 1, Text: "Reset your password from the account page.", DocId: "a", Metadata: map[string]interface{}{"section": "accounts"}},
+		{Id: 2, Text: "reset your password from the account page", DocId: "b"},
+		{Id: 3, Text: "Passwords expire every 90 days.", DocId: "a", Metadata: map[string]interface{}{"section": "accounts"}},
+		{Id: 4, Text: "Invoices are sent monthly.", DocId: "a", Metadata: map[string]interface{}{"section": "billing"}},
+		{Id: 5, Text: "Contact support to change your plan.", DocId: "c"},
+	}
+
+	check := func(opts deployment.CollapseOptions, topk int, expectedIds []uint64, expectedCounts []int) {
+		t.Helper()
+		collapsed, counts := opts.Collapse(chunks, topk)
+		ids := make([]uint64, 0, len(collapsed))
+		for _, chunk := range collapsed {
+			ids = append(ids, chunk.Id)
+		}
+		if !slices.Equal(ids, expectedIds) || !slices.Equal(counts, expectedCounts) {
+			t.Fatalf("%+v: expected %v %v, got %v %v", opts, expectedIds, expectedCounts, ids, counts)
+		}
+	}
+
+	check(deployment.CollapseOptions{MinOverlap: 0.9}, 5, []uint64{1, 3, 4, 5}, []int{1, 0, 0, 0})
+	check(deployment.CollapseOptions{SameDocument: true}, 5, []uint64{1, 2, 5}, []int{2, 0, 0})
+	check(deployment.CollapseOptions{SectionKey: "section"}, 5, []uint64{1, 2, 4, 5}, []int{1, 0, 0, 0})
+	check(deployment.CollapseOptions{SectionKey: "section", MinOverlap: 0.9}, 5, []uint64{1, 4, 5}, []int{2, 0, 0})
+	check(deployment.CollapseOptions{MinOverlap: 0.9}, 2, []uint64{1, 3}, []int{1, 0})
+}
+
+func queryStatus(t *testing.T, url string, body map[string]interface{}) (int, deployment.SearchResults) {
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(url+"/query", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /query: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res deployment.SearchResults
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("failed to decode /query response: %v", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func TestQueryCollapse(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	chunk := "quarterly revenue report for the finance team"
+	for _, docId := range []string{"repost_1", "repost_2", "repost_3"} {
+		if err := router.Ndb.Insert(docId, docId, []string{chunk}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := router.Ndb.Insert("other", "other", []string{"revenue forecast for the next quarter"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := queryStatus(t, testServer.URL, map[string]interface{}{"query": "quarterly revenue report", "top_k": 2})
+	if status != http.StatusOK || len(res.References) != 2 || res.References[0].Text != chunk || res.References[1].Text != chunk {
+		t.Fatalf("expected copies of the reposted chunk without collapse: %d %+v", status, res)
+	}
+
+	status, res = queryStatus(t, testServer.URL, map[string]interface{}{
+		"query": "quarterly revenue report", "top_k": 2, "collapse": map[string]interface{}{"min_overlap": 0.8},
+	})
+	if status != http.StatusOK || len(res.References) != 2 {
+		t.Fatalf("invalid collapsed results: %d %+v", status, res)
+	}
+	if res.References[0].Text != chunk || res.References[0].Collapsed != 2 || res.References[1].Text == chunk || res.References[1].Collapsed != 0 {
+		t.Fatalf("copies of the reposted chunk should be collapsed: %+v", res)
+	}
+
+	for _, collapse := range []map[string]interface{}{{}, {"min_overlap": 1.5}} {
+		status, _ := queryStatus(t, testServer.URL, map[string]interface{}{"query": "revenue", "top_k": 2, "collapse": collapse})
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected status 422 for collapse options %v, got %d", collapse, status)
+		}
+	}
+}
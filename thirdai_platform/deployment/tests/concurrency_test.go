@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"time"
+)
+
+func makeBlockingServer(limit config.ConcurrencyLimit) (*httptest.Server, chan struct{}, chan struct{}) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(deployment.ConcurrencyLimitMiddleware("query", limit)(handler))
+	return server, started, release
+}
+
+func TestConcurrencyLimitQueueing(t *testing.T) {
+	server, started, release := makeBlockingServer(config.ConcurrencyLimit{MaxConcurrent: 1, MaxQueued: 1, QueueTimeoutSeconds: 10})
+	defer server.Close()
+
+	statuses := make([]int, 2)
+	wg := sync.WaitGroup{}
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := http.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		}(i)
+
+		if i == 0 {
+			<-started // Ensure the first request holds the slot before the second is sent.
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // Ensure the second request is queued.
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "10" {
+		t.Fatalf("request should be rejected when the queue is full, got status %d", res.StatusCode)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for _, status := range statuses {
+		if status != http.StatusOK {
+			t.Fatalf("queued request should complete once a slot is free: %v", statuses)
+		}
+	}
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+	server, started, release := makeBlockingServer(config.ConcurrencyLimit{MaxConcurrent: 1, MaxQueued: 5, QueueTimeoutSeconds: 1})
+	defer server.Close()
+	defer close(release)
+
+	go func() {
+		res, err := http.Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	start := time.Now()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request should time out in queue, got status %d", res.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("request should wait for the queue timeout before being rejected, waited %v", elapsed)
+	}
+}
+
+func TestConcurrencyLimitValidation(t *testing.T) {
+	if err := config.ValidateConcurrencyLimits(map[string]config.ConcurrencyLimit{"query": {MaxConcurrent: 4}, "generate": {MaxConcurrent: 1, MaxQueued: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ValidateConcurrencyLimits(map[string]config.ConcurrencyLimit{"upvote": {MaxConcurrent: 4}}); err == nil {
+		t.Fatal("unsupported route should be rejected")
+	}
+	if err := config.ValidateConcurrencyLimits(map[string]config.ConcurrencyLimit{"query": {MaxConcurrent: -1}}); err == nil {
+		t.Fatal("negative limit should be rejected")
+	}
+}
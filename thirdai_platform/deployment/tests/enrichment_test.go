@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+func TestQueryEnrichment(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	var calls atomic.Int32
+	var delay atomic.Int64
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(time.Duration(delay.Load()))
+		if r.URL.Path != "/docs/doc_id_1" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"owner": "alice", "status": "open", "internal": "hidden"})
+	}))
+	defer endpoint.Close()
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+		QueryCache:          &config.QueryCacheOptions{MaxEntries: 10},
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	router.Enricher = deployment.NewEnricher(config.EnrichmentOptions{
+		Endpoint:      endpoint.URL + "/docs/{source_id}",
+		Fields:        []string{"owner", "status"},
+		TimeoutMillis: 100,
+	})
+
+	checkEnriched := func(results deployment.SearchResults) {
+		if len(results.References) != 2 {
+			t.Fatalf("expected 2 results, got %+v", results)
+		}
+		for _, ref := range results.References {
+			if len(ref.Enrichment) != 2 || ref.Enrichment["owner"] != "alice" || ref.Enrichment["status"] != "open" {
+				t.Fatalf("invalid enrichment %+v", ref.Enrichment)
+			}
+		}
+	}
+
+	// Both results are from the same source, which is only fetched once.
+	status, results := queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 2})
+	if status != http.StatusOK {
+		t.Fatalf("query failed with status %d", status)
+	}
+	checkEnriched(results)
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 enrichment request, got %d", calls.Load())
+	}
+
+	// Results from the query cache are enriched from the enrichment cache.
+	status, results = queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 2})
+	if status != http.StatusOK {
+		t.Fatalf("query failed with status %d", status)
+	}
+	checkEnriched(results)
+	if calls.Load() != 1 {
+		t.Fatalf("expected cached enrichment, got %d requests", calls.Load())
+	}
+
+	// Results are returned without the fields if the endpoint is too slow.
+	router.Enricher = deployment.NewEnricher(config.EnrichmentOptions{Endpoint: endpoint.URL + "/docs/{source_id}", TimeoutMillis: 20})
+	delay.Store(int64(200 * time.Millisecond))
+	start := time.Now()
+	status, results = queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 2})
+	if status != http.StatusOK || len(results.References) != 2 || results.References[0].Enrichment != nil {
+		t.Fatalf("expected results without enrichment: %d %+v", status, results)
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("query waited %v for the enrichment endpoint", time.Since(start))
+	}
+
+	// Sources that the endpoint does not know about have no fields.
+	delay.Store(0)
+	router.Enricher = deployment.NewEnricher(config.EnrichmentOptions{Endpoint: endpoint.URL + "/other/{source_id}"})
+	status, results = queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 2})
+	if status != http.StatusOK || len(results.References) != 2 || results.References[0].Enrichment != nil {
+		t.Fatalf("expected results without enrichment: %d %+v", status, results)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+func queryFacets(t *testing.T, url string, body map[string]interface{}) (int, deployment.FacetsResults) {
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(url+"/facets", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /facets: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res deployment.FacetsResults
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("failed to decode /facets response: %v", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func TestFacets(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	err = router.Ndb.Insert("reports", "reports", []string{
+		"revenue report for sales", "revenue report for finance", "revenue report for engineering", "revenue report for sales in europe",
+	}, []map[string]interface{}{
+		{"department": "sales", "year": 2023},
+		{"department": "finance", "year": 2024},
+		{"department": "engineering", "year": 2024},
+		{"department": "sales", "year": 2024},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := queryFacets(t, testServer.URL, map[string]interface{}{"query": "revenue report", "keys": []string{"department", "year", "missing"}})
+	if status != http.StatusOK || res.Matched != 4 {
+		t.Fatalf("invalid facets: %d %+v", status, res)
+	}
+
+	departments := res.Facets["department"]
+	if len(departments) != 3 || departments[0].Value != "sales" || departments[0].Count != 2 ||
+		departments[1].Value != "engineering" || departments[1].Count != 1 || departments[2].Value != "finance" || departments[2].Count != 1 {
+		t.Fatalf("invalid department facets: %+v", departments)
+	}
+	years := res.Facets["year"]
+	if len(years) != 2 || years[0].Value != float64(2024) || years[0].Count != 3 || years[1].Value != float64(2023) || years[1].Count != 1 {
+		t.Fatalf("invalid year facets: %+v", years)
+	}
+	if missing, ok := res.Facets["missing"]; !ok || len(missing) != 0 {
+		t.Fatalf("keys without values should have no facets: %+v", res.Facets)
+	}
+
+	status, res = queryFacets(t, testServer.URL, map[string]interface{}{
+		"query":       "revenue report",
+		"keys":        []string{"year"},
+		"constraints": map[string]interface{}{"department": map[string]interface{}{"op": "eq", "value": "sales"}},
+		"max_values":  1,
+	})
+	// Values with the same count are ordered by value.
+	if status != http.StatusOK || res.Matched != 2 || len(res.Facets["year"]) != 1 || res.Facets["year"][0].Value != float64(2023) || res.Facets["year"][0].Count != 1 {
+		t.Fatalf("invalid facets with constraints: %d %+v", status, res)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"query": "revenue"},
+		{"keys": []string{"department"}},
+		{"query": "revenue", "keys": []string{"department"}, "max_results": 100000},
+	} {
+		if status, _ := queryFacets(t, testServer.URL, body); status != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %v, got %d", body, status)
+		}
+	}
+
+	status, _ = queryFacets(t, testServer.URL, map[string]interface{}{
+		"query": "revenue", "keys": []string{"department"}, "constraints": map[string]interface{}{"year": map[string]interface{}{"op": "bad"}},
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for invalid constraints, got %d", status)
+	}
+}
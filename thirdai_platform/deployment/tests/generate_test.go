@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/utils/llm_generation"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// blockingLLM streams the first chunk of the response, then waits until it is
+// released or cancelled before streaming the rest.
+type blockingLLM struct {
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func newBlockingLLM() *blockingLLM {
+	return &blockingLLM{release: make(chan struct{}), cancelled: make(chan struct{})}
+}
+
+func (m *blockingLLM) StreamResponse(ctx context.Context, req llm_generation.GenerateRequest, onChunk func(string)) (string, error) {
+	onChunk("This ")
+
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		close(m.cancelled)
+		return "", ctx.Err()
+	}
+
+	onChunk("is ")
+	onChunk("a test.")
+	return "This is a test.", nil
+}
+
+type sseEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+func readEvent(t *testing.T, reader *bufio.Reader) *sseEvent {
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return &event
+		case strings.HasPrefix(line, ":"):
+			event.comment = strings.TrimSpace(strings.TrimPrefix(line, ":"))
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected line in event stream: %q", line)
+		}
+	}
+}
+
+// readDataEvent returns the next event that is not a keep-alive.
+func readDataEvent(t *testing.T, reader *bufio.Reader) *sseEvent {
+	for {
+		event := readEvent(t, reader)
+		if event == nil || event.comment == "" {
+			return event
+		}
+	}
+}
+
+func startGenerate(t *testing.T, testServer *httptest.Server, lastEventId string, resumeFrom string) *http.Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":       "is this a test?",
+		"references":  []map[string]interface{}{{"reference_id": 4, "text": "my name is chatgpt", "source": "doc_id_1"}},
+		"model":       "gpt-4o-mini",
+		"resume_from": resumeFrom,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/generate", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func expectEvent(t *testing.T, event *sseEvent, id, data string) {
+	if event == nil || event.id != id || event.data != data {
+		t.Fatalf("expected event with id %q and data %q, got %+v", id, data, event)
+	}
+}
+
+func newGenerateTestServer(t *testing.T) (*httptest.Server, *blockingLLM, func() string) {
+	if err := verifyTestLicense(); err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+
+	llm := newBlockingLLM()
+	router.LLM = llm
+
+	router.Generations.KeepAliveInterval = 20 * time.Millisecond
+	router.Generations.ResumeGracePeriod = 100 * time.Millisecond
+
+	cachedResponse := func() string {
+		res, err := router.LLMCache.Query("is this a test?", []uint64{4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	return testServer, llm, cachedResponse
+}
+
+func TestGenerateKeepAliveAndEventIds(t *testing.T) {
+	testServer, llm, cachedResponse := newGenerateTestServer(t)
+	defer testServer.Close()
+
+	res := startGenerate(t, testServer, "", "")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	reader := bufio.NewReader(res.Body)
+
+	first := readEvent(t, reader)
+	if first == nil || first.data != "This " || !strings.HasSuffix(first.id, ":0") {
+		t.Fatalf("invalid first event %+v", first)
+	}
+	genId := strings.TrimSuffix(first.id, ":0")
+
+	if keepAlive := readEvent(t, reader); keepAlive == nil || keepAlive.comment != "keep-alive" {
+		t.Fatalf("expected keep-alive while waiting for llm, got %+v", keepAlive)
+	}
+
+	close(llm.release)
+
+	expectEvent(t, readDataEvent(t, reader), genId+":1", "is ")
+	expectEvent(t, readDataEvent(t, reader), genId+":2", "a test.")
+	if event := readDataEvent(t, reader); event != nil {
+		t.Fatalf("expected end of stream, got %+v", event)
+	}
+
+	if cached := cachedResponse(); cached != "This is a test." {
+		t.Fatalf("completed generation should be cached, got %q", cached)
+	}
+}
+
+func TestGenerateResume(t *testing.T) {
+	testServer, llm, _ := newGenerateTestServer(t)
+	defer testServer.Close()
+
+	res := startGenerate(t, testServer, "", "")
+	first := readEvent(t, bufio.NewReader(res.Body))
+	if first == nil || first.data != "This " {
+		t.Fatalf("invalid first event %+v", first)
+	}
+	res.Body.Close()
+
+	close(llm.release)
+
+	res = startGenerate(t, testServer, first.id, "")
+	reader := bufio.NewReader(res.Body)
+	genId := strings.TrimSuffix(first.id, ":0")
+	expectEvent(t, readDataEvent(t, reader), genId+":1", "is ")
+	expectEvent(t, readDataEvent(t, reader), genId+":2", "a test.")
+	if event := readDataEvent(t, reader); event != nil {
+		t.Fatalf("expected end of stream, got %+v", event)
+	}
+	res.Body.Close()
+
+	res = startGenerate(t, testServer, "", genId+":1")
+	reader = bufio.NewReader(res.Body)
+	expectEvent(t, readDataEvent(t, reader), genId+":2", "a test.")
+	res.Body.Close()
+
+	res = startGenerate(t, testServer, uuid.NewString()+":0", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown generation, got %d", res.StatusCode)
+	}
+
+	res = startGenerate(t, testServer, "invalid", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid event id, got %d", res.StatusCode)
+	}
+}
+
+func TestGenerateAbortedWithoutResume(t *testing.T) {
+	testServer, llm, cachedResponse := newGenerateTestServer(t)
+	defer testServer.Close()
+
+	res := startGenerate(t, testServer, "", "")
+	first := readEvent(t, bufio.NewReader(res.Body))
+	if first == nil || first.data != "This " {
+		t.Fatalf("invalid first event %+v", first)
+	}
+	res.Body.Close()
+
+	select {
+	case <-llm.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("llm call should be aborted if the client does not resume")
+	}
+
+	// Wait for the generation to record the error.
+	time.Sleep(50 * time.Millisecond)
+
+	res = startGenerate(t, testServer, first.id, "")
+	defer res.Body.Close()
+	if event := readDataEvent(t, bufio.NewReader(res.Body)); event == nil || event.event != "error" {
+		t.Fatalf("expected error event when resuming aborted generation, got %+v", event)
+	}
+
+	if cached := cachedResponse(); cached != "" {
+		t.Fatalf("aborted generation should not be cached, got %q", cached)
+	}
+
+	metrics, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Body.Close()
+	data, err := io.ReadAll(metrics.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `llm_generations_total{status="cancelled"}`) {
+		t.Fatal("aborted generation should be recorded as cancelled in metrics")
+	}
+}
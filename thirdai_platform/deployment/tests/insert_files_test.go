@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/search/ndb"
+
+	"github.com/google/uuid"
+)
+
+func insertMultipartFiles(t *testing.T, url string, options string, files map[string]string) (*http.Response, []byte) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	if err := writer.WriteField("options", options); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url+"/insert-files", writer.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestInsertFiles(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	resp, data := insertMultipartFiles(t, testServer.URL, `{"doc_id": "doc_id_2", "metadata": {"team": "finance"}}`, map[string]string{
+		"report.html": "<html><body><h1>Quarterly report</h1><p>revenue increased significantly</p></body></html>",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("insert files failed: %d %s", resp.StatusCode, data)
+	}
+	var res deployment.InsertFilesResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Documents) != 1 || res.Documents[0].DocId != "doc_id_2" || res.Documents[0].Chunks != 1 {
+		t.Fatalf("invalid insert files response %+v", res)
+	}
+	checkSources(t, testServer, []string{"doc_id_1", "doc_id_2"})
+
+	chunks, err := router.Ndb.Query("revenue increased", 1, nil)
+	if err != nil || len(chunks) != 1 || chunks[0].Text != "Quarterly report revenue increased significantly" || chunks[0].Metadata["team"] != "finance" {
+		t.Fatalf("inserted file should be queryable: %v %v", chunks, err)
+	}
+
+	// Files in an upload are inserted with a random doc id.
+	uploadId := uuid.New()
+	uploadDir := filepath.Join(cfg.ModelBazaarDir, storage.UploadPath(uploadId))
+	if err := os.MkdirAll(filepath.Join(uploadDir, "notes"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadDir, "notes", "meeting.txt"), []byte("first paragraph\n\nsecond paragraph"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(deployment.InsertFilesRequest{UploadId: uploadId})
+	resp, err = http.Post(testServer.URL+"/insert-files", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	res = deployment.InsertFilesResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Documents) != 1 || res.Documents[0].Document != filepath.Join("notes", "meeting.txt") {
+		t.Fatalf("invalid insert files response %+v", res)
+	}
+	srcs, err := router.Ndb.Sources()
+	if err != nil || len(srcs) != 3 || !slices.ContainsFunc(srcs, func(src ndb.Source) bool { return src.DocId == res.Documents[0].DocId }) {
+		t.Fatalf("upload files should be inserted: %v %v", srcs, err)
+	}
+
+	// Unsupported files and doc ids for multiple files are rejected before
+	// anything is inserted.
+	resp, data = insertMultipartFiles(t, testServer.URL, `{}`, map[string]string{"data.csv": "a,b"})
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(data), "unsupported") {
+		t.Fatalf("expected unsupported file to be rejected: %d %s", resp.StatusCode, data)
+	}
+
+	resp, data = insertMultipartFiles(t, testServer.URL, `{"doc_id": "doc_id_3"}`, map[string]string{"a.txt": "a", "b.txt": "b"})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected doc_id with multiple files to be rejected: %d %s", resp.StatusCode, data)
+	}
+
+	resp, data = insertMultipartFiles(t, testServer.URL, `{"chunk_size": 10, "chunk_overlap": 10}`, map[string]string{"a.txt": "a"})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected invalid chunk options to be rejected: %d %s", resp.StatusCode, data)
+	}
+
+	srcs, err = router.Ndb.Sources()
+	if err != nil || len(srcs) != 3 {
+		t.Fatalf("rejected files should not be inserted: %v %v", srcs, err)
+	}
+}
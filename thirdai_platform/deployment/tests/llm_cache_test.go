@@ -1,8 +1,17 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func checkCacheQuery(t *testing.T, cache *deployment.LLMCache, query string, referenceIds []uint64, expectedAnswer string) {
@@ -36,7 +45,7 @@ func TestLLMCache(t *testing.T) {
 	modelbazaardir := t.TempDir()
 	modelID := "test_model"
 
-	cache, err := deployment.NewLLMCache(modelbazaardir, modelID)
+	cache, err := deployment.NewLLMCache(modelbazaardir, modelID, nil)
 	if err != nil || cache == nil {
 		t.Fatalf("failed to create LLMCache: %v", err)
 	}
@@ -46,7 +55,7 @@ func TestLLMCache(t *testing.T) {
 
 	checkCacheQuery(t, cache, "test query", []uint64{0}, "")
 
-	err = cache.Insert("test query", "test response", []uint64{0, 1, 2})
+	err = cache.Insert("test query", "test response", []uint64{0, 1, 2}, nil)
 	if err != nil {
 		t.Fatalf("failed to insert into cache: %v", err)
 	}
@@ -60,11 +69,11 @@ func TestLLMCache(t *testing.T) {
 	checkCacheQuery(t, cache, "test query", []uint64{0, 1, 2}, "")
 
 	// multiple insertion shouldn't fail
-	err = cache.Insert("test query", "test response", []uint64{0, 1, 2})
+	err = cache.Insert("test query", "test response", []uint64{0, 1, 2}, nil)
 	if err != nil {
 		t.Fatalf("failed to insert into cache: %v", err)
 	}
-	err = cache.Insert("test query", "another response", []uint64{0, 1, 2})
+	err = cache.Insert("test query", "another response", []uint64{0, 1, 2}, nil)
 	if err != nil {
 		t.Fatalf("failed to insert into cache: %v", err)
 	}
@@ -73,3 +82,149 @@ func TestLLMCache(t *testing.T) {
 	checkCacheQuery(t, cache, "test query", []uint64{}, "")
 	checkCacheQuery(t, cache, "test query", []uint64{0, 1, 2}, "")
 }
+
+func newTestLLMCache(t *testing.T, modelBazaarDir string, opts *config.LLMCacheOptions) *deployment.LLMCache {
+	cache, err := deployment.NewLLMCache(modelBazaarDir, "test_model", opts)
+	if err != nil {
+		t.Fatalf("failed to create LLMCache: %v", err)
+	}
+	return cache
+}
+
+func insertCacheEntry(t *testing.T, cache *deployment.LLMCache, query, llmRes string, referenceIds []uint64, sourceIds []string) {
+	if err := cache.Insert(query, llmRes, referenceIds, sourceIds); err != nil {
+		t.Fatalf("failed to insert into cache: %v", err)
+	}
+}
+
+func TestLLMCacheTtl(t *testing.T) {
+	if err := verifyTestLicense(); err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cache := newTestLLMCache(t, t.TempDir(), &config.LLMCacheOptions{TtlSeconds: 1})
+	defer cache.Close()
+
+	insertCacheEntry(t, cache, "test query", "test response", []uint64{0}, nil)
+	checkCacheQuery(t, cache, "test query", []uint64{0}, "test response")
+
+	time.Sleep(1100 * time.Millisecond)
+
+	checkCacheQuery(t, cache, "test query", []uint64{0}, "")
+	checkCacheSuggestions(t, cache, "test query", []string{})
+}
+
+func TestLLMCacheMaxEntries(t *testing.T) {
+	if err := verifyTestLicense(); err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cache := newTestLLMCache(t, t.TempDir(), &config.LLMCacheOptions{MaxEntries: 2})
+	defer cache.Close()
+
+	insertCacheEntry(t, cache, "first query", "first response", []uint64{0}, nil)
+	insertCacheEntry(t, cache, "second query", "second response", []uint64{1}, nil)
+	insertCacheEntry(t, cache, "third query", "third response", []uint64{2}, nil)
+
+	checkCacheQuery(t, cache, "first query", []uint64{0}, "")
+	checkCacheQuery(t, cache, "second query", []uint64{1}, "second response")
+	checkCacheQuery(t, cache, "third query", []uint64{2}, "third response")
+
+	// the entries should be loaded when the cache is opened, so the limit still
+	// applies to them
+	modelBazaarDir := t.TempDir()
+	if err := cache.Ndb.Save(filepath.Join(modelBazaarDir, "models", "test_model", "llm_cache", "llm_cache.ndb")); err != nil {
+		t.Fatalf("failed to save cache: %v", err)
+	}
+	loaded := newTestLLMCache(t, modelBazaarDir, &config.LLMCacheOptions{MaxEntries: 2})
+	defer loaded.Close()
+
+	checkCacheQuery(t, loaded, "second query", []uint64{1}, "second response")
+
+	insertCacheEntry(t, loaded, "fourth query", "fourth response", []uint64{3}, nil)
+
+	checkCacheQuery(t, loaded, "second query", []uint64{1}, "")
+	checkCacheQuery(t, loaded, "third query", []uint64{2}, "third response")
+	checkCacheQuery(t, loaded, "fourth query", []uint64{3}, "fourth response")
+}
+
+func TestLLMCacheInvalidate(t *testing.T) {
+	if err := verifyTestLicense(); err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cache := newTestLLMCache(t, t.TempDir(), nil)
+	defer cache.Close()
+
+	insertCacheEntry(t, cache, "first query", "first response", []uint64{0, 1}, []string{"doc_a"})
+	insertCacheEntry(t, cache, "second query", "second response", []uint64{2, 3}, []string{"doc_a", "doc_b"})
+	insertCacheEntry(t, cache, "third query", "third response", []uint64{4}, []string{"doc_c"})
+
+	invalidated, err := cache.Invalidate([]string{"doc_b"}, []uint64{1})
+	if err != nil {
+		t.Fatalf("failed to invalidate cache: %v", err)
+	}
+	if invalidated != 2 {
+		t.Fatalf("expected 2 invalidated entries, got %d", invalidated)
+	}
+
+	checkCacheQuery(t, cache, "first query", []uint64{0, 1}, "")
+	checkCacheQuery(t, cache, "second query", []uint64{2, 3}, "")
+	checkCacheQuery(t, cache, "third query", []uint64{4}, "third response")
+}
+
+func doCacheInvalidate(t *testing.T, testServer *httptest.Server, body map[string]interface{}) (int, deployment.CacheInvalidateResponse) {
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(testServer.URL+"/cache-invalidate", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /cache-invalidate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res deployment.CacheInvalidateResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func TestLLMCacheInvalidatedByDocumentChanges(t *testing.T) {
+	if err := verifyTestLicense(); err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	generate := func() {
+		doGenerate(t, testServer, "is this a test?", []map[string]interface{}{
+			{"reference_id": 4, "text": "a new word", "source": "doc_name_2", "source_id": "doc_id_2"},
+		}, "gpt-4o-mini")
+		checkLLMCache(t, router.LLMCache, "is this a test?", []uint64{4}, "This is a test.")
+	}
+
+	// inserting a new version of the document invalidates responses that used it
+	generate()
+	doInsert(t, testServer)
+	checkLLMCache(t, router.LLMCache, "is this a test?", []uint64{4}, "")
+
+	generate()
+	doDelete(t, testServer, []string{"doc_id_2"})
+	checkLLMCache(t, router.LLMCache, "is this a test?", []uint64{4}, "")
+
+	generate()
+	if status, _ := doCacheInvalidate(t, testServer, map[string]interface{}{}); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, status)
+	}
+	if status, res := doCacheInvalidate(t, testServer, map[string]interface{}{"reference_ids": []uint64{4}}); status != http.StatusOK || res.Invalidated != 1 {
+		t.Fatalf("expected 1 invalidated entry, got status %d and %+v", status, res)
+	}
+	checkLLMCache(t, router.LLMCache, "is this a test?", []uint64{4}, "")
+}
@@ -2,8 +2,8 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
@@ -26,17 +26,10 @@ import (
 
 type MockLLM struct{}
 
-func (m *MockLLM) StreamResponse(req llm_generation.GenerateRequest, w http.ResponseWriter, r *http.Request) (string, error) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		return "", fmt.Errorf("streaming unsupported")
-	}
-
+func (m *MockLLM) StreamResponse(ctx context.Context, req llm_generation.GenerateRequest, onChunk func(string)) (string, error) {
 	responses := []string{"This ", "is ", "a test."}
 	for _, chunk := range responses {
-		fmt.Fprintf(w, "data: %s\n\n", chunk)
-		flusher.Flush()
+		onChunk(chunk)
 	}
 
 	return "This is a test.", nil
@@ -58,6 +51,10 @@ func (m *MockPermissions) ModelPermissionsCheck(permission_type deployment.Permi
 	}
 }
 
+func (m *MockPermissions) CheckUploadAccess(token string, uploadId uuid.UUID) error {
+	return nil
+}
+
 func makeNdbServer(t *testing.T, config *config.DeployConfig) (*httptest.Server, *deployment.NdbRouter) {
 	modelID := config.ModelId
 	modelDir := filepath.Join(config.ModelBazaarDir, "models", modelID.String(), "model", "model.ndb")
@@ -78,7 +75,7 @@ func makeNdbServer(t *testing.T, config *config.DeployConfig) (*httptest.Server,
 	}
 
 	mockPermissions := MockPermissions{}
-	cache, err := deployment.NewLLMCache(config.ModelBazaarDir, modelID.String())
+	cache, err := deployment.NewLLMCache(config.ModelBazaarDir, modelID.String(), nil)
 	if err != nil {
 		t.Fatalf("failed to create llm cache: %v", err)
 	}
@@ -336,6 +333,110 @@ func TestBasicEndpoints(t *testing.T) {
 	}, "gpt-4o-mini")
 }
 
+func queryWithConstraints(t *testing.T, testServer *httptest.Server, query string, constraints map[string]interface{}) (int, []int) {
+	body := map[string]interface{}{"query": query, "top_k": 3, "constraints": constraints}
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(testServer.URL+"/query", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /query: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	var data deployment.SearchResults
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatalf("failed to decode /query response: %v", err)
+	}
+	ids := make([]int, 0, len(data.References))
+	for _, ref := range data.References {
+		ids = append(ids, ref.Id)
+	}
+	return resp.StatusCode, ids
+}
+
+func TestQueryConstraints(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	config := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, _ := makeNdbServer(t, config)
+	defer testServer.Close()
+
+	body := map[string]interface{}{
+		"document": "doc_name_2",
+		"doc_id":   "doc_id_2",
+		"chunks":   []string{"test line for sales", "test line for hr", "test line for engineering"},
+		"metadata": []map[string]interface{}{
+			{"team": "sales", "date": "2024-01-15"},
+			{"team": "hr", "date": "2024-06-30"},
+			{"team": "eng-infra", "date": "2025-01-01"},
+		},
+	}
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(testServer.URL+"/insert", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("insert failed with status %d", resp.StatusCode)
+	}
+
+	checks := []struct {
+		constraints map[string]interface{}
+		expected    []int
+	}{
+		{map[string]interface{}{"team": map[string]interface{}{"op": "in", "value": []string{"sales", "hr"}}}, []int{3, 4}},
+		{map[string]interface{}{"team": map[string]interface{}{"op": "prefix", "value": "eng"}}, []int{5}},
+		{map[string]interface{}{"team": map[string]interface{}{"op": "contains", "value": "ale"}}, []int{3}},
+		{map[string]interface{}{"date": map[string]interface{}{"op": "gte", "value": "2024-06-30"}}, []int{4, 5}},
+		{map[string]interface{}{"date": map[string]interface{}{"op": "and", "constraints": []map[string]interface{}{
+			{"op": "gt", "value": "2024-01-15"}, {"op": "lte", "value": "2024-06-30"},
+		}}}, []int{4}},
+		{map[string]interface{}{
+			"team": map[string]interface{}{"op": "or", "constraints": []map[string]interface{}{
+				{"op": "eq", "value": "sales"}, {"op": "prefix", "value": "eng"},
+			}},
+			"date": map[string]interface{}{"op": "lt", "value": "2025"},
+		}, []int{3}},
+	}
+
+	for _, check := range checks {
+		status, ids := queryWithConstraints(t, testServer, "test line for", check.constraints)
+		if status != http.StatusOK {
+			t.Fatalf("query with constraints %v failed with status %d", check.constraints, status)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, check.expected) {
+			t.Fatalf("constraints %v: expected %v, got %v", check.constraints, check.expected, ids)
+		}
+	}
+
+	invalid := []map[string]interface{}{
+		{"op": "like", "value": "sales"},
+		{"op": "in", "value": []string{}},
+		{"op": "contains", "value": 1},
+		{"op": "eq"},
+		{"op": "and"},
+		{"op": "or", "constraints": []map[string]interface{}{{"op": "in", "value": "sales"}}},
+	}
+	for _, constraint := range invalid {
+		status, _ := queryWithConstraints(t, testServer, "test line for", map[string]interface{}{"team": constraint})
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("constraint %v should be rejected, got status %d", constraint, status)
+		}
+	}
+}
+
 func TestSaveLoadDeployConfig(t *testing.T) {
 	expectedConfig := &config.DeployConfig{
 		ModelId:             uuid.New(),
@@ -351,6 +452,9 @@ func TestSaveLoadDeployConfig(t *testing.T) {
 			"param1": "value1",
 			"param2": "value2",
 		},
+		ConcurrencyLimits: map[string]config.ConcurrencyLimit{
+			"generate": {MaxConcurrent: 2, MaxQueued: 8, QueueTimeoutSeconds: 60},
+		},
 	}
 
 	tmp_dir := t.TempDir()
@@ -376,4 +480,4 @@ func TestSaveLoadDeployConfig(t *testing.T) {
 	}
 }
 
-// TODO unit tests for constraints, full source paths, insertion of large files
+// TODO unit tests for full source paths, insertion of large files
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+func TestModelPermissionsCheck(t *testing.T) {
+	modelId := uuid.New()
+
+	modelBazaar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/api/v2/model/%v/permissions", modelId) || r.Header.Get("Authorization") != "Bearer user-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(services.ModelPermissions{Read: true}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer modelBazaar.Close()
+
+	permissions := &deployment.Permissions{ModelBazaarEndpoint: modelBazaar.URL, ModelId: modelId}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	check := func(permission deployment.PermissionType, token string) int {
+		req := httptest.NewRequest("GET", "/query", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		permissions.ModelPermissionsCheck(permission)(ok).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := check(deployment.ReadPermission, ""); code != http.StatusUnauthorized {
+		t.Fatalf("requests without a token should be rejected, got %d", code)
+	}
+	if code := check(deployment.ReadPermission, "user-token"); code != http.StatusOK {
+		t.Fatalf("requests with a valid token should be allowed, got %d", code)
+	}
+	if code := check(deployment.WritePermission, "user-token"); code != http.StatusForbidden {
+		t.Fatalf("requests without the permission should be forbidden, got %d", code)
+	}
+	if code := check(deployment.OwnerPermission, "user-token"); code != http.StatusForbidden {
+		t.Fatalf("requests without owner permission should be forbidden, got %d", code)
+	}
+	if code := check(deployment.ReadPermission, "other-token"); code == http.StatusOK {
+		t.Fatal("requests with an invalid token should be rejected")
+	}
+}
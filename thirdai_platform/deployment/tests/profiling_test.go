@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+func TestProfilingEndpoints(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	for _, enabled := range []bool{false, true} {
+		cfg := &config.DeployConfig{
+			ModelId:             uuid.New(),
+			ModelBazaarDir:      t.TempDir(),
+			ModelBazaarEndpoint: "http://localhost:8080",
+			EnableProfiling:     enabled,
+		}
+		testServer, _ := makeNdbServer(t, cfg)
+
+		resp, err := http.Get(testServer.URL + "/debug/pprof/heap?debug=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		testServer.Close()
+
+		expected := http.StatusNotFound
+		if enabled {
+			expected = http.StatusOK
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status %d with profiling enabled=%v, got %d", expected, enabled, resp.StatusCode)
+		}
+	}
+}
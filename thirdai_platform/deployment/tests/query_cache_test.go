@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func cachedResults(id int) deployment.SearchResults {
+	return deployment.SearchResults{References: []deployment.SearchResult{{Id: id}}}
+}
+
+func TestQueryCacheLRU(t *testing.T) {
+	cache := deployment.NewQueryCache(config.QueryCacheOptions{MaxEntries: 2})
+
+	for i, key := range []string{"a", "b"} {
+		_, gen := cache.Get(key)
+		cache.Put(key, cachedResults(i), gen)
+	}
+
+	if res, _ := cache.Get("a"); res == nil || res.References[0].Id != 0 {
+		t.Fatal("expected cache hit for a")
+	}
+
+	_, gen := cache.Get("c")
+	cache.Put("c", cachedResults(2), gen)
+
+	// b is the least recently used entry since a was accessed after it was added.
+	if res, _ := cache.Get("b"); res != nil {
+		t.Fatal("b should be evicted")
+	}
+	if res, _ := cache.Get("a"); res == nil {
+		t.Fatal("a should not be evicted")
+	}
+	if res, _ := cache.Get("c"); res == nil {
+		t.Fatal("c should not be evicted")
+	}
+}
+
+func TestQueryCacheTTL(t *testing.T) {
+	cache := deployment.NewQueryCache(config.QueryCacheOptions{MaxEntries: 2, TtlSeconds: 1})
+
+	_, gen := cache.Get("a")
+	cache.Put("a", cachedResults(0), gen)
+
+	if res, _ := cache.Get("a"); res == nil {
+		t.Fatal("expected cache hit before ttl")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if res, _ := cache.Get("a"); res != nil {
+		t.Fatal("entry should expire after ttl")
+	}
+}
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	cache := deployment.NewQueryCache(config.QueryCacheOptions{MaxEntries: 2})
+
+	_, gen := cache.Get("a")
+	cache.Put("a", cachedResults(0), gen)
+
+	_, staleGen := cache.Get("b")
+
+	cache.Invalidate()
+
+	if res, _ := cache.Get("a"); res != nil {
+		t.Fatal("cache should be empty after invalidation")
+	}
+
+	// Results computed before the invalidation should not be cached.
+	cache.Put("b", cachedResults(1), staleGen)
+	if res, _ := cache.Get("b"); res != nil {
+		t.Fatal("stale results should not be cached")
+	}
+}
+
+func TestQueryCacheEndpoints(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	router.QueryCache = deployment.NewQueryCache(config.QueryCacheOptions{MaxEntries: 10})
+
+	queryIds := func(query string) []int {
+		body, _ := json.Marshal(map[string]interface{}{"query": query, "top_k": 5})
+		resp, err := http.Post(testServer.URL+"/query", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to post /query: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var data deployment.SearchResults
+		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+			t.Fatalf("failed to decode /query response: %v", err)
+		}
+		ids := make([]int, 0, len(data.References))
+		for _, ref := range data.References {
+			ids = append(ids, ref.Id)
+		}
+		return ids
+	}
+
+	if ids := queryIds("test line"); !slices.Equal(ids, queryIds("test line")) || !slices.Contains(ids, 0) {
+		t.Fatalf("repeated query should return the same results: %v", ids)
+	}
+
+	// Each modification must invalidate the cached results for the query.
+	if slices.Contains(queryIds("unrelated query"), 2) {
+		t.Fatal("unrelated query should not match reference 2 before upvote")
+	}
+	doUpvote(t, testServer, "unrelated query", 2)
+	if !slices.Contains(queryIds("unrelated query"), 2) {
+		t.Fatal("upvote should invalidate cached query results")
+	}
+
+	if slices.Contains(queryIds("source"), 1) {
+		t.Fatal("source should not match reference 1 before associate")
+	}
+	doAssociate(t, testServer, "source", "another test line")
+	if !slices.Contains(queryIds("source"), 1) {
+		t.Fatal("associate should invalidate cached query results")
+	}
+
+	if len(queryIds("new word")) != 0 {
+		t.Fatal("new word should not match any references before insert")
+	}
+	doInsert(t, testServer)
+	if len(queryIds("new word")) == 0 {
+		t.Fatal("insert should invalidate cached query results")
+	}
+
+	doDelete(t, testServer, []string{"doc_id_2"})
+	if len(queryIds("new word")) != 0 {
+		t.Fatal("delete should invalidate cached query results")
+	}
+}
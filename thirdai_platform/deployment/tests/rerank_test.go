@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+// keywordEmbedder embeds texts that contain the keyword, and the query, in the
+// same direction, so that reranking moves the chunks with the keyword first.
+type keywordEmbedder struct {
+	keyword string
+	fail    bool
+}
+
+func (e *keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	if e.fail {
+		return nil, errors.New("embedding model unavailable")
+	}
+	embeddings := make([][]float64, len(texts))
+	for i, text := range texts {
+		if i == 0 || strings.Contains(text, e.keyword) {
+			embeddings[i] = []float64{1, 0}
+		} else {
+			embeddings[i] = []float64{0, 1}
+		}
+	}
+	return embeddings, nil
+}
+
+func TestQueryRerank(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	query := map[string]interface{}{"query": "another test line", "top_k": 1, "rerank": true}
+
+	if status, _ := queryStatus(t, testServer.URL, query); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 when rerank is not enabled, got %d", status)
+	}
+
+	status, res := queryStatus(t, testServer.URL, map[string]interface{}{"query": "another test line", "top_k": 1})
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text == "test line one" {
+		t.Fatalf("invalid lexical results: %d %+v", status, res)
+	}
+	lexicalTop := res.References[0].Text
+
+	cfg.Rerank = &config.RerankOptions{Provider: config.RerankLocal, Weight: 1}
+	embedder := &keywordEmbedder{keyword: "one"}
+	router.Embedder = embedder
+
+	status, res = queryStatus(t, testServer.URL, query)
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text != "test line one" {
+		t.Fatalf("reranking should return the chunk with the closest embedding: %d %+v", status, res)
+	}
+
+	// The lexical results are returned if the embeddings cannot be computed.
+	embedder.fail = true
+	status, res = queryStatus(t, testServer.URL, query)
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text != lexicalTop {
+		t.Fatalf("expected lexical results when reranking fails: %d %+v", status, res)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+func scrollPage(t *testing.T, url string, cursor string) (int, deployment.SearchResults) {
+	bodyBytes, _ := json.Marshal(map[string]string{"cursor": cursor})
+	resp, err := http.Post(url+"/query/scroll", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /query/scroll: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res deployment.SearchResults
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("failed to decode /query/scroll response: %v", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func TestQueryScroll(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, _ := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	status, all := queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 10})
+	if status != http.StatusOK || len(all.References) != 2 || all.Cursor != "" {
+		t.Fatalf("invalid results without scroll: %d %+v", status, all)
+	}
+
+	status, first := queryStatus(t, testServer.URL, map[string]interface{}{"query": "test line", "top_k": 1, "scroll": map[string]interface{}{}})
+	if status != http.StatusOK || len(first.References) != 1 || first.Total != 2 || first.Cursor == "" {
+		t.Fatalf("invalid first page of scroll: %d %+v", status, first)
+	}
+
+	// The pages are read from the results ranked when the scroll started, so
+	// deleting the document does not change the remaining pages.
+	doDelete(t, testServer, []string{"doc_id_1"})
+
+	status, second := scrollPage(t, testServer.URL, first.Cursor)
+	if status != http.StatusOK || len(second.References) != 1 || second.Total != 2 || second.Cursor != "" {
+		t.Fatalf("invalid second page of scroll: %d %+v", status, second)
+	}
+	if first.References[0].Id != all.References[0].Id || second.References[0].Id != all.References[1].Id {
+		t.Fatalf("scroll pages %+v %+v do not match the results %+v", first, second, all)
+	}
+
+	// A page can be retried with the same cursor.
+	status, retried := scrollPage(t, testServer.URL, first.Cursor)
+	if status != http.StatusOK || len(retried.References) != 1 || retried.References[0].Id != second.References[0].Id {
+		t.Fatalf("retrying a cursor should return the same page: %d %+v", status, retried)
+	}
+
+	if status, _ := scrollPage(t, testServer.URL, "invalid"); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid cursor, got %d", status)
+	}
+	if status, _ := scrollPage(t, testServer.URL, uuid.NewString()+":1"); status != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown scroll, got %d", status)
+	}
+
+	query := map[string]interface{}{"query": "test line", "top_k": 1, "scroll": map[string]interface{}{"max_results": 100000}}
+	if status, _ := queryStatus(t, testServer.URL, query); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for invalid scroll options, got %d", status)
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+// metricValue returns the value of the metric from the metrics endpoint of the
+// server. The metrics are shared by all routers in the process, so tests check
+// the change in the value rather than the value itself.
+func metricValue(t *testing.T, testServer *httptest.Server, metric string) float64 {
+	resp, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to get /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), metric+" "); found {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("invalid value for metric %v: %v", metric, value)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestShadowQueries(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	shadowId := uuid.New()
+
+	type shadowRequest struct {
+		path, authorization string
+		query               deployment.SearchRequest
+	}
+
+	var mu sync.Mutex
+	var received []shadowRequest
+	shadowStatus := http.StatusOK
+
+	setShadowStatus := func(status int) {
+		mu.Lock()
+		defer mu.Unlock()
+		shadowStatus = status
+	}
+	receivedRequests := func() []shadowRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(received)
+	}
+
+	shadowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var req deployment.SearchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, shadowRequest{path: r.URL.Path, authorization: r.Header.Get("Authorization"), query: req})
+
+		if shadowStatus != http.StatusOK {
+			http.Error(w, "shadow error", shadowStatus)
+			return
+		}
+		// Only one of the results of the primary is returned, with a different id
+		// since ids are not compared.
+		_ = json.NewEncoder(w).Encode(deployment.SearchResults{References: []deployment.SearchResult{
+			{Id: 10, Text: "test line one", Source: "doc_name_1"},
+			{Id: 11, Text: "a different line", Source: "doc_name_1"},
+		}})
+	}))
+	defer shadowServer.Close()
+
+	router.Shadow = deployment.NewShadow(shadowServer.URL, config.ShadowOptions{ModelId: shadowId, Percentage: 100})
+
+	query := func() {
+		body, _ := json.Marshal(map[string]interface{}{"query": "test line one", "top_k": 2})
+		req, _ := http.NewRequest("POST", testServer.URL+"/query", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer user-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post /query: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		router.Shadow.Wait()
+	}
+
+	succeeded := `ndb_shadow_requests_total{outcome="success"}`
+	failed := `ndb_shadow_requests_total{outcome="error"}`
+
+	succeededBefore := metricValue(t, testServer, succeeded)
+	matchesBefore := metricValue(t, testServer, "ndb_shadow_top1_matches_total")
+	overlapBefore := metricValue(t, testServer, "ndb_shadow_overlap_sum")
+
+	query()
+
+	requests := receivedRequests()
+	if len(requests) != 1 {
+		t.Fatalf("query should be mirrored to the shadow, received %d queries", len(requests))
+	}
+	if requests[0].path != fmt.Sprintf("/%v/query", shadowId) || requests[0].authorization != "Bearer user-token" {
+		t.Fatalf("invalid shadow request: %+v", requests[0])
+	}
+	if requests[0].query.Query != "test line one" || requests[0].query.Topk != 2 {
+		t.Fatalf("shadow should receive the same query: %+v", requests[0].query)
+	}
+
+	if v := metricValue(t, testServer, succeeded) - succeededBefore; v != 1 {
+		t.Fatalf("expected 1 successful shadow query, found %v", v)
+	}
+	if v := metricValue(t, testServer, "ndb_shadow_top1_matches_total") - matchesBefore; v != 1 {
+		t.Fatalf("top results should match, found %v matches", v)
+	}
+	if v := metricValue(t, testServer, "ndb_shadow_overlap_sum") - overlapBefore; v != 0.5 {
+		t.Fatalf("half of the results should overlap, found %v", v)
+	}
+
+	// Errors from the shadow are counted, and do not affect the query.
+	setShadowStatus(http.StatusForbidden)
+
+	failedBefore := metricValue(t, testServer, failed)
+	query()
+	if v := metricValue(t, testServer, failed) - failedBefore; v != 1 {
+		t.Fatalf("expected 1 failed shadow query, found %v", v)
+	}
+}
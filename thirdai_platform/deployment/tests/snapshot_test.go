@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/search/ndb"
+
+	"github.com/google/uuid"
+)
+
+func doSnapshotRequest(t *testing.T, method, url string, body interface{}) (int, []byte) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send snapshot request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data.Bytes()
+}
+
+func ndbSourceIds(t *testing.T, db ndb.NeuralDB) []string {
+	sources, err := db.Sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(sources))
+	for _, source := range sources {
+		ids = append(ids, source.DocId)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func restoreSnapshot(t *testing.T, testServer *httptest.Server, name string) {
+	if status, body := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots/"+name+"/restore", nil); status != http.StatusOK {
+		t.Fatalf("failed to restore snapshot: %d %s", status, body)
+	}
+}
+
+func TestNdbSnapshots(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+		QueryCache:          &config.QueryCacheOptions{MaxEntries: 10},
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+	router.QueryCache = deployment.NewQueryCache(*cfg.QueryCache)
+
+	status, body := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots", map[string]string{"name": "before-batch"})
+	if status != http.StatusOK {
+		t.Fatalf("failed to create snapshot: %d %s", status, body)
+	}
+	var created deployment.SnapshotInfo
+	if err := json.Unmarshal(body, &created); err != nil || created.Name != "before-batch" || created.CreatedAt.IsZero() {
+		t.Fatalf("invalid snapshot %s: %v", body, err)
+	}
+
+	if status, _ := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots", map[string]string{"name": "before-batch"}); status != http.StatusConflict {
+		t.Fatalf("duplicate snapshot names should be rejected, got %d", status)
+	}
+	if status, _ := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots", map[string]string{"name": "../model"}); status != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid snapshot names should be rejected, got %d", status)
+	}
+
+	doInsert(t, testServer)
+	doDelete(t, testServer, []string{"doc_id_1"})
+	if ids := ndbSourceIds(t, router.Ndb); !slices.Equal(ids, []string{"doc_id_2"}) {
+		t.Fatalf("unexpected sources before restore: %v", ids)
+	}
+	query := map[string]interface{}{"query": "a new word", "top_k": 2}
+	if status, results := queryStatus(t, testServer.URL, query); status != http.StatusOK || len(results.References) == 0 {
+		t.Fatalf("inserted document should be returned: %d %v", status, results)
+	}
+
+	restoreSnapshot(t, testServer, "before-batch")
+	if ids := ndbSourceIds(t, router.Ndb); !slices.Equal(ids, []string{"doc_id_1"}) {
+		t.Fatalf("unexpected sources after restore: %v", ids)
+	}
+	// The cached results from before the restore should not be returned.
+	if status, results := queryStatus(t, testServer.URL, query); status != http.StatusOK || slices.ContainsFunc(results.References, func(r deployment.SearchResult) bool { return r.SourceId == "doc_id_2" }) {
+		t.Fatalf("restored ndb should not return the inserted document: %d %v", status, results)
+	}
+	checkQuery(t, testServer, "test line", []int{0, 1})
+
+	// Changes after a restore do not modify the snapshot, so it can be restored
+	// again.
+	doInsert(t, testServer)
+	restoreSnapshot(t, testServer, "before-batch")
+	if ids := ndbSourceIds(t, router.Ndb); !slices.Equal(ids, []string{"doc_id_1"}) {
+		t.Fatalf("unexpected sources after second restore: %v", ids)
+	}
+
+	status, body = doSnapshotRequest(t, "GET", testServer.URL+"/snapshots", nil)
+	var list deployment.SnapshotList
+	if status != http.StatusOK || json.Unmarshal(body, &list) != nil || len(list.Snapshots) != 1 || list.Snapshots[0].Name != "before-batch" {
+		t.Fatalf("invalid snapshot list: %d %s", status, body)
+	}
+
+	if status, _ := doSnapshotRequest(t, "DELETE", testServer.URL+"/snapshots/before-batch", nil); status != http.StatusOK {
+		t.Fatalf("failed to delete snapshot, got %d", status)
+	}
+	if status, _ := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots/before-batch/restore", nil); status != http.StatusNotFound {
+		t.Fatalf("deleted snapshot should not be restored, got %d", status)
+	}
+
+	// The restored ndb is loaded if the deployment restarts. The ndb is opened
+	// through a symlink since it was already opened with its path in this process.
+	router.Close()
+	alias := filepath.Join(t.TempDir(), "model.ndb")
+	if err := os.Symlink(filepath.Join(cfg.ModelBazaarDir, "models", cfg.ModelId.String(), "model", "model.ndb"), alias); err != nil {
+		t.Fatal(err)
+	}
+	db, err := ndb.New(alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Free()
+	if ids := ndbSourceIds(t, db); !slices.Equal(ids, []string{"doc_id_1"}) {
+		t.Fatalf("restored ndb should be saved to the model path: %v", ids)
+	}
+}
+
+func TestNdbSnapshotsWithTeamIsolation(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+		TeamIsolation:       true,
+	}
+	testServer, _ := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	if status, _ := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots", map[string]string{"name": "snapshot"}); status != http.StatusUnprocessableEntity {
+		t.Fatalf("snapshots should not be supported with team isolation, got %d", status)
+	}
+}
+
+func TestNdbSnapshotRestoreWithAutoscaling(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+		Autoscaling:         true,
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	if status, body := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots", map[string]string{"name": "snapshot"}); status != http.StatusOK {
+		t.Fatalf("failed to create snapshot: %d %s", status, body)
+	}
+	doInsert(t, testServer)
+
+	if status, _ := doSnapshotRequest(t, "POST", testServer.URL+"/snapshots/snapshot/restore", nil); status != http.StatusUnprocessableEntity {
+		t.Fatalf("snapshots should not be restored for deployments that can have multiple replicas, got %d", status)
+	}
+	if ids := ndbSourceIds(t, router.Ndb); !slices.Equal(ids, []string{"doc_id_1", "doc_id_2"}) {
+		t.Fatalf("ndb should not be changed by a rejected restore: %v", ids)
+	}
+}
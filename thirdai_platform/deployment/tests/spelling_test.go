@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+
+	"github.com/google/uuid"
+)
+
+func TestSpellCheckerCorrect(t *testing.T) {
+	dir := t.TempDir()
+	checker := deployment.NewSpellChecker(dir, "model", config.SpellCorrectionOptions{Aggressiveness: config.SpellCorrectionMedium})
+	checker.AddChunks([]string{
+		"Quarterly revenue report", "revenue forecast for the quarter", "the revenue team", "review of the forecast",
+	})
+
+	corrected, suggestions := checker.Correct("Revnue forcast")
+	if corrected != "Revenue forecast" || len(suggestions) == 0 || suggestions[0] != corrected {
+		t.Fatalf("invalid correction: %q %v", corrected, suggestions)
+	}
+
+	if corrected, _ := checker.Correct("revenue forecast"); corrected != "" {
+		t.Fatalf("known words should not be corrected: %q", corrected)
+	}
+
+	// Short words and words with digits are not corrected.
+	if corrected, _ := checker.Correct("fo q3 revnue2"); corrected != "" {
+		t.Fatalf("short words and words with digits should not be corrected: %q", corrected)
+	}
+
+	// Results are only counted once per chunk.
+	checker.AddResults([]deployment.SearchResult{{Id: 1, Text: "analytics"}, {Id: 1, Text: "analytics"}})
+
+	if err := checker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := deployment.NewSpellChecker(dir, "model", config.SpellCorrectionOptions{Aggressiveness: config.SpellCorrectionMedium})
+	if corrected, _ := reloaded.Correct("forcast analytcs"); corrected != "forecast analytics" {
+		t.Fatalf("vocabulary should be loaded from the model directory: %q", corrected)
+	}
+	reloaded.AddResults([]deployment.SearchResult{{Id: 1, Text: "analytcs"}})
+	if corrected, _ := reloaded.Correct("analytcs"); corrected != "analytics" {
+		t.Fatalf("results of saved chunk ids should not be added again: %q", corrected)
+	}
+}
+
+func TestSpellCheckerAggressiveness(t *testing.T) {
+	chunks := []string{"search the index", "searching documents", "documents in the index"}
+	for i := 0; i < 40; i++ {
+		chunks = append(chunks, "documents")
+	}
+	chunks = append(chunks, "docments")
+
+	check := func(aggressiveness, query, expected string) {
+		checker := deployment.NewSpellChecker(t.TempDir(), "model", config.SpellCorrectionOptions{Aggressiveness: aggressiveness})
+		checker.AddChunks(chunks)
+		if corrected, _ := checker.Correct(query); corrected != expected {
+			t.Fatalf("%v: expected %q to be corrected to %q, got %q", aggressiveness, query, expected, corrected)
+		}
+	}
+
+	check(config.SpellCorrectionLow, "indx", "index")
+	check(config.SpellCorrectionLow, "serch", "search")
+	check(config.SpellCorrectionLow, "serchng", "")
+	check(config.SpellCorrectionMedium, "serchng", "searching")
+	check(config.SpellCorrectionMedium, "serhc", "")
+	check(config.SpellCorrectionHigh, "serhc", "search")
+
+	// Only high aggressiveness corrects rare words that are in the vocabulary.
+	check(config.SpellCorrectionMedium, "docments", "")
+	check(config.SpellCorrectionHigh, "docments", "documents")
+}
+
+func querySpelling(t *testing.T, testServer *httptest.Server, body map[string]interface{}) deployment.SearchResults {
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := http.Post(testServer.URL+"/query", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("failed to post /query: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	var res deployment.SearchResults
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode /query response: %v", err)
+	}
+	return res
+}
+
+func TestSpellCorrectionEndpoints(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+	}
+	testServer, router := makeNdbServer(t, cfg)
+	defer testServer.Close()
+
+	router.Spelling = deployment.NewSpellChecker(cfg.ModelBazaarDir, cfg.ModelId.String(), config.SpellCorrectionOptions{Aggressiveness: config.SpellCorrectionMedium})
+
+	doInsert(t, testServer)
+
+	res := querySpelling(t, testServer, map[string]interface{}{"query": "anoter", "top_k": 5})
+	if res.CorrectedQuery != "" || !slices.Equal(res.DidYouMean, []string{"another"}) {
+		t.Fatalf("expected did you mean suggestion for inserted chunks: %+v", res)
+	}
+
+	res = querySpelling(t, testServer, map[string]interface{}{"query": "anoter", "top_k": 5, "spell_correction": false})
+	if res.CorrectedQuery != "" || len(res.DidYouMean) != 0 {
+		t.Fatalf("spell correction should be disabled for the request: %+v", res)
+	}
+
+	router.Spelling = deployment.NewSpellChecker(cfg.ModelBazaarDir, cfg.ModelId.String(), config.SpellCorrectionOptions{Aggressiveness: config.SpellCorrectionMedium, AutoCorrect: true})
+
+	// The vocabulary is also built from the chunks returned by queries.
+	querySpelling(t, testServer, map[string]interface{}{"query": "test line", "top_k": 5})
+
+	res = querySpelling(t, testServer, map[string]interface{}{"query": "tset lnie", "top_k": 5})
+	if res.CorrectedQuery != "test line" || len(res.References) == 0 {
+		t.Fatalf("query should be auto corrected: %+v", res)
+	}
+	for _, suggestion := range res.DidYouMean {
+		if suggestion == res.CorrectedQuery {
+			t.Fatalf("corrected query should not be repeated in did you mean: %+v", res)
+		}
+	}
+}
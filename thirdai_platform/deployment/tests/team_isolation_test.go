@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/search/ndb"
+
+	"github.com/google/uuid"
+)
+
+// TeamPermissions adds the permissions of the user with the token to the
+// request context, like the permission check of the deployment does.
+type TeamPermissions struct {
+	MockPermissions
+	Users map[string]services.ModelPermissions
+}
+
+func (m *TeamPermissions) ModelPermissionsCheck(permission_type deployment.PermissionType) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			perms, ok := m.Users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				http.Error(w, "unknown user", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(deployment.WithModelPermissions(r.Context(), perms)))
+		})
+	}
+}
+
+func teamRequest(t *testing.T, method, url, token, team string, body interface{}, res interface{}) int {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if team != "" {
+		req.Header.Set(deployment.TeamIdHeader, team)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestTeamIsolation(t *testing.T) {
+	verifyTestLicense()
+
+	teamA, teamB, teamC := uuid.New(), uuid.New(), uuid.New()
+
+	permissions := &TeamPermissions{Users: map[string]services.ModelPermissions{
+		"a":     {Read: true, Write: true, TeamIds: []uuid.UUID{teamA}},
+		"b":     {Read: true, Write: true, TeamIds: []uuid.UUID{teamB}},
+		"ab":    {Read: true, Write: true, TeamIds: []uuid.UUID{teamA, teamB}},
+		"none":  {Read: true, Write: true},
+		"admin": {Read: true, Write: true, Admin: true},
+	}}
+
+	cfg := &config.DeployConfig{ModelId: uuid.New(), ModelBazaarDir: t.TempDir(), TeamIsolation: true}
+	db, err := ndb.New(filepath.Join(t.TempDir(), "model.ndb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := deployment.NdbRouter{Ndb: db, Config: cfg, Permissions: permissions}
+	testServer := httptest.NewServer(router.Routes())
+	defer testServer.Close()
+	defer router.Close()
+
+	insert := func(token, team, chunk string) {
+		body := deployment.InsertRequest{Document: "doc.txt", DocId: "doc", Chunks: []string{chunk}}
+		if status := teamRequest(t, "POST", testServer.URL+"/insert", token, team, body, nil); status != http.StatusOK {
+			t.Fatalf("insert failed with status %d", status)
+		}
+	}
+
+	// Both teams use the same doc id without replacing each other's document.
+	insert("a", "", "apple pie recipe")
+	insert("b", "", "apple tart recipe")
+
+	query := func(token, team string, body map[string]interface{}) (int, deployment.SearchResults) {
+		var res deployment.SearchResults
+		status := teamRequest(t, "POST", testServer.URL+"/query", token, team, body, &res)
+		return status, res
+	}
+
+	status, res := query("a", "", map[string]interface{}{"query": "apple recipe", "top_k": 5})
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text != "apple pie recipe" || res.References[0].SourceId != "doc" {
+		t.Fatalf("invalid results for team a: %d %+v", status, res)
+	}
+
+	// Constraints on the team key cannot select the documents of other teams.
+	status, res = query("a", "", map[string]interface{}{
+		"query": "apple recipe", "top_k": 5,
+		"constraints": map[string]interface{}{deployment.TeamMetadataKey: map[string]interface{}{"op": "eq", "value": teamB.String()}},
+	})
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text != "apple pie recipe" {
+		t.Fatalf("invalid results with team constraint: %d %+v", status, res)
+	}
+
+	// Users in several teams select the team with the header.
+	if status, _ := query("ab", "", map[string]interface{}{"query": "apple", "top_k": 5}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without team header, got %d", status)
+	}
+	status, res = query("ab", teamB.String(), map[string]interface{}{"query": "apple recipe", "top_k": 5})
+	if status != http.StatusOK || len(res.References) != 1 || res.References[0].Text != "apple tart recipe" {
+		t.Fatalf("invalid results for selected team: %d %+v", status, res)
+	}
+	if status, _ := query("a", teamB.String(), map[string]interface{}{"query": "apple", "top_k": 5}); status != http.StatusForbidden {
+		t.Fatalf("expected status 403 for team of other users, got %d", status)
+	}
+	if status, _ := query("a", "xyz", map[string]interface{}{"query": "apple", "top_k": 5}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid team header, got %d", status)
+	}
+	if status, _ := query("none", "", map[string]interface{}{"query": "apple", "top_k": 5}); status != http.StatusForbidden {
+		t.Fatalf("expected status 403 for user without teams, got %d", status)
+	}
+	status, res = query("admin", teamC.String(), map[string]interface{}{"query": "apple recipe", "top_k": 5})
+	if status != http.StatusOK || len(res.References) != 0 {
+		t.Fatalf("invalid results for admin: %d %+v", status, res)
+	}
+
+	// Scrolls can only be continued by the team that started them.
+	insert("a", "", "apple crumble recipe")
+	status, res = query("a", "", map[string]interface{}{"query": "apple recipe", "top_k": 1, "scroll": map[string]interface{}{}})
+	if status != http.StatusOK || res.Cursor == "" {
+		t.Fatalf("invalid first page of scroll: %d %+v", status, res)
+	}
+	scroll := map[string]string{"cursor": res.Cursor}
+	if status := teamRequest(t, "POST", testServer.URL+"/query/scroll", "b", "", scroll, nil); status != http.StatusNotFound {
+		t.Fatalf("expected status 404 for scroll of other team, got %d", status)
+	}
+	if status := teamRequest(t, "POST", testServer.URL+"/query/scroll", "a", "", scroll, nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 for scroll of team, got %d", status)
+	}
+
+	var sources deployment.Sources
+	if status := teamRequest(t, "GET", testServer.URL+"/sources", "b", "", nil, &sources); status != http.StatusOK {
+		t.Fatalf("sources failed with status %d", status)
+	}
+	if len(sources.Sources) != 1 || sources.Sources[0].SourceID != "doc" {
+		t.Fatalf("invalid sources for team b: %+v", sources)
+	}
+
+	// Deleting a document only deletes the document of the team.
+	if status := teamRequest(t, "POST", testServer.URL+"/delete", "a", "", map[string]interface{}{"source_ids": []string{"doc"}}, nil); status != http.StatusOK {
+		t.Fatalf("delete failed with status %d", status)
+	}
+	if status, res := query("a", "", map[string]interface{}{"query": "apple recipe", "top_k": 5}); status != http.StatusOK || len(res.References) != 0 {
+		t.Fatalf("expected no results after delete: %d %+v", status, res)
+	}
+	if status, res := query("b", "", map[string]interface{}{"query": "apple recipe", "top_k": 5}); status != http.StatusOK || len(res.References) != 1 {
+		t.Fatalf("expected document of other team to remain: %d %+v", status, res)
+	}
+
+	upvote := map[string]interface{}{"text_id_pairs": []map[string]interface{}{{"query_text": "apple", "reference_id": 0}}}
+	if status := teamRequest(t, "POST", testServer.URL+"/upvote", "b", "", upvote, nil); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 for upvote, got %d", status)
+	}
+}
+
+func TestTeamIsolationDisablesLLMCache(t *testing.T) {
+	verifyTestLicense()
+
+	cfg := &config.DeployConfig{ModelId: uuid.New(), ModelBazaarDir: t.TempDir(), TeamIsolation: true}
+	db, err := ndb.New(filepath.Join(t.TempDir(), "model.ndb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := deployment.NewLLMCache(cfg.ModelBazaarDir, cfg.ModelId.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Insert("apple pie recipe", "bake the apples", []uint64{0}, []string{"doc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	permissions := &TeamPermissions{Users: map[string]services.ModelPermissions{
+		"a": {Read: true, Write: true, TeamIds: []uuid.UUID{uuid.New()}},
+	}}
+	// The cache is shared by all teams, so it is not used even if the router has one.
+	router := deployment.NdbRouter{Ndb: db, Config: cfg, Permissions: permissions, LLMCache: cache}
+	testServer := httptest.NewServer(router.Routes())
+	defer testServer.Close()
+	defer router.Close()
+
+	suggestions := map[string]string{"query": "apple"}
+	if status := teamRequest(t, "POST", testServer.URL+"/cache-suggestions", "a", "", suggestions, nil); status != http.StatusNotFound {
+		t.Fatalf("cache suggestions should not be available with team isolation, got status %d", status)
+	}
+	invalidate := map[string][]string{"source_ids": {"doc"}}
+	if status := teamRequest(t, "POST", testServer.URL+"/cache-invalidate", "a", "", invalidate, nil); status != http.StatusNotFound {
+		t.Fatalf("cache invalidation should not be available with team isolation, got status %d", status)
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"thirdai_platform/deployment"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/search/ndb"
+
+	"github.com/google/uuid"
+)
+
+func TestNdbPreloadAndWarmup(t *testing.T) {
+	err := verifyTestLicense()
+	if err != nil {
+		t.Fatalf("license error: %v", err)
+	}
+
+	cfg := &config.DeployConfig{
+		ModelId:             uuid.New(),
+		ModelBazaarDir:      t.TempDir(),
+		ModelBazaarEndpoint: "http://localhost:8080",
+		NdbLoad: &config.NdbLoadOptions{
+			LoadMode:      config.NdbLoadPreload,
+			WarmupQueries: []string{"test line", "something"},
+		},
+	}
+
+	db, err := ndb.New(filepath.Join(t.TempDir(), "model.ndb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Free()
+	if err := db.Insert("doc_name_1", "doc_id_1", []string{"test line one", "something else"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Save(filepath.Join(cfg.ModelBazaarDir, "models", cfg.ModelId.String(), "model", "model.ndb")); err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := deployment.NewNdbRouter(cfg, deployment.Reporter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	if n := router.Warmup(); n != 2 {
+		t.Fatalf("expected 2 warmup queries to run, got %d", n)
+	}
+
+	chunks, err := router.Ndb.Query("test line", 1, nil)
+	if err != nil || len(chunks) != 1 || chunks[0].Text != "test line one" {
+		t.Fatalf("ndb should be queryable after preloading: %v %v", chunks, err)
+	}
+
+	cfg.NdbLoad = nil
+	if n := router.Warmup(); n != 0 {
+		t.Fatalf("no warmup queries should run without load options, got %d", n)
+	}
+}
+
+func TestNdbLoadOptionsValidation(t *testing.T) {
+	opts := config.NdbLoadOptions{}
+	if err := opts.Validate(); err != nil || opts.LoadMode != config.NdbLoadOnDemand || opts.TopK() != 10 {
+		t.Fatalf("invalid defaults: %v %v", opts, err)
+	}
+
+	opts = config.NdbLoadOptions{LoadMode: "mlock"}
+	if err := opts.Validate(); err == nil {
+		t.Fatal("invalid load mode should be rejected")
+	}
+}
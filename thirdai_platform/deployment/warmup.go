@@ -0,0 +1,73 @@
+package deployment
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"thirdai_platform/utils/logging"
+	"time"
+)
+
+// preloadNdb reads every file of the ndb so that it is in the OS page cache
+// before the ndb is opened. The storage engine otherwise reads index files from
+// disk on demand, which makes the first queries after startup slow for large
+// indexes. Returns the number of bytes read.
+func preloadNdb(ndbPath string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(ndbPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		n, err := io.Copy(io.Discard, file)
+		total += n
+		return err
+	})
+	return total, err
+}
+
+// Warmup runs the configured warmup queries against the ndb and returns the
+// number of queries that succeeded. It is intended to be run in the background
+// after the deployment starts so that startup is not delayed.
+func (s *NdbRouter) Warmup() int {
+	if s.Config == nil || s.Config.NdbLoad == nil || len(s.Config.NdbLoad.WarmupQueries) == 0 {
+		return 0
+	}
+	opts := s.Config.NdbLoad
+
+	slog.Info("starting ndb warmup", "n_queries", len(opts.WarmupQueries), "code", logging.MODEL_INIT)
+	start := time.Now()
+
+	succeeded := 0
+	for _, query := range opts.WarmupQueries {
+		s.ndbLock.RLock()
+		chunks, err := s.Ndb.Query(query, opts.TopK(), nil)
+		s.ndbLock.RUnlock()
+		if err != nil {
+			slog.Warn("ndb warmup query failed", "query", query, "error", err, "code", logging.MODEL_INIT)
+			continue
+		}
+		succeeded++
+
+		// The results of the warmup queries also seed the spelling vocabulary.
+		if s.Spelling != nil {
+			results := make([]SearchResult, len(chunks))
+			for i, chunk := range chunks {
+				results[i] = SearchResult{Id: int(chunk.Id), Text: chunk.Text}
+			}
+			s.Spelling.AddResults(results)
+		}
+	}
+
+	slog.Info("completed ndb warmup", "n_queries", succeeded, "duration", time.Since(start).String(), "code", logging.MODEL_INIT)
+
+	return succeeded
+}
@@ -1,7 +1,10 @@
 package integrationtests
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"thirdai_platform/client"
@@ -84,6 +87,43 @@ func checkReports(t *testing.T, ke *client.KnowledgeExtractionClient, expectedAn
 	return reportId
 }
 
+func checkReportExport(t *testing.T, ke *client.KnowledgeExtractionClient, reportId string, nQuestions int) {
+	dir := t.TempDir()
+
+	jsonPath := filepath.Join(dir, "report.json")
+	if err := ke.ExportReport(reportId, "json", jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported struct {
+		Results []struct {
+			Question string `json:"question"`
+			Sources  []struct {
+				Source string `json:"source"`
+			} `json:"sources"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Results) != nQuestions || len(exported.Results[0].Sources) == 0 {
+		t.Fatalf("invalid exported report: %v", exported)
+	}
+
+	for _, format := range []string{"docx", "pdf"} {
+		path := filepath.Join(dir, "report."+format)
+		if err := ke.ExportReport(reportId, format, path); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Fatalf("%v export should not be empty", format)
+		}
+	}
+}
+
 func TestKnowledgeExtraction(t *testing.T) {
 	c := getClient(t)
 
@@ -128,6 +168,8 @@ func TestKnowledgeExtraction(t *testing.T) {
 
 	reusedReportId := checkReports(t, ke, expectedAnswers, nil)
 
+	checkReportExport(t, ke, reusedReportId, len(expectedAnswers))
+
 	badReportId, err := ke.CreateReport([]client.FileInfo{
 		{Path: "./utils.go", Location: "upload"},
 	})
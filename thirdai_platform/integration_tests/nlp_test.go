@@ -86,6 +86,15 @@ func TestNlpDocSupervised(t *testing.T) {
 	if result.PredictedClasses[0].Class != "positive" {
 		t.Fatalf("invalid predicted class %v", result.PredictedClasses)
 	}
+
+	explanation, err := model.Explain("The product exceeded my expectations!", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if explanation.ClassName != "positive" || len(explanation.Attributions) != 5 {
+		t.Fatalf("invalid explanation %v", explanation)
+	}
 }
 
 func TestNlpTokenDatagen(t *testing.T) {
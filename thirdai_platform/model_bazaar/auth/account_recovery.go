@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidToken       = errors.New("token is invalid or has expired")
+	ErrEmailNotConfigured = errors.New("unable to send emails, smtp is not configured")
+)
+
+const (
+	passwordResetExpiration     = time.Hour
+	emailVerificationExpiration = 24 * time.Hour
+)
+
+// AccountRecoveryProvider is implemented by identity providers that store the
+// passwords of users. Keycloak and OIDC providers have their own flows for
+// resetting passwords and verifying emails.
+type AccountRecoveryProvider interface {
+	// SendPasswordReset emails a password reset link to the user with the
+	// email. It does not return an error if there is no user with the email,
+	// so that it cannot be used to check which emails have accounts.
+	SendPasswordReset(email string) error
+
+	ResetPassword(token, password string) error
+
+	// SendEmailVerification emails a verification link to the user with the
+	// email. Like SendPasswordReset, it does not return an error if there is no
+	// user with the email or if the email is already verified, so that users
+	// who cannot log in can request it.
+	SendEmailVerification(email string) error
+
+	VerifyEmail(token string) error
+}
+
+var accountEmailTemplate = template.Must(template.New("account_email").Parse(`<html>
+<body style="font-family: sans-serif;">
+<p>Hi {{.Username}},</p>
+<p>{{.Text}}</p>
+<p><a href="{{.Link}}">{{.Action}}</a></p>
+<p>This link expires in {{.Expiration}}. If you did not request this email you can ignore it.</p>
+</body>
+</html>`))
+
+type accountEmail struct {
+	Username   string
+	Text       string
+	Action     string
+	Link       string
+	Expiration string
+}
+
+func hashUserToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// createUserToken replaces the existing tokens of the user for the purpose
+// with a new token, so that only the most recent email can be used.
+func createUserToken(txn *gorm.DB, userId uuid.UUID, purpose string, expiration time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := txn.Delete(&schema.UserToken{}, "user_id = ? AND purpose = ?", userId, purpose).Error; err != nil {
+		slog.Error("sql error deleting previous user tokens", "user_id", userId, "purpose", purpose, "error", err)
+		return "", schema.ErrDbAccessFailed
+	}
+
+	now := time.Now().UTC()
+	userToken := schema.UserToken{
+		TokenHash: hashUserToken(token),
+		UserId:    userId,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(expiration),
+		CreatedAt: now,
+	}
+	if err := txn.Create(&userToken).Error; err != nil {
+		slog.Error("sql error creating user token", "user_id", userId, "purpose", purpose, "error", err)
+		return "", schema.ErrDbAccessFailed
+	}
+
+	return token, nil
+}
+
+// useUserToken deletes the token and returns the user it was created for. The
+// token is looked up and deleted in the same statement, so that concurrent
+// requests with the same token cannot both use it.
+func useUserToken(txn *gorm.DB, token, purpose string) (schema.User, error) {
+	var used []schema.UserToken
+	result := txn.Clauses(clause.Returning{}).Where("token_hash = ? AND purpose = ?", hashUserToken(token), purpose).Delete(&used)
+	if result.Error != nil {
+		slog.Error("sql error using user token", "purpose", purpose, "error", result.Error)
+		return schema.User{}, schema.ErrDbAccessFailed
+	}
+	if len(used) == 0 || used[0].ExpiresAt.Before(time.Now()) {
+		return schema.User{}, ErrInvalidToken
+	}
+	userToken := used[0]
+
+	if err := txn.Delete(&schema.UserToken{}, "user_id = ? AND purpose = ?", userToken.UserId, purpose).Error; err != nil {
+		slog.Error("sql error deleting used user tokens", "user_id", userToken.UserId, "purpose", purpose, "error", err)
+		return schema.User{}, schema.ErrDbAccessFailed
+	}
+
+	user, err := schema.GetUser(userToken.UserId, txn)
+	if err != nil {
+		if errors.Is(err, schema.ErrUserNotFound) {
+			return schema.User{}, ErrInvalidToken
+		}
+		return schema.User{}, err
+	}
+	return user, nil
+}
+
+func (auth *BasicIdentityProvider) sendAccountEmail(user schema.User, subject, path, token string, expiration time.Duration, content accountEmail) error {
+	content.Username = user.Username
+	content.Link = fmt.Sprintf("%v/%v?token=%v", auth.publicUrl, path, url.QueryEscape(token))
+	content.Expiration = expiration.String()
+
+	var body bytes.Buffer
+	if err := accountEmailTemplate.Execute(&body, content); err != nil {
+		return fmt.Errorf("error rendering email: %w", err)
+	}
+
+	return auth.mailer.Send(mail.Message{To: []string{user.Email}, Subject: subject, Html: body.String()})
+}
+
+func (auth *BasicIdentityProvider) SendPasswordReset(email string) error {
+	if auth.mailer == nil {
+		return ErrEmailNotConfigured
+	}
+
+	var user schema.User
+	var token string
+	err := auth.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Limit(1).Find(&user, "email = ?", email)
+		if result.Error != nil {
+			slog.Error("sql error looking up user by email", "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		var err error
+		token, err = createUserToken(txn, user.Id, schema.PasswordResetToken, passwordResetExpiration)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		slog.Info("password reset requested for unknown email")
+		return nil
+	}
+
+	err = auth.sendAccountEmail(user, "Reset your password", "reset-password", token, passwordResetExpiration, accountEmail{
+		Text:   "We received a request to reset the password of your ThirdAI Platform account.",
+		Action: "Reset password",
+	})
+	if err != nil {
+		return fmt.Errorf("error sending password reset email: %w", err)
+	}
+
+	slog.Info("sent password reset email", "user_id", user.Id)
+	return nil
+}
+
+// ResetPassword also unlocks the account and verifies the email of the user,
+// since the token could only have been received at their email.
+func (auth *BasicIdentityProvider) ResetPassword(token, password string) error {
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return fmt.Errorf("error encrypting password: %w", err)
+	}
+
+	return auth.db.Transaction(func(txn *gorm.DB) error {
+		user, err := useUserToken(txn, token, schema.PasswordResetToken)
+		if err != nil {
+			return err
+		}
+
+		result := txn.Model(&user).Updates(map[string]interface{}{
+			"password": hashedPwd, "email_verified": true, "failed_logins": 0, "locked_until": nil,
+		})
+		if result.Error != nil {
+			slog.Error("sql error resetting password", "user_id", user.Id, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+
+		slog.Info("reset user password", "user_id", user.Id)
+		return nil
+	})
+}
+
+func (auth *BasicIdentityProvider) SendEmailVerification(email string) error {
+	if auth.mailer == nil {
+		return ErrEmailNotConfigured
+	}
+
+	var user schema.User
+	var token string
+	err := auth.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Limit(1).Find(&user, "email = ?", email)
+		if result.Error != nil {
+			slog.Error("sql error looking up user by email", "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 || user.EmailVerified {
+			return nil
+		}
+
+		var err error
+		token, err = createUserToken(txn, user.Id, schema.EmailVerificationToken, emailVerificationExpiration)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		slog.Info("email verification requested for unknown or verified email")
+		return nil
+	}
+
+	err = auth.sendAccountEmail(user, "Verify your email", "verify-email", token, emailVerificationExpiration, accountEmail{
+		Text:   "Please verify the email of your ThirdAI Platform account.",
+		Action: "Verify email",
+	})
+	if err != nil {
+		return fmt.Errorf("error sending verification email: %w", err)
+	}
+
+	slog.Info("sent email verification", "user_id", user.Id)
+	return nil
+}
+
+func (auth *BasicIdentityProvider) VerifyEmail(token string) error {
+	return auth.db.Transaction(func(txn *gorm.DB) error {
+		user, err := useUserToken(txn, token, schema.EmailVerificationToken)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Model(&user).Update("email_verified", true).Error; err != nil {
+			slog.Error("sql error verifying email", "user_id", user.Id, "error", err)
+			return schema.ErrDbAccessFailed
+		}
+
+		slog.Info("verified user email", "user_id", user.Id)
+		return nil
+	})
+}
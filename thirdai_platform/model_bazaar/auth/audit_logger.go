@@ -1,15 +1,21 @@
 package auth
 
 import (
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
 	"strings"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-func clientIp(r *http.Request) string {
+func ClientIp(r *http.Request) string {
 	// https://stackoverflow.com/questions/27234861/correct-way-of-getting-clients-ip-addresses-from-http-request
 	if ip := r.Header.Get("X-Real-Ip"); len(ip) > 0 {
 		return ip
@@ -57,13 +63,69 @@ func queryParams(r *http.Request) []interface{} {
 	return params
 }
 
+// AuditLogger writes each authenticated request to the stream, and if db is
+// not nil also records it in the audit log table so that it can be queried
+// through the api.
 type AuditLogger struct {
 	logger *slog.Logger
+	db     *gorm.DB
 }
 
-func NewAuditLogger(stream io.Writer) AuditLogger {
+func NewAuditLogger(stream io.Writer, db *gorm.DB) AuditLogger {
 	logger := slog.New(slog.NewJSONHandler(stream, nil))
-	return AuditLogger{logger: logger}
+	return AuditLogger{logger: logger, db: db}
+}
+
+func paramsJson(params map[string]string) string {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// record is called after the request is handled, since the route pattern and
+// path params are only known once the request has been routed.
+func (log *AuditLogger) record(r *http.Request, user schema.User, status int, timestamp time.Time) {
+	entry := schema.AuditLogEntry{
+		Timestamp: timestamp,
+		UserId:    user.Id,
+		Username:  user.Username,
+		Method:    r.Method,
+		Action:    r.Method + " " + r.URL.Path,
+		Url:       r.URL.Path,
+		Status:    status,
+		ClientIp:  ClientIp(r),
+		Protocol:  protocol(r),
+	}
+
+	pathParams := map[string]string{}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		// Requests that are rejected before they are fully routed, for instance by
+		// the admin check on a subrouter, only match the mount's wildcard pattern.
+		if pattern := rctx.RoutePattern(); pattern != "" && !strings.HasSuffix(pattern, "*") {
+			entry.Action = r.Method + " " + pattern
+		}
+		for i, key := range rctx.URLParams.Keys {
+			if key != "*" {
+				pathParams[key] = rctx.URLParams.Values[i]
+			}
+		}
+	}
+	if modelId, err := uuid.Parse(pathParams["model_id"]); err == nil {
+		entry.ModelId = &modelId
+	}
+	entry.PathParams = paramsJson(pathParams)
+
+	queryParams := map[string]string{}
+	for k, v := range r.URL.Query() {
+		queryParams[k] = strings.Join(v, ";")
+	}
+	entry.QueryParams = paramsJson(queryParams)
+
+	if err := log.db.Create(&entry).Error; err != nil {
+		slog.Error("sql error recording audit log entry", "action", entry.Action, "user_id", user.Id, "error", err)
+	}
 }
 
 func (log *AuditLogger) Middleware(next http.Handler) http.Handler {
@@ -77,7 +139,7 @@ func (log *AuditLogger) Middleware(next http.Handler) http.Handler {
 		log.logger.Info("",
 			"username", user.Username,
 			"user_id", user.Id,
-			"client_ip", clientIp(r),
+			"client_ip", ClientIp(r),
 			"protocol", protocol(r),
 			"method", r.Method,
 			"url", r.URL.Path,
@@ -85,7 +147,20 @@ func (log *AuditLogger) Middleware(next http.Handler) http.Handler {
 			slog.Group("query_params", queryParams(r)...),
 		)
 
-		next.ServeHTTP(w, r)
+		if log.db == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timestamp := time.Now().UTC()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.record(r, user, status, timestamp)
 	}
 	return http.HandlerFunc(handler)
 }
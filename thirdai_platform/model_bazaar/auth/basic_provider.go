@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
+	"thirdai_platform/model_bazaar/mail"
 	"thirdai_platform/model_bazaar/schema"
 	"time"
 
@@ -20,6 +22,11 @@ type BasicIdentityProvider struct {
 	jwtManager *JwtManager
 	db         *gorm.DB
 	auditLog   AuditLogger
+
+	mailer                   mail.Sender
+	publicUrl                string
+	requireEmailVerification bool
+	lockout                  LockoutPolicy
 }
 
 type BasicProviderArgs struct {
@@ -27,6 +34,23 @@ type BasicProviderArgs struct {
 	AdminUsername string
 	AdminEmail    string
 	AdminPassword string
+
+	// Mailer sends the password reset and email verification emails, users
+	// cannot reset their password if it is nil.
+	Mailer mail.Sender
+	// PublicUrl is the url of the platform that the links in emails use.
+	PublicUrl string
+	// RequireEmailVerification stops users that have not verified their email
+	// from logging in. Admins can always log in so they cannot be locked out.
+	RequireEmailVerification bool
+	Lockout                  LockoutPolicy
+}
+
+// LockoutPolicy locks an account for Duration after MaxFailures consecutive
+// failed logins. Accounts are never locked if MaxFailures is 0.
+type LockoutPolicy struct {
+	MaxFailures int
+	Duration    time.Duration
 }
 
 func NewBasicIdentityProvider(db *gorm.DB, auditLog AuditLogger, args BasicProviderArgs) (IdentityProvider, error) {
@@ -41,9 +65,13 @@ func NewBasicIdentityProvider(db *gorm.DB, auditLog AuditLogger, args BasicProvi
 	}
 
 	return &BasicIdentityProvider{
-		jwtManager: NewJwtManager(args.Secret),
-		db:         db,
-		auditLog:   auditLog,
+		jwtManager:               NewJwtManager(args.Secret),
+		db:                       db,
+		auditLog:                 auditLog,
+		mailer:                   args.Mailer,
+		publicUrl:                strings.TrimSuffix(args.PublicUrl, "/"),
+		requireEmailVerification: args.RequireEmailVerification,
+		lockout:                  args.Lockout,
 	}, nil
 }
 
@@ -100,11 +128,30 @@ func (auth *BasicIdentityProvider) LoginWithEmail(email, password string) (Login
 		return LoginResult{}, schema.ErrDbAccessFailed
 	}
 
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		return LoginResult{}, ErrAccountLocked
+	}
+
 	err := bcrypt.CompareHashAndPassword(user.Password, []byte(password))
 	if err != nil {
+		if err := auth.recordFailedLogin(user.Id); err != nil {
+			return LoginResult{}, err
+		}
 		return LoginResult{}, ErrInvalidCredentials
 	}
 
+	if auth.requireEmailVerification && !user.EmailVerified && !user.IsAdmin {
+		return LoginResult{}, ErrEmailNotVerified
+	}
+
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		result := auth.db.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+		if result.Error != nil {
+			slog.Error("sql error resetting failed logins", "user_id", user.Id, "error", result.Error)
+			return LoginResult{}, schema.ErrDbAccessFailed
+		}
+	}
+
 	token, err := auth.jwtManager.CreateUserJwt(user.Id)
 	if err != nil {
 		return LoginResult{}, ErrGeneratingJwt
@@ -153,10 +200,59 @@ func (auth *BasicIdentityProvider) CreateUser(username, email, password string)
 		return uuid.Nil, fmt.Errorf("error creating new user: %w", err)
 	}
 
+	if auth.mailer != nil {
+		// The user is created even if the email cannot be sent, since they can
+		// request another verification email.
+		if err := auth.SendEmailVerification(newUser.Email); err != nil {
+			slog.Error("error sending verification email to new user", "user_id", newUser.Id, "error", err)
+		}
+	}
+
 	return newUser.Id, nil
 }
 
+// recordFailedLogin locks the account of the user once they reach the maximum
+// number of consecutive failed logins.
+func (auth *BasicIdentityProvider) recordFailedLogin(userId uuid.UUID) error {
+	if auth.lockout.MaxFailures <= 0 {
+		return nil
+	}
+
+	return auth.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Model(&schema.User{}).Where("id = ?", userId).Update("failed_logins", gorm.Expr("failed_logins + 1"))
+		if result.Error != nil {
+			slog.Error("sql error recording failed login", "user_id", userId, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+
+		var user schema.User
+		if err := txn.First(&user, "id = ?", userId).Error; err != nil {
+			slog.Error("sql error loading failed logins", "user_id", userId, "error", err)
+			return schema.ErrDbAccessFailed
+		}
+		if user.FailedLogins < auth.lockout.MaxFailures {
+			return nil
+		}
+
+		slog.Warn("locking user after too many failed logins", "user_id", userId, "failures", user.FailedLogins, "duration", auth.lockout.Duration)
+		result = txn.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": time.Now().UTC().Add(auth.lockout.Duration)})
+		if result.Error != nil {
+			slog.Error("sql error locking user", "user_id", userId, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		return nil
+	})
+}
+
 func (auth *BasicIdentityProvider) VerifyUser(userId uuid.UUID) error {
+	result := auth.db.Model(&schema.User{}).Where("id = ?", userId).Update("email_verified", true)
+	if result.Error != nil {
+		slog.Error("sql error verifying user", "user_id", userId, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+	if result.RowsAffected == 0 {
+		return schema.ErrUserNotFound
+	}
 	return nil
 }
 
@@ -19,6 +19,8 @@ var (
 	ErrGeneratingJwt         = errors.New("error generating jwt")
 	ErrEmailAlreadyInUse     = errors.New("email is already in use")
 	ErrUsernameAlreadyInUse  = errors.New("username is already in use")
+	ErrAccountLocked         = errors.New("account is locked after too many failed logins, try again later or reset the password")
+	ErrEmailNotVerified      = errors.New("email is not verified")
 )
 
 type LoginResult struct {
@@ -50,6 +52,8 @@ func addInitialAdminToDb(db *gorm.DB, userId uuid.UUID, username, email string,
 		Username: username,
 		Email:    email,
 		IsAdmin:  true,
+
+		EmailVerified: true,
 	}
 	if password != nil {
 		user.Password = password
@@ -83,4 +87,5 @@ type requestContextKey string
 const (
 	UserRequestContextKey requestContextKey = "user"
 	ContextAPIKeyExpiry   requestContextKey = "api_key_expiry"
+	ContextAPIKeyId       requestContextKey = "api_key_id"
 )
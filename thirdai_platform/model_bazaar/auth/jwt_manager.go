@@ -29,8 +29,9 @@ func (m *JwtManager) Authenticator() func(http.Handler) http.Handler {
 }
 
 const (
-	userIdKey  = "user_id"
-	modelIdKey = "model_id"
+	userIdKey            = "user_id"
+	modelIdKey           = "model_id"
+	batchPredictJobIdKey = "batch_predict_job_id"
 )
 
 func (m *JwtManager) createToken(key, value string, exp time.Duration) (string, error) {
@@ -54,6 +55,13 @@ func (m *JwtManager) CreateModelJwt(modelId uuid.UUID, exp time.Duration) (strin
 	return m.createToken(modelIdKey, modelId.String(), exp)
 }
 
+// CreateBatchPredictJwt creates a token for a batch prediction job. The token
+// only identifies the batch job, so it cannot be used to update the status of
+// the model the job is running.
+func (m *JwtManager) CreateBatchPredictJwt(jobId uuid.UUID, exp time.Duration) (string, error) {
+	return m.createToken(batchPredictJobIdKey, jobId.String(), exp)
+}
+
 func ValueFromContext(r *http.Request, key string) (string, error) {
 	_, claims, err := jwtauth.FromContext(r.Context())
 	if err != nil {
@@ -74,7 +82,15 @@ func ValueFromContext(r *http.Request, key string) (string, error) {
 }
 
 func ModelIdFromContext(r *http.Request) (uuid.UUID, error) {
-	value, err := ValueFromContext(r, modelIdKey)
+	return uuidFromContext(r, modelIdKey)
+}
+
+func BatchPredictJobIdFromContext(r *http.Request) (uuid.UUID, error) {
+	return uuidFromContext(r, batchPredictJobIdKey)
+}
+
+func uuidFromContext(r *http.Request, key string) (uuid.UUID, error) {
+	value, err := ValueFromContext(r, key)
 	if err != nil {
 		return uuid.Nil, err
 	}
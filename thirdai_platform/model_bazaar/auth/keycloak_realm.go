@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+const DefaultPasswordPolicy = "length(8) and digits(1) and lowerCase(1) and upperCase(1) and specialChars(1)"
+
+const (
+	SmtpTlsStartTls = "starttls"
+	SmtpTlsSsl      = "ssl"
+	SmtpTlsNone     = "none"
+)
+
+var ErrInvalidRealmSettings = errors.New("invalid realm settings")
+
+// RealmSMTP is the smtp server keycloak uses to send verification and password
+// reset emails.
+type RealmSMTP struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username,omitempty"`
+	// Password is never returned, an empty password keeps the current password
+	// when the settings are updated.
+	Password string `json:"password,omitempty"`
+	From     string `json:"from"`
+	// Tls is starttls, ssl, or none.
+	Tls string `json:"tls"`
+}
+
+// BruteForcePolicy temporarily locks users out after failed logins. The wait
+// starts at WaitIncrementSeconds after MaxFailures failures, and increases by
+// WaitIncrementSeconds with each further failure up to MaxWaitSeconds. The
+// failures are forgotten after FailureResetSeconds without a failure.
+type BruteForcePolicy struct {
+	// MaxFailures is 0 if brute force protection is disabled.
+	MaxFailures          int `json:"max_failures"`
+	WaitIncrementSeconds int `json:"wait_increment_seconds"`
+	MaxWaitSeconds       int `json:"max_wait_seconds"`
+	FailureResetSeconds  int `json:"failure_reset_seconds"`
+}
+
+// RealmSettings are the email and login policies of the platform realm in
+// keycloak.
+type RealmSettings struct {
+	// SMTP is nil if keycloak does not send emails, users are then not required
+	// to verify their email.
+	SMTP *RealmSMTP `json:"smtp"`
+	// PasswordPolicy uses the keycloak password policy syntax, for example
+	// "length(12) and digits(1)".
+	PasswordPolicy string           `json:"password_policy"`
+	BruteForce     BruteForcePolicy `json:"brute_force"`
+}
+
+// RealmSettingsProvider is implemented by identity providers whose realm
+// settings can be changed by admins.
+type RealmSettingsProvider interface {
+	GetRealmSettings() (RealmSettings, error)
+
+	UpdateRealmSettings(settings RealmSettings) error
+}
+
+func DefaultBruteForcePolicy() BruteForcePolicy {
+	return BruteForcePolicy{
+		MaxFailures:          30,
+		WaitIncrementSeconds: 60,
+		MaxWaitSeconds:       900,
+		FailureResetSeconds:  43200,
+	}
+}
+
+func (s *RealmSMTP) Validate() error {
+	if s.Host == "" {
+		return errors.New("smtp host must be specified")
+	}
+	if s.Port <= 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid smtp port %d", s.Port)
+	}
+	if s.From == "" {
+		return errors.New("smtp from address must be specified")
+	}
+	switch s.Tls {
+	case SmtpTlsStartTls, SmtpTlsSsl, SmtpTlsNone:
+	default:
+		return fmt.Errorf("smtp tls must be %v, %v, or %v", SmtpTlsStartTls, SmtpTlsSsl, SmtpTlsNone)
+	}
+	return nil
+}
+
+func (p BruteForcePolicy) Validate() error {
+	if p.MaxFailures < 0 {
+		return errors.New("brute force max_failures must be non negative, use 0 to disable brute force protection")
+	}
+	if p.MaxFailures == 0 {
+		return nil
+	}
+	if p.WaitIncrementSeconds <= 0 || p.MaxWaitSeconds <= 0 || p.FailureResetSeconds <= 0 {
+		return errors.New("brute force wait_increment_seconds, max_wait_seconds, and failure_reset_seconds must be positive")
+	}
+	if p.MaxWaitSeconds < p.WaitIncrementSeconds {
+		return errors.New("brute force max_wait_seconds must be at least wait_increment_seconds")
+	}
+	return nil
+}
+
+func (s RealmSettings) Validate() error {
+	if s.SMTP != nil {
+		if err := s.SMTP.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.PasswordPolicy == "" {
+		return errors.New("password policy must be specified")
+	}
+	return s.BruteForce.Validate()
+}
+
+// apply sets the settings on the realm. The smtp password of the realm is kept
+// if the settings do not specify one, keycloak returns it masked and restores
+// it if the masked value is sent back.
+func (s RealmSettings) apply(realm *gocloak.RealmRepresentation) {
+	if s.SMTP != nil {
+		smtp := map[string]string{
+			"host":     s.SMTP.Host,
+			"port":     strconv.Itoa(s.SMTP.Port),
+			"from":     s.SMTP.From,
+			"replyTo":  s.SMTP.From,
+			"ssl":      strconv.FormatBool(s.SMTP.Tls == SmtpTlsSsl),
+			"starttls": strconv.FormatBool(s.SMTP.Tls == SmtpTlsStartTls),
+			"auth":     strconv.FormatBool(s.SMTP.Username != ""),
+		}
+		if s.SMTP.Username != "" {
+			smtp["user"] = s.SMTP.Username
+			smtp["password"] = s.SMTP.Password
+			if s.SMTP.Password == "" && realm.SMTPServer != nil {
+				smtp["password"] = (*realm.SMTPServer)["password"]
+			}
+		}
+		realm.SMTPServer = &smtp
+	} else {
+		realm.SMTPServer = &map[string]string{}
+	}
+	realm.VerifyEmail = boolArg(s.SMTP != nil)
+
+	realm.PasswordPolicy = strArg(s.PasswordPolicy)
+
+	bruteForce := s.BruteForce
+	if bruteForce.MaxFailures == 0 {
+		// Keycloak requires valid wait times even if protection is disabled.
+		bruteForce = DefaultBruteForcePolicy()
+		realm.BruteForceProtected = boolArg(false)
+	} else {
+		realm.BruteForceProtected = boolArg(true)
+	}
+	realm.FailureFactor = intArg(bruteForce.MaxFailures)
+	realm.WaitIncrementSeconds = intArg(bruteForce.WaitIncrementSeconds)
+	realm.MaxFailureWaitSeconds = intArg(bruteForce.MaxWaitSeconds)
+	realm.MaxDeltaTimeSeconds = intArg(bruteForce.FailureResetSeconds)
+	realm.MinimumQuickLoginWaitSeconds = intArg(60)
+	realm.QuickLoginCheckMilliSeconds = pArg(int64(1000))
+}
+
+func realmSettingsFrom(realm *gocloak.RealmRepresentation) RealmSettings {
+	var settings RealmSettings
+
+	if realm.SMTPServer != nil && (*realm.SMTPServer)["host"] != "" {
+		server := *realm.SMTPServer
+		port, _ := strconv.Atoi(server["port"])
+		tls := SmtpTlsNone
+		if server["ssl"] == "true" {
+			tls = SmtpTlsSsl
+		} else if server["starttls"] == "true" {
+			tls = SmtpTlsStartTls
+		}
+		settings.SMTP = &RealmSMTP{
+			Host:     server["host"],
+			Port:     port,
+			Username: server["user"],
+			From:     server["from"],
+			Tls:      tls,
+		}
+	}
+
+	if realm.PasswordPolicy != nil {
+		settings.PasswordPolicy = *realm.PasswordPolicy
+	}
+
+	if realm.BruteForceProtected != nil && *realm.BruteForceProtected {
+		deref := func(v *int) int {
+			if v == nil {
+				return 0
+			}
+			return *v
+		}
+		settings.BruteForce = BruteForcePolicy{
+			MaxFailures:          deref(realm.FailureFactor),
+			WaitIncrementSeconds: deref(realm.WaitIncrementSeconds),
+			MaxWaitSeconds:       deref(realm.MaxFailureWaitSeconds),
+			FailureResetSeconds:  deref(realm.MaxDeltaTimeSeconds),
+		}
+	}
+
+	return settings
+}
+
+func (auth *KeycloakIdentityProvider) getRealm() (string, *gocloak.RealmRepresentation, error) {
+	adminToken, err := adminLogin(auth.keycloak, auth.adminUsername, auth.adminPassword)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	realm, err := auth.keycloak.GetRealm(ctx, adminToken, auth.realm)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting keycloak realm: %w", err)
+	}
+	return adminToken, realm, nil
+}
+
+func (auth *KeycloakIdentityProvider) GetRealmSettings() (RealmSettings, error) {
+	_, realm, err := auth.getRealm()
+	if err != nil {
+		return RealmSettings{}, err
+	}
+	return realmSettingsFrom(realm), nil
+}
+
+func (auth *KeycloakIdentityProvider) UpdateRealmSettings(settings RealmSettings) error {
+	adminToken, realm, err := auth.getRealm()
+	if err != nil {
+		return err
+	}
+
+	settings.apply(realm)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := auth.keycloak.UpdateRealm(ctx, adminToken, *realm); err != nil {
+		var apiErr *gocloak.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			// Keycloak rejects invalid password policies with a bad request.
+			return fmt.Errorf("%w: %v", ErrInvalidRealmSettings, apiErr.Message)
+		}
+		return fmt.Errorf("error updating keycloak realm: %w", err)
+	}
+	return nil
+}
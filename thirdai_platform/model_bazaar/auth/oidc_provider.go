@@ -0,0 +1,580 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"gorm.io/gorm"
+)
+
+// The jwks is refetched when a token fails verification, in case the issuer has
+// rotated its keys, but not more often than this.
+const oidcJwksRefreshInterval = time.Minute
+
+const oidcRequestTimeout = 5 * time.Second
+
+type oidcDiscovery struct {
+	Issuer              string   `json:"issuer"`
+	JwksUri             string   `json:"jwks_uri"`
+	UserinfoEndpoint    string   `json:"userinfo_endpoint"`
+	TokenEndpoint       string   `json:"token_endpoint"`
+	GrantTypesSupported []string `json:"grant_types_supported"`
+}
+
+type OidcArgs struct {
+	// The discovery document is loaded from IssuerUrl + /.well-known/openid-configuration.
+	IssuerUrl    string
+	ClientId     string
+	ClientSecret string
+
+	// Audience that access tokens must be issued for, defaults to ClientId.
+	Audience string
+
+	// Claim that lists the groups of the user, defaults to "groups".
+	GroupsClaim string
+	// Maps groups from the identity provider to the names of platform teams.
+	GroupTeams map[string]string
+	// Members of this group are made admins when they log in.
+	AdminGroup string
+
+	AdminUsername string
+	AdminEmail    string
+
+	Client *http.Client
+}
+
+type OidcIdentityProvider struct {
+	db       *gorm.DB
+	auditLog AuditLogger
+	client   *http.Client
+
+	discovery oidcDiscovery
+
+	clientId, clientSecret string
+	audience               string
+	groupsClaim            string
+	groupTeams             map[string]string
+	adminGroup             string
+
+	keysMu      sync.Mutex
+	keys        jwk.Set
+	keysFetched time.Time
+}
+
+func NewOidcIdentityProvider(db *gorm.DB, auditLog AuditLogger, args OidcArgs) (IdentityProvider, error) {
+	if args.IssuerUrl == "" || args.ClientId == "" {
+		return nil, fmt.Errorf("oidc issuer url and client id must be specified")
+	}
+	if args.Audience == "" {
+		args.Audience = args.ClientId
+	}
+	if args.GroupsClaim == "" {
+		args.GroupsClaim = "groups"
+	}
+	if args.Client == nil {
+		args.Client = &http.Client{Timeout: oidcRequestTimeout}
+	}
+
+	auth := &OidcIdentityProvider{
+		db:           db,
+		auditLog:     auditLog,
+		client:       args.Client,
+		clientId:     args.ClientId,
+		clientSecret: args.ClientSecret,
+		audience:     args.Audience,
+		groupsClaim:  args.GroupsClaim,
+		groupTeams:   args.GroupTeams,
+		adminGroup:   args.AdminGroup,
+	}
+
+	discoveryUrl := strings.TrimSuffix(args.IssuerUrl, "/") + "/.well-known/openid-configuration"
+	if err := auth.getJson(discoveryUrl, "", &auth.discovery); err != nil {
+		slog.Error("OIDC: error loading discovery document", "url", discoveryUrl, "error", err)
+		return nil, fmt.Errorf("error loading oidc discovery document: %w", err)
+	}
+	if strings.TrimSuffix(auth.discovery.Issuer, "/") != strings.TrimSuffix(args.IssuerUrl, "/") {
+		return nil, fmt.Errorf("oidc discovery document has issuer '%v', expected '%v'", auth.discovery.Issuer, args.IssuerUrl)
+	}
+	if auth.discovery.JwksUri == "" {
+		return nil, fmt.Errorf("oidc discovery document does not specify a jwks_uri")
+	}
+	slog.Info("OIDC: loaded discovery document", "issuer", auth.discovery.Issuer)
+
+	if _, err := auth.refreshKeys(time.Time{}); err != nil {
+		slog.Error("OIDC: error loading jwks", "url", auth.discovery.JwksUri, "error", err)
+		return nil, err
+	}
+
+	// The admin is linked to their identity the first time they log in, using
+	// their email.
+	err := addInitialAdminToDb(db, uuid.New(), args.AdminUsername, args.AdminEmail, nil)
+	if err != nil {
+		slog.Error("OIDC: adding new admin to db failed", "error", err)
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func (auth *OidcIdentityProvider) getJson(endpoint, token string, dest interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return auth.doJson(req, dest)
+}
+
+func (auth *OidcIdentityProvider) doJson(req *http.Request, dest interface{}) error {
+	res, err := auth.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request to %v: %w", req.URL.Host, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("request to %v returned status %d: %v", req.URL.Path, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(dest); err != nil {
+		return fmt.Errorf("error parsing response from %v: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// refreshKeys fetches the jwks unless it has been fetched since the given time,
+// which allows concurrent requests that fail verification to share one fetch.
+func (auth *OidcIdentityProvider) refreshKeys(after time.Time) (jwk.Set, error) {
+	auth.keysMu.Lock()
+	defer auth.keysMu.Unlock()
+
+	if auth.keys != nil && (auth.keysFetched.After(after) || time.Since(auth.keysFetched) < oidcJwksRefreshInterval) {
+		return auth.keys, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	keys, err := jwk.Fetch(ctx, auth.discovery.JwksUri, jwk.WithHTTPClient(auth.client))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching oidc jwks: %w", err)
+	}
+
+	auth.keys = keys
+	auth.keysFetched = time.Now()
+	return keys, nil
+}
+
+func (auth *OidcIdentityProvider) currentKeys() (jwk.Set, time.Time) {
+	auth.keysMu.Lock()
+	defer auth.keysMu.Unlock()
+	return auth.keys, auth.keysFetched
+}
+
+func (auth *OidcIdentityProvider) parseWithKeys(token string, keys jwk.Set) (jwt.Token, error) {
+	return jwt.ParseString(
+		token,
+		jwt.WithKeySet(keys, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(auth.discovery.Issuer),
+		jwt.WithAudience(auth.audience),
+		jwt.WithAcceptableSkew(30*time.Second),
+	)
+}
+
+func (auth *OidcIdentityProvider) verifyToken(token string) (jwt.Token, error) {
+	keys, fetched := auth.currentKeys()
+
+	parsed, err := auth.parseWithKeys(token, keys)
+	if err == nil {
+		return parsed, nil
+	}
+
+	// Only signature errors can be caused by the issuer rotating its keys.
+	if errors.Is(err, jwt.ErrInvalidJWT()) || jwt.IsValidationError(err) {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	keys, refreshErr := auth.refreshKeys(fetched)
+	if refreshErr != nil {
+		slog.Error("OIDC: error refreshing jwks", "error", refreshErr)
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	parsed, err = auth.parseWithKeys(token, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return parsed, nil
+}
+
+func (auth *OidcIdentityProvider) findIdentity(token jwt.Token) (schema.User, error) {
+	var identity schema.OidcIdentity
+	result := auth.db.Preload("User").Limit(1).Find(&identity, "issuer = ? AND subject = ?", token.Issuer(), token.Subject())
+	if result.Error != nil {
+		slog.Error("sql error finding oidc identity", "subject", token.Subject(), "error", result.Error)
+		return schema.User{}, schema.ErrDbAccessFailed
+	}
+	if result.RowsAffected == 0 || identity.User == nil {
+		return schema.User{}, schema.ErrUserNotFound
+	}
+	return *identity.User, nil
+}
+
+func (auth *OidcIdentityProvider) middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			token, err := getToken(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			verified, err := auth.verifyToken(token)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to verify token: %v", err), http.StatusUnauthorized)
+				return
+			}
+
+			user, err := auth.findIdentity(verified)
+			if err != nil {
+				if errors.Is(err, schema.ErrUserNotFound) {
+					http.Error(w, "user must log in before accessing the platform", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, fmt.Sprintf("unable to find user %v: %v", verified.Subject(), err), http.StatusInternalServerError)
+				return
+			}
+
+			reqCtx := r.Context()
+			reqCtx = context.WithValue(reqCtx, UserRequestContextKey, user)
+			next.ServeHTTP(w, r.WithContext(reqCtx))
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+func (auth *OidcIdentityProvider) AuthMiddleware() chi.Middlewares {
+	return chi.Middlewares{auth.middleware(), auth.auditLog.Middleware}
+}
+
+func (auth *OidcIdentityProvider) AllowDirectSignup() bool {
+	return false
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// LoginWithEmail uses the resource owner password grant, which is only possible
+// if the issuer supports it and a client secret is configured.
+func (auth *OidcIdentityProvider) LoginWithEmail(email, password string) (LoginResult, error) {
+	if auth.clientSecret == "" || auth.discovery.TokenEndpoint == "" || !slices.Contains(auth.discovery.GrantTypesSupported, "password") {
+		return LoginResult{}, fmt.Errorf("login with email is not supported for this identity provider")
+	}
+
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {email},
+		"password":   {password},
+		"scope":      {"openid profile email"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(auth.clientId), url.QueryEscape(auth.clientSecret))
+
+	var tokens oidcTokenResponse
+	if err := auth.doJson(req, &tokens); err != nil {
+		slog.Error("OIDC: password login failed", "error", err)
+		return LoginResult{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	return auth.LoginWithToken(tokens.AccessToken)
+}
+
+type oidcUserInfo struct {
+	subject  string
+	username string
+	email    string
+	groups   []string
+	// Existing users are only linked by email if the issuer has verified it.
+	emailVerified bool
+	// Some issuers only include groups in the token if they are requested.
+	hasGroups bool
+}
+
+func claimGroups(value interface{}) ([]string, bool) {
+	switch groups := value.(type) {
+	case string:
+		return []string{groups}, true
+	case []string:
+		return groups, true
+	case []interface{}:
+		out := make([]string, 0, len(groups))
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				out = append(out, name)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+func (auth *OidcIdentityProvider) setClaims(info *oidcUserInfo, claims map[string]interface{}) {
+	if email, ok := claims["email"].(string); ok && email != "" {
+		info.email = email
+	}
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		info.emailVerified = verified
+	case string:
+		// Some issuers send boolean claims as strings.
+		info.emailVerified = verified == "true"
+	}
+	if username, ok := claims["preferred_username"].(string); ok && username != "" {
+		info.username = username
+	}
+	if groups, ok := claimGroups(claims[auth.groupsClaim]); ok {
+		info.groups = groups
+		info.hasGroups = true
+	}
+}
+
+func (auth *OidcIdentityProvider) userInfo(token jwt.Token, accessToken string) (oidcUserInfo, error) {
+	info := oidcUserInfo{subject: token.Subject()}
+	auth.setClaims(&info, token.PrivateClaims())
+
+	needsGroups := len(auth.groupTeams) > 0 || auth.adminGroup != ""
+	if (info.email == "" || !info.emailVerified || info.username == "" || (needsGroups && !info.hasGroups)) && auth.discovery.UserinfoEndpoint != "" {
+		var claims map[string]interface{}
+		if err := auth.getJson(auth.discovery.UserinfoEndpoint, accessToken, &claims); err != nil {
+			return info, fmt.Errorf("error getting user info: %w", err)
+		}
+		if sub, _ := claims["sub"].(string); sub != info.subject {
+			return info, fmt.Errorf("user info is for subject '%v', expected '%v'", sub, info.subject)
+		}
+		auth.setClaims(&info, claims)
+	}
+
+	if info.email == "" {
+		return info, fmt.Errorf("email is missing from oidc token and user info")
+	}
+	if info.username == "" {
+		info.username = info.email
+	}
+	// Many issuers use the email as the preferred username.
+	if at := strings.Index(info.username, "@"); at > 0 {
+		info.username = info.username[:at]
+	}
+
+	return info, nil
+}
+
+func (auth *OidcIdentityProvider) LoginWithToken(accessToken string) (LoginResult, error) {
+	token, err := auth.verifyToken(accessToken)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	info, err := auth.userInfo(token, accessToken)
+	if err != nil {
+		slog.Error("OIDC: invalid user info", "subject", token.Subject(), "error", err)
+		return LoginResult{}, fmt.Errorf("failed to authenticate user: %w", err)
+	}
+
+	var user schema.User
+
+	err = auth.db.Transaction(func(txn *gorm.DB) error {
+		var identity schema.OidcIdentity
+		result := txn.Preload("User").Limit(1).Find(&identity, "issuer = ? AND subject = ?", token.Issuer(), info.subject)
+		if result.Error != nil {
+			slog.Error("sql error checking for existing oidc identity", "subject", info.subject, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+
+		if result.RowsAffected == 1 && identity.User != nil {
+			user = *identity.User
+		} else {
+			result := txn.Limit(1).Find(&user, "email = ?", info.email)
+			if result.Error != nil {
+				slog.Error("sql error checking for existing user in oidc identity provider", "email", info.email, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+
+			if result.RowsAffected == 1 && !info.emailVerified {
+				// Otherwise anyone able to set an arbitrary email with the issuer
+				// could take over the account with that email.
+				slog.Error("OIDC: refusing to link identity with unverified email to existing user", "subject", info.subject, "user_id", user.Id)
+				return fmt.Errorf("%w: cannot link oidc identity to existing user with email '%v'", ErrEmailNotVerified, info.email)
+			}
+
+			if result.RowsAffected != 1 {
+				var existing schema.User
+				result := txn.Limit(1).Find(&existing, "username = ?", info.username)
+				if result.Error != nil {
+					slog.Error("sql error checking for existing username in oidc identity provider", "username", info.username, "error", result.Error)
+					return schema.ErrDbAccessFailed
+				}
+				if result.RowsAffected != 0 {
+					return fmt.Errorf("%w: '%v'", ErrUsernameAlreadyInUse, info.username)
+				}
+
+				user = schema.User{Id: uuid.New(), Username: info.username, Email: info.email, IsAdmin: false}
+				if result := txn.Create(&user); result.Error != nil {
+					slog.Error("sql error creating new user in oidc identity provider", "error", result.Error)
+					return schema.ErrDbAccessFailed
+				}
+			}
+
+			identity = schema.OidcIdentity{Issuer: token.Issuer(), Subject: info.subject, UserId: user.Id}
+			if result := txn.Save(&identity); result.Error != nil {
+				slog.Error("sql error creating oidc identity", "subject", info.subject, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+		}
+
+		if info.hasGroups {
+			return auth.syncGroups(txn, &user, info.groups)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return LoginResult{}, fmt.Errorf("error logging in user: %w", err)
+	}
+
+	return LoginResult{UserId: user.Id, AccessToken: accessToken}, nil
+}
+
+// syncGroups updates the admin status of the user if an admin group is set, and
+// the membership of the user in the teams that are mapped to groups. Teams that
+// are not mapped to a group are managed in the platform.
+func (auth *OidcIdentityProvider) syncGroups(txn *gorm.DB, user *schema.User, groups []string) error {
+	if auth.adminGroup != "" {
+		if isAdmin := slices.Contains(groups, auth.adminGroup); isAdmin != user.IsAdmin {
+			if result := txn.Model(user).Update("is_admin", isAdmin); result.Error != nil {
+				slog.Error("sql error updating user admin status from oidc group", "user_id", user.Id, "is_admin", isAdmin, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+			user.IsAdmin = isAdmin
+		}
+	}
+
+	if len(auth.groupTeams) == 0 {
+		return nil
+	}
+
+	teamNames := make([]string, 0, len(auth.groupTeams))
+	for _, team := range auth.groupTeams {
+		teamNames = append(teamNames, team)
+	}
+
+	var teams []schema.Team
+	if result := txn.Where("name IN ?", teamNames).Find(&teams); result.Error != nil {
+		slog.Error("sql error loading teams mapped to oidc groups", "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+	teamIds := make(map[string]uuid.UUID, len(teams))
+	for _, team := range teams {
+		teamIds[team.Name] = team.Id
+	}
+
+	memberOf := map[uuid.UUID]bool{}
+	for group, team := range auth.groupTeams {
+		teamId, ok := teamIds[team]
+		if !ok {
+			slog.Warn("OIDC: team mapped to group does not exist", "group", group, "team", team)
+			continue
+		}
+		if slices.Contains(groups, group) {
+			memberOf[teamId] = true
+		} else if _, ok := memberOf[teamId]; !ok {
+			memberOf[teamId] = false
+		}
+	}
+
+	for teamId, member := range memberOf {
+		if member {
+			membership := schema.UserTeam{UserId: user.Id, TeamId: teamId}
+			if result := txn.Where(membership).FirstOrCreate(&membership); result.Error != nil {
+				slog.Error("sql error adding user to team from oidc group", "user_id", user.Id, "team_id", teamId, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+		} else {
+			if result := txn.Delete(&schema.UserTeam{}, "user_id = ? AND team_id = ?", user.Id, teamId); result.Error != nil {
+				slog.Error("sql error removing user from team from oidc group", "user_id", user.Id, "team_id", teamId, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+		}
+	}
+
+	return nil
+}
+
+func (auth *OidcIdentityProvider) CreateUser(username, email, password string) (uuid.UUID, error) {
+	return uuid.Nil, fmt.Errorf("users must be created in the oidc identity provider")
+}
+
+func (auth *OidcIdentityProvider) VerifyUser(userId uuid.UUID) error {
+	return fmt.Errorf("users must be verified in the oidc identity provider")
+}
+
+// DeleteUser only removes the link between the user and their identity, the
+// user can still log in again unless they are removed from the issuer.
+func (auth *OidcIdentityProvider) DeleteUser(userId uuid.UUID) error {
+	result := auth.db.Delete(&schema.OidcIdentity{}, "user_id = ?", userId)
+	if result.Error != nil {
+		slog.Error("sql error deleting oidc identity", "user_id", userId, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+	return nil
+}
+
+func (auth *OidcIdentityProvider) GetTokenExpiration(r *http.Request) (time.Time, error) {
+	authToken, err := getToken(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	token, err := auth.verifyToken(authToken)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to verify token: %w", err)
+	}
+
+	if token.Expiration().IsZero() {
+		return time.Time{}, fmt.Errorf("no token expiration found")
+	}
+	return token.Expiration(), nil
+}
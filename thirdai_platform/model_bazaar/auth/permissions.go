@@ -3,6 +3,7 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/utils"
@@ -64,6 +65,15 @@ func AdminOrTeamAdminOnly(db *gorm.DB) func(http.Handler) http.Handler {
 				return
 			}
 
+			if !user.IsAdmin && !isAdmin {
+				// Org admins can manage the teams in their organization.
+				isAdmin, err = isTeamOrgAdmin(teamId, user, db)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+
 			if !user.IsAdmin && !isAdmin {
 				http.Error(w, "user must be admin or team admin to access endpoint", http.StatusForbidden)
 				return
@@ -75,6 +85,53 @@ func AdminOrTeamAdminOnly(db *gorm.DB) func(http.Handler) http.Handler {
 	}
 }
 
+func isTeamOrgAdmin(teamId uuid.UUID, user schema.User, db *gorm.DB) (bool, error) {
+	if !user.IsOrgAdmin || user.OrganizationId == nil {
+		return false, nil
+	}
+
+	team, err := schema.GetTeam(teamId, db)
+	if err != nil {
+		if errors.Is(err, schema.ErrTeamNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return schema.SameOrganization(team.OrganizationId, user.OrganizationId), nil
+}
+
+// IsOrgAdmin returns if the user is an admin of the organization.
+func IsOrgAdmin(orgId uuid.UUID, user schema.User) bool {
+	return user.IsOrgAdmin && user.OrganizationId != nil && *user.OrganizationId == orgId
+}
+
+func AdminOrOrgAdminOnly(db *gorm.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		hfn := func(w http.ResponseWriter, r *http.Request) {
+			orgId, err := utils.URLParamUUID(r, "org_id")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			user, err := UserFromContext(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !user.IsAdmin && !IsOrgAdmin(orgId, user) {
+				http.Error(w, "user must be admin or organization admin to access endpoint", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(hfn)
+	}
+}
+
 func isTeamMember(teamId, userId uuid.UUID, db *gorm.DB) (bool, error) {
 	_, err := schema.GetUserTeam(teamId, userId, db)
 	if err != nil {
@@ -148,24 +205,179 @@ func GetModelPermissions(modelId uuid.UUID, user schema.User, db *gorm.DB) (mode
 		return OwnerPermission, nil
 	}
 
-	model, err := schema.GetModel(modelId, db, false, false, false)
+	model, err := schema.GetModel(modelId, db, false, false, true)
 	if err != nil {
 		return NoPermission, err
 	}
 
+	var userTeam *schema.UserTeam
+	if model.UserId != user.Id && model.Access == schema.Protected && model.TeamId != nil {
+		team, err := schema.GetUserTeam(*model.TeamId, user.Id, db)
+		if err != nil && !errors.Is(err, schema.ErrUserTeamNotFound) {
+			return NoPermission, err
+		}
+		if err == nil {
+			userTeam = &team
+		}
+	}
+
+	return permissionForModel(model, user, userTeam), nil
+}
+
+// permissionForModel returns the permission of a non admin user for the model.
+// The model's owner must be loaded. The userTeam is the user's membership in
+// the model's team, or nil if the user is not a member.
+func permissionForModel(model schema.Model, user schema.User, userTeam *schema.UserTeam) modelPermission {
 	if model.UserId == user.Id {
-		return OwnerPermission, nil
+		return OwnerPermission
+	}
+
+	// Models are never shared outside of the owner's organization.
+	if model.User == nil || !schema.SameOrganization(model.User.OrganizationId, user.OrganizationId) {
+		return NoPermission
+	}
+
+	if user.IsOrgAdmin && user.OrganizationId != nil {
+		return OwnerPermission
 	}
 
 	if model.Access == schema.Public {
 		if model.DefaultPermission == schema.WritePerm {
-			return WritePermission, nil
+			return WritePermission
 		}
-		return ReadPermission, nil
+		return ReadPermission
 	}
 
-	if model.Access == schema.Protected && model.TeamId != nil {
-		userTeam, err := schema.GetUserTeam(*model.TeamId, user.Id, db)
+	if model.Access == schema.Protected && model.TeamId != nil && userTeam != nil {
+		if userTeam.IsTeamAdmin {
+			return OwnerPermission
+		}
+		if model.DefaultPermission == schema.WritePerm {
+			return WritePermission
+		}
+		return ReadPermission
+	}
+
+	return NoPermission
+}
+
+// GetModelPermissionsBatch returns the user's permission for each of the given
+// models that exist, using one query for the models and one for the user's
+// team memberships regardless of the number of models.
+func GetModelPermissionsBatch(modelIds []uuid.UUID, user schema.User, db *gorm.DB) (map[uuid.UUID]modelPermission, error) {
+	var models []schema.Model
+	result := db.
+		Select("id", "user_id", "access", "default_permission", "team_id").
+		Preload("User", func(db *gorm.DB) *gorm.DB { return db.Select("id", "organization_id") }).
+		Where("id IN ?", modelIds).
+		Find(&models)
+	if result.Error != nil {
+		slog.Error("sql error listing models for permissions", "error", result.Error)
+		return nil, schema.ErrDbAccessFailed
+	}
+
+	teamIds := make([]uuid.UUID, 0)
+	for _, model := range models {
+		if !user.IsAdmin && model.UserId != user.Id && model.Access == schema.Protected && model.TeamId != nil {
+			teamIds = append(teamIds, *model.TeamId)
+		}
+	}
+
+	userTeams := make(map[uuid.UUID]*schema.UserTeam)
+	if len(teamIds) > 0 {
+		var teams []schema.UserTeam
+		result := db.Where("user_id = ? AND team_id IN ?", user.Id, teamIds).Find(&teams)
+		if result.Error != nil {
+			slog.Error("sql error listing user teams for permissions", "user_id", user.Id, "error", result.Error)
+			return nil, schema.ErrDbAccessFailed
+		}
+		for i := range teams {
+			userTeams[teams[i].TeamId] = &teams[i]
+		}
+	}
+
+	permissions := make(map[uuid.UUID]modelPermission, len(models))
+	for _, model := range models {
+		if user.IsAdmin {
+			permissions[model.Id] = OwnerPermission
+			continue
+		}
+
+		var userTeam *schema.UserTeam
+		if model.TeamId != nil {
+			userTeam = userTeams[*model.TeamId]
+		}
+		permissions[model.Id] = permissionForModel(model, user, userTeam)
+	}
+
+	return permissions, nil
+}
+
+func ModelPermissionOnly(db *gorm.DB, minPermission modelPermission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		hfn := func(w http.ResponseWriter, r *http.Request) {
+			modelId, err := utils.URLParamUUID(r, "model_id")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			user, err := UserFromContext(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			permission, err := GetModelPermissions(modelId, user, db)
+			if err != nil {
+				if errors.Is(err, schema.ErrModelNotFound) {
+					utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if permission >= minPermission {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			required, actual := modelPermissionToString(minPermission), modelPermissionToString(permission)
+			http.Error(w, fmt.Sprintf("user %v does not have required permission for model %v (required=%v, actual=%v)", user.Id, modelId, required, actual), http.StatusForbidden)
+		}
+		return http.HandlerFunc(hfn)
+	}
+}
+
+// GetDatasetPermissions returns the user's permission for the dataset. Access to
+// datasets works like access to models, except that only the owner and admins
+// can change a dataset, other users with access can only read it.
+func GetDatasetPermissions(dataset schema.Dataset, user schema.User, db *gorm.DB) (modelPermission, error) {
+	if user.IsAdmin || dataset.UserId == user.Id {
+		return OwnerPermission, nil
+	}
+
+	owner, err := schema.GetUser(dataset.UserId, db)
+	if err != nil {
+		return NoPermission, err
+	}
+
+	// Datasets are never shared outside of the owner's organization.
+	if !schema.SameOrganization(owner.OrganizationId, user.OrganizationId) {
+		return NoPermission, nil
+	}
+
+	if user.IsOrgAdmin && user.OrganizationId != nil {
+		return OwnerPermission, nil
+	}
+
+	switch {
+	case dataset.Access == schema.Public:
+		return ReadPermission, nil
+
+	case dataset.Access == schema.Protected && dataset.TeamId != nil:
+		userTeam, err := schema.GetUserTeam(*dataset.TeamId, user.Id, db)
 		if err != nil {
 			if errors.Is(err, schema.ErrUserTeamNotFound) {
 				return NoPermission, nil
@@ -175,19 +387,17 @@ func GetModelPermissions(modelId uuid.UUID, user schema.User, db *gorm.DB) (mode
 		if userTeam.IsTeamAdmin {
 			return OwnerPermission, nil
 		}
-		if model.DefaultPermission == schema.WritePerm {
-			return WritePermission, nil
-		}
 		return ReadPermission, nil
-	}
 
-	return NoPermission, nil
+	default:
+		return NoPermission, nil
+	}
 }
 
-func ModelPermissionOnly(db *gorm.DB, minPermission modelPermission) func(http.Handler) http.Handler {
+func DatasetPermissionOnly(db *gorm.DB, minPermission modelPermission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		hfn := func(w http.ResponseWriter, r *http.Request) {
-			modelId, err := utils.URLParamUUID(r, "model_id")
+			datasetId, err := utils.URLParamUUID(r, "dataset_id")
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
@@ -199,9 +409,9 @@ func ModelPermissionOnly(db *gorm.DB, minPermission modelPermission) func(http.H
 				return
 			}
 
-			permission, err := GetModelPermissions(modelId, user, db)
+			dataset, err := schema.GetDataset(datasetId, db)
 			if err != nil {
-				if errors.Is(err, schema.ErrModelNotFound) {
+				if errors.Is(err, schema.ErrDatasetNotFound) {
 					http.Error(w, err.Error(), http.StatusNotFound)
 					return
 				}
@@ -209,13 +419,19 @@ func ModelPermissionOnly(db *gorm.DB, minPermission modelPermission) func(http.H
 				return
 			}
 
+			permission, err := GetDatasetPermissions(dataset, user, db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
 			if permission >= minPermission {
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			required, actual := modelPermissionToString(minPermission), modelPermissionToString(permission)
-			http.Error(w, fmt.Sprintf("user %v does not have required permission for model %v (required=%v, actual=%v)", user.Id, modelId, required, actual), http.StatusForbidden)
+			http.Error(w, fmt.Sprintf("user %v does not have required permission for dataset %v (required=%v, actual=%v)", user.Id, datasetId, required, actual), http.StatusForbidden)
 		}
 		return http.HandlerFunc(hfn)
 	}
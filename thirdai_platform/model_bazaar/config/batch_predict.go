@@ -0,0 +1,46 @@
+package config
+
+import (
+	"thirdai_platform/utils/validation"
+
+	"github.com/google/uuid"
+)
+
+type BatchPredictOptions struct {
+	// The column of the input csv files that contains the text to predict on.
+	TextColumn string `json:"text_column"`
+	// The number of classes to return for each row of an nlp-text model.
+	TopK int `json:"top_k" validate:"gt=0"`
+	// The number of rows that are predicted on at once, the job reports its
+	// progress after each batch.
+	BatchSize int `json:"batch_size" validate:"gt=0,max=10000"`
+}
+
+func (opts *BatchPredictOptions) Validate() error {
+	if opts.TextColumn == "" {
+		opts.TextColumn = "text"
+	}
+	if opts.TopK == 0 {
+		opts.TopK = 1
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1000
+	}
+
+	return validation.Struct(opts).Err()
+}
+
+type BatchPredictConfig struct {
+	JobId               uuid.UUID `json:"job_id"`
+	ModelId             uuid.UUID `json:"model_id"`
+	ModelType           string    `json:"model_type"`
+	UserId              uuid.UUID `json:"user_id"`
+	ModelBazaarDir      string    `json:"model_bazaar_dir"`
+	ModelBazaarEndpoint string    `json:"model_bazaar_endpoint"`
+	JobAuthToken        string    `json:"job_auth_token"`
+	LicenseKey          string    `json:"license_key"`
+
+	InputFile  TrainFile           `json:"input_file"`
+	OutputPath string              `json:"output_path"`
+	Options    BatchPredictOptions `json:"options"`
+}
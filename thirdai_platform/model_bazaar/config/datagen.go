@@ -1,14 +1,14 @@
 package config
 
 import (
-	"fmt"
 	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils/validation"
 
 	"github.com/google/uuid"
 )
 
 type LabelEntity struct {
-	Name        string   `json:"name"`
+	Name        string   `json:"name" validate:"required"`
 	Examples    []string `json:"examples"`
 	Description string   `json:"description"`
 	Status      string   `json:"status"`
@@ -22,7 +22,7 @@ type NlpTokenSample struct {
 type NlpTokenDatagenOptions struct {
 	ModelType string `json:"model_type"`
 
-	Tags                   []LabelEntity `json:"tags"`
+	Tags                   []LabelEntity `json:"tags" validate:"required"`
 	NumSentencesToGenerate int           `json:"num_sentences_to_generate"`
 	NumSamplesPerTag       *int          `json:"num_samples_per_tag"`
 
@@ -35,14 +35,9 @@ type NlpTokenDatagenOptions struct {
 func (opts *NlpTokenDatagenOptions) Validate() error {
 	opts.ModelType = schema.NlpTokenModel
 
-	if opts.Tags == nil {
-		return fmt.Errorf("'tags' must be specified in token datagen options")
-	}
+	errs := validation.Struct(opts)
 
 	for i, tag := range opts.Tags {
-		if tag.Name == "" {
-			return fmt.Errorf("each tag must have a specified name")
-		}
 		if tag.Status == "" {
 			opts.Tags[i].Status = "uninserted"
 		}
@@ -56,7 +51,7 @@ func (opts *NlpTokenDatagenOptions) Validate() error {
 		opts.TemplatesPerSample = 10
 	}
 
-	return nil
+	return errs.Err()
 }
 
 func (opts *NlpTokenDatagenOptions) GetModelOptions() interface{} {
@@ -81,8 +76,8 @@ type NlpTextSample struct {
 type NlpTextDatagenOptions struct {
 	ModelType string `json:"model_type"`
 
-	Labels           []LabelEntity `json:"labels"`
-	SamplesPerlabel  int           `json:"samples_per_label"`
+	Labels           []LabelEntity `json:"labels" validate:"required"`
+	SamplesPerlabel  int           `json:"samples_per_label" validate:"required,gt=0"`
 	UserVocab        []string      `json:"user_vocab"`
 	UserPrompts      []string      `json:"user_prompts"`
 	VocabPerSentence int           `json:"vocab_per_sentence"`
@@ -91,28 +86,19 @@ type NlpTextDatagenOptions struct {
 func (opts *NlpTextDatagenOptions) Validate() error {
 	opts.ModelType = schema.NlpTextModel
 
-	if opts.Labels == nil {
-		return fmt.Errorf("'labels' must be specified in text datagen options")
-	}
+	errs := validation.Struct(opts)
 
 	for i, tag := range opts.Labels {
-		if tag.Name == "" {
-			return fmt.Errorf("each label must have a specified name")
-		}
 		if tag.Status == "" {
 			opts.Labels[i].Status = "uninserted"
 		}
 	}
 
-	if opts.SamplesPerlabel == 0 {
-		return fmt.Errorf("'samples_per_label' must be specified in datagen options")
-	}
-
 	if opts.VocabPerSentence == 0 {
 		opts.VocabPerSentence = 4
 	}
 
-	return nil
+	return errs.Err()
 }
 
 func (opts *NlpTextDatagenOptions) GetModelOptions() interface{} {
@@ -4,7 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"maps"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"thirdai_platform/utils/validation"
+	"time"
 )
 
 type DeployConfig struct {
@@ -18,6 +24,434 @@ type DeployConfig struct {
 	JobAuthToken        string            `json:"job_auth_token"`
 	Autoscaling         bool              `json:"autoscaling_enabled"`
 	Options             map[string]string `json:"options"`
+
+	AutoscalingOptions *AutoscalingOptions `json:"autoscaling,omitempty"`
+
+	ConcurrencyLimits map[string]ConcurrencyLimit `json:"concurrency_limits,omitempty"`
+	QueryCache        *QueryCacheOptions          `json:"query_cache,omitempty"`
+	LLMCache          *LLMCacheOptions            `json:"llm_cache,omitempty"`
+	NdbLoad           *NdbLoadOptions             `json:"ndb_load,omitempty"`
+	SpellCorrection   *SpellCorrectionOptions     `json:"spell_correction,omitempty"`
+	Rerank            *RerankOptions              `json:"rerank,omitempty"`
+	Enrichment        *EnrichmentOptions          `json:"enrichment,omitempty"`
+
+	ConfidenceThresholds *ConfidenceThresholdOptions `json:"confidence_thresholds,omitempty"`
+
+	Shadow *ShadowOptions `json:"shadow,omitempty"`
+
+	// TeamIsolation tags inserted documents with the team of the caller, and
+	// only returns the documents of the caller's team, so that one deployment
+	// can be shared by multiple teams.
+	TeamIsolation bool `json:"team_isolation,omitempty"`
+
+	EnableProfiling bool `json:"enable_profiling,omitempty"`
+}
+
+var concurrencyLimitedRoutes = []string{"query", "insert", "generate"}
+
+// ConcurrencyLimit caps the number of requests to a route that a deployment
+// replica processes at once. Requests over the limit wait in a queue of size
+// MaxQueued, and are rejected once the queue is full or they have waited longer
+// than the queue timeout. A MaxConcurrent of 0 means the route is unlimited.
+type ConcurrencyLimit struct {
+	MaxConcurrent       int `json:"max_concurrent"`
+	MaxQueued           int `json:"max_queued"`
+	QueueTimeoutSeconds int `json:"queue_timeout_seconds"`
+}
+
+const DefaultQueueTimeoutSeconds = 30
+
+func (l ConcurrencyLimit) QueueTimeout() time.Duration {
+	if l.QueueTimeoutSeconds <= 0 {
+		return DefaultQueueTimeoutSeconds * time.Second
+	}
+	return time.Duration(l.QueueTimeoutSeconds) * time.Second
+}
+
+type QueryCacheOptions struct {
+	MaxEntries int `json:"max_entries" validate:"required,gt=0"`
+	TtlSeconds int `json:"ttl_seconds" validate:"min=0"`
+}
+
+const DefaultQueryCacheTtlSeconds = 300
+
+func (opts *QueryCacheOptions) Ttl() time.Duration {
+	if opts.TtlSeconds <= 0 {
+		return DefaultQueryCacheTtlSeconds * time.Second
+	}
+	return time.Duration(opts.TtlSeconds) * time.Second
+}
+
+func (opts *QueryCacheOptions) Validate() error {
+	return validation.Struct(opts).Err()
+}
+
+// LLMCacheOptions bound the cache of llm responses of an ndb deployment with
+// an llm. Responses older than the ttl are not returned, and the oldest
+// responses are evicted once the cache has more than MaxEntries.
+type LLMCacheOptions struct {
+	MaxEntries int `json:"max_entries" validate:"min=0"`
+	TtlSeconds int `json:"ttl_seconds" validate:"min=0"`
+}
+
+const (
+	DefaultLLMCacheMaxEntries = 10000
+	DefaultLLMCacheTtlSeconds = 7 * 24 * 60 * 60
+)
+
+func (opts *LLMCacheOptions) Limit() int {
+	if opts.MaxEntries <= 0 {
+		return DefaultLLMCacheMaxEntries
+	}
+	return opts.MaxEntries
+}
+
+func (opts *LLMCacheOptions) Ttl() time.Duration {
+	if opts.TtlSeconds <= 0 {
+		return DefaultLLMCacheTtlSeconds * time.Second
+	}
+	return time.Duration(opts.TtlSeconds) * time.Second
+}
+
+func (opts *LLMCacheOptions) Validate() error {
+	return validation.Struct(opts).Err()
+}
+
+const (
+	SpellCorrectionLow    = "low"
+	SpellCorrectionMedium = "medium"
+	SpellCorrectionHigh   = "high"
+
+	DefaultSpellCorrectionSuggestions = 3
+)
+
+// SpellCorrectionOptions enable spell correction of the queries to an ndb
+// deployment, using a vocabulary of the words in its documents. Aggressiveness
+// controls which words are corrected: low only corrects unknown words to words
+// one edit away, medium allows two edits for longer words, and high also
+// corrects rare words to much more common words. If AutoCorrect is set the
+// corrected query is searched, otherwise the corrections are only suggested.
+type SpellCorrectionOptions struct {
+	Aggressiveness string `json:"aggressiveness" validate:"oneof=low medium high"`
+	AutoCorrect    bool   `json:"auto_correct"`
+	MaxSuggestions int    `json:"max_suggestions" validate:"min=0,max=10"`
+}
+
+func (opts *SpellCorrectionOptions) Suggestions() int {
+	if opts.MaxSuggestions <= 0 {
+		return DefaultSpellCorrectionSuggestions
+	}
+	return opts.MaxSuggestions
+}
+
+func (opts *SpellCorrectionOptions) Validate() error {
+	if opts.Aggressiveness == "" {
+		opts.Aggressiveness = SpellCorrectionMedium
+	}
+	return validation.Struct(opts).Err()
+}
+
+const (
+	RerankLocal       = "local"
+	RerankLlmDispatch = "llm-dispatch"
+
+	DefaultRerankCandidates = 50
+	DefaultRerankWeight     = 0.5
+)
+
+// RerankOptions enable reranking the results of queries to an ndb deployment
+// by the similarity of their embeddings to the query, so that paraphrases of
+// the query can be found. The local provider computes the embeddings with the
+// openai compatible embeddings api at Endpoint, such as an embedding model that
+// is served next to the deployment, and the llm-dispatch provider computes them
+// with the llm dispatch service using LlmProvider. The top Candidates results
+// of the lexical search are reranked, and Weight is the weight of the embedding
+// similarity in the combined score.
+type RerankOptions struct {
+	Provider    string  `json:"provider" validate:"oneof=local llm-dispatch"`
+	Endpoint    string  `json:"endpoint"`
+	LlmProvider string  `json:"llm_provider"`
+	Model       string  `json:"model"`
+	Candidates  int     `json:"candidates" validate:"min=0,max=1000"`
+	Weight      float64 `json:"weight" validate:"min=0,max=1"`
+}
+
+func (opts *RerankOptions) CandidateCount() int {
+	if opts.Candidates <= 0 {
+		return DefaultRerankCandidates
+	}
+	return opts.Candidates
+}
+
+func (opts *RerankOptions) SimilarityWeight() float64 {
+	if opts.Weight <= 0 {
+		return DefaultRerankWeight
+	}
+	return opts.Weight
+}
+
+func (opts *RerankOptions) Validate() error {
+	if opts.Provider == RerankLlmDispatch && opts.LlmProvider == "" {
+		opts.LlmProvider = "openai"
+	}
+	errs := validation.Struct(opts)
+	switch opts.Provider {
+	case RerankLocal:
+		if opts.Endpoint == "" {
+			errs.Add("endpoint", "endpoint is required for the local rerank provider")
+		}
+	case RerankLlmDispatch:
+		if opts.LlmProvider != "openai" && opts.LlmProvider != "on-prem" {
+			errs.Add("llm_provider", "llm_provider must be openai or on-prem for the llm-dispatch rerank provider")
+		}
+	}
+	return errs.Err()
+}
+
+// EnrichmentOptions add fields from an external service to the results of
+// queries to an ndb deployment, such as the owner of a document or the status of
+// a ticket. Endpoint is called with GET for each source id in the results, with
+// {source_id} replaced by the source id, and must return a json object. If
+// Fields is not empty only those fields are added. The responses are cached for
+// CacheTtlSeconds, and results that cannot be enriched within TimeoutMillis are
+// returned without the fields.
+type EnrichmentOptions struct {
+	Endpoint        string   `json:"endpoint" validate:"required"`
+	Fields          []string `json:"fields,omitempty"`
+	TimeoutMillis   int      `json:"timeout_ms" validate:"min=0,max=10000"`
+	CacheTtlSeconds int      `json:"cache_ttl_seconds" validate:"min=0"`
+	MaxCacheEntries int      `json:"max_cache_entries" validate:"min=0"`
+	MaxConcurrent   int      `json:"max_concurrent" validate:"min=0,max=100"`
+}
+
+const (
+	EnrichmentSourceIdPlaceholder = "{source_id}"
+
+	DefaultEnrichmentTimeoutMillis   = 500
+	DefaultEnrichmentCacheTtlSeconds = 60
+	DefaultEnrichmentMaxCacheEntries = 10000
+	DefaultEnrichmentMaxConcurrent   = 8
+)
+
+func (opts *EnrichmentOptions) Timeout() time.Duration {
+	if opts.TimeoutMillis <= 0 {
+		return DefaultEnrichmentTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(opts.TimeoutMillis) * time.Millisecond
+}
+
+func (opts *EnrichmentOptions) CacheTtl() time.Duration {
+	if opts.CacheTtlSeconds <= 0 {
+		return DefaultEnrichmentCacheTtlSeconds * time.Second
+	}
+	return time.Duration(opts.CacheTtlSeconds) * time.Second
+}
+
+func (opts *EnrichmentOptions) CacheLimit() int {
+	if opts.MaxCacheEntries <= 0 {
+		return DefaultEnrichmentMaxCacheEntries
+	}
+	return opts.MaxCacheEntries
+}
+
+func (opts *EnrichmentOptions) ConcurrencyLimit() int {
+	if opts.MaxConcurrent <= 0 {
+		return DefaultEnrichmentMaxConcurrent
+	}
+	return opts.MaxConcurrent
+}
+
+func (opts *EnrichmentOptions) Validate() error {
+	errs := validation.Struct(opts)
+	if opts.Endpoint != "" {
+		endpoint, err := url.Parse(opts.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			errs.Add("endpoint", "endpoint must be an http or https url")
+		} else if !strings.Contains(opts.Endpoint, EnrichmentSourceIdPlaceholder) {
+			errs.Add("endpoint", "endpoint must contain %v", EnrichmentSourceIdPlaceholder)
+		}
+	}
+	return errs.Err()
+}
+
+const (
+	// The ndb index files are read from disk as they are accessed by queries.
+	NdbLoadOnDemand = "on_demand"
+	// The ndb index files are read into the page cache before the ndb is opened.
+	NdbLoadPreload = "preload"
+
+	defaultWarmupTopk = 10
+)
+
+type NdbLoadOptions struct {
+	LoadMode      string   `json:"load_mode" validate:"oneof=on_demand preload"`
+	WarmupQueries []string `json:"warmup_queries"`
+	WarmupTopK    int      `json:"warmup_top_k" validate:"min=0"`
+}
+
+func (opts *NdbLoadOptions) Preload() bool {
+	return opts.LoadMode == NdbLoadPreload
+}
+
+func (opts *NdbLoadOptions) TopK() int {
+	if opts.WarmupTopK <= 0 {
+		return defaultWarmupTopk
+	}
+	return opts.WarmupTopK
+}
+
+func (opts *NdbLoadOptions) Validate() error {
+	if opts.LoadMode == "" {
+		opts.LoadMode = NdbLoadOnDemand
+	}
+	return validation.Struct(opts).Err()
+}
+
+// ShadowOptions mirror a percentage of the queries to an ndb deployment to the
+// deployment of another model, for example to compare a retrained model with
+// the model in production before promoting it. The shadow responses are only
+// used to measure how much the results diverge, and are never returned. At most
+// MaxInFlight shadow queries are sent at once, queries over the limit are not
+// mirrored so that a slow shadow deployment cannot back up the deployment.
+type ShadowOptions struct {
+	ModelId     uuid.UUID `json:"model_id" validate:"required"`
+	Percentage  float64   `json:"percentage" validate:"required,gt=0,max=100"`
+	MaxInFlight int       `json:"max_in_flight" validate:"min=0"`
+}
+
+const DefaultShadowMaxInFlight = 16
+
+func (opts *ShadowOptions) InFlightLimit() int {
+	if opts.MaxInFlight <= 0 {
+		return DefaultShadowMaxInFlight
+	}
+	return opts.MaxInFlight
+}
+
+func (opts *ShadowOptions) Validate() error {
+	return validation.Struct(opts).Err()
+}
+
+const DefaultAbstainLabel = "unsure"
+
+// ConfidenceThresholdOptions are the minimum scores that nlp-text and nlp-token
+// deployments need to return a prediction. Predictions with a lower score are
+// returned as AbstainLabel instead, so that they can be reviewed by a person.
+// ClassThresholds overrides MinConfidence for individual classes or tags.
+type ConfidenceThresholdOptions struct {
+	MinConfidence   float64            `json:"min_confidence" validate:"min=0,max=1"`
+	ClassThresholds map[string]float64 `json:"class_thresholds,omitempty"`
+	AbstainLabel    string             `json:"abstain_label"`
+}
+
+func (opts *ConfidenceThresholdOptions) Validate() error {
+	if opts.AbstainLabel == "" {
+		opts.AbstainLabel = DefaultAbstainLabel
+	}
+	errs := validation.Struct(opts)
+	for _, class := range slices.Sorted(maps.Keys(opts.ClassThresholds)) {
+		if threshold := opts.ClassThresholds[class]; threshold < 0 || threshold > 1 {
+			errs.Add("class_thresholds."+class, "confidence threshold for class '%v' must be between 0 and 1", class)
+		}
+	}
+	return errs.Err()
+}
+
+// AutoscalingOptions are the scaling bounds of a deployment with autoscaling
+// enabled. TargetCpu is the average cpu utilization, as a percent of the
+// allocated cpu, that the autoscaler tries to keep the replicas at. If
+// ScaleToZeroIdleSeconds is set the deployment is scaled to zero replicas once
+// it has been idle for that long.
+type AutoscalingOptions struct {
+	MinReplicas            int `json:"min_replicas"`
+	MaxReplicas            int `json:"max_replicas"`
+	TargetCpu              int `json:"target_cpu"`
+	ScaleToZeroIdleSeconds int `json:"scale_to_zero_idle_seconds"`
+}
+
+const (
+	DefaultAutoscalingTargetCpu = 70
+
+	// The autoscaler evaluates the policies every 30 seconds, so shorter idle
+	// timeouts would scale down deployments that are between requests.
+	minScaleToZeroIdleSeconds = 60
+)
+
+// Validate reports errors for the fields of the deploy and autoscaling
+// requests, such as autoscaling_min, since the options are set from them.
+func (opts *AutoscalingOptions) Validate() error {
+	if opts.TargetCpu == 0 {
+		opts.TargetCpu = DefaultAutoscalingTargetCpu
+	}
+
+	var errs validation.Errors
+	if opts.MinReplicas < 1 {
+		errs.Add("autoscaling_min", "autoscaling_min must be at least 1")
+	}
+	if opts.MaxReplicas < opts.MinReplicas {
+		errs.Add("autoscaling_max", "autoscaling_max cannot be less than autoscaling_min")
+	}
+	if opts.TargetCpu < 1 || opts.TargetCpu > 100 {
+		errs.Add("autoscaling_target_cpu", "autoscaling_target_cpu must be between 1 and 100")
+	}
+	if opts.ScaleToZeroIdleSeconds != 0 && opts.ScaleToZeroIdleSeconds < minScaleToZeroIdleSeconds {
+		errs.Add("scale_to_zero_idle_seconds", "scale_to_zero_idle_seconds must be 0 or at least %d", minScaleToZeroIdleSeconds)
+	}
+	return errs.Err()
+}
+
+const (
+	AntiAffinityPreferred = "preferred"
+	AntiAffinityRequired  = "required"
+
+	SpreadByNode = "node"
+	SpreadByZone = "zone"
+)
+
+// PlacementOptions control how the replicas of a deployment on kubernetes are
+// scheduled, so that a node drain or zone outage does not take down every
+// replica at once. AntiAffinity spreads the replicas across the nodes or zones
+// given by SpreadBy: with preferred the scheduler places replicas together if
+// there is no other option, with required the extra replicas stay pending.
+// MaxUnavailable creates a pod disruption budget that limits how many replicas
+// can be evicted at once by voluntary disruptions, such as node drains.
+type PlacementOptions struct {
+	AntiAffinity   string `json:"anti_affinity" validate:"oneof=preferred required"`
+	SpreadBy       string `json:"spread_by" validate:"oneof=node zone"`
+	MaxUnavailable int    `json:"max_unavailable" validate:"min=0"`
+}
+
+// TopologyKey is the node label that the replicas are spread across.
+func (opts *PlacementOptions) TopologyKey() string {
+	if opts.SpreadBy == SpreadByZone {
+		return "topology.kubernetes.io/zone"
+	}
+	return "kubernetes.io/hostname"
+}
+
+func (opts *PlacementOptions) Validate() error {
+	if opts.SpreadBy == "" {
+		opts.SpreadBy = SpreadByNode
+	}
+	errs := validation.Struct(opts)
+	if opts.AntiAffinity == "" && opts.MaxUnavailable == 0 {
+		errs.Add("", "anti_affinity or max_unavailable must be specified")
+	}
+	return errs.Err()
+}
+
+func ValidateConcurrencyLimits(limits map[string]ConcurrencyLimit) error {
+	var errs validation.Errors
+	for _, route := range slices.Sorted(maps.Keys(limits)) {
+		limit := limits[route]
+		if !slices.Contains(concurrencyLimitedRoutes, route) {
+			errs.Add(route, "invalid route '%v' for concurrency limit, must be one of %v", route, strings.Join(concurrencyLimitedRoutes, ", "))
+		}
+		if limit.MaxConcurrent < 0 || limit.MaxQueued < 0 || limit.QueueTimeoutSeconds < 0 {
+			errs.Add(route, "concurrency limit for route '%v' cannot have negative values", route)
+		}
+	}
+	return errs.Err()
 }
 
 func LoadDeployConfig(configPath string) (*DeployConfig, error) {
@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"reflect"
 	"slices"
+	"strings"
 	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils/validation"
 
 	"github.com/google/uuid"
 )
@@ -14,6 +18,9 @@ const (
 	FileLocS3     = "s3"
 	FileLocAzure  = "azure"
 	FileLocGcp    = "gcp"
+	// Dataset files refer to a version of a registered dataset by its name and
+	// version, as "name:version", or to its latest version by its name.
+	FileLocDataset = "dataset"
 )
 
 const (
@@ -21,32 +28,27 @@ const (
 	NlpDataType = "nlp"
 )
 
+// File validation modes control how a train job handles individual training
+// files that fail validation. In strict mode the job fails, in skip and report
+// mode the file is skipped and the failure is recorded in the train report.
+const (
+	FileValidationStrict        = "strict"
+	FileValidationSkipAndReport = "skip_and_report"
+)
+
 type TrainFile struct {
-	Path     string                 `json:"path"`
-	Location string                 `json:"location"`
+	Path     string                 `json:"path" validate:"required"`
+	Location string                 `json:"location" validate:"required,oneof=upload s3 azure gcp dataset"`
 	SourceId *string                `json:"source_id"`
 	Options  map[string]interface{} `json:"options"`
 	Metadata map[string]interface{} `json:"metadata"`
 }
 
-func validateFileInfo(files []TrainFile) error {
-	for i, file := range files {
-		if file.Path == "" {
-			return fmt.Errorf("file path cannot be empty")
-		}
-
-		switch file.Location {
-		case FileLocUpload, FileLocS3, FileLocAzure, FileLocGcp:
-			// ok
-		default:
-			return fmt.Errorf("invalid file location '%v', must be 'upload', 's3', 'azure', or 'gcp'", file.Location)
-
-		}
-		if file.Options == nil {
-			files[i].Options = map[string]interface{}{}
-		}
+func (file *TrainFile) Validate() error {
+	if file.Options == nil {
+		file.Options = map[string]interface{}{}
 	}
-	return nil
+	return validation.Struct(file).Err()
 }
 
 type NdbOptions struct {
@@ -71,6 +73,8 @@ type NDBData struct {
 	SupervisedFiles   []TrainFile `json:"supervised_files"`
 
 	Deletions []string `json:"deletions"`
+
+	FileValidation string `json:"file_validation" validate:"oneof=strict skip_and_report"`
 }
 
 func (data *NDBData) Validate() error {
@@ -86,85 +90,144 @@ func (data *NDBData) Validate() error {
 		data.Deletions = []string{}
 	}
 
+	errs := validation.Struct(data)
+
 	if len(data.UnsupervisedFiles)+len(data.SupervisedFiles) == 0 {
-		return fmt.Errorf("NDB training requires either supervised or unsupervised data")
+		errs.Add("unsupervised_files", "NDB training requires either supervised or unsupervised data")
 	}
 
-	if err := validateFileInfo(data.UnsupervisedFiles); err != nil {
-		return fmt.Errorf("invalid unsupervised files: %w", err)
+	// NDB training has always skipped documents it cannot parse, so that remains
+	// the default.
+	if data.FileValidation == "" {
+		data.FileValidation = FileValidationSkipAndReport
 	}
 
-	if err := validateFileInfo(data.SupervisedFiles); err != nil {
-		return fmt.Errorf("invalid supervised files: %w", err)
-	}
+	return errs.Err()
+}
 
-	return nil
+// TextPreprocessing is applied by the train job to the text or source column of
+// the training data. Text models also apply it to queries once deployed.
+type TextPreprocessing struct {
+	Lowercase bool `json:"lowercase"`
+	StripHtml bool `json:"strip_html"`
 }
 
 type NlpTokenOptions struct {
 	ModelType string `json:"model_type"`
 
 	TargetLabels []string `json:"target_labels"`
-	SourceColumn string   `json:"source_column"`
-	TargetColumn string   `json:"target_column"`
+	SourceColumn string   `json:"source_column" validate:"required"`
+	TargetColumn string   `json:"target_column" validate:"required"`
 	DefaultTag   string   `json:"default_tag"`
+
+	Preprocessing TextPreprocessing `json:"preprocessing"`
 }
 
 func (opts *NlpTokenOptions) Validate() error {
 	opts.ModelType = schema.NlpTokenModel
 
-	if opts.SourceColumn == "" {
-		return fmt.Errorf("source_column must be specified")
-	}
+	errs := validation.Struct(opts)
 
-	if opts.TargetColumn == "" {
-		return fmt.Errorf("target_column must be specified")
+	if opts.SourceColumn != "" && opts.SourceColumn == opts.TargetColumn {
+		errs.Add("target_column", "source_column and target_column must be different")
 	}
 
 	if opts.DefaultTag == "" {
 		opts.DefaultTag = "O"
 	}
 
-	return nil
+	return errs.Err()
 }
 
 type NlpTextOptions struct {
 	ModelType string `json:"model_type"`
 
-	TextColumn     string `json:"text_column"`
-	LabelColumn    string `json:"label_column"`
-	NTargetClasses int    `json:"n_target_classes"`
+	TextColumn     string `json:"text_column" validate:"required"`
+	LabelColumn    string `json:"label_column" validate:"required"`
+	NTargetClasses int    `json:"n_target_classes" validate:"required,gt=0"`
 	Delimiter      string `json:"delimiter"`
+
+	Preprocessing TextPreprocessing `json:"preprocessing"`
 }
 
+// Validate cannot be used as a validation.Validator since the default columns
+// depend on the request, so requests must validate the options explicitly.
 func (opts *NlpTextOptions) Validate(docClassification bool) error {
 	opts.ModelType = schema.NlpTextModel
 
-	if opts.TextColumn == "" {
-		if docClassification {
+	if docClassification {
+		if opts.TextColumn == "" {
 			opts.TextColumn = "text"
-		} else {
-			return fmt.Errorf("text_column must be specified")
 		}
-	}
-
-	if opts.LabelColumn == "" {
-		if docClassification {
+		if opts.LabelColumn == "" {
 			opts.LabelColumn = "labels"
-		} else {
-			return fmt.Errorf("label_column must be specified")
 		}
 	}
 
-	if opts.NTargetClasses <= 0 {
-		return fmt.Errorf("n_target_classes must be > 0")
+	errs := validation.Struct(opts)
+
+	if opts.TextColumn != "" && opts.TextColumn == opts.LabelColumn {
+		errs.Add("label_column", "text_column and label_column must be different")
 	}
 
 	if opts.Delimiter == "" {
 		opts.Delimiter = ","
 	}
 
-	return nil
+	return errs.Err()
+}
+
+const (
+	EncodingUtf8        = "utf-8"
+	EncodingUtf16       = "utf-16"
+	EncodingLatin1      = "latin-1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// CSVOptions describes the dialect of csv training files. Any options that are
+// not specified are detected from the file.
+type CSVOptions struct {
+	Delimiter string `json:"delimiter" validate:"char"`
+	Quote     string `json:"quote" validate:"ascii_char"`
+	Encoding  string `json:"encoding"`
+}
+
+func init() {
+	validation.Register("char", func(value reflect.Value, param string) string {
+		if len([]rune(value.String())) != 1 {
+			return "must be a single character"
+		}
+		return ""
+	})
+	validation.Register("ascii_char", func(value reflect.Value, param string) string {
+		if s := value.String(); len(s) != 1 || s[0] >= 0x80 {
+			return "must be a single ascii character"
+		}
+		return ""
+	})
+}
+
+func (opts *CSVOptions) Validate() error {
+	errs := validation.Struct(opts)
+
+	if opts.Delimiter != "" && opts.Delimiter == opts.Quote {
+		errs.Add("quote", "csv delimiter and quote must be different")
+	}
+
+	switch strings.ToLower(opts.Encoding) {
+	case "", EncodingUtf8, EncodingUtf16, EncodingLatin1, EncodingWindows1252:
+		opts.Encoding = strings.ToLower(opts.Encoding)
+	case "utf8":
+		opts.Encoding = EncodingUtf8
+	case "iso-8859-1", "latin1":
+		opts.Encoding = EncodingLatin1
+	case "cp1252":
+		opts.Encoding = EncodingWindows1252
+	default:
+		errs.Add("encoding", "unsupported csv encoding '%v', supported encodings are %v, %v, %v, %v", opts.Encoding, EncodingUtf8, EncodingUtf16, EncodingLatin1, EncodingWindows1252)
+	}
+
+	return errs.Err()
 }
 
 type NlpData struct {
@@ -172,6 +235,13 @@ type NlpData struct {
 
 	SupervisedFiles []TrainFile `json:"supervised_files"`
 	TestFiles       []TrainFile `json:"test_files"`
+
+	FileValidation string `json:"file_validation" validate:"oneof=strict skip_and_report"`
+
+	// If specified the train job converts the csv files to utf-8 with the
+	// delimiter expected by the model before training. Parquet and jsonl files
+	// are always converted to csv.
+	CsvOptions *CSVOptions `json:"csv_options"`
 }
 
 func (data *NlpData) Validate() error {
@@ -184,19 +254,17 @@ func (data *NlpData) Validate() error {
 		data.TestFiles = []TrainFile{}
 	}
 
-	if len(data.SupervisedFiles) == 0 {
-		return fmt.Errorf("Nlp training requires training files")
-	}
+	errs := validation.Struct(data)
 
-	if err := validateFileInfo(data.SupervisedFiles); err != nil {
-		return fmt.Errorf("invalid supervised files: %w", err)
+	if len(data.SupervisedFiles) == 0 {
+		errs.Add("supervised_files", "Nlp training requires training files")
 	}
 
-	if err := validateFileInfo(data.TestFiles); err != nil {
-		return fmt.Errorf("invalid test files: %w", err)
+	if data.FileValidation == "" {
+		data.FileValidation = FileValidationStrict
 	}
 
-	return nil
+	return errs.Err()
 }
 
 type NlpTrainOptions struct {
@@ -204,10 +272,220 @@ type NlpTrainOptions struct {
 	LearningRate       float32  `json:"learning_rate"`
 	BatchSize          int      `json:"batch_size"`
 	MaxInMemoryBatches *int     `json:"max_in_memory_batches"`
-	TestSplit          *float32 `json:"test_split"`
+	TestSplit          *float32 `json:"test_split" validate:"min=0,lt=1"`
+	TestSplitSeed      *int     `json:"test_split_seed"`
+
+	// TestSplitStrategy is either random, where the train job splits each
+	// train file, or stratified, where model bazaar splits the uploaded train
+	// files by label when the train request is made.
+	TestSplitStrategy string `json:"test_split_strategy" validate:"oneof=random stratified"`
+
+	// Minimum values for metrics of the holdout evaluation run after training,
+	// for example {"precision@1": 0.8}. Models that do not meet the thresholds
+	// cannot be deployed unless the thresholds are explicitly ignored.
+	EvalThresholds map[string]float32 `json:"eval_thresholds"`
+
+	// Evaluation configures optional fairness and robustness checks that are
+	// run on the holdout data after training.
+	Evaluation *EvaluationOptions `json:"evaluation"`
+
+	ImbalanceOptions
+}
+
+const (
+	ClassWeightingNone     = "none"
+	ClassWeightingBalanced = "balanced"
+	ClassWeightingCustom   = "custom"
+
+	OversamplingNone   = "none"
+	OversamplingRandom = "random"
+
+	DefaultOversamplingRatio = 0.5
+)
+
+// ImbalanceOptions control how the train job mitigates class imbalance in the
+// training data, they are not applied to test data. The class of a row of token
+// classification data is its least frequent tag.
+//
+// Class weights are applied by resampling the rows of each class in proportion
+// to its weight, since the models do not support weighted losses. Balanced
+// weights are inversely proportional to the frequency of each class, so they
+// keep the number of rows the same. Random oversampling samples rows of each
+// class with replacement until it has at least oversampling_ratio times as many
+// rows as the largest class, and is applied before the class weights.
+type ImbalanceOptions struct {
+	ClassWeighting    string             `json:"class_weighting" validate:"oneof=none balanced custom"`
+	ClassWeights      map[string]float32 `json:"class_weights,omitempty"`
+	Oversampling      string             `json:"oversampling" validate:"oneof=none random"`
+	OversamplingRatio float32            `json:"oversampling_ratio"`
 }
 
+func (opts *ImbalanceOptions) Validate() error {
+	errs := validation.Struct(opts)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if opts.ClassWeighting == "" {
+		if len(opts.ClassWeights) > 0 {
+			opts.ClassWeighting = ClassWeightingCustom
+		} else {
+			opts.ClassWeighting = ClassWeightingNone
+		}
+	}
+
+	if opts.ClassWeighting == ClassWeightingCustom {
+		if len(opts.ClassWeights) == 0 {
+			errs.Add("class_weights", "class_weights must be specified for custom class weighting")
+		}
+		for _, class := range slices.Sorted(maps.Keys(opts.ClassWeights)) {
+			if opts.ClassWeights[class] <= 0 {
+				errs.Add("class_weights."+class, "class weight for class '%v' must be > 0", class)
+			}
+		}
+	} else if len(opts.ClassWeights) > 0 {
+		errs.Add("class_weights", "class_weights can only be specified for custom class weighting")
+	}
+
+	if opts.Oversampling == "" {
+		opts.Oversampling = OversamplingNone
+	}
+
+	if opts.Oversampling == OversamplingRandom {
+		if opts.OversamplingRatio == 0 {
+			opts.OversamplingRatio = DefaultOversamplingRatio
+		}
+		if opts.OversamplingRatio < 0 || opts.OversamplingRatio > 1 {
+			errs.Add("oversampling_ratio", "oversampling_ratio must be in the range (0, 1]")
+		}
+	} else if opts.OversamplingRatio != 0 {
+		errs.Add("oversampling_ratio", "oversampling_ratio can only be specified for random oversampling")
+	}
+
+	return errs.Err()
+}
+
+// SuggestImbalanceOptions suggests imbalance options from the number of rows
+// (or tags for token classification) of each class. Moderately imbalanced data
+// uses balanced class weights. For heavily imbalanced data the balanced weights
+// would drop most rows of the largest classes, so random oversampling is used
+// instead.
+func SuggestImbalanceOptions(counts map[string]int) ImbalanceOptions {
+	suggestion := ImbalanceOptions{ClassWeighting: ClassWeightingNone, Oversampling: OversamplingNone}
+
+	ratio := ImbalanceRatio(counts)
+	switch {
+	case ratio >= 20:
+		suggestion.Oversampling = OversamplingRandom
+		suggestion.OversamplingRatio = DefaultOversamplingRatio
+	case ratio >= 3:
+		suggestion.ClassWeighting = ClassWeightingBalanced
+	}
+
+	return suggestion
+}
+
+// ImbalanceRatio returns the ratio of the size of the largest class to the size
+// of the smallest class.
+func ImbalanceRatio(counts map[string]int) float64 {
+	largest, smallest := 0, 0
+	for _, count := range counts {
+		largest = max(largest, count)
+		if smallest == 0 || count < smallest {
+			smallest = count
+		}
+	}
+	if smallest == 0 {
+		return 1
+	}
+	return float64(largest) / float64(smallest)
+}
+
+const (
+	PerturbationLowercase         = "lowercase"
+	PerturbationUppercase         = "uppercase"
+	PerturbationTypos             = "typos"
+	PerturbationRemovePunctuation = "remove_punctuation"
+
+	DefaultMinGroupSize = 10
+)
+
+var perturbations = []string{PerturbationLowercase, PerturbationUppercase, PerturbationTypos, PerturbationRemovePunctuation}
+
+// EvaluationOptions configure the fairness and robustness checks run by the
+// train job on the holdout data after training. The results are written to the
+// train report, and checks that exceed their limits are reported as warnings,
+// they do not block deployment like the eval thresholds.
+//
+// Group metrics split the holdout data by the value of group_column, which is
+// a metadata column of the test data that is not used by the model, and compare
+// the metrics of each group. Groups with fewer than min_group_size rows are
+// skipped. Perturbation tests evaluate the model on copies of the holdout data
+// with perturbed text, and compare the metrics to those of the original data.
+type EvaluationOptions struct {
+	GroupColumn  string   `json:"group_column"`
+	MinGroupSize int      `json:"min_group_size" validate:"min=0"`
+	MaxGroupGap  *float32 `json:"max_group_gap" validate:"min=0,max=1"`
+
+	Perturbations       []string `json:"perturbations"`
+	PerturbationSeed    *int     `json:"perturbation_seed"`
+	MaxPerturbationDrop *float32 `json:"max_perturbation_drop" validate:"min=0,max=1"`
+}
+
+func (opts *EvaluationOptions) Validate() error {
+	errs := validation.Struct(opts)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if opts.GroupColumn == "" && len(opts.Perturbations) == 0 {
+		errs.Add("", "evaluation requires group_column or perturbations to be specified")
+	}
+
+	if opts.GroupColumn != "" {
+		if opts.MinGroupSize == 0 {
+			opts.MinGroupSize = DefaultMinGroupSize
+		}
+	} else if opts.MaxGroupGap != nil || opts.MinGroupSize != 0 {
+		errs.Add("group_column", "group_column must be specified for group metrics")
+	}
+
+	for i, perturbation := range opts.Perturbations {
+		if !slices.Contains(perturbations, perturbation) {
+			errs.Add(fmt.Sprintf("perturbations[%d]", i), "invalid perturbation '%v', must be one of '%v'", perturbation, strings.Join(perturbations, "', '"))
+		} else if slices.Index(opts.Perturbations, perturbation) < i {
+			errs.Add(fmt.Sprintf("perturbations[%d]", i), "perturbation '%v' is specified more than once", perturbation)
+		}
+	}
+	if len(opts.Perturbations) == 0 && (opts.MaxPerturbationDrop != nil || opts.PerturbationSeed != nil) {
+		errs.Add("perturbations", "perturbations must be specified for perturbation tests")
+	}
+
+	return errs.Err()
+}
+
+const (
+	TestSplitRandom     = "random"
+	TestSplitStratified = "stratified"
+)
+
 func (opts *NlpTrainOptions) Validate() error {
+	// This also validates the imbalance options.
+	errs := validation.Struct(opts)
+
+	if opts.TestSplitStrategy == "" {
+		opts.TestSplitStrategy = TestSplitRandom
+	}
+	if opts.TestSplitStrategy == TestSplitStratified && (opts.TestSplit == nil || *opts.TestSplit == 0) {
+		errs.Add("test_split", "test_split must be specified for a stratified test split")
+	}
+
+	for _, metric := range slices.Sorted(maps.Keys(opts.EvalThresholds)) {
+		if threshold := opts.EvalThresholds[metric]; threshold < 0 || threshold > 1 {
+			errs.Add("eval_thresholds."+metric, "eval threshold for metric '%v' must be between 0 and 1", metric)
+		}
+	}
+
 	if opts.Epochs == 0 {
 		opts.Epochs = 1
 	}
@@ -220,7 +498,30 @@ func (opts *NlpTrainOptions) Validate() error {
 		opts.BatchSize = 2048
 	}
 
-	return nil
+	return errs.Err()
+}
+
+// ValidateHoldout checks that there is holdout data to evaluate the eval
+// thresholds and evaluation checks on, either from test files or from
+// splitting the train files.
+func (opts *NlpTrainOptions) ValidateHoldout(testFiles []TrainFile) error {
+	var errs validation.Errors
+	if len(opts.EvalThresholds) > 0 && len(testFiles) == 0 && (opts.TestSplit == nil || *opts.TestSplit == 0) {
+		errs.Add("eval_thresholds", "eval_thresholds require test_files or test_split to be specified")
+	}
+	if opts.TestSplitStrategy == TestSplitStratified && len(testFiles) > 0 {
+		errs.Add("test_split_strategy", "a stratified test split cannot be used with test_files")
+	}
+	if opts.Evaluation != nil {
+		if len(testFiles) == 0 && (opts.TestSplit == nil || *opts.TestSplit == 0) {
+			errs.Add("evaluation", "evaluation requires test_files or test_split to be specified")
+		}
+		// Stratified splits only keep the source and target columns.
+		if opts.Evaluation.GroupColumn != "" && opts.TestSplitStrategy == TestSplitStratified {
+			errs.Add("evaluation.group_column", "group_column cannot be used with a stratified test split")
+		}
+	}
+	return errs.Err()
 }
 
 type TrainConfig struct {
@@ -246,9 +547,21 @@ type TrainConfig struct {
 	GenerativeSupervision bool `json:"generative_supervision"`
 }
 
+// DefaultJobPriority is the priority of train jobs that do not specify one,
+// which is also the default priority of nomad jobs.
+const DefaultJobPriority = 50
+
 type JobOptions struct {
 	AllocationCores  int `json:"allocation_cores"`
 	AllocationMemory int `json:"allocation_memory"`
+
+	// Priority is the priority of train jobs from 1 to 100, 0 uses the default
+	// priority. Queued jobs with a higher priority are started first, and the
+	// orchestrator schedules them first if the cluster is full. If Preempt is
+	// set and there is not enough license capacity for the job, running train
+	// jobs with a lower priority are stopped and queued again to make room.
+	Priority int  `json:"priority,omitempty" validate:"min=0,max=100"`
+	Preempt  bool `json:"preempt,omitempty"`
 }
 
 func (opts *JobOptions) Validate() error {
@@ -256,7 +569,16 @@ func (opts *JobOptions) Validate() error {
 	if opts.AllocationMemory < 500 {
 		opts.AllocationMemory = 6800
 	}
-	return nil
+	return validation.Struct(opts).Err()
+}
+
+// EffectivePriority is the priority of the job, with the default priority if
+// none is specified.
+func (opts *JobOptions) EffectivePriority() int {
+	if opts.Priority == 0 {
+		return DefaultJobPriority
+	}
+	return opts.Priority
 }
 
 func (opts *JobOptions) CpuUsageMhz() int {
@@ -264,18 +586,16 @@ func (opts *JobOptions) CpuUsageMhz() int {
 }
 
 type LLMConfig struct {
-	Provider  string `json:"provider"`
+	Provider  string `json:"provider" validate:"required,oneof=openai cohere onprem mock"`
 	ApiKey    string `json:"api_key,omitempty"`
 	BaseUrl   string `json:"base_url,omitempty"`
 	ModelName string `json:"model_name,omitempty"`
 }
 
 func (opts *LLMConfig) Validate() error {
-	if !slices.Contains([]string{"openai", "cohere", "onprem", "mock"}, opts.Provider) {
-		return fmt.Errorf("invalid provider '%v', must be 'openai' or 'cohere'", opts.Provider)
+	errs := validation.Struct(opts)
+	if len(errs) == 0 && !slices.Contains([]string{"onprem", "mock"}, opts.Provider) && opts.ApiKey == "" {
+		errs.Add("api_key", "api_key must be specified")
 	}
-	if !slices.Contains([]string{"onprem", "mock"}, opts.Provider) && opts.ApiKey == "" {
-		return fmt.Errorf("api_key must be specified")
-	}
-	return nil
+	return errs.Err()
 }
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"thirdai_platform/utils"
+	"time"
+)
+
+// KafkaRestPublisher publishes events through a Kafka REST proxy (the
+// Confluent REST Proxy v2 API), which avoids having the platform speak the
+// native Kafka protocol. Events are keyed by model or team id so they are
+// routed to the same partition and stay ordered for each entity.
+type KafkaRestPublisher struct {
+	url   string
+	topic string
+
+	client *http.Client
+}
+
+func NewKafkaRestPublisher(url, topic string) *KafkaRestPublisher {
+	return &KafkaRestPublisher{url: url, topic: topic, client: &http.Client{Timeout: 10 * time.Second, Transport: utils.OutboundTransport()}}
+}
+
+func (p *KafkaRestPublisher) Name() string {
+	return "kafka"
+}
+
+type kafkaRecord struct {
+	Key   string  `json:"key"`
+	Value Payload `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition *int    `json:"partition"`
+		Offset    *int64  `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (p *KafkaRestPublisher) Publish(payload Payload) error {
+	endpoint, err := url.JoinPath(p.url, "topics", p.topic)
+	if err != nil {
+		return fmt.Errorf("invalid kafka rest proxy url: %w", err)
+	}
+
+	body, err := json.Marshal(kafkaProduceRequest{Records: []kafkaRecord{{Key: payload.Key(), Value: payload}}})
+	if err != nil {
+		return fmt.Errorf("error serializing event: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating kafka produce request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending kafka produce request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("kafka rest proxy returned status %d: %v", res.StatusCode, string(content))
+	}
+
+	var produceRes kafkaProduceResponse
+	if err := json.NewDecoder(res.Body).Decode(&produceRes); err != nil {
+		return fmt.Errorf("error parsing kafka produce response: %w", err)
+	}
+
+	for _, offset := range produceRes.Offsets {
+		if offset.Error != nil && *offset.Error != "" {
+			return fmt.Errorf("kafka produce failed: %v", *offset.Error)
+		}
+	}
+
+	return nil
+}
+
+func (p *KafkaRestPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const natsTimeout = 10 * time.Second
+
+// NatsPublisher publishes events using the NATS client protocol. Each publish
+// is followed by a PING so that the publish is only reported as successful
+// once the server has processed it. Events are published to the subject
+// <subject>.<event type>, so consumers can subscribe to specific event types
+// using subject wildcards.
+type NatsPublisher struct {
+	url     string
+	subject string
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewNatsPublisher(url, subject string) *NatsPublisher {
+	return &NatsPublisher{url: url, subject: subject}
+}
+
+func (p *NatsPublisher) Name() string {
+	return "nats"
+}
+
+func (p *NatsPublisher) connect() error {
+	u, err := url.Parse(p.url)
+	if err != nil {
+		return fmt.Errorf("invalid nats url: %w", err)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, natsTimeout)
+	if err != nil {
+		return fmt.Errorf("error connecting to nats server: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error reading nats server info: %w", err)
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected message from nats server: %v", strings.TrimSpace(info))
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "thirdai-platform"}
+	if u.User != nil {
+		if pwd, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = pwd
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	optionsJson, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error serializing nats connect options: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", optionsJson); err != nil {
+		conn.Close()
+		return fmt.Errorf("error sending nats connect: %w", err)
+	}
+
+	p.conn = conn
+	p.reader = reader
+
+	slog.Info("connected to nats server", "url", u.Host)
+
+	return nil
+}
+
+func (p *NatsPublisher) waitForPong() error {
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("error reading from nats server: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("error responding to nats ping: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats server returned error: %v", line)
+		}
+	}
+}
+
+func (p *NatsPublisher) Publish(payload Payload) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error serializing event: %w", err)
+	}
+
+	subject := p.subject + "." + payload.Type
+
+	_ = p.conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(data), data)
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		p.Close()
+		return fmt.Errorf("error publishing to nats: %w", err)
+	}
+
+	if err := p.waitForPong(); err != nil {
+		p.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (p *NatsPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	p.reader = nil
+	return err
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is included in every published payload so that consumers can
+// detect breaking changes to the event format. It must be incremented if any
+// field is removed or changes meaning.
+const SchemaVersion = 1
+
+type Payload struct {
+	SchemaVersion int             `json:"schema_version"`
+	Id            uint64          `json:"id"`
+	Type          string          `json:"type"`
+	ModelId       *uuid.UUID      `json:"model_id"`
+	TeamId        *uuid.UUID      `json:"team_id"`
+	Data          json.RawMessage `json:"data"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+// Key returns the key used to partition events so that all events for the
+// same model or team are delivered in order.
+func (p *Payload) Key() string {
+	if p.ModelId != nil {
+		return p.ModelId.String()
+	}
+	if p.TeamId != nil {
+		return p.TeamId.String()
+	}
+	return p.Type
+}
+
+type Publisher interface {
+	Publish(payload Payload) error
+
+	Close() error
+
+	Name() string
+}
+
+type PublisherArgs struct {
+	Kind  string
+	Url   string
+	Topic string
+}
+
+func NewPublisher(args PublisherArgs) (Publisher, error) {
+	if args.Url == "" {
+		return nil, fmt.Errorf("event publisher url must be specified")
+	}
+	if args.Topic == "" {
+		return nil, fmt.Errorf("event publisher topic must be specified")
+	}
+
+	switch args.Kind {
+	case "nats":
+		return NewNatsPublisher(args.Url, args.Topic), nil
+	case "kafka":
+		return NewKafkaRestPublisher(args.Url, args.Topic), nil
+	default:
+		return nil, fmt.Errorf("invalid event publisher '%v', must be 'nats' or 'kafka'", args.Kind)
+	}
+}
@@ -0,0 +1,239 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+type FieldDef struct {
+	// Type is the object type returned by the field, or nil if the field is a
+	// scalar. Fields returning objects may resolve to a single value or a slice.
+	Type    *Object
+	Resolve ResolveFunc
+}
+
+type Object struct {
+	Name   string
+	Fields map[string]*FieldDef
+}
+
+func NewObject(name string) *Object {
+	return &Object{Name: name, Fields: map[string]*FieldDef{}}
+}
+
+func (o *Object) Field(name string, typ *Object, resolve ResolveFunc) *Object {
+	o.Fields[name] = &FieldDef{Type: typ, Resolve: resolve}
+	return o
+}
+
+type Error struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+type Response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []Error                `json:"errors,omitempty"`
+}
+
+const (
+	// maxQueryDepth is the maximum nesting of fields with selection sets in a
+	// query, each level can run a database query for every value of its parent.
+	maxQueryDepth = 10
+	// maxQueryFields is the maximum number of fields in a query once its
+	// fragments are expanded.
+	maxQueryFields = 500
+	// maxResolvedFields is the maximum number of fields resolved while executing
+	// a query, since fields inside lists are resolved once for each item.
+	maxResolvedFields = 100000
+)
+
+type executor struct {
+	doc       *Document
+	variables map[string]interface{}
+	errors    []Error
+	resolved  int
+}
+
+// Execute runs the query against the root object. Errors from individual
+// resolvers are collected in the response and the corresponding field is set
+// to null, so that a partial result is still returned.
+func Execute(ctx context.Context, query *Object, source string, variables map[string]interface{}) Response {
+	doc, err := Parse(source)
+	if err != nil {
+		return Response{Errors: []Error{{Message: fmt.Sprintf("syntax error: %v", err)}}}
+	}
+
+	if err := checkLimits(doc); err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{doc: doc, variables: variables}
+	data := e.executeSelections(ctx, query, nil, doc.Selections, nil, map[string]bool{})
+
+	return Response{Data: data, Errors: e.errors}
+}
+
+// checkLimits rejects queries that are nested too deeply, that have too many
+// fields, or whose fragments spread themselves, before any field is resolved.
+func checkLimits(doc *Document) error {
+	fields := 0
+	return checkSelections(doc, doc.Selections, 1, map[string]bool{}, &fields)
+}
+
+func checkSelections(doc *Document, selections []Selection, depth int, visiting map[string]bool, fields *int) error {
+	for _, selection := range selections {
+		if selection.Fragment != "" {
+			fragment, ok := doc.Fragments[selection.Fragment]
+			if !ok {
+				// Unknown fragments are reported as errors when the query is executed.
+				continue
+			}
+			if visiting[selection.Fragment] {
+				return fmt.Errorf("fragment %v is recursive", selection.Fragment)
+			}
+			visiting[selection.Fragment] = true
+			if err := checkSelections(doc, fragment, depth, visiting, fields); err != nil {
+				return err
+			}
+			delete(visiting, selection.Fragment)
+			continue
+		}
+
+		*fields++
+		if *fields > maxQueryFields {
+			return fmt.Errorf("query has more than the maximum of %d fields", maxQueryFields)
+		}
+
+		if len(selection.Field.Selections) > 0 {
+			if depth >= maxQueryDepth {
+				return fmt.Errorf("query is nested more than the maximum depth of %d", maxQueryDepth)
+			}
+			if err := checkSelections(doc, selection.Field.Selections, depth+1, visiting, fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) fail(path []string, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: append([]string{}, path...)})
+}
+
+func (e *executor) resolveArgs(field *Field) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(field.Args))
+	for name, value := range field.Args {
+		if value.Variable != "" {
+			v, ok := e.variables[value.Variable]
+			if !ok {
+				return nil, fmt.Errorf("variable $%v is not defined", value.Variable)
+			}
+			args[name] = v
+		} else {
+			args[name] = value.Literal
+		}
+	}
+	return args, nil
+}
+
+func (e *executor) executeSelections(
+	ctx context.Context, obj *Object, source interface{}, selections []Selection, path []string, visiting map[string]bool,
+) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	for _, selection := range selections {
+		if selection.Fragment != "" {
+			fragment, ok := e.doc.Fragments[selection.Fragment]
+			if !ok {
+				e.fail(path, fmt.Errorf("unknown fragment %v", selection.Fragment))
+				continue
+			}
+			if visiting[selection.Fragment] {
+				e.fail(path, fmt.Errorf("fragment %v is recursive", selection.Fragment))
+				continue
+			}
+			visiting[selection.Fragment] = true
+			for k, v := range e.executeSelections(ctx, obj, source, fragment, path, visiting) {
+				result[k] = v
+			}
+			delete(visiting, selection.Fragment)
+			continue
+		}
+
+		field := selection.Field
+		fieldPath := append(append([]string{}, path...), field.ResultKey())
+
+		if field.Name == "__typename" {
+			result[field.ResultKey()] = obj.Name
+			continue
+		}
+
+		def, ok := obj.Fields[field.Name]
+		if !ok {
+			e.fail(fieldPath, fmt.Errorf("field '%v' does not exist on type %v", field.Name, obj.Name))
+			continue
+		}
+
+		result[field.ResultKey()] = e.executeField(ctx, def, source, field, fieldPath, visiting)
+	}
+
+	return result
+}
+
+// executeField resolves the field and its selections. The fragments that are
+// being expanded are passed to the selections, so that a fragment which spreads
+// itself through a nested field is not expanded again.
+func (e *executor) executeField(
+	ctx context.Context, def *FieldDef, source interface{}, field *Field, path []string, visiting map[string]bool,
+) interface{} {
+	if def.Type == nil && len(field.Selections) > 0 {
+		e.fail(path, fmt.Errorf("field '%v' is a scalar and cannot have a selection set", field.Name))
+		return nil
+	}
+	if def.Type != nil && len(field.Selections) == 0 {
+		e.fail(path, fmt.Errorf("field '%v' of type %v must have a selection set", field.Name, def.Type.Name))
+		return nil
+	}
+
+	e.resolved++
+	if e.resolved > maxResolvedFields {
+		if e.resolved == maxResolvedFields+1 {
+			e.fail(path, fmt.Errorf("query resolves more than the maximum of %d fields", maxResolvedFields))
+		}
+		return nil
+	}
+
+	args, err := e.resolveArgs(field)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	value, err := def.Resolve(ctx, source, args)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	if def.Type == nil || value == nil {
+		return value
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil
+	}
+	if rv.Kind() == reflect.Slice {
+		list := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list = append(list, e.executeSelections(ctx, def.Type, rv.Index(i).Interface(), field.Selections, path, visiting))
+		}
+		return list
+	}
+
+	return e.executeSelections(ctx, def.Type, value, field.Selections, path, visiting)
+}
@@ -0,0 +1,406 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This package implements the read-only subset of GraphQL needed to serve
+// metadata queries: a single query operation with nested selection sets,
+// aliases, arguments, variables, and named fragments. Mutations,
+// subscriptions, and directives are rejected at parse time.
+
+type Field struct {
+	Alias      string
+	Name       string
+	Args       map[string]Value
+	Selections []Selection
+}
+
+func (f *Field) ResultKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// A Selection is either a field or a fragment spread. Fragment spreads are
+// resolved against the document's fragments during execution.
+type Selection struct {
+	Field    *Field
+	Fragment string
+}
+
+type Value struct {
+	Variable string
+	Literal  interface{}
+}
+
+type Document struct {
+	Selections []Selection
+	Fragments  map[string][]Selection
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokPunct
+	tokString
+	tokNumber
+	tokSpread
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ',' || unicode.IsSpace(rune(c)):
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}():$!=[]", rune(c)):
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, token{kind: tokSpread, text: "...", pos: i})
+			i += 3
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			value, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", start, err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: value, pos: start})
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			for i < len(src) && strings.ContainsRune("0123456789.eE+-", rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokName, text: src[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(src)})
+	return tokens, nil
+}
+
+// maxNesting is the maximum nesting of selection sets and list values in a
+// document, so that deeply nested documents cannot exhaust the stack.
+const maxNesting = 32
+
+type parser struct {
+	tokens []token
+	idx    int
+	depth  int
+}
+
+// enter is called when the parser starts a nested selection set or list, the
+// returned function must be called when it ends.
+func (p *parser) enter() (func(), error) {
+	if p.depth >= maxNesting {
+		return nil, fmt.Errorf("document is nested more than %d levels deep at position %d", maxNesting, p.peek().pos)
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.idx]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.idx]
+	if t.kind != tokEOF {
+		p.idx++
+	}
+	return t
+}
+
+func (p *parser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text
+}
+
+func (p *parser) expectPunct(text string) error {
+	t := p.next()
+	if t.kind != tokPunct || t.text != text {
+		return fmt.Errorf("expected '%v' at position %d", text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	t := p.next()
+	if t.kind != tokName {
+		return "", fmt.Errorf("expected name at position %d", t.pos)
+	}
+	return t.text, nil
+}
+
+func Parse(query string) (*Document, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	doc := &Document{Fragments: map[string][]Selection{}}
+	foundOperation := false
+
+	for p.peek().kind != tokEOF {
+		if p.isPunct("{") {
+			if foundOperation {
+				return nil, fmt.Errorf("only a single operation is supported per request")
+			}
+			doc.Selections, err = p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			foundOperation = true
+			continue
+		}
+
+		keyword, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		switch keyword {
+		case "query":
+			if foundOperation {
+				return nil, fmt.Errorf("only a single operation is supported per request")
+			}
+			if p.peek().kind == tokName {
+				p.next() // Operation name is not used.
+			}
+			if p.isPunct("(") {
+				if err := p.skipVariableDefinitions(); err != nil {
+					return nil, err
+				}
+			}
+			doc.Selections, err = p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			foundOperation = true
+		case "fragment":
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if on, err := p.expectName(); err != nil || on != "on" {
+				return nil, fmt.Errorf("expected 'on' in definition of fragment %v", name)
+			}
+			if _, err := p.expectName(); err != nil {
+				return nil, err
+			}
+			selections, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments[name] = selections
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("%v operations are not supported, the graphql api is read only", keyword)
+		default:
+			return nil, fmt.Errorf("unexpected '%v' in document", keyword)
+		}
+	}
+
+	if !foundOperation {
+		return nil, fmt.Errorf("document does not contain a query")
+	}
+
+	return doc, nil
+}
+
+// Variable types are not needed to execute the query since argument values
+// are coerced by the resolvers, so the definitions are only validated for
+// syntax and then discarded.
+func (p *parser) skipVariableDefinitions() error {
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	for !p.isPunct(")") {
+		t := p.next()
+		if t.kind == tokEOF {
+			return fmt.Errorf("unterminated variable definitions")
+		}
+	}
+	return p.expectPunct(")")
+}
+
+func (p *parser) parseSelectionSet() ([]Selection, error) {
+	exit, err := p.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer exit()
+
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	selections := []Selection{}
+	for !p.isPunct("}") {
+		if p.peek().kind == tokSpread {
+			p.next()
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			selections = append(selections, Selection{Fragment: name})
+			continue
+		}
+
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, Selection{Field: field})
+	}
+	p.next()
+
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("selection set cannot be empty")
+	}
+
+	return selections, nil
+}
+
+func (p *parser) parseField() (*Field, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	field := &Field{Name: name}
+	if p.isPunct(":") {
+		p.next()
+		field.Alias = name
+		field.Name, err = p.expectName()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isPunct("(") {
+		p.next()
+		field.Args = map[string]Value{}
+		for !p.isPunct(")") {
+			argName, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			field.Args[argName] = value
+		}
+		p.next()
+	}
+
+	if p.isPunct("{") {
+		field.Selections, err = p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return field, nil
+}
+
+func (p *parser) parseValue() (Value, error) {
+	t := p.next()
+	switch t.kind {
+	case tokPunct:
+		if t.text == "$" {
+			name, err := p.expectName()
+			if err != nil {
+				return Value{}, err
+			}
+			return Value{Variable: name}, nil
+		}
+		if t.text == "[" {
+			exit, err := p.enter()
+			if err != nil {
+				return Value{}, err
+			}
+			defer exit()
+
+			list := []interface{}{}
+			for !p.isPunct("]") {
+				item, err := p.parseValue()
+				if err != nil {
+					return Value{}, err
+				}
+				if item.Variable != "" {
+					return Value{}, fmt.Errorf("variables are not supported inside list values")
+				}
+				list = append(list, item.Literal)
+			}
+			p.next()
+			return Value{Literal: list}, nil
+		}
+	case tokString:
+		return Value{Literal: t.text}, nil
+	case tokNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return Value{Literal: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("invalid number '%v' at position %d", t.text, t.pos)
+		}
+		return Value{Literal: f}, nil
+	case tokName:
+		switch t.text {
+		case "true":
+			return Value{Literal: true}, nil
+		case "false":
+			return Value{Literal: false}, nil
+		case "null":
+			return Value{Literal: nil}, nil
+		default:
+			// Enum values are passed to resolvers as strings.
+			return Value{Literal: t.text}, nil
+		}
+	}
+	return Value{}, fmt.Errorf("unexpected token at position %d", t.pos)
+}
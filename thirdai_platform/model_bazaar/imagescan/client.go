@@ -0,0 +1,151 @@
+package imagescan
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ResultAllowed = "allowed"
+	ResultWarned  = "warned"
+	ResultBlocked = "blocked"
+)
+
+type cachedReport struct {
+	report    Report
+	scannedAt time.Time
+}
+
+// Client wraps an orchestrator client so that the images for each job are
+// scanned before the job is started. The scan of each image is recorded in the
+// db regardless of the result.
+type Client struct {
+	orchestrator.Client
+
+	scanner Scanner
+	policy  Policy
+	db      *gorm.DB
+
+	// Scan results are cached since most jobs use the same image.
+	cacheTTL time.Duration
+	mu       sync.Mutex
+	cache    map[string]cachedReport
+}
+
+func NewClient(client orchestrator.Client, scanner Scanner, policy Policy, db *gorm.DB) *Client {
+	return &Client{
+		Client:   client,
+		scanner:  scanner,
+		policy:   policy,
+		db:       db,
+		cacheTTL: 10 * time.Minute,
+		cache:    map[string]cachedReport{},
+	}
+}
+
+// WatchJobs watches the jobs with the wrapped client, since scanning images does
+// not change how jobs are watched.
+func (c *Client) WatchJobs(ctx context.Context, onChange func(orchestrator.JobInfo)) error {
+	if watcher, ok := c.Client.(orchestrator.JobWatcher); ok {
+		return watcher.WatchJobs(ctx, onChange)
+	}
+	return orchestrator.ErrWatchNotSupported
+}
+
+// StreamJobLogs streams the logs with the wrapped client, since scanning images
+// does not change how logs are read.
+func (c *Client) StreamJobLogs(ctx context.Context, jobName string, onLine func(orchestrator.JobLogLine)) error {
+	if streamer, ok := c.Client.(orchestrator.JobLogStreamer); ok {
+		return streamer.StreamJobLogs(ctx, jobName, onLine)
+	}
+	return orchestrator.ErrLogStreamNotSupported
+}
+
+func (c *Client) scan(image string) (Report, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[image]
+	c.mu.Unlock()
+	if ok && time.Since(cached.scannedAt) < c.cacheTTL {
+		return cached.report, nil
+	}
+
+	report, err := c.scanner.Scan(image)
+	if err != nil {
+		return Report{}, err
+	}
+
+	c.mu.Lock()
+	c.cache[image] = cachedReport{report: report, scannedAt: time.Now()}
+	c.mu.Unlock()
+
+	return report, nil
+}
+
+func (c *Client) record(jobName, image string, report Report, result string, scanErr error) {
+	entry := schema.ImageScan{
+		JobName:   jobName,
+		Image:     image,
+		Digest:    report.Digest,
+		Scanner:   c.scanner.Name(),
+		Critical:  report.Counts[SeverityCritical],
+		High:      report.Counts[SeverityHigh],
+		Medium:    report.Counts[SeverityMedium],
+		Low:       report.Counts[SeverityLow],
+		Unknown:   report.Counts[SeverityUnknown],
+		Result:    result,
+		CreatedAt: time.Now().UTC(),
+	}
+	if scanErr != nil {
+		entry.Error = scanErr.Error()
+	}
+
+	if err := c.db.Create(&entry).Error; err != nil {
+		slog.Error("sql error recording image scan", "job_name", jobName, "image", image, "error", err)
+	}
+}
+
+// StartJob scans each image of the job, and only starts the job if none of the
+// images are blocked by the policy.
+func (c *Client) StartJob(job orchestrator.Job) error {
+	for _, image := range orchestrator.JobImages(job) {
+		if err := c.checkImage(job.GetJobName(), image); err != nil {
+			return err
+		}
+	}
+	return c.Client.StartJob(job)
+}
+
+func (c *Client) checkImage(jobName, image string) error {
+	report, err := c.scan(image)
+	if err != nil {
+		slog.Error("error scanning job image", "job_name", jobName, "image", image, "error", err)
+		if c.policy.Mode == ModeBlock {
+			c.record(jobName, image, report, ResultBlocked, err)
+			return fmt.Errorf("%w: unable to scan image %v: %v", ErrImageBlocked, image, err)
+		}
+		c.record(jobName, image, report, ResultWarned, err)
+		return nil
+	}
+
+	found := report.CountAtLeast(c.policy.Threshold)
+	switch {
+	case found == 0:
+		c.record(jobName, image, report, ResultAllowed, nil)
+	case c.policy.Mode == ModeBlock:
+		slog.Error("blocking job due to image vulnerabilities", "job_name", jobName, "image", image, "digest", report.Digest, "count", found, "threshold", c.policy.Threshold)
+		c.record(jobName, image, report, ResultBlocked, nil)
+		return fmt.Errorf("%w: image %v has %d vulnerabilities with severity %v or higher", ErrImageBlocked, image, found, NormalizeSeverity(c.policy.Threshold))
+	default:
+		slog.Warn("starting job with image vulnerabilities", "job_name", jobName, "image", image, "digest", report.Digest, "count", found, "threshold", c.policy.Threshold)
+		c.record(jobName, image, report, ResultWarned, nil)
+	}
+
+	return nil
+}
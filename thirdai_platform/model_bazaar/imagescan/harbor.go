@@ -0,0 +1,111 @@
+package imagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"thirdai_platform/utils"
+	"time"
+)
+
+// HarborScanner uses the scan results that a Harbor registry already has for
+// an image, so it does not trigger any scanning itself. Images that have not
+// been scanned by the registry are treated as scan failures.
+type HarborScanner struct {
+	url      string
+	username string
+	password string
+
+	client *http.Client
+}
+
+func NewHarborScanner(url, username, password string) *HarborScanner {
+	return &HarborScanner{url: url, username: username, password: password, client: &http.Client{Timeout: 30 * time.Second, Transport: utils.OutboundTransport()}}
+}
+
+func (s *HarborScanner) Name() string {
+	return "harbor"
+}
+
+const harborVulnerabilityReport = "application/vnd.security.vulnerability.report; version=1.1"
+
+type harborArtifact struct {
+	Digest       string `json:"digest"`
+	ScanOverview map[string]struct {
+		ScanStatus string `json:"scan_status"`
+		Summary    struct {
+			Summary map[string]int `json:"summary"`
+		} `json:"summary"`
+	} `json:"scan_overview"`
+}
+
+// parseHarborImage splits an image of the form registry/project/repository:tag.
+func parseHarborImage(image string) (project, repository, tag string, err error) {
+	_, path, found := strings.Cut(image, "/")
+	if !found {
+		return "", "", "", fmt.Errorf("image '%v' does not include a registry", image)
+	}
+
+	path, tag, found = strings.Cut(path, ":")
+	if !found {
+		tag = "latest"
+	}
+
+	project, repository, found = strings.Cut(path, "/")
+	if !found || project == "" || repository == "" {
+		return "", "", "", fmt.Errorf("image '%v' does not include a harbor project", image)
+	}
+
+	return project, repository, tag, nil
+}
+
+func (s *HarborScanner) Scan(image string) (Report, error) {
+	project, repository, tag, err := parseHarborImage(image)
+	if err != nil {
+		return Report{}, err
+	}
+
+	// Harbor requires slashes in repository names to be double encoded.
+	endpoint, err := url.JoinPath(s.url, "api/v2.0/projects", project, "repositories", url.PathEscape(url.PathEscape(repository)), "artifacts", tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid harbor url: %w", err)
+	}
+
+	req, err := http.NewRequest("GET", endpoint+"?with_scan_overview=true", nil)
+	if err != nil {
+		return Report{}, fmt.Errorf("error creating harbor request: %w", err)
+	}
+	req.Header.Set("X-Accept-Vulnerabilities", harborVulnerabilityReport)
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Report{}, fmt.Errorf("error requesting harbor scan results: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Report{}, fmt.Errorf("harbor returned status %d for image %v", res.StatusCode, image)
+	}
+
+	var artifact harborArtifact
+	if err := json.NewDecoder(res.Body).Decode(&artifact); err != nil {
+		return Report{}, fmt.Errorf("error parsing harbor artifact: %w", err)
+	}
+
+	overview, ok := artifact.ScanOverview[harborVulnerabilityReport]
+	if !ok || overview.ScanStatus != "Success" {
+		return Report{}, fmt.Errorf("harbor has no completed scan for image %v", image)
+	}
+
+	result := Report{Digest: artifact.Digest, Counts: map[string]int{}}
+	for severity, count := range overview.Summary.Summary {
+		result.Counts[NormalizeSeverity(severity)] += count
+	}
+
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package imagescan
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrImageBlocked = errors.New("image blocked by vulnerability scan policy")
+
+const (
+	SeverityUnknown  = "UNKNOWN"
+	SeverityLow      = "LOW"
+	SeverityMedium   = "MEDIUM"
+	SeverityHigh     = "HIGH"
+	SeverityCritical = "CRITICAL"
+)
+
+var severityRank = map[string]int{
+	SeverityUnknown:  0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// NormalizeSeverity converts the severity names used by different scanners
+// (e.g. "High" or "high") to the names used here.
+func NormalizeSeverity(severity string) string {
+	s := strings.ToUpper(severity)
+	if _, ok := severityRank[s]; ok {
+		return s
+	}
+	return SeverityUnknown
+}
+
+// Report is the result of scanning an image. Counts is the number of
+// vulnerabilities found for each severity.
+type Report struct {
+	Digest string
+	Counts map[string]int
+}
+
+// CountAtLeast returns the number of vulnerabilities with at least the given severity.
+func (r Report) CountAtLeast(severity string) int {
+	threshold := severityRank[NormalizeSeverity(severity)]
+	total := 0
+	for s, count := range r.Counts {
+		if severityRank[s] >= threshold {
+			total += count
+		}
+	}
+	return total
+}
+
+type Scanner interface {
+	// Scan returns the vulnerabilities found for the image, which is a full
+	// reference of the form registry/repository:tag.
+	Scan(image string) (Report, error)
+
+	Name() string
+}
+
+const (
+	ModeWarn  = "warn"
+	ModeBlock = "block"
+)
+
+// Policy determines what happens when an image has vulnerabilities with at
+// least the threshold severity. In warn mode the job is started and a warning
+// is recorded, in block mode the job is not started. Images that cannot be
+// scanned are blocked in block mode.
+type Policy struct {
+	Mode      string
+	Threshold string
+}
+
+func (p Policy) Validate() error {
+	if p.Mode != ModeWarn && p.Mode != ModeBlock {
+		return fmt.Errorf("invalid image scan mode '%v', must be '%v' or '%v'", p.Mode, ModeWarn, ModeBlock)
+	}
+	if _, ok := severityRank[strings.ToUpper(p.Threshold)]; !ok {
+		return fmt.Errorf("invalid image scan severity threshold '%v'", p.Threshold)
+	}
+	return nil
+}
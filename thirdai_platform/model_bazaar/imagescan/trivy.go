@@ -0,0 +1,88 @@
+package imagescan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"thirdai_platform/utils"
+	"time"
+)
+
+// TrivyScanner retrieves reports in the trivy json format (the output of
+// `trivy image --format json`) from a scan service, which is called with
+// GET {endpoint}?image={image}.
+type TrivyScanner struct {
+	endpoint string
+
+	client *http.Client
+}
+
+func NewTrivyScanner(endpoint string) *TrivyScanner {
+	// Scanning an image that has not been scanned before can take a while.
+	return &TrivyScanner{endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Minute, Transport: utils.OutboundTransport()}}
+}
+
+func (s *TrivyScanner) Name() string {
+	return "trivy"
+}
+
+type trivyReport struct {
+	Metadata struct {
+		RepoDigests []string `json:"RepoDigests"`
+	} `json:"Metadata"`
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID string `json:"VulnerabilityID"`
+			Severity        string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+func (s *TrivyScanner) Scan(image string) (Report, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid trivy endpoint: %w", err)
+	}
+	query := u.Query()
+	query.Set("image", image)
+	u.RawQuery = query.Encode()
+
+	res, err := s.client.Get(u.String())
+	if err != nil {
+		return Report{}, fmt.Errorf("error requesting trivy scan: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Report{}, fmt.Errorf("trivy scan returned status %d", res.StatusCode)
+	}
+
+	var report trivyReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("error parsing trivy report: %w", err)
+	}
+
+	result := Report{Counts: map[string]int{}}
+	for _, digest := range report.Metadata.RepoDigests {
+		if _, d, found := strings.Cut(digest, "@"); found {
+			result.Digest = d
+			break
+		}
+	}
+
+	// The same vulnerability can be reported for multiple packages or targets.
+	seen := map[string]bool{}
+	for _, target := range report.Results {
+		for _, vuln := range target.Vulnerabilities {
+			if seen[vuln.VulnerabilityID] {
+				continue
+			}
+			seen[vuln.VulnerabilityID] = true
+			result.Counts[NormalizeSeverity(vuln.Severity)]++
+		}
+	}
+
+	return result, nil
+}
@@ -6,7 +6,7 @@ import (
 	"thirdai_platform/model_bazaar/orchestrator"
 )
 
-func StartLlmDispatchJob(orchestratorClient orchestrator.Client, driver orchestrator.Driver, modelBazaarEndpoint, shareDir string) error {
+func StartLlmDispatchJob(orchestratorClient orchestrator.Client, driver orchestrator.Driver, modelBazaarEndpoint, shareDir string, extraEnv map[string]string) error {
 	slog.Info("starting llm-dispatch job")
 
 	job := orchestrator.LlmDispatchJob{
@@ -14,6 +14,7 @@ func StartLlmDispatchJob(orchestratorClient orchestrator.Client, driver orchestr
 		Driver:              driver,
 		ShareDir:            shareDir,
 		IngressHostname:     orchestratorClient.IngressHostname(),
+		ExtraEnv:            extraEnv,
 	}
 
 	if driver.DriverType() == "local" {
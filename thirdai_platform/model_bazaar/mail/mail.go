@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is an html email.
+type Message struct {
+	To      []string
+	Subject string
+	Html    string
+}
+
+type Sender interface {
+	Send(msg Message) error
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (c SMTPConfig) Validate() error {
+	if c.Host == "" {
+		return errors.New("smtp host must be specified")
+	}
+	if c.Port <= 0 {
+		return fmt.Errorf("invalid smtp port %d", c.Port)
+	}
+	if c.From == "" {
+		return errors.New("smtp from address must be specified")
+	}
+	return nil
+}
+
+// SMTPSender sends emails through an SMTP server. STARTTLS is used if the
+// server supports it, and the credentials are only sent over TLS.
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	return &SMTPSender{config: config}
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("email has no recipients")
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	if err := smtp.SendMail(addr, auth, s.config.From, msg.To, formatMessage(s.config.From, msg)); err != nil {
+		return fmt.Errorf("error sending email with smtp server %v: %w", addr, err)
+	}
+	return nil
+}
+
+func formatMessage(from string, msg Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=\"utf-8\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// Lines in the body are limited to 76 characters by the mime spec.
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Html))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	return buf.Bytes()
+}
@@ -1,5 +1,7 @@
 package orchestrator
 
+import "context"
+
 type JobStatus string
 
 // These are the default Nomad job status types
@@ -19,6 +21,12 @@ type JobLog struct {
 	Stderr string `json:"stderr"`
 }
 
+// JobLogLine is a line that a job wrote to stdout or stderr.
+type JobLogLine struct {
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+}
+
 type ServiceAllocation struct {
 	Address string
 	AllocID string
@@ -36,6 +44,10 @@ type Client interface {
 
 	StopJob(jobName string) error
 
+	// UpdateAutoscaling applies the autoscaling settings of the job to the
+	// running deployment without restarting it.
+	UpdateAutoscaling(job DeployJob) error
+
 	JobInfo(jobName string) (JobInfo, error)
 
 	JobLogs(jobName string) ([]JobLog, error)
@@ -48,3 +60,23 @@ type Client interface {
 
 	GetName() string
 }
+
+// JobWatcher is implemented by clients that can report changes to the status
+// of train and deploy jobs as they happen, so that the platform does not have
+// to wait for its next status sync to notice that a job has died.
+type JobWatcher interface {
+	// WatchJobs calls onChange with the new info of a job whenever its status
+	// changes, until ctx is canceled. It returns once the watch is established.
+	// Deleting a job is not reported since the platform deletes jobs itself
+	// when they are stopped.
+	WatchJobs(ctx context.Context, onChange func(JobInfo)) error
+}
+
+// JobLogStreamer is implemented by clients that can follow the logs of a job as
+// they are written, so that the platform does not have to poll for new logs.
+type JobLogStreamer interface {
+	// StreamJobLogs calls onLine with the end of the existing logs of the running
+	// instances of the job, and then with each new line as it is written. It
+	// returns once the instances exit or ctx is canceled.
+	StreamJobLogs(ctx context.Context, jobName string, onLine func(JobLogLine)) error
+}
@@ -1,5 +1,7 @@
 package orchestrator
 
+import "fmt"
+
 type Driver interface {
 	DriverType() string
 }
@@ -21,6 +23,11 @@ func (p DockerDriver) DriverType() string {
 	return "docker"
 }
 
+// Image is the full image reference used in the job templates.
+func (p DockerDriver) Image() string {
+	return fmt.Sprintf("%s/%s:%s", p.Registry, p.ImageName, p.Tag)
+}
+
 type LocalDriver struct {
 	PlatformDir string
 	PythonPath  string
@@ -52,22 +59,83 @@ type Job interface {
 	JobTemplatePath() string
 }
 
+// NamespacedJob is implemented by jobs that can run outside of the default
+// namespace of the orchestrator, so that the jobs of each team can be isolated.
+// An empty namespace uses the default namespace.
+type NamespacedJob interface {
+	Job
+
+	JobNamespace() string
+}
+
+// TeamNamespacePrefix is the prefix of the namespaces created for teams.
+const TeamNamespacePrefix = "thirdai-team-"
+
+func TeamNamespace(teamId string) string {
+	return TeamNamespacePrefix + teamId
+}
+
 type TrainJob struct {
 	JobName          string
+	ModelId          string
 	ConfigPath       string
 	Driver           Driver
 	Resources        Resources
 	CloudCredentials CloudCredentials
+	Namespace        string
+
+	// Priority is the priority of the job from 1 to 100, or 0 to use the default
+	// priority of the orchestrator.
+	Priority int
+
+	// ExtraEnv is additional environment variables configured by the platform admin.
+	ExtraEnv map[string]string
 }
 
 func (j TrainJob) GetJobName() string {
 	return j.JobName
 }
 
+// PriorityClassName is the kubernetes priority class of the job, which is empty
+// if the job has the default priority.
+func (j TrainJob) PriorityClassName() string {
+	if j.Priority == 0 {
+		return ""
+	}
+	return fmt.Sprintf("thirdai-train-priority-%d", j.Priority)
+}
+
 func (j TrainJob) JobTemplatePath() string {
 	return "train"
 }
 
+func (j TrainJob) JobNamespace() string {
+	return j.Namespace
+}
+
+type BatchPredictJob struct {
+	JobName          string
+	ConfigPath       string
+	Driver           Driver
+	Resources        Resources
+	CloudCredentials CloudCredentials
+	Namespace        string
+
+	ExtraEnv map[string]string
+}
+
+func (j BatchPredictJob) GetJobName() string {
+	return j.JobName
+}
+
+func (j BatchPredictJob) JobTemplatePath() string {
+	return "batch_predict"
+}
+
+func (j BatchPredictJob) JobNamespace() string {
+	return j.Namespace
+}
+
 type DeployJob struct {
 	JobName string
 	ModelId string
@@ -75,9 +143,11 @@ type DeployJob struct {
 	ConfigPath     string
 	DeploymentName string
 
-	AutoscalingEnabled bool
-	AutoscalingMin     int
-	AutoscalingMax     int
+	AutoscalingEnabled     bool
+	AutoscalingMin         int
+	AutoscalingMax         int
+	AutoscalingTargetCpu   int
+	ScaleToZeroIdleSeconds int
 
 	Driver Driver
 
@@ -88,7 +158,24 @@ type DeployJob struct {
 	JobToken string
 	IsKE     bool
 
+	// ExternalImage is the image of a user provided inference server that runs
+	// next to the deployment, which forwards requests to it on ExternalPort.
+	// It is only set for external models that run in a container.
+	ExternalImage string
+	ExternalPort  int
+
+	// AntiAffinity is "preferred" or "required" to spread the replicas across
+	// the values of the TopologyKey node label, or empty to not spread them. A
+	// pod disruption budget is created if MaxUnavailable is set. These are only
+	// used by the kubernetes client.
+	AntiAffinity   string
+	TopologyKey    string
+	MaxUnavailable int
+
 	IngressHostname string
+	Namespace       string
+
+	ExtraEnv map[string]string
 }
 
 func (j DeployJob) GetJobName() string {
@@ -99,6 +186,19 @@ func (j DeployJob) JobTemplatePath() string {
 	return "deploy"
 }
 
+func (j DeployJob) JobNamespace() string {
+	return j.Namespace
+}
+
+// ScalingMin is the lower bound on the number of replicas, which is 0 if the
+// deployment can scale to zero once it is idle.
+func (j DeployJob) ScalingMin() int {
+	if j.ScaleToZeroIdleSeconds > 0 {
+		return 0
+	}
+	return j.AutoscalingMin
+}
+
 type DatagenTrainJob struct {
 	TrainJob
 
@@ -139,6 +239,8 @@ type LlmDispatchJob struct {
 	Driver Driver
 
 	IngressHostname string
+
+	ExtraEnv map[string]string
 }
 
 func (j LlmDispatchJob) GetJobName() string {
@@ -235,3 +337,71 @@ func (j SnapshotJob) GetJobName() string {
 func (j SnapshotJob) JobTemplatePath() string {
 	return "snapshot"
 }
+
+// RestoreJob restores the database and the share directory from a backup
+// created by the SnapshotJob.
+type RestoreJob struct {
+	RestoreId  string
+	ConfigPath string
+	ShareDir   string
+	DbUri      string
+
+	Driver Driver
+}
+
+func (j RestoreJob) GetJobName() string {
+	return "recovery-restore"
+}
+
+func (j RestoreJob) JobTemplatePath() string {
+	return "restore"
+}
+
+// JobImages returns the images that the job runs. This is the platform image if
+// the job uses the docker driver, along with the image of an external model for
+// deployments of external models that run in a container.
+func JobImages(job Job) []string {
+	images := []string{}
+	if image, ok := jobImage(job); ok {
+		images = append(images, image)
+	}
+	if deploy, ok := job.(DeployJob); ok && deploy.ExternalImage != "" {
+		images = append(images, deploy.ExternalImage)
+	}
+	return images
+}
+
+// jobImage returns the platform image that the job runs, if the job uses the
+// docker driver. Jobs that run third party images or run locally return false.
+func jobImage(job Job) (string, bool) {
+	var driver Driver
+	switch j := job.(type) {
+	case TrainJob:
+		driver = j.Driver
+	case DatagenTrainJob:
+		driver = j.Driver
+	case BatchPredictJob:
+		driver = j.Driver
+	case DeployJob:
+		driver = j.Driver
+	case LlmCacheJob:
+		driver = j.Driver
+	case LlmDispatchJob:
+		driver = j.Driver
+	case FrontendJob:
+		driver = j.Driver
+	case SnapshotJob:
+		driver = j.Driver
+	case RestoreJob:
+		driver = j.Driver
+	}
+
+	switch d := driver.(type) {
+	case DockerDriver:
+		return d.Image(), true
+	case *DockerDriver:
+		return d.Image(), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,78 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"thirdai_platform/model_bazaar/orchestrator"
+)
+
+func TestDeployPlacement(t *testing.T) {
+	client := &KubernetesClient{namespace: "default", clientset: fake.NewSimpleClientset()}
+	ctx := context.Background()
+
+	job := orchestrator.DeployJob{
+		JobName:        "deploy-ndb-1",
+		ModelId:        "1",
+		Driver:         orchestrator.DockerDriver{ImageName: "backend", Tag: "v1"},
+		Resources:      orchestrator.Resources{AllocationCores: 2, AllocationMemory: 1000, AllocationMemoryMax: 4000},
+		AntiAffinity:   "required",
+		TopologyKey:    "topology.kubernetes.io/zone",
+		MaxUnavailable: 1,
+	}
+	if err := client.StartJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment, err := client.clientset.AppsV1().Deployments("default").Get(ctx, job.JobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	affinity := deployment.Spec.Template.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil || len(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Fatalf("deployment should have required anti affinity: %+v", affinity)
+	}
+	term := affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+	if term.TopologyKey != job.TopologyKey || term.LabelSelector.MatchLabels["app"] != job.JobName {
+		t.Fatalf("invalid anti affinity term %+v", term)
+	}
+
+	pdb, err := client.clientset.PolicyV1().PodDisruptionBudgets("default").Get(ctx, job.JobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdb.Spec.MaxUnavailable == nil || pdb.Spec.MaxUnavailable.IntValue() != 1 || pdb.Spec.Selector.MatchLabels["app"] != job.JobName {
+		t.Fatalf("invalid pod disruption budget %+v", pdb.Spec)
+	}
+
+	// Resubmitting the job without placement options removes them.
+	job.AntiAffinity, job.TopologyKey, job.MaxUnavailable = "", "", 0
+	if err := client.StartJob(job); err != nil {
+		t.Fatal(err)
+	}
+	deployment, err = client.clientset.AppsV1().Deployments("default").Get(ctx, job.JobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Spec.Affinity != nil {
+		t.Fatalf("deployment should not have an affinity: %+v", deployment.Spec.Template.Spec.Affinity)
+	}
+	if _, err := client.clientset.PolicyV1().PodDisruptionBudgets("default").Get(ctx, job.JobName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("pod disruption budget should be deleted, got %v", err)
+	}
+
+	job.MaxUnavailable = 2
+	if err := client.StartJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StopJob(job.JobName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.clientset.PolicyV1().PodDisruptionBudgets("default").Get(ctx, job.JobName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("pod disruption budget should be deleted when the job is stopped, got %v", err)
+	}
+}
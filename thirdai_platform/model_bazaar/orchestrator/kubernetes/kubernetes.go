@@ -10,8 +10,11 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	// For Job manifests.
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,8 +42,12 @@ func getNamespace() (string, error) {
 
 type KubernetesClient struct {
 	namespace       string
-	clientset       *kubernetes.Clientset
+	clientset       kubernetes.Interface
 	ingressHostname string
+
+	// The listers of the informers started by WatchJobs, nil if the jobs are
+	// not being watched.
+	listers atomic.Pointer[jobListers]
 }
 
 func NewKubernetesClient(ingressHostname string) orchestrator.Client {
@@ -78,6 +85,19 @@ func (c *KubernetesClient) StartJob(job orchestrator.Job) error {
 
 	ctx := context.Background()
 
+	var train orchestrator.TrainJob
+	switch j := job.(type) {
+	case orchestrator.TrainJob:
+		train = j
+	case orchestrator.DatagenTrainJob:
+		train = j.TrainJob
+	}
+	if name := train.PriorityClassName(); name != "" {
+		if err := c.ensurePriorityClass(name, train.Priority, ctx); err != nil {
+			return err
+		}
+	}
+
 	for _, res := range resources {
 		slog.Info("processing resource type", "fileSuffix", res.FileSuffix, "job_name", job.GetJobName())
 		if err := c.processTemplate(res.FileSuffix, subDir, job, ctx); err != nil {
@@ -86,6 +106,14 @@ func (c *KubernetesClient) StartJob(job orchestrator.Job) error {
 		}
 	}
 
+	// The template only renders a disruption budget if it is enabled, so one
+	// from a previous version of the deployment is deleted here.
+	if deploy, ok := job.(orchestrator.DeployJob); ok && deploy.MaxUnavailable == 0 {
+		if err := c.deletePDB(deploy.JobName, ctx); err != nil {
+			return fmt.Errorf("error deleting pod disruption budget: %w", err)
+		}
+	}
+
 	slog.Info("all resources for job started successfully", "job_name", job.GetJobName(), "namespace", c.namespace)
 	return nil
 }
@@ -148,6 +176,10 @@ func (c *KubernetesClient) StopJob(jobName string) error {
 		slog.Info("internal ingress deleted successfully", "ingress_name", internalIngressName)
 	}
 
+	if err := c.deletePDB(jobName, ctx); err != nil {
+		errs = append(errs, fmt.Errorf("pod disruption budget: %w", err))
+	}
+
 	if len(errs) > 0 {
 		slog.Info("one or more errors occurred while stopping job resources", "job_name", jobName, "errors", errs)
 		return fmt.Errorf("error stopping job resources for %s: %w", jobName, errors.Join(errs...))
@@ -156,23 +188,64 @@ func (c *KubernetesClient) StopJob(jobName string) error {
 	return nil
 }
 
+func (c *KubernetesClient) UpdateAutoscaling(job orchestrator.DeployJob) error {
+	slog.Info("updating kubernetes job autoscaling", "job_name", job.JobName, "namespace", c.namespace)
+	ctx := context.Background()
+
+	if _, err := c.clientset.AppsV1().Deployments(c.namespace).Get(ctx, job.JobName, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return orchestrator.ErrJobNotFound
+		}
+		slog.Error("error getting deployment", "deployment_name", job.JobName, "error", err)
+		return fmt.Errorf("error updating autoscaling for %s: %w", job.JobName, err)
+	}
+
+	// Only the HPA is updated, the deployment's pods are not restarted.
+	if err := c.processTemplate("_hpa.yaml", fmt.Sprintf("jobs/%s", job.JobTemplatePath()), job, ctx); err != nil {
+		slog.Error("error updating HPA", "job_name", job.JobName, "error", err)
+		return fmt.Errorf("error updating autoscaling for %s: %w", job.JobName, err)
+	}
+
+	slog.Info("kubernetes job autoscaling updated successfully", "job_name", job.JobName)
+	return nil
+}
+
+func deploymentStatus(deployment *appsv1.Deployment) orchestrator.JobStatus {
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas > 0 {
+		if deployment.Status.AvailableReplicas > 0 {
+			return orchestrator.StatusRunning
+		}
+		return orchestrator.StatusPending
+	}
+	return orchestrator.StatusDead
+}
+
+func jobStatus(job *batchv1.Job) orchestrator.JobStatus {
+	if job.Status.Active > 0 {
+		return orchestrator.StatusRunning
+	} else if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
+		return orchestrator.StatusDead
+	}
+	return orchestrator.StatusPending
+}
+
 func (c *KubernetesClient) JobInfo(jobName string) (orchestrator.JobInfo, error) {
+	// Jobs that are not in the informer caches, either because they were
+	// created before the platform labeled its jobs or because the informers
+	// have not seen them yet, are retrieved from the api server.
+	if listers := c.listers.Load(); listers != nil {
+		if info, ok := listers.jobInfo(jobName); ok {
+			return info, nil
+		}
+	}
+
 	slog.Info("retrieving job info", "job_name", jobName, "namespace", c.namespace)
 	ctx := context.Background()
 
 	deployment, err := c.clientset.AppsV1().Deployments(c.namespace).Get(ctx, jobName, metav1.GetOptions{})
 	if err == nil {
 		slog.Info("deployment found for job", "job_name", jobName)
-		var status orchestrator.JobStatus
-		if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas > 0 {
-			if deployment.Status.AvailableReplicas > 0 {
-				status = orchestrator.StatusRunning
-			} else {
-				status = orchestrator.StatusPending
-			}
-		} else {
-			status = orchestrator.StatusDead
-		}
+		status := deploymentStatus(deployment)
 		info := orchestrator.JobInfo{
 			Name:   deployment.Name,
 			Status: status,
@@ -197,14 +270,7 @@ func (c *KubernetesClient) JobInfo(jobName string) (orchestrator.JobInfo, error)
 		return orchestrator.JobInfo{}, fmt.Errorf("error getting job %s: %w", jobName, err)
 	}
 
-	var status orchestrator.JobStatus
-	if job.Status.Active > 0 {
-		status = orchestrator.StatusRunning
-	} else if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
-		status = orchestrator.StatusDead
-	} else {
-		status = orchestrator.StatusPending
-	}
+	status := jobStatus(job)
 	info := orchestrator.JobInfo{
 		Name:   job.Name,
 		Status: status,
@@ -266,6 +332,40 @@ func (c *KubernetesClient) getPodLogs(podName string) (string, error) {
 	return builder.String(), nil
 }
 
+func (c *KubernetesClient) StreamJobLogs(ctx context.Context, jobName string, onLine func(orchestrator.JobLogLine)) error {
+	slog.Info("streaming job logs", "job_name", jobName, "namespace", c.namespace)
+	podList, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s", jobName),
+	})
+	if err != nil {
+		slog.Error("error listing pods for job", "job_name", jobName, "error", err)
+		return fmt.Errorf("error listing pods for job %s: %w", jobName, err)
+	}
+
+	streams := make([]orchestrator.LogStream, 0)
+	for _, pod := range podList.Items {
+		// Pods that are not running will not write any more logs.
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		tailLines := int64(100)
+		req := c.clientset.CoreV1().Pods(c.namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Follow: true, TailLines: &tailLines})
+		stream, err := req.Stream(ctx)
+		if err != nil {
+			for _, opened := range streams {
+				opened.Reader.Close()
+			}
+			slog.Error("error opening log stream", "podName", pod.Name, "error", err)
+			return fmt.Errorf("error opening log stream for pod %s: %w", pod.Name, err)
+		}
+		// Kubernetes combines stdout and stderr in the container logs.
+		streams = append(streams, orchestrator.LogStream{Stream: "stdout", Reader: stream})
+	}
+
+	return orchestrator.FollowLogStreams(streams, onLine)
+}
+
 func (c *KubernetesClient) ListServices() ([]orchestrator.ServiceInfo, error) {
 	slog.Info("listing services", "namespace", c.namespace)
 	ctx := context.Background()
@@ -0,0 +1,57 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"thirdai_platform/model_bazaar/orchestrator"
+)
+
+func TestTrainPriority(t *testing.T) {
+	client := &KubernetesClient{namespace: "default", clientset: fake.NewSimpleClientset()}
+	ctx := context.Background()
+
+	job := orchestrator.TrainJob{
+		JobName:   "train-1",
+		ModelId:   "1",
+		Driver:    orchestrator.DockerDriver{ImageName: "backend", Tag: "v1"},
+		Resources: orchestrator.Resources{AllocationCores: 2, AllocationMemory: 1000, AllocationMemoryMax: 4000},
+		Priority:  80,
+	}
+	if err := client.StartJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	k8sJob, err := client.clientset.BatchV1().Jobs("default").Get(ctx, job.JobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sJob.Spec.Template.Spec.PriorityClassName != "thirdai-train-priority-80" {
+		t.Fatalf("invalid priority class %q", k8sJob.Spec.Template.Spec.PriorityClassName)
+	}
+
+	class, err := client.clientset.SchedulingV1().PriorityClasses().Get(ctx, "thirdai-train-priority-80", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class.Value != 80 || class.PreemptionPolicy == nil || *class.PreemptionPolicy != corev1.PreemptNever {
+		t.Fatalf("invalid priority class %+v", class)
+	}
+
+	// Jobs with the default priority do not use a priority class.
+	job.JobName, job.Priority = "train-2", 0
+	if err := client.StartJob(job); err != nil {
+		t.Fatal(err)
+	}
+	k8sJob, err = client.clientset.BatchV1().Jobs("default").Get(ctx, job.JobName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sJob.Spec.Template.Spec.PriorityClassName != "" {
+		t.Fatalf("job with the default priority should not have a priority class, got %q", k8sJob.Spec.Template.Spec.PriorityClassName)
+	}
+}
@@ -18,6 +18,8 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
@@ -49,6 +51,10 @@ var resources = []resourceDef{
 		FileSuffix:   "_hpa.yaml",
 		ResourceType: "HorizontalPodAutoscaler",
 	},
+	{
+		FileSuffix:   "_pdb.yaml",
+		ResourceType: "PodDisruptionBudget",
+	},
 }
 
 func (c *KubernetesClient) processJob(doc string, ctx context.Context) error {
@@ -220,6 +226,81 @@ func (c *KubernetesClient) processHPA(doc string, ctx context.Context) error {
 	return nil
 }
 
+func (c *KubernetesClient) processPDB(doc string, ctx context.Context) error {
+	slog.Info("processing PDB YAML document", "namespace", c.namespace)
+	var pdb policyv1.PodDisruptionBudget
+	if err := k8syaml.Unmarshal([]byte(doc), &pdb); err != nil {
+		return fmt.Errorf("error unmarshaling PDB YAML: %w", err)
+	}
+	slog.Info("PDB YAML unmarshaled", "pdb_name", pdb.Name)
+
+	existing, err := c.clientset.PolicyV1().PodDisruptionBudgets(c.namespace).Get(ctx, pdb.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			slog.Info("PDB resource not found, creating new PDB", "pdb_name", pdb.Name)
+			if _, err := c.clientset.PolicyV1().PodDisruptionBudgets(c.namespace).Create(ctx, &pdb, metav1.CreateOptions{}); err != nil {
+				slog.Error("error creating PDB resource", "pdb_name", pdb.Name, "error", err)
+				return fmt.Errorf("error creating PDB resource: %w", err)
+			}
+			slog.Info("PDB resource created successfully", "pdb_name", pdb.Name)
+			return nil
+		}
+		return fmt.Errorf("error checking for existing PDB: %w", err)
+	}
+
+	slog.Info("PDB resource exists, updating PDB", "pdb_name", pdb.Name)
+	pdb.ResourceVersion = existing.ResourceVersion
+	if _, err := c.clientset.PolicyV1().PodDisruptionBudgets(c.namespace).Update(ctx, &pdb, metav1.UpdateOptions{}); err != nil {
+		slog.Error("error updating PDB resource", "pdb_name", pdb.Name, "error", err)
+		return fmt.Errorf("error updating PDB resource: %w", err)
+	}
+	slog.Info("PDB resource updated successfully", "pdb_name", pdb.Name)
+	return nil
+}
+
+// deletePDB deletes the pod disruption budget of a deployment, if it has one.
+func (c *KubernetesClient) deletePDB(name string, ctx context.Context) error {
+	slog.Info("attempting to delete PDB", "pdb_name", name, "namespace", c.namespace)
+	if err := c.clientset.PolicyV1().PodDisruptionBudgets(c.namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			slog.Info("PDB not found, skipping deletion", "pdb_name", name)
+			return nil
+		}
+		slog.Error("error deleting PDB", "pdb_name", name, "error", err)
+		return err
+	}
+	slog.Info("PDB deleted successfully", "pdb_name", name)
+	return nil
+}
+
+// ensurePriorityClass creates the priority class of a train job if it does not
+// exist. The classes do not preempt pods, since model bazaar preempts train jobs
+// itself so that the preempted jobs are queued again.
+func (c *KubernetesClient) ensurePriorityClass(name string, priority int, ctx context.Context) error {
+	_, err := c.clientset.SchedulingV1().PriorityClasses().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error checking for existing priority class: %w", err)
+	}
+
+	slog.Info("priority class not found, creating new priority class", "priority_class", name)
+	never := corev1.PreemptNever
+	class := schedulingv1.PriorityClass{
+		ObjectMeta:       metav1.ObjectMeta{Name: name},
+		Value:            int32(priority),
+		PreemptionPolicy: &never,
+		Description:      "Priority of thirdai platform train jobs",
+	}
+	if _, err := c.clientset.SchedulingV1().PriorityClasses().Create(ctx, &class, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		slog.Error("error creating priority class", "priority_class", name, "error", err)
+		return fmt.Errorf("error creating priority class: %w", err)
+	}
+	slog.Info("priority class created successfully", "priority_class", name)
+	return nil
+}
+
 func (c *KubernetesClient) processByFileSuffix(fileSuffix string, doc string, ctx context.Context) error {
 	switch fileSuffix {
 	case "_job.yaml":
@@ -232,6 +313,8 @@ func (c *KubernetesClient) processByFileSuffix(fileSuffix string, doc string, ct
 		return c.processIngress(doc, ctx)
 	case "_hpa.yaml":
 		return c.processHPA(doc, ctx)
+	case "_pdb.yaml":
+		return c.processPDB(doc, ctx)
 	default:
 		return fmt.Errorf("error processing template due to unknown file suffix: %s", fileSuffix)
 	}
@@ -255,6 +338,7 @@ func (c *KubernetesClient) processTemplate(fileSuffix, subDir string, job orches
 			"namespace": func() string {
 				return c.namespace
 			},
+			"yamlString": orchestrator.YamlString,
 		}).
 		Parse(string(content))
 	if err != nil {
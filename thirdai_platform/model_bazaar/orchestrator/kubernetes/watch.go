@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"thirdai_platform/model_bazaar/orchestrator"
+)
+
+// modelIdLabel is set on the jobs and deployments for training and deploying
+// models, so that the informers only watch the resources of models instead of
+// every job and deployment in the namespace.
+const modelIdLabel = "thirdai.com/model-id"
+
+type jobListers struct {
+	namespace   string
+	jobs        batchlisters.JobLister
+	deployments appslisters.DeploymentLister
+}
+
+// jobInfo returns the info of the job from the informer caches, and false if
+// the job is not in the caches.
+func (l *jobListers) jobInfo(jobName string) (orchestrator.JobInfo, bool) {
+	deployment, err := l.deployments.Deployments(l.namespace).Get(jobName)
+	if err == nil {
+		return orchestrator.JobInfo{Name: deployment.Name, Status: deploymentStatus(deployment)}, true
+	}
+	if !apierrors.IsNotFound(err) {
+		slog.Error("error getting deployment from informer cache", "job_name", jobName, "error", err)
+		return orchestrator.JobInfo{}, false
+	}
+
+	job, err := l.jobs.Jobs(l.namespace).Get(jobName)
+	if err == nil {
+		return orchestrator.JobInfo{Name: job.Name, Status: jobStatus(job)}, true
+	}
+	if !apierrors.IsNotFound(err) {
+		slog.Error("error getting job from informer cache", "job_name", jobName, "error", err)
+	}
+	return orchestrator.JobInfo{}, false
+}
+
+// statusChangeHandler calls onChange when an update to a resource changes the
+// status computed for it. Additions are not reported since new jobs are
+// pending, and deletions are not reported since the platform deletes jobs
+// itself when it stops them.
+func statusChangeHandler[T metav1.Object](status func(T) orchestrator.JobStatus, onChange func(orchestrator.JobInfo)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldRes, ok := oldObj.(T)
+			if !ok {
+				return
+			}
+			newRes, ok := newObj.(T)
+			if !ok {
+				return
+			}
+			if oldStatus, newStatus := status(oldRes), status(newRes); oldStatus != newStatus {
+				slog.Info("kubernetes job status changed", "job_name", newRes.GetName(), "from", oldStatus, "to", newStatus)
+				onChange(orchestrator.JobInfo{Name: newRes.GetName(), Status: newStatus})
+			}
+		},
+	}
+}
+
+func (c *KubernetesClient) WatchJobs(ctx context.Context, onChange func(orchestrator.JobInfo)) error {
+	slog.Info("starting kubernetes job informers", "namespace", c.namespace, "label", modelIdLabel)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		c.clientset, 0,
+		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = modelIdLabel
+		}),
+	)
+
+	jobs := factory.Batch().V1().Jobs()
+	deployments := factory.Apps().V1().Deployments()
+
+	if _, err := jobs.Informer().AddEventHandler(statusChangeHandler(jobStatus, onChange)); err != nil {
+		return fmt.Errorf("error adding job event handler: %w", err)
+	}
+	if _, err := deployments.Informer().AddEventHandler(statusChangeHandler(deploymentStatus, onChange)); err != nil {
+		return fmt.Errorf("error adding deployment event handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+
+	var errs []error
+	for informer, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			errs = append(errs, fmt.Errorf("informer for %v did not sync", informer))
+		}
+	}
+	if len(errs) > 0 {
+		factory.Shutdown()
+		return fmt.Errorf("error starting kubernetes job informers: %w", errors.Join(errs...))
+	}
+
+	c.listers.Store(&jobListers{namespace: c.namespace, jobs: jobs.Lister(), deployments: deployments.Lister()})
+
+	go func() {
+		<-ctx.Done()
+		c.listers.Store(nil)
+		factory.Shutdown()
+		slog.Info("stopped kubernetes job informers")
+	}()
+
+	slog.Info("kubernetes job informers synced")
+	return nil
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"thirdai_platform/model_bazaar/orchestrator"
+)
+
+func TestWatchJobs(t *testing.T) {
+	labeled := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "train-ndb-1", Namespace: "default", Labels: map[string]string{modelIdLabel: "1"}},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	unlabeled := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "train-ndb-2", Namespace: "default"},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	clientset := fake.NewSimpleClientset(labeled, unlabeled)
+	client := &KubernetesClient{namespace: "default", clientset: clientset}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan orchestrator.JobInfo, 10)
+	if err := client.WatchJobs(ctx, func(info orchestrator.JobInfo) { changes <- info }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := client.listers.Load().jobInfo("train-ndb-2"); ok {
+		t.Fatal("jobs without the model id label should not be watched")
+	}
+
+	// Jobs that are not in the informer caches are still found.
+	for _, name := range []string{"train-ndb-1", "train-ndb-2"} {
+		info, err := client.JobInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != name || info.Status != orchestrator.StatusRunning {
+			t.Fatalf("invalid job info %+v", info)
+		}
+	}
+	if _, err := client.JobInfo("train-ndb-3"); !errors.Is(err, orchestrator.ErrJobNotFound) {
+		t.Fatalf("expected job not found error, got %v", err)
+	}
+
+	// Updates that do not change the status are not reported.
+	labeled.Status = batchv1.JobStatus{Active: 1, Ready: new(int32)}
+	if _, err := clientset.BatchV1().Jobs("default").UpdateStatus(ctx, labeled, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	labeled.Status = batchv1.JobStatus{Failed: 1}
+	if _, err := clientset.BatchV1().Jobs("default").UpdateStatus(ctx, labeled, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case info := <-changes:
+		if info.Name != "train-ndb-1" || info.Status != orchestrator.StatusDead {
+			t.Fatalf("invalid status change %+v", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("status change was not reported")
+	}
+
+	info, err := client.JobInfo("train-ndb-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != orchestrator.StatusDead {
+		t.Fatalf("invalid job info %+v", info)
+	}
+
+	select {
+	case info := <-changes:
+		t.Fatalf("unexpected status change %+v", info)
+	default:
+	}
+
+	cancel()
+	for i := 0; i < 100 && client.listers.Load() != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.listers.Load() != nil {
+		t.Fatal("informer caches should not be used once the watch is stopped")
+	}
+}
@@ -2,6 +2,7 @@ package nomad
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"text/template"
 	"thirdai_platform/model_bazaar/orchestrator"
 )
@@ -27,13 +29,25 @@ type NomadClient struct {
 	token           string
 	templates       *template.Template
 	ingressHostname string
+
+	// namespace is used for jobs that do not specify a namespace.
+	namespace string
+
+	// The namespace of each job is cached since the namespace is needed for
+	// each request about the job, but is not known from the job name.
+	mu            sync.Mutex
+	jobNamespaces map[string]string
+	namespaces    map[string]bool
 }
 
 func NomadTemplatePath(jobPath string) string {
 	return jobPath + ".hcl.tmpl"
 }
 
-func NewNomadClient(addr string, token string, ingressHostname string) orchestrator.Client {
+// NewNomadClient creates a client that runs jobs in the given namespace, unless
+// the job specifies its own namespace. If namespace is empty the default
+// namespace is used.
+func NewNomadClient(addr string, token string, ingressHostname string, namespace string) orchestrator.Client {
 	funcs := template.FuncMap{
 		"isLocal": func(d orchestrator.Driver) bool {
 			return d.DriverType() == "local"
@@ -44,6 +58,7 @@ func NewNomadClient(addr string, token string, ingressHostname string) orchestra
 		"replaceHyphen": func(s string) string {
 			return strings.Replace(s, "-", "_", -1)
 		},
+		"hclString": orchestrator.HclString,
 	}
 
 	tmpl, err := template.New("job_templates").Funcs(funcs).ParseFS(jobTemplates, "jobs/*")
@@ -56,16 +71,34 @@ func NewNomadClient(addr string, token string, ingressHostname string) orchestra
 		slog.Info("found job template: " + t.Name())
 	}
 
-	return &NomadClient{addr: addr, token: token, templates: tmpl, ingressHostname: ingressHostname}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	return &NomadClient{
+		addr:            addr,
+		token:           token,
+		templates:       tmpl,
+		ingressHostname: ingressHostname,
+		namespace:       namespace,
+		jobNamespaces:   map[string]string{},
+		namespaces:      map[string]bool{defaultNamespace: true},
+	}
 }
 
+const defaultNamespace = "default"
+
 var errNomadReturnedNotFound = errors.New("nomad returned status 404")
 
 func (c *NomadClient) request(method, endpoint string, body io.Reader, result interface{}) error {
-	fullEndpoint, err := url.JoinPath(c.addr, endpoint)
+	path, query, _ := strings.Cut(endpoint, "?")
+	fullEndpoint, err := url.JoinPath(c.addr, path)
 	if err != nil {
 		return fmt.Errorf("error formatting url for nomad endpoint %v: %w", endpoint, err)
 	}
+	if query != "" {
+		fullEndpoint += "?" + query
+	}
 
 	req, err := http.NewRequest(method, fullEndpoint, body)
 	if err != nil {
@@ -113,6 +146,119 @@ func (c *NomadClient) delete(endpoint string) error {
 	return c.request("DELETE", endpoint, nil, nil)
 }
 
+func inNamespace(endpoint, namespace string) string {
+	return endpoint + "?namespace=" + url.QueryEscape(namespace)
+}
+
+// ownsNamespace returns true for the namespaces that the platform runs jobs in,
+// other namespaces in the cluster are ignored.
+func (c *NomadClient) ownsNamespace(namespace string) bool {
+	return namespace == c.namespace || strings.HasPrefix(namespace, orchestrator.TeamNamespacePrefix)
+}
+
+func (c *NomadClient) jobNamespaceFor(job orchestrator.Job) string {
+	if namespaced, ok := job.(orchestrator.NamespacedJob); ok && namespaced.JobNamespace() != "" {
+		return namespaced.JobNamespace()
+	}
+	return c.namespace
+}
+
+// ensureNamespace creates the namespace if it does not exist. This requires a
+// management token, so an error is returned that explains how to create the
+// namespace manually if it cannot be created.
+func (c *NomadClient) ensureNamespace(namespace string) error {
+	c.mu.Lock()
+	exists := c.namespaces[namespace]
+	c.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	err := c.get(fmt.Sprintf("v1/namespace/%v", url.PathEscape(namespace)), nil)
+	if errors.Is(err, errNomadReturnedNotFound) {
+		slog.Info("creating nomad namespace", "namespace", namespace)
+		body := &bytes.Buffer{}
+		payload := map[string]string{"Name": namespace, "Description": "created by thirdai platform"}
+		if err := json.NewEncoder(body).Encode(payload); err != nil {
+			return fmt.Errorf("error encoding namespace payload: %w", err)
+		}
+		err = c.post(fmt.Sprintf("v1/namespace/%v", url.PathEscape(namespace)), body, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create nomad namespace %v, it can be created with 'nomad namespace apply %v': %w", namespace, namespace, err)
+	}
+
+	c.mu.Lock()
+	c.namespaces[namespace] = true
+	c.mu.Unlock()
+
+	return nil
+}
+
+type jobStub struct {
+	ID        string
+	Namespace string
+}
+
+// jobNamespace returns the namespace of the job, and whether it came from the
+// cache. It returns errNomadReturnedNotFound if the job does not exist in any
+// namespace of the platform.
+func (c *NomadClient) jobNamespace(jobName string) (string, bool, error) {
+	c.mu.Lock()
+	namespace, ok := c.jobNamespaces[jobName]
+	c.mu.Unlock()
+	if ok {
+		return namespace, true, nil
+	}
+
+	var jobs []jobStub
+	if err := c.get("v1/jobs?namespace=*&prefix="+url.QueryEscape(jobName), &jobs); err != nil {
+		return "", false, err
+	}
+
+	for _, job := range jobs {
+		if job.ID == jobName && c.ownsNamespace(job.Namespace) {
+			c.setJobNamespace(jobName, job.Namespace)
+			return job.Namespace, false, nil
+		}
+	}
+
+	return "", false, errNomadReturnedNotFound
+}
+
+func (c *NomadClient) setJobNamespace(jobName, namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobNamespaces[jobName] = namespace
+}
+
+func (c *NomadClient) forgetJobNamespace(jobName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.jobNamespaces, jobName)
+}
+
+// withJobNamespace calls do with the namespace of the job. If the job is not
+// found in the cached namespace, the namespace is looked up again in case the
+// job was moved by another instance of model bazaar.
+func (c *NomadClient) withJobNamespace(jobName string, do func(namespace string) error) error {
+	namespace, cached, err := c.jobNamespace(jobName)
+	if err != nil {
+		return err
+	}
+
+	err = do(namespace)
+	if errors.Is(err, errNomadReturnedNotFound) && cached {
+		c.forgetJobNamespace(jobName)
+		namespace, _, err = c.jobNamespace(jobName)
+		if err != nil {
+			return err
+		}
+		return do(namespace)
+	}
+	return err
+}
+
 func (c *NomadClient) parseJob(job orchestrator.Job) (interface{}, error) {
 	content := strings.Builder{}
 	err := c.templates.ExecuteTemplate(&content, NomadTemplatePath(job.JobTemplatePath()), job)
@@ -137,14 +283,18 @@ func (c *NomadClient) parseJob(job orchestrator.Job) (interface{}, error) {
 	return jobDef, nil
 }
 
-func (c *NomadClient) submitJob(jobDef interface{}) error {
+func (c *NomadClient) submitJob(jobDef interface{}, namespace string) error {
+	if job, ok := jobDef.(map[string]interface{}); ok {
+		job["Namespace"] = namespace
+	}
+
 	body := &bytes.Buffer{}
 	err := json.NewEncoder(body).Encode(map[string]interface{}{"Job": jobDef})
 	if err != nil {
 		return fmt.Errorf("error encoding job submit payload: %w", err)
 	}
 
-	err = c.post("v1/jobs", body, nil)
+	err = c.post(inNamespace("v1/jobs", namespace), body, nil)
 	if err != nil {
 		return err
 	}
@@ -155,7 +305,13 @@ func (c *NomadClient) submitJob(jobDef interface{}) error {
 func (c *NomadClient) StartJob(job orchestrator.Job) error {
 
 	nomadTemplatePath := NomadTemplatePath(job.JobTemplatePath())
-	slog.Info("starting nomad job", "job_name", job.GetJobName(), "template", nomadTemplatePath)
+	namespace := c.jobNamespaceFor(job)
+	slog.Info("starting nomad job", "job_name", job.GetJobName(), "template", nomadTemplatePath, "namespace", namespace)
+
+	if err := c.ensureNamespace(namespace); err != nil {
+		slog.Error("error creating nomad namespace", "job_name", job.GetJobName(), "namespace", namespace, "error", err)
+		return fmt.Errorf("error starting nomad job: %w", err)
+	}
 
 	jobDef, err := c.parseJob(job)
 	if err != nil {
@@ -163,11 +319,12 @@ func (c *NomadClient) StartJob(job orchestrator.Job) error {
 		return fmt.Errorf("error starting nomad job: %w", err)
 	}
 
-	err = c.submitJob(jobDef)
+	err = c.submitJob(jobDef, namespace)
 	if err != nil {
 		slog.Error("error submitting nomad job", "job_name", job.GetJobName(), "template", nomadTemplatePath, "error", err)
 		return fmt.Errorf("error starting nomad job: %w", err)
 	}
+	c.setJobNamespace(job.GetJobName(), namespace)
 
 	slog.Info("nomad job started successfully", "job_name", job.GetJobName(), "template", nomadTemplatePath)
 
@@ -177,22 +334,101 @@ func (c *NomadClient) StartJob(job orchestrator.Job) error {
 func (c *NomadClient) StopJob(jobName string) error {
 	slog.Info("stopping nomad job", "job_name", jobName)
 
-	err := c.delete(fmt.Sprintf("v1/job/%v", jobName))
+	err := c.withJobNamespace(jobName, func(namespace string) error {
+		return c.delete(inNamespace(fmt.Sprintf("v1/job/%v", jobName), namespace))
+	})
 	if err != nil {
 		slog.Error("error stopping nomad job", "job_name", jobName, "error", err)
 		return fmt.Errorf("error stopping nomad job %v: %w", jobName, err)
 	}
 
+	c.forgetJobNamespace(jobName)
+
 	slog.Info("nomad job stopped successfully", "job_name", jobName)
 
 	return nil
 }
 
+func taskGroups(jobDef interface{}) []map[string]interface{} {
+	job, _ := jobDef.(map[string]interface{})
+	list, _ := job["TaskGroups"].([]interface{})
+
+	groups := make([]map[string]interface{}, 0, len(list))
+	for _, group := range list {
+		if g, ok := group.(map[string]interface{}); ok {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+func (c *NomadClient) UpdateAutoscaling(job orchestrator.DeployJob) error {
+	slog.Info("updating nomad job autoscaling", "job_name", job.JobName, "min", job.ScalingMin(), "max", job.AutoscalingMax)
+
+	// The scaling policies are taken from the rendered template so that they
+	// match what a new deployment would use, then copied into the running job.
+	// Resubmitting the job with only the scaling policies changed does not
+	// restart its allocations.
+	rendered, err := c.parseJob(job)
+	if err != nil {
+		slog.Error("error parsing nomad job", "job_name", job.JobName, "error", err)
+		return fmt.Errorf("error updating autoscaling for nomad job %v: %w", job.JobName, err)
+	}
+
+	policies := make(map[interface{}]interface{})
+	for _, group := range taskGroups(rendered) {
+		if scaling, ok := group["Scaling"]; ok && scaling != nil {
+			policies[group["Name"]] = scaling
+		}
+	}
+
+	var current interface{}
+	var namespace string
+	err = c.withJobNamespace(job.JobName, func(ns string) error {
+		namespace = ns
+		return c.get(inNamespace(fmt.Sprintf("v1/job/%v", job.JobName), ns), &current)
+	})
+	if err != nil {
+		if errors.Is(err, errNomadReturnedNotFound) {
+			return orchestrator.ErrJobNotFound
+		}
+		slog.Error("error getting nomad job", "job_name", job.JobName, "error", err)
+		return fmt.Errorf("error updating autoscaling for nomad job %v: %w", job.JobName, err)
+	}
+
+	for _, group := range taskGroups(current) {
+		policy, ok := policies[group["Name"]]
+		if !ok {
+			continue
+		}
+		group["Scaling"] = policy
+
+		// Nomad rejects counts outside of the scaling bounds. A deployment that
+		// has scaled to zero is started again since the autoscaler cannot
+		// measure the load of a group without allocations.
+		count, _ := group["Count"].(float64)
+		count = max(count, float64(job.ScalingMin()), 1)
+		count = min(count, float64(job.AutoscalingMax))
+		group["Count"] = count
+	}
+
+	if err := c.submitJob(current, namespace); err != nil {
+		slog.Error("error submitting nomad job", "job_name", job.JobName, "error", err)
+		return fmt.Errorf("error updating autoscaling for nomad job %v: %w", job.JobName, err)
+	}
+
+	slog.Info("nomad job autoscaling updated successfully", "job_name", job.JobName)
+
+	return nil
+}
+
 func (c *NomadClient) JobInfo(jobName string) (orchestrator.JobInfo, error) {
 	slog.Debug("retrieving nomad job info", "job_name", jobName)
 
 	var info orchestrator.JobInfo
-	err := c.get(fmt.Sprintf("v1/job/%v", jobName), &info)
+	err := c.withJobNamespace(jobName, func(namespace string) error {
+		return c.get(inNamespace(fmt.Sprintf("v1/job/%v", jobName), namespace), &info)
+	})
 	if err != nil {
 		if errors.Is(err, errNomadReturnedNotFound) {
 			return orchestrator.JobInfo{}, orchestrator.ErrJobNotFound
@@ -207,12 +443,13 @@ func (c *NomadClient) JobInfo(jobName string) (orchestrator.JobInfo, error) {
 }
 
 type jobAllocation struct {
-	ID string
+	ID           string
+	ClientStatus string
 }
 
-func (c *NomadClient) jobAllocations(jobName string) ([]string, error) {
+func (c *NomadClient) jobAllocations(jobName, namespace string) ([]string, error) {
 	var allocations []jobAllocation
-	err := c.get(fmt.Sprintf("v1/job/%v/allocations", jobName), &allocations)
+	err := c.get(inNamespace(fmt.Sprintf("v1/job/%v/allocations", jobName), namespace), &allocations)
 	if err != nil {
 		return nil, fmt.Errorf("error retreiving allocations for nomad job %v: %w", jobName, err)
 	}
@@ -225,20 +462,25 @@ func (c *NomadClient) jobAllocations(jobName string) ([]string, error) {
 	return allocIds, nil
 }
 
-func (c *NomadClient) getLogs(allocId string, logType string) (string, error) {
+// openLogs opens the end of the logs of the allocation. If follow is true the
+// response continues with new logs as they are written until the task exits.
+func (c *NomadClient) openLogs(ctx context.Context, allocId, namespace, logType string, follow bool) (io.ReadCloser, error) {
 	url, err := url.JoinPath(c.addr, fmt.Sprintf("v1/client/fs/logs/%v", allocId))
 	if err != nil {
-		return "", fmt.Errorf("error formatting allocation logs url: %w", err)
+		return nil, fmt.Errorf("error formatting allocation logs url: %w", err)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("error creating new request: %w", err)
+		return nil, fmt.Errorf("error creating new request: %w", err)
 	}
 	req.Header.Add("X-Nomad-Token", c.token)
 
 	params := map[string]string{
-		"task": "backend", "type": logType, "origin": "end", "offset": "5000", "plain": "true",
+		"task": "backend", "type": logType, "origin": "end", "offset": "5000", "plain": "true", "namespace": namespace,
+	}
+	if follow {
+		params["follow"] = "true"
 	}
 	query := req.URL.Query()
 	for k, v := range params {
@@ -248,15 +490,25 @@ func (c *NomadClient) getLogs(allocId string, logType string) (string, error) {
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error retrieving nomad logs: %w", err)
+		return nil, fmt.Errorf("error retrieving nomad logs: %w", err)
 	}
-	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("get nomad job logs returned status %d", res.StatusCode)
+		res.Body.Close()
+		return nil, fmt.Errorf("get nomad job logs returned status %d", res.StatusCode)
 	}
 
-	content, err := io.ReadAll(res.Body)
+	return res.Body, nil
+}
+
+func (c *NomadClient) getLogs(allocId, namespace, logType string) (string, error) {
+	body, err := c.openLogs(context.Background(), allocId, namespace, logType, false)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
 	if err != nil {
 		return "", fmt.Errorf("error reading log response: %w", err)
 	}
@@ -267,7 +519,14 @@ func (c *NomadClient) getLogs(allocId string, logType string) (string, error) {
 func (c *NomadClient) JobLogs(jobName string) ([]orchestrator.JobLog, error) {
 	slog.Info("retrieving nomad job logs", "job_name", jobName)
 
-	allocations, err := c.jobAllocations(jobName)
+	var namespace string
+	var allocations []string
+	err := c.withJobNamespace(jobName, func(ns string) error {
+		var err error
+		namespace = ns
+		allocations, err = c.jobAllocations(jobName, ns)
+		return err
+	})
 	if err != nil {
 		slog.Error("error listing job allocations", "job_name", jobName, "error", err)
 		return nil, fmt.Errorf("error listing allcoations for job %v: %w", jobName, err)
@@ -276,12 +535,12 @@ func (c *NomadClient) JobLogs(jobName string) ([]orchestrator.JobLog, error) {
 	logs := make([]orchestrator.JobLog, 0)
 
 	for _, alloc := range allocations {
-		stdoutLogs, err := c.getLogs(alloc, "stdout")
+		stdoutLogs, err := c.getLogs(alloc, namespace, "stdout")
 		if err != nil {
 			slog.Error("error getting stdout logs", "job_name", jobName, "error", err)
 			return nil, fmt.Errorf("error getting logs from stdout for job %v: %w", jobName, err)
 		}
-		stderrLogs, err := c.getLogs(alloc, "stderr")
+		stderrLogs, err := c.getLogs(alloc, namespace, "stderr")
 		if err != nil {
 			slog.Error("error getting stderr logs", "job_name", jobName, "error", err)
 			return nil, fmt.Errorf("error getting logs from stderr for job %v: %w", jobName, err)
@@ -295,6 +554,47 @@ func (c *NomadClient) JobLogs(jobName string) ([]orchestrator.JobLog, error) {
 	return logs, nil
 }
 
+func (c *NomadClient) StreamJobLogs(ctx context.Context, jobName string, onLine func(orchestrator.JobLogLine)) error {
+	slog.Info("streaming nomad job logs", "job_name", jobName)
+
+	var namespace string
+	var allocations []jobAllocation
+	err := c.withJobNamespace(jobName, func(ns string) error {
+		namespace = ns
+		return c.get(inNamespace(fmt.Sprintf("v1/job/%v/allocations", jobName), ns), &allocations)
+	})
+	if err != nil {
+		slog.Error("error listing job allocations", "job_name", jobName, "error", err)
+		return fmt.Errorf("error listing allocations for job %v: %w", jobName, err)
+	}
+
+	streams := make([]orchestrator.LogStream, 0)
+	closeStreams := func() {
+		for _, stream := range streams {
+			stream.Reader.Close()
+		}
+	}
+
+	for _, alloc := range allocations {
+		// Allocations that have exited are not followed since they will not
+		// write any more logs.
+		if alloc.ClientStatus != "running" {
+			continue
+		}
+		for _, logType := range []string{"stdout", "stderr"} {
+			body, err := c.openLogs(ctx, alloc.ID, namespace, logType, true)
+			if err != nil {
+				closeStreams()
+				slog.Error("error opening log stream", "job_name", jobName, "allocation", alloc.ID, "error", err)
+				return fmt.Errorf("error opening %v logs for job %v: %w", logType, jobName, err)
+			}
+			streams = append(streams, orchestrator.LogStream{Stream: logType, Reader: body})
+		}
+	}
+
+	return orchestrator.FollowLogStreams(streams, onLine)
+}
+
 type serviceResponse struct {
 	Namespace string
 	Services  []struct {
@@ -302,46 +602,54 @@ type serviceResponse struct {
 	}
 }
 
-func (c *NomadClient) listAllServices() ([]string, error) {
+type namespacedService struct {
+	Name      string
+	Namespace string
+}
+
+func (c *NomadClient) listAllServices() ([]namespacedService, error) {
 	var namespaces []serviceResponse
-	err := c.get("v1/services", &namespaces)
+	err := c.get("v1/services?namespace=*", &namespaces)
 	if err != nil {
 		return nil, err
 	}
 
-	services := make([]string, 0)
+	services := make([]namespacedService, 0)
 	for _, namespace := range namespaces {
+		if !c.ownsNamespace(namespace.Namespace) {
+			continue
+		}
 		for _, service := range namespace.Services {
-			services = append(services, service.ServiceName)
+			services = append(services, namespacedService{Name: service.ServiceName, Namespace: namespace.Namespace})
 		}
 	}
 
 	return services, nil
 }
 
-func (c *NomadClient) getServiceAllocations(service string) (orchestrator.ServiceInfo, error) {
+func (c *NomadClient) getServiceAllocations(service namespacedService) (orchestrator.ServiceInfo, error) {
 	var allocations []orchestrator.ServiceAllocation
-	err := c.get(fmt.Sprintf("v1/service/%v", service), &allocations)
+	err := c.get(inNamespace(fmt.Sprintf("v1/service/%v", service.Name), service.Namespace), &allocations)
 	if err != nil {
 		return orchestrator.ServiceInfo{}, nil
 	}
 
-	return orchestrator.ServiceInfo{Name: service, Allocations: allocations}, nil
+	return orchestrator.ServiceInfo{Name: service.Name, Allocations: allocations}, nil
 }
 
 func (c *NomadClient) ListServices() ([]orchestrator.ServiceInfo, error) {
-	serviceNames, err := c.listAllServices()
+	services, err := c.listAllServices()
 	if err != nil {
 		slog.Error("error listing nomad services", "error", err)
 		return nil, fmt.Errorf("error listing nomad services: %w", err)
 	}
 
-	serviceInfos := make([]orchestrator.ServiceInfo, 0, len(serviceNames))
-	for _, service := range serviceNames {
+	serviceInfos := make([]orchestrator.ServiceInfo, 0, len(services))
+	for _, service := range services {
 		info, err := c.getServiceAllocations(service)
 		if err != nil {
-			slog.Error("error getting info for nomad service", "service", service, "error", err)
-			return nil, fmt.Errorf("error getting info for service %v: %w", service, err)
+			slog.Error("error getting info for nomad service", "service", service.Name, "namespace", service.Namespace, "error", err)
+			return nil, fmt.Errorf("error getting info for service %v: %w", service.Name, err)
 		}
 		serviceInfos = append(serviceInfos, info)
 	}
@@ -350,6 +658,7 @@ func (c *NomadClient) ListServices() ([]orchestrator.ServiceInfo, error) {
 }
 
 type nomadAllocation struct {
+	Namespace          string
 	ClientStatus       string
 	AllocatedResources struct {
 		Tasks map[string]struct {
@@ -364,7 +673,7 @@ func (c *NomadClient) TotalCpuUsage() (int, error) {
 	slog.Info("getting nomad total cpu usage")
 
 	var allocations []nomadAllocation
-	err := c.get("v1/allocations", &allocations)
+	err := c.get("v1/allocations?namespace=*", &allocations)
 	if err != nil {
 		slog.Error("error getting nomad total cpu usage", "error", err)
 		return 0, fmt.Errorf("error getting nomad total cpu usage: %w", err)
@@ -372,7 +681,7 @@ func (c *NomadClient) TotalCpuUsage() (int, error) {
 
 	totalUsage := 0
 	for _, alloc := range allocations {
-		if alloc.ClientStatus == "running" {
+		if alloc.ClientStatus == "running" && c.ownsNamespace(alloc.Namespace) {
 			for _, task := range alloc.AllocatedResources.Tasks {
 				totalUsage += task.Cpu.CpuShares
 			}
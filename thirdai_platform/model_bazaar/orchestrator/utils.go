@@ -1,12 +1,22 @@
 package orchestrator
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 var ErrJobNotFound = errors.New("job not found")
 
+var ErrWatchNotSupported = errors.New("orchestrator does not support watching jobs")
+
+var ErrLogStreamNotSupported = errors.New("orchestrator does not support streaming job logs")
+
 func JobExists(client Client, jobName string) (bool, error) {
 	_, err := client.JobInfo(jobName)
 	if errors.Is(err, ErrJobNotFound) {
@@ -33,3 +43,64 @@ func StopJobIfExists(client Client, jobName string) error {
 
 	return nil
 }
+
+// HclString quotes a string for use in a nomad job template. Nomad treats ${
+// and %{ as the start of interpolation or template directives, so they are
+// escaped as well.
+func HclString(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+	return strings.ReplaceAll(quoted, "%{", "%%{")
+}
+
+// YamlString quotes a string for use in a kubernetes yaml template. Json
+// strings are valid double quoted yaml strings.
+func YamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// LogStream is an open log of a job, Stream is either stdout or stderr.
+type LogStream struct {
+	Stream string
+	Reader io.ReadCloser
+}
+
+// FollowLogStreams reads the streams concurrently and calls onLine with each
+// line, one line at a time. The streams are closed once they end, and the first
+// error reading any of the streams is returned once all of them have ended.
+func FollowLogStreams(streams []LogStream, onLine func(JobLogLine)) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream LogStream) {
+			defer wg.Done()
+			defer stream.Reader.Close()
+
+			scanner := bufio.NewScanner(stream.Reader)
+			scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				mu.Lock()
+				onLine(JobLogLine{Stream: stream.Stream, Line: scanner.Text()})
+				mu.Unlock()
+			}
+
+			if err := scanner.Err(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("error reading %v logs: %w", stream.Stream, err)
+				}
+				mu.Unlock()
+			}
+		}(stream)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,137 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+var errCorruptPage = errors.New("corrupt parquet page")
+
+// decodeHybrid decodes count values from the RLE/bit-packing hybrid encoding
+// that parquet uses for definition levels and dictionary indices.
+func decodeHybrid(data []byte, bitWidth int, count int) ([]int, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+
+	values := make([]int, 0, count)
+	byteWidth := (bitWidth + 7) / 8
+
+	for len(values) < count {
+		header, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errCorruptPage
+		}
+		data = data[n:]
+
+		if header&1 == 0 {
+			runLength := int(header >> 1)
+			if len(data) < byteWidth {
+				return nil, errCorruptPage
+			}
+			value := 0
+			for i := byteWidth - 1; i >= 0; i-- {
+				value = value<<8 | int(data[i])
+			}
+			data = data[byteWidth:]
+			for i := 0; i < runLength && len(values) < count; i++ {
+				values = append(values, value)
+			}
+			continue
+		}
+
+		groups := int(header >> 1)
+		if groups*bitWidth > len(data) {
+			return nil, errCorruptPage
+		}
+		packed := data[:groups*bitWidth]
+		data = data[groups*bitWidth:]
+		for i := 0; i < groups*8 && len(values) < count; i++ {
+			value := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				value |= int(packed[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+func bitWidth(maxValue int) int {
+	return bits.Len(uint(maxValue))
+}
+
+// decodePlain decodes count values of the physical type from the plain
+// encoding, formatted as strings.
+func decodePlain(data []byte, physicalType int64, typeLength int, count int) ([]string, error) {
+	values := make([]string, 0, count)
+
+	fixed := func(size int) ([]byte, error) {
+		if len(data) < size {
+			return nil, errCorruptPage
+		}
+		value := data[:size]
+		data = data[size:]
+		return value, nil
+	}
+
+	for i := 0; i < count; i++ {
+		switch physicalType {
+		case typeBoolean:
+			if len(data) < (count+7)/8 {
+				return nil, errCorruptPage
+			}
+			values = append(values, strconv.FormatBool(data[i/8]>>(i%8)&1 == 1))
+		case typeInt32:
+			b, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10))
+		case typeInt64:
+			b, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10))
+		case typeFloat:
+			b, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32))
+		case typeDouble:
+			b, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64))
+		case typeByteArray:
+			b, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			value, err := fixed(int(binary.LittleEndian.Uint32(b)))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(value))
+		case typeFixedLenByteArray:
+			value, err := fixed(typeLength)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(value))
+		default:
+			return nil, fmt.Errorf("reading values of type %v is not supported", physicalTypeName(physicalType))
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,488 @@
+// Package parquet reads the schema and the values of flat columns from parquet
+// files, so that training data can be validated without converting it to csv.
+// Values can be read from pages that are uncompressed or compressed with snappy,
+// gzip, or zstd, and that use the plain or dictionary encodings, which covers
+// what pyarrow and spark write by default.
+package parquet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var magic = []byte("PAR1")
+
+const maxFooterLength = 64 * 1024 * 1024
+
+// Physical types.
+const (
+	typeBoolean           = 0
+	typeInt32             = 1
+	typeInt64             = 2
+	typeInt96             = 3
+	typeFloat             = 4
+	typeDouble            = 5
+	typeByteArray         = 6
+	typeFixedLenByteArray = 7
+)
+
+// Field repetition types.
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+)
+
+// Compression codecs.
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+	codecZstd         = 6
+)
+
+// Encodings.
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRleDictionary   = 8
+)
+
+// Page types.
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Column is a top level column in the schema of the file. The type is the
+// logical type of the column if it has one, and otherwise its physical type.
+// Nested columns have the type list, map, or struct.
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+
+	physicalType int64
+	typeLength   int
+	nested       bool
+}
+
+type columnChunk struct {
+	codec            int64
+	numValues        int64
+	dataOffset       int64
+	dictionaryOffset int64
+	compressedSize   int64
+}
+
+type File struct {
+	NumRows int64
+	Columns []Column
+
+	r         io.ReaderAt
+	rowGroups []map[string]columnChunk
+}
+
+// Open reads the footer of the parquet file.
+func Open(r io.ReaderAt, size int64) (*File, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, errors.New("file is too small to be a parquet file")
+	}
+
+	var tail [8]byte
+	if _, err := r.ReadAt(tail[:], size-8); err != nil {
+		return nil, fmt.Errorf("error reading parquet footer: %w", err)
+	}
+	if !bytes.Equal(tail[4:], magic) {
+		return nil, errors.New("file is not a parquet file")
+	}
+
+	footerLength := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLength > maxFooterLength || footerLength > size-int64(len(tail)+len(magic)) {
+		return nil, fmt.Errorf("invalid parquet footer length %d", footerLength)
+	}
+
+	footer := make([]byte, footerLength)
+	if _, err := r.ReadAt(footer, size-8-footerLength); err != nil {
+		return nil, fmt.Errorf("error reading parquet footer: %w", err)
+	}
+
+	metadata, err := readThriftStruct(bytes.NewReader(footer))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing parquet footer: %w", err)
+	}
+
+	columns, err := parseSchema(metadata.structs(2))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{NumRows: metadata.int(3), Columns: columns, r: r}
+
+	for _, rowGroup := range metadata.structs(4) {
+		chunks := make(map[string]columnChunk)
+		for _, chunk := range rowGroup.structs(1) {
+			meta := chunk.fields(3)
+			if meta == nil {
+				return nil, errors.New("parquet files with column chunks in separate files are not supported")
+			}
+			path := meta.list(3)
+			if len(path) != 1 {
+				continue // Only the values of top level columns can be read.
+			}
+			name, _ := path[0].([]byte)
+			chunks[string(name)] = columnChunk{
+				codec:            meta.int(4),
+				numValues:        meta.int(5),
+				dataOffset:       meta.int(9),
+				dictionaryOffset: meta.int(11),
+				compressedSize:   meta.int(7),
+			}
+		}
+		file.rowGroups = append(file.rowGroups, chunks)
+	}
+
+	return file, nil
+}
+
+func parseSchema(elements []thriftFields) ([]Column, error) {
+	if len(elements) == 0 {
+		return nil, errors.New("parquet file has no schema")
+	}
+
+	// The schema is a depth first traversal of the tree of fields, starting at
+	// the root. Only the top level fields are returned, nested fields are
+	// skipped over.
+	pos := 1
+	var skip func() error
+	skip = func() error {
+		if pos >= len(elements) {
+			return errors.New("invalid parquet schema")
+		}
+		children := int(elements[pos].int(5))
+		pos++
+		for i := 0; i < children; i++ {
+			if err := skip(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	columns := make([]Column, 0, elements[0].int(5))
+	for i := int64(0); i < elements[0].int(5); i++ {
+		if pos >= len(elements) {
+			return nil, errors.New("invalid parquet schema")
+		}
+		element := elements[pos]
+		column := Column{
+			Name:         element.string(4),
+			Nullable:     element.int(3) == repetitionOptional,
+			physicalType: element.int(1),
+			typeLength:   int(element.int(2)),
+			nested:       element.int(5) > 0 || element.int(3) > repetitionOptional,
+		}
+		column.Type = typeName(element)
+		columns = append(columns, column)
+
+		if err := skip(); err != nil {
+			return nil, err
+		}
+	}
+
+	return columns, nil
+}
+
+func physicalTypeName(physicalType int64) string {
+	switch physicalType {
+	case typeBoolean:
+		return "boolean"
+	case typeInt32:
+		return "int32"
+	case typeInt64:
+		return "int64"
+	case typeInt96:
+		return "int96"
+	case typeFloat:
+		return "float"
+	case typeDouble:
+		return "double"
+	case typeByteArray:
+		return "binary"
+	case typeFixedLenByteArray:
+		return "fixed_len_byte_array"
+	default:
+		return "unknown"
+	}
+}
+
+var convertedTypes = map[int64]string{
+	0: "string", 1: "map", 3: "list", 4: "enum", 5: "decimal", 6: "date",
+	7: "time", 8: "time", 9: "timestamp", 10: "timestamp", 19: "json", 20: "bson",
+}
+
+var logicalTypes = map[int16]string{
+	1: "string", 2: "map", 3: "list", 4: "enum", 5: "decimal", 6: "date",
+	7: "time", 8: "timestamp", 12: "json", 13: "bson", 14: "uuid",
+}
+
+func typeName(element thriftFields) string {
+	if logical := element.fields(10); logical != nil {
+		for id, name := range logicalTypes {
+			if logical.has(id) {
+				return name
+			}
+		}
+	}
+	if element.has(6) {
+		if name, ok := convertedTypes[element.int(6)]; ok {
+			return name
+		}
+	}
+	if element.int(5) > 0 {
+		return "struct"
+	}
+	if element.int(3) > repetitionOptional {
+		return "list"
+	}
+	return physicalTypeName(element.int(1))
+}
+
+func (f *File) column(name string) (Column, error) {
+	idx := slices.IndexFunc(f.Columns, func(c Column) bool { return c.Name == name })
+	if idx < 0 {
+		return Column{}, fmt.Errorf("parquet file has no column '%v'", name)
+	}
+	column := f.Columns[idx]
+	if column.nested {
+		return Column{}, fmt.Errorf("reading values of %v column '%v' is not supported", column.Type, name)
+	}
+	return column, nil
+}
+
+// ReadColumn calls fn with each value of the column, formatted as a string.
+// Null values are passed as nil.
+func (f *File) ReadColumn(name string, fn func(value *string) error) error {
+	column, err := f.column(name)
+	if err != nil {
+		return err
+	}
+
+	for _, chunks := range f.rowGroups {
+		chunk, ok := chunks[name]
+		if !ok {
+			return fmt.Errorf("parquet row group is missing column '%v'", name)
+		}
+		if err := f.readChunk(column, chunk, fn); err != nil {
+			return fmt.Errorf("error reading column '%v': %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func decompress(codec int64, data []byte, uncompressedSize int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		length, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if length > uncompressedSize {
+			return nil, errCorruptPage
+		}
+		return snappy.Decode(nil, data)
+	case codecGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(io.LimitReader(reader, int64(uncompressedSize)))
+	case codecZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(uncompressedSize)))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(data, make([]byte, 0, uncompressedSize))
+	default:
+		return nil, fmt.Errorf("compression codec %d is not supported, only uncompressed, snappy, gzip, and zstd pages can be read", codec)
+	}
+}
+
+func (f *File) readChunk(column Column, chunk columnChunk, fn func(value *string) error) error {
+	start := chunk.dataOffset
+	if chunk.dictionaryOffset > 0 && chunk.dictionaryOffset < start {
+		start = chunk.dictionaryOffset
+	}
+	reader := bufio.NewReader(io.NewSectionReader(f.r, start, chunk.compressedSize))
+
+	var dictionary []string
+	maxDefinition := 0
+	if column.Nullable {
+		maxDefinition = 1
+	}
+
+	for read := int64(0); read < chunk.numValues; {
+		header, err := readThriftStruct(reader)
+		if err != nil {
+			return fmt.Errorf("error reading page header: %w", err)
+		}
+
+		compressedSize, uncompressedSize := int(header.int(3)), int(header.int(2))
+		if compressedSize < 0 || uncompressedSize < 0 || int64(compressedSize) > chunk.compressedSize {
+			return errCorruptPage
+		}
+		page := make([]byte, compressedSize)
+		if _, err := io.ReadFull(reader, page); err != nil {
+			return fmt.Errorf("error reading page: %w", err)
+		}
+
+		var values []*string
+		switch header.int(1) {
+		case pageDictionary:
+			data, err := decompress(chunk.codec, page, uncompressedSize)
+			if err != nil {
+				return err
+			}
+			dictHeader := header.fields(7)
+			dictionary, err = decodePlain(data, column.physicalType, column.typeLength, int(dictHeader.int(1)))
+			if err != nil {
+				return err
+			}
+			continue
+		case pageData:
+			data, err := decompress(chunk.codec, page, uncompressedSize)
+			if err != nil {
+				return err
+			}
+			dataHeader := header.fields(5)
+			numValues := int(dataHeader.int(1))
+
+			var definitions []int
+			if maxDefinition > 0 {
+				if len(data) < 4 {
+					return errCorruptPage
+				}
+				length := int(binary.LittleEndian.Uint32(data))
+				if length > len(data)-4 {
+					return errCorruptPage
+				}
+				definitions, err = decodeHybrid(data[4:4+length], bitWidth(maxDefinition), numValues)
+				if err != nil {
+					return err
+				}
+				data = data[4+length:]
+			}
+
+			values, err = decodeValues(data, column, dataHeader.int(2), dictionary, definitions, numValues)
+			if err != nil {
+				return err
+			}
+		case pageDataV2:
+			dataHeader := header.fields(8)
+			numValues := int(dataHeader.int(1))
+			definitionLength, repetitionLength := int(dataHeader.int(5)), int(dataHeader.int(6))
+			if definitionLength < 0 || repetitionLength < 0 || definitionLength+repetitionLength > len(page) {
+				return errCorruptPage
+			}
+
+			// The levels are never compressed in v2 pages.
+			var definitions []int
+			if maxDefinition > 0 {
+				definitions, err = decodeHybrid(page[repetitionLength:repetitionLength+definitionLength], bitWidth(maxDefinition), numValues)
+				if err != nil {
+					return err
+				}
+			}
+
+			data := page[repetitionLength+definitionLength:]
+			if dataHeader.bool(7, true) {
+				data, err = decompress(chunk.codec, data, uncompressedSize-repetitionLength-definitionLength)
+				if err != nil {
+					return err
+				}
+			}
+
+			values, err = decodeValues(data, column, dataHeader.int(4), dictionary, definitions, numValues)
+			if err != nil {
+				return err
+			}
+		default:
+			continue // Index pages are skipped.
+		}
+
+		for _, value := range values {
+			if err := fn(value); err != nil {
+				return err
+			}
+		}
+		read += int64(len(values))
+	}
+
+	return nil
+}
+
+func decodeValues(data []byte, column Column, encoding int64, dictionary []string, definitions []int, numValues int) ([]*string, error) {
+	nonNull := numValues
+	if definitions != nil {
+		nonNull = 0
+		for _, level := range definitions {
+			if level > 0 {
+				nonNull++
+			}
+		}
+	}
+
+	var decoded []string
+	switch encoding {
+	case encodingPlain:
+		var err error
+		decoded, err = decodePlain(data, column.physicalType, column.typeLength, nonNull)
+		if err != nil {
+			return nil, err
+		}
+	case encodingPlainDictionary, encodingRleDictionary:
+		if dictionary == nil || len(data) < 1 {
+			return nil, errCorruptPage
+		}
+		indices, err := decodeHybrid(data[1:], int(data[0]), nonNull)
+		if err != nil {
+			return nil, err
+		}
+		decoded = make([]string, 0, nonNull)
+		for _, idx := range indices {
+			if idx >= len(dictionary) {
+				return nil, errCorruptPage
+			}
+			decoded = append(decoded, dictionary[idx])
+		}
+	default:
+		return nil, fmt.Errorf("encoding %d is not supported, only plain and dictionary encoded pages can be read", encoding)
+	}
+
+	values := make([]*string, 0, numValues)
+	next := 0
+	for i := 0; i < numValues; i++ {
+		if definitions != nil && definitions[i] == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, &decoded[next])
+		next++
+	}
+
+	return values, nil
+}
@@ -0,0 +1,229 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The parquet metadata is serialized with the thrift compact protocol. Instead
+// of generating code for the parquet thrift definitions, structs are decoded
+// into maps from field id to value, and only the fields that are needed are
+// read from them.
+
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+const (
+	maxThriftDepth  = 32
+	maxThriftLength = 64 * 1024 * 1024
+)
+
+type thriftReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) int(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f thriftFields) has(id int16) bool {
+	_, ok := f[id]
+	return ok
+}
+
+func (f thriftFields) bool(id int16, defaultValue bool) bool {
+	v, ok := f[id].(bool)
+	if !ok {
+		return defaultValue
+	}
+	return v
+}
+
+func (f thriftFields) string(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) fields(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+func (f thriftFields) list(id int16) []interface{} {
+	v, _ := f[id].([]interface{})
+	return v
+}
+
+func (f thriftFields) structs(id int16) []thriftFields {
+	list := f.list(id)
+	structs := make([]thriftFields, 0, len(list))
+	for _, elem := range list {
+		if s, ok := elem.(thriftFields); ok {
+			structs = append(structs, s)
+		}
+	}
+	return structs
+}
+
+func readThriftStruct(r thriftReader) (thriftFields, error) {
+	return readStruct(r, 0)
+}
+
+func readStruct(r thriftReader, depth int) (thriftFields, error) {
+	if depth > maxThriftDepth {
+		return nil, errors.New("thrift struct is nested too deeply")
+	}
+
+	fields := make(thriftFields)
+	var lastId int16
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		fieldType := header & 0x0f
+		if fieldType == thriftStop {
+			return fields, nil
+		}
+
+		if delta := int16(header >> 4); delta != 0 {
+			lastId += delta
+		} else {
+			id, err := readZigzag(r)
+			if err != nil {
+				return nil, err
+			}
+			lastId = int16(id)
+		}
+
+		var value interface{}
+		switch fieldType {
+		case thriftTrue:
+			value = true
+		case thriftFalse:
+			value = false
+		default:
+			value, err = readValue(r, fieldType, depth)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fields[lastId] = value
+	}
+}
+
+func readValue(r thriftReader, valueType byte, depth int) (interface{}, error) {
+	switch valueType {
+	case thriftTrue, thriftFalse:
+		// Booleans in lists are encoded as a single byte.
+		b, err := r.ReadByte()
+		return b == thriftTrue, err
+	case thriftByte:
+		b, err := r.ReadByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return readZigzag(r)
+	case thriftDouble:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+	case thriftBinary:
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if length > maxThriftLength {
+			return nil, fmt.Errorf("thrift binary field of length %d is too large", length)
+		}
+		buf := make([]byte, length)
+		_, err = io.ReadFull(r, buf)
+		return buf, err
+	case thriftList, thriftSet:
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size, elemType := uint64(header>>4), header&0x0f
+		if size == 15 {
+			if size, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		if size > maxThriftLength {
+			return nil, fmt.Errorf("thrift list of length %d is too large", size)
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			elem, err := readValue(r, elemType, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		return list, nil
+	case thriftMap:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []interface{}{}, nil
+		}
+		if size > maxThriftLength {
+			return nil, fmt.Errorf("thrift map of size %d is too large", size)
+		}
+		types, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		// Map keys are only needed to skip over key value metadata, so they are
+		// not kept.
+		values := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			if _, err := readValue(r, types>>4, depth+1); err != nil {
+				return nil, err
+			}
+			value, err := readValue(r, types&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case thriftStruct:
+		return readStruct(r, depth+1)
+	default:
+		return nil, fmt.Errorf("invalid thrift type %d", valueType)
+	}
+}
+
+func readZigzag(r io.ByteReader) (int64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v>>1) ^ -int64(v&1), nil
+}
@@ -0,0 +1,387 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/auth"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	CategoryQuery    = "query"
+	CategoryUpload   = "upload"
+	CategoryTrain    = "train"
+	CategoryDeploy   = "deploy"
+	CategoryDownload = "download"
+)
+
+// Config is the number of requests per minute that are allowed for each
+// budget, 0 disables the budget. Each budget is a token bucket that holds up to
+// a minute of requests, so clients can burst up to the limit and then are
+// throttled to the average rate.
+type Config struct {
+	// Global applies to all requests to model bazaar.
+	Global int `json:"global"`
+
+	// PerUser applies to all requests from a user that are authenticated with
+	// a login token.
+	PerUser int `json:"per_user"`
+
+	// PerApiKey applies to all requests made with an API key. Each key has its
+	// own budget, separate from the PerUser budget of the user that created it.
+	PerApiKey int `json:"per_api_key"`
+
+	// The query heavy and expensive endpoints have separate budgets per user,
+	// which apply in addition to the PerUser or PerApiKey budget. Requests
+	// made with an API key count against the budgets of the user that created
+	// the key, so that keys cannot be used to get around them.
+	Query    int `json:"query"`
+	Upload   int `json:"upload"`
+	Train    int `json:"train"`
+	Deploy   int `json:"deploy"`
+	Download int `json:"download"`
+}
+
+func (c Config) Enabled() bool {
+	for _, limit := range c.Budgets() {
+		if limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Budgets returns the limit of each budget, keyed by the json name of the
+// budget.
+func (c Config) Budgets() map[string]int {
+	return map[string]int{
+		"global":         c.Global,
+		"per_user":       c.PerUser,
+		"per_api_key":    c.PerApiKey,
+		CategoryQuery:    c.Query,
+		CategoryUpload:   c.Upload,
+		CategoryTrain:    c.Train,
+		CategoryDeploy:   c.Deploy,
+		CategoryDownload: c.Download,
+	}
+}
+
+// SetBudget sets the limit of the budget with the given json name.
+func (c *Config) SetBudget(name string, limit int) error {
+	budgets := map[string]*int{
+		"global":         &c.Global,
+		"per_user":       &c.PerUser,
+		"per_api_key":    &c.PerApiKey,
+		CategoryQuery:    &c.Query,
+		CategoryUpload:   &c.Upload,
+		CategoryTrain:    &c.Train,
+		CategoryDeploy:   &c.Deploy,
+		CategoryDownload: &c.Download,
+	}
+	budget, ok := budgets[name]
+	if !ok {
+		return fmt.Errorf("unknown rate limit budget '%v'", name)
+	}
+	*budget = limit
+	return nil
+}
+
+func (c Config) Validate() error {
+	for name, limit := range c.Budgets() {
+		if limit < 0 {
+			return fmt.Errorf("%v rate limit must be non negative, got %d", name, limit)
+		}
+	}
+	return nil
+}
+
+// Buckets that have not been used in this long are full and can be dropped.
+const idleBucketTimeout = 5 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// bucketSet is a set of token buckets with the same limit, keyed by user.
+type bucketSet struct {
+	limit int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newBucketSet(limit int) *bucketSet {
+	if limit <= 0 {
+		return nil
+	}
+	return &bucketSet{limit: limit, buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+type result struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func (s *bucketSet) take(key string, now time.Time) result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > idleBucketTimeout {
+		for k, b := range s.buckets {
+			if now.Sub(b.lastUsed) > idleBucketTimeout {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(float64(s.limit)/60), s.limit)}
+		s.buckets[key] = b
+	}
+	b.lastUsed = now
+
+	allowed := b.limiter.AllowN(now, 1)
+	tokens := b.limiter.TokensAt(now)
+	perSecond := float64(s.limit) / 60
+
+	res := result{
+		allowed:   allowed,
+		limit:     s.limit,
+		remaining: max(int(math.Floor(tokens)), 0),
+		reset:     time.Duration((float64(s.limit) - tokens) / perSecond * float64(time.Second)),
+	}
+	if !allowed {
+		res.retryAfter = time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	}
+	return res
+}
+
+// Limiter holds the token buckets for each budget. The limits can be changed
+// while the server is running with SetConfig.
+type Limiter struct {
+	mu         sync.RWMutex
+	config     Config
+	global     *bucketSet
+	perUser    *bucketSet
+	perApiKey  *bucketSet
+	categories map[string]*bucketSet
+}
+
+func New(config Config) *Limiter {
+	l := &Limiter{categories: make(map[string]*bucketSet)}
+	l.SetConfig(config)
+	return l
+}
+
+func (l *Limiter) Config() Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config
+}
+
+// SetConfig changes the limits of the budgets. Budgets whose limit did not
+// change keep their current state, the others start out full.
+func (l *Limiter) SetConfig(config Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.global == nil || config.Global != l.config.Global {
+		l.global = newBucketSet(config.Global)
+	}
+	if l.perUser == nil || config.PerUser != l.config.PerUser {
+		l.perUser = newBucketSet(config.PerUser)
+	}
+	if l.perApiKey == nil || config.PerApiKey != l.config.PerApiKey {
+		l.perApiKey = newBucketSet(config.PerApiKey)
+	}
+
+	old, limits := l.config.Budgets(), config.Budgets()
+	for _, category := range []string{CategoryQuery, CategoryUpload, CategoryTrain, CategoryDeploy, CategoryDownload} {
+		if _, ok := l.categories[category]; !ok || limits[category] != old[category] {
+			l.categories[category] = newBucketSet(limits[category])
+		}
+	}
+
+	l.config = config
+}
+
+var (
+	queryPath    = regexp.MustCompile(`(/search|/graphql|/model/[^/]+/permissions|/model/permissions/batch)$`)
+	trainPath    = regexp.MustCompile(`/train/(ndb|ndb-retrain|nlp-token|nlp-text|nlp-datagen|nlp-token-retrain|sweep)$`)
+	deployPath   = regexp.MustCompile(`/deploy/([^/]+)(/rollback)?$`)
+	downloadPath = regexp.MustCompile(`/model/[^/]+/download$`)
+)
+
+// These are routes under /deploy that do not start a deployment.
+var nonDeployRoutes = map[string]bool{"presets": true, "update-status": true, "log": true, "status-internal": true}
+
+func isDeploy(r *http.Request, path string) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	match := deployPath.FindStringSubmatch(path)
+	return match != nil && !nonDeployRoutes[match[1]]
+}
+
+// Category returns which of the query heavy or expensive endpoint budgets the
+// request counts against, if any.
+func Category(r *http.Request) string {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case queryPath.MatchString(path):
+		return CategoryQuery
+	case r.Method == http.MethodPost && (strings.HasSuffix(path, "/model/upload") || strings.HasSuffix(path, "/train/upload-data")):
+		return CategoryUpload
+	case r.Method == http.MethodPost && trainPath.MatchString(path):
+		return CategoryTrain
+	case isDeploy(r, path):
+		return CategoryDeploy
+	case r.Method == http.MethodGet && downloadPath.MatchString(path):
+		return CategoryDownload
+	default:
+		return ""
+	}
+}
+
+// writeHeaders sets the RateLimit headers from the IETF draft, as well as
+// Retry-After if the request was rejected. If several budgets apply then the
+// one with the fewest remaining requests is reported.
+func writeHeaders(w http.ResponseWriter, results []result) {
+	if len(results) == 0 {
+		return
+	}
+
+	reported := slices.MinFunc(results, func(a, b result) int {
+		if !a.allowed && b.allowed {
+			return -1
+		}
+		if a.allowed && !b.allowed {
+			return 1
+		}
+		return a.remaining - b.remaining
+	})
+
+	seconds := func(d time.Duration) string {
+		return strconv.Itoa(int(math.Ceil(d.Seconds())))
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(reported.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(reported.remaining))
+	w.Header().Set("RateLimit-Reset", seconds(reported.reset))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=60", reported.limit))
+	if !reported.allowed {
+		w.Header().Set("Retry-After", seconds(reported.retryAfter))
+	}
+}
+
+// budget is a bucket set along with the key of the bucket to take from.
+type budget struct {
+	set *bucketSet
+	key string
+}
+
+func (l *Limiter) check(w http.ResponseWriter, budgets ...budget) bool {
+	now := time.Now()
+	results := make([]result, 0, len(budgets))
+	allowed := true
+	for _, b := range budgets {
+		if b.set == nil {
+			continue
+		}
+		res := b.set.take(b.key, now)
+		results = append(results, res)
+		allowed = allowed && res.allowed
+	}
+
+	writeHeaders(w, results)
+
+	if !allowed {
+		http.Error(w, "rate limit exceeded, retry after the time in the Retry-After header", http.StatusTooManyRequests)
+	}
+	return allowed
+}
+
+// GlobalMiddleware limits the total rate of requests to the server.
+func (l *Limiter) GlobalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.RLock()
+		global := l.global
+		l.mu.RUnlock()
+
+		if l.check(w, budget{set: global}) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// userBudgets returns the budgets for a request from a user, which is either
+// the PerUser budget or, if the request is made with an API key, the PerApiKey
+// budget of the key, along with the budget of the request's category.
+func (l *Limiter) userBudgets(r *http.Request, userId, apiKeyId uuid.UUID) []budget {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	budgets := []budget{{set: l.perUser, key: userId.String()}}
+	if apiKeyId != uuid.Nil {
+		budgets[0] = budget{set: l.perApiKey, key: apiKeyId.String()}
+	}
+	if category := Category(r); category != "" {
+		budgets = append(budgets, budget{set: l.categories[category], key: userId.String()})
+	}
+	return budgets
+}
+
+// UserMiddleware limits the rate of requests for the user in the request
+// context, so it must run after the user is authenticated.
+func (l *Limiter) UserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.UserFromContext(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if l.check(w, l.userBudgets(r, user.Id, uuid.Nil)...) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// AllowApiKey applies the rate limits to a request made with the API key,
+// and writes a 429 response if the request is over any of the limits.
+func (l *Limiter) AllowApiKey(w http.ResponseWriter, r *http.Request, userId, apiKeyId uuid.UUID) bool {
+	return l.check(w, l.userBudgets(r, userId, apiKeyId)...)
+}
+
+type rateLimitedProvider struct {
+	auth.IdentityProvider
+	limiter *Limiter
+}
+
+func (p *rateLimitedProvider) AuthMiddleware() chi.Middlewares {
+	return append(slices.Clone(p.IdentityProvider.AuthMiddleware()), p.limiter.UserMiddleware)
+}
+
+// WrapIdentityProvider adds the per user rate limits to the auth middleware of
+// the identity provider, so that they apply to every endpoint that requires a
+// user to be logged in.
+func (l *Limiter) WrapIdentityProvider(provider auth.IdentityProvider) auth.IdentityProvider {
+	return &rateLimitedProvider{IdentityProvider: provider, limiter: l}
+}
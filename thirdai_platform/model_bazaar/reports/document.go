@@ -0,0 +1,96 @@
+package reports
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatHTML = "html"
+	FormatPDF  = "pdf"
+)
+
+// Document is a format independent representation of a report, which is
+// rendered to html or pdf.
+type Document struct {
+	Title       string
+	Subtitle    string
+	GeneratedAt time.Time
+	Sections    []Section
+}
+
+type Section struct {
+	Heading    string
+	Paragraphs []string
+	Table      *Table
+}
+
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+func ContentType(format string) string {
+	if format == FormatPDF {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+func Render(doc Document, format string) ([]byte, error) {
+	switch format {
+	case FormatHTML:
+		return RenderHTML(doc)
+	case FormatPDF:
+		return RenderPDF(doc)
+	default:
+		return nil, fmt.Errorf("unsupported report format '%v', must be '%v' or '%v'", format, FormatHTML, FormatPDF)
+	}
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'g', 6, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func flatten(prefix string, value interface{}, rows *[][]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(key, v[k], rows)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), elem, rows)
+		}
+	default:
+		*rows = append(*rows, []string{prefix, formatValue(v)})
+	}
+}
+
+// KeyValueTable flattens arbitrary json data, such as a train report, into a
+// table of the path to each value and the value.
+func KeyValueTable(data interface{}) *Table {
+	table := &Table{Columns: []string{"Field", "Value"}}
+	flatten("", data, &table.Rows)
+	return table
+}
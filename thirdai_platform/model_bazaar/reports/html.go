@@ -0,0 +1,47 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px auto; max-width: 960px; color: #222; }
+h1 { margin-bottom: 4px; }
+.subtitle { color: #555; margin-top: 0; }
+.generated { color: #888; font-size: 0.85em; }
+table { border-collapse: collapse; width: 100%; margin: 12px 0 24px; }
+th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Subtitle}}<p class="subtitle">{{.Subtitle}}</p>{{end}}
+<p class="generated">Generated {{.GeneratedAt.UTC.Format "2006-01-02 15:04 MST"}}</p>
+{{range .Sections}}
+<h2>{{.Heading}}</h2>
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}
+{{with .Table}}<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func RenderHTML(doc Document) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := htmlTemplate.Execute(buf, doc); err != nil {
+		return nil, fmt.Errorf("error rendering html report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
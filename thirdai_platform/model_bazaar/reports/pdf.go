@@ -0,0 +1,252 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The pdf renderer is a minimal writer that only supports text, lines, and the
+// standard Helvetica fonts, which is all that reports need. Text widths are
+// estimated from an average character width since the font metrics are not
+// embedded.
+
+const (
+	pageWidth    = 612.0 // US Letter in points
+	pageHeight   = 792.0
+	pageMargin   = 54.0
+	contentWidth = pageWidth - 2*pageMargin
+
+	regularFont = "F1"
+	boldFont    = "F2"
+
+	avgCharWidth = 0.55 // As a fraction of the font size
+	cellPadding  = 4.0
+)
+
+type pdfWriter struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	y       float64
+}
+
+func newPdfWriter() *pdfWriter {
+	w := &pdfWriter{}
+	w.newPage()
+	return w
+}
+
+func (w *pdfWriter) newPage() {
+	w.current = new(bytes.Buffer)
+	w.pages = append(w.pages, w.current)
+	w.y = pageHeight - pageMargin
+}
+
+// ensureSpace starts a new page if there is less than height remaining.
+func (w *pdfWriter) ensureSpace(height float64) {
+	if w.y-height < pageMargin {
+		w.newPage()
+	}
+}
+
+func escapePdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteRune(' ')
+		case r < 32 || r > 255:
+			// The standard fonts only support a single byte encoding.
+			b.WriteRune('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+func (w *pdfWriter) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(w.current, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePdfText(s))
+}
+
+func (w *pdfWriter) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(w.current, "0.8 G 0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// wrapText splits the text into lines that fit in the given width.
+func wrapText(s string, size, width float64) []string {
+	maxChars := int(width / (size * avgCharWidth))
+	if maxChars < 1 {
+		maxChars = 1
+	}
+
+	lines := []string{}
+	current := ""
+	for _, word := range strings.Fields(s) {
+		for utf8.RuneCountInString(word) > maxChars {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:maxChars]))
+			word = string(runes[maxChars:])
+		}
+		if current == "" {
+			current = word
+		} else if utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) <= maxChars {
+			current += " " + word
+		} else {
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	if current != "" || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func (w *pdfWriter) paragraph(font string, size float64, s string, spaceAfter float64) {
+	lineHeight := size * 1.3
+	for _, line := range wrapText(s, size, contentWidth) {
+		w.ensureSpace(lineHeight)
+		w.y -= lineHeight
+		w.text(font, size, pageMargin, w.y, line)
+	}
+	w.y -= spaceAfter
+}
+
+// columnWidths splits the content width between the columns in proportion to
+// the length of their longest cell, so that narrow columns are not wrapped.
+func columnWidths(table *Table) []float64 {
+	lengths := make([]float64, len(table.Columns))
+	for i, col := range table.Columns {
+		lengths[i] = float64(utf8.RuneCountInString(col))
+	}
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			if i < len(lengths) {
+				lengths[i] = max(lengths[i], float64(utf8.RuneCountInString(cell)))
+			}
+		}
+	}
+
+	total := 0.0
+	for i := range lengths {
+		lengths[i] = min(max(lengths[i], 4), 60)
+		total += lengths[i]
+	}
+
+	widths := make([]float64, len(lengths))
+	for i, l := range lengths {
+		widths[i] = contentWidth * l / total
+	}
+	return widths
+}
+
+func (w *pdfWriter) tableRow(font string, size float64, cells []string, widths []float64) {
+	lineHeight := size * 1.3
+
+	wrapped := make([][]string, len(widths))
+	nLines := 1
+	for i := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		wrapped[i] = wrapText(cell, size, widths[i]-2*cellPadding)
+		nLines = max(nLines, len(wrapped[i]))
+	}
+
+	height := float64(nLines)*lineHeight + cellPadding
+	w.ensureSpace(height)
+
+	x := pageMargin
+	for i, lines := range wrapped {
+		for j, line := range lines {
+			w.text(font, size, x+cellPadding, w.y-float64(j+1)*lineHeight, line)
+		}
+		x += widths[i]
+	}
+	w.y -= height
+	w.line(pageMargin, w.y, pageMargin+contentWidth, w.y)
+}
+
+func (w *pdfWriter) table(table *Table) {
+	widths := columnWidths(table)
+	w.tableRow(boldFont, 9, table.Columns, widths)
+	for _, row := range table.Rows {
+		w.tableRow(regularFont, 9, row, widths)
+	}
+	w.y -= 12
+}
+
+func (w *pdfWriter) bytes() []byte {
+	out := new(bytes.Buffer)
+	offsets := []int{}
+
+	addObject := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are the catalog, page tree, and fonts, each page is followed
+	// by its content stream.
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	addObject("<< /Type /Catalog /Pages 2 0 R >>")
+	addObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range w.pages {
+		addObject(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, regularFont, boldFont, 6+2*i,
+		))
+		addObject(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+func RenderPDF(doc Document) ([]byte, error) {
+	w := newPdfWriter()
+
+	w.paragraph(boldFont, 18, doc.Title, 4)
+	if doc.Subtitle != "" {
+		w.paragraph(regularFont, 11, doc.Subtitle, 2)
+	}
+	w.paragraph(regularFont, 8, "Generated "+doc.GeneratedAt.UTC().Format("2006-01-02 15:04 MST"), 14)
+
+	for _, section := range doc.Sections {
+		// Avoid leaving a heading at the bottom of a page without any content.
+		w.ensureSpace(50)
+		w.paragraph(boldFont, 13, section.Heading, 6)
+		for _, p := range section.Paragraphs {
+			w.paragraph(regularFont, 10, p, 6)
+		}
+		if section.Table != nil && len(section.Table.Columns) > 0 {
+			w.table(section.Table)
+		}
+	}
+
+	return w.bytes(), nil
+}
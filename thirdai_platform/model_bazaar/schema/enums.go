@@ -1,9 +1,13 @@
 package schema
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 const (
 	NotStarted = "not_started"
+	Queued     = "queued"
 	Starting   = "starting"
 	InProgress = "in_progress"
 	Stopped    = "stopped"
@@ -19,13 +23,60 @@ const (
 
 func CheckValidStatus(status string) error {
 	switch status {
-	case NotStarted, Starting, InProgress, Stopped, Complete, Failed:
+	case NotStarted, Queued, Starting, InProgress, Stopped, Complete, Failed:
 		return nil
 	default:
 		return fmt.Errorf("invalid status '%v'", status)
 	}
 }
 
+// The statuses a model can move to from each status, for train and deploy
+// jobs. Failed jobs can still move to complete since the status sync marks jobs
+// as failed if the orchestrator cannot find them, which can race with the job
+// reporting that it completed. Train jobs are queued if there is not enough
+// license capacity to start them, and are queued again if they are preempted
+// by a higher priority job. Deployments can be restarted or stopped from any
+// status.
+var statusTransitions = map[string]map[string][]string{
+	"train": {
+		NotStarted: {Queued, Starting, InProgress, Complete, Failed},
+		Queued:     {Starting, Failed, Stopped},
+		Starting:   {Queued, InProgress, Complete, Failed, Stopped},
+		InProgress: {Queued, Complete, Failed, Stopped},
+		Complete:   {},
+		Failed:     {Starting, Complete},
+		Stopped:    {Starting},
+	},
+	"deploy": {
+		NotStarted: {Starting, Failed, Stopped},
+		Starting:   {InProgress, Complete, Failed, Stopped},
+		InProgress: {Starting, Complete, Failed, Stopped},
+		Complete:   {Starting, Failed, Stopped},
+		Failed:     {Starting, Complete, Stopped},
+		Stopped:    {Starting, Failed},
+	},
+}
+
+// CheckValidTransition returns an error if the status of the given job cannot
+// change from one status to the other. Setting the current status again is
+// always allowed.
+func CheckValidTransition(job, from, to string) error {
+	if err := CheckValidStatus(to); err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	transitions, ok := statusTransitions[job]
+	if !ok {
+		return fmt.Errorf("invalid job '%v'", job)
+	}
+	if !slices.Contains(transitions[from], to) {
+		return fmt.Errorf("invalid %v status transition from '%v' to '%v'", job, from, to)
+	}
+	return nil
+}
+
 func CheckValidAccess(access string) error {
 	switch access {
 	case Public, Private, Protected:
@@ -56,14 +107,88 @@ const (
 	NlpDocModel         = "nlp-doc"
 	EnterpriseSearch    = "enterprise-search"
 	KnowledgeExtraction = "ke"
+	EnsembleModel       = "ensemble"
+	ExternalModel       = "external"
 	UploadInProgress    = "uploading"
 )
 
 func CheckValidModelType(modelType string) error {
 	switch modelType {
-	case NdbModel, NlpTokenModel, NlpTextModel, EnterpriseSearch:
+	case NdbModel, NlpTokenModel, NlpTextModel, EnterpriseSearch, EnsembleModel, ExternalModel:
 		return nil
 	default:
 		return fmt.Errorf("invalid model type '%v'", modelType)
 	}
 }
+
+const (
+	ModelCreatedEvent       = "model.created"
+	ModelUpdatedEvent       = "model.updated"
+	ModelDeletedEvent       = "model.deleted"
+	ModelLockedEvent        = "model.locked"
+	ModelUnlockedEvent      = "model.unlocked"
+	ModelArchivedEvent      = "model.archived"
+	ModelRehydratedEvent    = "model.rehydrated"
+	ModelGovernanceEvent    = "model.governance_updated"
+	TrainStatusEvent        = "model.train_status"
+	DeployStatusEvent       = "model.deploy_status"
+	DeploymentStartedEvent  = "deployment.started"
+	DeploymentStoppedEvent  = "deployment.stopped"
+	DeploymentRollbackEvent = "deployment.rolled_back"
+	DeploymentScaledEvent   = "deployment.autoscaling_updated"
+	DeploymentSloEvent      = "deployment.slo_status"
+	DeploymentInactiveEvent = "deployment.inactive"
+	TeamCreatedEvent        = "team.created"
+	TeamDeletedEvent        = "team.deleted"
+	TeamMemberAddedEvent    = "team.member_added"
+	TeamMemberRemovedEvent  = "team.member_removed"
+	TeamAdminChangedEvent   = "team.admin_changed"
+	OrgCreatedEvent         = "organization.created"
+	OrgDeletedEvent         = "organization.deleted"
+	OrgUpdatedEvent         = "organization.updated"
+	OrgMemberAddedEvent     = "organization.member_added"
+	OrgMemberRemovedEvent   = "organization.member_removed"
+	OrgAdminChangedEvent    = "organization.admin_changed"
+	UserLoginFailedEvent    = "user.login_failed"
+	LegalHoldPlacedEvent    = "legal_hold.placed"
+	LegalHoldReleasedEvent  = "legal_hold.released"
+	AuditExportedEvent      = "audit.exported"
+)
+
+const (
+	// SloPending is the status of an SLO until its deployment has served
+	// enough requests for it to be evaluated.
+	SloPending  = "pending"
+	SloMet      = "ok"
+	SloBreached = "breached"
+)
+
+const (
+	// Holds on audit data stop old platform events from being deleted.
+	LegalHoldAudit = "audit"
+	// Holds on a model stop the model and its job logs from being deleted.
+	LegalHoldModel = "model"
+)
+
+const (
+	PasswordResetToken     = "password_reset"
+	EmailVerificationToken = "email_verification"
+)
+
+const (
+	// Artifacts of models in the hot tier are in the platform storage.
+	StorageTierHot = "hot"
+	// The artifacts are being moved to cold storage.
+	StorageTierArchiving = "archiving"
+	// The artifacts are in cold storage, and must be rehydrated before the
+	// model can be deployed, downloaded, or retrained.
+	StorageTierCold = "cold"
+	// The artifacts are being restored from cold storage.
+	StorageTierRehydrating = "rehydrating"
+)
+
+const (
+	RiskTierLow    = "low"
+	RiskTierMedium = "medium"
+	RiskTierHigh   = "high"
+)
@@ -10,7 +10,8 @@ import (
 type Model struct {
 	Id uuid.UUID `gorm:"type:uuid;primaryKey"`
 
-	Name string `gorm:"size:100;not null"`
+	// Model names are unique per user.
+	Name string `gorm:"size:100;not null;uniqueIndex:idx_models_user_name,priority:2"`
 	Type string `gorm:"size:100;not null"`
 
 	PublishedDate time.Time
@@ -21,19 +22,27 @@ type Model struct {
 	Access            string `gorm:"size:100;not null;default:'private'"`
 	DefaultPermission string `gorm:"size:100;not null;default:'read'"`
 
+	// Locked models cannot be deleted, undeployed, or have their access reduced.
+	Locked   bool       `gorm:"not null;default:false"`
+	LockedBy *uuid.UUID `gorm:"type:uuid"`
+	LockedAt *time.Time
+
 	Attributes   []ModelAttribute  `gorm:"constraint:OnDelete:CASCADE"`
 	Dependencies []ModelDependency `gorm:"foreignKey:ModelId;constraint:OnDelete:CASCADE"`
 
 	BaseModelId *uuid.UUID `gorm:"type:uuid"`
 	BaseModel   *Model     `gorm:"constraint:OnDelete:SET NULL"`
 
-	UserId uuid.UUID `gorm:"type:uuid;not null"`
+	UserId uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_models_user_name,priority:1"`
 	User   *User
 
 	TeamId *uuid.UUID `gorm:"type:uuid"`
 	Team   *Team      `gorm:"constraint:OnDelete:SET NULL"`
 
 	UserAPIKeys []UserAPIKey `gorm:"many2many:user_api_key_models;"`
+
+	// The dataset versions the model was trained on.
+	Datasets []ModelDataset `gorm:"constraint:OnDelete:CASCADE"`
 }
 
 func (m *Model) GetAttributes() map[string]string {
@@ -67,6 +76,17 @@ type User struct {
 
 	IsAdmin bool `gorm:"not null;default:false"`
 
+	// Users can only access the models of users in the same organization, users
+	// without an organization are in a shared default organization.
+	OrganizationId *uuid.UUID `gorm:"type:uuid;index"`
+	IsOrgAdmin     bool       `gorm:"not null;default:false"`
+
+	// These are only used by the basic identity provider, the other identity
+	// providers verify emails and lock accounts themselves.
+	EmailVerified bool `gorm:"not null;default:false"`
+	FailedLogins  int  `gorm:"not null;default:0"`
+	LockedUntil   *time.Time
+
 	Models []Model
 	Teams  []UserTeam `gorm:"constraint:OnDelete:CASCADE"`
 }
@@ -75,7 +95,7 @@ type UserAPIKey struct {
 	Id uuid.UUID `gorm:"type:uuid;primaryKey"`
 
 	HashKey string  `gorm:"column:hashkey;unique;size:500;not null;index"` // Added `index` as we use this field for comparing
-	Name    string  `gorm:"size:500;not null"`
+	Name    string  `gorm:"size:500;not null;uniqueIndex:idx_user_api_keys_creator_name,priority:2"`
 	Models  []Model `gorm:"many2many:user_api_key_models;constraint:OnDelete:CASCADE;"`
 
 	AllModels bool `gorm:"default:false;not null"`
@@ -83,13 +103,31 @@ type UserAPIKey struct {
 	GeneratedTime time.Time
 	ExpiryTime    time.Time `gorm:"not null"`
 
-	CreatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_api_keys_creator_name,priority:1"`
 	User      User      `gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
 }
 
 type Team struct {
 	Id   uuid.UUID `gorm:"type:uuid;primaryKey"`
 	Name string    `gorm:"unique;size:100;not null"`
+
+	// Only users in the team's organization can be added to the team.
+	OrganizationId *uuid.UUID `gorm:"type:uuid;index"`
+}
+
+// Organization groups the users and teams of a business unit. The quotas are
+// shared by the jobs of all users in the organization, a quota of 0 means
+// there is no limit. MaxCpuMhz is the part of the license cpu limit that is
+// allocated to the organization.
+type Organization struct {
+	Id   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name string    `gorm:"unique;size:100;not null"`
+
+	MaxTrainJobs   int `gorm:"not null;default:0"`
+	MaxDeployments int `gorm:"not null;default:0"`
+	MaxCpuMhz      int `gorm:"not null;default:0"`
+
+	CreatedAt time.Time `gorm:"not null"`
 }
 
 type UserTeam struct {
@@ -119,6 +157,55 @@ type Upload struct {
 	User *User `gorm:"constraint:OnDelete:CASCADE"`
 }
 
+// Dataset is a named collection of training data with numbered versions, so
+// that train requests can refer to data by name and version instead of by
+// upload id. Dataset names are unique so that datasets shared with a team can
+// be referred to by name. Access to datasets works like access to models.
+type Dataset struct {
+	Id          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name        string    `gorm:"unique;size:100;not null"`
+	Description string
+
+	Access string `gorm:"size:100;not null;default:'private'"`
+
+	UserId uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   *User     `gorm:"constraint:OnDelete:CASCADE"`
+
+	TeamId *uuid.UUID `gorm:"type:uuid"`
+	Team   *Team      `gorm:"constraint:OnDelete:SET NULL"`
+
+	CreatedAt time.Time `gorm:"not null"`
+
+	Versions []DatasetVersion `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// DatasetVersion is an upload registered as a version of a dataset. Files can
+// no longer be added to the upload once it is registered, so the version always
+// refers to the same data. Schema is a json object describing the data.
+type DatasetVersion struct {
+	Id        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	DatasetId uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_dataset_versions_version,priority:1"`
+	Version   int       `gorm:"not null;uniqueIndex:idx_dataset_versions_version,priority:2"`
+
+	UploadId    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Files       string
+	Schema      string
+	Description string
+
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// ModelDataset records a dataset version that a model was trained on. The name
+// and version of the dataset are kept if the dataset is deleted.
+type ModelDataset struct {
+	ModelId     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	DatasetName string    `gorm:"size:100;primaryKey"`
+	Version     int       `gorm:"primaryKey"`
+
+	DatasetVersionId *uuid.UUID `gorm:"type:uuid;index"`
+}
+
 func (m *Model) TrainJobName() string {
 	return fmt.Sprintf("train-%v-%v", m.Type, m.Id)
 }
@@ -126,3 +213,607 @@ func (m *Model) TrainJobName() string {
 func (m *Model) DeployJobName() string {
 	return fmt.Sprintf("deploy-%v-%v", m.Type, m.Id)
 }
+
+type PlatformEvent struct {
+	Id        uint64     `gorm:"primaryKey;autoIncrement"`
+	Type      string     `gorm:"size:100;not null;index"`
+	ModelId   *uuid.UUID `gorm:"type:uuid;index"`
+	TeamId    *uuid.UUID `gorm:"type:uuid"`
+	Data      string
+	Timestamp time.Time `gorm:"not null"`
+
+	// DeliveredAt is set when the event relay publishes the event to the
+	// external event bus.
+	DeliveredAt *time.Time `gorm:"index"`
+}
+
+// AuditLogEntry is a request to an authenticated endpoint, recorded by the
+// audit log middleware. Action is the method and route pattern of the request,
+// for example "POST /api/v2/model/{model_id}/lock".
+type AuditLogEntry struct {
+	Id        uint64    `gorm:"primaryKey;autoIncrement"`
+	Timestamp time.Time `gorm:"not null;index"`
+
+	UserId   uuid.UUID `gorm:"type:uuid;not null;index"`
+	Username string    `gorm:"size:100;not null"`
+
+	Action  string     `gorm:"size:255;not null;index"`
+	Method  string     `gorm:"size:10;not null"`
+	Url     string     `gorm:"not null"`
+	ModelId *uuid.UUID `gorm:"type:uuid;index"`
+	Status  int        `gorm:"not null"`
+
+	ClientIp string `gorm:"size:100"`
+	Protocol string `gorm:"size:10"`
+	// The path and query params of the request as json objects.
+	PathParams  string
+	QueryParams string
+}
+
+// Webhook is an endpoint that is sent a signed POST request when the train or
+// deploy status of a model changes to one of its events. Events are stored as
+// a comma separated list.
+type Webhook struct {
+	Id     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name   string    `gorm:"size:100;not null"`
+	Url    string    `gorm:"not null"`
+	Events string    `gorm:"not null"`
+	Secret string    `gorm:"not null"`
+
+	LastDeliveryAt *time.Time
+	LastError      string
+
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+type EventPublisherCursor struct {
+	Publisher string `gorm:"size:100;primaryKey"`
+	Cursor    uint64 `gorm:"not null"`
+}
+
+type JobLogArchive struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Job     string    `gorm:"size:50;primaryKey"`
+	// Attempt is the attempt of the job run the logs are from, so that the logs
+	// of a previous run are kept when the job is run again. It is 0 for jobs
+	// which were started before job runs were recorded.
+	Attempt    int       `gorm:"primaryKey;autoIncrement:false;default:0"`
+	JobName    string    `gorm:"size:200;not null"`
+	ArchivedAt time.Time `gorm:"not null;index"`
+	// Available is false if the logs could not be retrieved when the job finished
+	// or if the archive has been deleted after the retention period.
+	Available bool `gorm:"not null;default:false"`
+}
+
+// HoldoutEvaluation stores the results of evaluating a model on its holdout
+// data after training. The split, metrics, and thresholds are stored as json.
+type HoldoutEvaluation struct {
+	ModelId    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Split      string
+	Metrics    string
+	Thresholds string
+	Passed     bool
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+type JobProgress struct {
+	ModelId    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Job        string    `gorm:"size:50;primaryKey"`
+	Phase      string    `gorm:"size:100"`
+	Step       int
+	TotalSteps int
+	Percent    float64
+	EtaSeconds *float64
+	UpdatedAt  time.Time `gorm:"not null"`
+}
+
+// StatusTransition records each change to the train or deploy status of a
+// model, so that the status history can be shown to users.
+type StatusTransition struct {
+	Id         uint64    `gorm:"primaryKey;autoIncrement"`
+	ModelId    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Job        string    `gorm:"size:50;not null"`
+	FromStatus string    `gorm:"size:100;not null"`
+	ToStatus   string    `gorm:"size:100;not null"`
+	Timestamp  time.Time `gorm:"not null"`
+}
+
+// JobRun records each run of a train or deploy job, so that the history of the
+// jobs is still available after the orchestrator has garbage collected them.
+type JobRun struct {
+	Id      uint64    `gorm:"primaryKey;autoIncrement"`
+	JobName string    `gorm:"size:200;not null;index"`
+	ModelId uuid.UUID `gorm:"type:uuid;not null;index"`
+	Job     string    `gorm:"size:50;not null"`
+	// Attempt is the number of runs of this job for the model, including this one.
+	Attempt int `gorm:"not null"`
+
+	StartedAt time.Time `gorm:"not null;index"`
+	EndedAt   *time.Time
+	// Status is the last status of the job, and Message explains why the job
+	// ended if it did not complete, for example the error if it failed.
+	Status  string `gorm:"size:100;not null"`
+	Message string
+
+	AllocationCores     int
+	AllocationMhz       int
+	AllocationMemory    int
+	AllocationMemoryMax int
+
+	// Priority is the priority of train jobs that can be preempted by higher
+	// priority jobs, or 0 if the job cannot be preempted.
+	Priority int `gorm:"not null;default:0"`
+}
+
+// QueuedTrainJob is a train job that is waiting for enough license cpu capacity
+// to start, or for its user to be below their train job limit. The train config
+// is saved before the job is queued, the license key is added to it when the
+// job is started.
+type QueuedTrainJob struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserId  uuid.UUID `gorm:"type:uuid;not null"`
+
+	ConfigPath       string `gorm:"not null"`
+	AllocationMhz    int    `gorm:"not null"`
+	AllocationMemory int    `gorm:"not null"`
+
+	// Jobs with a higher priority are started first. If Preempt is set, lower
+	// priority train jobs are stopped to make room for the job, and PreemptedAt
+	// is the last time that happened.
+	Priority    int  `gorm:"not null;default:50"`
+	Preempt     bool `gorm:"not null;default:false"`
+	PreemptedAt *time.Time
+
+	QueuedAt time.Time `gorm:"not null;index"`
+}
+
+// BatchPredictJob is an offline job that runs a model over a file and writes
+// the predictions to storage.
+type BatchPredictJob struct {
+	Id      uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ModelId uuid.UUID `gorm:"type:uuid;not null;index"`
+	UserId  uuid.UUID `gorm:"type:uuid;not null;index"`
+
+	// The input file as specified in the request.
+	InputPath     string `gorm:"not null"`
+	InputLocation string `gorm:"size:20;not null"`
+
+	Status  string `gorm:"size:100;not null"`
+	Message string
+
+	RowsProcessed int `gorm:"not null;default:0"`
+	TotalRows     int `gorm:"not null;default:0"`
+
+	CreatedAt   time.Time `gorm:"not null"`
+	CompletedAt *time.Time
+}
+
+func (j *BatchPredictJob) JobName() string {
+	return fmt.Sprintf("batch-predict-%v", j.Id)
+}
+
+func (j *BatchPredictJob) Active() bool {
+	return j.Status == Starting || j.Status == InProgress
+}
+
+// Sweep is a group of training jobs over different values of the train options
+// for the same model type and data.
+type Sweep struct {
+	Id   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name string    `gorm:"size:100;not null"`
+
+	ModelType     string `gorm:"size:100;not null"`
+	Metric        string `gorm:"size:100"`
+	MaxConcurrent int    `gorm:"not null"`
+	Status        string `gorm:"size:100;not null"`
+
+	BestModelId *uuid.UUID `gorm:"type:uuid"`
+
+	UserId uuid.UUID `gorm:"type:uuid;not null"`
+
+	Trials []SweepTrial `gorm:"constraint:OnDelete:CASCADE"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+type SweepTrial struct {
+	SweepId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Number  int       `gorm:"primaryKey;autoIncrement:false"`
+
+	// The parameter values for the trial and the full train request, stored as json.
+	Params  string
+	Request string
+
+	// The model id is set once the train job for the trial is started.
+	ModelId *uuid.UUID `gorm:"type:uuid"`
+	Error   string
+
+	// The holdout metrics from the train report once the model finishes training.
+	Metrics string
+}
+
+type SharedReport struct {
+	Id     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Kind   string    `gorm:"size:50;not null"`
+	Title  string    `gorm:"size:500;not null"`
+	Format string    `gorm:"size:10;not null"`
+
+	// Only the hash of the token in the share link is stored, the link itself
+	// is returned once when the report is generated.
+	TokenHash string `gorm:"size:100;unique;not null;index"`
+
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	User      User      `gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// DeploymentVersion is a job specification that was submitted to the
+// orchestrator for a deployment, so that it can be resubmitted if a later
+// version of the deployment breaks.
+type DeploymentVersion struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Version int       `gorm:"primaryKey;autoIncrement:false"`
+
+	// For rollbacks this is the version whose specification was resubmitted,
+	// otherwise it is the same as the version.
+	SourceVersion int `gorm:"not null"`
+
+	Image string `gorm:"size:200"`
+	Tag   string `gorm:"size:100"`
+
+	// The full job specification, stored as json.
+	Spec string `gorm:"not null"`
+
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// ImageScan records the vulnerability scan of the image for a job, and whether
+// the job was allowed to start.
+type ImageScan struct {
+	Id      uint64 `gorm:"primaryKey;autoIncrement"`
+	JobName string `gorm:"size:200;not null;index"`
+	Image   string `gorm:"size:500;not null"`
+	Digest  string `gorm:"size:100"`
+	Scanner string `gorm:"size:50;not null"`
+
+	Critical int
+	High     int
+	Medium   int
+	Low      int
+	Unknown  int
+
+	// Either allowed, warned, or blocked.
+	Result string `gorm:"size:20;not null"`
+	Error  string
+
+	CreatedAt time.Time `gorm:"not null;index"`
+}
+
+// JobEnvVar is an additional environment variable that is set for all jobs of
+// the given type, either train or deploy.
+type JobEnvVar struct {
+	Job       string    `gorm:"size:20;primaryKey"`
+	Key       string    `gorm:"size:200;primaryKey"`
+	Value     string    `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// TrustedCertificate is a pem encoded CA certificate that is trusted by model
+// bazaar and by jobs, in addition to the system CAs.
+type TrustedCertificate struct {
+	Id          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name        string    `gorm:"size:100;not null"`
+	Subject     string    `gorm:"not null"`
+	Fingerprint string    `gorm:"size:64;not null;uniqueIndex"`
+	Pem         string    `gorm:"not null"`
+	NotAfter    time.Time `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+}
+
+// ModelUploadSession stores what the client expects a chunked model upload to
+// contain, so that the upload can be verified when it is committed.
+type ModelUploadSession struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// TotalChunks and Sha256 are optional, 0 and empty mean they are unknown.
+	TotalChunks int
+	Sha256      string    `gorm:"size:64"`
+	CreatedAt   time.Time `gorm:"not null"`
+	// Expiration of the most recent upload token issued for the upload.
+	TokenExpiresAt *time.Time
+}
+
+// ModelUploadChunk records each chunk of a model upload that has been received,
+// so that clients can resume an upload by only sending the missing chunks.
+type ModelUploadChunk struct {
+	ModelId    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ChunkIdx   int       `gorm:"primaryKey;autoIncrement:false"`
+	Size       int64     `gorm:"not null"`
+	Sha256     string    `gorm:"size:64;not null"`
+	ReceivedAt time.Time `gorm:"not null"`
+}
+
+// ModelUploadPresignedChunk records the sha256 that a presigned url for a chunk
+// of a model upload was created with, so that the chunk can only be confirmed
+// with the checksum that the storage verified.
+type ModelUploadPresignedChunk struct {
+	ModelId     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ChunkIdx    int       `gorm:"primaryKey;autoIncrement:false"`
+	Sha256      string    `gorm:"size:64;not null"`
+	PresignedAt time.Time `gorm:"not null"`
+}
+
+// OidcIdentity links the subject of an oidc issuer to a platform user, since
+// subjects are not necessarily uuids and are not shared between issuers.
+type OidcIdentity struct {
+	Issuer    string    `gorm:"size:500;primaryKey"`
+	Subject   string    `gorm:"size:255;primaryKey"`
+	UserId    uuid.UUID `gorm:"type:uuid;not null;index"`
+	User      *User     `gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// UserJobLimit overrides the default limits on the number of train jobs and
+// deployments a user can run at the same time. A nil limit uses the default,
+// and 0 means the user is not limited.
+type UserJobLimit struct {
+	UserId         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	User           *User     `gorm:"constraint:OnDelete:CASCADE"`
+	MaxTrainJobs   *int
+	MaxDeployments *int
+	UpdatedAt      time.Time `gorm:"not null"`
+}
+
+// RateLimitSetting is the limit of a rate limit budget set by an admin, which
+// takes precedence over the limit from the environment of model bazaar.
+type RateLimitSetting struct {
+	Budget    string    `gorm:"size:50;primaryKey"`
+	PerMinute int       `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// HealthReportRun is a weekly health report sent to admins. The week is the
+// primary key so that each report is only sent by one instance of model bazaar,
+// and the storage usage is recorded to show the trend in later reports.
+type HealthReportRun struct {
+	WeekStart time.Time `gorm:"primaryKey"`
+	SentAt    time.Time `gorm:"not null"`
+
+	StorageUsedBytes  int64
+	StorageTotalBytes int64
+
+	Recipients int
+	// Status is sent or failed, and Message is the error if it failed.
+	Status  string `gorm:"size:20;not null"`
+	Message string
+}
+
+// DeploymentPreset is a named set of deployment settings managed by admins that
+// deploy requests can reference instead of specifying the settings themselves.
+type DeploymentPreset struct {
+	Name        string `gorm:"size:100;primaryKey"`
+	Description string
+	// The json encoded deployment settings.
+	Settings  string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// TrainSchedule retrains a model on a cron schedule. Each run creates a new
+// model from the same base model, named with the model name of the schedule
+// and the time of the run.
+type TrainSchedule struct {
+	Id        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ModelId   uuid.UUID `gorm:"type:uuid;not null;index"`
+	ModelType string    `gorm:"size:100;not null"`
+	UserId    uuid.UUID `gorm:"type:uuid;not null;index"`
+
+	ModelName string `gorm:"size:100;not null"`
+	Cron      string `gorm:"size:100;not null"`
+	Enabled   bool   `gorm:"not null"`
+
+	// The json encoded options for the retrain request, without the model name
+	// and base model id.
+	Options string `gorm:"not null"`
+
+	NextRunAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Runs []TrainScheduleRun `gorm:"foreignKey:ScheduleId;constraint:OnDelete:CASCADE"`
+}
+
+// TrainScheduleRun records each time a schedule was due, including runs that
+// were skipped because the retrain from the previous run was still in progress.
+type TrainScheduleRun struct {
+	Id         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ScheduleId uuid.UUID `gorm:"type:uuid;not null;index"`
+
+	Status  string     `gorm:"size:100;not null"`
+	ModelId *uuid.UUID `gorm:"type:uuid"`
+	Message string
+
+	RunAt time.Time `gorm:"not null;index"`
+}
+
+// LegalHold prevents data from being deleted while it may be needed for
+// litigation. A hold is active until it is released, and released holds are
+// kept as a record of the hold.
+type LegalHold struct {
+	Id    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Scope string    `gorm:"size:20;not null;index"`
+	// ModelId is only set for holds on a model.
+	ModelId *uuid.UUID `gorm:"type:uuid;index"`
+	Reason  string     `gorm:"not null"`
+
+	PlacedBy uuid.UUID `gorm:"type:uuid;not null"`
+	PlacedAt time.Time `gorm:"not null"`
+
+	ReleasedBy *uuid.UUID `gorm:"type:uuid"`
+	ReleasedAt *time.Time
+}
+
+// StorageQuotaOverride replaces the storage quota for the type of a model, so
+// that admins can allow a specific model to exceed it.
+type StorageQuotaOverride struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	// MaxBytes is the quota for the model, 0 means it is unlimited.
+	MaxBytes int64  `gorm:"not null"`
+	Reason   string `gorm:"not null"`
+
+	UpdatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// Experiment splits the traffic of a virtual endpoint between the deployments
+// of two models, so that a change to a model can be measured against the
+// current model before all traffic is moved to it.
+type Experiment struct {
+	Id   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name string    `gorm:"unique;size:100;not null"`
+
+	ModelAId uuid.UUID `gorm:"type:uuid;not null;index"`
+	ModelBId uuid.UUID `gorm:"type:uuid;not null;index"`
+	// The percentage of callers that are assigned to model b.
+	SplitPercent int `gorm:"not null"`
+
+	UserId uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   *User     `gorm:"constraint:OnDelete:CASCADE"`
+
+	CreatedAt time.Time `gorm:"not null"`
+
+	Arms []ExperimentArm `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// ExperimentArm has the metrics of the requests that an experiment sent to one
+// of its models.
+type ExperimentArm struct {
+	ExperimentId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Arm          string    `gorm:"size:1;primaryKey"`
+
+	Queries        int64 `gorm:"not null;default:0"`
+	QueryErrors    int64 `gorm:"not null;default:0"`
+	TotalLatencyMs int64 `gorm:"not null;default:0"`
+	Feedback       int64 `gorm:"not null;default:0"`
+}
+
+// DeploymentSlo has the latency and error rate objectives of a deployment, and
+// the result of the last time they were evaluated against the metrics scraped
+// from the deployment.
+type DeploymentSlo struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Model   *Model    `gorm:"foreignKey:ModelId;constraint:OnDelete:CASCADE"`
+
+	// LatencyMs is the maximum average query latency, 0 means it is not checked.
+	LatencyMs int `gorm:"not null"`
+	// ErrorRatePercent is the maximum percentage of queries that fail, 0 means
+	// it is not checked.
+	ErrorRatePercent float64 `gorm:"not null"`
+	WindowMinutes    int     `gorm:"not null"`
+	// The objectives are not evaluated in windows with fewer requests.
+	MinRequests int `gorm:"not null"`
+
+	Status  string `gorm:"size:20;not null"`
+	Message string
+	// The values observed in the last evaluation.
+	Requests                 float64
+	ObservedLatencyMs        float64
+	ObservedErrorRatePercent float64
+	EvaluatedAt              *time.Time
+	BreachedAt               *time.Time
+
+	UpdatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// EntityDictionaryVersion is a version of the dictionary of an nlp-token
+// deployment, which tags exact strings and regexes in the text in addition to
+// the entities predicted by the model. Each change to the entries creates a
+// new version, and the deployment uses the latest one.
+type EntityDictionaryVersion struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Version int       `gorm:"primaryKey;autoIncrement:false"`
+
+	// The entries, stored as json.
+	Entries string `gorm:"not null"`
+	// Describes the change from the previous version.
+	Change string `gorm:"not null"`
+
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// UnredactGrant allows a user to view the original text of the entities that an
+// enterprise search workflow redacts. It is separate from the access to the
+// workflow, so that users that can query the workflow only see redacted text.
+type UnredactGrant struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserId  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	User    *User     `gorm:"constraint:OnDelete:CASCADE"`
+
+	GrantedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// UserToken is a single use token that is emailed to a user to reset their
+// password or verify their email. Only the sha256 hash of the token is stored.
+type UserToken struct {
+	TokenHash string    `gorm:"primaryKey"`
+	UserId    uuid.UUID `gorm:"type:uuid;not null;index"`
+	User      *User     `gorm:"constraint:OnDelete:CASCADE"`
+	Purpose   string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// InactiveDeployment flags a deployment that has not been queried or retrained
+// for the inactivity period. The flag is removed if the deployment is used
+// again, and the deployment is stopped at UndeployAt if it is set.
+type InactiveDeployment struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Model   *Model    `gorm:"foreignKey:ModelId;constraint:OnDelete:CASCADE"`
+
+	FlaggedAt  time.Time `gorm:"not null"`
+	UndeployAt *time.Time
+}
+
+// ModelStorageTier records where the artifacts of a model are stored. Models
+// without a row are in the hot tier and have not been used since they were
+// published.
+type ModelStorageTier struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Model   *Model    `gorm:"foreignKey:ModelId;constraint:OnDelete:CASCADE"`
+
+	Tier string `gorm:"size:20;not null"`
+	// LastUsedAt is the last time the model was deployed or downloaded.
+	LastUsedAt   *time.Time
+	ArchivedAt   *time.Time
+	ArchiveBytes int64
+	// Message is the last error moving the artifacts between tiers.
+	Message string
+	// ClaimedAt is set while an instance of model bazaar is moving the
+	// artifacts, so that other instances do not move them at the same time.
+	ClaimedAt *time.Time
+}
+
+// ModelGovernance is the governance metadata of a model. Models without a row
+// have no risk tier and are not approved for production.
+type ModelGovernance struct {
+	ModelId uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Model   *Model    `gorm:"foreignKey:ModelId;constraint:OnDelete:CASCADE"`
+
+	RiskTier string `gorm:"size:20"`
+
+	ApprovedForProduction bool       `gorm:"not null;default:false"`
+	ApprovedBy            *uuid.UUID `gorm:"type:uuid"`
+	Approver              *User      `gorm:"foreignKey:ApprovedBy;constraint:OnDelete:SET NULL"`
+	ApprovedAt            *time.Time
+
+	// ReviewDate is when the model must next be reviewed, the approval lapses
+	// after this date.
+	ReviewDate *time.Time
+
+	UpdatedBy *uuid.UUID `gorm:"type:uuid"`
+	UpdatedAt time.Time  `gorm:"not null"`
+}
@@ -12,8 +12,10 @@ var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrModelNotFound      = errors.New("model not found")
 	ErrTeamNotFound       = errors.New("team not found")
+	ErrOrgNotFound        = errors.New("organization not found")
 	ErrUserTeamNotFound   = errors.New("user team relationship not found")
 	ErrUserAPIKeyNotFound = errors.New("user api key not found")
+	ErrDatasetNotFound    = errors.New("dataset not found")
 	ErrDbAccessFailed     = errors.New("db access failed")
 )
 
@@ -100,3 +102,52 @@ func GetUserTeam(teamId, userId uuid.UUID, db *gorm.DB) (UserTeam, error) {
 
 	return team, nil
 }
+
+func GetDataset(datasetId uuid.UUID, db *gorm.DB) (Dataset, error) {
+	var dataset Dataset
+
+	result := db.First(&dataset, "id = ?", datasetId)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return dataset, ErrDatasetNotFound
+		}
+		slog.Error("sql error in get dataset", "dataset_id", datasetId, "error", result.Error)
+		return dataset, ErrDbAccessFailed
+	}
+
+	return dataset, nil
+}
+
+func GetOrganization(orgId uuid.UUID, db *gorm.DB) (Organization, error) {
+	var org Organization
+
+	result := db.First(&org, "id = ?", orgId)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return org, ErrOrgNotFound
+		}
+		slog.Error("sql error in get organization", "org_id", orgId, "error", result.Error)
+		return org, ErrDbAccessFailed
+	}
+
+	return org, nil
+}
+
+// SameOrganization returns if two users or teams with the given organizations
+// are in the same organization.
+func SameOrganization(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// OrganizationUserIds returns a subquery for the ids of the users in the
+// organization, or of the users without an organization if orgId is nil.
+func OrganizationUserIds(db *gorm.DB, orgId *uuid.UUID) *gorm.DB {
+	query := db.Model(&User{}).Select("id")
+	if orgId == nil {
+		return query.Where("organization_id IS NULL")
+	}
+	return query.Where("organization_id = ?", *orgId)
+}
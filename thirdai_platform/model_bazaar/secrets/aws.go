@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"thirdai_platform/utils"
+	"time"
+)
+
+const (
+	awsService    = "secretsmanager"
+	awsDateFormat = "20060102T150405Z"
+)
+
+// AwsProvider reads secrets from AWS Secrets Manager. References have the form
+// aws-sm://secret-id#key, where the secret id is the name or arn of the secret.
+// If a key is given the secret string is parsed as a json object and the value
+// of the key is returned, otherwise the secret string is returned as is.
+type AwsProvider struct {
+	region          string
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+	endpoint        string
+
+	client *http.Client
+}
+
+func NewAwsProvider(region, accessKeyId, secretAccessKey, sessionToken, endpoint string) (*AwsProvider, error) {
+	if accessKeyId == "" || secretAccessKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be specified to use aws secrets")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%v.amazonaws.com", region)
+	}
+	return &AwsProvider{
+		region:          region,
+		accessKeyId:     accessKeyId,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		client:          &http.Client{Timeout: 30 * time.Second, Transport: utils.OutboundTransport()},
+	}, nil
+}
+
+type awsGetSecretValueResponse struct {
+	SecretString *string `json:"SecretString"`
+}
+
+func (p *AwsProvider) Get(ref Reference) (string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": ref.Path})
+	if err != nil {
+		return "", fmt.Errorf("error encoding secrets manager request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", p.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("error creating secrets manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	p.sign(req, body, time.Now().UTC())
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending secrets manager request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("secrets manager returned status %d: %v", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var secret awsGetSecretValueResponse
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error parsing secrets manager response: %w", err)
+	}
+	if secret.SecretString == nil {
+		return "", fmt.Errorf("secret '%v' does not have a secret string, binary secrets are not supported", ref.Path)
+	}
+
+	if ref.Key == "" {
+		return *secret.SecretString, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(*secret.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secret '%v' is not a json object, a key cannot be used: %w", ref.Path, err)
+	}
+	value, ok := values[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret '%v' does not have key '%v'", ref.Path, ref.Key)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key '%v' of secret '%v' is not a string", ref.Key, ref.Path)
+	}
+
+	return str, nil
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds a signature version 4 authorization header to the request.
+// See https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv.html
+func (p *AwsProvider) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	timestamp := now.Format(awsDateFormat)
+	date := timestamp[:8]
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, p.region, awsService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+p.secretAccessKey), date)
+	key = hmacSha256(key, p.region)
+	key = hmacSha256(key, awsService)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		p.accessKeyId, scope, signedHeaders, signature,
+	))
+}
@@ -0,0 +1,62 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// KubernetesProvider reads Kubernetes secrets with the api, rather than from
+// files or env vars in the pod, so that rotated secrets are seen without
+// restarting the pod. References have the form k8s://namespace/name#key. The
+// service account of model bazaar needs permission to get the secrets.
+type KubernetesProvider struct {
+	clientset kubernetes.Interface
+}
+
+func NewKubernetesProvider(clientset kubernetes.Interface) *KubernetesProvider {
+	return &KubernetesProvider{clientset: clientset}
+}
+
+// NewInClusterKubernetesProvider uses the service account of the pod that
+// model bazaar is running in.
+func NewInClusterKubernetesProvider() (*KubernetesProvider, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error getting in-cluster config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes clientset: %w", err)
+	}
+
+	return NewKubernetesProvider(clientset), nil
+}
+
+func (p *KubernetesProvider) Get(ref Reference) (string, error) {
+	namespace, name, found := strings.Cut(ref.Path, "/")
+	if !found || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("kubernetes secret path '%v' must have the form namespace/name", ref.Path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	secret, err := p.clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting kubernetes secret: %w", err)
+	}
+
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("kubernetes secret '%v' does not have key '%v'", ref.Path, ref.Key)
+	}
+
+	return string(value), nil
+}
@@ -0,0 +1,245 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemeVault      = "vault"
+	SchemeAws        = "aws-sm"
+	SchemeKubernetes = "k8s"
+)
+
+// Reference is a secret stored outside of the env, written as
+// scheme://path#key. The path and key are interpreted by the provider for the
+// scheme:
+//   - vault://mount/path#key reads the key of a Vault KV v2 secret.
+//   - aws-sm://secret-id#key reads an AWS Secrets Manager secret. If a key is
+//     given the secret must be a json object, otherwise the whole secret string
+//     is used.
+//   - k8s://namespace/name#key reads the key of a Kubernetes secret.
+type Reference struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+func (r Reference) String() string {
+	if r.Key == "" {
+		return r.Scheme + "://" + r.Path
+	}
+	return r.Scheme + "://" + r.Path + "#" + r.Key
+}
+
+// ParseReference returns false if the value is not a reference to a secret, in
+// which case it should be used as is.
+func ParseReference(value string) (Reference, bool, error) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found || (scheme != SchemeVault && scheme != SchemeAws && scheme != SchemeKubernetes) {
+		return Reference{}, false, nil
+	}
+
+	path, key, _ := strings.Cut(rest, "#")
+	ref := Reference{Scheme: scheme, Path: strings.Trim(path, "/"), Key: key}
+	if ref.Path == "" {
+		return ref, true, fmt.Errorf("secret reference '%v' does not include a path", value)
+	}
+	if ref.Key == "" && scheme != SchemeAws {
+		return ref, true, fmt.Errorf("secret reference '%v' does not include a key", value)
+	}
+
+	return ref, true, nil
+}
+
+type Provider interface {
+	// Get returns the current value of the secret.
+	Get(ref Reference) (string, error)
+}
+
+// Config has the credentials for the providers, only the providers that are
+// referenced by the env need to be configured.
+type Config struct {
+	VaultAddr      string
+	VaultToken     string
+	VaultNamespace string
+
+	AwsRegion          string
+	AwsAccessKeyId     string
+	AwsSecretAccessKey string
+	AwsSessionToken    string
+	// AwsEndpoint overrides the Secrets Manager endpoint for the region, for
+	// example to use a vpc endpoint.
+	AwsEndpoint string
+}
+
+// ConfigFromEnv loads the config from the standard VAULT_* and AWS_* env vars.
+func ConfigFromEnv() Config {
+	return Config{
+		VaultAddr:      os.Getenv("VAULT_ADDR"),
+		VaultToken:     os.Getenv("VAULT_TOKEN"),
+		VaultNamespace: os.Getenv("VAULT_NAMESPACE"),
+
+		AwsRegion:          os.Getenv("AWS_REGION"),
+		AwsAccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		AwsSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		AwsSessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		AwsEndpoint:        os.Getenv("AWS_SECRETS_MANAGER_ENDPOINT"),
+	}
+}
+
+// NewProvider creates the provider for the scheme from the config.
+func (c Config) NewProvider(scheme string) (Provider, error) {
+	switch scheme {
+	case SchemeVault:
+		return NewVaultProvider(c.VaultAddr, c.VaultToken, c.VaultNamespace)
+	case SchemeAws:
+		return NewAwsProvider(c.AwsRegion, c.AwsAccessKeyId, c.AwsSecretAccessKey, c.AwsSessionToken, c.AwsEndpoint)
+	case SchemeKubernetes:
+		return NewInClusterKubernetesProvider()
+	default:
+		return nil, fmt.Errorf("unsupported secret provider '%v'", scheme)
+	}
+}
+
+type resolvedEnv struct {
+	ref   Reference
+	value string
+}
+
+// Resolver replaces env vars whose values are secret references with the
+// secrets, and checks whether the secrets have been rotated. Providers are only
+// created for the schemes that are referenced.
+type Resolver struct {
+	newProvider func(scheme string) (Provider, error)
+
+	mu        sync.Mutex
+	providers map[string]Provider
+	resolved  map[string]resolvedEnv
+}
+
+func NewResolver(newProvider func(scheme string) (Provider, error)) *Resolver {
+	return &Resolver{
+		newProvider: newProvider,
+		providers:   map[string]Provider{},
+		resolved:    map[string]resolvedEnv{},
+	}
+}
+
+func (r *Resolver) get(ref Reference) (string, error) {
+	provider, ok := r.providers[ref.Scheme]
+	if !ok {
+		var err error
+		provider, err = r.newProvider(ref.Scheme)
+		if err != nil {
+			return "", fmt.Errorf("error creating %v secret provider: %w", ref.Scheme, err)
+		}
+		r.providers[ref.Scheme] = provider
+	}
+
+	value, err := provider.Get(ref)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret '%v': %w", ref, err)
+	}
+	return value, nil
+}
+
+// ResolveEnv sets each env var that is a secret reference to the value of the
+// secret, and returns the names of the env vars that were resolved. All
+// references are attempted and the errors are combined.
+func (r *Resolver) ResolveEnv() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	keys := []string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+
+		ref, ok, err := ParseReference(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid env var %v: %w", key, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		secret, err := r.get(ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to resolve env var %v: %w", key, err))
+			continue
+		}
+
+		if err := os.Setenv(key, secret); err != nil {
+			errs = append(errs, fmt.Errorf("unable to set env var %v: %w", key, err))
+			continue
+		}
+		r.resolved[key] = resolvedEnv{ref: ref, value: secret}
+		keys = append(keys, key)
+
+		slog.Info("resolved env var from secret provider", "key", key, "provider", ref.Scheme)
+	}
+
+	slices.Sort(keys)
+
+	return keys, errors.Join(errs...)
+}
+
+// Refresh fetches the secrets for the resolved env vars again, and returns the
+// names of the env vars whose secrets have changed. The env vars are updated to
+// the new values. Secrets that cannot be fetched keep their previous values.
+func (r *Resolver) Refresh() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	changed := []string{}
+	for key, resolved := range r.resolved {
+		secret, err := r.get(resolved.ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to refresh env var %v: %w", key, err))
+			continue
+		}
+		if secret == resolved.value {
+			continue
+		}
+
+		if err := os.Setenv(key, secret); err != nil {
+			errs = append(errs, fmt.Errorf("unable to set env var %v: %w", key, err))
+			continue
+		}
+		r.resolved[key] = resolvedEnv{ref: resolved.ref, value: secret}
+		changed = append(changed, key)
+	}
+
+	slices.Sort(changed)
+
+	return changed, errors.Join(errs...)
+}
+
+// WaitForRotation refreshes the secrets at the given interval until at least
+// one of them changes, and returns the names of the env vars that changed.
+// Errors are logged and the secrets are checked again at the next interval.
+func (r *Resolver) WaitForRotation(interval time.Duration) []string {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := r.Refresh()
+		if err != nil {
+			slog.Error("error refreshing secrets", "error", err)
+		}
+		if len(changed) > 0 {
+			return changed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"thirdai_platform/utils"
+	"time"
+)
+
+// VaultProvider reads secrets from the KV version 2 secrets engine of
+// HashiCorp Vault. References have the form vault://mount/path#key, for
+// example vault://secret/thirdai/platform#jwt_secret.
+type VaultProvider struct {
+	addr      string
+	token     string
+	namespace string
+
+	client *http.Client
+}
+
+func NewVaultProvider(addr, token, namespace string) (*VaultProvider, error) {
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR must be specified to use vault secrets")
+	}
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN must be specified to use vault secrets")
+	}
+	return &VaultProvider{
+		addr:      addr,
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 30 * time.Second, Transport: utils.OutboundTransport()},
+	}, nil
+}
+
+type vaultKvResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func (p *VaultProvider) Get(ref Reference) (string, error) {
+	mount, path, found := strings.Cut(ref.Path, "/")
+	if !found || path == "" {
+		return "", fmt.Errorf("vault secret path '%v' must have the form mount/path", ref.Path)
+	}
+
+	endpoint, err := url.JoinPath(p.addr, "v1", mount, "data", path)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault url: %w", err)
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending vault request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("vault returned status %d: %v", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var secret vaultKvResponse
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error parsing vault response: %w", err)
+	}
+
+	value, ok := secret.Data.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("vault secret '%v' does not have key '%v'", ref.Path, ref.Key)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key '%v' of vault secret '%v' is not a string", ref.Key, ref.Path)
+	}
+
+	return str, nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+)
+
+type passwordResetRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+// RequestPasswordReset emails a password reset link to the user. It succeeds
+// even if no user has the email, so that it does not reveal which emails have
+// accounts.
+func (s *UserService) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var params passwordResetRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid password reset request: %v", err), err)
+		return
+	}
+
+	if err := s.recovery.SendPasswordReset(strings.TrimSpace(params.Email)); err != nil {
+		if errors.Is(err, auth.ErrEmailNotConfigured) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		slog.Error("error sending password reset email", "error", err)
+		http.Error(w, "error sending password reset email", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (s *UserService) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var params resetPasswordRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid password reset: %v", err), err)
+		return
+	}
+
+	if err := s.recovery.ResetPassword(params.Token, params.Password); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			http.Error(w, fmt.Sprintf("unable to reset password: %v", err), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error resetting password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+type emailVerificationRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+// RequestEmailVerification resends the verification email. Users cannot log in
+// until their email is verified, so this does not require authentication and
+// succeeds even if no user has the email or the email is already verified.
+func (s *UserService) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var params emailVerificationRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid email verification request: %v", err), err)
+		return
+	}
+
+	if err := s.recovery.SendEmailVerification(strings.TrimSpace(params.Email)); err != nil {
+		if errors.Is(err, auth.ErrEmailNotConfigured) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		slog.Error("error sending verification email", "error", err)
+		http.Error(w, "error sending verification email", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (s *UserService) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var params verifyEmailRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid email verification: %v", err), err)
+		return
+	}
+
+	if err := s.recovery.VerifyEmail(params.Token); err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			http.Error(w, fmt.Sprintf("unable to verify email: %v", err), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error verifying email: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"strings"
 	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/ratelimit"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/utils"
 	"time"
@@ -61,16 +62,18 @@ func hashSecret(secret string) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func validateApiKey(db *gorm.DB, r *http.Request) (uuid.UUID, time.Time, error) {
+// lookupApiKey returns the unexpired API key in the X-API-Key header, without
+// checking which models it has access to.
+func lookupApiKey(db *gorm.DB, r *http.Request) (schema.UserAPIKey, error) {
 	fullKey := r.Header.Get("X-API-Key")
 
 	if fullKey == "" {
-		return uuid.Nil, time.Time{}, ErrMissingAPIKey
+		return schema.UserAPIKey{}, ErrMissingAPIKey
 	}
 
 	secret, err := removeApiKeyPrefix(fullKey)
 	if err != nil {
-		return uuid.Nil, time.Time{}, ErrInvalidAPIKey
+		return schema.UserAPIKey{}, ErrInvalidAPIKey
 	}
 
 	hashedKey := hashSecret(secret)
@@ -78,40 +81,73 @@ func validateApiKey(db *gorm.DB, r *http.Request) (uuid.UUID, time.Time, error)
 	var record schema.UserAPIKey
 	if err := db.Where("hashkey = ?", hashedKey).Preload("Models").First(&record).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return uuid.Nil, time.Time{}, ErrInvalidAPIKey
+			return schema.UserAPIKey{}, ErrInvalidAPIKey
 		}
-		return uuid.Nil, time.Time{}, fmt.Errorf("database error: %w", err)
+		return schema.UserAPIKey{}, fmt.Errorf("database error: %w", err)
 	}
 
 	if time.Now().After(record.ExpiryTime) {
-		return uuid.Nil, time.Time{}, ErrExpiredAPIKey
+		return schema.UserAPIKey{}, ErrExpiredAPIKey
 	}
 
 	if hashSecret(secret) != record.HashKey {
-		return uuid.Nil, time.Time{}, ErrInvalidAPIKey
+		return schema.UserAPIKey{}, ErrInvalidAPIKey
+	}
+
+	return record, nil
+}
+
+func apiKeyHasModel(key schema.UserAPIKey, modelId uuid.UUID) bool {
+	if key.AllModels {
+		return true
+	}
+	for _, model := range key.Models {
+		if model.Id == modelId {
+			return true
+		}
+	}
+	return false
+}
+
+func validateApiKey(db *gorm.DB, r *http.Request) (schema.UserAPIKey, error) {
+	record, err := lookupApiKey(db, r)
+	if err != nil {
+		return schema.UserAPIKey{}, err
 	}
 
 	modelId, err := utils.URLParamUUID(r, "model_id")
 	if err != nil {
-		return uuid.Nil, time.Time{}, fmt.Errorf("invalid model_id parameter: %w", err)
+		return schema.UserAPIKey{}, fmt.Errorf("invalid model_id parameter: %w", err)
 	}
 
-	if !record.AllModels {
-		for _, model := range record.Models {
-			if model.Id == modelId {
-				return record.CreatedBy, record.ExpiryTime, nil
-			}
-		}
-	} else {
-		return record.CreatedBy, record.ExpiryTime, nil
+	if !apiKeyHasModel(record, modelId) {
+		return schema.UserAPIKey{}, ErrAPIKeyModelMismatch
 	}
 
-	return uuid.Nil, time.Time{}, ErrAPIKeyModelMismatch
+	return record, nil
 }
 
+// eitherUserOrApiKeyAuthMiddleware authenticates requests with an API key if
+// one is given, and otherwise with the user auth middleware. Requests with an
+// API key count against the per API key rate limits.
 func eitherUserOrApiKeyAuthMiddleware(
 	db *gorm.DB,
 	userAuthMiddlewares chi.Middlewares,
+	rateLimiter *ratelimit.Limiter,
+) func(http.Handler) http.Handler {
+	return apiKeyOrUserAuthMiddleware(db, userAuthMiddlewares, rateLimiter, func(r *http.Request) (schema.UserAPIKey, error) {
+		return validateApiKey(db, r)
+	})
+}
+
+// apiKeyOrUserAuthMiddleware is eitherUserOrApiKeyAuthMiddleware for routes
+// whose API keys are validated by validate instead of against the model_id url
+// parameter.
+func apiKeyOrUserAuthMiddleware(
+	db *gorm.DB,
+	userAuthMiddlewares chi.Middlewares,
+	rateLimiter *ratelimit.Limiter,
+	validate func(r *http.Request) (schema.UserAPIKey, error),
 ) func(http.Handler) http.Handler {
 
 	userAuthChain := chi.Chain(userAuthMiddlewares...)
@@ -121,7 +157,7 @@ func eitherUserOrApiKeyAuthMiddleware(
 			apiKey := r.Header.Get("X-API-Key")
 
 			if apiKey != "" {
-				userID, expiry, err := validateApiKey(db, r)
+				key, err := validate(r)
 
 				if err != nil {
 					switch {
@@ -131,18 +167,24 @@ func eitherUserOrApiKeyAuthMiddleware(
 						http.Error(w, err.Error(), http.StatusUnauthorized)
 					case errors.Is(err, ErrExpiredAPIKey), errors.Is(err, ErrAPIKeyModelMismatch):
 						http.Error(w, err.Error(), http.StatusForbidden)
+					case errors.As(err, new(*codedError)):
+						writeError(w, err.Error(), err)
 					default:
 						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					}
 					return
 				}
 
-				if userID == uuid.Nil {
+				if key.CreatedBy == uuid.Nil {
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
 					return
 				}
 
-				user, err := schema.GetUser(userID, db)
+				if rateLimiter != nil && !rateLimiter.AllowApiKey(w, r, key.CreatedBy, key.Id) {
+					return
+				}
+
+				user, err := schema.GetUser(key.CreatedBy, db)
 				if err != nil {
 					http.Error(w, fmt.Sprintf("unable to get user: %v", err), http.StatusInternalServerError)
 					return
@@ -150,7 +192,8 @@ func eitherUserOrApiKeyAuthMiddleware(
 
 				reqCtx := r.Context()
 				reqCtx = context.WithValue(reqCtx, auth.UserRequestContextKey, user)
-				reqCtx = context.WithValue(reqCtx, auth.ContextAPIKeyExpiry, expiry)
+				reqCtx = context.WithValue(reqCtx, auth.ContextAPIKeyExpiry, key.ExpiryTime)
+				reqCtx = context.WithValue(reqCtx, auth.ContextAPIKeyId, key.Id)
 
 				next.ServeHTTP(w, r.WithContext(reqCtx))
 				return
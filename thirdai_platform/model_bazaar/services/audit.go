@@ -0,0 +1,317 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditService lets admins query the audit log, which records each request to
+// an authenticated endpoint, without access to the log files in SHARE_DIR.
+type AuditService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *AuditService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/audit", s.List)
+	r.Get("/audit/export", s.Export)
+
+	return r
+}
+
+type AuditLogEntryInfo struct {
+	Id          uint64            `json:"id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	UserId      uuid.UUID         `json:"user_id"`
+	Username    string            `json:"username"`
+	Action      string            `json:"action"`
+	Method      string            `json:"method"`
+	Url         string            `json:"url"`
+	ModelId     *uuid.UUID        `json:"model_id"`
+	Status      int               `json:"status"`
+	ClientIp    string            `json:"client_ip"`
+	Protocol    string            `json:"protocol"`
+	PathParams  map[string]string `json:"path_params"`
+	QueryParams map[string]string `json:"query_params"`
+}
+
+func newAuditLogEntryInfo(entry schema.AuditLogEntry) AuditLogEntryInfo {
+	info := AuditLogEntryInfo{
+		Id:          entry.Id,
+		Timestamp:   entry.Timestamp.UTC(),
+		UserId:      entry.UserId,
+		Username:    entry.Username,
+		Action:      entry.Action,
+		Method:      entry.Method,
+		Url:         entry.Url,
+		ModelId:     entry.ModelId,
+		Status:      entry.Status,
+		ClientIp:    entry.ClientIp,
+		Protocol:    entry.Protocol,
+		PathParams:  map[string]string{},
+		QueryParams: map[string]string{},
+	}
+	if entry.PathParams != "" {
+		_ = json.Unmarshal([]byte(entry.PathParams), &info.PathParams)
+	}
+	if entry.QueryParams != "" {
+		_ = json.Unmarshal([]byte(entry.QueryParams), &info.QueryParams)
+	}
+	return info
+}
+
+type AuditLogResponse struct {
+	Entries []AuditLogEntryInfo `json:"entries"`
+	// Cursor is the id of the last entry returned, it can be passed as before in
+	// the next request to get older entries. It is 0 if there are no more entries.
+	Cursor uint64 `json:"cursor"`
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	auditExportBatch  = 1000
+)
+
+// auditQuery applies the filters in the query params, the entries are returned
+// most recent first.
+func (s *AuditService) auditQuery(r *http.Request) (*gorm.DB, error) {
+	query := s.db.Model(&schema.AuditLogEntry{}).Order("id DESC")
+
+	params := r.URL.Query()
+	if userId := params.Get("user_id"); userId != "" {
+		id, err := uuid.Parse(userId)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user_id '%v': %w", userId, err)
+		}
+		query = query.Where("user_id = ?", id)
+	}
+	if username := params.Get("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if modelId := params.Get("model_id"); modelId != "" {
+		id, err := uuid.Parse(modelId)
+		if err != nil {
+			return nil, fmt.Errorf("invalid model_id '%v': %w", modelId, err)
+		}
+		query = query.Where("model_id = ?", id)
+	}
+	if method := params.Get("method"); method != "" {
+		query = query.Where("method = ?", strings.ToUpper(method))
+	}
+	if action := params.Get("action"); action != "" {
+		query = query.Where("action LIKE ?", "%"+action+"%")
+	}
+
+	start, err := parseTimeParam(r, "start")
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		query = query.Where("timestamp >= ?", start.UTC())
+	}
+
+	end, err := parseTimeParam(r, "end")
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		query = query.Where("timestamp < ?", end.UTC())
+	}
+
+	return query, nil
+}
+
+// List returns a page of the audit log entries that match the filters, most
+// recent first. Older entries are returned by passing the cursor of the
+// response as before.
+func (s *AuditService) List(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseUintParam(r, "limit", defaultAuditLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 || limit > maxAuditLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+		return
+	}
+
+	before, err := parseUintParam(r, "before", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, err := s.auditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	var entries []schema.AuditLogEntry
+	if err := query.Limit(int(limit)).Find(&entries).Error; err != nil {
+		slog.Error("sql error listing audit log entries", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := AuditLogResponse{Entries: make([]AuditLogEntryInfo, 0, len(entries))}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, newAuditLogEntryInfo(entry))
+	}
+	if len(entries) == int(limit) {
+		res.Cursor = entries[len(entries)-1].Id
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+var auditCsvHeader = []string{
+	"id", "timestamp", "user_id", "username", "action", "method", "url", "model_id", "status", "client_ip", "protocol", "path_params", "query_params",
+}
+
+func auditCsvRow(entry schema.AuditLogEntry) []string {
+	modelId := ""
+	if entry.ModelId != nil {
+		modelId = entry.ModelId.String()
+	}
+	return []string{
+		strconv.FormatUint(entry.Id, 10),
+		entry.Timestamp.UTC().Format(time.RFC3339),
+		entry.UserId.String(),
+		entry.Username,
+		entry.Action,
+		entry.Method,
+		entry.Url,
+		modelId,
+		strconv.Itoa(entry.Status),
+		entry.ClientIp,
+		entry.Protocol,
+		entry.PathParams,
+		entry.QueryParams,
+	}
+}
+
+// Export downloads all audit log entries that match the filters, most recent
+// first, as a csv file or a json array. The entries are read in batches so
+// that large exports are not loaded into memory.
+func (s *AuditService) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, fmt.Sprintf("invalid format '%v', must be 'csv' or 'json'", format), http.StatusBadRequest)
+		return
+	}
+
+	query, err := s.auditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The first batch is read before the response is started, so that database
+	// errors can still be returned as an error status.
+	var batch []schema.AuditLogEntry
+	if err := query.Session(&gorm.Session{}).Limit(auditExportBatch).Find(&batch).Error; err != nil {
+		slog.Error("sql error exporting audit log entries", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%v.%v", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, filename))
+
+	var writeEntry func(schema.AuditLogEntry) error
+	var finish func() error
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		writer := csv.NewWriter(w)
+		if err := writer.Write(auditCsvHeader); err != nil {
+			slog.Error("error writing audit export", "error", err)
+			return
+		}
+		writeEntry = func(entry schema.AuditLogEntry) error {
+			return writer.Write(auditCsvRow(entry))
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte("[")); err != nil {
+			slog.Error("error writing audit export", "error", err)
+			return
+		}
+		first := true
+		encoder := json.NewEncoder(w)
+		writeEntry = func(entry schema.AuditLogEntry) error {
+			if !first {
+				if _, err := w.Write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			first = false
+			return encoder.Encode(newAuditLogEntryInfo(entry))
+		}
+		finish = func() error {
+			_, err := w.Write([]byte("]\n"))
+			return err
+		}
+	}
+
+	count := 0
+	for len(batch) > 0 {
+		for _, entry := range batch {
+			if err := writeEntry(entry); err != nil {
+				slog.Error("error writing audit export", "error", err)
+				return
+			}
+		}
+		count += len(batch)
+
+		if len(batch) < auditExportBatch {
+			break
+		}
+
+		last := batch[len(batch)-1].Id
+		batch = nil
+		if err := query.Session(&gorm.Session{}).Where("id < ?", last).Limit(auditExportBatch).Find(&batch).Error; err != nil {
+			// The response has already started, so the export is truncated.
+			slog.Error("sql error exporting audit log entries", "error", err)
+			return
+		}
+	}
+
+	if err := finish(); err != nil {
+		slog.Error("error writing audit export", "error", err)
+		return
+	}
+
+	user, _ := auth.UserFromContext(r)
+	slog.Info("exported audit log", "format", format, "entries", count, "user_id", user.Id)
+}
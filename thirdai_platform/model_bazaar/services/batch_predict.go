@@ -0,0 +1,549 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const batchPredictOutputFile = "predictions.csv"
+
+// BatchPredictService runs models over files in offline jobs, so that large
+// datasets do not have to be sent through the deployment endpoints. The model
+// does not need to be deployed, the job loads it from storage.
+type BatchPredictService struct {
+	db                 *gorm.DB
+	orchestratorClient orchestrator.Client
+	storage            storage.Storage
+
+	userAuth auth.IdentityProvider
+	jobAuth  *auth.JwtManager
+
+	license   *licensing.LicenseVerifier
+	variables Variables
+}
+
+func (s *BatchPredictService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.userAuth.AuthMiddleware()...)
+
+		r.With(checkSufficientStorage(s.storage)).Post("/", s.Submit)
+		r.Get("/", s.List)
+		r.Get("/{job_id}", s.Info)
+		r.Get("/{job_id}/download", s.Download)
+		r.Post("/{job_id}/stop", s.Stop)
+		r.Delete("/{job_id}", s.Delete)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.jobAuth.Verifier())
+		r.Use(s.jobAuth.Authenticator())
+
+		r.Post("/update-status", s.UpdateStatus)
+	})
+
+	return r
+}
+
+type BatchPredictRequest struct {
+	ModelId    uuid.UUID                  `json:"model_id"`
+	InputFile  config.TrainFile           `json:"input_file"`
+	Options    config.BatchPredictOptions `json:"options"`
+	JobOptions config.JobOptions          `json:"job_options"`
+}
+
+func (r *BatchPredictRequest) validate() error {
+	return validation.Struct(r).Err()
+}
+
+type BatchPredictJobInfo struct {
+	Id            uuid.UUID  `json:"id"`
+	ModelId       uuid.UUID  `json:"model_id"`
+	UserId        uuid.UUID  `json:"user_id"`
+	InputPath     string     `json:"input_path"`
+	InputLocation string     `json:"input_location"`
+	Status        string     `json:"status"`
+	Message       string     `json:"message"`
+	RowsProcessed int        `json:"rows_processed"`
+	TotalRows     int        `json:"total_rows"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at"`
+}
+
+func convertBatchPredictJob(job schema.BatchPredictJob) BatchPredictJobInfo {
+	return BatchPredictJobInfo{
+		Id:            job.Id,
+		ModelId:       job.ModelId,
+		UserId:        job.UserId,
+		InputPath:     job.InputPath,
+		InputLocation: job.InputLocation,
+		Status:        job.Status,
+		Message:       job.Message,
+		RowsProcessed: job.RowsProcessed,
+		TotalRows:     job.TotalRows,
+		CreatedAt:     job.CreatedAt.UTC(),
+		CompletedAt:   utcTime(job.CompletedAt),
+	}
+}
+
+func (s *BatchPredictService) Submit(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params BatchPredictRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid batch prediction request: %v", err), err)
+		return
+	}
+
+	job, err := s.startJob(params, user)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error starting batch prediction: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, convertBatchPredictJob(job))
+}
+
+func (s *BatchPredictService) startJob(params BatchPredictRequest, user schema.User) (schema.BatchPredictJob, error) {
+	perm, err := auth.GetModelPermissions(params.ModelId, user, s.db)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return schema.BatchPredictJob{}, CodedError(err, http.StatusNotFound)
+		}
+		return schema.BatchPredictJob{}, CodedError(err, http.StatusInternalServerError)
+	}
+	if perm < auth.ReadPermission {
+		return schema.BatchPredictJob{}, CodedError(fmt.Errorf("user %v does not have permission to access model %v", user.Id, params.ModelId), http.StatusForbidden)
+	}
+
+	model, err := schema.GetModel(params.ModelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return schema.BatchPredictJob{}, CodedError(err, http.StatusNotFound)
+		}
+		return schema.BatchPredictJob{}, CodedError(err, http.StatusInternalServerError)
+	}
+
+	if model.Type != schema.NlpTextModel && model.Type != schema.NlpTokenModel {
+		return schema.BatchPredictJob{}, CodedError(fmt.Errorf("batch prediction is only supported for %v and %v models", schema.NlpTextModel, schema.NlpTokenModel), http.StatusUnprocessableEntity)
+	}
+	if model.TrainStatus != schema.Complete {
+		return schema.BatchPredictJob{}, CodedError(fmt.Errorf("cannot run batch prediction with model %v since it has train status %v", model.Id, model.TrainStatus), http.StatusUnprocessableEntity)
+	}
+
+	// The model is marked as used before its tier is checked, so that it is not
+	// archived while the job is running.
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := touchModelStorageTier(txn, model.Id); err != nil {
+			return err
+		}
+		return checkModelHot(txn, model.Id, "used for batch prediction")
+	})
+	if err != nil {
+		return schema.BatchPredictJob{}, err
+	}
+
+	// The input file as given in the request is recorded, rather than the local
+	// path that uploads are resolved to.
+	inputPath, inputLocation := params.InputFile.Path, params.InputFile.Location
+	files := []config.TrainFile{params.InputFile}
+	if _, err := resolveUploads(s.db, s.storage, user.Id, files); err != nil {
+		return schema.BatchPredictJob{}, err
+	}
+
+	resources := orchestrator.Resources{
+		AllocationCores:     params.JobOptions.AllocationCores,
+		AllocationMhz:       params.JobOptions.CpuUsageMhz(),
+		AllocationMemory:    params.JobOptions.AllocationMemory,
+		AllocationMemoryMax: 2 * params.JobOptions.AllocationMemory,
+	}
+
+	license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, resources.AllocationMhz)
+	if err != nil {
+		return schema.BatchPredictJob{}, err
+	}
+
+	job := schema.BatchPredictJob{
+		Id:            uuid.New(),
+		ModelId:       model.Id,
+		UserId:        user.Id,
+		InputPath:     inputPath,
+		InputLocation: inputLocation,
+		Status:        schema.Starting,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	token, err := s.jobAuth.CreateBatchPredictJwt(job.Id, 7*24*time.Hour)
+	if err != nil {
+		return schema.BatchPredictJob{}, CodedError(errors.New("error setting up batch prediction job"), http.StatusInternalServerError)
+	}
+
+	jobDir := storage.BatchPredictPath(job.Id)
+	jobConfig := config.BatchPredictConfig{
+		JobId:               job.Id,
+		ModelId:             model.Id,
+		ModelType:           model.Type,
+		UserId:              user.Id,
+		ModelBazaarDir:      s.storage.Location(),
+		ModelBazaarEndpoint: s.variables.ModelBazaarEndpoint,
+		JobAuthToken:        token,
+		LicenseKey:          license,
+		InputFile:           files[0],
+		OutputPath:          filepath.Join(s.storage.Location(), jobDir, batchPredictOutputFile),
+		Options:             params.Options,
+	}
+
+	configData, err := json.MarshalIndent(jobConfig, "", "    ")
+	if err != nil {
+		slog.Error("error encoding batch prediction config", "error", err)
+		return schema.BatchPredictJob{}, CodedError(errors.New("error encoding job config"), http.StatusInternalServerError)
+	}
+	configPath := filepath.Join(jobDir, "config.json")
+	if err := s.storage.Write(configPath, bytes.NewReader(configData)); err != nil {
+		slog.Error("error saving batch prediction config", "error", err)
+		return schema.BatchPredictJob{}, CodedError(errors.New("error saving job config"), http.StatusInternalServerError)
+	}
+
+	extraEnv, err := s.variables.jobEnv(s.db, s.storage, trainJobEnv)
+	if err != nil {
+		return schema.BatchPredictJob{}, err
+	}
+
+	if err := s.db.Create(&job).Error; err != nil {
+		slog.Error("sql error creating batch prediction job", "error", err)
+		return schema.BatchPredictJob{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	err = s.orchestratorClient.StartJob(orchestrator.BatchPredictJob{
+		JobName:          job.JobName(),
+		ConfigPath:       filepath.Join(s.storage.Location(), configPath),
+		Driver:           s.variables.BackendDriver,
+		Resources:        resources,
+		CloudCredentials: s.variables.CloudCredentials,
+		Namespace:        s.variables.jobNamespace(model),
+		ExtraEnv:         extraEnv,
+	})
+	if err != nil {
+		slog.Error("error starting batch prediction job", "job_id", job.Id, "error", err)
+		if err := s.finishJob(&job, schema.Failed, startErrorMessage(err)); err != nil {
+			slog.Error("error recording failed batch prediction job", "job_id", job.Id, "error", err)
+		}
+		return schema.BatchPredictJob{}, jobStartError(err, "error starting batch prediction job")
+	}
+
+	slog.Info("started batch prediction job", "job_id", job.Id, "model_id", model.Id)
+
+	return job, nil
+}
+
+func (s *BatchPredictService) finishJob(job *schema.BatchPredictJob, status, message string) error {
+	now := time.Now().UTC()
+	job.Status, job.Message, job.CompletedAt = status, message, &now
+	return s.db.Model(job).Updates(map[string]interface{}{"status": status, "message": message, "completed_at": now}).Error
+}
+
+// getJob returns the batch prediction job if it exists and the user has access
+// to it, which is either the user that submitted it or an admin.
+func (s *BatchPredictService) getJob(r *http.Request) (schema.BatchPredictJob, error) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		return schema.BatchPredictJob{}, CodedError(err, http.StatusInternalServerError)
+	}
+
+	jobId, err := utils.URLParamUUID(r, "job_id")
+	if err != nil {
+		return schema.BatchPredictJob{}, CodedError(err, http.StatusBadRequest)
+	}
+
+	var job schema.BatchPredictJob
+	if err := s.db.First(&job, "id = ?", jobId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return job, CodedError(fmt.Errorf("batch prediction job %v does not exist", jobId), http.StatusNotFound)
+		}
+		slog.Error("sql error retrieving batch prediction job", "job_id", jobId, "error", err)
+		return job, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if job.UserId != user.Id && !user.IsAdmin {
+		return job, CodedError(fmt.Errorf("user %v does not have permission to access batch prediction job %v", user.Id, jobId), http.StatusForbidden)
+	}
+
+	return job, nil
+}
+
+func (s *BatchPredictService) Info(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJob(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, convertBatchPredictJob(job))
+}
+
+// List returns the batch prediction jobs submitted by the user, or all jobs for
+// admins, optionally filtered to a single model.
+func (s *BatchPredictService) List(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := s.db.Order("created_at DESC")
+	if !user.IsAdmin {
+		query = query.Where("user_id = ?", user.Id)
+	}
+	if modelId := r.URL.Query().Get("model_id"); modelId != "" {
+		id, err := uuid.Parse(modelId)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid model_id '%v'", modelId), http.StatusBadRequest)
+			return
+		}
+		query = query.Where("model_id = ?", id)
+	}
+
+	var jobs []schema.BatchPredictJob
+	if err := query.Find(&jobs).Error; err != nil {
+		slog.Error("sql error listing batch prediction jobs", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]BatchPredictJobInfo, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, convertBatchPredictJob(job))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+func (s *BatchPredictService) Download(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJob(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if job.Status != schema.Complete {
+		http.Error(w, fmt.Sprintf("predictions are not available since batch prediction job %v has status %v", job.Id, job.Status), http.StatusUnprocessableEntity)
+		return
+	}
+
+	file, err := s.storage.Read(filepath.Join(storage.BatchPredictPath(job.Id), batchPredictOutputFile))
+	if err != nil {
+		slog.Error("error reading batch predictions", "job_id", job.Id, "error", err)
+		http.Error(w, "error reading predictions", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="predictions-%v.csv"`, job.Id))
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Error("error sending batch predictions", "job_id", job.Id, "error", err)
+	}
+}
+
+func (s *BatchPredictService) stopJob(job *schema.BatchPredictJob) error {
+	if !job.Active() {
+		return nil
+	}
+
+	if err := s.orchestratorClient.StopJob(job.JobName()); err != nil {
+		slog.Error("error stopping batch prediction job", "job_id", job.Id, "error", err)
+		return CodedError(errors.New("error stopping batch prediction job"), http.StatusInternalServerError)
+	}
+
+	if err := s.finishJob(job, schema.Stopped, ""); err != nil {
+		slog.Error("sql error updating stopped batch prediction job", "job_id", job.Id, "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *BatchPredictService) Stop(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJob(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if !job.Active() {
+		http.Error(w, fmt.Sprintf("batch prediction job %v cannot be stopped since it has status %v", job.Id, job.Status), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.stopJob(&job); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+// Delete stops the job if it is running and removes it along with its
+// predictions.
+func (s *BatchPredictService) Delete(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJob(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if err := s.stopJob(&job); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if err := deleteBatchPredictJobs(s.db, s.storage, []schema.BatchPredictJob{job}); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+func deleteBatchPredictJobs(txn *gorm.DB, store storage.Storage, jobs []schema.BatchPredictJob) error {
+	for _, job := range jobs {
+		if err := store.Delete(storage.BatchPredictPath(job.Id)); err != nil {
+			slog.Error("error deleting batch predictions", "job_id", job.Id, "error", err)
+			return CodedError(errors.New("error deleting batch predictions"), http.StatusInternalServerError)
+		}
+
+		if err := txn.Delete(&job).Error; err != nil {
+			slog.Error("sql error deleting batch prediction job", "job_id", job.Id, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+type batchPredictStatusRequest struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	RowsProcessed *int   `json:"rows_processed"`
+	TotalRows     *int   `json:"total_rows"`
+}
+
+// UpdateStatus is called by batch prediction jobs to report their status and
+// the number of rows they have processed.
+func (s *BatchPredictService) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	jobId, err := auth.BatchPredictJobIdFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var params batchPredictStatusRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	switch params.Status {
+	case schema.InProgress, schema.Complete, schema.Failed:
+	default:
+		http.Error(w, fmt.Sprintf("invalid status '%v' for batch prediction job", params.Status), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		var job schema.BatchPredictJob
+		if err := txn.First(&job, "id = ?", jobId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return CodedError(fmt.Errorf("batch prediction job %v does not exist", jobId), http.StatusNotFound)
+			}
+			slog.Error("sql error retrieving batch prediction job", "job_id", jobId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		// Jobs that failed can still complete since the status sync marks jobs as
+		// failed if the orchestrator cannot find them.
+		if job.Status == schema.Complete || job.Status == schema.Stopped || (job.Status == schema.Failed && params.Status != schema.Complete) {
+			return CodedError(fmt.Errorf("cannot update batch prediction job %v with status %v", jobId, job.Status), http.StatusUnprocessableEntity)
+		}
+
+		updates := map[string]interface{}{"status": params.Status, "message": params.Message}
+		if params.RowsProcessed != nil {
+			updates["rows_processed"] = *params.RowsProcessed
+		}
+		if params.TotalRows != nil {
+			updates["total_rows"] = *params.TotalRows
+		}
+		if params.Status != schema.InProgress {
+			updates["completed_at"] = time.Now().UTC()
+		}
+
+		if err := txn.Model(&job).Updates(updates).Error; err != nil {
+			slog.Error("sql error updating batch prediction job", "job_id", jobId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating batch prediction status: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+// syncJobs marks running batch prediction jobs as failed if their job has died
+// or is missing in the orchestrator.
+func (s *BatchPredictService) syncJobs() {
+	var jobs []schema.BatchPredictJob
+	if err := s.db.Where("status IN ?", []string{schema.Starting, schema.InProgress}).Find(&jobs).Error; err != nil {
+		slog.Error("status sync: sql error querying active batch prediction jobs", "error", err)
+		return
+	}
+
+	for _, job := range jobs {
+		jobInfo, err := s.orchestratorClient.JobInfo(job.JobName())
+		jobNotFound := errors.Is(err, orchestrator.ErrJobNotFound)
+		if err != nil && !jobNotFound {
+			slog.Error("status sync: batch prediction job info", "job_id", job.Id, "error", err)
+			continue
+		}
+
+		if jobInfo.Status == "dead" || jobNotFound {
+			// The status is only updated if the job has not reported a new status
+			// since it was queried.
+			now := time.Now().UTC()
+			result := s.db.Model(&job).Where("status = ?", job.Status).
+				Updates(map[string]interface{}{"status": schema.Failed, "message": syncFailureMessage(jobNotFound), "completed_at": now})
+			if result.Error != nil {
+				slog.Error("status sync: sql error updating batch prediction status", "job_id", job.Id, "error", result.Error)
+				continue
+			}
+			slog.Info("status sync: updated batch prediction status to failed", "job_id", job.Id)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"thirdai_platform/model_bazaar/config"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const csvSampleSize = 64 * 1024
+
+var csvDelimiterCandidates = []rune{',', ';', '\t', '|'}
+
+type CSVDialect struct {
+	Delimiter string `json:"delimiter"`
+	Quote     string `json:"quote"`
+	Encoding  string `json:"encoding"`
+}
+
+// csvReader reads csv files with an arbitrary delimiter, quote character, and
+// encoding. Any options that are not specified are detected from the start of
+// the file.
+type csvReader struct {
+	reader  *csv.Reader
+	quote   byte
+	dialect CSVDialect
+}
+
+func peekSample(r *bufio.Reader) []byte {
+	sample, err := r.Peek(csvSampleSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil
+	}
+	return sample
+}
+
+func detectEncoding(sample []byte) string {
+	if bytes.HasPrefix(sample, []byte{0xFF, 0xFE}) || bytes.HasPrefix(sample, []byte{0xFE, 0xFF}) {
+		return config.EncodingUtf16
+	}
+
+	// The sample may end in the middle of a multi-byte character.
+	for i := 0; i < utf8.UTFMax && len(sample) > 0 && !utf8.Valid(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	if utf8.Valid(sample) {
+		return config.EncodingUtf8
+	}
+
+	// Windows-1252 is a superset of the printable characters in Latin-1, and is
+	// what most spreadsheet software means by Latin-1 exports.
+	return config.EncodingWindows1252
+}
+
+func decodeCSV(r io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case config.EncodingUtf8:
+		// The UTF-8 BOM decoder strips the BOM if present.
+		return transform.NewReader(r, unicode.UTF8BOM.NewDecoder()), nil
+	case config.EncodingUtf16:
+		return transform.NewReader(r, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()), nil
+	case config.EncodingLatin1:
+		return transform.NewReader(r, charmap.ISO8859_1.NewDecoder()), nil
+	case config.EncodingWindows1252:
+		return transform.NewReader(r, charmap.Windows1252.NewDecoder()), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding '%v'", encoding)
+	}
+}
+
+// detectDelimiter picks the candidate delimiter which splits the sample lines
+// into the same number of fields, preferring delimiters that produce more fields.
+func detectDelimiter(sample []byte, quote byte) rune {
+	lines := strings.Split(string(sample), "\n")
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1] // The last line may be incomplete.
+	}
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+	text := swapQuote(strings.Join(lines, "\n"), quote)
+
+	best, bestFields := ',', 1
+	for _, candidate := range csvDelimiterCandidates {
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.Comma = candidate
+		reader.FieldsPerRecord = 0 // Require all records to have the same number of fields.
+
+		records, err := reader.ReadAll()
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		if fields := len(records[0]); fields > bestFields {
+			best, bestFields = candidate, fields
+		}
+	}
+
+	return best
+}
+
+// The csv package only supports '"' as a quote character, so other quote
+// characters are handled by swapping them with '"' before parsing and swapping
+// them back in the parsed fields.
+func swapQuote(s string, quote byte) string {
+	if quote == '"' {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case rune(quote):
+			return '"'
+		case '"':
+			return rune(quote)
+		}
+		return r
+	}, s)
+}
+
+type quoteSwapReader struct {
+	r     io.Reader
+	quote byte
+}
+
+func (q *quoteSwapReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := 0; i < n; i++ {
+		switch p[i] {
+		case q.quote:
+			p[i] = '"'
+		case '"':
+			p[i] = q.quote
+		}
+	}
+	return n, err
+}
+
+func newCSVReader(r io.Reader, opts config.CSVOptions) (*csvReader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	raw := bufio.NewReaderSize(r, csvSampleSize)
+
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = detectEncoding(peekSample(raw))
+	}
+
+	decoded, err := decodeCSV(raw, encoding)
+	if err != nil {
+		return nil, err
+	}
+	decodedBuf := bufio.NewReaderSize(decoded, csvSampleSize)
+
+	quote := byte('"')
+	if opts.Quote != "" {
+		quote = opts.Quote[0]
+	}
+
+	var delimiter rune
+	if opts.Delimiter != "" {
+		delimiter, _ = utf8.DecodeRuneInString(opts.Delimiter)
+	} else {
+		delimiter = detectDelimiter(peekSample(decodedBuf), quote)
+	}
+
+	var input io.Reader = decodedBuf
+	if quote != '"' {
+		input = &quoteSwapReader{r: decodedBuf, quote: quote}
+	}
+
+	reader := csv.NewReader(input)
+	reader.Comma = delimiter
+
+	return &csvReader{
+		reader:  reader,
+		quote:   quote,
+		dialect: CSVDialect{Delimiter: string(delimiter), Quote: string(quote), Encoding: encoding},
+	}, nil
+}
+
+func (r *csvReader) Read() ([]string, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.quote != '"' {
+		for i := range record {
+			record[i] = swapQuote(record[i], r.quote)
+		}
+	}
+	return record, nil
+}
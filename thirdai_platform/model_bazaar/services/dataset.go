@@ -0,0 +1,566 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var datasetNameRe = regexp.MustCompile(`^[\w.\-]+$`)
+
+type DatasetService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *DatasetService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+
+	r.Post("/", s.Create)
+	r.Get("/list", s.List)
+
+	r.Route("/{dataset_id}", func(r chi.Router) {
+		r.With(auth.DatasetPermissionOnly(s.db, auth.ReadPermission)).Get("/", s.Info)
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.DatasetPermissionOnly(s.db, auth.OwnerPermission))
+
+			r.Patch("/", s.Update)
+			r.Delete("/", s.Delete)
+			r.Post("/versions", s.AddVersion)
+		})
+	})
+
+	return r
+}
+
+type DatasetVersionInfo struct {
+	Version     int             `json:"version"`
+	UploadId    uuid.UUID       `json:"upload_id"`
+	Files       []string        `json:"files"`
+	Schema      json.RawMessage `json:"schema"`
+	Description string          `json:"description"`
+	CreatedBy   uuid.UUID       `json:"created_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type DatasetInfo struct {
+	Id            uuid.UUID            `json:"id"`
+	Name          string               `json:"name"`
+	Description   string               `json:"description"`
+	Access        string               `json:"access"`
+	TeamId        *uuid.UUID           `json:"team_id"`
+	UserId        uuid.UUID            `json:"user_id"`
+	Username      string               `json:"username"`
+	LatestVersion int                  `json:"latest_version"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Versions      []DatasetVersionInfo `json:"versions,omitempty"`
+}
+
+func datasetInfo(dataset schema.Dataset) DatasetInfo {
+	info := DatasetInfo{
+		Id:          dataset.Id,
+		Name:        dataset.Name,
+		Description: dataset.Description,
+		Access:      dataset.Access,
+		TeamId:      dataset.TeamId,
+		UserId:      dataset.UserId,
+		CreatedAt:   dataset.CreatedAt.UTC(),
+	}
+	if dataset.User != nil {
+		info.Username = dataset.User.Username
+	}
+	for _, version := range dataset.Versions {
+		info.LatestVersion = max(info.LatestVersion, version.Version)
+	}
+	return info
+}
+
+func datasetVersionInfo(version schema.DatasetVersion) DatasetVersionInfo {
+	files := make([]string, 0)
+	if version.Files != "" {
+		files = strings.Split(version.Files, ";")
+	}
+	var datasetSchema json.RawMessage
+	if version.Schema != "" {
+		datasetSchema = json.RawMessage(version.Schema)
+	}
+	return DatasetVersionInfo{
+		Version:     version.Version,
+		UploadId:    version.UploadId,
+		Files:       files,
+		Schema:      datasetSchema,
+		Description: version.Description,
+		CreatedBy:   version.CreatedBy,
+		CreatedAt:   version.CreatedAt.UTC(),
+	}
+}
+
+type createDatasetRequest struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Access      string     `json:"access"`
+	TeamId      *uuid.UUID `json:"team_id"`
+}
+
+// checkDatasetAccess checks that the access of a dataset is valid, and that the
+// user can share the dataset with the team if it is protected.
+func checkDatasetAccess(txn *gorm.DB, user schema.User, access string, teamId *uuid.UUID) error {
+	if err := schema.CheckValidAccess(access); err != nil {
+		return CodedError(err, http.StatusUnprocessableEntity)
+	}
+	if access != schema.Protected {
+		return nil
+	}
+	if teamId == nil {
+		return CodedError(errors.New("must specify team id if the dataset access is protected"), http.StatusUnprocessableEntity)
+	}
+	if err := checkTeamExists(txn, *teamId); err != nil {
+		return err
+	}
+	if !user.IsAdmin {
+		if err := checkTeamMember(txn, user.Id, *teamId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create registers a dataset without any versions, versions are added from
+// uploads with the versions endpoint.
+func (s *DatasetService) Create(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params createDatasetRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if len(params.Name) > 100 || !datasetNameRe.MatchString(params.Name) {
+		http.Error(w, "dataset name must be between 1 and 100 characters, and only contain alphanumeric, '_', '-', or '.' characters", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if params.Access == "" {
+		params.Access = schema.Private
+	}
+
+	dataset := schema.Dataset{
+		Id:          uuid.New(),
+		Name:        params.Name,
+		Description: params.Description,
+		Access:      params.Access,
+		UserId:      user.Id,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if params.Access == schema.Protected {
+		dataset.TeamId = params.TeamId
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkDatasetAccess(txn, user, params.Access, params.TeamId); err != nil {
+			return err
+		}
+
+		var existing schema.Dataset
+		result := txn.Limit(1).Find(&existing, "name = ?", params.Name)
+		if result.Error != nil {
+			slog.Error("sql error checking for duplicate dataset name", "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected != 0 {
+			return CodedErrorWithErrorCode(fmt.Errorf("dataset with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+		}
+
+		if err := txn.Create(&dataset).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return CodedErrorWithErrorCode(fmt.Errorf("dataset with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+			}
+			slog.Error("sql error creating dataset", "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error creating dataset: %v", err), err)
+		return
+	}
+
+	slog.Info("created dataset", "dataset_id", dataset.Id, "name", dataset.Name, "user_id", user.Id)
+
+	dataset.User = &user
+	utils.WriteJsonResponse(w, datasetInfo(dataset))
+}
+
+// accessibleDatasets restricts the query to the datasets the user can read, it
+// matches the permissions of GetDatasetPermissions.
+func accessibleDatasets(query *gorm.DB, db *gorm.DB, user schema.User) (*gorm.DB, error) {
+	if user.IsAdmin {
+		return query, nil
+	}
+	userTeams, err := schema.GetUserTeamIds(user.Id, db)
+	if err != nil {
+		return nil, CodedError(err, http.StatusInternalServerError)
+	}
+
+	orgUsers := schema.OrganizationUserIds(db, user.OrganizationId)
+
+	access := db.Where("datasets.user_id = ?", user.Id).
+		Or("datasets.access = ? AND datasets.user_id IN (?)", schema.Public, orgUsers).
+		Or("datasets.access = ? AND datasets.team_id IN ? AND datasets.user_id IN (?)", schema.Protected, userTeams, orgUsers)
+	if user.IsOrgAdmin && user.OrganizationId != nil {
+		access = access.Or("datasets.user_id IN (?)", orgUsers)
+	}
+
+	return query.Where(access), nil
+}
+
+func (s *DatasetService) List(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query, err := accessibleDatasets(s.db.Model(&schema.Dataset{}), s.db, user)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error listing datasets: %v", err), err)
+		return
+	}
+
+	var datasets []schema.Dataset
+	if err := query.Preload("User").Preload("Versions").Order("datasets.name").Find(&datasets).Error; err != nil {
+		slog.Error("sql error listing datasets", "error", err)
+		http.Error(w, fmt.Sprintf("error listing datasets: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]DatasetInfo, 0, len(datasets))
+	for _, dataset := range datasets {
+		infos = append(infos, datasetInfo(dataset))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+// Info returns the dataset with all of its versions.
+func (s *DatasetService) Info(w http.ResponseWriter, r *http.Request) {
+	datasetId, err := utils.URLParamUUID(r, "dataset_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var dataset schema.Dataset
+	result := s.db.Preload("User").Preload("Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("version")
+	}).First(&dataset, "id = ?", datasetId)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			http.Error(w, schema.ErrDatasetNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		slog.Error("sql error retrieving dataset", "dataset_id", datasetId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error retrieving dataset: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	info := datasetInfo(dataset)
+	info.Versions = make([]DatasetVersionInfo, 0, len(dataset.Versions))
+	for _, version := range dataset.Versions {
+		info.Versions = append(info.Versions, datasetVersionInfo(version))
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
+
+type updateDatasetRequest struct {
+	Description *string    `json:"description"`
+	Access      *string    `json:"access"`
+	TeamId      *uuid.UUID `json:"team_id"`
+}
+
+// Update changes the description or the access of a dataset, the fields that
+// are not specified are unchanged.
+func (s *DatasetService) Update(w http.ResponseWriter, r *http.Request) {
+	datasetId, err := utils.URLParamUUID(r, "dataset_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params updateDatasetRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		dataset, err := schema.GetDataset(datasetId, txn)
+		if err != nil {
+			if errors.Is(err, schema.ErrDatasetNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if params.Description != nil {
+			dataset.Description = *params.Description
+		}
+
+		if params.Access != nil {
+			if err := checkDatasetAccess(txn, user, *params.Access, params.TeamId); err != nil {
+				return err
+			}
+			dataset.Access = *params.Access
+			if dataset.Access == schema.Protected {
+				dataset.TeamId = params.TeamId
+			} else {
+				dataset.TeamId = nil
+			}
+		}
+
+		if err := txn.Save(&dataset).Error; err != nil {
+			slog.Error("sql error updating dataset", "dataset_id", datasetId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating dataset: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+// Delete removes the dataset and its versions. The uploads of the versions are
+// not deleted, and models trained on the dataset keep the name and version of
+// the dataset they were trained on.
+func (s *DatasetService) Delete(w http.ResponseWriter, r *http.Request) {
+	datasetId, err := utils.URLParamUUID(r, "dataset_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		versionIds := txn.Model(&schema.DatasetVersion{}).Select("id").Where("dataset_id = ?", datasetId)
+		result := txn.Model(&schema.ModelDataset{}).Where("dataset_version_id IN (?)", versionIds).Update("dataset_version_id", nil)
+		if result.Error != nil {
+			slog.Error("sql error clearing model dataset versions", "dataset_id", datasetId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		if err := txn.Delete(&schema.DatasetVersion{}, "dataset_id = ?", datasetId).Error; err != nil {
+			slog.Error("sql error deleting dataset versions", "dataset_id", datasetId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.Dataset{}, "id = ?", datasetId)
+		if result.Error != nil {
+			slog.Error("sql error deleting dataset", "dataset_id", datasetId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected == 0 {
+			return CodedError(schema.ErrDatasetNotFound, http.StatusNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error deleting dataset: %v", err), err)
+		return
+	}
+
+	slog.Info("deleted dataset", "dataset_id", datasetId)
+
+	utils.WriteSuccess(w)
+}
+
+type addDatasetVersionRequest struct {
+	UploadId    uuid.UUID       `json:"upload_id"`
+	Schema      json.RawMessage `json:"schema"`
+	Description string          `json:"description"`
+}
+
+type addDatasetVersionResponse struct {
+	Version int `json:"version"`
+}
+
+// AddVersion registers an upload of the user as the next version of the
+// dataset. Files can no longer be added to the upload once it is registered.
+func (s *DatasetService) AddVersion(w http.ResponseWriter, r *http.Request) {
+	datasetId, err := utils.URLParamUUID(r, "dataset_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params addDatasetVersionRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	datasetSchema := ""
+	if len(params.Schema) > 0 && string(params.Schema) != "null" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(params.Schema, &fields); err != nil {
+			http.Error(w, "dataset schema must be a json object", http.StatusUnprocessableEntity)
+			return
+		}
+		datasetSchema = string(params.Schema)
+	}
+
+	var upload schema.Upload
+	result := s.db.Limit(1).Find(&upload, "id = ?", params.UploadId)
+	if result.Error != nil {
+		slog.Error("sql error retrieving upload info", "upload_id", params.UploadId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("unable to retrieve upload: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("upload %v does not exist", params.UploadId), http.StatusNotFound)
+		return
+	}
+	if upload.UserId != user.Id {
+		http.Error(w, fmt.Sprintf("user %v does not have permission to access upload %v", user.Id, params.UploadId), http.StatusForbidden)
+		return
+	}
+	if upload.Files == "" {
+		http.Error(w, fmt.Sprintf("upload %v does not contain any files", params.UploadId), http.StatusUnprocessableEntity)
+		return
+	}
+
+	version := schema.DatasetVersion{
+		Id:          uuid.New(),
+		DatasetId:   datasetId,
+		UploadId:    upload.Id,
+		Files:       upload.Files,
+		Schema:      datasetSchema,
+		Description: params.Description,
+		CreatedBy:   user.Id,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		var latest int
+		if err := txn.Model(&schema.DatasetVersion{}).Where("dataset_id = ?", datasetId).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			slog.Error("sql error retrieving latest dataset version", "dataset_id", datasetId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		version.Version = latest + 1
+
+		if err := txn.Create(&version).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return CodedError(errors.New("another version of the dataset was added at the same time, retry the request"), http.StatusConflict)
+			}
+			slog.Error("sql error creating dataset version", "dataset_id", datasetId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error adding dataset version: %v", err), err)
+		return
+	}
+
+	slog.Info("added dataset version", "dataset_id", datasetId, "version", version.Version, "upload_id", upload.Id)
+
+	utils.WriteJsonResponse(w, addDatasetVersionResponse{Version: version.Version})
+}
+
+// isDatasetUpload returns true if the upload is registered as a dataset version.
+func isDatasetUpload(db *gorm.DB, uploadId uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.Model(&schema.DatasetVersion{}).Where("upload_id = ?", uploadId).Count(&count).Error; err != nil {
+		slog.Error("sql error checking for dataset versions of upload", "upload_id", uploadId, "error", err)
+		return false, schema.ErrDbAccessFailed
+	}
+	return count > 0, nil
+}
+
+// resolveDatasetFile returns the dataset version referred to by the path of a
+// dataset train file, which is either "name:version", or the name of the
+// dataset for its latest version.
+func resolveDatasetFile(db *gorm.DB, user schema.User, path string) (schema.Dataset, schema.DatasetVersion, error) {
+	name, versionStr, hasVersion := strings.Cut(path, ":")
+
+	versionNum := 0
+	if hasVersion {
+		v, err := strconv.Atoi(versionStr)
+		if err != nil || v < 1 {
+			return schema.Dataset{}, schema.DatasetVersion{}, CodedError(fmt.Errorf("invalid dataset version '%v', must be a positive integer", versionStr), http.StatusUnprocessableEntity)
+		}
+		versionNum = v
+	}
+
+	var dataset schema.Dataset
+	result := db.Limit(1).Find(&dataset, "name = ?", name)
+	if result.Error != nil {
+		slog.Error("sql error retrieving dataset", "name", name, "error", result.Error)
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected == 0 {
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(fmt.Errorf("dataset %v does not exist", name), http.StatusNotFound)
+	}
+
+	perm, err := auth.GetDatasetPermissions(dataset, user, db)
+	if err != nil {
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(err, http.StatusInternalServerError)
+	}
+	if perm < auth.ReadPermission {
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(fmt.Errorf("user %v does not have permission to access dataset %v", user.Id, name), http.StatusForbidden)
+	}
+
+	query := db.Where("dataset_id = ?", dataset.Id)
+	if hasVersion {
+		query = query.Where("version = ?", versionNum)
+	} else {
+		query = query.Order("version DESC")
+	}
+
+	var version schema.DatasetVersion
+	result = query.Limit(1).Find(&version)
+	if result.Error != nil {
+		slog.Error("sql error retrieving dataset version", "dataset_id", dataset.Id, "error", result.Error)
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected == 0 {
+		if hasVersion {
+			return schema.Dataset{}, schema.DatasetVersion{}, CodedError(fmt.Errorf("version %d of dataset %v does not exist", versionNum, name), http.StatusNotFound)
+		}
+		return schema.Dataset{}, schema.DatasetVersion{}, CodedError(fmt.Errorf("dataset %v does not have any versions", name), http.StatusUnprocessableEntity)
+	}
+
+	return dataset, version, nil
+}
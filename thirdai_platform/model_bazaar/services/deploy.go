@@ -7,15 +7,18 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/model_bazaar/jobs"
 	"thirdai_platform/model_bazaar/licensing"
 	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/ratelimit"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -28,25 +31,85 @@ type DeployService struct {
 	orchestratorClient orchestrator.Client
 	storage            storage.Storage
 
-	userAuth auth.IdentityProvider
-	jobAuth  *auth.JwtManager
+	userAuth    auth.IdentityProvider
+	jobAuth     *auth.JwtManager
+	rateLimiter *ratelimit.Limiter
 
 	license   *licensing.LicenseVerifier
 	variables Variables
+
+	webhooks *WebhookDispatcher
+
+	// Used to derive the keys that enterprise search workflows use to encrypt
+	// the original text of redacted entities.
+	redactionSecret []byte
+
+	// The last time deployments were checked for inactivity, shared by copies
+	// of the service.
+	inactivityCheckedAt *time.Time
 }
 
 func (s *DeployService) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	eitherOrMiddleware := eitherUserOrApiKeyAuthMiddleware(s.db, s.userAuth.AuthMiddleware())
+	eitherOrMiddleware := eitherUserOrApiKeyAuthMiddleware(s.db, s.userAuth.AuthMiddleware(), s.rateLimiter)
+
+	r.Route("/presets", func(r chi.Router) {
+		r.Use(s.userAuth.AuthMiddleware()...)
+
+		r.Get("/", s.ListPresets)
+		r.Get("/{name}", s.GetPreset)
+		r.With(auth.AdminOnly(s.db)).Post("/", s.SavePreset)
+		r.With(auth.AdminOnly(s.db)).Delete("/{name}", s.DeletePreset)
+	})
+
+	r.Route("/inactive", func(r chi.Router) {
+		r.Use(s.userAuth.AuthMiddleware()...)
+		r.Use(auth.AdminOnly(s.db))
+
+		r.Get("/", s.ListInactive)
+	})
+
+	r.Route("/experiments", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(s.userAuth.AuthMiddleware()...)
+
+			r.Get("/", s.ListExperiments)
+			r.Post("/", s.CreateExperiment)
+			r.Get("/{experiment_id}", s.GetExperiment)
+			r.Patch("/{experiment_id}", s.UpdateExperiment)
+			r.Delete("/{experiment_id}", s.DeleteExperiment)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(apiKeyOrUserAuthMiddleware(s.db, s.userAuth.AuthMiddleware(), s.rateLimiter, s.validateExperimentApiKey))
+
+			r.Get("/{experiment_id}/endpoint/*", s.ExperimentProxy)
+			r.Post("/{experiment_id}/endpoint/*", s.ExperimentProxy)
+		})
+	})
+
 	r.Route("/{model_id}", func(r chi.Router) {
 		r.Use(eitherOrMiddleware)
+		r.Use(resolveModelAlias(s.db))
 
 		r.Group(func(r chi.Router) {
 			r.Use(auth.ModelPermissionOnly(s.db, auth.OwnerPermission))
 
 			r.With(checkSufficientStorage(s.storage)).Post("/", s.Start)
 			r.Delete("/", s.Stop)
+			r.Post("/rollback", s.Rollback)
+			r.Patch("/autoscaling", s.UpdateAutoscaling)
+			r.Post("/slo", s.SetSlo)
+			r.Delete("/slo", s.DeleteSlo)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.ModelPermissionOnly(s.db, auth.WritePermission))
+
+			r.Post("/dictionary/entries", s.AddEntityDictionaryEntries)
+			r.Delete("/dictionary/entries/{entry_id}", s.DeleteEntityDictionaryEntry)
+			r.Post("/dictionary/rollback", s.RollbackEntityDictionary)
 		})
 
 		r.Group(func(r chi.Router) {
@@ -54,6 +117,11 @@ func (s *DeployService) Routes() chi.Router {
 
 			r.Get("/status", s.GetStatus)
 			r.Get("/logs", s.Logs)
+			r.With(logStreamTimeouts).Get("/logs/stream", s.StreamLogs)
+			r.Get("/versions", s.Versions)
+			r.Get("/slo", s.GetSlo)
+			r.Get("/dictionary", s.GetEntityDictionary)
+			r.Get("/dictionary/versions", s.EntityDictionaryVersions)
 
 			r.Post("/save", s.SaveDeployed)
 		})
@@ -65,6 +133,7 @@ func (s *DeployService) Routes() chi.Router {
 		r.Use(s.jobAuth.Authenticator())
 
 		r.Get("/status-internal", s.GetStatusInternal)
+		r.Get("/dictionary-internal", s.GetEntityDictionaryInternal)
 		r.Post("/update-status", s.UpdateStatus)
 		r.Post("/log", s.JobLog)
 	})
@@ -103,8 +172,33 @@ func getDeploymentMemory(modelId uuid.UUID, userSpecified int, attrs map[string]
 	return 1000
 }
 
-func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autoscaling bool, autoscalingMin, autoscalingMax int, memory int, deploymentName string) error {
-	slog.Info("deploying model", "model_id", modelId, "autoscaling", autoscaling, "autoscalingMax", autoscalingMax, "memory", memory, "deployment_name", deploymentName)
+// deploymentOptions are the options from the deploy request that only apply to
+// the deployed model, and not to its dependencies.
+type deploymentOptions struct {
+	// The preset the settings are from, if any.
+	preset      string
+	environment string
+	llmProvider string
+
+	concurrencyLimits map[string]config.ConcurrencyLimit
+	queryCache        *config.QueryCacheOptions
+	llmCache          *config.LLMCacheOptions
+	ndbLoad           *config.NdbLoadOptions
+	spellCorrection   *config.SpellCorrectionOptions
+	rerank            *config.RerankOptions
+	enrichment        *config.EnrichmentOptions
+
+	confidenceThresholds *config.ConfidenceThresholdOptions
+
+	shadow *config.ShadowOptions
+
+	teamIsolation bool
+
+	placement *config.PlacementOptions
+}
+
+func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autoscaling bool, scaling config.AutoscalingOptions, memory int, deploymentName string, opts deploymentOptions) error {
+	slog.Info("deploying model", "model_id", modelId, "autoscaling", autoscaling, "autoscalingMax", scaling.MaxReplicas, "memory", memory, "deployment_name", deploymentName)
 
 	requiresOnPremLlm := false
 
@@ -138,8 +232,46 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 			return nil
 		}
 
+		if err := checkModelHot(txn, modelId, "deployed"); err != nil {
+			return err
+		}
+		if err := touchModelStorageTier(txn, modelId); err != nil {
+			return err
+		}
+
+		if err := checkDeploymentLimit(txn, s.variables.UserJobLimits, model.UserId); err != nil {
+			return err
+		}
+
+		if opts.confidenceThresholds != nil && model.Type != schema.NlpTextModel && model.Type != schema.NlpTokenModel {
+			return CodedError(fmt.Errorf("confidence thresholds are only supported for %v and %v models", schema.NlpTextModel, schema.NlpTokenModel), http.StatusUnprocessableEntity)
+		}
+		if opts.rerank != nil && model.Type != schema.NdbModel {
+			return CodedError(fmt.Errorf("rerank is only supported for %v models", schema.NdbModel), http.StatusUnprocessableEntity)
+		}
+		if opts.enrichment != nil && model.Type != schema.NdbModel {
+			return CodedError(fmt.Errorf("enrichment is only supported for %v models", schema.NdbModel), http.StatusUnprocessableEntity)
+		}
+
+		if opts.shadow != nil {
+			if err := checkShadowModel(txn, model, user, opts.shadow.ModelId); err != nil {
+				return err
+			}
+		}
+
 		attrs := model.GetAttributes()
 
+		var external externalModelSpec
+		if model.Type == schema.ExternalModel {
+			external, err = parseExternalModelSpec(attrs)
+			if err != nil {
+				return CodedError(err, http.StatusUnprocessableEntity)
+			}
+			if external.Runtime == ExternalRuntimeContainer && s.variables.BackendDriver.DriverType() != "docker" {
+				return CodedError(fmt.Errorf("external models with the %v runtime can only be deployed with the docker driver", ExternalRuntimeContainer), http.StatusUnprocessableEntity)
+			}
+		}
+
 		isKE := (model.Type == schema.KnowledgeExtraction)
 
 		var resources orchestrator.Resources
@@ -161,7 +293,13 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 			}
 		}
 
-		license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, resources.AllocationMhz)
+		jobMhz := resources.AllocationMhz
+		if external.Image != "" {
+			// The inference server runs with the same resources as the backend.
+			jobMhz *= 2
+		}
+
+		license, err := verifyLicenseForUserJob(txn, s.orchestratorClient, s.license, model.UserId, jobMhz)
 		if err != nil {
 			return CodedError(err, GetResponseCode(err))
 		}
@@ -172,6 +310,9 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 			return CodedError(errors.New("error setting up model deployment"), http.StatusInternalServerError)
 		}
 
+		if opts.llmProvider != "" {
+			attrs["llm_provider"] = opts.llmProvider
+		}
 		if llm, hasLlm := attrs["llm_provider"]; hasLlm {
 			if llm == "on-prem" {
 				requiresOnPremLlm = true
@@ -179,6 +320,14 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 				attrs["genai_key"] = s.variables.LlmProviders[llm]
 			}
 		}
+		if opts.rerank != nil && opts.rerank.Provider == config.RerankLlmDispatch && opts.rerank.LlmProvider != "on-prem" {
+			// The key is passed with the options of the model so that it is not
+			// saved in the deployment settings.
+			attrs["rerank_genai_key"] = s.variables.LlmProviders[opts.rerank.LlmProvider]
+		}
+		if _, hasGuardrail := attrs["guardrail_id"]; hasGuardrail && model.Type == schema.EnterpriseSearch {
+			attrs["redaction_key"] = redactionKey(s.redactionSecret, modelId)
+		}
 
 		var hostDir string
 		if s.variables.BackendDriver.DriverType() == "local" {
@@ -188,40 +337,68 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 		}
 
 		config := config.DeployConfig{
-			ModelId:             model.Id,
-			UserId:              user.Id,
-			ModelType:           model.Type,
-			ModelBazaarDir:      s.storage.Location(),
-			HostDir:             hostDir,
-			ModelBazaarEndpoint: s.variables.ModelBazaarEndpoint,
-			LicenseKey:          license,
-			JobAuthToken:        token,
-			Autoscaling:         autoscaling,
-			Options:             attrs,
-		}
-
-		configPath, err := saveConfig(config.ModelId, "deploy", config, s.storage)
+			ModelId:              model.Id,
+			UserId:               user.Id,
+			ModelType:            model.Type,
+			ModelBazaarDir:       s.storage.Location(),
+			HostDir:              hostDir,
+			ModelBazaarEndpoint:  s.variables.ModelBazaarEndpoint,
+			LicenseKey:           license,
+			JobAuthToken:         token,
+			Autoscaling:          autoscaling,
+			Options:              attrs,
+			ConcurrencyLimits:    opts.concurrencyLimits,
+			QueryCache:           opts.queryCache,
+			LLMCache:             opts.llmCache,
+			NdbLoad:              opts.ndbLoad,
+			SpellCorrection:      opts.spellCorrection,
+			Rerank:               opts.rerank,
+			Enrichment:           opts.enrichment,
+			ConfidenceThresholds: opts.confidenceThresholds,
+			Shadow:               opts.shadow,
+			TeamIsolation:        opts.teamIsolation,
+			EnableProfiling:      s.variables.EnableProfiling,
+		}
+		if autoscaling {
+			config.AutoscalingOptions = &scaling
+		}
+
+		version, err := nextDeploymentVersion(txn, model.Id)
+		if err != nil {
+			return err
+		}
+
+		// Each version has its own config so that rolling back to it uses the
+		// config it was deployed with.
+		configPath, err := saveConfig(config.ModelId, fmt.Sprintf("deploy_v%d", version), config, s.storage)
 		if err != nil {
 			return CodedError(errors.New("error creating model deployment config"), http.StatusInternalServerError)
 		}
 
-		nomadErr = s.orchestratorClient.StartJob(
-			orchestrator.DeployJob{
-				JobName:            model.DeployJobName(),
-				ModelId:            model.Id.String(),
-				ConfigPath:         configPath,
-				DeploymentName:     deploymentName,
-				AutoscalingEnabled: autoscaling,
-				AutoscalingMin:     autoscalingMin,
-				AutoscalingMax:     autoscalingMax,
-				Driver:             s.variables.BackendDriver,
-				Resources:          resources,
-				CloudCredentials:   s.variables.CloudCredentials,
-				JobToken:           uuid.New().String(),
-				IsKE:               isKE,
-				IngressHostname:    s.orchestratorClient.IngressHostname(),
-			},
-		)
+		spec := deploymentSpec{
+			ConfigPath:         configPath,
+			DeploymentName:     deploymentName,
+			AutoscalingEnabled: autoscaling,
+			Resources:          resources,
+			IsKE:               isKE,
+			ExternalImage:      external.Image,
+			ExternalPort:       external.Port,
+			Placement:          opts.placement,
+		}
+		spec.setAutoscaling(scaling)
+		spec.setDriver(s.variables.BackendDriver)
+
+		job, err := s.deployJob(txn, model, spec)
+		if err != nil {
+			return err
+		}
+
+		if err := startJobRun(txn, "deploy", model.Id, job.JobName, job.Resources, 0); err != nil {
+			return err
+		}
+
+		nomadErr = s.orchestratorClient.StartJob(job)
+
 		var newStatus string
 		if nomadErr != nil {
 			newStatus = schema.Failed
@@ -229,13 +406,30 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 			newStatus = schema.Starting
 		}
 
-		result := txn.Model(&model).Update("deploy_status", newStatus)
-		if result.Error != nil {
-			slog.Error("sql error updating deploy status on job start", "model_id", model.Id, "error", result.Error)
-			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		if err := updateStatus(txn, "deploy", &model, newStatus, startErrorMessage(nomadErr)); err != nil {
+			return err
+		}
+
+		if nomadErr != nil {
+			return nil
+		}
+
+		if err := saveDeploymentVersion(txn, model.Id, version, version, spec); err != nil {
+			return err
 		}
 
-		return nil
+		metadata := map[string]interface{}{"deployment_name": deploymentName, "autoscaling": autoscaling}
+		if opts.preset != "" {
+			metadata["preset"] = opts.preset
+		}
+		if opts.environment != "" {
+			metadata["environment"] = opts.environment
+		}
+		if opts.shadow != nil {
+			metadata["shadow_model_id"] = opts.shadow.ModelId
+			metadata["shadow_percentage"] = opts.shadow.Percentage
+		}
+		return recordModelEvent(txn, schema.DeploymentStartedEvent, model, metadata)
 	})
 
 	if err != nil {
@@ -243,7 +437,7 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 	}
 
 	if nomadErr != nil {
-		return CodedError(errors.New("error starting model deployment on nomad"), http.StatusInternalServerError)
+		return jobStartError(nomadErr, "error starting model deployment on nomad")
 	}
 
 	if requiresOnPremLlm {
@@ -259,12 +453,143 @@ func (s *DeployService) deployModel(modelId uuid.UUID, user schema.User, autosca
 	return nil
 }
 
+// DeploymentSettings are the settings for a deployment that can be specified
+// in the deploy request or saved in a deployment preset.
+type DeploymentSettings struct {
+	Autoscaling            bool `json:"autoscaling_enabled"`
+	AutoscalingMin         int  `json:"autoscaling_min"`
+	AutoscalingMax         int  `json:"autoscaling_max"`
+	AutoscalingTargetCpu   int  `json:"autoscaling_target_cpu"`
+	ScaleToZeroIdleSeconds int  `json:"scale_to_zero_idle_seconds"`
+	Memory                 int  `json:"memory"`
+
+	// Overrides the llm provider of the model, if it uses one.
+	LlmProvider string `json:"llm_provider"`
+
+	ConcurrencyLimits map[string]config.ConcurrencyLimit `json:"concurrency_limits"`
+	QueryCache        *config.QueryCacheOptions          `json:"query_cache"`
+	LLMCache          *config.LLMCacheOptions            `json:"llm_cache"`
+	NdbLoad           *config.NdbLoadOptions             `json:"ndb_load"`
+	SpellCorrection   *config.SpellCorrectionOptions     `json:"spell_correction"`
+	Rerank            *config.RerankOptions              `json:"rerank"`
+	Enrichment        *config.EnrichmentOptions          `json:"enrichment"`
+
+	ConfidenceThresholds *config.ConfidenceThresholdOptions `json:"confidence_thresholds"`
+
+	// Constrains the documents of each user to their team, so that one ndb
+	// deployment can be shared by multiple teams.
+	TeamIsolation bool `json:"team_isolation"`
+
+	// Spreads the replicas across nodes or zones and limits how many can be
+	// evicted at once, this is only supported on kubernetes.
+	Placement *config.PlacementOptions `json:"placement"`
+
+	// Labels the environment of the deployment. Only models that are approved
+	// for production can be deployed to the prod environment.
+	Environment string `json:"environment" validate:"max=50"`
+}
+
+// validateSettings checks the settings and returns the autoscaling options for them.
+func (s *DeployService) validateSettings(settings DeploymentSettings) (config.AutoscalingOptions, error) {
+	scaling := config.AutoscalingOptions{
+		MinReplicas:            max(settings.AutoscalingMin, 1),
+		MaxReplicas:            max(settings.AutoscalingMax, 1),
+		TargetCpu:              settings.AutoscalingTargetCpu,
+		ScaleToZeroIdleSeconds: settings.ScaleToZeroIdleSeconds,
+	}
+	// The query cache, llm cache, ndb load, spell correction, rerank,
+	// enrichment, confidence threshold, and placement options are validated by
+	// Struct.
+	errs := validation.Struct(&settings)
+
+	if settings.Autoscaling {
+		errs.Merge("", s.validateAutoscaling(&scaling))
+	} else {
+		scaling.TargetCpu = config.DefaultAutoscalingTargetCpu
+		scaling.ScaleToZeroIdleSeconds = 0
+	}
+
+	if settings.LlmProvider != "" {
+		if _, err := s.variables.GenaiKey(settings.LlmProvider); err != nil {
+			errs.Merge("llm_provider", err)
+		}
+	}
+
+	if settings.Rerank != nil && settings.Rerank.Provider == config.RerankLlmDispatch && settings.Rerank.LlmProvider == "openai" {
+		if _, err := s.variables.GenaiKey(settings.Rerank.LlmProvider); err != nil {
+			errs.Merge("rerank.llm_provider", err)
+		}
+	}
+
+	if settings.TeamIsolation && settings.SpellCorrection != nil {
+		// The vocabulary is built from the documents of every team, so its
+		// suggestions could reveal the documents of other teams.
+		errs.Add("spell_correction", "spell correction cannot be used with team isolation")
+	}
+
+	if settings.TeamIsolation && settings.LLMCache != nil {
+		// The cache is shared by all teams, so its suggestions and cached answers
+		// could reveal the documents of other teams.
+		errs.Add("llm_cache", "the llm cache cannot be used with team isolation")
+	}
+
+	if settings.Placement != nil && s.orchestratorClient.GetName() != "kubernetes" {
+		errs.Add("placement", "placement is only supported on kubernetes")
+	}
+
+	errs.Merge("concurrency_limits", config.ValidateConcurrencyLimits(settings.ConcurrencyLimits))
+
+	return scaling, errs.Err()
+}
+
 type startRequest struct {
 	DeploymentName string `json:"deployment_name"`
-	Autoscaling    bool   `json:"autoscaling_enabled"`
-	AutoscalingMin int    `json:"autoscaling_min"`
-	AutoscalingMax int    `json:"autoscaling_max"`
-	Memory         int    `json:"memory"`
+
+	// The name of a deployment preset to use for the settings. The settings
+	// cannot also be specified in the request when a preset is used.
+	Preset string `json:"preset"`
+
+	IgnoreEvalThresholds bool `json:"ignore_eval_thresholds"`
+
+	// Mirrors queries to the deployment of another model. This is not part of
+	// the deployment settings since it is specific to the model being deployed.
+	Shadow *config.ShadowOptions `json:"shadow"`
+
+	DeploymentSettings
+}
+
+// checkShadowModel checks that the queries to the model can be mirrored to the
+// shadow model. The shadow model does not need to be deployed yet, queries that
+// are mirrored before it is deployed are counted as shadow errors.
+func checkShadowModel(txn *gorm.DB, model schema.Model, user schema.User, shadowId uuid.UUID) error {
+	if model.Type != schema.NdbModel {
+		return CodedError(fmt.Errorf("shadow deployments are only supported for %v models", schema.NdbModel), http.StatusUnprocessableEntity)
+	}
+	if shadowId == model.Id {
+		return CodedError(errors.New("a model cannot shadow itself"), http.StatusUnprocessableEntity)
+	}
+
+	shadow, err := schema.GetModel(shadowId, txn, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return CodedError(fmt.Errorf("shadow model %v not found", shadowId), http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	if shadow.Type != schema.NdbModel {
+		return CodedError(fmt.Errorf("shadow model %v must be a %v model, found %v", shadowId, schema.NdbModel, shadow.Type), http.StatusUnprocessableEntity)
+	}
+
+	perm, err := auth.GetModelPermissions(shadowId, user, txn)
+	if err != nil {
+		slog.Error("unable to retrieve permissions for shadow model", "model_id", shadowId, "error", err)
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	if perm < auth.ReadPermission {
+		return CodedError(fmt.Errorf("user %v does not have permission to read shadow model %v", user.Id, shadowId), http.StatusForbidden)
+	}
+
+	return nil
 }
 
 func (s *DeployService) Start(w http.ResponseWriter, r *http.Request) {
@@ -285,23 +610,84 @@ func (s *DeployService) Start(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	params.AutoscalingMin = max(params.AutoscalingMin, 1)
-	params.AutoscalingMax = max(params.AutoscalingMax, 1)
+	settings := params.DeploymentSettings
+	if params.Preset != "" {
+		if !reflect.ValueOf(params.DeploymentSettings).IsZero() {
+			http.Error(w, fmt.Sprintf("deployment settings cannot be specified when using preset '%v'", params.Preset), http.StatusUnprocessableEntity)
+			return
+		}
+		preset, err := getDeploymentPreset(s.db, params.Preset)
+		if err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+		settings = preset.Settings
+	}
+
+	scaling, err := s.validateSettings(settings)
+	if err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid deployment settings: %v", err), err)
+		return
+	}
+
+	if params.Shadow != nil {
+		var errs validation.Errors
+		errs.Merge("shadow", params.Shadow.Validate())
+		if err := errs.Err(); err != nil {
+			utils.WriteValidationError(w, fmt.Sprintf("invalid shadow options: %v", err), err)
+			return
+		}
+	}
+
+	if !params.IgnoreEvalThresholds {
+		if err := checkHoldoutEvaluation(s.db, modelId); err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+	}
 
 	deps, err := listModelDependencies(modelId, s.db)
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
+	// The dependencies are deployed with the same settings, so they must also
+	// be approved.
+	if settings.Environment == ProductionEnvironment {
+		for _, dep := range deps {
+			if err := checkApprovedForProduction(s.db, dep.Id); err != nil {
+				writeError(w, err.Error(), err)
+				return
+			}
+		}
+	}
+
 	for _, dep := range deps {
 		name := ""
+		var opts deploymentOptions
 		if dep.Id == modelId {
 			name = params.DeploymentName
+			opts = deploymentOptions{
+				preset:               params.Preset,
+				environment:          settings.Environment,
+				llmProvider:          settings.LlmProvider,
+				concurrencyLimits:    settings.ConcurrencyLimits,
+				queryCache:           settings.QueryCache,
+				llmCache:             settings.LLMCache,
+				ndbLoad:              settings.NdbLoad,
+				spellCorrection:      settings.SpellCorrection,
+				rerank:               settings.Rerank,
+				enrichment:           settings.Enrichment,
+				confidenceThresholds: settings.ConfidenceThresholds,
+				shadow:               params.Shadow,
+				teamIsolation:        settings.TeamIsolation,
+				placement:            settings.Placement,
+			}
 		}
-		err := s.deployModel(dep.Id, user, params.Autoscaling, params.AutoscalingMin, params.AutoscalingMax, params.Memory, name)
+		err := s.deployModel(dep.Id, user, settings.Autoscaling, scaling, settings.Memory, name, opts)
 		if err != nil {
-			http.Error(w, err.Error(), GetResponseCode(err))
+			writeError(w, err.Error(), err)
 			return
 		}
 	}
@@ -318,46 +704,75 @@ func (s *DeployService) Stop(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("stopping deployment for model", "model_id", modelId)
 
-	err = s.db.Transaction(func(txn *gorm.DB) error {
-		usedBy, err := countDownstreamModels(modelId, txn, true)
-		if err != nil {
-			return fmt.Errorf("error checking if model is a dependend of other models: %w", err)
-		}
-		if usedBy != 0 {
-			return CodedError(fmt.Errorf("cannot stop deployment for model %v since it is used as a dependency by %d other active models", modelId, usedBy), http.StatusUnprocessableEntity)
-		}
+	err = s.stopDeployment(modelId, nil)
 
-		model, err := schema.GetModel(modelId, txn, false, false, false)
-		if err != nil {
-			if errors.Is(err, schema.ErrModelNotFound) {
-				return CodedError(err, http.StatusNotFound)
-			}
-			return CodedError(err, http.StatusInternalServerError)
-		}
+	if err != nil {
+		writeError(w, fmt.Sprintf("error stopping model deployment: %v", err), err)
+		return
+	}
 
-		err = s.orchestratorClient.StopJob(model.DeployJobName())
-		if err != nil {
-			slog.Error("error stopping deployment", "error", err)
-			return CodedError(errors.New("error stopping deployment job"), http.StatusInternalServerError)
-		}
+	slog.Info("model stopped successfully", "model_id", modelId)
 
-		result := txn.Model(&model).Update("deploy_status", schema.Stopped)
-		if result.Error != nil {
-			slog.Error("sql error updating deploy status on job stop", "model_id", model.Id, "error", result.Error)
-			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
-		}
+	utils.WriteSuccess(w)
+}
 
-		return nil
+// stopDeployment stops the deployment job of the model, the event data is
+// recorded with the deployment stopped event. The orchestrator is called
+// between the transaction which checks the model and the one which updates its
+// status, so that a slow orchestrator does not hold a transaction open.
+func (s *DeployService) stopDeployment(modelId uuid.UUID, eventData map[string]interface{}) error {
+	var model schema.Model
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		var err error
+		model, err = checkDeploymentStoppable(txn, modelId)
+		return err
 	})
+	if err != nil {
+		return err
+	}
 
+	// Logs are archived before stopping the job since stopped allocations can
+	// be garbage collected by the orchestrator at any point.
+	_ = archiveJobLogs(s.db, s.orchestratorClient, s.storage, model.Id, "deploy", model.DeployJobName())
+
+	err = s.orchestratorClient.StopJob(model.DeployJobName())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error stopping model deployment: %v", err), GetResponseCode(err))
-		return
+		slog.Error("error stopping deployment", "error", err)
+		return CodedError(errors.New("error stopping deployment job"), http.StatusInternalServerError)
 	}
 
-	slog.Info("model stopped successfully", "model_id", modelId)
+	// The status update only applies if the deploy status has not changed since
+	// the model was checked.
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		if err := updateStatus(txn, "deploy", &model, schema.Stopped, ""); err != nil {
+			return err
+		}
+		return recordModelEvent(txn, schema.DeploymentStoppedEvent, model, eventData)
+	})
+}
 
-	utils.WriteSuccess(w)
+func checkDeploymentStoppable(txn *gorm.DB, modelId uuid.UUID) (schema.Model, error) {
+	usedBy, err := countDownstreamModels(modelId, txn, true)
+	if err != nil {
+		return schema.Model{}, fmt.Errorf("error checking if model is a dependend of other models: %w", err)
+	}
+	if usedBy != 0 {
+		return schema.Model{}, CodedError(fmt.Errorf("cannot stop deployment for model %v since it is used as a dependency by %d other active models", modelId, usedBy), http.StatusUnprocessableEntity)
+	}
+
+	model, err := schema.GetModel(modelId, txn, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return schema.Model{}, CodedError(err, http.StatusNotFound)
+		}
+		return schema.Model{}, CodedError(err, http.StatusInternalServerError)
+	}
+
+	if err := checkModelUnlocked(model, "undeployed"); err != nil {
+		return schema.Model{}, err
+	}
+
+	return model, nil
 }
 
 func (s *DeployService) GetStatusInternal(w http.ResponseWriter, r *http.Request) {
@@ -379,11 +794,16 @@ func (s *DeployService) GetStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *DeployService) UpdateStatus(w http.ResponseWriter, r *http.Request) {
-	updateStatusHandler(w, r, s.db, "deploy")
+	updateStatusHandler(w, r, s.db, "deploy", nil)
+	s.webhooks.Notify()
 }
 
 func (s *DeployService) Logs(w http.ResponseWriter, r *http.Request) {
-	getLogsHandler(w, r, s.db, s.orchestratorClient, "deploy")
+	getLogsHandler(w, r, s.db, s.orchestratorClient, s.storage, "deploy")
+}
+
+func (s *DeployService) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	streamLogsHandler(w, r, s.db, s.orchestratorClient, "deploy")
 }
 
 func (s *DeployService) JobLog(w http.ResponseWriter, r *http.Request) {
@@ -440,7 +860,7 @@ func (s *DeployService) SaveDeployed(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error saving new deployed model: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error saving new deployed model: %v", err), err)
 		return
 	}
 
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+
+	"gorm.io/gorm"
+)
+
+// validateAutoscaling checks the autoscaling options, and that they can be
+// applied by the orchestrator. The horizontal pod autoscaler on kubernetes
+// cannot scale to zero replicas unless the HPAScaleToZero feature gate is
+// enabled on the cluster.
+func (s *DeployService) validateAutoscaling(scaling *config.AutoscalingOptions) error {
+	if err := scaling.Validate(); err != nil {
+		return err
+	}
+	if scaling.ScaleToZeroIdleSeconds > 0 && s.orchestratorClient.GetName() == "kubernetes" {
+		var errs validation.Errors
+		errs.Add("scale_to_zero_idle_seconds", "scale_to_zero_idle_seconds is not supported on kubernetes")
+		return errs
+	}
+	return nil
+}
+
+type autoscalingRequest struct {
+	AutoscalingMin         *int `json:"autoscaling_min"`
+	AutoscalingMax         *int `json:"autoscaling_max"`
+	AutoscalingTargetCpu   *int `json:"autoscaling_target_cpu"`
+	ScaleToZeroIdleSeconds *int `json:"scale_to_zero_idle_seconds"`
+}
+
+func (req autoscalingRequest) apply(scaling *config.AutoscalingOptions) {
+	if req.AutoscalingMin != nil {
+		scaling.MinReplicas = *req.AutoscalingMin
+	}
+	if req.AutoscalingMax != nil {
+		scaling.MaxReplicas = *req.AutoscalingMax
+	}
+	if req.AutoscalingTargetCpu != nil {
+		scaling.TargetCpu = *req.AutoscalingTargetCpu
+	}
+	if req.ScaleToZeroIdleSeconds != nil {
+		scaling.ScaleToZeroIdleSeconds = *req.ScaleToZeroIdleSeconds
+	}
+}
+
+type AutoscalingResponse struct {
+	Version                int `json:"version"`
+	AutoscalingMin         int `json:"autoscaling_min"`
+	AutoscalingMax         int `json:"autoscaling_max"`
+	AutoscalingTargetCpu   int `json:"autoscaling_target_cpu"`
+	ScaleToZeroIdleSeconds int `json:"scale_to_zero_idle_seconds"`
+}
+
+// saveAutoscalingConfig copies the deploy config of the spec to the config for
+// the new version, with the autoscaling options updated.
+func (s *DeployService) saveAutoscalingConfig(spec deploymentSpec, version int, scaling config.AutoscalingOptions) (string, error) {
+	path, err := filepath.Rel(s.storage.Location(), spec.ConfigPath)
+	if err != nil {
+		slog.Error("invalid deploy config path", "config_path", spec.ConfigPath, "error", err)
+		return "", CodedError(errors.New("error loading deployment config"), http.StatusInternalServerError)
+	}
+
+	file, err := s.storage.Read(path)
+	if err != nil {
+		slog.Error("error reading deploy config", "config_path", spec.ConfigPath, "error", err)
+		return "", CodedError(errors.New("error loading deployment config"), http.StatusInternalServerError)
+	}
+	defer file.Close()
+
+	var deployConfig config.DeployConfig
+	if err := json.NewDecoder(file).Decode(&deployConfig); err != nil {
+		slog.Error("error parsing deploy config", "config_path", spec.ConfigPath, "error", err)
+		return "", CodedError(errors.New("error loading deployment config"), http.StatusInternalServerError)
+	}
+
+	deployConfig.AutoscalingOptions = &scaling
+
+	return saveConfig(deployConfig.ModelId, fmt.Sprintf("deploy_v%d", version), deployConfig, s.storage)
+}
+
+func (s *DeployService) UpdateAutoscaling(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params autoscalingRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	slog.Info("updating autoscaling for deployment", "model_id", modelId)
+
+	var res AutoscalingResponse
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if model.DeployStatus != schema.Starting && model.DeployStatus != schema.InProgress && model.DeployStatus != schema.Complete {
+			return CodedError(fmt.Errorf("model %v is not deployed", modelId), http.StatusUnprocessableEntity)
+		}
+
+		var current schema.DeploymentVersion
+		result := txn.Where("model_id = ?", modelId).Order("version DESC").Limit(1).Find(&current)
+		if result.Error != nil {
+			slog.Error("sql error getting current deployment version", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected != 1 {
+			return CodedError(fmt.Errorf("model %v has not been deployed", modelId), http.StatusUnprocessableEntity)
+		}
+
+		var spec deploymentSpec
+		if err := json.Unmarshal([]byte(current.Spec), &spec); err != nil {
+			slog.Error("error parsing deployment spec", "model_id", modelId, "version", current.Version, "error", err)
+			return CodedError(errors.New("error loading deployment version"), http.StatusInternalServerError)
+		}
+
+		// Deployments without autoscaling allow writes to the model, so they
+		// cannot be given more replicas without being redeployed.
+		if !spec.AutoscalingEnabled {
+			return CodedError(errors.New("autoscaling is not enabled for this deployment, it must be redeployed with autoscaling_enabled to change the autoscaling settings"), http.StatusUnprocessableEntity)
+		}
+
+		scaling := spec.autoscaling()
+		params.apply(&scaling)
+		if err := s.validateAutoscaling(&scaling); err != nil {
+			return CodedError(err, http.StatusUnprocessableEntity)
+		}
+
+		// The change is saved as a new version so that rolling back restores
+		// the previous autoscaling settings.
+		version := current.Version + 1
+		spec.ConfigPath, err = s.saveAutoscalingConfig(spec, version, scaling)
+		if err != nil {
+			return err
+		}
+		spec.setAutoscaling(scaling)
+
+		job, err := s.deployJob(txn, model, spec)
+		if err != nil {
+			return err
+		}
+
+		if err := s.orchestratorClient.UpdateAutoscaling(job); err != nil {
+			if errors.Is(err, orchestrator.ErrJobNotFound) {
+				return CodedError(fmt.Errorf("deployment job for model %v is not running", modelId), http.StatusUnprocessableEntity)
+			}
+			slog.Error("error updating deployment autoscaling", "model_id", modelId, "error", err)
+			return CodedError(errors.New("error updating deployment autoscaling"), http.StatusInternalServerError)
+		}
+
+		if err := saveDeploymentVersion(txn, model.Id, version, version, spec); err != nil {
+			return err
+		}
+
+		res = AutoscalingResponse{
+			Version:                version,
+			AutoscalingMin:         scaling.MinReplicas,
+			AutoscalingMax:         scaling.MaxReplicas,
+			AutoscalingTargetCpu:   scaling.TargetCpu,
+			ScaleToZeroIdleSeconds: scaling.ScaleToZeroIdleSeconds,
+		}
+
+		return recordModelEvent(txn, schema.DeploymentScaledEvent, model, map[string]interface{}{
+			"version": version, "autoscaling_min": scaling.MinReplicas, "autoscaling_max": scaling.MaxReplicas,
+		})
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating deployment autoscaling: %v", err), err)
+		return
+	}
+
+	slog.Info("deployment autoscaling updated successfully", "model_id", modelId, "version", res.Version)
+
+	utils.WriteJsonResponse(w, res)
+}
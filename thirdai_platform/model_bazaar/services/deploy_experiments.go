@@ -0,0 +1,502 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var experimentNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+
+const (
+	experimentArmA = "a"
+	experimentArmB = "b"
+
+	// The header of proxied responses that has the arm the caller is assigned to.
+	experimentArmHeader = "X-Experiment-Arm"
+)
+
+// The deployment endpoints whose requests are counted in the metrics of the
+// arms. Other requests are proxied without being counted.
+var (
+	experimentQueryPaths    = map[string]bool{"query": true}
+	experimentFeedbackPaths = map[string]bool{"upvote": true, "associate": true}
+)
+
+type ExperimentArmInfo struct {
+	Arm     string    `json:"arm"`
+	ModelId uuid.UUID `json:"model_id"`
+
+	Queries     int64 `json:"queries"`
+	QueryErrors int64 `json:"query_errors"`
+	// The mean latency of the queries in milliseconds, 0 if there were no queries.
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	Feedback     int64   `json:"feedback"`
+}
+
+type ExperimentInfo struct {
+	Id           uuid.UUID           `json:"id"`
+	Name         string              `json:"name"`
+	ModelAId     uuid.UUID           `json:"model_a_id"`
+	ModelBId     uuid.UUID           `json:"model_b_id"`
+	SplitPercent int                 `json:"split_percent"`
+	UserId       uuid.UUID           `json:"user_id"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Arms         []ExperimentArmInfo `json:"arms"`
+}
+
+func convertToExperimentInfo(experiment schema.Experiment) ExperimentInfo {
+	info := ExperimentInfo{
+		Id:           experiment.Id,
+		Name:         experiment.Name,
+		ModelAId:     experiment.ModelAId,
+		ModelBId:     experiment.ModelBId,
+		SplitPercent: experiment.SplitPercent,
+		UserId:       experiment.UserId,
+		CreatedAt:    experiment.CreatedAt.UTC(),
+		Arms:         make([]ExperimentArmInfo, 0, len(experiment.Arms)),
+	}
+	for _, arm := range experiment.Arms {
+		armInfo := ExperimentArmInfo{
+			Arm:         arm.Arm,
+			ModelId:     experimentArmModel(experiment, arm.Arm),
+			Queries:     arm.Queries,
+			QueryErrors: arm.QueryErrors,
+			Feedback:    arm.Feedback,
+		}
+		if arm.Queries > 0 {
+			armInfo.AvgLatencyMs = float64(arm.TotalLatencyMs) / float64(arm.Queries)
+		}
+		info.Arms = append(info.Arms, armInfo)
+	}
+	return info
+}
+
+func getExperiment(db *gorm.DB, r *http.Request) (schema.Experiment, error) {
+	experimentId, err := utils.URLParamUUID(r, "experiment_id")
+	if err != nil {
+		return schema.Experiment{}, CodedError(err, http.StatusBadRequest)
+	}
+
+	var experiment schema.Experiment
+	if err := db.Preload("Arms", func(db *gorm.DB) *gorm.DB { return db.Order("arm") }).First(&experiment, "id = ?", experimentId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return schema.Experiment{}, CodedError(fmt.Errorf("experiment %v does not exist", experimentId), http.StatusNotFound)
+		}
+		slog.Error("sql error retrieving experiment", "experiment_id", experimentId, "error", err)
+		return schema.Experiment{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return experiment, nil
+}
+
+// getOwnedExperiment returns the experiment if the user created it or is an
+// admin.
+func getOwnedExperiment(db *gorm.DB, r *http.Request) (schema.Experiment, error) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		return schema.Experiment{}, CodedError(err, http.StatusUnauthorized)
+	}
+
+	experiment, err := getExperiment(db, r)
+	if err != nil {
+		return schema.Experiment{}, err
+	}
+
+	if !user.IsAdmin && experiment.UserId != user.Id {
+		return schema.Experiment{}, CodedError(fmt.Errorf("user %v does not have permission to manage experiment %v", user.Id, experiment.Id), http.StatusForbidden)
+	}
+	return experiment, nil
+}
+
+func (s *DeployService) ListExperiments(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	query := s.db.Preload("Arms", func(db *gorm.DB) *gorm.DB { return db.Order("arm") }).Order("created_at")
+	if !user.IsAdmin {
+		query = query.Where("user_id = ?", user.Id)
+	}
+
+	var experiments []schema.Experiment
+	if err := query.Find(&experiments).Error; err != nil {
+		slog.Error("sql error listing experiments", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]ExperimentInfo, 0, len(experiments))
+	for _, experiment := range experiments {
+		infos = append(infos, convertToExperimentInfo(experiment))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+func (s *DeployService) GetExperiment(w http.ResponseWriter, r *http.Request) {
+	experiment, err := getOwnedExperiment(s.db, r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, convertToExperimentInfo(experiment))
+}
+
+type CreateExperimentRequest struct {
+	Name         string    `json:"name"`
+	ModelAId     uuid.UUID `json:"model_a_id"`
+	ModelBId     uuid.UUID `json:"model_b_id"`
+	SplitPercent int       `json:"split_percent"`
+}
+
+func validateSplitPercent(split int) error {
+	if split < 0 || split > 100 {
+		return CodedError(fmt.Errorf("split_percent must be between 0 and 100, got %d", split), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+// checkExperimentModel checks that the model is a deployed ndb model that the
+// user owns.
+func (s *DeployService) checkExperimentModel(modelId uuid.UUID, user schema.User) error {
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return CodedError(err, http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+
+	permission, err := auth.GetModelPermissions(modelId, user, s.db)
+	if err != nil {
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	if permission < auth.OwnerPermission {
+		return CodedError(fmt.Errorf("user %v must be an owner of model %v to use it in an experiment", user.Id, modelId), http.StatusForbidden)
+	}
+
+	if model.Type != schema.NdbModel {
+		return CodedError(fmt.Errorf("experiments are only supported for %v deployments, model %v has type %v", schema.NdbModel, modelId, model.Type), http.StatusUnprocessableEntity)
+	}
+	if model.DeployStatus != schema.Complete {
+		return CodedError(fmt.Errorf("model %v is not deployed, it has deploy status '%v'", modelId, model.DeployStatus), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+// CreateExperiment registers an experiment between two deployed ndb models.
+// Both models must be owned by the caller, so that traffic cannot be split to
+// a model that its owner did not agree to.
+func (s *DeployService) CreateExperiment(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var params CreateExperimentRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if !experimentNameRe.MatchString(params.Name) {
+		http.Error(w, fmt.Sprintf("invalid experiment name '%v', names must be at most 100 characters and only contain letters, numbers, '_', '.', and '-'", params.Name), http.StatusUnprocessableEntity)
+		return
+	}
+	if params.ModelAId == params.ModelBId {
+		http.Error(w, "model_a_id and model_b_id must be different models", http.StatusUnprocessableEntity)
+		return
+	}
+	if err := validateSplitPercent(params.SplitPercent); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	for _, modelId := range []uuid.UUID{params.ModelAId, params.ModelBId} {
+		if err := s.checkExperimentModel(modelId, user); err != nil {
+			writeError(w, fmt.Sprintf("unable to create experiment: %v", err), err)
+			return
+		}
+	}
+
+	experimentId := uuid.New()
+	experiment := schema.Experiment{
+		Id:           experimentId,
+		Name:         params.Name,
+		ModelAId:     params.ModelAId,
+		ModelBId:     params.ModelBId,
+		SplitPercent: params.SplitPercent,
+		UserId:       user.Id,
+		CreatedAt:    time.Now().UTC(),
+		Arms: []schema.ExperimentArm{
+			{ExperimentId: experimentId, Arm: experimentArmA},
+			{ExperimentId: experimentId, Arm: experimentArmB},
+		},
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		var existing int64
+		if err := txn.Model(&schema.Experiment{}).Where("name = ?", params.Name).Count(&existing).Error; err != nil {
+			slog.Error("sql error checking for existing experiment", "name", params.Name, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if existing > 0 {
+			return CodedError(fmt.Errorf("experiment '%v' already exists", params.Name), http.StatusConflict)
+		}
+
+		if err := txn.Create(&experiment).Error; err != nil {
+			slog.Error("sql error creating experiment", "name", params.Name, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("unable to create experiment: %v", err), err)
+		return
+	}
+
+	slog.Info("created experiment", "experiment_id", experiment.Id, "name", experiment.Name, "model_a_id", experiment.ModelAId, "model_b_id", experiment.ModelBId, "split_percent", experiment.SplitPercent)
+
+	utils.WriteJsonResponse(w, convertToExperimentInfo(experiment))
+}
+
+type UpdateExperimentRequest struct {
+	SplitPercent *int `json:"split_percent"`
+	// ResetMetrics clears the metrics of both arms, for example after a change
+	// to one of the models.
+	ResetMetrics bool `json:"reset_metrics"`
+}
+
+// UpdateExperiment changes the traffic split of an experiment. Callers keep
+// their arm unless the change moves their bucket to the other side of the split.
+func (s *DeployService) UpdateExperiment(w http.ResponseWriter, r *http.Request) {
+	experiment, err := getOwnedExperiment(s.db, r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	var params UpdateExperimentRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.SplitPercent != nil {
+		if err := validateSplitPercent(*params.SplitPercent); err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if params.SplitPercent != nil {
+			if err := txn.Model(&schema.Experiment{}).Where("id = ?", experiment.Id).Update("split_percent", *params.SplitPercent).Error; err != nil {
+				return err
+			}
+		}
+		if params.ResetMetrics {
+			reset := map[string]interface{}{"queries": 0, "query_errors": 0, "total_latency_ms": 0, "feedback": 0}
+			if err := txn.Model(&schema.ExperimentArm{}).Where("experiment_id = ?", experiment.Id).Updates(reset).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("sql error updating experiment", "experiment_id", experiment.Id, "error", err)
+		http.Error(w, fmt.Sprintf("error updating experiment: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("updated experiment", "experiment_id", experiment.Id, "split_percent", params.SplitPercent, "reset_metrics", params.ResetMetrics)
+
+	experiment, err = getExperiment(s.db, r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, convertToExperimentInfo(experiment))
+}
+
+// DeleteExperiment removes the experiment and its metrics, the deployments of
+// the models are not changed.
+func (s *DeployService) DeleteExperiment(w http.ResponseWriter, r *http.Request) {
+	experiment, err := getOwnedExperiment(s.db, r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if err := s.db.Select(clause.Associations).Delete(&experiment).Error; err != nil {
+		slog.Error("sql error deleting experiment", "experiment_id", experiment.Id, "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("deleted experiment", "experiment_id", experiment.Id, "name", experiment.Name)
+
+	utils.WriteSuccess(w)
+}
+
+// validateExperimentApiKey checks that the API key has access to both models of
+// the experiment, so that the requests of a caller do not fail depending on the
+// arm they are assigned to.
+func (s *DeployService) validateExperimentApiKey(r *http.Request) (schema.UserAPIKey, error) {
+	key, err := lookupApiKey(s.db, r)
+	if err != nil {
+		return schema.UserAPIKey{}, err
+	}
+
+	experiment, err := getExperiment(s.db, r)
+	if err != nil {
+		return schema.UserAPIKey{}, err
+	}
+
+	if !apiKeyHasModel(key, experiment.ModelAId) || !apiKeyHasModel(key, experiment.ModelBId) {
+		return schema.UserAPIKey{}, ErrAPIKeyModelMismatch
+	}
+	return key, nil
+}
+
+// assignArm returns the arm of the caller. Callers are hashed into 100 buckets
+// and the buckets below the split percentage are assigned to model b, so the
+// assignment is sticky, and a change to the split only moves the callers in
+// the buckets between the old and new split.
+func assignArm(experiment schema.Experiment, caller string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(experiment.Id.String()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(caller))
+
+	if int(hash.Sum32()%100) < experiment.SplitPercent {
+		return experimentArmB
+	}
+	return experimentArmA
+}
+
+func experimentArmModel(experiment schema.Experiment, arm string) uuid.UUID {
+	if arm == experimentArmB {
+		return experiment.ModelBId
+	}
+	return experiment.ModelAId
+}
+
+// recordExperimentRequest adds a proxied request to the metrics of the arm.
+// Queries are counted with their latency, and failed queries are also counted
+// as errors. Feedback is only counted if it was accepted by the deployment.
+func (s *DeployService) recordExperimentRequest(experimentId uuid.UUID, arm, path string, status int, latency time.Duration) {
+	updates := map[string]interface{}{}
+	switch {
+	case experimentQueryPaths[path]:
+		updates["queries"] = gorm.Expr("queries + 1")
+		updates["total_latency_ms"] = gorm.Expr("total_latency_ms + ?", latency.Milliseconds())
+		if status >= http.StatusBadRequest {
+			updates["query_errors"] = gorm.Expr("query_errors + 1")
+		}
+	case experimentFeedbackPaths[path]:
+		if status >= http.StatusBadRequest {
+			return
+		}
+		updates["feedback"] = gorm.Expr("feedback + 1")
+	default:
+		return
+	}
+
+	result := s.db.Model(&schema.ExperimentArm{}).Where("experiment_id = ? AND arm = ?", experimentId, arm).UpdateColumns(updates)
+	if result.Error != nil {
+		slog.Error("sql error recording experiment metrics", "experiment_id", experimentId, "arm", arm, "error", result.Error)
+	}
+}
+
+// ExperimentProxy forwards a request to the deployment of the arm the caller is
+// assigned to. Callers are identified by their API key if they use one, and
+// otherwise by their user id. The caller's credentials are forwarded, so the
+// deployment checks their permissions as usual.
+func (s *DeployService) ExperimentProxy(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	experiment, err := getExperiment(s.db, r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	for _, modelId := range []uuid.UUID{experiment.ModelAId, experiment.ModelBId} {
+		permission, err := auth.GetModelPermissions(modelId, user, s.db)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				http.Error(w, fmt.Sprintf("model %v of experiment %v no longer exists", modelId, experiment.Id), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if permission < auth.ReadPermission {
+			http.Error(w, fmt.Sprintf("user %v does not have read permission for model %v of experiment %v", user.Id, modelId, experiment.Id), http.StatusForbidden)
+			return
+		}
+	}
+
+	caller := "user:" + user.Id.String()
+	if keyId, ok := r.Context().Value(auth.ContextAPIKeyId).(uuid.UUID); ok {
+		caller = "api_key:" + keyId.String()
+	}
+	arm := assignArm(experiment, caller)
+
+	path := chi.URLParam(r, "*")
+	target, err := url.JoinPath(s.variables.ModelBazaarEndpoint, experimentArmModel(experiment, arm).String(), path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid deployment endpoint: %v", err), http.StatusInternalServerError)
+		return
+	}
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid deployment endpoint: %v", err), http.StatusInternalServerError)
+		return
+	}
+	targetUrl.RawQuery = r.URL.RawQuery
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = targetUrl
+			pr.Out.Host = ""
+		},
+		// Flushes immediately so that streamed responses are not buffered.
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("error proxying experiment request", "experiment_id", experiment.Id, "arm", arm, "path", path, "error", err)
+			http.Error(w, fmt.Sprintf("deployment for arm %v of experiment %v is unavailable", arm, experiment.Id), http.StatusBadGateway)
+		},
+	}
+
+	w.Header().Set(experimentArmHeader, arm)
+
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	start := time.Now()
+	proxy.ServeHTTP(ww, r)
+
+	s.recordExperimentRequest(experiment.Id, arm, path, ww.Status(), time.Since(start))
+}
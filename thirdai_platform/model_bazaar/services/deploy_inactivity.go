@@ -0,0 +1,297 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Deployments are checked for inactivity at most once per interval by the
+// status sync, since the check queries the traffic of every deployment over
+// the inactivity period. Flagged deployments are stopped at the first status
+// sync after their grace period ends.
+const inactivityCheckInterval = time.Hour
+
+type InactivityPolicy struct {
+	// After is how long a deployment must go without being queried or
+	// retrained before it is flagged as inactive, 0 disables the check.
+	After time.Duration
+	// UndeployGrace is how long a flagged deployment is kept running before it
+	// is stopped, 0 only notifies the owner.
+	UndeployGrace time.Duration
+}
+
+type InactiveDeploymentInfo struct {
+	ModelId    uuid.UUID  `json:"model_id"`
+	ModelName  string     `json:"model_name"`
+	Username   string     `json:"username"`
+	FlaggedAt  time.Time  `json:"flagged_at"`
+	UndeployAt *time.Time `json:"undeploy_at"`
+}
+
+func newInactiveDeploymentInfo(flag schema.InactiveDeployment) InactiveDeploymentInfo {
+	info := InactiveDeploymentInfo{ModelId: flag.ModelId, FlaggedAt: flag.FlaggedAt.UTC(), UndeployAt: utcTime(flag.UndeployAt)}
+	if flag.Model != nil {
+		info.ModelName = flag.Model.Name
+		if flag.Model.User != nil {
+			info.Username = flag.Model.User.Username
+		}
+	}
+	return info
+}
+
+func listInactiveDeployments(db *gorm.DB) ([]InactiveDeploymentInfo, error) {
+	var flags []schema.InactiveDeployment
+	if err := db.Preload("Model.User").Order("flagged_at").Find(&flags).Error; err != nil {
+		slog.Error("sql error listing inactive deployments", "error", err)
+		return nil, schema.ErrDbAccessFailed
+	}
+
+	infos := make([]InactiveDeploymentInfo, 0, len(flags))
+	for _, flag := range flags {
+		infos = append(infos, newInactiveDeploymentInfo(flag))
+	}
+	return infos, nil
+}
+
+// getInactiveDeployment returns the inactivity flag of the deployment, or nil
+// if it is not flagged.
+func getInactiveDeployment(db *gorm.DB, modelId uuid.UUID) (*InactiveDeploymentInfo, error) {
+	var flags []schema.InactiveDeployment
+	if err := db.Limit(1).Find(&flags, "model_id = ?", modelId).Error; err != nil {
+		slog.Error("sql error retrieving inactive deployment flag", "model_id", modelId, "error", err)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	info := newInactiveDeploymentInfo(flags[0])
+	return &info, nil
+}
+
+func (s *DeployService) ListInactive(w http.ResponseWriter, r *http.Request) {
+	infos, err := listInactiveDeployments(s.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error listing inactive deployments: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+// activeDeployments returns which of the deployments were queried, trained, or
+// started within the inactivity period.
+func (s *DeployService) activeDeployments(models []schema.Model, now time.Time) (map[uuid.UUID]bool, error) {
+	query := fmt.Sprintf(`sum by (model_id) (increase({__name__=~"%s"}[%dh]))`, deploymentTrafficMetrics, int(s.variables.InactiveDeployments.After.Hours()))
+	requests, err := queryMetricsByModel(s.variables.metricsEndpoint(), query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.Id)
+	}
+
+	cutoff := now.Add(-s.variables.InactiveDeployments.After)
+	var recent []uuid.UUID
+	err = s.db.Model(&schema.JobRun{}).
+		Where("model_id IN ?", ids).
+		Where("(job = ? AND (ended_at IS NULL OR ended_at >= ?)) OR (job = ? AND started_at >= ?)", "train", cutoff, "deploy", cutoff).
+		Distinct().
+		Pluck("model_id", &recent).Error
+	if err != nil {
+		slog.Error("sql error listing recent job runs", "error", err)
+		return nil, schema.ErrDbAccessFailed
+	}
+
+	active := make(map[uuid.UUID]bool, len(models))
+	for _, id := range recent {
+		active[id] = true
+	}
+	for _, model := range models {
+		if requests[model.Id.String()] > 0 {
+			active[model.Id] = true
+		}
+	}
+	return active, nil
+}
+
+// checkInactiveDeployments flags deployments that have not been queried or
+// retrained for the inactivity period and notifies their owners, and stops
+// flagged deployments once their grace period ends. This is called from the
+// status sync.
+func (s *DeployService) checkInactiveDeployments() {
+	if s.variables.InactiveDeployments.After == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	if now.Sub(*s.inactivityCheckedAt) >= inactivityCheckInterval {
+		*s.inactivityCheckedAt = now
+		s.flagInactiveDeployments(now)
+	}
+
+	if s.variables.InactiveDeployments.UndeployGrace > 0 {
+		s.undeployInactiveDeployments(now)
+	}
+}
+
+func (s *DeployService) flagInactiveDeployments(now time.Time) {
+	// Flags of deployments that were stopped are removed, they are flagged
+	// again if they are redeployed and not used.
+	result := s.db.Where("model_id NOT IN (?)", s.db.Model(&schema.Model{}).Select("id").Where("deploy_status = ?", schema.Complete)).Delete(&schema.InactiveDeployment{})
+	if result.Error != nil {
+		slog.Error("status sync: sql error deleting flags of stopped deployments", "error", result.Error)
+		return
+	}
+
+	// Locked models cannot be undeployed, so they are not flagged.
+	var models []schema.Model
+	if err := s.db.Preload("User").Where("deploy_status = ? AND locked = ?", schema.Complete, false).Find(&models).Error; err != nil {
+		slog.Error("status sync: sql error listing deployments for inactivity check", "error", err)
+		return
+	}
+	if len(models) == 0 {
+		return
+	}
+
+	active, err := s.activeDeployments(models, now)
+	if err != nil {
+		slog.Error("status sync: error checking deployment activity", "error", err)
+		return
+	}
+
+	for _, model := range models {
+		if active[model.Id] {
+			result := s.db.Delete(&schema.InactiveDeployment{}, "model_id = ?", model.Id)
+			if result.Error != nil {
+				slog.Error("status sync: sql error deleting inactive deployment flag", "model_id", model.Id, "error", result.Error)
+			} else if result.RowsAffected > 0 {
+				slog.Info("status sync: inactive deployment is active again", "model_id", model.Id)
+			}
+			continue
+		}
+
+		// Models used by other active deployments are used through those
+		// deployments, and cannot be stopped while they are running.
+		usedBy, err := countDownstreamModels(model.Id, s.db, true)
+		if err != nil || usedBy > 0 {
+			continue
+		}
+
+		s.flagInactiveDeployment(model, now)
+	}
+}
+
+func (s *DeployService) flagInactiveDeployment(model schema.Model, now time.Time) {
+	flag := schema.InactiveDeployment{ModelId: model.Id, FlaggedAt: now}
+	if grace := s.variables.InactiveDeployments.UndeployGrace; grace > 0 {
+		undeployAt := now.Add(grace)
+		flag.UndeployAt = &undeployAt
+	}
+
+	flagged := false
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		// Only one instance of model bazaar records the flag if several check
+		// the deployment at the same time.
+		result := txn.Clauses(clause.OnConflict{DoNothing: true}).Create(&flag)
+		if result.Error != nil {
+			slog.Error("status sync: sql error flagging inactive deployment", "model_id", model.Id, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		flagged = true
+
+		data := map[string]interface{}{"inactive_days": int(s.variables.InactiveDeployments.After.Hours() / 24)}
+		if flag.UndeployAt != nil {
+			data["undeploy_at"] = flag.UndeployAt
+		}
+		return recordModelEvent(txn, schema.DeploymentInactiveEvent, model, data)
+	})
+	if err != nil {
+		slog.Error("status sync: error flagging inactive deployment", "model_id", model.Id, "error", err)
+		return
+	}
+
+	if flagged {
+		slog.Info("status sync: flagged inactive deployment", "model_id", model.Id, "undeploy_at", flag.UndeployAt)
+		s.emailInactiveDeployment(model, flag.UndeployAt, false)
+	}
+}
+
+func (s *DeployService) undeployInactiveDeployments(now time.Time) {
+	var flags []schema.InactiveDeployment
+	if err := s.db.Preload("Model.User").Where("undeploy_at <= ?", now).Find(&flags).Error; err != nil {
+		slog.Error("status sync: sql error listing inactive deployments to stop", "error", err)
+		return
+	}
+
+	for _, flag := range flags {
+		// The flag is deleted first so that only one instance of model bazaar
+		// stops the deployment.
+		result := s.db.Delete(&schema.InactiveDeployment{}, "model_id = ?", flag.ModelId)
+		if result.Error != nil {
+			slog.Error("status sync: sql error deleting inactive deployment flag", "model_id", flag.ModelId, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.stopDeployment(flag.ModelId, map[string]interface{}{"reason": "inactive"}); err != nil {
+			slog.Error("status sync: error stopping inactive deployment", "model_id", flag.ModelId, "error", err)
+			// The flag is restored so that stopping the deployment is retried
+			// on the next sync.
+			if err := s.db.Omit(clause.Associations).Create(&flag).Error; err != nil {
+				slog.Error("status sync: sql error restoring inactive deployment flag", "model_id", flag.ModelId, "error", err)
+			}
+			continue
+		}
+
+		slog.Info("status sync: stopped inactive deployment", "model_id", flag.ModelId)
+		s.emailInactiveDeployment(*flag.Model, nil, true)
+	}
+}
+
+func (s *DeployService) emailInactiveDeployment(model schema.Model, undeployAt *time.Time, stopped bool) {
+	if s.variables.Mailer == nil || model.User == nil || model.User.Email == "" {
+		return
+	}
+
+	days := int(s.variables.InactiveDeployments.After.Hours() / 24)
+	name := html.EscapeString(model.Name)
+
+	var subject, body string
+	switch {
+	case stopped:
+		subject = fmt.Sprintf("Inactive deployment '%v' was stopped", model.Name)
+		body = fmt.Sprintf("<p>Deployment <b>%v</b> (%v) was stopped since it was not queried or retrained in the last %d days. It can be deployed again at any time.</p>", name, model.Id, days)
+	case undeployAt != nil:
+		subject = fmt.Sprintf("Deployment '%v' is inactive", model.Name)
+		body = fmt.Sprintf(
+			"<p>Deployment <b>%v</b> (%v) has not been queried or retrained in the last %d days. It will be stopped after %v unless it is used before then.</p>",
+			name, model.Id, days, undeployAt.UTC().Format("2006-01-02 15:04 MST"),
+		)
+	default:
+		subject = fmt.Sprintf("Deployment '%v' is inactive", model.Name)
+		body = fmt.Sprintf("<p>Deployment <b>%v</b> (%v) has not been queried or retrained in the last %d days. Consider stopping it to free its resources.</p>", name, model.Id, days)
+	}
+
+	msg := mail.Message{To: []string{model.User.Email}, Subject: subject, Html: body}
+	if err := s.variables.Mailer.Send(msg); err != nil {
+		slog.Error("status sync: error emailing inactive deployment owner", "model_id", model.Id, "error", err)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var deploymentPresetNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+
+type DeploymentPresetInfo struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Settings    DeploymentSettings `json:"settings"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+func convertToDeploymentPresetInfo(preset schema.DeploymentPreset) (DeploymentPresetInfo, error) {
+	var settings DeploymentSettings
+	if err := json.Unmarshal([]byte(preset.Settings), &settings); err != nil {
+		slog.Error("error parsing deployment preset settings", "preset", preset.Name, "error", err)
+		return DeploymentPresetInfo{}, CodedError(fmt.Errorf("error parsing settings of deployment preset '%v'", preset.Name), http.StatusInternalServerError)
+	}
+	return DeploymentPresetInfo{
+		Name:        preset.Name,
+		Description: preset.Description,
+		Settings:    settings,
+		CreatedAt:   preset.CreatedAt.UTC(),
+		UpdatedAt:   preset.UpdatedAt.UTC(),
+	}, nil
+}
+
+func getDeploymentPreset(db *gorm.DB, name string) (DeploymentPresetInfo, error) {
+	var preset schema.DeploymentPreset
+	if err := db.First(&preset, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return DeploymentPresetInfo{}, CodedError(fmt.Errorf("deployment preset '%v' does not exist", name), http.StatusNotFound)
+		}
+		slog.Error("sql error retrieving deployment preset", "preset", name, "error", err)
+		return DeploymentPresetInfo{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return convertToDeploymentPresetInfo(preset)
+}
+
+func (s *DeployService) ListPresets(w http.ResponseWriter, r *http.Request) {
+	var presets []schema.DeploymentPreset
+	if result := s.db.Order("name").Find(&presets); result.Error != nil {
+		slog.Error("sql error listing deployment presets", "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]DeploymentPresetInfo, 0, len(presets))
+	for _, preset := range presets {
+		info, err := convertToDeploymentPresetInfo(preset)
+		if err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+		infos = append(infos, info)
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+func (s *DeployService) GetPreset(w http.ResponseWriter, r *http.Request) {
+	info, err := getDeploymentPreset(s.db, chi.URLParam(r, "name"))
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
+
+type DeploymentPresetRequest struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Settings    DeploymentSettings `json:"settings"`
+}
+
+// SavePreset creates a preset or replaces the settings of an existing preset.
+// Deployments that already use the preset keep the settings they were started
+// with until they are redeployed.
+func (s *DeployService) SavePreset(w http.ResponseWriter, r *http.Request) {
+	var params DeploymentPresetRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if !deploymentPresetNameRe.MatchString(params.Name) {
+		http.Error(w, fmt.Sprintf("invalid preset name '%v', names must be at most 100 characters and only contain letters, numbers, '_', '.', and '-'", params.Name), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, err := s.validateSettings(params.Settings); err != nil {
+		var errs validation.Errors
+		errs.Merge("settings", err)
+		utils.WriteValidationError(w, fmt.Sprintf("invalid preset settings: %v", err), errs)
+		return
+	}
+
+	settings, err := json.Marshal(params.Settings)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error serializing preset settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var info DeploymentPresetInfo
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		now := time.Now().UTC()
+		preset := schema.DeploymentPreset{
+			Name:        params.Name,
+			Description: params.Description,
+			Settings:    string(settings),
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		var existing schema.DeploymentPreset
+		result := txn.Limit(1).Find(&existing, "name = ?", params.Name)
+		if result.Error != nil {
+			slog.Error("sql error retrieving deployment preset", "preset", params.Name, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected > 0 {
+			preset.CreatedAt = existing.CreatedAt
+		}
+
+		if result := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&preset); result.Error != nil {
+			slog.Error("sql error saving deployment preset", "preset", params.Name, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		info, err = convertToDeploymentPresetInfo(preset)
+		return err
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error saving deployment preset: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
+
+func (s *DeployService) DeletePreset(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	result := s.db.Delete(&schema.DeploymentPreset{}, "name = ?", name)
+	if result.Error != nil {
+		slog.Error("sql error deleting deployment preset", "preset", name, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("deployment preset '%v' does not exist", name), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSloWindowMinutes = 60
+	defaultSloMinRequests   = 10
+
+	// Each SLO is evaluated at most once per interval by the status sync.
+	sloEvaluationInterval = time.Minute
+)
+
+// The metrics used to evaluate SLOs. Queries rejected because the query route
+// of the deployment is saturated count as failed requests, they are not
+// included in ndb_query_count since they are rejected before the query runs.
+const (
+	sloRequestsQuery = `sum by (model_id) (increase({__name__=~"ndb_query_count|deployment_route_rejected_total",route=~"query|"}[%dm]))`
+	sloFailedQuery   = `sum by (model_id) (increase({__name__=~"ndb_query_errors|deployment_route_rejected_total",route=~"query|"}[%dm]))`
+	sloLatencyQuery  = `sum by (model_id) (increase(ndb_query_sum[%dm])) / sum by (model_id) (increase(ndb_query_count[%dm]))`
+)
+
+type sloRequest struct {
+	LatencyMs        int     `json:"latency_ms" validate:"min=0,max=600000"`
+	ErrorRatePercent float64 `json:"error_rate_percent" validate:"min=0,max=100"`
+	WindowMinutes    int     `json:"window_minutes" validate:"min=5,max=1440"`
+	MinRequests      int     `json:"min_requests" validate:"min=0"`
+}
+
+type SloInfo struct {
+	ModelId          uuid.UUID `json:"model_id"`
+	LatencyMs        int       `json:"latency_ms"`
+	ErrorRatePercent float64   `json:"error_rate_percent"`
+	WindowMinutes    int       `json:"window_minutes"`
+	MinRequests      int       `json:"min_requests"`
+
+	Status                   string     `json:"status"`
+	Message                  string     `json:"message,omitempty"`
+	Requests                 float64    `json:"requests"`
+	ObservedLatencyMs        float64    `json:"observed_latency_ms"`
+	ObservedErrorRatePercent float64    `json:"observed_error_rate_percent"`
+	EvaluatedAt              *time.Time `json:"evaluated_at"`
+	BreachedAt               *time.Time `json:"breached_at"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newSloInfo(slo schema.DeploymentSlo) SloInfo {
+	return SloInfo{
+		ModelId:                  slo.ModelId,
+		LatencyMs:                slo.LatencyMs,
+		ErrorRatePercent:         slo.ErrorRatePercent,
+		WindowMinutes:            slo.WindowMinutes,
+		MinRequests:              slo.MinRequests,
+		Status:                   slo.Status,
+		Message:                  slo.Message,
+		Requests:                 slo.Requests,
+		ObservedLatencyMs:        slo.ObservedLatencyMs,
+		ObservedErrorRatePercent: slo.ObservedErrorRatePercent,
+		EvaluatedAt:              utcTime(slo.EvaluatedAt),
+		BreachedAt:               utcTime(slo.BreachedAt),
+		UpdatedAt:                slo.UpdatedAt.UTC(),
+	}
+}
+
+// getDeploymentSlo returns the SLO of the deployment, or nil if it does not
+// have one.
+func getDeploymentSlo(db *gorm.DB, modelId uuid.UUID) (*SloInfo, error) {
+	var slos []schema.DeploymentSlo
+	if err := db.Limit(1).Find(&slos, "model_id = ?", modelId).Error; err != nil {
+		slog.Error("sql error retrieving deployment slo", "model_id", modelId, "error", err)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(slos) == 0 {
+		return nil, nil
+	}
+	info := newSloInfo(slos[0])
+	return &info, nil
+}
+
+func (s *DeployService) GetSlo(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slo, err := getDeploymentSlo(s.db, modelId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving slo: %v", err), err)
+		return
+	}
+	if slo == nil {
+		http.Error(w, fmt.Sprintf("deployment %v does not have an slo", modelId), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJsonResponse(w, slo)
+}
+
+// SetSlo sets the objectives of the deployment. The status of an existing SLO
+// is kept, and the SLO is evaluated with the new objectives at the next status
+// sync.
+func (s *DeployService) SetSlo(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params sloRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	errs := validation.Struct(&params)
+	if params.LatencyMs == 0 && params.ErrorRatePercent == 0 {
+		errs.Add("", "at least one of latency_ms or error_rate_percent must be specified")
+	}
+	if err := errs.Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid slo: %v", err), err)
+		return
+	}
+	if params.WindowMinutes == 0 {
+		params.WindowMinutes = defaultSloWindowMinutes
+	}
+	if params.MinRequests == 0 {
+		params.MinRequests = defaultSloMinRequests
+	}
+
+	var slo schema.DeploymentSlo
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if _, err := schema.GetModel(modelId, txn, false, false, false); err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		var existing []schema.DeploymentSlo
+		if err := txn.Limit(1).Find(&existing, "model_id = ?", modelId).Error; err != nil {
+			slog.Error("sql error retrieving deployment slo", "model_id", modelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if len(existing) > 0 {
+			slo = existing[0]
+		} else {
+			slo = schema.DeploymentSlo{ModelId: modelId, Status: schema.SloPending}
+		}
+
+		slo.LatencyMs = params.LatencyMs
+		slo.ErrorRatePercent = params.ErrorRatePercent
+		slo.WindowMinutes = params.WindowMinutes
+		slo.MinRequests = params.MinRequests
+		slo.EvaluatedAt = nil
+		slo.UpdatedBy = user.Id
+		slo.UpdatedAt = time.Now().UTC()
+
+		if err := txn.Save(&slo).Error; err != nil {
+			slog.Error("sql error saving deployment slo", "model_id", modelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error setting slo: %v", err), err)
+		return
+	}
+
+	slog.Info("set deployment slo", "model_id", modelId, "latency_ms", slo.LatencyMs, "error_rate_percent", slo.ErrorRatePercent, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, newSloInfo(slo))
+}
+
+func (s *DeployService) DeleteSlo(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := s.db.Delete(&schema.DeploymentSlo{}, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error deleting deployment slo", "model_id", modelId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error deleting slo: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("deployment %v does not have an slo", modelId), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+type sloObservation struct {
+	requests  float64
+	failed    float64
+	latencyMs float64
+}
+
+// evaluateSlo returns the status of the SLO for the requests observed over its
+// window. Windows with too few requests do not change the status, so that a
+// few failed requests while a deployment is idle do not breach the SLO.
+func evaluateSlo(slo schema.DeploymentSlo, observed sloObservation) (string, string) {
+	if observed.requests < float64(max(slo.MinRequests, 1)) {
+		return slo.Status, slo.Message
+	}
+
+	var violations []string
+	if slo.LatencyMs > 0 && observed.latencyMs > float64(slo.LatencyMs) {
+		violations = append(violations, fmt.Sprintf("average query latency of %.0fms exceeds the objective of %dms", observed.latencyMs, slo.LatencyMs))
+	}
+	errorRate := 100 * observed.failed / observed.requests
+	if slo.ErrorRatePercent > 0 && errorRate > slo.ErrorRatePercent {
+		violations = append(violations, fmt.Sprintf("%.2f%% of queries failed, exceeding the objective of %v%%", errorRate, slo.ErrorRatePercent))
+	}
+
+	if len(violations) == 0 {
+		return schema.SloMet, ""
+	}
+	return schema.SloBreached, fmt.Sprintf("%v in the last %d minutes", strings.Join(violations, " and "), slo.WindowMinutes)
+}
+
+// observeSlos queries the metrics of the deployments over the window of the
+// SLOs, which all have the same window.
+func (s *DeployService) observeSlos(windowMinutes int, now time.Time) (map[string]sloObservation, error) {
+	endpoint := s.variables.metricsEndpoint()
+
+	requests, err := queryMetricsByModel(endpoint, fmt.Sprintf(sloRequestsQuery, windowMinutes), now)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := queryMetricsByModel(endpoint, fmt.Sprintf(sloFailedQuery, windowMinutes), now)
+	if err != nil {
+		return nil, err
+	}
+	latency, err := queryMetricsByModel(endpoint, fmt.Sprintf(sloLatencyQuery, windowMinutes, windowMinutes), now)
+	if err != nil {
+		return nil, err
+	}
+
+	observed := make(map[string]sloObservation, len(requests))
+	for modelId, count := range requests {
+		observed[modelId] = sloObservation{requests: count, failed: failed[modelId], latencyMs: 1000 * latency[modelId]}
+	}
+	return observed, nil
+}
+
+// evaluateSlos checks the SLOs of running deployments against the metrics
+// scraped from the deployments, this is called from the status sync. Owners
+// are notified when an SLO is breached and when it recovers, through platform
+// events, webhooks, and email if smtp is configured.
+func (s *DeployService) evaluateSlos() {
+	now := time.Now().UTC()
+
+	var slos []schema.DeploymentSlo
+	err := s.db.
+		Preload("Model.User").
+		Where("model_id IN (?)", s.db.Model(&schema.Model{}).Select("id").Where("deploy_status = ?", schema.Complete)).
+		Where("evaluated_at IS NULL OR evaluated_at < ?", now.Add(-sloEvaluationInterval)).
+		Find(&slos).Error
+	if err != nil {
+		slog.Error("status sync: sql error listing deployment slos", "error", err)
+		return
+	}
+
+	byWindow := make(map[int][]schema.DeploymentSlo)
+	for _, slo := range slos {
+		byWindow[slo.WindowMinutes] = append(byWindow[slo.WindowMinutes], slo)
+	}
+
+	for window, slos := range byWindow {
+		observed, err := s.observeSlos(window, now)
+		if err != nil {
+			slog.Error("status sync: error getting deployment metrics for slos", "window_minutes", window, "error", err)
+			continue
+		}
+
+		for _, slo := range slos {
+			s.updateSlo(slo, observed[slo.ModelId.String()], now)
+		}
+	}
+}
+
+func (s *DeployService) updateSlo(slo schema.DeploymentSlo, observed sloObservation, now time.Time) {
+	status, message := evaluateSlo(slo, observed)
+
+	updates := map[string]interface{}{
+		"status":       status,
+		"message":      message,
+		"requests":     observed.requests,
+		"evaluated_at": now,
+	}
+	if observed.requests > 0 {
+		updates["observed_latency_ms"] = observed.latencyMs
+		updates["observed_error_rate_percent"] = 100 * observed.failed / observed.requests
+	}
+	// Only breaching or recovering from a breach is notified, an SLO that is
+	// met once the deployment has enough traffic is not.
+	notify := status != slo.Status && (status == schema.SloBreached || slo.Status == schema.SloBreached)
+	if status == schema.SloBreached && slo.Status != schema.SloBreached {
+		updates["breached_at"] = now
+	} else if status != schema.SloBreached {
+		updates["breached_at"] = nil
+	}
+
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		// The status is checked so that only one instance of model bazaar
+		// records the change if several evaluate the SLO at the same time.
+		result := txn.Model(&schema.DeploymentSlo{}).Where("model_id = ? AND status = ?", slo.ModelId, slo.Status).Updates(updates)
+		if result.Error != nil {
+			slog.Error("status sync: sql error updating deployment slo", "model_id", slo.ModelId, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			notify = false
+			return nil
+		}
+
+		if notify {
+			data := map[string]interface{}{"status": status, "previous_status": slo.Status}
+			if message != "" {
+				data["message"] = message
+			}
+			return recordModelEvent(txn, schema.DeploymentSloEvent, *slo.Model, data)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("status sync: error updating deployment slo", "model_id", slo.ModelId, "error", err)
+		return
+	}
+
+	if notify {
+		slog.Info("status sync: deployment slo status changed", "model_id", slo.ModelId, "status", status, "previous_status", slo.Status, "message", message)
+		s.emailSloStatus(slo, status, message)
+	}
+}
+
+func (s *DeployService) emailSloStatus(slo schema.DeploymentSlo, status, message string) {
+	if s.variables.Mailer == nil || slo.Model.User == nil || slo.Model.User.Email == "" {
+		return
+	}
+
+	subject := fmt.Sprintf("SLO breached for deployment '%v'", slo.Model.Name)
+	body := fmt.Sprintf("<p>The SLO of deployment <b>%v</b> (%v) is breached: %v.</p>", html.EscapeString(slo.Model.Name), slo.ModelId, html.EscapeString(message))
+	if status != schema.SloBreached {
+		subject = fmt.Sprintf("SLO recovered for deployment '%v'", slo.Model.Name)
+		body = fmt.Sprintf("<p>The SLO of deployment <b>%v</b> (%v) is met again.</p>", html.EscapeString(slo.Model.Name), slo.ModelId)
+	}
+
+	msg := mail.Message{To: []string{slo.Model.User.Email}, Subject: subject, Html: body}
+	if err := s.variables.Mailer.Send(msg); err != nil {
+		slog.Error("status sync: error emailing deployment slo status", "model_id", slo.ModelId, "error", err)
+	}
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// deploymentSpec is the part of a deploy job that is pinned for each deployment
+// version. Credentials and the job token are not stored, they are taken from
+// the current environment when a version is resubmitted.
+type deploymentSpec struct {
+	ConfigPath             string                 `json:"config_path"`
+	DeploymentName         string                 `json:"deployment_name"`
+	AutoscalingEnabled     bool                   `json:"autoscaling_enabled"`
+	AutoscalingMin         int                    `json:"autoscaling_min"`
+	AutoscalingMax         int                    `json:"autoscaling_max"`
+	AutoscalingTargetCpu   int                    `json:"autoscaling_target_cpu,omitempty"`
+	ScaleToZeroIdleSeconds int                    `json:"scale_to_zero_idle_seconds,omitempty"`
+	Resources              orchestrator.Resources `json:"resources"`
+	IsKE                   bool                   `json:"is_ke"`
+
+	// The inference server of external models that run in a container.
+	ExternalImage string `json:"external_image,omitempty"`
+	ExternalPort  int    `json:"external_port,omitempty"`
+
+	Placement *config.PlacementOptions `json:"placement,omitempty"`
+
+	DriverType string `json:"driver_type"`
+	Image      string `json:"image,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+func (spec *deploymentSpec) setDriver(driver orchestrator.Driver) {
+	spec.DriverType = driver.DriverType()
+	switch d := driver.(type) {
+	case orchestrator.DockerDriver:
+		spec.Image, spec.Tag = d.ImageName, d.Tag
+	case *orchestrator.DockerDriver:
+		spec.Image, spec.Tag = d.ImageName, d.Tag
+	}
+}
+
+func (spec *deploymentSpec) setAutoscaling(scaling config.AutoscalingOptions) {
+	spec.AutoscalingMin = scaling.MinReplicas
+	spec.AutoscalingMax = scaling.MaxReplicas
+	spec.AutoscalingTargetCpu = scaling.TargetCpu
+	spec.ScaleToZeroIdleSeconds = scaling.ScaleToZeroIdleSeconds
+}
+
+func (spec *deploymentSpec) autoscaling() config.AutoscalingOptions {
+	targetCpu := spec.AutoscalingTargetCpu
+	if targetCpu == 0 {
+		// Versions saved before the target was configurable.
+		targetCpu = config.DefaultAutoscalingTargetCpu
+	}
+	return config.AutoscalingOptions{
+		MinReplicas:            spec.AutoscalingMin,
+		MaxReplicas:            spec.AutoscalingMax,
+		TargetCpu:              targetCpu,
+		ScaleToZeroIdleSeconds: spec.ScaleToZeroIdleSeconds,
+	}
+}
+
+// driver returns the current backend driver with the image pinned in the spec.
+func (spec *deploymentSpec) driver(current orchestrator.Driver) (orchestrator.Driver, error) {
+	if current.DriverType() != spec.DriverType {
+		return nil, CodedError(fmt.Errorf("deployment version uses the %v driver, but the platform is using the %v driver", spec.DriverType, current.DriverType()), http.StatusUnprocessableEntity)
+	}
+
+	switch d := current.(type) {
+	case orchestrator.DockerDriver:
+		d.ImageName, d.Tag = spec.Image, spec.Tag
+		return d, nil
+	case *orchestrator.DockerDriver:
+		pinned := *d
+		pinned.ImageName, pinned.Tag = spec.Image, spec.Tag
+		return pinned, nil
+	default:
+		return current, nil
+	}
+}
+
+func (s *DeployService) deployJob(txn *gorm.DB, model schema.Model, spec deploymentSpec) (orchestrator.DeployJob, error) {
+	driver, err := spec.driver(s.variables.BackendDriver)
+	if err != nil {
+		return orchestrator.DeployJob{}, err
+	}
+
+	extraEnv, err := s.variables.jobEnv(txn, s.storage, deployJobEnv)
+	if err != nil {
+		return orchestrator.DeployJob{}, err
+	}
+
+	scaling := spec.autoscaling()
+
+	job := orchestrator.DeployJob{
+		JobName:                model.DeployJobName(),
+		ModelId:                model.Id.String(),
+		ConfigPath:             spec.ConfigPath,
+		DeploymentName:         spec.DeploymentName,
+		AutoscalingEnabled:     spec.AutoscalingEnabled,
+		AutoscalingMin:         scaling.MinReplicas,
+		AutoscalingMax:         scaling.MaxReplicas,
+		AutoscalingTargetCpu:   scaling.TargetCpu,
+		ScaleToZeroIdleSeconds: scaling.ScaleToZeroIdleSeconds,
+		Driver:                 driver,
+		Resources:              spec.Resources,
+		CloudCredentials:       s.variables.CloudCredentials,
+		JobToken:               uuid.New().String(),
+		IsKE:                   spec.IsKE,
+		ExternalImage:          spec.ExternalImage,
+		ExternalPort:           spec.ExternalPort,
+		IngressHostname:        s.orchestratorClient.IngressHostname(),
+		Namespace:              s.variables.jobNamespace(model),
+		ExtraEnv:               extraEnv,
+	}
+	if spec.Placement != nil {
+		job.AntiAffinity = spec.Placement.AntiAffinity
+		job.TopologyKey = spec.Placement.TopologyKey()
+		job.MaxUnavailable = spec.Placement.MaxUnavailable
+	}
+
+	return job, nil
+}
+
+func nextDeploymentVersion(txn *gorm.DB, modelId uuid.UUID) (int, error) {
+	var latest int
+	result := txn.Model(&schema.DeploymentVersion{}).Where("model_id = ?", modelId).Select("COALESCE(MAX(version), 0)").Scan(&latest)
+	if result.Error != nil {
+		slog.Error("sql error getting latest deployment version", "model_id", modelId, "error", result.Error)
+		return 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return latest + 1, nil
+}
+
+func saveDeploymentVersion(txn *gorm.DB, modelId uuid.UUID, version, sourceVersion int, spec deploymentSpec) error {
+	specJson, err := json.Marshal(spec)
+	if err != nil {
+		slog.Error("error serializing deployment spec", "model_id", modelId, "error", err)
+		return CodedError(errors.New("error saving deployment version"), http.StatusInternalServerError)
+	}
+
+	entry := schema.DeploymentVersion{
+		ModelId:       modelId,
+		Version:       version,
+		SourceVersion: sourceVersion,
+		Image:         spec.Image,
+		Tag:           spec.Tag,
+		Spec:          string(specJson),
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	if result := txn.Create(&entry); result.Error != nil {
+		slog.Error("sql error saving deployment version", "model_id", modelId, "version", version, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+type DeploymentVersionInfo struct {
+	Version       int                    `json:"version"`
+	SourceVersion int                    `json:"source_version"`
+	Image         string                 `json:"image"`
+	Tag           string                 `json:"tag"`
+	Resources     orchestrator.Resources `json:"resources"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Current       bool                   `json:"current"`
+}
+
+func (s *DeployService) Versions(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var versions []schema.DeploymentVersion
+	result := s.db.Where("model_id = ?", modelId).Order("version DESC").Find(&versions)
+	if result.Error != nil {
+		slog.Error("sql error listing deployment versions", "model_id", modelId, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]DeploymentVersionInfo, 0, len(versions))
+	for i, version := range versions {
+		var spec deploymentSpec
+		if err := json.Unmarshal([]byte(version.Spec), &spec); err != nil {
+			slog.Error("error parsing deployment spec", "model_id", modelId, "version", version.Version, "error", err)
+		}
+		infos = append(infos, DeploymentVersionInfo{
+			Version:       version.Version,
+			SourceVersion: version.SourceVersion,
+			Image:         version.Image,
+			Tag:           version.Tag,
+			Resources:     spec.Resources,
+			CreatedAt:     version.CreatedAt.UTC(),
+			Current:       i == 0,
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type rollbackRequest struct {
+	Version int `json:"version"`
+}
+
+type rollbackResponse struct {
+	Version       int `json:"version"`
+	SourceVersion int `json:"source_version"`
+}
+
+// rollbackTarget returns the version to roll back to. If no version is
+// specified it is the newest version older than the one that is currently
+// deployed, so that repeated rollbacks keep moving to older versions.
+func rollbackTarget(txn *gorm.DB, current schema.DeploymentVersion, requested int) (schema.DeploymentVersion, error) {
+	var target schema.DeploymentVersion
+
+	var result *gorm.DB
+	if requested > 0 {
+		result = txn.Where("model_id = ? AND version = ?", current.ModelId, requested).Limit(1).Find(&target)
+	} else {
+		result = txn.Where("model_id = ? AND version < ?", current.ModelId, current.SourceVersion).Order("version DESC").Limit(1).Find(&target)
+	}
+	if result.Error != nil {
+		slog.Error("sql error getting deployment version for rollback", "model_id", current.ModelId, "error", result.Error)
+		return target, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if result.RowsAffected != 1 {
+		if requested > 0 {
+			return target, CodedError(fmt.Errorf("deployment version %d not found", requested), http.StatusNotFound)
+		}
+		return target, CodedError(fmt.Errorf("there is no deployment version before version %d to roll back to", current.SourceVersion), http.StatusUnprocessableEntity)
+	}
+
+	if target.SourceVersion == current.SourceVersion {
+		return target, CodedError(fmt.Errorf("deployment version %d is already deployed", target.Version), http.StatusUnprocessableEntity)
+	}
+
+	return target, nil
+}
+
+func (s *DeployService) Rollback(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params rollbackRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	slog.Info("rolling back deployment for model", "model_id", modelId, "version", params.Version)
+
+	var res rollbackResponse
+	var nomadErr error = nil
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		var current schema.DeploymentVersion
+		result := txn.Where("model_id = ?", modelId).Order("version DESC").Limit(1).Find(&current)
+		if result.Error != nil {
+			slog.Error("sql error getting current deployment version", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected != 1 {
+			return CodedError(fmt.Errorf("model %v has not been deployed", modelId), http.StatusUnprocessableEntity)
+		}
+
+		target, err := rollbackTarget(txn, current, params.Version)
+		if err != nil {
+			return err
+		}
+
+		var spec deploymentSpec
+		if err := json.Unmarshal([]byte(target.Spec), &spec); err != nil {
+			slog.Error("error parsing deployment spec", "model_id", modelId, "version", target.Version, "error", err)
+			return CodedError(errors.New("error loading deployment version"), http.StatusInternalServerError)
+		}
+
+		// The resubmitted job replaces the current one, so only the validity of
+		// the license is checked and not the additional cpu usage.
+		if _, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, 0); err != nil {
+			return CodedError(err, GetResponseCode(err))
+		}
+
+		job, err := s.deployJob(txn, model, spec)
+		if err != nil {
+			return err
+		}
+
+		if err := startJobRun(txn, "deploy", model.Id, job.JobName, job.Resources, 0); err != nil {
+			return err
+		}
+
+		nomadErr = s.orchestratorClient.StartJob(job)
+
+		newStatus := schema.Starting
+		if nomadErr != nil {
+			newStatus = schema.Failed
+		}
+
+		if err := updateStatus(txn, "deploy", &model, newStatus, startErrorMessage(nomadErr)); err != nil {
+			return err
+		}
+
+		if nomadErr != nil {
+			return nil
+		}
+
+		res = rollbackResponse{Version: current.Version + 1, SourceVersion: target.SourceVersion}
+		if err := saveDeploymentVersion(txn, model.Id, res.Version, res.SourceVersion, spec); err != nil {
+			return err
+		}
+
+		return recordModelEvent(txn, schema.DeploymentRollbackEvent, model, map[string]interface{}{"from_version": current.SourceVersion, "to_version": target.SourceVersion})
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error rolling back model deployment: %v", err), err)
+		return
+	}
+
+	if nomadErr != nil {
+		err := jobStartError(nomadErr, "error starting model deployment on nomad")
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	slog.Info("model deployment rolled back successfully", "model_id", modelId, "version", res.Version, "source_version", res.SourceVersion)
+
+	utils.WriteJsonResponse(w, res)
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	minDocDirCategories       = 2
+	minDocDirCategoryExamples = 10
+
+	// PDFs larger than this are only checked for a valid header.
+	maxPdfInspectBytes = 64 * 1024 * 1024
+)
+
+var (
+	pdfPageRe  = regexp.MustCompile(`/Type\s*/Page[^s]`)
+	pdfCountRe = regexp.MustCompile(`/Count\s+(\d+)`)
+)
+
+func checkDocDirCategories(categories map[string][]string) validateDocDirResponse {
+	if len(categories) < minDocDirCategories {
+		return validateDocDirResponse{
+			IsValid:    false,
+			Msg:        "At least two distinct categories must be present",
+			Categories: categories,
+		}
+	}
+
+	insufficientCategories := []string{}
+	for category, examples := range categories {
+		if len(examples) < minDocDirCategoryExamples {
+			insufficientCategories = append(insufficientCategories, category)
+		}
+	}
+	slices.Sort(insufficientCategories)
+
+	if len(insufficientCategories) > 0 {
+		return validateDocDirResponse{
+			IsValid:    false,
+			Msg:        fmt.Sprintf("All categories must have at least 10 example documents. The following categories have fewer: %s", strings.Join(insufficientCategories, ", ")),
+			Categories: categories,
+		}
+	}
+
+	return validateDocDirResponse{
+		IsValid:    true,
+		Msg:        "Directory structure is valid",
+		Categories: categories,
+	}
+}
+
+// validateDocDirFilenames checks the directory structure described by the
+// filenames supplied by the client, without looking at any file contents.
+func validateDocDirFilenames(filenames []string) validateDocDirResponse {
+	invalidFileTypes := []string{}
+	for _, filename := range filenames {
+		if filepath.Ext(filename) != ".pdf" {
+			invalidFileTypes = append(invalidFileTypes, filename)
+		}
+	}
+
+	if len(invalidFileTypes) > 0 {
+		return validateDocDirResponse{
+			IsValid: false,
+			Msg:     fmt.Sprintf("Invalid file formats found: %s. Only .pdf files are supported.", strings.Join(invalidFileTypes, ", ")),
+		}
+	}
+
+	invalidFilePaths := []string{}
+	categories := map[string][]string{}
+	for _, filename := range filenames {
+		root, category := filepath.Split(filepath.Dir(filename))
+		extraDir, _ := filepath.Split(root)
+		if root == "" || category == "" || extraDir != "" {
+			invalidFilePaths = append(invalidFilePaths, filename)
+		} else {
+			categories[category] = append(categories[category], filename)
+		}
+	}
+
+	if len(invalidFilePaths) > 0 {
+		return validateDocDirResponse{
+			IsValid: false,
+			Msg:     fmt.Sprintf("Invalid directory structure detected. Files must be in the format 'root_directory/category/filename'. Invalid files: %s", strings.Join(invalidFilePaths, ", ")),
+		}
+	}
+
+	return checkDocDirCategories(categories)
+}
+
+// validateDocDirUpload checks the files stored for the given upload. The upload
+// directory is treated as the root directory, so files must be stored as
+// 'category/filename'. Each file is opened and checked to be a readable pdf with
+// at least one page, and the categories are built from the files that pass.
+func validateDocDirUpload(store storage.Storage, uploadId uuid.UUID) (validateDocDirResponse, error) {
+	uploadDir := storage.UploadPath(uploadId)
+
+	filenames, err := store.ListFiles(uploadDir)
+	if err != nil {
+		slog.Error("error listing upload files", "upload_id", uploadId, "error", err)
+		return validateDocDirResponse{}, CodedError(fmt.Errorf("unable to list files in upload %v", uploadId), http.StatusInternalServerError)
+	}
+	slices.Sort(filenames)
+
+	if len(filenames) == 0 {
+		return validateDocDirResponse{IsValid: false, Msg: "Upload does not contain any files"}, nil
+	}
+
+	invalidFiles := map[string]string{}
+	categories := map[string][]string{}
+	for _, filename := range filenames {
+		category, name := filepath.Split(filename)
+		category = filepath.Clean(category)
+		if category == "." || strings.ContainsRune(category, filepath.Separator) {
+			invalidFiles[filename] = "file must be in the format 'category/filename'"
+			continue
+		}
+
+		if filepath.Ext(name) != ".pdf" {
+			invalidFiles[filename] = "only .pdf files are supported"
+			continue
+		}
+
+		if err := inspectPdf(store, filepath.Join(uploadDir, filename)); err != nil {
+			invalidFiles[filename] = err.Error()
+			continue
+		}
+
+		categories[category] = append(categories[category], filename)
+	}
+
+	res := checkDocDirCategories(categories)
+	if len(invalidFiles) > 0 {
+		res.InvalidFiles = invalidFiles
+		if res.IsValid {
+			res.IsValid = false
+			res.Msg = fmt.Sprintf("Found %d files that are not readable pdfs", len(invalidFiles))
+		}
+	}
+
+	return res, nil
+}
+
+// inspectPdf returns an error describing why the file cannot be used for
+// training, or nil if it looks like a readable pdf with at least one page. This
+// is a lightweight structural check rather than a full parse of the document.
+func inspectPdf(store storage.Storage, path string) error {
+	file, err := store.Read(path)
+	if err != nil {
+		return fmt.Errorf("unable to read file")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxPdfInspectBytes))
+	if err != nil {
+		return fmt.Errorf("unable to read file")
+	}
+
+	if len(data) == 0 {
+		return fmt.Errorf("file is empty")
+	}
+
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\r "), []byte("%PDF-")) {
+		return fmt.Errorf("file is not a valid pdf")
+	}
+
+	if len(data) == maxPdfInspectBytes {
+		return nil
+	}
+
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return fmt.Errorf("pdf is encrypted")
+	}
+
+	pages := len(pdfPageRe.FindAllIndex(data, -1))
+	for _, match := range pdfCountRe.FindAllSubmatch(data, -1) {
+		if count, err := strconv.Atoi(string(match[1])); err == nil && count > pages {
+			pages = count
+		}
+	}
+
+	// Page objects may be stored in compressed object streams, in which case the
+	// page count cannot be determined without decompressing the streams.
+	if pages == 0 && !bytes.Contains(data, []byte("/ObjStm")) {
+		return fmt.Errorf("pdf has no pages")
+	}
+
+	return nil
+}
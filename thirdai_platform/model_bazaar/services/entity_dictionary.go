@@ -0,0 +1,400 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxEntityDictionaryEntries = 10000
+
+var entityTagRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+
+// EntityDictionaryEntry tags the matches of Pattern in the text with Tag. If
+// Regex is false the pattern is matched as an exact string at word boundaries.
+type EntityDictionaryEntry struct {
+	Id            uuid.UUID `json:"id"`
+	Pattern       string    `json:"pattern"`
+	Tag           string    `json:"tag"`
+	Regex         bool      `json:"regex"`
+	CaseSensitive bool      `json:"case_sensitive"`
+}
+
+type EntityDictionary struct {
+	ModelId   uuid.UUID               `json:"model_id"`
+	Version   int                     `json:"version"`
+	Change    string                  `json:"change,omitempty"`
+	Entries   []EntityDictionaryEntry `json:"entries"`
+	CreatedBy *uuid.UUID              `json:"created_by,omitempty"`
+	CreatedAt *time.Time              `json:"created_at,omitempty"`
+}
+
+type EntityDictionaryVersionInfo struct {
+	Version    int       `json:"version"`
+	Change     string    `json:"change"`
+	NumEntries int       `json:"num_entries"`
+	CreatedBy  uuid.UUID `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current"`
+}
+
+func newEntityDictionary(version schema.EntityDictionaryVersion) (EntityDictionary, error) {
+	var entries []EntityDictionaryEntry
+	if err := json.Unmarshal([]byte(version.Entries), &entries); err != nil {
+		slog.Error("error parsing entity dictionary entries", "model_id", version.ModelId, "version", version.Version, "error", err)
+		return EntityDictionary{}, CodedError(errors.New("error loading entity dictionary"), http.StatusInternalServerError)
+	}
+	createdAt := version.CreatedAt.UTC()
+	return EntityDictionary{
+		ModelId:   version.ModelId,
+		Version:   version.Version,
+		Change:    version.Change,
+		Entries:   entries,
+		CreatedBy: &version.CreatedBy,
+		CreatedAt: &createdAt,
+	}, nil
+}
+
+// getEntityDictionary returns the given version of the dictionary of the
+// model, or the latest version if version is 0. A model without a dictionary
+// has an empty dictionary with version 0.
+func getEntityDictionary(db *gorm.DB, modelId uuid.UUID, version int) (EntityDictionary, error) {
+	query := db.Where("model_id = ?", modelId)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+
+	var versions []schema.EntityDictionaryVersion
+	if err := query.Order("version DESC").Limit(1).Find(&versions).Error; err != nil {
+		slog.Error("sql error retrieving entity dictionary", "model_id", modelId, "error", err)
+		return EntityDictionary{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(versions) == 0 {
+		if version > 0 {
+			return EntityDictionary{}, CodedError(fmt.Errorf("entity dictionary version %d does not exist", version), http.StatusNotFound)
+		}
+		return EntityDictionary{ModelId: modelId, Entries: []EntityDictionaryEntry{}}, nil
+	}
+	return newEntityDictionary(versions[0])
+}
+
+// updateEntityDictionary saves the entries returned by update as a new
+// version of the dictionary of the model.
+func (s *DeployService) updateEntityDictionary(modelId uuid.UUID, user schema.User, update func(txn *gorm.DB, current EntityDictionary) ([]EntityDictionaryEntry, string, error)) (EntityDictionary, error) {
+	var dictionary EntityDictionary
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+		if model.Type != schema.NlpTokenModel {
+			return CodedError(fmt.Errorf("entity dictionaries are only supported for %v models", schema.NlpTokenModel), http.StatusUnprocessableEntity)
+		}
+
+		current, err := getEntityDictionary(txn, modelId, 0)
+		if err != nil {
+			return err
+		}
+
+		entries, change, err := update(txn, current)
+		if err != nil {
+			return err
+		}
+		if len(entries) > maxEntityDictionaryEntries {
+			return CodedError(fmt.Errorf("entity dictionaries can have at most %d entries", maxEntityDictionaryEntries), http.StatusUnprocessableEntity)
+		}
+
+		entriesJson, err := json.Marshal(entries)
+		if err != nil {
+			return CodedError(fmt.Errorf("error serializing entity dictionary: %w", err), http.StatusInternalServerError)
+		}
+
+		version := schema.EntityDictionaryVersion{
+			ModelId:   modelId,
+			Version:   current.Version + 1,
+			Entries:   string(entriesJson),
+			Change:    change,
+			CreatedBy: user.Id,
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := txn.Create(&version).Error; err != nil {
+			slog.Error("sql error saving entity dictionary", "model_id", modelId, "version", version.Version, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		dictionary, err = newEntityDictionary(version)
+		return err
+	})
+	return dictionary, err
+}
+
+func (s *DeployService) GetEntityDictionary(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err = strconv.Atoi(v)
+		if err != nil || version < 1 {
+			http.Error(w, fmt.Sprintf("invalid version '%v'", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dictionary, err := getEntityDictionary(s.db, modelId, version)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving entity dictionary: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, dictionary)
+}
+
+// GetEntityDictionaryInternal returns the latest version of the dictionary to
+// the deployment, which polls it so that changes apply without redeploying.
+func (s *DeployService) GetEntityDictionaryInternal(w http.ResponseWriter, r *http.Request) {
+	modelId, err := auth.ModelIdFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dictionary, err := getEntityDictionary(s.db, modelId, 0)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving entity dictionary: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, dictionary)
+}
+
+func (s *DeployService) EntityDictionaryVersions(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var versions []schema.EntityDictionaryVersion
+	if err := s.db.Where("model_id = ?", modelId).Order("version DESC").Find(&versions).Error; err != nil {
+		slog.Error("sql error listing entity dictionary versions", "model_id", modelId, "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]EntityDictionaryVersionInfo, 0, len(versions))
+	for i, version := range versions {
+		dictionary, err := newEntityDictionary(version)
+		if err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+		infos = append(infos, EntityDictionaryVersionInfo{
+			Version:    version.Version,
+			Change:     version.Change,
+			NumEntries: len(dictionary.Entries),
+			CreatedBy:  version.CreatedBy,
+			CreatedAt:  version.CreatedAt.UTC(),
+			Current:    i == 0,
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type entityDictionaryEntryRequest struct {
+	Pattern       string `json:"pattern" validate:"required,max=500"`
+	Tag           string `json:"tag" validate:"required"`
+	Regex         bool   `json:"regex"`
+	CaseSensitive bool   `json:"case_sensitive"`
+}
+
+func (req *entityDictionaryEntryRequest) Validate() error {
+	errs := validation.Struct(req)
+	if req.Tag != "" && !entityTagRe.MatchString(req.Tag) {
+		errs.Add("tag", "tags must be at most 100 characters and only contain letters, numbers, '_', and '-'")
+	} else if req.Tag == "O" {
+		errs.Add("tag", "'O' is the tag for tokens that are not entities")
+	}
+	if req.Regex && req.Pattern != "" {
+		re, err := regexp.Compile(req.Pattern)
+		if err != nil {
+			errs.Add("pattern", "invalid regex: %v", err)
+		} else if re.MatchString("") {
+			errs.Add("pattern", "regex cannot match empty text")
+		}
+	}
+	return errs.Err()
+}
+
+func (req entityDictionaryEntryRequest) sameEntry(entry EntityDictionaryEntry) bool {
+	if req.Regex != entry.Regex || req.CaseSensitive != entry.CaseSensitive {
+		return false
+	}
+	if req.CaseSensitive || req.Regex {
+		return req.Pattern == entry.Pattern
+	}
+	return strings.EqualFold(req.Pattern, entry.Pattern)
+}
+
+type addEntityDictionaryEntriesRequest struct {
+	Entries []entityDictionaryEntryRequest `json:"entries" validate:"required"`
+}
+
+// AddEntityDictionaryEntries adds entries to the dictionary of the deployment.
+// Entries with the same pattern as an existing entry are rejected, so that it
+// is unambiguous which tag a pattern has.
+func (s *DeployService) AddEntityDictionaryEntries(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params addEntityDictionaryEntriesRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid entity dictionary entries: %v", err), err)
+		return
+	}
+
+	dictionary, err := s.updateEntityDictionary(modelId, user, func(txn *gorm.DB, current EntityDictionary) ([]EntityDictionaryEntry, string, error) {
+		entries := slices.Clone(current.Entries)
+		for i, req := range params.Entries {
+			for _, entry := range entries {
+				if req.sameEntry(entry) {
+					return nil, "", CodedError(fmt.Errorf("entries[%d]: the dictionary already has an entry for pattern '%v'", i, req.Pattern), http.StatusConflict)
+				}
+			}
+			entries = append(entries, EntityDictionaryEntry{
+				Id:            uuid.New(),
+				Pattern:       req.Pattern,
+				Tag:           req.Tag,
+				Regex:         req.Regex,
+				CaseSensitive: req.CaseSensitive,
+			})
+		}
+		return entries, fmt.Sprintf("added %d entries", len(params.Entries)), nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error adding entity dictionary entries: %v", err), err)
+		return
+	}
+
+	slog.Info("added entity dictionary entries", "model_id", modelId, "version", dictionary.Version, "entries", len(params.Entries), "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, dictionary)
+}
+
+func (s *DeployService) DeleteEntityDictionaryEntry(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entryId, err := utils.URLParamUUID(r, "entry_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dictionary, err := s.updateEntityDictionary(modelId, user, func(txn *gorm.DB, current EntityDictionary) ([]EntityDictionaryEntry, string, error) {
+		i := slices.IndexFunc(current.Entries, func(entry EntityDictionaryEntry) bool { return entry.Id == entryId })
+		if i < 0 {
+			return nil, "", CodedError(fmt.Errorf("entity dictionary entry %v does not exist", entryId), http.StatusNotFound)
+		}
+		entry := current.Entries[i]
+		return slices.Delete(slices.Clone(current.Entries), i, i+1), fmt.Sprintf("deleted entry '%v' with tag %v", entry.Pattern, entry.Tag), nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error deleting entity dictionary entry: %v", err), err)
+		return
+	}
+
+	slog.Info("deleted entity dictionary entry", "model_id", modelId, "version", dictionary.Version, "entry_id", entryId, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, dictionary)
+}
+
+type entityDictionaryRollbackRequest struct {
+	Version int `json:"version" validate:"required,min=1"`
+}
+
+// RollbackEntityDictionary creates a new version of the dictionary with the
+// entries of an earlier version, so that the history of changes is kept.
+func (s *DeployService) RollbackEntityDictionary(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params entityDictionaryRollbackRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid rollback request: %v", err), err)
+		return
+	}
+
+	dictionary, err := s.updateEntityDictionary(modelId, user, func(txn *gorm.DB, current EntityDictionary) ([]EntityDictionaryEntry, string, error) {
+		if params.Version >= current.Version {
+			return nil, "", CodedError(fmt.Errorf("can only roll back to a version before the current version %d", current.Version), http.StatusUnprocessableEntity)
+		}
+		target, err := getEntityDictionary(txn, modelId, params.Version)
+		if err != nil {
+			return nil, "", err
+		}
+		return target.Entries, fmt.Sprintf("rolled back to version %d", params.Version), nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error rolling back entity dictionary: %v", err), err)
+		return
+	}
+
+	slog.Info("rolled back entity dictionary", "model_id", modelId, "version", dictionary.Version, "to_version", params.Version, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, dictionary)
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type holdoutEvaluation struct {
+	Split      map[string]interface{} `json:"split"`
+	Metrics    map[string]float64     `json:"metrics"`
+	Thresholds map[string]float64     `json:"thresholds"`
+}
+
+type holdoutEvaluationResponse struct {
+	holdoutEvaluation
+	Passed        bool      `json:"passed"`
+	FailedMetrics []string  `json:"failed_metrics"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// failedMetrics returns the metrics which are below their threshold. Metrics
+// that have a threshold but were not reported are treated as failed.
+func (e *holdoutEvaluation) failedMetrics() []string {
+	failed := []string{}
+	for metric, threshold := range e.Thresholds {
+		if value, ok := e.Metrics[metric]; !ok || value < threshold {
+			failed = append(failed, metric)
+		}
+	}
+	slices.Sort(failed)
+	return failed
+}
+
+// trainEvalThresholds returns the eval thresholds from the saved train config
+// of the model. The thresholds are not taken from the train job, so that a job
+// cannot change the thresholds its model is evaluated against.
+func (s *TrainService) trainEvalThresholds(modelId uuid.UUID) (map[string]float64, error) {
+	file, err := s.storage.Read(filepath.Join(storage.ModelPath(modelId), "train_config.json"))
+	if err != nil {
+		slog.Error("error reading train config for holdout evaluation", "model_id", modelId, "error", err)
+		return nil, CodedError(errors.New("error reading train config"), http.StatusInternalServerError)
+	}
+	defer file.Close()
+
+	var trainConfig struct {
+		TrainOptions struct {
+			EvalThresholds map[string]float64 `json:"eval_thresholds"`
+		} `json:"train_options"`
+	}
+	if err := json.NewDecoder(file).Decode(&trainConfig); err != nil {
+		slog.Error("error parsing train config for holdout evaluation", "model_id", modelId, "error", err)
+		return nil, CodedError(errors.New("error parsing train config"), http.StatusInternalServerError)
+	}
+
+	return trainConfig.TrainOptions.EvalThresholds, nil
+}
+
+// UpdateEvaluation stores the holdout evaluation reported by the train job. The
+// metrics are checked against the eval thresholds the model was trained with,
+// any thresholds in the request are ignored.
+func (s *TrainService) UpdateEvaluation(w http.ResponseWriter, r *http.Request) {
+	modelId, err := auth.ModelIdFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params holdoutEvaluation
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	params.Thresholds, err = s.trainEvalThresholds(modelId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error saving holdout evaluation: %v", err), err)
+		return
+	}
+
+	split, err := json.Marshal(params.Split)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("split cannot be serialized to json: %v", err), http.StatusBadRequest)
+		return
+	}
+	metrics, err := json.Marshal(params.Metrics)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("metrics cannot be serialized to json: %v", err), http.StatusBadRequest)
+		return
+	}
+	thresholds, err := json.Marshal(params.Thresholds)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("thresholds cannot be serialized to json: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	failed := params.failedMetrics()
+
+	evaluation := schema.HoldoutEvaluation{
+		ModelId:    modelId,
+		Split:      string(split),
+		Metrics:    string(metrics),
+		Thresholds: string(thresholds),
+		Passed:     len(failed) == 0,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkModelExists(txn, modelId); err != nil {
+			return err
+		}
+
+		result := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&evaluation)
+		if result.Error != nil {
+			slog.Error("sql error saving holdout evaluation", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error saving holdout evaluation: %v", err), err)
+		return
+	}
+
+	if len(failed) > 0 {
+		slog.Warn("model did not meet holdout evaluation thresholds", "model_id", modelId, "failed_metrics", failed)
+	}
+
+	utils.WriteJsonResponse(w, holdoutEvaluationResponse{
+		holdoutEvaluation: params, Passed: evaluation.Passed, FailedMetrics: failed, CreatedAt: evaluation.CreatedAt.UTC(),
+	})
+}
+
+func getHoldoutEvaluation(db *gorm.DB, modelId uuid.UUID) (*holdoutEvaluationResponse, error) {
+	var evaluation schema.HoldoutEvaluation
+	if err := db.First(&evaluation, "model_id = ?", modelId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		slog.Error("sql error retrieving holdout evaluation", "model_id", modelId, "error", err)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	res := holdoutEvaluationResponse{Passed: evaluation.Passed, CreatedAt: evaluation.CreatedAt.UTC()}
+	err := errors.Join(
+		json.Unmarshal([]byte(evaluation.Split), &res.Split),
+		json.Unmarshal([]byte(evaluation.Metrics), &res.Metrics),
+		json.Unmarshal([]byte(evaluation.Thresholds), &res.Thresholds),
+	)
+	if err != nil {
+		slog.Error("error parsing holdout evaluation", "model_id", modelId, "error", err)
+		return nil, CodedError(errors.New("error parsing holdout evaluation"), http.StatusInternalServerError)
+	}
+	res.FailedMetrics = res.failedMetrics()
+
+	return &res, nil
+}
+
+func (s *TrainService) GetEvaluation(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	evaluation, err := getHoldoutEvaluation(s.db, modelId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving holdout evaluation: %v", err), err)
+		return
+	}
+	if evaluation == nil {
+		http.Error(w, fmt.Sprintf("no holdout evaluation found for model %v", modelId), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteJsonResponse(w, evaluation)
+}
+
+// checkHoldoutEvaluation returns an error if the model has a holdout evaluation
+// that did not meet its thresholds. Models without an evaluation are allowed.
+func checkHoldoutEvaluation(db *gorm.DB, modelId uuid.UUID) error {
+	evaluation, err := getHoldoutEvaluation(db, modelId)
+	if err != nil {
+		return err
+	}
+	if evaluation != nil && !evaluation.Passed {
+		return CodedError(fmt.Errorf("model %v did not meet the holdout evaluation thresholds for metrics: %v, set 'ignore_eval_thresholds' to deploy anyway", modelId, strings.Join(evaluation.FailedMetrics, ", ")), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
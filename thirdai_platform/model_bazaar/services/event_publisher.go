@@ -0,0 +1,165 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"thirdai_platform/model_bazaar/events"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventRelay forwards events from the platform event log to an external event
+// bus. Each event is marked as delivered once it is published, so events are
+// delivered at least once, even across restarts or outages of the event bus.
+// Replicas of model bazaar take a lock on the publisher's cursor row before
+// relaying, so only one replica relays at a time and events are published in
+// the order of their ids. An event whose transaction commits after events with
+// higher ids were published is published late rather than skipped.
+type EventRelay struct {
+	db        *gorm.DB
+	publisher events.Publisher
+
+	maxAttempts int
+	backoff     time.Duration
+
+	stop chan bool
+}
+
+func NewEventRelay(db *gorm.DB, publisher events.Publisher) *EventRelay {
+	return &EventRelay{
+		db:          db,
+		publisher:   publisher,
+		maxAttempts: 5,
+		backoff:     500 * time.Millisecond,
+		stop:        make(chan bool, 1),
+	}
+}
+
+var errRelayStopped = errors.New("event relay stopped")
+
+func (r *EventRelay) publishWithRetry(payload events.Payload) error {
+	var err error
+	backoff := r.backoff
+	for attempt := 1; attempt <= r.maxAttempts; attempt++ {
+		if err = r.publisher.Publish(payload); err == nil {
+			return nil
+		}
+		slog.Warn("event relay: publish failed", "publisher", r.publisher.Name(), "event_id", payload.Id, "attempt", attempt, "error", err)
+		if attempt < r.maxAttempts {
+			select {
+			case <-time.After(backoff):
+			case <-r.stop:
+				return errors.Join(errRelayStopped, err)
+			}
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+func newEventPayload(event schema.PlatformEvent) events.Payload {
+	var data json.RawMessage
+	if event.Data != "" {
+		data = json.RawMessage(event.Data)
+	}
+	return events.Payload{
+		SchemaVersion: events.SchemaVersion,
+		Id:            event.Id,
+		Type:          event.Type,
+		ModelId:       event.ModelId,
+		TeamId:        event.TeamId,
+		Data:          data,
+		Timestamp:     event.Timestamp.UTC(),
+	}
+}
+
+// relayBatch publishes the next batch of undelivered events and returns the
+// number of events that were delivered. It returns without publishing if
+// another replica is relaying events.
+func (r *EventRelay) relayBatch(limit int) (int, error) {
+	lock := schema.EventPublisherCursor{Publisher: r.publisher.Name()}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock).Error; err != nil {
+		slog.Error("sql error creating event publisher cursor", "publisher", r.publisher.Name(), "error", err)
+		return 0, schema.ErrDbAccessFailed
+	}
+
+	delivered := 0
+	var publishErr error
+
+	err := r.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("publisher = ?", r.publisher.Name()).Limit(1).Find(&lock)
+		if result.Error != nil {
+			slog.Error("sql error locking event publisher cursor", "publisher", r.publisher.Name(), "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		var batch []schema.PlatformEvent
+		result = txn.Where("delivered_at IS NULL").Order("id ASC").Limit(limit).Find(&batch)
+		if result.Error != nil {
+			slog.Error("sql error listing events to relay", "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+
+		for _, event := range batch {
+			if err := r.publishWithRetry(newEventPayload(event)); err != nil {
+				publishErr = errors.Join(errors.New("unable to publish event, will retry"), err)
+				break
+			}
+
+			result := txn.Model(&schema.PlatformEvent{}).Where("id = ?", event.Id).Update("delivered_at", time.Now().UTC())
+			if result.Error != nil {
+				slog.Error("sql error marking event as delivered", "event_id", event.Id, "error", result.Error)
+				return schema.ErrDbAccessFailed
+			}
+			lock.Cursor = event.Id
+			delivered++
+		}
+
+		if delivered > 0 {
+			if err := txn.Save(&lock).Error; err != nil {
+				slog.Error("sql error saving event publisher cursor", "publisher", r.publisher.Name(), "error", err)
+				return schema.ErrDbAccessFailed
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return delivered, publishErr
+}
+
+func (r *EventRelay) Run(interval time.Duration) {
+	slog.Info("event relay: starting", "publisher", r.publisher.Name())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.relayBatch(100); err != nil {
+				slog.Error("event relay: error relaying events", "publisher", r.publisher.Name(), "error", err)
+			}
+		case <-r.stop:
+			slog.Info("event relay: stopped", "publisher", r.publisher.Name())
+			if err := r.publisher.Close(); err != nil {
+				slog.Error("event relay: error closing publisher", "error", err)
+			}
+			return
+		}
+	}
+}
+
+func (r *EventRelay) Stop() {
+	close(r.stop)
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// recordEvent appends an event to the platform event log. It should be called
+// with the same transaction that performs the change so that the event is only
+// visible if the change is committed.
+func recordEvent(txn *gorm.DB, eventType string, modelId, teamId *uuid.UUID, data map[string]interface{}) error {
+	event := schema.PlatformEvent{
+		Type:      eventType,
+		ModelId:   modelId,
+		TeamId:    teamId,
+		Timestamp: time.Now().UTC(),
+	}
+
+	if data != nil {
+		dataJson, err := json.Marshal(data)
+		if err != nil {
+			slog.Error("error serializing event data", "event_type", eventType, "error", err)
+			return CodedError(fmt.Errorf("error serializing event data: %w", err), http.StatusInternalServerError)
+		}
+		event.Data = string(dataJson)
+	}
+
+	if result := txn.Create(&event); result.Error != nil {
+		slog.Error("sql error recording platform event", "event_type", eventType, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+func recordModelEvent(txn *gorm.DB, eventType string, model schema.Model, data map[string]interface{}) error {
+	return recordEvent(txn, eventType, &model.Id, model.TeamId, data)
+}
+
+func recordStatusEvent(txn *gorm.DB, job string, modelId uuid.UUID, from, status, message string) error {
+	eventType := schema.TrainStatusEvent
+	if job == "deploy" {
+		eventType = schema.DeployStatusEvent
+	}
+	data := map[string]interface{}{"status": status, "previous_status": from}
+	if message != "" {
+		data["message"] = message
+	}
+	return recordEvent(txn, eventType, &modelId, nil, data)
+}
+
+type EventService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *EventService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+
+	return r
+}
+
+type EventInfo struct {
+	Id         uint64          `json:"id"`
+	Type       string          `json:"type"`
+	ModelId    *uuid.UUID      `json:"model_id"`
+	TeamId     *uuid.UUID      `json:"team_id"`
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+
+	// Deprecated: the same as occurred_at, kept for older clients.
+	Timestamp time.Time `json:"timestamp" deprecated:"true"`
+}
+
+func newEventInfo(event schema.PlatformEvent) EventInfo {
+	var data json.RawMessage
+	if event.Data != "" {
+		data = json.RawMessage(event.Data)
+	}
+	return EventInfo{
+		Id:         event.Id,
+		Type:       event.Type,
+		ModelId:    event.ModelId,
+		TeamId:     event.TeamId,
+		Data:       data,
+		OccurredAt: event.Timestamp.UTC(),
+		Timestamp:  event.Timestamp.UTC(),
+	}
+}
+
+type EventsResponse struct {
+	Events []EventInfo `json:"events"`
+	// Cursor is the id of the last event returned, or the cursor from the request
+	// if no events were returned. It should be passed in the next request.
+	Cursor uint64 `json:"cursor"`
+}
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	maxEventWait      = 60 * time.Second
+	eventPollInterval = 500 * time.Millisecond
+
+	// eventCommitLag is how long a gap in the event ids is assumed to be an
+	// event whose transaction has not committed yet.
+	eventCommitLag = 30 * time.Second
+)
+
+func parseUintParam(r *http.Request, key string, defaultValue uint64) (uint64, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%v' for query param '%v': must be a non negative integer", value, key)
+	}
+	return parsed, nil
+}
+
+func (s *EventService) queryEvents(cursor uint64, limit int) ([]schema.PlatformEvent, error) {
+	var events []schema.PlatformEvent
+	result := s.db.Where("id > ?", cursor).Order("id ASC").Limit(limit).Find(&events)
+	if result.Error != nil {
+		slog.Error("sql error listing platform events", "cursor", cursor, "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return committedEvents(cursor, events, time.Now().UTC()), nil
+}
+
+// committedEvents returns the events up to the first gap in the ids that is
+// newer than eventCommitLag. Ids are assigned when an event is recorded, not
+// when its transaction commits, so an event with a lower id can become visible
+// after events with higher ids. Stopping at the gap keeps the cursor before the
+// missing id so that the event is returned by a later request once it commits.
+// Gaps older than eventCommitLag are from transactions that were rolled back,
+// or events that were deleted, and are skipped.
+func committedEvents(cursor uint64, events []schema.PlatformEvent, now time.Time) []schema.PlatformEvent {
+	last := cursor
+	for i, event := range events {
+		if event.Id != last+1 && now.Sub(event.Timestamp) < eventCommitLag {
+			return events[:i]
+		}
+		last = event.Id
+	}
+	return events
+}
+
+// List returns the events after the given cursor in the order they were
+// recorded. Recent events are held back while an event with a lower id may
+// still be committing, so no event is skipped by a client following the
+// cursor. If wait is specified and there are no new events the request is held
+// open (long-poll) until an event is recorded or the wait expires.
+func (s *EventService) List(w http.ResponseWriter, r *http.Request) {
+	cursor, err := parseUintParam(r, "cursor", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseUintParam(r, "limit", defaultEventLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 || limit > maxEventLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxEventLimit), http.StatusBadRequest)
+		return
+	}
+
+	waitSeconds, err := parseUintParam(r, "wait", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wait := time.Duration(min(waitSeconds, uint64(maxEventWait/time.Second))) * time.Second
+
+	events, err := s.queryEvents(cursor, int(limit))
+	if err != nil {
+		writeError(w, fmt.Sprintf("error listing events: %v", err), err)
+		return
+	}
+
+	if len(events) == 0 && wait > 0 {
+		ticker := time.NewTicker(eventPollInterval)
+		defer ticker.Stop()
+		timeout := time.After(wait)
+
+	poll:
+		for len(events) == 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timeout:
+				break poll
+			case <-ticker.C:
+				events, err = s.queryEvents(cursor, int(limit))
+				if err != nil {
+					writeError(w, fmt.Sprintf("error listing events: %v", err), err)
+					return
+				}
+			}
+		}
+	}
+
+	res := EventsResponse{Events: make([]EventInfo, 0, len(events)), Cursor: cursor}
+	for _, event := range events {
+		res.Events = append(res.Events, newEventInfo(event))
+		res.Cursor = event.Id
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+// cleanupExpiredAuditEvents deletes events and audit log entries older than the
+// audit retention.
+// Nothing is deleted while there is an active legal hold on audit data, and
+// the events of models under legal hold are kept until the hold is released.
+func (m *ModelBazaar) cleanupExpiredAuditEvents() {
+	if m.auditRetention <= 0 {
+		return
+	}
+
+	held, err := auditUnderHold(m.db)
+	if err != nil || held {
+		return
+	}
+
+	expiry := time.Now().UTC().Add(-m.auditRetention)
+
+	result := m.db.
+		Where("timestamp < ?", expiry).
+		Where("model_id IS NULL OR model_id NOT IN (?)", heldModelIds(m.db)).
+		Delete(&schema.PlatformEvent{})
+	if result.Error != nil {
+		slog.Error("audit retention: sql error deleting expired events", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		slog.Info("audit retention: deleted expired events", "count", result.RowsAffected)
+	}
+
+	result = m.db.
+		Where("timestamp < ?", expiry).
+		Where("model_id IS NULL OR model_id NOT IN (?)", heldModelIds(m.db)).
+		Delete(&schema.AuditLogEntry{})
+	if result.Error != nil {
+		slog.Error("audit retention: sql error deleting expired audit log entries", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		slog.Info("audit retention: deleted expired audit log entries", "count", result.RowsAffected)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// The deployment runs the onnx file of the model with onnxruntime.
+	ExternalRuntimeOnnx = "onnx"
+	// The deployment runs the image of the model next to it, and forwards
+	// requests to the inference server in the image.
+	ExternalRuntimeContainer = "container"
+)
+
+// The file that onnx external models are loaded from, in the model directory.
+const externalOnnxFile = "model.onnx"
+
+const defaultExternalPort = 8080
+
+// Image references with a registry, repository, and a tag or digest. This is
+// stricter than docker allows so that the image can be safely templated into
+// job specs.
+var externalImageRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9._:/-]*[a-z0-9])?(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}|@sha256:[a-f0-9]{64})?$`)
+
+// externalModelSpec is the declaration of an external model, which is given in
+// the attributes of the model's metadata when the model is uploaded. The
+// request and response schemas are json schemas for the bodies of predict
+// requests and responses, which the deployment validates.
+type externalModelSpec struct {
+	Runtime        string
+	Image          string
+	Port           int
+	RequestSchema  map[string]interface{}
+	ResponseSchema map[string]interface{}
+}
+
+func parseJsonSchema(attrs map[string]string, key string) (map[string]interface{}, error) {
+	raw, ok := attrs[key]
+	if !ok {
+		return nil, fmt.Errorf("external models must declare a %v", key)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		return nil, fmt.Errorf("%v must be a json schema object: %w", key, err)
+	}
+	return schema, nil
+}
+
+// parseExternalModelSpec parses the external model declaration from the model
+// attributes.
+func parseExternalModelSpec(attrs map[string]string) (externalModelSpec, error) {
+	spec := externalModelSpec{Runtime: attrs["runtime"]}
+
+	switch spec.Runtime {
+	case ExternalRuntimeOnnx:
+		if _, ok := attrs["image"]; ok {
+			return externalModelSpec{}, errors.New("image can only be specified for external models with the container runtime")
+		}
+	case ExternalRuntimeContainer:
+		spec.Image = attrs["image"]
+		if !externalImageRe.MatchString(spec.Image) || strings.Contains(spec.Image, "//") {
+			return externalModelSpec{}, fmt.Errorf("invalid image '%v' for external model, must be an image reference like registry/repository:tag", spec.Image)
+		}
+
+		spec.Port = defaultExternalPort
+		if port, ok := attrs["port"]; ok {
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 || p == 80 {
+				return externalModelSpec{}, fmt.Errorf("invalid port '%v' for external model, must be between 1 and 65535 and not 80", port)
+			}
+			spec.Port = p
+		}
+	default:
+		return externalModelSpec{}, fmt.Errorf("invalid runtime '%v' for external model, must be '%v' or '%v'", spec.Runtime, ExternalRuntimeOnnx, ExternalRuntimeContainer)
+	}
+
+	var err error
+	if spec.RequestSchema, err = parseJsonSchema(attrs, "request_schema"); err != nil {
+		return externalModelSpec{}, err
+	}
+	if spec.ResponseSchema, err = parseJsonSchema(attrs, "response_schema"); err != nil {
+		return externalModelSpec{}, err
+	}
+
+	return spec, nil
+}
+
+// validateExternalModel checks the declaration of an uploaded external model,
+// and that an onnx model contains the onnx file.
+func validateExternalModel(store storage.Storage, modelId uuid.UUID, attrs map[string]string) error {
+	spec, err := parseExternalModelSpec(attrs)
+	if err != nil {
+		return CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	if spec.Runtime == ExternalRuntimeOnnx {
+		exists, err := store.Exists(filepath.Join(storage.ModelPath(modelId), "model", externalOnnxFile))
+		if err != nil {
+			return CodedError(fmt.Errorf("error checking for onnx file: %w", err), http.StatusInternalServerError)
+		}
+		if !exists {
+			return CodedError(fmt.Errorf("external models with the onnx runtime must contain a %v file", externalOnnxFile), http.StatusUnprocessableEntity)
+		}
+	}
+
+	return nil
+}
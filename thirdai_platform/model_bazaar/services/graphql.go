@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/graphql"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type GraphQLService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *GraphQLService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+
+	r.Post("/", s.Query)
+
+	return r
+}
+
+type GraphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+func (s *GraphQLService) Query(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params GraphQLRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.Query == "" {
+		http.Error(w, "query must be specified", http.StatusBadRequest)
+		return
+	}
+
+	teamIds, err := schema.GetUserTeamIds(user.Id, s.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error loading user teams: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resolver := &graphqlResolver{db: s.db, user: user, teamIds: teamIds}
+
+	res := graphql.Execute(r.Context(), resolver.queryType(), params.Query, params.Variables)
+
+	utils.WriteJsonResponse(w, res)
+}
+
+// graphqlResolver is created per request so that every resolver applies the
+// same visibility rules as the REST list endpoints for the requesting user.
+type graphqlResolver struct {
+	db      *gorm.DB
+	user    schema.User
+	teamIds []uuid.UUID
+	// The organizations of the owners of the models checked by canReadModel.
+	ownerOrgs map[uuid.UUID]*uuid.UUID
+	// The users checked by canReadUser.
+	visibleUsers map[uuid.UUID]bool
+}
+
+type graphqlTeamMember struct {
+	user        schema.User
+	team        schema.Team
+	isTeamAdmin bool
+}
+
+func (g *graphqlResolver) ownerOrganization(userId uuid.UUID) (*uuid.UUID, error) {
+	if orgId, ok := g.ownerOrgs[userId]; ok {
+		return orgId, nil
+	}
+	owner, err := schema.GetUser(userId, g.db)
+	if err != nil {
+		return nil, err
+	}
+	if g.ownerOrgs == nil {
+		g.ownerOrgs = make(map[uuid.UUID]*uuid.UUID)
+	}
+	g.ownerOrgs[userId] = owner.OrganizationId
+	return owner.OrganizationId, nil
+}
+
+func (g *graphqlResolver) canReadModel(model schema.Model) bool {
+	if g.user.IsAdmin || model.UserId == g.user.Id {
+		return true
+	}
+
+	orgId, err := g.ownerOrganization(model.UserId)
+	if err != nil || !schema.SameOrganization(orgId, g.user.OrganizationId) {
+		return false
+	}
+	if g.user.IsOrgAdmin && g.user.OrganizationId != nil {
+		return true
+	}
+
+	if model.Access == schema.Public {
+		return true
+	}
+	return model.Access == schema.Protected && model.TeamId != nil && slices.Contains(g.teamIds, *model.TeamId)
+}
+
+func (g *graphqlResolver) canReadTeam(teamId uuid.UUID) bool {
+	return g.user.IsAdmin || slices.Contains(g.teamIds, teamId)
+}
+
+// canReadUser applies the visibility rules of the REST user list: admins can
+// see every user, org admins can see the users in their organization, and other
+// users can see themselves and the members of their teams. Only the id and
+// username of other users are returned.
+func (g *graphqlResolver) canReadUser(user schema.User) bool {
+	if g.user.IsAdmin || user.Id == g.user.Id {
+		return true
+	}
+	if g.user.IsOrgAdmin && g.user.OrganizationId != nil && user.OrganizationId != nil && *user.OrganizationId == *g.user.OrganizationId {
+		return true
+	}
+
+	if visible, ok := g.visibleUsers[user.Id]; ok {
+		return visible
+	}
+	teamIds, err := schema.GetUserTeamIds(user.Id, g.db)
+	if err != nil {
+		return false
+	}
+	visible := slices.ContainsFunc(teamIds, func(teamId uuid.UUID) bool { return slices.Contains(g.teamIds, teamId) })
+	if g.visibleUsers == nil {
+		g.visibleUsers = make(map[uuid.UUID]bool)
+	}
+	g.visibleUsers[user.Id] = visible
+	return visible
+}
+
+func (g *graphqlResolver) filterModels(models []schema.Model) []schema.Model {
+	visible := make([]schema.Model, 0, len(models))
+	for _, model := range models {
+		if g.canReadModel(model) {
+			visible = append(visible, model)
+		}
+	}
+	return visible
+}
+
+func stringArg(args map[string]interface{}, name string) (string, bool, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", false, fmt.Errorf("argument '%v' must be a string", name)
+	}
+	return str, true, nil
+}
+
+func uuidArg(args map[string]interface{}, name string) (uuid.UUID, error) {
+	str, ok, err := stringArg(args, name)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		return uuid.Nil, fmt.Errorf("argument '%v' is required", name)
+	}
+	id, err := uuid.Parse(str)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("argument '%v' is not a valid uuid: %w", name, err)
+	}
+	return id, nil
+}
+
+func graphqlDbError(msg string, err error) error {
+	slog.Error("sql error in graphql "+msg, "error", err)
+	return fmt.Errorf("error loading %v: %w", msg, schema.ErrDbAccessFailed)
+}
+
+func (g *graphqlResolver) queryType() *graphql.Object {
+	jobLogType := graphql.NewObject("JobLog").
+		Field("id", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.JobLog).Id, nil
+		}).
+		Field("job", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.JobLog).Job, nil
+		}).
+		Field("level", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.JobLog).Level, nil
+		}).
+		Field("message", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.JobLog).Message, nil
+		})
+
+	attributeType := graphql.NewObject("ModelAttribute").
+		Field("key", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.ModelAttribute).Key, nil
+		}).
+		Field("value", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.ModelAttribute).Value, nil
+		})
+
+	// The types reference each other, so they are created first and their
+	// fields are filled in afterwards.
+	modelType := graphql.NewObject("Model")
+	userType := graphql.NewObject("User")
+	teamType := graphql.NewObject("Team")
+	memberType := graphql.NewObject("TeamMember")
+
+	modelType.
+		Field("id", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).Id, nil
+		}).
+		Field("name", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).Name, nil
+		}).
+		Field("type", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).Type, nil
+		}).
+		Field("access", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).Access, nil
+		}).
+		Field("defaultPermission", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).DefaultPermission, nil
+		}).
+		Field("trainStatus", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).TrainStatus, nil
+		}).
+		Field("deployStatus", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).DeployStatus, nil
+		}).
+		Field("publishedDate", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Model).PublishedDate.UTC().Format(time.RFC3339), nil
+		}).
+		Field("attributes", attributeType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			var attrs []schema.ModelAttribute
+			if result := g.db.Where("model_id = ?", src.(schema.Model).Id).Find(&attrs); result.Error != nil {
+				return nil, graphqlDbError("model attributes", result.Error)
+			}
+			return attrs, nil
+		}).
+		Field("dependencies", modelType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			var deps []schema.Model
+			result := g.db.
+				Joins("JOIN model_dependencies ON model_dependencies.dependency_id = models.id").
+				Where("model_dependencies.model_id = ?", src.(schema.Model).Id).
+				Find(&deps)
+			if result.Error != nil {
+				return nil, graphqlDbError("model dependencies", result.Error)
+			}
+			return g.filterModels(deps), nil
+		}).
+		Field("usedBy", modelType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			var users []schema.Model
+			result := g.db.
+				Joins("JOIN model_dependencies ON model_dependencies.model_id = models.id").
+				Where("model_dependencies.dependency_id = ?", src.(schema.Model).Id).
+				Find(&users)
+			if result.Error != nil {
+				return nil, graphqlDbError("model dependents", result.Error)
+			}
+			return g.filterModels(users), nil
+		}).
+		Field("baseModel", modelType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			model := src.(schema.Model)
+			if model.BaseModelId == nil {
+				return nil, nil
+			}
+			base, err := schema.GetModel(*model.BaseModelId, g.db, false, false, false)
+			if err != nil {
+				if errors.Is(err, schema.ErrModelNotFound) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			if !g.canReadModel(base) {
+				return nil, nil
+			}
+			return base, nil
+		}).
+		Field("owner", userType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return schema.GetUser(src.(schema.Model).UserId, g.db)
+		}).
+		Field("team", teamType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			model := src.(schema.Model)
+			if model.TeamId == nil || !g.canReadTeam(*model.TeamId) {
+				return nil, nil
+			}
+			return schema.GetTeam(*model.TeamId, g.db)
+		}).
+		Field("jobLogs", jobLogType, func(_ context.Context, src interface{}, args map[string]interface{}) (interface{}, error) {
+			query := g.db.Where("model_id = ?", src.(schema.Model).Id)
+			job, ok, err := stringArg(args, "job")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				query = query.Where("job = ?", job)
+			}
+			var logs []schema.JobLog
+			if result := query.Find(&logs); result.Error != nil {
+				return nil, graphqlDbError("job logs", result.Error)
+			}
+			return logs, nil
+		})
+
+	userType.
+		Field("id", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.User).Id, nil
+		}).
+		Field("username", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.User).Username, nil
+		}).
+		Field("email", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			user := src.(schema.User)
+			if !g.canReadUser(user) {
+				return nil, nil
+			}
+			return user.Email, nil
+		}).
+		Field("isAdmin", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.User).IsAdmin, nil
+		}).
+		Field("teams", memberType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			user := src.(schema.User)
+			var userTeams []schema.UserTeam
+			if result := g.db.Preload("Team").Where("user_id = ?", user.Id).Find(&userTeams); result.Error != nil {
+				return nil, graphqlDbError("user teams", result.Error)
+			}
+			members := make([]graphqlTeamMember, 0, len(userTeams))
+			for _, ut := range userTeams {
+				if ut.Team != nil && g.canReadTeam(ut.TeamId) {
+					members = append(members, graphqlTeamMember{user: user, team: *ut.Team, isTeamAdmin: ut.IsTeamAdmin})
+				}
+			}
+			return members, nil
+		}).
+		Field("models", modelType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			var models []schema.Model
+			if result := g.db.Where("user_id = ?", src.(schema.User).Id).Find(&models); result.Error != nil {
+				return nil, graphqlDbError("user models", result.Error)
+			}
+			return g.filterModels(models), nil
+		})
+
+	teamType.
+		Field("id", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Team).Id, nil
+		}).
+		Field("name", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(schema.Team).Name, nil
+		}).
+		Field("members", memberType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			team := src.(schema.Team)
+			var userTeams []schema.UserTeam
+			if result := g.db.Preload("User").Where("team_id = ?", team.Id).Find(&userTeams); result.Error != nil {
+				return nil, graphqlDbError("team members", result.Error)
+			}
+			members := make([]graphqlTeamMember, 0, len(userTeams))
+			for _, ut := range userTeams {
+				if ut.User != nil {
+					members = append(members, graphqlTeamMember{user: *ut.User, team: team, isTeamAdmin: ut.IsTeamAdmin})
+				}
+			}
+			return members, nil
+		}).
+		Field("models", modelType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			var models []schema.Model
+			if result := g.db.Where("team_id = ?", src.(schema.Team).Id).Find(&models); result.Error != nil {
+				return nil, graphqlDbError("team models", result.Error)
+			}
+			return g.filterModels(models), nil
+		})
+
+	memberType.
+		Field("user", userType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(graphqlTeamMember).user, nil
+		}).
+		Field("team", teamType, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(graphqlTeamMember).team, nil
+		}).
+		Field("isTeamAdmin", nil, func(_ context.Context, src interface{}, _ map[string]interface{}) (interface{}, error) {
+			return src.(graphqlTeamMember).isTeamAdmin, nil
+		})
+
+	return graphql.NewObject("Query").
+		Field("me", userType, func(_ context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			return g.user, nil
+		}).
+		Field("model", modelType, func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			modelId, err := uuidArg(args, "id")
+			if err != nil {
+				return nil, err
+			}
+			model, err := schema.GetModel(modelId, g.db, false, false, false)
+			if err != nil {
+				return nil, err
+			}
+			if !g.canReadModel(model) {
+				return nil, schema.ErrModelNotFound
+			}
+			return model, nil
+		}).
+		Field("models", modelType, func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			query, err := accessibleModels(g.db.Model(&schema.Model{}), g.db, g.user)
+			if err != nil {
+				return nil, err
+			}
+			for _, filter := range []string{"type", "access", "name"} {
+				value, ok, err := stringArg(args, filter)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					query = query.Where(filter+" = ?", value)
+				}
+			}
+			var models []schema.Model
+			if result := query.Find(&models); result.Error != nil {
+				return nil, graphqlDbError("models", result.Error)
+			}
+			return models, nil
+		}).
+		Field("team", teamType, func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			teamId, err := uuidArg(args, "id")
+			if err != nil {
+				return nil, err
+			}
+			if !g.canReadTeam(teamId) {
+				return nil, schema.ErrTeamNotFound
+			}
+			return schema.GetTeam(teamId, g.db)
+		}).
+		Field("teams", teamType, func(_ context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			var teams []schema.Team
+			query := g.db
+			if !g.user.IsAdmin {
+				query = query.Where("id IN ?", g.teamIds)
+			}
+			if result := query.Find(&teams); result.Error != nil {
+				return nil, graphqlDbError("teams", result.Error)
+			}
+			return teams, nil
+		}).
+		Field("users", userType, func(_ context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			var users []schema.User
+			if g.user.IsAdmin {
+				if result := g.db.Find(&users); result.Error != nil {
+					return nil, graphqlDbError("users", result.Error)
+				}
+				return users, nil
+			}
+			query := g.db.
+				Where("id = ?", g.user.Id).
+				Or("id IN (?)", g.db.Table("user_teams").Select("user_id").Where("team_id IN ?", g.teamIds))
+			if g.user.IsOrgAdmin && g.user.OrganizationId != nil {
+				query = query.Or("organization_id = ?", *g.user.OrganizationId)
+			}
+			result := query.Find(&users)
+			if result.Error != nil {
+				return nil, graphqlDbError("users", result.Error)
+			}
+			return users, nil
+		})
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The time allowed for each readiness check, which should be less than the
+// timeout of the probe so that the response can report which check is slow.
+const readinessCheckTimeout = 3 * time.Second
+
+type ReadinessCheck struct {
+	Name      string `json:"name"`
+	Ok        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type ReadinessResponse struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving requests. It does not
+// check any dependencies, so that a liveness probe does not restart model
+// bazaar when the database or orchestrator is down.
+func (m *ModelBazaar) Healthz(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether model bazaar can serve requests, by checking that the
+// database and orchestrator are reachable, that storage is writable, and that
+// the license is valid. It returns 503 if any check fails.
+func (m *ModelBazaar) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"database", m.checkDb},
+		{"orchestrator", m.checkOrchestrator},
+		{"storage", m.checkStorage},
+		{"license", m.checkLicense},
+	}
+
+	res := ReadinessResponse{Ready: true, Checks: make([]ReadinessCheck, len(checks))}
+
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := runReadinessCheck(r.Context(), c.check)
+			res.Checks[i] = ReadinessCheck{Name: c.name, Ok: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				res.Checks[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range res.Checks {
+		res.Ready = res.Ready && check.Ok
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !res.Ready {
+		utils.WriteJsonResponseWithStatus(w, res, http.StatusServiceUnavailable)
+		return
+	}
+	utils.WriteJsonResponse(w, res)
+}
+
+// runReadinessCheck returns an error if the check does not finish within the
+// timeout. The orchestrator and storage clients do not take a context, so the
+// check keeps running in the background in that case.
+func runReadinessCheck(ctx context.Context, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %v", readinessCheckTimeout)
+	}
+}
+
+func (m *ModelBazaar) checkDb(ctx context.Context) error {
+	db, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+func (m *ModelBazaar) checkOrchestrator(ctx context.Context) error {
+	if m.orchestratorClient == nil {
+		return errors.New("no orchestrator is configured")
+	}
+	_, err := m.orchestratorClient.TotalCpuUsage()
+	return err
+}
+
+func (m *ModelBazaar) checkStorage(ctx context.Context) error {
+	// Each check uses a new file so that checks from several instances of model
+	// bazaar do not interfere.
+	path := filepath.Join(".readyz", uuid.NewString())
+	if err := m.storage.Write(path, bytes.NewReader([]byte("ok"))); err != nil {
+		return fmt.Errorf("storage is not writable: %w", err)
+	}
+	if err := m.storage.Delete(path); err != nil {
+		return fmt.Errorf("unable to delete readiness check file: %w", err)
+	}
+	return nil
+}
+
+func (m *ModelBazaar) checkLicense(ctx context.Context) error {
+	// Only the signature and expiry of the license are checked, the cpu limit
+	// is enforced when jobs are started.
+	_, err := m.license.Verify(0)
+	return err
+}
@@ -0,0 +1,602 @@
+package services
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/reports"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	HealthReportKind = "health"
+
+	HealthReportSent    = "sent"
+	HealthReportSending = "sending"
+	HealthReportFailed  = "failed"
+
+	healthReportPeriod      = 7 * 24 * time.Hour
+	healthReportTrendWeeks  = 8
+	healthReportTopN        = 10
+	healthReportMetricsWait = 10 * time.Second
+)
+
+// The metrics recorded by each type of deployment for the requests it serves.
+const deploymentTrafficMetrics = "ndb_query_count|udt_predict_count|enterprise_search_query_count|ensemble_predict_count|external_predict_count"
+
+// healthReportWeek returns the start of the week containing t, weeks start on
+// Monday at midnight UTC.
+func healthReportWeek(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+type storageSnapshot struct {
+	// Known is false if the storage does not have a fixed capacity, for example
+	// s3 without a configured capacity.
+	Known      bool
+	UsedBytes  int64
+	TotalBytes int64
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit && bytes > -unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exp := float64(bytes), 0
+	for math.Abs(value) >= unit && exp < 5 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp-1])
+}
+
+func percent(part, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(part)/float64(total))
+}
+
+func (s *ReportService) jobSuccessSection(start, end time.Time) (reports.Section, error) {
+	var runs []schema.JobRun
+	if err := s.db.Where("ended_at >= ? AND ended_at < ?", start, end).Find(&runs).Error; err != nil {
+		slog.Error("sql error listing job runs for health report", "error", err)
+		return reports.Section{}, schema.ErrDbAccessFailed
+	}
+
+	type jobCounts struct{ total, complete, failed int64 }
+	counts := map[string]*jobCounts{}
+	for _, run := range runs {
+		if counts[run.Job] == nil {
+			counts[run.Job] = &jobCounts{}
+		}
+		counts[run.Job].total++
+		switch run.Status {
+		case schema.Complete:
+			counts[run.Job].complete++
+		case schema.Failed:
+			counts[run.Job].failed++
+		}
+	}
+
+	jobs := make([]string, 0, len(counts))
+	for job := range counts {
+		jobs = append(jobs, job)
+	}
+	slices.Sort(jobs)
+
+	table := &reports.Table{Columns: []string{"Job", "Runs", "Completed", "Failed", "Success Rate"}}
+	var total, complete int64
+	for _, job := range jobs {
+		c := counts[job]
+		total += c.total
+		complete += c.complete
+		table.Rows = append(table.Rows, []string{
+			job, strconv.FormatInt(c.total, 10), strconv.FormatInt(c.complete, 10), strconv.FormatInt(c.failed, 10), percent(c.complete, c.total),
+		})
+	}
+
+	summary := fmt.Sprintf("%d jobs finished during the week, %v completed successfully.", total, percent(complete, total))
+	return reports.Section{Heading: "Job Success Rates", Paragraphs: []string{summary}, Table: table}, nil
+}
+
+func (s *ReportService) storageUsage() (storageSnapshot, error) {
+	usage, err := s.storage.Usage()
+	if err != nil {
+		return storageSnapshot{}, err
+	}
+	if usage.TotalBytes == math.MaxUint64 || usage.TotalBytes > math.MaxInt64 {
+		return storageSnapshot{}, nil
+	}
+	return storageSnapshot{
+		Known:      true,
+		UsedBytes:  int64(usage.TotalBytes - usage.FreeBytes),
+		TotalBytes: int64(usage.TotalBytes),
+	}, nil
+}
+
+func (s *ReportService) storageSection(week time.Time, current storageSnapshot) (reports.Section, error) {
+	section := reports.Section{Heading: "Storage"}
+	if !current.Known {
+		section.Paragraphs = []string{"The storage does not have a fixed capacity, so its usage is not tracked."}
+		return section, nil
+	}
+
+	section.Paragraphs = []string{
+		fmt.Sprintf("%v of %v is used (%v).", formatBytes(current.UsedBytes), formatBytes(current.TotalBytes), percent(current.UsedBytes, current.TotalBytes)),
+	}
+
+	var history []schema.HealthReportRun
+	err := s.db.
+		Where("week_start < ? AND storage_total_bytes > 0", week).
+		Order("week_start DESC").
+		Limit(healthReportTrendWeeks - 1).
+		Find(&history).Error
+	if err != nil {
+		slog.Error("sql error listing previous health reports", "error", err)
+		return reports.Section{}, schema.ErrDbAccessFailed
+	}
+	slices.Reverse(history)
+	history = append(history, schema.HealthReportRun{WeekStart: week, StorageUsedBytes: current.UsedBytes, StorageTotalBytes: current.TotalBytes})
+
+	if len(history) > 1 {
+		change := current.UsedBytes - history[len(history)-2].StorageUsedBytes
+		section.Paragraphs = append(section.Paragraphs, fmt.Sprintf("Usage changed by %v since the previous report.", formatBytes(change)))
+	}
+
+	section.Table = &reports.Table{Columns: []string{"Week Of", "Used", "Capacity", "Change"}}
+	for i, run := range history {
+		change := ""
+		if i > 0 {
+			change = formatBytes(run.StorageUsedBytes - history[i-1].StorageUsedBytes)
+		}
+		section.Table.Rows = append(section.Table.Rows, []string{
+			run.WeekStart.UTC().Format("2006-01-02"), formatBytes(run.StorageUsedBytes), formatBytes(run.StorageTotalBytes), change,
+		})
+	}
+
+	return section, nil
+}
+
+func (s *ReportService) licenseSection(now time.Time) reports.Section {
+	section := reports.Section{Heading: "License"}
+
+	license, err := s.license.Verify(0)
+	if err != nil {
+		section.Paragraphs = []string{fmt.Sprintf("The license could not be verified: %v.", err)}
+		return section
+	}
+
+	table := &reports.Table{Columns: []string{"Field", "Value"}}
+
+	if expiry, err := license.Expiry(); err == nil {
+		days := int(expiry.Sub(now).Hours() / 24)
+		table.Rows = append(table.Rows, []string{"Expires", expiry.UTC().Format("2006-01-02")}, []string{"Days Until Expiry", strconv.Itoa(days)})
+	}
+
+	limit, err := strconv.ParseInt(license.CpuMhzLimit, 10, 64)
+	if err != nil {
+		section.Paragraphs = []string{fmt.Sprintf("The license has an invalid cpu limit '%v'.", license.CpuMhzLimit)}
+	} else {
+		table.Rows = append(table.Rows, []string{"CPU Limit (MHz)", strconv.FormatInt(limit, 10)})
+		if usage, err := s.orchestratorClient.TotalCpuUsage(); err != nil {
+			slog.Error("error getting cpu usage for health report", "error", err)
+			section.Paragraphs = []string{"The current cpu usage could not be retrieved from the orchestrator."}
+		} else {
+			headroom := limit - int64(usage)
+			table.Rows = append(table.Rows,
+				[]string{"CPU In Use (MHz)", strconv.Itoa(usage)},
+				[]string{"CPU Headroom (MHz)", strconv.FormatInt(headroom, 10)},
+			)
+			section.Paragraphs = []string{fmt.Sprintf("%v of the licensed cpu capacity is available.", percent(headroom, limit))}
+		}
+	}
+
+	section.Table = table
+	return section
+}
+
+type metricsQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			// The value is a pair of the timestamp and the value as a string.
+			Value []interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+type deploymentTraffic struct {
+	ModelId  string
+	Requests float64
+}
+
+// queryMetricsByModel evaluates an instant query on the metrics server and
+// returns the value of each series by its model_id label. Series without a
+// value, such as ratios with no requests, are skipped.
+func queryMetricsByModel(endpoint, query string, at time.Time) (map[string]float64, error) {
+	params := url.Values{"query": {query}, "time": {strconv.FormatInt(at.Unix(), 10)}}
+
+	client := http.Client{Timeout: healthReportMetricsWait}
+	res, err := client.Get(endpoint + "/api/v1/query?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error querying metrics: %w", err)
+	}
+	defer res.Body.Close()
+
+	var result metricsQueryResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing metrics response (status %d): %w", res.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("metrics query failed: %v", result.Error)
+	}
+
+	values := make(map[string]float64, len(result.Data.Result))
+	for _, series := range result.Data.Result {
+		if len(series.Value) != 2 {
+			continue
+		}
+		value, ok := series.Value[1].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			continue
+		}
+		values[series.Metric["model_id"]] = parsed
+	}
+
+	return values, nil
+}
+
+// topDeployments returns the deployments that served the most requests in the
+// week before end, using the metrics scraped from deployments.
+func (s *ReportService) topDeployments(end time.Time) ([]deploymentTraffic, error) {
+	query := fmt.Sprintf(
+		`topk(%d, sum by (model_id) (increase({__name__=~"%s"}[%dh])))`,
+		healthReportTopN, deploymentTrafficMetrics, int(healthReportPeriod.Hours()),
+	)
+
+	requests, err := queryMetricsByModel(s.variables.metricsEndpoint(), query, end)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make([]deploymentTraffic, 0, len(requests))
+	for modelId, count := range requests {
+		traffic = append(traffic, deploymentTraffic{ModelId: modelId, Requests: count})
+	}
+
+	slices.SortStableFunc(traffic, func(a, b deploymentTraffic) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), strings.Compare(a.ModelId, b.ModelId))
+	})
+
+	return traffic, nil
+}
+
+func (s *ReportService) trafficSection(end time.Time) (reports.Section, error) {
+	section := reports.Section{Heading: "Top Deployments by Traffic"}
+
+	traffic, err := s.topDeployments(end)
+	if err != nil {
+		slog.Error("error getting deployment traffic for health report", "error", err)
+		section.Paragraphs = []string{"Deployment traffic could not be retrieved from the metrics server."}
+		return section, nil
+	}
+	if len(traffic) == 0 {
+		section.Paragraphs = []string{"No requests were served by deployments during the week."}
+		return section, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(traffic))
+	for _, t := range traffic {
+		if id, err := uuid.Parse(t.ModelId); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	var models []schema.Model
+	if err := s.db.Select("id", "name", "type").Find(&models, "id IN ?", ids).Error; err != nil {
+		slog.Error("sql error listing models for health report", "error", err)
+		return reports.Section{}, schema.ErrDbAccessFailed
+	}
+	names := map[string]schema.Model{}
+	for _, model := range models {
+		names[model.Id.String()] = model
+	}
+
+	section.Table = &reports.Table{Columns: []string{"Deployment", "Type", "Requests"}}
+	for _, t := range traffic {
+		name, modelType := t.ModelId, ""
+		if model, ok := names[t.ModelId]; ok {
+			name, modelType = model.Name, model.Type
+		}
+		section.Table.Rows = append(section.Table.Rows, []string{name, modelType, strconv.FormatFloat(math.Round(t.Requests), 'f', 0, 64)})
+	}
+
+	return section, nil
+}
+
+func (s *ReportService) failedLoginSection(start, end time.Time) (reports.Section, error) {
+	var events []schema.PlatformEvent
+	err := s.db.
+		Where("type = ? AND timestamp >= ? AND timestamp < ?", schema.UserLoginFailedEvent, start, end).
+		Find(&events).Error
+	if err != nil {
+		slog.Error("sql error listing failed logins for health report", "error", err)
+		return reports.Section{}, schema.ErrDbAccessFailed
+	}
+
+	byEmail := map[string]int{}
+	for _, event := range events {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			slog.Warn("unable to parse failed login event", "event_id", event.Id, "error", err)
+			continue
+		}
+		if email, ok := data["email"].(string); ok {
+			byEmail[email]++
+		}
+	}
+
+	emails := make([]string, 0, len(byEmail))
+	for email := range byEmail {
+		emails = append(emails, email)
+	}
+	slices.SortFunc(emails, func(a, b string) int {
+		return cmp.Or(cmp.Compare(byEmail[b], byEmail[a]), strings.Compare(a, b))
+	})
+
+	section := reports.Section{
+		Heading:    "Failed Logins",
+		Paragraphs: []string{fmt.Sprintf("%d logins failed for %d emails during the week.", len(events), len(byEmail))},
+	}
+	if len(emails) > 0 {
+		section.Table = &reports.Table{Columns: []string{"Email", "Failed Logins"}}
+		for _, email := range emails[:min(len(emails), healthReportTopN)] {
+			section.Table.Rows = append(section.Table.Rows, []string{email, strconv.Itoa(byEmail[email])})
+		}
+	}
+
+	return section, nil
+}
+
+func (s *ReportService) inactiveDeploymentSection() (reports.Section, error) {
+	section := reports.Section{Heading: "Inactive Deployments"}
+	if s.variables.InactiveDeployments.After == 0 {
+		section.Paragraphs = []string{"Deployments are not checked for inactivity."}
+		return section, nil
+	}
+
+	inactive, err := listInactiveDeployments(s.db)
+	if err != nil {
+		return reports.Section{}, err
+	}
+
+	days := int(s.variables.InactiveDeployments.After.Hours() / 24)
+	section.Paragraphs = []string{fmt.Sprintf("%d deployments have not been queried or retrained in the last %d days.", len(inactive), days)}
+	if len(inactive) > 0 {
+		section.Table = &reports.Table{Columns: []string{"Deployment", "Owner", "Flagged", "Undeploy After"}}
+		for _, info := range inactive {
+			undeployAt := "-"
+			if info.UndeployAt != nil {
+				undeployAt = info.UndeployAt.Format("2006-01-02")
+			}
+			section.Table.Rows = append(section.Table.Rows, []string{info.ModelName, info.Username, info.FlaggedAt.Format("2006-01-02"), undeployAt})
+		}
+	}
+
+	return section, nil
+}
+
+// healthReport summarizes the health of the platform in the week before end.
+func (s *ReportService) healthReport(end time.Time, storage storageSnapshot) (reports.Document, error) {
+	start := end.Add(-healthReportPeriod)
+
+	jobs, err := s.jobSuccessSection(start, end)
+	if err != nil {
+		return reports.Document{}, err
+	}
+	storageUsage, err := s.storageSection(healthReportWeek(end), storage)
+	if err != nil {
+		return reports.Document{}, err
+	}
+	traffic, err := s.trafficSection(end)
+	if err != nil {
+		return reports.Document{}, err
+	}
+	logins, err := s.failedLoginSection(start, end)
+	if err != nil {
+		return reports.Document{}, err
+	}
+	inactive, err := s.inactiveDeploymentSection()
+	if err != nil {
+		return reports.Document{}, err
+	}
+
+	return reports.Document{
+		Title:       "Platform Health Report",
+		Subtitle:    fmt.Sprintf("Platform health from %v to %v", start.Format("2006-01-02"), end.Format("2006-01-02")),
+		GeneratedAt: time.Now().UTC(),
+		Sections:    []reports.Section{jobs, storageUsage, s.licenseSection(end), traffic, inactive, logins},
+	}, nil
+}
+
+func (s *ReportService) adminEmails() ([]string, error) {
+	var emails []string
+	if err := s.db.Model(&schema.User{}).Where("is_admin = ? AND email != ''", true).Order("email").Pluck("email", &emails).Error; err != nil {
+		slog.Error("sql error listing admin emails for health report", "error", err)
+		return nil, schema.ErrDbAccessFailed
+	}
+	return emails, nil
+}
+
+func (s *ReportService) emailHealthReport(doc reports.Document) ([]string, error) {
+	recipients, err := s.adminEmails()
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("there are no admins with an email address")
+	}
+
+	content, err := reports.Render(doc, reports.FormatHTML)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering health report: %w", err)
+	}
+
+	msg := mail.Message{To: recipients, Subject: fmt.Sprintf("%v: %v", doc.Title, doc.Subtitle), Html: string(content)}
+	if err := s.variables.Mailer.Send(msg); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+type healthReportRequest struct {
+	reportOptions
+	// Email also sends the report to all admins.
+	Email bool `json:"email"`
+}
+
+type healthReportResponse struct {
+	createReportResponse
+	EmailedTo []string `json:"emailed_to,omitempty"`
+}
+
+// HealthReport creates a health report for the last week, which can also be
+// emailed to the admins immediately instead of waiting for the weekly report.
+func (s *ReportService) HealthReport(w http.ResponseWriter, r *http.Request) {
+	var params healthReportRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := params.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if params.Email && s.variables.Mailer == nil {
+		http.Error(w, "unable to email health report, smtp is not configured", http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	storage, err := s.storageUsage()
+	if err != nil {
+		slog.Error("error getting storage usage for health report", "error", err)
+		http.Error(w, "error getting storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	doc, err := s.healthReport(time.Now().UTC(), storage)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error creating health report: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := s.saveReport(user, HealthReportKind, doc, params.reportOptions)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+	res := healthReportResponse{createReportResponse: saved}
+
+	if params.Email {
+		res.EmailedTo, err = s.emailHealthReport(doc)
+		if err != nil {
+			slog.Error("error emailing health report", "error", err)
+			http.Error(w, fmt.Sprintf("the report was created but could not be emailed: %v", err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+// sendWeeklyHealthReport emails the health report for the previous week to the
+// admins once the week is over, this is called from the status sync. Each
+// report is claimed by recording its week before it is sent, so it is sent by
+// only one instance of model bazaar and is not retried if sending fails.
+func (s *ReportService) sendWeeklyHealthReport() {
+	if s.variables.Mailer == nil {
+		return
+	}
+
+	week := healthReportWeek(time.Now())
+
+	var existing int64
+	if err := s.db.Model(&schema.HealthReportRun{}).Where("week_start = ?", week).Count(&existing).Error; err != nil {
+		slog.Error("status sync: sql error checking for health report", "error", err)
+		return
+	}
+	if existing > 0 {
+		return
+	}
+
+	run := schema.HealthReportRun{WeekStart: week, SentAt: time.Now().UTC(), Status: HealthReportSending}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+	if result.Error != nil {
+		slog.Error("status sync: sql error claiming health report", "error", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	send := func() error {
+		storage, err := s.storageUsage()
+		if err != nil {
+			return fmt.Errorf("error getting storage usage: %w", err)
+		}
+		if storage.Known {
+			run.StorageUsedBytes, run.StorageTotalBytes = storage.UsedBytes, storage.TotalBytes
+		}
+
+		doc, err := s.healthReport(week, storage)
+		if err != nil {
+			return err
+		}
+
+		recipients, err := s.emailHealthReport(doc)
+		run.Recipients = len(recipients)
+		return err
+	}
+
+	if err := send(); err != nil {
+		slog.Error("status sync: error sending health report", "week", week, "error", err)
+		run.Status, run.Message = HealthReportFailed, err.Error()
+	} else {
+		slog.Info("status sync: sent health report", "week", week, "recipients", run.Recipients)
+		run.Status = HealthReportSent
+	}
+	run.SentAt = time.Now().UTC()
+
+	if err := s.db.Save(&run).Error; err != nil {
+		slog.Error("status sync: sql error saving health report", "week", week, "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+type ImageScanService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *ImageScanService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+
+	return r
+}
+
+const maxImageScans = 100
+
+type ImageScanInfo struct {
+	JobName   string         `json:"job_name"`
+	Image     string         `json:"image"`
+	Digest    string         `json:"digest"`
+	Scanner   string         `json:"scanner"`
+	Counts    map[string]int `json:"vulnerabilities"`
+	Result    string         `json:"result"`
+	Error     string         `json:"error,omitempty"`
+	ScannedAt time.Time      `json:"scanned_at"`
+}
+
+func (s *ImageScanService) List(w http.ResponseWriter, r *http.Request) {
+	query := s.db.Order("id DESC").Limit(maxImageScans)
+	if jobName := r.URL.Query().Get("job_name"); jobName != "" {
+		query = query.Where("job_name = ?", jobName)
+	}
+	if result := r.URL.Query().Get("result"); result != "" {
+		query = query.Where("result = ?", result)
+	}
+
+	var scans []schema.ImageScan
+	if err := query.Find(&scans).Error; err != nil {
+		slog.Error("sql error listing image scans", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]ImageScanInfo, 0, len(scans))
+	for _, scan := range scans {
+		infos = append(infos, ImageScanInfo{
+			JobName: scan.JobName,
+			Image:   scan.Image,
+			Digest:  scan.Digest,
+			Scanner: scan.Scanner,
+			Counts: map[string]int{
+				"critical": scan.Critical,
+				"high":     scan.High,
+				"medium":   scan.Medium,
+				"low":      scan.Low,
+				"unknown":  scan.Unknown,
+			},
+			Result:    scan.Result,
+			Error:     scan.Error,
+			ScannedAt: scan.CreatedAt.UTC(),
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	trainJobEnv  = "train"
+	deployJobEnv = "deploy"
+)
+
+// These are set by the job templates or by the orchestrator, and overriding
+// them would break the jobs or leak credentials.
+var reservedJobEnvKeys = map[string]bool{
+	"CONFIG_PATH":          true,
+	"JOB_TOKEN":            true,
+	"JOB_ENDPOINT":         true,
+	"GENAI_KEY":            true,
+	"HF_HOME":              true,
+	"WORKER_CORES":         true,
+	"AWS_ACCESS_KEY":       true,
+	"AWS_ACCESS_SECRET":    true,
+	"AWS_REGION_NAME":      true,
+	"AZURE_ACCOUNT_NAME":   true,
+	"AZURE_ACCOUNT_KEY":    true,
+	"GCP_CREDENTIALS_FILE": true,
+	"PATH":                 true,
+	"HOME":                 true,
+	"PYTHONPATH":           true,
+	"LD_PRELOAD":           true,
+	"LD_LIBRARY_PATH":      true,
+}
+
+var reservedJobEnvPrefixes = []string{"NOMAD_", "KUBERNETES_"}
+
+var jobEnvKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const maxJobEnvVars = 100
+
+func validateJobEnv(env map[string]string) error {
+	if len(env) > maxJobEnvVars {
+		return fmt.Errorf("at most %d environment variables can be specified for each job type", maxJobEnvVars)
+	}
+
+	for key, value := range env {
+		if !jobEnvKeyRe.MatchString(key) || len(key) > 200 {
+			return fmt.Errorf("invalid environment variable name '%v'", key)
+		}
+		upper := strings.ToUpper(key)
+		if reservedJobEnvKeys[upper] {
+			return fmt.Errorf("environment variable '%v' is reserved by the platform", key)
+		}
+		for _, prefix := range reservedJobEnvPrefixes {
+			if strings.HasPrefix(upper, prefix) {
+				return fmt.Errorf("environment variables starting with '%v' are reserved by the platform", prefix)
+			}
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("value of environment variable '%v' cannot contain control characters", key)
+		}
+	}
+
+	return nil
+}
+
+// loadJobEnv returns the additional environment variables for the job type.
+func loadJobEnv(db *gorm.DB, job string) (map[string]string, error) {
+	var vars []schema.JobEnvVar
+	if err := db.Where("job = ?", job).Find(&vars).Error; err != nil {
+		slog.Error("sql error loading job env vars", "job", job, "error", err)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	env := make(map[string]string, len(vars))
+	for _, v := range vars {
+		env[v.Key] = v.Value
+	}
+	return env, nil
+}
+
+// jobEnv returns the env for a new job, which is the platform's outbound proxy
+// and trusted certificate settings along with the admin configured vars, which
+// take precedence.
+func (vars *Variables) jobEnv(db *gorm.DB, store storage.Storage, job string) (map[string]string, error) {
+	certs, err := TrustedCertsJobEnv(db, store)
+	if err != nil {
+		return nil, err
+	}
+
+	configured, err := loadJobEnv(db, job)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string, len(vars.OutboundJobEnv)+len(certs)+len(configured))
+	for _, source := range []map[string]string{vars.OutboundJobEnv, certs, configured} {
+		for k, v := range source {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+type JobEnvService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *JobEnvService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.Get)
+	r.Post("/", s.Set)
+
+	return r
+}
+
+type JobEnvSettings struct {
+	Train  map[string]string `json:"train"`
+	Deploy map[string]string `json:"deploy"`
+}
+
+func (s *JobEnvService) Get(w http.ResponseWriter, r *http.Request) {
+	train, err := loadJobEnv(s.db, trainJobEnv)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	deploy, err := loadJobEnv(s.db, deployJobEnv)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, JobEnvSettings{Train: train, Deploy: deploy})
+}
+
+// Set replaces the environment variables for all job types. The new values only
+// apply to jobs that are started afterwards.
+func (s *JobEnvService) Set(w http.ResponseWriter, r *http.Request) {
+	var params JobEnvSettings
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	for job, env := range map[string]map[string]string{trainJobEnv: params.Train, deployJobEnv: params.Deploy} {
+		if err := validateJobEnv(env); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %v job env: %v", job, err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		if err := txn.Where("1 = 1").Delete(&schema.JobEnvVar{}).Error; err != nil {
+			slog.Error("sql error clearing job env vars", "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		now := time.Now().UTC()
+		vars := []schema.JobEnvVar{}
+		for key, value := range params.Train {
+			vars = append(vars, schema.JobEnvVar{Job: trainJobEnv, Key: key, Value: value, UpdatedAt: now})
+		}
+		for key, value := range params.Deploy {
+			vars = append(vars, schema.JobEnvVar{Job: deployJobEnv, Key: key, Value: value, UpdatedAt: now})
+		}
+
+		if len(vars) == 0 {
+			return nil
+		}
+
+		if err := txn.Create(&vars).Error; err != nil {
+			slog.Error("sql error saving job env vars", "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating job env: %v", err), err)
+		return
+	}
+
+	slog.Info("updated job env vars", "train", len(params.Train), "deploy", len(params.Deploy))
+
+	utils.WriteSuccess(w)
+}
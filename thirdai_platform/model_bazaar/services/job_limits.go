@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobLimits are the number of train jobs and deployments a user can run at the
+// same time, so that a single user cannot use all of the cpu allowed by the
+// license. A limit of 0 means there is no limit.
+type JobLimits struct {
+	MaxTrainJobs   int `json:"max_train_jobs"`
+	MaxDeployments int `json:"max_deployments"`
+}
+
+var ErrUserJobLimitReached = errors.New("user job limit reached")
+
+// The train and deploy statuses of models whose jobs count towards the limits
+// of their owner. Queued train jobs do not count since they are not using any
+// resources.
+var (
+	activeTrainStatuses  = []string{schema.NotStarted, schema.Starting, schema.InProgress}
+	activeDeployStatuses = []string{schema.Starting, schema.InProgress, schema.Complete}
+)
+
+// getJobLimitOverride returns the limits an admin has set for the user, the
+// limits are nil if they have not been overridden.
+func getJobLimitOverride(db *gorm.DB, userId uuid.UUID) (schema.UserJobLimit, error) {
+	var override schema.UserJobLimit
+	result := db.Limit(1).Find(&override, "user_id = ?", userId)
+	if result.Error != nil {
+		slog.Error("sql error retrieving user job limits", "user_id", userId, "error", result.Error)
+		return override, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return override, nil
+}
+
+// userJobLimits returns the limits of the user, which are the defaults unless
+// an admin has overridden them.
+func userJobLimits(db *gorm.DB, defaults JobLimits, userId uuid.UUID) (JobLimits, error) {
+	override, err := getJobLimitOverride(db, userId)
+	if err != nil {
+		return JobLimits{}, err
+	}
+	return applyJobLimitOverride(defaults, override), nil
+}
+
+func applyJobLimitOverride(defaults JobLimits, override schema.UserJobLimit) JobLimits {
+	limits := defaults
+	if override.MaxTrainJobs != nil {
+		limits.MaxTrainJobs = *override.MaxTrainJobs
+	}
+	if override.MaxDeployments != nil {
+		limits.MaxDeployments = *override.MaxDeployments
+	}
+	return limits
+}
+
+func countActiveJobs(db *gorm.DB, userId uuid.UUID, statusColumn string, statuses []string) (int, error) {
+	var count int64
+	result := db.Model(&schema.Model{}).
+		Where("user_id = ?", userId).
+		Where(statusColumn+" IN ?", statuses).
+		Count(&count)
+	if result.Error != nil {
+		slog.Error("sql error counting active jobs for user", "user_id", userId, "status", statusColumn, "error", result.Error)
+		return 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return int(count), nil
+}
+
+// checkTrainJobLimit returns an error wrapping ErrUserJobLimitReached if the
+// user cannot start another train job.
+func checkTrainJobLimit(db *gorm.DB, defaults JobLimits, userId uuid.UUID) error {
+	limits, err := userJobLimits(db, defaults, userId)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxTrainJobs != 0 {
+		running, err := countActiveJobs(db, userId, "train_status", activeTrainStatuses)
+		if err != nil {
+			return err
+		}
+		if running >= limits.MaxTrainJobs {
+			return CodedError(fmt.Errorf("%w: user %v is running %d train jobs which is the maximum allowed, wait for a job to finish or ask an admin to raise the limit", ErrUserJobLimitReached, userId, running), http.StatusTooManyRequests)
+		}
+	}
+
+	return checkOrganizationTrainJobLimit(db, userId)
+}
+
+// checkDeploymentLimit returns an error wrapping ErrUserJobLimitReached if the
+// user cannot deploy another model.
+func checkDeploymentLimit(db *gorm.DB, defaults JobLimits, userId uuid.UUID) error {
+	limits, err := userJobLimits(db, defaults, userId)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxDeployments != 0 {
+		deployed, err := countActiveJobs(db, userId, "deploy_status", activeDeployStatuses)
+		if err != nil {
+			return err
+		}
+		if deployed >= limits.MaxDeployments {
+			return CodedError(fmt.Errorf("%w: user %v has %d deployments which is the maximum allowed, undeploy a model or ask an admin to raise the limit", ErrUserJobLimitReached, userId, deployed), http.StatusTooManyRequests)
+		}
+	}
+
+	return checkOrganizationDeploymentLimit(db, userId)
+}
+
+type UserJobLimitsResponse struct {
+	UserId uuid.UUID `json:"user_id"`
+	// The limits that apply to the user, after any overrides.
+	Limits JobLimits `json:"limits"`
+	// The overrides set by an admin, null fields use the default limits.
+	Overrides UserJobLimitsRequest `json:"overrides"`
+
+	RunningTrainJobs int `json:"running_train_jobs"`
+	Deployments      int `json:"deployments"`
+}
+
+type UserJobLimitsRequest struct {
+	MaxTrainJobs   *int `json:"max_train_jobs"`
+	MaxDeployments *int `json:"max_deployments"`
+}
+
+func (s *UserService) GetJobLimits(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.jobLimitsInfo(userId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving job limits: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+func (s *UserService) jobLimitsInfo(userId uuid.UUID) (UserJobLimitsResponse, error) {
+	if _, err := schema.GetUser(userId, s.db); err != nil {
+		if errors.Is(err, schema.ErrUserNotFound) {
+			return UserJobLimitsResponse{}, CodedError(err, http.StatusNotFound)
+		}
+		return UserJobLimitsResponse{}, CodedError(err, http.StatusInternalServerError)
+	}
+
+	override, err := getJobLimitOverride(s.db, userId)
+	if err != nil {
+		return UserJobLimitsResponse{}, err
+	}
+
+	running, err := countActiveJobs(s.db, userId, "train_status", activeTrainStatuses)
+	if err != nil {
+		return UserJobLimitsResponse{}, err
+	}
+	deployed, err := countActiveJobs(s.db, userId, "deploy_status", activeDeployStatuses)
+	if err != nil {
+		return UserJobLimitsResponse{}, err
+	}
+
+	return UserJobLimitsResponse{
+		UserId:           userId,
+		Limits:           applyJobLimitOverride(s.jobLimits, override),
+		Overrides:        UserJobLimitsRequest{MaxTrainJobs: override.MaxTrainJobs, MaxDeployments: override.MaxDeployments},
+		RunningTrainJobs: running,
+		Deployments:      deployed,
+	}, nil
+}
+
+// SetJobLimits overrides the default job limits for a user. Jobs that are
+// already running are not affected if the limits are lowered.
+func (s *UserService) SetJobLimits(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params UserJobLimitsRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if (params.MaxTrainJobs != nil && *params.MaxTrainJobs < 0) || (params.MaxDeployments != nil && *params.MaxDeployments < 0) {
+		http.Error(w, "job limits must be non-negative, use 0 for no limit or null for the default limit", http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if _, err := schema.GetUser(userId, txn); err != nil {
+			if errors.Is(err, schema.ErrUserNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if params.MaxTrainJobs == nil && params.MaxDeployments == nil {
+			if result := txn.Delete(&schema.UserJobLimit{}, "user_id = ?", userId); result.Error != nil {
+				slog.Error("sql error deleting user job limits", "user_id", userId, "error", result.Error)
+				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+			}
+			return nil
+		}
+
+		override := schema.UserJobLimit{
+			UserId:         userId,
+			MaxTrainJobs:   params.MaxTrainJobs,
+			MaxDeployments: params.MaxDeployments,
+			UpdatedAt:      time.Now().UTC(),
+		}
+		result := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&override)
+		if result.Error != nil {
+			slog.Error("sql error saving user job limits", "user_id", userId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error setting job limits: %v", err), err)
+		return
+	}
+
+	res, err := s.jobLimitsInfo(userId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving job limits: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
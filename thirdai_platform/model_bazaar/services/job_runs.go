@@ -0,0 +1,238 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// jobRunEnded returns if a job is no longer running once it has the given
+// status. Deployments keep running after they are complete.
+func jobRunEnded(job, status string) bool {
+	switch status {
+	case schema.Failed, schema.Stopped:
+		return true
+	case schema.Complete:
+		return job == "train"
+	default:
+		return false
+	}
+}
+
+// startJobRun records a new run of the job for the model. Any previous run that
+// has not ended is replaced by the new run, for example when a deployment is
+// rolled back.
+func startJobRun(txn *gorm.DB, job string, modelId uuid.UUID, jobName string, resources orchestrator.Resources, priority int) error {
+	now := time.Now().UTC()
+
+	var attempts int64
+	if err := txn.Model(&schema.JobRun{}).Where("model_id = ? AND job = ?", modelId, job).Count(&attempts).Error; err != nil {
+		slog.Error("sql error counting job runs", "model_id", modelId, "job", job, "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	result := txn.Model(&schema.JobRun{}).
+		Where("model_id = ? AND job = ? AND ended_at IS NULL", modelId, job).
+		Updates(map[string]interface{}{"ended_at": now, "status": schema.Stopped, "message": fmt.Sprintf("replaced by attempt %d", attempts+1)})
+	if result.Error != nil {
+		slog.Error("sql error ending replaced job runs", "model_id", modelId, "job", job, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	run := schema.JobRun{
+		JobName:             jobName,
+		ModelId:             modelId,
+		Job:                 job,
+		Attempt:             int(attempts) + 1,
+		StartedAt:           now,
+		Status:              schema.Starting,
+		AllocationCores:     resources.AllocationCores,
+		AllocationMhz:       resources.AllocationMhz,
+		AllocationMemory:    resources.AllocationMemory,
+		AllocationMemoryMax: resources.AllocationMemoryMax,
+		Priority:            priority,
+	}
+	if result := txn.Create(&run); result.Error != nil {
+		slog.Error("sql error recording job run", "model_id", modelId, "job", job, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// updateJobRun sets the status of the current run of the job, and ends the run
+// if the status means the job is no longer running. The message explains the
+// status, for instance why the job failed, and is optional.
+func updateJobRun(txn *gorm.DB, job string, modelId uuid.UUID, status, message string) error {
+	updates := map[string]interface{}{"status": status}
+	if jobRunEnded(job, status) {
+		updates["ended_at"] = time.Now().UTC()
+	}
+	if message != "" {
+		updates["message"] = message
+	}
+
+	result := txn.Model(&schema.JobRun{}).Where("model_id = ? AND job = ? AND ended_at IS NULL", modelId, job).Updates(updates)
+	if result.Error != nil {
+		slog.Error("sql error updating job run", "model_id", modelId, "job", job, "status", status, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func startErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("error starting job: %v", err)
+}
+
+type JobRunService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *JobRunService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+
+	return r
+}
+
+type JobRunResources struct {
+	Cores       int `json:"cores"`
+	CpuMhz      int `json:"cpu_mhz"`
+	MemoryMb    int `json:"memory_mb"`
+	MemoryMaxMb int `json:"memory_max_mb"`
+}
+
+type JobRunInfo struct {
+	Id        uint64          `json:"id"`
+	JobName   string          `json:"job_name"`
+	ModelId   uuid.UUID       `json:"model_id"`
+	Job       string          `json:"job"`
+	Attempt   int             `json:"attempt"`
+	StartedAt time.Time       `json:"started_at"`
+	EndedAt   *time.Time      `json:"ended_at"`
+	Status    string          `json:"status"`
+	Message   string          `json:"message,omitempty"`
+	Resources JobRunResources `json:"resources"`
+}
+
+const (
+	defaultJobRunLimit = 100
+	maxJobRunLimit     = 1000
+)
+
+func parseTimeParam(r *http.Request, key string) (*time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%v' for query param '%v': must be an RFC3339 timestamp", value, key)
+	}
+	return &parsed, nil
+}
+
+// List returns the job runs that match the filters in the query params, most
+// recently started first.
+func (s *JobRunService) List(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseUintParam(r, "limit", defaultJobRunLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 || limit > maxJobRunLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxJobRunLimit), http.StatusBadRequest)
+		return
+	}
+
+	query := s.db.Order("started_at DESC").Order("id DESC").Limit(int(limit))
+
+	params := r.URL.Query()
+	if modelId := params.Get("model_id"); modelId != "" {
+		id, err := uuid.Parse(modelId)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid model_id '%v': %v", modelId, err), http.StatusBadRequest)
+			return
+		}
+		query = query.Where("model_id = ?", id)
+	}
+	if job := params.Get("job"); job != "" {
+		if job != "train" && job != "deploy" {
+			http.Error(w, fmt.Sprintf("invalid job '%v', must be 'train' or 'deploy'", job), http.StatusBadRequest)
+			return
+		}
+		query = query.Where("job = ?", job)
+	}
+	if status := params.Get("status"); status != "" {
+		if err := schema.CheckValidStatus(status); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	startedAfter, err := parseTimeParam(r, "started_after")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if startedAfter != nil {
+		query = query.Where("started_at >= ?", startedAfter.UTC())
+	}
+
+	startedBefore, err := parseTimeParam(r, "started_before")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if startedBefore != nil {
+		query = query.Where("started_at < ?", startedBefore.UTC())
+	}
+
+	var runs []schema.JobRun
+	if err := query.Find(&runs).Error; err != nil {
+		slog.Error("sql error listing job runs", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]JobRunInfo, 0, len(runs))
+	for _, run := range runs {
+		infos = append(infos, JobRunInfo{
+			Id:        run.Id,
+			JobName:   run.JobName,
+			ModelId:   run.ModelId,
+			Job:       run.Job,
+			Attempt:   run.Attempt,
+			StartedAt: run.StartedAt.UTC(),
+			EndedAt:   utcTime(run.EndedAt),
+			Status:    run.Status,
+			Message:   run.Message,
+			Resources: JobRunResources{
+				Cores:       run.AllocationCores,
+				CpuMhz:      run.AllocationMhz,
+				MemoryMb:    run.AllocationMemory,
+				MemoryMaxMb: run.AllocationMemoryMax,
+			},
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const maxJsonlLineSize = 16 * 1024 * 1024
+
+// jsonlReader reads the json objects in a jsonl file. The keys of each object
+// are returned in the order they appear, so that the schema lists the columns
+// in the order they are in the file.
+type jsonlReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func newJsonlReader(r io.Reader) *jsonlReader {
+	return &jsonlReader{reader: bufio.NewReader(r)}
+}
+
+type jsonlRecord struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *jsonlReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxJsonlLineSize {
+			return nil, fmt.Errorf("line %d is longer than %d bytes", r.line+1, maxJsonlLineSize)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+			return nil, err
+		}
+		r.line++
+		return line, nil
+	}
+}
+
+// Read returns the next record, skipping blank lines.
+func (r *jsonlReader) Read() (jsonlRecord, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return jsonlRecord{}, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		record, err := parseJsonlRecord(line)
+		if err != nil {
+			return jsonlRecord{}, fmt.Errorf("invalid json on line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+}
+
+func parseJsonlRecord(line []byte) (jsonlRecord, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return jsonlRecord{}, errors.New("each line must be a json object")
+	}
+
+	record := jsonlRecord{values: make(map[string]interface{})}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return jsonlRecord{}, err
+		}
+		key := token.(string)
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return jsonlRecord{}, err
+		}
+		if _, seen := record.values[key]; !seen {
+			record.keys = append(record.keys, key)
+		}
+		record.values[key] = value
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return jsonlRecord{}, err
+	}
+	if decoder.More() {
+		return jsonlRecord{}, errors.New("each line must contain a single json object")
+	}
+
+	return record, nil
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// mergeJsonTypes returns the type of a column which has values of both types.
+func mergeJsonTypes(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case a == "null":
+		return b
+	case b == "null":
+		return a
+	case (a == "integer" && b == "number") || (a == "number" && b == "integer"):
+		return "number"
+	default:
+		return "mixed"
+	}
+}
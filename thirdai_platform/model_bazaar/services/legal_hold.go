@@ -0,0 +1,450 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	auditExportsDir = "audit_exports"
+
+	defaultAuditExportRetainDays = 365
+)
+
+// heldModelIds is a subquery for the ids of the models with an active hold.
+func heldModelIds(db *gorm.DB) *gorm.DB {
+	return db.Model(&schema.LegalHold{}).Select("model_id").Where("scope = ? AND model_id IS NOT NULL AND released_at IS NULL", schema.LegalHoldModel)
+}
+
+func checkNoLegalHold(txn *gorm.DB, modelId uuid.UUID, action string) error {
+	var holds int64
+	result := txn.Model(&schema.LegalHold{}).Where("scope = ? AND model_id = ? AND released_at IS NULL", schema.LegalHoldModel, modelId).Count(&holds)
+	if result.Error != nil {
+		slog.Error("sql error checking legal holds for model", "model_id", modelId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if holds > 0 {
+		return CodedError(fmt.Errorf("model %v is under legal hold and cannot be %v until the hold is released", modelId, action), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+func auditUnderHold(db *gorm.DB) (bool, error) {
+	var holds int64
+	result := db.Model(&schema.LegalHold{}).Where("scope = ? AND released_at IS NULL", schema.LegalHoldAudit).Count(&holds)
+	if result.Error != nil {
+		slog.Error("sql error checking legal holds on audit data", "error", result.Error)
+		return false, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return holds > 0, nil
+}
+
+type LegalHoldService struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	userAuth  auth.IdentityProvider
+	variables Variables
+}
+
+func (s *LegalHoldService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+	r.Post("/", s.Place)
+	r.Post("/{hold_id}/release", s.Release)
+	r.Post("/audit-export", s.ExportAudit)
+
+	return r
+}
+
+type LegalHoldInfo struct {
+	Id         uuid.UUID  `json:"id"`
+	Scope      string     `json:"scope"`
+	ModelId    *uuid.UUID `json:"model_id"`
+	Reason     string     `json:"reason"`
+	PlacedBy   uuid.UUID  `json:"placed_by"`
+	PlacedAt   time.Time  `json:"placed_at"`
+	ReleasedBy *uuid.UUID `json:"released_by"`
+	ReleasedAt *time.Time `json:"released_at"`
+	Active     bool       `json:"active"`
+}
+
+func newLegalHoldInfo(hold schema.LegalHold) LegalHoldInfo {
+	info := LegalHoldInfo{
+		Id:         hold.Id,
+		Scope:      hold.Scope,
+		ModelId:    hold.ModelId,
+		Reason:     hold.Reason,
+		PlacedBy:   hold.PlacedBy,
+		PlacedAt:   hold.PlacedAt.UTC(),
+		ReleasedBy: hold.ReleasedBy,
+		Active:     hold.ReleasedAt == nil,
+	}
+	if hold.ReleasedAt != nil {
+		releasedAt := hold.ReleasedAt.UTC()
+		info.ReleasedAt = &releasedAt
+	}
+	return info
+}
+
+// List returns all legal holds, including released holds unless active=true
+// is specified.
+func (s *LegalHoldService) List(w http.ResponseWriter, r *http.Request) {
+	query := s.db.Order("placed_at")
+	if r.URL.Query().Get("active") == "true" {
+		query = query.Where("released_at IS NULL")
+	}
+
+	var holds []schema.LegalHold
+	if err := query.Find(&holds).Error; err != nil {
+		slog.Error("sql error listing legal holds", "error", err)
+		http.Error(w, fmt.Sprintf("error listing legal holds: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]LegalHoldInfo, 0, len(holds))
+	for _, hold := range holds {
+		infos = append(infos, newLegalHoldInfo(hold))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type placeLegalHoldRequest struct {
+	Scope   string     `json:"scope" validate:"required,oneof=audit model"`
+	ModelId *uuid.UUID `json:"model_id"`
+	Reason  string     `json:"reason" validate:"required,max=1000"`
+}
+
+func (s *LegalHoldService) Place(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params placeLegalHoldRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	errs := validation.Struct(&params)
+	if params.Scope == schema.LegalHoldModel && params.ModelId == nil {
+		errs.Add("model_id", "model_id is required for holds on a model")
+	}
+	if params.Scope == schema.LegalHoldAudit && params.ModelId != nil {
+		errs.Add("model_id", "model_id cannot be specified for holds on audit data")
+	}
+	if err := errs.Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid legal hold: %v", err), err)
+		return
+	}
+
+	hold := schema.LegalHold{
+		Id:       uuid.New(),
+		Scope:    params.Scope,
+		ModelId:  params.ModelId,
+		Reason:   params.Reason,
+		PlacedBy: user.Id,
+		PlacedAt: time.Now().UTC(),
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		var teamId *uuid.UUID
+		if hold.ModelId != nil {
+			model, err := schema.GetModel(*hold.ModelId, txn, false, false, false)
+			if err != nil {
+				if errors.Is(err, schema.ErrModelNotFound) {
+					return CodedError(err, http.StatusNotFound)
+				}
+				return CodedError(err, http.StatusInternalServerError)
+			}
+			teamId = model.TeamId
+		}
+
+		if err := txn.Create(&hold).Error; err != nil {
+			slog.Error("sql error creating legal hold", "scope", hold.Scope, "model_id", hold.ModelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.LegalHoldPlacedEvent, hold.ModelId, teamId, map[string]interface{}{
+			"hold_id": hold.Id, "scope": hold.Scope, "reason": hold.Reason, "user_id": user.Id, "username": user.Username,
+		})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error placing legal hold: %v", err), err)
+		return
+	}
+
+	slog.Info("placed legal hold", "hold_id", hold.Id, "scope", hold.Scope, "model_id", hold.ModelId, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, newLegalHoldInfo(hold))
+}
+
+func (s *LegalHoldService) Release(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	holdId, err := utils.URLParamUUID(r, "hold_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var hold schema.LegalHold
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := txn.First(&hold, "id = ?", holdId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return CodedError(fmt.Errorf("legal hold %v not found", holdId), http.StatusNotFound)
+			}
+			slog.Error("sql error retrieving legal hold", "hold_id", holdId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if hold.ReleasedAt != nil {
+			return CodedError(fmt.Errorf("legal hold %v has already been released", holdId), http.StatusUnprocessableEntity)
+		}
+
+		now := time.Now().UTC()
+		hold.ReleasedBy = &user.Id
+		hold.ReleasedAt = &now
+
+		result := txn.Model(&hold).Updates(map[string]interface{}{"released_by": user.Id, "released_at": now})
+		if result.Error != nil {
+			slog.Error("sql error releasing legal hold", "hold_id", holdId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.LegalHoldReleasedEvent, hold.ModelId, nil, map[string]interface{}{
+			"hold_id": hold.Id, "scope": hold.Scope, "user_id": user.Id, "username": user.Username,
+		})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error releasing legal hold: %v", err), err)
+		return
+	}
+
+	slog.Info("released legal hold", "hold_id", hold.Id, "scope", hold.Scope, "model_id", hold.ModelId, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, newLegalHoldInfo(hold))
+}
+
+type auditExportRequest struct {
+	Start      *time.Time `json:"start"`
+	End        *time.Time `json:"end"`
+	RetainDays int        `json:"retain_days" validate:"min=0"`
+}
+
+type auditExportFile struct {
+	Sha256 string `json:"sha256"`
+	Bytes  int    `json:"bytes"`
+}
+
+// AuditExportInfo is returned by the export and is also saved with the export
+// as manifest.json, so that the files can be checked against their checksums.
+type AuditExportInfo struct {
+	ExportId      uuid.UUID                  `json:"export_id"`
+	Path          string                     `json:"path"`
+	CreatedBy     uuid.UUID                  `json:"created_by"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	Start         *time.Time                 `json:"start"`
+	End           time.Time                  `json:"end"`
+	Events        int                        `json:"events"`
+	AuditLogLines int                        `json:"audit_log_lines"`
+	Files         map[string]auditExportFile `json:"files"`
+	RetainUntil   time.Time                  `json:"retain_until"`
+	// Immutable is true if the files were written with object lock, so that
+	// they cannot be modified or deleted until RetainUntil.
+	Immutable bool `json:"immutable"`
+}
+
+func (s *LegalHoldService) exportEvents(start *time.Time, end time.Time) ([]byte, int, error) {
+	query := s.db.Where("timestamp < ?", end).Order("id")
+	if start != nil {
+		query = query.Where("timestamp >= ?", *start)
+	}
+
+	var events []schema.PlatformEvent
+	if err := query.Find(&events).Error; err != nil {
+		slog.Error("sql error listing platform events for audit export", "error", err)
+		return nil, 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	for _, event := range events {
+		if err := encoder.Encode(newEventInfo(event)); err != nil {
+			return nil, 0, CodedError(fmt.Errorf("error serializing event %d: %w", event.Id, err), http.StatusInternalServerError)
+		}
+	}
+	return buf.Bytes(), len(events), nil
+}
+
+// exportAuditLog returns the lines of the audit log file for requests in the
+// time range. Lines without a valid time are skipped.
+func (s *LegalHoldService) exportAuditLog(start *time.Time, end time.Time) ([]byte, int, error) {
+	if s.variables.AuditLogPath == "" {
+		return nil, 0, nil
+	}
+
+	file, err := os.Open(s.variables.AuditLogPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, nil
+		}
+		slog.Error("error opening audit log for export", "error", err)
+		return nil, 0, CodedError(errors.New("error reading audit log"), http.StatusInternalServerError)
+	}
+	defer file.Close()
+
+	buf := new(bytes.Buffer)
+	lines := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Time.IsZero() {
+			continue
+		}
+		if !entry.Time.Before(end) || (start != nil && entry.Time.Before(*start)) {
+			continue
+		}
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("error reading audit log for export", "error", err)
+		return nil, 0, CodedError(errors.New("error reading audit log"), http.StatusInternalServerError)
+	}
+
+	return buf.Bytes(), lines, nil
+}
+
+// ExportAudit writes the platform events and audit log entries in the time
+// range to storage. If the storage supports it the files are written with
+// object lock so that they cannot be modified or deleted until they expire.
+func (s *LegalHoldService) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params auditExportRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	errs := validation.Struct(&params)
+	if params.Start != nil && params.End != nil && !params.Start.Before(*params.End) {
+		errs.Add("start", "start must be before end")
+	}
+	if err := errs.Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid audit export: %v", err), err)
+		return
+	}
+
+	now := time.Now().UTC()
+	retainDays := params.RetainDays
+	if retainDays == 0 {
+		retainDays = defaultAuditExportRetainDays
+	}
+
+	info := AuditExportInfo{
+		ExportId:    uuid.New(),
+		CreatedBy:   user.Id,
+		CreatedAt:   now,
+		Start:       params.Start,
+		End:         now,
+		Files:       map[string]auditExportFile{},
+		RetainUntil: now.AddDate(0, 0, retainDays),
+	}
+	if params.End != nil {
+		info.End = params.End.UTC()
+	}
+	info.Path = filepath.Join(auditExportsDir, info.ExportId.String())
+
+	events, nEvents, err := s.exportEvents(info.Start, info.End)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error exporting audit data: %v", err), err)
+		return
+	}
+	auditLog, nLines, err := s.exportAuditLog(info.Start, info.End)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error exporting audit data: %v", err), err)
+		return
+	}
+	info.Events, info.AuditLogLines = nEvents, nLines
+
+	files := map[string][]byte{"events.jsonl": events, "audit.log": auditLog}
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		info.Files[name] = auditExportFile{Sha256: hex.EncodeToString(sum[:]), Bytes: len(data)}
+	}
+
+	retained, immutable := s.storage.(storage.RetentionWriter)
+	info.Immutable = immutable
+
+	manifest, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error serializing audit export manifest: %v", err), http.StatusInternalServerError)
+		return
+	}
+	files["manifest.json"] = manifest
+
+	// The manifest is written last so that an export with a manifest is complete.
+	for _, name := range []string{"events.jsonl", "audit.log", "manifest.json"} {
+		path := filepath.Join(info.Path, name)
+		if immutable {
+			err = retained.WriteRetained(path, files[name], info.RetainUntil)
+		} else {
+			err = s.storage.Write(path, bytes.NewReader(files[name]))
+		}
+		if err != nil {
+			slog.Error("error writing audit export", "export_id", info.ExportId, "file", name, "error", err)
+			http.Error(w, fmt.Sprintf("error writing audit export: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = recordEvent(s.db, schema.AuditExportedEvent, nil, nil, map[string]interface{}{
+		"export_id": info.ExportId, "path": info.Path, "events": info.Events, "immutable": info.Immutable, "user_id": user.Id, "username": user.Username,
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error recording audit export: %v", err), err)
+		return
+	}
+
+	slog.Info("exported audit data", "export_id", info.ExportId, "events", info.Events, "audit_log_lines", info.AuditLogLines, "immutable", info.Immutable)
+
+	utils.WriteJsonResponse(w, info)
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LlmCacheService lets admins manage the llm-cache job that is started with
+// model bazaar. The requests are validated and forwarded to the admin endpoints
+// of the job, which is reached through the same ingress as the deployments.
+type LlmCacheService struct {
+	db        *gorm.DB
+	userAuth  auth.IdentityProvider
+	variables Variables
+}
+
+func (s *LlmCacheService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/stats", s.Stats)
+	r.Post("/evict", s.Evict)
+	r.Post("/capacity", s.SetCapacity)
+
+	return r
+}
+
+const llmCacheRequestTimeout = 30 * time.Second
+
+// callLlmCache sends a request to the admin endpoints of the llm-cache job and
+// decodes the response into res. The caller's credentials are forwarded, since
+// the job checks that the caller is an admin.
+func (s *LlmCacheService) callLlmCache(r *http.Request, method, path string, body interface{}, res interface{}) error {
+	endpoint, err := url.JoinPath(s.variables.ModelBazaarEndpoint, "cache", "admin", path)
+	if err != nil {
+		return CodedError(fmt.Errorf("invalid llm-cache endpoint: %w", err), http.StatusInternalServerError)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return CodedError(fmt.Errorf("error encoding llm-cache request: %w", err), http.StatusInternalServerError)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), llmCacheRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return CodedError(fmt.Errorf("error creating llm-cache request: %w", err), http.StatusInternalServerError)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, header := range []string{"Authorization", "X-API-Key"} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("error sending request to llm-cache job", "path", path, "error", err)
+		return CodedError(fmt.Errorf("llm-cache job is unavailable: %w", err), http.StatusBadGateway)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		slog.Error("llm-cache job returned an error", "path", path, "status", resp.StatusCode, "error", string(msg))
+		code := http.StatusBadGateway
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+			code = resp.StatusCode
+		}
+		return CodedError(fmt.Errorf("llm-cache job returned status %d: %v", resp.StatusCode, strings.TrimSpace(string(msg))), code)
+	}
+
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			return CodedError(fmt.Errorf("invalid response from llm-cache job: %w", err), http.StatusBadGateway)
+		}
+	}
+
+	return nil
+}
+
+type LlmCacheUsage struct {
+	Entries   int64 `json:"entries"`
+	SizeBytes int64 `json:"size_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	// The fraction of lookups that were hits, computed by model bazaar from the
+	// hits and misses reported by the job. It is 0 if there were no lookups.
+	HitRate float64 `json:"hit_rate"`
+}
+
+func (u *LlmCacheUsage) setHitRate() {
+	if lookups := u.Hits + u.Misses; lookups > 0 {
+		u.HitRate = float64(u.Hits) / float64(lookups)
+	} else {
+		u.HitRate = 0
+	}
+}
+
+type LlmCacheModelUsage struct {
+	ModelId uuid.UUID `json:"model_id"`
+	LlmCacheUsage
+}
+
+type LlmCacheStats struct {
+	LlmCacheUsage
+	// The maximum number of entries in the cache, 0 means it is unbounded.
+	MaxEntries int64                `json:"max_entries"`
+	Models     []LlmCacheModelUsage `json:"models"`
+}
+
+func (stats *LlmCacheStats) setHitRates() {
+	stats.setHitRate()
+	if stats.Models == nil {
+		stats.Models = []LlmCacheModelUsage{}
+	}
+	for i := range stats.Models {
+		stats.Models[i].setHitRate()
+	}
+}
+
+func (s *LlmCacheService) Stats(w http.ResponseWriter, r *http.Request) {
+	var stats LlmCacheStats
+	if err := s.callLlmCache(r, http.MethodGet, "stats", nil, &stats); err != nil {
+		writeError(w, fmt.Sprintf("unable to get llm cache stats: %v", err), err)
+		return
+	}
+
+	stats.setHitRates()
+
+	utils.WriteJsonResponse(w, stats)
+}
+
+// LlmCacheEvictRequest selects the entries to evict. If both the model and age
+// are given, only the entries of the model older than the age are evicted.
+type LlmCacheEvictRequest struct {
+	ModelId          *uuid.UUID `json:"model_id"`
+	OlderThanSeconds int        `json:"older_than_seconds"`
+}
+
+type LlmCacheEvictResponse struct {
+	Evicted int64 `json:"evicted"`
+}
+
+func (s *LlmCacheService) Evict(w http.ResponseWriter, r *http.Request) {
+	var params LlmCacheEvictRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.OlderThanSeconds < 0 {
+		http.Error(w, "older_than_seconds cannot be negative", http.StatusUnprocessableEntity)
+		return
+	}
+	if params.ModelId == nil && params.OlderThanSeconds == 0 {
+		http.Error(w, "model_id or older_than_seconds must be specified", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var res LlmCacheEvictResponse
+	if err := s.callLlmCache(r, http.MethodPost, "evict", params, &res); err != nil {
+		writeError(w, fmt.Sprintf("unable to evict llm cache entries: %v", err), err)
+		return
+	}
+
+	slog.Info("evicted llm cache entries", "model_id", params.ModelId, "older_than_seconds", params.OlderThanSeconds, "evicted", res.Evicted)
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type LlmCacheCapacityRequest struct {
+	// The maximum number of entries in the cache, the oldest entries are evicted
+	// if the cache is larger. 0 removes the limit.
+	MaxEntries *int64 `json:"max_entries"`
+}
+
+func (s *LlmCacheService) SetCapacity(w http.ResponseWriter, r *http.Request) {
+	var params LlmCacheCapacityRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.MaxEntries == nil {
+		http.Error(w, "max_entries must be specified", http.StatusUnprocessableEntity)
+		return
+	}
+	if *params.MaxEntries < 0 {
+		http.Error(w, "max_entries cannot be negative", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var stats LlmCacheStats
+	if err := s.callLlmCache(r, http.MethodPost, "capacity", params, &stats); err != nil {
+		writeError(w, fmt.Sprintf("unable to set llm cache capacity: %v", err), err)
+		return
+	}
+
+	stats.setHitRates()
+
+	slog.Info("set llm cache capacity", "max_entries", *params.MaxEntries)
+
+	utils.WriteJsonResponse(w, stats)
+}
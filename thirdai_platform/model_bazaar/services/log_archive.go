@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type archivedJobLogs struct {
+	JobName    string                `json:"job_name"`
+	ArchivedAt time.Time             `json:"archived_at"`
+	Logs       []orchestrator.JobLog `json:"logs"`
+}
+
+// archiveJobLogs copies the logs for a job from the orchestrator to storage so
+// that they remain available after the orchestrator garbage collects the job.
+// The logs are archived under the attempt of the latest run of the job, so a
+// job that is run again does not replace the logs of its previous runs.
+// Archiving is best effort, errors are logged and returned but should not
+// block the operation that triggered the archival.
+func archiveJobLogs(db *gorm.DB, c orchestrator.Client, store storage.Storage, modelId uuid.UUID, job, jobName string) error {
+	attempt, err := latestJobAttempt(db, modelId, job)
+	if err != nil {
+		return err
+	}
+
+	logs, err := c.JobLogs(jobName)
+	if err != nil {
+		slog.Error("error retrieving job logs for archival", "model_id", modelId, "job_name", jobName, "error", err)
+		return fmt.Errorf("error retrieving job logs for archival: %w", err)
+	}
+
+	archive := archivedJobLogs{JobName: jobName, ArchivedAt: time.Now().UTC(), Logs: logs}
+
+	data, err := json.Marshal(archive)
+	if err != nil {
+		slog.Error("error serializing job logs for archival", "model_id", modelId, "job_name", jobName, "error", err)
+		return fmt.Errorf("error serializing job logs: %w", err)
+	}
+
+	if err := store.Write(storage.JobLogArchivePath(modelId, job, attempt), bytes.NewReader(data)); err != nil {
+		slog.Error("error writing job log archive", "model_id", modelId, "job_name", jobName, "error", err)
+		return fmt.Errorf("error writing job log archive: %w", err)
+	}
+
+	if err := saveJobLogArchiveEntry(db, modelId, job, attempt, jobName, true); err != nil {
+		return err
+	}
+
+	slog.Info("archived job logs", "model_id", modelId, "job_name", jobName, "attempt", attempt, "allocations", len(logs))
+
+	return nil
+}
+
+// latestJobAttempt returns the attempt of the latest run of the job, or 0 if
+// no runs are recorded for it.
+func latestJobAttempt(db *gorm.DB, modelId uuid.UUID, job string) (int, error) {
+	var attempt int
+	result := db.Model(&schema.JobRun{}).Select("COALESCE(MAX(attempt), 0)").Where("model_id = ? AND job = ?", modelId, job).Scan(&attempt)
+	if result.Error != nil {
+		slog.Error("sql error finding latest job run for log archival", "model_id", modelId, "job", job, "error", result.Error)
+		return 0, schema.ErrDbAccessFailed
+	}
+	return attempt, nil
+}
+
+func saveJobLogArchiveEntry(db *gorm.DB, modelId uuid.UUID, job string, attempt int, jobName string, available bool) error {
+	entry := schema.JobLogArchive{ModelId: modelId, Job: job, Attempt: attempt, JobName: jobName, ArchivedAt: time.Now().UTC(), Available: available}
+	if result := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry); result.Error != nil {
+		slog.Error("sql error saving job log archive entry", "model_id", modelId, "job", job, "attempt", attempt, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+	return nil
+}
+
+// loadArchivedJobLogs returns the archived logs of the given attempt of the job,
+// or of its latest archived run if the attempt is nil. It returns nil if there
+// are no archived logs.
+func loadArchivedJobLogs(db *gorm.DB, store storage.Storage, modelId uuid.UUID, job string, attempt *int) ([]orchestrator.JobLog, error) {
+	query := db.Where("model_id = ? AND job = ? AND available = ?", modelId, job, true)
+	if attempt != nil {
+		query = query.Where("attempt = ?", *attempt)
+	}
+
+	var entries []schema.JobLogArchive
+	if result := query.Order("attempt DESC").Limit(1).Find(&entries); result.Error != nil {
+		slog.Error("sql error finding job log archive", "model_id", modelId, "job", job, "error", result.Error)
+		return nil, schema.ErrDbAccessFailed
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	path := storage.JobLogArchivePath(modelId, job, entries[0].Attempt)
+
+	exists, err := store.Exists(path)
+	if err != nil {
+		return nil, fmt.Errorf("error checking for job log archive: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	file, err := store.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading job log archive: %w", err)
+	}
+	defer file.Close()
+
+	var archive archivedJobLogs
+	if err := json.NewDecoder(file).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("error parsing job log archive: %w", err)
+	}
+
+	return archive.Logs, nil
+}
+
+func expireArchivedJobLogs(db *gorm.DB, store storage.Storage, archive schema.JobLogArchive) error {
+	if err := store.Delete(storage.JobLogArchivePath(archive.ModelId, archive.Job, archive.Attempt)); err != nil {
+		slog.Error("error deleting job log archive", "model_id", archive.ModelId, "job", archive.Job, "attempt", archive.Attempt, "error", err)
+		return fmt.Errorf("error deleting job log archive: %w", err)
+	}
+
+	result := db.Model(&schema.JobLogArchive{}).
+		Where("model_id = ? AND job = ? AND attempt = ?", archive.ModelId, archive.Job, archive.Attempt).
+		Update("available", false)
+	if result.Error != nil {
+		slog.Error("sql error updating job log archive entry", "model_id", archive.ModelId, "job", archive.Job, "attempt", archive.Attempt, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+
+	return nil
+}
+
+// archiveCompletedTrainLogs archives the logs of train jobs which have finished
+// but whose logs have not been archived yet. Failed jobs detected by the status
+// sync are archived there, this handles jobs which report their own completion.
+func (m *ModelBazaar) archiveCompletedTrainLogs() {
+	var models []schema.Model
+	result := m.db.
+		Where("train_status IN ?", []string{schema.Complete, schema.Failed}).
+		Where("NOT EXISTS (?)", m.db.Model(&schema.JobLogArchive{}).Select("1").
+			Where("job_log_archives.model_id = models.id AND job_log_archives.job = ?", "train").
+			Where("job_log_archives.attempt = (?)", m.db.Model(&schema.JobRun{}).Select("COALESCE(MAX(attempt), 0)").Where("job_runs.model_id = models.id AND job_runs.job = ?", "train"))).
+		Find(&models)
+	if result.Error != nil {
+		slog.Error("log archival: sql error listing models to archive", "error", result.Error)
+		return
+	}
+
+	for _, model := range models {
+		if model.Type == schema.UploadInProgress {
+			continue
+		}
+		err := archiveJobLogs(m.db, m.orchestratorClient, m.storage, model.Id, "train", model.TrainJobName())
+		if err != nil {
+			// The entry is still saved so that archival is not retried on every sync
+			// for jobs that have already been removed from the orchestrator.
+			if attempt, err := latestJobAttempt(m.db, model.Id, "train"); err == nil {
+				_ = saveJobLogArchiveEntry(m.db, model.Id, "train", attempt, model.TrainJobName(), false)
+			}
+		}
+	}
+}
+
+func (m *ModelBazaar) cleanupExpiredJobLogs() {
+	if m.jobLogRetention <= 0 {
+		return
+	}
+
+	var expired []schema.JobLogArchive
+	// Logs of models under legal hold are kept until the hold is released.
+	result := m.db.
+		Where("available = ? AND archived_at < ?", true, time.Now().UTC().Add(-m.jobLogRetention)).
+		Where("model_id NOT IN (?)", heldModelIds(m.db)).
+		Find(&expired)
+	if result.Error != nil {
+		slog.Error("log archival: sql error listing expired archives", "error", result.Error)
+		return
+	}
+
+	for _, archive := range expired {
+		if err := expireArchivedJobLogs(m.db, m.storage, archive); err == nil {
+			slog.Info("log archival: deleted expired archive", "model_id", archive.ModelId, "job", archive.Job, "attempt", archive.Attempt)
+		}
+	}
+}
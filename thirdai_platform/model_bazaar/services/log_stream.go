@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const logStreamKeepAliveInterval = 15 * time.Second
+
+// streamLogsHandler streams the logs of the train or deploy job of the model as
+// server sent events while the job is running. Each event contains a line of the
+// logs, and an end event is sent once the job exits. Comments are sent
+// periodically while the job is not writing logs so that proxies do not close the
+// connection as idle.
+func streamLogsHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, c orchestrator.Client, job string) {
+	_, jobName, ok := logsJobName(w, r, db, job)
+	if !ok {
+		return
+	}
+
+	streamer, ok := c.(orchestrator.JobLogStreamer)
+	if !ok {
+		http.Error(w, orchestrator.ErrLogStreamNotSupported.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	info, err := c.JobInfo(jobName)
+	if errors.Is(err, orchestrator.ErrJobNotFound) || (err == nil && info.Status == orchestrator.StatusDead) {
+		http.Error(w, fmt.Sprintf("%v job is not running, the logs of the last run can be retrieved from /logs", job), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error getting job info for log stream", "job_name", jobName, "error", err)
+		http.Error(w, "error getting job info from orchestrator", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	lines := make(chan orchestrator.JobLogLine, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- streamer.StreamJobLogs(ctx, jobName, func(line orchestrator.JobLogLine) {
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	write := func(event string) bool {
+		if _, err := fmt.Fprint(w, event); err != nil {
+			slog.Warn("error writing log event, client likely disconnected", "job_name", jobName, "error", err)
+			return false
+		}
+		if err := rc.Flush(); err != nil {
+			slog.Warn("error flushing log event, client likely disconnected", "job_name", jobName, "error", err)
+			return false
+		}
+		return true
+	}
+
+	writeLine := func(line orchestrator.JobLogLine) bool {
+		data, err := json.Marshal(line)
+		if err != nil {
+			slog.Error("error encoding log line", "job_name", jobName, "error", err)
+			return true
+		}
+		return write(fmt.Sprintf("data: %s\n\n", data))
+	}
+
+	// The headers are sent immediately so that the client knows the stream is
+	// open before the job writes any logs.
+	if !write(": connected\n\n") {
+		return
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case line := <-lines:
+			if !writeLine(line) {
+				return
+			}
+		case err := <-done:
+			// The lines written before the stream ended are still buffered.
+			for len(lines) > 0 {
+				if !writeLine(<-lines) {
+					return
+				}
+			}
+			if err != nil {
+				slog.Error("error streaming job logs", "job_name", jobName, "error", err)
+				write(fmt.Sprintf("event: error\ndata: %s\n\n", err.Error()))
+				return
+			}
+			write("event: end\ndata: {}\n\n")
+			return
+		case <-keepAlive.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package services
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,10 +11,11 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/ratelimit"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
@@ -32,20 +34,36 @@ type ModelService struct {
 
 	userAuth          auth.IdentityProvider
 	uploadSessionAuth *auth.JwtManager
+	rateLimiter       *ratelimit.Limiter
+
+	artifactCompression string
+	storageQuotas       map[string]int64
+	storageTiering      TieringPolicy
+
+	downloadLocks *downloadLocks
+
+	tieringCheckedAt *time.Time
 }
 
 type CreateAPIKeyRequest struct {
 	ModelIDs  []uuid.UUID `json:"model_ids"`
 	Name      string      `json:"name"`
-	Exp       time.Time   `json:"exp"`
+	ExpiresAt time.Time   `json:"expires_at"`
 	AllModels bool        `json:"all_models"`
+
+	// Deprecated: older clients send the expiration as exp, it is used if
+	// expires_at is not specified.
+	Exp time.Time `json:"exp" deprecated:"true"`
 }
 
 type APIKeyResponse struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string    `json:"name"`
 	CreatedBy uuid.UUID `json:"created_by"`
-	Expiry    time.Time `json:"expiry"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Deprecated: the same as expires_at, kept for older clients.
+	Expiry time.Time `json:"expiry" deprecated:"true"`
 }
 
 type deleteRequestBody struct {
@@ -55,9 +73,10 @@ type deleteRequestBody struct {
 func (s *ModelService) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	eitherOrMiddleware := eitherUserOrApiKeyAuthMiddleware(s.db, s.userAuth.AuthMiddleware())
+	eitherOrMiddleware := eitherUserOrApiKeyAuthMiddleware(s.db, s.userAuth.AuthMiddleware(), s.rateLimiter)
 	r.Route("/{model_id}", func(r chi.Router) {
 		r.Use(eitherOrMiddleware)
+		r.Use(resolveModelAlias(s.db))
 
 		r.Get("/permissions", s.Permissions)
 
@@ -65,7 +84,11 @@ func (s *ModelService) Routes() chi.Router {
 			r.Use(auth.ModelPermissionOnly(s.db, auth.ReadPermission))
 
 			r.Get("/", s.Info)
-			r.Get("/download", s.Download)
+			r.Get("/lineage", s.Lineage)
+			r.With(downloadTimeouts).Get("/download", s.Download)
+			r.Get("/download/manifest", s.DownloadManifest)
+			r.Get("/download-url", s.DownloadUrl)
+			r.Get("/governance", s.GetGovernance)
 		})
 
 		r.Group(func(r chi.Router) {
@@ -74,6 +97,10 @@ func (s *ModelService) Routes() chi.Router {
 			r.Delete("/", s.Delete)
 			r.Post("/access", s.UpdateAccess)
 			r.Post("/default-permission", s.UpdateDefaultPermission)
+			r.Post("/lock", s.Lock)
+			r.Post("/unlock", s.Unlock)
+			r.Post("/rehydrate", s.Rehydrate)
+			r.Post("/governance", s.UpdateGovernance)
 		})
 	})
 
@@ -81,17 +108,26 @@ func (s *ModelService) Routes() chi.Router {
 		r.Use(s.userAuth.AuthMiddleware()...)
 
 		r.Get("/list", s.List)
+		r.Post("/permissions/batch", s.BatchPermissions)
 		r.Post("/create-api-key", s.CreateAPIKey)
 		r.Post("/delete-api-key", s.DeleteAPIKey)
 		r.Get("/list-api-keys", s.ListUserAPIKeys)
+		r.With(downloadTimeouts).Post("/export", s.Export)
+		r.With(uploadTimeouts, checkSufficientStorage(s.storage)).Post("/import", s.Import)
 		r.With(checkSufficientStorage(s.storage)).Post("/upload", s.UploadStart)
+		r.Post("/upload/resume", s.UploadResume)
+		r.Get("/upload/list", s.UploadList)
+		r.Post("/upload/abort", s.UploadAbort)
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(s.uploadSessionAuth.Verifier())
 		r.Use(s.uploadSessionAuth.Authenticator())
 
-		r.Post("/upload/{chunk_idx}", s.UploadChunk)
+		r.With(uploadTimeouts).Post("/upload/{chunk_idx}", s.UploadChunk)
+		r.Post("/upload/{chunk_idx}/presign", s.UploadChunkPresign)
+		r.Post("/upload/{chunk_idx}/confirm", s.UploadChunkConfirm)
+		r.Get("/upload/status", s.UploadStatus)
 		r.Post("/upload/commit", s.UploadCommit)
 	})
 
@@ -105,6 +141,14 @@ type ModelDependency struct {
 	Username  string    `json:"username"`
 }
 
+// ModelTrainDataset is a dataset version the model was trained on. The dataset
+// version id is null if the dataset has since been deleted.
+type ModelTrainDataset struct {
+	DatasetName      string     `json:"dataset_name"`
+	Version          int        `json:"version"`
+	DatasetVersionId *uuid.UUID `json:"dataset_version_id"`
+}
+
 type ModelInfo struct {
 	ModelId        uuid.UUID  `json:"model_id"`
 	ModelName      string     `json:"model_name"`
@@ -116,14 +160,24 @@ type ModelInfo struct {
 	DeployStatus   string     `json:"deploy_status"`
 	DeployErrors   []string   `json:"deploy_errors"`
 	DeployWarnings []string   `json:"deploy_warnings"`
-	PublishDate    time.Time  `json:"publish_date"`
+	PublishedAt    time.Time  `json:"published_at"`
 	UserEmail      string     `json:"user_email"`
 	Username       string     `json:"username"`
 	TeamId         *uuid.UUID `json:"team_id"`
+	Locked         bool       `json:"locked"`
+
+	// StorageTier is hot if the artifacts of the model are in the platform
+	// storage, otherwise the model must be rehydrated before it can be used.
+	StorageTier string `json:"storage_tier"`
 
 	Attributes map[string]string `json:"attributes"`
 
 	Dependencies []ModelDependency `json:"dependencies"`
+
+	Datasets []ModelTrainDataset `json:"datasets"`
+
+	// Deprecated: the same as published_at, kept for older clients.
+	PublishDate time.Time `json:"publish_date" deprecated:"true"`
 }
 
 func convertToModelInfo(model schema.Model, db *gorm.DB) (ModelInfo, error) {
@@ -177,6 +231,21 @@ func convertToModelInfo(model schema.Model, db *gorm.DB) (ModelInfo, error) {
 		deps = append(deps, depEntry)
 	}
 
+	var modelDatasets []schema.ModelDataset
+	if err := db.Order("dataset_name, version").Find(&modelDatasets, "model_id = ?", model.Id).Error; err != nil {
+		slog.Error("sql error retrieving model datasets", "model_id", model.Id, "error", err)
+		return ModelInfo{}, fmt.Errorf("error retrieving model datasets: %w", schema.ErrDbAccessFailed)
+	}
+	datasets := make([]ModelTrainDataset, 0, len(modelDatasets))
+	for _, dataset := range modelDatasets {
+		datasets = append(datasets, ModelTrainDataset{DatasetName: dataset.DatasetName, Version: dataset.Version, DatasetVersionId: dataset.DatasetVersionId})
+	}
+
+	storageTier, err := getModelStorageTier(db, model.Id)
+	if err != nil {
+		return ModelInfo{}, fmt.Errorf("error retrieving model storage tier: %w", err)
+	}
+
 	return ModelInfo{
 		ModelId:        model.Id,
 		ModelName:      model.Name,
@@ -188,12 +257,16 @@ func convertToModelInfo(model schema.Model, db *gorm.DB) (ModelInfo, error) {
 		DeployStatus:   deployStatus,
 		DeployErrors:   deployErrors,
 		DeployWarnings: deployWarnings,
-		PublishDate:    model.PublishedDate,
+		PublishedAt:    model.PublishedDate.UTC(),
+		PublishDate:    model.PublishedDate.UTC(),
 		UserEmail:      userEmail,
 		Username:       username,
 		TeamId:         model.TeamId,
+		Locked:         model.Locked,
+		StorageTier:    storageTier.Tier,
 		Attributes:     attributes,
 		Dependencies:   deps,
+		Datasets:       datasets,
 	}, nil
 }
 
@@ -207,7 +280,7 @@ func (s *ModelService) Info(w http.ResponseWriter, r *http.Request) {
 	model, err := schema.GetModel(modelId, s.db, true, true, true)
 	if err != nil {
 		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
 			return
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -216,7 +289,7 @@ func (s *ModelService) Info(w http.ResponseWriter, r *http.Request) {
 
 	info, err := convertToModelInfo(model, s.db)
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -230,37 +303,24 @@ func (s *ModelService) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var models []schema.Model
-	var result *gorm.DB
-	if user.IsAdmin {
-		result = s.db.
-			Preload("Dependencies").
-			Preload("Dependencies.Dependency").
-			Preload("Dependencies.Dependency.User").
-			Preload("Attributes").
-			Preload("User").
-			Find(&models)
-	} else {
-		userTeams, err := schema.GetUserTeamIds(user.Id, s.db)
-		if err != nil {
-			http.Error(w, "error loading user teams to determine model access", http.StatusInternalServerError)
-			return
-		}
-		result = s.db.
-			Preload("Dependencies").
-			Preload("Dependencies.Dependency").
-			Preload("Dependencies.Dependency.User").
-			Preload("Attributes").
-			Preload("User").
-			Where("access = ?", schema.Public).
-			Or("access = ? AND user_id = ?", schema.Private, user.Id).
-			Or("access = ? AND team_id IN ?", schema.Protected, userTeams).
-			Find(&models)
+	query, err := accessibleModels(s.db.Model(&schema.Model{}), s.db, user)
+	if err != nil {
+		http.Error(w, "error loading user teams to determine model access", http.StatusInternalServerError)
+		return
 	}
 
+	var models []schema.Model
+	result := query.
+		Preload("Dependencies").
+		Preload("Dependencies.Dependency").
+		Preload("Dependencies.Dependency.User").
+		Preload("Attributes").
+		Preload("User").
+		Find(&models)
+
 	if result.Error != nil {
-		slog.Error("sql error list accessible models", "error", err)
-		http.Error(w, fmt.Sprintf("unable to list models: %v", err), http.StatusInternalServerError)
+		slog.Error("sql error list accessible models", "error", result.Error)
+		http.Error(w, fmt.Sprintf("unable to list models: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
 		return
 	}
 
@@ -268,7 +328,7 @@ func (s *ModelService) List(w http.ResponseWriter, r *http.Request) {
 	for _, model := range models {
 		info, err := convertToModelInfo(model, s.db)
 		if err != nil {
-			http.Error(w, err.Error(), GetResponseCode(err))
+			writeError(w, err.Error(), err)
 			return
 		}
 		infos = append(infos, info)
@@ -311,13 +371,13 @@ func (s *ModelService) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		apiKey, err := s.createAndSaveAPIKeyInTransaction(
 			tx,
 			req.Name,
-			req.Exp,
+			req.ExpiresAt,
 			user.Id,
 			models,
 			req.AllModels,
 		)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to save API key: %v", err), http.StatusInternalServerError)
+			writeError(w, fmt.Sprintf("failed to save API key: %v", err), err)
 			return err
 		}
 
@@ -345,7 +405,11 @@ func parseCreateAPIKeyRequest(r *http.Request, w http.ResponseWriter) (CreateAPI
 		return req, errors.New("name is required")
 	}
 
-	if req.Exp.Before(time.Now()) {
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = req.Exp
+	}
+
+	if req.ExpiresAt.Before(time.Now()) {
 		return req, errors.New("api key is already expired")
 	}
 
@@ -405,7 +469,7 @@ func (s *ModelService) createAndSaveAPIKeyInTransaction(
 
 	apiKey, hashKey, err := generateApiKey()
 	if err != nil {
-		return "", err
+		return "", CodedError(err, http.StatusInternalServerError)
 	}
 
 	newAPIKey := schema.UserAPIKey{
@@ -420,6 +484,9 @@ func (s *ModelService) createAndSaveAPIKeyInTransaction(
 	}
 
 	if err := tx.Create(&newAPIKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return "", CodedErrorWithErrorCode(fmt.Errorf("an api key with name %v already exists", name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+		}
 		slog.Error("sql error creating user api keys", "error", err)
 		return "", CodedError(schema.ErrUserAPIKeyNotFound, http.StatusInternalServerError)
 	}
@@ -491,22 +558,39 @@ func (s *ModelService) ListUserAPIKeys(w http.ResponseWriter, r *http.Request) {
 		dbQuery = dbQuery.Where("created_by = ?", user.Id)
 	}
 
-	dbQuery = dbQuery.Select("id, name, created_by, expiry_time as expiry")
+	dbQuery = dbQuery.Select("id, name, created_by, expiry_time as expires_at")
 
 	if err := dbQuery.Scan(&apiKeys).Error; err != nil {
 		http.Error(w, "failed to retrieve API keys", http.StatusInternalServerError)
 		return
 	}
 
+	for i := range apiKeys {
+		apiKeys[i].ExpiresAt = apiKeys[i].ExpiresAt.UTC()
+		apiKeys[i].Expiry = apiKeys[i].ExpiresAt
+	}
+
 	utils.WriteJsonResponse(w, apiKeys)
 }
 
 type ModelPermissions struct {
-	Read     bool      `json:"read"`
-	Write    bool      `json:"write"`
-	Owner    bool      `json:"owner"`
-	Username string    `json:"username"`
-	Exp      time.Time `json:"exp"`
+	Read      bool      `json:"read"`
+	Write     bool      `json:"write"`
+	Owner     bool      `json:"owner"`
+	Admin     bool      `json:"admin"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// The teams of the user, deployments with team isolation use them to only
+	// return the documents of the user's team.
+	TeamIds []uuid.UUID `json:"team_ids"`
+
+	// Unredact is true if the user can view the original text of the entities
+	// redacted by an enterprise search workflow.
+	Unredact bool `json:"unredact"`
+
+	// Deprecated: the same as expires_at, kept for older clients.
+	Exp time.Time `json:"exp" deprecated:"true"`
 }
 
 func (s *ModelService) Permissions(w http.ResponseWriter, r *http.Request) {
@@ -526,7 +610,7 @@ func (s *ModelService) Permissions(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
 			return
 		}
 		http.Error(w, fmt.Sprintf("error retrieving model permissions: %v", err), http.StatusInternalServerError)
@@ -546,13 +630,95 @@ func (s *ModelService) Permissions(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	teamIds, err := schema.GetUserTeamIds(user.Id, s.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user teams: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	unredact, err := hasUnredactGrant(s.db, modelId, user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	res := ModelPermissions{
-		Read:     permission >= auth.ReadPermission,
-		Write:    permission >= auth.WritePermission,
-		Owner:    permission >= auth.OwnerPermission,
-		Username: user.Username,
-		Exp:      expiration,
+		Read:      permission >= auth.ReadPermission,
+		Write:     permission >= auth.WritePermission,
+		Owner:     permission >= auth.OwnerPermission,
+		Admin:     user.IsAdmin,
+		Username:  user.Username,
+		Unredact:  unredact,
+		ExpiresAt: expiration.UTC(),
+		Exp:       expiration.UTC(),
+		TeamIds:   teamIds,
+	}
+	utils.WriteJsonResponse(w, res)
+}
+
+const maxBatchPermissionModels = 1000
+
+type BatchPermissionsRequest struct {
+	ModelIds []uuid.UUID `json:"model_ids"`
+}
+
+type ModelPermissionLevels struct {
+	Read  bool `json:"read"`
+	Write bool `json:"write"`
+	Owner bool `json:"owner"`
+}
+
+type BatchPermissionsResponse struct {
+	Admin       bool                                `json:"admin"`
+	Username    string                              `json:"username"`
+	Permissions map[uuid.UUID]ModelPermissionLevels `json:"permissions"`
+	NotFound    []uuid.UUID                         `json:"not_found"`
+}
+
+func (s *ModelService) BatchPermissions(w http.ResponseWriter, r *http.Request) {
+	var params BatchPermissionsRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if len(params.ModelIds) > maxBatchPermissionModels {
+		http.Error(w, fmt.Sprintf("at most %d model ids can be checked at once", maxBatchPermissionModels), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting user_id: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := auth.GetModelPermissionsBatch(params.ModelIds, user, s.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving model permissions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	res := BatchPermissionsResponse{
+		Admin:       user.IsAdmin,
+		Username:    user.Username,
+		Permissions: make(map[uuid.UUID]ModelPermissionLevels, len(permissions)),
+		NotFound:    []uuid.UUID{},
+	}
+	for _, modelId := range params.ModelIds {
+		permission, ok := permissions[modelId]
+		if !ok {
+			if !slices.Contains(res.NotFound, modelId) {
+				res.NotFound = append(res.NotFound, modelId)
+			}
+			continue
+		}
+		res.Permissions[modelId] = ModelPermissionLevels{
+			Read:  permission >= auth.ReadPermission,
+			Write: permission >= auth.WritePermission,
+			Owner: permission >= auth.OwnerPermission,
+		}
 	}
+
 	utils.WriteJsonResponse(w, res)
 }
 
@@ -560,7 +726,7 @@ func countTrainingChildModels(db *gorm.DB, modelId uuid.UUID) (int64, error) {
 	var childModels int64
 	result := db.Model(&schema.Model{}).
 		Where("base_model_id = ?", modelId).
-		Where("train_status IN ?", []string{schema.NotStarted, schema.Starting, schema.InProgress}).
+		Where("train_status IN ?", []string{schema.NotStarted, schema.Queued, schema.Starting, schema.InProgress}).
 		Count(&childModels)
 
 	if result.Error != nil {
@@ -586,6 +752,13 @@ func (s *ModelService) Delete(w http.ResponseWriter, r *http.Request) {
 			return CodedError(err, http.StatusInternalServerError)
 		}
 
+		if err := checkModelUnlocked(model, "deleted"); err != nil {
+			return err
+		}
+		if err := checkNoLegalHold(txn, modelId, "deleted"); err != nil {
+			return err
+		}
+
 		usedBy, err := countDownstreamModels(modelId, txn, false)
 		if err != nil {
 			return err
@@ -602,6 +775,17 @@ func (s *ModelService) Delete(w http.ResponseWriter, r *http.Request) {
 			return CodedError(fmt.Errorf("cannot delete model %v since it is being used as a base model for %d actively training models", modelId, childModels), http.StatusUnprocessableEntity)
 		}
 
+		var batchJobs []schema.BatchPredictJob
+		if err := txn.Find(&batchJobs, "model_id = ?", modelId).Error; err != nil {
+			slog.Error("sql error listing batch prediction jobs", "model_id", modelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		for _, job := range batchJobs {
+			if job.Active() {
+				return CodedError(fmt.Errorf("cannot delete model %v since it is being used by batch prediction job %v", modelId, job.Id), http.StatusUnprocessableEntity)
+			}
+		}
+
 		if model.TrainStatus == schema.Starting || model.TrainStatus == schema.InProgress {
 			err = s.orchestratorClient.StopJob(model.TrainJobName())
 			if err != nil {
@@ -630,25 +814,176 @@ func (s *ModelService) Delete(w http.ResponseWriter, r *http.Request) {
 			return CodedError(errors.New("error deleting model data"), http.StatusInternalServerError)
 		}
 
-		result := txn.Delete(&model)
+		err = s.storage.Delete(storage.JobLogArchiveDir(modelId))
+		if err != nil {
+			slog.Error("error deleting model job log archives", "model_id", modelId, "error", err)
+			return CodedError(errors.New("error deleting model data"), http.StatusInternalServerError)
+		}
+
+		result := txn.Delete(&schema.JobLogArchive{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting job log archive entries", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.JobProgress{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting job progress", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.StatusTransition{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting status transitions", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.HoldoutEvaluation{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting holdout evaluation", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.QueuedTrainJob{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting queued train job", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.DeploymentVersion{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting deployment versions", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.StorageQuotaOverride{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting storage quota override", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.DeploymentSlo{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting deployment slo", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.InactiveDeployment{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting inactive deployment flag", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.EntityDictionaryVersion{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting entity dictionary", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.UnredactGrant{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting unredact grants", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.ModelGovernance{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting model governance", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		result = txn.Delete(&schema.ModelDataset{}, "model_id = ?", modelId)
+		if result.Error != nil {
+			slog.Error("sql error deleting model datasets", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		if err := s.deleteColdModelArchive(txn, modelId); err != nil {
+			return err
+		}
+
+		if err := deleteBatchPredictJobs(txn, s.storage, batchJobs); err != nil {
+			return err
+		}
+
+		if err := deleteUploadSession(txn, modelId); err != nil {
+			return err
+		}
+
+		if err := deleteTrainSchedules(txn, "model_id = ?", modelId); err != nil {
+			return err
+		}
+
+		result = txn.Delete(&model)
 		if result.Error != nil {
 			slog.Error("sql error deleting model", "model_id", modelId, "error", result.Error)
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordModelEvent(txn, schema.ModelDeletedEvent, model, nil)
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error deleting model: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error deleting model: %v", err), err)
 		return
 	}
 
 	utils.WriteSuccess(w)
 }
 
+// DownloadUrl returns a url that the client can use to download the model
+// archive directly from the storage.
+func (s *ModelService) DownloadUrl(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	presigner, ok := s.storage.(storage.Presigner)
+	if !ok {
+		http.Error(w, "presigned urls are not supported by the storage backend", http.StatusUnprocessableEntity)
+		return
+	}
+
+	archive, err := s.prepareDownloadArchive(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+	archivePath := archive.Path
+
+	expiresAt := time.Now().UTC().Add(presignedUrlExpiration)
+	url, err := presigner.Presign(http.MethodGet, archivePath, presignedUrlExpiration, nil)
+	if err != nil {
+		slog.Error("error presigning model download url", "model_id", modelId, "error", err)
+		http.Error(w, "error creating download url", http.StatusInternalServerError)
+		return
+	}
+
+	res := PresignedUrlResponse{Url: url, Method: http.MethodGet, Headers: map[string]string{}, ExpiresAt: expiresAt}
+	if strings.HasSuffix(archivePath, storage.ZstdArchiveExt) {
+		res.ContentEncoding = "zstd"
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
 type UploadStartRequest struct {
 	ModelName string `json:"model_name"`
+	// Optional, if specified the upload is verified against them on commit.
+	TotalChunks int    `json:"total_chunks"`
+	Sha256      string `json:"sha256"`
+}
+
+func (r *UploadStartRequest) validate() error {
+	if r.TotalChunks < 0 {
+		return errors.New("total_chunks must be non negative")
+	}
+	if r.Sha256 != "" && !isSha256(r.Sha256) {
+		return errors.New("sha256 must be a hex encoded sha256 checksum")
+	}
+	return nil
 }
 
 func (s *ModelService) UploadStart(w http.ResponseWriter, r *http.Request) {
@@ -657,6 +992,11 @@ func (s *ModelService) UploadStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := params.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	user, err := auth.UserFromContext(r)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
@@ -664,6 +1004,7 @@ func (s *ModelService) UploadStart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	model := newModel(uuid.New(), params.ModelName, schema.UploadInProgress, nil, user.Id)
+	tokenExpiration := time.Now().UTC().Add(uploadTokenExpiration)
 
 	err = s.db.Transaction(func(txn *gorm.DB) error {
 		if err := checkForDuplicateModel(txn, model.Name, model.UserId); err != nil {
@@ -672,19 +1013,34 @@ func (s *ModelService) UploadStart(w http.ResponseWriter, r *http.Request) {
 
 		result := txn.Create(&model)
 		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return duplicateModelError(model.Name, model.UserId)
+			}
 			slog.Error("sql error creating model for upload", "error", result.Error)
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		session := schema.ModelUploadSession{
+			ModelId:        model.Id,
+			TotalChunks:    params.TotalChunks,
+			Sha256:         strings.ToLower(params.Sha256),
+			CreatedAt:      time.Now().UTC(),
+			TokenExpiresAt: &tokenExpiration,
+		}
+		if result := txn.Create(&session); result.Error != nil {
+			slog.Error("sql error creating upload session", "model_id", model.Id, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordModelEvent(txn, schema.ModelCreatedEvent, model, map[string]interface{}{"name": model.Name, "type": model.Type, "user_id": model.UserId})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error creating new model: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error creating new model: %v", err), err)
 		return
 	}
 
-	uploadToken, err := s.uploadSessionAuth.CreateModelJwt(model.Id, 10*time.Minute)
+	uploadToken, err := s.uploadSessionAuth.CreateModelJwt(model.Id, uploadTokenExpiration)
 	if err != nil {
 		slog.Error("error creating upload token", "model_id", model.Id, "error", err)
 		http.Error(w, "error creating upload token for model", http.StatusInternalServerError)
@@ -695,33 +1051,66 @@ func (s *ModelService) UploadStart(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *ModelService) UploadChunk(w http.ResponseWriter, r *http.Request) {
-	chunkIdxParam, err := utils.URLParam(r, "chunk_idx")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	expectedSha256 := strings.ToLower(r.Header.Get(chunkSha256Header))
+	if expectedSha256 != "" && !isSha256(expectedSha256) {
+		http.Error(w, fmt.Sprintf("%v header must be a hex encoded sha256 checksum", chunkSha256Header), http.StatusBadRequest)
 		return
 	}
-	chunkIdx, err := strconv.Atoi(chunkIdxParam)
-	if err != nil || chunkIdx < 0 {
-		http.Error(w, "expected 'chunk_idx' parameter to be an positive integer", http.StatusBadRequest)
+
+	modelId, chunkIdx, err := s.uploadChunkParams(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	modelId, err := auth.ModelIdFromContext(r)
+	body, err := decodeChunkBody(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error retrieving model id from request: %v", err), http.StatusBadRequest)
+		writeError(w, err.Error(), err)
 		return
 	}
+	defer body.Close()
 
-	path := filepath.Join(storage.ModelPath(modelId), fmt.Sprintf("chunks/%d", chunkIdx))
+	path := uploadChunkPath(modelId, chunkIdx)
 
-	err = s.storage.Write(path, r.Body)
+	hash := sha256.New()
+	var size byteCounter
+	err = s.storage.Write(path, io.TeeReader(body, io.MultiWriter(hash, &size)))
 	if err != nil {
 		slog.Error("error uploading chunk to storage", "model_id", modelId, "chunk_idx", chunkIdx, "error", err)
 		http.Error(w, "error uploading chunk to storage", http.StatusInternalServerError)
 		return
 	}
 
-	utils.WriteSuccess(w)
+	// A chunk uploaded with a presigned url has been overwritten.
+	if err := s.deletePresignedChunk(modelId, chunkIdx); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	chunk := schema.ModelUploadChunk{
+		ModelId:    modelId,
+		ChunkIdx:   chunkIdx,
+		Size:       int64(size),
+		Sha256:     hex.EncodeToString(hash.Sum(nil)),
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	if expectedSha256 != "" && expectedSha256 != chunk.Sha256 {
+		// The chunk has been overwritten, so any previous copy must be sent again.
+		if err := s.discardUploadChunk(modelId, chunkIdx); err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+		http.Error(w, fmt.Sprintf("checksum of chunk %d is %v but expected %v, the chunk must be sent again", chunkIdx, chunk.Sha256, expectedSha256), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.recordUploadChunk(chunk); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, uploadChunkInfo(chunk))
 }
 
 type uploadCommitResponse struct {
@@ -729,37 +1118,52 @@ type uploadCommitResponse struct {
 	ModelType string    `json:"model_type"`
 }
 
-func (s *ModelService) combineChunks(modelId uuid.UUID) error {
-	chunks, err := s.storage.List(filepath.Join(storage.ModelPath(modelId), "chunks"))
+func (s *ModelService) combineChunks(session schema.ModelUploadSession, expectedSha256 string) error {
+	modelId := session.ModelId
+
+	chunks, err := s.listUploadChunks(modelId)
 	if err != nil {
-		slog.Error("error listing chunks for model upload", "error", err)
-		return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
+		return err
 	}
 
-	chunkSet := make(map[string]bool)
-	for _, chunk := range chunks {
-		chunkSet[chunk] = true
+	if len(chunks) == 0 {
+		return CodedError(errors.New("no chunks have been uploaded"), http.StatusBadRequest)
+	}
+	if missing := missingChunks(chunks, session.TotalChunks); len(missing) > 0 {
+		return CodedError(fmt.Errorf("chunks %v are missing", missing), http.StatusBadRequest)
 	}
 
-	modelZipfile := filepath.Join(storage.ModelPath(modelId), "model.zip")
-	for i := 0; i < len(chunks); i++ {
-		chunkPath := strconv.Itoa(i)
-		if !chunkSet[chunkPath] {
-			return CodedError(fmt.Errorf("chunk %d is missing", i), http.StatusBadRequest)
+	// The chunks are streamed into the archive, and each is checked against the
+	// checksum recorded when it was received in case it was corrupted in storage.
+	reader, writer := io.Pipe()
+	var chunkErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, chunk := range chunks {
+			if chunkErr = s.copyUploadChunk(writer, chunk); chunkErr != nil {
+				writer.CloseWithError(chunkErr)
+				return
+			}
 		}
+		writer.Close()
+	}()
 
-		chunk, err := s.storage.Read(filepath.Join(storage.ModelPath(modelId), "chunks", chunkPath))
-		if err != nil {
-			slog.Error("error reading chunk from upload", "model_id", modelId, "chunk_idx", i, "error", err)
-			return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
-		}
-		defer chunk.Close()
+	hash := sha256.New()
+	modelZipfile := filepath.Join(storage.ModelPath(modelId), "model.zip")
+	err = s.storage.Write(modelZipfile, io.TeeReader(reader, hash))
+	reader.CloseWithError(err)
+	<-done
+	if chunkErr != nil {
+		return chunkErr
+	}
+	if err != nil {
+		slog.Error("error combining upload chunks", "model_id", modelId, "error", err)
+		return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
+	}
 
-		err = s.storage.Append(modelZipfile, chunk)
-		if err != nil {
-			slog.Error("error appending chunk", "model_id", modelId, "chunk_idx", i, "error", err)
-			return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
-		}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); expectedSha256 != "" && checksum != expectedSha256 {
+		return CodedError(fmt.Errorf("checksum of uploaded data is %v but expected %v", checksum, expectedSha256), http.StatusBadRequest)
 	}
 
 	if err := s.storage.Unzip(modelZipfile); err != nil {
@@ -777,6 +1181,26 @@ func (s *ModelService) completeUpload(model *schema.Model) error {
 		return err
 	}
 
+	if err := checkStorageQuota(s.db, s.storage, s.storageQuotas, model.Id, metadata.Type); err != nil {
+		// The chunks are kept so that the upload can be committed again if an
+		// admin raises the quota for the model.
+		if errors.Is(err, ErrStorageQuotaExceeded) {
+			for _, path := range []string{"model", "model.zip"} {
+				if err := s.storage.Delete(filepath.Join(storage.ModelPath(model.Id), path)); err != nil {
+					slog.Error("error deleting model upload that exceeds storage quota", "model_id", model.Id, "error", err)
+				}
+			}
+		}
+		return err
+	}
+
+	if metadata.Type == schema.ExternalModel {
+		if err := validateExternalModel(s.storage, model.Id, metadata.Attributes); err != nil {
+			return err
+		}
+	}
+
+	previousStatus := model.TrainStatus
 	model.Type = metadata.Type
 	model.TrainStatus = schema.Complete
 
@@ -791,13 +1215,23 @@ func (s *ModelService) completeUpload(model *schema.Model) error {
 		}
 	}
 
-	result := s.db.Save(model)
-	if result.Error != nil {
-		slog.Error("sql error updating model info on upload commit", "model_id", model.Id, "error", result.Error)
-		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
-	}
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Save(model)
+		if result.Error != nil {
+			slog.Error("sql error updating model info on upload commit", "model_id", model.Id, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
 
-	return nil
+		if err := recordStatusTransition(txn, "train", model.Id, previousStatus, model.TrainStatus); err != nil {
+			return err
+		}
+
+		if err := deleteUploadSession(txn, model.Id); err != nil {
+			return err
+		}
+
+		return recordModelEvent(txn, schema.ModelUpdatedEvent, *model, map[string]interface{}{"type": model.Type, "train_status": model.TrainStatus})
+	})
 }
 
 type ModelMetadata struct {
@@ -805,16 +1239,19 @@ type ModelMetadata struct {
 	Attributes map[string]string
 }
 
-func saveModelMetadata(s storage.Storage, model schema.Model) error {
+func encodeModelMetadata(model schema.Model) ([]byte, error) {
 	metadata := ModelMetadata{Type: model.Type, Attributes: model.GetAttributes()}
 	buf := new(bytes.Buffer)
 	if err := json.NewEncoder(buf).Encode(metadata); err != nil {
 		slog.Error("error serializing metadata for model download", "model_id", model.Id, "error", err)
-		return CodedError(errors.New("error creating metadata for download archive"), http.StatusInternalServerError)
+		return nil, CodedError(errors.New("error creating metadata for download archive"), http.StatusInternalServerError)
 	}
+	return buf.Bytes(), nil
+}
 
-	if err := s.Write(storage.ModelMetadataPath(model.Id), buf); err != nil {
-		slog.Error("error saving metadata for model download", "model_id", model.Id, "error", err)
+func saveModelMetadata(s storage.Storage, modelId uuid.UUID, metadata []byte) error {
+	if err := s.Write(storage.ModelMetadataPath(modelId), bytes.NewReader(metadata)); err != nil {
+		slog.Error("error saving metadata for model download", "model_id", modelId, "error", err)
 		return CodedError(errors.New("error creating metadata for download archive"), http.StatusInternalServerError)
 	}
 
@@ -838,120 +1275,68 @@ func (s *ModelService) loadModelMetadata(modelId uuid.UUID) (ModelMetadata, erro
 	return metadata, nil
 }
 
-func (s *ModelService) UploadCommit(w http.ResponseWriter, r *http.Request) {
-	modelId, err := auth.ModelIdFromContext(r)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error retrieving model id from request: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	model, err := schema.GetModel(modelId, s.db, false, false, false)
-	if err != nil {
-		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		http.Error(w, fmt.Sprintf("error retrieving model: %v", err), http.StatusInternalServerError)
-		return
-	}
+type UploadCommitRequest struct {
+	// Optional, these override the values given when the upload was started.
+	TotalChunks int    `json:"total_chunks"`
+	Sha256      string `json:"sha256"`
+}
 
-	if err := s.combineChunks(modelId); err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+func (s *ModelService) UploadCommit(w http.ResponseWriter, r *http.Request) {
+	// The body is optional since older clients do not send one.
+	var params UploadCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("error parsing request body: %v", err), http.StatusBadRequest)
 		return
 	}
-
-	// TODO(Anyone): add checksum
-
-	if err := s.completeUpload(&model); err != nil {
-		http.Error(w, fmt.Sprintf("error completing model upload: %v", err), GetResponseCode(err))
+	if err := (&UploadStartRequest{TotalChunks: params.TotalChunks, Sha256: params.Sha256}).validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	utils.WriteJsonResponse(w, uploadCommitResponse{ModelId: model.Id, ModelType: model.Type})
-}
-
-func (s *ModelService) Download(w http.ResponseWriter, r *http.Request) {
-	modelId, err := utils.URLParamUUID(r, "model_id")
+	modelId, err := auth.ModelIdFromContext(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("error retrieving model id from request: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	model, err := schema.GetModel(modelId, s.db, true, true, false)
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
 	if err != nil {
 		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
 			return
 		}
 		http.Error(w, fmt.Sprintf("error retrieving model: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if model.TrainStatus != schema.Complete {
-		http.Error(w, fmt.Sprintf("can only download model with successfully completed training, model has train status %s", model.TrainStatus), http.StatusUnprocessableEntity)
+	session, err := s.getUploadSession(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
-
-	if len(model.Dependencies) > 0 {
-		http.Error(w, "downloading models with dependencies is not yet supported", http.StatusUnprocessableEntity)
-		return
+	if params.TotalChunks > 0 {
+		session.TotalChunks = params.TotalChunks
 	}
-
-	if err := saveModelMetadata(s.storage, model); err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
-		return
+	expectedSha256 := session.Sha256
+	if params.Sha256 != "" {
+		expectedSha256 = strings.ToLower(params.Sha256)
 	}
 
-	downloadPath := filepath.Join(storage.ModelPath(model.Id), "model")
-	if err := s.storage.Zip(downloadPath); err != nil {
-		slog.Error("error preparing zipfile for model download", "model_id", modelId, "error", err)
-		http.Error(w, "error preparing model download archive", http.StatusInternalServerError)
+	if err := s.combineChunks(session, expectedSha256); err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "http response does not support chunked response.", http.StatusInternalServerError)
+	if err := s.completeUpload(&model); err != nil {
+		writeError(w, fmt.Sprintf("error completing model upload: %v", err), err)
 		return
 	}
 
-	file, err := s.storage.Read(downloadPath + ".zip")
-	if err != nil {
-		slog.Error("error opening model zipfile for download", "model_id", modelId, "error", err)
-		http.Error(w, "error reading model download archive", http.StatusInternalServerError)
-		return
+	if err := s.storage.Delete(uploadChunksDir(modelId)); err != nil {
+		slog.Error("error deleting upload chunks", "model_id", modelId, "error", err)
 	}
-	defer file.Close()
-
-	buffer := bufio.NewReader(file)
-	chunk := make([]byte, 10*1024*1024)
-
-	for {
-		readN, err := buffer.Read(chunk)
-		isEof := err == io.EOF
-		if err != nil && !isEof {
-			slog.Error("error reading chunk of model archive", "model_id", modelId, "error", err)
-			http.Error(w, "error reading from model download archive", http.StatusInternalServerError)
-			return
-		}
-
-		writeN, err := w.Write(chunk[:readN])
-		if err != nil {
-			slog.Error("error writing model download chunk", "model_id", modelId, "error", err)
-			http.Error(w, fmt.Sprintf("error sending model download chunk: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if writeN != readN {
-			slog.Error("error writing model download chunk", "model_id", modelId, "error", fmt.Sprintf("expected to write %d bytes to stream, wrote %d", readN, writeN))
-			http.Error(w, "error sending model download chunk", http.StatusInternalServerError)
-			return
-		}
-		flusher.Flush() // Sends chunk
 
-		if isEof {
-			break
-		}
-	}
+	utils.WriteJsonResponse(w, uploadCommitResponse{ModelId: model.Id, ModelType: model.Type})
 }
 
 type updateAccessRequest struct {
@@ -996,6 +1381,10 @@ func (s *ModelService) UpdateAccess(w http.ResponseWriter, r *http.Request) {
 			return CodedError(err, http.StatusInternalServerError)
 		}
 
+		if model.Locked && reducesAccess(model, params.Access, params.TeamId) {
+			return checkModelUnlocked(model, "made less accessible")
+		}
+
 		model.Access = params.Access
 		if params.Access == schema.Protected {
 			if err := checkTeamExists(txn, *params.TeamId); err != nil {
@@ -1018,10 +1407,10 @@ func (s *ModelService) UpdateAccess(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordModelEvent(txn, schema.ModelUpdatedEvent, model, map[string]interface{}{"access": model.Access})
 	}); err != nil {
 		slog.Error("error updating model access", "model_id", modelId, "access", params.Access, "team_id", params.TeamId, "error", err)
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -1049,14 +1438,21 @@ func (s *ModelService) UpdateDefaultPermission(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	result := s.db.Model(&schema.Model{Id: modelId}).Update("default_permission", params.Permission)
-	if result.Error != nil {
-		slog.Error("sql error updating model default permission", "model_id", modelId, "error", result.Error)
-		http.Error(w, fmt.Sprintf("error updating model default permission: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
-		return
-	}
-	if result.RowsAffected != 1 {
-		http.Error(w, schema.ErrModelNotFound.Error(), http.StatusNotFound)
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Model(&schema.Model{Id: modelId}).Update("default_permission", params.Permission)
+		if result.Error != nil {
+			slog.Error("sql error updating model default permission", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected != 1 {
+			return CodedError(schema.ErrModelNotFound, http.StatusNotFound)
+		}
+
+		return recordEvent(txn, schema.ModelUpdatedEvent, &modelId, nil, map[string]interface{}{"default_permission": params.Permission})
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating model default permission: %v", err), err)
 		return
 	}
 
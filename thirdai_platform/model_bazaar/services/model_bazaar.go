@@ -1,15 +1,18 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"slices"
+	"sync"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/licensing"
 	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/ratelimit"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
@@ -17,22 +20,49 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type ModelBazaar struct {
-	user      UserService
-	team      TeamService
-	model     ModelService
-	train     TrainService
-	deploy    DeployService
-	telemetry TelemetryService
-	workflow  WorkflowService
-	recovery  RecoveryService
+	user       UserService
+	team       TeamService
+	orgs       OrganizationService
+	model      ModelService
+	train      TrainService
+	deploy     DeployService
+	telemetry  TelemetryService
+	workflow   WorkflowService
+	recovery   RecoveryService
+	graphql    GraphQLService
+	events     EventService
+	profiling  ProfilingService
+	reports    ReportService
+	imageScan  ImageScanService
+	jobEnv     JobEnvService
+	certs      TrustedCertService
+	jobRuns    JobRunService
+	webhooks   WebhookService
+	batch      BatchPredictService
+	search     SearchService
+	rateLimits RateLimitService
+	legalHolds LegalHoldService
+	quotas     StorageQuotaService
+	llmCache   LlmCacheService
+	audit      AuditService
+	datasets   DatasetService
+	realm      RealmSettingsService
 
 	db                 *gorm.DB
 	orchestratorClient orchestrator.Client
+	storage            storage.Storage
+	license            *licensing.LicenseVerifier
+	rateLimiter        *ratelimit.Limiter
+	webhookDispatcher  *WebhookDispatcher
+	jobLogRetention    time.Duration
+	auditRetention     time.Duration
 	stop               chan bool
+	tieringStop        chan bool
 }
 
 func NewModelBazaar(
@@ -40,26 +70,81 @@ func NewModelBazaar(
 ) ModelBazaar {
 	jobAuth := auth.NewJwtManager(slices.Concat(secret, []byte("job")))
 
+	webhookDispatcher := NewWebhookDispatcher(db)
+
+	// The limiter is always created, even if no limits are configured, so that
+	// admins can enable rate limits while the server is running.
+	rateLimiter := ratelimit.New(variables.RateLimits)
+	realmSettings, _ := userAuth.(auth.RealmSettingsProvider)
+	accountRecovery, _ := userAuth.(auth.AccountRecoveryProvider)
+	userAuth = rateLimiter.WrapIdentityProvider(userAuth)
+	rateLimits := RateLimitService{db: db, userAuth: userAuth, limiter: rateLimiter, defaults: variables.RateLimits}
+	if err := rateLimits.syncLimiter(); err != nil {
+		slog.Error("error loading rate limits set by admins, using the default limits", "error", err)
+	}
+
+	train := TrainService{
+		db:                 db,
+		orchestratorClient: orchestratorClient,
+		storage:            storage,
+		userAuth:           userAuth,
+		jobAuth:            jobAuth,
+		license:            license,
+		variables:          variables,
+		sweepLock:          &sync.Mutex{},
+		webhooks:           webhookDispatcher,
+	}
+
 	return ModelBazaar{
-		user: UserService{db: db, userAuth: userAuth},
+		user: UserService{db: db, userAuth: userAuth, recovery: accountRecovery, jobLimits: variables.UserJobLimits},
 		team: TeamService{db: db, userAuth: userAuth},
+		orgs: OrganizationService{db: db, userAuth: userAuth, license: license},
 		model: ModelService{
 			db:                 db,
 			orchestratorClient: orchestratorClient,
 			storage:            storage,
 			userAuth:           userAuth,
 			uploadSessionAuth:  auth.NewJwtManager(slices.Concat(secret, []byte("upload"))),
+			rateLimiter:        rateLimiter,
+			downloadLocks:      &downloadLocks{},
+
+			artifactCompression: variables.ArtifactCompression,
+			storageQuotas:       variables.StorageQuotas,
+			storageTiering:      variables.StorageTiering,
+			tieringCheckedAt:    &time.Time{},
 		},
-		train: TrainService{
-			db:                 db,
+		train: train,
+		deploy: DeployService{
+			db:                  db,
+			orchestratorClient:  orchestratorClient,
+			storage:             storage,
+			userAuth:            userAuth,
+			jobAuth:             jobAuth,
+			rateLimiter:         rateLimiter,
+			license:             license,
+			variables:           variables,
+			webhooks:            webhookDispatcher,
+			redactionSecret:     slices.Concat(secret, []byte("redaction")),
+			inactivityCheckedAt: &time.Time{},
+		},
+		telemetry: TelemetryService{
 			orchestratorClient: orchestratorClient,
+			variables:          variables,
+		},
+		workflow: WorkflowService{
+			db:       db,
+			storage:  storage,
+			userAuth: userAuth,
+			train:    train,
+		},
+		recovery: RecoveryService{
+			db:                 db,
 			storage:            storage,
+			orchestratorClient: orchestratorClient,
 			userAuth:           userAuth,
-			jobAuth:            jobAuth,
-			license:            license,
 			variables:          variables,
 		},
-		deploy: DeployService{
+		batch: BatchPredictService{
 			db:                 db,
 			orchestratorClient: orchestratorClient,
 			storage:            storage,
@@ -68,25 +153,40 @@ func NewModelBazaar(
 			license:            license,
 			variables:          variables,
 		},
-		telemetry: TelemetryService{
-			orchestratorClient: orchestratorClient,
-			variables:          variables,
-		},
-		workflow: WorkflowService{
-			db:       db,
-			storage:  storage,
-			userAuth: userAuth,
-		},
-		recovery: RecoveryService{
+		graphql:   GraphQLService{db: db, userAuth: userAuth},
+		events:    EventService{db: db, userAuth: userAuth},
+		profiling: ProfilingService{db: db, storage: storage, userAuth: userAuth, variables: variables},
+		reports: ReportService{
 			db:                 db,
 			storage:            storage,
 			orchestratorClient: orchestratorClient,
+			license:            license,
 			userAuth:           userAuth,
 			variables:          variables,
 		},
+		imageScan:          ImageScanService{db: db, userAuth: userAuth},
+		jobEnv:             JobEnvService{db: db, userAuth: userAuth},
+		certs:              TrustedCertService{db: db, storage: storage, userAuth: userAuth},
+		jobRuns:            JobRunService{db: db, userAuth: userAuth},
+		webhooks:           WebhookService{db: db, userAuth: userAuth},
+		search:             SearchService{db: db, userAuth: userAuth},
+		rateLimits:         rateLimits,
+		legalHolds:         LegalHoldService{db: db, storage: storage, userAuth: userAuth, variables: variables},
+		quotas:             StorageQuotaService{db: db, userAuth: userAuth, quotas: variables.StorageQuotas},
+		llmCache:           LlmCacheService{db: db, userAuth: userAuth, variables: variables},
+		audit:              AuditService{db: db, userAuth: userAuth},
+		datasets:           DatasetService{db: db, userAuth: userAuth},
+		realm:              RealmSettingsService{db: db, userAuth: userAuth, realm: realmSettings},
 		db:                 db,
 		orchestratorClient: orchestratorClient,
+		storage:            storage,
+		license:            license,
+		rateLimiter:        rateLimiter,
+		webhookDispatcher:  webhookDispatcher,
+		jobLogRetention:    variables.JobLogRetention,
+		auditRetention:     variables.AuditRetention,
 		stop:               make(chan bool, 1),
+		tieringStop:        make(chan bool, 1),
 	}
 }
 
@@ -97,23 +197,54 @@ func (m *ModelBazaar) Routes() chi.Router {
 	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{
 		Logger: log.New(os.Stderr, "", log.LstdFlags), NoColor: false,
 	}))
+	r.Use(utils.JsonErrors)
+	r.Use(m.rateLimiter.GlobalMiddleware)
 
 	r.Mount("/user", m.user.Routes())
 	r.Mount("/team", m.team.Routes())
+	r.Mount("/organization", m.orgs.Routes())
 	r.Mount("/model", m.model.Routes())
 	r.Mount("/train", m.train.Routes())
 	r.Mount("/deploy", m.deploy.Routes())
 	r.Mount("/telemetry", m.telemetry.Routes())
 	r.Mount("/workflow", m.workflow.Routes())
 	r.Mount("/recovery", m.recovery.Routes())
+	r.Mount("/graphql", m.graphql.Routes())
+	r.Mount("/events", m.events.Routes())
+	r.Mount("/profiling", m.profiling.Routes())
+	r.Mount("/report", m.reports.Routes())
+	r.Mount("/image-scan", m.imageScan.Routes())
+	r.Mount("/job-env", m.jobEnv.Routes())
+	r.Mount("/trusted-certs", m.certs.Routes())
+	r.Mount("/job-runs", m.jobRuns.Routes())
+	r.Mount("/webhooks", m.webhooks.Routes())
+	r.Mount("/batch-predict", m.batch.Routes())
+	r.Mount("/search", m.search.Routes())
+	r.Mount("/rate-limits", m.rateLimits.Routes())
+	r.Mount("/legal-hold", m.legalHolds.Routes())
+	r.Mount("/storage-quotas", m.quotas.Routes())
+	r.Mount("/llm-cache", m.llmCache.Routes())
+	r.Mount("/admin", m.audit.Routes())
+	r.Mount("/dataset", m.datasets.Routes())
+	r.Mount("/realm-settings", m.realm.Routes())
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		utils.WriteSuccess(w)
 	})
 
+	r.Get("/openapi.json", serveOpenAPISpec)
+	r.Get("/docs", serveSwaggerUI)
+
 	return r
 }
 
+func syncFailureMessage(jobNotFound bool) string {
+	if jobNotFound {
+		return "job was not found in the orchestrator"
+	}
+	return "job is dead in the orchestrator"
+}
+
 func (m *ModelBazaar) syncTrainStatus(model *schema.Model) {
 	if model.TrainStatus != schema.Starting && model.TrainStatus != schema.InProgress {
 		return
@@ -127,9 +258,15 @@ func (m *ModelBazaar) syncTrainStatus(model *schema.Model) {
 	}
 
 	if jobInfo.Status == "dead" || jobNotFound {
-		result := m.db.Model(model).Where("train_status = ?", model.TrainStatus).Update("train_status", schema.Failed)
-		if result.Error != nil {
-			slog.Error("status sync: sql error updating train status for failed training", "model_id", model.Id, "error", result.Error)
+		err := m.db.Transaction(func(txn *gorm.DB) error {
+			err := updateStatus(txn, "train", model, schema.Failed, syncFailureMessage(jobNotFound))
+			if errors.Is(err, errStatusChanged) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			slog.Error("status sync: sql error updating train status for failed training", "model_id", model.Id, "error", err)
 			return
 		}
 		slog.Info("status sync: updated train status to failed", "model_id", model.Id)
@@ -150,13 +287,23 @@ func (m *ModelBazaar) syncDeployStatus(model *schema.Model) {
 	}
 
 	if jobInfo.Status == "dead" || jobNotFound {
-		result := m.db.Model(model).Where("deploy_status = ?", model.DeployStatus).Update("deploy_status", schema.Failed)
-		if result.Error != nil {
-			slog.Error("status sync: sql error updating deploy status for failed deployment", "model_id", model.Id, "error", result.Error)
+		err := m.db.Transaction(func(txn *gorm.DB) error {
+			err := updateStatus(txn, "deploy", model, schema.Failed, syncFailureMessage(jobNotFound))
+			if errors.Is(err, errStatusChanged) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			slog.Error("status sync: sql error updating deploy status for failed deployment", "model_id", model.Id, "error", err)
 			return
 		}
 
 		slog.Info("status sync: updated deploy status to failed", "model_id", model.Id)
+
+		if !jobNotFound {
+			_ = archiveJobLogs(m.db, m.orchestratorClient, m.storage, model.Id, "deploy", model.DeployJobName())
+		}
 	}
 }
 
@@ -177,10 +324,74 @@ func (m *ModelBazaar) statusSync() {
 		m.syncTrainStatus(&model)
 		m.syncDeployStatus(&model)
 	}
+
+	m.archiveCompletedTrainLogs()
+	m.cleanupExpiredJobLogs()
+	m.cleanupExpiredAuditEvents()
+	m.train.startQueuedJobs()
+	m.train.syncSweeps()
+	m.train.runSchedules()
+	m.batch.syncJobs()
+	m.deploy.evaluateSlos()
+	m.deploy.checkInactiveDeployments()
+	m.webhookDispatcher.deliver()
+	m.reports.sendWeeklyHealthReport()
+
+	if err := m.rateLimits.syncLimiter(); err != nil {
+		slog.Error("status sync: error syncing rate limits", "error", err)
+	}
+}
+
+// syncWatchedJob syncs the status of a model as soon as the orchestrator
+// reports that its train or deploy job died, instead of waiting for the next
+// status sync.
+func (m *ModelBazaar) syncWatchedJob(job orchestrator.JobInfo) {
+	if job.Status != orchestrator.StatusDead {
+		return
+	}
+
+	// Train and deploy job names end with the id of the model.
+	if len(job.Name) < 36 {
+		return
+	}
+	modelId, err := uuid.Parse(job.Name[len(job.Name)-36:])
+	if err != nil {
+		return
+	}
+
+	model, err := schema.GetModel(modelId, m.db, false, false, false)
+	if err != nil {
+		if !errors.Is(err, schema.ErrModelNotFound) {
+			slog.Error("status sync: error retrieving model for watched job", "job_name", job.Name, "error", err)
+		}
+		return
+	}
+
+	switch job.Name {
+	case model.TrainJobName():
+		m.syncTrainStatus(&model)
+	case model.DeployJobName():
+		m.syncDeployStatus(&model)
+	}
 }
 
+// JobStatusSync periodically checks that the jobs of models that are training
+// or deployed are still running, and runs the other background tasks of the
+// platform. If the orchestrator can watch jobs, jobs that die are also synced
+// as soon as the orchestrator reports it.
 func (m *ModelBazaar) JobStatusSync(interval time.Duration) {
 	slog.Info("status sync: starting")
+
+	// The watch is stopped when the status sync returns.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if watcher, ok := m.orchestratorClient.(orchestrator.JobWatcher); ok {
+		if err := watcher.WatchJobs(ctx, m.syncWatchedJob); err != nil && !errors.Is(err, orchestrator.ErrWatchNotSupported) {
+			slog.Error("status sync: unable to watch jobs, dead jobs will only be found by polling", "error", err)
+		}
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -198,3 +409,27 @@ func (m *ModelBazaar) JobStatusSync(interval time.Duration) {
 func (m *ModelBazaar) StopJobStatusSync() {
 	close(m.stop)
 }
+
+// StorageTieringSync periodically moves the artifacts of unused models to cold
+// storage and rehydrates models. It runs separately from the status sync since
+// moving the artifacts of a large model can take a long time.
+func (m *ModelBazaar) StorageTieringSync(interval time.Duration) {
+	slog.Info("storage tiering: starting")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.model.syncStorageTiers()
+		case <-m.tieringStop:
+			slog.Info("storage tiering: process stopped")
+			return
+		}
+	}
+}
+
+func (m *ModelBazaar) StopStorageTieringSync() {
+	close(m.tieringStop)
+}
@@ -0,0 +1,437 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	defaultDownloadPartSize = 64 * 1024 * 1024
+	minDownloadPartSize     = 1024 * 1024
+	maxDownloadPartSize     = 1024 * 1024 * 1024
+)
+
+// downloadArchive describes the archive that was created for the download of a
+// model. It is saved next to the archive so that the archive is only recreated
+// if the model changes, since clients that download the archive in parts need
+// every request to read the same archive.
+type downloadArchive struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// Fingerprint identifies the files and metadata of the model the archive
+	// was created from.
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (a downloadArchive) zstd() bool {
+	return strings.HasSuffix(a.Path, storage.ZstdArchiveExt)
+}
+
+// etag is a strong ETag for the bytes of the archive in storage.
+func (a downloadArchive) etag() string {
+	return `"` + a.Sha256 + `"`
+}
+
+func downloadArchiveInfoPath(modelId uuid.UUID) string {
+	return filepath.Join(storage.ModelPath(modelId), "download_archive.json")
+}
+
+// downloadLocks serializes the preparation of the download archive of each
+// model, so that concurrent downloads do not recreate the archive while it is
+// being read.
+type downloadLocks struct {
+	mu    sync.Mutex
+	locks map[uuid.UUID]*sync.Mutex
+}
+
+func (l *downloadLocks) lock(modelId uuid.UUID) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[uuid.UUID]*sync.Mutex)
+	}
+	lock, ok := l.locks[modelId]
+	if !ok {
+		lock = new(sync.Mutex)
+		l.locks[modelId] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// modelFingerprint hashes the metadata and the names and sizes of the files of
+// the model, excluding the metadata file which is rewritten for each archive.
+func (s *ModelService) modelFingerprint(modelId uuid.UUID, dir string, metadata []byte) (string, error) {
+	files, err := s.storage.ListFiles(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	slices.Sort(files)
+
+	hash := sha256.New()
+	hash.Write(metadata)
+	metadataFile := filepath.Base(storage.ModelMetadataPath(modelId))
+	for _, file := range files {
+		if file == metadataFile {
+			continue
+		}
+		size, err := s.storage.Size(filepath.Join(dir, file))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, size)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// existingDownloadArchive returns the archive of the model if it was created
+// from the current files of the model and is still in storage.
+func (s *ModelService) existingDownloadArchive(modelId uuid.UUID, fingerprint string) (downloadArchive, bool) {
+	file, err := s.storage.Read(downloadArchiveInfoPath(modelId))
+	if err != nil {
+		return downloadArchive{}, false
+	}
+	defer file.Close()
+
+	var archive downloadArchive
+	if err := json.NewDecoder(file).Decode(&archive); err != nil {
+		slog.Warn("invalid download archive info, recreating archive", "model_id", modelId, "error", err)
+		return downloadArchive{}, false
+	}
+
+	if archive.Fingerprint != fingerprint {
+		return downloadArchive{}, false
+	}
+	if size, err := s.storage.Size(archive.Path); err != nil || size != archive.Size {
+		return downloadArchive{}, false
+	}
+	return archive, true
+}
+
+// prepareDownloadArchive returns the archive for a model download, creating it
+// if the model does not have an archive or has changed since it was created.
+func (s *ModelService) prepareDownloadArchive(modelId uuid.UUID) (downloadArchive, error) {
+	model, err := schema.GetModel(modelId, s.db, true, true, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return downloadArchive{}, CodedError(err, http.StatusNotFound)
+		}
+		return downloadArchive{}, CodedError(fmt.Errorf("error retrieving model: %w", err), http.StatusInternalServerError)
+	}
+
+	if model.TrainStatus != schema.Complete {
+		return downloadArchive{}, CodedError(fmt.Errorf("can only download model with successfully completed training, model has train status %s", model.TrainStatus), http.StatusUnprocessableEntity)
+	}
+
+	if len(model.Dependencies) > 0 {
+		return downloadArchive{}, CodedError(errors.New("downloading models with dependencies is not yet supported"), http.StatusUnprocessableEntity)
+	}
+
+	if err := checkModelHot(s.db, modelId, "downloaded"); err != nil {
+		return downloadArchive{}, err
+	}
+	if err := touchModelStorageTier(s.db, modelId); err != nil {
+		return downloadArchive{}, err
+	}
+
+	metadata, err := encodeModelMetadata(model)
+	if err != nil {
+		return downloadArchive{}, err
+	}
+
+	unlock := s.downloadLocks.lock(modelId)
+	defer unlock()
+
+	downloadPath := filepath.Join(storage.ModelPath(model.Id), "model")
+
+	fingerprint, err := s.modelFingerprint(modelId, downloadPath, metadata)
+	if err != nil {
+		slog.Error("error listing model files for download", "model_id", modelId, "error", err)
+		return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+	}
+
+	if archive, ok := s.existingDownloadArchive(modelId, fingerprint); ok {
+		return archive, nil
+	}
+
+	if err := saveModelMetadata(s.storage, model.Id, metadata); err != nil {
+		return downloadArchive{}, err
+	}
+
+	archive := downloadArchive{Fingerprint: fingerprint, CreatedAt: time.Now().UTC()}
+	if s.artifactCompression == ArtifactCompressionZstd {
+		if err := storage.ZipZstd(s.storage, downloadPath); err != nil {
+			slog.Error("error preparing zstd archive for model download", "model_id", modelId, "error", err)
+			return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+		}
+		archive.Path = downloadPath + storage.ZstdArchiveExt
+	} else {
+		if err := s.storage.Zip(downloadPath); err != nil {
+			slog.Error("error preparing zipfile for model download", "model_id", modelId, "error", err)
+			return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+		}
+		archive.Path = downloadPath + ".zip"
+	}
+
+	// The checksum is computed once when the archive is created, so that
+	// clients can verify downloads that were done in parts.
+	file, err := s.storage.Read(archive.Path)
+	if err != nil {
+		slog.Error("error opening model archive for checksum", "model_id", modelId, "error", err)
+		return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	archive.Size, err = io.Copy(hash, file)
+	if err != nil {
+		slog.Error("error computing checksum of model archive", "model_id", modelId, "error", err)
+		return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+	}
+	archive.Sha256 = hex.EncodeToString(hash.Sum(nil))
+
+	info, err := json.Marshal(archive)
+	if err != nil {
+		return downloadArchive{}, CodedError(fmt.Errorf("error serializing download archive info: %w", err), http.StatusInternalServerError)
+	}
+	if err := s.storage.Write(downloadArchiveInfoPath(modelId), bytes.NewReader(info)); err != nil {
+		slog.Error("error saving download archive info", "model_id", modelId, "error", err)
+		return downloadArchive{}, CodedError(errors.New("error preparing model download archive"), http.StatusInternalServerError)
+	}
+
+	slog.Info("created model download archive", "model_id", modelId, "path", archive.Path, "size", archive.Size)
+
+	return archive, nil
+}
+
+// archiveReadSeeker reads an archive in storage from the offset it is seeked
+// to, so that http.ServeContent can serve range requests without reading the
+// parts of the archive before the range.
+type archiveReadSeeker struct {
+	storage storage.Storage
+	path    string
+	size    int64
+
+	offset int64
+	reader io.ReadCloser
+}
+
+func (a *archiveReadSeeker) Read(p []byte) (int, error) {
+	if a.reader == nil {
+		if a.offset >= a.size {
+			return 0, io.EOF
+		}
+		reader, err := storage.ReadRange(a.storage, a.path, a.offset)
+		if err != nil {
+			return 0, err
+		}
+		a.reader = reader
+	}
+	n, err := a.reader.Read(p)
+	a.offset += int64(n)
+	return n, err
+}
+
+func (a *archiveReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.offset
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if offset != a.offset {
+		a.Close()
+		a.offset = offset
+	}
+	return offset, nil
+}
+
+func (a *archiveReadSeeker) Close() error {
+	if a.reader == nil {
+		return nil
+	}
+	err := a.reader.Close()
+	a.reader = nil
+	return err
+}
+
+// Download sends the model archive. Range requests are supported for the
+// archive as it is stored, so clients can download it in parts and resume
+// interrupted downloads. Archives stored with zstd are sent as is to clients
+// that accept zstd, and are decompressed for other clients, which then receive
+// a plain zip without range support.
+func (s *ModelService) Download(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := s.prepareDownloadArchive(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if archive.zstd() {
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !utils.AcceptsEncoding(r, "zstd") {
+			s.sendDecompressedArchive(w, modelId, archive)
+			return
+		}
+		w = &contentEncodingWriter{ResponseWriter: w, encoding: "zstd"}
+	}
+
+	reader := &archiveReadSeeker{storage: s.storage, path: archive.Path, size: archive.Size}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("ETag", archive.etag())
+	http.ServeContent(w, r, "", time.Time{}, reader)
+}
+
+// contentEncodingWriter sets the Content-Encoding of responses with content
+// once their headers are written. http.ServeContent does not send the
+// Content-Length of responses that already have a Content-Encoding, since it
+// assumes the content would be encoded as it is sent.
+type contentEncodingWriter struct {
+	http.ResponseWriter
+	encoding string
+}
+
+func (w *contentEncodingWriter) WriteHeader(status int) {
+	if status == http.StatusOK || status == http.StatusPartialContent {
+		w.Header().Set("Content-Encoding", w.encoding)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *contentEncodingWriter) Write(data []byte) (int, error) {
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *contentEncodingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *ModelService) sendDecompressedArchive(w http.ResponseWriter, modelId uuid.UUID, archive downloadArchive) {
+	file, err := s.storage.Read(archive.Path)
+	if err != nil {
+		slog.Error("error opening model archive for download", "model_id", modelId, "error", err)
+		http.Error(w, "error reading model download archive", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		slog.Error("error decompressing model archive for download", "model_id", modelId, "error", err)
+		http.Error(w, "error reading model download archive", http.StatusInternalServerError)
+		return
+	}
+	defer decoder.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Accept-Ranges", "none")
+	if _, err := io.Copy(w, decoder); err != nil {
+		slog.Error("error sending model download", "model_id", modelId, "error", err)
+	}
+}
+
+type DownloadPart struct {
+	Index  int   `json:"index"`
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+type DownloadManifest struct {
+	// The size and checksum of the archive as it is stored, which is what range
+	// requests to the download endpoint return.
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	ETag   string `json:"etag"`
+	// ContentEncoding is zstd if the archive is stored with zstd, range
+	// requests must then accept zstd, and the archive must be decompressed
+	// once all parts are downloaded.
+	ContentEncoding string         `json:"content_encoding,omitempty"`
+	PartSize        int64          `json:"part_size"`
+	Parts           []DownloadPart `json:"parts"`
+}
+
+// DownloadManifest describes the download archive of the model and splits it
+// into parts, so that clients can download the parts in parallel with range
+// requests, and resume the download by only requesting the missing parts.
+func (s *ModelService) DownloadManifest(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	partSize := int64(defaultDownloadPartSize)
+	if value := r.URL.Query().Get("part_size"); value != "" {
+		partSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || partSize < minDownloadPartSize || partSize > maxDownloadPartSize {
+			http.Error(w, fmt.Sprintf("part_size must be an integer between %d and %d", minDownloadPartSize, maxDownloadPartSize), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	archive, err := s.prepareDownloadArchive(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	manifest := DownloadManifest{
+		Size:     archive.Size,
+		Sha256:   archive.Sha256,
+		ETag:     archive.etag(),
+		PartSize: partSize,
+		Parts:    make([]DownloadPart, 0, (archive.Size+partSize-1)/partSize),
+	}
+	if archive.zstd() {
+		manifest.ContentEncoding = "zstd"
+	}
+	for offset := int64(0); offset < archive.Size; offset += partSize {
+		manifest.Parts = append(manifest.Parts, DownloadPart{
+			Index:  len(manifest.Parts),
+			Offset: offset,
+			Length: min(partSize, archive.Size-offset),
+		})
+	}
+
+	utils.WriteJsonResponse(w, manifest)
+}
@@ -0,0 +1,484 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Exports are zip archives with a manifest describing the models, and the
+// artifacts of each model under models/{model_id}/.
+const (
+	exportManifestFile    = "manifest.json"
+	exportManifestVersion = 1
+)
+
+type exportManifest struct {
+	Version int             `json:"version"`
+	Models  []exportedModel `json:"models"`
+}
+
+// exportedModel describes a model in an export, the ids are the ids of the
+// models in the platform they were exported from. The models in the manifest
+// are ordered so that the dependencies of a model come before it.
+type exportedModel struct {
+	ModelId      uuid.UUID         `json:"model_id"`
+	ModelName    string            `json:"model_name"`
+	Type         string            `json:"type"`
+	Attributes   map[string]string `json:"attributes"`
+	Dependencies []uuid.UUID       `json:"dependencies"`
+	BaseModelId  *uuid.UUID        `json:"base_model_id"`
+}
+
+func exportModelDir(modelId uuid.UUID) string {
+	return path.Join("models", modelId.String())
+}
+
+type ExportRequest struct {
+	ModelIds []uuid.UUID `json:"model_ids" validate:"required,min=1"`
+}
+
+// exportModels returns the models to export in dependency order. The
+// dependencies of the requested models are included since the models cannot be
+// used without them.
+func (s *ModelService) exportModels(user schema.User, modelIds []uuid.UUID) ([]exportedModel, error) {
+	visited := make(map[uuid.UUID]bool)
+	exported := make([]exportedModel, 0, len(modelIds))
+
+	var visit func(modelId uuid.UUID) error
+	visit = func(modelId uuid.UUID) error {
+		if visited[modelId] {
+			return nil
+		}
+		visited[modelId] = true
+
+		model, err := schema.GetModel(modelId, s.db, true, true, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedErrorWithErrorCode(fmt.Errorf("model %v does not exist", modelId), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+			}
+			return CodedError(fmt.Errorf("error retrieving model %v: %w", modelId, err), http.StatusInternalServerError)
+		}
+
+		perm, err := auth.GetModelPermissions(modelId, user, s.db)
+		if err != nil {
+			slog.Error("error checking permissions for model export", "model_id", modelId, "error", err)
+			return CodedError(fmt.Errorf("error verifying permissions for model %v", modelId), http.StatusInternalServerError)
+		}
+		if perm < auth.ReadPermission {
+			return CodedError(fmt.Errorf("user does not have permission to access model %v", modelId), http.StatusForbidden)
+		}
+
+		if model.TrainStatus != schema.Complete {
+			return CodedError(fmt.Errorf("can only export models with successfully completed training, model %v has train status %s", modelId, model.TrainStatus), http.StatusUnprocessableEntity)
+		}
+
+		if err := checkModelHot(s.db, modelId, "exported"); err != nil {
+			return err
+		}
+
+		deps := make([]uuid.UUID, 0, len(model.Dependencies))
+		for _, dep := range model.Dependencies {
+			if err := visit(dep.DependencyId); err != nil {
+				return err
+			}
+			deps = append(deps, dep.DependencyId)
+		}
+
+		exported = append(exported, exportedModel{
+			ModelId:      model.Id,
+			ModelName:    model.Name,
+			Type:         model.Type,
+			Attributes:   model.GetAttributes(),
+			Dependencies: deps,
+			BaseModelId:  model.BaseModelId,
+		})
+		return nil
+	}
+
+	for _, modelId := range modelIds {
+		if err := visit(modelId); err != nil {
+			return nil, err
+		}
+	}
+
+	// The base model is only kept if it is part of the export, otherwise the
+	// imported model would reference a model that does not exist.
+	for i := range exported {
+		if base := exported[i].BaseModelId; base != nil && !visited[*base] {
+			exported[i].BaseModelId = nil
+		}
+	}
+
+	return exported, nil
+}
+
+func (s *ModelService) writeExportArchive(w io.Writer, manifest exportManifest) error {
+	archive := zip.NewWriter(w)
+
+	manifestWriter, err := archive.Create(exportManifestFile)
+	if err != nil {
+		return fmt.Errorf("error adding manifest to archive: %w", err)
+	}
+	if err := json.NewEncoder(manifestWriter).Encode(manifest); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	for _, model := range manifest.Models {
+		modelDir := filepath.Join(storage.ModelPath(model.ModelId), "model")
+		exists, err := s.storage.Exists(modelDir)
+		if err != nil {
+			return fmt.Errorf("error checking artifacts of model %v: %w", model.ModelId, err)
+		}
+		if !exists {
+			// Workflows such as enterprise search have no artifacts.
+			continue
+		}
+
+		files, err := s.storage.ListFiles(modelDir)
+		if err != nil {
+			return fmt.Errorf("error listing artifacts of model %v: %w", model.ModelId, err)
+		}
+
+		for _, file := range files {
+			if err := s.addExportFile(archive, model.ModelId, modelDir, file); err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+func (s *ModelService) addExportFile(archive *zip.Writer, modelId uuid.UUID, modelDir, file string) error {
+	src, err := s.storage.Read(filepath.Join(modelDir, file))
+	if err != nil {
+		return fmt.Errorf("error reading artifact %v of model %v: %w", file, modelId, err)
+	}
+	defer src.Close()
+
+	dst, err := archive.Create(path.Join(exportModelDir(modelId), filepath.ToSlash(file)))
+	if err != nil {
+		return fmt.Errorf("error adding artifact %v of model %v to archive: %w", file, modelId, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("error writing artifact %v of model %v to archive: %w", file, modelId, err)
+	}
+	return nil
+}
+
+func (s *ModelService) Export(w http.ResponseWriter, r *http.Request) {
+	var params ExportRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid export request: %v", err), err)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	models, err := s.exportModels(user, params.ModelIds)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error exporting models: %v", err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="models.zip"`)
+
+	// Once the archive has started streaming the status can no longer be
+	// changed, so errors can only be logged and the client sees a truncated
+	// archive.
+	if err := s.writeExportArchive(w, exportManifest{Version: exportManifestVersion, Models: models}); err != nil {
+		slog.Error("error writing model export archive", "error", err)
+	}
+}
+
+type ImportedModel struct {
+	SourceModelId uuid.UUID `json:"source_model_id"`
+	ModelId       uuid.UUID `json:"model_id"`
+	ModelName     string    `json:"model_name"`
+	Type          string    `json:"type"`
+}
+
+type ImportResponse struct {
+	Models []ImportedModel `json:"models"`
+}
+
+func readExportManifest(archive *zip.Reader) (exportManifest, error) {
+	file, err := archive.Open(exportManifestFile)
+	if err != nil {
+		return exportManifest{}, CodedError(fmt.Errorf("archive does not contain %v", exportManifestFile), http.StatusUnprocessableEntity)
+	}
+	defer file.Close()
+
+	var manifest exportManifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return exportManifest{}, CodedError(fmt.Errorf("error parsing %v: %w", exportManifestFile, err), http.StatusUnprocessableEntity)
+	}
+
+	if manifest.Version != exportManifestVersion {
+		return exportManifest{}, CodedError(fmt.Errorf("unsupported export version %d", manifest.Version), http.StatusUnprocessableEntity)
+	}
+	if len(manifest.Models) == 0 {
+		return exportManifest{}, CodedError(errors.New("archive does not contain any models"), http.StatusUnprocessableEntity)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for _, model := range manifest.Models {
+		if model.ModelName == "" || model.Type == "" {
+			return exportManifest{}, CodedError(fmt.Errorf("model %v must have a name and type", model.ModelId), http.StatusUnprocessableEntity)
+		}
+		if !slices.Contains(importableModelTypes, model.Type) {
+			return exportManifest{}, CodedError(fmt.Errorf("model %v has unsupported type '%v'", model.ModelId, model.Type), http.StatusUnprocessableEntity)
+		}
+		if seen[model.ModelId] {
+			return exportManifest{}, CodedError(fmt.Errorf("model %v is specified multiple times", model.ModelId), http.StatusUnprocessableEntity)
+		}
+		for _, dep := range model.Dependencies {
+			if !seen[dep] {
+				return exportManifest{}, CodedError(fmt.Errorf("dependency %v of model %v must come before it in the archive", dep, model.ModelId), http.StatusUnprocessableEntity)
+			}
+		}
+		// Otherwise an imported workflow could use models that the user
+		// importing it does not have access to.
+		for _, key := range modelReferenceAttributes {
+			if value, ok := model.Attributes[key]; ok {
+				if id, err := uuid.Parse(value); err != nil || !seen[id] {
+					return exportManifest{}, CodedError(fmt.Errorf("%v of model %v must be a model that comes before it in the archive", key, model.ModelId), http.StatusUnprocessableEntity)
+				}
+			}
+		}
+		seen[model.ModelId] = true
+	}
+
+	return manifest, nil
+}
+
+// Models that are still uploading cannot be exported, so they are not imported.
+var importableModelTypes = []string{
+	schema.NdbModel, schema.NlpTokenModel, schema.NlpTextModel, schema.NlpDocModel, schema.EnterpriseSearch,
+	schema.KnowledgeExtraction, schema.EnsembleModel, schema.ExternalModel,
+}
+
+// The attributes of workflows that reference the models they use.
+var modelReferenceAttributes = []string{"retrieval_id", "guardrail_id"}
+
+// validateImportedModel applies the checks for uploaded models to an imported
+// model once its artifacts are saved.
+func (s *ModelService) validateImportedModel(model exportedModel, newId uuid.UUID) error {
+	if err := checkStorageQuota(s.db, s.storage, s.storageQuotas, newId, model.Type); err != nil {
+		return err
+	}
+	if model.Type == schema.ExternalModel {
+		if err := validateExternalModel(s.storage, newId, model.Attributes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importedArtifactPath returns the storage path for a file in the archive, or
+// an empty path if the file is not a model artifact.
+func importedArtifactPath(name string, newIds map[uuid.UUID]uuid.UUID) (string, error) {
+	rest, ok := strings.CutPrefix(name, "models/")
+	if !ok {
+		return "", nil
+	}
+	rawId, file, ok := strings.Cut(rest, "/")
+	if !ok || file == "" {
+		return "", nil
+	}
+
+	sourceId, err := uuid.Parse(rawId)
+	if err != nil {
+		return "", CodedError(fmt.Errorf("invalid model id in archive path %v", name), http.StatusUnprocessableEntity)
+	}
+	newId, ok := newIds[sourceId]
+	if !ok {
+		return "", CodedError(fmt.Errorf("archive contains artifacts for model %v which is not in the manifest", sourceId), http.StatusUnprocessableEntity)
+	}
+	if !filepath.IsLocal(file) {
+		return "", CodedError(fmt.Errorf("invalid archive path %v", name), http.StatusUnprocessableEntity)
+	}
+
+	return filepath.Join(storage.ModelPath(newId), "model", filepath.FromSlash(file)), nil
+}
+
+func (s *ModelService) importArtifacts(archive *zip.Reader, newIds map[uuid.UUID]uuid.UUID) error {
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		dst, err := importedArtifactPath(file.Name, newIds)
+		if err != nil {
+			return err
+		}
+		if dst == "" {
+			continue
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return CodedError(fmt.Errorf("error reading %v from archive: %w", file.Name, err), http.StatusUnprocessableEntity)
+		}
+		err = s.storage.Write(dst, src)
+		src.Close()
+		if err != nil {
+			slog.Error("error writing imported model artifact", "path", dst, "error", err)
+			return CodedError(errors.New("error saving model artifacts"), http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+// importedModel creates the model for an entry in the manifest. References to
+// other models in the archive, through dependencies, the base model, or
+// attributes such as the retrieval_id of enterprise search, are replaced with
+// the ids of the new models.
+func importedModel(model exportedModel, newIds map[uuid.UUID]uuid.UUID, userId uuid.UUID) schema.Model {
+	newId := newIds[model.ModelId]
+
+	var baseModelId *uuid.UUID
+	if model.BaseModelId != nil {
+		if id, ok := newIds[*model.BaseModelId]; ok {
+			baseModelId = &id
+		}
+	}
+
+	imported := newModel(newId, model.ModelName, model.Type, baseModelId, userId)
+	imported.TrainStatus = schema.Complete
+
+	imported.Dependencies = make([]schema.ModelDependency, 0, len(model.Dependencies))
+	for _, dep := range model.Dependencies {
+		imported.Dependencies = append(imported.Dependencies, schema.ModelDependency{ModelId: newId, DependencyId: newIds[dep]})
+	}
+
+	imported.Attributes = make([]schema.ModelAttribute, 0, len(model.Attributes))
+	for key, value := range model.Attributes {
+		for sourceId, id := range newIds {
+			value = strings.ReplaceAll(value, sourceId.String(), id.String())
+		}
+		imported.Attributes = append(imported.Attributes, schema.ModelAttribute{ModelId: newId, Key: key, Value: value})
+	}
+
+	return imported
+}
+
+func (s *ModelService) importModels(archive *zip.Reader, user schema.User) ([]ImportedModel, error) {
+	manifest, err := readExportManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	newIds := make(map[uuid.UUID]uuid.UUID, len(manifest.Models))
+	for _, model := range manifest.Models {
+		newIds[model.ModelId] = uuid.New()
+	}
+
+	cleanup := func() {
+		for _, id := range newIds {
+			if err := s.storage.Delete(storage.ModelPath(id)); err != nil {
+				slog.Error("error deleting artifacts of failed import", "model_id", id, "error", err)
+			}
+		}
+	}
+
+	if err := s.importArtifacts(archive, newIds); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	for _, model := range manifest.Models {
+		if err := s.validateImportedModel(model, newIds[model.ModelId]); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("invalid model %v: %w", model.ModelId, err)
+		}
+	}
+
+	imported := make([]ImportedModel, 0, len(manifest.Models))
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		for _, model := range manifest.Models {
+			created := importedModel(model, newIds, user.Id)
+			if err := saveModel(txn, created, user); err != nil {
+				return err
+			}
+			imported = append(imported, ImportedModel{
+				SourceModelId: model.ModelId,
+				ModelId:       created.Id,
+				ModelName:     created.Name,
+				Type:          created.Type,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	return imported, nil
+}
+
+func (s *ModelService) Import(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Reading a zip archive requires random access, so the archive is saved to
+	// a local file before it is opened.
+	file, err := os.CreateTemp("", "model-import-*.zip")
+	if err != nil {
+		slog.Error("error creating file for model import", "error", err)
+		http.Error(w, "error saving import archive", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := io.Copy(file, r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading import archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid import archive: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	models, err := s.importModels(archive, user)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error importing models: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, ImportResponse{Models: models})
+}
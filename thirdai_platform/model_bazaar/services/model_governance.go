@@ -0,0 +1,247 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Only models that are approved for production can be deployed with this
+// environment label.
+const ProductionEnvironment = "prod"
+
+type ModelGovernanceInfo struct {
+	ModelId               uuid.UUID  `json:"model_id"`
+	RiskTier              string     `json:"risk_tier"`
+	ApprovedForProduction bool       `json:"approved_for_production"`
+	ApprovedBy            *uuid.UUID `json:"approved_by"`
+	Approver              string     `json:"approver"`
+	ApprovedAt            *time.Time `json:"approved_at"`
+	ReviewDate            *time.Time `json:"review_date"`
+	// ReviewOverdue is true if the review date has passed, in which case the
+	// approval has lapsed until the model is reviewed again.
+	ReviewOverdue bool `json:"review_overdue"`
+	// CanApprove is true if the user making the request can approve the model.
+	CanApprove bool `json:"can_approve"`
+}
+
+func newModelGovernanceInfo(governance schema.ModelGovernance, now time.Time) ModelGovernanceInfo {
+	info := ModelGovernanceInfo{
+		ModelId:               governance.ModelId,
+		RiskTier:              governance.RiskTier,
+		ApprovedForProduction: governance.ApprovedForProduction,
+		ApprovedBy:            governance.ApprovedBy,
+		ApprovedAt:            utcTime(governance.ApprovedAt),
+		ReviewDate:            utcTime(governance.ReviewDate),
+		ReviewOverdue:         governance.ReviewDate != nil && governance.ReviewDate.Before(now),
+	}
+	if governance.Approver != nil {
+		info.Approver = governance.Approver.Username
+	}
+	return info
+}
+
+// getModelGovernance returns the governance metadata of the model, models
+// without metadata have an empty row.
+func getModelGovernance(db *gorm.DB, modelId uuid.UUID) (schema.ModelGovernance, error) {
+	var rows []schema.ModelGovernance
+	if err := db.Preload("Approver").Limit(1).Find(&rows, "model_id = ?", modelId).Error; err != nil {
+		slog.Error("sql error retrieving model governance", "model_id", modelId, "error", err)
+		return schema.ModelGovernance{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(rows) == 0 {
+		return schema.ModelGovernance{ModelId: modelId}, nil
+	}
+	return rows[0], nil
+}
+
+// canApproveModel returns if the user can approve the model for production,
+// which requires being an admin or an admin of the team of the model.
+func canApproveModel(db *gorm.DB, model schema.Model, user schema.User) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+	if model.TeamId == nil {
+		return false, nil
+	}
+	userTeam, err := schema.GetUserTeam(*model.TeamId, user.Id, db)
+	if err != nil {
+		if errors.Is(err, schema.ErrUserTeamNotFound) {
+			return false, nil
+		}
+		return false, CodedError(err, http.StatusInternalServerError)
+	}
+	return userTeam.IsTeamAdmin, nil
+}
+
+// checkApprovedForProduction returns an error if the model cannot be deployed
+// to the production environment, either because it was never approved or
+// because its review date has passed.
+func checkApprovedForProduction(db *gorm.DB, modelId uuid.UUID) error {
+	governance, err := getModelGovernance(db, modelId)
+	if err != nil {
+		return err
+	}
+	if !governance.ApprovedForProduction {
+		return CodedErrorWithErrorCode(fmt.Errorf("model %v is not approved for production and cannot be deployed to the '%v' environment", modelId, ProductionEnvironment), http.StatusUnprocessableEntity, utils.ErrorCodeModelNotApproved)
+	}
+	if governance.ReviewDate != nil && governance.ReviewDate.Before(time.Now()) {
+		return CodedErrorWithErrorCode(fmt.Errorf("the review date of model %v has passed, it must be reviewed before it can be deployed to the '%v' environment", modelId, ProductionEnvironment), http.StatusUnprocessableEntity, utils.ErrorCodeModelNotApproved)
+	}
+	return nil
+}
+
+func (s *ModelService) GetGovernance(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	governance, err := getModelGovernance(s.db, modelId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving model governance: %v", err), err)
+		return
+	}
+
+	info := newModelGovernanceInfo(governance, time.Now())
+	info.CanApprove, err = canApproveModel(s.db, model, user)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error checking approval permission: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
+
+type updateGovernanceRequest struct {
+	RiskTier              string     `json:"risk_tier" validate:"oneof=low medium high"`
+	ApprovedForProduction bool       `json:"approved_for_production"`
+	ReviewDate            *time.Time `json:"review_date"`
+}
+
+// UpdateGovernance replaces the governance metadata of the model. Owners can
+// edit the metadata of models that are not approved, approving a model, and
+// editing or revoking the approval, requires being an approver.
+func (s *ModelService) UpdateGovernance(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params updateGovernanceRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	errs := validation.Struct(&params)
+	if params.ApprovedForProduction && params.RiskTier == "" {
+		errs.Add("risk_tier", "a risk tier must be assigned before the model is approved for production")
+	}
+	if params.ApprovedForProduction && params.ReviewDate != nil && params.ReviewDate.Before(time.Now()) {
+		errs.Add("review_date", "the review date of an approved model must be in the future")
+	}
+	if err := errs.Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid governance metadata: %v", err), err)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var info ModelGovernanceInfo
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		governance, err := getModelGovernance(txn, modelId)
+		if err != nil {
+			return err
+		}
+
+		canApprove, err := canApproveModel(txn, model, user)
+		if err != nil {
+			return err
+		}
+		if (governance.ApprovedForProduction || params.ApprovedForProduction) && !canApprove {
+			return CodedError(fmt.Errorf("user %v must be an admin or an admin of the team of model %v to approve it or edit its approval", user.Id, modelId), http.StatusForbidden)
+		}
+
+		// The approver is the user that last saved the approved metadata, so
+		// that updating the review date records who reviewed the model.
+		now := time.Now().UTC()
+		governance.RiskTier = params.RiskTier
+		governance.ReviewDate = utcTime(params.ReviewDate)
+		governance.ApprovedForProduction = params.ApprovedForProduction
+		if params.ApprovedForProduction {
+			governance.ApprovedBy = &user.Id
+			governance.Approver = &user
+			governance.ApprovedAt = &now
+		} else {
+			governance.ApprovedBy = nil
+			governance.Approver = nil
+			governance.ApprovedAt = nil
+		}
+		governance.UpdatedBy = &user.Id
+		governance.UpdatedAt = now
+
+		if err := txn.Omit("Model", "Approver").Save(&governance).Error; err != nil {
+			slog.Error("sql error saving model governance", "model_id", modelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		info = newModelGovernanceInfo(governance, now)
+		info.CanApprove = canApprove
+
+		data := map[string]interface{}{
+			"user_id":                 user.Id,
+			"username":                user.Username,
+			"risk_tier":               governance.RiskTier,
+			"approved_for_production": governance.ApprovedForProduction,
+			"review_date":             governance.ReviewDate,
+		}
+		return recordModelEvent(txn, schema.ModelGovernanceEvent, model, data)
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating model governance: %v", err), err)
+		return
+	}
+
+	slog.Info("updated model governance", "model_id", modelId, "risk_tier", info.RiskTier, "approved_for_production", info.ApprovedForProduction, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, info)
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Models are retrained by creating a new model with the previous model as its
+// base model, so the versions of a model form a tree. Trees are expected to be
+// small, the limit prevents a bad base model reference from walking the whole
+// models table.
+const maxLineageSize = 10000
+
+// latestAliasSuffix can be used instead of a model id in model and deploy
+// routes to refer to the most recent completed version of a model.
+const latestAliasSuffix = ":latest"
+
+type LineageNode struct {
+	ModelId      uuid.UUID      `json:"model_id"`
+	ModelName    string         `json:"model_name"`
+	Type         string         `json:"type"`
+	Username     string         `json:"username"`
+	TrainStatus  string         `json:"train_status"`
+	DeployStatus string         `json:"deploy_status"`
+	PublishedAt  time.Time      `json:"published_at"`
+	BaseModelId  *uuid.UUID     `json:"base_model_id"`
+	Children     []*LineageNode `json:"children"`
+
+	// Deprecated: the same as published_at, kept for older clients.
+	PublishDate time.Time `json:"publish_date" deprecated:"true"`
+}
+
+type LineageResponse struct {
+	ModelId uuid.UUID `json:"model_id"`
+	// The oldest ancestor of the model the user can access, and all of its
+	// descendants the user can access.
+	Root *LineageNode `json:"root"`
+	// The most recent completed version of the model, out of the model and its
+	// descendants. Null if none of them have completed training.
+	Latest *LineageNode `json:"latest"`
+}
+
+type lineageModel struct {
+	Id            uuid.UUID
+	Name          string
+	Type          string
+	TrainStatus   string
+	DeployStatus  string
+	PublishedDate time.Time
+	BaseModelId   *uuid.UUID
+	Username      string
+}
+
+func loadLineageModels(db *gorm.DB, column string, ids []uuid.UUID) ([]lineageModel, error) {
+	var models []lineageModel
+	result := db.Table("models").
+		Select("models.id, models.name, models.type, models.train_status, models.deploy_status, models.published_date, models.base_model_id, users.username").
+		Joins("LEFT JOIN users ON users.id = models.user_id").
+		Where("models."+column+" IN ?", ids).
+		Order("models.published_date").Order("models.id").
+		Scan(&models)
+	if result.Error != nil {
+		slog.Error("sql error loading model lineage", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return models, nil
+}
+
+// modelLineage returns the tree of versions containing the model, and the node
+// of the model in the tree. Models the user cannot read are left out of the
+// tree, along with their descendants.
+func modelLineage(db *gorm.DB, modelId uuid.UUID, user schema.User) (*LineageNode, *LineageNode, error) {
+	// The ids are kept in the order the models are loaded, so that children are
+	// ordered by publish date.
+	nodes := make(map[uuid.UUID]*LineageNode)
+	ids := make([]uuid.UUID, 0)
+	addNodes := func(models []lineageModel) int {
+		added := 0
+		for _, m := range models {
+			if _, ok := nodes[m.Id]; ok {
+				continue
+			}
+			nodes[m.Id] = &LineageNode{
+				ModelId:      m.Id,
+				ModelName:    m.Name,
+				Type:         m.Type,
+				Username:     m.Username,
+				TrainStatus:  m.TrainStatus,
+				DeployStatus: m.DeployStatus,
+				PublishedAt:  m.PublishedDate.UTC(),
+				PublishDate:  m.PublishedDate.UTC(),
+				BaseModelId:  m.BaseModelId,
+				Children:     []*LineageNode{},
+			}
+			ids = append(ids, m.Id)
+			added++
+		}
+		return added
+	}
+
+	models, err := loadLineageModels(db, "id", []uuid.UUID{modelId})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(models) == 0 {
+		return nil, nil, CodedError(schema.ErrModelNotFound, http.StatusNotFound)
+	}
+	addNodes(models)
+
+	// Walk up to the oldest ancestor, the visited check guards against cycles.
+	rootId := modelId
+	for base := nodes[modelId].BaseModelId; base != nil && len(nodes) < maxLineageSize; {
+		if _, ok := nodes[*base]; ok {
+			break
+		}
+		models, err := loadLineageModels(db, "id", []uuid.UUID{*base})
+		if err != nil {
+			return nil, nil, err
+		}
+		if addNodes(models) == 0 {
+			break
+		}
+		rootId = *base
+		base = nodes[*base].BaseModelId
+	}
+
+	// Walk down from the oldest ancestor to find all of its descendants. The
+	// ancestors are already loaded, but their children still need to be found.
+	expanded := make(map[uuid.UUID]bool)
+	frontier := []uuid.UUID{rootId}
+	for len(frontier) > 0 && len(nodes) < maxLineageSize {
+		for _, id := range frontier {
+			expanded[id] = true
+		}
+		models, err := loadLineageModels(db, "base_model_id", frontier)
+		if err != nil {
+			return nil, nil, err
+		}
+		addNodes(models)
+		frontier = frontier[:0]
+		for _, m := range models {
+			if !expanded[m.Id] {
+				frontier = append(frontier, m.Id)
+			}
+		}
+	}
+
+	permissions, err := auth.GetModelPermissionsBatch(ids, user, db)
+	if err != nil {
+		return nil, nil, CodedError(err, http.StatusInternalServerError)
+	}
+
+	// The root is the oldest ancestor the user can read without skipping any
+	// ancestors they cannot read.
+	root := nodes[modelId]
+	for root.ModelId != rootId {
+		base := nodes[*root.BaseModelId]
+		if permissions[base.ModelId] < auth.ReadPermission {
+			break
+		}
+		root = base
+	}
+
+	// Each model has one base model, so the tree has no cycles once the links
+	// to the root are skipped.
+	children := make(map[uuid.UUID][]*LineageNode)
+	for _, id := range ids {
+		child := nodes[id]
+		if child.BaseModelId != nil && id != root.ModelId && permissions[id] >= auth.ReadPermission {
+			children[*child.BaseModelId] = append(children[*child.BaseModelId], child)
+		}
+	}
+	var link func(node *LineageNode)
+	link = func(node *LineageNode) {
+		node.Children = append(node.Children, children[node.ModelId]...)
+		for _, child := range node.Children {
+			link(child)
+		}
+	}
+	link(root)
+
+	return root, nodes[modelId], nil
+}
+
+// latestVersion returns the most recently published node with completed
+// training out of the node and its descendants.
+func latestVersion(node *LineageNode) *LineageNode {
+	var latest *LineageNode
+	if node.TrainStatus == schema.Complete {
+		latest = node
+	}
+	for _, child := range node.Children {
+		if candidate := latestVersion(child); candidate != nil && (latest == nil || candidate.PublishedAt.After(latest.PublishedAt)) {
+			latest = candidate
+		}
+	}
+	return latest
+}
+
+func (s *ModelService) Lineage(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	root, node, err := modelLineage(s.db, modelId, user)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving model lineage: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, LineageResponse{ModelId: modelId, Root: root, Latest: latestVersion(node)})
+}
+
+// resolveModelAlias replaces a model id of the form "{model_name}:latest" in the
+// url with the id of the most recent completed version of the user's model with
+// that name, so that clients can refer to a model without tracking the id of
+// each retrained version. Other model ids are left unchanged.
+func resolveModelAlias(db *gorm.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, isAlias := strings.CutSuffix(chi.URLParam(r, "model_id"), latestAliasSuffix)
+			if !isAlias {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := auth.UserFromContext(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			latest, err := resolveLatestVersion(db, user, name)
+			if err != nil {
+				writeError(w, fmt.Sprintf("unable to resolve model '%v%v': %v", name, latestAliasSuffix, err), err)
+				return
+			}
+
+			// Url params are looked up from the most recently added, so this
+			// overrides the alias for the handlers after this middleware.
+			chi.RouteContext(r.Context()).URLParams.Add("model_id", latest.String())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func resolveLatestVersion(db *gorm.DB, user schema.User, name string) (uuid.UUID, error) {
+	var model schema.Model
+	result := db.Limit(1).Find(&model, "user_id = ? AND name = ?", user.Id, name)
+	if result.Error != nil {
+		slog.Error("sql error finding model by name", "name", name, "error", result.Error)
+		return uuid.Nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, CodedError(fmt.Errorf("user %v does not have a model named '%v'", user.Id, name), http.StatusNotFound)
+	}
+
+	_, node, err := modelLineage(db, model.Id, user)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	latest := latestVersion(node)
+	if latest == nil {
+		return uuid.Nil, CodedError(errors.New("no version of the model has completed training"), http.StatusNotFound)
+	}
+	return latest.ModelId, nil
+}
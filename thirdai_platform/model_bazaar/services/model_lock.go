@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func checkModelUnlocked(model schema.Model, action string) error {
+	if model.Locked {
+		return CodedError(fmt.Errorf("model %v is locked and cannot be %v, it must be unlocked first", model.Id, action), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+var accessLevels = map[string]int{schema.Private: 0, schema.Protected: 1, schema.Public: 2}
+
+// reducesAccess returns if changing the access of the model would remove access
+// for any users, either by moving to a more restrictive access level or by
+// moving a protected model to a different team.
+func reducesAccess(model schema.Model, access string, teamId *uuid.UUID) bool {
+	if accessLevels[access] < accessLevels[model.Access] {
+		return true
+	}
+	if access == schema.Protected && model.Access == schema.Protected {
+		return model.TeamId == nil || teamId == nil || *model.TeamId != *teamId
+	}
+	return false
+}
+
+func (s *ModelService) setLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if model.Locked == locked {
+			return nil
+		}
+
+		updates := map[string]interface{}{"locked": locked, "locked_by": nil, "locked_at": nil}
+		if locked {
+			updates["locked_by"] = user.Id
+			updates["locked_at"] = time.Now().UTC()
+		}
+
+		result := txn.Model(&schema.Model{Id: modelId}).Updates(updates)
+		if result.Error != nil {
+			slog.Error("sql error updating model lock", "model_id", modelId, "locked", locked, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		eventType := schema.ModelUnlockedEvent
+		if locked {
+			eventType = schema.ModelLockedEvent
+		}
+		return recordModelEvent(txn, eventType, model, map[string]interface{}{"user_id": user.Id, "username": user.Username})
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating model lock: %v", err), err)
+		return
+	}
+
+	slog.Info("updated model lock", "model_id", modelId, "locked", locked, "user_id", user.Id)
+
+	utils.WriteSuccess(w)
+}
+
+// Lock prevents the model from being deleted, undeployed, or having its access
+// reduced until it is unlocked.
+func (s *ModelService) Lock(w http.ResponseWriter, r *http.Request) {
+	s.setLocked(w, r, true)
+}
+
+func (s *ModelService) Unlock(w http.ResponseWriter, r *http.Request) {
+	s.setLocked(w, r, false)
+}
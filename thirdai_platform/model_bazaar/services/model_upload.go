@@ -0,0 +1,632 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Clients can send the sha256 of each chunk in this header so that corrupted
+// chunks are rejected immediately instead of when the upload is committed.
+const chunkSha256Header = "X-Chunk-Sha256"
+
+const uploadTokenExpiration = 10 * time.Minute
+
+// Presigned urls only need to be valid when the request starts, transfers that
+// are in progress when the url expires are not interrupted.
+const presignedUrlExpiration = time.Hour
+
+func isSha256(checksum string) bool {
+	decoded, err := hex.DecodeString(checksum)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// decodeChunkBody returns the data of a chunk, chunks can be sent compressed
+// with zstd to reduce transfer times. The checksum and size of the chunk are of
+// the decompressed data.
+func decodeChunkBody(r *http.Request) (io.ReadCloser, error) {
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return r.Body, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r.Body)
+		if err != nil {
+			return nil, CodedError(fmt.Errorf("error decompressing chunk: %w", err), http.StatusBadRequest)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, CodedError(fmt.Errorf("unsupported content encoding '%v', chunks must be uncompressed or zstd", encoding), http.StatusUnsupportedMediaType)
+	}
+}
+
+func uploadChunksDir(modelId uuid.UUID) string {
+	return filepath.Join(storage.ModelPath(modelId), "chunks")
+}
+
+func uploadChunkPath(modelId uuid.UUID, chunkIdx int) string {
+	return filepath.Join(uploadChunksDir(modelId), strconv.Itoa(chunkIdx))
+}
+
+func (s *ModelService) getUploadSession(modelId uuid.UUID) (schema.ModelUploadSession, error) {
+	var session schema.ModelUploadSession
+	result := s.db.Limit(1).Find(&session, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error loading upload session", "model_id", modelId, "error", result.Error)
+		return session, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected == 0 {
+		return session, CodedError(fmt.Errorf("upload for model %v is not in progress", modelId), http.StatusUnprocessableEntity)
+	}
+	return session, nil
+}
+
+func deleteUploadSession(txn *gorm.DB, modelId uuid.UUID) error {
+	if result := txn.Delete(&schema.ModelUploadChunk{}, "model_id = ?", modelId); result.Error != nil {
+		slog.Error("sql error deleting upload chunks", "model_id", modelId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if result := txn.Delete(&schema.ModelUploadPresignedChunk{}, "model_id = ?", modelId); result.Error != nil {
+		slog.Error("sql error deleting presigned upload chunks", "model_id", modelId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if result := txn.Delete(&schema.ModelUploadSession{}, "model_id = ?", modelId); result.Error != nil {
+		slog.Error("sql error deleting upload session", "model_id", modelId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// uploadChunkParams returns the model and chunk index for a request to upload a
+// chunk, and checks that the upload is in progress and the index is in range.
+func (s *ModelService) uploadChunkParams(r *http.Request) (uuid.UUID, int, error) {
+	chunkIdxParam, err := utils.URLParam(r, "chunk_idx")
+	if err != nil {
+		return uuid.Nil, 0, CodedError(err, http.StatusBadRequest)
+	}
+	chunkIdx, err := strconv.Atoi(chunkIdxParam)
+	if err != nil || chunkIdx < 0 {
+		return uuid.Nil, 0, CodedError(errors.New("expected 'chunk_idx' parameter to be an positive integer"), http.StatusBadRequest)
+	}
+
+	modelId, err := auth.ModelIdFromContext(r)
+	if err != nil {
+		return uuid.Nil, 0, CodedError(fmt.Errorf("error retrieving model id from request: %w", err), http.StatusBadRequest)
+	}
+
+	session, err := s.getUploadSession(modelId)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+
+	if session.TotalChunks > 0 && chunkIdx >= session.TotalChunks {
+		return uuid.Nil, 0, CodedError(fmt.Errorf("chunk_idx %d is out of range for upload with %d chunks", chunkIdx, session.TotalChunks), http.StatusBadRequest)
+	}
+
+	return modelId, chunkIdx, nil
+}
+
+func (s *ModelService) recordUploadChunk(chunk schema.ModelUploadChunk) error {
+	result := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&chunk)
+	if result.Error != nil {
+		slog.Error("sql error recording upload chunk", "model_id", chunk.ModelId, "chunk_idx", chunk.ChunkIdx, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *ModelService) listUploadChunks(modelId uuid.UUID) ([]schema.ModelUploadChunk, error) {
+	var chunks []schema.ModelUploadChunk
+	result := s.db.Where("model_id = ?", modelId).Order("chunk_idx asc").Find(&chunks)
+	if result.Error != nil {
+		slog.Error("sql error listing upload chunks", "model_id", modelId, "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return chunks, nil
+}
+
+func (s *ModelService) discardUploadChunk(modelId uuid.UUID, chunkIdx int) error {
+	result := s.db.Delete(&schema.ModelUploadChunk{}, "model_id = ? AND chunk_idx = ?", modelId, chunkIdx)
+	if result.Error != nil {
+		slog.Error("sql error deleting upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if err := s.storage.Delete(uploadChunkPath(modelId, chunkIdx)); err != nil {
+		slog.Error("error deleting upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", err)
+		return CodedError(errors.New("error deleting upload chunk"), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// savePresignedChunk records the sha256 that the presigned url for the chunk is
+// created with, replacing the checksum of any previous url for the chunk.
+func (s *ModelService) savePresignedChunk(modelId uuid.UUID, chunkIdx int, checksum string) error {
+	presigned := schema.ModelUploadPresignedChunk{ModelId: modelId, ChunkIdx: chunkIdx, Sha256: checksum, PresignedAt: time.Now().UTC()}
+	if result := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&presigned); result.Error != nil {
+		slog.Error("sql error recording presigned upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// deletePresignedChunk removes the checksum of the presigned url for the chunk,
+// once the chunk is sent through model bazaar a presigned upload of it can no
+// longer be confirmed.
+func (s *ModelService) deletePresignedChunk(modelId uuid.UUID, chunkIdx int) error {
+	result := s.db.Delete(&schema.ModelUploadPresignedChunk{}, "model_id = ? AND chunk_idx = ?", modelId, chunkIdx)
+	if result.Error != nil {
+		slog.Error("sql error deleting presigned upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// missingChunks returns the indexes of the chunks that have not been received,
+// the chunks must be sorted by index. If the total number of chunks is not
+// known then only gaps before the last received chunk are reported.
+func missingChunks(chunks []schema.ModelUploadChunk, totalChunks int) []int {
+	if totalChunks == 0 && len(chunks) > 0 {
+		totalChunks = chunks[len(chunks)-1].ChunkIdx + 1
+	}
+
+	missing := []int{}
+	next := 0
+	for idx := 0; idx < totalChunks; idx++ {
+		if next < len(chunks) && chunks[next].ChunkIdx == idx {
+			next++
+			continue
+		}
+		missing = append(missing, idx)
+	}
+	return missing
+}
+
+func (s *ModelService) copyUploadChunk(w io.Writer, chunk schema.ModelUploadChunk) error {
+	data, err := s.storage.Read(uploadChunkPath(chunk.ModelId, chunk.ChunkIdx))
+	if err != nil {
+		slog.Error("error reading chunk from upload", "model_id", chunk.ModelId, "chunk_idx", chunk.ChunkIdx, "error", err)
+		return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
+	}
+	defer data.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), data); err != nil {
+		slog.Error("error copying chunk from upload", "model_id", chunk.ModelId, "chunk_idx", chunk.ChunkIdx, "error", err)
+		return CodedError(errors.New("error accessing uploaded data"), http.StatusInternalServerError)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != chunk.Sha256 {
+		slog.Error("upload chunk does not match checksum", "model_id", chunk.ModelId, "chunk_idx", chunk.ChunkIdx)
+		return CodedError(fmt.Errorf("chunk %d does not match its checksum, the chunk must be sent again", chunk.ChunkIdx), http.StatusBadRequest)
+	}
+
+	return nil
+}
+
+type UploadChunkInfo struct {
+	ChunkIdx int    `json:"chunk_idx"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+}
+
+func uploadChunkInfo(chunk schema.ModelUploadChunk) UploadChunkInfo {
+	return UploadChunkInfo{ChunkIdx: chunk.ChunkIdx, Size: chunk.Size, Sha256: chunk.Sha256}
+}
+
+type UploadStatusResponse struct {
+	ModelId     uuid.UUID         `json:"model_id"`
+	TotalChunks int               `json:"total_chunks"`
+	Sha256      string            `json:"sha256"`
+	Received    []UploadChunkInfo `json:"received"`
+	Missing     []int             `json:"missing"`
+}
+
+func (s *ModelService) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	modelId, err := auth.ModelIdFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving model id from request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.getUploadSession(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	chunks, err := s.listUploadChunks(modelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	received := make([]UploadChunkInfo, 0, len(chunks))
+	for _, chunk := range chunks {
+		received = append(received, uploadChunkInfo(chunk))
+	}
+
+	utils.WriteJsonResponse(w, UploadStatusResponse{
+		ModelId:     modelId,
+		TotalChunks: session.TotalChunks,
+		Sha256:      session.Sha256,
+		Received:    received,
+		Missing:     missingChunks(chunks, session.TotalChunks),
+	})
+}
+
+// getUpload returns the model for an upload that is still in progress.
+func (s *ModelService) getUpload(modelId uuid.UUID) (schema.Model, error) {
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return model, CodedError(err, http.StatusNotFound)
+		}
+		return model, CodedError(fmt.Errorf("error retrieving model: %w", err), http.StatusInternalServerError)
+	}
+
+	if _, err := s.getUploadSession(model.Id); err != nil {
+		return model, err
+	}
+
+	return model, nil
+}
+
+type PresignedUrlResponse struct {
+	Url    string `json:"url"`
+	Method string `json:"method"`
+	// Headers that must be sent with the request, since they are part of the
+	// signature.
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// Set if the file is compressed, and must be decompressed by the client.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+}
+
+type UploadChunkPresignRequest struct {
+	Sha256 string `json:"sha256"`
+}
+
+// UploadChunkPresign returns a url that the client can use to upload a chunk
+// directly to the storage. The sha256 of the chunk is required so that the
+// storage rejects the chunk if it is corrupted. The client must call
+// UploadChunkConfirm after the chunk is uploaded.
+func (s *ModelService) UploadChunkPresign(w http.ResponseWriter, r *http.Request) {
+	var params UploadChunkPresignRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	checksum, err := hex.DecodeString(params.Sha256)
+	if err != nil || len(checksum) != sha256.Size {
+		http.Error(w, "sha256 must be a hex encoded sha256 checksum", http.StatusBadRequest)
+		return
+	}
+
+	presigner, ok := s.storage.(storage.Presigner)
+	if !ok {
+		http.Error(w, "presigned urls are not supported by the storage backend", http.StatusUnprocessableEntity)
+		return
+	}
+
+	modelId, chunkIdx, err := s.uploadChunkParams(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	// Any previous copy of the chunk will be overwritten, so it must not be
+	// used until the new copy is confirmed.
+	if err := s.discardUploadChunk(modelId, chunkIdx); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if err := s.savePresignedChunk(modelId, chunkIdx, hex.EncodeToString(checksum)); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	headers := map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(checksum)}
+	expiresAt := time.Now().UTC().Add(presignedUrlExpiration)
+	url, err := presigner.Presign(http.MethodPut, uploadChunkPath(modelId, chunkIdx), presignedUrlExpiration, headers)
+	if err != nil {
+		slog.Error("error presigning upload chunk url", "model_id", modelId, "chunk_idx", chunkIdx, "error", err)
+		http.Error(w, "error creating upload url", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, PresignedUrlResponse{Url: url, Method: http.MethodPut, Headers: headers, ExpiresAt: expiresAt})
+}
+
+type UploadChunkConfirmRequest struct {
+	Sha256 string `json:"sha256"`
+}
+
+// UploadChunkConfirm records a chunk that was uploaded with a presigned url. The
+// sha256 must be the one that the url was presigned with.
+func (s *ModelService) UploadChunkConfirm(w http.ResponseWriter, r *http.Request) {
+	var params UploadChunkConfirmRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	params.Sha256 = strings.ToLower(params.Sha256)
+	if !isSha256(params.Sha256) {
+		http.Error(w, "sha256 must be a hex encoded sha256 checksum", http.StatusBadRequest)
+		return
+	}
+
+	modelId, chunkIdx, err := s.uploadChunkParams(r)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	var presigned schema.ModelUploadPresignedChunk
+	result := s.db.Limit(1).Find(&presigned, "model_id = ? AND chunk_idx = ?", modelId, chunkIdx)
+	if result.Error != nil {
+		slog.Error("sql error loading presigned upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("no presigned url has been created for chunk %d", chunkIdx), http.StatusUnprocessableEntity)
+		return
+	}
+	// The storage only verified the chunk against the checksum in the presigned
+	// url, so the chunk cannot be recorded with any other checksum.
+	if presigned.Sha256 != params.Sha256 {
+		http.Error(w, fmt.Sprintf("sha256 of chunk %d does not match the sha256 of its presigned url", chunkIdx), http.StatusBadRequest)
+		return
+	}
+
+	size, err := s.storage.Size(uploadChunkPath(modelId, chunkIdx))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, fmt.Sprintf("chunk %d has not been uploaded", chunkIdx), http.StatusUnprocessableEntity)
+			return
+		}
+		slog.Error("error checking size of presigned upload chunk", "model_id", modelId, "chunk_idx", chunkIdx, "error", err)
+		http.Error(w, "error accessing uploaded data", http.StatusInternalServerError)
+		return
+	}
+
+	// The storage verified the checksum when the chunk was uploaded, and it is
+	// checked again when the upload is committed.
+	chunk := schema.ModelUploadChunk{
+		ModelId:    modelId,
+		ChunkIdx:   chunkIdx,
+		Size:       size,
+		Sha256:     params.Sha256,
+		ReceivedAt: time.Now().UTC(),
+	}
+	if err := s.recordUploadChunk(chunk); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, uploadChunkInfo(chunk))
+}
+
+type UploadResumeRequest struct {
+	ModelId uuid.UUID `json:"model_id"`
+}
+
+// UploadResume returns a new upload session token for an upload that is still
+// in progress, so that an upload can continue after its token expires.
+func (s *ModelService) UploadResume(w http.ResponseWriter, r *http.Request) {
+	var params UploadResumeRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	model, err := s.getUpload(params.ModelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if model.UserId != user.Id {
+		http.Error(w, "only the user who started an upload can resume it", http.StatusForbidden)
+		return
+	}
+
+	tokenExpiration := time.Now().UTC().Add(uploadTokenExpiration)
+	result := s.db.Model(&schema.ModelUploadSession{}).Where("model_id = ?", model.Id).Update("token_expires_at", tokenExpiration)
+	if result.Error != nil {
+		slog.Error("sql error updating upload token expiration", "model_id", model.Id, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uploadToken, err := s.uploadSessionAuth.CreateModelJwt(model.Id, uploadTokenExpiration)
+	if err != nil {
+		slog.Error("error creating upload token", "model_id", model.Id, "error", err)
+		http.Error(w, "error creating upload token for model", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, map[string]string{"token": uploadToken})
+}
+
+type UploadSessionInfo struct {
+	ModelId        uuid.UUID  `json:"model_id"`
+	ModelName      string     `json:"model_name"`
+	UserId         uuid.UUID  `json:"user_id"`
+	Username       string     `json:"username"`
+	TotalChunks    int        `json:"total_chunks"`
+	Sha256         string     `json:"sha256"`
+	ChunksReceived int        `json:"chunks_received"`
+	BytesReceived  int64      `json:"bytes_received"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastChunkAt    *time.Time `json:"last_chunk_at"`
+	TokenExpiresAt *time.Time `json:"token_expires_at"`
+	TokenExpired   bool       `json:"token_expired"`
+}
+
+// UploadList returns the model uploads that are in progress for the user, or
+// for all users if the user is an admin.
+func (s *ModelService) UploadList(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type sessionRow struct {
+		schema.ModelUploadSession
+		ModelName string
+		UserId    uuid.UUID
+		Username  string
+	}
+
+	query := s.db.Table("model_upload_sessions").
+		Select("model_upload_sessions.*, models.name as model_name, models.user_id as user_id, users.username as username").
+		Joins("JOIN models ON models.id = model_upload_sessions.model_id").
+		Joins("JOIN users ON users.id = models.user_id")
+	if !user.IsAdmin {
+		query = query.Where("models.user_id = ?", user.Id)
+	}
+
+	var sessions []sessionRow
+	if result := query.Order("model_upload_sessions.created_at asc").Scan(&sessions); result.Error != nil {
+		slog.Error("sql error listing upload sessions", "user_id", user.Id, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelIds := make([]uuid.UUID, 0, len(sessions))
+	for _, session := range sessions {
+		modelIds = append(modelIds, session.ModelId)
+	}
+
+	var chunks []schema.ModelUploadChunk
+	if len(modelIds) > 0 {
+		result := s.db.Select("model_id", "size", "received_at").Where("model_id IN ?", modelIds).Find(&chunks)
+		if result.Error != nil {
+			slog.Error("sql error listing upload chunks", "user_id", user.Id, "error", result.Error)
+			http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	infos := make(map[uuid.UUID]*UploadSessionInfo, len(sessions))
+	res := make([]UploadSessionInfo, len(sessions))
+	now := time.Now()
+	for i, session := range sessions {
+		res[i] = UploadSessionInfo{
+			ModelId:        session.ModelId,
+			ModelName:      session.ModelName,
+			UserId:         session.UserId,
+			Username:       session.Username,
+			TotalChunks:    session.TotalChunks,
+			Sha256:         session.Sha256,
+			CreatedAt:      session.CreatedAt.UTC(),
+			TokenExpiresAt: utcTime(session.TokenExpiresAt),
+			TokenExpired:   session.TokenExpiresAt == nil || session.TokenExpiresAt.Before(now),
+		}
+		infos[session.ModelId] = &res[i]
+	}
+	for _, chunk := range chunks {
+		info := infos[chunk.ModelId]
+		info.ChunksReceived++
+		info.BytesReceived += chunk.Size
+		if info.LastChunkAt == nil || chunk.ReceivedAt.After(*info.LastChunkAt) {
+			receivedAt := chunk.ReceivedAt
+			info.LastChunkAt = &receivedAt
+		}
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type UploadAbortRequest struct {
+	ModelId uuid.UUID `json:"model_id"`
+}
+
+// UploadAbort cancels an upload that is in progress, the model and any chunks
+// that have been received are deleted.
+func (s *ModelService) UploadAbort(w http.ResponseWriter, r *http.Request) {
+	var params UploadAbortRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving user id from request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	model, err := s.getUpload(params.ModelId)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if model.UserId != user.Id && !user.IsAdmin {
+		http.Error(w, "only the user who started an upload or an admin can abort it", http.StatusForbidden)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := deleteUploadSession(txn, model.Id); err != nil {
+			return err
+		}
+
+		if result := txn.Delete(&model); result.Error != nil {
+			slog.Error("sql error deleting model for aborted upload", "model_id", model.Id, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		// The storage is deleted in the transaction so that the upload can still be
+		// aborted again if this fails.
+		if err := s.storage.Delete(storage.ModelPath(model.Id)); err != nil {
+			slog.Error("error deleting data for aborted upload", "model_id", model.Id, "error", err)
+			return CodedError(errors.New("error deleting uploaded data"), http.StatusInternalServerError)
+		}
+
+		return recordModelEvent(txn, schema.ModelDeletedEvent, model, nil)
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error aborting upload: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
@@ -0,0 +1,472 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/graphql"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/utils"
+)
+
+//go:generate go run ../../cmd/openapi -out openapi.json
+
+// The spec is generated from apiOperations, a test checks that it is up to
+// date and that every route is documented.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+type apiAuth int
+
+const (
+	authNone apiAuth = iota
+	authUser
+	// Routes for a model that also accept an api key with access to the model.
+	authUserOrApiKey
+	// Routes called by train, deploy, and batch predict jobs with the token
+	// they are given when they are started.
+	authJob
+	// Routes for model uploads, which use the token from starting the upload.
+	authUpload
+	// Login with the user's email and password.
+	authBasic
+)
+
+type apiQueryParam struct {
+	name        string
+	description string
+}
+
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	auth    apiAuth
+	query   []apiQueryParam
+
+	// The request and response bodies are json unless a content type is given.
+	// A nil request means there is no body.
+	request         interface{}
+	requestContent  string
+	response        interface{}
+	responseContent string
+}
+
+// Most routes return an empty object on success.
+var emptyResponse = struct{}{}
+
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/health", summary: "Check that model bazaar is running", response: emptyResponse},
+	{method: "GET", path: "/openapi.json", summary: "Get the OpenAPI spec for the api", response: map[string]interface{}{}},
+	{method: "GET", path: "/docs", summary: "View the api docs", responseContent: "text/html"},
+
+	{method: "POST", path: "/user/signup", summary: "Create a user, if direct signup is enabled", request: signupRequest{}, response: signupResponse{}},
+	{method: "GET", path: "/user/login", summary: "Login with email and password", auth: authBasic, response: loginResponse{}},
+	{method: "POST", path: "/user/login-with-token", summary: "Login with an access token from the identity provider", request: loginWithTokenRequest{}, response: loginResponse{}},
+	{method: "POST", path: "/user/password-reset/request", summary: "Email a password reset link to a user (basic auth only)", request: passwordResetRequest{}, response: emptyResponse},
+	{method: "POST", path: "/user/password-reset", summary: "Reset a password with the token from a password reset email (basic auth only)", request: resetPasswordRequest{}, response: emptyResponse},
+	{method: "POST", path: "/user/verify-email", summary: "Verify an email with the token from a verification email (basic auth only)", request: verifyEmailRequest{}, response: emptyResponse},
+	{method: "POST", path: "/user/verify-email/request", summary: "Resend the verification email to a user (basic auth only)", request: emailVerificationRequest{}, response: emptyResponse},
+	{method: "GET", path: "/user/list", summary: "List the users visible to the user", auth: authUser, response: []UserInfo{}},
+	{method: "GET", path: "/user/info", summary: "Get info for the current user", auth: authUser, response: UserInfo{}},
+	{method: "POST", path: "/user/create", summary: "Create a user (admin)", auth: authUser, request: signupRequest{}, response: signupResponse{}},
+	{method: "DELETE", path: "/user/{user_id}", summary: "Delete a user (admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/user/{user_id}/admin", summary: "Promote a user to admin (admin)", auth: authUser, response: emptyResponse},
+	{method: "DELETE", path: "/user/{user_id}/admin", summary: "Demote an admin (admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/user/{user_id}/verify", summary: "Verify a user's email (admin)", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/user/{user_id}/job-limits", summary: "Get the job limits of a user (admin)", auth: authUser, response: UserJobLimitsResponse{}},
+	{method: "POST", path: "/user/{user_id}/job-limits", summary: "Override the job limits of a user (admin)", auth: authUser, request: UserJobLimitsRequest{}, response: UserJobLimitsResponse{}},
+
+	{method: "POST", path: "/team/create", summary: "Create a team (admin or org admin)", auth: authUser, request: createTeamRequest{}, response: createTeamResponse{}},
+	{method: "GET", path: "/team/list", summary: "List the teams visible to the user", auth: authUser, response: []TeamInfo{}},
+	{method: "DELETE", path: "/team/{team_id}", summary: "Delete a team (admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/team/{team_id}/users/{user_id}", summary: "Add a user to a team (team admin)", auth: authUser, response: emptyResponse},
+	{method: "DELETE", path: "/team/{team_id}/users/{user_id}", summary: "Remove a user from a team (team admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/team/{team_id}/admins/{user_id}", summary: "Make a user a team admin (team admin)", auth: authUser, response: emptyResponse},
+	{method: "DELETE", path: "/team/{team_id}/admins/{user_id}", summary: "Remove a team admin (team admin)", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/team/{team_id}/users", summary: "List the users in a team (team admin)", auth: authUser, response: []TeamUserInfo{}},
+	{method: "GET", path: "/team/{team_id}/models", summary: "List the models in a team (team admin)", auth: authUser, response: []ModelInfo{}},
+
+	{method: "POST", path: "/organization/create", summary: "Create an organization (admin)", auth: authUser, request: createOrganizationRequest{}, response: createOrganizationResponse{}},
+	{method: "GET", path: "/organization/list", summary: "List the organizations visible to the user", auth: authUser, response: []OrganizationInfo{}},
+	{method: "GET", path: "/organization/{org_id}", summary: "Get the quotas, usage, and teams of an organization (org admin)", auth: authUser, response: OrganizationDetails{}},
+	{method: "DELETE", path: "/organization/{org_id}", summary: "Delete an organization without users or teams (admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/organization/{org_id}/quotas", summary: "Set the quotas of an organization (admin)", auth: authUser, request: OrganizationQuotas{}, response: OrganizationInfo{}},
+	{method: "GET", path: "/organization/{org_id}/users", summary: "List the users in an organization (org admin)", auth: authUser, response: []OrganizationUserInfo{}},
+	{method: "POST", path: "/organization/{org_id}/users/{user_id}", summary: "Add a user to an organization (admin)", auth: authUser, response: emptyResponse},
+	{method: "DELETE", path: "/organization/{org_id}/users/{user_id}", summary: "Remove a user from an organization (admin)", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/organization/{org_id}/admins/{user_id}", summary: "Make a user an org admin (admin)", auth: authUser, response: emptyResponse},
+	{method: "DELETE", path: "/organization/{org_id}/admins/{user_id}", summary: "Remove an org admin (admin)", auth: authUser, response: emptyResponse},
+
+	{method: "GET", path: "/model/list", summary: "List the models the user can access", auth: authUser, response: []ModelInfo{}},
+	{method: "POST", path: "/model/permissions/batch", summary: "Get the user's permissions for multiple models", auth: authUser, request: BatchPermissionsRequest{}, response: BatchPermissionsResponse{}},
+	{method: "POST", path: "/model/create-api-key", summary: "Create an api key", auth: authUser, request: CreateAPIKeyRequest{}, response: map[string]string{}},
+	{method: "POST", path: "/model/delete-api-key", summary: "Delete an api key", auth: authUser, request: deleteRequestBody{}, response: emptyResponse},
+	{method: "GET", path: "/model/list-api-keys", summary: "List the user's api keys", auth: authUser, response: []APIKeyResponse{}},
+	{method: "POST", path: "/model/export", summary: "Export models and their dependencies as an archive", auth: authUser, request: ExportRequest{}, responseContent: "application/zip"},
+	{method: "POST", path: "/model/import", summary: "Import models from an export archive", auth: authUser, requestContent: "application/zip", response: ImportResponse{}},
+	{method: "POST", path: "/model/upload", summary: "Start a model upload", auth: authUser, request: UploadStartRequest{}, response: map[string]string{}},
+	{method: "POST", path: "/model/upload/resume", summary: "Resume an interrupted model upload", auth: authUser, request: UploadResumeRequest{}, response: map[string]string{}},
+	{method: "GET", path: "/model/upload/list", summary: "List the user's incomplete model uploads", auth: authUser, response: []UploadSessionInfo{}},
+	{method: "POST", path: "/model/upload/abort", summary: "Abort a model upload", auth: authUser, request: UploadAbortRequest{}, response: emptyResponse},
+	{method: "POST", path: "/model/upload/{chunk_idx}", summary: "Upload a chunk of a model", auth: authUpload, requestContent: "application/octet-stream", response: UploadChunkInfo{}},
+	{method: "POST", path: "/model/upload/{chunk_idx}/presign", summary: "Get a presigned url to upload a chunk to storage directly", auth: authUpload, request: UploadChunkPresignRequest{}, response: PresignedUrlResponse{}},
+	{method: "POST", path: "/model/upload/{chunk_idx}/confirm", summary: "Confirm a chunk uploaded with a presigned url", auth: authUpload, request: UploadChunkConfirmRequest{}, response: UploadChunkInfo{}},
+	{method: "GET", path: "/model/upload/status", summary: "Get the status of a model upload", auth: authUpload, response: UploadStatusResponse{}},
+	{method: "POST", path: "/model/upload/commit", summary: "Finish a model upload", auth: authUpload, request: UploadCommitRequest{}, response: uploadCommitResponse{}},
+	{method: "GET", path: "/model/{model_id}", summary: "Get model info", auth: authUserOrApiKey, response: ModelInfo{}},
+	{method: "DELETE", path: "/model/{model_id}", summary: "Delete a model", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "GET", path: "/model/{model_id}/permissions", summary: "Get the user's permissions for a model", auth: authUserOrApiKey, response: ModelPermissions{}},
+	{method: "GET", path: "/model/{model_id}/lineage", summary: "Get the versions of a model", auth: authUserOrApiKey, response: LineageResponse{}},
+	{method: "GET", path: "/model/{model_id}/download", summary: "Download a model, or a byte range of its archive", auth: authUserOrApiKey, responseContent: "application/zip"},
+	{method: "GET", path: "/model/{model_id}/download/manifest", summary: "Get the size, checksum, and parts of the model download archive", auth: authUserOrApiKey, response: DownloadManifest{}, query: []apiQueryParam{{"part_size", "The size of the parts in bytes, 64MB by default"}}},
+	{method: "GET", path: "/model/{model_id}/download-url", summary: "Get a presigned url to download a model", auth: authUserOrApiKey, response: PresignedUrlResponse{}},
+	{method: "POST", path: "/model/{model_id}/access", summary: "Update the access level of a model", auth: authUserOrApiKey, request: updateAccessRequest{}, response: emptyResponse},
+	{method: "POST", path: "/model/{model_id}/default-permission", summary: "Update the default permission of a model", auth: authUserOrApiKey, request: updateDefaultPermissionRequest{}, response: emptyResponse},
+	{method: "POST", path: "/model/{model_id}/lock", summary: "Lock a model", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "POST", path: "/model/{model_id}/unlock", summary: "Unlock a model", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "POST", path: "/model/{model_id}/rehydrate", summary: "Move the artifacts of a model back from cold storage", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "GET", path: "/model/{model_id}/governance", summary: "Get the governance metadata of a model", auth: authUserOrApiKey, response: ModelGovernanceInfo{}},
+	{method: "POST", path: "/model/{model_id}/governance", summary: "Update the governance metadata and production approval of a model", auth: authUserOrApiKey, request: updateGovernanceRequest{}, response: ModelGovernanceInfo{}},
+
+	{method: "POST", path: "/train/ndb", summary: "Train an ndb model", auth: authUser, request: NdbTrainRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/train/ndb-retrain", summary: "Retrain an ndb model with the feedback from its deployment", auth: authUser, request: NdbRetrainRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/train/nlp-token", summary: "Train a token classification model", auth: authUser, request: NlpTokenTrainRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/train/nlp-text", summary: "Train a text classification model", auth: authUser, request: NlpTextTrainRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/train/nlp-datagen", summary: "Train an nlp model on generated data", auth: authUser, request: NlpTrainDatagenRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/train/nlp-token-retrain", summary: "Retrain a token classification model", auth: authUser, request: NlpTokenRetrainRequest{}, response: trainResponse{}},
+	{
+		method: "POST", path: "/train/upload-data", summary: "Upload train files", auth: authUser, requestContent: "multipart/form-data", response: map[string]string{},
+		query: []apiQueryParam{{"upload_id", "An existing upload to add the files to"}, {"sub_dir", "The directory in the upload to add the files to"}},
+	},
+	{method: "GET", path: "/train/upload/{upload_id}", summary: "Get the files in an upload", auth: authUser, response: UploadInfo{}},
+	{method: "POST", path: "/train/verify-doc-dir", summary: "Check the directory structure of a document classification upload", auth: authUser, request: validateDocDirRequest{}, response: validateDocDirResponse{}},
+	{method: "POST", path: "/train/validate-trainable-csv", summary: "Check that an uploaded file can be used to train an nlp model", auth: authUser, request: TrainableCSVRequest{}, response: TrainableCSVResponse{}},
+	{method: "POST", path: "/train/sweep", summary: "Start a hyperparameter sweep", auth: authUser, request: SweepRequest{}, response: sweepResponse{}},
+	{method: "GET", path: "/train/sweep/{sweep_id}", summary: "Get the status of a hyperparameter sweep", auth: authUser, response: sweepInfo{}},
+	{method: "GET", path: "/train/schedules", summary: "List the retrain schedules of the user, or all schedules for admins", auth: authUser, response: []trainScheduleInfo{}},
+	{method: "POST", path: "/train/schedules", summary: "Schedule automatic retraining of an ndb or nlp-token model", auth: authUser, request: TrainScheduleRequest{}, response: trainScheduleResponse{}},
+	{method: "GET", path: "/train/schedules/{schedule_id}", summary: "Get a retrain schedule and its recent runs", auth: authUser, response: trainScheduleInfo{}},
+	{method: "PATCH", path: "/train/schedules/{schedule_id}", summary: "Update the cron spec of a retrain schedule or pause it", auth: authUser, request: updateTrainScheduleRequest{}, response: trainScheduleInfo{}},
+	{method: "DELETE", path: "/train/schedules/{schedule_id}", summary: "Delete a retrain schedule and its run history", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/train/queue", summary: "List the queued train jobs (admin)", auth: authUser, response: []QueuedTrainJobInfo{}},
+	{method: "POST", path: "/train/update-status", summary: "Update the status of a train job", auth: authJob, request: updateStatusRequest{}, response: emptyResponse},
+	{method: "POST", path: "/train/update-progress", summary: "Update the progress of a train job", auth: authJob, request: updateProgressRequest{}, response: emptyResponse},
+	{method: "POST", path: "/train/update-evaluation", summary: "Report the holdout evaluation of a train job", auth: authJob, request: holdoutEvaluation{}, response: holdoutEvaluationResponse{}},
+	{method: "POST", path: "/train/log", summary: "Report a warning or error from a train job", auth: authJob, request: jobLogRequest{}, response: emptyResponse},
+	{method: "GET", path: "/train/{model_id}/status", summary: "Get the train status of a model", auth: authUser, response: StatusResponse{}},
+	{method: "GET", path: "/train/{model_id}/report", summary: "Get the train report of a model", auth: authUser, response: map[string]interface{}{}},
+	{method: "GET", path: "/train/{model_id}/evaluation", summary: "Get the holdout evaluation of a model", auth: authUser, response: holdoutEvaluationResponse{}},
+	{method: "GET", path: "/train/{model_id}/logs", summary: "Get the logs of a train job", auth: authUser, response: []orchestrator.JobLog{}},
+	{method: "GET", path: "/train/{model_id}/logs/stream", summary: "Stream the logs of a running train job as server sent events", auth: authUser, responseContent: "text/event-stream"},
+
+	{method: "GET", path: "/deploy/presets", summary: "List the deployment presets", auth: authUser, response: []DeploymentPresetInfo{}},
+	{method: "GET", path: "/deploy/presets/{name}", summary: "Get a deployment preset", auth: authUser, response: DeploymentPresetInfo{}},
+	{method: "POST", path: "/deploy/presets", summary: "Create or update a deployment preset", auth: authUser, request: DeploymentPresetRequest{}, response: DeploymentPresetInfo{}},
+	{method: "DELETE", path: "/deploy/presets/{name}", summary: "Delete a deployment preset", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/deploy/experiments", summary: "List the experiments of the user, or all experiments for admins", auth: authUser, response: []ExperimentInfo{}},
+	{method: "POST", path: "/deploy/experiments", summary: "Create an experiment that splits traffic between two deployed models", auth: authUser, request: CreateExperimentRequest{}, response: ExperimentInfo{}},
+	{method: "GET", path: "/deploy/experiments/{experiment_id}", summary: "Get an experiment and the metrics of its arms", auth: authUser, response: ExperimentInfo{}},
+	{method: "PATCH", path: "/deploy/experiments/{experiment_id}", summary: "Update the traffic split of an experiment or reset its metrics", auth: authUser, request: UpdateExperimentRequest{}, response: ExperimentInfo{}},
+	{method: "DELETE", path: "/deploy/experiments/{experiment_id}", summary: "Delete an experiment", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/deploy/experiments/{experiment_id}/endpoint/*", summary: "Send a request to the deployment the caller is assigned to", auth: authUserOrApiKey, response: map[string]interface{}{}},
+	{method: "POST", path: "/deploy/experiments/{experiment_id}/endpoint/*", summary: "Send a request to the deployment the caller is assigned to", auth: authUserOrApiKey, request: map[string]interface{}{}, response: map[string]interface{}{}},
+	{method: "POST", path: "/deploy/{model_id}", summary: "Deploy a model", auth: authUserOrApiKey, request: startRequest{}, response: emptyResponse},
+	{method: "DELETE", path: "/deploy/{model_id}", summary: "Undeploy a model", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "POST", path: "/deploy/{model_id}/rollback", summary: "Roll back a deployment to a previous version", auth: authUserOrApiKey, request: rollbackRequest{}, response: rollbackResponse{}},
+	{method: "PATCH", path: "/deploy/{model_id}/autoscaling", summary: "Update the autoscaling of a deployment", auth: authUserOrApiKey, request: autoscalingRequest{}, response: AutoscalingResponse{}},
+	{method: "GET", path: "/deploy/{model_id}/status", summary: "Get the deploy status of a model", auth: authUserOrApiKey, response: StatusResponse{}},
+	{method: "GET", path: "/deploy/{model_id}/logs", summary: "Get the logs of a deployment", auth: authUserOrApiKey, response: []orchestrator.JobLog{}},
+	{method: "GET", path: "/deploy/{model_id}/logs/stream", summary: "Stream the logs of a running deployment as server sent events", auth: authUserOrApiKey, responseContent: "text/event-stream"},
+	{method: "GET", path: "/deploy/{model_id}/versions", summary: "List the versions of a deployment", auth: authUserOrApiKey, response: []DeploymentVersionInfo{}},
+	{method: "GET", path: "/deploy/inactive", summary: "List the deployments flagged as inactive (admin)", auth: authUser, response: []InactiveDeploymentInfo{}},
+	{method: "GET", path: "/deploy/{model_id}/slo", summary: "Get the SLO of a deployment and its status", auth: authUserOrApiKey, response: SloInfo{}},
+	{method: "POST", path: "/deploy/{model_id}/slo", summary: "Set the latency and error rate SLO of a deployment", auth: authUserOrApiKey, request: sloRequest{}, response: SloInfo{}},
+	{method: "DELETE", path: "/deploy/{model_id}/slo", summary: "Remove the SLO of a deployment", auth: authUserOrApiKey, response: emptyResponse},
+	{method: "GET", path: "/deploy/{model_id}/dictionary", summary: "Get the entity dictionary of an nlp-token deployment", auth: authUserOrApiKey, response: EntityDictionary{}, query: []apiQueryParam{{"version", "The version to get, the latest version by default"}}},
+	{method: "GET", path: "/deploy/{model_id}/dictionary/versions", summary: "List the versions of the entity dictionary of a deployment", auth: authUserOrApiKey, response: []EntityDictionaryVersionInfo{}},
+	{method: "POST", path: "/deploy/{model_id}/dictionary/entries", summary: "Add entries to the entity dictionary of a deployment", auth: authUserOrApiKey, request: addEntityDictionaryEntriesRequest{}, response: EntityDictionary{}},
+	{method: "DELETE", path: "/deploy/{model_id}/dictionary/entries/{entry_id}", summary: "Delete an entry from the entity dictionary of a deployment", auth: authUserOrApiKey, response: EntityDictionary{}},
+	{method: "POST", path: "/deploy/{model_id}/dictionary/rollback", summary: "Restore the entries of an earlier version of the entity dictionary", auth: authUserOrApiKey, request: entityDictionaryRollbackRequest{}, response: EntityDictionary{}},
+	{method: "POST", path: "/deploy/{model_id}/save", summary: "Save a deployed model with its updates as a new model", auth: authUserOrApiKey, request: saveDeployedRequest{}, response: saveDeployedResponse{}},
+	{method: "GET", path: "/deploy/status-internal", summary: "Get the deploy status of the job's model", auth: authJob, response: StatusResponse{}},
+	{method: "GET", path: "/deploy/dictionary-internal", summary: "Get the latest entity dictionary of the job's model", auth: authJob, response: EntityDictionary{}},
+	{method: "POST", path: "/deploy/update-status", summary: "Update the status of a deployment", auth: authJob, request: updateStatusRequest{}, response: emptyResponse},
+	{method: "POST", path: "/deploy/log", summary: "Report a warning or error from a deployment", auth: authJob, request: jobLogRequest{}, response: emptyResponse},
+
+	{method: "POST", path: "/batch-predict", summary: "Start a batch prediction job", auth: authUser, request: BatchPredictRequest{}, response: BatchPredictJobInfo{}},
+	{method: "GET", path: "/batch-predict", summary: "List the user's batch prediction jobs", auth: authUser, response: []BatchPredictJobInfo{}, query: []apiQueryParam{{"model_id", "Only list jobs for this model"}}},
+	{method: "GET", path: "/batch-predict/{job_id}", summary: "Get a batch prediction job", auth: authUser, response: BatchPredictJobInfo{}},
+	{method: "DELETE", path: "/batch-predict/{job_id}", summary: "Delete a batch prediction job and its output", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/batch-predict/{job_id}/download", summary: "Download the output of a batch prediction job", auth: authUser, responseContent: "application/octet-stream"},
+	{method: "POST", path: "/batch-predict/{job_id}/stop", summary: "Stop a batch prediction job", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/batch-predict/update-status", summary: "Update the status of a batch prediction job", auth: authJob, request: batchPredictStatusRequest{}, response: emptyResponse},
+
+	{method: "POST", path: "/workflow/enterprise-search", summary: "Create an enterprise search workflow", auth: authUser, request: EnterpriseSearchRequest{}, response: trainResponse{}},
+	{method: "POST", path: "/workflow/ensemble", summary: "Create an ensemble of models", auth: authUser, request: EnsembleRequest{}, response: trainResponse{}},
+	{method: "GET", path: "/workflow/templates", summary: "List the workflow templates", auth: authUser, response: []WorkflowTemplateInfo{}},
+	{method: "GET", path: "/workflow/templates/{name}", summary: "Get a workflow template", auth: authUser, response: WorkflowTemplateInfo{}},
+	{method: "POST", path: "/workflow/templates/{name}", summary: "Create the models for a workflow template from the user's data", auth: authUser, request: WorkflowTemplateRequest{}, response: WorkflowTemplateResponse{}},
+	{method: "POST", path: "/workflow/knowledge-extraction", summary: "Create a knowledge extraction workflow", auth: authUser, request: KnowledgeExtractionRequest{}, response: trainResponse{}},
+	{method: "GET", path: "/workflow/{model_id}/redaction-policy", summary: "Get the redaction policy of an enterprise search workflow", auth: authUser, response: RedactionPolicy{}},
+	{method: "POST", path: "/workflow/{model_id}/redaction-policy", summary: "Set the redaction policy of an enterprise search workflow, applied on the next deployment", auth: authUser, request: RedactionPolicy{}, response: RedactionPolicy{}},
+	{method: "GET", path: "/workflow/{model_id}/unredact-permissions", summary: "List the users that can unredact the results of a workflow", auth: authUser, response: []UnredactGrantInfo{}},
+	{method: "POST", path: "/workflow/{model_id}/unredact-permissions", summary: "Allow a user to unredact the results of a workflow", auth: authUser, request: unredactGrantRequest{}, response: emptyResponse},
+	{method: "DELETE", path: "/workflow/{model_id}/unredact-permissions/{user_id}", summary: "Revoke the unredact permission of a user for a workflow", auth: authUser, response: emptyResponse},
+
+	{method: "GET", path: "/search", summary: "Search models, teams, users, and uploads", auth: authUser, response: SearchResponse{}, query: []apiQueryParam{
+		{"q", "The search query"}, {"types", "Comma separated types of results to return"}, {"limit", "The maximum number of results"},
+	}},
+	{method: "POST", path: "/graphql", summary: "Run a graphql query", auth: authUser, request: GraphQLRequest{}, response: graphql.Response{}},
+	{method: "GET", path: "/events", summary: "List platform events (admin)", auth: authUser, response: EventsResponse{}, query: []apiQueryParam{
+		{"cursor", "Only return events after this cursor"}, {"limit", "The maximum number of events"}, {"wait", "Seconds to wait for new events if there are none"},
+	}},
+	{method: "GET", path: "/job-runs", summary: "List the history of job runs (admin)", auth: authUser, response: []JobRunInfo{}, query: []apiQueryParam{
+		{"model_id", "Only list runs for this model"}, {"job", "Either train or deploy"}, {"status", "Only list runs with this status"},
+		{"started_after", "RFC3339 timestamp"}, {"started_before", "RFC3339 timestamp"}, {"limit", "The maximum number of runs"},
+	}},
+	{method: "GET", path: "/admin/audit", summary: "Query the audit log (admin)", auth: authUser, response: AuditLogResponse{}, query: []apiQueryParam{
+		{"user_id", "Only list requests by this user"}, {"username", "Only list requests by this username"}, {"model_id", "Only list requests for this model"},
+		{"method", "Only list requests with this http method"}, {"action", "Only list requests whose action contains this value"},
+		{"start", "RFC3339 timestamp"}, {"end", "RFC3339 timestamp"}, {"before", "Only list entries before this cursor"}, {"limit", "The maximum number of entries"},
+	}},
+	{method: "GET", path: "/admin/audit/export", summary: "Export the audit log as csv or json (admin)", auth: authUser, responseContent: "application/octet-stream", query: []apiQueryParam{
+		{"format", "Either csv or json"}, {"user_id", "Only export requests by this user"}, {"username", "Only export requests by this username"},
+		{"model_id", "Only export requests for this model"}, {"method", "Only export requests with this http method"},
+		{"action", "Only export requests whose action contains this value"}, {"start", "RFC3339 timestamp"}, {"end", "RFC3339 timestamp"},
+	}},
+	{method: "GET", path: "/image-scan", summary: "List image vulnerability scans (admin)", auth: authUser, response: []ImageScanInfo{}, query: []apiQueryParam{
+		{"job_name", "Only list scans for this job"}, {"result", "Only list scans with this result"},
+	}},
+	{method: "GET", path: "/job-env", summary: "Get the environment variables for jobs (admin)", auth: authUser, response: JobEnvSettings{}},
+	{method: "POST", path: "/job-env", summary: "Set the environment variables for jobs (admin)", auth: authUser, request: JobEnvSettings{}, response: emptyResponse},
+	{method: "GET", path: "/legal-hold", summary: "List legal holds (admin)", auth: authUser, response: []LegalHoldInfo{}, query: []apiQueryParam{
+		{"active", "If true only list holds that have not been released"},
+	}},
+	{method: "POST", path: "/legal-hold", summary: "Place a legal hold on audit data or a model (admin)", auth: authUser, request: placeLegalHoldRequest{}, response: LegalHoldInfo{}},
+	{method: "POST", path: "/legal-hold/{hold_id}/release", summary: "Release a legal hold (admin)", auth: authUser, response: LegalHoldInfo{}},
+	{method: "POST", path: "/legal-hold/audit-export", summary: "Export audit data to storage, immutably if supported (admin)", auth: authUser, request: auditExportRequest{}, response: AuditExportInfo{}},
+	{method: "GET", path: "/rate-limits", summary: "Get the rate limits (admin)", auth: authUser, response: RateLimitsResponse{}},
+	{method: "PATCH", path: "/rate-limits", summary: "Update the rate limits (admin)", auth: authUser, request: UpdateRateLimitsRequest{}, response: RateLimitsResponse{}},
+	{method: "DELETE", path: "/rate-limits", summary: "Reset the rate limits to the defaults (admin)", auth: authUser, response: RateLimitsResponse{}},
+	{method: "GET", path: "/realm-settings", summary: "Get the email and login policies of the keycloak realm (admin)", auth: authUser, response: auth.RealmSettings{}},
+	{method: "PATCH", path: "/realm-settings", summary: "Update the email and login policies of the keycloak realm (admin)", auth: authUser, request: UpdateRealmSettingsRequest{}, response: auth.RealmSettings{}},
+	{method: "GET", path: "/storage-quotas", summary: "Get the storage quotas (admin)", auth: authUser, response: StorageQuotasResponse{}},
+	{method: "POST", path: "/storage-quotas/model/{model_id}", summary: "Set the storage quota for a model (admin)", auth: authUser, request: setStorageQuotaRequest{}, response: StorageQuotaOverrideInfo{}},
+	{method: "DELETE", path: "/storage-quotas/model/{model_id}", summary: "Remove the storage quota set for a model (admin)", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/llm-cache/stats", summary: "Get the size and hit rates of the llm cache (admin)", auth: authUser, response: LlmCacheStats{}},
+	{method: "POST", path: "/llm-cache/evict", summary: "Evict llm cache entries by model or age (admin)", auth: authUser, request: LlmCacheEvictRequest{}, response: LlmCacheEvictResponse{}},
+	{method: "POST", path: "/llm-cache/capacity", summary: "Set the capacity of the llm cache (admin)", auth: authUser, request: LlmCacheCapacityRequest{}, response: LlmCacheStats{}},
+	{method: "GET", path: "/trusted-certs", summary: "List the trusted certificates (admin)", auth: authUser, response: []TrustedCertInfo{}},
+	{method: "POST", path: "/trusted-certs", summary: "Add trusted certificates (admin)", auth: authUser, request: addTrustedCertRequest{}, response: []TrustedCertInfo{}},
+	{method: "DELETE", path: "/trusted-certs/{cert_id}", summary: "Delete a trusted certificate (admin)", auth: authUser, response: emptyResponse},
+	{method: "GET", path: "/webhooks", summary: "List webhooks (admin)", auth: authUser, response: []WebhookInfo{}},
+	{method: "POST", path: "/webhooks", summary: "Create a webhook (admin)", auth: authUser, request: createWebhookRequest{}, response: WebhookInfo{}},
+	{method: "DELETE", path: "/webhooks/{webhook_id}", summary: "Delete a webhook (admin)", auth: authUser, response: emptyResponse},
+
+	{method: "POST", path: "/dataset", summary: "Create a dataset", auth: authUser, request: createDatasetRequest{}, response: DatasetInfo{}},
+	{method: "GET", path: "/dataset/list", summary: "List the datasets the user can access", auth: authUser, response: []DatasetInfo{}},
+	{method: "GET", path: "/dataset/{dataset_id}", summary: "Get a dataset and its versions", auth: authUser, response: DatasetInfo{}},
+	{method: "PATCH", path: "/dataset/{dataset_id}", summary: "Update the description or access of a dataset", auth: authUser, request: updateDatasetRequest{}, response: emptyResponse},
+	{method: "DELETE", path: "/dataset/{dataset_id}", summary: "Delete a dataset", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/dataset/{dataset_id}/versions", summary: "Add an upload as a new version of a dataset", auth: authUser, request: addDatasetVersionRequest{}, response: addDatasetVersionResponse{}},
+
+	{method: "GET", path: "/report", summary: "List the user's shared reports", auth: authUser, response: []SharedReportInfo{}},
+	{method: "DELETE", path: "/report/{report_id}", summary: "Delete a shared report", auth: authUser, response: emptyResponse},
+	{method: "POST", path: "/report/train/{model_id}", summary: "Create a train report", auth: authUser, request: reportOptions{}, response: createReportResponse{}},
+	{method: "POST", path: "/report/evaluation-comparison", summary: "Create a report comparing model evaluations", auth: authUser, request: evaluationComparisonRequest{}, response: createReportResponse{}},
+	{method: "POST", path: "/report/usage", summary: "Create a usage summary report (admin)", auth: authUser, request: usageSummaryRequest{}, response: createReportResponse{}},
+	{method: "POST", path: "/report/health", summary: "Create a platform health report, optionally emailing it to admins (admin)", auth: authUser, request: healthReportRequest{}, response: healthReportResponse{}},
+	{method: "GET", path: "/report/shared/{token}", summary: "View a shared report", responseContent: "text/html"},
+
+	{method: "POST", path: "/recovery/backup", summary: "Back up the platform (admin)", auth: authUser, request: BackupRequest{}, response: emptyResponse},
+	{method: "GET", path: "/recovery/backups", summary: "List local backups (admin)", auth: authUser, response: []string{}},
+	{method: "POST", path: "/recovery/restore", summary: "Restore the platform from a backup (admin)", auth: authUser, request: RestoreRequest{}, response: RestoreResponse{}},
+	{method: "GET", path: "/recovery/restore/{restore_id}", summary: "Get the progress of a restore (admin)", auth: authUser, response: RestoreStatus{}},
+	{method: "POST", path: "/profiling/capture", summary: "Capture profiles of model bazaar and deployments (admin)", auth: authUser, request: captureProfileRequest{}, response: captureProfileResponse{}},
+	{method: "GET", path: "/telemetry/deployment-services", summary: "List deployments for metrics scraping", response: []scrapeTarget{}},
+}
+
+var errorCodes = []string{
+	utils.ErrorCodeQuotaExceeded,
+	utils.ErrorCodeLicenseLimit,
+	utils.ErrorCodeDuplicateName,
+	utils.ErrorCodeModelNotFound,
+	utils.ErrorCodeInsufficientStorage,
+}
+
+var pathParamRe = regexp.MustCompile(`{([a-z_]+)}`)
+
+func apiSecurity(auth apiAuth) []map[string][]string {
+	switch auth {
+	case authUser:
+		return []map[string][]string{{"userToken": {}}}
+	case authUserOrApiKey:
+		return []map[string][]string{{"userToken": {}}, {"apiKey": {}}}
+	case authJob:
+		return []map[string][]string{{"jobToken": {}}}
+	case authUpload:
+		return []map[string][]string{{"uploadToken": {}}}
+	case authBasic:
+		return []map[string][]string{{"basicAuth": {}}}
+	default:
+		return []map[string][]string{}
+	}
+}
+
+func apiContent(g *schemaGenerator, value interface{}, contentType string) (map[string]interface{}, error) {
+	if contentType != "" {
+		schema := map[string]interface{}{"type": "string", "format": "binary"}
+		if contentType == "multipart/form-data" {
+			schema = map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"files": map[string]interface{}{"type": "array", "items": schema}},
+			}
+		}
+		return map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}, nil
+	}
+	schema, err := g.schema(reflect.TypeOf(value))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}, nil
+}
+
+func (op *apiOperation) spec(g *schemaGenerator) (map[string]interface{}, error) {
+	params := make([]interface{}, 0)
+	for _, match := range pathParamRe.FindAllStringSubmatch(op.path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range op.query {
+		params = append(params, map[string]interface{}{
+			"name": param.name, "in": "query", "description": param.description, "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+
+	response, err := apiContent(g, op.response, op.responseContent)
+	if err != nil {
+		return nil, fmt.Errorf("response for %v %v: %w", op.method, op.path, err)
+	}
+	errorResponse, err := apiContent(g, utils.ErrorResponse{}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{
+		"summary":    op.summary,
+		"tags":       []string{strings.Split(op.path, "/")[1]},
+		"parameters": params,
+		"security":   apiSecurity(op.auth),
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "Success", "content": response},
+			"default": map[string]interface{}{
+				"description": "Error message",
+				"content":     errorResponse,
+			},
+		},
+	}
+
+	if op.request != nil || op.requestContent != "" {
+		request, err := apiContent(g, op.request, op.requestContent)
+		if err != nil {
+			return nil, fmt.Errorf("request for %v %v: %w", op.method, op.path, err)
+		}
+		spec["requestBody"] = map[string]interface{}{"required": true, "content": request}
+	}
+
+	return spec, nil
+}
+
+// OpenAPISpec generates the OpenAPI document for the model bazaar api. It is
+// run with go generate to update the spec that is served.
+func OpenAPISpec() ([]byte, error) {
+	g := newSchemaGenerator()
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		path := "/api/v2" + op.path
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		method := strings.ToLower(op.method)
+		if _, ok := paths[path][method]; ok {
+			return nil, fmt.Errorf("duplicate api operation %v %v", op.method, op.path)
+		}
+		spec, err := op.spec(g)
+		if err != nil {
+			return nil, err
+		}
+		paths[path][method] = spec
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "ThirdAI Platform Model Bazaar API",
+			"version":     "2",
+			"description": fmt.Sprintf("Errors are returned as json with the corresponding status code. Common failures also have a machine readable code in the body and the %v header: %v.", utils.ErrorCodeHeader, strings.Join(errorCodes, ", ")),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"userToken":   map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Access token from login"},
+				"apiKey":      map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"jobToken":    map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Token given to train, deploy, and batch predict jobs"},
+				"uploadToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Token returned when a model upload is started"},
+				"basicAuth":   map[string]interface{}{"type": "http", "scheme": "basic", "description": "Email and password"},
+			},
+		},
+	}
+
+	spec, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing api spec: %w", err)
+	}
+	return append(spec, '\n'), nil
+}
+
+func serveOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openapiSpec); err != nil {
+		slog.Error("error writing api spec", "error", err)
+	}
+}
+
+// The swagger ui is loaded from a cdn so that its assets are not bundled in
+// the binary, on air gapped installs the spec can be opened in any OpenAPI
+// viewer instead.
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>Model Bazaar API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+func serveSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(swaggerUIPage)); err != nil {
+		slog.Error("error writing api docs", "error", err)
+	}
+}
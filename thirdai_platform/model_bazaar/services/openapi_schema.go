@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	uuidType       = reflect.TypeOf(uuid.UUID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaGenerator converts go types to OpenAPI schemas, following the rules
+// encoding/json uses to serialize them. Named struct types are added to the
+// components of the spec and referenced, so that recursive types are
+// supported.
+type schemaGenerator struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+	types      map[string]reflect.Type
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		components: make(map[string]interface{}),
+		names:      make(map[reflect.Type]string),
+		types:      make(map[string]reflect.Type),
+	}
+}
+
+// componentName is the name of the type in the spec. Types from other packages
+// are prefixed with their package so that they do not collide with the types
+// in this package.
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	if pkg := path.Base(t.PkgPath()); pkg != "services" {
+		return pkg + "." + string(name)
+	}
+	return string(name)
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}, nil
+	case rawMessageType:
+		return map[string]interface{}{}, nil
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		// The serialized form of types with custom marshalling is not known.
+		return map[string]interface{}{}, nil
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}, nil
+		}
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return g.ref(t)
+	default:
+		return nil, fmt.Errorf("unsupported type %v in api spec", t)
+	}
+}
+
+func (g *schemaGenerator) ref(t reflect.Type) (map[string]interface{}, error) {
+	name, ok := g.names[t]
+	if !ok {
+		name = componentName(t)
+		if other, ok := g.types[name]; ok {
+			return nil, fmt.Errorf("types %v and %v have the same name in the api spec", t, other)
+		}
+		// The name is added before the schema is generated so that recursive
+		// fields refer back to it.
+		g.names[t] = name
+		g.types[name] = t
+
+		schema, err := g.structSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		g.components[name] = schema
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}, nil
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) (map[string]interface{}, error) {
+	properties := make(map[string]interface{})
+	if err := g.addFields(t, properties); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}, nil
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Fields of embedded structs without a json name are serialized as if
+		// they were fields of the outer struct.
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := g.addFields(embedded, properties); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.Contains(","+opts+",", ",string,") {
+			properties[name] = map[string]interface{}{"type": "string"}
+			continue
+		}
+		schema, err := g.schema(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %v of %v: %w", field.Name, t, err)
+		}
+		if field.Tag.Get("deprecated") == "true" {
+			deprecated := map[string]interface{}{"deprecated": true}
+			for k, v := range schema {
+				deprecated[k] = v
+			}
+			schema = deprecated
+		}
+		properties[name] = schema
+	}
+	return nil
+}
@@ -0,0 +1,716 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrganizationService manages organizations, which group the users and teams of
+// a business unit. Models are only visible within the organization of their
+// owner, and the jobs of the organization's users share its quotas.
+type OrganizationService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+	license  *licensing.LicenseVerifier
+}
+
+func (s *OrganizationService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+
+	r.With(auth.AdminOnly(s.db)).Post("/create", s.CreateOrganization)
+
+	r.Get("/list", s.List)
+
+	r.Route("/{org_id}", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(auth.AdminOnly(s.db))
+
+			r.Delete("/", s.DeleteOrganization)
+			r.Post("/quotas", s.SetQuotas)
+
+			r.Post("/users/{user_id}", s.AddUser)
+			r.Delete("/users/{user_id}", s.RemoveUser)
+
+			r.Post("/admins/{user_id}", s.AddOrgAdmin)
+			r.Delete("/admins/{user_id}", s.RemoveOrgAdmin)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.AdminOrOrgAdminOnly(s.db))
+
+			r.Get("/", s.Info)
+			r.Get("/users", s.Users)
+		})
+	})
+
+	return r
+}
+
+var ErrOrgCpuAllocationExceeded = fmt.Errorf("organization cpu allocation exceeded: %w", licensing.ErrCpuLimitExceeded)
+
+// userOrganization returns the organization of the user, or nil if the user is
+// not in an organization.
+func userOrganization(db *gorm.DB, userId uuid.UUID) (*schema.Organization, error) {
+	user, err := schema.GetUser(userId, db)
+	if err != nil {
+		if errors.Is(err, schema.ErrUserNotFound) {
+			return nil, CodedError(err, http.StatusNotFound)
+		}
+		return nil, CodedError(err, http.StatusInternalServerError)
+	}
+	if user.OrganizationId == nil {
+		return nil, nil
+	}
+
+	org, err := schema.GetOrganization(*user.OrganizationId, db)
+	if err != nil {
+		return nil, CodedError(err, http.StatusInternalServerError)
+	}
+	return &org, nil
+}
+
+func countActiveOrgJobs(db *gorm.DB, orgId uuid.UUID, statusColumn string, statuses []string) (int, error) {
+	var count int64
+	result := db.Model(&schema.Model{}).
+		Where("user_id IN (?)", schema.OrganizationUserIds(db, &orgId)).
+		Where(statusColumn+" IN ?", statuses).
+		Count(&count)
+	if result.Error != nil {
+		slog.Error("sql error counting active jobs for organization", "org_id", orgId, "status", statusColumn, "error", result.Error)
+		return 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return int(count), nil
+}
+
+// orgCpuUsage returns the cpu allocated to the train and deploy jobs of the
+// organization's users that are running.
+func orgCpuUsage(db *gorm.DB, orgId uuid.UUID) (int, error) {
+	var usage int64
+	result := db.Model(&schema.JobRun{}).
+		Select("COALESCE(SUM(allocation_mhz), 0)").
+		Where("ended_at IS NULL").
+		Where("model_id IN (?)", db.Model(&schema.Model{}).Select("id").Where("user_id IN (?)", schema.OrganizationUserIds(db, &orgId))).
+		Scan(&usage)
+	if result.Error != nil {
+		slog.Error("sql error computing cpu usage for organization", "org_id", orgId, "error", result.Error)
+		return 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return int(usage), nil
+}
+
+// checkOrganizationTrainJobLimit returns an error wrapping
+// ErrUserJobLimitReached if the user's organization is running its maximum
+// number of train jobs.
+func checkOrganizationTrainJobLimit(db *gorm.DB, userId uuid.UUID) error {
+	org, err := userOrganization(db, userId)
+	if err != nil || org == nil || org.MaxTrainJobs == 0 {
+		return err
+	}
+
+	running, err := countActiveOrgJobs(db, org.Id, "train_status", activeTrainStatuses)
+	if err != nil {
+		return err
+	}
+	if running >= org.MaxTrainJobs {
+		return CodedError(fmt.Errorf("%w: organization %v is running %d train jobs which is the maximum allowed, wait for a job to finish or ask an admin to raise the limit", ErrUserJobLimitReached, org.Name, running), http.StatusTooManyRequests)
+	}
+	return nil
+}
+
+// checkOrganizationDeploymentLimit returns an error wrapping
+// ErrUserJobLimitReached if the user's organization has its maximum number of
+// deployments.
+func checkOrganizationDeploymentLimit(db *gorm.DB, userId uuid.UUID) error {
+	org, err := userOrganization(db, userId)
+	if err != nil || org == nil || org.MaxDeployments == 0 {
+		return err
+	}
+
+	deployed, err := countActiveOrgJobs(db, org.Id, "deploy_status", activeDeployStatuses)
+	if err != nil {
+		return err
+	}
+	if deployed >= org.MaxDeployments {
+		return CodedError(fmt.Errorf("%w: organization %v has %d deployments which is the maximum allowed, undeploy a model or ask an admin to raise the limit", ErrUserJobLimitReached, org.Name, deployed), http.StatusTooManyRequests)
+	}
+	return nil
+}
+
+// checkOrganizationCpuAllocation returns ErrOrgCpuAllocationExceeded if a job
+// of the user with the given cpu would exceed the part of the license cpu limit
+// allocated to the user's organization.
+func checkOrganizationCpuAllocation(db *gorm.DB, userId uuid.UUID, jobCpuUsage int) error {
+	org, err := userOrganization(db, userId)
+	if err != nil || org == nil || org.MaxCpuMhz == 0 {
+		return err
+	}
+
+	usage, err := orgCpuUsage(db, org.Id)
+	if err != nil {
+		return err
+	}
+	if usage+jobCpuUsage > org.MaxCpuMhz {
+		return CodedError(fmt.Errorf("%w: organization %v is using %d of its %d mhz", ErrOrgCpuAllocationExceeded, org.Name, usage, org.MaxCpuMhz), http.StatusForbidden)
+	}
+	return nil
+}
+
+type OrganizationQuotas struct {
+	// The maximum number of train jobs and deployments of the organization's
+	// users at the same time, 0 means there is no limit.
+	MaxTrainJobs   int `json:"max_train_jobs" validate:"min=0"`
+	MaxDeployments int `json:"max_deployments" validate:"min=0"`
+	// The part of the license cpu limit allocated to the organization's train
+	// jobs and deployments, 0 means the organization can use all of it.
+	MaxCpuMhz int `json:"max_cpu_mhz" validate:"min=0"`
+}
+
+type OrganizationUsage struct {
+	RunningTrainJobs int `json:"running_train_jobs"`
+	Deployments      int `json:"deployments"`
+	CpuMhz           int `json:"cpu_mhz"`
+}
+
+type OrganizationInfo struct {
+	Id        uuid.UUID          `json:"id"`
+	Name      string             `json:"name"`
+	Quotas    OrganizationQuotas `json:"quotas"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type OrganizationDetails struct {
+	OrganizationInfo
+	Usage OrganizationUsage `json:"usage"`
+	Teams []TeamInfo        `json:"teams"`
+}
+
+func newOrganizationInfo(org schema.Organization) OrganizationInfo {
+	return OrganizationInfo{
+		Id:   org.Id,
+		Name: org.Name,
+		Quotas: OrganizationQuotas{
+			MaxTrainJobs:   org.MaxTrainJobs,
+			MaxDeployments: org.MaxDeployments,
+			MaxCpuMhz:      org.MaxCpuMhz,
+		},
+		CreatedAt: org.CreatedAt.UTC(),
+	}
+}
+
+// checkCpuAllocations checks that the cpu allocated to all organizations does
+// not exceed the license cpu limit, after the organization's allocation is
+// changed to maxCpuMhz.
+func (s *OrganizationService) checkCpuAllocations(txn *gorm.DB, orgId uuid.UUID, maxCpuMhz int) error {
+	if maxCpuMhz == 0 {
+		return nil
+	}
+
+	var allocated int64
+	result := txn.Model(&schema.Organization{}).Select("COALESCE(SUM(max_cpu_mhz), 0)").Where("id != ?", orgId).Scan(&allocated)
+	if result.Error != nil {
+		slog.Error("sql error computing allocated organization cpu", "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	license, err := s.license.Verify(0)
+	if err != nil {
+		return CodedError(fmt.Errorf("unable to verify license to check cpu allocation: %w", err), http.StatusInternalServerError)
+	}
+	limit, err := strconv.Atoi(license.CpuMhzLimit)
+	if err != nil {
+		return CodedError(fmt.Errorf("license has an invalid cpu limit '%v'", license.CpuMhzLimit), http.StatusInternalServerError)
+	}
+
+	if int(allocated)+maxCpuMhz > limit {
+		return CodedError(fmt.Errorf("cannot allocate %d mhz to the organization since %d of the license's %d mhz are allocated to other organizations", maxCpuMhz, allocated, limit), http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+	OrganizationQuotas
+}
+
+type createOrganizationResponse struct {
+	OrganizationId uuid.UUID `json:"organization_id"`
+}
+
+func (s *OrganizationService) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var params createOrganizationRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid organization: %v", err), err)
+		return
+	}
+
+	org := schema.Organization{
+		Id:             uuid.New(),
+		Name:           params.Name,
+		MaxTrainJobs:   params.MaxTrainJobs,
+		MaxDeployments: params.MaxDeployments,
+		MaxCpuMhz:      params.MaxCpuMhz,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		var existing schema.Organization
+		result := txn.Limit(1).Find(&existing, "name = ?", params.Name)
+		if result.Error != nil {
+			slog.Error("sql error checking for duplicate organization name", "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected != 0 {
+			return CodedErrorWithErrorCode(fmt.Errorf("organization with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+		}
+
+		if err := s.checkCpuAllocations(txn, org.Id, org.MaxCpuMhz); err != nil {
+			return err
+		}
+
+		if result := txn.Create(&org); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return CodedErrorWithErrorCode(fmt.Errorf("organization with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+			}
+			slog.Error("sql error creating organization", "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.OrgCreatedEvent, nil, nil, map[string]interface{}{"organization_id": org.Id, "name": org.Name})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error creating organization: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, createOrganizationResponse{OrganizationId: org.Id})
+}
+
+// DeleteOrganization deletes an organization without any users or teams. The
+// users and teams must be removed first so that their models are not shared
+// with the default organization by accident.
+func (s *OrganizationService) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkOrganizationExists(txn, orgId); err != nil {
+			return err
+		}
+
+		var users, teams int64
+		if err := txn.Model(&schema.User{}).Where("organization_id = ?", orgId).Count(&users).Error; err != nil {
+			slog.Error("sql error counting organization users", "org_id", orgId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if err := txn.Model(&schema.Team{}).Where("organization_id = ?", orgId).Count(&teams).Error; err != nil {
+			slog.Error("sql error counting organization teams", "org_id", orgId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if users != 0 || teams != 0 {
+			return CodedError(fmt.Errorf("cannot delete organization %v since it has %d users and %d teams", orgId, users, teams), http.StatusUnprocessableEntity)
+		}
+
+		if result := txn.Delete(&schema.Organization{Id: orgId}); result.Error != nil {
+			slog.Error("sql error deleting organization", "org_id", orgId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.OrgDeletedEvent, nil, nil, map[string]interface{}{"organization_id": orgId})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error deleting organization: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+// SetQuotas replaces the quotas of the organization. Jobs that are already
+// running are not affected if the quotas are lowered.
+func (s *OrganizationService) SetQuotas(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params OrganizationQuotas
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid organization quotas: %v", err), err)
+		return
+	}
+
+	var org schema.Organization
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		var err error
+		org, err = schema.GetOrganization(orgId, txn)
+		if err != nil {
+			if errors.Is(err, schema.ErrOrgNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if err := s.checkCpuAllocations(txn, orgId, params.MaxCpuMhz); err != nil {
+			return err
+		}
+
+		org.MaxTrainJobs, org.MaxDeployments, org.MaxCpuMhz = params.MaxTrainJobs, params.MaxDeployments, params.MaxCpuMhz
+		if result := txn.Save(&org); result.Error != nil {
+			slog.Error("sql error updating organization quotas", "org_id", orgId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.OrgUpdatedEvent, nil, nil, map[string]interface{}{
+			"organization_id": orgId, "max_train_jobs": params.MaxTrainJobs, "max_deployments": params.MaxDeployments, "max_cpu_mhz": params.MaxCpuMhz,
+		})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error setting organization quotas: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, newOrganizationInfo(org))
+}
+
+// List returns all organizations for admins, and the user's organization for
+// other users.
+func (s *OrganizationService) List(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	orgs := []schema.Organization{}
+	if user.IsAdmin {
+		if result := s.db.Order("name").Find(&orgs); result.Error != nil {
+			slog.Error("sql error listing organizations", "error", result.Error)
+			http.Error(w, fmt.Sprintf("error listing organizations: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+			return
+		}
+	} else if user.OrganizationId != nil {
+		org, err := schema.GetOrganization(*user.OrganizationId, s.db)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error listing organizations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		orgs = append(orgs, org)
+	}
+
+	infos := make([]OrganizationInfo, 0, len(orgs))
+	for _, org := range orgs {
+		infos = append(infos, newOrganizationInfo(org))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+// Info returns the quotas, usage, and teams of the organization.
+func (s *OrganizationService) Info(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	org, err := schema.GetOrganization(orgId, s.db)
+	if err != nil {
+		if errors.Is(err, schema.ErrOrgNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := OrganizationDetails{OrganizationInfo: newOrganizationInfo(org), Teams: []TeamInfo{}}
+
+	if res.Usage.RunningTrainJobs, err = countActiveOrgJobs(s.db, orgId, "train_status", activeTrainStatuses); err != nil {
+		writeError(w, fmt.Sprintf("error retrieving organization usage: %v", err), err)
+		return
+	}
+	if res.Usage.Deployments, err = countActiveOrgJobs(s.db, orgId, "deploy_status", activeDeployStatuses); err != nil {
+		writeError(w, fmt.Sprintf("error retrieving organization usage: %v", err), err)
+		return
+	}
+	if res.Usage.CpuMhz, err = orgCpuUsage(s.db, orgId); err != nil {
+		writeError(w, fmt.Sprintf("error retrieving organization usage: %v", err), err)
+		return
+	}
+
+	var teams []schema.Team
+	if result := s.db.Order("name").Find(&teams, "organization_id = ?", orgId); result.Error != nil {
+		slog.Error("sql error listing organization teams", "org_id", orgId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error listing organization teams: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	for _, team := range teams {
+		res.Teams = append(res.Teams, TeamInfo{Id: team.Id, Name: team.Name, OrganizationId: team.OrganizationId})
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type OrganizationUserInfo struct {
+	UserId   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	OrgAdmin bool      `json:"org_admin"`
+}
+
+func (s *OrganizationService) Users(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := checkOrganizationExists(s.db, orgId); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	var users []schema.User
+	if result := s.db.Order("username").Find(&users, "organization_id = ?", orgId); result.Error != nil {
+		slog.Error("sql error listing organization users", "org_id", orgId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error listing organization users: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]OrganizationUserInfo, 0, len(users))
+	for _, user := range users {
+		infos = append(infos, OrganizationUserInfo{UserId: user.Id, Username: user.Username, Email: user.Email, OrgAdmin: user.IsOrgAdmin})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+// leaveTeams removes the user from the teams that are not in the organization,
+// and makes the user's models in those teams private, since teams cannot have
+// members from other organizations.
+func leaveTeams(txn *gorm.DB, userId uuid.UUID, orgId *uuid.UUID) error {
+	teams := txn.Model(&schema.Team{}).Select("id")
+	if orgId == nil {
+		teams = teams.Where("organization_id IS NOT NULL")
+	} else {
+		teams = teams.Where("organization_id IS NULL OR organization_id != ?", *orgId)
+	}
+
+	var teamIds []uuid.UUID
+	if result := txn.Model(&schema.UserTeam{}).Where("user_id = ? AND team_id IN (?)", userId, teams).Pluck("team_id", &teamIds); result.Error != nil {
+		slog.Error("sql error listing teams of user outside organization", "user_id", userId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(teamIds) == 0 {
+		return nil
+	}
+
+	var lockedModels int64
+	if err := txn.Model(&schema.Model{}).Where("user_id = ? AND team_id IN ? AND locked = ?", userId, teamIds, true).Count(&lockedModels).Error; err != nil {
+		slog.Error("sql error counting locked team models of user", "user_id", userId, "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if lockedModels != 0 {
+		return CodedError(fmt.Errorf("cannot change the organization of user %v since it has %d locked models shared with teams of its organization", userId, lockedModels), http.StatusUnprocessableEntity)
+	}
+
+	if result := txn.Where("user_id = ? AND team_id IN ?", userId, teamIds).Delete(&schema.UserTeam{}); result.Error != nil {
+		slog.Error("sql error removing user from teams outside organization", "user_id", userId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	result := txn.Model(&schema.Model{}).Where("user_id = ? AND team_id IN ?", userId, teamIds).Updates(map[string]interface{}{"team_id": nil, "access": schema.Private})
+	if result.Error != nil {
+		slog.Error("sql error updating model permissions after removing user from teams", "user_id", userId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	for _, teamId := range teamIds {
+		if err := recordEvent(txn, schema.TeamMemberRemovedEvent, nil, &teamId, map[string]interface{}{"user_id": userId}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setUserOrganization moves the user into the organization, or out of any
+// organization if orgId is nil. The user loses any org admin role and leaves
+// the teams of other organizations.
+func setUserOrganization(txn *gorm.DB, userId uuid.UUID, orgId *uuid.UUID) error {
+	if err := leaveTeams(txn, userId, orgId); err != nil {
+		return err
+	}
+
+	result := txn.Model(&schema.User{Id: userId}).Updates(map[string]interface{}{"organization_id": orgId, "is_org_admin": false})
+	if result.Error != nil {
+		slog.Error("sql error updating user organization", "user_id", userId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// AddUser moves a user without an organization into the organization. Users in
+// another organization must be removed from it first.
+func (s *OrganizationService) AddUser(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkOrganizationExists(txn, orgId); err != nil {
+			return err
+		}
+
+		user, err := schema.GetUser(userId, txn)
+		if err != nil {
+			if errors.Is(err, schema.ErrUserNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+		if user.OrganizationId != nil {
+			if *user.OrganizationId == orgId {
+				return nil
+			}
+			return CodedError(fmt.Errorf("user %v is already in organization %v, remove it from that organization first", userId, *user.OrganizationId), http.StatusConflict)
+		}
+
+		if err := setUserOrganization(txn, userId, &orgId); err != nil {
+			return err
+		}
+
+		return recordEvent(txn, schema.OrgMemberAddedEvent, nil, nil, map[string]interface{}{"organization_id": orgId, "user_id": userId})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error adding user to organization: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+// RemoveUser moves the user out of the organization. The user leaves the teams
+// of the organization, and their models shared with those teams become private.
+func (s *OrganizationService) RemoveUser(w http.ResponseWriter, r *http.Request) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkOrganizationMember(txn, userId, orgId); err != nil {
+			return err
+		}
+
+		if err := setUserOrganization(txn, userId, nil); err != nil {
+			return err
+		}
+
+		return recordEvent(txn, schema.OrgMemberRemovedEvent, nil, nil, map[string]interface{}{"organization_id": orgId, "user_id": userId})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error removing user from organization: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+func checkOrganizationMember(txn *gorm.DB, userId, orgId uuid.UUID) error {
+	if err := checkOrganizationExists(txn, orgId); err != nil {
+		return err
+	}
+
+	user, err := schema.GetUser(userId, txn)
+	if err != nil {
+		if errors.Is(err, schema.ErrUserNotFound) {
+			return CodedError(err, http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	if user.OrganizationId == nil || *user.OrganizationId != orgId {
+		return CodedError(errors.New("user is not a member of organization"), http.StatusNotFound)
+	}
+
+	return nil
+}
+
+func (s *OrganizationService) setOrgAdmin(w http.ResponseWriter, r *http.Request, isOrgAdmin bool) {
+	orgId, err := utils.URLParamUUID(r, "org_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := checkOrganizationMember(txn, userId, orgId); err != nil {
+			return err
+		}
+
+		if result := txn.Model(&schema.User{Id: userId}).Update("is_org_admin", isOrgAdmin); result.Error != nil {
+			slog.Error("sql error updating org admin", "user_id", userId, "org_id", orgId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return recordEvent(txn, schema.OrgAdminChangedEvent, nil, nil, map[string]interface{}{"organization_id": orgId, "user_id": userId, "is_org_admin": isOrgAdmin})
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating organization admin: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
+func (s *OrganizationService) AddOrgAdmin(w http.ResponseWriter, r *http.Request) {
+	s.setOrgAdmin(w, r, true)
+}
+
+func (s *OrganizationService) RemoveOrgAdmin(w http.ResponseWriter, r *http.Request) {
+	s.setOrgAdmin(w, r, false)
+}
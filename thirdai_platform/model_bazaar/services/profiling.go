@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"path/filepath"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultCpuProfileSeconds = 10
+	maxCpuProfileSeconds     = 120
+)
+
+// The profiles that are captured in addition to a cpu profile in each bundle.
+var bundleProfiles = []string{"heap", "allocs", "goroutine", "block", "mutex", "threadcreate"}
+
+type ProfilingService struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	userAuth  auth.IdentityProvider
+	variables Variables
+}
+
+func (s *ProfilingService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+	r.Use(s.profilingEnabled)
+
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	r.HandleFunc("/pprof/*", pprofIndex)
+
+	r.With(profileCaptureTimeouts).Post("/capture", s.Capture)
+
+	return r
+}
+
+func (s *ProfilingService) profilingEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.variables.EnableProfiling {
+			http.Error(w, "profiling is not enabled, set ENABLE_PROFILING to enable it", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pprofIndex serves the pprof index and named profiles. The pprof package
+// expects these to be served under /debug/pprof/, so the path is rewritten.
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/debug/pprof/" + chi.URLParam(r, "*")
+	pprof.Index(w, r2)
+}
+
+type captureProfileRequest struct {
+	CpuSeconds int        `json:"cpu_seconds"`
+	ModelId    *uuid.UUID `json:"model_id"`
+}
+
+type captureProfileResponse struct {
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+}
+
+type profile struct {
+	name string
+	data []byte
+}
+
+func captureLocalProfiles(cpuSeconds int) ([]profile, error) {
+	cpu := new(bytes.Buffer)
+	if err := runtimepprof.StartCPUProfile(cpu); err != nil {
+		return nil, CodedError(fmt.Errorf("unable to start cpu profile: %w", err), http.StatusConflict)
+	}
+	time.Sleep(time.Duration(cpuSeconds) * time.Second)
+	runtimepprof.StopCPUProfile()
+
+	profiles := []profile{{name: "cpu", data: cpu.Bytes()}}
+	for _, name := range bundleProfiles {
+		buf := new(bytes.Buffer)
+		if err := runtimepprof.Lookup(name).WriteTo(buf, 0); err != nil {
+			slog.Error("error writing profile", "profile", name, "error", err)
+			return nil, CodedError(fmt.Errorf("unable to capture %v profile", name), http.StatusInternalServerError)
+		}
+		profiles = append(profiles, profile{name: name, data: buf.Bytes()})
+	}
+
+	return profiles, nil
+}
+
+// captureDeploymentProfiles downloads profiles from the pprof endpoints of a
+// deployment. The request is forwarded with the caller's credentials, since the
+// deployment checks that the caller is an admin. If the deployment has multiple
+// replicas, the profiles are from whichever replica serves the requests.
+func captureDeploymentProfiles(endpoint string, r *http.Request, cpuSeconds int) ([]profile, error) {
+	fetch := func(name, query string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint+"/debug/pprof/"+name+query, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, header := range []string{"Authorization", "X-API-Key"} {
+			if value := r.Header.Get(header); value != "" {
+				req.Header.Set(header, value)
+			}
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+			return nil, fmt.Errorf("deployment returned status %d: %v", res.StatusCode, strings.TrimSpace(string(msg)))
+		}
+		return io.ReadAll(res.Body)
+	}
+
+	cpu, err := fetch("profile", fmt.Sprintf("?seconds=%d", cpuSeconds))
+	if err != nil {
+		return nil, CodedError(fmt.Errorf("unable to capture cpu profile from deployment: %w", err), http.StatusBadGateway)
+	}
+
+	profiles := []profile{{name: "cpu", data: cpu}}
+	for _, name := range bundleProfiles {
+		data, err := fetch(name, "")
+		if err != nil {
+			return nil, CodedError(fmt.Errorf("unable to capture %v profile from deployment: %w", name, err), http.StatusBadGateway)
+		}
+		profiles = append(profiles, profile{name: name, data: data})
+	}
+
+	return profiles, nil
+}
+
+func (s *ProfilingService) deploymentEndpoint(modelId uuid.UUID) (string, error) {
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return "", CodedError(err, http.StatusNotFound)
+		}
+		return "", CodedError(err, http.StatusInternalServerError)
+	}
+
+	if model.DeployStatus != schema.Complete {
+		return "", CodedError(fmt.Errorf("model %v is not deployed, it has deploy status '%v'", modelId, model.DeployStatus), http.StatusUnprocessableEntity)
+	}
+	if model.Type != schema.NdbModel {
+		return "", CodedError(fmt.Errorf("profiling is only supported for %v deployments", schema.NdbModel), http.StatusUnprocessableEntity)
+	}
+
+	return url.JoinPath(s.variables.ModelBazaarEndpoint, modelId.String())
+}
+
+func (s *ProfilingService) Capture(w http.ResponseWriter, r *http.Request) {
+	var params captureProfileRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.CpuSeconds == 0 {
+		params.CpuSeconds = defaultCpuProfileSeconds
+	}
+	if params.CpuSeconds < 0 || params.CpuSeconds > maxCpuProfileSeconds {
+		http.Error(w, fmt.Sprintf("cpu_seconds must be between 1 and %d", maxCpuProfileSeconds), http.StatusUnprocessableEntity)
+		return
+	}
+
+	target := "model_bazaar"
+	var profiles []profile
+	if params.ModelId != nil {
+		endpoint, err := s.deploymentEndpoint(*params.ModelId)
+		if err != nil {
+			writeError(w, fmt.Sprintf("unable to profile deployment: %v", err), err)
+			return
+		}
+		target = params.ModelId.String()
+
+		profiles, err = captureDeploymentProfiles(endpoint, r, params.CpuSeconds)
+		if err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+	} else {
+		var err error
+		profiles, err = captureLocalProfiles(params.CpuSeconds)
+		if err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+	}
+
+	dir := filepath.Join("profiles", target, time.Now().UTC().Format("20060102T150405Z"))
+	res := captureProfileResponse{Path: dir, Files: make([]string, 0, len(profiles))}
+	for _, p := range profiles {
+		filename := p.name + ".pb.gz"
+		if err := s.storage.Write(filepath.Join(dir, filename), bytes.NewReader(p.data)); err != nil {
+			slog.Error("error saving profile", "profile", p.name, "dir", dir, "error", err)
+			http.Error(w, "error saving profile bundle", http.StatusInternalServerError)
+			return
+		}
+		res.Files = append(res.Files, filename)
+	}
+
+	slog.Info("captured profile bundle", "target", target, "path", dir)
+
+	utils.WriteJsonResponse(w, res)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/ratelimit"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RateLimitService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+	limiter  *ratelimit.Limiter
+
+	// The limits from the environment, which apply to the budgets that an
+	// admin has not set.
+	defaults ratelimit.Config
+}
+
+func (s *RateLimitService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.Get)
+	r.Patch("/", s.Update)
+	r.Delete("/", s.Reset)
+
+	return r
+}
+
+type RateLimitsResponse struct {
+	// The limits in use, in requests per minute. 0 means the budget is disabled.
+	Limits ratelimit.Config `json:"limits"`
+	// The limits from the environment of model bazaar.
+	Defaults ratelimit.Config `json:"defaults"`
+}
+
+// UpdateRateLimitsRequest maps budget names to their new limits in requests per
+// minute. A null limit resets the budget to its default.
+type UpdateRateLimitsRequest map[string]*int
+
+// loadRateLimits returns the default limits with the limits set by admins
+// applied.
+func loadRateLimits(db *gorm.DB, defaults ratelimit.Config) (ratelimit.Config, error) {
+	var settings []schema.RateLimitSetting
+	if err := db.Find(&settings).Error; err != nil {
+		slog.Error("sql error loading rate limits", "error", err)
+		return ratelimit.Config{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	config := defaults
+	for _, setting := range settings {
+		if err := config.SetBudget(setting.Budget, setting.PerMinute); err != nil {
+			slog.Warn("ignoring saved rate limit", "budget", setting.Budget, "error", err)
+		}
+	}
+	return config, nil
+}
+
+// syncLimiter applies the limits saved in the database to the limiter, so that
+// changes made through other instances of model bazaar are picked up.
+func (s *RateLimitService) syncLimiter() error {
+	config, err := loadRateLimits(s.db, s.defaults)
+	if err != nil {
+		return err
+	}
+	if config != s.limiter.Config() {
+		slog.Info("applying updated rate limits", "limits", config)
+		s.limiter.SetConfig(config)
+	}
+	return nil
+}
+
+func (s *RateLimitService) Get(w http.ResponseWriter, r *http.Request) {
+	limits, err := loadRateLimits(s.db, s.defaults)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving rate limits: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, RateLimitsResponse{Limits: limits, Defaults: s.defaults})
+}
+
+// Update sets the limits of the given budgets. The new limits are applied
+// immediately by this instance, and by other instances of model bazaar at their
+// next status sync. Budgets whose limit changes start out full.
+func (s *RateLimitService) Update(w http.ResponseWriter, r *http.Request) {
+	var params UpdateRateLimitsRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	var check ratelimit.Config
+	for budget, limit := range params {
+		if err := check.SetBudget(budget, 0); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if limit != nil && *limit < 0 {
+			http.Error(w, fmt.Sprintf("%v rate limit must be non negative, use 0 to disable the budget or null for the default limit", budget), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		for budget, limit := range params {
+			if limit == nil {
+				if err := txn.Delete(&schema.RateLimitSetting{}, "budget = ?", budget).Error; err != nil {
+					slog.Error("sql error deleting rate limit", "budget", budget, "error", err)
+					return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+				}
+				continue
+			}
+
+			setting := schema.RateLimitSetting{Budget: budget, PerMinute: *limit, UpdatedAt: time.Now().UTC()}
+			if err := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&setting).Error; err != nil {
+				slog.Error("sql error saving rate limit", "budget", budget, "error", err)
+				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating rate limits: %v", err), err)
+		return
+	}
+
+	s.writeLimits(w)
+}
+
+// Reset removes the limits set by admins, so that the limits from the
+// environment apply to all budgets.
+func (s *RateLimitService) Reset(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Where("1 = 1").Delete(&schema.RateLimitSetting{}).Error; err != nil {
+		slog.Error("sql error clearing rate limits", "error", err)
+		writeError(w, "error resetting rate limits", CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError))
+		return
+	}
+
+	s.writeLimits(w)
+}
+
+func (s *RateLimitService) writeLimits(w http.ResponseWriter) {
+	if err := s.syncLimiter(); err != nil {
+		writeError(w, fmt.Sprintf("error applying rate limits: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, RateLimitsResponse{Limits: s.limiter.Config(), Defaults: s.defaults})
+}
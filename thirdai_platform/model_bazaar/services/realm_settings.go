@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/utils"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// RealmSettingsService lets admins change the email and login policies of the
+// identity provider after the platform is installed. It is only supported by
+// the keycloak identity provider.
+type RealmSettingsService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+	// realm is nil if the identity provider does not support realm settings.
+	realm auth.RealmSettingsProvider
+}
+
+func (s *RealmSettingsService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.Get)
+	r.Patch("/", s.Update)
+
+	return r
+}
+
+// UpdateRealmSettingsRequest changes the given settings, settings that are not
+// specified are unchanged.
+type UpdateRealmSettingsRequest struct {
+	SMTP *auth.RealmSMTP `json:"smtp,omitempty"`
+	// DisableSMTP stops keycloak from sending emails, it cannot be combined
+	// with SMTP.
+	DisableSMTP    bool                   `json:"disable_smtp,omitempty"`
+	PasswordPolicy *string                `json:"password_policy,omitempty"`
+	BruteForce     *auth.BruteForcePolicy `json:"brute_force,omitempty"`
+}
+
+func (s *RealmSettingsService) provider() (auth.RealmSettingsProvider, error) {
+	if s.realm == nil {
+		return nil, CodedError(errors.New("realm settings are only supported with the keycloak identity provider"), http.StatusUnprocessableEntity)
+	}
+	return s.realm, nil
+}
+
+func (s *RealmSettingsService) Get(w http.ResponseWriter, r *http.Request) {
+	provider, err := s.provider()
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	settings, err := provider.GetRealmSettings()
+	if err != nil {
+		slog.Error("error getting realm settings", "error", err)
+		http.Error(w, fmt.Sprintf("error getting realm settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, settings)
+}
+
+func (s *RealmSettingsService) Update(w http.ResponseWriter, r *http.Request) {
+	provider, err := s.provider()
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	var params UpdateRealmSettingsRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.DisableSMTP && params.SMTP != nil {
+		http.Error(w, "smtp and disable_smtp cannot both be specified", http.StatusUnprocessableEntity)
+		return
+	}
+
+	settings, err := provider.GetRealmSettings()
+	if err != nil {
+		slog.Error("error getting realm settings", "error", err)
+		http.Error(w, fmt.Sprintf("error getting realm settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if params.SMTP != nil {
+		settings.SMTP = params.SMTP
+		if settings.SMTP.Tls == "" {
+			settings.SMTP.Tls = auth.SmtpTlsStartTls
+		}
+	}
+	if params.DisableSMTP {
+		settings.SMTP = nil
+	}
+	if params.PasswordPolicy != nil {
+		settings.PasswordPolicy = *params.PasswordPolicy
+	}
+	if params.BruteForce != nil {
+		settings.BruteForce = *params.BruteForce
+	}
+
+	if err := settings.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := provider.UpdateRealmSettings(settings); err != nil {
+		if errors.Is(err, auth.ErrInvalidRealmSettings) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		slog.Error("error updating realm settings", "error", err)
+		http.Error(w, fmt.Sprintf("error updating realm settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("updated realm settings", "smtp_enabled", settings.SMTP != nil, "password_policy", settings.PasswordPolicy, "brute_force_max_failures", settings.BruteForce.MaxFailures)
+
+	settings, err = provider.GetRealmSettings()
+	if err != nil {
+		slog.Error("error getting realm settings", "error", err)
+		http.Error(w, fmt.Sprintf("error getting realm settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, settings)
+}
@@ -37,6 +37,8 @@ func (s *RecoveryService) Routes() chi.Router {
 
 	r.Post("/backup", s.Backup)
 	r.Get("/backups", s.ListLocalBackups)
+	r.Post("/restore", s.Restore)
+	r.Get("/restore/{restore_id}", s.GetRestoreStatus)
 
 	return r
 }
@@ -65,12 +67,14 @@ func (s *RecoveryService) getDbUri() (string, error) {
 	return fmt.Sprintf("postgresql://%v:%v@%v:%v/%v", fields["user"], fields["password"], fields["host"], fields["port"], fields["dbname"]), nil
 }
 
-func (s *RecoveryService) saveConfig(params BackupRequest, configPath string) error {
-	providerInfo := map[string]string{"provider": params.Provider}
-	if params.Provider != "local" {
-		providerInfo["bucket_name"] = params.BucketName
+// providerConfig returns the config for the storage provider that backups are
+// saved to, in the format expected by the snapshot and restore jobs.
+func (s *RecoveryService) providerConfig(provider, bucketName string) (map[string]string, error) {
+	providerInfo := map[string]string{"provider": provider}
+	if provider != "local" {
+		providerInfo["bucket_name"] = bucketName
 	}
-	switch params.Provider {
+	switch provider {
 	case "s3":
 		providerInfo["aws_access_key"] = s.variables.CloudCredentials.AwsAccessKey
 		providerInfo["aws_secret_access_key"] = s.variables.CloudCredentials.AwsAccessSecret
@@ -82,13 +86,12 @@ func (s *RecoveryService) saveConfig(params BackupRequest, configPath string) er
 	case "local":
 	// pass
 	default:
-		return CodedError(fmt.Errorf("invalid provider: '%v'", params.Provider), http.StatusUnprocessableEntity)
-	}
-
-	config := map[string]interface{}{
-		"provider": providerInfo, "interval_minutes": params.IntervalMinutes, "backup_limit": params.BackupLimit,
+		return nil, CodedError(fmt.Errorf("invalid provider: '%v'", provider), http.StatusUnprocessableEntity)
 	}
+	return providerInfo, nil
+}
 
+func (s *RecoveryService) writeJobConfig(config map[string]interface{}, configPath string) error {
 	data, err := json.Marshal(config)
 	if err != nil {
 		slog.Error("error serializing snapshot config", "error", err)
@@ -104,12 +107,36 @@ func (s *RecoveryService) saveConfig(params BackupRequest, configPath string) er
 	return nil
 }
 
+func (s *RecoveryService) saveConfig(params BackupRequest, configPath string) error {
+	providerInfo, err := s.providerConfig(params.Provider, params.BucketName)
+	if err != nil {
+		return err
+	}
+
+	if params.FullBackupEvery != nil && *params.FullBackupEvery < 1 {
+		return CodedError(errors.New("full_backup_every must be at least 1"), http.StatusUnprocessableEntity)
+	}
+
+	config := map[string]interface{}{
+		"provider": providerInfo, "interval_minutes": params.IntervalMinutes, "backup_limit": params.BackupLimit,
+		"incremental": params.Incremental, "full_backup_every": params.FullBackupEvery,
+	}
+
+	return s.writeJobConfig(config, configPath)
+}
+
 type BackupRequest struct {
 	Provider   string `json:"provider"`
 	BucketName string `json:"bucket_name"`
 
 	IntervalMinutes *int `json:"interval_minutes"`
 	BackupLimit     *int `json:"backup_limit"`
+
+	// Incremental backups only contain the models that changed since the
+	// previous backup. A full backup is still taken after every
+	// FullBackupEvery incremental backups, or if there is no previous backup.
+	Incremental     bool `json:"incremental"`
+	FullBackupEvery *int `json:"full_backup_every"`
 }
 
 func (s *RecoveryService) Backup(w http.ResponseWriter, r *http.Request) {
@@ -136,7 +163,7 @@ func (s *RecoveryService) Backup(w http.ResponseWriter, r *http.Request) {
 	configPath := "backup_config.json"
 	err = s.saveConfig(params, configPath)
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -159,7 +186,8 @@ func (s *RecoveryService) Backup(w http.ResponseWriter, r *http.Request) {
 	err = s.orchestratorClient.StartJob(job)
 	if err != nil {
 		slog.Error("error starting snapshot job", "error", err)
-		http.Error(w, "error starting snapshot job", http.StatusInternalServerError)
+		err = jobStartError(err, "error starting snapshot job")
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/orchestrator/kubernetes"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RestoreStarting   = "starting"
+	RestoreInProgress = "in_progress"
+	RestoreComplete   = "complete"
+	RestoreFailed     = "failed"
+)
+
+// A restore replaces the database and the models directory, so it is only
+// started when no train jobs or deployments have these statuses.
+var restoreBlockingStatuses = []string{schema.Queued, schema.Starting, schema.InProgress}
+
+// Backups are named by the time they were created, and incremental backups have
+// the suffix _incremental.
+var backupNameRe = regexp.MustCompile(`^backup_\d{8}_\d{6}(_incremental)?\.zip$`)
+
+type RestoreRequest struct {
+	Backup     string `json:"backup"`
+	Provider   string `json:"provider"`
+	BucketName string `json:"bucket_name"`
+}
+
+type RestoreResponse struct {
+	RestoreId uuid.UUID `json:"restore_id"`
+}
+
+// RestoreStatus is written by the restore job as it runs. It is kept in storage
+// rather than the database since the database is replaced by the restore.
+type RestoreStatus struct {
+	RestoreId uuid.UUID `json:"restore_id"`
+	Backup    string    `json:"backup"`
+	Status    string    `json:"status"`
+	// The current step of the restore, one of downloading, restoring_files, or
+	// restoring_database.
+	Step string `json:"step,omitempty"`
+	// The backups that are restored, starting with the full backup that the
+	// incremental backups are applied to.
+	Backups       []string  `json:"backups"`
+	FilesRestored int       `json:"files_restored"`
+	TotalFiles    int       `json:"total_files"`
+	Error         string    `json:"error,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func restoreDir(restoreId uuid.UUID) string {
+	return filepath.Join("restores", restoreId.String())
+}
+
+func restoreStatusPath(restoreId uuid.UUID) string {
+	return filepath.Join(restoreDir(restoreId), "status.json")
+}
+
+// Restore starts a job that restores the database and the share directory from
+// the given backup, along with the backups it was incremented from. Only one
+// restore can run at a time.
+func (s *RecoveryService) Restore(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.orchestratorClient.(*kubernetes.KubernetesClient); ok {
+		slog.Warn("Restore job not implemented for Kubernetes")
+		http.Error(w, "restore job not implemented in kubernetes environment", http.StatusNotImplemented)
+		return
+	}
+
+	var params RestoreRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if !backupNameRe.MatchString(params.Backup) {
+		http.Error(w, fmt.Sprintf("invalid backup name '%v'", params.Backup), http.StatusUnprocessableEntity)
+		return
+	}
+
+	providerInfo, err := s.providerConfig(params.Provider, params.BucketName)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if params.Provider == "local" {
+		exists, err := s.storage.Exists(filepath.Join("backups", params.Backup))
+		if err != nil {
+			slog.Error("error checking if local backup exists", "backup", params.Backup, "error", err)
+			http.Error(w, "error checking if backup exists", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, fmt.Sprintf("backup '%v' not found", params.Backup), http.StatusNotFound)
+			return
+		}
+	}
+
+	// The restore job checks this as well, since jobs could be started after the
+	// request.
+	var active int64
+	result := s.db.Model(&schema.Model{}).
+		Where("train_status IN ? OR deploy_status IN ?", restoreBlockingStatuses, restoreBlockingStatuses).
+		Count(&active)
+	if result.Error != nil {
+		slog.Error("sql error checking for running jobs before restore", "error", result.Error)
+		http.Error(w, fmt.Sprintf("error checking for running jobs: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	if active > 0 {
+		http.Error(w, fmt.Sprintf("cannot restore while %d models have running train jobs or deployments, stop them before restoring", active), http.StatusConflict)
+		return
+	}
+
+	dbUri, err := s.getDbUri()
+	if err != nil {
+		slog.Error("unable to perform restore, unable to validate DB connection string", "error", err)
+		http.Error(w, "unable to perform restore, unable to validate DB connection string", http.StatusInternalServerError)
+		return
+	}
+
+	restoreId := uuid.New()
+	job := orchestrator.RestoreJob{RestoreId: restoreId.String(), ShareDir: s.variables.ShareDir, DbUri: dbUri, Driver: s.variables.BackendDriver}
+
+	info, err := s.orchestratorClient.JobInfo(job.GetJobName())
+	if err != nil && !errors.Is(err, orchestrator.ErrJobNotFound) {
+		slog.Error("error checking for running restore job", "error", err)
+		http.Error(w, "error checking for running restore job", http.StatusInternalServerError)
+		return
+	}
+	if err == nil && info.Status != orchestrator.StatusDead {
+		http.Error(w, "a restore is already in progress", http.StatusConflict)
+		return
+	}
+
+	configPath := filepath.Join(restoreDir(restoreId), "config.json")
+	config := map[string]interface{}{"provider": providerInfo, "backup": params.Backup, "restore_id": restoreId}
+	if err := s.writeJobConfig(config, configPath); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+	job.ConfigPath = filepath.Join(s.storage.Location(), configPath)
+
+	now := time.Now().UTC()
+	status := RestoreStatus{RestoreId: restoreId, Backup: params.Backup, Status: RestoreStarting, Backups: []string{}, StartedAt: now, UpdatedAt: now}
+	if err := s.writeRestoreStatus(status); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	if err := orchestrator.StopJobIfExists(s.orchestratorClient, job.GetJobName()); err != nil {
+		slog.Error("error stopping previous restore job", "error", err)
+		http.Error(w, "error stopping previous restore job", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.orchestratorClient.StartJob(job); err != nil {
+		slog.Error("error starting restore job", "error", err)
+		status.Status, status.Error = RestoreFailed, "unable to start restore job"
+		_ = s.writeRestoreStatus(status)
+		err = jobStartError(err, "error starting restore job")
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	slog.Info("started restore", "restore_id", restoreId, "backup", params.Backup, "provider", params.Provider)
+
+	utils.WriteJsonResponse(w, RestoreResponse{RestoreId: restoreId})
+}
+
+func (s *RecoveryService) writeRestoreStatus(status RestoreStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		slog.Error("error serializing restore status", "error", err)
+		return CodedError(errors.New("error saving restore status"), http.StatusInternalServerError)
+	}
+	if err := s.storage.Write(restoreStatusPath(status.RestoreId), bytes.NewReader(data)); err != nil {
+		slog.Error("error saving restore status", "restore_id", status.RestoreId, "error", err)
+		return CodedError(errors.New("error saving restore status"), http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// GetRestoreStatus returns the progress of a restore. A restore that has not
+// finished is reported as failed if its job is no longer running.
+func (s *RecoveryService) GetRestoreStatus(w http.ResponseWriter, r *http.Request) {
+	restoreId, err := utils.URLParamUUID(r, "restore_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := s.storage.Exists(restoreStatusPath(restoreId))
+	if err != nil {
+		slog.Error("error checking if restore status exists", "restore_id", restoreId, "error", err)
+		http.Error(w, "error reading restore status", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, fmt.Sprintf("restore %v not found", restoreId), http.StatusNotFound)
+		return
+	}
+
+	file, err := s.storage.Read(restoreStatusPath(restoreId))
+	if err != nil {
+		slog.Error("error reading restore status", "restore_id", restoreId, "error", err)
+		http.Error(w, "error reading restore status", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	var status RestoreStatus
+	if err := json.NewDecoder(file).Decode(&status); err != nil {
+		slog.Error("error parsing restore status", "restore_id", restoreId, "error", err)
+		http.Error(w, "error reading restore status", http.StatusInternalServerError)
+		return
+	}
+
+	if status.Status == RestoreStarting || status.Status == RestoreInProgress {
+		info, err := s.orchestratorClient.JobInfo(orchestrator.RestoreJob{}.GetJobName())
+		if errors.Is(err, orchestrator.ErrJobNotFound) || (err == nil && info.Status == orchestrator.StatusDead) {
+			status.Status = RestoreFailed
+			status.Error = "restore job stopped before the restore completed, check the restore logs for details"
+		} else if err != nil {
+			slog.Error("error checking restore job", "restore_id", restoreId, "error", err)
+		}
+	}
+
+	utils.WriteJsonResponse(w, status)
+}
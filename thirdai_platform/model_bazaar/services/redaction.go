@@ -0,0 +1,301 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// RedactMask replaces an entity with its tag, e.g. [NAME].
+	RedactMask = "mask"
+	// RedactTokenize replaces an entity with a label, e.g. [NAME#0], that users
+	// with the unredact permission can map back to the original text.
+	RedactTokenize = "tokenize"
+	// RedactDrop removes the entity from the text.
+	RedactDrop = "drop"
+
+	redactionPolicyAttr = "redaction_policy"
+)
+
+// RedactionPolicy is the action an enterprise search workflow takes on the
+// entities its guardrail detects. Entities overrides the default action for
+// specific tags.
+type RedactionPolicy struct {
+	Default  string            `json:"default" validate:"oneof=mask tokenize drop"`
+	Entities map[string]string `json:"entities"`
+}
+
+func defaultRedactionPolicy() RedactionPolicy {
+	return RedactionPolicy{Default: RedactTokenize, Entities: map[string]string{}}
+}
+
+func (p *RedactionPolicy) Validate() error {
+	errs := validation.Struct(p)
+	if p.Default == "" {
+		p.Default = RedactTokenize
+	}
+	if p.Entities == nil {
+		p.Entities = map[string]string{}
+	}
+	for tag, action := range p.Entities {
+		if !entityTagRe.MatchString(tag) {
+			errs.Add(fmt.Sprintf("entities.%v", tag), "tags must be at most 100 characters and only contain letters, numbers, '_', and '-'")
+		} else if action != RedactMask && action != RedactTokenize && action != RedactDrop {
+			errs.Add(fmt.Sprintf("entities.%v", tag), "action must be one of %v, %v, or %v", RedactMask, RedactTokenize, RedactDrop)
+		}
+	}
+	return errs.Err()
+}
+
+func getRedactionPolicy(attrs map[string]string) (RedactionPolicy, error) {
+	value, ok := attrs[redactionPolicyAttr]
+	if !ok {
+		return defaultRedactionPolicy(), nil
+	}
+	var policy RedactionPolicy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		return RedactionPolicy{}, fmt.Errorf("error parsing redaction policy: %w", err)
+	}
+	return policy, nil
+}
+
+// redactionKey returns the key the deployment of an enterprise search workflow
+// uses to encrypt the original text of tokenized entities. It is derived from
+// the platform secret so that it is the same for every replica and deployment
+// of the workflow. The key is not stored in the database, it is passed to the
+// deployment in the options of its deploy config, so the config in storage must
+// be protected like the encrypted text.
+func redactionKey(secret []byte, modelId uuid.UUID) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(modelId.String()))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func hasUnredactGrant(db *gorm.DB, modelId, userId uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.Model(&schema.UnredactGrant{}).Where("model_id = ? AND user_id = ?", modelId, userId).Count(&count).Error; err != nil {
+		slog.Error("sql error checking unredact grant", "model_id", modelId, "user_id", userId, "error", err)
+		return false, schema.ErrDbAccessFailed
+	}
+	return count > 0, nil
+}
+
+// getGuardrailWorkflow returns the model if it is an enterprise search
+// workflow with a guardrail, since redaction only applies to those workflows.
+func getGuardrailWorkflow(db *gorm.DB, modelId uuid.UUID) (schema.Model, error) {
+	model, err := schema.GetModel(modelId, db, false, true, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return schema.Model{}, CodedError(err, http.StatusNotFound)
+		}
+		return schema.Model{}, CodedError(err, http.StatusInternalServerError)
+	}
+	if model.Type != schema.EnterpriseSearch {
+		return schema.Model{}, CodedError(fmt.Errorf("redaction policies are only supported for %v workflows", schema.EnterpriseSearch), http.StatusUnprocessableEntity)
+	}
+	if _, ok := model.GetAttributes()["guardrail_id"]; !ok {
+		return schema.Model{}, CodedError(errors.New("redaction policies require a workflow with a guardrail"), http.StatusUnprocessableEntity)
+	}
+	return model, nil
+}
+
+func (s *WorkflowService) GetRedactionPolicy(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	model, err := getGuardrailWorkflow(s.db, modelId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving redaction policy: %v", err), err)
+		return
+	}
+
+	policy, err := getRedactionPolicy(model.GetAttributes())
+	if err != nil {
+		slog.Error("error loading redaction policy", "model_id", modelId, "error", err)
+		http.Error(w, "error loading redaction policy", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, policy)
+}
+
+// SetRedactionPolicy replaces the redaction policy of the workflow. The new
+// policy applies the next time the workflow is deployed.
+func (s *WorkflowService) SetRedactionPolicy(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var policy RedactionPolicy
+	if !utils.ParseRequestBody(w, r, &policy) {
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid redaction policy: %v", err), err)
+		return
+	}
+
+	policyJson, err := json.Marshal(policy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error serializing redaction policy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if _, err := getGuardrailWorkflow(txn, modelId); err != nil {
+			return err
+		}
+		result := txn.Save(&schema.ModelAttribute{ModelId: modelId, Key: redactionPolicyAttr, Value: string(policyJson)})
+		if result.Error != nil {
+			slog.Error("sql error saving redaction policy", "model_id", modelId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating redaction policy: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, policy)
+}
+
+type UnredactGrantInfo struct {
+	UserId    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	GrantedBy uuid.UUID `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *WorkflowService) ListUnredactGrants(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var grants []schema.UnredactGrant
+	if err := s.db.Preload("User").Where("model_id = ?", modelId).Order("created_at").Find(&grants).Error; err != nil {
+		slog.Error("sql error listing unredact grants", "model_id", modelId, "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]UnredactGrantInfo, 0, len(grants))
+	for _, grant := range grants {
+		info := UnredactGrantInfo{UserId: grant.UserId, GrantedBy: grant.GrantedBy, CreatedAt: grant.CreatedAt.UTC()}
+		if grant.User != nil {
+			info.Username = grant.User.Username
+			info.Email = grant.User.Email
+		}
+		infos = append(infos, info)
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type unredactGrantRequest struct {
+	UserId uuid.UUID `json:"user_id" validate:"required"`
+}
+
+// AddUnredactGrant allows a user to unredact the entities tokenized by the
+// workflow. The user must also be able to read the workflow to query it.
+func (s *WorkflowService) AddUnredactGrant(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params unredactGrantRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid unredact grant: %v", err), err)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if _, err := getGuardrailWorkflow(txn, modelId); err != nil {
+			return err
+		}
+
+		if _, err := schema.GetUser(params.UserId, txn); err != nil {
+			if errors.Is(err, schema.ErrUserNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		result := txn.Save(&schema.UnredactGrant{ModelId: modelId, UserId: params.UserId, GrantedBy: user.Id, CreatedAt: time.Now().UTC()})
+		if result.Error != nil {
+			slog.Error("sql error saving unredact grant", "model_id", modelId, "user_id", params.UserId, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error granting unredact permission: %v", err), err)
+		return
+	}
+
+	slog.Info("granted unredact permission", "model_id", modelId, "user_id", params.UserId, "granted_by", user.Id)
+
+	utils.WriteSuccess(w)
+}
+
+func (s *WorkflowService) DeleteUnredactGrant(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userId, err := utils.URLParamUUID(r, "user_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := s.db.Delete(&schema.UnredactGrant{}, "model_id = ? AND user_id = ?", modelId, userId)
+	if result.Error != nil {
+		slog.Error("sql error deleting unredact grant", "model_id", modelId, "user_id", userId, "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("user %v does not have an unredact grant for workflow %v", userId, modelId), http.StatusNotFound)
+		return
+	}
+
+	slog.Info("revoked unredact permission", "model_id", modelId, "user_id", userId)
+
+	utils.WriteSuccess(w)
+}
@@ -0,0 +1,633 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/reports"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	TrainReportKind          = "train"
+	EvaluationComparisonKind = "evaluation_comparison"
+	UsageSummaryKind         = "usage"
+
+	defaultReportExpirationDays = 30
+	maxReportExpirationDays     = 365
+	maxComparisonModels         = 20
+
+	shareTokenLength = 32
+)
+
+// ReportService renders reports to html or pdf artifacts which are saved in
+// storage and can be retrieved with a share link by anyone that has the link,
+// including people without platform accounts.
+type ReportService struct {
+	db                 *gorm.DB
+	storage            storage.Storage
+	orchestratorClient orchestrator.Client
+	license            *licensing.LicenseVerifier
+	userAuth           auth.IdentityProvider
+	variables          Variables
+}
+
+func (s *ReportService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.userAuth.AuthMiddleware()...)
+
+		r.Get("/", s.List)
+		r.Delete("/{report_id}", s.Delete)
+
+		r.With(auth.ModelPermissionOnly(s.db, auth.ReadPermission)).Post("/train/{model_id}", s.TrainReport)
+		r.Post("/evaluation-comparison", s.EvaluationComparison)
+		r.With(auth.AdminOnly(s.db)).Post("/usage", s.UsageSummary)
+		r.With(auth.AdminOnly(s.db)).Post("/health", s.HealthReport)
+	})
+
+	r.Get("/shared/{token}", s.Shared)
+
+	return r
+}
+
+type reportOptions struct {
+	Format        string `json:"format"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+func (opts *reportOptions) validate() error {
+	if opts.Format == "" {
+		opts.Format = reports.FormatHTML
+	}
+	if opts.Format != reports.FormatHTML && opts.Format != reports.FormatPDF {
+		return fmt.Errorf("invalid report format '%v', must be '%v' or '%v'", opts.Format, reports.FormatHTML, reports.FormatPDF)
+	}
+	if opts.ExpiresInDays == 0 {
+		opts.ExpiresInDays = defaultReportExpirationDays
+	}
+	if opts.ExpiresInDays < 0 || opts.ExpiresInDays > maxReportExpirationDays {
+		return fmt.Errorf("expires_in_days must be between 1 and %d", maxReportExpirationDays)
+	}
+	return nil
+}
+
+type SharedReportInfo struct {
+	Id        uuid.UUID `json:"report_id"`
+	Kind      string    `json:"kind"`
+	Title     string    `json:"title"`
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type createReportResponse struct {
+	SharedReportInfo
+	Link string `json:"link"`
+}
+
+func convertSharedReport(report schema.SharedReport) SharedReportInfo {
+	return SharedReportInfo{
+		Id:        report.Id,
+		Kind:      report.Kind,
+		Title:     report.Title,
+		Format:    report.Format,
+		CreatedAt: report.CreatedAt.UTC(),
+		ExpiresAt: report.ExpiresAt.UTC(),
+	}
+}
+
+func sharedReportPath(report schema.SharedReport) string {
+	return filepath.Join("reports", report.Id.String(), "report."+report.Format)
+}
+
+// saveReport renders the document, saves it to storage, and returns the share
+// link for the report.
+func (s *ReportService) saveReport(user schema.User, kind string, doc reports.Document, opts reportOptions) (createReportResponse, error) {
+	content, err := reports.Render(doc, opts.Format)
+	if err != nil {
+		slog.Error("error rendering report", "kind", kind, "error", err)
+		return createReportResponse{}, CodedError(errors.New("error rendering report"), http.StatusInternalServerError)
+	}
+
+	token, err := generateRandomString(shareTokenLength)
+	if err != nil {
+		slog.Error("error generating report share token", "error", err)
+		return createReportResponse{}, CodedError(errors.New("error generating share link"), http.StatusInternalServerError)
+	}
+
+	report := schema.SharedReport{
+		Id:        uuid.New(),
+		Kind:      kind,
+		Title:     doc.Title,
+		Format:    opts.Format,
+		TokenHash: hashSecret(token),
+		CreatedBy: user.Id,
+		CreatedAt: doc.GeneratedAt.UTC(),
+		ExpiresAt: doc.GeneratedAt.AddDate(0, 0, opts.ExpiresInDays).UTC(),
+	}
+
+	if err := s.storage.Write(sharedReportPath(report), bytes.NewReader(content)); err != nil {
+		slog.Error("error saving report", "report_id", report.Id, "error", err)
+		return createReportResponse{}, CodedError(errors.New("error saving report"), http.StatusInternalServerError)
+	}
+
+	if result := s.db.Create(&report); result.Error != nil {
+		slog.Error("sql error creating shared report", "report_id", report.Id, "error", result.Error)
+		if err := s.storage.Delete(filepath.Dir(sharedReportPath(report))); err != nil {
+			slog.Error("error cleaning up report after failed creation", "report_id", report.Id, "error", err)
+		}
+		return createReportResponse{}, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	link, err := url.JoinPath(s.variables.ModelBazaarEndpoint, "api/v2/report/shared", token)
+	if err != nil {
+		slog.Error("error formatting report share link", "error", err)
+		return createReportResponse{}, CodedError(errors.New("error formatting share link"), http.StatusInternalServerError)
+	}
+
+	slog.Info("created shared report", "report_id", report.Id, "kind", kind, "format", opts.Format, "user_id", user.Id)
+
+	return createReportResponse{SharedReportInfo: convertSharedReport(report), Link: link}, nil
+}
+
+func modelSummaryTable(model schema.Model) *reports.Table {
+	return &reports.Table{
+		Columns: []string{"Field", "Value"},
+		Rows: [][]string{
+			{"Name", model.Name},
+			{"Id", model.Id.String()},
+			{"Type", model.Type},
+			{"Train Status", model.TrainStatus},
+			{"Created", model.PublishedDate.UTC().Format(time.RFC3339)},
+		},
+	}
+}
+
+func evaluationTable(evaluation *holdoutEvaluationResponse) *reports.Table {
+	metrics := make([]string, 0, len(evaluation.Metrics))
+	for metric := range evaluation.Metrics {
+		metrics = append(metrics, metric)
+	}
+	slices.Sort(metrics)
+
+	table := &reports.Table{Columns: []string{"Metric", "Value", "Threshold", "Passed"}}
+	for _, metric := range metrics {
+		threshold, passed := "", ""
+		if t, ok := evaluation.Thresholds[metric]; ok {
+			threshold = strconv.FormatFloat(t, 'g', 6, 64)
+			passed = strconv.FormatBool(!slices.Contains(evaluation.FailedMetrics, metric))
+		}
+		table.Rows = append(table.Rows, []string{metric, strconv.FormatFloat(evaluation.Metrics[metric], 'g', 6, 64), threshold, passed})
+	}
+	return table
+}
+
+func (s *ReportService) TrainReport(w http.ResponseWriter, r *http.Request) {
+	var opts reportOptions
+	if !utils.ParseRequestBody(w, r, &opts) {
+		return
+	}
+	if err := opts.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	model, err := schema.GetModel(modelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error retrieving model info: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if model.TrainStatus != schema.Complete {
+		http.Error(w, fmt.Sprintf("unable to create train report, model %v has status %v", model.Id, model.TrainStatus), http.StatusUnprocessableEntity)
+		return
+	}
+
+	doc := reports.Document{
+		Title:       fmt.Sprintf("Training Report: %v", model.Name),
+		Subtitle:    fmt.Sprintf("%v model %v", model.Type, model.Id),
+		GeneratedAt: time.Now().UTC(),
+		Sections:    []reports.Section{{Heading: "Model", Table: modelSummaryTable(model)}},
+	}
+
+	evaluation, err := getHoldoutEvaluation(s.db, model.Id)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving holdout evaluation: %v", err), err)
+		return
+	}
+	if evaluation != nil {
+		doc.Sections = append(doc.Sections, reports.Section{Heading: "Holdout Evaluation", Table: evaluationTable(evaluation)})
+	}
+
+	trainReport, err := readLatestTrainReport(s.storage, model.Id)
+	switch {
+	case err == nil:
+		doc.Sections = append(doc.Sections, reports.Section{Heading: "Train Report", Table: reports.KeyValueTable(trainReport)})
+	case GetResponseCode(err) == http.StatusUnprocessableEntity:
+		doc.Sections = append(doc.Sections, reports.Section{Heading: "Train Report", Paragraphs: []string{"No train report was recorded for this model."}})
+	default:
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	res, err := s.saveReport(user, TrainReportKind, doc, opts)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type evaluationComparisonRequest struct {
+	reportOptions
+	ModelIds []uuid.UUID `json:"model_ids"`
+}
+
+func (s *ReportService) EvaluationComparison(w http.ResponseWriter, r *http.Request) {
+	var params evaluationComparisonRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := params.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(params.ModelIds) < 2 || len(params.ModelIds) > maxComparisonModels {
+		http.Error(w, fmt.Sprintf("between 2 and %d models must be specified for comparison", maxComparisonModels), http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	models := make([]schema.Model, 0, len(params.ModelIds))
+	evaluations := make([]*holdoutEvaluationResponse, 0, len(params.ModelIds))
+	metricSet := map[string]bool{}
+
+	for _, modelId := range params.ModelIds {
+		model, err := schema.GetModel(modelId, s.db, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error retrieving model info: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		perm, err := auth.GetModelPermissions(model.Id, user, s.db)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error verifying permissions for model %v: %v", model.Id, err), http.StatusInternalServerError)
+			return
+		}
+		if perm < auth.ReadPermission {
+			http.Error(w, fmt.Sprintf("user does not have permission to access model %v", model.Id), http.StatusForbidden)
+			return
+		}
+
+		evaluation, err := getHoldoutEvaluation(s.db, model.Id)
+		if err != nil {
+			writeError(w, fmt.Sprintf("error retrieving holdout evaluation: %v", err), err)
+			return
+		}
+		if evaluation != nil {
+			for metric := range evaluation.Metrics {
+				metricSet[metric] = true
+			}
+		}
+
+		models = append(models, model)
+		evaluations = append(evaluations, evaluation)
+	}
+
+	metrics := make([]string, 0, len(metricSet))
+	for metric := range metricSet {
+		metrics = append(metrics, metric)
+	}
+	slices.Sort(metrics)
+
+	table := &reports.Table{Columns: slices.Concat([]string{"Model", "Type"}, metrics, []string{"Passed Thresholds"})}
+	missing := []string{}
+	for i, model := range models {
+		row := []string{model.Name, model.Type}
+		evaluation := evaluations[i]
+		if evaluation == nil {
+			missing = append(missing, model.Name)
+		}
+		for _, metric := range metrics {
+			value := ""
+			if evaluation != nil {
+				if v, ok := evaluation.Metrics[metric]; ok {
+					value = strconv.FormatFloat(v, 'g', 6, 64)
+				}
+			}
+			row = append(row, value)
+		}
+		passed := ""
+		if evaluation != nil {
+			passed = strconv.FormatBool(evaluation.Passed)
+		}
+		table.Rows = append(table.Rows, append(row, passed))
+	}
+
+	summary := []string{}
+	for _, metric := range metrics {
+		best := -1
+		for i, evaluation := range evaluations {
+			if evaluation == nil {
+				continue
+			}
+			if v, ok := evaluation.Metrics[metric]; ok && (best < 0 || v > evaluations[best].Metrics[metric]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			summary = append(summary, fmt.Sprintf("Best %v: %v (%v)", metric, models[best].Name, strconv.FormatFloat(evaluations[best].Metrics[metric], 'g', 6, 64)))
+		}
+	}
+	if len(missing) > 0 {
+		summary = append(summary, fmt.Sprintf("No holdout evaluation was recorded for: %v", missing))
+	}
+
+	doc := reports.Document{
+		Title:       "Evaluation Comparison",
+		Subtitle:    fmt.Sprintf("Holdout evaluation metrics for %d models", len(models)),
+		GeneratedAt: time.Now().UTC(),
+		Sections: []reports.Section{
+			{Heading: "Summary", Paragraphs: summary},
+			{Heading: "Metrics", Table: table},
+		},
+	}
+
+	res, err := s.saveReport(user, EvaluationComparisonKind, doc, params.reportOptions)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type usageSummaryRequest struct {
+	reportOptions
+	// The month to summarize in the format YYYY-MM, defaults to the current month.
+	Month string `json:"month"`
+}
+
+func countTable(column string, counts map[string]int) *reports.Table {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	table := &reports.Table{Columns: []string{column, "Count"}}
+	for _, k := range keys {
+		table.Rows = append(table.Rows, []string{k, strconv.Itoa(counts[k])})
+	}
+	return table
+}
+
+func (s *ReportService) UsageSummary(w http.ResponseWriter, r *http.Request) {
+	var params usageSummaryRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := params.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if params.Month != "" {
+		month, err := time.Parse("2006-01", params.Month)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid month '%v', must have the format YYYY-MM", params.Month), http.StatusUnprocessableEntity)
+			return
+		}
+		start = month
+	}
+	end := start.AddDate(0, 1, 0)
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var events []schema.PlatformEvent
+	if err := s.db.Where("timestamp >= ? AND timestamp < ?", start, end).Find(&events).Error; err != nil {
+		slog.Error("sql error listing events for usage summary", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	eventCounts := map[string]int{}
+	modelTypes := map[string]int{}
+	trainStatuses := map[string]int{}
+	deployStatuses := map[string]int{}
+	activeModels := map[uuid.UUID]bool{}
+
+	for _, event := range events {
+		eventCounts[event.Type]++
+		if event.ModelId != nil {
+			activeModels[*event.ModelId] = true
+		}
+
+		var data map[string]interface{}
+		if event.Data != "" {
+			if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+				slog.Warn("unable to parse event data for usage summary", "event_id", event.Id, "error", err)
+			}
+		}
+
+		switch event.Type {
+		case schema.ModelCreatedEvent:
+			if modelType, ok := data["type"].(string); ok {
+				modelTypes[modelType]++
+			}
+		case schema.TrainStatusEvent:
+			if status, ok := data["status"].(string); ok {
+				trainStatuses[status]++
+			}
+		case schema.DeployStatusEvent:
+			if status, ok := data["status"].(string); ok {
+				deployStatuses[status]++
+			}
+		}
+	}
+
+	var userCount, modelCount int64
+	if err := s.db.Model(&schema.User{}).Count(&userCount).Error; err != nil {
+		slog.Error("sql error counting users for usage summary", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.Model(&schema.Model{}).Count(&modelCount).Error; err != nil {
+		slog.Error("sql error counting models for usage summary", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	doc := reports.Document{
+		Title:       fmt.Sprintf("Usage Summary: %v", start.Format("January 2006")),
+		Subtitle:    fmt.Sprintf("Platform activity from %v to %v", start.Format("2006-01-02"), end.Format("2006-01-02")),
+		GeneratedAt: now,
+		Sections: []reports.Section{
+			{
+				Heading: "Overview",
+				Paragraphs: []string{
+					fmt.Sprintf("%d events were recorded for %d models during the month.", len(events), len(activeModels)),
+					fmt.Sprintf("The platform currently has %d users and %d models.", userCount, modelCount),
+				},
+			},
+			{Heading: "Models Created by Type", Table: countTable("Model Type", modelTypes)},
+			{Heading: "Training Jobs by Status", Table: countTable("Status", trainStatuses)},
+			{Heading: "Deployments by Status", Table: countTable("Status", deployStatuses)},
+			{Heading: "All Events", Table: countTable("Event", eventCounts)},
+		},
+	}
+
+	res, err := s.saveReport(user, UsageSummaryKind, doc, params.reportOptions)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+func (s *ReportService) List(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var sharedReports []schema.SharedReport
+	if err := s.db.Where("created_by = ?", user.Id).Order("created_at DESC").Find(&sharedReports).Error; err != nil {
+		slog.Error("sql error listing shared reports", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]SharedReportInfo, 0, len(sharedReports))
+	for _, report := range sharedReports {
+		infos = append(infos, convertSharedReport(report))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+func (s *ReportService) Delete(w http.ResponseWriter, r *http.Request) {
+	reportId, err := utils.URLParamUUID(r, "report_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var report schema.SharedReport
+	if err := s.db.First(&report, "id = ?", reportId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("report %v not found", reportId), http.StatusNotFound)
+			return
+		}
+		slog.Error("sql error retrieving shared report", "report_id", reportId, "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if report.CreatedBy != user.Id && !user.IsAdmin {
+		http.Error(w, "only the creator of a report or an admin can delete it", http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.Delete(&report).Error; err != nil {
+		slog.Error("sql error deleting shared report", "report_id", reportId, "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.storage.Delete(filepath.Dir(sharedReportPath(report))); err != nil {
+		slog.Error("error deleting report artifact", "report_id", reportId, "error", err)
+	}
+
+	utils.WriteSuccess(w)
+}
+
+func (s *ReportService) Shared(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	var report schema.SharedReport
+	err := s.db.First(&report, "token_hash = ?", hashSecret(token)).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.Error("sql error retrieving shared report", "error", err)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil || time.Now().After(report.ExpiresAt) {
+		http.Error(w, "report not found, the link may have expired", http.StatusNotFound)
+		return
+	}
+
+	file, err := s.storage.Read(sharedReportPath(report))
+	if err != nil {
+		slog.Error("error reading shared report", "report_id", report.Id, "error", err)
+		http.Error(w, "error reading report", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", reports.ContentType(report.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="report.%v"`, report.Format))
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Error("error sending shared report", "report_id", report.Id, "error", err)
+	}
+}
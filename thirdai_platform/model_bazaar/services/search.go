@@ -0,0 +1,406 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SearchModel  = "model"
+	SearchTeam   = "team"
+	SearchUser   = "user"
+	SearchUpload = "upload"
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchQueryLen  = 100
+)
+
+var searchTypes = []string{SearchModel, SearchTeam, SearchUser, SearchUpload}
+
+// Scores for how well a value matches the query. Matches on the name of a
+// result rank above matches on its attributes or files.
+const (
+	searchExactMatch    = 100
+	searchPrefixMatch   = 50
+	searchContainsMatch = 10
+
+	searchSecondaryFieldPenalty = 5
+)
+
+// SearchService searches the models, teams, users, and uploads the user can
+// access by name, for the search box in the frontend. The columns that are
+// searched have trigram indexes in postgres so that substring matches do not
+// need to scan the tables.
+type SearchService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *SearchService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+
+	r.Get("/", s.Search)
+
+	return r
+}
+
+type SearchResult struct {
+	Type string    `json:"type"`
+	Id   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// The field that matched the query, either "name", "username", "attribute:{key}",
+	// or "file".
+	Field string `json:"field"`
+	// The value of the field that matched the query.
+	Match string `json:"match"`
+	Score int    `json:"score"`
+
+	// The owner of model and upload results.
+	Username string `json:"username,omitempty"`
+}
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+type searchParams struct {
+	query string
+	types []string
+	limit int
+}
+
+func parseSearchParams(r *http.Request) (searchParams, error) {
+	params := searchParams{
+		query: strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q"))),
+		types: searchTypes,
+		limit: defaultSearchLimit,
+	}
+
+	if params.query == "" {
+		return params, fmt.Errorf("search query 'q' must be specified")
+	}
+	if len(params.query) > maxSearchQueryLen {
+		return params, fmt.Errorf("search query must be at most %d characters", maxSearchQueryLen)
+	}
+
+	if types := r.URL.Query().Get("types"); types != "" {
+		params.types = strings.Split(types, ",")
+		for _, t := range params.types {
+			if !slices.Contains(searchTypes, t) {
+				return params, fmt.Errorf("invalid search type '%v', must be one of %v", t, strings.Join(searchTypes, ", "))
+			}
+		}
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxSearchLimit {
+			return params, fmt.Errorf("limit must be an integer between 1 and %d", maxSearchLimit)
+		}
+		params.limit = value
+	}
+
+	return params, nil
+}
+
+// searchScore ranks how well the value matches the query, the query must already
+// be lowercase. Returns 0 if the value does not contain the query.
+func searchScore(value, query string) int {
+	value = strings.ToLower(value)
+	switch {
+	case value == query:
+		return searchExactMatch
+	case strings.HasPrefix(value, query):
+		return searchPrefixMatch
+	case strings.Contains(value, query):
+		return searchContainsMatch
+	default:
+		return 0
+	}
+}
+
+// likePattern returns the pattern for a case insensitive substring match of the
+// query with LIKE, the special characters in the query are escaped with '\'.
+func likePattern(query string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+	return "%" + escaped + "%"
+}
+
+// matchColumn adds a case insensitive substring match of the column, ordered so
+// that exact and prefix matches are returned first when the results are limited.
+func matchColumn(db *gorm.DB, column, query string) *gorm.DB {
+	lower := "LOWER(" + column + ")"
+	return db.
+		Where(lower+" LIKE ? ESCAPE '\\'", likePattern(query)).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "CASE WHEN " + lower + " = ? THEN 0 WHEN " + lower + " LIKE ? ESCAPE '\\' THEN 1 ELSE 2 END, LENGTH(" + column + ")",
+			Vars:               []interface{}{query, strings.TrimPrefix(likePattern(query), "%")},
+			WithoutParentheses: true,
+		}})
+}
+
+// accessibleModels restricts the query on the models table to the models the
+// user can read, which are the same models auth.GetModelPermissions allows the
+// user to read. Models of users in other organizations are never accessible.
+func accessibleModels(query *gorm.DB, db *gorm.DB, user schema.User) (*gorm.DB, error) {
+	if user.IsAdmin {
+		return query, nil
+	}
+	userTeams, err := schema.GetUserTeamIds(user.Id, db)
+	if err != nil {
+		return nil, CodedError(err, http.StatusInternalServerError)
+	}
+
+	orgUsers := schema.OrganizationUserIds(db, user.OrganizationId)
+
+	access := db.Where("models.user_id = ?", user.Id).
+		Or("models.access = ? AND models.user_id IN (?)", schema.Public, orgUsers).
+		Or("models.access = ? AND models.team_id IN ? AND models.user_id IN (?)", schema.Protected, userTeams, orgUsers)
+	if user.IsOrgAdmin && user.OrganizationId != nil {
+		access = access.Or("models.user_id IN (?)", orgUsers)
+	}
+
+	return query.Where(access), nil
+}
+
+type modelSearchRow struct {
+	Id       uuid.UUID
+	Name     string
+	Username string
+	Key      string
+	Value    string
+}
+
+func searchModels(db *gorm.DB, user schema.User, query string, limit int) ([]SearchResult, error) {
+	base := func() (*gorm.DB, error) {
+		return accessibleModels(db.Table("models").Joins("LEFT JOIN users ON users.id = models.user_id"), db, user)
+	}
+
+	byName, err := base()
+	if err != nil {
+		return nil, err
+	}
+	var names []modelSearchRow
+	result := matchColumn(byName.Select("models.id, models.name, users.username"), "models.name", query).Limit(limit).Scan(&names)
+	if result.Error != nil {
+		slog.Error("sql error searching model names", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	byAttribute, err := base()
+	if err != nil {
+		return nil, err
+	}
+	var attributes []modelSearchRow
+	result = matchColumn(
+		byAttribute.Select("models.id, models.name, users.username, model_attributes.key, model_attributes.value").
+			Joins("JOIN model_attributes ON model_attributes.model_id = models.id"),
+		"model_attributes.value", query,
+	).Limit(limit).Scan(&attributes)
+	if result.Error != nil {
+		slog.Error("sql error searching model attributes", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	results := make([]SearchResult, 0, len(names)+len(attributes))
+	for _, row := range names {
+		results = append(results, SearchResult{
+			Type: SearchModel, Id: row.Id, Name: row.Name, Field: "name", Match: row.Name,
+			Score: searchScore(row.Name, query), Username: row.Username,
+		})
+	}
+	for _, row := range attributes {
+		results = append(results, SearchResult{
+			Type: SearchModel, Id: row.Id, Name: row.Name, Field: "attribute:" + row.Key, Match: row.Value,
+			Score: searchScore(row.Value, query) - searchSecondaryFieldPenalty, Username: row.Username,
+		})
+	}
+	return results, nil
+}
+
+func searchTeams(db *gorm.DB, user schema.User, query string, limit int) ([]SearchResult, error) {
+	teams := db.Table("teams").Select("id, name")
+	if !user.IsAdmin {
+		userTeams, err := schema.GetUserTeamIds(user.Id, db)
+		if err != nil {
+			return nil, CodedError(err, http.StatusInternalServerError)
+		}
+		teams = teams.Where("id IN ?", userTeams)
+	}
+
+	var rows []schema.Team
+	if result := matchColumn(teams, "name", query).Limit(limit).Scan(&rows); result.Error != nil {
+		slog.Error("sql error searching teams", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, SearchResult{
+			Type: SearchTeam, Id: row.Id, Name: row.Name, Field: "name", Match: row.Name, Score: searchScore(row.Name, query),
+		})
+	}
+	return results, nil
+}
+
+// searchUsers searches the usernames of the users the user can list, which are
+// the members of their teams for users that are not admins.
+func searchUsers(db *gorm.DB, user schema.User, query string, limit int) ([]SearchResult, error) {
+	users := db.Table("users").Select("id, username")
+	if !user.IsAdmin {
+		userTeams, err := schema.GetUserTeamIds(user.Id, db)
+		if err != nil {
+			return nil, CodedError(err, http.StatusInternalServerError)
+		}
+		users = users.Where(
+			db.Where("id = ?", user.Id).
+				Or("id IN (?)", db.Table("user_teams").Select("user_id").Where("team_id IN ?", userTeams)),
+		)
+	}
+
+	var rows []struct {
+		Id       uuid.UUID
+		Username string
+	}
+	if result := matchColumn(users, "username", query).Limit(limit).Scan(&rows); result.Error != nil {
+		slog.Error("sql error searching users", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, SearchResult{
+			Type: SearchUser, Id: row.Id, Name: row.Username, Field: "username", Match: row.Username, Score: searchScore(row.Username, query),
+		})
+	}
+	return results, nil
+}
+
+// searchUploads searches the filenames in the user's uploads. Uploads store the
+// filenames as a single ';' separated column, so the matching file is found
+// after the upload is loaded.
+func searchUploads(db *gorm.DB, user schema.User, query string, limit int) ([]SearchResult, error) {
+	uploads := db.Table("uploads").
+		Select("uploads.id, uploads.files, users.username").
+		Joins("LEFT JOIN users ON users.id = uploads.user_id").
+		Where("LOWER(uploads.files) LIKE ? ESCAPE '\\'", likePattern(query)).
+		Order("uploads.upload_date DESC")
+	if !user.IsAdmin {
+		uploads = uploads.Where("uploads.user_id = ?", user.Id)
+	}
+
+	var rows []struct {
+		Id       uuid.UUID
+		Files    string
+		Username string
+	}
+	if result := uploads.Limit(limit).Scan(&rows); result.Error != nil {
+		slog.Error("sql error searching uploads", "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		// A match can span the separator between files, in which case the upload
+		// does not have a matching file.
+		best := SearchResult{}
+		for _, file := range strings.Split(row.Files, ";") {
+			if score := searchScore(file, query); score > best.Score {
+				best = SearchResult{
+					Type: SearchUpload, Id: row.Id, Name: file, Field: "file", Match: file,
+					Score: score - searchSecondaryFieldPenalty, Username: row.Username,
+				}
+			}
+		}
+		if best.Score > 0 {
+			results = append(results, best)
+		}
+	}
+	return results, nil
+}
+
+// rankSearchResults keeps the best match for each result and orders them by
+// score, with shorter names first since they are closer to the query.
+func rankSearchResults(results []SearchResult, limit int) []SearchResult {
+	type key struct {
+		kind string
+		id   uuid.UUID
+	}
+	best := make(map[key]int, len(results))
+	ranked := make([]SearchResult, 0, len(results))
+	for _, res := range results {
+		k := key{kind: res.Type, id: res.Id}
+		if i, ok := best[k]; ok {
+			if res.Score > ranked[i].Score {
+				ranked[i] = res
+			}
+			continue
+		}
+		best[k] = len(ranked)
+		ranked = append(ranked, res)
+	}
+
+	slices.SortStableFunc(ranked, func(a, b SearchResult) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) - len(b.Name)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+func (s *SearchService) Search(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	searches := map[string]func(*gorm.DB, schema.User, string, int) ([]SearchResult, error){
+		SearchModel:  searchModels,
+		SearchTeam:   searchTeams,
+		SearchUser:   searchUsers,
+		SearchUpload: searchUploads,
+	}
+
+	results := make([]SearchResult, 0)
+	for _, t := range params.types {
+		found, err := searches[t](s.db, user, params.query, params.limit)
+		if err != nil {
+			writeError(w, fmt.Sprintf("error searching %vs: %v", t, err), err)
+			return
+		}
+		results = append(results, found...)
+	}
+
+	utils.WriteJsonResponse(w, SearchResponse{Query: params.query, Results: rankSearchResults(results, params.limit)})
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errStatusChanged = errors.New("model status was changed by another request")
+
+func currentStatus(model *schema.Model, job string) string {
+	if job == "deploy" {
+		return model.DeployStatus
+	}
+	return model.TrainStatus
+}
+
+// updateStatus moves the train or deploy status of the model to the new status
+// if the transition is allowed, and records it in the status history and the
+// current job run. The update only applies if the status still matches the
+// status in the model, so that concurrent updates cannot bypass the transition
+// checks. The message is optional and is saved in the job run.
+func updateStatus(txn *gorm.DB, job string, model *schema.Model, status, message string) error {
+	from := currentStatus(model, job)
+	if err := schema.CheckValidTransition(job, from, status); err != nil {
+		return CodedError(err, http.StatusConflict)
+	}
+
+	result := txn.Model(&schema.Model{}).Where("id = ?", model.Id).Where(job+"_status = ?", from).Update(job+"_status", status)
+	if result.Error != nil {
+		slog.Error("sql error updating model status", "model_id", model.Id, "job", job, "status", status, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected == 0 {
+		return CodedError(errStatusChanged, http.StatusConflict)
+	}
+
+	if job == "deploy" {
+		model.DeployStatus = status
+	} else {
+		model.TrainStatus = status
+	}
+
+	if err := recordStatusTransition(txn, job, model.Id, from, status); err != nil {
+		return err
+	}
+
+	if from != status || message != "" {
+		if err := updateJobRun(txn, job, model.Id, status, message); err != nil {
+			return err
+		}
+	}
+
+	return recordStatusEvent(txn, job, model.Id, from, status, message)
+}
+
+func recordStatusTransition(txn *gorm.DB, job string, modelId uuid.UUID, from, to string) error {
+	if from == to {
+		return nil
+	}
+
+	transition := schema.StatusTransition{
+		ModelId:    modelId,
+		Job:        job,
+		FromStatus: from,
+		ToStatus:   to,
+		Timestamp:  time.Now().UTC(),
+	}
+	if result := txn.Create(&transition); result.Error != nil {
+		slog.Error("sql error recording status transition", "model_id", modelId, "job", job, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+type StatusTransitionInfo struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+
+	// Deprecated: the same as changed_at, kept for older clients.
+	Timestamp time.Time `json:"timestamp" deprecated:"true"`
+}
+
+func getStatusHistory(db *gorm.DB, modelId uuid.UUID, job string) ([]StatusTransitionInfo, error) {
+	var transitions []schema.StatusTransition
+	result := db.Where("model_id = ? AND job = ?", modelId, job).Order("id").Find(&transitions)
+	if result.Error != nil {
+		slog.Error("sql error listing status transitions", "model_id", modelId, "job", job, "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	history := make([]StatusTransitionInfo, 0, len(transitions))
+	for _, t := range transitions {
+		history = append(history, StatusTransitionInfo{From: t.FromStatus, To: t.ToStatus, ChangedAt: t.Timestamp.UTC(), Timestamp: t.Timestamp.UTC()})
+	}
+	return history, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrStorageQuotaExceeded = errors.New("model exceeds storage quota")
+
+// ParseStorageQuotas parses quotas in the format 'type:gb,type:gb', for example
+// 'ndb:50,nlp-text:2', into the quota in bytes for each model type.
+func ParseStorageQuotas(quotas string) (map[string]int64, error) {
+	parsed := map[string]int64{}
+	if quotas == "" {
+		return parsed, nil
+	}
+	for _, entry := range strings.Split(quotas, ",") {
+		modelType, gb, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry '%v', expected 'type:gb'", entry)
+		}
+		if err := schema.CheckValidModelType(modelType); err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseFloat(gb, 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid quota '%v' for %v models, must be a positive number of gb", gb, modelType)
+		}
+		parsed[modelType] = int64(size * 1024 * 1024 * 1024)
+	}
+	return parsed, nil
+}
+
+// modelStorageQuota returns the quota for the model, or 0 if it is unlimited.
+func modelStorageQuota(db *gorm.DB, quotas map[string]int64, modelId uuid.UUID, modelType string) (int64, error) {
+	var override schema.StorageQuotaOverride
+	result := db.Limit(1).Find(&override, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error retrieving storage quota override", "model_id", modelId, "error", result.Error)
+		return 0, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if result.RowsAffected > 0 {
+		return override.MaxBytes, nil
+	}
+	return quotas[modelType], nil
+}
+
+// checkStorageQuota checks that the artifacts of the model are within the
+// quota for its type, or the quota set for the model by an admin.
+func checkStorageQuota(db *gorm.DB, store storage.Storage, quotas map[string]int64, modelId uuid.UUID, modelType string) error {
+	quota, err := modelStorageQuota(db, quotas, modelId, modelType)
+	if err != nil || quota == 0 {
+		return err
+	}
+
+	size, err := storage.DirSize(store, filepath.Join(storage.ModelPath(modelId), "model"))
+	if err != nil {
+		slog.Error("error getting size of model artifacts", "model_id", modelId, "error", err)
+		return CodedError(errors.New("error getting size of model artifacts"), http.StatusInternalServerError)
+	}
+
+	if size > quota {
+		return CodedError(
+			fmt.Errorf("%w: model %v is %v, but the quota for %v models is %v, an admin can raise the quota for this model", ErrStorageQuotaExceeded, modelId, formatBytes(size), modelType, formatBytes(quota)),
+			http.StatusRequestEntityTooLarge,
+		)
+	}
+	return nil
+}
+
+type StorageQuotaService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+
+	quotas map[string]int64
+}
+
+func (s *StorageQuotaService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+	r.Post("/model/{model_id}", s.SetOverride)
+	r.Delete("/model/{model_id}", s.DeleteOverride)
+
+	return r
+}
+
+type StorageQuotaOverrideInfo struct {
+	ModelId   uuid.UUID `json:"model_id"`
+	MaxBytes  int64     `json:"max_bytes"`
+	Reason    string    `json:"reason"`
+	UpdatedBy uuid.UUID `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newStorageQuotaOverrideInfo(override schema.StorageQuotaOverride) StorageQuotaOverrideInfo {
+	return StorageQuotaOverrideInfo{
+		ModelId:   override.ModelId,
+		MaxBytes:  override.MaxBytes,
+		Reason:    override.Reason,
+		UpdatedBy: override.UpdatedBy,
+		UpdatedAt: override.UpdatedAt.UTC(),
+	}
+}
+
+type StorageQuotasResponse struct {
+	// The quota in bytes for each model type, types that are not listed are
+	// unlimited.
+	Quotas    map[string]int64           `json:"quotas"`
+	Overrides []StorageQuotaOverrideInfo `json:"overrides"`
+}
+
+func (s *StorageQuotaService) List(w http.ResponseWriter, r *http.Request) {
+	var overrides []schema.StorageQuotaOverride
+	if err := s.db.Order("updated_at").Find(&overrides).Error; err != nil {
+		slog.Error("sql error listing storage quota overrides", "error", err)
+		http.Error(w, fmt.Sprintf("error listing storage quotas: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	res := StorageQuotasResponse{Quotas: s.quotas, Overrides: make([]StorageQuotaOverrideInfo, 0, len(overrides))}
+	for _, override := range overrides {
+		res.Overrides = append(res.Overrides, newStorageQuotaOverrideInfo(override))
+	}
+
+	utils.WriteJsonResponse(w, res)
+}
+
+type setStorageQuotaRequest struct {
+	// MaxBytes is the quota for the model, 0 means it is unlimited.
+	MaxBytes int64  `json:"max_bytes" validate:"min=0"`
+	Reason   string `json:"reason" validate:"required,max=1000"`
+}
+
+// SetOverride sets the quota for a model, replacing the quota for its type. It
+// applies when an upload of the model is committed or its training completes
+// after the override is set.
+func (s *StorageQuotaService) SetOverride(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params setStorageQuotaRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid storage quota: %v", err), err)
+		return
+	}
+
+	override := schema.StorageQuotaOverride{
+		ModelId:   modelId,
+		MaxBytes:  params.MaxBytes,
+		Reason:    params.Reason,
+		UpdatedBy: user.Id,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if _, err := schema.GetModel(modelId, txn, false, false, false); err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if err := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&override).Error; err != nil {
+			slog.Error("sql error saving storage quota override", "model_id", modelId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error setting storage quota: %v", err), err)
+		return
+	}
+
+	slog.Info("set storage quota for model", "model_id", modelId, "max_bytes", params.MaxBytes, "user_id", user.Id)
+
+	utils.WriteJsonResponse(w, newStorageQuotaOverrideInfo(override))
+}
+
+// DeleteOverride removes the quota set for a model, so that the quota for its
+// type applies again.
+func (s *StorageQuotaService) DeleteOverride(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := s.db.Delete(&schema.StorageQuotaOverride{}, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error deleting storage quota override", "model_id", modelId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error deleting storage quota: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("model %v does not have a storage quota override", modelId), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
@@ -0,0 +1,482 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Models are checked for archiving at most once per interval by the storage
+// tiering sync. Models that are being rehydrated are checked at every run.
+const storageTieringCheckInterval = time.Hour
+
+// An instance of model bazaar claims a model while it moves the artifacts of
+// the model. Claims older than the timeout are from an instance that stopped
+// while moving the artifacts, and can be taken by another instance.
+const storageTierClaimTimeout = 6 * time.Hour
+
+// Restored copies of archived artifacts only need to be readable long enough
+// to be copied back to the platform storage.
+const rehydrateRestoreDays = 1
+
+type TieringPolicy struct {
+	// After is how long a model must go without being deployed or downloaded
+	// before its artifacts are moved to cold storage, 0 disables archiving.
+	After time.Duration
+	// Cold is the storage that artifacts are moved to, archived models can
+	// only be rehydrated if it is set.
+	Cold storage.Storage
+}
+
+func newModelStorageTier(modelId uuid.UUID) schema.ModelStorageTier {
+	return schema.ModelStorageTier{ModelId: modelId, Tier: schema.StorageTierHot}
+}
+
+func getModelStorageTier(db *gorm.DB, modelId uuid.UUID) (schema.ModelStorageTier, error) {
+	var tiers []schema.ModelStorageTier
+	if err := db.Limit(1).Find(&tiers, "model_id = ?", modelId).Error; err != nil {
+		slog.Error("sql error retrieving model storage tier", "model_id", modelId, "error", err)
+		return schema.ModelStorageTier{}, schema.ErrDbAccessFailed
+	}
+	if len(tiers) == 0 {
+		return newModelStorageTier(modelId), nil
+	}
+	return tiers[0], nil
+}
+
+// checkModelHot returns an error if the artifacts of the model are not in the
+// platform storage, in which case it must be rehydrated before it can be used.
+func checkModelHot(db *gorm.DB, modelId uuid.UUID, action string) error {
+	tier, err := getModelStorageTier(db, modelId)
+	if err != nil {
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	if tier.Tier != schema.StorageTierHot {
+		return CodedErrorWithErrorCode(
+			fmt.Errorf("model %v cannot be %v since its artifacts are in the %v storage tier, it must be rehydrated first", modelId, action, tier.Tier),
+			http.StatusConflict, utils.ErrorCodeModelArchived,
+		)
+	}
+	return nil
+}
+
+// touchModelStorageTier records that the model was used, models are only
+// archived if they are not used for the period of the tiering policy.
+func touchModelStorageTier(db *gorm.DB, modelId uuid.UUID) error {
+	now := time.Now().UTC()
+	tier := newModelStorageTier(modelId)
+	tier.LastUsedAt = &now
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "model_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_used_at"}),
+	}).Create(&tier).Error
+	if err != nil {
+		slog.Error("sql error updating model last used time", "model_id", modelId, "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *ModelService) Rehydrate(w http.ResponseWriter, r *http.Request) {
+	modelId, err := utils.URLParamUUID(r, "model_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.storageTiering.Cold == nil {
+		http.Error(w, "cold storage is not configured", http.StatusUnprocessableEntity)
+		return
+	}
+
+	tier, err := getModelStorageTier(s.db, modelId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving model storage tier: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch tier.Tier {
+	case schema.StorageTierHot, schema.StorageTierRehydrating:
+		utils.WriteSuccess(w)
+		return
+	case schema.StorageTierArchiving:
+		http.Error(w, fmt.Sprintf("model %v is being moved to cold storage, it can be rehydrated once it has been moved", modelId), http.StatusConflict)
+		return
+	}
+
+	result := s.db.Model(&schema.ModelStorageTier{}).
+		Where("model_id = ? AND tier = ?", modelId, schema.StorageTierCold).
+		Updates(map[string]interface{}{"tier": schema.StorageTierRehydrating, "message": ""})
+	if result.Error != nil {
+		slog.Error("sql error starting model rehydration", "model_id", modelId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error starting model rehydration: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("started model rehydration", "model_id", modelId)
+
+	utils.WriteSuccess(w)
+}
+
+// syncStorageTiers moves the artifacts of unused models to cold storage, and
+// restores the artifacts of models that are being rehydrated. This is called
+// from the storage tiering sync, since moving the artifacts of large models
+// would delay the status sync.
+func (s *ModelService) syncStorageTiers() {
+	if s.storageTiering.Cold == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	s.resetStaleArchives(now)
+	s.rehydrateModels(now)
+
+	if s.storageTiering.After == 0 {
+		return
+	}
+
+	if now.Sub(*s.tieringCheckedAt) >= storageTieringCheckInterval {
+		*s.tieringCheckedAt = now
+		s.archiveUnusedModels(now)
+	}
+}
+
+// resetStaleArchives returns models whose archiving claim has expired to the
+// hot tier. The artifacts are only deleted once the model is in the cold tier,
+// so they are still in the platform storage.
+func (s *ModelService) resetStaleArchives(now time.Time) {
+	result := s.db.Model(&schema.ModelStorageTier{}).
+		Where("tier = ? AND (claimed_at IS NULL OR claimed_at < ?)", schema.StorageTierArchiving, now.Add(-storageTierClaimTimeout)).
+		Updates(map[string]interface{}{"tier": schema.StorageTierHot, "claimed_at": nil, "message": "moving the artifacts to cold storage did not complete"})
+	if result.Error != nil {
+		slog.Error("storage tiering: sql error resetting stale archiving models", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		slog.Warn("storage tiering: reset models that did not finish archiving", "count", result.RowsAffected)
+	}
+}
+
+func (s *ModelService) archiveUnusedModels(now time.Time) {
+	// Models that are deployed or locked are in use, so they are not archived.
+	var models []schema.Model
+	err := s.db.
+		Joins("LEFT JOIN model_storage_tiers ON model_storage_tiers.model_id = models.id").
+		Where("models.train_status = ? AND models.deploy_status NOT IN ? AND models.locked = ?", schema.Complete, activeDeployStatuses, false).
+		Where("model_storage_tiers.tier IS NULL OR model_storage_tiers.tier = ?", schema.StorageTierHot).
+		Where("COALESCE(model_storage_tiers.last_used_at, models.published_date) < ?", now.Add(-s.storageTiering.After)).
+		Find(&models).Error
+	if err != nil {
+		slog.Error("storage tiering: sql error listing unused models to archive", "error", err)
+		return
+	}
+
+	for _, model := range models {
+		// Models that are used by active deployments or as the base model of
+		// train jobs are used through them.
+		usedBy, err := countDownstreamModels(model.Id, s.db, true)
+		if err != nil || usedBy > 0 {
+			continue
+		}
+		children, err := countTrainingChildModels(s.db, model.Id)
+		if err != nil || children > 0 {
+			continue
+		}
+		var activeBatchJobs int64
+		err = s.db.Model(&schema.BatchPredictJob{}).Where("model_id = ? AND status IN ?", model.Id, []string{schema.Starting, schema.InProgress}).Count(&activeBatchJobs).Error
+		if err != nil || activeBatchJobs > 0 {
+			continue
+		}
+
+		claimedAt := newStorageTierClaim()
+		if err := s.archiveModel(model, now.Add(-s.storageTiering.After), claimedAt); err != nil {
+			slog.Error("storage tiering: error archiving model", "model_id", model.Id, "error", err)
+			s.setStorageTierError(model.Id, schema.StorageTierArchiving, schema.StorageTierHot, claimedAt, err)
+		}
+	}
+}
+
+// newStorageTierClaim returns the time that identifies a claim on a model. It is
+// truncated to the precision of the database so that it can be compared with the
+// stored claim.
+func newStorageTierClaim() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// setStorageTierError returns the model to the previous tier if this instance
+// still has the claim on the model.
+func (s *ModelService) setStorageTierError(modelId uuid.UUID, from, to string, claimedAt time.Time, cause error) {
+	result := s.db.Model(&schema.ModelStorageTier{}).
+		Where("model_id = ? AND tier = ? AND claimed_at = ?", modelId, from, claimedAt).
+		Updates(map[string]interface{}{"tier": to, "claimed_at": nil, "message": cause.Error()})
+	if result.Error != nil {
+		slog.Error("storage tiering: sql error recording storage tier error", "model_id", modelId, "error", result.Error)
+	}
+}
+
+// archiveModel moves the artifacts of the model to cold storage if it has not
+// been used since the cutoff.
+func (s *ModelService) archiveModel(model schema.Model, cutoff, claimedAt time.Time) error {
+	modelDir := storage.ModelPath(model.Id)
+
+	exists, err := s.storage.Exists(modelDir)
+	if err != nil {
+		return fmt.Errorf("error checking model artifacts: %w", err)
+	}
+	if !exists {
+		// Workflows such as enterprise search have no artifacts.
+		return nil
+	}
+
+	claimed := false
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		tier := newModelStorageTier(model.Id)
+		if err := txn.Clauses(clause.OnConflict{DoNothing: true}).Create(&tier).Error; err != nil {
+			slog.Error("sql error creating model storage tier", "model_id", model.Id, "error", err)
+			return schema.ErrDbAccessFailed
+		}
+
+		// The deploy status is checked again in case the model was deployed
+		// after it was listed.
+		var deployed int64
+		err := txn.Model(&schema.Model{}).Where("id = ? AND deploy_status IN ?", model.Id, activeDeployStatuses).Count(&deployed).Error
+		if err != nil {
+			slog.Error("sql error checking model deploy status", "model_id", model.Id, "error", err)
+			return schema.ErrDbAccessFailed
+		}
+		if deployed > 0 {
+			return nil
+		}
+
+		// The last used time is checked again so that a model that is used,
+		// for instance by a batch prediction job, after it was listed is not
+		// archived.
+		result := txn.Model(&schema.ModelStorageTier{}).
+			Where("model_id = ? AND tier = ? AND (last_used_at IS NULL OR last_used_at < ?)", model.Id, schema.StorageTierHot, cutoff).
+			Updates(map[string]interface{}{"tier": schema.StorageTierArchiving, "claimed_at": claimedAt})
+		if result.Error != nil {
+			slog.Error("sql error claiming model to archive", "model_id", model.Id, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		claimed = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil || !claimed {
+		return err
+	}
+
+	slog.Info("storage tiering: archiving model", "model_id", model.Id)
+
+	// Download archives can be recreated from the artifacts, so they are not
+	// moved to cold storage.
+	downloadPath := filepath.Join(modelDir, "model")
+	for _, path := range []string{downloadArchiveInfoPath(model.Id), downloadPath + ".zip", downloadPath + storage.ZstdArchiveExt} {
+		if err := s.storage.Delete(path); err != nil {
+			return fmt.Errorf("error deleting model download archive: %w", err)
+		}
+	}
+
+	if err := s.storage.Zip(modelDir); err != nil {
+		return fmt.Errorf("error creating archive of model artifacts: %w", err)
+	}
+	archivePath := modelDir + ".zip"
+	defer func() {
+		if err := s.storage.Delete(archivePath); err != nil {
+			slog.Error("storage tiering: error deleting model archive", "model_id", model.Id, "error", err)
+		}
+	}()
+
+	size, err := s.storage.Size(archivePath)
+	if err != nil {
+		return fmt.Errorf("error getting size of model archive: %w", err)
+	}
+	if err := copyBetweenStorages(s.storage, archivePath, s.storageTiering.Cold, storage.ColdModelArchivePath(model.Id)); err != nil {
+		return fmt.Errorf("error moving model archive to cold storage: %w", err)
+	}
+
+	// The tier is updated before the artifacts are deleted, so that the model
+	// is not used once the artifacts start being deleted.
+	archived := false
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		archivedAt := time.Now().UTC()
+		result := txn.Model(&schema.ModelStorageTier{}).
+			Where("model_id = ? AND tier = ? AND claimed_at = ?", model.Id, schema.StorageTierArchiving, claimedAt).
+			Updates(map[string]interface{}{"tier": schema.StorageTierCold, "claimed_at": nil, "archived_at": archivedAt, "archive_bytes": size, "message": ""})
+		if result.Error != nil {
+			slog.Error("sql error updating model storage tier", "model_id", model.Id, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		archived = true
+		return recordModelEvent(txn, schema.ModelArchivedEvent, model, map[string]interface{}{"archive_bytes": size})
+	})
+	if err != nil {
+		return err
+	}
+	if !archived {
+		// The claim expired and the model was reset to the hot tier, so the
+		// artifacts may be in use again.
+		slog.Warn("storage tiering: claim on model expired while archiving, keeping artifacts", "model_id", model.Id)
+		return nil
+	}
+
+	if err := s.storage.Delete(modelDir); err != nil {
+		slog.Error("storage tiering: error deleting artifacts of archived model", "model_id", model.Id, "error", err)
+	}
+
+	slog.Info("storage tiering: archived model", "model_id", model.Id, "archive_bytes", size)
+
+	return nil
+}
+
+func (s *ModelService) rehydrateModels(now time.Time) {
+	staleClaim := now.Add(-storageTierClaimTimeout)
+
+	var tiers []schema.ModelStorageTier
+	err := s.db.Preload("Model").
+		Where("tier = ? AND (claimed_at IS NULL OR claimed_at < ?)", schema.StorageTierRehydrating, staleClaim).
+		Find(&tiers).Error
+	if err != nil {
+		slog.Error("storage tiering: sql error listing models to rehydrate", "error", err)
+		return
+	}
+
+	for _, tier := range tiers {
+		if tier.Model == nil {
+			continue
+		}
+
+		// The model is claimed so that other instances of model bazaar do not
+		// rehydrate it at the same time.
+		claimedAt := newStorageTierClaim()
+		result := s.db.Model(&schema.ModelStorageTier{}).
+			Where("model_id = ? AND tier = ? AND (claimed_at IS NULL OR claimed_at < ?)", tier.ModelId, schema.StorageTierRehydrating, staleClaim).
+			Update("claimed_at", claimedAt)
+		if result.Error != nil {
+			slog.Error("storage tiering: sql error claiming model to rehydrate", "model_id", tier.ModelId, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.rehydrateModel(*tier.Model, claimedAt); err != nil {
+			slog.Error("storage tiering: error rehydrating model", "model_id", tier.ModelId, "error", err)
+			s.setStorageTierError(tier.ModelId, schema.StorageTierRehydrating, schema.StorageTierCold, claimedAt, err)
+		}
+	}
+}
+
+// releaseStorageTierClaim lets the model be claimed again at the next run of
+// the storage tiering sync.
+func (s *ModelService) releaseStorageTierClaim(modelId uuid.UUID, claimedAt time.Time) {
+	result := s.db.Model(&schema.ModelStorageTier{}).Where("model_id = ? AND claimed_at = ?", modelId, claimedAt).Update("claimed_at", nil)
+	if result.Error != nil {
+		slog.Error("storage tiering: sql error releasing model claim", "model_id", modelId, "error", result.Error)
+	}
+}
+
+func (s *ModelService) rehydrateModel(model schema.Model, claimedAt time.Time) error {
+	coldPath := storage.ColdModelArchivePath(model.Id)
+
+	// Archives in storages such as S3 Glacier must be restored before they can
+	// be read, which can take several hours.
+	if restorer, ok := s.storageTiering.Cold.(storage.Restorer); ok {
+		ready, err := restorer.Restore(coldPath, rehydrateRestoreDays)
+		if err != nil {
+			return fmt.Errorf("error restoring model archive: %w", err)
+		}
+		if !ready {
+			s.releaseStorageTierClaim(model.Id, claimedAt)
+			return nil
+		}
+	}
+
+	archivePath := storage.ModelPath(model.Id) + ".zip"
+	if err := copyBetweenStorages(s.storageTiering.Cold, coldPath, s.storage, archivePath); err != nil {
+		return fmt.Errorf("error copying model archive from cold storage: %w", err)
+	}
+	defer func() {
+		if err := s.storage.Delete(archivePath); err != nil {
+			slog.Error("storage tiering: error deleting model archive", "model_id", model.Id, "error", err)
+		}
+	}()
+
+	if err := s.storage.Unzip(archivePath); err != nil {
+		return fmt.Errorf("error extracting model archive: %w", err)
+	}
+
+	rehydrated := false
+	err := s.db.Transaction(func(txn *gorm.DB) error {
+		now := time.Now().UTC()
+		result := txn.Model(&schema.ModelStorageTier{}).
+			Where("model_id = ? AND tier = ? AND claimed_at = ?", model.Id, schema.StorageTierRehydrating, claimedAt).
+			Updates(map[string]interface{}{"tier": schema.StorageTierHot, "claimed_at": nil, "last_used_at": now, "archived_at": nil, "archive_bytes": 0, "message": ""})
+		if result.Error != nil {
+			slog.Error("sql error updating model storage tier", "model_id", model.Id, "error", result.Error)
+			return schema.ErrDbAccessFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		rehydrated = true
+		return recordModelEvent(txn, schema.ModelRehydratedEvent, model, nil)
+	})
+	if err != nil || !rehydrated {
+		return err
+	}
+
+	if err := s.storageTiering.Cold.Delete(coldPath); err != nil {
+		slog.Error("storage tiering: error deleting archive of rehydrated model from cold storage", "model_id", model.Id, "error", err)
+	}
+
+	slog.Info("storage tiering: rehydrated model", "model_id", model.Id)
+
+	return nil
+}
+
+func copyBetweenStorages(from storage.Storage, fromPath string, to storage.Storage, toPath string) error {
+	file, err := from.Read(fromPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return to.Write(toPath, file)
+}
+
+// deleteColdModelArchive deletes the archive of the model from cold storage
+// when the model is deleted.
+func (s *ModelService) deleteColdModelArchive(txn *gorm.DB, modelId uuid.UUID) error {
+	tier, err := getModelStorageTier(txn, modelId)
+	if err != nil {
+		return CodedError(err, http.StatusInternalServerError)
+	}
+
+	if tier.Tier != schema.StorageTierHot && s.storageTiering.Cold != nil {
+		if err := s.storageTiering.Cold.Delete(storage.ColdModelArchivePath(modelId)); err != nil {
+			slog.Error("error deleting model archive from cold storage", "model_id", modelId, "error", err)
+			return CodedError(errors.New("error deleting model data"), http.StatusInternalServerError)
+		}
+	}
+
+	result := txn.Delete(&schema.ModelStorageTier{}, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error deleting model storage tier", "model_id", modelId, "error", result.Error)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	SweepGrid   = "grid"
+	SweepRandom = "random"
+
+	maxSweepTrials = 100
+)
+
+type SweepRequest struct {
+	SweepName string `json:"sweep_name" validate:"required"`
+	ModelType string `json:"model_type" validate:"required,oneof=ndb nlp-token nlp-text"`
+
+	// The train request for the model type, the parameters are applied to this
+	// request to create the request for each trial.
+	TrainRequest map[string]interface{} `json:"train_request"`
+
+	// Maps parameters such as 'train_options.learning_rate' or 'model_options.retriever'
+	// to the values to try for that parameter.
+	Parameters map[string][]interface{} `json:"parameters" validate:"required"`
+
+	Strategy  string `json:"strategy" validate:"oneof=grid random"`
+	NumTrials int    `json:"num_trials"`
+	Seed      *int64 `json:"seed"`
+
+	MaxConcurrent int `json:"max_concurrent"`
+
+	// The holdout evaluation metric used to select the best model.
+	Metric string `json:"metric"`
+}
+
+// The holdout evaluation metrics reported by the train jobs of each model type.
+var sweepMetrics = map[string][]string{
+	schema.NlpTokenModel: {"macro_precision", "macro_recall", "macro_fmeasure"},
+	schema.NlpTextModel:  {"precision@1", "recall@1"},
+}
+
+func (opts *SweepRequest) validate() error {
+	errs := validation.Struct(opts)
+
+	if opts.TrainRequest == nil {
+		opts.TrainRequest = map[string]interface{}{}
+	}
+
+	for _, param := range slices.Sorted(maps.Keys(opts.Parameters)) {
+		if !strings.HasPrefix(param, "train_options.") && !strings.HasPrefix(param, "model_options.") {
+			errs.Add("parameters."+param, "invalid parameter '%v', parameters must be in train_options or model_options", param)
+		}
+		if len(opts.Parameters[param]) == 0 {
+			errs.Add("parameters."+param, "no values specified for parameter '%v'", param)
+		}
+	}
+
+	if opts.Strategy == "" {
+		opts.Strategy = SweepGrid
+	}
+	switch opts.Strategy {
+	case SweepGrid:
+		if n := gridSize(opts.Parameters); n > maxSweepTrials {
+			errs.Add("parameters", "grid has %d trials, sweeps can have at most %d trials", n, maxSweepTrials)
+		}
+	case SweepRandom:
+		if opts.NumTrials <= 0 || opts.NumTrials > maxSweepTrials {
+			errs.Add("num_trials", "num_trials must be between 1 and %d for random sweeps", maxSweepTrials)
+		}
+	}
+
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = 1
+	}
+
+	if opts.Metric != "" && !slices.Contains(sweepMetrics[opts.ModelType], opts.Metric) {
+		if metrics, ok := sweepMetrics[opts.ModelType]; ok {
+			errs.Add("metric", "invalid metric '%v' for %v models, must be one of: %v", opts.Metric, opts.ModelType, strings.Join(metrics, ", "))
+		} else {
+			errs.Add("metric", "%v models do not report holdout evaluation metrics, metric cannot be specified", opts.ModelType)
+		}
+	}
+
+	return errs.Err()
+}
+
+func gridSize(params map[string][]interface{}) int {
+	size := 1
+	for _, values := range params {
+		size *= len(values)
+		if size > maxSweepTrials {
+			return size
+		}
+	}
+	return size
+}
+
+// sweepTrialParams returns the parameter values for each trial of the sweep.
+func sweepTrialParams(opts SweepRequest) []map[string]interface{} {
+	// Parameter names are sorted so that trials are generated deterministically.
+	names := make([]string, 0, len(opts.Parameters))
+	for name := range opts.Parameters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if opts.Strategy == SweepRandom {
+		seed := time.Now().UnixNano()
+		if opts.Seed != nil {
+			seed = *opts.Seed
+		}
+		rng := rand.New(rand.NewSource(seed))
+
+		trials := make([]map[string]interface{}, 0, opts.NumTrials)
+		for i := 0; i < opts.NumTrials; i++ {
+			trial := map[string]interface{}{}
+			for _, name := range names {
+				values := opts.Parameters[name]
+				trial[name] = values[rng.Intn(len(values))]
+			}
+			trials = append(trials, trial)
+		}
+		return trials
+	}
+
+	trials := []map[string]interface{}{{}}
+	for _, name := range names {
+		next := make([]map[string]interface{}, 0, len(trials)*len(opts.Parameters[name]))
+		for _, trial := range trials {
+			for _, value := range opts.Parameters[name] {
+				newTrial := maps.Clone(trial)
+				newTrial[name] = value
+				next = append(next, newTrial)
+			}
+		}
+		trials = next
+	}
+	return trials
+}
+
+// applySweepParams returns the train request for a trial, with the parameter
+// values set in the base request.
+func applySweepParams(base map[string]interface{}, modelName string, params map[string]interface{}) ([]byte, error) {
+	// The base request is copied through json so that nested objects are not shared.
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid train request: %w", err)
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("invalid train request: %w", err)
+	}
+
+	for param, value := range params {
+		path := strings.Split(param, ".")
+		obj := request
+		for _, key := range path[:len(path)-1] {
+			child, ok := obj[key].(map[string]interface{})
+			if !ok {
+				if obj[key] != nil {
+					return nil, fmt.Errorf("cannot set parameter '%v' since '%v' is not an object in the train request", param, key)
+				}
+				child = map[string]interface{}{}
+				obj[key] = child
+			}
+			obj = child
+		}
+		obj[path[len(path)-1]] = value
+	}
+	request["model_name"] = modelName
+
+	return json.Marshal(request)
+}
+
+func decodeStrict(data []byte, dest interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dest)
+}
+
+// sweepTrialArgs parses and validates the train request for a trial.
+func (s *TrainService) sweepTrialArgs(userId uuid.UUID, modelType string, request []byte) (basicTrainArgs, error) {
+	switch modelType {
+	case schema.NdbModel:
+		var options NdbTrainRequest
+		if err := decodeStrict(request, &options); err != nil {
+			return basicTrainArgs{}, CodedError(fmt.Errorf("invalid train request: %w", err), http.StatusUnprocessableEntity)
+		}
+		if err := options.validate(); err != nil {
+			return basicTrainArgs{}, CodedError(err, http.StatusUnprocessableEntity)
+		}
+		return s.ndbTrainArgs(userId, options)
+
+	case schema.NlpTokenModel:
+		var options NlpTokenTrainRequest
+		if err := decodeStrict(request, &options); err != nil {
+			return basicTrainArgs{}, CodedError(fmt.Errorf("invalid train request: %w", err), http.StatusUnprocessableEntity)
+		}
+		if err := options.validate(); err != nil {
+			return basicTrainArgs{}, CodedError(err, http.StatusUnprocessableEntity)
+		}
+		return s.nlpTokenTrainArgs(userId, options)
+
+	case schema.NlpTextModel:
+		var options NlpTextTrainRequest
+		if err := decodeStrict(request, &options); err != nil {
+			return basicTrainArgs{}, CodedError(fmt.Errorf("invalid train request: %w", err), http.StatusUnprocessableEntity)
+		}
+		if err := options.validate(); err != nil {
+			return basicTrainArgs{}, CodedError(err, http.StatusUnprocessableEntity)
+		}
+		return s.nlpTextTrainArgs(userId, options)
+
+	default:
+		return basicTrainArgs{}, CodedError(fmt.Errorf("sweeps are not supported for model type %v", modelType), http.StatusUnprocessableEntity)
+	}
+}
+
+type sweepResponse struct {
+	SweepId uuid.UUID `json:"sweep_id"`
+}
+
+func (s *TrainService) Sweep(w http.ResponseWriter, r *http.Request) {
+	var params SweepRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start sweep, found the following errors: %v", err), err)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sweep := schema.Sweep{
+		Id:            uuid.New(),
+		Name:          params.SweepName,
+		ModelType:     params.ModelType,
+		Metric:        params.Metric,
+		MaxConcurrent: params.MaxConcurrent,
+		Status:        schema.InProgress,
+		UserId:        user.Id,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	// All trials are validated before the sweep is created so that invalid
+	// parameter values are reported immediately instead of as failed trials.
+	for i, trialParams := range sweepTrialParams(params) {
+		modelName := fmt.Sprintf("%v-%d", params.SweepName, i)
+
+		request, err := applySweepParams(params.TrainRequest, modelName, trialParams)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to create trial %d: %v", i, err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if _, err := s.sweepTrialArgs(user.Id, params.ModelType, request); err != nil {
+			writeError(w, fmt.Sprintf("invalid train request for trial %d: %v", i, err), err)
+			return
+		}
+
+		if err := checkForDuplicateModel(s.db, modelName, user.Id); err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+
+		paramsJson, err := json.Marshal(trialParams)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to serialize parameters for trial %d: %v", i, err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		sweep.Trials = append(sweep.Trials, schema.SweepTrial{
+			SweepId: sweep.Id, Number: i, Params: string(paramsJson), Request: string(request),
+		})
+	}
+
+	if err := s.db.Create(&sweep).Error; err != nil {
+		slog.Error("sql error creating sweep", "error", err)
+		http.Error(w, fmt.Sprintf("error creating sweep: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("created sweep", "sweep_id", sweep.Id, "model_type", sweep.ModelType, "n_trials", len(sweep.Trials))
+
+	if err := s.advanceSweep(sweep.Id); err != nil {
+		slog.Error("error starting sweep trials", "sweep_id", sweep.Id, "error", err)
+	}
+
+	utils.WriteJsonResponse(w, sweepResponse{SweepId: sweep.Id})
+}
+
+// advanceSweep records the metrics of trials that have finished training and
+// starts pending trials while the number of active trials is below the limit
+// for the sweep. Trials that cannot be started because the license cpu limit
+// is reached remain pending. Once all trials are finished the best model is
+// selected using the metric for the sweep.
+func (s *TrainService) advanceSweep(sweepId uuid.UUID) error {
+	s.sweepLock.Lock()
+	defer s.sweepLock.Unlock()
+
+	var sweep schema.Sweep
+	if err := s.db.Preload("Trials", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).First(&sweep, "id = ?", sweepId).Error; err != nil {
+		slog.Error("sql error retrieving sweep", "sweep_id", sweepId, "error", err)
+		return schema.ErrDbAccessFailed
+	}
+
+	if sweep.Status != schema.InProgress {
+		return nil
+	}
+
+	trainStatuses, err := sweepTrainStatuses(s.db, sweep.Trials)
+	if err != nil {
+		return err
+	}
+
+	var user schema.User
+	if err := s.db.First(&user, "id = ?", sweep.UserId).Error; err != nil {
+		slog.Error("sql error retrieving sweep user", "sweep_id", sweepId, "error", err)
+		return schema.ErrDbAccessFailed
+	}
+
+	active, pending := 0, 0
+	for i := range sweep.Trials {
+		trial := &sweep.Trials[i]
+
+		if trial.ModelId == nil {
+			if trial.Error == "" {
+				pending++
+			}
+			continue
+		}
+
+		switch trainStatuses[*trial.ModelId] {
+		case schema.NotStarted, schema.Queued, schema.Starting, schema.InProgress:
+			active++
+		case schema.Complete:
+			if trial.Metrics == "" {
+				trial.Metrics = s.trialMetrics(*trial.ModelId)
+				if err := updateSweepTrial(s.db, *trial, map[string]interface{}{"metrics": trial.Metrics}); err != nil {
+					slog.Error("sql error saving sweep trial metrics", "sweep_id", sweepId, "trial", trial.Number, "error", err)
+					return schema.ErrDbAccessFailed
+				}
+			}
+		}
+	}
+
+	for i := range sweep.Trials {
+		trial := &sweep.Trials[i]
+		if trial.ModelId != nil || trial.Error != "" || active >= sweep.MaxConcurrent {
+			continue
+		}
+
+		args, err := s.sweepTrialArgs(user.Id, sweep.ModelType, []byte(trial.Request))
+		var modelId uuid.UUID
+		if err == nil {
+			modelId, err = s.startTraining(user, args)
+		}
+
+		if errors.Is(err, licensing.ErrCpuLimitExceeded) {
+			slog.Info("license cpu limit reached, sweep trials will be started later", "sweep_id", sweepId)
+			break
+		}
+		if errors.Is(err, ErrUserJobLimitReached) {
+			slog.Info("user train job limit reached, sweep trials will be started later", "sweep_id", sweepId)
+			break
+		}
+
+		pending--
+		updates := map[string]interface{}{}
+		if err != nil {
+			slog.Error("error starting sweep trial", "sweep_id", sweepId, "trial", trial.Number, "error", err)
+			trial.Error = err.Error()
+			updates["error"] = trial.Error
+		} else {
+			trial.ModelId = &modelId
+			updates["model_id"] = modelId
+			active++
+		}
+
+		if err := updateSweepTrial(s.db, *trial, updates); err != nil {
+			slog.Error("sql error updating sweep trial", "sweep_id", sweepId, "trial", trial.Number, "error", err)
+			return schema.ErrDbAccessFailed
+		}
+	}
+
+	if active > 0 || pending > 0 {
+		return nil
+	}
+
+	bestModelId := bestSweepModel(sweep)
+	result := s.db.Model(&sweep).Updates(map[string]interface{}{"status": schema.Complete, "best_model_id": bestModelId})
+	if result.Error != nil {
+		slog.Error("sql error completing sweep", "sweep_id", sweepId, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+
+	slog.Info("sweep complete", "sweep_id", sweepId, "best_model_id", bestModelId)
+
+	return nil
+}
+
+// The trial is specified explicitly since gorm ignores zero valued primary keys,
+// which would match every trial in the sweep for trial 0.
+func updateSweepTrial(db *gorm.DB, trial schema.SweepTrial, updates map[string]interface{}) error {
+	return db.Model(&schema.SweepTrial{}).Where("sweep_id = ? AND number = ?", trial.SweepId, trial.Number).Updates(updates).Error
+}
+
+func sweepTrainStatuses(db *gorm.DB, trials []schema.SweepTrial) (map[uuid.UUID]string, error) {
+	modelIds := []uuid.UUID{}
+	for _, trial := range trials {
+		if trial.ModelId != nil {
+			modelIds = append(modelIds, *trial.ModelId)
+		}
+	}
+
+	var models []schema.Model
+	if err := db.Select("id", "train_status").Find(&models, "id IN ?", modelIds).Error; err != nil {
+		slog.Error("sql error retrieving sweep models", "error", err)
+		return nil, schema.ErrDbAccessFailed
+	}
+
+	statuses := make(map[uuid.UUID]string, len(models))
+	for _, model := range models {
+		statuses[model.Id] = model.TrainStatus
+	}
+
+	// Models that were deleted are treated as failed.
+	for _, id := range modelIds {
+		if _, ok := statuses[id]; !ok {
+			statuses[id] = schema.Failed
+		}
+	}
+
+	return statuses, nil
+}
+
+// trialMetrics returns the holdout evaluation metrics from the train report of
+// the model as json. If there are no metrics in the report an empty object is
+// returned so that the report is not read again.
+func (s *TrainService) trialMetrics(modelId uuid.UUID) string {
+	report, err := readLatestTrainReport(s.storage, modelId)
+	if err != nil {
+		slog.Warn("unable to read train report for sweep trial", "model_id", modelId, "error", err)
+		return "{}"
+	}
+
+	metrics := map[string]float64{}
+	if reportObj, ok := report.(map[string]interface{}); ok {
+		if evaluation, ok := reportObj["holdout_evaluation"].(map[string]interface{}); ok {
+			if values, ok := evaluation["metrics"].(map[string]interface{}); ok {
+				for name, value := range values {
+					if v, ok := value.(float64); ok {
+						metrics[name] = v
+					}
+				}
+			}
+		}
+	}
+
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func parseTrialMetrics(trial schema.SweepTrial) map[string]float64 {
+	metrics := map[string]float64{}
+	if trial.Metrics != "" {
+		if err := json.Unmarshal([]byte(trial.Metrics), &metrics); err != nil {
+			slog.Error("error parsing sweep trial metrics", "sweep_id", trial.SweepId, "trial", trial.Number, "error", err)
+		}
+	}
+	return metrics
+}
+
+// bestSweepModel returns the model with the highest value of the sweep metric,
+// or nil if no trials reported the metric.
+func bestSweepModel(sweep schema.Sweep) *uuid.UUID {
+	if sweep.Metric == "" {
+		return nil
+	}
+
+	var best *uuid.UUID
+	bestValue := 0.0
+	for _, trial := range sweep.Trials {
+		if trial.ModelId == nil {
+			continue
+		}
+		value, ok := parseTrialMetrics(trial)[sweep.Metric]
+		if ok && (best == nil || value > bestValue) {
+			best, bestValue = trial.ModelId, value
+		}
+	}
+	return best
+}
+
+// advanceTrialSweep advances the sweep of the model if it is a sweep trial, this
+// is called when the status of a train job is updated so that the next trials
+// are started and the sweep is completed without waiting for the status sync.
+func (s *TrainService) advanceTrialSweep(modelId uuid.UUID) {
+	var trial schema.SweepTrial
+	result := s.db.Limit(1).Find(&trial, "model_id = ?", modelId)
+	if result.Error != nil {
+		slog.Error("sql error finding sweep trial", "model_id", modelId, "error", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	if err := s.advanceSweep(trial.SweepId); err != nil {
+		slog.Error("error advancing sweep", "sweep_id", trial.SweepId, "error", err)
+	}
+}
+
+// syncSweeps advances all sweeps that are in progress, this is called from the
+// status sync so that pending trials are started as other trials finish.
+func (s *TrainService) syncSweeps() {
+	var sweeps []schema.Sweep
+	if err := s.db.Select("id").Find(&sweeps, "status = ?", schema.InProgress).Error; err != nil {
+		slog.Error("status sync: sql error querying active sweeps", "error", err)
+		return
+	}
+
+	for _, sweep := range sweeps {
+		if err := s.advanceSweep(sweep.Id); err != nil {
+			slog.Error("status sync: error advancing sweep", "sweep_id", sweep.Id, "error", err)
+		}
+	}
+}
+
+type sweepTrialInfo struct {
+	Index       int                    `json:"index"`
+	Params      map[string]interface{} `json:"params"`
+	ModelId     *uuid.UUID             `json:"model_id"`
+	TrainStatus string                 `json:"train_status"`
+	Error       string                 `json:"error,omitempty"`
+	Metrics     map[string]float64     `json:"metrics"`
+	IsBest      bool                   `json:"is_best"`
+}
+
+type sweepInfo struct {
+	SweepId       uuid.UUID        `json:"sweep_id"`
+	SweepName     string           `json:"sweep_name"`
+	ModelType     string           `json:"model_type"`
+	Metric        string           `json:"metric"`
+	MaxConcurrent int              `json:"max_concurrent"`
+	Status        string           `json:"status"`
+	BestModelId   *uuid.UUID       `json:"best_model_id"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Trials        []sweepTrialInfo `json:"trials"`
+}
+
+func (s *TrainService) SweepInfo(w http.ResponseWriter, r *http.Request) {
+	sweepId, err := utils.URLParamUUID(r, "sweep_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var sweep schema.Sweep
+	result := s.db.Preload("Trials", func(db *gorm.DB) *gorm.DB { return db.Order("number") }).First(&sweep, "id = ?", sweepId)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			http.Error(w, fmt.Sprintf("error retrieving sweep: sweep %v does not exist", sweepId), http.StatusNotFound)
+			return
+		}
+		slog.Error("sql error retrieving sweep", "sweep_id", sweepId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error retrieving sweep: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	if sweep.UserId != user.Id && !user.IsAdmin {
+		http.Error(w, fmt.Sprintf("user %v does not have permission to access sweep %v", user.Id, sweepId), http.StatusForbidden)
+		return
+	}
+
+	trainStatuses, err := sweepTrainStatuses(s.db, sweep.Trials)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving sweep models: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	info := sweepInfo{
+		SweepId:       sweep.Id,
+		SweepName:     sweep.Name,
+		ModelType:     sweep.ModelType,
+		Metric:        sweep.Metric,
+		MaxConcurrent: sweep.MaxConcurrent,
+		Status:        sweep.Status,
+		BestModelId:   sweep.BestModelId,
+		CreatedAt:     sweep.CreatedAt.UTC(),
+		Trials:        make([]sweepTrialInfo, 0, len(sweep.Trials)),
+	}
+
+	for _, trial := range sweep.Trials {
+		var params map[string]interface{}
+		if err := json.Unmarshal([]byte(trial.Params), &params); err != nil {
+			slog.Error("error parsing sweep trial params", "sweep_id", sweepId, "trial", trial.Number, "error", err)
+		}
+
+		status := "pending"
+		if trial.Error != "" {
+			status = schema.Failed
+		} else if trial.ModelId != nil {
+			status = trainStatuses[*trial.ModelId]
+		}
+
+		info.Trials = append(info.Trials, sweepTrialInfo{
+			Index:       trial.Number,
+			Params:      params,
+			ModelId:     trial.ModelId,
+			TrainStatus: status,
+			Error:       trial.Error,
+			Metrics:     parseTrialMetrics(trial),
+			IsBest:      sweep.BestModelId != nil && trial.ModelId != nil && *sweep.BestModelId == *trial.ModelId,
+		})
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -23,7 +24,8 @@ func (s *TeamService) Routes() chi.Router {
 
 	r.Use(s.userAuth.AuthMiddleware()...)
 
-	r.With(auth.AdminOnly(s.db)).Post("/create", s.CreateTeam)
+	// Org admins can also create teams in their organization.
+	r.Post("/create", s.CreateTeam)
 
 	r.Get("/list", s.List)
 
@@ -49,6 +51,9 @@ func (s *TeamService) Routes() chi.Router {
 
 type createTeamRequest struct {
 	Name string `json:"name"`
+	// The organization of the team, only users in the organization can be added
+	// to the team. Defaults to the organization of an org admin creating the team.
+	OrganizationId *uuid.UUID `json:"organization_id"`
 }
 
 type createTeamResponse struct {
@@ -66,9 +71,31 @@ func (s *TeamService) CreateTeam(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newTeam := schema.Team{Id: uuid.New(), Name: params.Name}
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !user.IsAdmin {
+		if params.OrganizationId == nil {
+			params.OrganizationId = user.OrganizationId
+		}
+		if params.OrganizationId == nil || !auth.IsOrgAdmin(*params.OrganizationId, user) {
+			http.Error(w, fmt.Sprintf("user %v must be an admin or an admin of the team's organization to create a team", user.Id), http.StatusForbidden)
+			return
+		}
+	}
+
+	newTeam := schema.Team{Id: uuid.New(), Name: params.Name, OrganizationId: params.OrganizationId}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if newTeam.OrganizationId != nil {
+			if err := checkOrganizationExists(txn, *newTeam.OrganizationId); err != nil {
+				return err
+			}
+		}
 
-	err := s.db.Transaction(func(txn *gorm.DB) error {
 		var existingTeam schema.Team
 		result := txn.Limit(1).Find(&existingTeam, "name = ?", params.Name)
 		if result.Error != nil {
@@ -76,20 +103,23 @@ func (s *TeamService) CreateTeam(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 		if result.RowsAffected != 0 {
-			return CodedError(fmt.Errorf("team with name %v already exists", params.Name), http.StatusConflict)
+			return CodedErrorWithErrorCode(fmt.Errorf("team with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
 		}
 
 		result = txn.Create(&newTeam)
 		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return CodedErrorWithErrorCode(fmt.Errorf("team with name %v already exists", params.Name), http.StatusConflict, utils.ErrorCodeDuplicateName)
+			}
 			slog.Error("sql error creating new team", "error", result.Error)
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamCreatedEvent, nil, &newTeam.Id, map[string]interface{}{"name": newTeam.Name, "organization_id": newTeam.OrganizationId})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error creating team: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error creating team: %v", err), err)
 		return
 	}
 
@@ -110,6 +140,17 @@ func (s *TeamService) DeleteTeam(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 
+		// Deleting the team makes its models private, which is not allowed for
+		// locked models.
+		var lockedModels int64
+		if err := txn.Model(&schema.Model{}).Where("team_id = ? AND locked = ?", team.Id, true).Count(&lockedModels).Error; err != nil {
+			slog.Error("sql error counting locked team models", "team_id", teamId, "error", err)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if lockedModels != 0 {
+			return CodedError(fmt.Errorf("cannot delete team %v since it has %d locked models", teamId, lockedModels), http.StatusUnprocessableEntity)
+		}
+
 		result := txn.Delete(&team)
 		if result.Error != nil {
 			slog.Error("sql error deleting team", "team_id", teamId, "error", result.Error)
@@ -122,11 +163,11 @@ func (s *TeamService) DeleteTeam(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamDeletedEvent, nil, &teamId, nil)
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error deleting team: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error deleting team: %v", err), err)
 		return
 	}
 
@@ -148,11 +189,7 @@ func (s *TeamService) AddUserToTeam(w http.ResponseWriter, r *http.Request) {
 	userTeam := schema.UserTeam{UserId: userId, TeamId: teamId}
 
 	err = s.db.Transaction(func(txn *gorm.DB) error {
-		if err := checkTeamExists(txn, teamId); err != nil {
-			return err
-		}
-
-		if err := checkUserExists(txn, userId); err != nil {
+		if err := checkSameOrganization(txn, userId, teamId); err != nil {
 			return err
 		}
 
@@ -162,11 +199,11 @@ func (s *TeamService) AddUserToTeam(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamMemberAddedEvent, nil, &teamId, map[string]interface{}{"user_id": userId})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error adding user to team: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error adding user to team: %v", err), err)
 		return
 	}
 
@@ -210,11 +247,11 @@ func (s *TeamService) RemoveUserFromTeam(w http.ResponseWriter, r *http.Request)
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamMemberRemovedEvent, nil, &teamId, map[string]interface{}{"user_id": userId})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error removing user from team: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error removing user from team: %v", err), err)
 		return
 	}
 
@@ -234,11 +271,7 @@ func (s *TeamService) AddTeamAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = s.db.Transaction(func(txn *gorm.DB) error {
-		if err := checkTeamExists(txn, teamId); err != nil {
-			return err
-		}
-
-		if err := checkUserExists(txn, userId); err != nil {
+		if err := checkSameOrganization(txn, userId, teamId); err != nil {
 			return err
 		}
 
@@ -248,11 +281,11 @@ func (s *TeamService) AddTeamAdmin(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamAdminChangedEvent, nil, &teamId, map[string]interface{}{"user_id": userId, "is_team_admin": true})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error adding team admin: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error adding team admin: %v", err), err)
 		return
 	}
 
@@ -290,11 +323,11 @@ func (s *TeamService) RemoveTeamAdmin(w http.ResponseWriter, r *http.Request) {
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
 
-		return nil
+		return recordEvent(txn, schema.TeamAdminChangedEvent, nil, &teamId, map[string]interface{}{"user_id": userId, "is_team_admin": false})
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error removing team admin: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error removing team admin: %v", err), err)
 		return
 	}
 
@@ -302,8 +335,9 @@ func (s *TeamService) RemoveTeamAdmin(w http.ResponseWriter, r *http.Request) {
 }
 
 type TeamInfo struct {
-	Id   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	Id             uuid.UUID  `json:"id"`
+	Name           string     `json:"name"`
+	OrganizationId *uuid.UUID `json:"organization_id"`
 }
 
 func (s *TeamService) List(w http.ResponseWriter, r *http.Request) {
@@ -323,7 +357,11 @@ func (s *TeamService) List(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		result = s.db.Where("id IN ?", userTeams).Find(&teams)
+		query := s.db.Where("id IN ?", userTeams)
+		if user.IsOrgAdmin && user.OrganizationId != nil {
+			query = query.Or("organization_id = ?", *user.OrganizationId)
+		}
+		result = query.Find(&teams)
 	}
 
 	if result.Error != nil {
@@ -334,7 +372,7 @@ func (s *TeamService) List(w http.ResponseWriter, r *http.Request) {
 
 	infos := make([]TeamInfo, 0, len(teams))
 	for _, team := range teams {
-		infos = append(infos, TeamInfo{Id: team.Id, Name: team.Name})
+		infos = append(infos, TeamInfo{Id: team.Id, Name: team.Name, OrganizationId: team.OrganizationId})
 	}
 
 	utils.WriteJsonResponse(w, infos)
@@ -355,7 +393,7 @@ func (s *TeamService) TeamUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := checkTeamExists(s.db, teamId); err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -388,7 +426,7 @@ func (s *TeamService) TeamModels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := checkTeamExists(s.db, teamId); err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -408,7 +446,7 @@ func (s *TeamService) TeamModels(w http.ResponseWriter, r *http.Request) {
 	for _, model := range models {
 		info, err := convertToModelInfo(model, s.db)
 		if err != nil {
-			http.Error(w, err.Error(), GetResponseCode(err))
+			writeError(w, err.Error(), err)
 			return
 		}
 		infos = append(infos, info)
@@ -416,3 +454,29 @@ func (s *TeamService) TeamModels(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJsonResponse(w, infos)
 }
+
+// checkSameOrganization checks that the user and team exist and are in the same
+// organization, since teams cannot have members from other organizations.
+func checkSameOrganization(txn *gorm.DB, userId, teamId uuid.UUID) error {
+	team, err := schema.GetTeam(teamId, txn)
+	if err != nil {
+		if errors.Is(err, schema.ErrTeamNotFound) {
+			return CodedError(err, http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+
+	user, err := schema.GetUser(userId, txn)
+	if err != nil {
+		if errors.Is(err, schema.ErrUserNotFound) {
+			return CodedError(err, http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+
+	if !schema.SameOrganization(user.OrganizationId, team.OrganizationId) {
+		return CodedError(fmt.Errorf("user %v is not in the organization of team %v", userId, teamId), http.StatusUnprocessableEntity)
+	}
+
+	return nil
+}
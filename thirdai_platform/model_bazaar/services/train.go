@@ -1,7 +1,6 @@
 package services
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +14,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/model_bazaar/licensing"
@@ -27,6 +27,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type TrainService struct {
@@ -39,6 +40,12 @@ type TrainService struct {
 
 	license   *licensing.LicenseVerifier
 	variables Variables
+
+	webhooks *WebhookDispatcher
+
+	// Prevents sweep trials from being started concurrently by a request and the
+	// status sync.
+	sweepLock *sync.Mutex
 }
 
 func (s *TrainService) Routes() chi.Router {
@@ -54,9 +61,13 @@ func (s *TrainService) Routes() chi.Router {
 		r.Post("/nlp-text", s.TrainNlpText)
 		r.Post("/nlp-datagen", s.TrainNlpDatagen)
 		r.Post("/nlp-token-retrain", s.NlpTokenRetrain)
-		r.Post("/upload-data", s.UploadData)
+		r.With(uploadTimeouts).Post("/upload-data", s.UploadData)
+		r.Get("/upload/{upload_id}", s.UploadInfo)
 		r.Post("/verify-doc-dir", s.VerifyDocDir)
 		r.Post("/validate-trainable-csv", s.ValidateTokenTextClassificationCSV)
+		r.Post("/sweep", s.Sweep)
+		r.Get("/sweep/{sweep_id}", s.SweepInfo)
+		r.With(auth.AdminOnly(s.db)).Get("/queue", s.TrainQueue)
 	})
 
 	r.Group(func(r chi.Router) {
@@ -64,16 +75,30 @@ func (s *TrainService) Routes() chi.Router {
 		r.Use(s.jobAuth.Authenticator())
 
 		r.Post("/update-status", s.UpdateStatus)
+		r.Post("/update-progress", s.UpdateProgress)
+		r.Post("/update-evaluation", s.UpdateEvaluation)
 		r.Post("/log", s.JobLog)
 	})
 
+	r.Route("/schedules", func(r chi.Router) {
+		r.Use(s.userAuth.AuthMiddleware()...)
+
+		r.Get("/", s.ListSchedules)
+		r.Post("/", s.CreateSchedule)
+		r.Get("/{schedule_id}", s.GetSchedule)
+		r.Patch("/{schedule_id}", s.UpdateSchedule)
+		r.Delete("/{schedule_id}", s.DeleteSchedule)
+	})
+
 	r.Route("/{model_id}", func(r chi.Router) {
 		r.Use(s.userAuth.AuthMiddleware()...)
 		r.Use(auth.ModelPermissionOnly(s.db, auth.ReadPermission))
 
 		r.Get("/status", s.GetStatus)
 		r.Get("/report", s.TrainReport)
+		r.Get("/evaluation", s.GetEvaluation)
 		r.Get("/logs", s.Logs)
+		r.With(logStreamTimeouts).Get("/logs/stream", s.StreamLogs)
 	})
 
 	return r
@@ -90,6 +115,14 @@ type basicTrainArgs struct {
 	jobOptions            config.JobOptions
 	retraining            bool
 	generativeSupervision bool
+
+	// The dataset versions in the train files, they are recorded for the model.
+	datasets []schema.ModelDataset
+
+	// If set, prepare is called with the id of the new model before its train
+	// config is saved, so that it can create files in the model's directory and
+	// update the args accordingly.
+	prepare func(modelId uuid.UUID, args *basicTrainArgs) error
 }
 
 type trainResponse struct {
@@ -103,21 +136,63 @@ func (s *TrainService) basicTraining(w http.ResponseWriter, r *http.Request, arg
 		return
 	}
 
+	modelId, err := s.startTraining(user, args)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, trainResponse{ModelId: modelId})
+}
+
+func (s *TrainService) startTraining(user schema.User, args basicTrainArgs) (uuid.UUID, error) {
 	model := newModel(uuid.New(), args.modelName, args.modelType, args.baseModelId, user.Id)
+	for _, dataset := range args.datasets {
+		dataset.ModelId = model.Id
+		model.Datasets = append(model.Datasets, dataset)
+	}
 
 	slog.Info("starting training", "model_type", args.modelType, "model_id", model.Id, "model_name", args.modelName)
 
-	license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, args.jobOptions.CpuUsageMhz())
+	if args.baseModelId != nil {
+		if err := checkModelHot(s.db, *args.baseModelId, "used as a base model"); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	if args.jobOptions.EffectivePriority() > config.DefaultJobPriority && !user.IsAdmin {
+		return uuid.Nil, CodedError(fmt.Errorf("only admins can train with a priority above %d", config.DefaultJobPriority), http.StatusForbidden)
+	}
+
+	queued := false
+	if err := checkTrainJobLimit(s.db, s.variables.UserJobLimits, user.Id); err != nil {
+		if !s.variables.QueueTrainJobs || !errors.Is(err, ErrUserJobLimitReached) {
+			return uuid.Nil, err
+		}
+		queued = true
+	}
+
+	license, err := verifyLicenseForUserJob(s.db, s.orchestratorClient, s.license, user.Id, args.jobOptions.CpuUsageMhz())
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
-		return
+		// Jobs that preempt other jobs are queued even if queueing is disabled,
+		// since they are started once the jobs they preempt have stopped.
+		if !(s.variables.QueueTrainJobs || args.jobOptions.Preempt) || !errors.Is(err, licensing.ErrCpuLimitExceeded) {
+			return uuid.Nil, err
+		}
+		// The license key is added to the config when the job is started.
+		queued = true
 	}
 
 	jobToken, err := s.jobAuth.CreateModelJwt(model.Id, time.Hour*1000*24)
 	if err != nil {
 		slog.Error("error creating job token for train job", "error", err)
-		http.Error(w, "error setting up train job", http.StatusInternalServerError)
-		return
+		return uuid.Nil, CodedError(errors.New("error setting up train job"), http.StatusInternalServerError)
+	}
+
+	if args.prepare != nil {
+		if err := args.prepare(model.Id, &args); err != nil {
+			return uuid.Nil, err
+		}
 	}
 
 	trainConfig := config.TrainConfig{
@@ -141,37 +216,74 @@ func (s *TrainService) basicTraining(w http.ResponseWriter, r *http.Request, arg
 	configPath, err := saveConfig(trainConfig.ModelId, "train", trainConfig, s.storage)
 	if err != nil {
 		slog.Error("error saving train config", "error", err)
-		http.Error(w, err.Error(), GetResponseCode(err))
-		return
+		return uuid.Nil, err
+	}
+
+	if queued {
+		if err := s.queueTrainJob(model, user, configPath, trainConfig.JobOptions); err != nil {
+			return uuid.Nil, CodedError(fmt.Errorf("error queueing %v training: %w", args.modelType, err), GetResponseCode(err))
+		}
+		slog.Info("queued training until there is capacity", "model_type", args.modelType, "model_id", model.Id, "model_name", args.modelName)
+		return model.Id, nil
+	}
+
+	job, err := s.trainJob(model, configPath, trainConfig.JobOptions.CpuUsageMhz(), trainConfig.JobOptions.AllocationMemory, trainConfig.JobOptions.EffectivePriority())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	err = s.saveModelAndStartJob(model, user, job, job.Resources, trainConfig.JobOptions.EffectivePriority())
+	if err != nil {
+		return uuid.Nil, CodedError(fmt.Errorf("error starting %v training: %w", args.modelType, err), GetResponseCode(err))
 	}
 
-	job := orchestrator.TrainJob{
+	slog.Info("started training succesfully", "model_type", args.modelType, "model_id", model.Id, "model_name", args.modelName)
+
+	return model.Id, nil
+}
+
+func (s *TrainService) trainJob(model schema.Model, configPath string, allocationMhz, allocationMemory, priority int) (orchestrator.TrainJob, error) {
+	extraEnv, err := s.variables.jobEnv(s.db, s.storage, trainJobEnv)
+	if err != nil {
+		return orchestrator.TrainJob{}, err
+	}
+
+	return orchestrator.TrainJob{
 		JobName:    model.TrainJobName(),
+		ModelId:    model.Id.String(),
 		ConfigPath: configPath,
 		Driver:     s.variables.BackendDriver,
 		Resources: orchestrator.Resources{
 			AllocationCores:     2,
-			AllocationMhz:       trainConfig.JobOptions.CpuUsageMhz(),
-			AllocationMemory:    trainConfig.JobOptions.AllocationMemory,
+			AllocationMhz:       allocationMhz,
+			AllocationMemory:    allocationMemory,
 			AllocationMemoryMax: 60000,
 		},
 		CloudCredentials: s.variables.CloudCredentials,
-	}
+		Namespace:        s.variables.jobNamespace(model),
+		Priority:         orchestratorPriority(priority),
+		ExtraEnv:         extraEnv,
+	}, nil
+}
 
-	err = s.saveModelAndStartJob(model, user, job)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error starting %v training: %v", args.modelType, err), GetResponseCode(err))
-		return
+// orchestratorPriority is the priority that a train job is submitted with. Jobs
+// with the default priority are submitted without one, so that they use the
+// default of the orchestrator.
+func orchestratorPriority(priority int) int {
+	if priority == config.DefaultJobPriority {
+		return 0
 	}
-
-	slog.Info("started training succesfully", "model_type", args.modelType, "model_id", model.Id, "model_name", args.modelName)
-
-	utils.WriteJsonResponse(w, trainResponse{ModelId: model.Id})
+	return priority
 }
 
-func (s *TrainService) saveModelAndStartJob(model schema.Model, user schema.User, job orchestrator.Job) error {
+// saveModelAndStartJob saves the model and starts its train job. priority is
+// the priority of the job if it can be preempted, or 0 if it cannot.
+func (s *TrainService) saveModelAndStartJob(model schema.Model, user schema.User, job orchestrator.Job, resources orchestrator.Resources, priority int) error {
 	err := s.db.Transaction(func(txn *gorm.DB) error {
-		return saveModel(txn, model, user)
+		if err := saveModel(txn, model, user); err != nil {
+			return err
+		}
+		return startJobRun(txn, "train", model.Id, job.GetJobName(), resources, priority)
 	})
 
 	if err != nil {
@@ -181,16 +293,20 @@ func (s *TrainService) saveModelAndStartJob(model schema.Model, user schema.User
 	err = s.orchestratorClient.StartJob(job)
 	if err != nil {
 		slog.Error("error starting train job", "error", err)
-		return CodedError(errors.New("error starting train job on nomad"), http.StatusInternalServerError)
-	}
-
-	result := s.db.Model(&model).Update("train_status", schema.Starting)
-	if result.Error != nil {
-		slog.Error("sql error updating model train status", "error", err)
-		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		if err := updateJobRun(s.db, "train", model.Id, schema.Failed, startErrorMessage(err)); err != nil {
+			slog.Error("error recording failed train job run", "model_id", model.Id, "error", err)
+		}
+		return jobStartError(err, "error starting train job on nomad")
 	}
 
-	return nil
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		// The job may have already reported its status if it started quickly.
+		err := updateStatus(txn, "train", &model, schema.Starting, "")
+		if errors.Is(err, errStatusChanged) {
+			return nil
+		}
+		return err
+	})
 }
 
 func getMultipartBoundary(r *http.Request) (string, error) {
@@ -223,24 +339,48 @@ func (s *TrainService) UploadData(w http.ResponseWriter, r *http.Request) {
 
 	boundary, err := getMultipartBoundary(r)
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	uploadId := uuid.New()
+	var upload schema.Upload
+	filenames := make([]string, 0)
 
-	upload := schema.Upload{
-		Id:         uploadId,
-		UserId:     user.Id,
-		UploadDate: time.Now().UTC(),
-	}
-	if err := s.db.Create(&upload).Error; err != nil {
-		slog.Error("sql error creating upload", "error", err)
-		http.Error(w, fmt.Sprintf("unable to create upload: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
-		return
+	// Files can be added to an existing upload, this allows for uploads with
+	// multiple subdirectories to be created with multiple requests.
+	if existingId := r.URL.Query().Get("upload_id"); existingId != "" {
+		if err := s.validateUploads(user.Id, []config.TrainFile{{Path: existingId, Location: config.FileLocUpload}}); err != nil {
+			writeError(w, fmt.Sprintf("invalid upload specified: %v", err), err)
+			return
+		}
+		if err := s.db.First(&upload, "id = ?", existingId).Error; err != nil {
+			slog.Error("sql error retrieving upload info", "upload_id", existingId, "error", err)
+			http.Error(w, fmt.Sprintf("unable to retrieve upload: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+			return
+		}
+		// Uploads that are registered as dataset versions cannot be changed.
+		frozen, err := isDatasetUpload(s.db, upload.Id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to retrieve upload: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if frozen {
+			http.Error(w, fmt.Sprintf("upload %v is registered as a dataset version, files cannot be added to it", upload.Id), http.StatusConflict)
+			return
+		}
+	} else {
+		upload = schema.Upload{
+			Id:         uuid.New(),
+			UserId:     user.Id,
+			UploadDate: time.Now().UTC(),
+		}
+		if err := s.db.Create(&upload).Error; err != nil {
+			slog.Error("sql error creating upload", "error", err)
+			http.Error(w, fmt.Sprintf("unable to create upload: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+			return
+		}
 	}
-
-	filenames := make([]string, 0)
+	uploadId := upload.Id
 
 	reader := multipart.NewReader(r.Body, boundary)
 
@@ -283,8 +423,18 @@ func (s *TrainService) UploadData(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	upload.Files = strings.Join(filenames, ";")
-	if err := s.db.Save(&upload).Error; err != nil {
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		// The upload is locked and its file list is read again, so that files
+		// added to the upload by concurrent requests are not lost.
+		if err := txn.Clauses(clause.Locking{Strength: "UPDATE"}).First(&upload, "id = ?", uploadId).Error; err != nil {
+			return err
+		}
+		if upload.Files != "" {
+			filenames = append(strings.Split(upload.Files, ";"), filenames...)
+		}
+		return txn.Model(&upload).Update("files", strings.Join(filenames, ";")).Error
+	})
+	if err != nil {
 		slog.Error("sql error updating upload file list", "error", err)
 		http.Error(w, "error storing upload metadata", http.StatusInternalServerError)
 		return
@@ -293,34 +443,129 @@ func (s *TrainService) UploadData(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJsonResponse(w, map[string]uuid.UUID{"upload_id": uploadId})
 }
 
+type UploadInfo struct {
+	UploadId   uuid.UUID `json:"upload_id"`
+	Files      []string  `json:"files"`
+	UploadedAt time.Time `json:"uploaded_at"`
+
+	// Deprecated: the same as uploaded_at, kept for older clients.
+	UploadDate time.Time `json:"upload_date" deprecated:"true"`
+}
+
+// UploadInfo returns the files in an upload, it is also used by deployments to
+// check that a user can access an upload before reading it.
+func (s *TrainService) UploadInfo(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uploadId, err := utils.URLParamUUID(r, "upload_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.validateUploads(user.Id, []config.TrainFile{{Path: uploadId.String(), Location: config.FileLocUpload}}); err != nil {
+		writeError(w, fmt.Sprintf("unable to access upload: %v", err), err)
+		return
+	}
+
+	var upload schema.Upload
+	if err := s.db.First(&upload, "id = ?", uploadId).Error; err != nil {
+		slog.Error("sql error retrieving upload info", "upload_id", uploadId, "error", err)
+		http.Error(w, fmt.Sprintf("unable to retrieve upload: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	files := make([]string, 0)
+	if upload.Files != "" {
+		files = strings.Split(upload.Files, ";")
+	}
+
+	utils.WriteJsonResponse(w, UploadInfo{UploadId: upload.Id, Files: files, UploadedAt: upload.UploadDate.UTC(), UploadDate: upload.UploadDate.UTC()})
+}
+
 func (s *TrainService) validateUploads(userId uuid.UUID, files []config.TrainFile) error {
+	_, err := resolveUploads(s.db, s.storage, userId, files)
+	return err
+}
+
+// resolveTrainFiles resolves the uploads and dataset versions in the files of a
+// train request, and returns the dataset versions so that they can be recorded
+// for the model.
+func (s *TrainService) resolveTrainFiles(userId uuid.UUID, fileLists ...[]config.TrainFile) ([]schema.ModelDataset, error) {
+	datasets := make([]schema.ModelDataset, 0)
+	for _, files := range fileLists {
+		resolved, err := resolveUploads(s.db, s.storage, userId, files)
+		if err != nil {
+			return nil, CodedError(fmt.Errorf("invalid uploads specified: %w", err), GetResponseCode(err))
+		}
+		for _, dataset := range resolved {
+			if !slices.ContainsFunc(datasets, func(d schema.ModelDataset) bool {
+				return d.DatasetName == dataset.DatasetName && d.Version == dataset.Version
+			}) {
+				datasets = append(datasets, dataset)
+			}
+		}
+	}
+	return datasets, nil
+}
+
+// resolveUploads checks that the user owns the uploads in the files, and can
+// access the datasets in the files, and replaces them with the local path of
+// the upload so that jobs can read them. It returns the dataset versions that
+// are used by the files.
+func resolveUploads(db *gorm.DB, store storage.Storage, userId uuid.UUID, files []config.TrainFile) ([]schema.ModelDataset, error) {
+	var datasets []schema.ModelDataset
 	for i, file := range files {
-		if file.Location == config.FileLocUpload {
+		switch file.Location {
+		case config.FileLocUpload:
 			uploadId, err := uuid.Parse(file.Path)
 			if err != nil {
-				return CodedError(fmt.Errorf("invalid upload id: %v", file.Path), http.StatusBadRequest)
+				return nil, CodedError(fmt.Errorf("invalid upload id: %v", file.Path), http.StatusBadRequest)
 			}
 
 			var upload schema.Upload
-			result := s.db.First(&upload, "id = ?", uploadId)
+			result := db.First(&upload, "id = ?", uploadId)
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					return CodedError(fmt.Errorf("upload %v does not exist", uploadId), http.StatusNotFound)
+					return nil, CodedError(fmt.Errorf("upload %v does not exist", uploadId), http.StatusNotFound)
 				}
 				slog.Error("sql error retrieving upload info", "upload_id", uploadId, "error", result.Error)
-				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+				return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 			}
 
 			if upload.UserId != userId {
-				return CodedError(fmt.Errorf("user %v does not have permission to access upload %v", userId, uploadId), http.StatusForbidden)
+				return nil, CodedError(fmt.Errorf("user %v does not have permission to access upload %v", userId, uploadId), http.StatusForbidden)
+			}
+
+			files[i].Location = config.FileLocLocal
+			files[i].Path = filepath.Join(store.Location(), storage.UploadPath(uploadId))
+
+		case config.FileLocDataset:
+			user, err := schema.GetUser(userId, db)
+			if err != nil {
+				if errors.Is(err, schema.ErrUserNotFound) {
+					return nil, CodedError(err, http.StatusNotFound)
+				}
+				return nil, CodedError(err, http.StatusInternalServerError)
+			}
+
+			dataset, version, err := resolveDatasetFile(db, user, file.Path)
+			if err != nil {
+				return nil, err
 			}
 
+			datasets = append(datasets, schema.ModelDataset{DatasetName: dataset.Name, Version: version.Version, DatasetVersionId: &version.Id})
+
 			files[i].Location = config.FileLocLocal
-			files[i].Path = filepath.Join(s.storage.Location(), storage.UploadPath(uploadId))
+			files[i].Path = filepath.Join(store.Location(), storage.UploadPath(version.UploadId))
 		}
 	}
 
-	return nil
+	return datasets, nil
 }
 
 func (s *TrainService) GetStatus(w http.ResponseWriter, r *http.Request) {
@@ -333,11 +578,26 @@ func (s *TrainService) GetStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *TrainService) UpdateStatus(w http.ResponseWriter, r *http.Request) {
-	updateStatusHandler(w, r, s.db, "train")
+	updateStatusHandler(w, r, s.db, "train", func(txn *gorm.DB, model schema.Model) error {
+		return checkStorageQuota(txn, s.storage, s.variables.StorageQuotas, model.Id, model.Type)
+	})
+	s.webhooks.Notify()
+
+	if modelId, err := auth.ModelIdFromContext(r); err == nil {
+		s.advanceTrialSweep(modelId)
+	}
+}
+
+func (s *TrainService) UpdateProgress(w http.ResponseWriter, r *http.Request) {
+	updateProgressHandler(w, r, s.db, "train")
 }
 
 func (s *TrainService) Logs(w http.ResponseWriter, r *http.Request) {
-	getLogsHandler(w, r, s.db, s.orchestratorClient, "train")
+	getLogsHandler(w, r, s.db, s.orchestratorClient, s.storage, "train")
+}
+
+func (s *TrainService) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	streamLogsHandler(w, r, s.db, s.orchestratorClient, "train")
 }
 
 func (s *TrainService) JobLog(w http.ResponseWriter, r *http.Request) {
@@ -354,7 +614,7 @@ func (s *TrainService) TrainReport(w http.ResponseWriter, r *http.Request) {
 	model, err := schema.GetModel(modelId, s.db, false, false, false)
 	if err != nil {
 		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
 			return
 		}
 		http.Error(w, fmt.Sprintf("error retrieving model info: %v", err), http.StatusInternalServerError)
@@ -366,18 +626,26 @@ func (s *TrainService) TrainReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reportDir := filepath.Join(storage.ModelPath(model.Id), "train_reports")
+	report, err := readLatestTrainReport(s.storage, model.Id)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, report)
+}
+
+func readLatestTrainReport(store storage.Storage, modelId uuid.UUID) (interface{}, error) {
+	reportDir := filepath.Join(storage.ModelPath(modelId), "train_reports")
 
-	reports, err := s.storage.List(reportDir)
+	reports, err := store.List(reportDir)
 	if err != nil {
 		slog.Error("error listing train reports", "model_id", modelId, "error", err)
-		http.Error(w, "error listing train reports", http.StatusInternalServerError)
-		return
+		return nil, CodedError(errors.New("error listing train reports"), http.StatusInternalServerError)
 	}
 
 	if len(reports) == 0 {
-		http.Error(w, fmt.Sprintf("no train reports found for model %v", model.Id), http.StatusUnprocessableEntity)
-		return
+		return nil, CodedError(fmt.Errorf("no train reports found for model %v", modelId), http.StatusUnprocessableEntity)
 	}
 
 	mostRecent := -1
@@ -393,16 +661,14 @@ func (s *TrainService) TrainReport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if mostRecent <= 0 {
-		slog.Error("no processable train reports found", "model_id", model.Id)
-		http.Error(w, fmt.Sprintf("no train reports found for model %v", model.Id), http.StatusUnprocessableEntity)
-		return
+		slog.Error("no processable train reports found", "model_id", modelId)
+		return nil, CodedError(fmt.Errorf("no train reports found for model %v", modelId), http.StatusUnprocessableEntity)
 	}
 
-	reportData, err := s.storage.Read(filepath.Join(reportDir, fmt.Sprintf("%d.json", mostRecent)))
+	reportData, err := store.Read(filepath.Join(reportDir, fmt.Sprintf("%d.json", mostRecent)))
 	if err != nil {
 		slog.Error("error reading train report", "error", err)
-		http.Error(w, "error reading train report", http.StatusInternalServerError)
-		return
+		return nil, CodedError(errors.New("error reading train report"), http.StatusInternalServerError)
 	}
 	defer reportData.Close()
 
@@ -410,48 +676,60 @@ func (s *TrainService) TrainReport(w http.ResponseWriter, r *http.Request) {
 	err = json.NewDecoder(reportData).Decode(&report)
 	if err != nil {
 		slog.Error("error parsing train report", "error", err)
-		http.Error(w, "error parsing train report", http.StatusInternalServerError)
-		return
+		return nil, CodedError(errors.New("error parsing train report"), http.StatusInternalServerError)
 	}
 
-	utils.WriteJsonResponse(w, report)
+	return report, nil
 }
 
-func ValidateCSVHeader(fileHeaders []string, expectedHeaders []string) error {
-	if len(fileHeaders) != len(expectedHeaders) {
-		return fmt.Errorf("invalid column: expected %v, got %v", expectedHeaders, fileHeaders)
+func findCSVColumns(fileHeaders []string, sourceColumn, targetColumn string) (int, int, error) {
+	if sourceColumn == targetColumn {
+		return 0, 0, fmt.Errorf("source and target columns must be different, got '%v' for both", sourceColumn)
 	}
 
-	for _, key := range expectedHeaders {
-		if !slices.Contains(fileHeaders, key) {
-			return fmt.Errorf("invalid column: expected %v, got %v", expectedHeaders, fileHeaders)
-		}
+	trimmed := make([]string, 0, len(fileHeaders))
+	for _, header := range fileHeaders {
+		trimmed = append(trimmed, strings.TrimSpace(header))
+	}
+
+	sourceColIndex := slices.Index(trimmed, sourceColumn)
+	targetColIndex := slices.Index(trimmed, targetColumn)
+	if sourceColIndex < 0 || targetColIndex < 0 {
+		return 0, 0, fmt.Errorf("invalid columns: expected columns '%v' and '%v', got %v", sourceColumn, targetColumn, fileHeaders)
 	}
-	return nil
+
+	return sourceColIndex, targetColIndex, nil
 }
 
-func (s *TrainService) validateTrainableCSV(filepath string, expectedHeaders []string, targetColumn string, isTokenCSV bool) ([]string, error) {
+func (s *TrainService) validateTrainableCSV(filepath string, sourceColumn, targetColumn string, csvOptions config.CSVOptions, isTokenCSV bool) (TrainableCSVResponse, error) {
+	res := TrainableCSVResponse{Format: TrainFileCsv}
+
 	file, err := s.storage.Read(filepath)
 	if err != nil {
-		return nil, CodedError(fmt.Errorf("unable to open file. error: %w", err), http.StatusUnprocessableEntity)
+		return res, CodedError(fmt.Errorf("unable to open file. error: %w", err), http.StatusUnprocessableEntity)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	fileHeaders, err := reader.Read()
+	reader, err := newCSVReader(file, csvOptions)
 	if err != nil {
-		return nil, CodedError(fmt.Errorf("unable to read file. error: %w", err), http.StatusUnprocessableEntity)
+		return res, CodedError(fmt.Errorf("unable to read file. error: %w", err), http.StatusUnprocessableEntity)
 	}
+	res.Dialect = reader.dialect
 
-	// Validate the CSV header
-	if err := ValidateCSVHeader(fileHeaders, expectedHeaders); err != nil {
-		return nil, CodedError(err, http.StatusUnprocessableEntity)
+	fileHeaders, err := reader.Read()
+	if err != nil {
+		return res, CodedError(fmt.Errorf("unable to read file. error: %w", err), http.StatusUnprocessableEntity)
+	}
+	for _, header := range fileHeaders {
+		res.Schema = append(res.Schema, ColumnSchema{Name: strings.TrimSpace(header), Type: "string"})
 	}
 
-	targetColIndex := slices.Index(fileHeaders, targetColumn)
-	sourceColIndex := 1 - targetColIndex
+	sourceColIndex, targetColIndex, err := findCSVColumns(fileHeaders, sourceColumn, targetColumn)
+	if err != nil {
+		return res, CodedError(err, http.StatusUnprocessableEntity)
+	}
 
-	labels := make(map[string]bool)
+	labels := make(trainableLabels)
 
 	for {
 		line, err := reader.Read()
@@ -459,37 +737,51 @@ func (s *TrainService) validateTrainableCSV(filepath string, expectedHeaders []s
 			if err == io.EOF {
 				break
 			} else {
-				return nil, CodedError(err, http.StatusUnprocessableEntity)
+				return res, CodedError(err, http.StatusUnprocessableEntity)
 			}
 		}
+		res.Rows++
 
 		if isTokenCSV {
 			sourceTokens := strings.Split(line[sourceColIndex], " ")
 			targetTokens := strings.Split(line[targetColIndex], " ")
-			if len(sourceTokens) != len(targetTokens) {
-				return nil, CodedError(fmt.Errorf("number of source tokens: %d ≠ number of target tokens: %d. Invalid line: '%v'", len(sourceTokens), len(targetTokens), strings.Join(line, ",")), http.StatusUnprocessableEntity)
-			}
-			for _, token := range targetTokens {
-				if token != "O" {
-					labels[token] = true
-				}
+			if err := labels.addTokens(sourceTokens, targetTokens); err != nil {
+				return res, CodedError(fmt.Errorf("%w. Invalid line: '%v'", err, strings.Join(line, reader.dialect.Delimiter)), http.StatusUnprocessableEntity)
 			}
 		} else {
-			labels[line[targetColIndex]] = true
+			labels.addLabel(line[targetColIndex])
 		}
 	}
 
-	uniqueLabels := make([]string, 0)
-	for key := range labels {
-		uniqueLabels = append(uniqueLabels, key)
-	}
-
-	return uniqueLabels, nil
+	labels.report(&res)
+	return res, nil
 }
 
 type TrainableCSVRequest struct {
 	UploadId string `json:"upload_id"`
 	FileType string `json:"type"`
+
+	// Optional, defaults to text/labels for text files and source/target for token files.
+	SourceColumn string `json:"source_column"`
+	TargetColumn string `json:"target_column"`
+
+	// Optional, any options not specified are detected from the file.
+	CsvOptions config.CSVOptions `json:"csv_options"`
+}
+
+type TrainableCSVResponse struct {
+	Labels  []string   `json:"labels"`
+	Dialect CSVDialect `json:"dialect"`
+
+	Format string         `json:"format"`
+	Rows   int            `json:"rows"`
+	Schema []ColumnSchema `json:"schema"`
+
+	// The number of rows of each label for text files, or the number of tokens
+	// with each tag for token files.
+	LabelCounts               map[string]int          `json:"label_counts"`
+	ImbalanceRatio            float64                 `json:"imbalance_ratio"`
+	SuggestedImbalanceOptions config.ImbalanceOptions `json:"suggested_imbalance_options"`
 }
 
 func (s *TrainService) ValidateTokenTextClassificationCSV(w http.ResponseWriter, r *http.Request) {
@@ -522,13 +814,13 @@ func (s *TrainService) ValidateTokenTextClassificationCSV(w http.ResponseWriter,
 		},
 	}
 	if err := s.validateUploads(user.Id, trainConfig); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("invalid uploads specified: %v", err), err)
 		return
 	}
 
 	UploadID, err := uuid.Parse(options.UploadId)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("invalid upload id: %v", UploadID), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("invalid upload id: %v", UploadID), err)
 		return
 	}
 
@@ -541,29 +833,30 @@ func (s *TrainService) ValidateTokenTextClassificationCSV(w http.ResponseWriter,
 		http.Error(w, fmt.Sprintf("Only one file should be used. found %v files", len(fileNames)), http.StatusUnsupportedMediaType)
 		return
 	}
-	trainableCSVFilePath := filepath.Join(storage.UploadPath(UploadID), fileNames[0])
+	trainableFilePath := filepath.Join(storage.UploadPath(UploadID), fileNames[0])
 
-	if strings.ToLower(filepath.Ext(trainableCSVFilePath)) != ".csv" {
-		http.Error(w, "only CSV file is supported", http.StatusUnsupportedMediaType)
+	format := trainFileFormat(trainableFilePath)
+	if format == "" {
+		http.Error(w, "only csv, parquet, and jsonl files are supported", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	var labels []string
-	var validation_err error
-
+	sourceColumn, targetColumn := "source", "target"
 	if options.FileType == "text" {
-		labels, validation_err = s.validateTrainableCSV(trainableCSVFilePath, []string{"text", "labels"}, "labels", false)
-		if validation_err != nil {
-			http.Error(w, fmt.Sprintf("Validation failed: %v", validation_err.Error()), GetResponseCode(validation_err))
-			return
-		}
-	} else {
-		labels, validation_err = s.validateTrainableCSV(trainableCSVFilePath, []string{"source", "target"}, "target", true)
-		if validation_err != nil {
-			http.Error(w, fmt.Sprintf("Validation failed: %v", validation_err.Error()), GetResponseCode(validation_err))
-			return
-		}
+		sourceColumn, targetColumn = "text", "labels"
+	}
+	if options.SourceColumn != "" {
+		sourceColumn = options.SourceColumn
+	}
+	if options.TargetColumn != "" {
+		targetColumn = options.TargetColumn
+	}
+
+	res, validation_err := s.validateTrainableFile(trainableFilePath, format, options, sourceColumn, targetColumn)
+	if validation_err != nil {
+		writeError(w, fmt.Sprintf("Validation failed: %v", validation_err.Error()), validation_err)
+		return
 	}
 
-	utils.WriteJsonResponse(w, map[string][]string{"labels": labels})
+	utils.WriteJsonResponse(w, res)
 }
@@ -14,12 +14,13 @@ import (
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 
 	"github.com/google/uuid"
 )
 
 type NdbTrainRequest struct {
-	ModelName             string             `json:"model_name"`
+	ModelName             string             `json:"model_name" validate:"required"`
 	BaseModelId           *uuid.UUID         `json:"base_model_id"`
 	ModelOptions          *config.NdbOptions `json:"model_options"`
 	Data                  config.NDBData     `json:"data"`
@@ -29,33 +30,21 @@ type NdbTrainRequest struct {
 }
 
 func (opts *NdbTrainRequest) validate() error {
-	allErrors := make([]error, 0)
-
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
-	}
-
-	if opts.BaseModelId != nil && opts.ModelOptions != nil {
-		allErrors = append(allErrors, fmt.Errorf("only model options or base model can be specified for ndb training"))
-	}
 	if opts.ModelOptions == nil && opts.BaseModelId == nil {
 		opts.ModelOptions = new(config.NdbOptions)
 	}
 
-	if opts.ModelOptions != nil {
-		allErrors = append(allErrors, opts.ModelOptions.Validate())
+	errs := validation.Struct(opts)
+
+	if opts.BaseModelId != nil && opts.ModelOptions != nil {
+		errs.Add("model_options", "only model options or base model can be specified for ndb training")
 	}
-	allErrors = append(allErrors, opts.Data.Validate())
-	allErrors = append(allErrors, opts.JobOptions.Validate())
 
 	if opts.GenerativeSupervision && opts.LLMConfig == nil {
-		allErrors = append(allErrors, fmt.Errorf("llm_config must be specified for generative supervision"))
-	}
-	if opts.LLMConfig != nil {
-		allErrors = append(allErrors, opts.LLMConfig.Validate())
+		errs.Add("llm_config", "llm_config must be specified for generative supervision")
 	}
 
-	return errors.Join(allErrors...)
+	return errs.Err()
 }
 
 func (s *TrainService) TrainNdb(w http.ResponseWriter, r *http.Request) {
@@ -65,7 +54,7 @@ func (s *TrainService) TrainNdb(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := options.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start ndb training, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start ndb training, found the following errors: %v", err), err)
 		return
 	}
 
@@ -75,17 +64,24 @@ func (s *TrainService) TrainNdb(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.UnsupervisedFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
+	args, err := s.ndbTrainArgs(user.Id, options)
+	if err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.SupervisedFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
-		return
+	s.basicTraining(w, r, args)
+}
+
+// ndbTrainArgs converts a validated train request into the args for starting
+// the train job, resolving any uploads in the request.
+func (s *TrainService) ndbTrainArgs(userId uuid.UUID, options NdbTrainRequest) (basicTrainArgs, error) {
+	datasets, err := s.resolveTrainFiles(userId, options.Data.UnsupervisedFiles, options.Data.SupervisedFiles)
+	if err != nil {
+		return basicTrainArgs{}, err
 	}
 
-	s.basicTraining(w, r, basicTrainArgs{
+	return basicTrainArgs{
 		modelName:             options.ModelName,
 		modelType:             schema.NdbModel,
 		baseModelId:           options.BaseModelId,
@@ -94,7 +90,8 @@ func (s *TrainService) TrainNdb(w http.ResponseWriter, r *http.Request) {
 		jobOptions:            options.JobOptions,
 		llmConfig:             options.LLMConfig,
 		generativeSupervision: options.GenerativeSupervision,
-	})
+		datasets:              datasets,
+	}, nil
 }
 
 func listLogData[T any](dir string, s storage.Storage) ([]T, error) {
@@ -152,7 +149,8 @@ func (s *TrainService) getNdbRetrainingData(baseModelId uuid.UUID) (config.NDBDa
 			// in the future once this is standardized it will not be needed
 			{Path: filepath.Join(s.storage.Location(), deploymentDir, "feedback"), Location: config.FileLocLocal, Options: map[string]interface{}{}},
 		},
-		Deletions: []string{},
+		Deletions:      []string{},
+		FileValidation: config.FileValidationSkipAndReport,
 	}
 
 	insertionLogs, err := listLogData[ndbInsertionLog](filepath.Join(deploymentDir, "insertions"), s.storage)
@@ -175,21 +173,35 @@ func (s *TrainService) getNdbRetrainingData(baseModelId uuid.UUID) (config.NDBDa
 }
 
 type NdbRetrainRequest struct {
-	ModelName   string            `json:"model_name"`
+	ModelName   string            `json:"model_name" validate:"required"`
 	BaseModelId uuid.UUID         `json:"base_model_id"`
 	JobOptions  config.JobOptions `json:"job_options"`
 }
 
 func (opts *NdbRetrainRequest) validate() error {
-	allErrors := make([]error, 0)
+	return validation.Struct(opts).Err()
+}
 
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
+func (s *TrainService) ndbRetrainArgs(options NdbRetrainRequest) (basicTrainArgs, error) {
+	if err := checkModelHot(s.db, options.BaseModelId, "retrained"); err != nil {
+		return basicTrainArgs{}, err
 	}
 
-	allErrors = append(allErrors, opts.JobOptions.Validate())
+	data, err := s.getNdbRetrainingData(options.BaseModelId)
+	if err != nil {
+		return basicTrainArgs{}, CodedError(fmt.Errorf("error collecting retraining data: %w", err), GetResponseCode(err))
+	}
 
-	return errors.Join(allErrors...)
+	return basicTrainArgs{
+		modelName:             options.ModelName,
+		modelType:             schema.NdbModel,
+		baseModelId:           &options.BaseModelId,
+		modelOptions:          nil,
+		data:                  data,
+		jobOptions:            options.JobOptions,
+		retraining:            true,
+		generativeSupervision: false,
+	}, nil
 }
 
 func (s *TrainService) NdbRetrain(w http.ResponseWriter, r *http.Request) {
@@ -199,28 +211,19 @@ func (s *TrainService) NdbRetrain(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := options.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start ndb retraining, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start ndb retraining, found the following errors: %v", err), err)
 		return
 	}
 
 	slog.Info("starting ndb retraining", "base_model_id", options.BaseModelId, "model_name", options.ModelName)
 
-	data, err := s.getNdbRetrainingData(options.BaseModelId)
+	args, err := s.ndbRetrainArgs(options)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error collecting retraining data: %v", err), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	s.basicTraining(w, r, basicTrainArgs{
-		modelName:             options.ModelName,
-		modelType:             schema.NdbModel,
-		baseModelId:           &options.BaseModelId,
-		modelOptions:          nil,
-		data:                  data,
-		jobOptions:            options.JobOptions,
-		retraining:            true,
-		generativeSupervision: false,
-	})
+	s.basicTraining(w, r, args)
 
 	slog.Info("started ndb retraining succesfully", "base_model_id", options.BaseModelId, "model_name", options.ModelName)
 }
@@ -6,20 +6,20 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
-	"strings"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type NlpTokenTrainRequest struct {
-	ModelName    string                  `json:"model_name"`
+	ModelName    string                  `json:"model_name" validate:"required"`
 	BaseModelId  *uuid.UUID              `json:"base_model_id"`
 	ModelOptions *config.NlpTokenOptions `json:"model_options"`
 	Data         config.NlpData          `json:"data"`
@@ -28,28 +28,19 @@ type NlpTokenTrainRequest struct {
 }
 
 func (opts *NlpTokenTrainRequest) validate() error {
-	allErrors := make([]error, 0)
-
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
-	}
-
-	if opts.BaseModelId != nil && opts.ModelOptions != nil {
-		allErrors = append(allErrors, fmt.Errorf("only model options or base model can be specified for training"))
-	}
 	if opts.ModelOptions == nil && opts.BaseModelId == nil {
 		opts.ModelOptions = new(config.NlpTokenOptions)
 	}
 
-	if opts.ModelOptions != nil {
-		allErrors = append(allErrors, opts.ModelOptions.Validate())
+	errs := validation.Struct(opts)
+
+	if opts.BaseModelId != nil && opts.ModelOptions != nil {
+		errs.Add("model_options", "only model options or base model can be specified for training")
 	}
 
-	allErrors = append(allErrors, opts.Data.Validate())
-	allErrors = append(allErrors, opts.TrainOptions.Validate())
-	allErrors = append(allErrors, opts.JobOptions.Validate())
+	errs.Merge("train_options", opts.TrainOptions.ValidateHoldout(opts.Data.TestFiles))
 
-	return errors.Join(allErrors...)
+	return errs.Err()
 }
 
 func (s *TrainService) TrainNlpToken(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +50,7 @@ func (s *TrainService) TrainNlpToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := options.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), err)
 		return
 	}
 
@@ -69,17 +60,49 @@ func (s *TrainService) TrainNlpToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.SupervisedFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
+	args, err := s.nlpTokenTrainArgs(user.Id, options)
+	if err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.TestFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
-		return
+	s.basicTraining(w, r, args)
+}
+
+func (s *TrainService) validateNlpUploads(userId uuid.UUID, data config.NlpData) ([]schema.ModelDataset, error) {
+	return s.resolveTrainFiles(userId, data.SupervisedFiles, data.TestFiles)
+}
+
+// nlpUploadCsvOptions returns the options used to read the header of csv
+// uploads. Without csv options the train job reads the files with the delimiter
+// of the model.
+func nlpUploadCsvOptions(data config.NlpData, delimiter string) config.CSVOptions {
+	if data.CsvOptions != nil {
+		return *data.CsvOptions
+	}
+	return config.CSVOptions{Delimiter: delimiter}
+}
+
+func (s *TrainService) nlpTokenTrainArgs(userId uuid.UUID, options NlpTokenTrainRequest) (basicTrainArgs, error) {
+	datasets, err := s.validateNlpUploads(userId, options.Data)
+	if err != nil {
+		return basicTrainArgs{}, err
+	}
+
+	if opts := options.ModelOptions; opts != nil {
+		csvOptions := nlpUploadCsvOptions(options.Data, ",")
+		if err := s.validateUploadColumns(options.Data.SupervisedFiles, csvOptions, opts.SourceColumn, opts.TargetColumn); err != nil {
+			return basicTrainArgs{}, err
+		}
+		if err := s.validateUploadColumns(options.Data.TestFiles, csvOptions, opts.SourceColumn, opts.TargetColumn); err != nil {
+			return basicTrainArgs{}, err
+		}
+		if err := s.validateGroupColumn(options.Data, options.TrainOptions, csvOptions); err != nil {
+			return basicTrainArgs{}, err
+		}
 	}
 
-	s.basicTraining(w, r, basicTrainArgs{
+	args := basicTrainArgs{
 		modelName:    options.ModelName,
 		modelType:    schema.NlpTokenModel,
 		baseModelId:  options.BaseModelId,
@@ -87,42 +110,53 @@ func (s *TrainService) TrainNlpToken(w http.ResponseWriter, r *http.Request) {
 		data:         options.Data,
 		trainOptions: options.TrainOptions,
 		jobOptions:   options.JobOptions,
-	})
+		datasets:     datasets,
+	}
+
+	if options.TrainOptions.TestSplitStrategy == config.TestSplitStratified {
+		opts := options.ModelOptions
+		if opts == nil {
+			return basicTrainArgs{}, CodedError(errors.New("a stratified test split requires model_options to be specified"), http.StatusUnprocessableEntity)
+		}
+		columns := splitColumns{source: opts.SourceColumn, target: opts.TargetColumn, delimiter: ",", defaultTag: opts.DefaultTag}
+		if err := s.setStratifiedSplit(&args, options.Data, options.TrainOptions, columns); err != nil {
+			return basicTrainArgs{}, err
+		}
+	}
+
+	return args, nil
 }
 
 type NlpTextTrainRequest struct {
-	ModelName         string                 `json:"model_name"`
+	ModelName         string                 `json:"model_name" validate:"required"`
 	BaseModelId       *uuid.UUID             `json:"base_model_id"`
 	DocClassification bool                   `json:"doc_classification"`
-	ModelOptions      *config.NlpTextOptions `json:"model_options"`
+	ModelOptions      *config.NlpTextOptions `json:"model_options" validate:"-"`
 	Data              config.NlpData         `json:"data"`
 	TrainOptions      config.NlpTrainOptions `json:"train_options"`
 	JobOptions        config.JobOptions      `json:"job_options"`
 }
 
 func (opts *NlpTextTrainRequest) validate() error {
-	allErrors := make([]error, 0)
-
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
+	if opts.ModelOptions == nil && opts.BaseModelId == nil {
+		opts.ModelOptions = new(config.NlpTextOptions)
 	}
 
+	errs := validation.Struct(opts)
+
 	if opts.BaseModelId != nil && opts.ModelOptions != nil {
-		allErrors = append(allErrors, fmt.Errorf("only model options or base model can be specified for training"))
-	}
-	if opts.ModelOptions == nil && opts.BaseModelId == nil {
-		opts.ModelOptions = new(config.NlpTextOptions)
+		errs.Add("model_options", "only model options or base model can be specified for training")
+	} else if opts.ModelOptions != nil {
+		errs.Merge("model_options", opts.ModelOptions.Validate(opts.DocClassification))
 	}
 
-	if opts.ModelOptions != nil {
-		allErrors = append(allErrors, opts.ModelOptions.Validate(opts.DocClassification))
+	if opts.DocClassification && opts.TrainOptions.Evaluation != nil {
+		errs.Add("train_options.evaluation", "evaluation cannot be used for doc classification")
 	}
 
-	allErrors = append(allErrors, opts.Data.Validate())
-	allErrors = append(allErrors, opts.TrainOptions.Validate())
-	allErrors = append(allErrors, opts.JobOptions.Validate())
+	errs.Merge("train_options", opts.TrainOptions.ValidateHoldout(opts.Data.TestFiles))
 
-	return errors.Join(allErrors...)
+	return errs.Err()
 }
 
 func (s *TrainService) TrainNlpText(w http.ResponseWriter, r *http.Request) {
@@ -132,7 +166,7 @@ func (s *TrainService) TrainNlpText(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := options.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start nlp-text training, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start nlp-text training, found the following errors: %v", err), err)
 		return
 	}
 
@@ -142,14 +176,47 @@ func (s *TrainService) TrainNlpText(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.SupervisedFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
+	args, err := s.nlpTextTrainArgs(user.Id, options)
+	if err != nil {
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	if err := s.validateUploads(user.Id, options.Data.TestFiles); err != nil {
-		http.Error(w, fmt.Sprintf("invalid uploads specified: %v", err), GetResponseCode(err))
-		return
+	s.basicTraining(w, r, args)
+}
+
+// validateGroupColumn checks that uploaded holdout data has the group column of
+// the evaluation options. The holdout data is the test files if specified,
+// otherwise it is split from the train files.
+func (s *TrainService) validateGroupColumn(data config.NlpData, opts config.NlpTrainOptions, csvOptions config.CSVOptions) error {
+	if opts.Evaluation == nil || opts.Evaluation.GroupColumn == "" {
+		return nil
+	}
+	files := data.TestFiles
+	if len(files) == 0 {
+		files = data.SupervisedFiles
+	}
+	return s.validateUploadColumns(files, csvOptions, opts.Evaluation.GroupColumn)
+}
+
+func (s *TrainService) nlpTextTrainArgs(userId uuid.UUID, options NlpTextTrainRequest) (basicTrainArgs, error) {
+	datasets, err := s.validateNlpUploads(userId, options.Data)
+	if err != nil {
+		return basicTrainArgs{}, err
+	}
+
+	// The uploads for document classification are directories of documents.
+	if opts := options.ModelOptions; opts != nil && !options.DocClassification {
+		csvOptions := nlpUploadCsvOptions(options.Data, opts.Delimiter)
+		if err := s.validateUploadColumns(options.Data.SupervisedFiles, csvOptions, opts.TextColumn, opts.LabelColumn); err != nil {
+			return basicTrainArgs{}, err
+		}
+		if err := s.validateUploadColumns(options.Data.TestFiles, csvOptions, opts.TextColumn, opts.LabelColumn); err != nil {
+			return basicTrainArgs{}, err
+		}
+		if err := s.validateGroupColumn(options.Data, options.TrainOptions, csvOptions); err != nil {
+			return basicTrainArgs{}, err
+		}
 	}
 
 	var modelType string
@@ -159,7 +226,7 @@ func (s *TrainService) TrainNlpText(w http.ResponseWriter, r *http.Request) {
 		modelType = schema.NlpTextModel
 	}
 
-	s.basicTraining(w, r, basicTrainArgs{
+	args := basicTrainArgs{
 		modelName:    options.ModelName,
 		modelType:    modelType,
 		baseModelId:  options.BaseModelId,
@@ -167,107 +234,71 @@ func (s *TrainService) TrainNlpText(w http.ResponseWriter, r *http.Request) {
 		data:         options.Data,
 		trainOptions: options.TrainOptions,
 		jobOptions:   options.JobOptions,
-	})
+		datasets:     datasets,
+	}
+
+	if options.TrainOptions.TestSplitStrategy == config.TestSplitStratified {
+		opts := options.ModelOptions
+		if opts == nil || options.DocClassification {
+			return basicTrainArgs{}, CodedError(errors.New("a stratified test split requires model_options to be specified and cannot be used for doc classification"), http.StatusUnprocessableEntity)
+		}
+		columns := splitColumns{source: opts.TextColumn, target: opts.LabelColumn, delimiter: opts.Delimiter}
+		if err := s.setStratifiedSplit(&args, options.Data, options.TrainOptions, columns); err != nil {
+			return basicTrainArgs{}, err
+		}
+	}
+
+	return args, nil
 }
 
 type validateDocDirRequest struct {
 	Filenames []string `json:"filenames"`
+
+	// If specified the files in the upload are validated instead of the filenames
+	// provided by the client.
+	UploadId *uuid.UUID `json:"upload_id"`
 }
 
 type validateDocDirResponse struct {
-	IsValid    bool                `json:"is_valid"`
-	Msg        string              `json:"msg"`
-	Categories map[string][]string `json:"categories"`
+	IsValid      bool                `json:"is_valid"`
+	Msg          string              `json:"msg"`
+	Categories   map[string][]string `json:"categories"`
+	InvalidFiles map[string]string   `json:"invalid_files,omitempty"`
 }
 
-// TODO(Anyone): this seems like it should just be done in the frontend, not sure why it's in the backend.
 func (s *TrainService) VerifyDocDir(w http.ResponseWriter, r *http.Request) {
 	var params validateDocDirRequest
 	if !utils.ParseRequestBody(w, r, &params) {
 		return
 	}
 
-	invalidFileTypes := []string{}
-	for _, filename := range params.Filenames {
-		ext := filepath.Ext(filename)
-		switch ext {
-		case ".pdf":
-			break
-		default:
-			invalidFileTypes = append(invalidFileTypes, filename)
-		}
-	}
-
-	if len(invalidFileTypes) > 0 {
-		res := validateDocDirResponse{
-			IsValid: false,
-			Msg:     fmt.Sprintf("Invalid file formats found: %s. Only .pdf files are supported.", strings.Join(invalidFileTypes, ", ")),
-		}
-		utils.WriteJsonResponse(w, res)
+	if params.UploadId == nil {
+		utils.WriteJsonResponse(w, validateDocDirFilenames(params.Filenames))
 		return
 	}
 
-	invalidFilePaths := []string{}
-	categories := map[string][]string{}
-	for _, filename := range params.Filenames {
-		root, category := filepath.Split(filepath.Dir(filename))
-		extraDir, _ := filepath.Split(root)
-		if root == "" || category == "" || extraDir != "" {
-			invalidFilePaths = append(invalidFilePaths, filename)
-		} else {
-			if _, ok := categories[category]; !ok {
-				categories[category] = []string{}
-			}
-			categories[category] = append(categories[category], filename)
-		}
-	}
-
-	if len(invalidFilePaths) > 0 {
-		res := validateDocDirResponse{
-			IsValid: false,
-			Msg:     fmt.Sprintf("Invalid directory structure detected. Files must be in the format 'root_directory/category/filename'. Invalid files: %s", strings.Join(invalidFilePaths, ", ")),
-		}
-		utils.WriteJsonResponse(w, res)
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if len(categories) < 2 {
-		res := validateDocDirResponse{
-			IsValid:    false,
-			Msg:        "At least two distinct categories must be present",
-			Categories: categories,
-		}
-		utils.WriteJsonResponse(w, res)
+	if err := s.validateUploads(user.Id, []config.TrainFile{{Path: params.UploadId.String(), Location: config.FileLocUpload}}); err != nil {
+		writeError(w, fmt.Sprintf("invalid upload specified: %v", err), err)
 		return
 	}
 
-	insufficientCategories := []string{}
-	for category, examples := range categories {
-		if len(examples) < 10 {
-			insufficientCategories = append(insufficientCategories, category)
-		}
-	}
-
-	if len(insufficientCategories) > 0 {
-		res := validateDocDirResponse{
-			IsValid:    false,
-			Msg:        fmt.Sprintf("All categories must have at least 10 example documents. The following categories have fewer: %s", strings.Join(insufficientCategories, ", ")),
-			Categories: categories,
-		}
-		utils.WriteJsonResponse(w, res)
+	res, err := validateDocDirUpload(s.storage, *params.UploadId)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error validating upload: %v", err), err)
 		return
 	}
 
-	res := validateDocDirResponse{
-		IsValid:    true,
-		Msg:        "Directory structure is valid",
-		Categories: categories,
-	}
 	utils.WriteJsonResponse(w, res)
 }
 
 type NlpTrainDatagenRequest struct {
-	ModelName   string     `json:"model_name"`
+	ModelName   string     `json:"model_name" validate:"required"`
 	BaseModelId *uuid.UUID `json:"base_model_id"`
 
 	TaskPrompt  string  `json:"task_prompt"`
@@ -303,12 +334,6 @@ func (opts *NlpTrainDatagenRequest) modelOptions() interface{} {
 }
 
 func (opts *NlpTrainDatagenRequest) validate() error {
-	allErrors := make([]error, 0)
-
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
-	}
-
 	if opts.LlmProvider == "" {
 		opts.LlmProvider = "openai"
 	}
@@ -317,26 +342,17 @@ func (opts *NlpTrainDatagenRequest) validate() error {
 		opts.TestSize = 0.05
 	}
 
+	errs := validation.Struct(opts)
+
 	if opts.TokenOptions != nil && opts.TextOptions != nil {
-		allErrors = append(allErrors, fmt.Errorf("cannot specify both 'token_options' and 'text_options'"))
+		errs.Add("text_options", "cannot specify both 'token_options' and 'text_options'")
 	}
 
 	if opts.TokenOptions == nil && opts.TextOptions == nil {
-		allErrors = append(allErrors, fmt.Errorf("must specify one of 'token_options' or 'text_options'"))
-	}
-
-	if opts.TokenOptions != nil {
-		allErrors = append(allErrors, opts.TokenOptions.Validate())
-	}
-
-	if opts.TextOptions != nil {
-		allErrors = append(allErrors, opts.TextOptions.Validate())
+		errs.Add("token_options", "must specify one of 'token_options' or 'text_options'")
 	}
 
-	allErrors = append(allErrors, opts.TrainOptions.Validate())
-	allErrors = append(allErrors, opts.JobOptions.Validate())
-
-	return errors.Join(allErrors...)
+	return errs.Err()
 }
 
 func (s *TrainService) TrainNlpDatagen(w http.ResponseWriter, r *http.Request) {
@@ -346,7 +362,7 @@ func (s *TrainService) TrainNlpDatagen(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := params.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), err)
 		return
 	}
 
@@ -360,9 +376,15 @@ func (s *TrainService) TrainNlpDatagen(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("starting datagen training", "model_type", params.modelType(), "model_id", modelId, "model_name", params.ModelName)
 
-	license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, params.JobOptions.CpuUsageMhz())
+	// Datagen train jobs are never queued.
+	if err := checkTrainJobLimit(s.db, s.variables.UserJobLimits, user.Id); err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	license, err := verifyLicenseForUserJob(s.db, s.orchestratorClient, s.license, user.Id, params.JobOptions.CpuUsageMhz())
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -412,7 +434,7 @@ func (s *TrainService) TrainNlpDatagen(w http.ResponseWriter, r *http.Request) {
 
 	err = s.createModelAndStartDatagenTraining(params.ModelName, user, trainConfig, datagenConfig)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to start training: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("unable to start training: %v", err), err)
 		return
 	}
 
@@ -422,7 +444,7 @@ func (s *TrainService) TrainNlpDatagen(w http.ResponseWriter, r *http.Request) {
 }
 
 type NlpTokenRetrainRequest struct {
-	ModelName   string    `json:"model_name"`
+	ModelName   string    `json:"model_name" validate:"required"`
 	BaseModelId uuid.UUID `json:"base_model_id"`
 
 	LlmProvider string  `json:"llm_provider"`
@@ -433,12 +455,6 @@ type NlpTokenRetrainRequest struct {
 }
 
 func (opts *NlpTokenRetrainRequest) validate() error {
-	allErrors := make([]error, 0)
-
-	if opts.ModelName == "" {
-		allErrors = append(allErrors, fmt.Errorf("model name must be specified"))
-	}
-
 	if opts.LlmProvider == "" {
 		opts.LlmProvider = "openai"
 	}
@@ -447,10 +463,7 @@ func (opts *NlpTokenRetrainRequest) validate() error {
 		opts.TestSize = 0.05
 	}
 
-	allErrors = append(allErrors, opts.TrainOptions.Validate())
-	allErrors = append(allErrors, opts.JobOptions.Validate())
-
-	return errors.Join(allErrors...)
+	return validation.Struct(opts).Err()
 }
 
 func (s *TrainService) NlpTokenRetrain(w http.ResponseWriter, r *http.Request) {
@@ -460,7 +473,7 @@ func (s *TrainService) NlpTokenRetrain(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := params.validate(); err != nil {
-		http.Error(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("unable to start nlp-token training, found the following errors: %v", err), err)
 		return
 	}
 
@@ -470,21 +483,34 @@ func (s *TrainService) NlpTokenRetrain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	modelId, err := s.startNlpTokenRetrain(user, params)
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, trainResponse{ModelId: modelId})
+}
+
+func (s *TrainService) startNlpTokenRetrain(user schema.User, params NlpTokenRetrainRequest) (uuid.UUID, error) {
 	modelId := uuid.New()
 
 	slog.Info("starting datagen retraining", "model_type", schema.NlpTokenModel, "model_id", modelId, "model_name", params.ModelName)
 
-	license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, params.JobOptions.CpuUsageMhz())
+	// Datagen train jobs are never queued.
+	if err := checkTrainJobLimit(s.db, s.variables.UserJobLimits, user.Id); err != nil {
+		return uuid.Nil, err
+	}
+
+	license, err := verifyLicenseForUserJob(s.db, s.orchestratorClient, s.license, user.Id, params.JobOptions.CpuUsageMhz())
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
-		return
+		return uuid.Nil, err
 	}
 
 	jobToken, err := s.jobAuth.CreateModelJwt(modelId, time.Hour*1000*24)
 	if err != nil {
 		slog.Error("error creating job token for train job", "error", err)
-		http.Error(w, "error setting up train job", http.StatusInternalServerError)
-		return
+		return uuid.Nil, CodedError(errors.New("error setting up train job"), http.StatusInternalServerError)
 	}
 
 	storageDir, data := s.getDatagenData(modelId)
@@ -528,13 +554,12 @@ func (s *TrainService) NlpTokenRetrain(w http.ResponseWriter, r *http.Request) {
 
 	err = s.createModelAndStartDatagenTraining(params.ModelName, user, trainConfig, datagenConfig)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to start training: %v", err), GetResponseCode(err))
-		return
+		return uuid.Nil, CodedError(fmt.Errorf("unable to start training: %w", err), GetResponseCode(err))
 	}
 
 	slog.Info("started datagen retraining successfully", "model_type", schema.NlpTokenModel, "model_id", modelId, "model_name", params.ModelName)
 
-	utils.WriteJsonResponse(w, trainResponse{ModelId: modelId})
+	return modelId, nil
 }
 
 func (s *TrainService) createModelAndStartDatagenTraining(
@@ -555,11 +580,17 @@ func (s *TrainService) createModelAndStartDatagenTraining(
 		return err
 	}
 
+	extraEnv, err := s.variables.jobEnv(s.db, s.storage, trainJobEnv)
+	if err != nil {
+		return err
+	}
+
 	model := newModel(trainConfig.ModelId, modelName, trainConfig.ModelType, trainConfig.BaseModelId, user.Id)
 
 	job := orchestrator.DatagenTrainJob{
 		TrainJob: orchestrator.TrainJob{
 			JobName:    model.TrainJobName(),
+			ModelId:    model.Id.String(),
 			ConfigPath: trainConfigPath,
 			Driver:     s.variables.BackendDriver,
 			Resources: orchestrator.Resources{
@@ -569,12 +600,17 @@ func (s *TrainService) createModelAndStartDatagenTraining(
 				AllocationMemoryMax: 60000,
 			},
 			CloudCredentials: s.variables.CloudCredentials,
+			Namespace:        s.variables.jobNamespace(model),
+			Priority:         orchestratorPriority(trainConfig.JobOptions.EffectivePriority()),
+			ExtraEnv:         extraEnv,
 		},
 		DatagenConfigPath: datagenConfigPath,
 		GenaiKey:          genaiKey,
 	}
 
-	return s.saveModelAndStartJob(model, user, job)
+	// Datagen jobs are not preempted, since they would be queued again as train
+	// jobs without the data they generate.
+	return s.saveModelAndStartJob(model, user, job, job.Resources, 0)
 }
 
 func (s *TrainService) getDatagenData(modelId uuid.UUID) (string, config.NlpData) {
@@ -590,6 +626,7 @@ func (s *TrainService) getDatagenData(modelId uuid.UUID) (string, config.NlpData
 		TestFiles: []config.TrainFile{
 			{Path: filepath.Join(storageDir, "test/test.csv"), Location: "local", Options: map[string]interface{}{}},
 		},
+		FileValidation: config.FileValidationStrict,
 	}
 
 	return storageDir, data
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/licensing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// queueTrainJob saves the model with status queued, along with the resources
+// of its train job, so that the job can be started by the status sync once
+// there is enough license capacity and the user is below their job limit.
+func (s *TrainService) queueTrainJob(model schema.Model, user schema.User, configPath string, jobOptions config.JobOptions) error {
+	model.TrainStatus = schema.Queued
+
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		if err := saveModel(txn, model, user); err != nil {
+			return err
+		}
+
+		queued := schema.QueuedTrainJob{
+			ModelId:          model.Id,
+			UserId:           user.Id,
+			ConfigPath:       configPath,
+			AllocationMhz:    jobOptions.CpuUsageMhz(),
+			AllocationMemory: jobOptions.AllocationMemory,
+			Priority:         jobOptions.EffectivePriority(),
+			Preempt:          jobOptions.Preempt,
+			QueuedAt:         time.Now().UTC(),
+		}
+		if result := txn.Create(&queued); result.Error != nil {
+			slog.Error("sql error queueing train job", "model_id", model.Id, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+
+		return nil
+	})
+}
+
+// setConfigLicenseKey adds the license key to a saved train config. The config
+// is updated as json so that fields the config types do not round trip are
+// left unchanged.
+func (s *TrainService) setConfigLicenseKey(modelId uuid.UUID, licenseKey string) error {
+	configPath := filepath.Join(storage.ModelPath(modelId), "train_config.json")
+
+	file, err := s.storage.Read(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading train config: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("error reading train config: %w", err)
+	}
+
+	var trainConfig map[string]interface{}
+	if err := json.Unmarshal(data, &trainConfig); err != nil {
+		return fmt.Errorf("error parsing train config: %w", err)
+	}
+	trainConfig["license_key"] = licenseKey
+
+	data, err = json.MarshalIndent(trainConfig, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error encoding train config: %w", err)
+	}
+	if err := s.storage.Write(configPath, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error saving train config: %w", err)
+	}
+	return nil
+}
+
+// startQueuedJob starts the train job for a queued model. The job is removed
+// from the queue even if it fails to start, in which case the model is marked
+// as failed.
+func (s *TrainService) startQueuedJob(queued schema.QueuedTrainJob, licenseKey string) error {
+	model, err := schema.GetModel(queued.ModelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return s.db.Delete(&queued).Error
+		}
+		return err
+	}
+
+	if err := s.setConfigLicenseKey(model.Id, licenseKey); err != nil {
+		return err
+	}
+
+	job, err := s.trainJob(model, queued.ConfigPath, queued.AllocationMhz, queued.AllocationMemory, queued.Priority)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		if err := txn.Delete(&queued).Error; err != nil {
+			return err
+		}
+		return startJobRun(txn, "train", model.Id, job.GetJobName(), job.Resources, queued.Priority)
+	})
+	if err != nil {
+		return err
+	}
+
+	status, message := schema.Starting, ""
+	if err := s.orchestratorClient.StartJob(job); err != nil {
+		slog.Error("error starting queued train job", "model_id", model.Id, "error", err)
+		status, message = schema.Failed, startErrorMessage(err)
+		if err := updateJobRun(s.db, "train", model.Id, schema.Failed, message); err != nil {
+			slog.Error("error recording failed train job run", "model_id", model.Id, "error", err)
+		}
+	}
+
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		err := updateStatus(txn, "train", &model, status, message)
+		if errors.Is(err, errStatusChanged) {
+			return nil
+		}
+		return err
+	})
+}
+
+// preemptionGracePeriod is how long a queued job waits for the jobs it preempted
+// to stop before it preempts more jobs, since the orchestrator counts the cpu of
+// the preempted jobs until they exit.
+const preemptionGracePeriod = 2 * time.Minute
+
+// preemptTrainJobs stops running train jobs with a lower priority than the
+// queued job until it fits in the license cpu limit, starting with the lowest
+// priority and most recently started jobs. The stopped jobs are queued again
+// and start from the beginning once there is capacity. No jobs are stopped if
+// stopping every lower priority job would not make enough room.
+func (s *TrainService) preemptTrainJobs(queued schema.QueuedTrainJob) error {
+	if queued.PreemptedAt != nil && time.Since(*queued.PreemptedAt) < preemptionGracePeriod {
+		return nil
+	}
+
+	var runs []schema.JobRun
+	result := s.db.
+		Where("job = ? AND ended_at IS NULL AND priority > 0 AND priority < ?", "train", queued.Priority).
+		Order("priority").Order("started_at DESC").
+		Find(&runs)
+	if result.Error != nil {
+		return fmt.Errorf("error listing running train jobs: %w", result.Error)
+	}
+
+	usage, err := s.orchestratorClient.TotalCpuUsage()
+	if err != nil {
+		return err
+	}
+
+	fits := func() (bool, error) {
+		_, err := s.license.Verify(usage + queued.AllocationMhz)
+		if errors.Is(err, licensing.ErrCpuLimitExceeded) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	var preempted []schema.JobRun
+	for _, run := range runs {
+		if ok, err := fits(); ok || err != nil {
+			break
+		}
+		preempted = append(preempted, run)
+		usage -= run.AllocationMhz
+	}
+	if ok, err := fits(); !ok {
+		return err
+	}
+
+	for _, run := range preempted {
+		if err := s.requeuePreemptedJob(run, queued.ModelId); err != nil {
+			return err
+		}
+		slog.Info("status sync: preempted train job", "model_id", run.ModelId, "priority", run.Priority, "preempted_by", queued.ModelId)
+	}
+
+	if err := s.db.Model(&queued).Update("preempted_at", time.Now().UTC()).Error; err != nil {
+		return fmt.Errorf("error updating queued train job: %w", err)
+	}
+	return nil
+}
+
+// requeuePreemptedJob stops a running train job and queues it again with the
+// same resources and priority.
+func (s *TrainService) requeuePreemptedJob(run schema.JobRun, preemptedBy uuid.UUID) error {
+	model, err := schema.GetModel(run.ModelId, s.db, false, false, false)
+	if err != nil {
+		return err
+	}
+
+	if err := s.orchestratorClient.StopJob(run.JobName); err != nil {
+		return fmt.Errorf("error stopping preempted train job: %w", err)
+	}
+
+	message := fmt.Sprintf("preempted by the train job of model %v", preemptedBy)
+	return s.db.Transaction(func(txn *gorm.DB) error {
+		if err := updateJobRun(txn, "train", model.Id, schema.Stopped, message); err != nil {
+			return err
+		}
+		if err := updateStatus(txn, "train", &model, schema.Queued, message); err != nil {
+			return err
+		}
+
+		queued := schema.QueuedTrainJob{
+			ModelId:          model.Id,
+			UserId:           model.UserId,
+			ConfigPath:       filepath.Join(s.storage.Location(), storage.ModelPath(model.Id), "train_config.json"),
+			AllocationMhz:    run.AllocationMhz,
+			AllocationMemory: run.AllocationMemory,
+			Priority:         run.Priority,
+			QueuedAt:         time.Now().UTC(),
+		}
+		if result := txn.Create(&queued); result.Error != nil {
+			slog.Error("sql error queueing preempted train job", "model_id", model.Id, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+}
+
+// startQueuedJobs starts queued train jobs in order of priority, and then in
+// the order they were queued, until the license cpu limit is reached. Later
+// jobs are not started before earlier ones, even if they would fit, so that
+// large jobs are not starved. Jobs of users that are running their maximum
+// number of train jobs are skipped. If the next job can preempt other jobs,
+// lower priority jobs are stopped to make room for it.
+func (s *TrainService) startQueuedJobs() {
+	var queue []schema.QueuedTrainJob
+	if err := s.db.Order("priority DESC").Order("queued_at").Order("model_id").Find(&queue).Error; err != nil {
+		slog.Error("status sync: sql error querying queued train jobs", "error", err)
+		return
+	}
+
+	for _, queued := range queue {
+		if err := checkTrainJobLimit(s.db, s.variables.UserJobLimits, queued.UserId); err != nil {
+			if !errors.Is(err, ErrUserJobLimitReached) {
+				slog.Error("status sync: error checking job limit for queued train job", "model_id", queued.ModelId, "error", err)
+			}
+			// A user that is at their limit does not block the jobs of other users.
+			continue
+		}
+
+		if err := checkOrganizationCpuAllocation(s.db, queued.UserId, queued.AllocationMhz); err != nil {
+			if !errors.Is(err, ErrOrgCpuAllocationExceeded) {
+				slog.Error("status sync: error checking organization cpu for queued train job", "model_id", queued.ModelId, "error", err)
+			}
+			// An organization that is using its cpu allocation does not block the
+			// jobs of other organizations.
+			continue
+		}
+
+		license, err := verifyLicenseForNewJob(s.orchestratorClient, s.license, queued.AllocationMhz)
+		if err != nil {
+			if !errors.Is(err, licensing.ErrCpuLimitExceeded) {
+				slog.Error("status sync: error verifying license for queued train job", "model_id", queued.ModelId, "error", err)
+			} else if queued.Preempt {
+				if err := s.preemptTrainJobs(queued); err != nil {
+					slog.Error("status sync: error preempting train jobs", "model_id", queued.ModelId, "error", err)
+				}
+			}
+			return
+		}
+
+		if err := s.startQueuedJob(queued, license); err != nil {
+			slog.Error("status sync: error starting queued train job", "model_id", queued.ModelId, "error", err)
+			return
+		}
+
+		slog.Info("status sync: started queued train job", "model_id", queued.ModelId)
+	}
+}
+
+type QueuedTrainJobInfo struct {
+	Position  int       `json:"position"`
+	ModelId   uuid.UUID `json:"model_id"`
+	ModelName string    `json:"model_name"`
+	ModelType string    `json:"model_type"`
+	UserId    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	CpuMhz    int       `json:"cpu_mhz"`
+	MemoryMb  int       `json:"memory_mb"`
+	Priority  int       `json:"priority"`
+	Preempt   bool      `json:"preempt"`
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+// TrainQueue lists the queued train jobs in the order they will be started.
+func (s *TrainService) TrainQueue(w http.ResponseWriter, r *http.Request) {
+	type queueRow struct {
+		schema.QueuedTrainJob
+		ModelName string
+		ModelType string
+		Username  string
+	}
+
+	var rows []queueRow
+	result := s.db.Table("queued_train_jobs").
+		Select("queued_train_jobs.*, models.name AS model_name, models.type AS model_type, users.username").
+		Joins("JOIN models ON models.id = queued_train_jobs.model_id").
+		Joins("JOIN users ON users.id = queued_train_jobs.user_id").
+		Order("queued_train_jobs.priority DESC").Order("queued_train_jobs.queued_at").Order("queued_train_jobs.model_id").
+		Scan(&rows)
+	if result.Error != nil {
+		slog.Error("sql error listing queued train jobs", "error", result.Error)
+		http.Error(w, schema.ErrDbAccessFailed.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]QueuedTrainJobInfo, 0, len(rows))
+	for i, row := range rows {
+		infos = append(infos, QueuedTrainJobInfo{
+			Position:  i + 1,
+			ModelId:   row.ModelId,
+			ModelName: row.ModelName,
+			ModelType: row.ModelType,
+			UserId:    row.UserId,
+			Username:  row.Username,
+			CpuMhz:    row.AllocationMhz,
+			MemoryMb:  row.AllocationMemory,
+			Priority:  row.Priority,
+			Preempt:   row.Preempt,
+			QueuedAt:  row.QueuedAt.UTC(),
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
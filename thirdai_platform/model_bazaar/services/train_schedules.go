@@ -0,0 +1,551 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/cron"
+	"thirdai_platform/utils/validation"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ScheduleRunStarted = "started"
+	ScheduleRunSkipped = "skipped"
+	ScheduleRunFailed  = "failed"
+
+	// The number of runs returned in the info for a schedule.
+	maxScheduleRunsReturned = 100
+)
+
+func init() {
+	validation.Register("cron", func(value reflect.Value, param string) string {
+		if _, err := cron.Parse(value.String()); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+}
+
+type TrainScheduleRequest struct {
+	ModelId uuid.UUID `json:"model_id" validate:"required"`
+	// The retrained models are named model_name followed by the time of the
+	// run, the name of the model is used if it is not specified.
+	ModelName string `json:"model_name" validate:"max=80"`
+	// A cron spec in UTC, for example "0 2 * * *" to retrain daily at 2:00.
+	Cron string `json:"cron" validate:"required,cron"`
+	// The fields of the ndb-retrain or nlp-token-retrain request for the model,
+	// other than model_name and base_model_id which are set for each run.
+	Options json.RawMessage `json:"options"`
+}
+
+type trainScheduleResponse struct {
+	ScheduleId uuid.UUID `json:"schedule_id"`
+	NextRunAt  time.Time `json:"next_run_at"`
+}
+
+func scheduledModelName(prefix string, runAt time.Time) string {
+	return fmt.Sprintf("%v-%v", prefix, runAt.UTC().Format("20060102-150405"))
+}
+
+// scheduledRetrainRequest returns the validated retrain request for a run of a
+// schedule, which is either an NdbRetrainRequest or an NlpTokenRetrainRequest.
+func scheduledRetrainRequest(modelType string, options []byte, modelName string, baseModelId uuid.UUID) (interface{}, error) {
+	if len(options) == 0 {
+		options = []byte("{}")
+	}
+
+	switch modelType {
+	case schema.NdbModel:
+		var request NdbRetrainRequest
+		if err := decodeStrict(options, &request); err != nil {
+			return nil, fmt.Errorf("invalid retrain options: %w", err)
+		}
+		request.ModelName, request.BaseModelId = modelName, baseModelId
+		if err := request.validate(); err != nil {
+			return nil, err
+		}
+		return request, nil
+
+	case schema.NlpTokenModel:
+		var request NlpTokenRetrainRequest
+		if err := decodeStrict(options, &request); err != nil {
+			return nil, fmt.Errorf("invalid retrain options: %w", err)
+		}
+		request.ModelName, request.BaseModelId = modelName, baseModelId
+		if err := request.validate(); err != nil {
+			return nil, err
+		}
+		return request, nil
+
+	default:
+		return nil, fmt.Errorf("scheduled retraining is only supported for %v and %v models", schema.NdbModel, schema.NlpTokenModel)
+	}
+}
+
+func (s *TrainService) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var params TrainScheduleRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid train schedule: %v", err), err)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	model, err := schema.GetModel(params.ModelId, s.db, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	perm, err := auth.GetModelPermissions(model.Id, user, s.db)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error retrieving permissions for model %v: %v", model.Id, err), http.StatusInternalServerError)
+		return
+	}
+	if perm < auth.ReadPermission {
+		http.Error(w, fmt.Sprintf("user %v does not have permission to access model %v", user.Id, model.Id), http.StatusForbidden)
+		return
+	}
+
+	if params.ModelName == "" {
+		params.ModelName = model.Name
+	}
+
+	now := time.Now().UTC()
+
+	if _, err := scheduledRetrainRequest(model.Type, params.Options, scheduledModelName(params.ModelName, now), model.Id); err != nil {
+		var errs validation.Errors
+		errs.Merge("options", err)
+		utils.WriteValidationError(w, fmt.Sprintf("invalid train schedule: %v", err), errs)
+		return
+	}
+
+	cronSchedule, _ := cron.Parse(params.Cron)
+	nextRunAt := cronSchedule.Next(now)
+	if nextRunAt.IsZero() {
+		var errs validation.Errors
+		errs.Add("cron", "cron spec '%v' does not match any time in the next 5 years", params.Cron)
+		utils.WriteValidationError(w, fmt.Sprintf("invalid train schedule: %v", errs), errs)
+		return
+	}
+
+	options := "{}"
+	if len(params.Options) > 0 {
+		options = string(params.Options)
+	}
+
+	schedule := schema.TrainSchedule{
+		Id:        uuid.New(),
+		ModelId:   model.Id,
+		ModelType: model.Type,
+		UserId:    user.Id,
+		ModelName: params.ModelName,
+		Cron:      params.Cron,
+		Enabled:   true,
+		Options:   options,
+		NextRunAt: nextRunAt,
+		CreatedAt: now,
+	}
+
+	if err := s.db.Create(&schedule).Error; err != nil {
+		slog.Error("sql error creating train schedule", "model_id", model.Id, "error", err)
+		http.Error(w, fmt.Sprintf("error creating train schedule: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("created train schedule", "schedule_id", schedule.Id, "model_id", model.Id, "cron", schedule.Cron, "next_run_at", nextRunAt)
+
+	utils.WriteJsonResponse(w, trainScheduleResponse{ScheduleId: schedule.Id, NextRunAt: nextRunAt})
+}
+
+type trainScheduleRunInfo struct {
+	RunAt   time.Time  `json:"run_at"`
+	Status  string     `json:"status"`
+	ModelId *uuid.UUID `json:"model_id"`
+	// The current train status of the model created by the run.
+	TrainStatus string `json:"train_status,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+type trainScheduleInfo struct {
+	ScheduleId uuid.UUID       `json:"schedule_id"`
+	ModelId    uuid.UUID       `json:"model_id"`
+	ModelType  string          `json:"model_type"`
+	UserId     uuid.UUID       `json:"user_id"`
+	ModelName  string          `json:"model_name"`
+	Cron       string          `json:"cron"`
+	Enabled    bool            `json:"enabled"`
+	Options    json.RawMessage `json:"options"`
+	NextRunAt  time.Time       `json:"next_run_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+
+	// The most recent runs, only included when getting a single schedule.
+	Runs []trainScheduleRunInfo `json:"runs,omitempty"`
+}
+
+func convertTrainSchedule(schedule schema.TrainSchedule) trainScheduleInfo {
+	return trainScheduleInfo{
+		ScheduleId: schedule.Id,
+		ModelId:    schedule.ModelId,
+		ModelType:  schedule.ModelType,
+		UserId:     schedule.UserId,
+		ModelName:  schedule.ModelName,
+		Cron:       schedule.Cron,
+		Enabled:    schedule.Enabled,
+		Options:    json.RawMessage(schedule.Options),
+		NextRunAt:  schedule.NextRunAt.UTC(),
+		CreatedAt:  schedule.CreatedAt.UTC(),
+	}
+}
+
+// ListSchedules returns the schedules created by the user, or all schedules
+// for admins.
+func (s *TrainService) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := s.db.Order("created_at")
+	if !user.IsAdmin {
+		query = query.Where("user_id = ?", user.Id)
+	}
+
+	var schedules []schema.TrainSchedule
+	if err := query.Find(&schedules).Error; err != nil {
+		slog.Error("sql error listing train schedules", "error", err)
+		http.Error(w, fmt.Sprintf("error listing train schedules: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]trainScheduleInfo, 0, len(schedules))
+	for _, schedule := range schedules {
+		infos = append(infos, convertTrainSchedule(schedule))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+// getUserSchedule returns the schedule in the url if it was created by the
+// user, or the user is an admin.
+func (s *TrainService) getUserSchedule(r *http.Request) (schema.TrainSchedule, schema.User, error) {
+	var schedule schema.TrainSchedule
+
+	scheduleId, err := utils.URLParamUUID(r, "schedule_id")
+	if err != nil {
+		return schedule, schema.User{}, CodedError(err, http.StatusBadRequest)
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		return schedule, user, CodedError(err, http.StatusInternalServerError)
+	}
+
+	if err := s.db.First(&schedule, "id = ?", scheduleId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return schedule, user, CodedError(fmt.Errorf("train schedule %v does not exist", scheduleId), http.StatusNotFound)
+		}
+		slog.Error("sql error retrieving train schedule", "schedule_id", scheduleId, "error", err)
+		return schedule, user, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if schedule.UserId != user.Id && !user.IsAdmin {
+		return schedule, user, CodedError(fmt.Errorf("user %v does not have permission to access train schedule %v", user.Id, scheduleId), http.StatusForbidden)
+	}
+
+	return schedule, user, nil
+}
+
+func (s *TrainService) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, _, err := s.getUserSchedule(r)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving train schedule: %v", err), err)
+		return
+	}
+
+	var runs []schema.TrainScheduleRun
+	if err := s.db.Order("run_at DESC").Limit(maxScheduleRunsReturned).Find(&runs, "schedule_id = ?", schedule.Id).Error; err != nil {
+		slog.Error("sql error listing train schedule runs", "schedule_id", schedule.Id, "error", err)
+		http.Error(w, fmt.Sprintf("error listing train schedule runs: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	modelIds := make([]uuid.UUID, 0, len(runs))
+	for _, run := range runs {
+		if run.ModelId != nil {
+			modelIds = append(modelIds, *run.ModelId)
+		}
+	}
+	var models []schema.Model
+	if err := s.db.Select("id", "train_status").Find(&models, "id IN ?", modelIds).Error; err != nil {
+		slog.Error("sql error retrieving train schedule models", "schedule_id", schedule.Id, "error", err)
+		http.Error(w, fmt.Sprintf("error retrieving train schedule models: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	trainStatuses := make(map[uuid.UUID]string, len(models))
+	for _, model := range models {
+		trainStatuses[model.Id] = model.TrainStatus
+	}
+
+	info := convertTrainSchedule(schedule)
+	info.Runs = make([]trainScheduleRunInfo, 0, len(runs))
+	for _, run := range runs {
+		runInfo := trainScheduleRunInfo{RunAt: run.RunAt.UTC(), Status: run.Status, ModelId: run.ModelId, Message: run.Message}
+		if run.ModelId != nil {
+			runInfo.TrainStatus = trainStatuses[*run.ModelId]
+		}
+		info.Runs = append(info.Runs, runInfo)
+	}
+
+	utils.WriteJsonResponse(w, info)
+}
+
+type updateTrainScheduleRequest struct {
+	Cron    *string `json:"cron" validate:"cron"`
+	Enabled *bool   `json:"enabled"`
+}
+
+func (s *TrainService) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, _, err := s.getUserSchedule(r)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving train schedule: %v", err), err)
+		return
+	}
+
+	var params updateTrainScheduleRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := validation.Struct(&params).Err(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid train schedule: %v", err), err)
+		return
+	}
+
+	if params.Cron != nil {
+		schedule.Cron = *params.Cron
+	}
+	if params.Enabled != nil {
+		schedule.Enabled = *params.Enabled
+	}
+
+	// The next run is recomputed so that a schedule that is enabled again does
+	// not immediately run for the times it was disabled.
+	cronSchedule, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid cron spec for train schedule: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	schedule.NextRunAt = cronSchedule.Next(time.Now().UTC())
+	if schedule.NextRunAt.IsZero() {
+		var errs validation.Errors
+		errs.Add("cron", "cron spec '%v' does not match any time in the next 5 years", schedule.Cron)
+		utils.WriteValidationError(w, fmt.Sprintf("invalid train schedule: %v", errs), errs)
+		return
+	}
+
+	result := s.db.Model(&schedule).Updates(map[string]interface{}{
+		"cron": schedule.Cron, "enabled": schedule.Enabled, "next_run_at": schedule.NextRunAt,
+	})
+	if result.Error != nil {
+		slog.Error("sql error updating train schedule", "schedule_id", schedule.Id, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error updating train schedule: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJsonResponse(w, convertTrainSchedule(schedule))
+}
+
+func deleteTrainSchedules(txn *gorm.DB, query string, args ...interface{}) error {
+	var scheduleIds []uuid.UUID
+	if err := txn.Model(&schema.TrainSchedule{}).Where(query, args...).Pluck("id", &scheduleIds).Error; err != nil {
+		slog.Error("sql error listing train schedules to delete", "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(scheduleIds) == 0 {
+		return nil
+	}
+
+	if err := txn.Delete(&schema.TrainScheduleRun{}, "schedule_id IN ?", scheduleIds).Error; err != nil {
+		slog.Error("sql error deleting train schedule runs", "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if err := txn.Delete(&schema.TrainSchedule{}, "id IN ?", scheduleIds).Error; err != nil {
+		slog.Error("sql error deleting train schedules", "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *TrainService) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, _, err := s.getUserSchedule(r)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error retrieving train schedule: %v", err), err)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		return deleteTrainSchedules(txn, "id = ?", schedule.Id)
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error deleting train schedule: %v", err), err)
+		return
+	}
+
+	slog.Info("deleted train schedule", "schedule_id", schedule.Id)
+
+	utils.WriteSuccess(w)
+}
+
+// previousScheduleRunInProgress returns the model created by the last run of
+// the schedule if it is still training.
+func (s *TrainService) previousScheduleRunInProgress(scheduleId uuid.UUID) (*uuid.UUID, error) {
+	var run schema.TrainScheduleRun
+	result := s.db.Order("run_at DESC").Limit(1).Find(&run, "schedule_id = ? AND model_id IS NOT NULL", scheduleId)
+	if result.Error != nil {
+		slog.Error("sql error retrieving last train schedule run", "schedule_id", scheduleId, "error", result.Error)
+		return nil, schema.ErrDbAccessFailed
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	var model schema.Model
+	result = s.db.Select("id", "train_status").Limit(1).Find(&model, "id = ?", *run.ModelId)
+	if result.Error != nil {
+		slog.Error("sql error retrieving model for train schedule run", "schedule_id", scheduleId, "error", result.Error)
+		return nil, schema.ErrDbAccessFailed
+	}
+	// Models that were deleted are not in progress.
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	switch model.TrainStatus {
+	case schema.NotStarted, schema.Queued, schema.Starting, schema.InProgress:
+		return run.ModelId, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *TrainService) startScheduledRetrain(schedule schema.TrainSchedule, runAt time.Time) (uuid.UUID, error) {
+	var user schema.User
+	if err := s.db.First(&user, "id = ?", schedule.UserId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, fmt.Errorf("user %v who created the schedule no longer exists", schedule.UserId)
+		}
+		slog.Error("sql error retrieving train schedule user", "schedule_id", schedule.Id, "error", err)
+		return uuid.Nil, schema.ErrDbAccessFailed
+	}
+
+	request, err := scheduledRetrainRequest(schedule.ModelType, []byte(schedule.Options), scheduledModelName(schedule.ModelName, runAt), schedule.ModelId)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	switch request := request.(type) {
+	case NdbRetrainRequest:
+		args, err := s.ndbRetrainArgs(request)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return s.startTraining(user, args)
+	case NlpTokenRetrainRequest:
+		return s.startNlpTokenRetrain(user, request)
+	default:
+		return uuid.Nil, fmt.Errorf("invalid retrain request for schedule")
+	}
+}
+
+// runSchedule starts the retrain for a schedule that is due, unless the retrain
+// from its previous run is still in progress, and records the run.
+func (s *TrainService) runSchedule(schedule schema.TrainSchedule, now time.Time) error {
+	cronSchedule, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid cron spec: %w", err)
+	}
+
+	// The run is claimed by moving the next run time forward before the retrain
+	// is started, so that it is not started twice by overlapping status syncs.
+	// Runs that were missed while model bazaar was down are not made up.
+	result := s.db.Model(&schema.TrainSchedule{}).
+		Where("id = ? AND next_run_at = ?", schedule.Id, schedule.NextRunAt).
+		Update("next_run_at", cronSchedule.Next(now))
+	if result.Error != nil {
+		slog.Error("sql error updating next run for train schedule", "schedule_id", schedule.Id, "error", result.Error)
+		return schema.ErrDbAccessFailed
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	run := schema.TrainScheduleRun{Id: uuid.New(), ScheduleId: schedule.Id, RunAt: now}
+
+	inProgress, err := s.previousScheduleRunInProgress(schedule.Id)
+	if err != nil {
+		return err
+	}
+
+	if inProgress != nil {
+		run.Status = ScheduleRunSkipped
+		run.Message = fmt.Sprintf("the retrain from the previous run, model %v, is still in progress", *inProgress)
+		slog.Info("skipping train schedule run since the previous run is in progress", "schedule_id", schedule.Id, "model_id", *inProgress)
+	} else if modelId, err := s.startScheduledRetrain(schedule, now); err != nil {
+		run.Status = ScheduleRunFailed
+		run.Message = err.Error()
+		slog.Error("error starting scheduled retrain", "schedule_id", schedule.Id, "error", err)
+	} else {
+		run.Status = ScheduleRunStarted
+		run.ModelId = &modelId
+		slog.Info("started scheduled retrain", "schedule_id", schedule.Id, "model_id", modelId)
+	}
+
+	if err := s.db.Create(&run).Error; err != nil {
+		slog.Error("sql error recording train schedule run", "schedule_id", schedule.Id, "error", err)
+		return schema.ErrDbAccessFailed
+	}
+
+	return nil
+}
+
+// runSchedules starts the retrains for the schedules that are due, this is
+// called from the status sync.
+func (s *TrainService) runSchedules() {
+	now := time.Now().UTC()
+
+	var schedules []schema.TrainSchedule
+	if err := s.db.Find(&schedules, "enabled = ? AND next_run_at <= ?", true, now).Error; err != nil {
+		slog.Error("status sync: sql error querying due train schedules", "error", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if err := s.runSchedule(schedule, now); err != nil {
+			slog.Error("status sync: error running train schedule", "schedule_id", schedule.Id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/parquet"
+	"thirdai_platform/model_bazaar/storage"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// splitRow is a row of nlp training data. The class is the label used to
+// stratify the split.
+type splitRow struct {
+	source, target, class string
+}
+
+// splitColumns describes the columns of the training data that is split. For
+// token classification the default tag is set, and the class of each row is
+// its least frequent tag.
+type splitColumns struct {
+	source, target string
+	delimiter      string
+	defaultTag     string
+}
+
+func (s *TrainService) readSplitRows(path, format string, csvOptions config.CSVOptions, columns splitColumns) ([]splitRow, error) {
+	var rows []splitRow
+
+	switch format {
+	case TrainFileParquet:
+		reader, size, cleanup, err := openReaderAt(s.storage, path)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+
+		file, err := parquet.Open(reader, size)
+		if err != nil {
+			return nil, err
+		}
+		err = file.ReadColumn(columns.source, func(value *string) error {
+			if value == nil {
+				return fmt.Errorf("row %d has a null value in column '%v'", len(rows), columns.source)
+			}
+			rows = append(rows, splitRow{source: *value})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		row := 0
+		err = file.ReadColumn(columns.target, func(value *string) error {
+			defer func() { row++ }()
+			if value == nil {
+				return fmt.Errorf("row %d has a null value in column '%v'", row, columns.target)
+			}
+			if row >= len(rows) {
+				return errors.New("source and target columns have a different number of values")
+			}
+			rows[row].target = *value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case TrainFileJsonl:
+		file, err := s.storage.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		reader := newJsonlReader(file)
+		for {
+			record, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, err
+			}
+
+			var row splitRow
+			for i, column := range []string{columns.source, columns.target} {
+				value, ok := record.values[column]
+				if !ok || value == nil {
+					return nil, fmt.Errorf("line %d is missing column '%v'", reader.line, column)
+				}
+				var text string
+				if tokens, ok := jsonlTokens(value); ok {
+					text = strings.Join(tokens, " ")
+				} else {
+					text = fmt.Sprint(value)
+				}
+				if i == 0 {
+					row.source = text
+				} else {
+					row.target = text
+				}
+			}
+			rows = append(rows, row)
+		}
+
+	default:
+		file, err := s.storage.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		reader, err := newCSVReader(file, csvOptions)
+		if err != nil {
+			return nil, err
+		}
+		header, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		sourceIdx, targetIdx, err := findCSVColumns(header, columns.source, columns.target)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			line, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, err
+			}
+			rows = append(rows, splitRow{source: line[sourceIdx], target: line[targetIdx]})
+		}
+	}
+
+	return rows, nil
+}
+
+func (c splitColumns) setClasses(rows []splitRow) {
+	if c.defaultTag == "" {
+		for i := range rows {
+			rows[i].class = rows[i].target
+		}
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, row := range rows {
+		for _, tag := range splitTokens(row.target) {
+			counts[tag]++
+		}
+	}
+	for i := range rows {
+		rows[i].class = c.defaultTag
+		for _, tag := range splitTokens(rows[i].target) {
+			if tag != c.defaultTag && (rows[i].class == c.defaultTag || counts[tag] < counts[rows[i].class]) {
+				rows[i].class = tag
+			}
+		}
+	}
+}
+
+// stratifiedSplit splits the rows so that each class has the same fraction of
+// its rows in the test set. Classes with a single row are kept in the train
+// set.
+func stratifiedSplit(rows []splitRow, testSplit float32, seed int) ([]splitRow, []splitRow) {
+	classes := make(map[string][]int)
+	for i, row := range rows {
+		classes[row.class] = append(classes[row.class], i)
+	}
+
+	names := make([]string, 0, len(classes))
+	for class := range classes {
+		names = append(names, class)
+	}
+	slices.Sort(names)
+
+	rng := rand.New(rand.NewSource(int64(seed)))
+
+	var train, test []splitRow
+	for _, class := range names {
+		indices := classes[class]
+		rng.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+
+		nTest := int(math.Round(float64(len(indices)) * float64(testSplit)))
+		nTest = min(nTest, len(indices)-1)
+		for i, idx := range indices {
+			if i < nTest {
+				test = append(test, rows[idx])
+			} else {
+				train = append(train, rows[idx])
+			}
+		}
+	}
+
+	return train, test
+}
+
+func (s *TrainService) writeSplit(path string, rows []splitRow, columns splitColumns) error {
+	delimiter, _ := utf8.DecodeRuneInString(columns.delimiter)
+
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	writer.Comma = delimiter
+	if err := writer.Write([]string{columns.source, columns.target}); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writer.Write([]string{row.source, row.target}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return s.storage.Write(path, buf)
+}
+
+// splitTrainData splits the uploaded train files into a train file and a test
+// file in the model's directory, stratified by the class of each row. The data
+// and train options are updated to use the new files and to record the seed.
+// The files are written as utf-8 csvs with only the source and target columns.
+func (s *TrainService) splitTrainData(modelId uuid.UUID, data *config.NlpData, trainOptions *config.NlpTrainOptions, columns splitColumns) error {
+	csvOptions := nlpUploadCsvOptions(*data, columns.delimiter)
+
+	var rows []splitRow
+	for _, file := range data.SupervisedFiles {
+		dir, err := filepath.Rel(s.storage.Location(), file.Path)
+		if err != nil {
+			return CodedError(fmt.Errorf("invalid upload path: %w", err), http.StatusInternalServerError)
+		}
+
+		names, err := s.storage.ListFiles(dir)
+		if err != nil {
+			return CodedError(fmt.Errorf("unable to list files in upload: %w", err), http.StatusInternalServerError)
+		}
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			format := trainFileFormat(path)
+			if format == "" {
+				return CodedError(fmt.Errorf("unable to split file '%v', only csv, parquet, and jsonl files are supported", name), http.StatusUnprocessableEntity)
+			}
+			fileRows, err := s.readSplitRows(path, format, csvOptions, columns)
+			if err != nil {
+				return CodedError(fmt.Errorf("unable to read file '%v' for test split: %w", name, err), http.StatusUnprocessableEntity)
+			}
+			rows = append(rows, fileRows...)
+		}
+	}
+
+	if trainOptions.TestSplitSeed == nil {
+		seed := rand.Intn(math.MaxInt32)
+		trainOptions.TestSplitSeed = &seed
+	}
+
+	columns.setClasses(rows)
+	train, test := stratifiedSplit(rows, *trainOptions.TestSplit, *trainOptions.TestSplitSeed)
+	if len(train) == 0 || len(test) == 0 {
+		return CodedError(fmt.Errorf("train files have too few rows for a test split: found %d rows", len(rows)), http.StatusUnprocessableEntity)
+	}
+
+	trainPath := filepath.Join(storage.ModelPath(modelId), "splits", "train.csv")
+	testPath := filepath.Join(storage.ModelPath(modelId), "splits", "test.csv")
+	for path, split := range map[string][]splitRow{trainPath: train, testPath: test} {
+		if err := s.writeSplit(path, split, columns); err != nil {
+			slog.Error("error writing test split", "model_id", modelId, "path", path, "error", err)
+			return CodedError(errors.New("error creating test split"), http.StatusInternalServerError)
+		}
+	}
+
+	slog.Info("created stratified test split", "model_id", modelId, "train_rows", len(train), "test_rows", len(test), "seed", *trainOptions.TestSplitSeed)
+
+	data.SupervisedFiles = []config.TrainFile{
+		{Path: filepath.Join(s.storage.Location(), trainPath), Location: config.FileLocLocal, Options: map[string]interface{}{}},
+	}
+	data.TestFiles = []config.TrainFile{
+		{Path: filepath.Join(s.storage.Location(), testPath), Location: config.FileLocLocal, Options: map[string]interface{}{}},
+	}
+	// The split files are always utf-8 and use the delimiter of the model.
+	data.CsvOptions = nil
+
+	return nil
+}
+
+// setStratifiedSplit makes the train job use a stratified split of the train
+// data, which is created once the id of the new model is known.
+func (s *TrainService) setStratifiedSplit(args *basicTrainArgs, data config.NlpData, trainOptions config.NlpTrainOptions, columns splitColumns) error {
+	for _, file := range data.SupervisedFiles {
+		// Uploads are converted to local files by validateUploads, files in
+		// other locations are not accessible to model bazaar.
+		if file.Location != config.FileLocLocal {
+			return CodedError(errors.New("a stratified test split can only be used with uploaded train files"), http.StatusUnprocessableEntity)
+		}
+	}
+
+	args.prepare = func(modelId uuid.UUID, args *basicTrainArgs) error {
+		if err := s.splitTrainData(modelId, &data, &trainOptions, columns); err != nil {
+			return err
+		}
+		args.data = data
+		args.trainOptions = trainOptions
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/parquet"
+	"thirdai_platform/model_bazaar/storage"
+)
+
+const (
+	TrainFileCsv     = "csv"
+	TrainFileParquet = "parquet"
+	TrainFileJsonl   = "jsonl"
+)
+
+// trainFileFormat returns the format of a training file from its extension, or
+// an empty string if the format is not supported.
+func trainFileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return TrainFileCsv
+	case ".parquet":
+		return TrainFileParquet
+	case ".jsonl":
+		return TrainFileJsonl
+	default:
+		return ""
+	}
+}
+
+// ColumnSchema describes a column of a training file. Csv files are untyped so
+// all of their columns have the type string.
+type ColumnSchema struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// trainableLabels counts the labels of a text or token classification file.
+type trainableLabels map[string]int
+
+func (l trainableLabels) addLabel(label string) {
+	l[label]++
+}
+
+// addTokens checks that each source token has a tag, and adds the tags other
+// than O to the labels.
+func (l trainableLabels) addTokens(sourceTokens, targetTokens []string) error {
+	if len(sourceTokens) != len(targetTokens) {
+		return fmt.Errorf("number of source tokens: %d ≠ number of target tokens: %d", len(sourceTokens), len(targetTokens))
+	}
+	for _, token := range targetTokens {
+		if token != "O" {
+			l[token]++
+		}
+	}
+	return nil
+}
+
+func (l trainableLabels) list() []string {
+	labels := make([]string, 0, len(l))
+	for label := range l {
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// report adds the labels and their distribution to the validation report.
+func (l trainableLabels) report(res *TrainableCSVResponse) {
+	res.Labels = l.list()
+	res.LabelCounts = l
+	res.ImbalanceRatio = config.ImbalanceRatio(l)
+	res.SuggestedImbalanceOptions = config.SuggestImbalanceOptions(l)
+}
+
+// openReaderAt returns a file from storage that can be read at arbitrary
+// offsets. Files that are not on local disk are copied to a temporary file.
+func openReaderAt(store storage.Storage, path string) (io.ReaderAt, int64, func(), error) {
+	file, err := store.Read(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if local, ok := file.(*os.File); ok {
+		info, err := local.Stat()
+		if err != nil {
+			local.Close()
+			return nil, 0, nil, err
+		}
+		return local, info.Size(), func() { local.Close() }, nil
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", "trainable-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	size, err := io.Copy(tmp, file)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+
+	return tmp, size, cleanup, nil
+}
+
+func splitTokens(value string) []string {
+	return strings.Split(value, " ")
+}
+
+func (s *TrainService) validateTrainableParquet(path string, sourceColumn, targetColumn string, isToken bool) (TrainableCSVResponse, error) {
+	res := TrainableCSVResponse{Format: TrainFileParquet}
+
+	reader, size, cleanup, err := openReaderAt(s.storage, path)
+	if err != nil {
+		return res, CodedError(fmt.Errorf("unable to open file. error: %w", err), http.StatusUnprocessableEntity)
+	}
+	defer cleanup()
+
+	file, err := parquet.Open(reader, size)
+	if err != nil {
+		return res, CodedError(fmt.Errorf("unable to read file. error: %w", err), http.StatusUnprocessableEntity)
+	}
+
+	res.Rows = int(file.NumRows)
+	names := make([]string, 0, len(file.Columns))
+	for _, column := range file.Columns {
+		res.Schema = append(res.Schema, ColumnSchema{Name: column.Name, Type: column.Type, Nullable: column.Nullable})
+		names = append(names, column.Name)
+	}
+
+	if _, _, err := findCSVColumns(names, sourceColumn, targetColumn); err != nil {
+		return res, CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	// The columns are stored separately, so the source column is read first
+	// and then compared with the target column row by row.
+	var sources []string
+	err = file.ReadColumn(sourceColumn, func(value *string) error {
+		if value == nil {
+			return fmt.Errorf("row %d has a null value in column '%v'", len(sources), sourceColumn)
+		}
+		if isToken {
+			sources = append(sources, *value)
+		} else {
+			sources = append(sources, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return res, CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	labels := make(trainableLabels)
+	row := 0
+	err = file.ReadColumn(targetColumn, func(value *string) error {
+		defer func() { row++ }()
+		if value == nil {
+			return fmt.Errorf("row %d has a null value in column '%v'", row, targetColumn)
+		}
+		if row >= len(sources) {
+			return errors.New("source and target columns have a different number of values")
+		}
+		if !isToken {
+			labels.addLabel(*value)
+			return nil
+		}
+		if err := labels.addTokens(splitTokens(sources[row]), splitTokens(*value)); err != nil {
+			return fmt.Errorf("%w. Invalid row: %d", err, row)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, CodedError(err, http.StatusUnprocessableEntity)
+	}
+
+	labels.report(&res)
+	return res, nil
+}
+
+// jsonlTokens returns the tokens of a value in a jsonl file, which can be a
+// string of space separated tokens or an array of tokens.
+func jsonlTokens(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		return splitTokens(v), true
+	case []interface{}:
+		tokens := make([]string, 0, len(v))
+		for _, elem := range v {
+			token, ok := elem.(string)
+			if !ok {
+				return nil, false
+			}
+			tokens = append(tokens, token)
+		}
+		return tokens, true
+	default:
+		return nil, false
+	}
+}
+
+func (s *TrainService) validateTrainableJsonl(path string, sourceColumn, targetColumn string, isToken bool) (TrainableCSVResponse, error) {
+	res := TrainableCSVResponse{Format: TrainFileJsonl}
+
+	file, err := s.storage.Read(path)
+	if err != nil {
+		return res, CodedError(fmt.Errorf("unable to open file. error: %w", err), http.StatusUnprocessableEntity)
+	}
+	defer file.Close()
+
+	reader := newJsonlReader(file)
+	labels := make(trainableLabels)
+	columns := make(map[string]int)
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return res, CodedError(fmt.Errorf("unable to read file. error: %w", err), http.StatusUnprocessableEntity)
+		}
+
+		for _, key := range record.keys {
+			valueType := jsonType(record.values[key])
+			idx, seen := columns[key]
+			if !seen {
+				columns[key] = len(res.Schema)
+				// Columns that are missing from earlier records are nullable.
+				res.Schema = append(res.Schema, ColumnSchema{Name: key, Type: valueType, Nullable: res.Rows > 0 || valueType == "null"})
+				continue
+			}
+			res.Schema[idx].Type = mergeJsonTypes(res.Schema[idx].Type, valueType)
+			res.Schema[idx].Nullable = res.Schema[idx].Nullable || valueType == "null"
+		}
+		for idx := range res.Schema {
+			if _, ok := record.values[res.Schema[idx].Name]; !ok {
+				res.Schema[idx].Nullable = true
+			}
+		}
+		res.Rows++
+
+		source, sourceOk := record.values[sourceColumn]
+		target, targetOk := record.values[targetColumn]
+		if !sourceOk || !targetOk {
+			return res, CodedError(fmt.Errorf("invalid columns: expected columns '%v' and '%v' on line %d, got %v", sourceColumn, targetColumn, reader.line, record.keys), http.StatusUnprocessableEntity)
+		}
+
+		sourceTokens, ok := jsonlTokens(source)
+		if !ok {
+			return res, CodedError(fmt.Errorf("column '%v' on line %d must be a string or an array of strings", sourceColumn, reader.line), http.StatusUnprocessableEntity)
+		}
+
+		if !isToken {
+			switch v := target.(type) {
+			case string:
+				labels.addLabel(v)
+			case nil, []interface{}, map[string]interface{}:
+				return res, CodedError(fmt.Errorf("column '%v' on line %d must be a string, number, or boolean", targetColumn, reader.line), http.StatusUnprocessableEntity)
+			default:
+				labels.addLabel(fmt.Sprint(v))
+			}
+			continue
+		}
+
+		targetTokens, ok := jsonlTokens(target)
+		if !ok {
+			return res, CodedError(fmt.Errorf("column '%v' on line %d must be a string or an array of strings", targetColumn, reader.line), http.StatusUnprocessableEntity)
+		}
+		if err := labels.addTokens(sourceTokens, targetTokens); err != nil {
+			return res, CodedError(fmt.Errorf("%w. Invalid line: %d", err, reader.line), http.StatusUnprocessableEntity)
+		}
+	}
+
+	if res.Rows == 0 {
+		return res, CodedError(errors.New("file does not contain any records"), http.StatusUnprocessableEntity)
+	}
+
+	labels.report(&res)
+	return res, nil
+}
+
+func (s *TrainService) validateTrainableFile(path, format string, options TrainableCSVRequest, sourceColumn, targetColumn string) (TrainableCSVResponse, error) {
+	isToken := options.FileType == "token"
+	switch format {
+	case TrainFileParquet:
+		return s.validateTrainableParquet(path, sourceColumn, targetColumn, isToken)
+	case TrainFileJsonl:
+		return s.validateTrainableJsonl(path, sourceColumn, targetColumn, isToken)
+	default:
+		return s.validateTrainableCSV(path, sourceColumn, targetColumn, options.CsvOptions, isToken)
+	}
+}
+
+// trainFileColumns returns the names of the columns of a training file. The
+// keys of the first record are used for jsonl files.
+func (s *TrainService) trainFileColumns(path, format string, csvOptions config.CSVOptions) ([]string, error) {
+	if format == TrainFileParquet {
+		reader, size, cleanup, err := openReaderAt(s.storage, path)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+
+		file, err := parquet.Open(reader, size)
+		if err != nil {
+			return nil, err
+		}
+		columns := make([]string, 0, len(file.Columns))
+		for _, column := range file.Columns {
+			columns = append(columns, column.Name)
+		}
+		return columns, nil
+	}
+
+	file, err := s.storage.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if format == TrainFileJsonl {
+		record, err := newJsonlReader(file).Read()
+		if err != nil {
+			return nil, err
+		}
+		return record.keys, nil
+	}
+
+	reader, err := newCSVReader(file, csvOptions)
+	if err != nil {
+		return nil, err
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	return header, nil
+}
+
+// validateUploadColumns checks that the files in the uploads used for training
+// contain the columns of the model, so that a mismatch is reported when the
+// train request is made instead of failing the train job.
+func (s *TrainService) validateUploadColumns(files []config.TrainFile, csvOptions config.CSVOptions, columns ...string) error {
+	for _, file := range files {
+		// Uploads are converted to local files by validateUploads, other
+		// locations are not accessible to model bazaar.
+		if file.Location != config.FileLocLocal {
+			continue
+		}
+		dir, err := filepath.Rel(s.storage.Location(), file.Path)
+		if err != nil {
+			return CodedError(fmt.Errorf("invalid upload path: %w", err), http.StatusInternalServerError)
+		}
+
+		names, err := s.storage.ListFiles(dir)
+		if err != nil {
+			return CodedError(fmt.Errorf("unable to list files in upload: %w", err), http.StatusInternalServerError)
+		}
+
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			format := trainFileFormat(path)
+			if format == "" {
+				// The train job reports files with unsupported formats.
+				continue
+			}
+
+			header, err := s.trainFileColumns(path, format, csvOptions)
+			if err != nil {
+				return CodedError(fmt.Errorf("unable to read columns of file '%v': %w", name, err), http.StatusUnprocessableEntity)
+			}
+			for _, column := range columns {
+				if !slices.Contains(header, column) {
+					return CodedError(fmt.Errorf("file '%v' does not contain column '%v', found columns %v", name, column, header), http.StatusUnprocessableEntity)
+				}
+			}
+		}
+	}
+	return nil
+}
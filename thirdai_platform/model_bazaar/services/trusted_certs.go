@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const trustedCaBundlePath = "certs/trusted_ca_bundle.pem"
+
+// The same locations that go checks for the system CAs on linux.
+var systemCaBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// systemCaBundle returns the system CAs so that they can be included in the
+// bundle for jobs, since the env vars used to set the bundle in jobs replace
+// the system CAs instead of adding to them.
+func systemCaBundle() []byte {
+	paths := systemCaBundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		paths = append([]string{file}, paths...)
+	}
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil {
+			return data
+		}
+	}
+	slog.Warn("unable to find system ca bundle, jobs will only trust the configured certificates")
+	return nil
+}
+
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	rest := []byte(data)
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("pem block of type '%v' is not allowed, only certificates can be added", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("certificate data contains content that is not pem encoded")
+	}
+	return certs, nil
+}
+
+// LoadTrustedCerts configures model bazaar to trust the added certificates, and
+// writes the bundle that is mounted in jobs.
+func LoadTrustedCerts(db *gorm.DB, store storage.Storage) error {
+	var certs []schema.TrustedCertificate
+	if err := db.Order("created_at").Find(&certs).Error; err != nil {
+		slog.Error("sql error loading trusted certificates", "error", err)
+		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+
+	if len(certs) == 0 {
+		if err := utils.SetTrustedCerts(nil); err != nil {
+			return err
+		}
+		if err := store.Delete(trustedCaBundlePath); err != nil {
+			return fmt.Errorf("error removing trusted ca bundle: %w", err)
+		}
+		return nil
+	}
+
+	trusted := new(bytes.Buffer)
+	for _, cert := range certs {
+		trusted.WriteString(strings.TrimSpace(cert.Pem))
+		trusted.WriteString("\n")
+	}
+
+	if err := utils.SetTrustedCerts(trusted.Bytes()); err != nil {
+		return fmt.Errorf("error setting trusted certificates: %w", err)
+	}
+
+	bundle := new(bytes.Buffer)
+	if system := systemCaBundle(); len(system) > 0 {
+		bundle.Write(bytes.TrimSpace(system))
+		bundle.WriteString("\n")
+	}
+	bundle.Write(trusted.Bytes())
+
+	if err := store.Write(trustedCaBundlePath, bundle); err != nil {
+		return fmt.Errorf("error writing trusted ca bundle: %w", err)
+	}
+
+	return nil
+}
+
+// TrustedCertsJobEnv returns the env vars that point the common tls libraries
+// in jobs to the bundle, if any certificates have been added.
+func TrustedCertsJobEnv(db *gorm.DB, store storage.Storage) (map[string]string, error) {
+	var count int64
+	if err := db.Model(&schema.TrustedCertificate{}).Count(&count).Error; err != nil {
+		slog.Error("sql error counting trusted certificates", "error", err)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if count == 0 {
+		return map[string]string{}, nil
+	}
+
+	bundle := filepath.Join(store.Location(), trustedCaBundlePath)
+	return map[string]string{
+		"SSL_CERT_FILE":      bundle,
+		"REQUESTS_CA_BUNDLE": bundle,
+		"CURL_CA_BUNDLE":     bundle,
+	}, nil
+}
+
+type TrustedCertService struct {
+	db       *gorm.DB
+	storage  storage.Storage
+	userAuth auth.IdentityProvider
+}
+
+func (s *TrustedCertService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+	r.Post("/", s.Add)
+	r.Delete("/{cert_id}", s.Delete)
+
+	return r
+}
+
+type TrustedCertInfo struct {
+	Id          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+	Expired     bool      `json:"expired"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (s *TrustedCertService) List(w http.ResponseWriter, r *http.Request) {
+	var certs []schema.TrustedCertificate
+	if err := s.db.Order("created_at").Find(&certs).Error; err != nil {
+		slog.Error("sql error listing trusted certificates", "error", err)
+		http.Error(w, fmt.Sprintf("error listing trusted certificates: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	infos := make([]TrustedCertInfo, 0, len(certs))
+	for _, cert := range certs {
+		infos = append(infos, TrustedCertInfo{
+			Id:          cert.Id,
+			Name:        cert.Name,
+			Subject:     cert.Subject,
+			Fingerprint: cert.Fingerprint,
+			NotAfter:    cert.NotAfter.UTC(),
+			Expired:     cert.NotAfter.Before(now),
+			CreatedAt:   cert.CreatedAt.UTC(),
+		})
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type addTrustedCertRequest struct {
+	Name string `json:"name"`
+	Pem  string `json:"pem"`
+}
+
+// Add adds the certificates in the pem data, each certificate is stored
+// separately so that they can be removed individually. Certificates that have
+// already been added are skipped.
+func (s *TrustedCertService) Add(w http.ResponseWriter, r *http.Request) {
+	var params addTrustedCertRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > 100 {
+		http.Error(w, "name must be between 1 and 100 characters", http.StatusUnprocessableEntity)
+		return
+	}
+
+	parsed, err := parseCertificates(params.Pem)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(parsed) == 0 {
+		http.Error(w, "no certificates found in pem data", http.StatusUnprocessableEntity)
+		return
+	}
+
+	now := time.Now().UTC()
+	added := []TrustedCertInfo{}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		for _, cert := range parsed {
+			if now.After(cert.NotAfter) {
+				return CodedError(fmt.Errorf("certificate '%v' expired at %v", cert.Subject, cert.NotAfter), http.StatusUnprocessableEntity)
+			}
+
+			sum := sha256.Sum256(cert.Raw)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			var existing int64
+			if err := txn.Model(&schema.TrustedCertificate{}).Where("fingerprint = ?", fingerprint).Count(&existing).Error; err != nil {
+				slog.Error("sql error checking for existing trusted certificate", "error", err)
+				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+			}
+			if existing > 0 {
+				continue
+			}
+
+			entry := schema.TrustedCertificate{
+				Id:          uuid.New(),
+				Name:        params.Name,
+				Subject:     cert.Subject.String(),
+				Fingerprint: fingerprint,
+				Pem:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+				NotAfter:    cert.NotAfter.UTC(),
+				CreatedAt:   now,
+			}
+			if err := txn.Create(&entry).Error; err != nil {
+				slog.Error("sql error saving trusted certificate", "error", err)
+				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+			}
+
+			added = append(added, TrustedCertInfo{
+				Id:          entry.Id,
+				Name:        entry.Name,
+				Subject:     entry.Subject,
+				Fingerprint: entry.Fingerprint,
+				NotAfter:    entry.NotAfter.UTC(),
+				CreatedAt:   entry.CreatedAt.UTC(),
+			})
+		}
+
+		return LoadTrustedCerts(txn, s.storage)
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error adding trusted certificates: %v", err), err)
+		return
+	}
+
+	slog.Info("added trusted certificates", "name", params.Name, "added", len(added))
+
+	utils.WriteJsonResponse(w, added)
+}
+
+func (s *TrustedCertService) Delete(w http.ResponseWriter, r *http.Request) {
+	certId, err := utils.URLParamUUID(r, "cert_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		result := txn.Delete(&schema.TrustedCertificate{}, "id = ?", certId)
+		if result.Error != nil {
+			slog.Error("sql error deleting trusted certificate", "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		if result.RowsAffected == 0 {
+			return CodedError(fmt.Errorf("trusted certificate %v not found", certId), http.StatusNotFound)
+		}
+
+		return LoadTrustedCerts(txn, s.storage)
+	})
+	if err != nil {
+		writeError(w, fmt.Sprintf("error deleting trusted certificate: %v", err), err)
+		return
+	}
+
+	slog.Info("deleted trusted certificate", "cert_id", certId)
+
+	utils.WriteSuccess(w)
+}
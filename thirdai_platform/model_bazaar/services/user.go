@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"thirdai_platform/model_bazaar/auth"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
@@ -19,6 +21,12 @@ import (
 type UserService struct {
 	db       *gorm.DB
 	userAuth auth.IdentityProvider
+	// recovery is nil if the identity provider has its own flows for resetting
+	// passwords and verifying emails.
+	recovery auth.AccountRecoveryProvider
+
+	// The default job limits for users without overrides.
+	jobLimits JobLimits
 }
 
 func (s *UserService) Routes() chi.Router {
@@ -31,6 +39,13 @@ func (s *UserService) Routes() chi.Router {
 
 		r.Get("/login", s.LoginWithEmail)
 		r.Post("/login-with-token", s.LoginWithToken)
+
+		if s.recovery != nil {
+			r.Post("/password-reset/request", s.RequestPasswordReset)
+			r.Post("/password-reset", s.ResetPassword)
+			r.Post("/verify-email/request", s.RequestEmailVerification)
+			r.Post("/verify-email", s.VerifyEmail)
+		}
 	})
 
 	r.Group(func(r chi.Router) {
@@ -52,15 +67,26 @@ func (s *UserService) Routes() chi.Router {
 		r.Delete("/{user_id}/admin", s.DemoteAdmin)
 
 		r.Post("/{user_id}/verify", s.VerifyUser)
+
+		r.Get("/{user_id}/job-limits", s.GetJobLimits)
+		r.Post("/{user_id}/job-limits", s.SetJobLimits)
 	})
 
 	return r
 }
 
 type signupRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (r *signupRequest) validate() error {
+	errs := validation.Struct(r)
+	if r.Email != "" && !strings.Contains(r.Email, "@") {
+		errs.Add("email", "invalid email '%v'", r.Email)
+	}
+	return errs.Err()
 }
 
 type signupResponse struct {
@@ -73,6 +99,11 @@ func (s *UserService) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid user: %v", err), err)
+		return
+	}
+
 	if !s.userAuth.AllowDirectSignup() {
 		http.Error(w, "direct signup is not supported for this identify provider", http.StatusBadRequest)
 		return
@@ -80,14 +111,14 @@ func (s *UserService) Signup(w http.ResponseWriter, r *http.Request) {
 
 	userId, err := s.userAuth.CreateUser(params.Username, params.Email, params.Password)
 	if err != nil {
-		responseCode := http.StatusInternalServerError
+		responseCode, errCode := http.StatusInternalServerError, ""
 		switch {
 		case errors.Is(err, auth.ErrEmailAlreadyInUse):
-			responseCode = http.StatusConflict
+			responseCode, errCode = http.StatusConflict, utils.ErrorCodeDuplicateName
 		case errors.Is(err, auth.ErrUsernameAlreadyInUse):
-			responseCode = http.StatusConflict
+			responseCode, errCode = http.StatusConflict, utils.ErrorCodeDuplicateName
 		}
-		http.Error(w, err.Error(), responseCode)
+		utils.WriteError(w, err.Error(), responseCode, errCode)
 		return
 	}
 
@@ -111,14 +142,23 @@ func (s *UserService) LoginWithEmail(w http.ResponseWriter, r *http.Request) {
 
 	login, err := s.userAuth.LoginWithEmail(email, password)
 	if err != nil {
-		responseCode := http.StatusInternalServerError
+		responseCode, errCode := http.StatusInternalServerError, ""
 		switch {
 		case errors.Is(err, auth.ErrUserNotFoundWithEmail):
 			responseCode = http.StatusNotFound
 		case errors.Is(err, auth.ErrInvalidCredentials):
 			responseCode = http.StatusUnauthorized
+		case errors.Is(err, auth.ErrAccountLocked):
+			responseCode, errCode = http.StatusForbidden, utils.ErrorCodeAccountLocked
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			responseCode, errCode = http.StatusForbidden, utils.ErrorCodeEmailNotVerified
 		}
-		http.Error(w, fmt.Sprintf("login failed: %v", err), responseCode)
+		if responseCode != http.StatusInternalServerError {
+			// Failed logins are recorded for the health report, an error recording
+			// the event does not change the response.
+			_ = recordEvent(s.db, schema.UserLoginFailedEvent, nil, nil, map[string]interface{}{"email": email, "client_ip": auth.ClientIp(r)})
+		}
+		utils.WriteError(w, fmt.Sprintf("login failed: %v", err), responseCode, errCode)
 		return
 	}
 
@@ -169,6 +209,9 @@ func (s *UserService) DeleteUser(w http.ResponseWriter, r *http.Request) {
 			Where("user_id = ?", userId).
 			Update("user_id", admin.Id)
 		if updateResult.Error != nil {
+			if errors.Is(updateResult.Error, gorm.ErrDuplicatedKey) {
+				return CodedErrorWithErrorCode(fmt.Errorf("user %v has models with the same names as models of admin %v, the models must be deleted first", userId, admin.Username), http.StatusConflict, utils.ErrorCodeDuplicateName)
+			}
 			slog.Error("sql error updating owner of user protected/public models", "user_id", userId, "error", updateResult.Error)
 			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 		}
@@ -183,7 +226,7 @@ func (s *UserService) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error deleting user %v: %v", userId, err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error deleting user %v: %v", userId, err), err)
 		return
 	}
 
@@ -224,7 +267,7 @@ func (s *UserService) PromoteAdmin(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error promoting admin: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error promoting admin: %v", err), err)
 		return
 	}
 
@@ -274,7 +317,7 @@ func (s *UserService) DemoteAdmin(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error demoting admin: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error demoting admin: %v", err), err)
 		return
 	}
 
@@ -288,12 +331,14 @@ type UserTeamInfo struct {
 }
 
 type UserInfo struct {
-	Id            uuid.UUID      `json:"id"`
-	Username      string         `json:"username"`
-	Email         string         `json:"email"`
-	Admin         bool           `json:"admin"`
-	Teams         []UserTeamInfo `json:"teams"`
-	RoleSignature string         `json:"role_signature"`
+	Id             uuid.UUID      `json:"id"`
+	Username       string         `json:"username"`
+	Email          string         `json:"email"`
+	Admin          bool           `json:"admin"`
+	OrganizationId *uuid.UUID     `json:"organization_id"`
+	OrgAdmin       bool           `json:"org_admin"`
+	Teams          []UserTeamInfo `json:"teams"`
+	RoleSignature  string         `json:"role_signature"`
 }
 
 type RolePayload struct {
@@ -366,12 +411,14 @@ func convertToUserInfo(user *schema.User) (UserInfo, error) {
 	}
 
 	return UserInfo{
-		Id:            user.Id,
-		Username:      user.Username,
-		Email:         user.Email,
-		Admin:         user.IsAdmin,
-		Teams:         teams,
-		RoleSignature: roleSignature,
+		Id:             user.Id,
+		Username:       user.Username,
+		Email:          user.Email,
+		Admin:          user.IsAdmin,
+		OrganizationId: user.OrganizationId,
+		OrgAdmin:       user.IsOrgAdmin,
+		Teams:          teams,
+		RoleSignature:  roleSignature,
 	}, nil
 }
 
@@ -392,7 +439,13 @@ func (s *UserService) List(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "error loading user teams", http.StatusInternalServerError)
 			return
 		}
-		if len(userTeams) > 0 {
+		if user.IsOrgAdmin && user.OrganizationId != nil {
+			// Org admins can see all users in their organization to add them to teams.
+			result = s.db.Preload("Teams").Preload("Teams.Team").
+				Where("organization_id = ?", *user.OrganizationId).
+				Or("id IN (?)", s.db.Table("user_teams").Select("user_id").Where("team_id IN ?", userTeams)).
+				Find(&users)
+		} else if len(userTeams) > 0 {
 			result = s.db.Preload("Teams").Preload("Teams.Team").Joins("JOIN user_teams ON user_teams.user_id = users.id").Where("user_teams.team_id in ?", userTeams).Find(&users)
 		} else {
 			users = []schema.User{user}
@@ -446,16 +499,21 @@ func (s *UserService) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid user: %v", err), err)
+		return
+	}
+
 	userId, err := s.userAuth.CreateUser(params.Username, params.Email, params.Password)
 	if err != nil {
-		responseCode := http.StatusInternalServerError
+		responseCode, errCode := http.StatusInternalServerError, ""
 		switch {
 		case errors.Is(err, auth.ErrEmailAlreadyInUse):
-			responseCode = http.StatusConflict
+			responseCode, errCode = http.StatusConflict, utils.ErrorCodeDuplicateName
 		case errors.Is(err, auth.ErrUsernameAlreadyInUse):
-			responseCode = http.StatusConflict
+			responseCode, errCode = http.StatusConflict, utils.ErrorCodeDuplicateName
 		}
-		http.Error(w, fmt.Sprintf("error creating user: %v", err), responseCode)
+		utils.WriteError(w, fmt.Sprintf("error creating user: %v", err), responseCode, errCode)
 		return
 	}
 
@@ -472,7 +530,11 @@ func (s *UserService) VerifyUser(w http.ResponseWriter, r *http.Request) {
 
 	err = s.userAuth.VerifyUser(userId)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error verifying user '%v': %v", userId, err), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		if errors.Is(err, schema.ErrUserNotFound) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("error verifying user '%v': %v", userId, err), code)
 		return
 	}
 
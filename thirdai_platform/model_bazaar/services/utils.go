@@ -8,7 +8,9 @@ import (
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/imagescan"
 	"thirdai_platform/model_bazaar/licensing"
 	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/schema"
@@ -18,6 +20,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const thirdaiPlatformKeyPrefix = "thirdai_platform_key"
@@ -27,11 +30,15 @@ var (
 	ErrInvalidAPIKey       = errors.New("API key is invalid")
 	ErrExpiredAPIKey       = errors.New("API key has expired")
 	ErrAPIKeyModelMismatch = errors.New("API key does not have access to the requested model")
+
+	ErrInsufficientStorage = errors.New("insufficient disk space available")
 )
 
 type codedError struct {
 	err  error
 	code int
+	// The machine readable code returned to the client, see errorCode.
+	errorCode string
 }
 
 func (e *codedError) Error() string {
@@ -55,6 +62,54 @@ func GetResponseCode(err error) int {
 	return http.StatusInternalServerError
 }
 
+// CodedErrorWithErrorCode is a CodedError that is returned to the client with
+// the given error code, for errors that are not identified by a sentinel error
+// in errorCode.
+func CodedErrorWithErrorCode(err error, code int, errorCode string) error {
+	return &codedError{err: err, code: code, errorCode: errorCode}
+}
+
+// errorCode returns the utils.ErrorCode* for the error, or an empty string if
+// the error does not have one.
+func errorCode(err error) string {
+	// Handlers often wrap coded errors in another CodedError to add context, so
+	// the whole chain is checked for an error code.
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if cerr, ok := e.(*codedError); ok && cerr.errorCode != "" {
+			return cerr.errorCode
+		}
+	}
+
+	switch {
+	case errors.Is(err, schema.ErrModelNotFound):
+		return utils.ErrorCodeModelNotFound
+	case errors.Is(err, licensing.ErrCpuLimitExceeded):
+		return utils.ErrorCodeLicenseLimit
+	case errors.Is(err, ErrUserJobLimitReached), errors.Is(err, ErrStorageQuotaExceeded):
+		return utils.ErrorCodeQuotaExceeded
+	case errors.Is(err, ErrInsufficientStorage):
+		return utils.ErrorCodeInsufficientStorage
+	}
+	return ""
+}
+
+// writeError writes an error response with the status and error code of err.
+// The message is passed separately since handlers usually add context to err.
+func writeError(w http.ResponseWriter, message string, err error) {
+	utils.WriteError(w, message, GetResponseCode(err), errorCode(err))
+}
+
+// Timestamps read from the database are in the location of the server, so they
+// are converted to UTC before being returned, so that every response uses
+// RFC3339 timestamps in UTC.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
 func listModelDependencies(modelId uuid.UUID, db *gorm.DB) ([]schema.Model, error) {
 	visited := map[uuid.UUID]struct{}{}
 	models := []schema.Model{}
@@ -111,7 +166,7 @@ func getModelStatus(model schema.Model, db *gorm.DB, trainStatus bool) (string,
 
 	statusPriority := []string{
 		schema.Failed, schema.NotStarted, schema.Stopped,
-		schema.Starting, schema.InProgress, schema.Complete,
+		schema.Queued, schema.Starting, schema.InProgress, schema.Complete,
 	}
 
 	statuses := map[string][]string{}
@@ -190,10 +245,46 @@ func getJobLogs(db *gorm.DB, modelId uuid.UUID, job string) ([]string, []string,
 	return errors, warnings, nil
 }
 
+type JobProgressInfo struct {
+	Phase      string    `json:"phase"`
+	Step       int       `json:"step"`
+	TotalSteps int       `json:"total_steps"`
+	Percent    float64   `json:"percent"`
+	EtaSeconds *float64  `json:"eta_seconds"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
 type StatusResponse struct {
-	Status   string   `json:"status"`
-	Errors   []string `json:"errors"`
-	Warnings []string `json:"warnings"`
+	Status   string                 `json:"status"`
+	Errors   []string               `json:"errors"`
+	Warnings []string               `json:"warnings"`
+	Progress *JobProgressInfo       `json:"progress,omitempty"`
+	History  []StatusTransitionInfo `json:"history"`
+	// Slo is the SLO of a deployment, if it has one.
+	Slo *SloInfo `json:"slo,omitempty"`
+	// Inactive is set if a deployment is flagged as inactive.
+	Inactive *InactiveDeploymentInfo `json:"inactive,omitempty"`
+}
+
+func getJobProgress(db *gorm.DB, modelId uuid.UUID, job string) (*JobProgressInfo, error) {
+	var progress []schema.JobProgress
+	result := db.Limit(1).Find(&progress, "model_id = ? AND job = ?", modelId, job)
+	if result.Error != nil {
+		slog.Error("sql error retrieving job progress", "model_id", modelId, "job", job, "error", result.Error)
+		return nil, CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+	}
+	if len(progress) == 0 {
+		return nil, nil
+	}
+
+	return &JobProgressInfo{
+		Phase:      progress[0].Phase,
+		Step:       progress[0].Step,
+		TotalSteps: progress[0].TotalSteps,
+		Percent:    progress[0].Percent,
+		EtaSeconds: progress[0].EtaSeconds,
+		UpdatedAt:  progress[0].UpdatedAt.UTC(),
+	}, nil
 }
 
 func getStatusHandler(w http.ResponseWriter, modelId uuid.UUID, db *gorm.DB, job string) {
@@ -219,18 +310,48 @@ func getStatusHandler(w http.ResponseWriter, modelId uuid.UUID, db *gorm.DB, job
 	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("retrieving model status: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("retrieving model status: %v", err), err)
 		return
 	}
 
 	errors, warnings, err := getJobLogs(db, modelId, job)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("retrieving model job messages: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("retrieving model job messages: %v", err), err)
 		return
 	}
 	res.Errors = errors
 	res.Warnings = warnings
 
+	progress, err := getJobProgress(db, modelId, job)
+	if err != nil {
+		writeError(w, fmt.Sprintf("retrieving model job progress: %v", err), err)
+		return
+	}
+	res.Progress = progress
+
+	history, err := getStatusHistory(db, modelId, job)
+	if err != nil {
+		writeError(w, fmt.Sprintf("retrieving model status history: %v", err), err)
+		return
+	}
+	res.History = history
+
+	if job == "deploy" {
+		slo, err := getDeploymentSlo(db, modelId)
+		if err != nil {
+			writeError(w, fmt.Sprintf("retrieving deployment slo: %v", err), err)
+			return
+		}
+		res.Slo = slo
+
+		inactive, err := getInactiveDeployment(db, modelId)
+		if err != nil {
+			writeError(w, fmt.Sprintf("retrieving deployment inactivity: %v", err), err)
+			return
+		}
+		res.Inactive = inactive
+	}
+
 	slog.Info("got status for model successfully", "job", job, "model_id", modelId, "status", res.Status)
 
 	utils.WriteJsonResponse(w, res)
@@ -238,10 +359,14 @@ func getStatusHandler(w http.ResponseWriter, modelId uuid.UUID, db *gorm.DB, job
 
 type updateStatusRequest struct {
 	Status   string                 `json:"status"`
+	Message  string                 `json:"message"`
 	Metadata map[string]interface{} `json:"metadata"`
 }
 
-func updateStatusHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, job string) {
+// updateStatusHandler updates the status of the job. If checkComplete is not nil
+// it is called before a job is marked complete, and the job is marked failed
+// instead if it returns ErrStorageQuotaExceeded.
+func updateStatusHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, job string, checkComplete func(txn *gorm.DB, model schema.Model) error) {
 	modelId, err := auth.ModelIdFromContext(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -261,14 +386,44 @@ func updateStatusHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, jo
 	slog.Info("updating status for model", "job", job, "status", params.Status, "model_id", modelId)
 
 	err = db.Transaction(func(txn *gorm.DB) error {
-		if err := checkModelExists(txn, modelId); err != nil {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			if errors.Is(err, schema.ErrModelNotFound) {
+				return CodedError(err, http.StatusNotFound)
+			}
+			return CodedError(err, http.StatusInternalServerError)
+		}
+
+		if params.Status == schema.Complete && checkComplete != nil {
+			if err := checkComplete(txn, model); err != nil {
+				if !errors.Is(err, ErrStorageQuotaExceeded) {
+					return err
+				}
+				slog.Warn("marking job as failed", "job", job, "model_id", modelId, "error", err)
+				params.Status, params.Message = schema.Failed, err.Error()
+
+				// The error is added to the job logs so that it is returned with the status.
+				log := schema.JobLog{Id: uuid.New(), ModelId: modelId, Job: job, Level: "error", Message: err.Error()}
+				if err := txn.Create(&log).Error; err != nil {
+					slog.Error("sql error creating job log", "error", err)
+					return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+				}
+			}
+		}
+
+		if err := updateStatus(txn, job, &model, params.Status, params.Message); err != nil {
 			return err
 		}
 
-		result := txn.Model(&schema.Model{Id: modelId}).Update(job+"_status", params.Status)
-		if result.Error != nil {
-			slog.Error("sql error updating model status", "job", job, "status", params.Status, "error", result.Error)
-			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		if params.Status == schema.Complete {
+			// Jobs may not report progress for their final steps, so the progress is
+			// marked as finished to avoid showing stale progress for complete jobs.
+			result := txn.Model(&schema.JobProgress{}).Where("model_id = ? AND job = ?", modelId, job).
+				Updates(map[string]interface{}{"percent": 100, "eta_seconds": 0, "updated_at": time.Now().UTC()})
+			if result.Error != nil {
+				slog.Error("sql error completing job progress", "model_id", modelId, "job", job, "error", result.Error)
+				return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+			}
 		}
 
 		if len(params.Metadata) > 0 {
@@ -288,7 +443,7 @@ func updateStatusHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, jo
 
 	if err != nil {
 		slog.Error("error updating model status", "job", job, "error", err)
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
@@ -297,6 +452,89 @@ func updateStatusHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, jo
 	utils.WriteSuccess(w)
 }
 
+type updateProgressRequest struct {
+	Phase      string   `json:"phase"`
+	Step       int      `json:"step"`
+	TotalSteps int      `json:"total_steps"`
+	Percent    *float64 `json:"percent"`
+	EtaSeconds *float64 `json:"eta_seconds"`
+}
+
+func (r *updateProgressRequest) validate() error {
+	if r.Step < 0 || r.TotalSteps < 0 {
+		return fmt.Errorf("step and total_steps must be non-negative")
+	}
+	if r.TotalSteps > 0 && r.Step > r.TotalSteps {
+		return fmt.Errorf("step %d exceeds total_steps %d", r.Step, r.TotalSteps)
+	}
+	if r.Percent != nil && (*r.Percent < 0 || *r.Percent > 100) {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	if r.Percent == nil && r.TotalSteps == 0 {
+		return fmt.Errorf("either percent or total_steps must be specified")
+	}
+	if r.EtaSeconds != nil && *r.EtaSeconds < 0 {
+		return fmt.Errorf("eta_seconds must be non-negative")
+	}
+	return nil
+}
+
+func (r *updateProgressRequest) percent() float64 {
+	if r.Percent != nil {
+		return *r.Percent
+	}
+	return 100 * float64(r.Step) / float64(r.TotalSteps)
+}
+
+func updateProgressHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, job string) {
+	modelId, err := auth.ModelIdFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var params updateProgressRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := params.validate(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid progress update: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	progress := schema.JobProgress{
+		ModelId:    modelId,
+		Job:        job,
+		Phase:      params.Phase,
+		Step:       params.Step,
+		TotalSteps: params.TotalSteps,
+		Percent:    params.percent(),
+		EtaSeconds: params.EtaSeconds,
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	err = db.Transaction(func(txn *gorm.DB) error {
+		if err := checkModelExists(txn, modelId); err != nil {
+			return err
+		}
+
+		result := txn.Clauses(clause.OnConflict{UpdateAll: true}).Create(&progress)
+		if result.Error != nil {
+			slog.Error("sql error updating job progress", "model_id", modelId, "job", job, "error", result.Error)
+			return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
+		}
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, fmt.Sprintf("error updating job progress: %v", err), err)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
+
 type jobLogRequest struct {
 	Level   string `json:"level"`
 	Message string `json:"message"`
@@ -330,31 +568,71 @@ func jobLogHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, job stri
 	utils.WriteSuccess(w)
 }
 
-func getLogsHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, c orchestrator.Client, job string) {
+// logsJobName returns the id of the model in the request and the name of its
+// train or deploy job. An error response is written if the model is not found.
+func logsJobName(w http.ResponseWriter, r *http.Request, db *gorm.DB, job string) (uuid.UUID, string, bool) {
 	modelId, err := utils.URLParamUUID(r, "model_id")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return uuid.Nil, "", false
 	}
 
 	model, err := schema.GetModel(modelId, db, false, false, false)
 	if err != nil {
 		if errors.Is(err, schema.ErrModelNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
+			utils.WriteError(w, err.Error(), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+			return uuid.Nil, "", false
 		}
 		http.Error(w, fmt.Sprintf("error getting logs: %v", err), http.StatusInternalServerError)
-		return
+		return uuid.Nil, "", false
 	}
 
-	var jobName string
 	if job == "train" {
-		jobName = model.TrainJobName()
-	} else {
-		jobName = model.DeployJobName()
+		return modelId, model.TrainJobName(), true
+	}
+	return modelId, model.DeployJobName(), true
+}
+
+func getLogsHandler(w http.ResponseWriter, r *http.Request, db *gorm.DB, c orchestrator.Client, store storage.Storage, job string) {
+	modelId, jobName, ok := logsJobName(w, r, db, job)
+	if !ok {
+		return
+	}
+
+	// The logs of previous runs of the job are only available from the archive.
+	if param := r.URL.Query().Get("attempt"); param != "" {
+		attempt, err := strconv.Atoi(param)
+		if err != nil || attempt < 0 {
+			http.Error(w, "attempt must be a non negative integer", http.StatusBadRequest)
+			return
+		}
+		archived, err := loadArchivedJobLogs(db, store, modelId, job, &attempt)
+		if err != nil {
+			slog.Error("error loading archived job logs", "model_id", modelId, "job", job, "attempt", attempt, "error", err)
+			http.Error(w, "error loading archived job logs", http.StatusInternalServerError)
+			return
+		}
+		if archived == nil {
+			http.Error(w, fmt.Sprintf("no archived logs for attempt %d of the %v job", attempt, job), http.StatusNotFound)
+			return
+		}
+		utils.WriteJsonResponse(w, archived)
+		return
 	}
 
 	logs, err := c.JobLogs(jobName)
+	if err != nil || len(logs) == 0 {
+		// The orchestrator no longer has logs once the job's allocations are
+		// garbage collected, in which case the archived logs are returned.
+		archived, archiveErr := loadArchivedJobLogs(db, store, modelId, job, nil)
+		if archiveErr != nil {
+			slog.Error("error loading archived job logs", "model_id", modelId, "job", job, "error", archiveErr)
+		}
+		if archived != nil {
+			utils.WriteJsonResponse(w, archived)
+			return
+		}
+	}
 	if err != nil {
 		slog.Error("error retrieving job logs from nomad", "error", err)
 		http.Error(w, "error getting logs from nomad", http.StatusInternalServerError)
@@ -399,6 +677,32 @@ func verifyLicenseForNewJob(orchestratorClient orchestrator.Client, license *lic
 	return licenseData.BoltLicenseKey, nil
 }
 
+// verifyLicenseForUserJob verifies the license for a new job of the user, and
+// checks that the job fits in the cpu allocated to the user's organization.
+func verifyLicenseForUserJob(db *gorm.DB, orchestratorClient orchestrator.Client, license *licensing.LicenseVerifier, userId uuid.UUID, jobCpuUsage int) (string, error) {
+	if err := checkOrganizationCpuAllocation(db, userId, jobCpuUsage); err != nil {
+		return "", err
+	}
+	return verifyLicenseForNewJob(orchestratorClient, license, jobCpuUsage)
+}
+
+// jobStartError converts an error from starting a job into the error returned
+// to the user, which only includes the details if the image was blocked.
+func jobStartError(err error, msg string) error {
+	if errors.Is(err, imagescan.ErrImageBlocked) {
+		return CodedError(err, http.StatusUnprocessableEntity)
+	}
+	return CodedError(errors.New(msg), http.StatusInternalServerError)
+}
+
+func duplicateModelError(modelName string, userId uuid.UUID) error {
+	return CodedErrorWithErrorCode(fmt.Errorf("a model with name %v already exists for user %v", modelName, userId), http.StatusConflict, utils.ErrorCodeDuplicateName)
+}
+
+// checkForDuplicateModel gives a clear error before any other work is done for
+// a new model. It does not prevent concurrent requests from creating models
+// with the same name, that is enforced by the unique index on (user_id, name),
+// so inserts must also check for gorm.ErrDuplicatedKey.
 func checkForDuplicateModel(db *gorm.DB, modelName string, userId uuid.UUID) error {
 	var duplicateModel schema.Model
 	result := db.Limit(1).Find(&duplicateModel, "user_id = ? AND name = ?", userId, modelName)
@@ -407,7 +711,7 @@ func checkForDuplicateModel(db *gorm.DB, modelName string, userId uuid.UUID) err
 		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 	}
 	if result.RowsAffected != 0 {
-		return CodedError(fmt.Errorf("a model with name %v already exists for user %v", modelName, userId), http.StatusConflict)
+		return duplicateModelError(modelName, userId)
 	}
 	return nil
 }
@@ -484,11 +788,14 @@ func saveModel(txn *gorm.DB, model schema.Model, user schema.User) error {
 
 	result := txn.Create(&model)
 	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return duplicateModelError(model.Name, model.UserId)
+		}
 		slog.Error("sql error creating new model entry", "error", result.Error)
 		return CodedError(schema.ErrDbAccessFailed, http.StatusInternalServerError)
 	}
 
-	return nil
+	return recordModelEvent(txn, schema.ModelCreatedEvent, model, map[string]interface{}{"name": model.Name, "type": model.Type, "user_id": model.UserId})
 }
 
 func checkDiskUsage(storage storage.Storage) error {
@@ -504,17 +811,31 @@ func checkDiskUsage(storage storage.Storage) error {
 		used := (stats.TotalBytes - stats.FreeBytes) / oneMib
 		total := stats.TotalBytes / oneMib
 		delta := (threshold - stats.FreeBytes) / oneMib
-		return CodedError(fmt.Errorf("insufficient disk space available, usage: %d/%d Mib, please clear %d Mib", used, total, delta), http.StatusInsufficientStorage)
+		return CodedError(fmt.Errorf("%w, usage: %d/%d Mib, please clear %d Mib", ErrInsufficientStorage, used, total, delta), http.StatusInsufficientStorage)
 	}
 	return nil
 }
 
+// Uploads and downloads can take much longer than the server timeouts allow for
+// regular requests, so those routes have their own limits. Log streams are open
+// for as long as the job runs, so they have no write deadline, disconnected
+// clients are detected when the keep-alive comments fail to write.
+var (
+	uploadTimeouts    = utils.WithTimeouts(30*time.Minute, 30*time.Minute)
+	downloadTimeouts  = utils.WithTimeouts(2*time.Minute, 2*time.Hour)
+	logStreamTimeouts = utils.WithTimeouts(2*time.Minute, 0)
+
+	// Profile captures run for up to maxCpuProfileSeconds before the profiles
+	// are written to storage.
+	profileCaptureTimeouts = utils.WithTimeouts(2*time.Minute, maxCpuProfileSeconds*time.Second+5*time.Minute)
+)
+
 func checkSufficientStorage(storage storage.Storage) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		handler := func(w http.ResponseWriter, r *http.Request) {
 			if err := checkDiskUsage(storage); err != nil {
 				slog.Error(err.Error())
-				http.Error(w, err.Error(), GetResponseCode(err))
+				writeError(w, err.Error(), err)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -534,6 +855,16 @@ func checkTeamExists(txn *gorm.DB, teamId uuid.UUID) error {
 	return nil
 }
 
+func checkOrganizationExists(txn *gorm.DB, orgId uuid.UUID) error {
+	if _, err := schema.GetOrganization(orgId, txn); err != nil {
+		if errors.Is(err, schema.ErrOrgNotFound) {
+			return CodedError(err, http.StatusNotFound)
+		}
+		return CodedError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
 func checkUserExists(txn *gorm.DB, userId uuid.UUID) error {
 	if _, err := schema.GetUser(userId, txn); err != nil {
 		if errors.Is(err, schema.ErrUserNotFound) {
@@ -2,7 +2,12 @@ package services
 
 import (
 	"fmt"
+	"strings"
+	"thirdai_platform/model_bazaar/mail"
 	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/ratelimit"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
 )
 
 type DockerRegistry struct {
@@ -22,8 +27,69 @@ type Variables struct {
 	CloudCredentials orchestrator.CloudCredentials
 
 	LlmProviders map[string]string
+
+	// JobLogRetention is how long archived job logs are kept, 0 keeps them forever.
+	JobLogRetention time.Duration
+
+	// AuditRetention is how long platform events are kept, 0 keeps them forever.
+	// Events are not deleted while there is a legal hold on audit data.
+	AuditRetention time.Duration
+
+	// AuditLogPath is the file that the audit log middleware writes to, it is
+	// included in audit exports.
+	AuditLogPath string
+
+	// EnableProfiling exposes pprof endpoints for admins on model bazaar and ndb deployments.
+	EnableProfiling bool
+
+	// OutboundJobEnv configures the outbound proxy in train and deploy jobs.
+	OutboundJobEnv map[string]string
+
+	RateLimits ratelimit.Config
+
+	// QueueTrainJobs queues train jobs that would exceed the license cpu limit
+	// instead of rejecting them, they are started once there is capacity.
+	QueueTrainJobs bool
+
+	// UserJobLimits are the default limits on the train jobs and deployments
+	// each user can run at the same time, admins can override them per user.
+	UserJobLimits JobLimits
+
+	// StorageQuotas are the maximum size in bytes of the artifacts of a model,
+	// by model type. Types without a quota are unlimited.
+	StorageQuotas map[string]int64
+
+	// ArtifactCompression is how model download archives are stored, either
+	// zip, or zstd to store them as zstd compressed archives.
+	ArtifactCompression string
+
+	// TeamNamespaces runs the jobs of models that belong to a team in a nomad
+	// namespace for the team, so that nomad ACLs can be applied per team.
+	TeamNamespaces bool
+
+	// Mailer sends the weekly health report to admins, the report is not sent
+	// if it is nil.
+	Mailer mail.Sender
+
+	// InactiveDeployments flags deployments that are not queried or retrained
+	// for a period, and can stop them after a grace period.
+	InactiveDeployments InactivityPolicy
+
+	// StorageTiering moves the artifacts of models that are not used for a
+	// period to cold storage.
+	StorageTiering TieringPolicy
+
+	// MetricsEndpoint is the victoria metrics server that deployment traffic is
+	// read from for the health report, it defaults to the victoria metrics
+	// proxy of model bazaar.
+	MetricsEndpoint string
 }
 
+const (
+	ArtifactCompressionZip  = "zip"
+	ArtifactCompressionZstd = "zstd"
+)
+
 func (vars *Variables) DockerEnv() orchestrator.DockerEnv {
 	return orchestrator.DockerEnv{
 		Registry:       vars.DockerRegistry.Registry,
@@ -33,6 +99,22 @@ func (vars *Variables) DockerEnv() orchestrator.DockerEnv {
 	}
 }
 
+func (vars *Variables) metricsEndpoint() string {
+	if vars.MetricsEndpoint != "" {
+		return strings.TrimSuffix(vars.MetricsEndpoint, "/")
+	}
+	return strings.TrimSuffix(vars.ModelBazaarEndpoint, "/") + "/victoriametrics"
+}
+
+// jobNamespace returns the namespace for the jobs of the model, an empty
+// namespace uses the default namespace of the orchestrator.
+func (vars *Variables) jobNamespace(model schema.Model) string {
+	if !vars.TeamNamespaces || model.TeamId == nil {
+		return ""
+	}
+	return orchestrator.TeamNamespace(model.TeamId.String())
+}
+
 func (vars *Variables) GenaiKey(provider string) (string, error) {
 	if provider == "on-prem" {
 		return "", nil
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	webhookCursor = "webhooks"
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIdHeader        = "X-Webhook-Id"
+)
+
+// WebhookSignature returns the signature sent in the X-Webhook-Signature header,
+// which is the hex encoded HMAC-SHA256 of the timestamp and the body, joined by
+// a '.', using the secret of the webhook.
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookPayload is the body of the request sent to a webhook. The text field
+// is a summary of the event, so that the payload can be sent directly to chat
+// integrations such as Slack incoming webhooks.
+type WebhookPayload struct {
+	EventId        uint64    `json:"event_id"`
+	Event          string    `json:"event"`
+	ModelId        uuid.UUID `json:"model_id"`
+	ModelName      string    `json:"model_name"`
+	ModelType      string    `json:"model_type"`
+	Job            string    `json:"job"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status"`
+	Message        string    `json:"message,omitempty"`
+	Text           string    `json:"text"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// WebhookDispatcher delivers status events from the platform event log to the
+// registered webhooks. Deliveries are triggered after jobs update their status
+// and by the status sync, which also catches any events that were missed.
+type WebhookDispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+
+	maxAttempts int
+	backoff     time.Duration
+
+	// Only one delivery runs at a time so that events are not sent twice.
+	lock sync.Mutex
+}
+
+func NewWebhookDispatcher(db *gorm.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second, Transport: utils.OutboundTransport()},
+		maxAttempts: 3,
+		backoff:     500 * time.Millisecond,
+	}
+}
+
+// Notify delivers any new events in the background.
+func (d *WebhookDispatcher) Notify() {
+	go d.deliver()
+}
+
+func (d *WebhookDispatcher) deliver() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for {
+		delivered, err := d.deliverBatch(100)
+		if err != nil {
+			slog.Error("webhooks: error delivering events", "error", err)
+			return
+		}
+		if delivered < 100 {
+			return
+		}
+	}
+}
+
+type statusEventData struct {
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status"`
+	Message        string `json:"message"`
+}
+
+func webhookEvent(event schema.PlatformEvent) (string, string, statusEventData, bool) {
+	job := "train"
+	switch event.Type {
+	case schema.DeployStatusEvent:
+		job = "deploy"
+	case schema.DeploymentSloEvent:
+		job = "slo"
+	}
+
+	var data statusEventData
+	if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+		slog.Error("webhooks: invalid status event data", "event_id", event.Id, "error", err)
+		return "", "", data, false
+	}
+	// Jobs can report the same status multiple times, which is not a change.
+	if data.Status == data.PreviousStatus && data.Message == "" {
+		return "", "", data, false
+	}
+
+	return job + "." + data.Status, job, data, true
+}
+
+func webhookMatches(webhook schema.Webhook, event string) bool {
+	job, _, _ := strings.Cut(event, ".")
+	for _, e := range strings.Split(webhook.Events, ",") {
+		if e == event || e == job+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverBatch sends the next batch of status events to the matching webhooks
+// and returns the number of events processed. Webhooks only receive events
+// from after they were created. A delivery that still fails after retrying is
+// recorded on the webhook and skipped, so that one unavailable endpoint does
+// not block the others.
+func (d *WebhookDispatcher) deliverBatch(limit int) (int, error) {
+	var webhooks []schema.Webhook
+	if err := d.db.Find(&webhooks).Error; err != nil {
+		slog.Error("sql error listing webhooks", "error", err)
+		return 0, schema.ErrDbAccessFailed
+	}
+
+	var cursor schema.EventPublisherCursor
+	if err := d.db.Limit(1).Find(&cursor, "publisher = ?", webhookCursor).Error; err != nil {
+		slog.Error("sql error loading webhook cursor", "error", err)
+		return 0, schema.ErrDbAccessFailed
+	}
+
+	var batch []schema.PlatformEvent
+	result := d.db.
+		Where("id > ?", cursor.Cursor).
+		Where("type IN ?", []string{schema.TrainStatusEvent, schema.DeployStatusEvent, schema.DeploymentSloEvent}).
+		Order("id ASC").Limit(limit).Find(&batch)
+	if result.Error != nil {
+		slog.Error("sql error listing events for webhooks", "error", result.Error)
+		return 0, schema.ErrDbAccessFailed
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	models := make(map[uuid.UUID]schema.Model)
+	if len(webhooks) > 0 {
+		modelIds := make([]uuid.UUID, 0, len(batch))
+		for _, event := range batch {
+			if event.ModelId != nil {
+				modelIds = append(modelIds, *event.ModelId)
+			}
+		}
+		var found []schema.Model
+		if err := d.db.Where("id IN ?", modelIds).Find(&found).Error; err != nil {
+			slog.Error("sql error loading models for webhooks", "error", err)
+			return 0, schema.ErrDbAccessFailed
+		}
+		for _, model := range found {
+			models[model.Id] = model
+		}
+	}
+
+	for _, event := range batch {
+		if len(webhooks) == 0 || event.ModelId == nil {
+			continue
+		}
+
+		name, job, data, ok := webhookEvent(event)
+		if !ok {
+			continue
+		}
+
+		model := models[*event.ModelId]
+		payload := WebhookPayload{
+			EventId:        event.Id,
+			Event:          name,
+			ModelId:        *event.ModelId,
+			ModelName:      model.Name,
+			ModelType:      model.Type,
+			Job:            job,
+			Status:         data.Status,
+			PreviousStatus: data.PreviousStatus,
+			Message:        data.Message,
+			Timestamp:      event.Timestamp.UTC(),
+		}
+		payload.Text = fmt.Sprintf("%v job for model '%v' (%v) is %v", job, model.Name, event.ModelId, data.Status)
+		if job == "slo" {
+			payload.Text = fmt.Sprintf("slo for deployment '%v' (%v) is %v", model.Name, event.ModelId, data.Status)
+		}
+		if data.Message != "" {
+			payload.Text += ": " + data.Message
+		}
+
+		for i := range webhooks {
+			webhook := &webhooks[i]
+			if event.Timestamp.Before(webhook.CreatedAt) || !webhookMatches(*webhook, name) {
+				continue
+			}
+			d.send(webhook, payload)
+		}
+	}
+
+	last := batch[len(batch)-1].Id
+	if err := d.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&schema.EventPublisherCursor{Publisher: webhookCursor, Cursor: last}).Error; err != nil {
+		slog.Error("sql error saving webhook cursor", "error", err)
+		return 0, schema.ErrDbAccessFailed
+	}
+
+	return len(batch), nil
+}
+
+func (d *WebhookDispatcher) post(webhook *schema.Webhook, payload WebhookPayload, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, payload.Event)
+	req.Header.Set(WebhookIdHeader, strconv.FormatUint(payload.EventId, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(webhook.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		content, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("webhook returned status %d: %v", res.StatusCode, string(content))
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) send(webhook *schema.Webhook, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("webhooks: error serializing payload", "webhook_id", webhook.Id, "event_id", payload.EventId, "error", err)
+		return
+	}
+
+	backoff := d.backoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if err = d.post(webhook, payload, body); err == nil {
+			break
+		}
+		slog.Warn("webhooks: delivery failed", "webhook_id", webhook.Id, "event_id", payload.EventId, "attempt", attempt, "error", err)
+		if attempt < d.maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	updates := map[string]interface{}{"last_delivery_at": time.Now().UTC(), "last_error": ""}
+	if err != nil {
+		slog.Error("webhooks: unable to deliver event", "webhook_id", webhook.Id, "event_id", payload.EventId, "error", err)
+		updates["last_error"] = err.Error()
+	}
+	if result := d.db.Model(&schema.Webhook{}).Where("id = ?", webhook.Id).Updates(updates); result.Error != nil {
+		slog.Error("sql error recording webhook delivery", "webhook_id", webhook.Id, "error", result.Error)
+	}
+}
+
+type WebhookService struct {
+	db       *gorm.DB
+	userAuth auth.IdentityProvider
+}
+
+func (s *WebhookService) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(s.userAuth.AuthMiddleware()...)
+	r.Use(auth.AdminOnly(s.db))
+
+	r.Get("/", s.List)
+	r.Post("/", s.Create)
+	r.Delete("/{webhook_id}", s.Delete)
+
+	return r
+}
+
+type WebhookInfo struct {
+	Id             uuid.UUID  `json:"id"`
+	Name           string     `json:"name"`
+	Url            string     `json:"url"`
+	Events         []string   `json:"events"`
+	Secret         string     `json:"secret,omitempty"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func webhookInfo(webhook schema.Webhook) WebhookInfo {
+	return WebhookInfo{
+		Id:             webhook.Id,
+		Name:           webhook.Name,
+		Url:            webhook.Url,
+		Events:         strings.Split(webhook.Events, ","),
+		LastDeliveryAt: utcTime(webhook.LastDeliveryAt),
+		LastError:      webhook.LastError,
+		CreatedAt:      webhook.CreatedAt.UTC(),
+	}
+}
+
+// List returns the webhooks without their secrets.
+func (s *WebhookService) List(w http.ResponseWriter, r *http.Request) {
+	var webhooks []schema.Webhook
+	if err := s.db.Order("created_at").Find(&webhooks).Error; err != nil {
+		slog.Error("sql error listing webhooks", "error", err)
+		http.Error(w, fmt.Sprintf("error listing webhooks: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]WebhookInfo, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		infos = append(infos, webhookInfo(webhook))
+	}
+
+	utils.WriteJsonResponse(w, infos)
+}
+
+type createWebhookRequest struct {
+	Name   string   `json:"name"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+func validateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("at least one event must be specified")
+	}
+	for _, event := range events {
+		job, status, ok := strings.Cut(event, ".")
+		if !ok || (job != "train" && job != "deploy" && job != "slo") {
+			return fmt.Errorf("invalid event '%v', events must have the form 'train.<status>', 'deploy.<status>', or 'slo.<status>'", event)
+		}
+		if status == "*" {
+			continue
+		}
+		if job == "slo" {
+			if status != schema.SloBreached && status != schema.SloMet {
+				return fmt.Errorf("invalid event '%v', slo events must be 'slo.%v' or 'slo.%v'", event, schema.SloBreached, schema.SloMet)
+			}
+			continue
+		}
+		if err := schema.CheckValidStatus(status); err != nil {
+			return fmt.Errorf("invalid event '%v': %w", event, err)
+		}
+	}
+	return nil
+}
+
+// Create registers a webhook. If a secret is not specified one is generated,
+// the secret is only returned in this response.
+func (s *WebhookService) Create(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var params createWebhookRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > 100 {
+		http.Error(w, "name must be between 1 and 100 characters", http.StatusUnprocessableEntity)
+		return
+	}
+
+	parsed, err := url.Parse(params.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		http.Error(w, fmt.Sprintf("invalid webhook url '%v', must be an http or https url", params.Url), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := validateWebhookEvents(params.Events); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if params.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			slog.Error("error generating webhook secret", "error", err)
+			http.Error(w, "error generating webhook secret", http.StatusInternalServerError)
+			return
+		}
+		params.Secret = hex.EncodeToString(secret)
+	}
+
+	slices.Sort(params.Events)
+	webhook := schema.Webhook{
+		Id:        uuid.New(),
+		Name:      params.Name,
+		Url:       params.Url,
+		Events:    strings.Join(slices.Compact(params.Events), ","),
+		Secret:    params.Secret,
+		CreatedBy: user.Id,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.db.Create(&webhook).Error; err != nil {
+		slog.Error("sql error creating webhook", "error", err)
+		http.Error(w, fmt.Sprintf("error creating webhook: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("created webhook", "webhook_id", webhook.Id, "name", webhook.Name, "events", webhook.Events)
+
+	info := webhookInfo(webhook)
+	info.Secret = webhook.Secret
+	utils.WriteJsonResponse(w, info)
+}
+
+func (s *WebhookService) Delete(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := utils.URLParamUUID(r, "webhook_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := s.db.Delete(&schema.Webhook{}, "id = ?", webhookId)
+	if result.Error != nil {
+		slog.Error("sql error deleting webhook", "webhook_id", webhookId, "error", result.Error)
+		http.Error(w, fmt.Sprintf("error deleting webhook: %v", schema.ErrDbAccessFailed), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, fmt.Sprintf("webhook %v not found", webhookId), http.StatusNotFound)
+		return
+	}
+
+	utils.WriteSuccess(w)
+}
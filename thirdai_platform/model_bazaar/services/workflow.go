@@ -1,6 +1,7 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -13,6 +14,7 @@ import (
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/storage"
 	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -24,6 +26,9 @@ type WorkflowService struct {
 	db       *gorm.DB
 	storage  storage.Storage
 	userAuth auth.IdentityProvider
+
+	// Used to start the train jobs for workflow templates.
+	train TrainService
 }
 
 func (s *WorkflowService) Routes() chi.Router {
@@ -33,17 +38,48 @@ func (s *WorkflowService) Routes() chi.Router {
 
 	r.Post("/enterprise-search", s.EnterpriseSearch)
 	r.Post("/knowledge-extraction", s.KnowledgeExtraction)
+	r.Post("/ensemble", s.Ensemble)
+
+	r.Get("/templates", s.ListTemplates)
+	r.Get("/templates/{name}", s.GetTemplate)
+	r.Post("/templates/{name}", s.InstantiateTemplate)
+
+	r.Route("/{model_id}", func(r chi.Router) {
+		r.Use(resolveModelAlias(s.db))
+
+		r.With(auth.ModelPermissionOnly(s.db, auth.ReadPermission)).Get("/redaction-policy", s.GetRedactionPolicy)
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.ModelPermissionOnly(s.db, auth.OwnerPermission))
+
+			r.Post("/redaction-policy", s.SetRedactionPolicy)
+			r.Get("/unredact-permissions", s.ListUnredactGrants)
+			r.Post("/unredact-permissions", s.AddUnredactGrant)
+			r.Delete("/unredact-permissions/{user_id}", s.DeleteUnredactGrant)
+		})
+	})
 
 	return r
 }
 
 type EnterpriseSearchRequest struct {
-	ModelName       string     `json:"model_name"`
-	RetrievalId     uuid.UUID  `json:"retrieval_id"`
+	ModelName       string     `json:"model_name" validate:"required"`
+	RetrievalId     uuid.UUID  `json:"retrieval_id" validate:"required"`
 	GuardrailId     *uuid.UUID `json:"guardrail_id"`
 	LlmProvider     *string    `json:"llm_provider"`
 	NlpClassifierId *uuid.UUID `json:"nlp_classifier_id"`
 	DefaultMode     *string    `json:"default_mode"`
+
+	// RedactionPolicy requires a guardrail, by default entities are tokenized.
+	RedactionPolicy *RedactionPolicy `json:"redaction_policy"`
+}
+
+func (r *EnterpriseSearchRequest) validate() error {
+	errs := validation.Struct(r)
+	if r.RedactionPolicy != nil && r.GuardrailId == nil {
+		errs.Add("redaction_policy", "a redaction policy requires a guardrail_id")
+	}
+	return errs.Err()
 }
 
 type searchComponent struct {
@@ -63,6 +99,32 @@ func (r *EnterpriseSearchRequest) components() []searchComponent {
 	return components
 }
 
+// checkWorkflowComponent verifies that the model for a workflow component exists,
+// has the expected type, and can be read by the user.
+func checkWorkflowComponent(txn *gorm.DB, user schema.User, component searchComponent) error {
+	model, err := schema.GetModel(component.id, txn, false, false, false)
+	if err != nil {
+		if errors.Is(err, schema.ErrModelNotFound) {
+			return CodedErrorWithErrorCode(fmt.Errorf("model %v for component %s does not exist", component.id, component.component), http.StatusNotFound, utils.ErrorCodeModelNotFound)
+		}
+		return CodedError(fmt.Errorf("error loading model for component %s: %w", component.component, schema.ErrDbAccessFailed), http.StatusInternalServerError)
+	}
+	if model.Type != component.expectedType {
+		return CodedError(fmt.Errorf("component %v was expected to have type %v, but specified model has type %v", component.component, component.expectedType, model.Type), http.StatusUnprocessableEntity)
+	}
+
+	perm, err := auth.GetModelPermissions(model.Id, user, txn)
+	if err != nil {
+		slog.Error("error verify permissions for component of workflow", "model_id", component.id, "error", err)
+		return CodedError(fmt.Errorf("error verifying permissions for component %v", component.component), http.StatusInternalServerError)
+	}
+	if perm < auth.ReadPermission {
+		return CodedError(fmt.Errorf("user does not have permissions to access %v", component.component), http.StatusForbidden)
+	}
+
+	return nil
+}
+
 func (s *WorkflowService) EnterpriseSearch(w http.ResponseWriter, r *http.Request) {
 	var params EnterpriseSearchRequest
 	if !utils.ParseRequestBody(w, r, &params) {
@@ -75,35 +137,34 @@ func (s *WorkflowService) EnterpriseSearch(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid enterprise search request: %v", err), err)
+		return
+	}
+
+	modelId, err := createEnterpriseSearch(s.db, user, params)
+	if err != nil {
+		writeError(w, fmt.Sprintf("error creating enterprise search model: %v", err), err)
+		return
+	}
+
+	utils.WriteJsonResponse(w, trainResponse{ModelId: modelId})
+}
+
+func createEnterpriseSearch(db *gorm.DB, user schema.User, params EnterpriseSearchRequest) (uuid.UUID, error) {
 	modelId := uuid.New()
 
-	err = s.db.Transaction(func(txn *gorm.DB) error {
+	err := db.Transaction(func(txn *gorm.DB) error {
 		components := params.components()
 		deps := make([]schema.ModelDependency, 0, len(components))
 		// Each component is stored as an attribute, and then we have 2 additional hyperparameters
 		attrs := make([]schema.ModelAttribute, 0, len(components)+2)
 		for _, component := range components {
-			model, err := schema.GetModel(component.id, txn, false, false, false)
-			if err != nil {
-				if errors.Is(err, schema.ErrModelNotFound) {
-					return CodedError(fmt.Errorf("model %v for component %s does not exist", component.id, component.component), http.StatusNotFound)
-				}
-				return CodedError(fmt.Errorf("error loading model for component %s: %w", component.component, schema.ErrDbAccessFailed), http.StatusInternalServerError)
-			}
-			if model.Type != component.expectedType {
-				return CodedError(fmt.Errorf("component %v was expected to have type %v, but specified model has type %v", component.component, component.expectedType, model.Type), http.StatusUnprocessableEntity)
+			if err := checkWorkflowComponent(txn, user, component); err != nil {
+				return err
 			}
 
-			perm, err := auth.GetModelPermissions(model.Id, user, txn)
-			if err != nil {
-				slog.Error("error verify permissions for component of enterprise search workflow", "model_id", component.id, "error", err)
-				return CodedError(fmt.Errorf("error verifying permissions for component %v", component.component), http.StatusInternalServerError)
-			}
-			if perm < auth.ReadPermission {
-				return CodedError(fmt.Errorf("user does not have permissions to access %v", component.component), http.StatusForbidden)
-			}
-
-			deps = append(deps, schema.ModelDependency{ModelId: modelId, DependencyId: model.Id})
+			deps = append(deps, schema.ModelDependency{ModelId: modelId, DependencyId: component.id})
 			attrs = append(attrs, schema.ModelAttribute{ModelId: modelId, Key: component.component, Value: component.id.String()})
 		}
 
@@ -113,6 +174,13 @@ func (s *WorkflowService) EnterpriseSearch(w http.ResponseWriter, r *http.Reques
 		if params.DefaultMode != nil {
 			attrs = append(attrs, schema.ModelAttribute{ModelId: modelId, Key: "default_mode", Value: *params.DefaultMode})
 		}
+		if params.RedactionPolicy != nil {
+			policyJson, err := json.Marshal(params.RedactionPolicy)
+			if err != nil {
+				return CodedError(fmt.Errorf("error serializing redaction policy: %w", err), http.StatusInternalServerError)
+			}
+			attrs = append(attrs, schema.ModelAttribute{ModelId: modelId, Key: redactionPolicyAttr, Value: string(policyJson)})
+		}
 
 		model := newModel(modelId, params.ModelName, schema.EnterpriseSearch, nil, user.Id)
 		model.TrainStatus = schema.Complete
@@ -121,9 +189,87 @@ func (s *WorkflowService) EnterpriseSearch(w http.ResponseWriter, r *http.Reques
 
 		return saveModel(txn, model, user)
 	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return modelId, nil
+}
+
+const (
+	EnsembleMajorityVote = "majority_vote"
+	EnsembleScoreAverage = "score_average"
+)
+
+type EnsembleRequest struct {
+	ModelName string      `json:"model_name" validate:"required"`
+	ModelIds  []uuid.UUID `json:"model_ids" validate:"required,min=2"`
+	Strategy  string      `json:"strategy" validate:"oneof=majority_vote score_average"`
+}
+
+func (r *EnsembleRequest) validate() error {
+	errs := validation.Struct(r)
+
+	seen := make(map[uuid.UUID]bool)
+	for i, id := range r.ModelIds {
+		if seen[id] {
+			errs.Add(fmt.Sprintf("model_ids[%d]", i), "model %v is specified multiple times in ensemble", id)
+		}
+		seen[id] = true
+	}
+
+	if r.Strategy == "" {
+		r.Strategy = EnsembleScoreAverage
+	}
+
+	return errs.Err()
+}
+
+func (s *WorkflowService) Ensemble(w http.ResponseWriter, r *http.Request) {
+	var params EnsembleRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := params.validate(); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid ensemble request: %v", err), err)
+		return
+	}
+
+	modelId := uuid.New()
+
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		deps := make([]schema.ModelDependency, 0, len(params.ModelIds))
+		memberIds := make([]string, 0, len(params.ModelIds))
+		for i, id := range params.ModelIds {
+			component := searchComponent{component: fmt.Sprintf("model_ids[%d]", i), id: id, expectedType: schema.NlpTextModel}
+			if err := checkWorkflowComponent(txn, user, component); err != nil {
+				return err
+			}
+
+			deps = append(deps, schema.ModelDependency{ModelId: modelId, DependencyId: id})
+			memberIds = append(memberIds, id.String())
+		}
+
+		model := newModel(modelId, params.ModelName, schema.EnsembleModel, nil, user.Id)
+		model.TrainStatus = schema.Complete
+		model.Dependencies = deps
+		model.Attributes = []schema.ModelAttribute{
+			{ModelId: modelId, Key: "model_ids", Value: strings.Join(memberIds, ",")},
+			{ModelId: modelId, Key: "strategy", Value: params.Strategy},
+		}
+
+		return saveModel(txn, model, user)
+	})
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error creating enterprise search model: %v", err), GetResponseCode(err))
+		writeError(w, fmt.Sprintf("error creating ensemble model: %v", err), err)
 		return
 	}
 
@@ -131,13 +277,13 @@ func (s *WorkflowService) EnterpriseSearch(w http.ResponseWriter, r *http.Reques
 }
 
 type QuestionKeywords struct {
-	Question string   `json:"question"`
+	Question string   `json:"question" validate:"required"`
 	Keywords []string `json:"keywords"`
 }
 
 type KnowledgeExtractionRequest struct {
-	ModelName string             `json:"model_name"`
-	Questions []QuestionKeywords `json:"questions"`
+	ModelName string             `json:"model_name" validate:"required"`
+	Questions []QuestionKeywords `json:"questions" validate:"required"`
 
 	LlmProvider      string
 	AdvancedIndexing *bool `json:"advanced_indexing"`
@@ -146,18 +292,13 @@ type KnowledgeExtractionRequest struct {
 }
 
 func (r *KnowledgeExtractionRequest) validate() error {
-	if r.ModelName == "" {
-		return fmt.Errorf("model_name must be specified")
-	}
-	if len(r.Questions) == 0 {
-		return fmt.Errorf("must provide at least one question to create knowledge extraction model")
-	}
+	errs := validation.Struct(r)
 
 	questions := make(map[string]bool)
-	for _, q := range r.Questions {
+	for i, q := range r.Questions {
 		qLower := strings.ToLower(q.Question)
 		if questions[qLower] {
-			return fmt.Errorf("duplicate question '%v' detected", q.Question)
+			errs.Add(fmt.Sprintf("questions[%d].question", i), "duplicate question '%v' detected", q.Question)
 		}
 		questions[qLower] = true
 	}
@@ -174,10 +315,10 @@ func (r *KnowledgeExtractionRequest) validate() error {
 	}
 
 	if r.LlmProvider == "" && *r.GenerateAnswers {
-		return fmt.Errorf("llm_provider must be be specifed unless generate_answers is false")
+		errs.Add("LlmProvider", "llm_provider must be be specifed unless generate_answers is false")
 	}
 
-	return nil
+	return errs.Err()
 }
 
 type Question struct {
@@ -271,7 +412,7 @@ func (s *WorkflowService) KnowledgeExtraction(w http.ResponseWriter, r *http.Req
 	}
 
 	if err := params.validate(); err != nil {
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		utils.WriteValidationError(w, fmt.Sprintf("invalid knowledge extraction request: %v", err), err)
 		return
 	}
 
@@ -291,20 +432,26 @@ func (s *WorkflowService) KnowledgeExtraction(w http.ResponseWriter, r *http.Req
 	})
 	if err != nil {
 		slog.Error("error creating knowledge extraction model", "error", err)
-		http.Error(w, "error creating knowledge extraction model", GetResponseCode(err))
+		writeError(w, "error creating knowledge extraction model", err)
 		return
 	}
 
 	err = s.createQuestionDb(modelId, params.Questions)
 	if err != nil {
-		http.Error(w, err.Error(), GetResponseCode(err))
+		writeError(w, err.Error(), err)
 		return
 	}
 
-	result := s.db.Model(&schema.Model{}).Where("id = ?", modelId).Update("train_status", schema.Complete)
-	if result.Error != nil {
-		slog.Error("error updating knowledge extraction train status", "error", result.Error)
-		http.Error(w, "error updating knowledge extraction train status", http.StatusInternalServerError)
+	err = s.db.Transaction(func(txn *gorm.DB) error {
+		model, err := schema.GetModel(modelId, txn, false, false, false)
+		if err != nil {
+			return CodedError(err, http.StatusInternalServerError)
+		}
+		return updateStatus(txn, "train", &model, schema.Complete, "")
+	})
+	if err != nil {
+		slog.Error("error updating knowledge extraction train status", "error", err)
+		writeError(w, "error updating knowledge extraction train status", err)
 		return
 	}
 
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"thirdai_platform/utils/validation"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type WorkflowTemplateInput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+type WorkflowTemplateInfo struct {
+	Name        string                  `json:"name"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description"`
+	Inputs      []WorkflowTemplateInput `json:"inputs"`
+	// The types of the models created by the template.
+	ModelTypes []string `json:"model_types"`
+}
+
+// WorkflowTemplateRequest contains the inputs for all templates, each template
+// only accepts the inputs listed in its info.
+type WorkflowTemplateRequest struct {
+	ModelName string `json:"model_name" validate:"required"`
+
+	Documents      []config.TrainFile `json:"documents"`
+	LabeledFiles   []config.TrainFile `json:"labeled_files"`
+	TextColumn     string             `json:"text_column"`
+	LabelColumn    string             `json:"label_column"`
+	NTargetClasses int                `json:"n_target_classes"`
+	GuardrailId    *uuid.UUID         `json:"guardrail_id"`
+	LlmProvider    *string            `json:"llm_provider"`
+
+	JobOptions config.JobOptions `json:"job_options"`
+}
+
+func (r *WorkflowTemplateRequest) inputs() map[string]bool {
+	return map[string]bool{
+		"documents":        len(r.Documents) > 0,
+		"labeled_files":    len(r.LabeledFiles) > 0,
+		"text_column":      r.TextColumn != "",
+		"label_column":     r.LabelColumn != "",
+		"n_target_classes": r.NTargetClasses != 0,
+		"guardrail_id":     r.GuardrailId != nil,
+		"llm_provider":     r.LlmProvider != nil,
+	}
+}
+
+type WorkflowTemplateModel struct {
+	ModelId   uuid.UUID `json:"model_id"`
+	ModelName string    `json:"model_name"`
+	ModelType string    `json:"model_type"`
+}
+
+type WorkflowTemplateResponse struct {
+	Template string `json:"template"`
+	// The model to deploy to use the workflow.
+	ModelId uuid.UUID               `json:"model_id"`
+	Models  []WorkflowTemplateModel `json:"models"`
+}
+
+// workflowTemplate resolves a template request into the train and workflow
+// requests that create its models. The last model returned is the one that is
+// deployed to use the workflow.
+type workflowTemplate struct {
+	WorkflowTemplateInfo
+
+	// The names of the models created by the template, so that duplicates can
+	// be reported before any models are created.
+	modelNames  func(req WorkflowTemplateRequest) []string
+	instantiate func(s *WorkflowService, user schema.User, req WorkflowTemplateRequest) ([]WorkflowTemplateModel, error)
+}
+
+var (
+	documentsInput = WorkflowTemplateInput{
+		Name: "documents", Required: true,
+		Description: "The uploaded documents to search, in the same format as the unsupervised_files of an ndb train request.",
+	}
+	llmProviderInput = WorkflowTemplateInput{
+		Name:        "llm_provider",
+		Description: "The llm provider used to generate answers from the search results.",
+	}
+)
+
+func (t workflowTemplate) validate(req WorkflowTemplateRequest) error {
+	errs := validation.Struct(&req)
+
+	inputs := req.inputs()
+	for _, input := range slices.Sorted(maps.Keys(inputs)) {
+		accepted := slices.ContainsFunc(t.Inputs, func(i WorkflowTemplateInput) bool { return i.Name == input })
+		if inputs[input] && !accepted {
+			errs.Add(input, "input '%v' is not used by template '%v'", input, t.Name)
+		}
+	}
+	for _, input := range t.Inputs {
+		if input.Required && !inputs[input.Name] {
+			errs.Add(input.Name, "input '%v' is required by template '%v'", input.Name, t.Name)
+		}
+	}
+
+	return errs.Err()
+}
+
+func searchTemplateModelNames(req WorkflowTemplateRequest) []string {
+	return []string{req.ModelName + "-retrieval", req.ModelName}
+}
+
+// instantiateSearchTemplate trains an ndb model on the documents and creates an
+// enterprise search workflow that uses it for retrieval.
+func instantiateSearchTemplate(s *WorkflowService, user schema.User, req WorkflowTemplateRequest) ([]WorkflowTemplateModel, error) {
+	if req.GuardrailId != nil {
+		// The guardrail is checked before training so that a request with an
+		// invalid guardrail does not leave behind an ndb model.
+		component := searchComponent{component: "guardrail_id", id: *req.GuardrailId, expectedType: schema.NlpTokenModel}
+		if err := checkWorkflowComponent(s.db, user, component); err != nil {
+			return nil, err
+		}
+	}
+
+	ndbRequest := NdbTrainRequest{
+		ModelName:  req.ModelName + "-retrieval",
+		Data:       config.NDBData{UnsupervisedFiles: req.Documents},
+		JobOptions: req.JobOptions,
+	}
+	if err := ndbRequest.validate(); err != nil {
+		return nil, CodedError(err, http.StatusUnprocessableEntity)
+	}
+	args, err := s.train.ndbTrainArgs(user.Id, ndbRequest)
+	if err != nil {
+		return nil, err
+	}
+	ndbId, err := s.train.startTraining(user, args)
+	if err != nil {
+		return nil, err
+	}
+	models := []WorkflowTemplateModel{{ModelId: ndbId, ModelName: ndbRequest.ModelName, ModelType: schema.NdbModel}}
+
+	searchRequest := EnterpriseSearchRequest{
+		ModelName:   req.ModelName,
+		RetrievalId: ndbId,
+		GuardrailId: req.GuardrailId,
+		LlmProvider: req.LlmProvider,
+	}
+	searchId, err := createEnterpriseSearch(s.db, user, searchRequest)
+	if err != nil {
+		return models, err
+	}
+	models = append(models, WorkflowTemplateModel{ModelId: searchId, ModelName: req.ModelName, ModelType: schema.EnterpriseSearch})
+
+	return models, nil
+}
+
+var workflowTemplates = []workflowTemplate{
+	{
+		WorkflowTemplateInfo: WorkflowTemplateInfo{
+			Name:        "document-search",
+			Title:       "Document search",
+			Description: "Search and question answering over a collection of documents.",
+			Inputs:      []WorkflowTemplateInput{documentsInput, llmProviderInput},
+			ModelTypes:  []string{schema.NdbModel, schema.EnterpriseSearch},
+		},
+		modelNames:  searchTemplateModelNames,
+		instantiate: instantiateSearchTemplate,
+	},
+	{
+		WorkflowTemplateInfo: WorkflowTemplateInfo{
+			Name:        "contract-search-with-guardrails",
+			Title:       "Contract search with guardrails",
+			Description: "Search and question answering over contracts, with sensitive entities such as names and account numbers redacted from queries and answers by an nlp-token model.",
+			Inputs: []WorkflowTemplateInput{
+				documentsInput,
+				{Name: "guardrail_id", Required: true, Description: "The id of an nlp-token model that detects the entities to redact."},
+				llmProviderInput,
+			},
+			ModelTypes: []string{schema.NdbModel, schema.EnterpriseSearch},
+		},
+		modelNames:  searchTemplateModelNames,
+		instantiate: instantiateSearchTemplate,
+	},
+	{
+		WorkflowTemplateInfo: WorkflowTemplateInfo{
+			Name:        "support-ticket-classifier",
+			Title:       "Support ticket classifier",
+			Description: "Routes support tickets to a category, such as billing or technical issues, learned from tickets that have already been labeled.",
+			Inputs: []WorkflowTemplateInput{
+				{Name: "labeled_files", Required: true, Description: "Uploaded csv files of labeled tickets, in the same format as the supervised_files of an nlp-text train request."},
+				{Name: "text_column", Required: true, Description: "The column of the csv files containing the ticket text."},
+				{Name: "label_column", Required: true, Description: "The column of the csv files containing the category of the ticket."},
+				{Name: "n_target_classes", Required: true, Description: "The number of categories."},
+			},
+			ModelTypes: []string{schema.NlpTextModel},
+		},
+		modelNames: func(req WorkflowTemplateRequest) []string { return []string{req.ModelName} },
+		instantiate: func(s *WorkflowService, user schema.User, req WorkflowTemplateRequest) ([]WorkflowTemplateModel, error) {
+			request := NlpTextTrainRequest{
+				ModelName: req.ModelName,
+				ModelOptions: &config.NlpTextOptions{
+					TextColumn:     req.TextColumn,
+					LabelColumn:    req.LabelColumn,
+					NTargetClasses: req.NTargetClasses,
+				},
+				Data:       config.NlpData{SupervisedFiles: req.LabeledFiles},
+				JobOptions: req.JobOptions,
+			}
+			if err := request.validate(); err != nil {
+				return nil, CodedError(err, http.StatusUnprocessableEntity)
+			}
+			args, err := s.train.nlpTextTrainArgs(user.Id, request)
+			if err != nil {
+				return nil, err
+			}
+			modelId, err := s.train.startTraining(user, args)
+			if err != nil {
+				return nil, err
+			}
+			return []WorkflowTemplateModel{{ModelId: modelId, ModelName: req.ModelName, ModelType: schema.NlpTextModel}}, nil
+		},
+	},
+}
+
+func getWorkflowTemplate(name string) (workflowTemplate, error) {
+	for _, template := range workflowTemplates {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return workflowTemplate{}, CodedError(fmt.Errorf("workflow template '%v' does not exist", name), http.StatusNotFound)
+}
+
+func (s *WorkflowService) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	infos := make([]WorkflowTemplateInfo, 0, len(workflowTemplates))
+	for _, template := range workflowTemplates {
+		infos = append(infos, template.WorkflowTemplateInfo)
+	}
+	utils.WriteJsonResponse(w, infos)
+}
+
+func (s *WorkflowService) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := getWorkflowTemplate(chi.URLParam(r, "name"))
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+	utils.WriteJsonResponse(w, template.WorkflowTemplateInfo)
+}
+
+// InstantiateTemplate creates the models for a template from the user's data.
+// The models are trained in the background, like models created with the train
+// endpoints.
+func (s *WorkflowService) InstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := getWorkflowTemplate(chi.URLParam(r, "name"))
+	if err != nil {
+		writeError(w, err.Error(), err)
+		return
+	}
+
+	var params WorkflowTemplateRequest
+	if !utils.ParseRequestBody(w, r, &params) {
+		return
+	}
+
+	if err := template.validate(params); err != nil {
+		utils.WriteValidationError(w, fmt.Sprintf("invalid inputs for workflow template: %v", err), err)
+		return
+	}
+
+	user, err := auth.UserFromContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, name := range template.modelNames(params) {
+		if err := checkForDuplicateModel(s.db, name, user.Id); err != nil {
+			writeError(w, err.Error(), err)
+			return
+		}
+	}
+
+	models, err := template.instantiate(s, user, params)
+	if err != nil {
+		if len(models) > 0 {
+			slog.Error("workflow template was partially instantiated", "template", template.Name, "models", models, "error", err)
+		}
+		writeError(w, fmt.Sprintf("error instantiating workflow template '%v': %v", template.Name, err), err)
+		return
+	}
+
+	slog.Info("instantiated workflow template", "template", template.Name, "user_id", user.Id, "model_id", models[len(models)-1].ModelId)
+
+	utils.WriteJsonResponse(w, WorkflowTemplateResponse{Template: template.Name, ModelId: models[len(models)-1].ModelId, Models: models})
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/google/uuid"
@@ -14,6 +15,12 @@ func ModelMetadataPath(modelId uuid.UUID) string {
 	return filepath.Join(ModelPath(modelId), "model", "metadata.json")
 }
 
+// ColdModelArchivePath is where the archive of the artifacts of a model is kept
+// in cold storage.
+func ColdModelArchivePath(modelId uuid.UUID) string {
+	return filepath.Join("models", modelId.String()+".zip")
+}
+
 func DataPath(modelId uuid.UUID) string {
 	return filepath.Join("data", modelId.String())
 }
@@ -21,3 +28,18 @@ func DataPath(modelId uuid.UUID) string {
 func UploadPath(id uuid.UUID) string {
 	return filepath.Join("uploads", id.String())
 }
+
+func JobLogArchiveDir(modelId uuid.UUID) string {
+	return filepath.Join("job_logs", modelId.String())
+}
+
+func JobLogArchivePath(modelId uuid.UUID, job string, attempt int) string {
+	if attempt == 0 {
+		return filepath.Join(JobLogArchiveDir(modelId), job+".json")
+	}
+	return filepath.Join(JobLogArchiveDir(modelId), fmt.Sprintf("%v-%d.json", job, attempt))
+}
+
+func BatchPredictPath(jobId uuid.UUID) string {
+	return filepath.Join("batch_predictions", jobId.String())
+}
@@ -0,0 +1,865 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	Bucket string
+	// Prefix is prepended to all object keys, so that several deployments can
+	// share a bucket.
+	Prefix string
+	Region string
+	// Endpoint is the url of the S3 api, it only needs to be set for S3
+	// compatible services such as MinIO. Defaults to the AWS endpoint for the
+	// region.
+	Endpoint string
+
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PathStyle addresses the bucket as part of the path instead of the
+	// hostname, this is required by most S3 compatible services.
+	PathStyle bool
+
+	// MountPath is where the bucket is mounted in job containers. Jobs still
+	// access model artifacts through the filesystem, so this is what is
+	// returned by Location.
+	MountPath string
+
+	// CapacityBytes is used to report usage, since buckets do not have a fixed
+	// size. If it is 0 the storage is never considered full.
+	CapacityBytes uint64
+
+	// StorageClass is the storage class objects are written with, for example
+	// GLACIER for cold storage. The default storage class of the bucket is used
+	// if it is empty.
+	StorageClass string
+}
+
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// Objects larger than this are written with multipart uploads, it is also the
+// amount of data that is buffered in memory while writing.
+const s3PartSize = 16 * 1024 * 1024
+
+func NewS3(config S3Config, client *http.Client) (Storage, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket must be specified")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", config.Region)
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint '%v'", config.Endpoint)
+	}
+
+	slog.Info("creating new s3 storage", "endpoint", config.Endpoint, "bucket", config.Bucket, "prefix", config.Prefix, "mount_path", config.MountPath)
+	return &S3Storage{config: config, endpoint: endpoint, client: client}, nil
+}
+
+func (s *S3Storage) key(p string) string {
+	key := path.Join(s.config.Prefix, filepath.ToSlash(p))
+	if key == "." {
+		return ""
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// dirPrefix is the prefix of all objects under the given path.
+func (s *S3Storage) dirPrefix(p string) string {
+	if key := s.key(p); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+type s3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 returned status %d: %v %v", e.StatusCode, e.Code, e.Message)
+}
+
+func (e *s3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+func parseS3Error(statusCode int, body []byte) error {
+	s3Err := &s3Error{StatusCode: statusCode}
+	_ = xml.Unmarshal(body, s3Err)
+	return s3Err
+}
+
+func (s *S3Storage) objectUrl(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.config.PathStyle {
+		objectPath = "/" + s.config.Bucket + objectPath
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	u.Path = path.Join(strings.TrimSuffix(u.Path, "/"), objectPath)
+	if strings.HasSuffix(objectPath, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request to S3 and returns the response if it succeeded.
+// The caller must close the response body.
+func (s *S3Storage) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	return s.doWithHeaders(method, key, query, nil, body)
+}
+
+func (s *S3Storage) doWithHeaders(method, key string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectUrl(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating s3 request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	s.sign(req, body, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending s3 request: %w", err)
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+		return nil, parseS3Error(res.StatusCode, data)
+	}
+
+	return res, nil
+}
+
+func (s *S3Storage) doAndDecode(method, key string, query url.Values, body []byte, result interface{}) error {
+	return s.doAndDecodeWithHeaders(method, key, query, nil, body, result)
+}
+
+func (s *S3Storage) doAndDecodeWithHeaders(method, key string, query url.Values, headers map[string]string, body []byte, result interface{}) error {
+	res, err := s.doWithHeaders(method, key, query, headers, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading s3 response: %w", err)
+	}
+
+	// Some operations, such as completing a multipart upload, can fail after a
+	// 200 status has been sent, in which case the body contains the error.
+	if bytes.Contains(data, []byte("<Error>")) {
+		return parseS3Error(res.StatusCode, data)
+	}
+
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("error parsing s3 response: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Read(path string) (io.ReadCloser, error) {
+	res, err := s.do(http.MethodGet, s.key(path), nil, nil)
+	if err != nil {
+		slog.Error("error reading object", "path", path, "error", err)
+		return nil, fmt.Errorf("error reading file %v: %w", path, err)
+	}
+	return res.Body, nil
+}
+
+func (s *S3Storage) ReadRange(path string, offset int64) (io.ReadCloser, error) {
+	res, err := s.doWithHeaders(http.MethodGet, s.key(path), nil, map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}, nil)
+	if err != nil {
+		slog.Error("error reading object range", "path", path, "offset", offset, "error", err)
+		return nil, fmt.Errorf("error reading file %v from offset %d: %w", path, offset, err)
+	}
+	return res.Body, nil
+}
+
+// writeHeaders are the headers of requests that create objects.
+func (s *S3Storage) writeHeaders() map[string]string {
+	if s.config.StorageClass == "" {
+		return nil
+	}
+	return map[string]string{"X-Amz-Storage-Class": s.config.StorageClass}
+}
+
+func (s *S3Storage) Write(path string, data io.Reader) error {
+	key := s.key(path)
+
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(data, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		res, err := s.doWithHeaders(http.MethodPut, key, nil, s.writeHeaders(), buf[:n])
+		if err != nil {
+			slog.Error("error writing object", "path", path, "error", err)
+			return fmt.Errorf("error writing to file %v: %w", path, err)
+		}
+		res.Body.Close()
+		return nil
+	}
+	if err != nil {
+		slog.Error("error reading data to write", "path", path, "error", err)
+		return fmt.Errorf("error writing to file %v: %w", path, err)
+	}
+
+	if err := s.multipartUpload(key, buf, data); err != nil {
+		slog.Error("error writing object with multipart upload", "path", path, "error", err)
+		return fmt.Errorf("error writing to file %v: %w", path, err)
+	}
+	return nil
+}
+
+// WriteRetained writes the object in compliance mode object lock, so that it
+// cannot be overwritten or deleted until retainUntil, even by the root account.
+// The bucket must have object lock enabled, S3 rejects the request otherwise.
+func (s *S3Storage) WriteRetained(path string, data []byte, retainUntil time.Time) error {
+	md5Sum := md5.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	headers := map[string]string{
+		"Content-MD5":                         base64.StdEncoding.EncodeToString(md5Sum[:]),
+		"X-Amz-Checksum-Sha256":               base64.StdEncoding.EncodeToString(sha256Sum[:]),
+		"X-Amz-Object-Lock-Mode":              "COMPLIANCE",
+		"X-Amz-Object-Lock-Retain-Until-Date": retainUntil.UTC().Format(time.RFC3339),
+	}
+
+	res, err := s.doWithHeaders(http.MethodPut, s.key(path), nil, headers, data)
+	if err != nil {
+		slog.Error("error writing retained object", "path", path, "error", err)
+		return fmt.Errorf("error writing file %v with object lock, object lock must be enabled for the bucket: %w", path, err)
+	}
+	res.Body.Close()
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// multipartUpload writes the object in parts, the first part must already be
+// read into buf.
+func (s *S3Storage) multipartUpload(key string, buf []byte, data io.Reader) error {
+	var initiate struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := s.doAndDecodeWithHeaders(http.MethodPost, key, url.Values{"uploads": {""}}, s.writeHeaders(), nil, &initiate); err != nil {
+		return fmt.Errorf("error starting multipart upload: %w", err)
+	}
+
+	abort := func() {
+		res, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {initiate.UploadId}}, nil)
+		if err != nil {
+			slog.Error("error aborting multipart upload", "key", key, "error", err)
+			return
+		}
+		res.Body.Close()
+	}
+
+	parts := []completedPart{}
+	n := len(buf)
+	for n > 0 {
+		partNumber := len(parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {initiate.UploadId}}
+		res, err := s.do(http.MethodPut, key, query, buf[:n])
+		if err != nil {
+			abort()
+			return fmt.Errorf("error uploading part %d: %w", partNumber, err)
+		}
+		res.Body.Close()
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: res.Header.Get("ETag")})
+
+		n, err = io.ReadFull(data, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			abort()
+			return fmt.Errorf("error reading data to write: %w", err)
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return fmt.Errorf("error encoding multipart upload parts: %w", err)
+	}
+
+	var complete struct{}
+	if err := s.doAndDecode(http.MethodPost, key, url.Values{"uploadId": {initiate.UploadId}}, body, &complete); err != nil {
+		abort()
+		return fmt.Errorf("error completing multipart upload: %w", err)
+	}
+	return nil
+}
+
+// Append rewrites the whole object since S3 does not support appending to
+// objects. It is only used for combining upload chunks, so this is acceptable.
+func (s *S3Storage) Append(path string, data io.Reader) error {
+	exists, err := s.Exists(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return s.Write(path, data)
+	}
+
+	existing, err := s.Read(path)
+	if err != nil {
+		return err
+	}
+	defer existing.Close()
+
+	return s.Write(path, io.MultiReader(existing, data))
+}
+
+type s3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+// list returns the objects and common prefixes under the prefix. If the
+// delimiter is empty all objects under the prefix are returned.
+func (s *S3Storage) list(prefix, delimiter string) ([]s3Object, []string, error) {
+	objects, prefixes := []s3Object{}, []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		var page struct {
+			Contents       []s3Object `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		// The list request is sent to the bucket, not an object.
+		if err := s.doAndDecode(http.MethodGet, "", query, nil, &page); err != nil {
+			return nil, nil, err
+		}
+
+		objects = append(objects, page.Contents...)
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, prefixes, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Delete(path string) error {
+	key := s.key(path)
+
+	objects, _, err := s.list(s.dirPrefix(path), "")
+	if err != nil {
+		slog.Error("error listing objects to delete", "path", path, "error", err)
+		return fmt.Errorf("error deleting file %v: %w", path, err)
+	}
+
+	keys := []string{}
+	if key != "" {
+		keys = append(keys, key)
+	}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+
+	for _, k := range keys {
+		res, err := s.do(http.MethodDelete, k, nil, nil)
+		if err != nil {
+			slog.Error("error deleting object", "path", path, "key", k, "error", err)
+			return fmt.Errorf("error deleting file %v: %w", path, err)
+		}
+		res.Body.Close()
+	}
+
+	return nil
+}
+
+// S3 has no directories, so a directory exists if there are any objects under
+// its prefix. Listing a directory without any objects is an error, which
+// matches the behavior of the shared disk storage for missing directories.
+func (s *S3Storage) List(path string) ([]string, error) {
+	prefix := s.dirPrefix(path)
+	objects, prefixes, err := s.list(prefix, "/")
+	if err != nil {
+		slog.Error("error listing entries", "path", path, "error", err)
+		return nil, fmt.Errorf("error listing entries at %v: %w", path, err)
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, fmt.Errorf("error listing entries at %v: %w", path, fs.ErrNotExist)
+	}
+
+	paths := make([]string, 0, len(objects)+len(prefixes))
+	for _, obj := range objects {
+		paths = append(paths, strings.TrimPrefix(obj.Key, prefix))
+	}
+	for _, p := range prefixes {
+		paths = append(paths, strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"))
+	}
+	slices.Sort(paths)
+
+	return paths, nil
+}
+
+func (s *S3Storage) ListFiles(path string) ([]string, error) {
+	prefix := s.dirPrefix(path)
+	objects, _, err := s.list(prefix, "")
+	if err != nil {
+		slog.Error("error listing files", "path", path, "error", err)
+		return nil, fmt.Errorf("error listing files at %v: %w", path, err)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("error listing files at %v: %w", path, fs.ErrNotExist)
+	}
+
+	paths := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		paths = append(paths, filepath.FromSlash(strings.TrimPrefix(obj.Key, prefix)))
+	}
+
+	return paths, nil
+}
+
+func (s *S3Storage) head(path string) (*http.Response, error) {
+	res, err := s.do(http.MethodHead, s.key(path), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// Storage classes whose objects must be restored before they can be read.
+var s3ArchiveStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// Restore requests a temporary copy of an object in an archive storage class,
+// which is readable for the given number of days. Restores take minutes to
+// hours depending on the storage class.
+func (s *S3Storage) Restore(path string, days int) (bool, error) {
+	res, err := s.head(path)
+	if err != nil {
+		slog.Error("error checking restore status of object", "path", path, "error", err)
+		return false, fmt.Errorf("error checking restore status of file %v: %w", path, err)
+	}
+
+	// The header is 'ongoing-request="true"' while the restore is in progress,
+	// and 'ongoing-request="false", expiry-date="..."' once it completes.
+	if restore := res.Header.Get("X-Amz-Restore"); restore != "" {
+		return strings.Contains(restore, `ongoing-request="false"`), nil
+	}
+	if !slices.Contains(s3ArchiveStorageClasses, res.Header.Get("X-Amz-Storage-Class")) {
+		return true, nil
+	}
+
+	body := []byte(fmt.Sprintf("<RestoreRequest><Days>%d</Days><GlacierJobParameters><Tier>Standard</Tier></GlacierJobParameters></RestoreRequest>", days))
+	res, err = s.do(http.MethodPost, s.key(path), url.Values{"restore": {""}}, body)
+	if err != nil {
+		var s3Err *s3Error
+		if errors.As(err, &s3Err) && s3Err.Code == "RestoreAlreadyInProgress" {
+			return false, nil
+		}
+		slog.Error("error restoring object", "path", path, "error", err)
+		return false, fmt.Errorf("error restoring file %v: %w", path, err)
+	}
+	res.Body.Close()
+
+	// S3 returns 200 if the object was already restored, and 202 if the
+	// restore was started.
+	return res.StatusCode == http.StatusOK, nil
+}
+
+func (s *S3Storage) Exists(path string) (bool, error) {
+	_, err := s.head(path)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("error checking if file exists", "path", path, "error", err)
+		return false, fmt.Errorf("error checking if file %v exists: %w", path, err)
+	}
+
+	query := url.Values{"list-type": {"2"}, "prefix": {s.dirPrefix(path)}, "max-keys": {"1"}}
+	var page struct {
+		KeyCount int `xml:"KeyCount"`
+	}
+	if err := s.doAndDecode(http.MethodGet, "", query, nil, &page); err != nil {
+		slog.Error("error checking if directory exists", "path", path, "error", err)
+		return false, fmt.Errorf("error checking if file %v exists: %w", path, err)
+	}
+	return page.KeyCount > 0, nil
+}
+
+func (s *S3Storage) Unzip(path string) error {
+	// Reading a zip archive requires random access, so the archive is
+	// downloaded to a temporary file first.
+	archive, err := s.Read(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tmp, err := os.CreateTemp("", "s3-unzip-*.zip")
+	if err != nil {
+		slog.Error("error creating temporary file for zip archive", "path", path, "error", err)
+		return fmt.Errorf("error creating temporary file for zip archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, archive)
+	if err != nil {
+		slog.Error("error downloading zip archive", "path", path, "error", err)
+		return fmt.Errorf("error downloading zip archive: %w", err)
+	}
+
+	reader, err := zip.NewReader(tmp, size)
+	if err != nil {
+		slog.Error("error opening zip reader", "path", path, "error", err)
+		return fmt.Errorf("error opening zip reader: %w", err)
+	}
+
+	newPath := strings.TrimSuffix(path, ".zip")
+
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue // directory
+		}
+
+		if err := s.writeZipEntry(filepath.Join(newPath, file.Name), file); err != nil {
+			slog.Error("error writing contents of file in zipfile", "path", path, "name", file.Name, "error", err)
+			return fmt.Errorf("error writing contents from zipfile %v: %w", file.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Storage) writeZipEntry(path string, file *zip.File) error {
+	data, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	return s.Write(path, data)
+}
+
+func (s *S3Storage) Zip(path string) error {
+	files, err := s.ListFiles(path)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		archive := zip.NewWriter(writer)
+		for _, file := range files {
+			if err := s.addToZip(archive, path, file); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.CloseWithError(archive.Close())
+	}()
+
+	err = s.Write(path+".zip", reader)
+	// Unblocks the writer goroutine if the upload failed before reading everything.
+	reader.CloseWithError(err)
+	if err != nil {
+		slog.Error("error writing directory to zip archive", "path", path, "error", err)
+		return fmt.Errorf("error writing directory '%v' to zipfile: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) addToZip(archive *zip.Writer, dir, file string) error {
+	data, err := s.Read(filepath.Join(dir, file))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	entry, err := archive.Create(filepath.ToSlash(file))
+	if err != nil {
+		return fmt.Errorf("error adding %v to zip archive: %w", file, err)
+	}
+
+	if _, err := io.Copy(entry, data); err != nil {
+		return fmt.Errorf("error adding %v to zip archive: %w", file, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Size(path string) (int64, error) {
+	res, err := s.head(path)
+	if err != nil {
+		slog.Error("error getting stats for file", "path", path, "error", err)
+		return 0, fmt.Errorf("error gettings stats for file %v: %w", path, err)
+	}
+	return res.ContentLength, nil
+}
+
+func (s *S3Storage) Usage() (UsageStats, error) {
+	if s.config.CapacityBytes == 0 {
+		return UsageStats{TotalBytes: math.MaxUint64, FreeBytes: math.MaxUint64}, nil
+	}
+
+	objects, _, err := s.list(s.dirPrefix(""), "")
+	if err != nil {
+		slog.Error("error getting usage for s3 storage", "bucket", s.config.Bucket, "error", err)
+		return UsageStats{}, fmt.Errorf("error getting storage usage stats: %w", err)
+	}
+
+	used := uint64(0)
+	for _, obj := range objects {
+		used += uint64(obj.Size)
+	}
+
+	return UsageStats{
+		TotalBytes: s.config.CapacityBytes,
+		FreeBytes:  s.config.CapacityBytes - min(used, s.config.CapacityBytes),
+	}, nil
+}
+
+func (s *S3Storage) Location() string {
+	return s.config.MountPath
+}
+
+const (
+	s3DateFormat = "20060102T150405Z"
+	s3Service    = "s3"
+)
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape uri encodes the string as required by signature version 4, which
+// only allows the unreserved characters to be left as is.
+func s3Escape(s string, encodeSlash bool) string {
+	var out strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			out.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// sign adds an AWS signature version 4 authorization header to the request.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	timestamp := now.Format(s3DateFormat)
+	date := timestamp[:8]
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.config.Region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.config.AccessKeyId, scope, signedHeaders, s.signature(date, stringToSign),
+	))
+}
+
+func (s *S3Storage) signature(date, stringToSign string) string {
+	key := hmacSha256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSha256(key, s.config.Region)
+	key = hmacSha256(key, s3Service)
+	key = hmacSha256(key, "aws4_request")
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+// S3 does not accept presigned urls that are valid for longer than a week.
+const maxPresignExpiration = 7 * 24 * time.Hour
+
+func (s *S3Storage) Presign(method, path string, expires time.Duration, headers map[string]string) (string, error) {
+	return s.presign(method, path, expires, headers, time.Now().UTC())
+}
+
+// presign creates a url with the signature in the query parameters, the payload
+// is not signed since the client sends it directly to S3.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func (s *S3Storage) presign(method, path string, expires time.Duration, headers map[string]string, now time.Time) (string, error) {
+	if expires <= 0 || expires > maxPresignExpiration {
+		return "", fmt.Errorf("presigned url expiration must be between 1 second and %v", maxPresignExpiration)
+	}
+
+	timestamp := now.Format(s3DateFormat)
+	date := timestamp[:8]
+	scope := strings.Join([]string{date, s.config.Region, s3Service, "aws4_request"}, "/")
+
+	u := s.objectUrl(s.key(path), nil)
+
+	signed := map[string]string{"host": u.Host}
+	for name, value := range headers {
+		signed[strings.ToLower(name)] = strings.TrimSpace(value)
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.config.AccessKeyId + "/" + scope},
+		"X-Amz-Date":          {timestamp},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {signedHeaders},
+	}
+	if s.config.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(date, stringToSign)
+	return u.String(), nil
+}
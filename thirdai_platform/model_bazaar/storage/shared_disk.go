@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -37,6 +38,21 @@ func (s *SharedDiskStorage) Read(path string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+func (s *SharedDiskStorage) ReadRange(path string, offset int64) (io.ReadCloser, error) {
+	file, err := s.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.(*os.File).Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		slog.Error("error seeking in file for read", "path", s.fullpath(path), "offset", offset, "error", err)
+		return nil, fmt.Errorf("error reading file %v from offset %d: %v", path, offset, err)
+	}
+
+	return file, nil
+}
+
 func (s *SharedDiskStorage) Write(path string, data io.Reader) error {
 	return s.writeData(path, data, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 }
@@ -96,6 +112,31 @@ func (s *SharedDiskStorage) List(path string) ([]string, error) {
 	return paths, nil
 }
 
+func (s *SharedDiskStorage) ListFiles(path string) ([]string, error) {
+	fullpath := s.fullpath(path)
+
+	paths := make([]string, 0)
+	err := filepath.WalkDir(fullpath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, err := filepath.Rel(fullpath, p)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error listing files", "path", fullpath, "error", err)
+		return nil, fmt.Errorf("error listing files at %v: %w", path, err)
+	}
+
+	return paths, nil
+}
+
 func (s *SharedDiskStorage) Exists(path string) (bool, error) {
 	fullpath := s.fullpath(path)
 	_, err := os.Stat(fullpath)
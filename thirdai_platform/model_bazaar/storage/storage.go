@@ -1,6 +1,13 @@
 package storage
 
-import "io"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
 
 type Storage interface {
 	Read(path string) (io.ReadCloser, error)
@@ -13,6 +20,10 @@ type Storage interface {
 
 	List(path string) ([]string, error)
 
+	// ListFiles returns the paths of all files under the given directory,
+	// including files in subdirectories, relative to the directory.
+	ListFiles(path string) ([]string, error)
+
 	Exists(path string) (bool, error)
 
 	Unzip(path string) error
@@ -26,7 +37,82 @@ type Storage interface {
 	Location() string
 }
 
+// Presigner is implemented by storages that can give clients temporary access
+// to a file without credentials, so that large files can be transferred without
+// passing through model bazaar.
+type Presigner interface {
+	// Presign returns a url that allows a request with the given method for
+	// the file until it expires. The headers are part of the signature, so the
+	// client must send them with the request.
+	Presign(method, path string, expires time.Duration, headers map[string]string) (string, error)
+}
+
+// RetentionWriter is implemented by storages that can write files that cannot
+// be modified or deleted by anyone until the retention date has passed (WORM),
+// such as S3 buckets with object lock enabled.
+type RetentionWriter interface {
+	WriteRetained(path string, data []byte, retainUntil time.Time) error
+}
+
+// Restorer is implemented by storages that can keep files in an archive tier,
+// such as the S3 Glacier storage classes, where they must be restored before
+// they can be read. Files in storages that do not implement it can always be
+// read.
+type Restorer interface {
+	// Restore starts restoring the file if it is archived, and returns true
+	// once it can be read. It can be called again to check if the restore has
+	// completed, the restored copy can be read for the given number of days.
+	Restore(path string, days int) (bool, error)
+}
+
+// RangeReader is implemented by storages that can read a file starting at an
+// offset without reading the data before it, so that large files can be
+// downloaded in parts.
+type RangeReader interface {
+	ReadRange(path string, offset int64) (io.ReadCloser, error)
+}
+
+// ReadRange returns the contents of the file starting at offset. Storages that
+// do not implement RangeReader read and discard the data before the offset.
+func ReadRange(s Storage, path string, offset int64) (io.ReadCloser, error) {
+	if reader, ok := s.(RangeReader); ok {
+		return reader.ReadRange(path, offset)
+	}
+
+	file, err := s.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, file, offset); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error skipping to offset %d of file %v: %w", offset, path, err)
+	}
+	return file, nil
+}
+
 type UsageStats struct {
 	TotalBytes uint64
 	FreeBytes  uint64
 }
+
+// DirSize returns the total size of the files under the directory, or 0 if the
+// directory does not exist.
+func DirSize(s Storage, path string) (int64, error) {
+	files, err := s.ListFiles(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	total := int64(0)
+	for _, file := range files {
+		size, err := s.Size(filepath.Join(path, file))
+		if err != nil {
+			return 0, fmt.Errorf("error getting size of directory %v: %w", path, err)
+		}
+		total += size
+	}
+	return total, nil
+}
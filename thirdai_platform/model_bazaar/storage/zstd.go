@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdArchiveExt is the extension of archives created by ZipZstd.
+const ZstdArchiveExt = ".zip.zst"
+
+// ZipZstd archives the files in the directory to path + ".zip.zst". The files
+// are stored in the zip archive without compression and the archive is
+// compressed as a whole with zstd, which is much faster than deflate for large
+// ndb indexes, and allows a plain zip to be recovered by only decompressing the
+// stream for clients that do not support zstd.
+func ZipZstd(s Storage, path string) error {
+	files, err := s.ListFiles(path)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		encoder, err := zstd.NewWriter(writer)
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		archive := zip.NewWriter(encoder)
+		for _, file := range files {
+			if err := addStoredToZip(s, archive, path, file); err != nil {
+				encoder.Close()
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		if err := archive.Close(); err != nil {
+			encoder.Close()
+			writer.CloseWithError(err)
+			return
+		}
+		writer.CloseWithError(encoder.Close())
+	}()
+
+	err = s.Write(path+ZstdArchiveExt, reader)
+	// Unblocks the writer goroutine if the write failed before reading everything.
+	reader.CloseWithError(err)
+	if err != nil {
+		slog.Error("error writing directory to zstd archive", "path", path, "error", err)
+		return fmt.Errorf("error writing directory '%v' to zstd archive: %w", path, err)
+	}
+
+	return nil
+}
+
+func addStoredToZip(s Storage, archive *zip.Writer, dir, file string) error {
+	data, err := s.Read(filepath.Join(dir, file))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(file), Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("error adding %v to zip archive: %w", file, err)
+	}
+
+	if _, err := io.Copy(entry, data); err != nil {
+		return fmt.Errorf("error adding %v to zip archive: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+var emailTokenRe = regexp.MustCompile(`https://platform\.example\.com/([a-z-]+)\?token=([A-Za-z0-9_-]+)`)
+
+// lastEmailToken returns the token in the link of the last email sent to the
+// address, checking that the link is for the given page.
+func lastEmailToken(t *testing.T, mailer *fakeMailer, to, page string) string {
+	sent := mailer.messages()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if len(sent[i].To) != 1 || sent[i].To[0] != to {
+			continue
+		}
+		match := emailTokenRe.FindStringSubmatch(sent[i].Html)
+		if match == nil || match[1] != page {
+			t.Fatalf("email to %v does not have a %v link: %v", to, page, sent[i].Html)
+		}
+		return match[2]
+	}
+	t.Fatalf("no email was sent to %v", to)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	mailer := &fakeMailer{}
+	env := setupTestEnvWithAuth(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}}, nil, nil, auth.BasicProviderArgs{
+		Mailer: mailer, PublicUrl: "https://platform.example.com/", RequireEmailVerification: true,
+	})
+
+	user := env.newClient()
+	login, err := user.signup("abc", "abc@mail.com", "abc_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.login(login); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("login should fail before the email is verified: %v", err)
+	}
+
+	token := lastEmailToken(t, mailer, "abc@mail.com", "verify-email")
+
+	err = user.Post("/user/verify-email").Json(map[string]string{"token": "invalid"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("invalid tokens should be rejected: %v", err)
+	}
+
+	if err := user.Post("/user/verify-email").Json(map[string]string{"token": token}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post("/user/verify-email").Json(map[string]string{"token": token}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("tokens should only be usable once: %v", err)
+	}
+
+	if err := user.login(login); err != nil {
+		t.Fatal(err)
+	}
+
+	// The verification email can be resent without logging in, since users
+	// cannot log in until they are verified. Requests for verified or unknown
+	// emails succeed without sending an email.
+	emails := len(mailer.messages())
+	anonymous := env.newClient()
+	for _, email := range []string{"abc@mail.com", "missing@mail.com"} {
+		if err := anonymous.Post("/user/verify-email/request").Json(map[string]string{"email": email}).Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mailer.messages()) != emails {
+		t.Fatal("verification should not be sent for verified or unknown emails")
+	}
+
+	other := env.newClient()
+	otherLogin, err := other.signup("xyz", "xyz@mail.com", "xyz_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requesting another email invalidates the previous token.
+	first := lastEmailToken(t, mailer, "xyz@mail.com", "verify-email")
+	if err := other.Post("/user/verify-email/request").Json(map[string]string{"email": "xyz@mail.com"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if resent := lastEmailToken(t, mailer, "xyz@mail.com", "verify-email"); resent == first {
+		t.Fatal("a new token should be sent when the email is resent")
+	}
+	err = other.Post("/user/verify-email").Json(map[string]string{"token": first}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("previous tokens should be invalidated when the email is resent: %v", err)
+	}
+
+	// Admins can verify users that did not receive the email.
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := admin.listUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if u.Username == "xyz" {
+			if err := admin.Post(fmt.Sprintf("/user/%v/verify", u.Id)).Do(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := other.login(otherLogin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordResetAndLockout(t *testing.T) {
+	mailer := &fakeMailer{}
+	env := setupTestEnvWithAuth(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}}, nil, nil, auth.BasicProviderArgs{
+		Mailer: mailer, PublicUrl: "https://platform.example.com", Lockout: auth.LockoutPolicy{MaxFailures: 3, Duration: time.Hour},
+	})
+
+	user := env.newClient()
+	login, err := user.signup("abc", "abc@mail.com", "abc_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Successful logins reset the count of failed logins.
+	for _, password := range []string{"wrong", "wrong", "abc_password", "wrong", "wrong"} {
+		err := user.login(loginInfo{Email: login.Email, Password: password})
+		if password == "abc_password" && err != nil {
+			t.Fatal(err)
+		} else if password != "abc_password" && (err == nil || !strings.Contains(err.Error(), "status 401")) {
+			t.Fatalf("login with the wrong password should fail: %v", err)
+		}
+	}
+
+	if err := user.login(login); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := user.login(loginInfo{Email: login.Email, Password: "wrong"}); err == nil || !strings.Contains(err.Error(), "status 401") {
+			t.Fatalf("login with the wrong password should fail: %v", err)
+		}
+	}
+
+	if err := user.login(login); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("account should be locked after too many failed logins: %v", err)
+	}
+
+	emails := len(mailer.messages())
+	if err := user.Post("/user/password-reset/request").Json(map[string]string{"email": "missing@mail.com"}).Do(nil); err != nil {
+		t.Fatalf("password reset should succeed for unknown emails: %v", err)
+	}
+	if len(mailer.messages()) != emails {
+		t.Fatal("no email should be sent for unknown emails")
+	}
+
+	if err := user.Post("/user/password-reset/request").Json(map[string]string{"email": login.Email}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	oldToken := lastEmailToken(t, mailer, login.Email, "reset-password")
+
+	if err := user.Post("/user/password-reset/request").Json(map[string]string{"email": login.Email}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	token := lastEmailToken(t, mailer, login.Email, "reset-password")
+
+	err = user.Post("/user/password-reset").Json(map[string]string{"token": oldToken, "password": "new_password"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("only the latest reset token should be valid: %v", err)
+	}
+
+	err = user.Post("/user/password-reset").Json(map[string]string{"token": token}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("password should be required: %v", err)
+	}
+
+	if err := user.Post("/user/password-reset").Json(map[string]string{"token": token, "password": "new_password"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.login(login); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("old password should not work after reset: %v", err)
+	}
+
+	// The reset also unlocks the account.
+	if err := user.login(loginInfo{Email: login.Email, Password: "new_password"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordResetWithoutSmtp(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user := env.newClient()
+	err := user.Post("/user/password-reset/request").Json(map[string]string{"email": adminEmail}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("password reset should fail if smtp is not configured: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func exportAuditLog(c client, query string) (string, error) {
+	endpoint := "/admin/audit/export?" + query
+	req := httptest.NewRequest("GET", endpoint, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.authToken))
+	w := httptest.NewRecorder()
+	c.api.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return "", fmt.Errorf("get %v failed with status %d and res '%v'", endpoint, w.Code, w.Body.String())
+	}
+	return w.Body.String(), nil
+}
+
+func TestAuditLog(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC().Add(-time.Minute)
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.modelInfo(model); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.modelInfo("not-a-model"); err == nil {
+		t.Fatal("invalid model id should fail")
+	}
+
+	err = user.Get("/admin/audit").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("audit log should be admin only: %v", err)
+	}
+
+	var res services.AuditLogResponse
+	if err := admin.Get("/admin/audit?username=abc").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) < 4 || res.Cursor != 0 {
+		t.Fatalf("requests by the user should be recorded: %+v", res)
+	}
+	for i := 1; i < len(res.Entries); i++ {
+		if res.Entries[i].Id >= res.Entries[i-1].Id {
+			t.Fatal("entries should be returned most recent first")
+		}
+	}
+	if denied := res.Entries[0]; denied.Action != "GET /admin/audit" || denied.Status != http.StatusForbidden || denied.UserId.String() != user.userId {
+		t.Fatalf("denied request should be recorded with its status: %+v", denied)
+	}
+
+	var modelRes services.AuditLogResponse
+	if err := admin.Get("/admin/audit?model_id=" + model).Do(&modelRes); err != nil {
+		t.Fatal(err)
+	}
+	if len(modelRes.Entries) != 1 {
+		t.Fatalf("expected only the model info request for the model: %+v", modelRes)
+	}
+	entry := modelRes.Entries[0]
+	if entry.Action != "GET /model/{model_id}" || entry.Method != "GET" || entry.Url != "/model/"+model ||
+		entry.Status != http.StatusOK || entry.Username != "abc" || entry.PathParams["model_id"] != model {
+		t.Fatalf("invalid audit log entry: %+v", entry)
+	}
+
+	var actionRes services.AuditLogResponse
+	if err := admin.Get("/admin/audit?method=post&action=" + url.QueryEscape("/train/ndb")).Do(&actionRes); err != nil {
+		t.Fatal(err)
+	}
+	if len(actionRes.Entries) != 1 || actionRes.Entries[0].Action != "POST /train/ndb" {
+		t.Fatalf("expected only the train request: %+v", actionRes)
+	}
+
+	var timeRes services.AuditLogResponse
+	if err := admin.Get("/admin/audit?end=" + url.QueryEscape(start.Format(time.RFC3339))).Do(&timeRes); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeRes.Entries) != 0 {
+		t.Fatalf("no entries should be recorded before the test: %+v", timeRes)
+	}
+
+	var page services.AuditLogResponse
+	if err := admin.Get("/admin/audit?username=abc&limit=2").Do(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 || page.Cursor != page.Entries[1].Id {
+		t.Fatalf("invalid first page: %+v", page)
+	}
+	var next services.AuditLogResponse
+	if err := admin.Get(fmt.Sprintf("/admin/audit?username=abc&limit=2&before=%d", page.Cursor)).Do(&next); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Entries) == 0 || next.Entries[0].Id != res.Entries[2].Id {
+		t.Fatalf("invalid second page: %+v", next)
+	}
+
+	for _, query := range []string{"user_id=abc", "model_id=xyz", "start=yesterday", "limit=0", "limit=5000", "before=-1"} {
+		err := admin.Get("/admin/audit?" + query).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 400") {
+			t.Fatalf("query %v should be rejected: %v", query, err)
+		}
+	}
+
+	exported, err := exportAuditLog(admin, "username=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(exported)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(res.Entries)+1 || rows[0][0] != "id" || rows[1][0] != fmt.Sprint(res.Entries[0].Id) {
+		t.Fatalf("invalid csv export: %v", rows)
+	}
+
+	exported, err = exportAuditLog(admin, "format=json&model_id="+model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []services.AuditLogEntryInfo
+	if err := json.Unmarshal([]byte(exported), &entries); err != nil {
+		t.Fatalf("invalid json export: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Id != entry.Id {
+		t.Fatalf("invalid json export: %+v", entries)
+	}
+
+	exported, err = exportAuditLog(admin, "format=json&username=nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(exported) != "[]" {
+		t.Fatalf("empty export should be an empty array: %v", exported)
+	}
+
+	if _, err := exportAuditLog(admin, "format=xml"); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("invalid format should be rejected: %v", err)
+	}
+}
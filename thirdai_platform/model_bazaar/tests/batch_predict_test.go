@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func getBatchPredictToken(env *testEnv, t *testing.T, jobId uuid.UUID) string {
+	file, err := env.storage.Read(filepath.Join(storage.BatchPredictPath(jobId), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var params map[string]interface{}
+	if err := json.NewDecoder(file).Decode(&params); err != nil {
+		t.Fatal(err)
+	}
+	return params["job_auth_token"].(string)
+}
+
+func (c *client) downloadPredictions(jobId uuid.UUID) (string, error) {
+	endpoint := fmt.Sprintf("/batch-predict/%v/download", jobId)
+	req := httptest.NewRequest("GET", endpoint, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.authToken))
+	w := httptest.NewRecorder()
+	c.api.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return "", fmt.Errorf("get %v failed with status %d and res '%v'", endpoint, w.Code, w.Body.String())
+	}
+	return w.Body.String(), nil
+}
+
+func TestBatchPredict(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNlpText("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{
+		"model_id":   model,
+		"input_file": map[string]string{"path": "s3://bucket/rows.csv", "location": "s3"},
+		"options":    map[string]interface{}{"text_column": "review", "top_k": 2},
+	}
+
+	if err := user.Post("/batch-predict").Json(body).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("batch prediction should fail for model that is not trained: %v", err)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := user.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, ndb), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"model_id": ndb, "input_file": body["input_file"]},
+		{"model_id": model, "input_file": map[string]string{"path": "rows.csv", "location": "ftp"}},
+		{"model_id": model, "input_file": body["input_file"], "options": map[string]int{"batch_size": 100000}},
+	} {
+		if err := user.Post("/batch-predict").Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid batch prediction %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	var job services.BatchPredictJobInfo
+	if err := user.Post("/batch-predict").Json(body).Do(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "starting" || job.ModelId.String() != model || job.InputPath != "s3://bucket/rows.csv" {
+		t.Fatalf("invalid batch prediction job %+v", job)
+	}
+	if _, ok := env.nomad.submitted[fmt.Sprintf("batch-predict-%v", job.Id)]; !ok {
+		t.Fatal("batch prediction job should be submitted")
+	}
+
+	if err := other.Get(fmt.Sprintf("/batch-predict/%v", job.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("other users should not access the batch prediction job: %v", err)
+	}
+
+	if _, err := user.downloadPredictions(job.Id); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("predictions should not be downloadable before the job completes: %v", err)
+	}
+
+	token := getBatchPredictToken(env, t, job.Id)
+
+	// The job token cannot be used to update the status of the model.
+	if err := updateTrainStatus(user, token, "failed"); err == nil {
+		t.Fatal("batch prediction token should not update train status")
+	}
+
+	progress := map[string]interface{}{"status": "in_progress", "rows_processed": 500, "total_rows": 1000}
+	if err := user.Post("/batch-predict/update-status").Auth(token).Json(progress).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var info services.BatchPredictJobInfo
+	if err := user.Get(fmt.Sprintf("/batch-predict/%v", job.Id)).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != "in_progress" || info.RowsProcessed != 500 || info.TotalRows != 1000 {
+		t.Fatalf("invalid batch prediction progress %+v", info)
+	}
+
+	predictions := "review,predicted_class,score\ngreat,positive,0.9\n"
+	if err := env.storage.Write(filepath.Join(storage.BatchPredictPath(job.Id), "predictions.csv"), strings.NewReader(predictions)); err != nil {
+		t.Fatal(err)
+	}
+
+	complete := map[string]interface{}{"status": "complete", "rows_processed": 1000}
+	if err := user.Post("/batch-predict/update-status").Auth(token).Json(complete).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := user.downloadPredictions(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != predictions {
+		t.Fatalf("invalid predictions downloaded: %v", data)
+	}
+
+	if err := user.Post("/batch-predict/update-status").Auth(token).Json(progress).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("completed job should not be updated: %v", err)
+	}
+
+	var jobs []services.BatchPredictJobInfo
+	if err := user.Get("/batch-predict").Do(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Id != job.Id || jobs[0].CompletedAt == nil {
+		t.Fatalf("invalid batch prediction jobs %+v", jobs)
+	}
+	jobs = nil
+	if err := other.Get("/batch-predict").Do(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("other users should not see the batch prediction job %+v", jobs)
+	}
+
+	if err := user.Delete(fmt.Sprintf("/batch-predict/%v", job.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get(fmt.Sprintf("/batch-predict/%v", job.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deleted batch prediction job should not exist: %v", err)
+	}
+}
+
+func TestBatchPredictStatusSync(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNlpToken("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{
+		"model_id":   model,
+		"input_file": map[string]string{"path": "s3://bucket/rows.csv", "location": "s3"},
+	}
+
+	var stopped, failed services.BatchPredictJobInfo
+	if err := user.Post("/batch-predict").Json(body).Do(&stopped); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Post("/batch-predict").Json(body).Do(&failed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deleteModel(model); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("model with running batch prediction jobs should not be deleted: %v", err)
+	}
+
+	if err := user.Post(fmt.Sprintf("/batch-predict/%v/stop", stopped.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	env.nomad.Clear()
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	for job, expected := range map[uuid.UUID]string{stopped.Id: "stopped", failed.Id: "failed"} {
+		var info services.BatchPredictJobInfo
+		if err := user.Get(fmt.Sprintf("/batch-predict/%v", job)).Do(&info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Status != expected || info.CompletedAt == nil {
+			t.Fatalf("batch prediction job should be %v: %+v", expected, info)
+		}
+	}
+
+	if err := user.deleteModel(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get(fmt.Sprintf("/batch-predict/%v", failed.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("batch prediction jobs should be deleted with the model: %v", err)
+	}
+}
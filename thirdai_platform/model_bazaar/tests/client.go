@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/services"
 	"time"
 
@@ -149,6 +150,11 @@ func (c *client) Post(endpoint string) *httpTestRequest {
 	return c.addAuthHeaders(r)
 }
 
+func (c *client) Patch(endpoint string) *httpTestRequest {
+	r := newHttpTestRequest(c.api, "PATCH", endpoint)
+	return c.addAuthHeaders(r)
+}
+
 func (c *client) Delete(endpoint string) *httpTestRequest {
 	r := newHttpTestRequest(c.api, "DELETE", endpoint)
 	return c.addAuthHeaders(r)
@@ -348,6 +354,14 @@ func (c *client) trainNdbDummyFile(name string) (string, error) {
 	return c.trainNdb(name, config.TrainFile{Path: "n/a", Location: "s3"})
 }
 
+func (c *client) retrainNdb(name, baseModelId string) (string, error) {
+	body := services.NdbRetrainRequest{ModelName: name, BaseModelId: uuid.MustParse(baseModelId)}
+
+	var res map[string]string
+	err := c.Post("/train/ndb-retrain").Json(body).Do(&res)
+	return res["model_id"], err
+}
+
 func (c *client) trainNdb(name string, file config.TrainFile) (string, error) {
 	body := services.NdbTrainRequest{
 		ModelName:    name,
@@ -381,6 +395,24 @@ func (c *client) trainNlpToken(name string) (string, error) {
 	return res["model_id"], err
 }
 
+func (c *client) trainNlpText(name string) (string, error) {
+	body := services.NlpTextTrainRequest{
+		ModelName: name,
+		ModelOptions: &config.NlpTextOptions{
+			TextColumn:     "text",
+			LabelColumn:    "label",
+			NTargetClasses: 2,
+		},
+		Data: config.NlpData{
+			SupervisedFiles: []config.TrainFile{{Path: "a.txt", Location: "s3"}},
+		},
+	}
+
+	var res map[string]string
+	err := c.Post("/train/nlp-text").Json(body).Do(&res)
+	return res["model_id"], err
+}
+
 func (c *client) createEnterpriseSearch(name, ndb, guardrail string) (string, error) {
 	body := map[string]string{
 		"model_name": name, "retrieval_id": ndb, "guardrail_id": guardrail,
@@ -391,6 +423,16 @@ func (c *client) createEnterpriseSearch(name, ndb, guardrail string) (string, er
 	return res["model_id"], err
 }
 
+func (c *client) createEnsemble(name string, models []string, strategy string) (string, error) {
+	body := map[string]interface{}{
+		"model_name": name, "model_ids": models, "strategy": strategy,
+	}
+
+	var res map[string]string
+	err := c.Post("/workflow/ensemble").Json(body).Do(&res)
+	return res["model_id"], err
+}
+
 func (c *client) startUpload(modelName string) (string, error) {
 	body := map[string]string{"model_name": modelName}
 
@@ -470,6 +512,30 @@ func (c *client) undeploy(modelId string) error {
 	return c.Delete(fmt.Sprintf("/deploy/%v", modelId)).Do(nil)
 }
 
+func (c *client) trainLogs(modelId string) ([]orchestrator.JobLog, error) {
+	var res []orchestrator.JobLog
+	err := c.Get(fmt.Sprintf("/train/%v/logs", modelId)).Do(&res)
+	return res, err
+}
+
+// streamTrainLogs returns the server sent events of the train log stream, which
+// ends when the stub has written the logs of the job.
+func (c *client) streamTrainLogs(modelId string) (string, error) {
+	endpoint := fmt.Sprintf("/train/%v/logs/stream", modelId)
+	req := httptest.NewRequest("GET", endpoint, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.authToken))
+	w := httptest.NewRecorder()
+	c.api.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return "", fmt.Errorf("get %v failed with status %d and res '%v'", endpoint, w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		return "", fmt.Errorf("get %v returned content type '%v'", endpoint, contentType)
+	}
+	return w.Body.String(), nil
+}
+
 func (c *client) trainReport(modelId string) (interface{}, error) {
 	var res interface{}
 	err := c.Get(fmt.Sprintf("/train/%v/report", modelId)).Do(&res)
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func readZipEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := archive.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestZstdModelDownload(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{}, ArtifactCompression: services.ArtifactCompressionZstd,
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	modelData := bytes.Repeat([]byte("ndb index data "), 10000)
+	if err := env.storage.Write(filepath.Join("models", model, "model", "model.ndb"), bytes.NewReader(modelData)); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/model/%v/download", model), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", user.authToken))
+		if acceptEncoding != "" {
+			req.Header.Add("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		env.api.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("download failed with status %d: %v", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	compressed := download("gzip, zstd")
+	if compressed.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("download should be compressed with zstd: %v", compressed.Header())
+	}
+	if compressed.Body.Len() >= len(modelData) {
+		t.Fatalf("compressed download is %d bytes for %d bytes of data", compressed.Body.Len(), len(modelData))
+	}
+	decoder, err := zstd.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	archive, err := io.ReadAll(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readZipEntry(t, archive, "model.ndb"), modelData) {
+		t.Fatal("model data does not match after zstd download")
+	}
+
+	for _, acceptEncoding := range []string{"", "gzip", "zstd;q=0"} {
+		plain := download(acceptEncoding)
+		if plain.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("download should not be compressed for Accept-Encoding '%v': %v", acceptEncoding, plain.Header())
+		}
+		if !bytes.Equal(readZipEntry(t, plain.Body.Bytes(), "model.ndb"), modelData) {
+			t.Fatal("model data does not match after plain download")
+		}
+	}
+
+	// Uploading the downloaded archive should recreate the model.
+	newModel, err := user.uploadModel("xyz-new", bytes.NewReader(archive), 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := os.ReadFile(filepath.Join(env.storage.Location(), "models", newModel, "model", "model.ndb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newData, modelData) {
+		t.Fatal("model data does not match after download and upload")
+	}
+}
+
+func TestZstdUploadChunks(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	modelData := randomBytes(20000)
+	if err := env.storage.Write(filepath.Join("models", model, "model", "model.ndb"), bytes.NewReader(modelData)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := user.downloadModel(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := user.startUpload("xyz-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+
+	const chunkSize = 5000
+	for i := 0; i*chunkSize < len(archive); i++ {
+		chunk := archive[i*chunkSize : min((i+1)*chunkSize, len(archive))]
+		checksum := sha256.Sum256(chunk)
+		err := user.Post(fmt.Sprintf("/model/upload/%d", i)).Auth(token).
+			Header("Content-Encoding", "zstd").
+			Header("X-Chunk-Sha256", hex.EncodeToString(checksum[:])).
+			Body(bytes.NewReader(encoder.EncodeAll(chunk, nil))).Do(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = user.Post("/model/upload/0").Auth(token).Header("Content-Encoding", "br").Body(bytes.NewReader(archive[:chunkSize])).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 415") {
+		t.Fatalf("unsupported content encodings should be rejected: %v", err)
+	}
+
+	var res map[string]string
+	if err := user.Post("/model/upload/commit").Auth(token).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	newData, err := os.ReadFile(filepath.Join(env.storage.Location(), "models", res["model_id"], "model", "model.ndb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newData, modelData) {
+		t.Fatal("model data does not match after compressed upload")
+	}
+}
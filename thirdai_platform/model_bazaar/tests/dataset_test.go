@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+func uploadDatasetFiles(t *testing.T, c client, upload string) string {
+	body, contentType := createUploadBody(t, []struct{ name, data string }{{"data.csv", "text,label\nabc,1\n"}})
+	endpoint := "/train/upload-data"
+	if upload != "" {
+		endpoint += "?upload_id=" + upload
+	}
+	var res map[string]uuid.UUID
+	if err := c.Post(endpoint).Header("Content-Type", contentType).Body(body).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res["upload_id"].String()
+}
+
+func TestDatasets(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dataset services.DatasetInfo
+	if err := user1.Post("/dataset").Json(map[string]string{"name": "docs", "description": "support docs"}).Do(&dataset); err != nil {
+		t.Fatal(err)
+	}
+	if dataset.Name != "docs" || dataset.Access != "private" || dataset.LatestVersion != 0 {
+		t.Fatalf("invalid dataset %+v", dataset)
+	}
+
+	if err := user2.Post("/dataset").Json(map[string]string{"name": "docs"}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("expected duplicate dataset name to fail with 409: %v", err)
+	}
+	if err := user1.Post("/dataset").Json(map[string]string{"name": "a/b"}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("expected invalid dataset name to fail with 422: %v", err)
+	}
+
+	if _, err := user1.trainNdb("empty", config.TrainFile{Path: "docs", Location: config.FileLocDataset}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("expected training on a dataset without versions to fail with 422: %v", err)
+	}
+
+	upload1 := uploadDatasetFiles(t, user1, "")
+	upload2 := uploadDatasetFiles(t, user1, "")
+
+	versionsEndpoint := fmt.Sprintf("/dataset/%v/versions", dataset.Id)
+	var version map[string]int
+	if err := user1.Post(versionsEndpoint).Json(map[string]interface{}{"upload_id": upload1, "schema": map[string]string{"text": "string"}}).Do(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version["version"] != 1 {
+		t.Fatalf("expected version 1, got %v", version)
+	}
+	if err := user1.Post(versionsEndpoint).Json(map[string]interface{}{"upload_id": upload2}).Do(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version["version"] != 2 {
+		t.Fatalf("expected version 2, got %v", version)
+	}
+
+	if err := user1.Post(versionsEndpoint).Json(map[string]interface{}{"upload_id": upload1, "schema": []string{"text"}}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("expected schema that is not an object to fail with 422: %v", err)
+	}
+
+	// Registered uploads cannot be changed.
+	body, contentType := createUploadBody(t, []struct{ name, data string }{{"more.csv", "text,label\n"}})
+	if err := user1.Post("/train/upload-data?upload_id="+upload1).Header("Content-Type", contentType).Body(body).Do(nil); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("expected adding files to a dataset upload to fail with 409: %v", err)
+	}
+
+	var info services.DatasetInfo
+	if err := user1.Get(fmt.Sprintf("/dataset/%v", dataset.Id)).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.LatestVersion != 2 || len(info.Versions) != 2 || info.Versions[0].Version != 1 || info.Versions[0].Files[0] != "data.csv" || string(info.Versions[0].Schema) != `{"text":"string"}` {
+		t.Fatalf("invalid dataset info %+v", info)
+	}
+
+	// Private datasets can only be accessed by the owner.
+	if err := user2.Get(fmt.Sprintf("/dataset/%v", dataset.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected access to private dataset to fail with 403: %v", err)
+	}
+	if _, err := user2.trainNdb("other", config.TrainFile{Path: "docs:1", Location: config.FileLocDataset}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected training on private dataset to fail with 403: %v", err)
+	}
+	var list []services.DatasetInfo
+	if err := user2.Get("/dataset/list").Do(&list); err != nil || len(list) != 0 {
+		t.Fatalf("expected no datasets for other user: %v %v", list, err)
+	}
+
+	model, err := user1.trainNdb("model", config.TrainFile{Path: "docs:1", Location: config.FileLocDataset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelInfo, err := user1.modelInfo(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modelInfo.Datasets) != 1 || modelInfo.Datasets[0].DatasetName != "docs" || modelInfo.Datasets[0].Version != 1 || modelInfo.Datasets[0].DatasetVersionId == nil {
+		t.Fatalf("invalid model datasets %+v", modelInfo.Datasets)
+	}
+
+	if _, err := user1.trainNdb("missing", config.TrainFile{Path: "docs:3", Location: config.FileLocDataset}); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected training on missing version to fail with 404: %v", err)
+	}
+
+	// Protected datasets can be used by the members of the team.
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	team, err := admin.createTeam("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []client{user1, user2} {
+		userInfo, err := c.userInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := admin.addUserToTeam(team, userInfo.Id.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := user1.Patch(fmt.Sprintf("/dataset/%v", dataset.Id)).Json(map[string]string{"access": "protected", "team_id": team}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user2.Get("/dataset/list").Do(&list); err != nil || len(list) != 1 || list[0].LatestVersion != 2 {
+		t.Fatalf("expected team dataset to be listed: %v %v", list, err)
+	}
+	latest, err := user2.trainNdb("latest", config.TrainFile{Path: "docs", Location: config.FileLocDataset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelInfo, err = user2.modelInfo(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modelInfo.Datasets) != 1 || modelInfo.Datasets[0].Version != 2 {
+		t.Fatalf("expected the latest version to be used %+v", modelInfo.Datasets)
+	}
+
+	// Only the owner can change the dataset.
+	if err := user2.Delete(fmt.Sprintf("/dataset/%v", dataset.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected team member deleting dataset to fail with 403: %v", err)
+	}
+	if err := user1.Delete(fmt.Sprintf("/dataset/%v", dataset.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Models keep the name and version of the deleted dataset.
+	modelInfo, err = user1.modelInfo(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modelInfo.Datasets) != 1 || modelInfo.Datasets[0].DatasetName != "docs" || modelInfo.Datasets[0].DatasetVersionId != nil {
+		t.Fatalf("invalid model datasets after dataset deletion %+v", modelInfo.Datasets)
+	}
+}
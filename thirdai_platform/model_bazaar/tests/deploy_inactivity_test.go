@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func TestInactiveDeployments(t *testing.T) {
+	mailer := &fakeMailer{}
+	metrics := &sloMetrics{}
+	metricsServer := httptest.NewServer(metrics)
+	defer metricsServer.Close()
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{}, Mailer: mailer, MetricsEndpoint: metricsServer.URL,
+		InactiveDeployments: services.InactivityPolicy{After: 30 * 24 * time.Hour, UndeployGrace: 7 * 24 * time.Hour},
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := map[string]string{}
+	for _, name := range []string{"idle", "queried", "retrained"} {
+		model, err := user.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+		if err := user.deploy(model); err != nil {
+			t.Fatal(err)
+		}
+		markDeployed(t, env, model)
+		models[name] = model
+	}
+
+	// Only the retrained model has job runs in the inactivity period, and only
+	// the queried model has traffic.
+	old := time.Now().UTC().Add(-60 * 24 * time.Hour)
+	err = env.db.Model(&schema.JobRun{}).
+		Where("model_id IN ?", []string{models["idle"], models["queried"]}).
+		Updates(map[string]interface{}{"started_at": old, "ended_at": old}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.modelId = models["queried"]
+	metrics.set(100, 0, 0)
+
+	if err := user.Get("/deploy/inactive").Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should list inactive deployments: %v", err)
+	}
+
+	go env.modelBazaar.JobStatusSync(50 * time.Millisecond)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	awaitInactive := func(count int) []services.InactiveDeploymentInfo {
+		var inactive []services.InactiveDeploymentInfo
+		for i := 0; i < 40; i++ {
+			inactive = nil
+			if err := admin.Get("/deploy/inactive").Do(&inactive); err != nil {
+				t.Fatal(err)
+			}
+			if len(inactive) == count {
+				return inactive
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected %d inactive deployments: %+v", count, inactive)
+		return inactive
+	}
+
+	inactive := awaitInactive(1)
+	if inactive[0].ModelId.String() != models["idle"] || inactive[0].ModelName != "idle" || inactive[0].Username != "abc" ||
+		inactive[0].UndeployAt == nil || inactive[0].UndeployAt.Sub(inactive[0].FlaggedAt) != 7*24*time.Hour {
+		t.Fatalf("invalid inactive deployment %+v", inactive[0])
+	}
+
+	status, err := user.deployStatus(models["idle"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Inactive == nil || status.Inactive.ModelId.String() != models["idle"] {
+		t.Fatalf("deploy status should include the inactivity flag: %+v", status.Inactive)
+	}
+	status, err = user.deployStatus(models["queried"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Inactive != nil {
+		t.Fatalf("queried deployment should not be flagged: %+v", status.Inactive)
+	}
+
+	// The deployment is stopped at the first status sync after the grace period.
+	err = env.db.Model(&schema.InactiveDeployment{}).Where("model_id = ?", models["idle"]).Update("undeploy_at", time.Now().UTC().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitInactive(0)
+
+	status, err = user.deployStatus(models["idle"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != schema.Stopped || status.Inactive != nil {
+		t.Fatalf("inactive deployment should be stopped: %+v", status)
+	}
+	for _, name := range []string{"queried", "retrained"} {
+		status, err := user.deployStatus(models[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != schema.Complete {
+			t.Fatalf("active deployment %v should not be stopped: %v", name, status.Status)
+		}
+	}
+
+	sent := slices.DeleteFunc(mailer.messages(), func(msg mail.Message) bool { return !strings.Contains(msg.Subject, "'idle'") })
+	if len(sent) != 2 || sent[0].To[0] != "abc@mail.com" || !strings.Contains(sent[0].Subject, "is inactive") ||
+		!strings.Contains(sent[0].Html, "will be stopped after") || !strings.Contains(sent[1].Subject, "was stopped") {
+		t.Fatalf("owner should be emailed when the deployment is flagged and stopped: %+v", sent)
+	}
+}
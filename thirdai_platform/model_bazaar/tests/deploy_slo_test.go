@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+// sloMetrics serves the query metrics of a deployment for the SLO queries.
+type sloMetrics struct {
+	mu             sync.Mutex
+	modelId        string
+	requests       float64
+	failed         float64
+	latencySeconds float64
+}
+
+func (m *sloMetrics) set(requests, failed, latencySeconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests, m.failed, m.latencySeconds = requests, failed, latencySeconds
+}
+
+func (m *sloMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query := r.URL.Query().Get("query")
+	value := m.requests
+	switch {
+	case strings.Contains(query, "ndb_query_errors"):
+		value = m.failed
+	case strings.Contains(query, "ndb_query_sum"):
+		value = m.latencySeconds
+	}
+	fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"model_id":"%v"},"value":[1700000000,"%v"]}]}}`, m.modelId, value)
+}
+
+func TestDeploymentSlo(t *testing.T) {
+	mailer := &fakeMailer{}
+	metrics := &sloMetrics{}
+	metricsServer := httptest.NewServer(metrics)
+	defer metricsServer.Close()
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{}, Mailer: mailer, MetricsEndpoint: metricsServer.URL,
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver.handler("slo-secret"))
+	defer server.Close()
+
+	if err := admin.Post("/webhooks").Json(map[string]interface{}{"name": "slo", "url": server.URL, "events": []string{"slo.failed"}}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid slo event should be rejected: %v", err)
+	}
+	if err := admin.Post("/webhooks").Json(map[string]interface{}{"name": "slo", "url": server.URL, "events": []string{"slo.*"}, "secret": "slo-secret"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("slo-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	markDeployed(t, env, model)
+	metrics.modelId = model
+
+	sloPath := fmt.Sprintf("/deploy/%v/slo", model)
+	slo := map[string]interface{}{"latency_ms": 200, "error_rate_percent": 1, "window_minutes": 30}
+
+	if err := user.Get(sloPath).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deployment without an slo should return 404: %v", err)
+	}
+	for _, invalid := range []map[string]interface{}{{}, {"error_rate_percent": 101}, {"latency_ms": 100, "window_minutes": 1}} {
+		if err := user.Post(sloPath).Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid slo %v should be rejected: %v", invalid, err)
+		}
+	}
+	if err := other.Post(sloPath).Json(slo).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the owner should set the slo: %v", err)
+	}
+
+	// Windows with too few requests do not change the status.
+	metrics.set(5, 5, 1)
+	var info services.SloInfo
+	if err := user.Post(sloPath).Json(slo).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != schema.SloPending || info.MinRequests != 10 || info.WindowMinutes != 30 {
+		t.Fatalf("invalid slo %+v", info)
+	}
+
+	go env.modelBazaar.JobStatusSync(50 * time.Millisecond)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	awaitSlo := func(check func(services.SloInfo) bool) services.SloInfo {
+		var info services.SloInfo
+		for i := 0; i < 40; i++ {
+			info = services.SloInfo{}
+			if err := user.Get(sloPath).Do(&info); err != nil {
+				t.Fatal(err)
+			}
+			if check(info) {
+				return info
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("slo did not reach the expected state: %+v", info)
+		return info
+	}
+
+	info = awaitSlo(func(info services.SloInfo) bool { return info.EvaluatedAt != nil })
+	if info.Status != schema.SloPending || info.Requests != 5 {
+		t.Fatalf("slo should not be evaluated with too few requests: %+v", info)
+	}
+
+	// Updating the slo evaluates it again at the next status sync.
+	metrics.set(100, 5, 0.35)
+	if err := user.Post(sloPath).Json(slo).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	info = awaitSlo(func(info services.SloInfo) bool { return info.Status == schema.SloBreached })
+	if info.BreachedAt == nil || info.ObservedLatencyMs != 350 || info.ObservedErrorRatePercent != 5 ||
+		!strings.Contains(info.Message, "latency of 350ms") || !strings.Contains(info.Message, "5.00% of queries failed") {
+		t.Fatalf("invalid breached slo %+v", info)
+	}
+
+	status, err := user.deployStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Slo == nil || status.Slo.Status != schema.SloBreached {
+		t.Fatalf("deploy status should include the slo: %+v", status.Slo)
+	}
+
+	payloads := receiver.await(t, 1)
+	if payloads[0].Event != "slo.breached" || payloads[0].ModelId.String() != model || payloads[0].PreviousStatus != schema.SloPending || payloads[0].Message != info.Message {
+		t.Fatalf("invalid webhook payload %+v", payloads[0])
+	}
+
+	metrics.set(100, 0, 0.05)
+	if err := user.Post(sloPath).Json(slo).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	info = awaitSlo(func(info services.SloInfo) bool { return info.Status == schema.SloMet })
+	if info.BreachedAt != nil || info.Message != "" {
+		t.Fatalf("invalid recovered slo %+v", info)
+	}
+
+	payloads = receiver.await(t, 2)
+	if payloads[1].Event != "slo.ok" || payloads[1].PreviousStatus != schema.SloBreached {
+		t.Fatalf("invalid webhook payload %+v", payloads[1])
+	}
+
+	// The weekly health report is also sent by the status sync.
+	sent := slices.DeleteFunc(mailer.messages(), func(msg mail.Message) bool { return !strings.Contains(msg.Subject, "SLO") })
+	if len(sent) != 2 || sent[0].To[0] != "abc@mail.com" || !strings.Contains(sent[0].Subject, "SLO breached") || !strings.Contains(sent[1].Subject, "SLO recovered") {
+		t.Fatalf("owner should be emailed when the slo is breached and recovers: %+v", sent)
+	}
+
+	if err := user.Delete(sloPath).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	status, err = user.deployStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Slo != nil {
+		t.Fatalf("deleted slo should not be in the deploy status: %+v", status.Slo)
+	}
+}
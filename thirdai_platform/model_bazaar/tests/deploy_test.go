@@ -1,10 +1,18 @@
 package tests
 
 import (
+	"fmt"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestDeploy(t *testing.T) {
@@ -99,3 +107,684 @@ func TestDeploy(t *testing.T) {
 		t.Fatalf("invalid status: %v", status)
 	}
 }
+
+func TestDeployRollback(t *testing.T) {
+	driver := orchestrator.DockerDriver{ImageName: "thirdai_platform_jobs", Tag: "v1"}
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: driver})
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = updateTrainStatus(client, getJobAuthToken(env, t, model), "complete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rollback should fail for model that was never deployed: %v", err)
+	}
+
+	deployWithMemory := func(memory int) {
+		if err := client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]int{"memory": memory}).Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployWithMemory(1000)
+	err = client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rollback should fail when there is no previous version: %v", err)
+	}
+
+	if err := client.undeploy(model); err != nil {
+		t.Fatal(err)
+	}
+	deployWithMemory(2000)
+
+	jobName := fmt.Sprintf("deploy-ndb-%v", model)
+	submittedJob := func() orchestrator.DeployJob {
+		return env.nomad.submitted[jobName].(orchestrator.DeployJob)
+	}
+	if job := submittedJob(); job.Resources.AllocationMemory != 2000 || !strings.HasSuffix(job.ConfigPath, "deploy_v2_config.json") {
+		t.Fatalf("invalid job submitted: %+v", job)
+	}
+
+	var rollback struct {
+		Version       int `json:"version"`
+		SourceVersion int `json:"source_version"`
+	}
+	if err := client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{}).Do(&rollback); err != nil {
+		t.Fatal(err)
+	}
+	if rollback.Version != 3 || rollback.SourceVersion != 1 {
+		t.Fatalf("invalid rollback: %+v", rollback)
+	}
+
+	job := submittedJob()
+	if job.Resources.AllocationMemory != 1000 || !strings.HasSuffix(job.ConfigPath, "deploy_v1_config.json") || job.Driver != driver {
+		t.Fatalf("previous job should be resubmitted: %+v", job)
+	}
+
+	status, err := client.deployStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "starting" {
+		t.Fatalf("invalid status after rollback: %v", status.Status)
+	}
+
+	var versions []services.DeploymentVersionInfo
+	if err := client.Get(fmt.Sprintf("/deploy/%v/versions", model)).Do(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].Current || versions[0].SourceVersion != 1 || versions[1].Resources.AllocationMemory != 2000 || versions[2].Tag != "v1" || versions[2].Current {
+		t.Fatalf("invalid versions: %+v", versions)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rollback should fail since version 1 is the oldest version: %v", err)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{"version": 7}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("rollback should fail for missing version: %v", err)
+	}
+
+	if err := client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{"version": 2}).Do(&rollback); err != nil {
+		t.Fatal(err)
+	}
+	if rollback.Version != 4 || rollback.SourceVersion != 2 || submittedJob().Resources.AllocationMemory != 2000 {
+		t.Fatalf("invalid rollback: %+v", rollback)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{"version": 1}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the owner should be able to rollback: %v", err)
+	}
+}
+
+func TestDeployAutoscaling(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	updateAutoscaling := func(params map[string]int) (services.AutoscalingResponse, error) {
+		var res services.AutoscalingResponse
+		err := client.Patch(fmt.Sprintf("/deploy/%v/autoscaling", model)).Json(params).Do(&res)
+		return res, err
+	}
+
+	_, err = updateAutoscaling(map[string]int{"autoscaling_max": 4})
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("autoscaling cannot be updated for a model that is not deployed: %v", err)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"autoscaling_enabled": true, "autoscaling_min": 3, "autoscaling_max": 2}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("max replicas cannot be less than min replicas: %v", err)
+	}
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"autoscaling_enabled": true, "scale_to_zero_idle_seconds": 10}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("idle timeout should be validated: %v", err)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{
+		"autoscaling_enabled": true, "autoscaling_min": 1, "autoscaling_max": 3, "scale_to_zero_idle_seconds": 600,
+	}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobName := fmt.Sprintf("deploy-ndb-%v", model)
+	job := env.nomad.submitted[jobName].(orchestrator.DeployJob)
+	if !job.AutoscalingEnabled || job.AutoscalingMax != 3 || job.AutoscalingTargetCpu != 70 || job.ScaleToZeroIdleSeconds != 600 || job.ScalingMin() != 0 {
+		t.Fatalf("invalid job submitted: %+v", job)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(job.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployConfig.AutoscalingOptions == nil || deployConfig.AutoscalingOptions.MaxReplicas != 3 {
+		t.Fatalf("autoscaling should be saved in deploy config: %+v", deployConfig.AutoscalingOptions)
+	}
+
+	_, err = updateAutoscaling(map[string]int{"autoscaling_target_cpu": 150})
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("target cpu should be validated: %v", err)
+	}
+
+	res, err := updateAutoscaling(map[string]int{"autoscaling_max": 6, "autoscaling_target_cpu": 50, "scale_to_zero_idle_seconds": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != 2 || res.AutoscalingMin != 1 || res.AutoscalingMax != 6 || res.AutoscalingTargetCpu != 50 || res.ScaleToZeroIdleSeconds != 0 {
+		t.Fatalf("invalid autoscaling update: %+v", res)
+	}
+
+	updated := env.nomad.autoscaling[jobName]
+	if updated.AutoscalingMax != 6 || updated.AutoscalingTargetCpu != 50 || updated.ScalingMin() != 1 || !strings.HasSuffix(updated.ConfigPath, "deploy_v2_config.json") {
+		t.Fatalf("invalid autoscaling update: %+v", updated)
+	}
+	if env.nomad.submitted[jobName].(orchestrator.DeployJob).JobToken != job.JobToken {
+		t.Fatal("deployment should not be restarted to update autoscaling")
+	}
+
+	// Rolling back restores the previous autoscaling settings.
+	var rollback struct {
+		SourceVersion int `json:"source_version"`
+	}
+	if err := client.Post(fmt.Sprintf("/deploy/%v/rollback", model)).Json(map[string]int{}).Do(&rollback); err != nil {
+		t.Fatal(err)
+	}
+	if job := env.nomad.submitted[jobName].(orchestrator.DeployJob); rollback.SourceVersion != 1 || job.AutoscalingMax != 3 || job.ScaleToZeroIdleSeconds != 600 {
+		t.Fatalf("invalid rollback: %+v", job)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Patch(fmt.Sprintf("/deploy/%v/autoscaling", model)).Json(map[string]int{"autoscaling_max": 2}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the owner should be able to update autoscaling: %v", err)
+	}
+
+	if err := client.undeploy(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	_, err = updateAutoscaling(map[string]int{"autoscaling_max": 4})
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("autoscaling cannot be updated for a deployment without autoscaling: %v", err)
+	}
+}
+
+func TestDeployConfidenceThresholds(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNlpText("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := client.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, ndb), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	thresholds := map[string]interface{}{
+		"min_confidence":   0.6,
+		"class_thresholds": map[string]float64{"spam": 0.9},
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", ndb)).Json(map[string]interface{}{"confidence_thresholds": thresholds}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("confidence thresholds should be rejected for ndb models: %v", err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"min_confidence": 1.5},
+		{"min_confidence": 0.5, "class_thresholds": map[string]float64{"spam": -0.1}},
+	} {
+		err := client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"confidence_thresholds": invalid}).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid confidence thresholds %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	if err := client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"confidence_thresholds": thresholds}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(filepath.Join(env.storage.Location(), storage.ModelPath(uuid.MustParse(model)), "deploy_v1_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := deployConfig.ConfidenceThresholds
+	if opts == nil || opts.MinConfidence != 0.6 || opts.ClassThresholds["spam"] != 0.9 || opts.AbstainLabel != config.DefaultAbstainLabel {
+		t.Fatalf("invalid confidence thresholds in deploy config: %+v", opts)
+	}
+}
+
+func TestDeployRerank(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		LlmProviders:  map[string]string{"openai": "openai-key"},
+	})
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNlpText("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := client.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, ndb), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	rerank := map[string]interface{}{"provider": "llm-dispatch", "model": "text-embedding-3-small", "weight": 0.7}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"rerank": rerank}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rerank should be rejected for nlp models: %v", err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"provider": "cohere"},
+		{"provider": "local"},
+		{"provider": "local", "endpoint": "http://embeddings:8080/v1", "weight": 1.5},
+		{"provider": "llm-dispatch", "llm_provider": "cohere"},
+	} {
+		err := client.Post(fmt.Sprintf("/deploy/%v", ndb)).Json(map[string]interface{}{"rerank": invalid}).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid rerank options %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	if err := client.Post(fmt.Sprintf("/deploy/%v", ndb)).Json(map[string]interface{}{"rerank": rerank}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(filepath.Join(env.storage.Location(), storage.ModelPath(uuid.MustParse(ndb)), "deploy_v1_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := deployConfig.Rerank
+	if opts == nil || opts.LlmProvider != "openai" || opts.SimilarityWeight() != 0.7 || opts.CandidateCount() != config.DefaultRerankCandidates {
+		t.Fatalf("invalid rerank options in deploy config: %+v", opts)
+	}
+	if deployConfig.Options["rerank_genai_key"] != "openai-key" {
+		t.Fatalf("the genai key should be passed in the deploy config options: %v", deployConfig.Options)
+	}
+}
+
+func TestDeployEnrichment(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := client.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, ndb), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{},
+		{"endpoint": "tickets/{source_id}"},
+		{"endpoint": "http://tickets:8080/api/tickets"},
+		{"endpoint": "http://tickets:8080/api/tickets/{source_id}", "timeout_ms": 60000},
+	} {
+		err := client.Post(fmt.Sprintf("/deploy/%v", ndb)).Json(map[string]interface{}{"enrichment": invalid}).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid enrichment options %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	enrichment := map[string]interface{}{"endpoint": "http://tickets:8080/api/tickets/{source_id}", "fields": []string{"status"}, "cache_ttl_seconds": 30}
+	if err := client.Post(fmt.Sprintf("/deploy/%v", ndb)).Json(map[string]interface{}{"enrichment": enrichment}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(filepath.Join(env.storage.Location(), storage.ModelPath(uuid.MustParse(ndb)), "deploy_v1_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := deployConfig.Enrichment
+	if opts == nil || opts.Endpoint != enrichment["endpoint"] || len(opts.Fields) != 1 || opts.CacheTtl() != 30*time.Second || opts.Timeout() != config.DefaultEnrichmentTimeoutMillis*time.Millisecond {
+		t.Fatalf("invalid enrichment options in deploy config: %+v", opts)
+	}
+}
+
+func TestDeployShadow(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := map[string]string{}
+	for _, name := range []string{"production", "candidate"} {
+		models[name], err = client.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	models["nlp"], err = client.trainNlpText("nlp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	models["private"], err = other.trainNdbDummyFile("private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, model := range models {
+		c := client
+		if name == "private" {
+			c = other
+		}
+		if err := updateTrainStatus(c, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deployWithShadow := func(model string, shadow map[string]interface{}) error {
+		return client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"shadow": shadow}).Do(nil)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"model_id": models["candidate"], "percentage": 0},
+		{"model_id": models["candidate"], "percentage": 150},
+		{"percentage": 10},
+	} {
+		if err := deployWithShadow(models["production"], invalid); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid shadow options %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		model, shadow, status string
+	}{
+		{models["production"], models["production"], "status 422"},
+		{models["production"], models["nlp"], "status 422"},
+		{models["nlp"], models["candidate"], "status 422"},
+		{models["production"], models["private"], "status 403"},
+		{models["production"], uuid.NewString(), "status 404"},
+	} {
+		err := deployWithShadow(tc.model, map[string]interface{}{"model_id": tc.shadow, "percentage": 10})
+		if err == nil || !strings.Contains(err.Error(), tc.status) {
+			t.Fatalf("shadow %v for model %v should fail with %v: %v", tc.shadow, tc.model, tc.status, err)
+		}
+	}
+
+	if err := deployWithShadow(models["production"], map[string]interface{}{"model_id": models["candidate"], "percentage": 25}); err != nil {
+		t.Fatal(err)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(filepath.Join(env.storage.Location(), storage.ModelPath(uuid.MustParse(models["production"])), "deploy_v1_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shadow := deployConfig.Shadow
+	if shadow == nil || shadow.ModelId.String() != models["candidate"] || shadow.Percentage != 25 || shadow.InFlightLimit() != config.DefaultShadowMaxInFlight {
+		t.Fatalf("invalid shadow options in deploy config: %+v", shadow)
+	}
+}
+
+func TestDeploymentPresets(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset := map[string]interface{}{
+		"name":        "small-ndb",
+		"description": "approved settings for small ndb deployments",
+		"settings": map[string]interface{}{
+			"memory":             3000,
+			"concurrency_limits": map[string]interface{}{"query": map[string]int{"max_concurrent": 4, "max_queued": 8, "queue_timeout_seconds": 10}},
+		},
+	}
+
+	if err := client.Post("/deploy/presets").Json(preset).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should be able to create presets: %v", err)
+	}
+
+	invalid := map[string]interface{}{"name": "bad name", "settings": map[string]interface{}{}}
+	if err := admin.Post("/deploy/presets").Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("preset name should be validated: %v", err)
+	}
+	invalid = map[string]interface{}{"name": "bad", "settings": map[string]interface{}{"llm_provider": "not-a-provider"}}
+	if err := admin.Post("/deploy/presets").Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("preset settings should be validated: %v", err)
+	}
+
+	var created services.DeploymentPresetInfo
+	if err := admin.Post("/deploy/presets").Json(preset).Do(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "small-ndb" || created.Settings.Memory != 3000 || created.Settings.ConcurrencyLimits["query"].MaxConcurrent != 4 {
+		t.Fatalf("invalid preset: %+v", created)
+	}
+
+	var presets []services.DeploymentPresetInfo
+	if err := client.Get("/deploy/presets").Do(&presets); err != nil {
+		t.Fatal(err)
+	}
+	if len(presets) != 1 || presets[0].Name != "small-ndb" || presets[0].Description != "approved settings for small ndb deployments" {
+		t.Fatalf("invalid presets: %+v", presets)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"preset": "missing"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deploying with a missing preset should fail: %v", err)
+	}
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"preset": "small-ndb", "memory": 5000}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("settings cannot be combined with a preset: %v", err)
+	}
+
+	if err := client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"preset": "small-ndb"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	job := env.nomad.submitted[fmt.Sprintf("deploy-ndb-%v", model)].(orchestrator.DeployJob)
+	if job.Resources.AllocationMemory != 3000 {
+		t.Fatalf("preset memory should be used: %+v", job.Resources)
+	}
+	deployConfig, err := config.LoadDeployConfig(job.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployConfig.ConcurrencyLimits["query"].MaxConcurrent != 4 {
+		t.Fatalf("preset concurrency limits should be used: %+v", deployConfig.ConcurrencyLimits)
+	}
+
+	// Updating the preset applies to new deployments.
+	preset["settings"] = map[string]interface{}{"memory": 4000}
+	if err := admin.Post("/deploy/presets").Json(preset).Do(&created); err != nil {
+		t.Fatal(err)
+	}
+	var updated services.DeploymentPresetInfo
+	if err := client.Get("/deploy/presets/small-ndb").Do(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Settings.Memory != 4000 || updated.Settings.ConcurrencyLimits != nil || !updated.CreatedAt.Equal(presets[0].CreatedAt) {
+		t.Fatalf("invalid updated preset: %+v", updated)
+	}
+
+	if err := client.Delete("/deploy/presets/small-ndb").Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should be able to delete presets: %v", err)
+	}
+	if err := admin.Delete("/deploy/presets/small-ndb").Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get("/deploy/presets/small-ndb").Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("preset should be deleted: %v", err)
+	}
+}
+
+func TestDeployPlacementRequiresKubernetes(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	placement := map[string]interface{}{"anti_affinity": "preferred", "max_unavailable": 1}
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"placement": placement}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "only supported on kubernetes") {
+		t.Fatalf("placement should be rejected on nomad: %v", err)
+	}
+}
+
+func TestDeployTeamIsolationRejectsSharedCaches(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := map[string]interface{}{"team_isolation": true, "llm_cache": map[string]int{"max_entries": 100}}
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(settings).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "llm cache cannot be used with team isolation") {
+		t.Fatalf("llm cache should be rejected with team isolation: %v", err)
+	}
+
+	if err := client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]interface{}{"team_isolation": true}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeployLogArchival(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each deployment is a new run of the deploy job, and its logs are archived
+	// when it is stopped.
+	for i := 0; i < 2; i++ {
+		if err := client.deploy(model); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.undeploy(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, attempt := range []int{1, 2} {
+		exists, err := env.storage.Exists(storage.JobLogArchivePath(uuid.MustParse(model), "deploy", attempt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("logs of deployment attempt %d should be archived", attempt)
+		}
+	}
+
+	deployLogs := func(query string) ([]orchestrator.JobLog, error) {
+		var logs []orchestrator.JobLog
+		err := client.Get(fmt.Sprintf("/deploy/%v/logs%v", model, query)).Do(&logs)
+		return logs, err
+	}
+
+	expected := "logs for deploy-ndb-" + model
+	for _, query := range []string{"", "?attempt=1", "?attempt=2"} {
+		logs, err := deployLogs(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 1 || logs[0].Stdout != expected {
+			t.Fatalf("invalid archived logs for query %q: %v", query, logs)
+		}
+	}
+
+	if _, err := deployLogs("?attempt=3"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("logs of a missing attempt should not be found: %v", err)
+	}
+	if _, err := deployLogs("?attempt=x"); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("invalid attempt should be rejected: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// concurrently runs the request n times in parallel and returns the number of
+// requests that succeeded. Every request that fails must fail with a conflict.
+func concurrently(t *testing.T, n int, request func() error) int {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = request()
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !strings.Contains(err.Error(), "status 409") {
+			t.Fatalf("duplicate request should fail with a conflict: %v", err)
+		}
+	}
+	return succeeded
+}
+
+func TestConcurrentDuplicateNames(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// The in memory sqlite db is only visible to the connection that created it.
+	sqlDb, err := env.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.SetMaxOpenConns(1)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := concurrently(t, 8, func() error {
+		_, err := user.startUpload("model")
+		return err
+	}); n != 1 {
+		t.Fatalf("expected exactly 1 model to be created, got %d", n)
+	}
+
+	if n := concurrently(t, 8, func() error {
+		_, err := admin.createTeam("team")
+		return err
+	}); n != 1 {
+		t.Fatalf("expected exactly 1 team to be created, got %d", n)
+	}
+
+	if n := concurrently(t, 8, func() error {
+		_, err := user.createAPIKey(nil, "key", time.Now().Add(time.Hour), true)
+		return err
+	}); n != 1 {
+		t.Fatalf("expected exactly 1 api key to be created, got %d", n)
+	}
+
+	// Names only need to be unique per user.
+	if _, err := admin.startUpload("model"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.createAPIKey(nil, "key", time.Now().Add(time.Hour), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuplicateNameConstraints(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// These inserts skip the checks in the endpoints, as would happen if two
+	// requests both passed the checks before either was committed.
+	userId := uuid.New()
+	for i := 0; i < 2; i++ {
+		model := schema.Model{
+			Id: uuid.New(), Name: "model", Type: schema.NdbModel, TrainStatus: schema.NotStarted, DeployStatus: schema.NotStarted, UserId: userId,
+		}
+		err := env.db.Create(&model).Error
+		if i == 1 && !errors.Is(err, gorm.ErrDuplicatedKey) {
+			t.Fatalf("duplicate model name should violate the unique index: %v", err)
+		} else if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		key := schema.UserAPIKey{
+			Id: uuid.New(), HashKey: fmt.Sprintf("hash-%d", i), Name: "key", ExpiryTime: time.Now(), CreatedBy: userId,
+		}
+		err := env.db.Create(&key).Error
+		if i == 1 && !errors.Is(err, gorm.ErrDuplicatedKey) {
+			t.Fatalf("duplicate api key name should violate the unique index: %v", err)
+		} else if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		err := env.db.Create(&schema.Team{Id: uuid.New(), Name: "team"}).Error
+		if i == 1 && !errors.Is(err, gorm.ErrDuplicatedKey) {
+			t.Fatalf("duplicate team name should violate the unique index: %v", err)
+		} else if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteUserWithDuplicateModelNames(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := user.userInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.startUpload("model"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.startUpload("model"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The models of deleted users are given to an admin, which cannot have two
+	// models with the same name.
+	err = admin.deleteUser(info.Id.String())
+	if err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("deleting the user should fail with a conflict: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+)
+
+func TestEntityDictionary(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNlpToken("guardrail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ndb, err := user.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/deploy/%v/dictionary", model)
+
+	var dictionary services.EntityDictionary
+	if err := user.Get(path).Do(&dictionary); err != nil {
+		t.Fatal(err)
+	}
+	if dictionary.Version != 0 || len(dictionary.Entries) != 0 {
+		t.Fatalf("model without a dictionary should have an empty dictionary: %+v", dictionary)
+	}
+
+	codename := map[string]interface{}{"pattern": "Project Falcon", "tag": "CODENAME"}
+	ticket := map[string]interface{}{"pattern": `TKT-\d{6}`, "tag": "TICKET", "regex": true, "case_sensitive": true}
+
+	for _, invalid := range []map[string]interface{}{
+		{"pattern": "", "tag": "CODENAME"},
+		{"pattern": "falcon", "tag": "O"},
+		{"pattern": "falcon", "tag": "code name"},
+		{"pattern": "([a-z]", "tag": "CODENAME", "regex": true},
+		{"pattern": "a*", "tag": "CODENAME", "regex": true},
+	} {
+		err := user.Post(path + "/entries").Json(map[string]interface{}{"entries": []interface{}{invalid}}).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid entry %v should be rejected: %v", invalid, err)
+		}
+	}
+	if err := user.Post(fmt.Sprintf("/deploy/%v/dictionary/entries", ndb)).Json(map[string]interface{}{"entries": []interface{}{codename}}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("dictionaries should only be supported for nlp-token models: %v", err)
+	}
+	if err := other.Post(path + "/entries").Json(map[string]interface{}{"entries": []interface{}{codename}}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users without write access should not add entries: %v", err)
+	}
+
+	if err := user.Post(path + "/entries").Json(map[string]interface{}{"entries": []interface{}{codename, ticket}}).Do(&dictionary); err != nil {
+		t.Fatal(err)
+	}
+	if dictionary.Version != 1 || len(dictionary.Entries) != 2 || dictionary.Entries[1].Tag != "TICKET" || !dictionary.Entries[1].Regex {
+		t.Fatalf("invalid dictionary %+v", dictionary)
+	}
+
+	duplicate := map[string]interface{}{"pattern": "project falcon", "tag": "PROJECT"}
+	if err := user.Post(path + "/entries").Json(map[string]interface{}{"entries": []interface{}{duplicate}}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("entries with the same pattern should be rejected: %v", err)
+	}
+
+	if err := user.Delete(fmt.Sprintf("%v/entries/%v", path, dictionary.Entries[0].Id)).Do(&dictionary); err != nil {
+		t.Fatal(err)
+	}
+	if dictionary.Version != 2 || len(dictionary.Entries) != 1 || dictionary.Entries[0].Tag != "TICKET" {
+		t.Fatalf("invalid dictionary after delete %+v", dictionary)
+	}
+
+	// The deployment polls the latest version with its job token.
+	var latest services.EntityDictionary
+	if err := user.Get("/deploy/dictionary-internal").Auth(getJobAuthToken(env, t, model)).Do(&latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 || len(latest.Entries) != 1 {
+		t.Fatalf("invalid internal dictionary %+v", latest)
+	}
+
+	if err := user.Post(path + "/rollback").Json(map[string]int{"version": 2}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rollback to the current version should be rejected: %v", err)
+	}
+	if err := user.Post(path + "/rollback").Json(map[string]int{"version": 1}).Do(&dictionary); err != nil {
+		t.Fatal(err)
+	}
+	if dictionary.Version != 3 || len(dictionary.Entries) != 2 || dictionary.Change != "rolled back to version 1" {
+		t.Fatalf("invalid dictionary after rollback %+v", dictionary)
+	}
+
+	var versions []services.EntityDictionaryVersionInfo
+	if err := other.Get(path + "/versions").Do(&versions); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users without read access should not list versions: %v", err)
+	}
+	if err := user.Get(path + "/versions").Do(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].Current || versions[0].NumEntries != 2 || versions[1].NumEntries != 1 || versions[2].Change != "added 2 entries" {
+		t.Fatalf("invalid versions %+v", versions)
+	}
+
+	if err := user.Get(path + "?version=2").Do(&dictionary); err != nil {
+		t.Fatal(err)
+	}
+	if dictionary.Version != 2 || len(dictionary.Entries) != 1 {
+		t.Fatalf("invalid dictionary version %+v", dictionary)
+	}
+	if err := user.Get(path + "?version=7").Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("missing version should return 404: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/utils"
+
+	"github.com/google/uuid"
+)
+
+// errorResponse sends a request that is expected to fail and returns the
+// parsed error body, after checking that the error code header matches it.
+func errorResponse(t *testing.T, c client, method, endpoint string, body interface{}) (int, utils.ErrorResponse) {
+	t.Helper()
+
+	data := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(data).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, endpoint, data)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.authToken))
+
+	w := httptest.NewRecorder()
+	c.api.ServeHTTP(w, req)
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("%v %v should fail", method, endpoint)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("error response should be json, got content type %v: %v", contentType, w.Body.String())
+	}
+
+	var res utils.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid error response %v: %v", w.Body.String(), err)
+	}
+	if res.Message == "" {
+		t.Fatalf("error response should have a message: %v", w.Body.String())
+	}
+	if header := w.Header().Get(utils.ErrorCodeHeader); header != res.Code {
+		t.Fatalf("error code header '%v' does not match body '%v'", header, res.Code)
+	}
+	return w.Code, res
+}
+
+func dummyNdbTrainRequest(name string) services.NdbTrainRequest {
+	return services.NdbTrainRequest{
+		ModelName:    name,
+		ModelOptions: &config.NdbOptions{},
+		Data: config.NDBData{
+			UnsupervisedFiles: []config.TrainFile{{Path: "n/a", Location: "s3"}},
+		},
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		UserJobLimits: services.JobLimits{MaxTrainJobs: 1},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndbId, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name     string
+		method   string
+		endpoint string
+		body     interface{}
+		status   int
+		code     string
+	}{
+		{"duplicate model", "POST", "/workflow/enterprise-search", services.EnterpriseSearchRequest{ModelName: "xyz", RetrievalId: uuid.MustParse(ndbId)}, http.StatusConflict, utils.ErrorCodeDuplicateName},
+		{"user job limit", "POST", "/train/ndb", dummyNdbTrainRequest("other"), http.StatusTooManyRequests, utils.ErrorCodeQuotaExceeded},
+		{"missing model", "GET", fmt.Sprintf("/model/%v", uuid.New()), nil, http.StatusNotFound, utils.ErrorCodeModelNotFound},
+		{"missing workflow component", "POST", "/workflow/enterprise-search", services.EnterpriseSearchRequest{ModelName: "search", RetrievalId: uuid.New()}, http.StatusNotFound, utils.ErrorCodeModelNotFound},
+		// Errors without a code still use the json envelope.
+		{"invalid body", "POST", "/train/ndb", "abc", http.StatusBadRequest, ""},
+	}
+
+	for _, check := range checks {
+		status, res := errorResponse(t, user, check.method, check.endpoint, check.body)
+		if status != check.status || res.Code != check.code {
+			t.Fatalf("%v: expected status %d and code '%v', got %d and '%v': %v", check.name, check.status, check.code, status, res.Code, res.Message)
+		}
+	}
+}
+
+func TestLicenseLimitErrorCode(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use all of the cpu allowed by the license.
+	env.nomad.cpuUsage = 100000000
+
+	status, res := errorResponse(t, user, "POST", "/train/ndb", dummyNdbTrainRequest("xyz"))
+	if status != http.StatusForbidden || res.Code != utils.ErrorCodeLicenseLimit {
+		t.Fatalf("expected license limit error, got %d %+v", status, res)
+	}
+}
+
+func TestInvalidRequestFields(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSplit := float32(1.5)
+	request := services.NlpTokenTrainRequest{
+		ModelOptions: &config.NlpTokenOptions{SourceColumn: "text"},
+		Data: config.NlpData{
+			SupervisedFiles: []config.TrainFile{{Path: "a.csv", Location: "s3"}, {Path: "b.csv", Location: "ftp"}},
+		},
+		TrainOptions: config.NlpTrainOptions{TestSplit: &testSplit},
+	}
+
+	status, res := errorResponse(t, user, "POST", "/train/nlp-token", request)
+	if status != http.StatusUnprocessableEntity || res.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("expected invalid request error, got %d %+v", status, res)
+	}
+
+	fields := make([]string, 0, len(res.Fields))
+	for _, field := range res.Fields {
+		if field.Message == "" {
+			t.Fatalf("field error should have a message: %+v", field)
+		}
+		fields = append(fields, field.Field)
+	}
+	expected := []string{"model_name", "model_options.target_column", "data.supervised_files[1].location", "train_options.test_split"}
+	if !slices.Equal(fields, expected) {
+		t.Fatalf("expected errors for fields %v, got %+v", expected, res.Fields)
+	}
+
+	status, res = errorResponse(t, user, "POST", "/workflow/ensemble", services.EnsembleRequest{ModelName: "ensemble", Strategy: "max"})
+	if status != http.StatusUnprocessableEntity || len(res.Fields) != 2 || res.Fields[0].Field != "model_ids" || res.Fields[1].Field != "strategy" {
+		t.Fatalf("expected errors for model_ids and strategy, got %d %+v", status, res)
+	}
+}
@@ -0,0 +1,358 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/events"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func (c *client) listEvents(cursor uint64, wait int) (services.EventsResponse, error) {
+	var res services.EventsResponse
+	err := c.Get(fmt.Sprintf("/events?cursor=%d&wait=%d", cursor, wait)).Do(&res)
+	return res, err
+}
+
+func eventTypes(events []services.EventInfo) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestEventStream(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = user.listEvents(0, 0)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatal("only admins can access the event stream")
+	}
+
+	if _, err := admin.createTeam("team"); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+	if err := updateTrainStatus(user, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := admin.listEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		schema.TeamCreatedEvent,
+		schema.ModelCreatedEvent,
+		schema.TrainStatusEvent,
+		schema.TrainStatusEvent,
+		schema.DeployStatusEvent,
+		schema.DeploymentStartedEvent,
+	}
+	if !slices.Equal(eventTypes(res.Events), expected) {
+		t.Fatalf("invalid events: %v", eventTypes(res.Events))
+	}
+	for _, e := range res.Events[1:] {
+		if e.ModelId == nil || e.ModelId.String() != model {
+			t.Fatalf("invalid model id for event %v", e)
+		}
+	}
+	if res.Cursor != res.Events[len(res.Events)-1].Id {
+		t.Fatal("cursor should point to last event")
+	}
+
+	cursor := res.Cursor
+
+	res, err = admin.listEvents(cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 0 || res.Cursor != cursor {
+		t.Fatal("expected no new events")
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = user.undeploy(model)
+	}()
+
+	res, err = admin.listEvents(cursor, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(eventTypes(res.Events), []string{schema.DeployStatusEvent, schema.DeploymentStoppedEvent}) {
+		t.Fatalf("invalid events from long poll: %v", eventTypes(res.Events))
+	}
+}
+
+func TestEventStreamOutOfOrderCommit(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.createTeam("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := admin.listEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := res.Cursor
+
+	// The event with id cursor+1 is recorded by a transaction that commits
+	// after the transaction that recorded the event with id cursor+2.
+	createEvent := func(id uint64, timestamp time.Time) {
+		event := schema.PlatformEvent{Id: id, Type: schema.TeamCreatedEvent, Timestamp: timestamp}
+		if err := env.db.Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	createEvent(cursor+2, time.Now().UTC())
+
+	res, err = admin.listEvents(cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 0 || res.Cursor != cursor {
+		t.Fatalf("events after an uncommitted event should be held back: %v", res.Events)
+	}
+
+	createEvent(cursor+1, time.Now().UTC())
+
+	res, err = admin.listEvents(cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 2 || res.Events[0].Id != cursor+1 || res.Events[1].Id != cursor+2 || res.Cursor != cursor+2 {
+		t.Fatalf("expected both events once committed: %v", res.Events)
+	}
+	cursor = res.Cursor
+
+	// Gaps older than the commit lag are from rolled back transactions.
+	createEvent(cursor+2, time.Now().UTC().Add(-time.Minute))
+
+	res, err = admin.listEvents(cursor, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 1 || res.Cursor != cursor+2 {
+		t.Fatalf("old gaps should be skipped: %v", res.Events)
+	}
+}
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	payloads []events.Payload
+	failures int
+}
+
+func (p *recordingPublisher) Publish(payload events.Payload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("event bus unavailable")
+	}
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func (p *recordingPublisher) Name() string {
+	return "test"
+}
+
+func (p *recordingPublisher) published() []events.Payload {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.payloads)
+}
+
+func TestEventRelay(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := admin.createTeam(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The relay is only run while nothing else accesses the db, since each
+	// connection to the in memory sqlite db used for tests is a separate db.
+	publisher := &recordingPublisher{failures: 1}
+	relay := services.NewEventRelay(env.db, publisher)
+	done := make(chan bool)
+	go func() {
+		relay.Run(50 * time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(time.Second)
+	relay.Stop()
+	<-done
+
+	published := publisher.published()
+	if len(published) != 3 {
+		t.Fatalf("expected 3 published events, got %d", len(published))
+	}
+	for i, p := range published {
+		if p.SchemaVersion != events.SchemaVersion || p.Type != schema.TeamCreatedEvent || (i > 0 && p.Id <= published[i-1].Id) {
+			t.Fatalf("invalid published event: %v", p)
+		}
+	}
+
+	var cursor schema.EventPublisherCursor
+	if err := env.db.First(&cursor, "publisher = ?", "test").Error; err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Cursor != published[2].Id {
+		t.Fatal("relay cursor should be persisted after delivery")
+	}
+
+	var undelivered int64
+	if err := env.db.Model(&schema.PlatformEvent{}).Where("delivered_at IS NULL").Count(&undelivered).Error; err != nil {
+		t.Fatal(err)
+	}
+	if undelivered != 0 {
+		t.Fatalf("published events should be marked as delivered, %d are not", undelivered)
+	}
+
+	// An event whose transaction commits after later events were delivered is
+	// still published.
+	late := schema.PlatformEvent{Id: published[2].Id + 2, Type: schema.TeamCreatedEvent, Timestamp: time.Now().UTC()}
+	if err := env.db.Create(&late).Error; err != nil {
+		t.Fatal(err)
+	}
+	late = schema.PlatformEvent{Id: published[2].Id + 1, Type: schema.TeamCreatedEvent, Timestamp: time.Now().UTC()}
+	if err := env.db.Create(&late).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Model(&schema.PlatformEvent{}).Where("id = ?", published[2].Id+2).Update("delivered_at", time.Now().UTC()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	relay = services.NewEventRelay(env.db, publisher)
+	done = make(chan bool)
+	go func() {
+		relay.Run(50 * time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	relay.Stop()
+	<-done
+
+	published = publisher.published()
+	if len(published) != 4 || published[3].Id != late.Id {
+		t.Fatalf("late event should be published once: %v", published)
+	}
+}
+
+func TestEventRelayStopDuringRetry(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.createTeam("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &recordingPublisher{failures: 100}
+	relay := services.NewEventRelay(env.db, publisher)
+	done := make(chan bool)
+	go func() {
+		relay.Run(50 * time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	relay.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay should stop without waiting for the retry backoff")
+	}
+
+	var undelivered int64
+	if err := env.db.Model(&schema.PlatformEvent{}).Where("delivered_at IS NULL").Count(&undelivered).Error; err != nil {
+		t.Fatal(err)
+	}
+	if undelivered != 1 {
+		t.Fatal("event that was not published should not be marked as delivered")
+	}
+}
+
+func TestKafkaRestPublisher(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/platform-events" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer server.Close()
+
+	publisher, err := events.NewPublisher(events.PublisherArgs{Kind: "kafka", Url: server.URL, Topic: "platform-events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := publisher.Publish(events.Payload{SchemaVersion: events.SchemaVersion, Id: 1, Type: schema.TeamCreatedEvent}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := received["records"].([]interface{})
+	record := records[0].(map[string]interface{})
+	if record["key"] != schema.TeamCreatedEvent || record["value"].(map[string]interface{})["schema_version"] != float64(1) {
+		t.Fatalf("invalid record: %v", record)
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// deploymentStub serves the endpoints of deployments, responding with the model
+// the request was sent to.
+type deploymentStub struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *deploymentStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path)
+	s.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if parts[1] == "query" && r.URL.Query().Get("fail") == "true" {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"model_id": parts[0], "path": parts[1]})
+}
+
+func markDeployed(t *testing.T, env *testEnv, modelId string) {
+	if err := env.db.Model(&schema.Model{}).Where("id = ?", modelId).Update("deploy_status", schema.Complete).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeploymentExperiments(t *testing.T) {
+	deployment := &deploymentStub{}
+	server := httptest.NewServer(deployment)
+	defer server.Close()
+
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, ModelBazaarEndpoint: server.URL})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelA, err := user.trainNdbDummyFile("model-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelB, err := user.trainNdbDummyFile("model-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := map[string]interface{}{"name": "relevance", "model_a_id": modelA, "model_b_id": modelB, "split_percent": 0}
+
+	if err := user.Post("/deploy/experiments").Json(create).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("experiments should require deployed models: %v", err)
+	}
+
+	markDeployed(t, env, modelA)
+	markDeployed(t, env, modelB)
+
+	if err := other.Post("/deploy/experiments").Json(create).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("experiments should require ownership of both models: %v", err)
+	}
+	for _, split := range []int{-1, 101} {
+		invalid := map[string]interface{}{"name": "relevance", "model_a_id": modelA, "model_b_id": modelB, "split_percent": split}
+		if err := user.Post("/deploy/experiments").Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("split %d should be rejected: %v", split, err)
+		}
+	}
+
+	var experiment services.ExperimentInfo
+	if err := user.Post("/deploy/experiments").Json(create).Do(&experiment); err != nil {
+		t.Fatal(err)
+	}
+	if experiment.Name != "relevance" || len(experiment.Arms) != 2 || experiment.Arms[0].ModelId.String() != modelA || experiment.Arms[1].ModelId.String() != modelB {
+		t.Fatalf("invalid experiment: %+v", experiment)
+	}
+	if err := user.Post("/deploy/experiments").Json(create).Do(nil); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("experiment names should be unique: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("/deploy/experiments/%v/endpoint", experiment.Id)
+	query := func(c *client, params string) (string, error) {
+		var res map[string]string
+		err := c.Post(endpoint + "/query" + params).Json(map[string]interface{}{"query": "test"}).Do(&res)
+		return res["model_id"], err
+	}
+
+	// With a split of 0 every caller is assigned to model a.
+	if model, err := query(&user, ""); err != nil || model != modelA {
+		t.Fatalf("expected query to be sent to model a, got %v: %v", model, err)
+	}
+	if _, err := query(&other, ""); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("callers need access to both models: %v", err)
+	}
+
+	if err := user.Patch(fmt.Sprintf("/deploy/experiments/%v", experiment.Id)).Json(map[string]interface{}{"split_percent": 100}).Do(&experiment); err != nil {
+		t.Fatal(err)
+	}
+	if experiment.SplitPercent != 100 {
+		t.Fatalf("split was not updated: %+v", experiment)
+	}
+
+	// The assignment of a caller is sticky.
+	for i := 0; i < 3; i++ {
+		model, err := query(&user, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if model != modelB {
+			t.Fatalf("expected query to be sent to model b, got %v", model)
+		}
+	}
+	if _, err := query(&user, "?fail=true"); err == nil {
+		t.Fatal("failed query should return an error")
+	}
+	if err := user.Post(endpoint + "/upvote").Json(map[string]interface{}{}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get(endpoint + "/sources").Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.Get(fmt.Sprintf("/deploy/experiments/%v", experiment.Id)).Do(&experiment); err != nil {
+		t.Fatal(err)
+	}
+	armA, armB := experiment.Arms[0], experiment.Arms[1]
+	if armA.Queries != 1 || armA.QueryErrors != 0 || armA.Feedback != 0 {
+		t.Fatalf("invalid metrics for arm a: %+v", armA)
+	}
+	if armB.Queries != 4 || armB.QueryErrors != 1 || armB.Feedback != 1 {
+		t.Fatalf("invalid metrics for arm b: %+v", armB)
+	}
+
+	deployment.mu.Lock()
+	if last := deployment.requests[len(deployment.requests)-1]; last != "/"+modelB+"/sources" {
+		t.Fatalf("invalid proxied path %v", last)
+	}
+	deployment.mu.Unlock()
+
+	// API keys must have access to both models.
+	keyA, err := user.createAPIKey([]uuid.UUID{uuid.MustParse(modelA)}, "key-a", time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBoth, err := user.createAPIKey([]uuid.UUID{uuid.MustParse(modelA), uuid.MustParse(modelB)}, "key-both", time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyClient := &client{api: env.api}
+	_ = keyClient.UseApiKey(keyA)
+	if _, err := query(keyClient, ""); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("api key without access to model b should be rejected: %v", err)
+	}
+	_ = keyClient.UseApiKey(keyBoth)
+	if model, err := query(keyClient, ""); err != nil || model != modelB {
+		t.Fatalf("api key query failed: %v %v", model, err)
+	}
+
+	if err := other.Get(fmt.Sprintf("/deploy/experiments/%v", experiment.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the creator should be able to view the experiment: %v", err)
+	}
+
+	if err := user.Patch(fmt.Sprintf("/deploy/experiments/%v", experiment.Id)).Json(map[string]interface{}{"reset_metrics": true}).Do(&experiment); err != nil {
+		t.Fatal(err)
+	}
+	if experiment.Arms[1].Queries != 0 || experiment.Arms[1].Feedback != 0 {
+		t.Fatalf("metrics were not reset: %+v", experiment.Arms[1])
+	}
+
+	var experiments []services.ExperimentInfo
+	if err := other.Get("/deploy/experiments").Do(&experiments); err != nil || len(experiments) != 0 {
+		t.Fatalf("users should only see their own experiments: %v %v", experiments, err)
+	}
+
+	if err := user.Delete(fmt.Sprintf("/deploy/experiments/%v", experiment.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query(&user, ""); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deleted experiment should not be found: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+)
+
+const (
+	testRequestSchema  = `{"type": "object", "required": ["inputs"]}`
+	testResponseSchema = `{"type": "object", "required": ["outputs"]}`
+)
+
+func externalModelArchive(t *testing.T, attrs map[string]string, files map[string]string) []byte {
+	metadata, err := json.Marshal(services.ModelMetadata{Type: "external", Attributes: attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	files["metadata.json"] = string(metadata)
+	for name, data := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func onnxModelAttrs() map[string]string {
+	return map[string]string{"runtime": "onnx", "request_schema": testRequestSchema, "response_schema": testResponseSchema}
+}
+
+func containerModelAttrs() map[string]string {
+	return map[string]string{
+		"runtime":         "container",
+		"image":           "registry.example.com/inference/server:v2",
+		"port":            "9000",
+		"request_schema":  testRequestSchema,
+		"response_schema": testResponseSchema,
+	}
+}
+
+func TestUploadExternalModel(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withAttrs := func(update func(attrs map[string]string)) map[string]string {
+		attrs := containerModelAttrs()
+		update(attrs)
+		return attrs
+	}
+
+	invalid := []struct {
+		name  string
+		attrs map[string]string
+		files map[string]string
+	}{
+		{"missing-onnx", onnxModelAttrs(), map[string]string{}},
+		{"bad-runtime", withAttrs(func(a map[string]string) { a["runtime"] = "tensorflow" }), map[string]string{}},
+		{"bad-image", withAttrs(func(a map[string]string) { a["image"] = "registry/image:v1\nmalicious" }), map[string]string{}},
+		{"bad-port", withAttrs(func(a map[string]string) { a["port"] = "80" }), map[string]string{}},
+		{"missing-schema", withAttrs(func(a map[string]string) { delete(a, "response_schema") }), map[string]string{}},
+		{"bad-schema", withAttrs(func(a map[string]string) { a["request_schema"] = "[1, 2]" }), map[string]string{}},
+	}
+	for _, c := range invalid {
+		_, err := user.uploadModel(c.name, bytes.NewReader(externalModelArchive(t, c.attrs, c.files)), 1000)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("upload %v should fail: %v", c.name, err)
+		}
+	}
+
+	onnx, err := user.uploadModel("onnx", bytes.NewReader(externalModelArchive(t, onnxModelAttrs(), map[string]string{"model.onnx": "onnx"})), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := user.modelInfo(onnx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "external" || info.Attributes["runtime"] != "onnx" || info.Attributes["request_schema"] != testRequestSchema {
+		t.Fatalf("invalid model info: %+v", info)
+	}
+
+	if _, err := user.uploadModel("container", bytes.NewReader(externalModelArchive(t, containerModelAttrs(), map[string]string{})), 1000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeployExternalModel(t *testing.T) {
+	driver := orchestrator.DockerDriver{ImageName: "thirdai_platform_jobs", Tag: "v1", DockerEnv: orchestrator.DockerEnv{Registry: "registry"}}
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: driver})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onnx, err := user.uploadModel("onnx", bytes.NewReader(externalModelArchive(t, onnxModelAttrs(), map[string]string{"model.onnx": "onnx"})), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err := user.uploadModel("container", bytes.NewReader(externalModelArchive(t, containerModelAttrs(), map[string]string{})), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deploy(onnx); err != nil {
+		t.Fatal(err)
+	}
+	job := env.nomad.submitted[fmt.Sprintf("deploy-external-%v", onnx)].(orchestrator.DeployJob)
+	if job.ExternalImage != "" || !slices.Equal(orchestrator.JobImages(job), []string{"registry/thirdai_platform_jobs:v1"}) {
+		t.Fatalf("onnx models should not have a sidecar: %+v", job)
+	}
+
+	if err := user.deploy(container); err != nil {
+		t.Fatal(err)
+	}
+	job = env.nomad.submitted[fmt.Sprintf("deploy-external-%v", container)].(orchestrator.DeployJob)
+	if job.ExternalImage != "registry.example.com/inference/server:v2" || job.ExternalPort != 9000 {
+		t.Fatalf("invalid external model job: %+v", job)
+	}
+	if images := orchestrator.JobImages(job); !slices.Equal(images, []string{"registry/thirdai_platform_jobs:v1", "registry.example.com/inference/server:v2"}) {
+		t.Fatalf("the inference server image should be scanned: %v", images)
+	}
+}
+
+func TestDeployExternalContainerRequiresDocker(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container, err := user.uploadModel("container", bytes.NewReader(externalModelArchive(t, containerModelAttrs(), map[string]string{})), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.deploy(container)
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "docker driver") {
+		t.Fatalf("container models should require the docker driver: %v", err)
+	}
+}
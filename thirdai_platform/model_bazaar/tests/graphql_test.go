@@ -0,0 +1,286 @@
+package tests
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+)
+
+type graphqlModel struct {
+	Id           string         `json:"id"`
+	Name         string         `json:"name"`
+	Owner        *graphqlUser   `json:"owner"`
+	Dependencies []graphqlModel `json:"dependencies"`
+}
+
+type graphqlUser struct {
+	Username string `json:"username"`
+}
+
+func (c *client) graphql(query string, variables map[string]interface{}, result interface{}) ([]map[string]interface{}, error) {
+	var res struct {
+		Data   json.RawMessage          `json:"data"`
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	err := c.Post("/graphql").Json(services.GraphQLRequest{Query: query, Variables: variables}).Do(&res)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(res.Data, result); err != nil {
+		return nil, err
+	}
+	return res.Errors, nil
+}
+
+func TestGraphQLNestedModelQuery(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := user.trainNdbDummyFile("ndb-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nlp, err := user.trainNlpToken("nlp-token-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es, err := user.createEnterpriseSearch("search", ndb, nlp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := `
+		query GetModel($id: String!) {
+			search: model(id: $id) {
+				...modelFields
+				dependencies { ...modelFields owner { username } }
+			}
+		}
+		fragment modelFields on Model { id name }
+	`
+
+	var data struct {
+		Search graphqlModel `json:"search"`
+	}
+	errs, err := user.graphql(query, map[string]interface{}{"id": es}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected graphql errors: %v", errs)
+	}
+
+	if data.Search.Id != es || data.Search.Name != "search" || len(data.Search.Dependencies) != 2 {
+		t.Fatalf("invalid model returned: %v", data.Search)
+	}
+
+	depIds := []string{}
+	for _, dep := range data.Search.Dependencies {
+		if dep.Owner == nil || dep.Owner.Username != "abc" {
+			t.Fatalf("invalid dependency owner: %v", dep)
+		}
+		depIds = append(depIds, dep.Id)
+	}
+	slices.Sort(depIds)
+	expected := []string{ndb, nlp}
+	slices.Sort(expected)
+	if !slices.Equal(depIds, expected) {
+		t.Fatalf("invalid dependencies: %v", depIds)
+	}
+}
+
+func TestGraphQLModelVisibility(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user1.trainNdbDummyFile("private-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data struct {
+		Models []graphqlModel `json:"models"`
+	}
+
+	errs, err := user1.graphql("{ models { id } }", nil, &data)
+	if err != nil || len(errs) != 0 {
+		t.Fatal(err, errs)
+	}
+	if len(data.Models) != 1 || data.Models[0].Id != model {
+		t.Fatalf("owner should see model: %v", data.Models)
+	}
+
+	errs, err = user2.graphql("{ models { id } }", nil, &data)
+	if err != nil || len(errs) != 0 {
+		t.Fatal(err, errs)
+	}
+	if len(data.Models) != 0 {
+		t.Fatalf("other user should not see private model: %v", data.Models)
+	}
+
+	var single struct {
+		Model *graphqlModel `json:"model"`
+	}
+	errs, err = user2.graphql(`{ model(id: "`+model+`") { id } }`, nil, &single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || single.Model != nil {
+		t.Fatal("other user should not be able to query private model")
+	}
+
+	errs, err = user1.graphql("{ models { unknownField } }", nil, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatal("expected error for unknown field")
+	}
+
+	err = user1.Post("/graphql").Json(services.GraphQLRequest{Query: "mutation { deleteModel }"}).Do(nil)
+	if err != nil {
+		t.Fatal("syntax errors should be reported in the response body")
+	}
+}
+
+func TestGraphQLUserEmailVisibility(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user1.trainNdbDummyFile("public-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user1.updateAccess(model, "public", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ownerEmail := func(c client) *string {
+		var data struct {
+			Model *struct {
+				Owner struct {
+					Username string  `json:"username"`
+					Email    *string `json:"email"`
+				} `json:"owner"`
+			} `json:"model"`
+		}
+		errs, err := c.graphql(`{ model(id: "`+model+`") { owner { username email } } }`, nil, &data)
+		if err != nil || len(errs) != 0 || data.Model == nil || data.Model.Owner.Username != "abc" {
+			t.Fatal(err, errs, data.Model)
+		}
+		return data.Model.Owner.Email
+	}
+
+	if email := ownerEmail(user1); email == nil || *email != "abc@mail.com" {
+		t.Fatalf("users should see their own email: %v", email)
+	}
+	if email := ownerEmail(admin); email == nil || *email != "abc@mail.com" {
+		t.Fatalf("admins should see the owner's email: %v", email)
+	}
+	if email := ownerEmail(user2); email != nil {
+		t.Fatalf("users outside the owner's teams should not see the owner's email: %v", *email)
+	}
+
+	team, err := admin.createTeam("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []client{user1, user2} {
+		if err := admin.addUserToTeam(team, c.userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if email := ownerEmail(user2); email == nil || *email != "abc@mail.com" {
+		t.Fatalf("team members should see each other's email: %v", email)
+	}
+}
+
+func TestGraphQLQueryLimits(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queryError := func(query string) string {
+		var data map[string]interface{}
+		errs, err := user.graphql(query, nil, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != 1 || data != nil {
+			t.Fatalf("query should be rejected: %v %v", errs, data)
+		}
+		return errs[0]["message"].(string)
+	}
+
+	recursive := `query { me { ...F } } fragment F on User { teams { user { ...F } } }`
+	if msg := queryError(recursive); !strings.Contains(msg, "fragment F is recursive") {
+		t.Fatalf("recursive fragment should be rejected: %v", msg)
+	}
+
+	deep := "{ me " + strings.Repeat("{ teams { user ", 5) + "{ username }" + strings.Repeat("} } ", 5) + "}"
+	if msg := queryError(deep); !strings.Contains(msg, "maximum depth") {
+		t.Fatalf("deep query should be rejected: %v", msg)
+	}
+
+	wide := "{ me { " + strings.Repeat("username ", 500) + "} }"
+	if msg := queryError(wide); !strings.Contains(msg, "maximum of 500 fields") {
+		t.Fatalf("query with too many fields should be rejected: %v", msg)
+	}
+
+	nested := "{ models(ids: " + strings.Repeat("[", 100) + strings.Repeat("]", 100) + ") { id } }"
+	if msg := queryError(nested); !strings.Contains(msg, "nested more than") {
+		t.Fatalf("deeply nested document should be rejected: %v", msg)
+	}
+
+	// Fragments can be used more than once as long as they do not spread
+	// themselves.
+	var data struct {
+		Me struct {
+			Username string `json:"username"`
+			Teams    []struct {
+				User struct {
+					Username string `json:"username"`
+				} `json:"user"`
+			} `json:"teams"`
+		} `json:"me"`
+	}
+	errs, err := user.graphql(`query { me { ...U teams { user { ...U } } } } fragment U on User { username }`, nil, &data)
+	if err != nil || len(errs) != 0 || data.Me.Username != "abc" {
+		t.Fatal(err, errs, data)
+	}
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/mail"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *fakeMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *fakeMailer) messages() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
+
+// metricsServer serves deployment traffic from the given function, which
+// returns the number of requests for each model.
+func metricsServer(t *testing.T, traffic func() map[string]int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || !strings.Contains(r.URL.Query().Get("query"), "ndb_query_count") {
+			http.Error(w, "invalid query", http.StatusBadRequest)
+			return
+		}
+		results := []string{}
+		for modelId, requests := range traffic() {
+			results = append(results, fmt.Sprintf(`{"metric":{"model_id":"%v"},"value":[1700000000,"%d"]}`, modelId, requests))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%v]}}`, strings.Join(results, ","))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func addJobRun(t *testing.T, env *testEnv, job, status string) {
+	ended := time.Now().UTC().Add(-time.Hour)
+	run := schema.JobRun{
+		JobName: job + "-" + uuid.NewString(), ModelId: uuid.New(), Job: job, Attempt: 1,
+		StartedAt: ended.Add(-time.Hour), EndedAt: &ended, Status: status,
+	}
+	if err := env.db.Create(&run).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type healthReportResponse struct {
+	sharedReportResponse
+	EmailedTo []string `json:"emailed_to"`
+}
+
+func TestHealthReport(t *testing.T) {
+	mailer := &fakeMailer{}
+	var modelId string
+	unknownModel := uuid.NewString()
+	metrics := metricsServer(t, func() map[string]int {
+		return map[string]int{modelId: 1234, unknownModel: 56}
+	})
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{}, Mailer: mailer, MetricsEndpoint: metrics.URL,
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modelId, err = admin.trainNdbDummyFile("busy-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addJobRun(t, env, "train", schema.Complete)
+	addJobRun(t, env, "train", schema.Complete)
+	addJobRun(t, env, "train", schema.Failed)
+	addJobRun(t, env, "deploy", schema.Complete)
+
+	for i := 0; i < 3; i++ {
+		c := env.newClient()
+		if err := c.login(loginInfo{Email: "abc@mail.com", Password: "wrong"}); err == nil {
+			t.Fatal("login should fail with the wrong password")
+		}
+	}
+
+	if err := user.Post("/report/health").Json(map[string]interface{}{}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can create health reports: %v", err)
+	}
+
+	var res healthReportResponse
+	if err := admin.Post("/report/health").Json(map[string]interface{}{"email": true}).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Kind != services.HealthReportKind || !slices.Equal(res.EmailedTo, []string{adminEmail}) {
+		t.Fatalf("invalid health report response: %+v", res)
+	}
+
+	code, _, body := getSharedReport(env, res.Link)
+	if code != http.StatusOK {
+		t.Fatalf("unable to get shared health report: %d", code)
+	}
+	for _, expected := range []string{
+		"Job Success Rates", "66.7%", "100.0%",
+		"CPU Headroom (MHz)",
+		"busy-model", "1234", unknownModel,
+		"3 logins failed for 1 emails", "abc@mail.com",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("health report should contain %q:\n%v", expected, body)
+		}
+	}
+
+	sent := mailer.messages()
+	if len(sent) != 1 || !slices.Equal(sent[0].To, []string{adminEmail}) || !strings.Contains(sent[0].Html, "busy-model") {
+		t.Fatalf("health report should be emailed to admins: %+v", sent)
+	}
+}
+
+func TestHealthReportWithoutMailer(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = admin.Post("/report/health").Json(map[string]interface{}{"email": true}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("health report cannot be emailed without smtp: %v", err)
+	}
+
+	// The metrics endpoint is not reachable in the test, which should not
+	// prevent the report from being created.
+	var res healthReportResponse
+	if err := admin.Post("/report/health").Json(map[string]interface{}{}).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body := getSharedReport(env, res.Link)
+	if !strings.Contains(body, "Deployment traffic could not be retrieved") {
+		t.Fatalf("health report should note that traffic is unavailable:\n%v", body)
+	}
+}
+
+func TestWeeklyHealthReport(t *testing.T) {
+	mailer := &fakeMailer{}
+	metrics := metricsServer(t, func() map[string]int { return nil })
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{}, Mailer: mailer, MetricsEndpoint: metrics.URL,
+	})
+
+	sqlDb, err := env.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.SetMaxOpenConns(1)
+
+	// The report from the previous week is used for the storage trend.
+	now := time.Now().UTC()
+	thisWeek := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	previous := schema.HealthReportRun{
+		WeekStart: lastWeek, SentAt: lastWeek, StorageUsedBytes: 1, StorageTotalBytes: 1 << 40, Recipients: 1, Status: services.HealthReportSent,
+	}
+	if err := env.db.Create(&previous).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	time.Sleep(600 * time.Millisecond)
+
+	sent := mailer.messages()
+	if len(sent) != 1 {
+		t.Fatalf("health report should be sent once per week, sent %d times", len(sent))
+	}
+	if !slices.Equal(sent[0].To, []string{adminEmail}) || !strings.Contains(sent[0].Html, lastWeek.Format("2006-01-02")) {
+		t.Fatalf("invalid weekly health report: %+v", sent[0])
+	}
+
+	var run schema.HealthReportRun
+	if err := env.db.First(&run, "week_start = ?", thisWeek).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != services.HealthReportSent || run.Recipients != 1 || run.StorageTotalBytes == 0 {
+		t.Fatalf("invalid health report run: %+v", run)
+	}
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+)
+
+func readyz(t *testing.T, env *testEnv) (int, services.ReadinessResponse) {
+	w := httptest.NewRecorder()
+	env.modelBazaar.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	var res services.ReadinessResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, res
+}
+
+func TestHealthProbes(t *testing.T) {
+	env := setupTestEnv(t)
+
+	w := httptest.NewRecorder()
+	env.modelBazaar.Healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("healthz should succeed: %d", w.Code)
+	}
+
+	status, res := readyz(t, env)
+	if status != http.StatusOK || !res.Ready || len(res.Checks) != 4 {
+		t.Fatalf("model bazaar should be ready: %d %+v", status, res)
+	}
+	for _, check := range res.Checks {
+		if !check.Ok || check.Error != "" {
+			t.Fatalf("check should pass: %+v", check)
+		}
+	}
+
+	files, err := env.storage.List(".readyz")
+	if err == nil && len(files) > 0 {
+		t.Fatalf("readiness check should clean up its files: %v", files)
+	}
+
+	db, err := env.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, res = readyz(t, env)
+	if status != http.StatusServiceUnavailable || res.Ready {
+		t.Fatalf("model bazaar should not be ready without a database: %d %+v", status, res)
+	}
+	for _, check := range res.Checks {
+		if (check.Name == "database") == check.Ok {
+			t.Fatalf("only the database check should fail: %+v", res.Checks)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	env.modelBazaar.Healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("healthz should not depend on the database: %d", w.Code)
+	}
+}
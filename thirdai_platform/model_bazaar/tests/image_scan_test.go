@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"thirdai_platform/model_bazaar/imagescan"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+
+	"gorm.io/gorm"
+)
+
+func trivyVuln(id, severity string) map[string]string {
+	return map[string]string{"VulnerabilityID": id, "Severity": severity}
+}
+
+func mockTrivyServer(t *testing.T, scans *atomic.Int32) *httptest.Server {
+	reports := map[string]interface{}{
+		"registry/jobs:v1": map[string]interface{}{
+			"Metadata": map[string]interface{}{"RepoDigests": []string{"registry/jobs@sha256:abc"}},
+			"Results": []map[string]interface{}{
+				{"Vulnerabilities": []map[string]string{trivyVuln("CVE-1", "CRITICAL"), trivyVuln("CVE-2", "HIGH")}},
+				{"Vulnerabilities": []map[string]string{trivyVuln("CVE-1", "CRITICAL"), trivyVuln("CVE-3", "HIGH"), trivyVuln("CVE-4", "LOW")}},
+			},
+		},
+		"registry/jobs:v2": map[string]interface{}{
+			"Metadata": map[string]interface{}{"RepoDigests": []string{"registry/jobs@sha256:def"}},
+			"Results":  []map[string]interface{}{{"Vulnerabilities": []map[string]string{trivyVuln("CVE-4", "LOW")}}},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scans.Add(1)
+		report, ok := reports[r.URL.Query().Get("image")]
+		if !ok {
+			http.Error(w, "unknown image", http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func setupImageScanEnv(t *testing.T, tag, scannerUrl string, policy imagescan.Policy) *testEnv {
+	driver := orchestrator.DockerDriver{ImageName: "jobs", Tag: tag, DockerEnv: orchestrator.DockerEnv{Registry: "registry"}}
+	return setupTestEnvWithOrchestrator(t, services.Variables{BackendDriver: driver}, func(c orchestrator.Client, db *gorm.DB) orchestrator.Client {
+		return imagescan.NewClient(c, imagescan.NewTrivyScanner(scannerUrl), policy, db)
+	})
+}
+
+func listImageScans(t *testing.T, env *testEnv, query string) []services.ImageScanInfo {
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scans []services.ImageScanInfo
+	if err := admin.Get("/image-scan" + query).Do(&scans); err != nil {
+		t.Fatal(err)
+	}
+	return scans
+}
+
+func TestImageScanBlock(t *testing.T) {
+	scans := &atomic.Int32{}
+	server := mockTrivyServer(t, scans)
+
+	env := setupImageScanEnv(t, "v1", server.URL, imagescan.Policy{Mode: imagescan.ModeBlock, Threshold: "high"})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = user.trainNdbDummyFile("model-a")
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "3 vulnerabilities with severity HIGH or higher") {
+		t.Fatalf("train job should be blocked: %v", err)
+	}
+	if len(env.nomad.activeJobs) != 0 {
+		t.Fatal("no job should be started")
+	}
+
+	_, err = user.trainNdbDummyFile("model-b")
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("train job should be blocked: %v", err)
+	}
+	if scans.Load() != 1 {
+		t.Fatalf("scan results should be cached, got %d scans", scans.Load())
+	}
+
+	results := listImageScans(t, env, "")
+	if len(results) != 2 {
+		t.Fatalf("expected 2 scans, got %v", results)
+	}
+	scan := results[0]
+	if scan.Image != "registry/jobs:v1" || scan.Digest != "sha256:abc" || scan.Scanner != "trivy" || scan.Result != "blocked" ||
+		scan.Counts["critical"] != 1 || scan.Counts["high"] != 2 || scan.Counts["low"] != 1 || !strings.HasPrefix(scan.JobName, "train-ndb-") {
+		t.Fatalf("invalid scan: %+v", scan)
+	}
+
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user2.Get("/image-scan").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("image scans should be admin only: %v", err)
+	}
+}
+
+func TestImageScanWarn(t *testing.T) {
+	scans := &atomic.Int32{}
+	server := mockTrivyServer(t, scans)
+
+	env := setupImageScanEnv(t, "v1", server.URL, imagescan.Policy{Mode: imagescan.ModeWarn, Threshold: "critical"})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("model-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.nomad.activeJobs) != 1 {
+		t.Fatal("train job should be started")
+	}
+
+	results := listImageScans(t, env, "?result=warned")
+	if len(results) != 1 || results[0].JobName != "train-ndb-"+model {
+		t.Fatalf("invalid scans: %v", results)
+	}
+}
+
+func TestImageScanAllowed(t *testing.T) {
+	scans := &atomic.Int32{}
+	server := mockTrivyServer(t, scans)
+
+	env := setupImageScanEnv(t, "v2", server.URL, imagescan.Policy{Mode: imagescan.ModeBlock, Threshold: "medium"})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.trainNdbDummyFile("model-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := listImageScans(t, env, "?result=allowed")
+	if len(results) != 1 || results[0].Digest != "sha256:def" {
+		t.Fatalf("invalid scans: %v", results)
+	}
+}
+
+func TestImageScanFailure(t *testing.T) {
+	scans := &atomic.Int32{}
+	server := mockTrivyServer(t, scans)
+
+	env := setupImageScanEnv(t, "v3", server.URL, imagescan.Policy{Mode: imagescan.ModeBlock, Threshold: "critical"})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = user.trainNdbDummyFile("model-a")
+	if err == nil || !strings.Contains(err.Error(), "unable to scan image registry/jobs:v3") {
+		t.Fatalf("images that cannot be scanned should be blocked: %v", err)
+	}
+
+	results := listImageScans(t, env, "")
+	if len(results) != 1 || results[0].Result != "blocked" || !strings.Contains(results[0].Error, "status 500") {
+		t.Fatalf("invalid scans: %v", results)
+	}
+}
+
+func TestHarborScanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v2.0/projects/thirdai/repositories/platform%252Fjobs/artifacts/v1" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if user, pwd, ok := r.BasicAuth(); !ok || user != "user" || pwd != "pwd" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"digest": "sha256:abc",
+			"scan_overview": {
+				"application/vnd.security.vulnerability.report; version=1.1": {
+					"scan_status": "Success",
+					"summary": {"total": 4, "summary": {"Critical": 1, "High": 3}}
+				}
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	scanner := imagescan.NewHarborScanner(server.URL, "user", "pwd")
+
+	report, err := scanner.Scan("harbor.example.com/thirdai/platform/jobs:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Digest != "sha256:abc" || report.CountAtLeast("critical") != 1 || report.CountAtLeast("high") != 4 || report.CountAtLeast("low") != 4 {
+		t.Fatalf("invalid report: %+v", report)
+	}
+
+	if _, err := scanner.Scan("harbor.example.com/thirdai/platform/jobs:v2"); err == nil {
+		t.Fatal("scan should fail for unknown image")
+	}
+	if _, err := scanner.Scan("jobs:v1"); err == nil {
+		t.Fatal("scan should fail for image without project")
+	}
+}
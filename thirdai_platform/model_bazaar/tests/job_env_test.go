@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+)
+
+func TestJobEnv(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := services.JobEnvSettings{
+		Train:  map[string]string{"HTTPS_PROXY": "http://proxy:3128", "REQUESTS_CA_BUNDLE": "/model_bazaar/certs/ca.pem"},
+		Deploy: map[string]string{"ENABLE_FEATURE_X": "true"},
+	}
+	if err := admin.Post("/job-env").Json(settings).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var current services.JobEnvSettings
+	if err := admin.Get("/job-env").Do(&current); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(current.Train, settings.Train) || !maps.Equal(current.Deploy, settings.Deploy) {
+		t.Fatalf("invalid job env: %v", current)
+	}
+
+	err = user.Post("/job-env").Json(settings).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("job env should be admin only: %v", err)
+	}
+
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainJob := env.nomad.submitted[fmt.Sprintf("train-ndb-%v", model)].(orchestrator.TrainJob)
+	if !maps.Equal(trainJob.ExtraEnv, settings.Train) {
+		t.Fatalf("train job should have extra env, got %v", trainJob.ExtraEnv)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	deployJob := env.nomad.submitted[fmt.Sprintf("deploy-ndb-%v", model)].(orchestrator.DeployJob)
+	if !maps.Equal(deployJob.ExtraEnv, settings.Deploy) {
+		t.Fatalf("deploy job should have extra env, got %v", deployJob.ExtraEnv)
+	}
+
+	invalid := []map[string]string{
+		{"JOB_TOKEN": "abc"},
+		{"aws_access_key": "abc"},
+		{"NOMAD_ADDR": "http://localhost:4646"},
+		{"1ABC": "abc"},
+		{"MY-VAR": "abc"},
+		{"MY_VAR": "a\nb"},
+	}
+	for _, vars := range invalid {
+		err := admin.Post("/job-env").Json(services.JobEnvSettings{Deploy: vars}).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("env %v should be rejected: %v", vars, err)
+		}
+	}
+
+	if err := admin.Post("/job-env").Json(services.JobEnvSettings{Train: map[string]string{"NO_PROXY": "localhost"}}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	var replaced services.JobEnvSettings
+	if err := admin.Get("/job-env").Do(&replaced); err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced.Train) != 1 || replaced.Train["NO_PROXY"] != "localhost" || len(replaced.Deploy) != 0 {
+		t.Fatalf("job env should be replaced: %v", replaced)
+	}
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func TestUserTrainJobLimit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		UserJobLimits: services.JobLimits{MaxTrainJobs: 1},
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := user.trainNdbDummyFile("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.trainNdbDummyFile("second"); err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("train job over the user limit should be rejected, got %v", err)
+	}
+
+	// The limit is per user.
+	if _, err := other.trainNdbDummyFile("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	var limits services.UserJobLimitsResponse
+	if err := admin.Get(fmt.Sprintf("/user/%v/job-limits", user.userId)).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.MaxTrainJobs != 1 || limits.Overrides.MaxTrainJobs != nil || limits.RunningTrainJobs != 1 {
+		t.Fatalf("invalid job limits: %+v", limits)
+	}
+
+	maxTrainJobs := 2
+	if err := admin.Post(fmt.Sprintf("/user/%v/job-limits", user.userId)).Json(services.UserJobLimitsRequest{MaxTrainJobs: &maxTrainJobs}).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.MaxTrainJobs != 2 || limits.Overrides.MaxTrainJobs == nil || *limits.Overrides.MaxTrainJobs != 2 {
+		t.Fatalf("invalid job limits after override: %+v", limits)
+	}
+
+	second, err := user.trainNdbDummyFile("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.trainNdbDummyFile("third"); err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("train job over the overridden limit should be rejected, got %v", err)
+	}
+
+	// Finished jobs do not count towards the limit.
+	for _, model := range []string{first, second} {
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := user.trainNdbDummyFile("third"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.Get(fmt.Sprintf("/user/%v/job-limits", user.userId)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should view job limits, got %v", err)
+	}
+
+	negative := -1
+	if err := admin.Post(fmt.Sprintf("/user/%v/job-limits", user.userId)).Json(services.UserJobLimitsRequest{MaxTrainJobs: &negative}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("negative limits should be rejected, got %v", err)
+	}
+
+	// Clearing the overrides restores the defaults.
+	if err := admin.Post(fmt.Sprintf("/user/%v/job-limits", user.userId)).Json(services.UserJobLimitsRequest{}).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.MaxTrainJobs != 1 || limits.Overrides.MaxTrainJobs != nil {
+		t.Fatalf("invalid job limits after reset: %+v", limits)
+	}
+}
+
+func TestUserTrainJobLimitQueue(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver:  &orchestrator.LocalDriver{},
+		QueueTrainJobs: true,
+		UserJobLimits:  services.JobLimits{MaxTrainJobs: 1},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := user.trainNlpToken("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := user.trainNlpText("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := user.trainStatus(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "queued" || trainJobSubmitted(env, second) {
+		t.Fatalf("train job over the user limit should be queued: %v", status)
+	}
+
+	// The queued job is not started while the user is at their limit.
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	if status, err := user.trainStatus(second); err != nil || status.Status != "queued" {
+		t.Fatalf("queued train job should wait for the user's jobs to finish: %v %v", status, err)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, first), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	status, err = user.trainStatus(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "starting" || !trainJobSubmitted(env, second) {
+		t.Fatalf("queued train job should be started once the user is below their limit: %v", status)
+	}
+}
+
+func TestUserDeploymentLimit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		UserJobLimits: services.JobLimits{MaxDeployments: 1},
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := make([]string, 0)
+	for _, name := range []string{"first", "second"} {
+		model, err := user.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+		models = append(models, model)
+	}
+
+	if err := user.deploy(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Deploying a model that is already deployed does not count twice.
+	if err := user.deploy(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(models[1]); err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("deployment over the user limit should be rejected, got %v", err)
+	}
+
+	unlimited := 0
+	if err := admin.Post(fmt.Sprintf("/user/%v/job-limits", user.userId)).Json(services.UserJobLimitsRequest{MaxDeployments: &unlimited}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(models[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var limits services.UserJobLimitsResponse
+	if err := admin.Get(fmt.Sprintf("/user/%v/job-limits", user.userId)).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.MaxDeployments != 0 || limits.Deployments != 2 {
+		t.Fatalf("invalid job limits: %+v", limits)
+	}
+}
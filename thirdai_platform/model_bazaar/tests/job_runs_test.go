@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func listJobRuns(c client, query url.Values) ([]services.JobRunInfo, error) {
+	var runs []services.JobRunInfo
+	err := c.Get("/job-runs?" + query.Encode()).Do(&runs)
+	return runs, err
+}
+
+func TestJobRuns(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := user.trainNdbDummyFile("failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/train/update-status").Auth(getJobAuthToken(env, t, failed)).Json(map[string]string{"status": "failed", "message": "out of memory"}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobToken := getJobAuthToken(env, t, model)
+	if err := updateTrainStatus(user, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/deploy/update-status").Auth(jobToken).Json(map[string]string{"status": "complete"}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.undeploy(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listJobRuns(user, nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("job runs should be admin only: %v", err)
+	}
+
+	runs, err := listJobRuns(admin, url.Values{"model_id": {failed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Job != "train" || runs[0].Status != "failed" || runs[0].Message != "out of memory" || runs[0].EndedAt == nil {
+		t.Fatalf("invalid failed train run: %+v", runs)
+	}
+	if runs[0].JobName != fmt.Sprintf("train-ndb-%v", failed) || runs[0].Attempt != 1 || runs[0].Resources.Cores != 2 {
+		t.Fatalf("invalid failed train run: %+v", runs)
+	}
+
+	runs, err = listJobRuns(admin, url.Values{"model_id": {model}, "job": {"deploy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Attempt != 2 || runs[0].Status != "starting" || runs[0].EndedAt != nil {
+		t.Fatalf("invalid current deploy run: %+v", runs)
+	}
+	if runs[1].Attempt != 1 || runs[1].Status != "stopped" || runs[1].EndedAt == nil || runs[1].EndedAt.Before(runs[1].StartedAt) {
+		t.Fatalf("invalid stopped deploy run: %+v", runs)
+	}
+
+	runs, err = listJobRuns(admin, url.Values{"status": {"complete"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ModelId.String() != model || runs[0].Job != "train" {
+		t.Fatalf("invalid complete runs: %+v", runs)
+	}
+
+	runs, err = listJobRuns(admin, url.Values{"started_after": {time.Now().Add(time.Minute).Format(time.RFC3339)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Fatalf("no runs should start in the future: %+v", runs)
+	}
+
+	runs, err = listJobRuns(admin, url.Values{"started_before": {time.Now().Add(time.Minute).Format(time.RFC3339)}, "limit": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("expected 3 runs, got %+v", runs)
+	}
+
+	for _, query := range []url.Values{{"job": {"other"}}, {"started_after": {"yesterday"}}, {"limit": {"0"}}, {"model_id": {"abc"}}} {
+		if _, err := listJobRuns(admin, query); err == nil || !strings.Contains(err.Error(), "status 400") {
+			t.Fatalf("invalid filter %v should be rejected: %v", query, err)
+		}
+	}
+
+	// The status sync should end the run if the job disappears from the orchestrator.
+	env.nomad.Clear()
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(300 * time.Millisecond)
+
+	runs, err = listJobRuns(admin, url.Values{"model_id": {model}, "job": {"deploy"}, "limit": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != "failed" || !strings.Contains(runs[0].Message, "orchestrator") || runs[0].EndedAt == nil {
+		t.Fatalf("status sync should end the run: %+v", runs)
+	}
+}
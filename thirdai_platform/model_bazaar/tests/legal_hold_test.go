@@ -0,0 +1,307 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLegalHoldBlocksModelDeletion(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateTrainStatus(user, getJobAuthToken(env, t, model), "complete")
+
+	hold := map[string]string{"scope": "model", "model_id": model, "reason": "litigation 123"}
+
+	err = user.Post("/legal-hold").Json(hold).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("legal holds should be admin only: %v", err)
+	}
+
+	for _, invalid := range []map[string]string{
+		{"scope": "model", "reason": "no model"},
+		{"scope": "audit", "model_id": model, "reason": "audit holds are not for a model"},
+		{"scope": "team", "reason": "invalid scope"},
+		{"scope": "model", "model_id": model},
+	} {
+		err := admin.Post("/legal-hold").Json(invalid).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("legal hold %v should be invalid: %v", invalid, err)
+		}
+	}
+
+	err = admin.Post("/legal-hold").Json(map[string]string{"scope": "model", "model_id": uuid.NewString(), "reason": "missing"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("hold on a missing model should fail: %v", err)
+	}
+
+	var placed services.LegalHoldInfo
+	if err := admin.Post("/legal-hold").Json(hold).Do(&placed); err != nil {
+		t.Fatal(err)
+	}
+	if !placed.Active || placed.ModelId == nil || placed.ModelId.String() != model {
+		t.Fatalf("invalid legal hold: %+v", placed)
+	}
+
+	err = user.deleteModel(model)
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "legal hold") {
+		t.Fatalf("model under legal hold should not be deleted: %v", err)
+	}
+
+	var active []services.LegalHoldInfo
+	if err := admin.Get("/legal-hold?active=true").Do(&active); err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Id != placed.Id {
+		t.Fatalf("invalid active holds: %+v", active)
+	}
+
+	var released services.LegalHoldInfo
+	if err := admin.Post(fmt.Sprintf("/legal-hold/%v/release", placed.Id)).Do(&released); err != nil {
+		t.Fatal(err)
+	}
+	if released.Active || released.ReleasedAt == nil || released.ReleasedBy == nil {
+		t.Fatalf("hold should be released: %+v", released)
+	}
+
+	err = admin.Post(fmt.Sprintf("/legal-hold/%v/release", placed.Id)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("hold should not be released twice: %v", err)
+	}
+
+	if err := admin.Get("/legal-hold?active=true").Do(&active); err != nil || len(active) != 0 {
+		t.Fatalf("there should be no active holds %+v: %v", active, err)
+	}
+	var all []services.LegalHoldInfo
+	if err := admin.Get("/legal-hold").Do(&all); err != nil || len(all) != 1 {
+		t.Fatalf("released holds should be listed %+v: %v", all, err)
+	}
+
+	if err := user.deleteModel(model); err != nil {
+		t.Fatal(err)
+	}
+
+	var events int64
+	env.db.Model(&schema.PlatformEvent{}).Where("type IN ?", []string{schema.LegalHoldPlacedEvent, schema.LegalHoldReleasedEvent}).Count(&events)
+	if events != 2 {
+		t.Fatalf("expected 2 legal hold events, found %d", events)
+	}
+}
+
+func TestLegalHoldAuditRetention(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, AuditRetention: time.Hour})
+
+	// Each connection to an in memory sqlite db is a separate db, so the status
+	// sync and the test must share a single connection.
+	sqlDb, err := env.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.SetMaxOpenConns(1)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := admin.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelId := uuid.MustParse(model)
+
+	old := time.Now().UTC().Add(-2 * time.Hour)
+	env.db.Create(&[]schema.PlatformEvent{
+		{Type: "old.platform", Timestamp: old},
+		{Type: "old.model", ModelId: &modelId, Timestamp: old},
+	})
+	env.db.Create(&[]schema.AuditLogEntry{
+		{Timestamp: old, Action: "GET /api/v2/old", Method: "GET", Url: "/api/v2/old", Status: 200},
+		{Timestamp: old, Action: "GET /api/v2/old/{model_id}", Method: "GET", Url: "/api/v2/old/" + model, ModelId: &modelId, Status: 200},
+	})
+
+	countOldAudit := func() int64 {
+		var count int64
+		env.db.Model(&schema.AuditLogEntry{}).Where("action LIKE ?", "GET /api/v2/old%").Count(&count)
+		return count
+	}
+
+	countOld := func() int64 {
+		var count int64
+		env.db.Model(&schema.PlatformEvent{}).Where("type LIKE ?", "old.%").Count(&count)
+		return count
+	}
+
+	var auditHold services.LegalHoldInfo
+	if err := admin.Post("/legal-hold").Json(map[string]string{"scope": "audit", "reason": "litigation 123"}).Do(&auditHold); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Post("/legal-hold").Json(map[string]string{"scope": "model", "model_id": model, "reason": "litigation 123"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	time.Sleep(300 * time.Millisecond)
+	if n := countOld(); n != 2 {
+		t.Fatalf("events should not be deleted while audit data is under legal hold, found %d", n)
+	}
+	if n := countOldAudit(); n != 2 {
+		t.Fatalf("audit log entries should not be deleted while audit data is under legal hold, found %d", n)
+	}
+
+	if err := admin.Post(fmt.Sprintf("/legal-hold/%v/release", auditHold.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	var remaining []schema.PlatformEvent
+	env.db.Where("type LIKE ?", "old.%").Find(&remaining)
+	if len(remaining) != 1 || remaining[0].Type != "old.model" {
+		t.Fatalf("only the events of the held model should be kept: %+v", remaining)
+	}
+
+	var remainingAudit []schema.AuditLogEntry
+	env.db.Where("action LIKE ?", "GET /api/v2/old%").Find(&remainingAudit)
+	if len(remainingAudit) != 1 || remainingAudit[0].ModelId == nil || *remainingAudit[0].ModelId != modelId {
+		t.Fatalf("only the audit log entries of the held model should be kept: %+v", remainingAudit)
+	}
+
+	var recent int64
+	env.db.Model(&schema.PlatformEvent{}).Where("type = ?", schema.LegalHoldPlacedEvent).Count(&recent)
+	if recent != 2 {
+		t.Fatalf("events within the retention should be kept, found %d", recent)
+	}
+}
+
+func writeAuditLog(t *testing.T, times ...time.Time) string {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for i, ts := range times {
+		line, _ := json.Marshal(map[string]interface{}{"time": ts, "msg": "audit", "request": i})
+		fmt.Fprintln(file, string(line))
+	}
+	fmt.Fprintln(file, "not json")
+	return path
+}
+
+func TestAuditExportRetained(t *testing.T) {
+	store, stub := setupS3Storage(t, storage.S3Config{})
+	stub.objectLock = true
+
+	now := time.Now().UTC()
+	auditLog := writeAuditLog(t, now.Add(-3*time.Hour), now.Add(-time.Hour), now.Add(time.Hour))
+
+	env := setupTestEnvWithStorage(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, AuditLogPath: auditLog}, nil, store)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.trainNdbDummyFile("xyz"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = admin.Post("/legal-hold/audit-export").Json(map[string]interface{}{"start": now, "end": now.Add(-time.Hour)}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("start must be before end: %v", err)
+	}
+
+	var export services.AuditExportInfo
+	err = admin.Post("/legal-hold/audit-export").Json(map[string]interface{}{"start": now.Add(-2 * time.Hour), "retain_days": 30}).Do(&export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !export.Immutable || export.Events == 0 || export.AuditLogLines != 1 || len(export.Files) != 2 {
+		t.Fatalf("invalid audit export: %+v", export)
+	}
+	if d := export.RetainUntil.Sub(now); d < 29*24*time.Hour || d > 31*24*time.Hour {
+		t.Fatalf("invalid retention: %v", export.RetainUntil)
+	}
+
+	for _, name := range []string{"events.jsonl", "audit.log", "manifest.json"} {
+		key := filepath.Join(export.Path, name)
+		if until, ok := stub.retainUntil[key]; !ok || !until.Equal(export.RetainUntil.Truncate(time.Second)) {
+			t.Fatalf("%v should be written with object lock until %v, found %v", key, export.RetainUntil, until)
+		}
+	}
+
+	file, err := env.storage.Read(filepath.Join(export.Path, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var manifest services.AuditExportInfo
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ExportId != export.ExportId || manifest.Files["events.jsonl"] != export.Files["events.jsonl"] {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+
+	if err := env.storage.Delete(export.Path); err == nil {
+		t.Fatal("retained audit export should not be deleted")
+	}
+}
+
+func TestAuditExportWithoutObjectLock(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export services.AuditExportInfo
+	if err := admin.Post("/legal-hold/audit-export").Json(map[string]interface{}{}).Do(&export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Immutable || export.AuditLogLines != 0 || export.RetainUntil.Before(time.Now().AddDate(0, 0, 364)) {
+		t.Fatalf("invalid audit export: %+v", export)
+	}
+
+	file, err := env.storage.Read(filepath.Join(export.Path, "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Events != strings.Count(string(data), "\n") {
+		t.Fatalf("invalid exported events: %v", string(data))
+	}
+}
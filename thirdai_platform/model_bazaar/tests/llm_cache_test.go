@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+// llmCacheJobStub serves the admin endpoints of the llm-cache job.
+type llmCacheJobStub struct {
+	stats     services.LlmCacheStats
+	evictions []services.LlmCacheEvictRequest
+	auth      []string
+}
+
+func (s *llmCacheJobStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+
+	switch r.URL.Path {
+	case "/cache/admin/stats":
+		_ = json.NewEncoder(w).Encode(s.stats)
+	case "/cache/admin/evict":
+		var req services.LlmCacheEvictRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		s.evictions = append(s.evictions, req)
+		_ = json.NewEncoder(w).Encode(services.LlmCacheEvictResponse{Evicted: 3})
+	case "/cache/admin/capacity":
+		var req services.LlmCacheCapacityRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		s.stats.MaxEntries = *req.MaxEntries
+		_ = json.NewEncoder(w).Encode(s.stats)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func TestLlmCacheAdmin(t *testing.T) {
+	modelId := uuid.New()
+	job := &llmCacheJobStub{stats: services.LlmCacheStats{
+		LlmCacheUsage: services.LlmCacheUsage{Entries: 10, SizeBytes: 2048, Hits: 3, Misses: 1},
+		MaxEntries:    100,
+		Models: []services.LlmCacheModelUsage{
+			{ModelId: modelId, LlmCacheUsage: services.LlmCacheUsage{Entries: 10, SizeBytes: 2048, Hits: 3, Misses: 1}},
+		},
+	}}
+	server := httptest.NewServer(job)
+	defer server.Close()
+
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, ModelBazaarEndpoint: server.URL})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Get("/llm-cache/stats").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("llm cache endpoints should be admin only: %v", err)
+	}
+
+	var stats services.LlmCacheStats
+	if err := admin.Get("/llm-cache/stats").Do(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 10 || stats.HitRate != 0.75 || len(stats.Models) != 1 || stats.Models[0].ModelId != modelId || stats.Models[0].HitRate != 0.75 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+	if len(job.auth) != 1 || !strings.HasPrefix(job.auth[0], "Bearer ") {
+		t.Fatalf("the admin's credentials should be forwarded to the job: %v", job.auth)
+	}
+
+	var evicted services.LlmCacheEvictResponse
+	if err := admin.Post("/llm-cache/evict").Json(map[string]interface{}{"model_id": modelId, "older_than_seconds": 60}).Do(&evicted); err != nil {
+		t.Fatal(err)
+	}
+	if evicted.Evicted != 3 || len(job.evictions) != 1 || *job.evictions[0].ModelId != modelId || job.evictions[0].OlderThanSeconds != 60 {
+		t.Fatalf("invalid eviction: %+v %+v", evicted, job.evictions)
+	}
+
+	for _, invalid := range []map[string]interface{}{{}, {"older_than_seconds": -1}} {
+		err := admin.Post("/llm-cache/evict").Json(invalid).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("eviction %v should be rejected: %v", invalid, err)
+		}
+	}
+	if len(job.evictions) != 1 {
+		t.Fatal("invalid evictions should not be sent to the job")
+	}
+
+	if err := admin.Post("/llm-cache/capacity").Json(map[string]int{"max_entries": 500}).Do(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.MaxEntries != 500 || stats.HitRate != 0.75 {
+		t.Fatalf("invalid stats after setting capacity: %+v", stats)
+	}
+
+	for _, invalid := range []map[string]interface{}{{}, {"max_entries": -1}} {
+		err := admin.Post("/llm-cache/capacity").Json(invalid).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("capacity %v should be rejected: %v", invalid, err)
+		}
+	}
+}
+
+func TestLlmCacheAdminJobUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL
+	server.Close()
+
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, ModelBazaarEndpoint: endpoint})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = admin.Get("/llm-cache/stats").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 502") {
+		t.Fatalf("stats should fail if the job is unavailable: %v", err)
+	}
+}
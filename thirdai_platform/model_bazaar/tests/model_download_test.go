@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	platformclient "thirdai_platform/client"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+func TestModelDownloadRanges(t *testing.T) {
+	for _, compression := range []string{"", services.ArtifactCompressionZstd} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			testModelDownloadRanges(t, compression)
+		})
+	}
+}
+
+func testModelDownloadRanges(t *testing.T, compression string) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, ArtifactCompression: compression})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Random data does not compress, so the archive has several parts.
+	modelData := make([]byte, 3*1024*1024+100)
+	if _, err := rand.Read(modelData); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.storage.Write(filepath.Join("models", model, "model", "model.ndb"), bytes.NewReader(modelData)); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/model/%v/download", model), nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", user.authToken))
+		if compression != "" {
+			req.Header.Add("Accept-Encoding", "zstd")
+		}
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+		w := httptest.NewRecorder()
+		env.api.ServeHTTP(w, req)
+		return w
+	}
+
+	var manifest services.DownloadManifest
+	if err := user.Get(fmt.Sprintf("/model/%v/download/manifest?part_size=%d", model, 1024*1024)).Do(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Parts) != 4 || manifest.Parts[3].Offset+manifest.Parts[3].Length != manifest.Size {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+	if (compression == "") != (manifest.ContentEncoding == "") {
+		t.Fatalf("invalid content encoding in manifest: %+v", manifest)
+	}
+
+	full := download(nil)
+	if full.Code != http.StatusOK {
+		t.Fatalf("download failed with status %d: %v", full.Code, full.Body.String())
+	}
+	checksum := sha256.Sum256(full.Body.Bytes())
+	if hex.EncodeToString(checksum[:]) != manifest.Sha256 || int64(full.Body.Len()) != manifest.Size {
+		t.Fatal("download does not match the checksum and size in the manifest")
+	}
+	if full.Header().Get("ETag") != manifest.ETag || full.Header().Get("Content-Length") != fmt.Sprint(manifest.Size) || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("invalid download headers: %v", full.Header())
+	}
+
+	part := manifest.Parts[1]
+	partial := download(map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", part.Offset, part.Offset+part.Length-1), "If-Range": manifest.ETag})
+	if partial.Code != http.StatusPartialContent || !bytes.Equal(partial.Body.Bytes(), full.Body.Bytes()[part.Offset:part.Offset+part.Length]) {
+		t.Fatalf("invalid range response with status %d and %d bytes", partial.Code, partial.Body.Len())
+	}
+
+	stale := download(map[string]string{"Range": "bytes=0-99", "If-Range": `"other"`})
+	if stale.Code != http.StatusOK || int64(stale.Body.Len()) != manifest.Size {
+		t.Fatalf("range with a stale etag should return the whole archive, got status %d", stale.Code)
+	}
+
+	unsatisfiable := download(map[string]string{"Range": fmt.Sprintf("bytes=%d-", manifest.Size+10)})
+	if unsatisfiable.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected 416 for a range past the end of the archive, got %d", unsatisfiable.Code)
+	}
+
+	if err := user.Get(fmt.Sprintf("/model/%v/download/manifest?part_size=10", model)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("small part sizes should be rejected: %v", err)
+	}
+
+	// The client downloads the parts in parallel, and only downloads the
+	// missing parts when a download is resumed.
+	server := httptest.NewServer(http.StripPrefix("/api/v2", env.api))
+	defer server.Close()
+	modelClient := platformclient.NewModelClient(server.URL, user.authToken, uuid.MustParse(model))
+
+	dst := filepath.Join(t.TempDir(), "model.zip")
+	if err := os.WriteFile(dst+".progress", []byte(fmt.Sprintf(`{"etag":%q,"part_size":%d,"completed":[0]}`, manifest.ETag, 1024*1024)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := modelClient.DownloadParallel(dst, 3, 1024*1024); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("part recorded as complete should not be downloaded again: %v", err)
+	}
+	if err := modelClient.DownloadParallel(dst, 3, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readZipEntry(t, archive, "model.ndb"), modelData) {
+		t.Fatal("model data does not match after parallel download")
+	}
+	for _, leftover := range []string{dst + ".partial", dst + ".progress"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("%v should be removed after the download: %v", leftover, err)
+		}
+	}
+
+	// The archive is recreated once the model changes.
+	if err := env.storage.Write(filepath.Join("models", model, "model", "extra.txt"), strings.NewReader("extra")); err != nil {
+		t.Fatal(err)
+	}
+	var updated services.DownloadManifest
+	if err := user.Get(fmt.Sprintf("/model/%v/download/manifest", model)).Do(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Sha256 == manifest.Sha256 || len(updated.Parts) != 1 {
+		t.Fatalf("archive should be recreated after the model changes: %+v", updated)
+	}
+}
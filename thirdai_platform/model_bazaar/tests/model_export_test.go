@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+func (c *client) exportModels(modelIds ...string) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{"model_ids": modelIds})
+	if err != nil {
+		return nil, err
+	}
+	req := httptest.NewRequest("POST", "/model/export", bytes.NewReader(body))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.authToken))
+	w := httptest.NewRecorder()
+	c.api.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("post /model/export failed with status %d and res '%v'", w.Code, w.Body.String())
+	}
+	return w.Body.Bytes(), nil
+}
+
+func (c *client) importModels(archive []byte) ([]services.ImportedModel, error) {
+	var res services.ImportResponse
+	err := c.Post("/model/import").Header("Content-Type", "application/zip").Body(bytes.NewReader(archive)).Do(&res)
+	return res.Models, err
+}
+
+func TestModelExportImport(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := user.trainNdbDummyFile("ndb-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlp, err := user.trainNlpToken("nlp-token-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []string{ndb, nlp} {
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ndbData := randomBytes(1000)
+	if err := env.storage.Write(filepath.Join("models", ndb, "model", "model.ndb", "index"), bytes.NewReader(ndbData)); err != nil {
+		t.Fatal(err)
+	}
+
+	es, err := user.createEnterpriseSearch("search", ndb, nlp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := user.exportModels(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Open(fmt.Sprintf("models/%v/model.ndb/index", ndb)); err != nil {
+		t.Fatalf("export should contain the artifacts of dependencies: %v", err)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.exportModels(es); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users without access to a model should not be able to export it: %v", err)
+	}
+
+	imported, err := other.importModels(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 3 || imported[2].SourceModelId.String() != es || imported[2].Type != schema.EnterpriseSearch {
+		t.Fatalf("dependencies should be imported before the models that use them: %+v", imported)
+	}
+
+	newIds := make(map[string]string)
+	for _, model := range imported {
+		if model.ModelId == model.SourceModelId {
+			t.Fatalf("imported models should have new ids: %+v", model)
+		}
+		newIds[model.SourceModelId.String()] = model.ModelId.String()
+	}
+
+	info, err := other.modelInfo(newIds[es])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelName != "search" || info.TrainStatus != schema.Complete || info.Username != "xyz" {
+		t.Fatalf("invalid imported model: %+v", info)
+	}
+	if info.Attributes["retrieval_id"] != newIds[ndb] || info.Attributes["guardrail_id"] != newIds[nlp] {
+		t.Fatalf("workflow attributes should reference the imported models: %v", info.Attributes)
+	}
+	if len(info.Dependencies) != 2 {
+		t.Fatalf("invalid dependencies: %+v", info.Dependencies)
+	}
+	for _, dep := range info.Dependencies {
+		if dep.ModelId.String() != newIds[ndb] && dep.ModelId.String() != newIds[nlp] {
+			t.Fatalf("dependencies should be the imported models: %+v", info.Dependencies)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(env.storage.Location(), "models", newIds[ndb], "model", "model.ndb", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ndbData) {
+		t.Fatal("model artifacts do not match after import")
+	}
+
+	// The models already exist for the user.
+	if _, err := other.importModels(archive); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("import should fail if a model with the same name exists: %v", err)
+	}
+	models, err := other.listModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 3 {
+		t.Fatalf("failed imports should not create models: %+v", models)
+	}
+}
+
+func TestModelExportImportValidation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.exportModels(model); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("models that are not trained should not be exported: %v", err)
+	}
+
+	if _, err := user.importModels([]byte("not a zip")); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid archives should be rejected: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	manifest, err := archive.Create("manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(manifest, `{"version": 1, "models": [{"model_id": "%v", "model_name": "abc", "type": "ndb"}]}`, model)
+	file, err := archive.Create(fmt.Sprintf("models/%v/../../../escape", model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("abc"))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := user.importModels(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("archive paths outside of the model should be rejected: %v", err)
+	}
+}
+
+// importArchive creates an import archive with the given models in the manifest
+// and the given artifacts.
+func importArchive(t *testing.T, models []map[string]interface{}, files map[string]string) []byte {
+	manifest, err := json.Marshal(map[string]interface{}{"version": 1, "models": models})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	files["manifest.json"] = string(manifest)
+	for name, data := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestModelImportValidatesModels(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		StorageQuotas: map[string]int64{"ndb": 1000},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := other.trainNdbDummyFile("private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndbId, esId := uuid.New(), uuid.New()
+	ndbModel := map[string]interface{}{"model_id": ndbId, "model_name": "ndb", "type": "ndb"}
+
+	cases := []struct {
+		name   string
+		models []map[string]interface{}
+		files  map[string]string
+		status string
+	}{
+		{
+			name:   "unknown type",
+			models: []map[string]interface{}{{"model_id": ndbId, "model_name": "ndb", "type": "uploading"}},
+			status: "status 422",
+		},
+		{
+			name: "reference outside the archive",
+			models: []map[string]interface{}{ndbModel, {
+				"model_id": esId, "model_name": "search", "type": "enterprise-search",
+				"attributes": map[string]string{"retrieval_id": private}, "dependencies": []uuid.UUID{ndbId},
+			}},
+			status: "status 422",
+		},
+		{
+			name:   "invalid external model",
+			models: []map[string]interface{}{{"model_id": ndbId, "model_name": "onnx", "type": "external", "attributes": onnxModelAttrs()}},
+			status: "status 422",
+		},
+		{
+			name:   "storage quota",
+			models: []map[string]interface{}{ndbModel},
+			files:  map[string]string{fmt.Sprintf("models/%v/model.ndb", ndbId): string(randomBytes(2000))},
+			status: "status 413",
+		},
+	}
+
+	for _, c := range cases {
+		if c.files == nil {
+			c.files = map[string]string{}
+		}
+		if _, err := user.importModels(importArchive(t, c.models, c.files)); err == nil || !strings.Contains(err.Error(), c.status) {
+			t.Fatalf("import with %v should fail with %v: %v", c.name, c.status, err)
+		}
+	}
+
+	models, err := user.listModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 0 {
+		t.Fatalf("invalid imports should not create models: %+v", models)
+	}
+
+	if _, err := user.importModels(importArchive(t, []map[string]interface{}{ndbModel}, map[string]string{
+		fmt.Sprintf("models/%v/model.ndb", ndbId): "small model",
+	})); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func TestModelGovernance(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approver, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	team, err := admin.createTeam("governance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []client{owner, approver} {
+		if err := admin.addUserToTeam(team, user.userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := admin.addTeamAdmin(team, approver.userId); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := owner.trainNdbDummyFile("governed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(owner, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.updateAccess(model, schema.Protected, &team); err != nil {
+		t.Fatal(err)
+	}
+
+	governance := func(c client) services.ModelGovernanceInfo {
+		var info services.ModelGovernanceInfo
+		if err := c.Get(fmt.Sprintf("/model/%v/governance", model)).Do(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	update := func(c client, body map[string]interface{}) error {
+		return c.Post(fmt.Sprintf("/model/%v/governance", model)).Json(body).Do(nil)
+	}
+	deployToProd := func() error {
+		return owner.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]string{"environment": services.ProductionEnvironment}).Do(nil)
+	}
+
+	if info := governance(owner); info.RiskTier != "" || info.ApprovedForProduction || info.CanApprove {
+		t.Fatalf("models should not have governance metadata by default: %+v", info)
+	}
+	if info := governance(approver); !info.CanApprove {
+		t.Fatal("team admins should be able to approve models of the team")
+	}
+
+	if err := update(owner, map[string]interface{}{"risk_tier": "extreme"}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid risk tiers should be rejected: %v", err)
+	}
+	if err := update(approver, map[string]interface{}{"approved_for_production": true}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("approval should require a risk tier: %v", err)
+	}
+	if err := update(owner, map[string]interface{}{"risk_tier": schema.RiskTierHigh, "approved_for_production": true}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("owners should not be able to approve models: %v", err)
+	}
+	if err := update(owner, map[string]interface{}{"risk_tier": schema.RiskTierHigh}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deployToProd(); err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "model_not_approved") {
+		t.Fatalf("unapproved models should not be deployed to prod: %v", err)
+	}
+
+	reviewDate := time.Now().UTC().Add(90 * 24 * time.Hour)
+	if err := update(approver, map[string]interface{}{"risk_tier": schema.RiskTierHigh, "approved_for_production": true, "review_date": reviewDate}); err != nil {
+		t.Fatal(err)
+	}
+	info := governance(owner)
+	if !info.ApprovedForProduction || info.Approver != "xyz" || info.ApprovedAt == nil || info.ReviewDate == nil || info.ReviewOverdue {
+		t.Fatalf("invalid governance metadata after approval: %+v", info)
+	}
+
+	if err := update(owner, map[string]interface{}{"risk_tier": schema.RiskTierLow}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("owners should not be able to edit approved models: %v", err)
+	}
+
+	// The approval lapses once the review date has passed.
+	err = env.db.Model(&schema.ModelGovernance{}).Where("model_id = ?", model).Update("review_date", time.Now().UTC().Add(-time.Hour)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := governance(owner); !info.ReviewOverdue {
+		t.Fatalf("review should be overdue: %+v", info)
+	}
+	if err := deployToProd(); err == nil || !strings.Contains(err.Error(), "model_not_approved") {
+		t.Fatalf("models past their review date should not be deployed to prod: %v", err)
+	}
+
+	if err := update(approver, map[string]interface{}{"risk_tier": schema.RiskTierHigh, "approved_for_production": true, "review_date": reviewDate}); err != nil {
+		t.Fatal(err)
+	}
+	if err := deployToProd(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := admin.listEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := slices.DeleteFunc(eventTypes(events.Events), func(e string) bool { return e != schema.ModelGovernanceEvent })
+	if len(types) != 3 {
+		t.Fatalf("governance updates should be recorded as events: %v", eventTypes(events.Events))
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+
+	"github.com/google/uuid"
+)
+
+func lineageNames(node *services.LineageNode) string {
+	children := make([]string, 0, len(node.Children))
+	for _, child := range node.Children {
+		children = append(children, lineageNames(child))
+	}
+	if len(children) == 0 {
+		return node.ModelName
+	}
+	return fmt.Sprintf("%v(%v)", node.ModelName, strings.Join(children, ","))
+}
+
+// createDeploymentLogs creates the directories for the insertion and deletion
+// logs of an ndb deployment, which are read to retrain the model.
+func createDeploymentLogs(t *testing.T, env *testEnv, model string) {
+	for _, dir := range []string{"insertions", "deletions"} {
+		path := filepath.Join(storage.ModelPath(uuid.MustParse(model)), "deployments/data", dir, "logs.txt")
+		if err := env.storage.Write(path, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestModelLineage(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := user.trainNdbDummyFile("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, v1), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	createDeploymentLogs(t, env, v1)
+
+	v2, err := user.retrainNdb("v2", v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, v2), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	createDeploymentLogs(t, env, v2)
+
+	branch, err := user.retrainNdb("branch", v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, branch), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest version only includes models that completed training.
+	v3, err := user.retrainNdb("v3", v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lineage services.LineageResponse
+	if err := user.Get(fmt.Sprintf("/model/%v/lineage", v2)).Do(&lineage); err != nil {
+		t.Fatal(err)
+	}
+	if names := lineageNames(lineage.Root); names != "v1(v2(v3),branch)" {
+		t.Fatalf("invalid lineage %v", names)
+	}
+	if lineage.Latest == nil || lineage.Latest.ModelId.String() != v2 {
+		t.Fatalf("latest version of v2 should be v2: %+v", lineage.Latest)
+	}
+	if lineage.Root.TrainStatus != "complete" || lineage.Root.Children[0].Children[0].TrainStatus != "starting" {
+		t.Fatalf("lineage should include train statuses: %+v", lineage.Root)
+	}
+
+	// The alias resolves to the latest completed version in the model's tree.
+	var info services.ModelInfo
+	if err := user.Get("/model/v1:latest").Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelId.String() != branch {
+		t.Fatalf("v1:latest should resolve to the most recent completed version, got %v", info.ModelName)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, v3), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get("/model/v1:latest").Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ModelId.String() != v3 {
+		t.Fatalf("v1:latest should resolve to v3 once it completes, got %v", info.ModelName)
+	}
+
+	if err := user.Post("/deploy/v2:latest").Json(map[string]interface{}{}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	status, err := user.deployStatus(v3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "starting" {
+		t.Fatalf("deploying v2:latest should deploy v3: %v", status)
+	}
+
+	if err := user.Get("/model/missing:latest").Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("alias for a missing model should return 404, got %v", err)
+	}
+
+	// Aliases only refer to the user's own models, and other users cannot see
+	// the lineage of private models.
+	if err := other.Get("/model/v1:latest").Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("alias for another user's model should return 404, got %v", err)
+	}
+	if err := other.Get(fmt.Sprintf("/model/%v/lineage", v1)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("lineage of a private model should return 403, got %v", err)
+	}
+
+	// Models the user cannot read are left out of the lineage.
+	if err := user.updateAccess(v1, "public", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.updateAccess(v2, "public", nil); err != nil {
+		t.Fatal(err)
+	}
+	lineage = services.LineageResponse{}
+	if err := other.Get(fmt.Sprintf("/model/%v/lineage", v2)).Do(&lineage); err != nil {
+		t.Fatal(err)
+	}
+	if names := lineageNames(lineage.Root); names != "v1(v2)" {
+		t.Fatalf("invalid lineage for other user %v", names)
+	}
+	if lineage.Latest == nil || lineage.Latest.ModelId.String() != v2 {
+		t.Fatalf("latest version should only include accessible models: %+v", lineage.Latest)
+	}
+}
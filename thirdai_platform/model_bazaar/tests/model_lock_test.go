@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+)
+
+func TestModelLock(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userInfo, err := user.userInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	team, err := admin.createTeam("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.addUserToTeam(team, userInfo.Id.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.updateAccess(model, schema.Protected, &team); err != nil {
+		t.Fatal(err)
+	}
+
+	err = other.Post(fmt.Sprintf("/model/%v/lock", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only owners and admins can lock models: %v", err)
+	}
+
+	if err := user.Post(fmt.Sprintf("/model/%v/lock", model)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := user.modelInfo(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Locked {
+		t.Fatal("model should be locked")
+	}
+
+	checkLocked := func(err error) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "locked") {
+			t.Fatalf("action should be blocked by the lock: %v", err)
+		}
+	}
+
+	checkLocked(user.deleteModel(model))
+	checkLocked(admin.deleteModel(model))
+	checkLocked(user.undeploy(model))
+	checkLocked(user.updateAccess(model, schema.Private, nil))
+	checkLocked(admin.deleteTeam(team))
+
+	// Access can still be increased.
+	if err := user.updateAccess(model, schema.Public, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(user.updateAccess(model, schema.Protected, &team))
+
+	if err := admin.Post(fmt.Sprintf("/model/%v/unlock", model)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.undeploy(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.deleteModel(model); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := admin.listEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := eventTypes(events.Events)
+	if !slices.Contains(types, schema.ModelLockedEvent) || !slices.Contains(types, schema.ModelUnlockedEvent) {
+		t.Fatalf("lock changes should be recorded as events: %v", types)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"testing"
 	"thirdai_platform/model_bazaar/schema"
 	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
 )
 
 func TestModelInfo(t *testing.T) {
@@ -184,6 +186,115 @@ func TestProtectedModelPermissions(t *testing.T) {
 	checkPermissions(user3, t, model, false, false, false)
 }
 
+func TestBatchModelPermissions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	team, err := admin.createTeam("green")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamAdmin, err := env.newUser("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{owner.userId, member.userId, teamAdmin.userId} {
+		if err := admin.addUserToTeam(team, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := admin.addTeamAdmin(team, teamAdmin.userId); err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := owner.trainNdbDummyFile("private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := owner.trainNdbDummyFile("public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.updateAccess(public, schema.Public, nil); err != nil {
+		t.Fatal(err)
+	}
+	protected, err := owner.trainNdbDummyFile("protected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.updateAccess(protected, schema.Protected, &team); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.updateDefaultPermission(protected, schema.WritePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	models := []string{private, public, protected}
+	missing := uuid.New().String()
+
+	// The batch permissions should match the permissions for each model.
+	for _, user := range []client{admin, owner, member, teamAdmin, other} {
+		var res services.BatchPermissionsResponse
+		err := user.Post("/model/permissions/batch").Json(map[string]interface{}{"model_ids": append(models, missing)}).Do(&res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.NotFound) != 1 || res.NotFound[0].String() != missing || len(res.Permissions) != len(models) {
+			t.Fatalf("invalid batch permissions: %+v", res)
+		}
+
+		for _, model := range models {
+			expected, err := user.modelPermissions(model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			perm := res.Permissions[uuid.MustParse(model)]
+			if perm.Read != expected.Read || perm.Write != expected.Write || perm.Owner != expected.Owner || res.Admin != expected.Admin {
+				t.Fatalf("batch permissions %+v do not match permissions %+v for model %v", perm, expected, model)
+			}
+		}
+	}
+
+	var res services.BatchPermissionsResponse
+	if err := other.Post("/model/permissions/batch").Json(map[string]interface{}{"model_ids": models}).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if p := res.Permissions[uuid.MustParse(private)]; p.Read || p.Write || p.Owner {
+		t.Fatalf("user should not have access to private model: %+v", p)
+	}
+	if p := res.Permissions[uuid.MustParse(protected)]; p.Read {
+		t.Fatalf("user outside team should not have access to protected model: %+v", p)
+	}
+
+	tooMany := make([]string, 1001)
+	for i := range tooMany {
+		tooMany[i] = uuid.New().String()
+	}
+	err = other.Post("/model/permissions/batch").Json(map[string]interface{}{"model_ids": tooMany}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("too many model ids should be rejected: %v", err)
+	}
+}
+
 func TestListModels(t *testing.T) {
 	env := setupTestEnv(t)
 
@@ -0,0 +1,349 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func modelArchive(t *testing.T, env *testEnv, user client) []byte {
+	model, err := user.trainNdbDummyFile("source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.storage.Write(filepath.Join("models", model, "model", "model.ndb"), bytes.NewReader(randomBytes(20000))); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := user.downloadModel(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func splitChunks(data []byte, n int) [][]byte {
+	size := (len(data) + n - 1) / n
+	chunks := [][]byte{}
+	for i := 0; i < len(data); i += size {
+		chunks = append(chunks, data[i:min(i+size, len(data))])
+	}
+	return chunks
+}
+
+func sendChunk(c client, token string, idx int, chunk []byte, checksum string) error {
+	req := c.Post(fmt.Sprintf("/model/upload/%d", idx)).Auth(token).Body(bytes.NewReader(chunk))
+	if checksum != "" {
+		req = req.Header("X-Chunk-Sha256", checksum)
+	}
+	return req.Do(nil)
+}
+
+func uploadStatus(c client, token string) (services.UploadStatusResponse, error) {
+	var res services.UploadStatusResponse
+	err := c.Get("/model/upload/status").Auth(token).Do(&res)
+	return res, err
+}
+
+func TestResumableUpload(t *testing.T) {
+	env := setupTestEnv(t)
+
+	// Each connection to an in memory sqlite db is a separate db, so the
+	// parallel requests must share a single connection.
+	sqlDb, err := env.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.SetMaxOpenConns(1)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := modelArchive(t, env, user)
+	chunks := splitChunks(data, 4)
+
+	var start map[string]string
+	err = user.Post("/model/upload").Json(map[string]interface{}{"model_name": "uploaded", "total_chunks": 4, "sha256": sha256Hex(data)}).Do(&start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := start["token"]
+
+	// Chunks can be sent in parallel and in any order.
+	wg := sync.WaitGroup{}
+	errs := make([]error, 4)
+	for _, idx := range []int{3, 0, 2} {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			errs[idx] = sendChunk(user, token, idx, chunks[idx], sha256Hex(chunks[idx]))
+		}(idx)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = sendChunk(user, token, 1, chunks[1], sha256Hex([]byte("other data")))
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("chunk with invalid checksum should be rejected: %v", err)
+	}
+	err = sendChunk(user, token, 4, chunks[0], "")
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("chunk index should be checked against total chunks: %v", err)
+	}
+
+	status, err := uploadStatus(user, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TotalChunks != 4 || !slices.Equal(status.Missing, []int{1}) || len(status.Received) != 3 {
+		t.Fatalf("invalid upload status: %+v", status)
+	}
+	if status.Received[1].ChunkIdx != 2 || status.Received[1].Size != int64(len(chunks[2])) || status.Received[1].Sha256 != sha256Hex(chunks[2]) {
+		t.Fatalf("invalid chunk info: %+v", status.Received[1])
+	}
+
+	err = user.Post("/model/upload/commit").Auth(token).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "[1]") {
+		t.Fatalf("commit should fail when chunks are missing: %v", err)
+	}
+
+	// The token can be replaced if it expires before the upload is finished.
+	var resumed map[string]string
+	if err := user.Post("/model/upload/resume").Json(map[string]string{"model_id": status.ModelId.String()}).Do(&resumed); err != nil {
+		t.Fatal(err)
+	}
+	token = resumed["token"]
+
+	// A chunk that is re-sent replaces the previous copy.
+	if err := sendChunk(user, token, 1, []byte("wrong data"), ""); err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/model/upload/commit").Auth(token).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("commit should fail when the checksum of the data is wrong: %v", err)
+	}
+	if err := sendChunk(user, token, 1, chunks[1], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var res map[string]string
+	if err := user.Post("/model/upload/commit").Auth(token).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res["model_id"] != status.ModelId.String() {
+		t.Fatalf("invalid commit response: %v", res)
+	}
+
+	info, err := user.modelInfo(res["model_id"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "ndb" || info.TrainStatus != "complete" {
+		t.Fatalf("invalid model after upload: %+v", info)
+	}
+
+	if _, err := uploadStatus(user, token); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("upload should no longer be in progress: %v", err)
+	}
+	if err := sendChunk(user, token, 0, chunks[0], ""); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("chunks cannot be sent after commit: %v", err)
+	}
+	if exists, err := env.storage.Exists(filepath.Join("models", res["model_id"], "chunks")); err != nil || exists {
+		t.Fatalf("chunks should be deleted after commit: %v", err)
+	}
+}
+
+func TestUploadChecksumVerification(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := modelArchive(t, env, user)
+	chunks := splitChunks(data, 2)
+
+	var start map[string]string
+	if err := user.Post("/model/upload").Json(map[string]interface{}{"model_name": "uploaded", "sha256": sha256Hex([]byte("x"))}).Do(&start); err != nil {
+		t.Fatal(err)
+	}
+	token := start["token"]
+
+	for idx, chunk := range chunks {
+		if err := sendChunk(user, token, idx, chunk, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := uploadStatus(user, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Missing) != 0 || len(status.Received) != 2 {
+		t.Fatalf("invalid upload status: %+v", status)
+	}
+
+	err = other.Post("/model/upload/resume").Json(map[string]string{"model_id": status.ModelId.String()}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the owner should be able to resume the upload: %v", err)
+	}
+
+	// Chunks are checked against the checksum from when they were received.
+	chunkPath := filepath.Join("models", status.ModelId.String(), "chunks", "0")
+	if err := env.storage.Write(chunkPath, bytes.NewReader(append([]byte{0}, chunks[0][1:]...))); err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/model/upload/commit").Auth(token).Json(map[string]interface{}{"sha256": sha256Hex(data)}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "chunk 0") {
+		t.Fatalf("commit should fail when a chunk is corrupted: %v", err)
+	}
+	if err := sendChunk(user, token, 0, chunks[0], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post("/model/upload/commit").Auth(token).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("commit should use the checksum from the start of the upload: %v", err)
+	}
+
+	// The checksum given on commit overrides the one from the start of the upload.
+	if err := user.Post("/model/upload/commit").Auth(token).Json(map[string]interface{}{"total_chunks": 2, "sha256": sha256Hex(data)}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post("/model/upload").Json(map[string]interface{}{"model_name": "invalid", "sha256": "abc"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("invalid checksum should be rejected: %v", err)
+	}
+}
+
+func listUploads(c client) ([]services.UploadSessionInfo, error) {
+	var res []services.UploadSessionInfo
+	err := c.Get("/model/upload/list").Do(&res)
+	return res, err
+}
+
+func TestUploadListAndAbort(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var start map[string]string
+	if err := user.Post("/model/upload").Json(map[string]interface{}{"model_name": "stalled", "total_chunks": 3}).Do(&start); err != nil {
+		t.Fatal(err)
+	}
+	token := start["token"]
+	chunks := [][]byte{randomBytes(100), randomBytes(50)}
+	for idx, chunk := range chunks {
+		if err := sendChunk(user, token, idx, chunk, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := other.Post("/model/upload").Json(map[string]interface{}{"model_name": "other"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads, err := listUploads(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("users should only see their own uploads: %+v", uploads)
+	}
+	upload := uploads[0]
+	if upload.ModelName != "stalled" || upload.Username != "abc" || upload.TotalChunks != 3 || upload.ChunksReceived != 2 || upload.BytesReceived != 150 {
+		t.Fatalf("invalid upload info: %+v", upload)
+	}
+	if upload.LastChunkAt == nil || upload.TokenExpiresAt == nil || upload.TokenExpired || time.Until(*upload.TokenExpiresAt) < 9*time.Minute {
+		t.Fatalf("invalid upload times: %+v", upload)
+	}
+
+	if uploads, err := listUploads(admin); err != nil || len(uploads) != 2 {
+		t.Fatalf("admin should see all uploads %+v: %v", uploads, err)
+	}
+
+	err = other.Post("/model/upload/abort").Json(map[string]string{"model_id": upload.ModelId.String()}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("other users should not be able to abort the upload: %v", err)
+	}
+
+	if err := user.Post("/model/upload/abort").Json(map[string]string{"model_id": upload.ModelId.String()}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if uploads, err := listUploads(user); err != nil || len(uploads) != 0 {
+		t.Fatalf("aborted upload should not be listed %+v: %v", uploads, err)
+	}
+	if _, err := user.modelInfo(upload.ModelId.String()); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("model should be deleted when the upload is aborted: %v", err)
+	}
+	if exists, err := env.storage.Exists(filepath.Join("models", upload.ModelId.String())); err != nil || exists {
+		t.Fatalf("chunks should be deleted when the upload is aborted: %v", err)
+	}
+	if err := sendChunk(user, token, 2, randomBytes(10), ""); err == nil {
+		t.Fatal("chunks cannot be sent after the upload is aborted")
+	}
+
+	err = user.Post("/model/upload/abort").Json(map[string]string{"model_id": upload.ModelId.String()}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("aborted upload should not be found: %v", err)
+	}
+
+	// The model name can be reused after the upload is aborted.
+	if err := user.Post("/model/upload").Json(map[string]interface{}{"model_name": "stalled"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Admins can abort uploads of other users.
+	uploads, err = listUploads(other)
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("invalid uploads %+v: %v", uploads, err)
+	}
+	if err := admin.Post("/model/upload/abort").Json(map[string]string{"model_id": uploads[0].ModelId.String()}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"thirdai_platform/model_bazaar/config"
 	"thirdai_platform/model_bazaar/services"
 
 	"github.com/google/uuid"
@@ -35,13 +36,13 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}
 
 		uploadID := uploadFunc(non_csv_file)
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
 		if err == nil {
-			t.Fatal("Only CSV file should've been supported")
+			t.Fatal("Only csv, parquet, and jsonl files should've been supported")
 		}
-		if !strings.Contains(err.Error(), "only CSV file is supported") {
+		if !strings.Contains(err.Error(), "only csv, parquet, and jsonl files are supported") {
 			t.Fatal(err)
 		}
 	}
@@ -53,7 +54,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}{
 			{"textFile1.csv", "text,target\nNormal text,label1\nDifferent text,label2"},
 		}
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		uploadID := uploadFunc(textFile1)
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
@@ -72,7 +73,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}{
 			{"textFile2.csv", "text,labels\nNormal text,label1\nDifferent text,label2,extra-entry"},
 		}
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		uploadID := uploadFunc(textFile2)
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
@@ -91,7 +92,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}{
 			{"tokenFile2.csv", "source,target\nTexas is the address,LOCATION O O O\nHe saw Dr Liam yesterday,O O O NAME O O"},
 		}
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		uploadID := uploadFunc(tokenFile2)
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
@@ -110,7 +111,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}{
 			{"correctTextFile.csv", "text,labels\nNormal text,label1\nDifferent text,label2"},
 		}
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		uploadID := uploadFunc(correctTextFile)
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
@@ -118,7 +119,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		labels := response["labels"]
+		labels := response.Labels
 		if !slices.Contains(labels, "label1") || !slices.Contains(labels, "label2") {
 			t.Fatalf("Invalid labels: %v parsed", labels)
 		}
@@ -131,7 +132,7 @@ func TestTrainableCsvValidation(t *testing.T) {
 		}{
 			{"correctTokenFile.csv", "source,target\nTexas is the address,LOCATION O O O\nHe saw Dr Liam yesterday,O O O NAME O"},
 		}
-		var response map[string][]string
+		var response services.TrainableCSVResponse
 
 		uploadID := uploadFunc(correctTokenFile)
 		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
@@ -139,9 +140,237 @@ func TestTrainableCsvValidation(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		labels := response["labels"]
+		labels := response.Labels
 		if !slices.Contains(labels, "NAME") || !slices.Contains(labels, "LOCATION") {
 			t.Fatalf("Invalid labels: %v parsed", labels)
 		}
 	}
+
+	{
+		// Semicolon delimited latin-1 text-csv file with custom columns
+		latin1File := []struct {
+			name, data string
+		}{
+			{"latin1File.csv", "id;review;sentiment\n1;tr\xe8s bien;positif\n2;pas mal, \xe7a va;n\xe9gatif"},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(latin1File)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text", SourceColumn: "review", TargetColumn: "sentiment"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(response.Labels, "positif") || !slices.Contains(response.Labels, "négatif") {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+		if response.Dialect.Delimiter != ";" || response.Dialect.Encoding != "windows-1252" {
+			t.Fatalf("Invalid dialect detected: %v", response.Dialect)
+		}
+	}
+
+	{
+		// Token-csv file with a UTF-8 BOM and single quotes
+		bomFile := []struct {
+			name, data string
+		}{
+			{"bomFile.csv", "\ufeffsource,target\n'Paris, France',LOCATION LOCATION\n'He said \"hi\"',O O O"},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(bomFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token", CsvOptions: config.CSVOptions{Quote: "'"}}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(response.Labels) != 1 || response.Labels[0] != "LOCATION" {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+		if response.Dialect.Delimiter != "," || response.Dialect.Encoding != "utf-8" {
+			t.Fatalf("Invalid dialect detected: %v", response.Dialect)
+		}
+	}
+
+	{
+		// Jsonl text file with a schema that varies between records
+		jsonlFile := []struct {
+			name, data string
+		}{
+			{"textFile.jsonl", "{\"text\":\"Normal text\",\"labels\":\"label1\",\"score\":1}\n\n{\"text\":\"Different text\",\"labels\":\"label2\",\"score\":0.5,\"note\":null}\n"},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(jsonlFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(response.Labels, "label1") || !slices.Contains(response.Labels, "label2") {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+		expected := []services.ColumnSchema{
+			{Name: "text", Type: "string"},
+			{Name: "labels", Type: "string"},
+			{Name: "score", Type: "number"},
+			{Name: "note", Type: "null", Nullable: true},
+		}
+		if response.Format != "jsonl" || response.Rows != 2 || !slices.Equal(response.Schema, expected) {
+			t.Fatalf("Invalid validation report: %+v", response)
+		}
+	}
+
+	{
+		// Jsonl token file with arrays of tokens
+		jsonlFile := []struct {
+			name, data string
+		}{
+			{"tokenFile.jsonl", "{\"source\":[\"Paris\",\"is\",\"nice\"],\"target\":[\"LOCATION\",\"O\",\"O\"]}\n{\"source\":\"He saw Dr Liam\",\"target\":\"O O O NAME\"}"},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(jsonlFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(response.Labels, "LOCATION") || !slices.Contains(response.Labels, "NAME") {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+		if response.Schema[0].Type != "mixed" {
+			t.Fatalf("Invalid schema: %v", response.Schema)
+		}
+	}
+
+	{
+		// Jsonl token file with incorrect source and target length
+		jsonlFile := []struct {
+			name, data string
+		}{
+			{"badTokenFile.jsonl", "{\"source\":\"Texas is the address\",\"target\":\"LOCATION O O O\"}\n{\"source\":[\"He\",\"saw\"],\"target\":[\"O\"]}"},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(jsonlFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
+		if err == nil {
+			t.Fatal("jsonl file with incorrect source and target length shouldn't get validated")
+		}
+		if !strings.Contains(err.Error(), "number of source tokens: 2 ≠ number of target tokens: 1. Invalid line: 2") {
+			t.Fatal(err)
+		}
+	}
+
+	str := func(s string) *string { return &s }
+
+	{
+		// Parquet text file with dictionary encoded and compressed labels
+		parquetFile := []struct {
+			name, data string
+		}{
+			{"textFile.parquet", string(writeParquet(3, []parquetColumn{
+				{name: "id", ints: []int64{1, 2, 3}},
+				{name: "text", strings: []*string{str("Normal text"), str("Different text"), str("Other text")}},
+				{name: "labels", strings: []*string{str("label1"), str("label2"), str("label1")}, dictionary: true, snappy: true},
+				{name: "comment", optional: true, strings: []*string{nil, str("good"), nil}, dictionary: true},
+			}))},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(parquetFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(response.Labels) != 2 || !slices.Contains(response.Labels, "label1") || !slices.Contains(response.Labels, "label2") {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+		expected := []services.ColumnSchema{
+			{Name: "id", Type: "int64"},
+			{Name: "text", Type: "string"},
+			{Name: "labels", Type: "string"},
+			{Name: "comment", Type: "string", Nullable: true},
+			{Name: "tags", Type: "list", Nullable: true},
+		}
+		if response.Format != "parquet" || response.Rows != 3 || !slices.Equal(response.Schema, expected) {
+			t.Fatalf("Invalid validation report: %+v", response)
+		}
+	}
+
+	{
+		// Parquet token file with a null target
+		parquetFile := []struct {
+			name, data string
+		}{
+			{"tokenFile.parquet", string(writeParquet(2, []parquetColumn{
+				{name: "source", strings: []*string{str("Texas is nice"), str("He saw Dr Liam")}, snappy: true},
+				{name: "target", optional: true, strings: []*string{str("LOCATION O O"), nil}},
+			}))},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(parquetFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
+		if err == nil {
+			t.Fatal("parquet file with null targets shouldn't get validated")
+		}
+		if !strings.Contains(err.Error(), "row 1 has a null value in column 'target'") {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		// Parquet token file
+		parquetFile := []struct {
+			name, data string
+		}{
+			{"tokenFile.parquet", string(writeParquet(2, []parquetColumn{
+				{name: "source", strings: []*string{str("Texas is nice"), str("He saw Dr Liam")}, snappy: true},
+				{name: "target", strings: []*string{str("LOCATION O O"), str("O O O NAME")}, dictionary: true},
+			}))},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(parquetFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "token"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(response.Labels) != 2 || !slices.Contains(response.Labels, "LOCATION") || !slices.Contains(response.Labels, "NAME") {
+			t.Fatalf("Invalid labels: %v parsed", response.Labels)
+		}
+	}
+
+	{
+		// Imbalanced text file
+		rows := "text,labels\n"
+		for i := 0; i < 30; i++ {
+			rows += "common text,common\n"
+		}
+		rows += "rare text,rare\nother text,other\nother text,other\n"
+		imbalancedFile := []struct {
+			name, data string
+		}{
+			{"imbalancedFile.csv", rows},
+		}
+		var response services.TrainableCSVResponse
+
+		uploadID := uploadFunc(imbalancedFile)
+		err := client.Post("/train/validate-trainable-csv").Json(services.TrainableCSVRequest{UploadId: uploadID, FileType: "text"}).Do(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.LabelCounts["common"] != 30 || response.LabelCounts["rare"] != 1 || response.LabelCounts["other"] != 2 || response.ImbalanceRatio != 30 {
+			t.Fatalf("Invalid label distribution: %v %v", response.LabelCounts, response.ImbalanceRatio)
+		}
+		suggestion := response.SuggestedImbalanceOptions
+		if suggestion.Oversampling != config.OversamplingRandom || suggestion.OversamplingRatio != config.DefaultOversamplingRatio || suggestion.ClassWeighting != config.ClassWeightingNone {
+			t.Fatalf("Invalid imbalance suggestion: %+v", suggestion)
+		}
+	}
 }
@@ -0,0 +1,236 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/orchestrator/nomad"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+)
+
+var jobIdRe = regexp.MustCompile(`job\s+"([^"]+)"`)
+
+// fakeNomad implements the parts of the nomad api that are used to run jobs in
+// namespaces.
+type fakeNomad struct {
+	mu         sync.Mutex
+	namespaces map[string]bool
+	created    []string
+	// The namespace of each job, keyed by namespace and job id.
+	jobs map[[2]string]bool
+}
+
+func newFakeNomad(t *testing.T, namespaces ...string) (*fakeNomad, *httptest.Server) {
+	nomad := &fakeNomad{namespaces: map[string]bool{"default": true}, jobs: map[[2]string]bool{}}
+	for _, ns := range namespaces {
+		nomad.namespaces[ns] = true
+	}
+	server := httptest.NewServer(nomad)
+	t.Cleanup(server.Close)
+	return nomad, server
+}
+
+func (n *fakeNomad) addJob(namespace, id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.jobs[[2]string{namespace, id}] = true
+}
+
+func (n *fakeNomad) hasJob(namespace, id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.jobs[[2]string{namespace, id}]
+}
+
+func (n *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	namespace := r.URL.Query().Get("namespace")
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	writeJson := func(v interface{}) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case r.Method == "POST" && path == "jobs/parse":
+		var body struct{ JobHCL string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := jobIdRe.FindStringSubmatch(body.JobHCL)
+		if match == nil {
+			http.Error(w, "job id not found", http.StatusBadRequest)
+			return
+		}
+		writeJson(map[string]interface{}{"ID": match[1], "TaskGroups": []interface{}{}})
+
+	case r.Method == "POST" && path == "jobs":
+		var body struct{ Job map[string]interface{} }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Job["Namespace"] != namespace || !n.namespaces[namespace] {
+			http.Error(w, "invalid namespace", http.StatusBadRequest)
+			return
+		}
+		n.jobs[[2]string{namespace, body.Job["ID"].(string)}] = true
+
+	case strings.HasPrefix(path, "namespace/"):
+		name := strings.TrimPrefix(path, "namespace/")
+		if r.Method == "POST" {
+			n.namespaces[name] = true
+			n.created = append(n.created, name)
+		} else if !n.namespaces[name] {
+			http.NotFound(w, r)
+		}
+
+	case r.Method == "GET" && path == "jobs":
+		stubs := []map[string]string{}
+		for job := range n.jobs {
+			if strings.HasPrefix(job[1], r.URL.Query().Get("prefix")) {
+				stubs = append(stubs, map[string]string{"ID": job[1], "Namespace": job[0]})
+			}
+		}
+		writeJson(stubs)
+
+	case strings.HasPrefix(path, "job/"):
+		id := strings.TrimPrefix(path, "job/")
+		if !n.jobs[[2]string{namespace, id}] {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(n.jobs, [2]string{namespace, id})
+			return
+		}
+		writeJson(map[string]string{"Name": id, "Status": "running"})
+
+	case r.Method == "GET" && path == "allocations" && namespace == "*":
+		alloc := func(namespace string, cpu int) map[string]interface{} {
+			return map[string]interface{}{
+				"Namespace":          namespace,
+				"ClientStatus":       "running",
+				"AllocatedResources": map[string]interface{}{"Tasks": map[string]interface{}{"backend": map[string]interface{}{"Cpu": map[string]int{"CpuShares": cpu}}}},
+			}
+		}
+		writeJson([]interface{}{alloc("platform", 1000), alloc(orchestrator.TeamNamespace("a"), 500), alloc("other-tenant", 700)})
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+func TestNomadNamespaces(t *testing.T) {
+	fake, server := newFakeNomad(t, "platform")
+	client := nomad.NewNomadClient(server.URL, "token", "localhost", "platform")
+
+	teamNamespace := orchestrator.TeamNamespace("a")
+	jobs := []orchestrator.Job{
+		orchestrator.TrainJob{JobName: "train-team", Driver: orchestrator.LocalDriver{}, Namespace: teamNamespace},
+		orchestrator.TrainJob{JobName: "train-platform", Driver: orchestrator.LocalDriver{}},
+	}
+	for _, job := range jobs {
+		if err := client.StartJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(fake.created, []string{teamNamespace}) {
+		t.Fatalf("only the missing team namespace should be created: %v", fake.created)
+	}
+	if !fake.hasJob(teamNamespace, "train-team") || !fake.hasJob("platform", "train-platform") {
+		t.Fatalf("jobs should be submitted to their namespaces: %v", fake.jobs)
+	}
+
+	fake.addJob("platform", "deploy-platform")
+	fake.addJob("other-tenant", "deploy-other")
+
+	// A new client does not know the namespaces of the jobs, so they are looked
+	// up by name.
+	client = nomad.NewNomadClient(server.URL, "token", "localhost", "platform")
+
+	info, err := client.JobInfo("train-team")
+	if err != nil || info.Status != orchestrator.StatusRunning {
+		t.Fatalf("job in team namespace should be found: %+v %v", info, err)
+	}
+	if _, err := client.JobInfo("deploy-platform"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.JobInfo("deploy-other"); !errors.Is(err, orchestrator.ErrJobNotFound) {
+		t.Fatalf("jobs in other namespaces should be ignored: %v", err)
+	}
+
+	if err := client.StopJob("train-team"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.hasJob(teamNamespace, "train-team") {
+		t.Fatal("job should be stopped in its namespace")
+	}
+	if _, err := client.JobInfo("train-team"); !errors.Is(err, orchestrator.ErrJobNotFound) {
+		t.Fatalf("stopped job should not be found: %v", err)
+	}
+
+	usage, err := client.TotalCpuUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 1500 {
+		t.Fatalf("cpu usage should only include the namespaces of the platform: %d", usage)
+	}
+}
+
+func TestTeamNamespaces(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, TeamNamespaces: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamModel, err := admin.trainNdbDummyFile("team-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherModel, err := admin.trainNdbDummyFile("other-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamId, err := admin.createTeam("tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.updateAccess(teamModel, schema.Protected, &teamId); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []string{teamModel, otherModel} {
+		if err := updateTrainStatus(admin, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+		if err := admin.deploy(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	namespaces := map[string]string{}
+	for name, job := range env.nomad.submitted {
+		if deploy, ok := job.(orchestrator.DeployJob); ok {
+			namespaces[strings.TrimPrefix(name, "deploy-ndb-")] = deploy.Namespace
+		}
+	}
+
+	if namespaces[teamModel] != orchestrator.TeamNamespace(teamId) {
+		t.Fatalf("team model should be deployed in the team namespace: %v", namespaces)
+	}
+	if namespaces[otherModel] != "" {
+		t.Fatalf("model without a team should be deployed in the default namespace: %v", namespaces)
+	}
+}
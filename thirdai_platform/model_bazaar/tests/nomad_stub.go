@@ -1,20 +1,31 @@
 package tests
 
 import (
+	"context"
 	"thirdai_platform/model_bazaar/orchestrator"
 	"thirdai_platform/model_bazaar/orchestrator/nomad"
+	"time"
 )
 
 type NomadStub struct {
 	activeJobs map[string]string
+	// The last job submitted with each name.
+	submitted map[string]orchestrator.Job
+	// The last autoscaling update for each job.
+	autoscaling map[string]orchestrator.DeployJob
+	// The cpu usage reported to the license check.
+	cpuUsage int
+	// How long log streams wait between lines.
+	logDelay time.Duration
 }
 
 func newNomadStub() *NomadStub {
-	return &NomadStub{activeJobs: make(map[string]string)}
+	return &NomadStub{activeJobs: make(map[string]string), submitted: make(map[string]orchestrator.Job), autoscaling: make(map[string]orchestrator.DeployJob)}
 }
 
 func (c *NomadStub) StartJob(job orchestrator.Job) error {
 	c.activeJobs[job.GetJobName()] = nomad.NomadTemplatePath(job.JobTemplatePath())
+	c.submitted[job.GetJobName()] = job
 	return nil
 }
 
@@ -23,6 +34,14 @@ func (c *NomadStub) StopJob(jobName string) error {
 	return nil
 }
 
+func (c *NomadStub) UpdateAutoscaling(job orchestrator.DeployJob) error {
+	if _, active := c.activeJobs[job.JobName]; !active {
+		return orchestrator.ErrJobNotFound
+	}
+	c.autoscaling[job.JobName] = job
+	return nil
+}
+
 func (c *NomadStub) JobInfo(jobName string) (orchestrator.JobInfo, error) {
 	if _, active := c.activeJobs[jobName]; active {
 		return orchestrator.JobInfo{Name: jobName, Status: "running"}, nil
@@ -31,7 +50,23 @@ func (c *NomadStub) JobInfo(jobName string) (orchestrator.JobInfo, error) {
 }
 
 func (c *NomadStub) JobLogs(jobName string) ([]orchestrator.JobLog, error) {
-	return []orchestrator.JobLog{}, nil
+	if _, active := c.activeJobs[jobName]; active {
+		return []orchestrator.JobLog{{Stdout: "logs for " + jobName}}, nil
+	}
+	return nil, orchestrator.ErrJobNotFound
+}
+
+func (c *NomadStub) StreamJobLogs(ctx context.Context, jobName string, onLine func(orchestrator.JobLogLine)) error {
+	if _, active := c.activeJobs[jobName]; active {
+		onLine(orchestrator.JobLogLine{Stream: "stdout", Line: "logs for " + jobName})
+		select {
+		case <-time.After(c.logDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		onLine(orchestrator.JobLogLine{Stream: "stderr", Line: "warnings for " + jobName})
+	}
+	return nil
 }
 
 func (c *NomadStub) ListServices() ([]orchestrator.ServiceInfo, error) {
@@ -39,7 +74,7 @@ func (c *NomadStub) ListServices() ([]orchestrator.ServiceInfo, error) {
 }
 
 func (c *NomadStub) TotalCpuUsage() (int, error) {
-	return 0, nil
+	return c.cpuUsage, nil
 }
 
 func (c *NomadStub) Clear() {
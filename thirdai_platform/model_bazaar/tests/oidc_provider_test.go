@@ -0,0 +1,356 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/auth"
+	"thirdai_platform/model_bazaar/schema"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+type oidcIssuer struct {
+	server *httptest.Server
+	key    jwk.Key
+	// Claims returned from the userinfo endpoint for each subject.
+	userinfo map[string]map[string]interface{}
+}
+
+func newSigningKey(t *testing.T, kid string) jwk.Key {
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newOidcIssuer(t *testing.T) *oidcIssuer {
+	issuer := &oidcIssuer{key: newSigningKey(t, "key-1"), userinfo: map[string]map[string]interface{}{}}
+
+	r := chi.NewRouter()
+	r.Get("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                issuer.server.URL,
+			"jwks_uri":              issuer.server.URL + "/keys",
+			"userinfo_endpoint":     issuer.server.URL + "/userinfo",
+			"token_endpoint":        issuer.server.URL + "/token",
+			"grant_types_supported": []string{"authorization_code", "password"},
+		})
+	})
+	r.Get("/keys", func(w http.ResponseWriter, r *http.Request) {
+		public, err := jwk.PublicSetOf(issuer.keySet(t))
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(public)
+	})
+	r.Get("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		token, err := jwt.ParseString(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), jwt.WithVerify(false))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims := map[string]interface{}{"sub": token.Subject()}
+		for k, v := range issuer.userinfo[token.Subject()] {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+	r.Post("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		if clientId != "platform" || clientSecret != "secret" || r.FormValue("grant_type") != "password" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("password") != "okta-password" {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		token := issuer.token(t, "sub-"+r.FormValue("username"), map[string]interface{}{"email": r.FormValue("username")})
+		json.NewEncoder(w).Encode(map[string]string{"access_token": token})
+	})
+
+	issuer.server = httptest.NewServer(r)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *oidcIssuer) keySet(t *testing.T) jwk.Set {
+	set := jwk.NewSet()
+	if err := set.AddKey(i.key); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func (i *oidcIssuer) signedToken(t *testing.T, key jwk.Key, subject, audience string, expiration time.Time, claims map[string]interface{}) string {
+	builder := jwt.NewBuilder().Issuer(i.server.URL).Subject(subject).Audience([]string{audience}).IssuedAt(time.Now()).Expiration(expiration)
+	for k, v := range claims {
+		builder = builder.Claim(k, v)
+	}
+	token, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func (i *oidcIssuer) token(t *testing.T, subject string, claims map[string]interface{}) string {
+	return i.signedToken(t, i.key, subject, "platform", time.Now().Add(time.Hour), claims)
+}
+
+func setupOidcProvider(t *testing.T, env *testEnv, issuer *oidcIssuer) (auth.IdentityProvider, chi.Router) {
+	provider, err := auth.NewOidcIdentityProvider(env.db, auth.NewAuditLogger(new(bytes.Buffer), nil), auth.OidcArgs{
+		IssuerUrl:     issuer.server.URL,
+		ClientId:      "platform",
+		ClientSecret:  "secret",
+		GroupTeams:    map[string]string{"eng": "engineering", "ops": "missing-team"},
+		AdminGroup:    "platform-admins",
+		AdminUsername: adminUsername,
+		AdminEmail:    adminEmail,
+		Client:        issuer.server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(provider.AuthMiddleware()...)
+	r.Get("/user", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := auth.UserFromContext(r)
+		w.Write([]byte(user.Id.String()))
+	})
+	return provider, r
+}
+
+func oidcRequest(api chi.Router, token string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func userTeams(t *testing.T, env *testEnv, userId uuid.UUID) []uuid.UUID {
+	var memberships []schema.UserTeam
+	if err := env.db.Find(&memberships, "user_id = ?", userId).Error; err != nil {
+		t.Fatal(err)
+	}
+	teams := []uuid.UUID{}
+	for _, m := range memberships {
+		teams = append(teams, m.TeamId)
+	}
+	return teams
+}
+
+func TestOidcLoginAndMiddleware(t *testing.T) {
+	env := setupTestEnv(t)
+	issuer := newOidcIssuer(t)
+	provider, api := setupOidcProvider(t, env, issuer)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamId, err := admin.createTeam("engineering")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := issuer.token(t, "00u1abc", map[string]interface{}{
+		"email": "jane@corp.com", "preferred_username": "jane@corp.com", "groups": []string{"eng", "ops"},
+	})
+
+	if code, _ := oidcRequest(api, token); code != http.StatusUnauthorized {
+		t.Fatalf("users must log in before the token is accepted: %d", code)
+	}
+
+	login, err := provider.LoginWithToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := schema.GetUser(login.UserId, env.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "jane" || user.Email != "jane@corp.com" || user.IsAdmin {
+		t.Fatalf("invalid user created: %+v", user)
+	}
+	if teams := userTeams(t, env, user.Id); !slices.Equal(teams, []uuid.UUID{uuid.MustParse(teamId)}) {
+		t.Fatalf("user should be added to teams mapped to their groups: %v", teams)
+	}
+
+	if code, body := oidcRequest(api, token); code != http.StatusOK || body != user.Id.String() {
+		t.Fatalf("invalid response from middleware %d: %v", code, body)
+	}
+
+	expiration, err := provider.GetTokenExpiration(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err == nil {
+		t.Fatalf("expiration should require a token: %v", expiration)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if expiration, err := provider.GetTokenExpiration(req); err != nil || time.Until(expiration) < 59*time.Minute {
+		t.Fatalf("invalid token expiration %v: %v", expiration, err)
+	}
+
+	invalid := map[string]string{
+		"wrong audience": issuer.signedToken(t, issuer.key, "00u1abc", "other", time.Now().Add(time.Hour), nil),
+		"expired":        issuer.signedToken(t, issuer.key, "00u1abc", "platform", time.Now().Add(-time.Hour), nil),
+		"unknown key":    issuer.signedToken(t, newSigningKey(t, "key-1"), "00u1abc", "platform", time.Now().Add(time.Hour), nil),
+		"malformed":      "abc.def",
+	}
+	for name, token := range invalid {
+		if code, _ := oidcRequest(api, token); code != http.StatusUnauthorized {
+			t.Fatalf("%v token should be rejected: %d", name, code)
+		}
+		if _, err := provider.LoginWithToken(token); err == nil {
+			t.Fatalf("%v token should not be able to log in", name)
+		}
+	}
+
+	// Memberships are updated on each login, the same subject maps to the same user.
+	token = issuer.token(t, "00u1abc", map[string]interface{}{
+		"email": "jane@corp.com", "groups": []string{"platform-admins"},
+	})
+	relogin, err := provider.LoginWithToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relogin.UserId != user.Id {
+		t.Fatalf("login should return the same user: %v", relogin.UserId)
+	}
+	if teams := userTeams(t, env, user.Id); len(teams) != 0 {
+		t.Fatalf("user should be removed from teams for groups they left: %v", teams)
+	}
+	if user, _ := schema.GetUser(user.Id, env.db); !user.IsAdmin {
+		t.Fatal("user should be made admin from their group")
+	}
+
+	// Admins are demoted when they leave the admin group.
+	token = issuer.token(t, "00u1abc", map[string]interface{}{
+		"email": "jane@corp.com", "groups": []string{"eng"},
+	})
+	if _, err := provider.LoginWithToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := schema.GetUser(user.Id, env.db); user.IsAdmin {
+		t.Fatal("user should be demoted when they leave the admin group")
+	}
+
+	if err := provider.DeleteUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := oidcRequest(api, token); code != http.StatusUnauthorized {
+		t.Fatalf("deleted identity should not be accepted: %d", code)
+	}
+}
+
+func TestOidcUserInfoAndExistingUsers(t *testing.T) {
+	env := setupTestEnv(t)
+	issuer := newOidcIssuer(t)
+	provider, api := setupOidcProvider(t, env, issuer)
+
+	var admin schema.User
+	if err := env.db.First(&admin, "email = ?", adminEmail).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Identities are not linked to existing users by unverified emails.
+	issuer.userinfo["attacker-sub"] = map[string]interface{}{"email": adminEmail, "email_verified": false}
+	if _, err := provider.LoginWithToken(issuer.token(t, "attacker-sub", nil)); !errors.Is(err, auth.ErrEmailNotVerified) {
+		t.Fatalf("unverified email should not be linked to an existing user: %v", err)
+	}
+
+	// Claims missing from the token are loaded from the userinfo endpoint.
+	issuer.userinfo["admin-sub"] = map[string]interface{}{"email": adminEmail, "email_verified": true, "groups": []string{"platform-admins"}}
+	token := issuer.token(t, "admin-sub", nil)
+
+	login, err := provider.LoginWithToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.UserId != admin.Id {
+		t.Fatalf("existing user should be linked by email: %v", login.UserId)
+	}
+	if user, _ := schema.GetUser(admin.Id, env.db); !user.IsAdmin {
+		t.Fatal("admin status should be kept when the user is in the admin group")
+	}
+	if code, body := oidcRequest(api, token); code != http.StatusOK || body != admin.Id.String() {
+		t.Fatalf("invalid response from middleware %d: %v", code, body)
+	}
+
+	if _, err := provider.LoginWithToken(issuer.token(t, "no-email", nil)); err == nil {
+		t.Fatal("login should fail without an email")
+	}
+
+	// The username is taken by the admin.
+	conflict := issuer.token(t, "other-sub", map[string]interface{}{"email": adminUsername + "@other.com"})
+	if _, err := provider.LoginWithToken(conflict); err == nil || !strings.Contains(err.Error(), "username") {
+		t.Fatalf("login should fail if the username is in use: %v", err)
+	}
+
+	if _, err := provider.LoginWithEmail("bob@corp.com", "wrong"); err == nil {
+		t.Fatal("login with wrong password should fail")
+	}
+	login, err = provider.LoginWithEmail("bob@corp.com", "okta-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := schema.GetUser(login.UserId, env.db); err != nil || user.Email != "bob@corp.com" {
+		t.Fatalf("invalid user from password login %+v: %v", user, err)
+	}
+
+	if provider.AllowDirectSignup() {
+		t.Fatal("direct signup should not be allowed")
+	}
+	if _, err := provider.CreateUser("abc", "abc@mail.com", "password"); err == nil {
+		t.Fatal("users cannot be created through the oidc provider")
+	}
+}
+
+func TestOidcInvalidIssuer(t *testing.T) {
+	env := setupTestEnv(t)
+	issuer := newOidcIssuer(t)
+
+	_, err := auth.NewOidcIdentityProvider(env.db, auth.NewAuditLogger(new(bytes.Buffer), nil), auth.OidcArgs{
+		IssuerUrl: issuer.server.URL + "/other",
+		ClientId:  "platform",
+		Client:    issuer.server.Client(),
+	})
+	if err == nil {
+		t.Fatal("provider should fail if the discovery document cannot be loaded")
+	}
+
+	_, err = auth.NewOidcIdentityProvider(env.db, auth.NewAuditLogger(new(bytes.Buffer), nil), auth.OidcArgs{
+		IssuerUrl: issuer.server.URL,
+		Client:    issuer.server.Client(),
+	})
+	if err == nil {
+		t.Fatal("client id should be required")
+	}
+}
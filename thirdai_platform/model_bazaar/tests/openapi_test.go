@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOpenAPISpecUpToDate(t *testing.T) {
+	env := setupTestEnv(t)
+
+	spec, err := services.OpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	env.api.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("error getting api spec: %d %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), spec) {
+		t.Fatal("model_bazaar/services/openapi.json is out of date, run 'go generate ./model_bazaar/services' to update it")
+	}
+
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := make([]string, 0)
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+strings.TrimPrefix(path, "/api/v2"))
+		}
+	}
+
+	routes := make([]string, 0)
+	err = chi.Walk(env.api, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// The pprof handlers are only for debugging.
+		if strings.HasPrefix(route, "/profiling/pprof/") {
+			return nil
+		}
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routes = append(routes, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range routes {
+		if !slices.Contains(documented, route) {
+			t.Errorf("route '%v' is not in the api spec, add it to apiOperations in model_bazaar/services/openapi.go", route)
+		}
+	}
+	for _, op := range documented {
+		if !slices.Contains(routes, op) {
+			t.Errorf("api spec has '%v' which is not a route", op)
+		}
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	env := setupTestEnv(t)
+
+	w := httptest.NewRecorder()
+	env.api.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `url: "openapi.json"`) {
+		t.Fatalf("invalid swagger ui response: %d %s", w.Code, w.Body.String())
+	}
+}
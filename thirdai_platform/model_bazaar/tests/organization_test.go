@@ -0,0 +1,336 @@
+package tests
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+)
+
+func (c *client) createOrganization(name string, quotas services.OrganizationQuotas) (string, error) {
+	body := map[string]interface{}{
+		"name": name, "max_train_jobs": quotas.MaxTrainJobs, "max_deployments": quotas.MaxDeployments, "max_cpu_mhz": quotas.MaxCpuMhz,
+	}
+
+	var res map[string]string
+	err := c.Post("/organization/create").Json(body).Do(&res)
+	return res["organization_id"], err
+}
+
+func (c *client) addUserToOrganization(orgId, userId string) error {
+	return c.Post(fmt.Sprintf("/organization/%v/users/%v", orgId, userId)).Do(nil)
+}
+
+func (c *client) removeUserFromOrganization(orgId, userId string) error {
+	return c.Delete(fmt.Sprintf("/organization/%v/users/%v", orgId, userId)).Do(nil)
+}
+
+func (c *client) organizationInfo(orgId string) (services.OrganizationDetails, error) {
+	var res services.OrganizationDetails
+	err := c.Get(fmt.Sprintf("/organization/%v", orgId)).Do(&res)
+	return res, err
+}
+
+func modelIds(models []services.ModelInfo) []string {
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ModelId.String())
+	}
+	return ids
+}
+
+func TestOrganizationVisibility(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := make([]client, 0)
+	for _, name := range []string{"abc", "def", "xyz"} {
+		user, err := env.newUser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	orgUser, outsideUser, orgAdmin := users[0], users[1], users[2]
+
+	if _, err := orgUser.createOrganization("bu", services.OrganizationQuotas{}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users cannot create organizations: %v", err)
+	}
+
+	org, err := admin.createOrganization("bu", services.OrganizationQuotas{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.createOrganization("bu", services.OrganizationQuotas{}); err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("organization names must be unique: %v", err)
+	}
+
+	for _, user := range []client{orgUser, orgAdmin} {
+		if err := admin.addUserToOrganization(org, user.userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := orgUser.addUserToOrganization(org, outsideUser.userId); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can add users to organizations: %v", err)
+	}
+
+	info, err := orgUser.userInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.OrganizationId == nil || info.OrganizationId.String() != org || info.OrgAdmin {
+		t.Fatalf("invalid organization in user info: %+v", info)
+	}
+
+	orgModel, err := orgUser.trainNdbDummyFile("org-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateModel, err := orgUser.trainNdbDummyFile("private-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsideModel, err := outsideUser.trainNdbDummyFile("outside-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []struct {
+		user client
+		id   string
+	}{{orgUser, orgModel}, {outsideUser, outsideModel}} {
+		if err := model.user.updateAccess(model.id, schema.Public, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkVisible := func(user client, expected []string) {
+		t.Helper()
+		models, err := user.listModels()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := modelIds(models)
+		slices.Sort(ids)
+		slices.Sort(expected)
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("expected models %v, got %v", expected, ids)
+		}
+	}
+
+	// Public models are only visible within the owner's organization.
+	checkVisible(orgUser, []string{orgModel, privateModel})
+	checkVisible(orgAdmin, []string{orgModel})
+	checkVisible(outsideUser, []string{outsideModel})
+	checkVisible(admin, []string{orgModel, privateModel, outsideModel})
+
+	if _, err := outsideUser.modelInfo(orgModel); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("public models should not be accessible outside the organization: %v", err)
+	}
+	if _, err := orgUser.modelInfo(outsideModel); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("public models should not be accessible outside the organization: %v", err)
+	}
+	perms, err := orgAdmin.modelPermissions(orgModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !perms.Read || perms.Write || perms.Owner {
+		t.Fatalf("invalid permissions in organization: %+v", perms)
+	}
+
+	// Org admins own all models in the organization.
+	if err := orgUser.Post(fmt.Sprintf("/organization/%v/admins/%v", org, orgAdmin.userId)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can add org admins: %v", err)
+	}
+	if err := admin.Post(fmt.Sprintf("/organization/%v/admins/%v", org, orgAdmin.userId)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Post(fmt.Sprintf("/organization/%v/admins/%v", org, outsideUser.userId)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("org admins must be in the organization: %v", err)
+	}
+	perms, err = orgAdmin.modelPermissions(privateModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !perms.Owner {
+		t.Fatalf("org admin should own models in the organization: %+v", perms)
+	}
+	checkVisible(orgAdmin, []string{orgModel, privateModel})
+	if _, err := orgAdmin.modelInfo(outsideModel); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("org admins should not access models outside the organization: %v", err)
+	}
+
+	// Teams only have members from their organization.
+	orgTeam, err := orgAdmin.createTeam("org-team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orgUser.createTeam("other-team"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only org admins can create teams in the organization: %v", err)
+	}
+	if err := orgAdmin.addUserToTeam(orgTeam, orgUser.userId); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.addUserToTeam(orgTeam, outsideUser.userId); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("users outside the organization cannot join its teams: %v", err)
+	}
+	teams, err := orgAdmin.listTeams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teams) != 1 || teams[0].Id.String() != orgTeam || teams[0].OrganizationId == nil || teams[0].OrganizationId.String() != org {
+		t.Fatalf("org admin should see the teams of the organization: %+v", teams)
+	}
+
+	orgInfo, err := orgAdmin.organizationInfo(org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orgInfo.Name != "bu" || len(orgInfo.Teams) != 1 || orgInfo.Teams[0].Id.String() != orgTeam {
+		t.Fatalf("invalid organization info: %+v", orgInfo)
+	}
+	if _, err := orgUser.organizationInfo(org); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only org admins can view the organization: %v", err)
+	}
+
+	var orgUsers []services.OrganizationUserInfo
+	if err := orgAdmin.Get(fmt.Sprintf("/organization/%v/users", org)).Do(&orgUsers); err != nil {
+		t.Fatal(err)
+	}
+	if len(orgUsers) != 2 || orgUsers[0].Username != "abc" || orgUsers[0].OrgAdmin || orgUsers[1].Username != "xyz" || !orgUsers[1].OrgAdmin {
+		t.Fatalf("invalid organization users: %+v", orgUsers)
+	}
+
+	if err := orgUser.updateAccess(privateModel, schema.Protected, &orgTeam); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := admin.Delete(fmt.Sprintf("/organization/%v", org)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("organizations with users cannot be deleted: %v", err)
+	}
+
+	// Leaving the organization removes the user from its teams.
+	if err := admin.removeUserFromOrganization(org, orgUser.userId); err != nil {
+		t.Fatal(err)
+	}
+	info, err = orgUser.userInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.OrganizationId != nil || len(info.Teams) != 0 {
+		t.Fatalf("user should leave the organization and its teams: %+v", info)
+	}
+	model, err := orgUser.modelInfo(privateModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Access != schema.Private || model.TeamId != nil {
+		t.Fatalf("models shared with the organization's teams should become private: %+v", model)
+	}
+	checkVisible(outsideUser, []string{orgModel, outsideModel})
+
+	if err := admin.removeUserFromOrganization(org, orgAdmin.userId); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.deleteTeam(orgTeam); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Delete(fmt.Sprintf("/organization/%v", org)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var orgs []services.OrganizationInfo
+	if err := admin.Get("/organization/list").Do(&orgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(orgs) != 0 {
+		t.Fatalf("organization should be deleted: %+v", orgs)
+	}
+}
+
+func TestOrganizationQuotas(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2, err := env.newUser("def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.createOrganization("bu", services.OrganizationQuotas{MaxTrainJobs: -1}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("negative quotas should be rejected: %v", err)
+	}
+	if _, err := admin.createOrganization("bu", services.OrganizationQuotas{MaxCpuMhz: 200000000}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("cpu allocation over the license limit should be rejected: %v", err)
+	}
+
+	org, err := admin.createOrganization("bu", services.OrganizationQuotas{MaxTrainJobs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []client{user1, user2} {
+		if err := admin.addUserToOrganization(org, user.userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := user1.trainNdbDummyFile("first"); err != nil {
+		t.Fatal(err)
+	}
+	// The quota is shared by the users of the organization.
+	if _, err := user2.trainNdbDummyFile("second"); err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("train job over the organization limit should be rejected: %v", err)
+	}
+	if _, err := outside.trainNdbDummyFile("outside"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := admin.organizationInfo(org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Quotas.MaxTrainJobs != 1 || info.Usage.RunningTrainJobs != 1 || info.Usage.CpuMhz == 0 {
+		t.Fatalf("invalid organization usage: %+v", info)
+	}
+
+	// The second organization's allocation must fit in what is left of the license.
+	if _, err := admin.createOrganization("other", services.OrganizationQuotas{MaxCpuMhz: 60000000}); err != nil {
+		t.Fatal(err)
+	}
+	var updated services.OrganizationInfo
+	if err := admin.Post(fmt.Sprintf("/organization/%v/quotas", org)).Json(services.OrganizationQuotas{MaxCpuMhz: 50000000}).Do(&updated); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("total cpu allocation over the license limit should be rejected: %v", err)
+	}
+
+	if err := admin.Post(fmt.Sprintf("/organization/%v/quotas", org)).Json(services.OrganizationQuotas{MaxCpuMhz: info.Usage.CpuMhz}).Do(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Quotas.MaxTrainJobs != 0 || updated.Quotas.MaxCpuMhz != info.Usage.CpuMhz {
+		t.Fatalf("invalid quotas after update: %+v", updated)
+	}
+
+	if _, err := user2.trainNdbDummyFile("second"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("train job over the organization cpu allocation should be rejected: %v", err)
+	}
+
+	if err := user1.Post(fmt.Sprintf("/organization/%v/quotas", org)).Json(services.OrganizationQuotas{}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can set quotas: %v", err)
+	}
+}
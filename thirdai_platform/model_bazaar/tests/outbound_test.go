@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/utils"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("from proxy"))
+	}))
+	defer proxy.Close()
+
+	err := utils.ConfigureOutbound(utils.OutboundConfig{HttpProxy: proxy.URL, NoProxy: "internal.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = utils.ConfigureOutbound(utils.OutboundConfig{})
+	})
+
+	client := &http.Client{Transport: utils.OutboundTransport()}
+	res, err := client.Get("http://llm-provider.example.com/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "from proxy" || len(proxied) != 1 || proxied[0] != "http://llm-provider.example.com/v1/models" {
+		t.Fatalf("request should be sent through proxy: %v %v", string(body), proxied)
+	}
+
+	req, err := http.NewRequest("GET", "http://internal.example.com/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxyUrl, err := utils.OutboundTransport().Proxy(req); err != nil || proxyUrl != nil {
+		t.Fatalf("hosts in no proxy should not use the proxy: %v %v", proxyUrl, err)
+	}
+
+	err = utils.ConfigureOutbound(utils.OutboundConfig{CaBundle: "/does/not/exist.pem"})
+	if err == nil {
+		t.Fatal("invalid ca bundle should be rejected")
+	}
+}
+
+func TestOutboundJobEnv(t *testing.T) {
+	outbound := utils.OutboundConfig{HttpsProxy: "http://proxy:3128", NoProxy: ".corp"}
+	jobEnv := outbound.JobEnv("model-bazaar.internal")
+
+	expectedNoProxy := "localhost,127.0.0.1,.corp,model-bazaar.internal"
+	if jobEnv["HTTPS_PROXY"] != "http://proxy:3128" || jobEnv["https_proxy"] != "http://proxy:3128" ||
+		jobEnv["NO_PROXY"] != expectedNoProxy || jobEnv["no_proxy"] != expectedNoProxy {
+		t.Fatalf("invalid job env: %v", jobEnv)
+	}
+	if _, ok := jobEnv["HTTP_PROXY"]; ok {
+		t.Fatalf("http proxy should not be set: %v", jobEnv)
+	}
+
+	if len(utils.OutboundConfig{NoProxy: ".corp"}.JobEnv()) != 0 {
+		t.Fatal("job env should be empty if no proxy is configured")
+	}
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver:  &orchestrator.LocalDriver{},
+		OutboundJobEnv: jobEnv,
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := services.JobEnvSettings{Train: map[string]string{"NO_PROXY": "*", "MY_VAR": "abc"}}
+	if err := admin.Post("/job-env").Json(settings).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var current services.JobEnvSettings
+	if err := admin.Get("/job-env").Do(&current); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(current.Train, settings.Train) || len(current.Deploy) != 0 {
+		t.Fatalf("job env settings should only contain admin configured vars: %v", current)
+	}
+
+	model, err := admin.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainJob := env.nomad.submitted[fmt.Sprintf("train-ndb-%v", model)].(orchestrator.TrainJob)
+
+	expected := maps.Clone(jobEnv)
+	expected["NO_PROXY"] = "*"
+	expected["MY_VAR"] = "abc"
+	if !maps.Equal(trainJob.ExtraEnv, expected) {
+		t.Fatalf("train job should have proxy env with admin overrides, got %v", trainJob.ExtraEnv)
+	}
+
+	if err := updateTrainStatus(admin, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	deployJob := env.nomad.submitted[fmt.Sprintf("deploy-ndb-%v", model)].(orchestrator.DeployJob)
+	if !maps.Equal(deployJob.ExtraEnv, jobEnv) {
+		t.Fatalf("deploy job should have proxy env, got %v", deployJob.ExtraEnv)
+	}
+}
@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// A minimal parquet writer for testing the validation of parquet training
+// files. Each column is written as a single page, optionally with a dictionary
+// page and snappy compression.
+
+type thriftField struct {
+	id    int16
+	typ   byte
+	value interface{}
+}
+
+type thriftList struct {
+	elemType byte
+	elems    []interface{}
+}
+
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+func writeThrift(buf *bytes.Buffer, typ byte, value interface{}) {
+	switch typ {
+	case tI32, tI64:
+		buf.Write(binary.AppendUvarint(nil, uint64(value.(int64)<<1^value.(int64)>>63)))
+	case tBinary:
+		var data []byte
+		switch v := value.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+		buf.Write(data)
+	case tList:
+		list := value.(thriftList)
+		if len(list.elems) < 15 {
+			buf.WriteByte(byte(len(list.elems))<<4 | list.elemType)
+		} else {
+			buf.WriteByte(0xf0 | list.elemType)
+			buf.Write(binary.AppendUvarint(nil, uint64(len(list.elems))))
+		}
+		for _, elem := range list.elems {
+			writeThrift(buf, list.elemType, elem)
+		}
+	case tStruct:
+		var last int16
+		for _, field := range value.([]thriftField) {
+			if delta := field.id - last; delta > 0 && delta <= 15 {
+				buf.WriteByte(byte(delta)<<4 | field.typ)
+			} else {
+				buf.WriteByte(field.typ)
+				writeThrift(buf, tI32, int64(field.id))
+			}
+			last = field.id
+			writeThrift(buf, field.typ, field.value)
+		}
+		buf.WriteByte(0)
+	}
+}
+
+func thriftBytes(fields []thriftField) []byte {
+	buf := new(bytes.Buffer)
+	writeThrift(buf, tStruct, fields)
+	return buf.Bytes()
+}
+
+type parquetColumn struct {
+	name     string
+	optional bool
+	// The values of byte array columns, nil values are nulls.
+	strings []*string
+	// The values of int64 columns, if strings is nil.
+	ints []int64
+
+	dictionary bool
+	snappy     bool
+}
+
+func (c parquetColumn) numValues() int {
+	if c.strings != nil {
+		return len(c.strings)
+	}
+	return len(c.ints)
+}
+
+func plainString(value string) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(value))), value...)
+}
+
+// pageData returns the definition levels and values of the column, and the
+// dictionary and its size for dictionary encoded columns.
+func (c parquetColumn) pageData() ([]byte, []byte, int) {
+	data := new(bytes.Buffer)
+
+	if c.optional {
+		// The definition levels are bit packed.
+		groups := (c.numValues() + 7) / 8
+		levels := make([]byte, groups)
+		for i, value := range c.strings {
+			if value != nil {
+				levels[i/8] |= 1 << (i % 8)
+			}
+		}
+		encoded := append(binary.AppendUvarint(nil, uint64(groups<<1|1)), levels...)
+		data.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(encoded))))
+		data.Write(encoded)
+	}
+
+	if c.strings == nil {
+		for _, value := range c.ints {
+			data.Write(binary.LittleEndian.AppendUint64(nil, uint64(value)))
+		}
+		return data.Bytes(), nil, 0
+	}
+
+	if !c.dictionary {
+		for _, value := range c.strings {
+			if value != nil {
+				data.Write(plainString(*value))
+			}
+		}
+		return data.Bytes(), nil, 0
+	}
+
+	dictionary := new(bytes.Buffer)
+	index := map[string]int{}
+	indices := []int{}
+	for _, value := range c.strings {
+		if value == nil {
+			continue
+		}
+		if _, ok := index[*value]; !ok {
+			index[*value] = len(index)
+			dictionary.Write(plainString(*value))
+		}
+		indices = append(indices, index[*value])
+	}
+
+	// The indices are written as runs of length 1 with a bit width of 8.
+	data.WriteByte(8)
+	for _, idx := range indices {
+		data.Write(binary.AppendUvarint(nil, 1<<1))
+		data.WriteByte(byte(idx))
+	}
+
+	return data.Bytes(), dictionary.Bytes(), len(index)
+}
+
+func (c parquetColumn) page(pageType int64, raw []byte, header thriftField) []byte {
+	body := raw
+	if c.snappy {
+		body = snappy.Encode(nil, raw)
+	}
+	page := thriftBytes([]thriftField{
+		{1, tI32, pageType},
+		{2, tI32, int64(len(raw))},
+		{3, tI32, int64(len(body))},
+		header,
+	})
+	return append(page, body...)
+}
+
+func writeParquet(numRows int, columns []parquetColumn) []byte {
+	file := bytes.NewBufferString("PAR1")
+
+	schema := []interface{}{
+		[]thriftField{{4, tBinary, "schema"}, {5, tI32, int64(len(columns) + 1)}},
+	}
+	chunks := []interface{}{}
+
+	for _, column := range columns {
+		element := []thriftField{}
+		if column.strings != nil {
+			element = append(element, thriftField{1, tI32, int64(6)})
+		} else {
+			element = append(element, thriftField{1, tI32, int64(2)})
+		}
+		repetition := int64(0)
+		if column.optional {
+			repetition = 1
+		}
+		element = append(element, thriftField{3, tI32, repetition}, thriftField{4, tBinary, column.name})
+		if column.strings != nil {
+			element = append(element, thriftField{6, tI32, int64(0)})
+		}
+		schema = append(schema, element)
+
+		codec := int64(0)
+		if column.snappy {
+			codec = 1
+		}
+
+		start := int64(file.Len())
+		data, dictionary, dictionarySize := column.pageData()
+
+		encoding := int64(0)
+		var dictionaryOffset int64
+		if dictionary != nil {
+			encoding = 8
+			dictionaryOffset = start
+			file.Write(column.page(2, dictionary, thriftField{7, tStruct, []thriftField{
+				{1, tI32, int64(dictionarySize)}, {2, tI32, int64(0)},
+			}}))
+		}
+
+		dataOffset := int64(file.Len())
+		file.Write(column.page(0, data, thriftField{5, tStruct, []thriftField{
+			{1, tI32, int64(column.numValues())}, {2, tI32, encoding}, {3, tI32, int64(3)}, {4, tI32, int64(3)},
+		}}))
+		size := int64(file.Len()) - start
+
+		meta := []thriftField{
+			element[0],
+			{2, tList, thriftList{tI32, []interface{}{encoding, int64(3)}}},
+			{3, tList, thriftList{tBinary, []interface{}{column.name}}},
+			{4, tI32, codec},
+			{5, tI64, int64(column.numValues())},
+			{6, tI64, size},
+			{7, tI64, size},
+			{9, tI64, dataOffset},
+		}
+		if dictionary != nil {
+			meta = append(meta, thriftField{11, tI64, dictionaryOffset})
+		}
+		chunks = append(chunks, []thriftField{{2, tI64, start}, {3, tStruct, meta}})
+	}
+
+	// A nested list column, only its schema is written.
+	schema = append(schema,
+		[]thriftField{{3, tI32, int64(1)}, {4, tBinary, "tags"}, {5, tI32, int64(1)}, {6, tI32, int64(3)}},
+		[]thriftField{{3, tI32, int64(2)}, {4, tBinary, "list"}, {5, tI32, int64(1)}},
+		[]thriftField{{1, tI32, int64(6)}, {3, tI32, int64(1)}, {4, tBinary, "element"}, {6, tI32, int64(0)}},
+	)
+
+	footer := thriftBytes([]thriftField{
+		{1, tI32, int64(1)},
+		{2, tList, thriftList{tStruct, schema}},
+		{3, tI64, int64(numRows)},
+		{4, tList, thriftList{tStruct, []interface{}{
+			[]thriftField{{1, tList, thriftList{tStruct, chunks}}, {2, tI64, int64(file.Len())}, {3, tI64, int64(numRows)}},
+		}}},
+	})
+	file.Write(footer)
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	file.WriteString("PAR1")
+
+	return file.Bytes()
+}
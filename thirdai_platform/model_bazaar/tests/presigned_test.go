@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+)
+
+func setupS3TestEnv(t *testing.T) (*testEnv, *http.Client) {
+	stub := newS3Stub("test-bucket")
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	store, err := storage.NewS3(storage.S3Config{
+		Bucket:          "test-bucket",
+		Endpoint:        server.URL,
+		PathStyle:       true,
+		AccessKeyId:     "access-key",
+		SecretAccessKey: "secret-key",
+		MountPath:       t.TempDir(),
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := setupTestEnvWithStorage(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}}, nil, store)
+	return env, server.Client()
+}
+
+func sendPresigned(client *http.Client, presigned services.PresignedUrlResponse, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(presigned.Method, presigned.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range presigned.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presigned request returned status %d: %s", res.StatusCode, data)
+	}
+	return data, nil
+}
+
+func TestPresignedDownload(t *testing.T) {
+	env, s3Client := setupS3TestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Get(fmt.Sprintf("/model/%v/download-url", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("models that are not trained cannot be downloaded: %v", err)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	var presigned services.PresignedUrlResponse
+	if err := user.Get(fmt.Sprintf("/model/%v/download-url", model)).Do(&presigned); err != nil {
+		t.Fatal(err)
+	}
+	if presigned.Method != http.MethodGet || time.Until(presigned.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("invalid presigned url: %+v", presigned)
+	}
+
+	data, err := sendPresigned(s3Client, presigned, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte(readAll(t, env.storage, "models/"+model+"/model.zip"))) {
+		t.Fatal("presigned url should download the model archive")
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Get(fmt.Sprintf("/model/%v/download-url", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("download url requires read permission: %v", err)
+	}
+}
+
+func TestPresignedUpload(t *testing.T) {
+	env, s3Client := setupS3TestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := modelArchive(t, env, user)
+	chunks := splitChunks(data, 3)
+
+	var start map[string]string
+	err = user.Post("/model/upload").Json(map[string]interface{}{"model_name": "uploaded", "total_chunks": 3, "sha256": sha256Hex(data)}).Do(&start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := start["token"]
+
+	presign := func(idx int, checksum string) (services.PresignedUrlResponse, error) {
+		var res services.PresignedUrlResponse
+		err := user.Post(fmt.Sprintf("/model/upload/%d/presign", idx)).Auth(token).Json(map[string]string{"sha256": checksum}).Do(&res)
+		return res, err
+	}
+	confirm := func(idx int, checksum string) error {
+		return user.Post(fmt.Sprintf("/model/upload/%d/confirm", idx)).Auth(token).Json(map[string]string{"sha256": checksum}).Do(nil)
+	}
+
+	for idx, chunk := range chunks[:2] {
+		presigned, err := presign(idx, sha256Hex(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if presigned.Method != http.MethodPut || presigned.Headers["x-amz-checksum-sha256"] == "" {
+			t.Fatalf("invalid presigned url: %+v", presigned)
+		}
+		if _, err := sendPresigned(s3Client, presigned, chunk); err != nil {
+			t.Fatal(err)
+		}
+		if err := confirm(idx, sha256Hex([]byte("other"))); err == nil || !strings.Contains(err.Error(), "status 400") {
+			t.Fatalf("chunks can only be confirmed with the checksum of the presigned url: %v", err)
+		}
+		if err := confirm(idx, sha256Hex(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	presigned, err := presign(2, sha256Hex(chunks[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sendPresigned(s3Client, presigned, []byte("corrupted")); err == nil || !strings.Contains(err.Error(), "BadDigest") {
+		t.Fatalf("storage should reject chunks that do not match the checksum: %v", err)
+	}
+	presigned.Headers = nil
+	if _, err := sendPresigned(s3Client, presigned, chunks[2]); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("the checksum header is required: %v", err)
+	}
+	if err := confirm(2, sha256Hex(chunks[2])); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("chunks that have not been uploaded cannot be confirmed: %v", err)
+	}
+
+	if _, err := presign(3, sha256Hex(chunks[2])); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("chunk index should be checked against total chunks: %v", err)
+	}
+	if _, err := presign(2, "abc"); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("invalid checksum should be rejected: %v", err)
+	}
+
+	// Presigned uploads can be mixed with chunks sent through model bazaar.
+	if err := sendChunk(user, token, 2, chunks[2], sha256Hex(chunks[2])); err != nil {
+		t.Fatal(err)
+	}
+	if err := confirm(2, sha256Hex(chunks[2])); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("chunks sent through model bazaar cannot be confirmed: %v", err)
+	}
+	if err := confirm(1, sha256Hex(chunks[1])); err != nil {
+		t.Fatalf("confirming a chunk again should succeed: %v", err)
+	}
+
+	status, err := uploadStatus(user, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Missing) != 0 || status.Received[0].Size != int64(len(chunks[0])) || status.Received[0].Sha256 != sha256Hex(chunks[0]) {
+		t.Fatalf("invalid upload status: %+v", status)
+	}
+
+	var res map[string]string
+	if err := user.Post("/model/upload/commit").Auth(token).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	info, err := user.modelInfo(res["model_id"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != "ndb" || info.TrainStatus != "complete" {
+		t.Fatalf("invalid model after upload: %+v", info)
+	}
+}
+
+func TestPresignedUrlsNotSupported(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Get(fmt.Sprintf("/model/%v/download-url", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("shared disk storage does not support presigned urls: %v", err)
+	}
+
+	var start map[string]string
+	if err := user.Post("/model/upload").Json(map[string]interface{}{"model_name": "uploaded"}).Do(&start); err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/model/upload/0/presign").Auth(start["token"]).Json(map[string]string{"sha256": sha256Hex([]byte("abc"))}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("shared disk storage does not support presigned urls: %v", err)
+	}
+}
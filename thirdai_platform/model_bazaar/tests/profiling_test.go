@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+func TestProfilingDisabled(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = admin.Get("/profiling/pprof/").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("pprof endpoints should not be available unless profiling is enabled: %v", err)
+	}
+
+	err = admin.Post("/profiling/capture").Json(map[string]int{"cpu_seconds": 1}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("profile capture should not be available unless profiling is enabled: %v", err)
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, EnableProfiling: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Get("/profiling/pprof/heap").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("pprof endpoints should be admin only: %v", err)
+	}
+
+	for _, endpoint := range []string{"/profiling/pprof/", "/profiling/pprof/heap?debug=1", "/profiling/pprof/goroutine", "/profiling/pprof/cmdline"} {
+		if err := admin.Get(endpoint).Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var res struct {
+		Path  string   `json:"path"`
+		Files []string `json:"files"`
+	}
+	if err := admin.Post("/profiling/capture").Json(map[string]int{"cpu_seconds": 1}).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.Path, filepath.Join("profiles", "model_bazaar")) || len(res.Files) != 7 {
+		t.Fatalf("invalid profile bundle: %v", res)
+	}
+	for _, file := range res.Files {
+		if exists, err := env.storage.Exists(filepath.Join(res.Path, file)); err != nil || !exists {
+			t.Fatalf("profile %v should be saved: %v", file, err)
+		}
+	}
+
+	ndb, err := admin.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admin.Post("/profiling/capture").Json(map[string]interface{}{"model_id": ndb}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("profile capture should fail for models that are not deployed: %v", err)
+	}
+
+	err = admin.Post("/profiling/capture").Json(map[string]int{"cpu_seconds": 1000}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("profile capture should fail for invalid cpu_seconds: %v", err)
+	}
+}
+
+func TestProfileCaptureOutlivesWriteTimeout(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, EnableProfiling: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.StripPrefix("/api/v2", env.api))
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%v/api/v2/profiling/capture", server.URL), strings.NewReader(`{"cpu_seconds": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", admin.authToken))
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("profile capture should not be cut off by the write timeout: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("profile capture should succeed: %v %v %s", err, res.StatusCode, body)
+	}
+}
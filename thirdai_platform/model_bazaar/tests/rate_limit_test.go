@@ -0,0 +1,259 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/ratelimit"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func rateLimitedGet(env *testEnv, c client, endpoint string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", endpoint, nil)
+	req.Header.Add("Authorization", "Bearer "+c.authToken)
+	w := httptest.NewRecorder()
+	env.api.ServeHTTP(w, req)
+	return w
+}
+
+func TestPerUserRateLimit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		RateLimits:    ratelimit.Config{PerUser: 5, Train: 2},
+	})
+
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := rateLimitedGet(env, user1, "/model/list")
+	if w.Code != http.StatusOK {
+		t.Fatalf("request should succeed: %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "5" || w.Header().Get("RateLimit-Remaining") != "4" || w.Header().Get("RateLimit-Policy") != "5;w=60" {
+		t.Fatalf("invalid rate limit headers: %v", w.Header())
+	}
+	reset, err := strconv.Atoi(w.Header().Get("RateLimit-Reset"))
+	if err != nil || reset < 1 || reset > 60 {
+		t.Fatalf("invalid reset header: %v", w.Header().Get("RateLimit-Reset"))
+	}
+
+	for i := 0; i < 4; i++ {
+		if w := rateLimitedGet(env, user1, "/model/list"); w.Code != http.StatusOK {
+			t.Fatalf("request %d should succeed: %d", i, w.Code)
+		}
+	}
+
+	w = rateLimitedGet(env, user1, "/model/list")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("request should be rate limited: %d %v", w.Code, w.Header())
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 12 {
+		t.Fatalf("invalid retry after header: %v", w.Header().Get("Retry-After"))
+	}
+
+	if w := rateLimitedGet(env, user2, "/model/list"); w.Code != http.StatusOK {
+		t.Fatalf("other users should have a separate budget: %d", w.Code)
+	}
+
+	// The train budget is only 2, so the third training request is limited even
+	// though the user has requests remaining in the per user budget.
+	for i := 0; i < 2; i++ {
+		if _, err := user2.trainNdbDummyFile("model" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = user2.trainNdbDummyFile("model3")
+	if err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("train request should be rate limited: %v", err)
+	}
+
+	if w := rateLimitedGet(env, user2, "/model/list"); w.Code != http.StatusOK {
+		t.Fatalf("other endpoints should not use the train budget: %d", w.Code)
+	}
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		RateLimits:    ratelimit.Config{Global: 3},
+	})
+
+	c := env.newClient()
+	for i := 0; i < 3; i++ {
+		if err := c.Get("/health").Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.Get("/health").Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("global rate limit should apply to all requests: %v", err)
+	}
+}
+
+func TestRateLimitCategory(t *testing.T) {
+	cases := map[string]string{
+		"POST /api/v2/model/upload":                ratelimit.CategoryUpload,
+		"POST /api/v2/train/upload-data":           ratelimit.CategoryUpload,
+		"POST /api/v2/train/ndb":                   ratelimit.CategoryTrain,
+		"POST /api/v2/train/nlp-datagen":           ratelimit.CategoryTrain,
+		"POST /api/v2/train/update-status":         "",
+		"GET /api/v2/model/abc/download":           ratelimit.CategoryDownload,
+		"GET /api/v2/model/abc":                    "",
+		"POST /api/v2/model/upload/commit":         "",
+		"GET /api/v2/train/abc/report":             "",
+		"GET /api/v2/model/abc/download/something": "",
+		"GET /api/v2/search":                       ratelimit.CategoryQuery,
+		"POST /api/v2/graphql":                     ratelimit.CategoryQuery,
+		"GET /api/v2/model/abc/permissions":        ratelimit.CategoryQuery,
+		"POST /api/v2/model/permissions/batch":     ratelimit.CategoryQuery,
+		"POST /api/v2/deploy/abc":                  ratelimit.CategoryDeploy,
+		"POST /api/v2/deploy/abc/rollback":         ratelimit.CategoryDeploy,
+		"DELETE /api/v2/deploy/abc":                "",
+		"POST /api/v2/deploy/presets":              "",
+		"POST /api/v2/deploy/update-status":        "",
+	}
+
+	for request, expected := range cases {
+		parts := strings.SplitN(request, " ", 2)
+		if category := ratelimit.Category(httptest.NewRequest(parts[0], parts[1], nil)); category != expected {
+			t.Fatalf("expected category '%v' for %v, got '%v'", expected, request, category)
+		}
+	}
+}
+
+func TestApiKeyRateLimit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		RateLimits:    ratelimit.Config{PerUser: 10, PerApiKey: 3, Query: 4},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyClient := func(name string) client {
+		key, err := user.createAPIKey([]uuid.UUID{uuid.MustParse(model)}, name, time.Now().Add(time.Hour), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := user
+		c.UseApiKey(key)
+		return c
+	}
+	key1, key2 := keyClient("key1"), keyClient("key2")
+
+	for i := 0; i < 3; i++ {
+		if err := key1.Get(fmt.Sprintf("/model/%v", model)).Do(nil); err != nil {
+			t.Fatalf("request %d should succeed: %v", i, err)
+		}
+	}
+	err = key1.Get(fmt.Sprintf("/model/%v", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("api key requests should be rate limited: %v", err)
+	}
+
+	// The query budget is shared by the user and their API keys, so key2 is
+	// limited once the user and key2 have used it up, even though key2 has
+	// requests remaining in its own budget.
+	for _, c := range []client{user, user, key2, key2} {
+		if err := c.Get(fmt.Sprintf("/model/%v/permissions", model)).Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/model/%v/permissions", model), nil)
+	req.Header.Add("X-API-Key", key2.apiKey)
+	w := httptest.NewRecorder()
+	env.api.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("query budget should apply to api keys: %d %v", w.Code, w.Header())
+	}
+
+	if err := user.Get("/model/list").Do(nil); err != nil {
+		t.Fatalf("api key requests should not use the per user budget: %v", err)
+	}
+}
+
+func TestUpdateRateLimits(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limits services.RateLimitsResponse
+	if err := admin.Get("/rate-limits").Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.Enabled() || limits.Defaults.Enabled() {
+		t.Fatalf("rate limits should be disabled by default: %+v", limits)
+	}
+
+	if err := user.Get("/rate-limits").Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can view rate limits: %v", err)
+	}
+
+	for _, body := range []map[string]interface{}{{"unknown": 1}, {"train": -1}} {
+		err := admin.Patch("/rate-limits").Json(body).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid rate limits %v should be rejected: %v", body, err)
+		}
+	}
+
+	if err := admin.Patch("/rate-limits").Json(map[string]interface{}{"per_user": 2, "train": 1}).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.PerUser != 2 || limits.Limits.Train != 1 || limits.Defaults.Enabled() {
+		t.Fatalf("invalid rate limits after update: %+v", limits)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := rateLimitedGet(env, user, "/model/list"); w.Code != http.StatusOK {
+			t.Fatalf("request %d should succeed: %d", i, w.Code)
+		}
+	}
+	if w := rateLimitedGet(env, user, "/model/list"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("updated rate limits should apply immediately: %d", w.Code)
+	}
+
+	if err := admin.Patch("/rate-limits").Json(map[string]interface{}{"per_user": nil}).Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.PerUser != 0 || limits.Limits.Train != 1 {
+		t.Fatalf("null should reset the budget to the default: %+v", limits)
+	}
+	if w := rateLimitedGet(env, user, "/model/list"); w.Code != http.StatusOK {
+		t.Fatalf("request should succeed after the limit is removed: %d", w.Code)
+	}
+
+	if err := admin.Delete("/rate-limits").Do(&limits); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Limits.Enabled() {
+		t.Fatalf("rate limits should be reset to the defaults: %+v", limits)
+	}
+}
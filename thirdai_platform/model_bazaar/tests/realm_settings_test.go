@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/auth"
+)
+
+func TestRealmSettingsValidation(t *testing.T) {
+	valid := auth.RealmSettings{
+		SMTP:           &auth.RealmSMTP{Host: "smtp.example.com", Port: 587, From: "platform@example.com", Tls: auth.SmtpTlsStartTls},
+		PasswordPolicy: auth.DefaultPasswordPolicy,
+		BruteForce:     auth.DefaultBruteForcePolicy(),
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := valid
+	disabled.SMTP = nil
+	disabled.BruteForce = auth.BruteForcePolicy{}
+	if err := disabled.Validate(); err != nil {
+		t.Fatalf("expected settings without smtp or brute force protection to be valid: %v", err)
+	}
+
+	invalid := []func(s *auth.RealmSettings){
+		func(s *auth.RealmSettings) {
+			s.SMTP = &auth.RealmSMTP{Port: 587, From: "a@b.com", Tls: auth.SmtpTlsSsl}
+		},
+		func(s *auth.RealmSettings) {
+			s.SMTP = &auth.RealmSMTP{Host: "smtp", Port: 0, From: "a@b.com", Tls: auth.SmtpTlsSsl}
+		},
+		func(s *auth.RealmSettings) { s.SMTP = &auth.RealmSMTP{Host: "smtp", Port: 25, Tls: auth.SmtpTlsNone} },
+		func(s *auth.RealmSettings) {
+			s.SMTP = &auth.RealmSMTP{Host: "smtp", Port: 25, From: "a@b.com", Tls: "tls"}
+		},
+		func(s *auth.RealmSettings) { s.PasswordPolicy = "" },
+		func(s *auth.RealmSettings) { s.BruteForce.MaxFailures = -1 },
+		func(s *auth.RealmSettings) { s.BruteForce.WaitIncrementSeconds = 0 },
+		func(s *auth.RealmSettings) { s.BruteForce.MaxWaitSeconds = 10 },
+	}
+	for i, modify := range invalid {
+		settings := valid
+		settings.BruteForce = auth.DefaultBruteForcePolicy()
+		modify(&settings)
+		if err := settings.Validate(); err == nil {
+			t.Fatalf("expected invalid settings %d to fail validation", i)
+		}
+	}
+}
+
+func TestRealmSettingsRequireKeycloak(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.Get("/realm-settings").Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected non admin to be rejected with 403: %v", err)
+	}
+
+	if err := admin.Get("/realm-settings").Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("expected realm settings without keycloak to fail with 422: %v", err)
+	}
+	if err := admin.Patch("/realm-settings").Json(map[string]string{"password_policy": "length(12)"}).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("expected realm settings update without keycloak to fail with 422: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRestoreValidation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := func(c client, params services.RestoreRequest) error {
+		return c.Post("/recovery/restore").Json(params).Do(nil)
+	}
+
+	if err := restore(user, services.RestoreRequest{Backup: "backup_20241010_203418.zip", Provider: "local"}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins can restore: %v", err)
+	}
+
+	for _, name := range []string{"../backup_20241010_203418.zip", "backup_latest.zip", "backup_20241010_203418_full.zip"} {
+		err := restore(admin, services.RestoreRequest{Backup: name, Provider: "local"})
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("backup name %v should be rejected: %v", name, err)
+		}
+	}
+
+	err = restore(admin, services.RestoreRequest{Backup: "backup_20241010_203418.zip", Provider: "dropbox"})
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid provider should be rejected: %v", err)
+	}
+
+	err = restore(admin, services.RestoreRequest{Backup: "backup_20241010_203418_incremental.zip", Provider: "local"})
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("missing local backup should return 404: %v", err)
+	}
+
+	if err := env.storage.Write(filepath.Join("backups", "backup_20241010_203418.zip"), strings.NewReader("backup")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.trainNdbDummyFile("training"); err != nil {
+		t.Fatal(err)
+	}
+	err = restore(admin, services.RestoreRequest{Backup: "backup_20241010_203418.zip", Provider: "local"})
+	if err == nil || !strings.Contains(err.Error(), "status 409") || !strings.Contains(err.Error(), "running train jobs or deployments") {
+		t.Fatalf("restore should be rejected while jobs are running: %v", err)
+	}
+
+	for name := range env.nomad.submitted {
+		if name == "recovery-restore" {
+			t.Fatalf("no restore job should be started: %v", env.nomad.submitted)
+		}
+	}
+}
+
+func TestRestoreStatus(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeStatus := func(status services.RestoreStatus) {
+		data, err := json.Marshal(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := env.storage.Write(filepath.Join("restores", status.RestoreId.String(), "status.json"), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getStatus := func(restoreId uuid.UUID) (services.RestoreStatus, error) {
+		var res services.RestoreStatus
+		err := admin.Get(fmt.Sprintf("/recovery/restore/%v", restoreId)).Do(&res)
+		return res, err
+	}
+
+	if _, err := getStatus(uuid.New()); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("unknown restore should return 404: %v", err)
+	}
+
+	restoreId := uuid.New()
+	status := services.RestoreStatus{
+		RestoreId:     restoreId,
+		Backup:        "backup_20241011_101010_incremental.zip",
+		Status:        services.RestoreInProgress,
+		Step:          "restoring_files",
+		Backups:       []string{"backup_20241010_101010.zip", "backup_20241011_101010_incremental.zip"},
+		FilesRestored: 10,
+		TotalFiles:    40,
+		StartedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
+	}
+	writeStatus(status)
+
+	if err := env.nomad.StartJob(orchestrator.RestoreJob{RestoreId: restoreId.String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := getStatus(restoreId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != services.RestoreInProgress || res.FilesRestored != 10 || res.TotalFiles != 40 || len(res.Backups) != 2 {
+		t.Fatalf("invalid restore status: %+v", res)
+	}
+
+	env.nomad.Clear() // Make it look like the job stopped
+
+	res, err = getStatus(restoreId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != services.RestoreFailed || !strings.Contains(res.Error, "stopped before the restore completed") {
+		t.Fatalf("restore should fail if its job stopped: %+v", res)
+	}
+
+	status.Status = services.RestoreComplete
+	status.FilesRestored = 40
+	writeStatus(status)
+
+	res, err = getStatus(restoreId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != services.RestoreComplete || res.Error != "" {
+		t.Fatalf("invalid restore status: %+v", res)
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+
+	"github.com/google/uuid"
+)
+
+func TestRedactionPolicy(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := user.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlp, err := user.trainNlpToken("guardrail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []string{ndb, nlp} {
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = user.Post("/workflow/enterprise-search").Json(map[string]interface{}{
+		"model_name": "no-guardrail", "retrieval_id": ndb, "redaction_policy": map[string]string{"default": "mask"},
+	}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("redaction policy should require a guardrail: %v", err)
+	}
+
+	var res map[string]string
+	err = user.Post("/workflow/enterprise-search").Json(map[string]interface{}{
+		"model_name": "search", "retrieval_id": ndb, "guardrail_id": nlp,
+		"redaction_policy": map[string]interface{}{"entities": map[string]string{"SSN": "drop"}},
+	}).Do(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := res["model_id"]
+
+	var policy services.RedactionPolicy
+	if err := user.Get(fmt.Sprintf("/workflow/%v/redaction-policy", es)).Do(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Default != services.RedactTokenize || len(policy.Entities) != 1 || policy.Entities["SSN"] != services.RedactDrop {
+		t.Fatalf("invalid redaction policy: %+v", policy)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"default": "hide"},
+		{"entities": map[string]string{"NAME": "encrypt"}},
+		{"entities": map[string]string{"bad tag": "mask"}},
+	} {
+		err := user.Post(fmt.Sprintf("/workflow/%v/redaction-policy", es)).Json(invalid).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid redaction policy %v should be rejected: %v", invalid, err)
+		}
+	}
+
+	update := services.RedactionPolicy{Default: services.RedactMask, Entities: map[string]string{"NAME": services.RedactTokenize}}
+	if err := user.Post(fmt.Sprintf("/workflow/%v/redaction-policy", es)).Json(update).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	policy = services.RedactionPolicy{}
+	if err := user.Get(fmt.Sprintf("/workflow/%v/redaction-policy", es)).Do(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Default != services.RedactMask || len(policy.Entities) != 1 || policy.Entities["NAME"] != services.RedactTokenize {
+		t.Fatalf("redaction policy was not updated: %+v", policy)
+	}
+
+	err = user.Get(fmt.Sprintf("/workflow/%v/redaction-policy", ndb)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("redaction policies should only be supported for enterprise search: %v", err)
+	}
+
+	if err := user.deploy(es); err != nil {
+		t.Fatal(err)
+	}
+
+	deployConfig, err := config.LoadDeployConfig(filepath.Join(env.storage.Location(), storage.ModelPath(uuid.MustParse(es)), "deploy_v1_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(deployConfig.Options["redaction_policy"], `"default":"mask"`) || len(deployConfig.Options["redaction_key"]) != 44 {
+		t.Fatalf("the redaction policy and key should be passed in the deploy config options: %v", deployConfig.Options)
+	}
+}
+
+func TestUnredactPermission(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ndb, err := user.trainNdbDummyFile("ndb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlp, err := user.trainNlpToken("guardrail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := user.createEnterpriseSearch("search", ndb, nlp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.updateAccess(es, "public", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	legal, err := env.newUser("legal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legalInfo, err := legal.userInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := legal.modelPermissions(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !perms.Read || perms.Unredact {
+		t.Fatalf("invalid permissions before grant: %+v", perms)
+	}
+
+	err = legal.Post(fmt.Sprintf("/workflow/%v/unredact-permissions", es)).Json(map[string]uuid.UUID{"user_id": legalInfo.Id}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the owner should be able to grant unredact: %v", err)
+	}
+
+	err = user.Post(fmt.Sprintf("/workflow/%v/unredact-permissions", es)).Json(map[string]uuid.UUID{"user_id": uuid.New()}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("granting unredact to a missing user should fail: %v", err)
+	}
+
+	if err := user.Post(fmt.Sprintf("/workflow/%v/unredact-permissions", es)).Json(map[string]uuid.UUID{"user_id": legalInfo.Id}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var grants []services.UnredactGrantInfo
+	if err := user.Get(fmt.Sprintf("/workflow/%v/unredact-permissions", es)).Do(&grants); err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || grants[0].UserId != legalInfo.Id || grants[0].Username != "legal" {
+		t.Fatalf("invalid unredact grants: %+v", grants)
+	}
+
+	perms, err = legal.modelPermissions(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !perms.Read || perms.Write || !perms.Unredact {
+		t.Fatalf("invalid permissions after grant: %+v", perms)
+	}
+
+	if err := user.Delete(fmt.Sprintf("/workflow/%v/unredact-permissions/%v", es, legalInfo.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	err = user.Delete(fmt.Sprintf("/workflow/%v/unredact-permissions/%v", es, legalInfo.Id)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deleting a missing grant should fail: %v", err)
+	}
+
+	perms, err = legal.modelPermissions(es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perms.Unredact {
+		t.Fatalf("unredact should be revoked: %+v", perms)
+	}
+}
@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sharedReportResponse struct {
+	services.SharedReportInfo
+	Link string `json:"link"`
+}
+
+// getSharedReport fetches a report from its share link without any credentials.
+func getSharedReport(env *testEnv, link string) (int, string, string) {
+	req := httptest.NewRequest("GET", "/report/shared/"+path.Base(link), nil)
+	w := httptest.NewRecorder()
+	env.api.ServeHTTP(w, req)
+	return w.Code, w.Header().Get("Content-Type"), w.Body.String()
+}
+
+func trainModelWithEvaluation(t *testing.T, env *testEnv, c client, name string, metrics map[string]float64) string {
+	model, err := c.trainNdbDummyFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+	if err := updateTrainStatus(c, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics != nil {
+		params := map[string]interface{}{
+			"split":      map[string]interface{}{"test_split": 0.2},
+			"metrics":    metrics,
+			"thresholds": map[string]float64{"precision@1": 0.5},
+		}
+		if err := c.Post("/train/update-evaluation").Auth(jobToken).Json(params).Do(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return model
+}
+
+func TestTrainReportArtifact(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := trainModelWithEvaluation(t, env, user, "my-model", map[string]float64{"precision@1": 0.8})
+
+	trainReport := `{"train_time": 12.5, "epochs": [{"loss": 0.3}]}`
+	path := filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_reports", "1.json")
+	if err := env.storage.Write(path, strings.NewReader(trainReport)); err != nil {
+		t.Fatal(err)
+	}
+
+	var htmlReport sharedReportResponse
+	if err := user.Post(fmt.Sprintf("/report/train/%v", model)).Json(map[string]string{}).Do(&htmlReport); err != nil {
+		t.Fatal(err)
+	}
+	if htmlReport.Kind != "train" || htmlReport.Format != "html" || !htmlReport.ExpiresAt.After(time.Now().AddDate(0, 0, 29)) {
+		t.Fatalf("invalid report %+v", htmlReport)
+	}
+
+	code, contentType, body := getSharedReport(env, htmlReport.Link)
+	if code != http.StatusOK || !strings.HasPrefix(contentType, "text/html") {
+		t.Fatalf("shared report should be accessible without credentials: %d %v", code, contentType)
+	}
+	for _, expected := range []string{"Training Report: my-model", "precision@1", "epochs[0].loss", "12.5"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("html report should contain %q: %v", expected, body)
+		}
+	}
+
+	var pdfReport sharedReportResponse
+	if err := user.Post(fmt.Sprintf("/report/train/%v", model)).Json(map[string]interface{}{"format": "pdf", "expires_in_days": 1}).Do(&pdfReport); err != nil {
+		t.Fatal(err)
+	}
+	code, contentType, body = getSharedReport(env, pdfReport.Link)
+	if code != http.StatusOK || contentType != "application/pdf" {
+		t.Fatalf("invalid pdf response: %d %v", code, contentType)
+	}
+	if !strings.HasPrefix(body, "%PDF-") || !strings.Contains(body, "(Training Report: my-model)") || !strings.HasSuffix(body, "%%EOF\n") {
+		t.Fatal("invalid pdf report")
+	}
+
+	err = user.Post(fmt.Sprintf("/report/train/%v", model)).Json(map[string]string{"format": "docx"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid format should be rejected: %v", err)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Post(fmt.Sprintf("/report/train/%v", model)).Json(map[string]string{}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users without access to the model should not be able to create reports: %v", err)
+	}
+
+	var reports []services.SharedReportInfo
+	if err := user.Get("/report").Do(&reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %v", reports)
+	}
+
+	err = other.Delete(fmt.Sprintf("/report/%v", htmlReport.Id)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only the creator should be able to delete the report: %v", err)
+	}
+	if err := user.Delete(fmt.Sprintf("/report/%v", htmlReport.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := getSharedReport(env, htmlReport.Link); code != http.StatusNotFound {
+		t.Fatalf("deleted report should not be accessible, got %d", code)
+	}
+
+	if err := env.db.Model(&schema.SharedReport{}).Where("id = ?", pdfReport.Id).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := getSharedReport(env, pdfReport.Link); code != http.StatusNotFound {
+		t.Fatalf("expired report should not be accessible, got %d", code)
+	}
+
+	if code, _, _ := getSharedReport(env, "invalid-token"); code != http.StatusNotFound {
+		t.Fatalf("invalid token should return 404, got %d", code)
+	}
+}
+
+func TestEvaluationComparisonReport(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := trainModelWithEvaluation(t, env, user, "model-a", map[string]float64{"precision@1": 0.6, "recall@1": 0.9})
+	b := trainModelWithEvaluation(t, env, user, "model-b", map[string]float64{"precision@1": 0.8, "recall@1": 0.7})
+	c := trainModelWithEvaluation(t, env, user, "model-c", nil)
+
+	var report sharedReportResponse
+	if err := user.Post("/report/evaluation-comparison").Json(map[string]interface{}{"model_ids": []string{a, b, c}}).Do(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, body := getSharedReport(env, report.Link)
+	if code != http.StatusOK {
+		t.Fatalf("unable to get shared report: %d", code)
+	}
+	for _, expected := range []string{"Best precision@1: model-b (0.8)", "Best recall@1: model-a (0.9)", "No holdout evaluation was recorded for: [model-c]"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("comparison should contain %q: %v", expected, body)
+		}
+	}
+
+	err = user.Post("/report/evaluation-comparison").Json(map[string]interface{}{"model_ids": []string{a}}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("comparison requires at least 2 models: %v", err)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Post("/report/evaluation-comparison").Json(map[string]interface{}{"model_ids": []string{a, b}}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("users without access to the models should not be able to compare them: %v", err)
+	}
+}
+
+func TestUsageSummaryReport(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trainModelWithEvaluation(t, env, user, "model-a", nil)
+	if _, err := user.trainNlpToken("model-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post("/report/usage").Json(map[string]string{}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("usage summary should be admin only: %v", err)
+	}
+
+	var report sharedReportResponse
+	if err := admin.Post("/report/usage").Json(map[string]string{"format": "pdf"}).Do(&report); err != nil {
+		t.Fatal(err)
+	}
+	code, _, body := getSharedReport(env, report.Link)
+	if code != http.StatusOK || !strings.Contains(body, "(Usage Summary: "+time.Now().UTC().Format("January 2006")+")") {
+		t.Fatalf("invalid usage summary: %d", code)
+	}
+
+	if err := admin.Post("/report/usage").Json(map[string]string{}).Do(&report); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body = getSharedReport(env, report.Link)
+	for _, expected := range []string{"<td>ndb</td>", "<td>nlp-token</td>", "<td>model.created</td>", "<td>complete</td>"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("usage summary should contain %q: %v", expected, body)
+		}
+	}
+
+	if err := admin.Post("/report/usage").Json(map[string]string{"month": "2001-01"}).Do(&report); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body = getSharedReport(env, report.Link)
+	if !strings.Contains(body, "0 events were recorded") || bytes.Contains([]byte(body), []byte("<td>model.created</td>")) {
+		t.Fatalf("usage summary should only include events from the month: %v", body)
+	}
+
+	err = admin.Post("/report/usage").Json(map[string]string{"month": "2024-13"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("invalid month should be rejected: %v", err)
+	}
+}
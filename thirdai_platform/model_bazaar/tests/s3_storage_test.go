@@ -0,0 +1,290 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+)
+
+func setupS3Storage(t *testing.T, config storage.S3Config) (storage.Storage, *S3Stub) {
+	stub := newS3Stub("test-bucket")
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	config.Bucket = "test-bucket"
+	config.Endpoint = server.URL
+	config.PathStyle = true
+	config.AccessKeyId = "access-key"
+	config.SecretAccessKey = "secret-key"
+
+	store, err := storage.NewS3(config, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, stub
+}
+
+func readAll(t *testing.T, store storage.Storage, path string) string {
+	file, err := store.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestS3Storage(t *testing.T) {
+	store, stub := setupS3Storage(t, storage.S3Config{Prefix: "platform", MountPath: "/model_bazaar"})
+
+	files := map[string]string{
+		"models/abc/model/metadata.json": `{"type": "ndb"}`,
+		"models/abc/model/weights":       "weights",
+		"models/abc/config.json":         "config",
+		"uploads/xyz/data.csv":           "a,b,c",
+	}
+	for path, data := range files {
+		if err := store.Write(path, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := stub.objects["platform/models/abc/config.json"]; !ok {
+		t.Fatal("objects should be stored under the prefix")
+	}
+
+	if data := readAll(t, store, "models/abc/config.json"); data != "config" {
+		t.Fatalf("invalid data read: %v", data)
+	}
+	if _, err := store.Read("models/abc/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("reading a missing file should return not exist: %v", err)
+	}
+
+	if err := store.Append("models/abc/config.json", strings.NewReader("-more")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("models/abc/new", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data := readAll(t, store, "models/abc/config.json"); data != "config-more" {
+		t.Fatalf("invalid data after append: %v", data)
+	}
+
+	if size, err := store.Size("models/abc/config.json"); err != nil || size != 11 {
+		t.Fatalf("invalid size %d: %v", size, err)
+	}
+
+	for path, expected := range map[string]bool{"models/abc/config.json": true, "models/abc": true, "models": true, "models/ab": false, "other": false} {
+		if exists, err := store.Exists(path); err != nil || exists != expected {
+			t.Fatalf("exists %v should be %v, got %v: %v", path, expected, exists, err)
+		}
+	}
+
+	// Pagination is tested by only returning 2 entries per list request.
+	stub.listPageSize = 2
+
+	entries, err := store.List("models/abc")
+	if err != nil || !slices.Equal(entries, []string{"config.json", "model", "new"}) {
+		t.Fatalf("invalid entries %v: %v", entries, err)
+	}
+	if _, err := store.List("missing"); err == nil {
+		t.Fatal("listing a missing directory should fail")
+	}
+
+	allFiles, err := store.ListFiles("models")
+	if err != nil || !slices.Equal(allFiles, []string{"abc/config.json", "abc/model/metadata.json", "abc/model/weights", "abc/new"}) {
+		t.Fatalf("invalid files %v: %v", allFiles, err)
+	}
+
+	if err := store.Zip("models/abc/model"); err != nil {
+		t.Fatal(err)
+	}
+	archive := readAll(t, store, "models/abc/model.zip")
+	reader, err := zip.NewReader(strings.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	if !slices.Equal(names, []string{"metadata.json", "weights"}) {
+		t.Fatalf("invalid zip contents: %v", names)
+	}
+
+	if err := store.Write("uploads/new/model.zip", strings.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Unzip("uploads/new/model.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if data := readAll(t, store, "uploads/new/model/weights"); data != "weights" {
+		t.Fatalf("invalid unzipped data: %v", data)
+	}
+
+	if err := store.Delete("models/abc"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists("models/abc"); err != nil || exists {
+		t.Fatalf("directory should be deleted: %v", err)
+	}
+	if exists, err := store.Exists("uploads/xyz/data.csv"); err != nil || !exists {
+		t.Fatalf("other files should not be deleted: %v", err)
+	}
+
+	if store.Location() != "/model_bazaar" {
+		t.Fatalf("location should be the mount path: %v", store.Location())
+	}
+}
+
+func TestS3StorageMultipartUpload(t *testing.T) {
+	store, stub := setupS3Storage(t, storage.S3Config{})
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 2*1024*1024+10)
+	if err := store.Write("models/large", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(stub.multipartParts, []int{3}) {
+		t.Fatalf("large files should use multipart upload: %v", stub.multipartParts)
+	}
+	if len(stub.uploads) != 0 {
+		t.Fatal("multipart upload should be completed")
+	}
+	if !bytes.Equal(stub.objects["models/large"], data) {
+		t.Fatal("invalid data written with multipart upload")
+	}
+}
+
+func TestS3StorageWriteRetained(t *testing.T) {
+	store, stub := setupS3Storage(t, storage.S3Config{Prefix: "platform"})
+	retained := store.(storage.RetentionWriter)
+	retainUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	err := retained.WriteRetained("exports/a", []byte("data"), retainUntil)
+	if err == nil || !strings.Contains(err.Error(), "object lock must be enabled") {
+		t.Fatalf("write should fail if the bucket does not have object lock: %v", err)
+	}
+
+	stub.objectLock = true
+	if err := retained.WriteRetained("exports/a", []byte("data"), retainUntil); err != nil {
+		t.Fatal(err)
+	}
+	if until := stub.retainUntil["platform/exports/a"]; !until.Equal(retainUntil) {
+		t.Fatalf("invalid retention %v, expected %v", until, retainUntil)
+	}
+	if data := readAll(t, store, "exports/a"); data != "data" {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	if err := store.Write("exports/a", strings.NewReader("changed")); err == nil {
+		t.Fatal("retained object should not be overwritten")
+	}
+	if err := store.Delete("exports/a"); err == nil {
+		t.Fatal("retained object should not be deleted")
+	}
+}
+
+func TestS3StorageRestore(t *testing.T) {
+	store, stub := setupS3Storage(t, storage.S3Config{StorageClass: "GLACIER"})
+	restorer := store.(storage.Restorer)
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 2*1024*1024+10)
+	if err := store.Write("models/large", bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write("models/small", strings.NewReader("small")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"models/large", "models/small"} {
+		if class := stub.storageClasses[key]; class != "GLACIER" {
+			t.Fatalf("object %v should be written with the storage class: %v", key, class)
+		}
+	}
+
+	if _, err := store.Read("models/small"); err == nil || !strings.Contains(err.Error(), "InvalidObjectState") {
+		t.Fatalf("archived objects should not be readable before they are restored: %v", err)
+	}
+
+	// The first call starts the restore, and the second finds it in progress.
+	for i := 0; i < 2; i++ {
+		if ready, err := restorer.Restore("models/small", 1); err != nil || ready {
+			t.Fatalf("restore should be in progress: %v %v", ready, err)
+		}
+	}
+
+	stub.completeRestores()
+	if ready, err := restorer.Restore("models/small", 1); err != nil || !ready {
+		t.Fatalf("restore should be complete: %v %v", ready, err)
+	}
+	if data := readAll(t, store, "models/small"); data != "small" {
+		t.Fatalf("invalid data %v", data)
+	}
+
+	stub.storageClasses["models/small"] = "STANDARD"
+	delete(stub.restored, "models/small")
+	if ready, err := restorer.Restore("models/small", 1); err != nil || !ready {
+		t.Fatalf("objects that are not archived should be readable: %v %v", ready, err)
+	}
+
+	if _, err := restorer.Restore("models/missing", 1); err == nil {
+		t.Fatal("restoring a missing object should fail")
+	}
+}
+
+func TestS3StorageUsage(t *testing.T) {
+	store, _ := setupS3Storage(t, storage.S3Config{CapacityBytes: 100})
+
+	if err := store.Write("models/abc", strings.NewReader(strings.Repeat("a", 40))); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := store.Usage()
+	if err != nil || usage.TotalBytes != 100 || usage.FreeBytes != 60 {
+		t.Fatalf("invalid usage %+v: %v", usage, err)
+	}
+
+	unlimited, _ := setupS3Storage(t, storage.S3Config{})
+	if usage, err := unlimited.Usage(); err != nil || usage.FreeBytes < usage.TotalBytes/2 {
+		t.Fatalf("storage without a capacity should never be full %+v: %v", usage, err)
+	}
+}
+
+func TestS3StorageErrors(t *testing.T) {
+	store, _ := setupS3Storage(t, storage.S3Config{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3StubError(w, http.StatusForbidden, "AccessDenied")
+	}))
+	defer server.Close()
+
+	denied, err := storage.NewS3(storage.S3Config{Bucket: "bucket", Endpoint: server.URL, PathStyle: true}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = denied.Write("file", strings.NewReader("data"))
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("s3 errors should be returned: %v", err)
+	}
+	if _, err := denied.Exists("file"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("errors other than not found should be returned from exists: %v", err)
+	}
+
+	if _, err := storage.NewS3(storage.S3Config{}, http.DefaultClient); err == nil {
+		t.Fatal("bucket should be required")
+	}
+	if _, err := store.Size("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("size of a missing file should return not exist: %v", err)
+	}
+}
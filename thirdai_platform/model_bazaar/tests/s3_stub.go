@@ -0,0 +1,334 @@
+package tests
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Stub is an in memory implementation of the subset of the S3 api that is
+// used by the s3 storage, it only supports path style requests.
+type S3Stub struct {
+	bucket string
+
+	mu         sync.Mutex
+	objects    map[string][]byte
+	uploads    map[string]map[int][]byte
+	nextUpload int
+
+	// Number of parts in completed multipart uploads.
+	multipartParts []int
+	// Page size for list requests, to test pagination.
+	listPageSize int
+
+	// If object lock is enabled objects can be written with a retention date,
+	// and cannot be overwritten or deleted until then. Object versions are not
+	// modeled, so overwriting fails rather than creating a new version.
+	objectLock  bool
+	retainUntil map[string]time.Time
+
+	// Objects in archive storage classes can only be read once they are
+	// restored. Restores stay in progress until completeRestores is called.
+	storageClasses map[string]string
+	uploadClasses  map[string]string
+	restored       map[string]bool
+}
+
+func newS3Stub(bucket string) *S3Stub {
+	return &S3Stub{
+		bucket: bucket, objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}, listPageSize: 1000, retainUntil: map[string]time.Time{},
+		storageClasses: map[string]string{}, uploadClasses: map[string]string{}, restored: map[string]bool{},
+	}
+}
+
+func (s *S3Stub) archived(key string) bool {
+	class := s.storageClasses[key]
+	return class == "GLACIER" || class == "DEEP_ARCHIVE"
+}
+
+func (s *S3Stub) setStorageClass(key, class string) {
+	delete(s.restored, key)
+	if class == "" {
+		delete(s.storageClasses, key)
+	} else {
+		s.storageClasses[key] = class
+	}
+}
+
+// completeRestores makes all objects that are being restored readable.
+func (s *S3Stub) completeRestores() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.restored {
+		s.restored[key] = true
+	}
+}
+
+func s3StubError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%v</Code><Message>%v</Message></Error>", code, code)
+}
+
+func (s *S3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("X-Amz-Signature") {
+		if !s.validPresignedRequest(r) {
+			s3StubError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+	} else if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") || r.Header.Get("X-Amz-Date") == "" {
+		s3StubError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucketPath := "/" + s.bucket + "/"
+	if r.URL.Path != "/"+s.bucket && !strings.HasPrefix(r.URL.Path, bucketPath) {
+		s3StubError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, bucketPath)
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		s.list(w, query)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadId := strconv.Itoa(s.nextUpload)
+		s.nextUpload++
+		s.uploads[uploadId] = map[int][]byte{}
+		s.uploadClasses[uploadId] = r.Header.Get("x-amz-storage-class")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>", uploadId)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			s3StubError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		data, _ := io.ReadAll(r.Body)
+		parts[partNumber] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, partNumber))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			s3StubError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s3StubError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		data := []byte{}
+		for _, part := range complete.Parts {
+			data = append(data, parts[part.PartNumber]...)
+		}
+		s.objects[key] = data
+		s.setStorageClass(key, s.uploadClasses[query.Get("uploadId")])
+		s.multipartParts = append(s.multipartParts, len(complete.Parts))
+		delete(s.uploads, query.Get("uploadId"))
+		delete(s.uploadClasses, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		delete(s.uploadClasses, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && query.Has("restore"):
+		if _, ok := s.objects[key]; !ok {
+			s3StubError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		done, restoring := s.restored[key]
+		switch {
+		case !s.archived(key):
+			s3StubError(w, http.StatusForbidden, "InvalidObjectState")
+		case restoring && !done:
+			s3StubError(w, http.StatusConflict, "RestoreAlreadyInProgress")
+		case done:
+			w.WriteHeader(http.StatusOK)
+		default:
+			s.restored[key] = false
+			w.WriteHeader(http.StatusAccepted)
+		}
+
+	case r.Method == http.MethodPut:
+		if s.retained(key) {
+			s3StubError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if checksum := r.Header.Get("x-amz-checksum-sha256"); checksum != "" {
+			hash := sha256.Sum256(data)
+			if checksum != base64.StdEncoding.EncodeToString(hash[:]) {
+				s3StubError(w, http.StatusBadRequest, "BadDigest")
+				return
+			}
+		}
+		if mode := r.Header.Get("x-amz-object-lock-mode"); mode != "" {
+			retainUntil, err := time.Parse(time.RFC3339, r.Header.Get("x-amz-object-lock-retain-until-date"))
+			hash := md5.Sum(data)
+			switch {
+			case !s.objectLock || err != nil:
+				s3StubError(w, http.StatusBadRequest, "InvalidRequest")
+				return
+			case r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(hash[:]):
+				s3StubError(w, http.StatusBadRequest, "BadDigest")
+				return
+			}
+			s.retainUntil[key] = retainUntil
+		}
+		s.objects[key] = data
+		s.setStorageClass(key, r.Header.Get("x-amz-storage-class"))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			s3StubError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if class := s.storageClasses[key]; class != "" {
+			w.Header().Set("x-amz-storage-class", class)
+		}
+		if done, restoring := s.restored[key]; restoring {
+			restore := `ongoing-request="true"`
+			if done {
+				restore = `ongoing-request="false", expiry-date="Fri, 21 Dec 2040 00:00:00 GMT"`
+			}
+			w.Header().Set("x-amz-restore", restore)
+		}
+		if r.Method == http.MethodGet && s.archived(key) && !s.restored[key] {
+			s3StubError(w, http.StatusForbidden, "InvalidObjectState")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		if s.retained(key) {
+			s3StubError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		delete(s.objects, key)
+		s.setStorageClass(key, "")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s3StubError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *S3Stub) retained(key string) bool {
+	retainUntil, ok := s.retainUntil[key]
+	return ok && time.Now().Before(retainUntil)
+}
+
+// validPresignedRequest checks that a presigned url has not expired and that
+// the signed headers were sent, the signature itself is not verified.
+func (s *S3Stub) validPresignedRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	date, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || time.Now().After(date.Add(time.Duration(expires)*time.Second)) {
+		return false
+	}
+	for _, header := range strings.Split(query.Get("X-Amz-SignedHeaders"), ";") {
+		if header != "host" && r.Header.Get(header) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *S3Stub) list(w http.ResponseWriter, query map[string][]string) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	prefix, delimiter, token := get("prefix"), get("delimiter"), get("continuation-token")
+	maxKeys := s.listPageSize
+	if n, err := strconv.Atoi(get("max-keys")); err == nil {
+		maxKeys = min(n, maxKeys)
+	}
+
+	keys := make([]string, 0)
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	type entry struct {
+		key      string
+		isPrefix bool
+	}
+	entries := []entry{}
+	for _, key := range keys {
+		if delimiter != "" {
+			if idx := strings.Index(key[len(prefix):], delimiter); idx >= 0 {
+				commonPrefix := key[:len(prefix)+idx+1]
+				if len(entries) > 0 && entries[len(entries)-1].key == commonPrefix {
+					continue
+				}
+				entries = append(entries, entry{key: commonPrefix, isPrefix: true})
+				continue
+			}
+		}
+		entries = append(entries, entry{key: key})
+	}
+
+	truncated := len(entries) > maxKeys
+	if truncated {
+		entries = entries[:maxKeys]
+	}
+
+	var out strings.Builder
+	out.WriteString("<ListBucketResult>")
+	fmt.Fprintf(&out, "<KeyCount>%d</KeyCount><IsTruncated>%v</IsTruncated>", len(entries), truncated)
+	for _, e := range entries {
+		if e.isPrefix {
+			fmt.Fprintf(&out, "<CommonPrefixes><Prefix>%v</Prefix></CommonPrefixes>", e.key)
+		} else {
+			fmt.Fprintf(&out, "<Contents><Key>%v</Key><Size>%d</Size></Contents>", e.key, len(s.objects[e.key]))
+		}
+	}
+	if truncated {
+		// Keys after the continuation token are returned, so the common prefix
+		// is followed by a character that sorts after every key under it.
+		last := entries[len(entries)-1].key
+		if entries[len(entries)-1].isPrefix {
+			last += "\U0010FFFF"
+		}
+		fmt.Fprintf(&out, "<NextContinuationToken>%v</NextContinuationToken>", last)
+	}
+	out.WriteString("</ListBucketResult>")
+	w.Write([]byte(out.String()))
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func searchResultNames(res services.SearchResponse) string {
+	names := make([]string, 0, len(res.Results))
+	for _, r := range res.Results {
+		names = append(names, r.Type+":"+r.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSearch(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	team, err := admin.createTeam("finance-team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.addUserToTeam(team, user.userId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.createTeam("finance-ops"); err != nil {
+		t.Fatal(err)
+	}
+
+	finance, err := user.trainNdbDummyFile("finance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.trainNdbDummyFile("my-finance-docs"); err != nil {
+		t.Fatal(err)
+	}
+	legal, err := user.trainNdbDummyFile("legal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.trainNdbDummyFile("finance_private"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.db.Create(&schema.ModelAttribute{ModelId: uuid.MustParse(legal), Key: "department", Value: "Finance and Legal"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	uploads := []schema.Upload{
+		{Id: uuid.New(), UserId: uuid.MustParse(user.userId), UploadDate: time.Now(), Files: "notes.txt;finance_q3.pdf"},
+		{Id: uuid.New(), UserId: uuid.MustParse(other.userId), UploadDate: time.Now(), Files: "finance_q4.pdf"},
+	}
+	if err := env.db.Create(&uploads).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var res services.SearchResponse
+	if err := user.Get("/search?q=Finance").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	// Exact matches come first, then prefixes, then substrings, and matches on
+	// attributes and files rank below matches on names.
+	expected := "model:finance,team:finance-team,model:legal,upload:finance_q3.pdf,model:my-finance-docs"
+	if names := searchResultNames(res); names != expected {
+		t.Fatalf("invalid search results %v", names)
+	}
+	if res.Results[0].Id.String() != finance || res.Results[0].Username != "alice" {
+		t.Fatalf("invalid model result %+v", res.Results[0])
+	}
+	if res.Results[2].Field != "attribute:department" || res.Results[2].Match != "Finance and Legal" {
+		t.Fatalf("invalid attribute result %+v", res.Results[2])
+	}
+
+	// Admins can see all teams, models, and uploads.
+	res = services.SearchResponse{}
+	if err := admin.Get("/search?q=finance&types=team,upload").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchResultNames(res); names != "team:finance-ops,team:finance-team,upload:finance_q3.pdf,upload:finance_q4.pdf" {
+		t.Fatalf("invalid admin search results %v", names)
+	}
+
+	res = services.SearchResponse{}
+	if err := user.Get("/search?q=bo&types=user").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 0 {
+		t.Fatalf("users should only find users in their teams: %v", searchResultNames(res))
+	}
+	if err := admin.addUserToTeam(team, other.userId); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get("/search?q=bo&types=user").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchResultNames(res); names != "user:bob" {
+		t.Fatalf("invalid user search results %v", names)
+	}
+
+	// Like wildcards in the query are matched literally.
+	res = services.SearchResponse{}
+	if err := admin.Get("/search?q=e_&types=model").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchResultNames(res); names != "model:finance_private" {
+		t.Fatalf("invalid search results with wildcard %v", names)
+	}
+
+	res = services.SearchResponse{}
+	if err := user.Get("/search?q=finance&limit=2").Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	if names := searchResultNames(res); names != "model:finance,team:finance-team" {
+		t.Fatalf("invalid limited search results %v", names)
+	}
+
+	for _, query := range []string{"/search", "/search?q=%20", "/search?q=a&types=dataset", "/search?q=a&limit=0"} {
+		if err := user.Get(query).Do(nil); err == nil || !strings.Contains(err.Error(), "status 400") {
+			t.Fatalf("expected %v to be rejected, got %v", query, err)
+		}
+	}
+}
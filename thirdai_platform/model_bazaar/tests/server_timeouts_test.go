@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"thirdai_platform/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func startTimeoutTestServer(t *testing.T, timeouts utils.ServerTimeouts) *httptest.Server {
+	slowResponse := func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		for i := 0; i < 4; i++ {
+			if _, err := w.Write([]byte("chunk\n")); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Get("/slow", slowResponse)
+	r.With(utils.WithTimeouts(time.Second, 0)).Get("/stream", slowResponse)
+
+	server := httptest.NewUnstartedServer(r)
+	server.Config = utils.NewServer("", r, timeouts)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServerTimeouts(t *testing.T) {
+	server := startTimeoutTestServer(t, utils.ServerTimeouts{
+		ReadHeader:     200 * time.Millisecond,
+		Read:           time.Second,
+		Write:          250 * time.Millisecond,
+		Idle:           time.Second,
+		MaxHeaderBytes: 4096,
+	})
+
+	// A client that never finishes sending its headers should be disconnected.
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection should be closed by the server, got %v", err)
+	}
+
+	res, err := http.Get(server.URL + "/slow")
+	if err == nil {
+		body, readErr := io.ReadAll(res.Body)
+		res.Body.Close()
+		if readErr == nil && strings.Count(string(body), "chunk") == 4 {
+			t.Fatal("response should be cut off by the write timeout")
+		}
+	}
+
+	res, err = http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || strings.Count(string(body), "chunk") != 4 {
+		t.Fatalf("streaming route should not use the server write timeout: %v %q", err, body)
+	}
+
+	req, err := http.NewRequest("GET", server.URL+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Large", strings.Repeat("a", 16*1024))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("large headers should be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestServerTimeoutsFromEnv(t *testing.T) {
+	t.Setenv("SERVER_WRITE_TIMEOUT", "10m")
+
+	timeouts := utils.ServerTimeoutsFromEnv()
+	if timeouts.Write != 10*time.Minute || timeouts.ReadHeader != 10*time.Second || timeouts.MaxHeaderBytes != 128*1024 {
+		t.Fatalf("invalid timeouts: %+v", timeouts)
+	}
+}
@@ -23,6 +23,7 @@ type testEnv struct {
 	api         chi.Router
 	storage     storage.Storage
 	nomad       *NomadStub
+	db          *gorm.DB
 }
 
 const (
@@ -32,15 +33,44 @@ const (
 )
 
 func setupTestEnv(t *testing.T) *testEnv {
-	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	return setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}})
+}
+
+func setupTestEnvWithVariables(t *testing.T, variables services.Variables) *testEnv {
+	return setupTestEnvWithOrchestrator(t, variables, nil)
+}
+
+// setupTestEnvWithOrchestrator allows the nomad stub to be wrapped by another
+// orchestrator client before it is passed to model bazaar.
+func setupTestEnvWithOrchestrator(t *testing.T, variables services.Variables, wrap func(orchestrator.Client, *gorm.DB) orchestrator.Client) *testEnv {
+	return setupTestEnvWithStorage(t, variables, wrap, nil)
+}
+
+// setupTestEnvWithStorage uses the given storage instead of a shared disk in a
+// temp directory, if it is not nil.
+func setupTestEnvWithStorage(t *testing.T, variables services.Variables, wrap func(orchestrator.Client, *gorm.DB) orchestrator.Client, store storage.Storage) *testEnv {
+	return setupTestEnvWithAuth(t, variables, wrap, store, auth.BasicProviderArgs{})
+}
+
+// setupTestEnvWithAuth passes the given args to the basic identity provider,
+// the secret and admin args are always set by the test env.
+func setupTestEnvWithAuth(t *testing.T, variables services.Variables, wrap func(orchestrator.Client, *gorm.DB) orchestrator.Client, store storage.Storage, authArgs auth.BasicProviderArgs) *testEnv {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	err = db.AutoMigrate(
 		&schema.Model{}, &schema.ModelAttribute{}, &schema.ModelDependency{},
-		&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.JobLog{},
-		&schema.Upload{}, &schema.UserAPIKey{},
+		&schema.User{}, &schema.Team{}, &schema.UserTeam{}, &schema.Organization{}, &schema.JobLog{},
+		&schema.Upload{}, &schema.UserAPIKey{}, &schema.PlatformEvent{}, &schema.EventPublisherCursor{},
+		&schema.JobLogArchive{}, &schema.JobProgress{}, &schema.HoldoutEvaluation{}, &schema.Sweep{},
+		&schema.SweepTrial{}, &schema.SharedReport{}, &schema.DeploymentVersion{}, &schema.ImageScan{},
+		&schema.JobEnvVar{}, &schema.TrustedCertificate{}, &schema.StatusTransition{}, &schema.JobRun{},
+		&schema.ModelUploadSession{}, &schema.ModelUploadChunk{}, &schema.ModelUploadPresignedChunk{}, &schema.OidcIdentity{},
+		&schema.QueuedTrainJob{}, &schema.Webhook{}, &schema.BatchPredictJob{}, &schema.UserJobLimit{},
+		&schema.DeploymentPreset{}, &schema.TrainSchedule{}, &schema.TrainScheduleRun{}, &schema.RateLimitSetting{}, &schema.HealthReportRun{}, &schema.LegalHold{}, &schema.StorageQuotaOverride{},
+		&schema.AuditLogEntry{}, &schema.Dataset{}, &schema.DatasetVersion{}, &schema.ModelDataset{}, &schema.Experiment{}, &schema.ExperimentArm{}, &schema.DeploymentSlo{}, &schema.EntityDictionaryVersion{}, &schema.UnredactGrant{}, &schema.UserToken{}, &schema.InactiveDeployment{}, &schema.ModelStorageTier{}, &schema.ModelGovernance{},
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -63,36 +93,37 @@ func setupTestEnv(t *testing.T) *testEnv {
 		t.Fatalf("error creating storate directory: %v", err)
 	}
 
-	store := storage.NewSharedDisk(storagePath)
+	if store == nil {
+		store = storage.NewSharedDisk(storagePath)
+	}
 	nomadStub := newNomadStub()
 
 	secret := []byte("290zcv02ai249")
 
-	userAuth, err := auth.NewBasicIdentityProvider(
-		db,
-		auth.NewAuditLogger(new(bytes.Buffer)),
-		auth.BasicProviderArgs{
-			Secret:        secret,
-			AdminUsername: adminUsername,
-			AdminEmail:    adminEmail,
-			AdminPassword: adminPassword,
-		},
-	)
+	authArgs.Secret = secret
+	authArgs.AdminUsername = adminUsername
+	authArgs.AdminEmail = adminEmail
+	authArgs.AdminPassword = adminPassword
+
+	userAuth, err := auth.NewBasicIdentityProvider(db, auth.NewAuditLogger(new(bytes.Buffer), db), authArgs)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	var orchestratorClient orchestrator.Client = nomadStub
+	if wrap != nil {
+		orchestratorClient = wrap(nomadStub, db)
+	}
+
 	modelBazaar := services.NewModelBazaar(
-		db, nomadStub, store,
+		db, orchestratorClient, store,
 		licensing.NewVerifier(licensePath),
 		userAuth,
-		services.Variables{
-			BackendDriver: &orchestrator.LocalDriver{},
-		},
+		variables,
 		secret,
 	)
 
-	return &testEnv{modelBazaar: modelBazaar, api: modelBazaar.Routes(), storage: store, nomad: nomadStub}
+	return &testEnv{modelBazaar: modelBazaar, api: modelBazaar.Routes(), storage: store, nomad: nomadStub, db: db}
 }
 
 func (t *testEnv) newClient() client {
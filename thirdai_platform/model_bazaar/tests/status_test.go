@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func checkStatusHistory(t *testing.T, history []services.StatusTransitionInfo, expected ...string) {
+	t.Helper()
+	if len(history) != len(expected)-1 {
+		t.Fatalf("expected %d transitions, got %v", len(expected)-1, history)
+	}
+	for i, transition := range history {
+		if transition.From != expected[i] || transition.To != expected[i+1] {
+			t.Fatalf("transition %d should be %v -> %v, got %v", i, expected[i], expected[i+1], history)
+		}
+		if transition.Timestamp.IsZero() || (i > 0 && transition.Timestamp.Before(history[i-1].Timestamp)) {
+			t.Fatalf("invalid transition timestamps: %v", history)
+		}
+	}
+}
+
+func TestStatusTransitions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobToken := getJobAuthToken(env, t, model)
+
+	for _, status := range []string{"in_progress", "in_progress", "complete", "complete"} {
+		if err := updateTrainStatus(client, jobToken, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, status := range []string{"starting", "in_progress", "failed"} {
+		err := updateTrainStatus(client, jobToken, status)
+		if err == nil || !strings.Contains(err.Error(), "status 409") {
+			t.Fatalf("complete training should not move to %v: %v", status, err)
+		}
+	}
+
+	status, err := client.trainStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "complete" {
+		t.Fatalf("invalid status: %v", status.Status)
+	}
+	checkStatusHistory(t, status.History, "not_started", "starting", "in_progress", "complete")
+
+	if err := client.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Post("/deploy/update-status").Auth(jobToken).Json(map[string]string{"status": "complete"}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.undeploy(model); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Post("/deploy/update-status").Auth(jobToken).Json(map[string]string{"status": "complete"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("stopped deployment should not move to complete: %v", err)
+	}
+
+	status, err = client.deployStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatusHistory(t, status.History, "not_started", "starting", "complete", "stopped")
+}
+
+func TestCheckValidTransition(t *testing.T) {
+	valid := [][3]string{
+		{"train", schema.NotStarted, schema.Starting},
+		{"train", schema.NotStarted, schema.Complete},
+		{"train", schema.Failed, schema.Complete},
+		{"train", schema.Complete, schema.Complete},
+		{"deploy", schema.Complete, schema.Starting},
+		{"deploy", schema.InProgress, schema.Stopped},
+	}
+	for _, c := range valid {
+		if err := schema.CheckValidTransition(c[0], c[1], c[2]); err != nil {
+			t.Fatalf("%v should be valid: %v", c, err)
+		}
+	}
+
+	invalid := [][3]string{
+		{"train", schema.Complete, schema.Starting},
+		{"train", schema.Complete, schema.Failed},
+		{"train", schema.InProgress, schema.Starting},
+		{"deploy", schema.Stopped, schema.Complete},
+		{"deploy", schema.Starting, schema.NotStarted},
+		{"deploy", schema.Starting, "unknown"},
+		{"other", schema.NotStarted, schema.Starting},
+	}
+	for _, c := range invalid {
+		if err := schema.CheckValidTransition(c[0], c[1], c[2]); err == nil {
+			t.Fatalf("%v should be invalid", c)
+		}
+	}
+}
+
+// watchingOrchestrator reports job status changes to the status sync when the
+// test calls the onChange function passed to WatchJobs.
+type watchingOrchestrator struct {
+	orchestrator.Client
+	watches chan func(orchestrator.JobInfo)
+}
+
+func (c *watchingOrchestrator) WatchJobs(ctx context.Context, onChange func(orchestrator.JobInfo)) error {
+	c.watches <- onChange
+	return nil
+}
+
+func TestWatchedJobStatusSync(t *testing.T) {
+	watches := make(chan func(orchestrator.JobInfo), 1)
+	env := setupTestEnvWithOrchestrator(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}}, func(c orchestrator.Client, db *gorm.DB) orchestrator.Client {
+		return &watchingOrchestrator{Client: c, watches: watches}
+	})
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	training, err := client.trainNdbDummyFile("training")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployed, err := client.trainNdbDummyFile("deployed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(client, getJobAuthToken(env, t, deployed), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.deploy(deployed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interval is long enough that only the watch can update the statuses.
+	go env.modelBazaar.JobStatusSync(time.Hour)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	var onChange func(orchestrator.JobInfo)
+	select {
+	case onChange = <-watches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("status sync did not watch jobs")
+	}
+
+	// Changes to jobs that are still running, and to jobs that do not belong to
+	// a model, are ignored.
+	onChange(orchestrator.JobInfo{Name: "train-ndb-" + training, Status: orchestrator.StatusRunning})
+	onChange(orchestrator.JobInfo{Name: "thirdai-platform-frontend", Status: orchestrator.StatusDead})
+	onChange(orchestrator.JobInfo{Name: "train-ndb-" + uuid.NewString(), Status: orchestrator.StatusDead})
+
+	status, err := client.trainStatus(training)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "starting" {
+		t.Fatalf("invalid train status: %v", status.Status)
+	}
+
+	for _, job := range []string{"train-ndb-" + training, "deploy-ndb-" + deployed} {
+		if err := env.nomad.StopJob(job); err != nil {
+			t.Fatal(err)
+		}
+		onChange(orchestrator.JobInfo{Name: job, Status: orchestrator.StatusDead})
+	}
+
+	status, err = client.trainStatus(training)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" {
+		t.Fatalf("train status should be updated once the job dies: %v", status.Status)
+	}
+
+	status, err = client.deployStatus(deployed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" {
+		t.Fatalf("deploy status should be updated once the job dies: %v", status.Status)
+	}
+
+	status, err = client.trainStatus(deployed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "complete" {
+		t.Fatalf("train status should not be changed by the deploy job: %v", status.Status)
+	}
+}
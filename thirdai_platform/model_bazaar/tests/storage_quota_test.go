@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+)
+
+func TestParseStorageQuotas(t *testing.T) {
+	quotas, err := services.ParseStorageQuotas("ndb:50, nlp-text:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotas) != 2 || quotas["ndb"] != 50*1024*1024*1024 || quotas["nlp-text"] != 512*1024*1024 {
+		t.Fatalf("invalid quotas: %v", quotas)
+	}
+
+	for _, invalid := range []string{"ndb", "ndb:-1", "ndb:abc", "xyz:10"} {
+		if _, err := services.ParseStorageQuotas(invalid); err == nil {
+			t.Fatalf("quotas '%v' should be invalid", invalid)
+		}
+	}
+}
+
+func TestStorageQuotaTrainCompletion(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		StorageQuotas: map[string]int64{"ndb": 1000},
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trainModel := func(name string, size int) string {
+		model, err := user.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := env.storage.Write(filepath.Join("models", model, "model", "model.ndb"), bytes.NewReader(randomBytes(size))); err != nil {
+			t.Fatal(err)
+		}
+		return model
+	}
+
+	small := trainModel("small", 500)
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, small), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := user.trainStatus(small); err != nil || status.Status != "complete" {
+		t.Fatalf("model within the quota should complete %+v: %v", status, err)
+	}
+
+	large := trainModel("large", 2000)
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, large), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := user.trainStatus(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" || len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "quota for ndb models is 1000 B") {
+		t.Fatalf("model exceeding the quota should fail: %+v", status)
+	}
+
+	override := trainModel("override", 2000)
+
+	err = user.Post(fmt.Sprintf("/storage-quotas/model/%v", override)).Json(map[string]interface{}{"max_bytes": 5000, "reason": "large customer"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("storage quotas should be admin only: %v", err)
+	}
+	err = admin.Post(fmt.Sprintf("/storage-quotas/model/%v", override)).Json(map[string]interface{}{"max_bytes": -1, "reason": "invalid"}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("negative quota should be rejected: %v", err)
+	}
+	if err := admin.Post(fmt.Sprintf("/storage-quotas/model/%v", override)).Json(map[string]interface{}{"max_bytes": 5000, "reason": "large customer"}).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, override), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := user.trainStatus(override); err != nil || status.Status != "complete" {
+		t.Fatalf("model with an override should complete %+v: %v", status, err)
+	}
+
+	var quotas services.StorageQuotasResponse
+	if err := admin.Get("/storage-quotas").Do(&quotas); err != nil {
+		t.Fatal(err)
+	}
+	if quotas.Quotas["ndb"] != 1000 || len(quotas.Overrides) != 1 || quotas.Overrides[0].ModelId.String() != override || quotas.Overrides[0].MaxBytes != 5000 {
+		t.Fatalf("invalid storage quotas: %+v", quotas)
+	}
+
+	if err := admin.Delete(fmt.Sprintf("/storage-quotas/model/%v", override)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	err = admin.Delete(fmt.Sprintf("/storage-quotas/model/%v", override)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("deleting a missing override should fail: %v", err)
+	}
+}
+
+func TestStorageQuotaUploadCommit(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver: &orchestrator.LocalDriver{},
+		StorageQuotas: map[string]int64{"ndb": 10000},
+	})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The artifacts are written after the model completes, so the source model
+	// is not limited by the quota.
+	data := modelArchive(t, env, user)
+
+	var start map[string]string
+	if err := user.Post("/model/upload").Json(map[string]interface{}{"model_name": "uploaded", "total_chunks": 1}).Do(&start); err != nil {
+		t.Fatal(err)
+	}
+	token := start["token"]
+	if err := sendChunk(user, token, 0, data, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post("/model/upload/commit").Auth(token).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 413") || !strings.Contains(err.Error(), "quota_exceeded") {
+		t.Fatalf("upload exceeding the quota should be rejected: %v", err)
+	}
+
+	status, err := uploadStatus(user, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := env.storage.Exists(filepath.Join("models", status.ModelId.String(), "model")); err != nil || exists {
+		t.Fatalf("artifacts of rejected upload should be deleted: %v", err)
+	}
+
+	err = admin.Post(fmt.Sprintf("/storage-quotas/model/%v", status.ModelId)).Json(map[string]interface{}{"max_bytes": 0, "reason": "unlimited"}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.Post("/model/upload/commit").Auth(token).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := user.modelInfo(status.ModelId.String())
+	if err != nil || info.TrainStatus != "complete" {
+		t.Fatalf("upload should complete after override %+v: %v", info, err)
+	}
+}
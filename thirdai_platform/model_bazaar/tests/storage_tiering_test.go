@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStorageTiering(t *testing.T) {
+	cold, stub := setupS3Storage(t, storage.S3Config{StorageClass: "GLACIER"})
+
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver:  &orchestrator.LocalDriver{},
+		StorageTiering: services.TieringPolicy{After: 30 * 24 * time.Hour, Cold: cold},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := map[string]string{}
+	for _, name := range []string{"unused", "recent", "deployed"} {
+		model, err := user.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+		models[name] = model
+	}
+
+	weights := randomBytes(10000)
+	weightsPath := filepath.Join("models", models["unused"], "model", "model.ndb")
+	if err := env.storage.Write(weightsPath, bytes.NewReader(weights)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deploy(models["deployed"]); err != nil {
+		t.Fatal(err)
+	}
+	markDeployed(t, env, models["deployed"])
+
+	// Deployed models are not archived even if they have not been used for the
+	// period, since they are loaded by the deployment.
+	old := time.Now().UTC().Add(-60 * 24 * time.Hour)
+	err = env.db.Model(&schema.Model{}).Where("id IN ?", []string{models["unused"], models["deployed"]}).Update("published_date", old).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.db.Model(&schema.ModelStorageTier{}).Where("model_id = ?", models["deployed"]).Update("last_used_at", old).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go env.modelBazaar.StorageTieringSync(50 * time.Millisecond)
+	defer env.modelBazaar.StopStorageTieringSync()
+
+	awaitTier := func(model, tier string) {
+		var info services.ModelInfo
+		for i := 0; i < 40; i++ {
+			info, err = user.modelInfo(model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.StorageTier == tier {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("model %v should be in the %v tier, found %v", model, tier, info.StorageTier)
+	}
+
+	awaitTier(models["unused"], schema.StorageTierCold)
+	unusedId := uuid.MustParse(models["unused"])
+	for _, name := range []string{"recent", "deployed"} {
+		awaitTier(models[name], schema.StorageTierHot)
+	}
+
+	if exists, err := env.storage.Exists(storage.ModelPath(unusedId)); err != nil || exists {
+		t.Fatalf("artifacts of archived model should be deleted: %v %v", exists, err)
+	}
+	if exists, err := cold.Exists(storage.ColdModelArchivePath(unusedId)); err != nil || !exists {
+		t.Fatalf("archive should be in cold storage: %v %v", exists, err)
+	}
+
+	for _, use := range []func(string) error{
+		user.deploy,
+		func(model string) error { _, err := user.downloadModel(model); return err },
+	} {
+		if err := use(models["unused"]); err == nil || !strings.Contains(err.Error(), "status 409") || !strings.Contains(err.Error(), "model_archived") {
+			t.Fatalf("archived model should not be usable: %v", err)
+		}
+	}
+
+	if err := user.Post(fmt.Sprintf("/model/%v/rehydrate", models["unused"])).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The model stays rehydrating until the archive has been restored.
+	awaitTier(models["unused"], schema.StorageTierRehydrating)
+	time.Sleep(200 * time.Millisecond)
+	awaitTier(models["unused"], schema.StorageTierRehydrating)
+
+	stub.completeRestores()
+	awaitTier(models["unused"], schema.StorageTierHot)
+
+	if data := readAll(t, env.storage, weightsPath); data != string(weights) {
+		t.Fatal("rehydrated artifacts do not match")
+	}
+	if exists, err := cold.Exists(storage.ColdModelArchivePath(unusedId)); err != nil || exists {
+		t.Fatalf("archive should be deleted from cold storage after rehydration: %v %v", exists, err)
+	}
+
+	if err := user.deploy(models["unused"]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageTieringStaleClaims(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{
+		BackendDriver:  &orchestrator.LocalDriver{},
+		StorageTiering: services.TieringPolicy{Cold: storage.NewSharedDisk(t.TempDir())},
+	})
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := map[string]string{}
+	for _, name := range []string{"stale", "claimed"} {
+		model, err := user.trainNdbDummyFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		models[name] = model
+	}
+
+	// The stale model was claimed by an instance that stopped while archiving
+	// it, the other model is being archived by another instance.
+	staleClaim := time.Now().UTC().Add(-7 * time.Hour)
+	recentClaim := time.Now().UTC().Add(-time.Minute)
+	for name, claimedAt := range map[string]time.Time{"stale": staleClaim, "claimed": recentClaim} {
+		tier := schema.ModelStorageTier{ModelId: uuid.MustParse(models[name]), Tier: schema.StorageTierArchiving, ClaimedAt: &claimedAt}
+		if err := env.db.Create(&tier).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go env.modelBazaar.StorageTieringSync(50 * time.Millisecond)
+	defer env.modelBazaar.StopStorageTieringSync()
+
+	var info services.ModelInfo
+	for i := 0; i < 40; i++ {
+		info, err = user.modelInfo(models["stale"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.StorageTier == schema.StorageTierHot {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if info.StorageTier != schema.StorageTierHot {
+		t.Fatalf("models with stale archiving claims should be reset to the hot tier, found %v", info.StorageTier)
+	}
+
+	info, err = user.modelInfo(models["claimed"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.StorageTier != schema.StorageTierArchiving {
+		t.Fatalf("models claimed by another instance should not be reset, found %v", info.StorageTier)
+	}
+}
+
+func TestBatchPredictArchivedModel(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNlpText("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	tier := schema.ModelStorageTier{ModelId: uuid.MustParse(model), Tier: schema.StorageTierCold}
+	if err := env.db.Create(&tier).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{
+		"model_id":   model,
+		"input_file": map[string]string{"path": "s3://bucket/rows.csv", "location": "s3"},
+		"options":    map[string]interface{}{"text_column": "review", "top_k": 2},
+	}
+	err = user.Post("/batch-predict").Json(body).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 409") || !strings.Contains(err.Error(), "model_archived") {
+		t.Fatalf("batch prediction should fail for archived models: %v", err)
+	}
+
+	if err := env.db.Model(&tier).Update("tier", schema.StorageTierHot).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Post("/batch-predict").Json(body).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.First(&tier, "model_id = ?", model).Error; err != nil || tier.LastUsedAt == nil {
+		t.Fatalf("batch prediction should record that the model was used: %+v %v", tier, err)
+	}
+}
+
+func TestRehydrateWithoutColdStorage(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Post(fmt.Sprintf("/model/%v/rehydrate", model)).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("rehydrate should fail if cold storage is not configured: %v", err)
+	}
+
+	info, err := user.modelInfo(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.StorageTier != schema.StorageTierHot {
+		t.Fatalf("models should be in the hot tier by default: %v", info.StorageTier)
+	}
+}
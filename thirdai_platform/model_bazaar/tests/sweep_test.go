@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sweepTrialInfo struct {
+	Index       int                    `json:"index"`
+	Params      map[string]interface{} `json:"params"`
+	ModelId     *string                `json:"model_id"`
+	TrainStatus string                 `json:"train_status"`
+	Metrics     map[string]float64     `json:"metrics"`
+	IsBest      bool                   `json:"is_best"`
+}
+
+type sweepInfo struct {
+	Status      string           `json:"status"`
+	BestModelId *string          `json:"best_model_id"`
+	Trials      []sweepTrialInfo `json:"trials"`
+}
+
+func TestSweep(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{
+		"sweep_name": "my-sweep",
+		"model_type": "nlp-token",
+		"train_request": map[string]interface{}{
+			"model_options": map[string]interface{}{
+				"target_labels": []string{"NAME", "EMAIL"},
+				"source_column": "source",
+				"target_column": "target",
+				"default_tag":   "O",
+			},
+			"data": map[string]interface{}{
+				"supervised_files": []map[string]string{{"path": "a.txt", "location": "s3"}},
+			},
+			"train_options": map[string]interface{}{"test_split": 0.2},
+		},
+		"parameters": map[string][]interface{}{
+			"train_options.learning_rate": {0.001, 0.0001, 0.00001},
+		},
+		"max_concurrent": 2,
+		"metric":         "macro_fmeasure",
+	}
+
+	var res map[string]string
+	if err := client.Post("/train/sweep").Json(body).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	sweepId := res["sweep_id"]
+
+	getSweep := func() sweepInfo {
+		var info sweepInfo
+		if err := client.Get(fmt.Sprintf("/train/sweep/%v", sweepId)).Do(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	completeTrial := func(trial sweepTrialInfo, fmeasure float64) {
+		report := fmt.Sprintf(`{"holdout_evaluation": {"metrics": {"macro_fmeasure": %v}}}`, fmeasure)
+		path := filepath.Join(storage.ModelPath(uuid.MustParse(*trial.ModelId)), "train_reports", "1.json")
+		if err := env.storage.Write(path, strings.NewReader(report)); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainStatus(client, getJobAuthToken(env, t, *trial.ModelId), "complete"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := getSweep()
+	if info.Status != "in_progress" || len(info.Trials) != 3 {
+		t.Fatalf("invalid sweep %v", info)
+	}
+	for i, trial := range info.Trials {
+		started := trial.ModelId != nil
+		if started != (i < 2) {
+			t.Fatalf("only the first 2 trials should be started: %v", info)
+		}
+		if trial.Params["train_options.learning_rate"] == nil {
+			t.Fatalf("trial params missing: %v", trial)
+		}
+	}
+	if info.Trials[2].TrainStatus != "pending" {
+		t.Fatalf("trial 2 should be pending: %v", info.Trials[2])
+	}
+
+	// Getting the sweep does not advance it, trial 0 finishing without a status
+	// update is only picked up by the status sync.
+	report := `{"holdout_evaluation": {"metrics": {"macro_fmeasure": 0.7}}}`
+	if err := env.storage.Write(filepath.Join(storage.ModelPath(uuid.MustParse(*info.Trials[0].ModelId)), "train_reports", "1.json"), strings.NewReader(report)); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Model(&schema.Model{}).Where("id = ?", *info.Trials[0].ModelId).Update("train_status", schema.Complete).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	info = getSweep()
+	if info.Trials[2].ModelId != nil || len(info.Trials[0].Metrics) != 0 {
+		t.Fatalf("getting the sweep should not advance it: %v", info)
+	}
+
+	// The status sync starts the pending trial once the other trials complete.
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	var started int64
+	if err := env.db.Model(&schema.Model{}).Where("name = ?", "my-sweep-2").Count(&started).Error; err != nil {
+		t.Fatal(err)
+	}
+	if started != 1 {
+		t.Fatal("status sync should start the pending trial")
+	}
+
+	info = getSweep()
+	if info.Status != "in_progress" || info.Trials[2].ModelId == nil || info.Trials[2].TrainStatus != "starting" {
+		t.Fatalf("trial 2 should be started after other trials complete: %v", info)
+	}
+
+	// Status updates from the train jobs advance the sweep.
+	completeTrial(info.Trials[1], 0.9)
+
+	info = getSweep()
+	if info.Trials[0].Metrics["macro_fmeasure"] != 0.7 || info.Trials[1].Metrics["macro_fmeasure"] != 0.9 {
+		t.Fatalf("metrics should be collected from train reports: %v", info)
+	}
+
+	completeTrial(info.Trials[2], 0.8)
+
+	info = getSweep()
+	if info.Status != "complete" || info.BestModelId == nil || *info.BestModelId != *info.Trials[1].ModelId {
+		t.Fatalf("sweep should be complete with trial 1 as the best model: %v", info)
+	}
+	for i, trial := range info.Trials {
+		if trial.IsBest != (i == 1) {
+			t.Fatalf("only trial 1 should be marked as best: %v", info)
+		}
+	}
+
+	if err := client.Get(fmt.Sprintf("/train/sweep/%v", uuid.New())).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected 404 for missing sweep, got %v", err)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Get(fmt.Sprintf("/train/sweep/%v", sweepId)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected 403 for other user, got %v", err)
+	}
+}
+
+func TestSweepValidation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trainRequest := map[string]interface{}{
+		"model_options": map[string]interface{}{
+			"target_labels": []string{"NAME"}, "source_column": "source", "target_column": "target",
+		},
+		"data": map[string]interface{}{
+			"supervised_files": []map[string]string{{"path": "a.txt", "location": "s3"}},
+		},
+	}
+
+	for _, body := range []map[string]interface{}{
+		{ // Invalid parameter location
+			"sweep_name": "a", "model_type": "nlp-token", "train_request": trainRequest,
+			"parameters": map[string][]interface{}{"job_options.allocation_cores": {1, 2}},
+		},
+		{ // Unknown train option
+			"sweep_name": "b", "model_type": "nlp-token", "train_request": trainRequest,
+			"parameters": map[string][]interface{}{"train_options.epoch": {1, 2}},
+		},
+		{ // Invalid value for train option
+			"sweep_name": "c", "model_type": "nlp-token", "train_request": trainRequest,
+			"parameters": map[string][]interface{}{"train_options.test_split": {0.1, 2.0}},
+		},
+		{ // Random sweep without num_trials
+			"sweep_name": "d", "model_type": "nlp-token", "train_request": trainRequest, "strategy": "random",
+			"parameters": map[string][]interface{}{"train_options.epochs": {1, 2}},
+		},
+		{ // Grid too large
+			"sweep_name": "e", "model_type": "nlp-token", "train_request": trainRequest,
+			"parameters": map[string][]interface{}{
+				"train_options.epochs":        {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+				"train_options.learning_rate": {1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			},
+		},
+		{ // Metric not reported for the model type
+			"sweep_name": "f", "model_type": "nlp-token", "train_request": trainRequest, "metric": "precision@1",
+			"parameters": map[string][]interface{}{"train_options.epochs": {1, 2}},
+		},
+		{ // Metric for a model type without holdout evaluation
+			"sweep_name": "g", "model_type": "ndb", "metric": "precision@1",
+			"train_request": map[string]interface{}{
+				"data": map[string]interface{}{"unsupervised_files": []map[string]string{{"path": "a.txt", "location": "s3"}}},
+			},
+			"parameters": map[string][]interface{}{"model_options.advanced_search": {true, false}},
+		},
+	} {
+		err := client.Post("/train/sweep").Json(body).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("expected sweep %v to be rejected, got %v", body["sweep_name"], err)
+		}
+	}
+
+	body := map[string]interface{}{
+		"sweep_name": "f", "model_type": "nlp-token", "train_request": trainRequest,
+		"strategy": "random", "num_trials": 4, "seed": 7, "max_concurrent": 10,
+		"parameters": map[string][]interface{}{"train_options.epochs": {1, 2, 3}, "train_options.batch_size": {100, 200}},
+	}
+	var res map[string]string
+	if err := client.Post("/train/sweep").Json(body).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	var info sweepInfo
+	if err := client.Get(fmt.Sprintf("/train/sweep/%v", res["sweep_id"])).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Trials) != 4 {
+		t.Fatalf("expected 4 trials: %v", info)
+	}
+	for _, trial := range info.Trials {
+		if trial.ModelId == nil || trial.TrainStatus != "starting" {
+			t.Fatalf("all trials should be started: %v", info)
+		}
+	}
+}
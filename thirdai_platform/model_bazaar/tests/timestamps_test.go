@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func checkUtcTimestamp(t *testing.T, res map[string]interface{}, field, deprecated string) {
+	t.Helper()
+	value, ok := res[field].(string)
+	if !ok || !strings.HasSuffix(value, "Z") {
+		t.Fatalf("%v should be an RFC3339 timestamp in UTC: %v", field, res)
+	}
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		t.Fatalf("invalid %v timestamp %v: %v", field, value, err)
+	}
+	if res[deprecated] != value {
+		t.Fatalf("%v should match %v: %v", deprecated, field, res)
+	}
+}
+
+func TestTimestampsAreUtc(t *testing.T) {
+	// Timestamps are created and read back in the location of the server, this
+	// checks that a non UTC location does not leak into responses.
+	local := time.Local
+	time.Local = time.FixedZone("UTC-4", -4*60*60)
+	defer func() { time.Local = local }()
+
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(endpoint string) map[string]interface{} {
+		var res map[string]interface{}
+		if err := user.Get(endpoint).Do(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	checkUtcTimestamp(t, get(fmt.Sprintf("/model/%v", model)), "published_at", "publish_date")
+	checkUtcTimestamp(t, get(fmt.Sprintf("/model/%v/lineage", model))["root"].(map[string]interface{}), "published_at", "publish_date")
+	checkUtcTimestamp(t, get(fmt.Sprintf("/model/%v/permissions", model)), "expires_at", "exp")
+
+	history := get(fmt.Sprintf("/train/%v/status", model))["history"].([]interface{})
+	if len(history) == 0 {
+		t.Fatal("expected status history")
+	}
+	for _, transition := range history {
+		checkUtcTimestamp(t, transition.(map[string]interface{}), "changed_at", "timestamp")
+	}
+
+	body, contentType := createUploadBody(t, []struct{ name, data string }{{"a.csv", "id,text\n0,abc\n"}})
+	var upload map[string]string
+	if err := user.Post("/train/upload-data").Header("Content-Type", contentType).Body(body).Do(&upload); err != nil {
+		t.Fatal(err)
+	}
+	checkUtcTimestamp(t, get(fmt.Sprintf("/train/upload/%v", upload["upload_id"])), "uploaded_at", "upload_date")
+
+	// Older clients specify the expiration of api keys with exp.
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, field := range []string{"expires_at", "exp"} {
+		err := user.Post("/model/create-api-key").Json(map[string]interface{}{
+			"model_ids": []uuid.UUID{uuid.MustParse(model)}, "name": field, field: expiry,
+		}).Do(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []map[string]interface{}
+	if err := user.Get("/model/list-api-keys").Do(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 api keys: %v", keys)
+	}
+	for _, key := range keys {
+		checkUtcTimestamp(t, key, "expires_at", "expiry")
+		if key["expires_at"] != expiry.UTC().Format(time.RFC3339) {
+			t.Fatalf("invalid expiration for api key: %v", key)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/orchestrator"
+	"thirdai_platform/model_bazaar/services"
+	"thirdai_platform/model_bazaar/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func trainJobSubmitted(env *testEnv, model string) bool {
+	for name := range env.nomad.submitted {
+		if strings.HasPrefix(name, "train-") && strings.HasSuffix(name, model) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTrainQueue(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, QueueTrainJobs: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use all of the cpu allowed by the license.
+	env.nomad.cpuUsage = 100000000
+
+	first, err := user.trainNlpToken("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := user.trainNlpText("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []string{first, second} {
+		status, err := user.trainStatus(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != "queued" {
+			t.Fatalf("train job should be queued: %v", status)
+		}
+		if trainJobSubmitted(env, model) {
+			t.Fatal("queued train job should not be started")
+		}
+	}
+
+	var queue []services.QueuedTrainJobInfo
+	if err := admin.Get("/train/queue").Do(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 2 || queue[0].ModelId.String() != first || queue[1].ModelId.String() != second {
+		t.Fatalf("invalid queue: %v", queue)
+	}
+	if queue[0].Position != 1 || queue[0].Username != "abc" || queue[0].ModelName != "first" || queue[0].ModelType != "nlp-token" {
+		t.Fatalf("invalid queued job: %v", queue[0])
+	}
+
+	if err := user.Get("/train/queue").Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should list the queue, got %v", err)
+	}
+
+	// Queued jobs are started by the status sync once there is capacity.
+	env.nomad.cpuUsage = 0
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, model := range []string{first, second} {
+		status, err := user.trainStatus(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != "starting" {
+			t.Fatalf("queued train job should be started: %v", status)
+		}
+		if !trainJobSubmitted(env, model) {
+			t.Fatal("queued train job should be submitted")
+		}
+
+		file, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var trainConfig map[string]interface{}
+		if err := json.Unmarshal(data, &trainConfig); err != nil {
+			t.Fatal(err)
+		}
+		if trainConfig["license_key"] != TEST_LICENSE["license"].(map[string]string)["boltLicenseKey"] {
+			t.Fatalf("license key should be added to the config of the queued job: %v", trainConfig["license_key"])
+		}
+	}
+
+	queue = nil
+	if err := admin.Get("/train/queue").Do(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("queue should be empty: %v", queue)
+	}
+}
+
+func TestTrainQueueDisabled(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.nomad.cpuUsage = 100000000
+
+	if _, err := user.trainNlpToken("model"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("train job should be rejected if queueing is disabled, got %v", err)
+	}
+}
+
+func TestTrainQueueDeleteModel(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, QueueTrainJobs: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.nomad.cpuUsage = 100000000
+
+	model, err := user.trainNlpToken("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.deleteModel(model); err != nil {
+		t.Fatal(err)
+	}
+
+	var queue []services.QueuedTrainJobInfo
+	if err := admin.Get("/train/queue").Do(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("deleted model should be removed from the queue: %v", queue)
+	}
+}
+
+func trainNlpTokenWithOptions(c client, name string, jobOptions config.JobOptions) (string, error) {
+	body := services.NlpTokenTrainRequest{
+		ModelName: name,
+		ModelOptions: &config.NlpTokenOptions{
+			TargetLabels: []string{"NAME", "EMAIL"},
+			SourceColumn: "source",
+			TargetColumn: "target",
+			DefaultTag:   "O",
+		},
+		Data: config.NlpData{
+			SupervisedFiles: []config.TrainFile{{Path: "a.txt", Location: "s3"}},
+		},
+		JobOptions: jobOptions,
+	}
+
+	var res map[string]string
+	err := c.Post("/train/nlp-token").Json(body).Do(&res)
+	return res["model_id"], err
+}
+
+func TestTrainPreemption(t *testing.T) {
+	env := setupTestEnvWithVariables(t, services.Variables{BackendDriver: &orchestrator.LocalDriver{}, QueueTrainJobs: true})
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := trainNlpTokenWithOptions(user, "urgent", config.JobOptions{Priority: 80}); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should train with a high priority, got %v", err)
+	}
+	if _, err := trainNlpTokenWithOptions(user, "invalid", config.JobOptions{Priority: 101}); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("priority above 100 should be rejected, got %v", err)
+	}
+
+	experiment, err := trainNlpTokenWithOptions(user, "experiment", config.JobOptions{Priority: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	important, err := trainNlpTokenWithOptions(admin, "important", config.JobOptions{Priority: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := env.nomad.submitted["train-nlp-token-"+important].(orchestrator.TrainJob); job.Priority != 90 {
+		t.Fatalf("train job should be submitted with its priority: %v", job.Priority)
+	}
+	if job := env.nomad.submitted["train-nlp-token-"+experiment].(orchestrator.TrainJob); job.Priority != 10 {
+		t.Fatalf("train job should be submitted with its priority: %v", job.Priority)
+	}
+
+	// Use all of the cpu allowed by the license, so the next job only fits if a
+	// lower priority job is preempted.
+	env.nomad.cpuUsage = 100000000
+
+	production, err := trainNlpTokenWithOptions(admin, "production", config.JobOptions{Priority: 80, Preempt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := admin.trainStatus(production); err != nil || status.Status != "queued" {
+		t.Fatalf("train job should be queued until it preempts other jobs: %v %v", status, err)
+	}
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+
+	if status, err := user.trainStatus(experiment); err != nil || status.Status != "queued" {
+		t.Fatalf("lower priority train job should be preempted and queued again: %v %v", status, err)
+	}
+	if _, active := env.nomad.activeJobs["train-nlp-token-"+experiment]; active {
+		t.Fatal("preempted train job should be stopped")
+	}
+	if status, err := admin.trainStatus(important); err != nil || status.Status != "starting" {
+		t.Fatalf("higher priority train job should not be preempted: %v %v", status, err)
+	}
+
+	var queue []services.QueuedTrainJobInfo
+	if err := admin.Get("/train/queue").Do(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 2 || queue[0].ModelId.String() != production || queue[0].Priority != 80 || !queue[0].Preempt ||
+		queue[1].ModelId.String() != experiment || queue[1].Priority != 10 || queue[1].Preempt {
+		t.Fatalf("invalid queue: %v", queue)
+	}
+
+	// Both jobs are started once the preempted job has released its cpu.
+	env.nomad.cpuUsage = 0
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, model := range []string{production, experiment} {
+		status, err := admin.trainStatus(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != "starting" {
+			t.Fatalf("queued train job should be started: %v", status)
+		}
+	}
+	if job := env.nomad.submitted["train-nlp-token-"+experiment].(orchestrator.TrainJob); job.Priority != 10 {
+		t.Fatalf("preempted train job should keep its priority: %v", job.Priority)
+	}
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"thirdai_platform/model_bazaar/schema"
+	"thirdai_platform/utils"
+	"time"
+)
+
+type trainScheduleRun struct {
+	Status      string  `json:"status"`
+	ModelId     *string `json:"model_id"`
+	TrainStatus string  `json:"train_status"`
+	Message     string  `json:"message"`
+}
+
+type trainScheduleInfo struct {
+	ScheduleId string             `json:"schedule_id"`
+	ModelId    string             `json:"model_id"`
+	ModelName  string             `json:"model_name"`
+	Cron       string             `json:"cron"`
+	Enabled    bool               `json:"enabled"`
+	NextRunAt  time.Time          `json:"next_run_at"`
+	Runs       []trainScheduleRun `json:"runs"`
+}
+
+func TestTrainSchedule(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := user.trainNdbDummyFile("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, base), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	createDeploymentLogs(t, env, base)
+
+	var res map[string]string
+	body := map[string]string{"model_id": base, "model_name": "nightly", "cron": "0 2 * * *"}
+	if err := user.Post("/train/schedules").Json(body).Do(&res); err != nil {
+		t.Fatal(err)
+	}
+	scheduleId := res["schedule_id"]
+
+	getSchedule := func() trainScheduleInfo {
+		var info trainScheduleInfo
+		if err := user.Get(fmt.Sprintf("/train/schedules/%v", scheduleId)).Do(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	info := getSchedule()
+	if info.ModelId != base || info.Cron != "0 2 * * *" || !info.Enabled || len(info.Runs) != 0 {
+		t.Fatalf("invalid schedule info: %+v", info)
+	}
+	if next := info.NextRunAt.UTC(); next.Hour() != 2 || next.Minute() != 0 || !next.After(time.Now()) {
+		t.Fatalf("invalid next run: %v", next)
+	}
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	defer env.modelBazaar.StopJobStatusSync()
+
+	// Moves the next run of the schedule into the past and waits for the status
+	// sync to run it. Retrained models are named with the second of the run, so
+	// runs are kept at least a second apart.
+	runDue := func() {
+		time.Sleep(time.Second)
+		if err := env.db.Model(&schema.TrainSchedule{}).Where("id = ?", scheduleId).Update("next_run_at", time.Now().Add(-time.Minute)).Error; err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	runDue()
+
+	info = getSchedule()
+	if len(info.Runs) != 1 || info.Runs[0].Status != "started" || info.Runs[0].ModelId == nil {
+		t.Fatalf("scheduled retrain should start: %+v", info.Runs)
+	}
+	if !info.NextRunAt.After(time.Now()) {
+		t.Fatalf("next run should be moved forward: %v", info.NextRunAt)
+	}
+	retrained := *info.Runs[0].ModelId
+
+	var model schema.Model
+	if err := env.db.First(&model, "id = ?", retrained).Error; err != nil {
+		t.Fatal(err)
+	}
+	if model.BaseModelId == nil || model.BaseModelId.String() != base {
+		t.Fatalf("scheduled retrain should use the base model: %v", model.BaseModelId)
+	}
+
+	// The retrain from the first run has not finished.
+	runDue()
+
+	info = getSchedule()
+	if len(info.Runs) != 2 || info.Runs[0].Status != "skipped" || info.Runs[0].ModelId != nil {
+		t.Fatalf("run should be skipped while the previous retrain is in progress: %+v", info.Runs)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, retrained), "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	runDue()
+
+	info = getSchedule()
+	if len(info.Runs) != 3 || info.Runs[0].Status != "started" || *info.Runs[0].ModelId == retrained {
+		t.Fatalf("run should start after the previous retrain completes: %+v", info.Runs)
+	}
+	if info.Runs[2].TrainStatus != "complete" {
+		t.Fatalf("runs should include the status of their models: %+v", info.Runs)
+	}
+
+	var updated trainScheduleInfo
+	if err := user.Patch(fmt.Sprintf("/train/schedules/%v", scheduleId)).Json(map[string]interface{}{"enabled": false}).Do(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Enabled {
+		t.Fatal("schedule should be disabled")
+	}
+
+	runDue()
+
+	if info := getSchedule(); len(info.Runs) != 3 {
+		t.Fatalf("disabled schedules should not run: %+v", info.Runs)
+	}
+
+	var schedules []trainScheduleInfo
+	if err := user.Get("/train/schedules").Do(&schedules); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].ScheduleId != scheduleId {
+		t.Fatalf("invalid schedules: %+v", schedules)
+	}
+
+	if err := user.Delete(fmt.Sprintf("/train/schedules/%v", scheduleId)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Get(fmt.Sprintf("/train/schedules/%v", scheduleId)).Do(nil); err == nil {
+		t.Fatal("schedule should be deleted")
+	}
+	var runs int64
+	if err := env.db.Model(&schema.TrainScheduleRun{}).Where("schedule_id = ?", scheduleId).Count(&runs).Error; err != nil || runs != 0 {
+		t.Fatalf("schedule runs should be deleted: %v %v", runs, err)
+	}
+}
+
+func TestTrainScheduleValidation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := user.trainNdbDummyFile("model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := errorResponse(t, user, "POST", "/train/schedules", map[string]interface{}{
+		"model_id": model, "cron": "0 25 * * *", "options": map[string]interface{}{"abc": 1},
+	})
+	if status != http.StatusUnprocessableEntity || res.Code != utils.ErrorCodeInvalidRequest || len(res.Fields) != 1 || res.Fields[0].Field != "cron" {
+		t.Fatalf("invalid cron spec should be rejected: %v %+v", status, res)
+	}
+
+	status, res = errorResponse(t, user, "POST", "/train/schedules", map[string]interface{}{
+		"model_id": model, "cron": "@daily", "options": map[string]interface{}{"abc": 1},
+	})
+	if status != http.StatusUnprocessableEntity || len(res.Fields) != 1 || res.Fields[0].Field != "options" {
+		t.Fatalf("invalid retrain options should be rejected: %v %+v", status, res)
+	}
+
+	status, _ = errorResponse(t, other, "POST", "/train/schedules", map[string]interface{}{"model_id": model, "cron": "@daily"})
+	if status != http.StatusForbidden {
+		t.Fatalf("users without access to the model should not be able to schedule it: %v", status)
+	}
+
+	var created map[string]string
+	if err := user.Post("/train/schedules").Json(map[string]string{"model_id": model, "cron": "@daily"}).Do(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = errorResponse(t, other, "GET", fmt.Sprintf("/train/schedules/%v", created["schedule_id"]), nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("users should not be able to access other users' schedules: %v", status)
+	}
+
+	var schedules []trainScheduleInfo
+	if err := other.Get("/train/schedules").Do(&schedules); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 0 {
+		t.Fatalf("users should only list their own schedules: %+v", schedules)
+	}
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Get("/train/schedules").Do(&schedules); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].ModelName != "model" {
+		t.Fatalf("admins should list all schedules: %+v", schedules)
+	}
+}
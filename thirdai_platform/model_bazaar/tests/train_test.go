@@ -2,13 +2,19 @@ package tests
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/services"
 	"thirdai_platform/model_bazaar/storage"
 	"time"
 
@@ -76,6 +82,156 @@ func TestTrain(t *testing.T) {
 	}
 }
 
+func TestTrainProgress(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+
+	status, err := client.trainStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Progress != nil {
+		t.Fatal("progress should not be set before the job reports it")
+	}
+
+	updateProgress := func(params map[string]interface{}) error {
+		return client.Post("/train/update-progress").Auth(jobToken).Json(params).Do(nil)
+	}
+
+	err = updateProgress(map[string]interface{}{"phase": "indexing", "step": 30, "total_steps": 40, "eta_seconds": 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = client.trainStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := status.Progress
+	if progress == nil || progress.Phase != "indexing" || progress.Step != 30 || progress.TotalSteps != 40 || progress.Percent != 75 || *progress.EtaSeconds != 120 {
+		t.Fatalf("invalid progress: %v", progress)
+	}
+
+	if err := updateProgress(map[string]interface{}{"step": 50, "total_steps": 40}); err == nil {
+		t.Fatal("step cannot exceed total steps")
+	}
+	if err := updateProgress(map[string]interface{}{"percent": 120}); err == nil {
+		t.Fatal("percent cannot exceed 100")
+	}
+
+	if err := updateTrainStatus(client, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = client.trainStatus(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Progress == nil || status.Progress.Percent != 100 || *status.Progress.EtaSeconds != 0 {
+		t.Fatalf("progress should be finished for complete jobs: %v", status.Progress)
+	}
+}
+
+func TestTrainFileValidation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := services.NdbTrainRequest{
+		ModelName:    "xyz",
+		ModelOptions: &config.NdbOptions{},
+		Data: config.NDBData{
+			UnsupervisedFiles: []config.TrainFile{{Path: "n/a", Location: "s3"}},
+			FileValidation:    "lenient",
+		},
+	}
+	if err := client.Post("/train/ndb").Json(body).Do(nil); err == nil {
+		t.Fatal("invalid file validation mode should be rejected")
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configFile.Close()
+
+	var trainConfig struct {
+		Data config.NDBData `json:"data"`
+	}
+	if err := json.NewDecoder(configFile).Decode(&trainConfig); err != nil {
+		t.Fatal(err)
+	}
+	if trainConfig.Data.FileValidation != config.FileValidationSkipAndReport {
+		t.Fatalf("invalid default file validation mode for ndb: %v", trainConfig.Data.FileValidation)
+	}
+}
+
+func TestTrainLogArchival(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+
+	if err := updateTrainStatus(client, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond) // Ensure status sync runs
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(300 * time.Millisecond) // Ensure status sync stops
+
+	env.nomad.Clear() // Make it look like the job was garbage collected
+
+	logs, err := client.trainLogs(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Stdout != "logs for train-ndb-"+model {
+		t.Fatalf("invalid archived logs: %v", logs)
+	}
+
+	if err := client.deleteModel(model); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := env.storage.Exists(storage.JobLogArchiveDir(uuid.MustParse(model)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("job log archive should be deleted with model")
+	}
+}
+
 func createUploadBody(t *testing.T, files []struct{ name, data string }) (io.Reader, string) {
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
@@ -163,6 +319,236 @@ func TestFileUpload(t *testing.T) {
 	}
 }
 
+func (c *client) trainNlpTextWithThresholds(name string, thresholds map[string]float32) (string, error) {
+	testSplit := float32(0.2)
+	body := services.NlpTextTrainRequest{
+		ModelName: name,
+		ModelOptions: &config.NlpTextOptions{
+			TextColumn: "text", LabelColumn: "label", NTargetClasses: 2,
+		},
+		Data: config.NlpData{
+			SupervisedFiles: []config.TrainFile{{Path: "a.csv", Location: "s3"}},
+		},
+		TrainOptions: config.NlpTrainOptions{TestSplit: &testSplit, EvalThresholds: thresholds},
+	}
+	var res map[string]string
+	err := c.Post("/train/nlp-text").Json(body).Do(&res)
+	return res["model_id"], err
+}
+
+func TestHoldoutEvaluation(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNlpTextWithThresholds("xyz", map[string]float32{"precision@1": 0.8, "recall@1": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+
+	if err := updateTrainStatus(client, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The thresholds in the request are ignored, the thresholds from the train
+	// config are used.
+	updateEvaluation := func(metrics map[string]float64) error {
+		params := map[string]interface{}{
+			"split":      map[string]interface{}{"test_split": 0.2, "seed": 42},
+			"metrics":    metrics,
+			"thresholds": map[string]float64{"precision@1": 0.1},
+		}
+		return client.Post("/train/update-evaluation").Auth(jobToken).Json(params).Do(nil)
+	}
+
+	getEvaluation := func() map[string]interface{} {
+		var res map[string]interface{}
+		if err := client.Get(fmt.Sprintf("/train/%v/evaluation", model)).Do(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if err := client.Get(fmt.Sprintf("/train/%v/evaluation", model)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected 404 before evaluation is reported, got %v", err)
+	}
+
+	if err := updateEvaluation(map[string]float64{"precision@1": 0.7}); err != nil {
+		t.Fatal(err)
+	}
+
+	evaluation := getEvaluation()
+	failed := evaluation["failed_metrics"].([]interface{})
+	if evaluation["passed"].(bool) || len(failed) != 2 || failed[0] != "precision@1" || failed[1] != "recall@1" {
+		t.Fatalf("invalid evaluation %v", evaluation)
+	}
+
+	err = client.deploy(model)
+	if err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "holdout evaluation thresholds") {
+		t.Fatalf("deployment should be blocked by failed evaluation, got %v", err)
+	}
+
+	if err := updateEvaluation(map[string]float64{"precision@1": 0.9, "recall@1": 0.6}); err != nil {
+		t.Fatal(err)
+	}
+	evaluation = getEvaluation()
+	thresholds := evaluation["thresholds"].(map[string]interface{})
+	if !evaluation["passed"].(bool) || len(thresholds) != 2 || thresholds["precision@1"] != 0.8 || thresholds["recall@1"] != 0.5 {
+		t.Fatalf("invalid evaluation %v", evaluation)
+	}
+
+	if err := client.deploy(model); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoldoutEvaluationIgnoreThresholds(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNlpTextWithThresholds("xyz", map[string]float32{"precision@1": 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobToken := getJobAuthToken(env, t, model)
+
+	if err := updateTrainStatus(client, jobToken, "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]interface{}{
+		"split":   map[string]interface{}{"test_files": []string{"test.csv"}},
+		"metrics": map[string]float64{"precision@1": 0.1},
+	}
+	if err := client.Post("/train/update-evaluation").Auth(jobToken).Json(params).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.deploy(model); err == nil {
+		t.Fatal("deployment should be blocked by failed evaluation")
+	}
+
+	err = client.Post(fmt.Sprintf("/deploy/%v", model)).Json(map[string]bool{"ignore_eval_thresholds": true}).Do(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDocDirUpload(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user1, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user2, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const validPdf = "%PDF-1.4\n1 0 obj << /Type /Pages /Kids [2 0 R] /Count 1 >> endobj\n2 0 obj << /Type /Page /Parent 1 0 R >> endobj\n%%EOF"
+	const noPagesPdf = "%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%EOF"
+
+	upload := func(uploadId, subDir string, files []struct{ name, data string }) string {
+		body, contentType := createUploadBody(t, files)
+		endpoint := "/train/upload-data?sub_dir=" + subDir
+		if uploadId != "" {
+			endpoint += "&upload_id=" + uploadId
+		}
+		var res map[string]string
+		if err := user1.Post(endpoint).Header("Content-Type", contentType).Body(body).Do(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res["upload_id"]
+	}
+
+	pdfs := func(prefix string, n int) []struct{ name, data string } {
+		files := []struct{ name, data string }{}
+		for i := 0; i < n; i++ {
+			files = append(files, struct{ name, data string }{fmt.Sprintf("%s_%d.pdf", prefix, i), validPdf})
+		}
+		return files
+	}
+
+	verify := func(c client, uploadId string) (map[string]interface{}, error) {
+		var res map[string]interface{}
+		err := c.Post("/train/verify-doc-dir").Json(map[string]string{"upload_id": uploadId}).Do(&res)
+		return res, err
+	}
+
+	uploadId := upload("", "invoices", pdfs("invoice", 10))
+	if id := upload(uploadId, "receipts", pdfs("receipt", 10)); id != uploadId {
+		t.Fatalf("expected files to be added to upload %v, got %v", uploadId, id)
+	}
+
+	var info struct {
+		Files []string `json:"files"`
+	}
+	if err := user1.Get("/train/upload/" + uploadId).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Files) != 20 || info.Files[0] != "invoice_0.pdf" || info.Files[19] != "receipt_9.pdf" {
+		t.Fatalf("upload should list the files from both requests: %v", info.Files)
+	}
+
+	res, err := verify(user1, uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories := res["categories"].(map[string]interface{})
+	if !res["is_valid"].(bool) || len(categories) != 2 || len(categories["invoices"].([]interface{})) != 10 {
+		t.Fatalf("invalid response %v", res)
+	}
+
+	upload(uploadId, "receipts", []struct{ name, data string }{
+		{"empty.pdf", ""}, {"fake.pdf", "not a pdf"}, {"blank.pdf", noPagesPdf}, {"notes.txt", "abc"},
+	})
+
+	res, err = verify(user1, uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidFiles := res["invalid_files"].(map[string]interface{})
+	expected := map[string]string{
+		"receipts/empty.pdf": "file is empty",
+		"receipts/fake.pdf":  "file is not a valid pdf",
+		"receipts/blank.pdf": "pdf has no pages",
+		"receipts/notes.txt": "only .pdf files are supported",
+	}
+	if res["is_valid"].(bool) || len(invalidFiles) != len(expected) {
+		t.Fatalf("invalid response %v", res)
+	}
+	for file, reason := range expected {
+		if invalidFiles[file] != reason {
+			t.Fatalf("expected %v to be invalid with reason '%v', got %v", file, reason, invalidFiles[file])
+		}
+	}
+	if len(res["categories"].(map[string]interface{})["receipts"].([]interface{})) != 10 {
+		t.Fatalf("invalid files should not be included in categories: %v", res)
+	}
+
+	if _, err := verify(user2, uploadId); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+
+	body, contentType := createUploadBody(t, pdfs("other", 1))
+	err = user2.Post("/train/upload-data?upload_id="+uploadId).Header("Content-Type", contentType).Body(body).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+}
+
 func TestUseUploadInTrain(t *testing.T) {
 	env := setupTestEnv(t)
 
@@ -193,12 +579,332 @@ func TestUseUploadInTrain(t *testing.T) {
 		t.Fatalf("user cannot access another user's upload: %v", err)
 	}
 
+	var info services.UploadInfo
+	if err := user1.Get(fmt.Sprintf("/train/upload/%v", uploadRes["upload_id"])).Do(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.UploadId.String() != uploadRes["upload_id"] || !slices.Equal(info.Files, []string{"a.pdf"}) {
+		t.Fatalf("invalid upload info %+v", info)
+	}
+	if err := user2.Get(fmt.Sprintf("/train/upload/%v", uploadRes["upload_id"])).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("user cannot get info for another user's upload: %v", err)
+	}
+
 	_, err = user1.trainNdb("bad-upload", config.TrainFile{Location: "upload", Path: uploadRes["upload_id"]})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestNlpTrainColumnMapping(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, contentType := createUploadBody(t, []struct{ name, data string }{
+		{"reviews.csv", "id;review ;sentiment\n1;<b>Great</b> product;positive\n2;Not good;negative"},
+	})
+	var uploadRes map[string]string
+	err = client.Post("/train/upload-data").Header("Content-Type", contentType).Body(body).Do(&uploadRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	train := func(textColumn, labelColumn string) (string, error) {
+		body := services.NlpTextTrainRequest{
+			ModelName: "reviews",
+			ModelOptions: &config.NlpTextOptions{
+				TextColumn:     textColumn,
+				LabelColumn:    labelColumn,
+				NTargetClasses: 2,
+				Delimiter:      ";",
+				Preprocessing:  config.TextPreprocessing{Lowercase: true, StripHtml: true},
+			},
+			Data: config.NlpData{
+				SupervisedFiles: []config.TrainFile{{Path: uploadRes["upload_id"], Location: "upload"}},
+			},
+		}
+		var res map[string]string
+		err := client.Post("/train/nlp-text").Json(body).Do(&res)
+		return res["model_id"], err
+	}
+
+	if _, err := train("text", "labels"); err == nil || !strings.Contains(err.Error(), "status 422") || !strings.Contains(err.Error(), "does not contain column 'text'") {
+		t.Fatalf("columns missing from the upload should be rejected: %v", err)
+	}
+
+	if _, err := train("review", "review"); err == nil || !strings.Contains(err.Error(), "must be different") {
+		t.Fatalf("text and label columns must be different: %v", err)
+	}
+
+	model, err := train("review", "sentiment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configFile.Close()
+
+	var trainConfig struct {
+		ModelOptions config.NlpTextOptions `json:"model_options"`
+	}
+	if err := json.NewDecoder(configFile).Decode(&trainConfig); err != nil {
+		t.Fatal(err)
+	}
+	if !trainConfig.ModelOptions.Preprocessing.Lowercase || !trainConfig.ModelOptions.Preprocessing.StripHtml {
+		t.Fatalf("preprocessing options should be passed to the train job: %+v", trainConfig.ModelOptions)
+	}
+}
+
+func TestNlpTrainImbalanceOptions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	train := func(options config.ImbalanceOptions) (string, error) {
+		body := services.NlpTextTrainRequest{
+			ModelName: "imbalanced",
+			ModelOptions: &config.NlpTextOptions{
+				TextColumn: "text", LabelColumn: "label", NTargetClasses: 2,
+			},
+			Data: config.NlpData{
+				SupervisedFiles: []config.TrainFile{{Path: "a.csv", Location: "s3"}},
+			},
+			TrainOptions: config.NlpTrainOptions{ImbalanceOptions: options},
+		}
+		var res map[string]string
+		err := client.Post("/train/nlp-text").Json(body).Do(&res)
+		return res["model_id"], err
+	}
+
+	invalid := []config.ImbalanceOptions{
+		{ClassWeighting: "inverse"},
+		{ClassWeighting: config.ClassWeightingCustom},
+		{ClassWeighting: config.ClassWeightingBalanced, ClassWeights: map[string]float32{"a": 2}},
+		{ClassWeights: map[string]float32{"a": 0}},
+		{Oversampling: "smote"},
+		{Oversampling: config.OversamplingRandom, OversamplingRatio: 1.5},
+		{OversamplingRatio: 0.5},
+	}
+	for _, options := range invalid {
+		if _, err := train(options); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid imbalance options should be rejected: %+v %v", options, err)
+		}
+	}
+
+	model, err := train(config.ImbalanceOptions{ClassWeights: map[string]float32{"a": 2}, Oversampling: config.OversamplingRandom})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configFile.Close()
+
+	var trainConfig struct {
+		TrainOptions config.NlpTrainOptions `json:"train_options"`
+	}
+	if err := json.NewDecoder(configFile).Decode(&trainConfig); err != nil {
+		t.Fatal(err)
+	}
+	options := trainConfig.TrainOptions.ImbalanceOptions
+	if options.ClassWeighting != config.ClassWeightingCustom || options.ClassWeights["a"] != 2 || options.OversamplingRatio != config.DefaultOversamplingRatio {
+		t.Fatalf("invalid imbalance options: %+v", options)
+	}
+}
+
+func TestNlpTrainEvaluationOptions(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSplit := float32(0.2)
+	train := func(options config.NlpTrainOptions, docClassification bool) (string, error) {
+		body := services.NlpTextTrainRequest{
+			ModelName:         "evaluated",
+			DocClassification: docClassification,
+			ModelOptions: &config.NlpTextOptions{
+				TextColumn: "text", LabelColumn: "label", NTargetClasses: 2,
+			},
+			Data: config.NlpData{
+				SupervisedFiles: []config.TrainFile{{Path: "a.csv", Location: "s3"}},
+			},
+			TrainOptions: options,
+		}
+		var res map[string]string
+		err := client.Post("/train/nlp-text").Json(body).Do(&res)
+		return res["model_id"], err
+	}
+
+	gap, drop := float32(0.1), float32(1.5)
+	invalid := []config.NlpTrainOptions{
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{}},
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{Perturbations: []string{"shuffle"}}},
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{Perturbations: []string{"typos", "typos"}}},
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{Perturbations: []string{"typos"}, MaxPerturbationDrop: &drop}},
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{Perturbations: []string{"typos"}, MaxGroupGap: &gap}},
+		{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{GroupColumn: "region", MinGroupSize: -1}},
+		{Evaluation: &config.EvaluationOptions{GroupColumn: "region"}},
+	}
+	for _, options := range invalid {
+		if _, err := train(options, false); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid evaluation options should be rejected: %+v %v", options.Evaluation, err)
+		}
+	}
+
+	if _, err := train(config.NlpTrainOptions{TestSplit: &testSplit, Evaluation: &config.EvaluationOptions{Perturbations: []string{"typos"}}}, true); err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("evaluation should be rejected for doc classification: %v", err)
+	}
+
+	model, err := train(config.NlpTrainOptions{
+		TestSplit:  &testSplit,
+		Evaluation: &config.EvaluationOptions{GroupColumn: "region", MaxGroupGap: &gap, Perturbations: []string{"lowercase", "typos"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer configFile.Close()
+
+	var trainConfig struct {
+		TrainOptions config.NlpTrainOptions `json:"train_options"`
+	}
+	if err := json.NewDecoder(configFile).Decode(&trainConfig); err != nil {
+		t.Fatal(err)
+	}
+	evaluation := trainConfig.TrainOptions.Evaluation
+	if evaluation == nil || evaluation.GroupColumn != "region" || evaluation.MinGroupSize != config.DefaultMinGroupSize ||
+		evaluation.MaxGroupGap == nil || *evaluation.MaxGroupGap != gap || len(evaluation.Perturbations) != 2 {
+		t.Fatalf("invalid evaluation options: %+v", evaluation)
+	}
+}
+
+func TestNlpTrainStratifiedSplit(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := "label;text\n"
+	for i := 0; i < 10; i++ {
+		rows += fmt.Sprintf("a;text a %d\nb;text b %d\n", i, i)
+	}
+	rows += "c;text c\n"
+
+	body, contentType := createUploadBody(t, []struct{ name, data string }{{"data.csv", rows}})
+	var uploadRes map[string]string
+	err = client.Post("/train/upload-data").Header("Content-Type", contentType).Body(body).Do(&uploadRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	train := func(name string, file config.TrainFile, seed *int) (string, error) {
+		split := float32(0.2)
+		body := services.NlpTextTrainRequest{
+			ModelName: name,
+			ModelOptions: &config.NlpTextOptions{
+				TextColumn: "text", LabelColumn: "label", NTargetClasses: 3, Delimiter: ";",
+			},
+			Data: config.NlpData{SupervisedFiles: []config.TrainFile{file}},
+			TrainOptions: config.NlpTrainOptions{
+				TestSplit: &split, TestSplitSeed: seed, TestSplitStrategy: config.TestSplitStratified,
+			},
+		}
+		var res map[string]string
+		err := client.Post("/train/nlp-text").Json(body).Do(&res)
+		return res["model_id"], err
+	}
+
+	if _, err := train("s3", config.TrainFile{Path: "a.csv", Location: "s3"}, nil); err == nil || !strings.Contains(err.Error(), "can only be used with uploaded train files") {
+		t.Fatalf("stratified split should require uploads: %v", err)
+	}
+
+	readConfig := func(model string) (config.NlpData, config.NlpTrainOptions) {
+		configFile, err := env.storage.Read(filepath.Join(storage.ModelPath(uuid.MustParse(model)), "train_config.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer configFile.Close()
+
+		var trainConfig struct {
+			Data         config.NlpData         `json:"data"`
+			TrainOptions config.NlpTrainOptions `json:"train_options"`
+		}
+		if err := json.NewDecoder(configFile).Decode(&trainConfig); err != nil {
+			t.Fatal(err)
+		}
+		return trainConfig.Data, trainConfig.TrainOptions
+	}
+
+	readSplit := func(file config.TrainFile) string {
+		path, err := filepath.Rel(env.storage.Location(), file.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := env.storage.Read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer data.Close()
+		content, err := io.ReadAll(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	upload := config.TrainFile{Path: uploadRes["upload_id"], Location: "upload"}
+
+	model, err := train("stratified", upload, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, trainOptions := readConfig(model)
+	if trainOptions.TestSplitSeed == nil || len(data.SupervisedFiles) != 1 || len(data.TestFiles) != 1 {
+		t.Fatalf("invalid split config: %+v %+v", data, trainOptions)
+	}
+
+	test := readSplit(data.TestFiles[0])
+	trainSplit := readSplit(data.SupervisedFiles[0])
+	if strings.Count(test, ";a\n") != 2 || strings.Count(test, ";b\n") != 2 || strings.Count(test, ";c\n") != 0 {
+		t.Fatalf("test split is not stratified: %q", test)
+	}
+	if !strings.HasPrefix(test, "text;label\n") || strings.Count(trainSplit, "\n") != 18 {
+		t.Fatalf("invalid train split: %q", trainSplit)
+	}
+
+	// The same seed reproduces the split.
+	seed := *trainOptions.TestSplitSeed
+	model, err = train("stratified-seed", upload, &seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = readConfig(model)
+	if readSplit(data.TestFiles[0]) != test {
+		t.Fatal("split should be reproducible with the same seed")
+	}
+}
+
 func TestTrainReport(t *testing.T) {
 	env := setupTestEnv(t)
 
@@ -245,3 +951,83 @@ func TestTrainReport(t *testing.T) {
 		t.Fatal("invalid report data")
 	}
 }
+
+func TestTrainLogStream(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := client.streamTrainLogs(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(
+		"data: {\"stream\":\"stdout\",\"line\":\"logs for train-ndb-%[1]v\"}\n\ndata: {\"stream\":\"stderr\",\"line\":\"warnings for train-ndb-%[1]v\"}\n\nevent: end\n", model,
+	)
+	if !strings.Contains(events, expected) {
+		t.Fatalf("invalid log stream events: %v", events)
+	}
+
+	other, err := env.newUser("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.streamTrainLogs(model); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("user without access to the model should not stream its logs: %v", err)
+	}
+
+	env.nomad.Clear() // Make it look like the job exited
+
+	if _, err := client.streamTrainLogs(model); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("logs should not be streamed once the job is not running: %v", err)
+	}
+}
+
+func TestTrainLogStreamOutlivesWriteTimeout(t *testing.T) {
+	env := setupTestEnv(t)
+
+	client, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := client.trainNdbDummyFile("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.StripPrefix("/api/v2", env.api))
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	// The job writes its last line after the server's write timeout.
+	env.nomad.logDelay = 500 * time.Millisecond
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/api/v2/train/%v/logs/stream", server.URL, model), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", client.authToken))
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	events, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("log stream should not be closed by the write timeout: %v", err)
+	}
+	if !strings.Contains(string(events), "warnings for train-ndb-") || !strings.Contains(string(events), "event: end\n") {
+		t.Fatalf("log stream should include the lines written after the write timeout: %s", events)
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"thirdai_platform/model_bazaar/services"
+	"time"
+)
+
+type webhookReceiver struct {
+	lock     sync.Mutex
+	payloads []services.WebhookPayload
+	errors   []string
+}
+
+func (r *webhookReceiver) handler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		timestamp := req.Header.Get(services.WebhookTimestampHeader)
+		if req.Header.Get(services.WebhookSignatureHeader) != services.WebhookSignature(secret, timestamp, body) {
+			r.errors = append(r.errors, "invalid signature")
+		}
+
+		var payload services.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			r.errors = append(r.errors, err.Error())
+		}
+		if req.Header.Get(services.WebhookEventHeader) != payload.Event {
+			r.errors = append(r.errors, "event header does not match payload")
+		}
+		r.payloads = append(r.payloads, payload)
+	}
+}
+
+func (r *webhookReceiver) await(t *testing.T, n int) []services.WebhookPayload {
+	for i := 0; i < 40; i++ {
+		r.lock.Lock()
+		received := len(r.payloads)
+		r.lock.Unlock()
+		if received >= n {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.errors) > 0 {
+		t.Fatalf("invalid webhook requests: %v", r.errors)
+	}
+	if len(r.payloads) != n {
+		t.Fatalf("expected %d webhook requests, received %v", n, r.payloads)
+	}
+	return append([]services.WebhookPayload{}, r.payloads...)
+}
+
+func TestWebhooks(t *testing.T) {
+	env := setupTestEnv(t)
+
+	admin, err := env.adminClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver.handler("my-secret"))
+	defer server.Close()
+
+	body := map[string]interface{}{
+		"name":   "alerts",
+		"url":    server.URL,
+		"events": []string{"train.failed", "deploy.*"},
+		"secret": "my-secret",
+	}
+
+	if err := user.Post("/webhooks").Json(body).Do(nil); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Fatalf("only admins should create webhooks, got %v", err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"name": "a", "url": "ftp://host", "events": []string{"train.failed"}},
+		{"name": "a", "url": server.URL, "events": []string{}},
+		{"name": "a", "url": server.URL, "events": []string{"train.done"}},
+		{"name": "a", "url": server.URL, "events": []string{"model.failed"}},
+	} {
+		if err := admin.Post("/webhooks").Json(invalid).Do(nil); err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("invalid webhook %v should be rejected, got %v", invalid, err)
+		}
+	}
+
+	var created services.WebhookInfo
+	if err := admin.Post("/webhooks").Json(body).Do(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret != "my-secret" || len(created.Events) != 2 {
+		t.Fatalf("invalid webhook %v", created)
+	}
+
+	failed, err := user.trainNdbDummyFile("failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete, err := user.trainNdbDummyFile("complete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, complete), "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, failed), "failed"); err != nil {
+		t.Fatal(err)
+	}
+	// Reporting the same status again is not a change.
+	if err := updateTrainStatus(user, getJobAuthToken(env, t, failed), "failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := receiver.await(t, 1)
+	if payloads[0].Event != "train.failed" || payloads[0].ModelId.String() != failed || payloads[0].ModelName != "failed" ||
+		payloads[0].Job != "train" || payloads[0].PreviousStatus != "starting" || !strings.Contains(payloads[0].Text, "failed") {
+		t.Fatalf("invalid webhook payload %v", payloads[0])
+	}
+
+	// The status sync notifies webhooks of the failures it detects.
+	syncFailed, err := user.trainNdbDummyFile("sync-failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.nomad.Clear()
+
+	go env.modelBazaar.JobStatusSync(100 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	env.modelBazaar.StopJobStatusSync()
+	time.Sleep(100 * time.Millisecond)
+
+	payloads = receiver.await(t, 2)
+	if payloads[1].Event != "train.failed" || payloads[1].ModelId.String() != syncFailed || payloads[1].Message != "job is dead in the orchestrator" {
+		t.Fatalf("invalid webhook payload %v", payloads[1])
+	}
+
+	var webhooks []services.WebhookInfo
+	if err := admin.Get("/webhooks").Do(&webhooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].Secret != "" || webhooks[0].LastDeliveryAt == nil || webhooks[0].LastError != "" {
+		t.Fatalf("invalid webhooks %v", webhooks)
+	}
+
+	if err := admin.Delete(fmt.Sprintf("/webhooks/%v", created.Id)).Do(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Delete(fmt.Sprintf("/webhooks/%v", created.Id)).Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected 404 deleting missing webhook, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"thirdai_platform/model_bazaar/config"
+	"thirdai_platform/model_bazaar/services"
+
+	"github.com/google/uuid"
+)
+
+func TestEnsembleWorkflow(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := []string{}
+	for _, name := range []string{"text-a", "text-b", "text-c"} {
+		model, err := user.trainNlpText(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainStatus(user, getJobAuthToken(env, t, model), "complete"); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, model)
+	}
+
+	token, err := user.trainNlpToken("token-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := env.newUser("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := other.trainNlpText("private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		models   []string
+		strategy string
+		status   string
+	}{
+		{models: members[:1], strategy: "majority_vote", status: "status 422"},
+		{models: []string{members[0], members[0]}, strategy: "majority_vote", status: "status 422"},
+		{models: members, strategy: "median", status: "status 422"},
+		{models: []string{members[0], token}, strategy: "majority_vote", status: "status 422"},
+		{models: []string{members[0], private}, strategy: "majority_vote", status: "status 403"},
+	} {
+		_, err := user.createEnsemble("bad-ensemble", tc.models, tc.strategy)
+		if err == nil || !strings.Contains(err.Error(), tc.status) {
+			t.Fatalf("expected %v for ensemble %v with strategy %v, got %v", tc.status, tc.models, tc.strategy, err)
+		}
+	}
+
+	ensemble, err := user.createEnsemble("ensemble", members, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := user.modelInfo(ensemble)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortModelDeps(info)
+	if info.Type != "ensemble" || info.TrainStatus != "complete" || len(info.Dependencies) != 3 || info.Dependencies[0].ModelId.String() != members[0] {
+		t.Fatalf("invalid ensemble model: %v", info)
+	}
+	if info.Attributes["strategy"] != "score_average" || info.Attributes["model_ids"] != strings.Join(members, ",") {
+		t.Fatalf("invalid ensemble attributes: %v", info.Attributes)
+	}
+
+	err = user.deleteModel(members[1])
+	if err == nil || !strings.Contains(err.Error(), "it is used as a dependency by 1 other model") {
+		t.Fatal("cannot delete ensemble member")
+	}
+
+	// Deploying the ensemble should also deploy each of its members.
+	if err := user.deploy(ensemble); err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range append(members, ensemble) {
+		status, err := user.deployStatus(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != "starting" {
+			t.Fatalf("model %v should be deployed with the ensemble: %v", model, status)
+		}
+	}
+}
+
+func TestWorkflowTemplates(t *testing.T) {
+	env := setupTestEnv(t)
+
+	user, err := env.newUser("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var templates []services.WorkflowTemplateInfo
+	if err := user.Get("/workflow/templates").Do(&templates); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(templates))
+	for _, template := range templates {
+		names = append(names, template.Name)
+	}
+	if strings.Join(names, ",") != "document-search,contract-search-with-guardrails,support-ticket-classifier" {
+		t.Fatalf("invalid templates %v", names)
+	}
+
+	var template services.WorkflowTemplateInfo
+	if err := user.Get("/workflow/templates/contract-search-with-guardrails").Do(&template); err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Inputs) != 3 || template.Inputs[1].Name != "guardrail_id" || !template.Inputs[1].Required {
+		t.Fatalf("invalid template %+v", template)
+	}
+	if err := user.Get("/workflow/templates/missing").Do(nil); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected missing template to return 404: %v", err)
+	}
+
+	documents := []config.TrainFile{{Path: "contracts/a.pdf", Location: "s3"}}
+
+	// Inputs that the template does not use are rejected, as are missing inputs.
+	invalid := []map[string]interface{}{
+		{"model_name": "contracts", "documents": documents},
+		{"model_name": "contracts", "documents": documents, "guardrail_id": uuid.New(), "text_column": "text"},
+		{"documents": documents, "guardrail_id": uuid.New()},
+	}
+	for _, body := range invalid {
+		err := user.Post("/workflow/templates/contract-search-with-guardrails").Json(body).Do(nil)
+		if err == nil || !strings.Contains(err.Error(), "status 422") {
+			t.Fatalf("expected %v to be rejected: %v", body, err)
+		}
+	}
+
+	// The guardrail is checked before any models are created.
+	ndb, err := user.trainNdbDummyFile("not-a-guardrail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.Post("/workflow/templates/contract-search-with-guardrails").Json(map[string]interface{}{
+		"model_name": "contracts", "documents": documents, "guardrail_id": ndb,
+	}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 422") {
+		t.Fatalf("guardrail should be an nlp-token model: %v", err)
+	}
+	if models, err := user.listModels(); err != nil || len(models) != 1 {
+		t.Fatalf("no models should be created if the template is invalid: %v %v", models, err)
+	}
+
+	guardrail, err := user.trainNlpToken("guardrail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res services.WorkflowTemplateResponse
+	err = user.Post("/workflow/templates/contract-search-with-guardrails").Json(map[string]interface{}{
+		"model_name": "contracts", "documents": documents, "guardrail_id": guardrail, "llm_provider": "openai",
+	}).Do(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Models) != 2 || res.Models[0].ModelName != "contracts-retrieval" || res.Models[1].ModelId != res.ModelId {
+		t.Fatalf("invalid template response %+v", res)
+	}
+
+	search, err := user.modelInfo(res.ModelId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search.Type != "enterprise-search" || search.Attributes["retrieval_id"] != res.Models[0].ModelId.String() || search.Attributes["guardrail_id"] != guardrail || search.Attributes["llm_provider"] != "openai" {
+		t.Fatalf("invalid enterprise search model %+v", search)
+	}
+	retrieval, err := user.modelInfo(res.Models[0].ModelId.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retrieval.Type != "ndb" || retrieval.TrainStatus != "starting" {
+		t.Fatalf("invalid retrieval model %+v", retrieval)
+	}
+	if _, ok := env.nomad.submitted["train-ndb-"+retrieval.ModelId.String()]; !ok {
+		t.Fatal("train job should be started for the retrieval model")
+	}
+
+	// Instantiating a template again with the same name fails before training.
+	err = user.Post("/workflow/templates/document-search").Json(map[string]interface{}{"model_name": "contracts", "documents": documents}).Do(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 409") {
+		t.Fatalf("duplicate model names should be rejected: %v", err)
+	}
+
+	res = services.WorkflowTemplateResponse{}
+	err = user.Post("/workflow/templates/support-ticket-classifier").Json(map[string]interface{}{
+		"model_name":    "tickets",
+		"labeled_files": []config.TrainFile{{Path: "tickets.csv", Location: "s3"}},
+		"text_column":   "text", "label_column": "category", "n_target_classes": 4,
+	}).Do(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Models) != 1 || res.Models[0].ModelType != "nlp-text" || res.Models[0].ModelId != res.ModelId {
+		t.Fatalf("invalid template response %+v", res)
+	}
+}
@@ -9,10 +9,12 @@
 #include <vector>
 
 using thirdai::search::ndb::Chunk;
+using thirdai::search::ndb::Constraint;
 using thirdai::search::ndb::EqualTo;
 using thirdai::search::ndb::GreaterThan;
 using thirdai::search::ndb::LessThan;
 using thirdai::search::ndb::MetadataMap;
+using thirdai::search::ndb::MetadataType;
 using thirdai::search::ndb::MetadataValue;
 using thirdai::search::ndb::OnDiskNeuralDB;
 using thirdai::search::ndb::QueryConstraints;
@@ -131,21 +133,139 @@ void Constraints_free(Constraints_t *constraints) { delete constraints; }
 const int BinaryConstraintEq = 0;
 const int BinaryConstraintLt = 1;
 const int BinaryConstraintGt = 2;
+const int BinaryConstraintLte = 3;
+const int BinaryConstraintGte = 4;
+const int BinaryConstraintContains = 5;
+const int BinaryConstraintPrefix = 6;
 
-void Constraints_add_binary_constraint(Constraints_t *constraints, int op,
-                                       const char *key,
-                                       const MetadataValue_t *value) {
+namespace {
+
+class LessThanOrEqualTo final : public Constraint {
+public:
+  explicit LessThanOrEqualTo(MetadataValue value) : _value(std::move(value)) {}
+
+  bool matches(const MetadataValue &value) const final {
+    return value.lessThan(_value) || value.equals(_value);
+  }
+
+private:
+  MetadataValue _value;
+};
+
+class GreaterThanOrEqualTo final : public Constraint {
+public:
+  explicit GreaterThanOrEqualTo(MetadataValue value)
+      : _value(std::move(value)) {}
+
+  bool matches(const MetadataValue &value) const final {
+    return value.greaterThan(_value) || value.equals(_value);
+  }
+
+private:
+  MetadataValue _value;
+};
+
+// Matches string values that contain the substring, or start with it if
+// prefix is set. Values that are not strings never match.
+class Substring final : public Constraint {
+public:
+  Substring(MetadataValue value, bool prefix)
+      : _value(std::move(value)), _prefix(prefix) {}
+
+  bool matches(const MetadataValue &value) const final {
+    if (value.type() != MetadataType::Str ||
+        _value.type() != MetadataType::Str) {
+      return false;
+    }
+    if (_prefix) {
+      return value.asStr().rfind(_value.asStr(), 0) == 0;
+    }
+    return value.asStr().find(_value.asStr()) != std::string::npos;
+  }
+
+private:
+  MetadataValue _value;
+  bool _prefix;
+};
+
+// Combines the constraints on a single key, matching if all of them match, or
+// if any of them match.
+class Composite final : public Constraint {
+public:
+  explicit Composite(bool all) : _all(all) {}
+
+  void add(std::shared_ptr<Constraint> constraint) {
+    _constraints.push_back(std::move(constraint));
+  }
+
+  bool matches(const MetadataValue &value) const final {
+    for (const auto &constraint : _constraints) {
+      if (constraint->matches(value) != _all) {
+        return !_all;
+      }
+    }
+    return _all;
+  }
+
+private:
+  std::vector<std::shared_ptr<Constraint>> _constraints;
+  bool _all;
+};
+
+} // namespace
+
+struct Constraint_t {
+  std::shared_ptr<Constraint> constraint;
+  // Set if the constraint is a composite, so that children can be added.
+  std::shared_ptr<Composite> composite;
+};
+
+Constraint_t *Constraint_binary(int op, const MetadataValue_t *value) {
+  Constraint_t *out = new Constraint_t();
   switch (op) {
   case BinaryConstraintEq:
-    constraints->constraints[key] = EqualTo::make(value->value);
+    out->constraint = EqualTo::make(value->value);
     break;
   case BinaryConstraintLt:
-    constraints->constraints[key] = LessThan::make(value->value);
+    out->constraint = LessThan::make(value->value);
     break;
   case BinaryConstraintGt:
-    constraints->constraints[key] = GreaterThan::make(value->value);
+    out->constraint = GreaterThan::make(value->value);
+    break;
+  case BinaryConstraintLte:
+    out->constraint = std::make_shared<LessThanOrEqualTo>(value->value);
+    break;
+  case BinaryConstraintGte:
+    out->constraint = std::make_shared<GreaterThanOrEqualTo>(value->value);
+    break;
+  case BinaryConstraintContains:
+    out->constraint = std::make_shared<Substring>(value->value, false);
+    break;
+  case BinaryConstraintPrefix:
+    out->constraint = std::make_shared<Substring>(value->value, true);
     break;
   }
+  return out;
+}
+
+Constraint_t *Constraint_composite(bool all) {
+  Constraint_t *out = new Constraint_t();
+  out->composite = std::make_shared<Composite>(all);
+  out->constraint = out->composite;
+  return out;
+}
+
+void Constraint_add(Constraint_t *composite, const Constraint_t *constraint) {
+  if (composite->composite && constraint->constraint) {
+    composite->composite->add(constraint->constraint);
+  }
+}
+
+void Constraint_free(Constraint_t *constraint) { delete constraint; }
+
+void Constraints_add(Constraints_t *constraints, const char *key,
+                     const Constraint_t *constraint) {
+  constraints->constraints[key] = constraint->constraint;
 }
 
 struct QueryResults_t {
@@ -34,9 +34,14 @@ const char *MetadataList_str(MetadataList_t *metadata, unsigned int i);
 typedef struct Constraints_t Constraints_t;
 Constraints_t *Constraints_new();
 void Constraints_free(Constraints_t *constraints);
-void Constraints_add_binary_constraint(Constraints_t *constraints, int op,
-                                       const char *key,
-                                       const MetadataValue_t *value);
+
+typedef struct Constraint_t Constraint_t;
+Constraint_t *Constraint_binary(int op, const MetadataValue_t *value);
+Constraint_t *Constraint_composite(bool all);
+void Constraint_add(Constraint_t *composite, const Constraint_t *constraint);
+void Constraint_free(Constraint_t *constraint);
+void Constraints_add(Constraints_t *constraints, const char *key,
+                     const Constraint_t *constraint);
 
 typedef struct QueryResults_t QueryResults_t;
 unsigned int QueryResults_len(QueryResults_t *results);
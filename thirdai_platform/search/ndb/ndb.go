@@ -149,8 +149,10 @@ func (ndb *NeuralDB) Insert(document, docId string, chunks []string, metadata []
 	return nil
 }
 
+// Constraint filters the chunks returned by a query on the value of a metadata
+// key. Chunks that do not have the key never match.
 type Constraint interface {
-	addToConstraints(constraints *C.Constraints_t, key string) error
+	newConstraint() (*C.Constraint_t, error)
 }
 
 type binaryConstraintOp int8
@@ -159,6 +161,10 @@ const (
 	BinaryConstraintEq binaryConstraintOp = iota
 	BinaryConstraintLt
 	BinaryConstraintGt
+	BinaryConstraintLte
+	BinaryConstraintGte
+	BinaryConstraintContains
+	BinaryConstraintPrefix
 )
 
 type binaryConstraint struct {
@@ -166,19 +172,14 @@ type binaryConstraint struct {
 	op    binaryConstraintOp
 }
 
-func (c binaryConstraint) addToConstraints(constraints *C.Constraints_t, key string) error {
-	keyCStr := C.CString(key)
-	defer C.free(unsafe.Pointer(keyCStr))
-
+func (c binaryConstraint) newConstraint() (*C.Constraint_t, error) {
 	metadataValue, err := newMetadataValue(c.value)
 	if err != nil {
-		return fmt.Errorf("invalid constraint for key '%v': %w", key, err)
+		return nil, err
 	}
 	defer C.MetadataValue_free(metadataValue)
 
-	C.Constraints_add_binary_constraint(constraints, C.int(c.op), keyCStr, metadataValue)
-
-	return nil
+	return C.Constraint_binary(C.int(c.op), metadataValue), nil
 }
 
 func EqualTo(value interface{}) Constraint {
@@ -193,16 +194,81 @@ func GreaterThan(value interface{}) Constraint {
 	return binaryConstraint{value: value, op: BinaryConstraintGt}
 }
 
+func LessThanOrEqualTo(value interface{}) Constraint {
+	return binaryConstraint{value: value, op: BinaryConstraintLte}
+}
+
+func GreaterThanOrEqualTo(value interface{}) Constraint {
+	return binaryConstraint{value: value, op: BinaryConstraintGte}
+}
+
+// Contains matches string values that contain the substring.
+func Contains(substring string) Constraint {
+	return binaryConstraint{value: substring, op: BinaryConstraintContains}
+}
+
+// HasPrefix matches string values that start with the prefix.
+func HasPrefix(prefix string) Constraint {
+	return binaryConstraint{value: prefix, op: BinaryConstraintPrefix}
+}
+
+type compositeConstraint struct {
+	constraints []Constraint
+	all         bool
+}
+
+func (c compositeConstraint) newConstraint() (*C.Constraint_t, error) {
+	composite := C.Constraint_composite(C.bool(c.all))
+
+	for _, constraint := range c.constraints {
+		child, err := constraint.newConstraint()
+		if err != nil {
+			C.Constraint_free(composite)
+			return nil, err
+		}
+		C.Constraint_add(composite, child)
+		C.Constraint_free(child)
+	}
+
+	return composite, nil
+}
+
+// AllOf matches values that match every one of the constraints, for example
+// AllOf(GreaterThanOrEqualTo(1), LessThan(10)) for a range.
+func AllOf(constraints ...Constraint) Constraint {
+	return compositeConstraint{constraints: constraints, all: true}
+}
+
+// AnyOf matches values that match at least one of the constraints.
+func AnyOf(constraints ...Constraint) Constraint {
+	return compositeConstraint{constraints: constraints, all: false}
+}
+
+// In matches values that are equal to one of the values.
+func In(values ...interface{}) Constraint {
+	constraints := make([]Constraint, 0, len(values))
+	for _, value := range values {
+		constraints = append(constraints, EqualTo(value))
+	}
+	return AnyOf(constraints...)
+}
+
+// Constraints maps metadata keys to the constraint on their values, a chunk
+// must match the constraints for every key.
 type Constraints = map[string]Constraint
 
 func newConstraints(constraints Constraints) (*C.Constraints_t, error) {
 	constraintsMap := C.Constraints_new()
 
 	for k, v := range constraints {
-		err := v.addToConstraints(constraintsMap, k)
+		constraint, err := v.newConstraint()
 		if err != nil {
-			return nil, err
+			return constraintsMap, fmt.Errorf("invalid constraint for key '%v': %w", k, err)
 		}
+		keyCStr := C.CString(k)
+		C.Constraints_add(constraintsMap, keyCStr, constraint)
+		C.free(unsafe.Pointer(keyCStr))
+		C.Constraint_free(constraint)
 	}
 
 	return constraintsMap, nil
@@ -188,6 +188,49 @@ func TestConstrainedSearch(t *testing.T) {
 	checkQuery(t, db, "a b c d e", constraints, []uint64{0})
 }
 
+func TestConstraintOperators(t *testing.T) {
+	db, err := ndb.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Insert(
+		"doc", "id",
+		[]string{"a", "a b", "a b c", "a b c d", "a b c d e", "w"},
+		[]map[string]interface{}{
+			{"date": "2024-01-15", "group": "eng", "n": 1.0},
+			{"date": "2024-03-01", "group": "sales", "n": 2.0},
+			{"date": "2024-06-30", "group": "hr", "n": 3.0},
+			{"date": "2025-01-01", "group": "eng-infra", "n": 4.0},
+			{"date": "2023-12-31", "group": "legal", "n": 5.0},
+			{},
+		},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := "a b c d e"
+	checkQuery(t, db, query, nil, []uint64{4, 3, 2, 1, 0})
+
+	checkQuery(t, db, query, ndb.Constraints{"n": ndb.GreaterThanOrEqualTo(3.0)}, []uint64{4, 3, 2})
+	checkQuery(t, db, query, ndb.Constraints{"n": ndb.LessThanOrEqualTo(2.0)}, []uint64{1, 0})
+	checkQuery(t, db, query, ndb.Constraints{
+		"date": ndb.AllOf(ndb.GreaterThanOrEqualTo("2024-01-15"), ndb.LessThanOrEqualTo("2024-06-30")),
+	}, []uint64{2, 1, 0})
+	checkQuery(t, db, query, ndb.Constraints{"group": ndb.In("sales", "hr", "missing")}, []uint64{2, 1})
+	checkQuery(t, db, query, ndb.Constraints{"group": ndb.HasPrefix("eng")}, []uint64{3, 0})
+	checkQuery(t, db, query, ndb.Constraints{"group": ndb.Contains("ga")}, []uint64{4})
+	checkQuery(t, db, query, ndb.Constraints{
+		"group": ndb.AnyOf(ndb.EqualTo("hr"), ndb.HasPrefix("s")),
+		"n":     ndb.GreaterThan(2.0),
+	}, []uint64{2})
+
+	if _, err := db.Query(query, 5, ndb.Constraints{"n": ndb.In(1.0, []int{1})}); err == nil || !strings.Contains(err.Error(), "key 'n'") {
+		t.Fatalf("unsupported constraint values should be rejected: %v", err)
+	}
+}
+
 func intString(start, end int) string {
 	ints := make([]string, end-start)
 	for i := range ints {
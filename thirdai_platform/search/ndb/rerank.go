@@ -0,0 +1,86 @@
+package ndb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Embedder computes embeddings of texts, which are used to rerank the results
+// of lexical queries.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Rerank orders the chunks returned by a query by a weighted sum of their
+// lexical score, normalized by the highest score of the chunks, and the cosine
+// similarity of their embedding to the embedding of the query. A weight of 0
+// keeps the lexical order, and a weight of 1 orders the chunks only by their
+// embeddings. The scores of the returned chunks are the combined scores.
+func Rerank(ctx context.Context, embedder Embedder, query string, chunks []Chunk, weight float64) ([]Chunk, error) {
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	texts := make([]string, 0, len(chunks)+1)
+	texts = append(texts, query)
+	for _, chunk := range chunks {
+		texts = append(texts, chunk.Text)
+	}
+
+	embeddings, err := embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("error computing embeddings for rerank: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings for rerank, got %d", len(texts), len(embeddings))
+	}
+
+	var maxScore float32
+	for _, chunk := range chunks {
+		maxScore = max(maxScore, chunk.Score)
+	}
+
+	reranked := make([]Chunk, len(chunks))
+	for i, chunk := range chunks {
+		lexical := 0.0
+		if maxScore > 0 {
+			lexical = float64(chunk.Score / maxScore)
+		}
+		similarity := cosineSimilarity(embeddings[0], embeddings[i+1])
+
+		reranked[i] = chunk
+		reranked[i].Score = float32((1-weight)*lexical + weight*similarity)
+	}
+
+	// The sort is stable so that chunks with the same score keep the lexical order.
+	slices.SortStableFunc(reranked, func(a, b Chunk) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return reranked, nil
+}
@@ -0,0 +1,163 @@
+package ndb_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"thirdai_platform/search/ndb"
+	"thirdai_platform/utils/llm_generation"
+)
+
+// conceptEmbedder embeds texts as the counts of the concepts of their words, so
+// that words with the same meaning have the same embedding. It stands in for an
+// embedding model in tests.
+type conceptEmbedder struct{}
+
+var concepts = map[string]int{
+	"password": 0, "credentials": 0, "login": 0,
+	"reset": 1, "recover": 1, "change": 1, "forgot": 1,
+	"invoice": 2, "invoices": 2, "bill": 2, "billing": 2,
+	"refund": 3, "refunds": 3, "money": 3, "back": 3,
+	"offline": 4, "internet": 4, "connection": 4,
+	"security": 5, "authentication": 5, "2fa": 5,
+	"month": 6, "monthly": 6,
+}
+
+func (conceptEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	embeddings := make([][]float64, len(texts))
+	for i, text := range texts {
+		embeddings[i] = make([]float64, 7)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			if concept, ok := concepts[strings.Trim(word, ".,?")]; ok {
+				embeddings[i][concept]++
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return nil, errors.New("embedding model unavailable")
+}
+
+var rerankDocs = []string{
+	"open the account settings page and choose change password to reset it",
+	"invoices are emailed to the billing contact at the start of each month",
+	"refunds for annual plans are issued within ten business days",
+	"the mobile app supports offline mode for documents saved to the device",
+	"two factor authentication can be enabled from the security tab of the account",
+	"the account page shows the plan and the documents saved by each user of the account",
+}
+
+// The queries are paraphrases of the documents that share few words with them.
+var rerankQueries = []struct {
+	query    string
+	expected uint64
+}{
+	{"forgot login credentials for my account", 0},
+	{"when do I get the monthly bill for my account", 1},
+	{"how long until I get my money back for the plan", 2},
+	{"can I use the app without an internet connection to see documents", 3},
+	{"how do I turn on 2fa for my account", 4},
+	{"which plan is my account on", 5},
+}
+
+func newRerankNdb(t testing.TB) ndb.NeuralDB {
+	db, err := ndb.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Free)
+
+	if err := db.Insert("help", "help", rerankDocs, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// precisionAt1 returns the fraction of the queries whose top result is the
+// expected document, with and without reranking the top 10 lexical results.
+func precisionAt1(t testing.TB, db ndb.NeuralDB, embedder ndb.Embedder) (float64, float64) {
+	lexical, reranked := 0, 0
+	for _, q := range rerankQueries {
+		chunks, err := db.Query(q.query, 10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) > 0 && chunks[0].Id == q.expected {
+			lexical++
+		}
+
+		chunks, err = ndb.Rerank(context.Background(), embedder, q.query, chunks, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) > 0 && chunks[0].Id == q.expected {
+			reranked++
+		}
+	}
+	return float64(lexical) / float64(len(rerankQueries)), float64(reranked) / float64(len(rerankQueries))
+}
+
+func TestRerank(t *testing.T) {
+	db := newRerankNdb(t)
+
+	lexical, reranked := precisionAt1(t, db, conceptEmbedder{})
+	if reranked != 1 || lexical >= reranked {
+		t.Fatalf("reranking should find all paraphrased queries: lexical p@1 %v, reranked p@1 %v", lexical, reranked)
+	}
+
+	chunks, err := db.Query("account documents", 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint64, 0, len(chunks))
+	for _, chunk := range chunks {
+		ids = append(ids, chunk.Id)
+	}
+
+	// A weight of 0 keeps the lexical order.
+	lexicalOrder, err := ndb.Rerank(context.Background(), conceptEmbedder{}, "account documents", chunks, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, chunk := range lexicalOrder {
+		if chunk.Id != ids[i] {
+			t.Fatalf("weight 0 should keep the lexical order %v, got %+v", ids, lexicalOrder)
+		}
+	}
+
+	if _, err := ndb.Rerank(context.Background(), failingEmbedder{}, "account", chunks, 0.5); err == nil {
+		t.Fatal("rerank should fail if embeddings cannot be computed")
+	}
+
+	if empty, err := ndb.Rerank(context.Background(), failingEmbedder{}, "account", nil, 0.5); err != nil || len(empty) != 0 {
+		t.Fatalf("rerank of no chunks should not compute embeddings: %v", err)
+	}
+}
+
+// BenchmarkRerank reports the p@1 of the paraphrased queries with lexical search
+// and with reranking. By default the embeddings are computed by a stand in for
+// an embedding model, set RERANK_EMBEDDINGS_ENDPOINT and RERANK_EMBEDDINGS_MODEL
+// to benchmark an openai compatible embeddings api, with RERANK_EMBEDDINGS_KEY if
+// it requires a key.
+func BenchmarkRerank(b *testing.B) {
+	var embedder ndb.Embedder = conceptEmbedder{}
+	if endpoint := os.Getenv("RERANK_EMBEDDINGS_ENDPOINT"); endpoint != "" {
+		embedder = llm_generation.NewOpenAIEmbedder(endpoint, os.Getenv("RERANK_EMBEDDINGS_KEY"), os.Getenv("RERANK_EMBEDDINGS_MODEL"))
+	}
+
+	db := newRerankNdb(b)
+
+	var lexical, reranked float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lexical, reranked = precisionAt1(b, db, embedder)
+	}
+	b.ReportMetric(lexical, "p@1-lexical")
+	b.ReportMetric(reranked, "p@1-rerank")
+}
@@ -0,0 +1,86 @@
+package parsing
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Chunk struct {
+	Text string
+	// The page the chunk starts on, or 0 if the document does not have pages.
+	Page int
+}
+
+type ChunkOptions struct {
+	// The maximum number of words in a chunk.
+	Size int `json:"chunk_size"`
+	// The number of words at the end of a chunk that are repeated at the start
+	// of the next chunk, so that text near a boundary is in the same chunk as
+	// its context.
+	Overlap int `json:"chunk_overlap"`
+}
+
+const (
+	DefaultChunkSize = 100
+	maxChunkSize     = 1000
+)
+
+func (opts *ChunkOptions) Validate() error {
+	if opts.Size == 0 {
+		opts.Size = DefaultChunkSize
+	}
+	if opts.Size < 0 || opts.Size > maxChunkSize {
+		return fmt.Errorf("chunk_size must be between 1 and %d", maxChunkSize)
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.Size {
+		return fmt.Errorf("chunk_overlap must be at least 0 and less than chunk_size")
+	}
+	return nil
+}
+
+// ChunkBlocks splits the text of the blocks into chunks of at most opts.Size
+// words. A new chunk is started at the beginning of a block if the block does
+// not fit in the current chunk, so that paragraphs are not split unless they
+// are longer than a chunk.
+func ChunkBlocks(blocks []Block, opts ChunkOptions) []Chunk {
+	chunks := make([]Chunk, 0)
+
+	words := make([]string, 0, opts.Size)
+	// The number of words at the start of the current chunk that were carried
+	// over from the previous chunk.
+	carried := 0
+	page := 0
+
+	emit := func() {
+		if len(words) > carried {
+			chunks = append(chunks, Chunk{Text: strings.Join(words, " "), Page: page})
+		}
+		keep := min(opts.Overlap, len(words))
+		words = append(make([]string, 0, opts.Size), words[len(words)-keep:]...)
+		carried = len(words)
+	}
+
+	for _, block := range blocks {
+		fields := strings.Fields(block.Text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(words) > carried && len(words)+len(fields) > opts.Size {
+			emit()
+		}
+
+		for _, word := range fields {
+			if len(words) == carried {
+				page = block.Page
+			}
+			words = append(words, word)
+			if len(words) >= opts.Size {
+				emit()
+			}
+		}
+	}
+	emit()
+
+	return chunks
+}
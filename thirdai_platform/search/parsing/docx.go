@@ -0,0 +1,77 @@
+package parsing
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const wordNamespace = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+
+// parseDocx returns a block for each paragraph in the body of the document.
+// Headers, footers, and comments are stored in separate parts of the archive
+// and are not included.
+func parseDocx(data []byte) ([]Block, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid docx file: %w", err)
+	}
+
+	file, err := archive.Open("word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("invalid docx file: %w", err)
+	}
+	defer file.Close()
+
+	blocks := make([]Block, 0)
+	var paragraph strings.Builder
+	inText := false
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid docx document: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Space != wordNamespace {
+				continue
+			}
+			switch token.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			}
+		case xml.EndElement:
+			if token.Name.Space != wordNamespace {
+				continue
+			}
+			switch token.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if text := strings.TrimSpace(paragraph.String()); text != "" {
+					blocks = append(blocks, Block{Text: text})
+				}
+				paragraph.Reset()
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(token)
+			}
+		}
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,73 @@
+package parsing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The contents of these elements are not visible text.
+var skippedHtmlElements = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true, "svg": true,
+}
+
+// These elements start a new block, elements that are not listed are inline.
+var blockHtmlElements = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "li": true, "tr": true, "table": true,
+	"section": true, "article": true, "header": true, "footer": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+func parseHtml(data []byte) ([]Block, error) {
+	blocks := make([]Block, 0)
+	var text strings.Builder
+
+	flush := func() {
+		if block := strings.Join(strings.Fields(text.String()), " "); block != "" {
+			blocks = append(blocks, Block{Text: block})
+		}
+		text.Reset()
+	}
+
+	skipDepth := 0
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, fmt.Errorf("invalid html: %w", err)
+			}
+			flush()
+			return blocks, nil
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedHtmlElements[string(name)] {
+				skipDepth++
+			} else if blockHtmlElements[string(name)] {
+				flush()
+			} else if name := string(name); name == "td" || name == "th" {
+				// Table cells are not separated by whitespace in most documents.
+				text.WriteString(" ")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedHtmlElements[string(name)] {
+				skipDepth = max(skipDepth-1, 0)
+			} else if blockHtmlElements[string(name)] {
+				flush()
+			}
+		case html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if blockHtmlElements[string(name)] {
+				flush()
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package parsing extracts the text from documents and splits it into chunks
+// that can be inserted into an ndb.
+package parsing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported document format")
+
+// Block is a contiguous section of text in a document, for example a paragraph
+// or a page. Chunks never span multiple blocks unless the blocks are small.
+type Block struct {
+	Text string
+	// The 1-indexed page of the document the text is on, or 0 if the document
+	// does not have pages.
+	Page int
+}
+
+var parsers = map[string]func([]byte) ([]Block, error){
+	".pdf":  parsePdf,
+	".docx": parseDocx,
+	".html": parseHtml,
+	".htm":  parseHtml,
+	".txt":  parseText,
+	".md":   parseText,
+}
+
+func IsSupported(filename string) bool {
+	_, ok := parsers[strings.ToLower(filepath.Ext(filename))]
+	return ok
+}
+
+// Parse extracts the text from the document, the format is determined by the
+// extension of the filename.
+func Parse(filename string, data []byte) ([]Block, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	parser, ok := parsers[ext]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s': supported formats are pdf, docx, html, txt, and md", ErrUnsupportedFormat, ext)
+	}
+
+	blocks, err := parser(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filepath.Base(filename), err)
+	}
+	return blocks, nil
+}
+
+func parseText(data []byte) ([]Block, error) {
+	blocks := make([]Block, 0)
+	for _, paragraph := range bytes.Split(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n\n")) {
+		if text := strings.TrimSpace(string(paragraph)); text != "" {
+			blocks = append(blocks, Block{Text: text})
+		}
+	}
+	return blocks, nil
+}
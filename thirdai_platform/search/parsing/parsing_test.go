@@ -0,0 +1,235 @@
+package parsing_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"thirdai_platform/search/parsing"
+)
+
+// buildPdf creates a pdf with a page for each content stream. The fonts are
+// given as the body of the object, and are available to all pages as F1, F2,
+// etc. Streams that should be compressed are flate encoded.
+func buildPdf(t *testing.T, pages []string, compress []bool, fonts []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	nextObj := 3
+	fontRefs := make([]string, 0)
+	for i, font := range fonts {
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", nextObj, font)
+		fontRefs = append(fontRefs, fmt.Sprintf("/F%d %d 0 R", i+1, nextObj))
+		nextObj++
+	}
+
+	kids := make([]string, 0)
+	for i, content := range pages {
+		data := []byte(content)
+		filter := ""
+		if compress[i] {
+			var compressed bytes.Buffer
+			w := zlib.NewWriter(&compressed)
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			data = compressed.Bytes()
+			filter = "/Filter /FlateDecode "
+		}
+
+		fmt.Fprintf(&buf, "%d 0 obj\n<< %s/Length %d >>\nstream\n", nextObj, filter, len(data))
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /Page /Parent 2 0 R /Contents %d 0 R >>\nendobj\n", nextObj+1, nextObj)
+		kids = append(kids, fmt.Sprintf("%d 0 R", nextObj+1))
+		nextObj += 2
+	}
+
+	// The resources are inherited by the pages from the page tree.
+	fmt.Fprintf(&buf, "2 0 obj\n<< /Type /Pages /Kids [%s] /Count %d /Resources << /Font << %s >> >> >>\nendobj\n",
+		strings.Join(kids, " "), len(kids), strings.Join(fontRefs, " "))
+	buf.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	return buf.Bytes()
+}
+
+func TestParsePdf(t *testing.T) {
+	toUnicode := "/CIDInit /ProcSet findresource begin\nbegincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"2 beginbfchar <0001> <0048> <0002> <0069> endbfchar\n" +
+		"1 beginbfrange <0010> <0012> <0061> endbfrange\n" +
+		"endcmap end end"
+
+	pdf := buildPdf(t,
+		[]string{
+			"BT /F1 12 Tf 72 712 Td (Hello world) Tj 0 -14 Td [(Sec)20(ond) -300 (line \\(escaped\\))] TJ ET",
+			"BT /F2 12 Tf 1 0 0 1 72 700 Tm <00010002> Tj 1 0 0 1 72 680 Tm <001000110012> Tj ET",
+			"q 100 0 0 100 0 0 cm Q",
+		},
+		[]bool{false, true, false},
+		[]string{
+			"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+			fmt.Sprintf("<< /Type /Font /Subtype /Type0 /ToUnicode 99 0 R >>\nendobj\n99 0 obj\n<< /Length %d >>\nstream\n%s\nendstream", len(toUnicode), toUnicode),
+		},
+	)
+
+	blocks, err := parsing.Parse("doc.PDF", pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []parsing.Block{
+		{Text: "Hello world\nSecond line (escaped)", Page: 1},
+		{Text: "Hi\nabc", Page: 2},
+	}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("incorrect pdf text %#v", blocks)
+	}
+
+	if _, err := parsing.Parse("doc.pdf", []byte("not a pdf")); err == nil {
+		t.Fatal("invalid pdf should fail to parse")
+	}
+
+	encrypted := append(buildPdf(t, []string{"BT (secret) Tj ET"}, []bool{false}, nil), []byte("trailer\n<< /Root 1 0 R /Encrypt 5 0 R >>\n")...)
+	if _, err := parsing.Parse("doc.pdf", encrypted); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("encrypted pdf should fail to parse: %v", err)
+	}
+}
+
+func TestParseEncryptedPdf(t *testing.T) {
+	// This file is encrypted with an owner password and an empty user password.
+	data, err := os.ReadFile("../../integration_tests/data/apple-10k.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := parsing.Parse("apple-10k.pdf", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 80 || blocks[0].Page != 1 || !strings.Contains(blocks[0].Text, "SECURITIES AND EXCHANGE COMMISSION") {
+		t.Fatalf("incorrect text from encrypted pdf: %d pages", len(blocks))
+	}
+	if !strings.Contains(blocks[1].Text, "Indicate by check mark whether the Registrant is a large accelerated filer") {
+		t.Fatalf("incorrect word spacing in encrypted pdf: %q", blocks[1].Text[:100])
+	}
+}
+
+func TestParseDocx(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:body>
+    <w:p><w:r><w:t>First </w:t></w:r><w:r><w:t>paragraph</w:t></w:r></w:p>
+    <w:p><w:r><w:t></w:t></w:r></w:p>
+    <w:p><w:r><w:t>Second</w:t><w:tab/><w:t>paragraph &amp; more</w:t></w:r></w:p>
+  </w:body>
+</w:document>`
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(document)); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := parsing.Parse("doc.docx", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []parsing.Block{{Text: "First paragraph"}, {Text: "Second\tparagraph & more"}}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("incorrect docx text %#v", blocks)
+	}
+
+	if _, err := parsing.Parse("doc.docx", []byte("not a zip")); err == nil {
+		t.Fatal("invalid docx should fail to parse")
+	}
+}
+
+func TestParseHtml(t *testing.T) {
+	page := `<html><head><title>Title</title><style>p { color: red; }</style></head>
+<body>
+  <h1>Heading</h1>
+  <p>Some <b>bold</b> text &amp; an<a href="/">link</a>.</p>
+  <script>var x = "hidden";</script>
+  <table><tr><td>cell</td><td>values</td></tr></table>
+  line one<br/>line two
+</body></html>`
+
+	blocks, err := parsing.Parse("page.html", []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []parsing.Block{
+		{Text: "Heading"}, {Text: "Some bold text & anlink."}, {Text: "cell values"}, {Text: "line one"}, {Text: "line two"},
+	}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("incorrect html text %#v", blocks)
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	if parsing.IsSupported("data.csv") || !parsing.IsSupported("notes.TXT") {
+		t.Fatal("incorrect supported formats")
+	}
+	if _, err := parsing.Parse("data.csv", []byte("a,b")); !errors.Is(err, parsing.ErrUnsupportedFormat) {
+		t.Fatalf("csv should not be supported: %v", err)
+	}
+}
+
+func TestChunkBlocks(t *testing.T) {
+	blocks := []parsing.Block{
+		{Text: "a b c", Page: 1},
+		{Text: "d e", Page: 1},
+		{Text: "f g h i j k l", Page: 2},
+		{Text: "  ", Page: 3},
+		{Text: "m", Page: 4},
+	}
+
+	opts := parsing.ChunkOptions{}
+	if err := opts.Validate(); err != nil || opts.Size != parsing.DefaultChunkSize {
+		t.Fatalf("invalid default options %v: %v", opts, err)
+	}
+	for _, invalid := range []parsing.ChunkOptions{{Size: -1}, {Size: 10, Overlap: 10}, {Size: 10000}} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("chunk options %v should be invalid", invalid)
+		}
+	}
+
+	chunks := parsing.ChunkBlocks(blocks, parsing.ChunkOptions{Size: 5})
+	expected := []parsing.Chunk{
+		{Text: "a b c d e", Page: 1}, {Text: "f g h i j", Page: 2}, {Text: "k l m", Page: 2},
+	}
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("incorrect chunks %#v", chunks)
+	}
+
+	chunks = parsing.ChunkBlocks(blocks, parsing.ChunkOptions{Size: 4, Overlap: 1})
+	expected = []parsing.Chunk{
+		{Text: "a b c", Page: 1}, {Text: "c d e", Page: 1}, {Text: "e f g h", Page: 2},
+		{Text: "h i j k", Page: 2}, {Text: "k l m", Page: 2},
+	}
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("incorrect chunks with overlap %#v", chunks)
+	}
+
+	if chunks := parsing.ChunkBlocks(nil, parsing.ChunkOptions{Size: 4}); len(chunks) != 0 {
+		t.Fatalf("expected no chunks %#v", chunks)
+	}
+}
@@ -0,0 +1,538 @@
+package parsing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The pdf parser only extracts the text of the pages, it does not render the
+// pages, so the text is ordered as it is drawn, which is usually the reading
+// order. The objects are found by scanning the file rather than by reading the
+// cross-reference table, so that files with damaged tables can still be read.
+// Files that require a password to open and scanned pages without text are
+// not supported.
+
+var errPdfEncrypted = errors.New("encrypted pdfs are not supported")
+
+type pdfStream struct {
+	dict pdfDict
+	raw  []byte
+}
+
+type pdfFile struct {
+	objects map[int]interface{}
+	fonts   map[pdfRef]*pdfFont
+}
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfTrailer      = regexp.MustCompile(`trailer\s*<<`)
+	inlineImageEnd  = regexp.MustCompile(`\sEI\b`)
+)
+
+func parsePdf(data []byte) ([]Block, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return nil, fmt.Errorf("invalid pdf file: missing pdf header")
+	}
+
+	file, err := readPdfObjects(data)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]Block, 0)
+	for i, page := range file.pages() {
+		var text pdfTextWriter
+		file.extractText(&text, file.pageContents(page), file.inherited(page, "Resources"), 0)
+		if content := strings.TrimSpace(text.String()); content != "" {
+			blocks = append(blocks, Block{Text: content, Page: i + 1})
+		}
+	}
+
+	return blocks, nil
+}
+
+func readPdfObjects(data []byte) (*pdfFile, error) {
+	file := &pdfFile{objects: make(map[int]interface{}), fonts: make(map[pdfRef]*pdfFont)}
+	generations := make(map[int]int)
+	trailers := make([]pdfDict, 0)
+
+	for pos := 0; pos < len(data); {
+		match := pdfObjectHeader.FindSubmatchIndex(data[pos:])
+		if match == nil {
+			break
+		}
+		start, end := pos+match[0], pos+match[1]
+		num, _ := strconv.Atoi(string(data[pos+match[2] : pos+match[3]]))
+		gen, _ := strconv.Atoi(string(data[pos+match[4] : pos+match[5]]))
+		pos = end
+
+		if start > 0 && !isPdfWhitespace(data[start-1]) && !isPdfDelimiter(data[start-1]) {
+			continue
+		}
+
+		lexer := &pdfLexer{data: data, pos: end}
+		value, err := lexer.object()
+		if err != nil {
+			continue
+		}
+		pos = lexer.pos
+
+		if dict, ok := value.(pdfDict); ok {
+			// Cross reference streams replace the trailer in newer files.
+			if dict["Type"] == pdfName("XRef") {
+				trailers = append(trailers, dict)
+			}
+
+			if keyword, err := lexer.next(); err == nil && keyword == pdfKeyword("stream") {
+				raw, next := readStreamData(data, lexer.pos, dict)
+				value = &pdfStream{dict: dict, raw: raw}
+				pos = next
+			}
+		}
+
+		// Later definitions of an object replace earlier ones, since files are
+		// updated by appending the new objects.
+		file.objects[num] = value
+		generations[num] = gen
+	}
+
+	for _, trailer := range pdfTrailer.FindAllIndex(data, -1) {
+		lexer := &pdfLexer{data: data, pos: trailer[1] - 2}
+		if dict, err := lexer.object(); err == nil {
+			if dict, ok := dict.(pdfDict); ok {
+				trailers = append(trailers, dict)
+			}
+		}
+	}
+
+	for _, trailer := range trailers {
+		encrypt, ok := trailer["Encrypt"]
+		if !ok {
+			continue
+		}
+
+		var id []byte
+		if ids, ok := file.resolve(trailer["ID"]).(pdfArray); ok && len(ids) > 0 {
+			id, _ = ids[0].(pdfString)
+		}
+		decryptor, err := newPdfDecryptor(file, file.dict(encrypt), id)
+		if err != nil {
+			return nil, err
+		}
+		if decryptor != nil {
+			encryptRef, _ := encrypt.(pdfRef)
+			for num, value := range file.objects {
+				if num != encryptRef.num {
+					file.objects[num] = decryptor.decryptObject(value, num, generations[num])
+				}
+			}
+		}
+		break
+	}
+
+	// Objects can also be stored in compressed object streams.
+	for _, value := range file.objects {
+		if stream, ok := value.(*pdfStream); ok && stream.dict["Type"] == pdfName("ObjStm") {
+			file.readObjectStream(stream)
+		}
+	}
+
+	return file, nil
+}
+
+// readStreamData returns the data of the stream that starts after the stream
+// keyword at pos, and the position after the end of the stream.
+func readStreamData(data []byte, pos int, dict pdfDict) ([]byte, int) {
+	if pos < len(data) && data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(data) && data[pos] == '\n' {
+		pos++
+	}
+
+	if length, ok := dict["Length"].(float64); ok && pos+int(length) <= len(data) {
+		end := pos + int(length)
+		if bytes.HasPrefix(bytes.TrimLeft(data[end:], " \t\r\n"), []byte("endstream")) {
+			return data[pos:end], end
+		}
+	}
+
+	// The length is a reference or is incorrect, so the end of the stream is
+	// found by searching for the endstream keyword.
+	end := bytes.Index(data[pos:], []byte("endstream"))
+	if end < 0 {
+		return data[pos:], len(data)
+	}
+	return bytes.TrimRight(data[pos:pos+end], "\r\n"), pos + end
+}
+
+func (f *pdfFile) readObjectStream(stream *pdfStream) {
+	data, err := f.decodeStream(stream)
+	if err != nil {
+		return
+	}
+	n, _ := stream.dict["N"].(float64)
+	first, _ := stream.dict["First"].(float64)
+
+	// The stream starts with pairs of object numbers and offsets.
+	lexer := &pdfLexer{data: data}
+	offsets := make([][2]int, 0, int(n))
+	for i := 0; i < 2*int(n); i++ {
+		value, err := lexer.next()
+		if err != nil {
+			return
+		}
+		number, ok := value.(float64)
+		if !ok {
+			return
+		}
+		if i%2 == 0 {
+			offsets = append(offsets, [2]int{int(number), 0})
+		} else {
+			offsets[len(offsets)-1][1] = int(number)
+		}
+	}
+
+	for _, entry := range offsets {
+		if _, exists := f.objects[entry[0]]; exists {
+			continue
+		}
+		lexer := &pdfLexer{data: data, pos: int(first) + entry[1]}
+		if value, err := lexer.object(); err == nil {
+			f.objects[entry[0]] = value
+		}
+	}
+}
+
+func (f *pdfFile) resolve(value interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = f.objects[ref.num]
+	}
+	return nil
+}
+
+func (f *pdfFile) dict(value interface{}) pdfDict {
+	switch value := f.resolve(value).(type) {
+	case pdfDict:
+		return value
+	case *pdfStream:
+		return value.dict
+	}
+	return nil
+}
+
+func (f *pdfFile) decodeStream(stream *pdfStream) ([]byte, error) {
+	var filters pdfArray
+	switch filter := f.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{filter}
+	case pdfArray:
+		filters = filter
+	}
+
+	data := stream.raw
+	for _, filter := range filters {
+		var err error
+		switch f.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			var reader io.ReadCloser
+			if reader, err = zlib.NewReader(bytes.NewReader(data)); err == nil {
+				// Streams are often truncated, so the data that was decompressed
+				// before an error is still used.
+				data, err = io.ReadAll(reader)
+				if len(data) > 0 {
+					err = nil
+				}
+			}
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			encoded := bytes.TrimSuffix(bytes.TrimSpace(data), []byte("~>"))
+			decoded := make([]byte, 4*len(encoded))
+			var n int
+			n, _, err = ascii85.Decode(decoded, encoded, true)
+			data = decoded[:n]
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			var value interface{}
+			lexer := &pdfLexer{data: append(append([]byte("<"), bytes.TrimSuffix(bytes.TrimSpace(data), []byte(">"))...), '>')}
+			if value, err = lexer.hexString(); err == nil {
+				data = value.(pdfString)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported stream filter %v", filter)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding stream: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// pages returns the page dictionaries in the order of the page tree.
+func (f *pdfFile) pages() []pdfDict {
+	pages := make([]pdfDict, 0)
+	visited := make(map[int]bool)
+
+	var walk func(node interface{}, depth int)
+	walk = func(node interface{}, depth int) {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+		}
+		dict := f.dict(node)
+		if dict == nil || depth > 64 {
+			return
+		}
+		if dict["Type"] == pdfName("Page") {
+			pages = append(pages, dict)
+			return
+		}
+		if kids, ok := f.resolve(dict["Kids"]).(pdfArray); ok {
+			for _, kid := range kids {
+				walk(kid, depth+1)
+			}
+		}
+	}
+
+	for _, value := range f.objects {
+		if dict, ok := value.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+			walk(dict["Pages"], 0)
+			if len(pages) > 0 {
+				return pages
+			}
+		}
+	}
+	return pages
+}
+
+// inherited returns the value of the key in the page or the closest ancestor in
+// the page tree that defines it.
+func (f *pdfFile) inherited(page pdfDict, key pdfName) interface{} {
+	for i := 0; page != nil && i < 64; i++ {
+		if value, ok := page[key]; ok {
+			return f.resolve(value)
+		}
+		page = f.dict(page["Parent"])
+	}
+	return nil
+}
+
+func (f *pdfFile) pageContents(page pdfDict) []byte {
+	var streams pdfArray
+	switch contents := f.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		streams = pdfArray{contents}
+	case pdfArray:
+		streams = contents
+	}
+
+	contents := make([]byte, 0)
+	for _, stream := range streams {
+		if stream, ok := f.resolve(stream).(*pdfStream); ok {
+			if data, err := f.decodeStream(stream); err == nil {
+				contents = append(append(contents, data...), '\n')
+			}
+		}
+	}
+	return contents
+}
+
+func (f *pdfFile) font(resources pdfDict, name pdfName) *pdfFont {
+	ref, isRef := f.dict(resources["Font"])[name].(pdfRef)
+	if isRef {
+		if font, ok := f.fonts[ref]; ok {
+			return font
+		}
+	}
+
+	font := newPdfFont(f, f.dict(f.dict(resources["Font"])[name]))
+	if isRef {
+		f.fonts[ref] = font
+	}
+	return font
+}
+
+// pdfTextState tracks the position of the text on the current line so that
+// the gaps between words can be found, see section 9.4.2 of the pdf
+// specification. Positions are in unscaled text space units.
+type pdfTextState struct {
+	font     *pdfFont
+	fontSize float64
+	// The start of the current line, and the end of the last glyph drawn.
+	lineX, x float64
+	lastY    float64
+}
+
+// Gaps between glyphs that are wider than this fraction of the font size are
+// treated as spaces between words.
+const pdfWordGap = 0.2
+
+func (s *pdfTextState) show(text *pdfTextWriter, value interface{}) {
+	str, width := s.font.decode(value)
+	text.write(str)
+	s.x += width / 1000 * s.fontSize
+}
+
+// moveTo starts drawing at a new position on the line, and adds a space if
+// the position is not directly after the last glyph.
+func (s *pdfTextState) moveTo(text *pdfTextWriter, x float64) {
+	if gap := x - s.x; gap > pdfWordGap*s.fontSize || gap < -s.fontSize {
+		text.space()
+	}
+	s.lineX, s.x = x, x
+}
+
+func (s *pdfTextState) newline(text *pdfTextWriter, x float64) {
+	text.newline()
+	s.lineX, s.x = x, x
+}
+
+// extractText runs the text operators of a content stream and writes the text
+// that is drawn to the writer, see section 9.4 of the pdf specification.
+func (f *pdfFile) extractText(text *pdfTextWriter, content []byte, resourcesValue interface{}, depth int) {
+	resources := f.dict(resourcesValue)
+	state := &pdfTextState{fontSize: 1}
+
+	lexer := &pdfLexer{data: content}
+	operands := make([]interface{}, 0)
+	number := func(i int) float64 {
+		value, _ := operands[i].(float64)
+		return value
+	}
+
+	for {
+		token, err := lexer.object()
+		if err != nil {
+			return
+		}
+
+		op, ok := token.(pdfKeyword)
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					state.font = f.font(resources, name)
+				}
+				state.fontSize = number(1)
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				state.show(text, operands[0])
+			}
+		case "'", "\"":
+			state.newline(text, state.lineX)
+			if len(operands) >= 1 {
+				state.show(text, operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				array, _ := operands[0].(pdfArray)
+				for _, value := range array {
+					// Numbers move the next glyph left, in thousandths of the font
+					// size, large negative numbers are used as spaces.
+					if offset, ok := value.(float64); ok {
+						state.moveTo(text, state.x-offset/1000*state.fontSize)
+					} else {
+						state.show(text, value)
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if number(1) != 0 {
+					state.newline(text, state.lineX+number(0))
+				} else {
+					state.moveTo(text, state.lineX+number(0))
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				// The position is converted to text space so that it can be
+				// compared with the widths of the glyphs.
+				scale := math.Hypot(number(0), number(1))
+				if scale == 0 {
+					scale = 1
+				}
+				if y := number(5); y != state.lastY {
+					state.newline(text, number(4)/scale)
+					state.lastY = y
+				} else {
+					state.moveTo(text, number(4)/scale)
+				}
+			}
+		case "T*":
+			state.newline(text, state.lineX)
+		case "BT":
+			state.lineX, state.x = 0, 0
+		case "ET":
+			text.space()
+		case "Do":
+			// Form xobjects are content streams that can be drawn on pages.
+			if len(operands) >= 1 && depth < 8 {
+				name, _ := operands[0].(pdfName)
+				xobject, ok := f.resolve(f.dict(resources["XObject"])[name]).(*pdfStream)
+				if ok && xobject.dict["Subtype"] == pdfName("Form") {
+					if data, err := f.decodeStream(xobject); err == nil {
+						formResources := xobject.dict["Resources"]
+						if formResources == nil {
+							formResources = resources
+						}
+						f.extractText(text, data, formResources, depth+1)
+					}
+				}
+			}
+		case "ID":
+			// The data of inline images is binary, so it is skipped.
+			end := inlineImageEnd.FindIndex(content[lexer.pos:])
+			if end == nil {
+				return
+			}
+			lexer.pos += end[1]
+		}
+		operands = operands[:0]
+	}
+}
+
+// pdfTextWriter joins the text that is drawn, adding whitespace where the
+// position of the text changes.
+type pdfTextWriter struct {
+	strings.Builder
+	last byte
+}
+
+func (w *pdfTextWriter) write(text string) {
+	if len(text) > 0 {
+		w.WriteString(text)
+		w.last = text[len(text)-1]
+	}
+}
+
+func (w *pdfTextWriter) space() {
+	if w.last != 0 && w.last != ' ' && w.last != '\n' {
+		w.write(" ")
+	}
+}
+
+func (w *pdfTextWriter) newline() {
+	if w.last != 0 && w.last != '\n' {
+		w.write("\n")
+	}
+}
@@ -0,0 +1,178 @@
+package parsing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Many pdfs are encrypted with an empty user password so that they can be
+// opened without a password, but the owner password is required to edit them.
+// These files can be decrypted using the rc4 and aes-128 algorithms of the
+// standard security handler, see section 7.6 of the pdf specification.
+
+var pdfPasswordPadding = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+type pdfDecryptor struct {
+	key []byte
+	aes bool
+}
+
+func newPdfDecryptor(file *pdfFile, encrypt pdfDict, id []byte) (*pdfDecryptor, error) {
+	if encrypt == nil || encrypt["Filter"] != pdfName("Standard") {
+		return nil, fmt.Errorf("%w: unsupported security handler", errPdfEncrypted)
+	}
+
+	v, _ := file.resolve(encrypt["V"]).(float64)
+	r, _ := file.resolve(encrypt["R"]).(float64)
+	o, _ := file.resolve(encrypt["O"]).(pdfString)
+	u, _ := file.resolve(encrypt["U"]).(pdfString)
+	p, _ := file.resolve(encrypt["P"]).(float64)
+	if r < 2 || r > 4 || len(o) < 32 || len(u) < 16 {
+		return nil, fmt.Errorf("%w: unsupported encryption revision %v", errPdfEncrypted, r)
+	}
+
+	keyLength := 5
+	if length, ok := file.resolve(encrypt["Length"]).(float64); ok && r >= 3 {
+		keyLength = int(length) / 8
+	}
+
+	decryptor := &pdfDecryptor{}
+	if v == 4 {
+		filterName, _ := encrypt["StmF"].(pdfName)
+		filter := file.dict(file.dict(encrypt["CF"])[filterName])
+		switch filter["CFM"] {
+		case pdfName("AESV2"):
+			decryptor.aes = true
+			keyLength = 16
+		case pdfName("V2"):
+			keyLength = 16
+		case nil, pdfName("None"):
+			// The streams are not encrypted.
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("%w: unsupported crypt filter %v", errPdfEncrypted, filter["CFM"])
+		}
+	} else if v != 1 && v != 2 {
+		return nil, fmt.Errorf("%w: unsupported encryption version %v", errPdfEncrypted, v)
+	}
+	if keyLength < 5 || keyLength > 16 {
+		return nil, fmt.Errorf("%w: invalid key length", errPdfEncrypted)
+	}
+
+	// Algorithm 2: computing the encryption key from the empty password.
+	hash := md5.New()
+	hash.Write(pdfPasswordPadding)
+	hash.Write(o[:32])
+	binary.Write(hash, binary.LittleEndian, int32(p))
+	hash.Write(id)
+	if r >= 4 && encrypt["EncryptMetadata"] == false {
+		hash.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF})
+	}
+	key := hash.Sum(nil)
+	if r >= 3 {
+		for i := 0; i < 50; i++ {
+			sum := md5.Sum(key[:keyLength])
+			key = sum[:]
+		}
+	}
+	decryptor.key = key[:keyLength]
+
+	// Algorithms 4 and 5: the empty password is only the user password if the
+	// U entry can be computed from it.
+	var expected []byte
+	if r == 2 {
+		expected = rc4Crypt(decryptor.key, pdfPasswordPadding)
+		u = u[:min(len(u), 32)]
+	} else {
+		sum := md5.Sum(append(slices.Clone(pdfPasswordPadding), id...))
+		expected = sum[:]
+		for i := 0; i < 20; i++ {
+			roundKey := slices.Clone(decryptor.key)
+			for j := range roundKey {
+				roundKey[j] ^= byte(i)
+			}
+			expected = rc4Crypt(roundKey, expected)
+		}
+		u = u[:16]
+	}
+	if !bytes.Equal(expected, u) {
+		return nil, fmt.Errorf("%w: pdfs that require a password to open are not supported", errPdfEncrypted)
+	}
+
+	return decryptor, nil
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// Algorithm 1: each object is encrypted with a key derived from its number.
+func (d *pdfDecryptor) objectKey(num, gen int) []byte {
+	hash := md5.New()
+	hash.Write(d.key)
+	hash.Write([]byte{byte(num), byte(num >> 8), byte(num >> 16), byte(gen), byte(gen >> 8)})
+	if d.aes {
+		hash.Write([]byte("sAlT"))
+	}
+	return hash.Sum(nil)[:min(len(d.key)+5, 16)]
+}
+
+func (d *pdfDecryptor) decrypt(data []byte, num, gen int) []byte {
+	key := d.objectKey(num, gen)
+	if !d.aes {
+		return rc4Crypt(key, data)
+	}
+
+	// The data is the initialization vector followed by the cbc encrypted data.
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	if padding := int(out[len(out)-1]); padding > 0 && padding <= aes.BlockSize {
+		out = out[:len(out)-padding]
+	}
+	return out
+}
+
+// decryptObject decrypts the strings and stream data in the object.
+func (d *pdfDecryptor) decryptObject(value interface{}, num, gen int) interface{} {
+	switch value := value.(type) {
+	case pdfString:
+		return pdfString(d.decrypt(value, num, gen))
+	case pdfArray:
+		for i := range value {
+			value[i] = d.decryptObject(value[i], num, gen)
+		}
+	case pdfDict:
+		for key := range value {
+			value[key] = d.decryptObject(value[key], num, gen)
+		}
+	case *pdfStream:
+		// Cross reference streams are not encrypted.
+		if value.dict["Type"] != pdfName("XRef") {
+			d.decryptObject(value.dict, num, gen)
+			value.raw = d.decrypt(value.raw, num, gen)
+		}
+	}
+	return value
+}
@@ -0,0 +1,355 @@
+package parsing
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfFont maps the character codes in the strings drawn with the font to text,
+// see section 9.10 of the pdf specification. A nil font decodes codes using
+// the standard encoding.
+type pdfFont struct {
+	// The mapping from character codes to text from the ToUnicode cmap of the
+	// font, and the lengths of the codes in bytes, longest first.
+	toUnicode   map[string]string
+	codeLengths []int
+
+	// Glyph names for codes that are different than the standard encoding.
+	differences map[byte]string
+
+	// Composite fonts use multi byte codes that can only be decoded with the
+	// ToUnicode cmap.
+	composite bool
+
+	// The widths of the glyphs in thousandths of the font size, these are used
+	// to find the gaps between words.
+	widths       map[uint32]float64
+	defaultWidth float64
+}
+
+// The width of glyphs in fonts that do not specify widths, this is about the
+// average width of the glyphs in common fonts.
+const pdfDefaultGlyphWidth = 500
+
+func newPdfFont(file *pdfFile, dict pdfDict) *pdfFont {
+	if dict == nil {
+		return nil
+	}
+
+	font := &pdfFont{
+		composite:    dict["Subtype"] == pdfName("Type0"),
+		widths:       make(map[uint32]float64),
+		defaultWidth: pdfDefaultGlyphWidth,
+	}
+	font.readWidths(file, dict)
+
+	if stream, ok := file.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := file.decodeStream(stream); err == nil {
+			font.toUnicode, font.codeLengths = parseToUnicodeCmap(data)
+		}
+	}
+
+	if encoding := file.dict(dict["Encoding"]); encoding != nil {
+		differences, _ := file.resolve(encoding["Differences"]).(pdfArray)
+		font.differences = make(map[byte]string)
+		code := 0
+		for _, value := range differences {
+			switch value := value.(type) {
+			case float64:
+				code = int(value)
+			case pdfName:
+				if glyph := glyphText(string(value)); glyph != "" && code < 256 {
+					font.differences[byte(code)] = glyph
+				}
+				code++
+			}
+		}
+	}
+
+	return font
+}
+
+// decode returns the text of the string, and the total width of its glyphs in
+// thousandths of the font size.
+func (f *pdfFont) decode(value interface{}) (string, float64) {
+	str, ok := value.(pdfString)
+	if !ok {
+		return "", 0
+	}
+
+	var text strings.Builder
+	width := 0.0
+	for i := 0; i < len(str); {
+		if f != nil && f.toUnicode != nil {
+			matched := false
+			for _, length := range f.codeLengths {
+				if i+length <= len(str) {
+					if mapped, ok := f.toUnicode[string(str[i:i+length])]; ok {
+						text.WriteString(mapped)
+						width += f.width(bytesToCode(str[i : i+length]))
+						i += length
+						matched = true
+						break
+					}
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+
+		if f != nil && f.composite {
+			// The code cannot be decoded, so it is skipped.
+			length := min(max(f.minCodeLength(), 1), len(str)-i)
+			width += f.width(bytesToCode(str[i : i+length]))
+			i += length
+			continue
+		}
+
+		if glyph, ok := f.difference(str[i]); ok {
+			text.WriteString(glyph)
+		} else {
+			text.WriteRune(winAnsiRune(str[i]))
+		}
+		width += f.width(uint32(str[i]))
+		i++
+	}
+	return text.String(), width
+}
+
+func (f *pdfFont) width(code uint32) float64 {
+	if f == nil {
+		return pdfDefaultGlyphWidth
+	}
+	if width, ok := f.widths[code]; ok {
+		return width
+	}
+	return f.defaultWidth
+}
+
+// readWidths reads the Widths array of simple fonts, or the W array of the
+// descendant font of composite fonts, see sections 9.6.2 and 9.7.4.3 of the pdf
+// specification.
+func (f *pdfFont) readWidths(file *pdfFile, dict pdfDict) {
+	if !f.composite {
+		first, _ := file.resolve(dict["FirstChar"]).(float64)
+		widths, _ := file.resolve(dict["Widths"]).(pdfArray)
+		for i, width := range widths {
+			if width, ok := file.resolve(width).(float64); ok {
+				f.widths[uint32(int(first)+i)] = width
+			}
+		}
+		return
+	}
+
+	descendants, _ := file.resolve(dict["DescendantFonts"]).(pdfArray)
+	if len(descendants) == 0 {
+		return
+	}
+	descendant := file.dict(descendants[0])
+
+	f.defaultWidth = 1000
+	if width, ok := file.resolve(descendant["DW"]).(float64); ok {
+		f.defaultWidth = width
+	}
+
+	// The entries are either "first [w1 w2 ...]" or "first last w".
+	entries, _ := file.resolve(descendant["W"]).(pdfArray)
+	for i := 0; i+1 < len(entries); {
+		first, ok := file.resolve(entries[i]).(float64)
+		if !ok {
+			return
+		}
+		if widths, ok := file.resolve(entries[i+1]).(pdfArray); ok {
+			for j, width := range widths {
+				if width, ok := file.resolve(width).(float64); ok {
+					f.widths[uint32(int(first)+j)] = width
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(entries) {
+			return
+		}
+		last, ok1 := file.resolve(entries[i+1]).(float64)
+		width, ok2 := file.resolve(entries[i+2]).(float64)
+		if !ok1 || !ok2 || last < first || last-first > maxCmapRange {
+			return
+		}
+		for code := int(first); code <= int(last); code++ {
+			f.widths[uint32(code)] = width
+		}
+		i += 3
+	}
+}
+
+func (f *pdfFont) minCodeLength() int {
+	if len(f.codeLengths) == 0 {
+		return 2
+	}
+	return f.codeLengths[len(f.codeLengths)-1]
+}
+
+func (f *pdfFont) difference(code byte) (string, bool) {
+	if f == nil || f.differences == nil {
+		return "", false
+	}
+	glyph, ok := f.differences[code]
+	return glyph, ok
+}
+
+// The characters in the WinAnsi encoding that are different than latin-1.
+var winAnsiSpecial = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”',
+	0x95: '•', 0x96: '–', 0x97: '—', 0x99: '™',
+}
+
+func winAnsiRune(code byte) rune {
+	if r, ok := winAnsiSpecial[code]; ok {
+		return r
+	}
+	return rune(code)
+}
+
+// The text of glyph names that are commonly used in encoding differences.
+var glyphNames = map[string]string{
+	"space": " ", "period": ".", "comma": ",", "colon": ":", "semicolon": ";", "hyphen": "-",
+	"quoteright": "’", "quoteleft": "‘", "quotedblleft": "“", "quotedblright": "”", "quotesingle": "'",
+	"endash": "–", "emdash": "—", "bullet": "•", "ellipsis": "…", "parenleft": "(", "parenright": ")",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+}
+
+func glyphText(name string) string {
+	if len(name) == 1 {
+		return name
+	}
+	if text, ok := glyphNames[name]; ok {
+		return text
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if code, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(code))
+		}
+	}
+	return ""
+}
+
+func utf16BytesToString(data []byte) string {
+	if len(data)%2 == 1 {
+		return string(data)
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// A bfrange can map a large range of codes, the size is limited so that an
+// invalid range does not use too much memory.
+const maxCmapRange = 1 << 16
+
+// parseToUnicodeCmap returns the mapping from codes to text in the cmap and
+// the lengths of the codes, see section 9.10.3 of the pdf specification.
+func parseToUnicodeCmap(data []byte) (map[string]string, []int) {
+	cmap := make(map[string]string)
+	lengths := make([]int, 0)
+	addLength := func(length int) {
+		if length > 0 && !slices.Contains(lengths, length) {
+			lengths = append(lengths, length)
+		}
+	}
+
+	lexer := &pdfLexer{data: data}
+	operands := make([]interface{}, 0)
+	for {
+		token, err := lexer.object()
+		if err != nil {
+			break
+		}
+		op, ok := token.(pdfKeyword)
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if low, ok := operands[i].(pdfString); ok {
+					addLength(len(low))
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					cmap[string(src)] = utf16BytesToString(dst)
+					addLength(len(src))
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(pdfString)
+				high, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(low) != len(high) || len(low) == 0 || len(low) > 4 {
+					continue
+				}
+				addLength(len(low))
+
+				start, end := bytesToCode(low), bytesToCode(high)
+				if end < start || end-start > maxCmapRange {
+					continue
+				}
+				for code := start; code <= end; code++ {
+					offset := code - start
+					var text string
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						// The last byte of the destination is incremented for each
+						// code in the range.
+						incremented := slices.Clone([]byte(dst))
+						if len(incremented) > 0 {
+							incremented[len(incremented)-1] += byte(offset)
+						}
+						text = utf16BytesToString(incremented)
+					case pdfArray:
+						if int(offset) < len(dst) {
+							if value, ok := dst[offset].(pdfString); ok {
+								text = utf16BytesToString(value)
+							}
+						}
+					}
+					cmap[codeToBytes(code, len(low))] = text
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+
+	slices.SortFunc(lengths, func(a, b int) int { return b - a })
+	return cmap, lengths
+}
+
+func bytesToCode(data []byte) uint32 {
+	code := uint32(0)
+	for _, b := range data {
+		code = code<<8 | uint32(b)
+	}
+	return code
+}
+
+func codeToBytes(code uint32, length int) string {
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(code)
+		code >>= 8
+	}
+	return string(data)
+}
@@ -0,0 +1,275 @@
+package parsing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The types of the values in a pdf, see section 7.3 of the pdf specification.
+type (
+	pdfName    string
+	pdfKeyword string
+	pdfString  []byte
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+	pdfRef     struct{ num, gen int }
+	// A delimiter that ends an array or a dictionary.
+	pdfDelim string
+)
+
+var errPdfSyntax = errors.New("invalid pdf syntax")
+
+// pdfLexer reads the values in a pdf file or content stream. Numbers are
+// returned as float64, and booleans as bool.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPdfWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPdfDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (l *pdfLexer) eof() bool {
+	return l.pos >= len(l.data)
+}
+
+func (l *pdfLexer) skipWhitespace() {
+	for !l.eof() {
+		c := l.data[l.pos]
+		if c == '%' {
+			for !l.eof() && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else if isPdfWhitespace(c) {
+			l.pos++
+		} else {
+			return
+		}
+	}
+}
+
+func (l *pdfLexer) regularToken() string {
+	start := l.pos
+	for !l.eof() && !isPdfWhitespace(l.data[l.pos]) && !isPdfDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// next returns the next token, which is a value other than an array,
+// dictionary, or reference.
+func (l *pdfLexer) next() (interface{}, error) {
+	l.skipWhitespace()
+	if l.eof() {
+		return nil, fmt.Errorf("%w: unexpected end of data", errPdfSyntax)
+	}
+
+	switch c := l.data[l.pos]; c {
+	case '/':
+		l.pos++
+		return pdfName(decodePdfName(l.regularToken())), nil
+	case '(':
+		return l.literalString()
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfDelim("<<"), nil
+		}
+		return l.hexString()
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfDelim(">>"), nil
+		}
+		return nil, fmt.Errorf("%w: unexpected '>'", errPdfSyntax)
+	case '[', ']', '{', '}':
+		l.pos++
+		return pdfDelim(l.data[l.pos-1 : l.pos]), nil
+	case ')':
+		return nil, fmt.Errorf("%w: unexpected ')'", errPdfSyntax)
+	}
+
+	token := l.regularToken()
+	if number, err := strconv.ParseFloat(token, 64); err == nil {
+		return number, nil
+	}
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(token), nil
+}
+
+func decodePdfName(name string) string {
+	if !bytes.ContainsRune([]byte(name), '#') {
+		return name
+	}
+	decoded := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] == '#' && i+2 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				decoded = append(decoded, byte(c))
+				i += 2
+				continue
+			}
+		}
+		decoded = append(decoded, name[i])
+	}
+	return string(decoded)
+}
+
+func (l *pdfLexer) literalString() (interface{}, error) {
+	l.pos++ // (
+	str := make([]byte, 0)
+	depth := 1
+	for !l.eof() {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(str), nil
+			}
+		case '\\':
+			if l.eof() {
+				continue
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A backslash at the end of a line continues the string.
+				if !l.eof() && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					octal := int(c - '0')
+					for i := 0; i < 2 && !l.eof() && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						octal = octal*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(octal)
+				}
+			}
+		}
+		str = append(str, c)
+	}
+	return nil, fmt.Errorf("%w: unterminated string", errPdfSyntax)
+}
+
+func (l *pdfLexer) hexString() (interface{}, error) {
+	l.pos++ // <
+	end := bytes.IndexByte(l.data[l.pos:], '>')
+	if end < 0 {
+		return nil, fmt.Errorf("%w: unterminated hex string", errPdfSyntax)
+	}
+
+	digits := make([]byte, 0, end)
+	for _, c := range l.data[l.pos : l.pos+end] {
+		if !isPdfWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	l.pos += end + 1
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	str := make([]byte, len(digits)/2)
+	for i := range str {
+		c, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid hex string", errPdfSyntax)
+		}
+		str[i] = byte(c)
+	}
+	return pdfString(str), nil
+}
+
+// object returns the next value, including arrays, dictionaries, and
+// references.
+func (l *pdfLexer) object() (interface{}, error) {
+	token, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case float64:
+		// Check if the number is the start of a reference "<num> <gen> R".
+		start := l.pos
+		if gen, err := l.next(); err == nil {
+			if gen, ok := gen.(float64); ok {
+				if r, err := l.next(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{num: int(token), gen: int(gen)}, nil
+				}
+			}
+		}
+		l.pos = start
+		return token, nil
+	case pdfDelim:
+		switch token {
+		case "[":
+			array := make(pdfArray, 0)
+			for {
+				value, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if value == pdfDelim("]") {
+					return array, nil
+				}
+				array = append(array, value)
+			}
+		case "<<":
+			dict := make(pdfDict)
+			for {
+				key, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if key == pdfDelim(">>") {
+					return dict, nil
+				}
+				name, ok := key.(pdfName)
+				if !ok {
+					return nil, fmt.Errorf("%w: dictionary key must be a name", errPdfSyntax)
+				}
+				value, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				dict[name] = value
+			}
+		}
+	}
+	return token, nil
+}
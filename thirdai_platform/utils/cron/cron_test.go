@@ -0,0 +1,49 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday.
+	start := time.Date(2024, 1, 17, 10, 30, 0, 0, time.UTC)
+
+	checks := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 17, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 17, 10, 45, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 1, 18, 2, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 17, 11, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 1, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 1, 21, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * JUN *", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+		// Day of month and day of week are combined with or when both are set.
+		{"0 0 20 * 5", time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2024, 1, 17, 10, 45, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, check := range checks {
+		schedule, err := Parse(check.spec)
+		if err != nil {
+			t.Fatalf("%v: %v", check.spec, err)
+		}
+		if next := schedule.Next(start); !next.Equal(check.expected) {
+			t.Fatalf("%v: expected %v, got %v", check.spec, check.expected, next)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "* * * foo *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Fatalf("expected error for spec '%v'", spec)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"thirdai_platform/utils/validation"
+)
+
+// Error codes are stable, machine readable identifiers for common failures.
+// Unlike the error messages they will not change between releases, so clients
+// should branch on the codes rather than on the messages.
+const (
+	// A per user limit on jobs or resources was reached.
+	ErrorCodeQuotaExceeded = "quota_exceeded"
+	// The resources allowed by the platform license are in use.
+	ErrorCodeLicenseLimit = "license_limit"
+	// A model, team, or other resource with the same name already exists.
+	ErrorCodeDuplicateName = "duplicate_name"
+	ErrorCodeModelNotFound = "model_not_found"
+	// The platform storage does not have enough free space for the request.
+	ErrorCodeInsufficientStorage = "insufficient_storage"
+	// The request has invalid fields, which are listed in the fields of the
+	// error response.
+	ErrorCodeInvalidRequest = "invalid_request"
+	// The account is locked after too many failed logins.
+	ErrorCodeAccountLocked = "account_locked"
+	// The user must verify their email before they can log in.
+	ErrorCodeEmailNotVerified = "email_not_verified"
+	// The artifacts of the model are in cold storage and it must be rehydrated
+	// before it can be used.
+	ErrorCodeModelArchived = "model_archived"
+	// The model is not approved for production and cannot be deployed to the
+	// production environment.
+	ErrorCodeModelNotApproved = "model_not_approved"
+)
+
+// ErrorCodeHeader is set on error responses that have an error code, the code
+// is also included in the json body of the response.
+const ErrorCodeHeader = "X-Error-Code"
+
+// ErrorResponse is the body of all error responses. Code is omitted for errors
+// that do not have an error code, and Fields is only set for invalid requests.
+type ErrorResponse struct {
+	Message string                  `json:"message"`
+	Code    string                  `json:"code,omitempty"`
+	Fields  []validation.FieldError `json:"fields,omitempty"`
+}
+
+// WriteError writes an error response with the given error code, an empty code
+// writes the error without one.
+func WriteError(w http.ResponseWriter, message string, status int, code string) {
+	if code != "" {
+		w.Header().Set(ErrorCodeHeader, code)
+	}
+	http.Error(w, message, status)
+}
+
+// WriteValidationError writes a 422 error response. If err is a
+// validation.Errors then its errors are listed in the fields of the response.
+func WriteValidationError(w http.ResponseWriter, message string, err error) {
+	var fields validation.Errors
+	if !errors.As(err, &fields) {
+		WriteError(w, message, http.StatusUnprocessableEntity, ErrorCodeInvalidRequest)
+		return
+	}
+
+	w.Header().Set(ErrorCodeHeader, ErrorCodeInvalidRequest)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ErrorResponse{Message: message, Code: ErrorCodeInvalidRequest, Fields: fields}); err != nil {
+		slog.Error("error serializing error response", "error", err)
+	}
+}
+
+type errorResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	// Set if the response is an error that is being converted to json.
+	message *bytes.Buffer
+}
+
+func (w *errorResponseWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		w.message = new(bytes.Buffer)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorResponseWriter) Write(data []byte) (int, error) {
+	if w.message != nil {
+		return w.message.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && w.message == nil {
+		flusher.Flush()
+	}
+}
+
+func (w *errorResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// JsonErrors converts the plain text error responses written by http.Error into
+// an ErrorResponse, so that handlers can keep using http.Error and WriteError
+// while clients always receive the same error format.
+func JsonErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+
+		if ew.message == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(ew.status)
+
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(ErrorResponse{
+			Message: strings.TrimSuffix(ew.message.String(), "\n"),
+			Code:    w.Header().Get(ErrorCodeHeader),
+		})
+		if err != nil {
+			slog.Error("error serializing error response", "error", err)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package llm_generation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// OpenAIEmbedder computes embeddings with an openai compatible embeddings api,
+// for example an embedding model that is served locally.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAIEmbedder(endpoint, apiKey, model string) *OpenAIEmbedder {
+	client := openai.NewClient(
+		option.WithAPIKey(apiKey),
+		option.WithBaseURL(endpoint),
+		option.WithHTTPClient(DefaultHTTPClient()),
+	)
+	return &OpenAIEmbedder{client: client, model: model}
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	res, err := e.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model: openai.F(openai.EmbeddingModel(e.model)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error computing embeddings: %w", err)
+	}
+
+	embeddings := make([][]float64, len(texts))
+	for _, embedding := range res.Data {
+		if embedding.Index < 0 || int(embedding.Index) >= len(texts) {
+			return nil, fmt.Errorf("invalid embedding index %d for %d texts", embedding.Index, len(texts))
+		}
+		embeddings[embedding.Index] = embedding.Embedding
+	}
+	return embeddings, nil
+}
+
+// DispatchEmbedder computes embeddings with the llm dispatch service, which
+// forwards the request to the provider.
+type DispatchEmbedder struct {
+	client   *http.Client
+	url      string
+	provider string
+	apiKey   string
+	model    string
+}
+
+func NewDispatchEmbedder(modelBazaarEndpoint string, provider LLMProvider, apiKey, model string) (*DispatchEmbedder, error) {
+	endpoint, err := url.JoinPath(modelBazaarEndpoint, "llm-dispatch/embeddings")
+	if err != nil {
+		return nil, fmt.Errorf("error creating llm dispatch url: %w", err)
+	}
+	return &DispatchEmbedder{client: DefaultHTTPClient(), url: endpoint, provider: string(provider), apiKey: apiKey, model: model}, nil
+}
+
+type dispatchEmbedRequest struct {
+	Texts    []string `json:"texts"`
+	Provider string   `json:"provider"`
+	Key      string   `json:"key,omitempty"`
+	Model    string   `json:"model,omitempty"`
+}
+
+type dispatchEmbedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+func (e *DispatchEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	body, err := json.Marshal(dispatchEmbedRequest{Texts: texts, Provider: e.provider, Key: e.apiKey, Model: e.model})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding embedding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending embedding request to llm dispatch: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		content, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("embedding request to llm dispatch returned status %d, content '%s'", res.StatusCode, string(content))
+	}
+
+	var data dispatchEmbedResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("error parsing embedding response from llm dispatch: %w", err)
+	}
+	return data.Embeddings, nil
+}
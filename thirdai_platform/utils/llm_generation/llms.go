@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -16,7 +15,10 @@ import (
 )
 
 type LLM interface {
-	StreamResponse(req GenerateRequest, w http.ResponseWriter, r *http.Request) (string, error)
+	// StreamResponse calls onChunk with each chunk of the response as it is
+	// generated, and returns the full response. Cancelling the context aborts
+	// the upstream request.
+	StreamResponse(ctx context.Context, req GenerateRequest, onChunk func(string)) (string, error)
 }
 
 type LLMProvider string
@@ -127,15 +129,7 @@ func makePrompt(query, inputTaskPrompt string, refs []Reference) (string, string
 	return systemPrompt, userPrompt
 }
 
-func (llm *OpenAICompliantLLM) StreamResponse(req GenerateRequest, w http.ResponseWriter, r *http.Request) (string, error) {
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		slog.Error("streaming unsupported")
-		return "", fmt.Errorf("streaming unsupported")
-	}
-
+func (llm *OpenAICompliantLLM) StreamResponse(ctx context.Context, req GenerateRequest, onChunk func(string)) (string, error) {
 	var accumulatedResponse bytes.Buffer
 
 	systemPrompt, userPrompt := makePrompt(req.Query, req.TaskPrompt, req.References)
@@ -146,7 +140,7 @@ func (llm *OpenAICompliantLLM) StreamResponse(req GenerateRequest, w http.Respon
 	})
 
 	stream := llm.client.Chat.Completions.NewStreaming(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionNewParams{
 			Messages: messages,
 			Model:    openai.F(req.Model),
@@ -155,8 +149,7 @@ func (llm *OpenAICompliantLLM) StreamResponse(req GenerateRequest, w http.Respon
 	for stream.Next() {
 		evt := stream.Current()
 		if len(evt.Choices) > 0 {
-			fmt.Fprintf(w, "data: %s\n\n", evt.Choices[0].Delta.Content)
-			flusher.Flush()
+			onChunk(evt.Choices[0].Delta.Content)
 			accumulatedResponse.WriteString(evt.Choices[0].Delta.Content)
 		}
 	}
@@ -2,6 +2,7 @@ package llm_generation
 
 import (
 	"net/http"
+	"thirdai_platform/utils"
 	"time"
 )
 
@@ -9,6 +10,9 @@ type Reference struct {
 	Id     uint64 `json:"reference_id"`
 	Text   string `json:"text"`
 	Source string `json:"source,omitempty"`
+	// The doc id of the reference, which is used to invalidate cached responses
+	// when the document changes.
+	SourceId string `json:"source_id,omitempty"`
 }
 
 type GenerateRequest struct {
@@ -19,13 +23,15 @@ type GenerateRequest struct {
 }
 
 // DefaultHTTPClient returns an http.Client with sensible defaults for connection pooling
+// that uses the platform's outbound proxy settings.
 func DefaultHTTPClient() *http.Client {
+	transport := utils.OutboundTransport()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+
 	return &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Timeout:   30 * time.Second,
+		Transport: transport,
 	}
 }
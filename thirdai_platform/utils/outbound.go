@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// OutboundConfig is the proxy and CA configuration for requests to services
+// outside of the platform, such as llm providers, keycloak, and event or scan
+// services. Requests between platform components do not use it.
+type OutboundConfig struct {
+	HttpProxy  string
+	HttpsProxy string
+	NoProxy    string
+
+	// CaBundle is a pem file with additional CAs to trust, for example the CA
+	// of a proxy that intercepts tls connections.
+	CaBundle string
+}
+
+// OutboundConfigFromEnv loads the config from the OUTBOUND_* env vars, falling
+// back to the standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY env vars.
+func OutboundConfigFromEnv() OutboundConfig {
+	fromEnv := httpproxy.FromEnvironment()
+	withDefault := func(key, defaultValue string) string {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		return defaultValue
+	}
+
+	return OutboundConfig{
+		HttpProxy:  withDefault("OUTBOUND_HTTP_PROXY", fromEnv.HTTPProxy),
+		HttpsProxy: withDefault("OUTBOUND_HTTPS_PROXY", fromEnv.HTTPSProxy),
+		NoProxy:    withDefault("OUTBOUND_NO_PROXY", fromEnv.NoProxy),
+		CaBundle:   os.Getenv("OUTBOUND_CA_BUNDLE"),
+	}
+}
+
+func (c OutboundConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := (&httpproxy.Config{HTTPProxy: c.HttpProxy, HTTPSProxy: c.HttpsProxy, NoProxy: c.NoProxy}).ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}
+}
+
+func (c OutboundConfig) rootCAs(trustedCerts []byte) (*x509.CertPool, error) {
+	if c.CaBundle == "" && len(trustedCerts) == 0 {
+		return nil, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if c.CaBundle != "" {
+		pem, err := os.ReadFile(c.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %v", c.CaBundle)
+		}
+	}
+
+	if len(trustedCerts) > 0 && !pool.AppendCertsFromPEM(trustedCerts) {
+		return nil, fmt.Errorf("no certificates found in trusted certs")
+	}
+
+	return pool, nil
+}
+
+// JobEnv returns the env vars that configure the same proxy in jobs. Both
+// cases are set since python libraries differ in which ones they check.
+func (c OutboundConfig) JobEnv(internalHosts ...string) map[string]string {
+	env := map[string]string{}
+	if c.HttpProxy == "" && c.HttpsProxy == "" {
+		return env
+	}
+
+	noProxy := []string{"localhost", "127.0.0.1"}
+	if c.NoProxy != "" {
+		noProxy = append(noProxy, c.NoProxy)
+	}
+	for _, host := range internalHosts {
+		if host != "" {
+			noProxy = append(noProxy, host)
+		}
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			env[strings.ToUpper(key)] = value
+			env[strings.ToLower(key)] = value
+		}
+	}
+	set("HTTP_PROXY", c.HttpProxy)
+	set("HTTPS_PROXY", c.HttpsProxy)
+	set("NO_PROXY", strings.Join(noProxy, ","))
+
+	return env
+}
+
+var (
+	outboundMu     sync.RWMutex
+	outboundConfig = OutboundConfigFromEnv()
+	trustedCerts   []byte
+)
+
+// ConfigureOutbound sets the config used by OutboundTransport.
+func ConfigureOutbound(config OutboundConfig) error {
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+
+	if _, err := config.rootCAs(trustedCerts); err != nil {
+		return err
+	}
+	outboundConfig = config
+
+	return nil
+}
+
+// SetTrustedCerts sets additional pem encoded CAs to trust, on top of the
+// system CAs and the ca bundle in the config. Passing nil removes them.
+func SetTrustedCerts(pem []byte) error {
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+
+	if _, err := outboundConfig.rootCAs(pem); err != nil {
+		return err
+	}
+	trustedCerts = pem
+
+	return nil
+}
+
+// OutboundTransport returns a new transport for requests to external services.
+// Changes to the config or trusted certs only apply to transports created
+// afterwards. If the ca bundle cannot be loaded the system CAs are used.
+func OutboundTransport() *http.Transport {
+	outboundMu.RLock()
+	config, certs := outboundConfig, trustedCerts
+	outboundMu.RUnlock()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = config.proxyFunc()
+
+	if pool, err := config.rootCAs(certs); err == nil && pool != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport
+}
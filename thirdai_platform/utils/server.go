@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/caarlos0/env/v10"
+)
+
+// ServerTimeouts bound how long a client can hold a connection, so that slow
+// or idle clients cannot exhaust the server's connections. The durations use
+// go's duration format, for example 30s or 5m.
+type ServerTimeouts struct {
+	ReadHeader     time.Duration `env:"SERVER_READ_HEADER_TIMEOUT" envDefault:"10s"`
+	Read           time.Duration `env:"SERVER_READ_TIMEOUT" envDefault:"2m"`
+	Write          time.Duration `env:"SERVER_WRITE_TIMEOUT" envDefault:"2m"`
+	Idle           time.Duration `env:"SERVER_IDLE_TIMEOUT" envDefault:"2m"`
+	MaxHeaderBytes int           `env:"SERVER_MAX_HEADER_BYTES" envDefault:"131072"`
+}
+
+func ServerTimeoutsFromEnv() ServerTimeouts {
+	var timeouts ServerTimeouts
+	if err := env.Parse(&timeouts); err != nil {
+		log.Fatalf("invalid server timeouts: %v", err)
+	}
+	return timeouts
+}
+
+func NewServer(addr string, handler http.Handler, timeouts ServerTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		MaxHeaderBytes:    timeouts.MaxHeaderBytes,
+	}
+}
+
+// WithTimeouts overrides the server's read and write timeouts for routes that
+// stream large bodies, such as uploads, downloads, and generation. The new
+// deadlines are relative to when the handler starts, and 0 removes the deadline.
+func WithTimeouts(read, write time.Duration) func(http.Handler) http.Handler {
+	deadline := func(d time.Duration) time.Time {
+		if d == 0 {
+			return time.Time{}
+		}
+		return time.Now().Add(d)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(deadline(read)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				slog.Warn("unable to set read deadline", "path", r.URL.Path, "error", err)
+			}
+			if err := rc.SetWriteDeadline(deadline(write)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				slog.Warn("unable to set write deadline", "path", r.URL.Path, "error", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
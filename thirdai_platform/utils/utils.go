@@ -26,8 +26,12 @@ func ParseRequestBody(w http.ResponseWriter, r *http.Request, dest interface{})
 }
 
 func WriteJsonResponse(w http.ResponseWriter, data interface{}) {
+	WriteJsonResponseWithStatus(w, data, http.StatusOK)
+}
+
+func WriteJsonResponseWithStatus(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	err := json.NewEncoder(w).Encode(data)
 	if err != nil {
 		slog.Error("error serializing response body", "error", err)
@@ -62,6 +66,27 @@ func URLParamUUID(r *http.Request, key string) (uuid.UUID, error) {
 	return id, nil
 }
 
+// AcceptsEncoding returns if the Accept-Encoding header of the request allows
+// the response to be sent with the given content encoding.
+func AcceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, accepted := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(accepted, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+				continue
+			}
+			// An encoding with q=0 is explicitly not acceptable.
+			if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
 func BoolEnvVar(key string) bool {
 	value := os.Getenv(key)
 	return strings.ToLower(value) == "true"
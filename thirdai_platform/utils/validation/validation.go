@@ -0,0 +1,305 @@
+// Package validation checks requests using validate struct tags, and collects
+// every error in the request along with the json path of the field it is for,
+// so that clients can show the errors next to the fields.
+//
+// The tags are a comma separated list of rules:
+//
+//	required    the field must not be empty, nil, or zero
+//	min=n       numbers must be >= n, strings and slices must have at least n items
+//	max=n       numbers must be <= n, strings and slices must have at most n items
+//	gt=n, lt=n  numbers must be > n or < n
+//	oneof=a b   the value must be one of the space separated options
+//
+// Rules other than required are not checked for empty values, so optional
+// fields only need to be valid when they are specified. Other rules can be
+// added with Register. Fields tagged with validate:"-" are not checked, for
+// values that are validated explicitly.
+//
+// Rules that depend on several fields, and defaults for unspecified fields, are
+// implemented with a Validate method, which is called for nested values when
+// the request is checked.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FieldError is an error for a single field. Field is the json path of the
+// field, for example "data.supervised_files[0].location", or empty for errors
+// that are not for a specific field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of errors found when validating a value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		if err.Field == "" {
+			messages = append(messages, err.Message)
+		} else {
+			messages = append(messages, fmt.Sprintf("%v: %v", err.Field, err.Message))
+		}
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Err returns the errors, or nil if there are none, so that an empty list is
+// not returned as a non nil error.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *Errors) Add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Merge adds the errors from validating the value of field. If err is Errors
+// then the fields of its errors are prefixed with field, otherwise err is added
+// as an error for field.
+func (e *Errors) Merge(field string, err error) {
+	if err == nil {
+		return
+	}
+	var nested Errors
+	if !errors.As(err, &nested) {
+		e.Add(field, "%v", err)
+		return
+	}
+	for _, fieldErr := range nested {
+		*e = append(*e, FieldError{Field: joinPath(field, fieldErr.Field), Message: fieldErr.Message})
+	}
+}
+
+func joinPath(path, field string) string {
+	switch {
+	case path == "":
+		return field
+	case field == "":
+		return path
+	case strings.HasPrefix(field, "["):
+		return path + field
+	default:
+		return path + "." + field
+	}
+}
+
+// Validator is implemented by types with rules that cannot be expressed with
+// tags. Validate may also set defaults for fields that were not specified.
+// Implementations should call Struct to check their own tags.
+type Validator interface {
+	Validate() error
+}
+
+// Rule checks a value for a tag. It is only called for values that are not
+// empty, and returns a message describing why the value is invalid, or an
+// empty string if it is valid.
+type Rule func(value reflect.Value, param string) string
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"min":   compareRule("min"),
+		"max":   compareRule("max"),
+		"gt":    compareRule("gt"),
+		"lt":    compareRule("lt"),
+		"oneof": oneOf,
+	}
+)
+
+// Register adds a rule that can be used in validate tags. It panics if a rule
+// with the same name exists, and should be called from an init function.
+func Register(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if _, ok := rules[name]; ok || name == "required" {
+		panic(fmt.Sprintf("validation rule '%v' is already registered", name))
+	}
+	rules[name] = rule
+}
+
+func getRule(name string) (Rule, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	rule, ok := rules[name]
+	return rule, ok
+}
+
+// Struct checks the tags of the fields of v, which must be a pointer to a
+// struct, and validates nested structs, pointers, and slices. Nested values
+// that implement Validator are checked by calling Validate instead.
+func Struct(v interface{}) Errors {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation.Struct requires a pointer to a struct, got %T", v))
+	}
+
+	var errs Errors
+	checkStruct(value.Elem(), "", &errs)
+	return errs
+}
+
+func checkStruct(v reflect.Value, path string, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Fields of embedded structs are serialized as fields of the outer
+		// struct, so they have the same path.
+		fieldPath := path
+		if !field.Anonymous || name != "" {
+			if name == "" {
+				name = field.Name
+			}
+			fieldPath = joinPath(path, name)
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+
+		value := v.Field(i)
+		if tag != "" && !checkTag(value, tag, fieldPath, errs) {
+			continue
+		}
+		checkValue(value, fieldPath, errs)
+	}
+}
+
+func checkValue(v reflect.Value, path string, errs *Errors) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if validator, ok := v.Interface().(Validator); ok {
+			errs.Merge(path, validator.Validate())
+			return
+		}
+		checkValue(v.Elem(), path, errs)
+	case reflect.Struct:
+		if v.CanAddr() {
+			if validator, ok := v.Addr().Interface().(Validator); ok {
+				errs.Merge(path, validator.Validate())
+				return
+			}
+		}
+		checkStruct(v, path, errs)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			checkValue(v.Index(i), fmt.Sprintf("%v[%d]", path, i), errs)
+		}
+	}
+}
+
+// checkTag returns false if the value is missing or invalid, in which case the
+// nested fields of the value are not checked.
+func checkTag(v reflect.Value, tag string, path string, errs *Errors) bool {
+	empty := v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0)
+
+	for _, ruleTag := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(ruleTag, "=")
+		if name == "required" {
+			if empty {
+				errs.Add(path, "must be specified")
+				return false
+			}
+			continue
+		}
+
+		rule, ok := getRule(name)
+		if !ok {
+			panic(fmt.Sprintf("unknown validation rule '%v' for field %v", name, path))
+		}
+		if empty {
+			continue
+		}
+
+		for v.Kind() == reflect.Pointer {
+			v = v.Elem()
+		}
+		if message := rule(v, param); message != "" {
+			errs.Add(path, "%v", message)
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareRule(op string) Rule {
+	return func(v reflect.Value, param string) string {
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid parameter '%v' for validation rule '%v'", param, op))
+		}
+
+		var value float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			value = v.Float()
+		case reflect.String, reflect.Slice, reflect.Map:
+			length, unit := v.Len(), "items"
+			if v.Kind() == reflect.String {
+				length, unit = len([]rune(v.String())), "characters"
+			}
+			switch {
+			case op == "min" && float64(length) < limit:
+				return fmt.Sprintf("must have at least %v %v", param, unit)
+			case op == "max" && float64(length) > limit:
+				return fmt.Sprintf("must have at most %v %v", param, unit)
+			}
+			return ""
+		default:
+			panic(fmt.Sprintf("validation rule '%v' does not support %v", op, v.Type()))
+		}
+
+		switch {
+		case op == "min" && value < limit:
+			return fmt.Sprintf("must be >= %v", param)
+		case op == "max" && value > limit:
+			return fmt.Sprintf("must be <= %v", param)
+		case op == "gt" && value <= limit:
+			return fmt.Sprintf("must be > %v", param)
+		case op == "lt" && value >= limit:
+			return fmt.Sprintf("must be < %v", param)
+		}
+		return ""
+	}
+}
+
+func oneOf(v reflect.Value, param string) string {
+	options := strings.Fields(param)
+	value := fmt.Sprint(v.Interface())
+	if slices.Contains(options, value) {
+		return ""
+	}
+	return fmt.Sprintf("invalid value '%v', must be one of '%v'", value, strings.Join(options, "', '"))
+}
@@ -0,0 +1,110 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testFile struct {
+	Path     string `json:"path" validate:"required"`
+	Location string `json:"location" validate:"required,oneof=upload s3"`
+}
+
+type testOptions struct {
+	Columns []string `json:"columns" validate:"min=2"`
+	Epochs  int      `json:"epochs" validate:"gt=0,max=100"`
+
+	defaulted bool
+}
+
+func (opts *testOptions) Validate() error {
+	opts.defaulted = true
+	errs := Struct(opts)
+	if len(opts.Columns) > 0 && opts.Columns[0] == opts.Columns[len(opts.Columns)-1] {
+		errs.Add("columns", "first and last columns must be different")
+	}
+	return errs.Err()
+}
+
+type EmbeddedOptions struct {
+	Seed *int `json:"seed" validate:"min=0"`
+}
+
+type testRequest struct {
+	Name    string       `json:"name" validate:"required"`
+	Files   []testFile   `json:"files" validate:"required"`
+	Options *testOptions `json:"options"`
+	Ratio   *float32     `json:"ratio" validate:"min=0,lt=1"`
+	Skipped testFile     `json:"skipped" validate:"-"`
+
+	EmbeddedOptions
+}
+
+func TestStruct(t *testing.T) {
+	ratio, seed := float32(1), -1
+	req := testRequest{
+		Files:           []testFile{{Path: "a", Location: "s3"}, {Location: "ftp"}},
+		Options:         &testOptions{Columns: []string{"x"}, Epochs: 0},
+		Ratio:           &ratio,
+		EmbeddedOptions: EmbeddedOptions{Seed: &seed},
+	}
+
+	errs := Struct(&req)
+
+	expected := Errors{
+		{Field: "name", Message: "must be specified"},
+		{Field: "files[1].path", Message: "must be specified"},
+		{Field: "files[1].location", Message: "invalid value 'ftp', must be one of 'upload', 's3'"},
+		{Field: "options.columns", Message: "must have at least 2 items"},
+		{Field: "options.columns", Message: "first and last columns must be different"},
+		{Field: "ratio", Message: "must be < 1"},
+		{Field: "seed", Message: "must be >= 0"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf("expected errors %+v, got %+v", expected, errs)
+	}
+	if !req.Options.defaulted {
+		t.Fatal("Validate should be called for nested values")
+	}
+
+	ratio, seed = 0.5, 1
+	valid := testRequest{
+		Name:            "abc",
+		Files:           []testFile{{Path: "a", Location: "upload"}},
+		Options:         &testOptions{Columns: []string{"x", "y"}, Epochs: 10},
+		Ratio:           &ratio,
+		EmbeddedOptions: EmbeddedOptions{Seed: &seed},
+	}
+	if err := Struct(&valid).Err(); err != nil {
+		t.Fatalf("request should be valid: %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var nested Errors
+	nested.Add("path", "must be specified")
+	nested.Add("[2]", "invalid file")
+
+	var errs Errors
+	errs.Merge("data", nested)
+	errs.Merge("data", nil)
+	errs.Merge("llm_provider", errors.New("no key for provider"))
+
+	expected := Errors{
+		{Field: "data.path", Message: "must be specified"},
+		{Field: "data[2]", Message: "invalid file"},
+		{Field: "llm_provider", Message: "no key for provider"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf("expected errors %+v, got %+v", expected, errs)
+	}
+
+	if errs.Error() != "data.path: must be specified\ndata[2]: invalid file\nllm_provider: no key for provider" {
+		t.Fatalf("invalid error message: %v", errs.Error())
+	}
+
+	if err := (Errors{}).Err(); err != nil {
+		t.Fatalf("empty errors should be nil: %v", err)
+	}
+}
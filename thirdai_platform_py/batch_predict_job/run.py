@@ -0,0 +1,216 @@
+try:
+    import argparse
+    import json
+    import logging
+    import sys
+    import tempfile
+    from pathlib import Path
+    from typing import Dict, List
+    from urllib.parse import urljoin
+
+    import numpy as np
+    import pandas as pd
+    import requests
+    from licensing.verify import verify_license
+    from platform_common.file_handler import (
+        expand_cloud_buckets_and_directories,
+        get_local_file_infos,
+    )
+    from platform_common.logging import JobLogger, LogCode
+    from platform_common.pii.data_types import UnstructuredText
+    from platform_common.pydantic_models.batch_predict import BatchPredictConfig
+    from platform_common.pydantic_models.training import ModelType, TextPreprocessing
+    from thirdai import bolt
+except ImportError as e:
+    logging.error(f"Failed to import module: {e}")
+    sys.exit(f"ImportError: {e}")
+
+
+class BatchPredictReporter:
+    def __init__(self, config: BatchPredictConfig, logger: JobLogger):
+        self.url = urljoin(
+            config.model_bazaar_endpoint, "api/v2/batch-predict/update-status"
+        )
+        self.headers = {
+            "Authorization": f"Bearer {config.job_auth_token}",
+            "User-Agent": "Batch predict job",
+        }
+        self.logger = logger
+
+    def report(self, status: str, message: str = "", **progress):
+        response = requests.post(
+            self.url,
+            headers=self.headers,
+            json={"status": status, "message": message, **progress},
+        )
+        response.raise_for_status()
+
+    def report_progress(self, rows_processed: int, total_rows: int):
+        # Progress updates are informational, so failures do not stop the job.
+        try:
+            self.report(
+                "in_progress", rows_processed=rows_processed, total_rows=total_rows
+            )
+        except requests.exceptions.RequestException as e:
+            self.logger.warning(f"Error reporting batch prediction progress: {e}")
+
+
+def model_dir(config: BatchPredictConfig) -> Path:
+    return Path(config.model_bazaar_dir) / "models" / config.model_id / "model"
+
+
+def load_udt(config: BatchPredictConfig) -> bolt.UniversalDeepTransformer:
+    return bolt.UniversalDeepTransformer.load(str(model_dir(config) / "model.udt"))
+
+
+class TextPredictor:
+    def __init__(self, config: BatchPredictConfig):
+        self.model = load_udt(config)
+        self.text_col = self.model.text_dataset_config().text_column
+        # The preprocessing applied to the training data is applied to queries.
+        self.preprocessing = TextPreprocessing.load(str(model_dir(config)))
+        self.top_k = config.options.top_k
+
+    def predict(self, texts: List[str]) -> Dict[str, list]:
+        activations = self.model.predict_batch(
+            [{self.text_col: self.preprocessing.apply(text)} for text in texts]
+        )
+
+        columns = {"predicted_class": [], "score": []}
+        if self.top_k > 1:
+            columns["predictions"] = []
+
+        for row in activations:
+            top = np.argsort(-row)[: self.top_k]
+            classes = [
+                {"class": self.model.class_name(int(i)), "score": float(row[i])}
+                for i in top
+            ]
+            columns["predicted_class"].append(classes[0]["class"])
+            columns["score"].append(classes[0]["score"])
+            if self.top_k > 1:
+                columns["predictions"].append(json.dumps(classes))
+
+        return columns
+
+
+class TokenPredictor:
+    def __init__(self, config: BatchPredictConfig):
+        self.model = load_udt(config)
+
+    def predict(self, texts: List[str]) -> Dict[str, list]:
+        tags = []
+        for text in texts:
+            log = UnstructuredText(text)
+            result = log.process_prediction(
+                self.model.predict(log.inference_sample, top_k=1, as_unicode=True)
+            )
+            tags.append(" ".join(tag[0] for tag in result.predicted_tags))
+        return {"predicted_tags": tags}
+
+
+def get_predictor(config: BatchPredictConfig):
+    if config.model_type == ModelType.NLP_TEXT:
+        return TextPredictor(config)
+    if config.model_type == ModelType.NLP_TOKEN:
+        return TokenPredictor(config)
+    raise ValueError(
+        f"Batch prediction is not supported for model type {config.model_type.value}"
+    )
+
+
+def count_rows(path: str, config: BatchPredictConfig) -> int:
+    return sum(
+        len(chunk)
+        for chunk in pd.read_csv(
+            path,
+            usecols=[config.options.text_column],
+            chunksize=config.options.batch_size,
+        )
+    )
+
+
+def run(config: BatchPredictConfig, reporter: BatchPredictReporter, logger: JobLogger):
+    predictor = get_predictor(config)
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        files = get_local_file_infos(
+            expand_cloud_buckets_and_directories([config.input_file]), tmp_dir
+        )
+        paths = sorted(file.path for file in files if file.ext().lower() == ".csv")
+        if not paths:
+            raise ValueError(f"No csv files found in '{config.input_file.path}'")
+
+        total_rows = sum(count_rows(path, config) for path in paths)
+        logger.info(
+            f"Running batch prediction over {total_rows} rows in {len(paths)} files",
+            code=LogCode.MODEL_PREDICT,
+        )
+        reporter.report_progress(0, total_rows)
+
+        Path(config.output_path).parent.mkdir(parents=True, exist_ok=True)
+
+        rows_processed = 0
+        for path in paths:
+            for chunk in pd.read_csv(path, chunksize=config.options.batch_size):
+                texts = chunk[config.options.text_column].fillna("").astype(str)
+                for column, values in predictor.predict(texts.tolist()).items():
+                    chunk[column] = values
+
+                chunk.to_csv(
+                    config.output_path,
+                    mode="w" if rows_processed == 0 else "a",
+                    header=rows_processed == 0,
+                    index=False,
+                )
+
+                rows_processed += len(chunk)
+                reporter.report_progress(rows_processed, total_rows)
+
+    return rows_processed, total_rows
+
+
+def load_config():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--config", type=str, required=True)
+
+    args = parser.parse_args()
+
+    with open(args.config) as file:
+        return BatchPredictConfig.model_validate_json(file.read())
+
+
+def main():
+    config: BatchPredictConfig = load_config()
+    log_dir: Path = Path(config.model_bazaar_dir) / "logs" / config.model_id
+
+    logger = JobLogger(
+        log_dir=log_dir,
+        log_prefix="batch_predict",
+        service_type="batch_predict",
+        model_id=config.model_id,
+        model_type=config.model_type,
+        user_id=config.user_id,
+    )
+    reporter = BatchPredictReporter(config, logger)
+
+    try:
+        verify_license.activate_thirdai_license(config.license_key)
+
+        rows_processed, total_rows = run(config, reporter, logger)
+
+        reporter.report(
+            "complete", rows_processed=rows_processed, total_rows=total_rows
+        )
+        logger.info(
+            f"Batch prediction {config.job_id} complete", code=LogCode.MODEL_PREDICT
+        )
+    except Exception as error:
+        message = f"Batch prediction failed with error: '{error}'"
+        logger.error(message, code=LogCode.MODEL_PREDICT)
+        reporter.report("failed", message=message)
+        raise error
+
+
+if __name__ == "__main__":
+    main()
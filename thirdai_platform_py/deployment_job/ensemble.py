@@ -0,0 +1,66 @@
+from collections import defaultdict
+from typing import Any, Dict, List
+
+MAJORITY_VOTE = "majority_vote"
+SCORE_AVERAGE = "score_average"
+
+
+def average_scores(member_predictions: List[List[Dict[str, Any]]]) -> Dict[str, float]:
+    """
+    Averages the score of each class over all members. A class that is missing
+    from the predictions of a member contributes a score of 0 for that member.
+    """
+    totals = defaultdict(float)
+    for predictions in member_predictions:
+        for pred in predictions:
+            totals[pred["class"]] += pred["score"]
+
+    n_members = len(member_predictions)
+    return {label: total / n_members for label, total in totals.items()}
+
+
+def score_average(
+    member_predictions: List[List[Dict[str, Any]]], top_k: int
+) -> List[Dict[str, Any]]:
+    averaged = [
+        {"class": label, "score": score}
+        for label, score in average_scores(member_predictions).items()
+    ]
+    averaged.sort(key=lambda pred: (-pred["score"], pred["class"]))
+    return averaged[:top_k]
+
+
+def majority_vote(
+    member_predictions: List[List[Dict[str, Any]]], top_k: int
+) -> List[Dict[str, Any]]:
+    """
+    Each member votes for its highest scoring class, and the score of a class is
+    the fraction of members that voted for it. Ties are broken by the average
+    score of the tied classes, so classes without any votes are still ranked.
+    """
+    votes = defaultdict(int)
+    for predictions in member_predictions:
+        if predictions:
+            top = max(predictions, key=lambda pred: pred["score"])
+            votes[top["class"]] += 1
+
+    avg_scores = average_scores(member_predictions)
+
+    n_members = len(member_predictions)
+    ranked = sorted(
+        avg_scores.keys(),
+        key=lambda label: (-votes[label], -avg_scores[label], label),
+    )
+    return [
+        {"class": label, "score": votes[label] / n_members} for label in ranked[:top_k]
+    ]
+
+
+def combine_predictions(
+    strategy: str, member_predictions: List[List[Dict[str, Any]]], top_k: int
+) -> List[Dict[str, Any]]:
+    if strategy == MAJORITY_VOTE:
+        return majority_vote(member_predictions, top_k)
+    if strategy == SCORE_AVERAGE:
+        return score_average(member_predictions, top_k)
+    raise ValueError(f"Unsupported ensemble strategy '{strategy}'.")
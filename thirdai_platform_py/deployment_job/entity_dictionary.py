@@ -0,0 +1,104 @@
+import re
+import threading
+import time
+from typing import List, Pattern, Tuple
+
+from deployment_job.reporter import Reporter
+from platform_common.logging import JobLogger, LogCode
+
+TOKEN_RE = re.compile(r"\S+")
+
+# How often the deployment checks for a new version of the dictionary.
+SYNC_INTERVAL_SECONDS = 30
+
+
+class EntityDictionary:
+    """
+    Tags the matches of the entries of the entity dictionary of the deployment,
+    overriding the tags predicted by the model. This is used for entities that
+    the model does not know, such as internal project names or ticket ids.
+
+    An exact pattern matches at word boundaries, and a regex pattern matches
+    anywhere in the text. Entries are case insensitive unless case_sensitive is
+    set. Every token that overlaps a match is tagged, and if the matches of
+    several entries overlap then the later entry wins.
+    """
+
+    def __init__(self, logger: JobLogger):
+        self.logger = logger
+        self.version = 0
+        self.patterns: List[Tuple[Pattern, str]] = []
+        self.lock = threading.Lock()
+
+    def load(self, dictionary: dict):
+        patterns = []
+        for entry in dictionary.get("entries") or []:
+            flags = 0 if entry.get("case_sensitive") else re.IGNORECASE
+            if entry.get("regex"):
+                pattern = entry["pattern"]
+            else:
+                pattern = rf"(?<!\w){re.escape(entry['pattern'])}(?!\w)"
+            try:
+                patterns.append((re.compile(pattern, flags), entry["tag"]))
+            except re.error as e:
+                # Patterns are validated with go regex syntax when they are
+                # added, which is not the same as the python syntax.
+                self.logger.warning(
+                    f"Skipping entity dictionary pattern '{entry['pattern']}': {e}",
+                    code=LogCode.MODEL_PREDICT,
+                )
+
+        with self.lock:
+            self.version = dictionary.get("version", 0)
+            self.patterns = patterns
+
+    def apply(self, text: str, predicted_tags: List[List[str]]) -> List[List[str]]:
+        """
+        Returns the tags of the whitespace separated tokens of the text with the
+        tokens that overlap a match of an entry replaced by the tag of the entry.
+        """
+        with self.lock:
+            patterns = self.patterns
+        if not patterns:
+            return predicted_tags
+
+        tokens = [(m.start(), m.end()) for m in TOKEN_RE.finditer(text)]
+        tags = list(predicted_tags)
+        for pattern, tag in patterns:
+            for match in pattern.finditer(text):
+                if match.start() == match.end():
+                    continue
+                for i, (start, end) in enumerate(tokens):
+                    if start < match.end() and match.start() < end:
+                        tags[i] = [tag]
+        return tags
+
+    def sync(self, reporter: Reporter):
+        try:
+            dictionary = reporter.get_entity_dictionary()
+        except Exception as e:
+            # The current entries are kept until the next sync succeeds.
+            self.logger.error(
+                f"Error syncing entity dictionary: {e}", code=LogCode.MODEL_PREDICT
+            )
+            return
+
+        if dictionary.get("version", 0) != self.version:
+            self.load(dictionary)
+            self.logger.info(
+                f"Loaded version {self.version} of the entity dictionary",
+                code=LogCode.MODEL_PREDICT,
+            )
+
+    def start_sync(self, reporter: Reporter):
+        """
+        Loads the dictionary and then checks for new versions in the background.
+        """
+        self.sync(reporter)
+
+        def run():
+            while True:
+                time.sleep(SYNC_INTERVAL_SECONDS)
+                self.sync(reporter)
+
+        threading.Thread(target=run, daemon=True).start()
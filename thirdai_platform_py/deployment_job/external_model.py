@@ -0,0 +1,92 @@
+import json
+from typing import Any, Dict
+
+import numpy as np
+from jsonschema import Draft202012Validator
+
+ONNX_RUNTIME = "onnx"
+CONTAINER_RUNTIME = "container"
+
+ONNX_MODEL_FILE = "model.onnx"
+
+
+def load_schema(options: Dict[str, str], key: str) -> Draft202012Validator:
+    """
+    Loads the json schema declared for the external model, the schemas are
+    stored as json strings in the model attributes.
+    """
+    schema = json.loads(options[key])
+    Draft202012Validator.check_schema(schema)
+    return Draft202012Validator(schema)
+
+
+def schema_errors(validator: Draft202012Validator, body: Any) -> str:
+    """
+    Returns a description of the ways the body does not match the schema, or
+    an empty string if it matches.
+    """
+    errors = sorted(validator.iter_errors(body), key=lambda e: list(e.path))
+    return "; ".join(
+        f"{'/'.join(str(p) for p in error.path) or '<root>'}: {error.message}"
+        for error in errors
+    )
+
+
+class OnnxModel:
+    """
+    Runs an onnx model with onnxruntime. Requests are of the form
+    {"inputs": {<input name>: <nested list>}} and responses are of the form
+    {"outputs": {<output name>: <nested list>}}.
+    """
+
+    def __init__(self, path: str):
+        import onnxruntime
+
+        self.session = onnxruntime.InferenceSession(
+            path, providers=["CPUExecutionProvider"]
+        )
+        self.input_types = {
+            input.name: onnx_dtype(input.type) for input in self.session.get_inputs()
+        }
+        self.output_names = [output.name for output in self.session.get_outputs()]
+
+    def predict(self, body: Dict[str, Any]) -> Dict[str, Any]:
+        inputs = body.get("inputs")
+        if not isinstance(inputs, dict):
+            raise ValueError("request must contain an 'inputs' object")
+
+        missing = set(self.input_types) - set(inputs)
+        if missing:
+            raise ValueError(f"missing model inputs: {', '.join(sorted(missing))}")
+
+        feed = {
+            name: np.asarray(inputs[name], dtype=dtype)
+            for name, dtype in self.input_types.items()
+        }
+        outputs = self.session.run(self.output_names, feed)
+
+        return {
+            "outputs": {
+                name: output.tolist() if isinstance(output, np.ndarray) else output
+                for name, output in zip(self.output_names, outputs)
+            }
+        }
+
+
+ONNX_DTYPES = {
+    "tensor(float)": np.float32,
+    "tensor(double)": np.float64,
+    "tensor(float16)": np.float16,
+    "tensor(int64)": np.int64,
+    "tensor(int32)": np.int32,
+    "tensor(int8)": np.int8,
+    "tensor(uint8)": np.uint8,
+    "tensor(bool)": np.bool_,
+    "tensor(string)": np.object_,
+}
+
+
+def onnx_dtype(onnx_type: str):
+    if onnx_type not in ONNX_DTYPES:
+        raise ValueError(f"unsupported onnx input type '{onnx_type}'")
+    return ONNX_DTYPES[onnx_type]
@@ -1,8 +1,10 @@
+import json
 import re
 from collections import defaultdict
-from typing import Dict, List
+from typing import Dict, List, Optional
 from urllib.parse import urljoin
 
+from cryptography.fernet import Fernet, InvalidToken
 from deployment_job.pydantic_models.inputs import PiiEntity
 from fastapi import HTTPException, status
 from platform_common.logging import JobLogger, LogCode
@@ -69,13 +71,57 @@ class LabelMap:
         ]
 
 
+REDACT_MASK = "mask"
+REDACT_TOKENIZE = "tokenize"
+REDACT_DROP = "drop"
+
+
+class RedactionPolicy:
+    """
+    The action to take on each type of entity detected by the guardrail. Masked
+    entities are replaced by their tag, tokenized entities are replaced by a
+    label that can be unredacted, and dropped entities are removed.
+    """
+
+    def __init__(
+        self,
+        default: str = REDACT_TOKENIZE,
+        entities: Optional[Dict[str, str]] = None,
+    ):
+        self.default = default
+        self.entities = entities or {}
+
+    @staticmethod
+    def from_options(options: dict) -> "RedactionPolicy":
+        policy = options.get("redaction_policy")
+        if not policy:
+            return RedactionPolicy()
+        policy = json.loads(policy)
+        return RedactionPolicy(
+            default=policy.get("default") or REDACT_TOKENIZE,
+            entities=policy.get("entities"),
+        )
+
+    def action(self, tag: str) -> str:
+        return self.entities.get(tag, self.default)
+
+
 class Guardrail:
     def __init__(
-        self, guardrail_model_id: str, model_bazaar_endpoint: str, logger: JobLogger
+        self,
+        guardrail_model_id: str,
+        model_bazaar_endpoint: str,
+        logger: JobLogger,
+        policy: Optional[RedactionPolicy] = None,
+        redaction_key: Optional[str] = None,
     ):
         self.session = Session()
         self.endpoint = urljoin(model_bazaar_endpoint, f"{guardrail_model_id}/predict")
         self.logger = logger
+        self.policy = policy or RedactionPolicy()
+        # The key is used to encrypt the original text of tokenized entities,
+        # so that only users with the unredact permission can recover it.
+        self.fernet = Fernet(redaction_key) if redaction_key else None
 
     def query_pii_model(self, text: str, access_token: str, auth_scheme: str):
         if auth_scheme == "api_key":
@@ -121,12 +167,18 @@ class Guardrail:
                 tokens=data["tokens"], tags=data["predicted_tags"]
             )
 
-            entities = [
-                label_map.get_label(tag=tag, entity=entity) if tag != "O" else entity
-                for entity, tag in zip(entities, tags)
-            ]
-
-            return " ".join(entities)
+            redacted = []
+            for entity, tag in zip(entities, tags):
+                if tag == "O":
+                    redacted.append(entity)
+                    continue
+                action = self.policy.action(tag)
+                if action == REDACT_MASK:
+                    redacted.append(f"[{tag}]")
+                elif action == REDACT_TOKENIZE:
+                    redacted.append(label_map.get_label(tag=tag, entity=entity))
+
+            return " ".join(redacted)
         except Exception as e:
             message = f"Error redacting PII: {e}"
             self.logger.error(message, code=LogCode.GUARDRAILS)
@@ -141,10 +193,35 @@ class Guardrail:
             def replace(match):
                 return entity_map.get(match[0], "[UNKNOWN ENTITY]")
 
-            return re.sub(r"\[([A-Z]+)#(\d+)\]", replace, redacted_text)
+            return re.sub(r"\[([A-Za-z0-9_-]+)#(\d+)\]", replace, redacted_text)
         except Exception as e:
             message = f"Error unredacting PII: {e}"
             self.logger.error(message, code=LogCode.GUARDRAILS)
             raise HTTPException(
                 status_code=status.HTTP_500_INTERNAL_SERVER_ERROR, detail=message
             )
+
+    def encrypt_entities(self, entities: List[PiiEntity]) -> Optional[str]:
+        """
+        Returns a token containing the encrypted entities, which is returned
+        with the redacted text and passed back to unredact it.
+        """
+        if not self.fernet or not entities:
+            return None
+        data = json.dumps([entity.model_dump() for entity in entities])
+        return self.fernet.encrypt(data.encode()).decode()
+
+    def decrypt_entities(self, redaction_token: str) -> List[PiiEntity]:
+        if not self.fernet:
+            raise HTTPException(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                detail="This workflow is not configured to unredact text.",
+            )
+        try:
+            data = self.fernet.decrypt(redaction_token.encode())
+        except InvalidToken:
+            raise HTTPException(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                detail="Invalid redaction token.",
+            )
+        return [PiiEntity(**entity) for entity in json.loads(data)]
@@ -12,7 +12,9 @@ try:
     import uvicorn
     from deployment_job.permissions import Permissions
     from deployment_job.reporter import Reporter
+    from deployment_job.routers.ensemble import EnsembleRouter
     from deployment_job.routers.enterprise_search import EnterpriseSearchRouter
+    from deployment_job.routers.external import ExternalModelRouter
     from deployment_job.routers.knowledge_extraction import KnowledgeExtractionRouter
     from deployment_job.routers.ndb import NDBRouter
     from deployment_job.routers.udt import (
@@ -143,6 +145,12 @@ elif config.model_type == ModelType.ENTERPRISE_SEARCH:
     backend_router_factory = EnterpriseSearchRouter
 elif config.model_type == ModelType.KNOWLEDGE_EXTRACTION:
     backend_router_factory = KnowledgeExtractionRouter
+elif config.model_type == ModelType.ENSEMBLE:
+    backend_router_factory = EnsembleRouter
+    logger.info("Initializing Ensemble router", code=LogCode.MODEL_INIT)
+elif config.model_type == ModelType.EXTERNAL:
+    backend_router_factory = ExternalModelRouter
+    logger.info("Initializing External Model router", code=LogCode.MODEL_INIT)
 else:
     error_message = f"Unsupported ModelType '{config.model_type}'."
     logger.error(error_message, code=LogCode.MODEL_INIT)
@@ -1,14 +1,25 @@
 from abc import abstractmethod
 from pathlib import Path
-from typing import List, Optional
+from typing import List, Optional, Tuple, Union
 
+from deployment_job.entity_dictionary import EntityDictionary
 from deployment_job.models.model import Model
-from deployment_job.pydantic_models.inputs import SearchResultsTextClassification
+from deployment_job.pydantic_models.inputs import (
+    SearchResultsTextClassification,
+    TextClassificationExplanation,
+    TokenAttribution,
+)
 from fastapi import HTTPException, status
 from platform_common.logging import JobLogger
 from platform_common.logging.logcodes import LogCode
-from platform_common.pii.data_types import UnstructuredText, XMLLog
+from platform_common.pii.data_types import (
+    UnstructuredText,
+    UnstructuredTokenClassificationResults,
+    XMLLog,
+    XMLTokenClassificationResults,
+)
 from platform_common.pydantic_models.deployment import DeploymentConfig
+from platform_common.pydantic_models.training import TextPreprocessing
 from platform_common.thirdai_storage.data_types import (
     DataSample,
     LabelCollection,
@@ -24,6 +35,10 @@ from platform_common.thirdai_storage.data_types import (
 from platform_common.thirdai_storage.storage import DataStorage, SQLiteConnector
 from thirdai import bolt
 
+# Explanations run a prediction for each token, so the length of the text is
+# limited to bound the latency of a request.
+MAX_EXPLAIN_TOKENS = 256
+
 
 class ClassificationModel(Model):
     def __init__(self, config: DeploymentConfig, logger: JobLogger):
@@ -52,6 +67,10 @@ class TextClassificationModel(ClassificationModel):
     def __init__(self, config: DeploymentConfig, logger: JobLogger):
         super().__init__(config=config, logger=logger)
         self.text_col = self.model.text_dataset_config().text_column
+        # The preprocessing applied to the training data is applied to queries.
+        self.preprocessing = TextPreprocessing.load(
+            str(Path(self.get_udt_path()).parent)
+        )
         self.num_classes = self.model.predict({self.text_col: "test"}).shape[-1]
         self.logger.info(
             f"TextClassificationModel initialized with {self.num_classes} classes",
@@ -120,20 +139,93 @@ class TextClassificationModel(ClassificationModel):
     def predict(self, text: str, top_k: int, **kwargs):
         try:
             top_k = min(top_k, self.num_classes)
-            prediction = self.model.predict({self.text_col: text}, top_k=top_k)
+            prediction = self.model.predict(
+                {self.text_col: self.preprocessing.apply(text)}, top_k=top_k
+            )
             predicted_classes = [
                 {"class": self.model.class_name(class_id), "score": float(activation)}
                 for class_id, activation in zip(*prediction)
             ]
 
+            # The other classes are still returned after the abstain label so
+            # that the person reviewing the prediction can see the candidates.
+            thresholds = self.config.confidence_thresholds
+            abstained = (
+                thresholds is not None
+                and len(predicted_classes) > 0
+                and thresholds.should_abstain(
+                    predicted_classes[0]["class"], predicted_classes[0]["score"]
+                )
+            )
+            if abstained:
+                predicted_classes.insert(
+                    0,
+                    {
+                        "class": thresholds.abstain_label,
+                        "score": predicted_classes[0]["score"],
+                    },
+                )
+
             return SearchResultsTextClassification(
                 query_text=text,
                 predicted_classes=predicted_classes,
+                abstained=abstained,
             )
         except Exception as e:
             self.logger.error(f"Error predicting: {e}", code=LogCode.MODEL_PREDICT)
             raise e
 
+    def explain(self, text: str, class_name: Optional[str] = None, **kwargs):
+        """
+        Computes the attribution of each whitespace separated token of the text
+        to the score of the class, as the decrease in the score of the class
+        when the token is removed from the text.
+        """
+        tokens = text.split()
+        if len(tokens) > MAX_EXPLAIN_TOKENS:
+            raise HTTPException(
+                status_code=status.HTTP_422_UNPROCESSABLE_ENTITY,
+                detail=f"text has {len(tokens)} tokens, explanations support at most {MAX_EXPLAIN_TOKENS} tokens",
+            )
+
+        try:
+            texts = [text] + [
+                " ".join(tokens[:i] + tokens[i + 1 :]) for i in range(len(tokens))
+            ]
+            activations = self.model.predict_batch(
+                [{self.text_col: self.preprocessing.apply(t)} for t in texts]
+            )
+        except Exception as e:
+            self.logger.error(f"Error explaining: {e}", code=LogCode.MODEL_PREDICT)
+            raise e
+
+        if class_name is None:
+            class_id = int(activations[0].argmax())
+            class_name = self.model.class_name(class_id)
+        else:
+            class_names = [self.model.class_name(i) for i in range(self.num_classes)]
+            if class_name not in class_names:
+                raise HTTPException(
+                    status_code=status.HTTP_422_UNPROCESSABLE_ENTITY,
+                    detail=f"model does not have class '{class_name}'",
+                )
+            class_id = class_names.index(class_name)
+
+        score = float(activations[0][class_id])
+        attributions = [
+            TokenAttribution(
+                token=token, score=score - float(activations[i + 1][class_id])
+            )
+            for i, token in enumerate(tokens)
+        ]
+
+        return TextClassificationExplanation(
+            query_text=text,
+            class_name=class_name,
+            score=score,
+            attributions=attributions,
+        )
+
     def insert_sample(self, sample: TextClassificationData):
         text_sample = DataSample(
             name="text_classification",
@@ -172,6 +264,7 @@ class TokenClassificationModel(ClassificationModel):
     def __init__(self, config: DeploymentConfig, logger: JobLogger):
         super().__init__(config=config, logger=logger)
         self.load_storage()
+        self.entity_dictionary = EntityDictionary(logger)
 
     def load_storage(self):
         data_storage_path = (
@@ -239,7 +332,11 @@ class TokenClassificationModel(ClassificationModel):
             model_predictions = self.model.predict(
                 log.inference_sample, top_k=1, as_unicode=True
             )
-            result = log.process_prediction(model_predictions)
+            result = log.process_prediction(self.apply_thresholds(model_predictions))
+            if data_type == "unstructured":
+                result.predicted_tags = self.entity_dictionary.apply(
+                    text, result.predicted_tags
+                )
 
         except ValueError as e:
             message = f"Error processing prediction: {e}"
@@ -255,6 +352,41 @@ class TokenClassificationModel(ClassificationModel):
 
         return result
 
+    def apply_thresholds(
+        self, model_predictions: List[List[Tuple[str, float]]]
+    ) -> List[List[Tuple[str, float]]]:
+        """
+        Replaces the tags predicted with a score below their confidence
+        threshold with the abstain label. Tokens predicted as "O" are left
+        unchanged, since most tokens are not entities.
+        """
+        thresholds = self.config.confidence_thresholds
+        if thresholds is None:
+            return model_predictions
+
+        predictions = []
+        for token_predictions in model_predictions:
+            tag, score = token_predictions[0]
+            if tag != "O" and thresholds.should_abstain(tag, score):
+                token_predictions = [(thresholds.abstain_label, score)] + list(
+                    token_predictions[1:]
+                )
+            predictions.append(token_predictions)
+        return predictions
+
+    def abstained(
+        self,
+        result: Union[
+            UnstructuredTokenClassificationResults, XMLTokenClassificationResults
+        ],
+    ) -> bool:
+        thresholds = self.config.confidence_thresholds
+        if thresholds is None:
+            return False
+        if isinstance(result, XMLTokenClassificationResults):
+            return any(p.label == thresholds.abstain_label for p in result.predictions)
+        return any(thresholds.abstain_label in tags for tags in result.predicted_tags)
+
     @property
     def tag_metadata(self) -> TagMetadata:
         # load tags and their status from the storage
@@ -47,6 +47,22 @@ def optional_token_bearer(request: fastapi.Request) -> str:
     return "None"
 
 
+def websocket_token(websocket: fastapi.WebSocket) -> Tuple[str, str]:
+    """
+    Retrieves the token and auth scheme from the headers of a websocket, the
+    same as optional_token_bearer. Returns "None" for the token if there is no
+    token in the headers, since browsers cannot set headers on websockets.
+    """
+    x_api_token = websocket.headers.get("X-API-Key")
+    if x_api_token:
+        return x_api_token, "api_key"
+
+    token_type, _, token = websocket.headers.get("Authorization", "").partition(" ")
+    if token_type.lower() == "bearer" and token:
+        return token, "bearer"
+    return "None", "none"
+
+
 def now() -> datetime.datetime:
     """
     Returns the current UTC time without microseconds.
@@ -135,7 +151,7 @@ class Permissions:
     @classmethod
     def _get_permissions(
         cls, token: str, auth_scheme: str
-    ) -> Tuple[bool, bool, bool, str]:
+    ) -> Tuple[bool, bool, bool, str, bool]:
         """
         Retrieves permissions for a token, updating the cache if necessary.
 
@@ -143,7 +159,8 @@ class Permissions:
             token (str): The access token.
 
         Returns:
-            Tuple[bool, bool, bool, str]: Read, write, override permissions and username.
+            Tuple[bool, bool, bool, str, bool]: Read, write, override permissions,
+            username, and unredact permission.
         """
         cls._clear_expired_entries()
         curr_time = now()
@@ -161,15 +178,17 @@ class Permissions:
                 permissions["write"],
                 permissions["owner"],
                 permissions["username"],
+                permissions.get("unredact", False),
             )
         if cls.cache[token]["exp"] <= curr_time:
-            return False, False, False, "unknown"
+            return False, False, False, "unknown", False
         permissions = cls.cache[token]
         return (
             permissions["read"],
             permissions["write"],
             permissions["owner"],
             permissions["username"],
+            permissions.get("unredact", False),
         )
 
     @classmethod
@@ -178,7 +197,7 @@ class Permissions:
         Creates a function that verifies a specific permission type for the token.
 
         Args:
-            permission_type (str): The type of permission to verify (read, write, owner).
+            permission_type (str): The type of permission to verify (read, write, owner, unredact).
 
         Returns:
             Callable: A function that takes the token and checks the permission.
@@ -196,6 +215,7 @@ class Permissions:
                     "read": permissions[0],
                     "write": permissions[1],
                     "owner": permissions[2],
+                    "unredact": permissions[4],
                 }
                 if not permission_map.get(permission_type):
                     raise CREDENTIALS_EXCEPTION
@@ -214,7 +234,7 @@ class Permissions:
 
         Args:
             token (str): The access token.
-            permission_type (str): The type of permission to check (read, write, owner).
+            permission_type (str): The type of permission to check (read, write, owner, unredact).
 
         Returns:
             bool: True if the token has the required permission, False otherwise.
@@ -225,5 +245,6 @@ class Permissions:
                 "read": permissions[0],
                 "write": permissions[1],
                 "owner": permissions[2],
+                "unredact": permissions[4],
             }
             return permission_map.get(permission_type, False)
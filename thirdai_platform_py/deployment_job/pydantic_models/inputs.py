@@ -31,6 +31,16 @@ class TextAnalysisPredictParams(BaseModel):
     top_k: int = 5
 
 
+class TextAnalysisExplainParams(BaseModel):
+    """
+    Represents the query parameters for explaining a text classification. If
+    class_name is not specified the top predicted class is explained.
+    """
+
+    text: str
+    class_name: Optional[str] = None
+
+
 class TokenAnalysisPredictParams(BaseModel):
     """
     Represents the query parameters for token analysis.
@@ -41,6 +51,18 @@ class TokenAnalysisPredictParams(BaseModel):
     data_type: Literal["unstructured", "xml"] = "unstructured"
 
 
+class TokenStreamMessage(BaseModel):
+    """
+    Represents a chunk of text streamed to a token classification deployment.
+    The text is finished when final is set. Browsers cannot set the headers of
+    a websocket, so the first message can contain the access token instead.
+    """
+
+    text: str = ""
+    final: bool = False
+    token: Optional[str] = None
+
+
 class AssociateInputSingle(BaseModel):
     """
     Represents a single source-target pair for association.
@@ -124,12 +146,16 @@ class EnterpriseSearchResults(BaseModel):
     query_text: str
     references: List[Reference]
 
+    # The original text of the tokenized entities, only returned to users with
+    # the unredact permission.
     pii_entities: Optional[List[PiiEntity]] = None
+    # Passed to /unredact to recover the original text of the entities.
+    redaction_token: Optional[str] = None
 
 
 class UnredactArgs(BaseModel):
     text: str
-    pii_entities: List[PiiEntity]
+    redaction_token: str
 
 
 class DocumentList(BaseModel):
@@ -147,6 +173,26 @@ class DeleteInput(BaseModel):
 class SearchResultsTextClassification(BaseModel):
     query_text: str
     predicted_classes: List[Dict[str, Any]]
+    # True if the score of the top class was below its confidence threshold,
+    # in which case the abstain label is the first predicted class.
+    abstained: bool = False
+
+
+class TokenAttribution(BaseModel):
+    """
+    The attribution of a single token of the query to the explained class.
+    Positive scores mean the token increases the score of the class.
+    """
+
+    token: str
+    score: float
+
+
+class TextClassificationExplanation(BaseModel):
+    query_text: str
+    class_name: str
+    score: float
+    attributions: List[TokenAttribution]
 
 
 class SaveModel(BaseModel):
@@ -0,0 +1,147 @@
+import html
+import io
+import json
+from typing import Any, Dict, List, Optional
+
+import docx
+import fitz
+
+EXPORT_MEDIA_TYPES = {
+    "json": "application/json",
+    "docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+    "pdf": "application/pdf",
+}
+
+
+def answer_confidence(references: List[Dict[str, Any]]) -> Optional[float]:
+    """
+    The confidence of an answer is the retrieval score of its best reference.
+    Reports generated before scores were recorded have no confidence.
+    """
+    scores = [ref["score"] for ref in references if ref.get("score") is not None]
+    if not scores:
+        return None
+    return max(scores)
+
+
+def export_entries(results: List[Dict[str, Any]]) -> List[Dict[str, Any]]:
+    return [
+        {
+            "question_id": result["question_id"],
+            "question": result["question"],
+            "answer": result.get("answer"),
+            "confidence": answer_confidence(result.get("references", [])),
+            "sources": [
+                {
+                    "source": ref.get("source"),
+                    "text": ref.get("text"),
+                    "score": ref.get("score"),
+                }
+                for ref in result.get("references", [])
+            ],
+        }
+        for result in results
+    ]
+
+
+def format_confidence(confidence: Optional[float]) -> str:
+    return "n/a" if confidence is None else f"{confidence:.3f}"
+
+
+def export_json(report: Dict[str, Any]) -> bytes:
+    return json.dumps(
+        {
+            "report_id": report["report_id"],
+            "submitted_at": report["submitted_at"],
+            "documents": [doc.get("path") for doc in report["documents"]],
+            "results": export_entries(report["results"]),
+        },
+        indent=2,
+    ).encode()
+
+
+def export_docx(report: Dict[str, Any]) -> bytes:
+    document = docx.Document()
+    document.add_heading(f"Knowledge Extraction Report {report['report_id']}", 0)
+    document.add_paragraph(f"Submitted: {report['submitted_at']}")
+    document.add_paragraph(
+        "Documents: " + ", ".join(doc.get("path") for doc in report["documents"])
+    )
+
+    for i, entry in enumerate(export_entries(report["results"])):
+        document.add_heading(f"{i + 1}. {entry['question']}", 1)
+        document.add_paragraph(entry["answer"] or "No answer was generated.")
+        document.add_paragraph(f"Confidence: {format_confidence(entry['confidence'])}")
+
+        if entry["sources"]:
+            document.add_heading("Sources", 2)
+            for source in entry["sources"]:
+                paragraph = document.add_paragraph(style="List Bullet")
+                paragraph.add_run(f"{source['source']}: ").bold = True
+                paragraph.add_run(source["text"] or "")
+
+    output = io.BytesIO()
+    document.save(output)
+    return output.getvalue()
+
+
+def report_html(report: Dict[str, Any]) -> str:
+    parts = [
+        f"<h1>Knowledge Extraction Report {html.escape(report['report_id'])}</h1>",
+        f"<p>Submitted: {html.escape(str(report['submitted_at']))}</p>",
+        "<p>Documents: "
+        + html.escape(", ".join(doc.get("path") for doc in report["documents"]))
+        + "</p>",
+    ]
+
+    for i, entry in enumerate(export_entries(report["results"])):
+        parts.append(f"<h2>{i + 1}. {html.escape(entry['question'])}</h2>")
+        parts.append(
+            f"<p>{html.escape(entry['answer'] or 'No answer was generated.')}</p>"
+        )
+        parts.append(
+            f"<p><i>Confidence: {format_confidence(entry['confidence'])}</i></p>"
+        )
+        if entry["sources"]:
+            parts.append("<h3>Sources</h3><ul>")
+            for source in entry["sources"]:
+                parts.append(
+                    f"<li><b>{html.escape(str(source['source']))}:</b> "
+                    f"{html.escape(source['text'] or '')}</li>"
+                )
+            parts.append("</ul>")
+
+    return "".join(parts)
+
+
+def export_pdf(report: Dict[str, Any]) -> bytes:
+    story = fitz.Story(html=report_html(report))
+
+    output = io.BytesIO()
+    writer = fitz.DocumentWriter(output)
+    mediabox = fitz.paper_rect("letter")
+    content = mediabox + (54, 54, -54, -54)
+
+    more = True
+    while more:
+        device = writer.begin_page(mediabox)
+        more, _ = story.place(content)
+        story.draw(device)
+        writer.end_page()
+    writer.close()
+
+    return output.getvalue()
+
+
+def export_report(report: Dict[str, Any], format: str) -> bytes:
+    """
+    Exports a completed report. The report must contain the report_id,
+    submitted_at, documents, and results fields.
+    """
+    if format == "json":
+        return export_json(report)
+    if format == "docx":
+        return export_docx(report)
+    if format == "pdf":
+        return export_pdf(report)
+    raise ValueError(f"Unsupported export format '{format}'")
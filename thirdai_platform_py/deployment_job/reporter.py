@@ -127,8 +127,13 @@ class Reporter:
         Args:
             model_id (str): The ID of the model.
             status (str): The new status of the deployment.
+            message (str, optional): Explains the status, such as why the deployment failed.
         """
-        self._request("post", "api/v2/deploy/update-status", json={"status": status})
+        self._request(
+            "post",
+            "api/v2/deploy/update-status",
+            json={"status": status, "message": message or ""},
+        )
 
     def get_deploy_status(self, model_id: str) -> str:
         """
@@ -140,6 +145,12 @@ class Reporter:
         content = self._request("get", "api/v2/deploy/status-internal")
         return content["status"]
 
+    def get_entity_dictionary(self) -> dict:
+        """
+        Gets the latest version of the entity dictionary of the deployment.
+        """
+        return self._request("get", "api/v2/deploy/dictionary-internal")
+
     def log(
         self,
         action: str,
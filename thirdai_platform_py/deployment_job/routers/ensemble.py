@@ -0,0 +1,113 @@
+import time
+from concurrent.futures import ThreadPoolExecutor
+from typing import Any, Dict, List
+from urllib.parse import urljoin
+
+from deployment_job.ensemble import combine_predictions
+from deployment_job.permissions import Permissions
+from deployment_job.pydantic_models.inputs import (
+    SearchResultsTextClassification,
+    TextAnalysisPredictParams,
+)
+from deployment_job.reporter import Reporter
+from fastapi import APIRouter, Depends, status
+from fastapi.encoders import jsonable_encoder
+from platform_common.logging import JobLogger, LogCode
+from platform_common.pydantic_models.deployment import DeploymentConfig
+from platform_common.utils import response
+from prometheus_client import Summary
+from requests import Session
+
+ensemble_predict_metric = Summary("ensemble_predict", "Ensemble predictions")
+
+
+class EnsembleRouter:
+    def __init__(self, config: DeploymentConfig, reporter: Reporter, logger: JobLogger):
+        self.config = config
+        self.logger = logger
+
+        self.strategy = self.config.options["strategy"]
+        self.member_ids = self.config.options["model_ids"].split(",")
+        self.member_endpoints = [
+            urljoin(self.config.model_bazaar_endpoint, model_id + "/")
+            for model_id in self.member_ids
+        ]
+        self.logger.info(
+            f"Ensemble of {len(self.member_ids)} models using strategy '{self.strategy}'",
+            code=LogCode.MODEL_INIT,
+        )
+
+        self.session = Session()
+        self.executor = ThreadPoolExecutor(max_workers=len(self.member_ids))
+
+        self.router = APIRouter()
+        self.router.add_api_route("/predict", self.predict, methods=["POST"])
+
+    def member_predict(
+        self, endpoint: str, params: TextAnalysisPredictParams, headers: Dict[str, str]
+    ) -> List[Dict[str, Any]]:
+        res = self.session.post(
+            url=urljoin(endpoint, "predict"), json=params.model_dump(), headers=headers
+        )
+        if res.status_code != status.HTTP_200_OK:
+            raise ValueError(
+                f"request to {endpoint} failed with status code {res.status_code}: {res.text}"
+            )
+        return res.json()["data"]["prediction_results"]["predicted_classes"]
+
+    @ensemble_predict_metric.time()
+    def predict(
+        self,
+        params: TextAnalysisPredictParams,
+        token_scheme=Depends(Permissions.verify_permission("read")),
+    ):
+        start_time = time.perf_counter()
+
+        token, scheme = token_scheme
+        if scheme == "api_key":
+            headers = {"X-API-Key": token}
+        else:
+            headers = {"Authorization": f"Bearer {token}"}
+
+        futures = [
+            self.executor.submit(self.member_predict, endpoint, params, headers)
+            for endpoint in self.member_endpoints
+        ]
+
+        member_predictions = []
+        for model_id, future in zip(self.member_ids, futures):
+            try:
+                member_predictions.append(future.result())
+            except Exception as e:
+                self.logger.error(
+                    f"Ensemble member {model_id} failed to predict: {e}",
+                    code=LogCode.MODEL_PREDICT,
+                )
+                return response(
+                    status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+                    message=f"Unable to get predictions from ensemble member {model_id}: {e}",
+                )
+
+        results = SearchResultsTextClassification(
+            query_text=params.text,
+            predicted_classes=combine_predictions(
+                self.strategy, member_predictions, params.top_k
+            ),
+        )
+
+        time_taken = time.perf_counter() - start_time
+
+        return response(
+            status_code=status.HTTP_200_OK,
+            message="Successful",
+            data={
+                "prediction_results": jsonable_encoder(results),
+                "model_predictions": {
+                    model_id: predictions
+                    for model_id, predictions in zip(
+                        self.member_ids, member_predictions
+                    )
+                },
+                "time_taken": time_taken,
+            },
+        )
@@ -3,7 +3,7 @@ from urllib.parse import urljoin
 from deployment_job.permissions import Permissions
 from fastapi import APIRouter, Depends, status
 from fastapi.encoders import jsonable_encoder
-from guardrail import Guardrail, LabelMap
+from guardrail import Guardrail, LabelMap, RedactionPolicy
 from platform_common.logging import JobLogger, LogCode
 from platform_common.pydantic_models.deployment import DeploymentConfig
 from platform_common.utils import response
@@ -35,6 +35,8 @@ class EnterpriseSearchRouter:
                 guardrail_model_id=self.config.options["guardrail_id"],
                 model_bazaar_endpoint=self.config.model_bazaar_endpoint,
                 logger=self.logger,
+                policy=RedactionPolicy.from_options(self.config.options),
+                redaction_key=self.config.options.get("redaction_key"),
             )
             self.logger.info(
                 f"Guardrail initialized with ID {self.config.options['guardrail_id']}",
@@ -116,7 +118,10 @@ class EnterpriseSearchRouter:
                         auth_scheme=scheme,
                     )
 
-                results.pii_entities = label_map.get_entities()
+                entities = label_map.get_entities()
+                results.redaction_token = self.guardrail.encrypt_entities(entities)
+                if Permissions.check_permission(token, scheme, "unredact"):
+                    results.pii_entities = entities
                 self.logger.debug("Redacted PII from search results")
             except Exception as e:
                 self.logger.error(f"Exception during PII redaction: {e}")
@@ -130,10 +135,11 @@ class EnterpriseSearchRouter:
     def unredact(
         self,
         args: inputs.UnredactArgs,
-        _=Depends(Permissions.verify_permission("read")),
+        _=Depends(Permissions.verify_permission("unredact")),
     ):
         if self.guardrail:
-            unredacted_text = self.guardrail.unredact_pii(args.text, args.pii_entities)
+            entities = self.guardrail.decrypt_entities(args.redaction_token)
+            unredacted_text = self.guardrail.unredact_pii(args.text, entities)
             self.logger.debug("Unredacted text successfully")
             return response(
                 status_code=status.HTTP_200_OK,
@@ -0,0 +1,133 @@
+import json
+import os
+import time
+from typing import Any, Dict
+
+from deployment_job.external_model import (
+    CONTAINER_RUNTIME,
+    ONNX_MODEL_FILE,
+    ONNX_RUNTIME,
+    OnnxModel,
+    load_schema,
+    schema_errors,
+)
+from deployment_job.permissions import Permissions
+from deployment_job.reporter import Reporter
+from fastapi import APIRouter, Body, Depends, status
+from platform_common.logging import JobLogger, LogCode
+from platform_common.pydantic_models.deployment import DeploymentConfig
+from platform_common.utils import response
+from prometheus_client import Summary
+from requests import RequestException, Session
+
+external_predict_metric = Summary("external_predict", "External model predictions")
+
+
+class ExternalModelRouter:
+    def __init__(self, config: DeploymentConfig, reporter: Reporter, logger: JobLogger):
+        self.config = config
+        self.logger = logger
+
+        self.runtime = self.config.options["runtime"]
+        self.request_schema = load_schema(self.config.options, "request_schema")
+        self.response_schema = load_schema(self.config.options, "response_schema")
+
+        if self.runtime == ONNX_RUNTIME:
+            model_path = os.path.join(
+                self.config.model_bazaar_dir,
+                "models",
+                str(self.config.model_id),
+                "model",
+                ONNX_MODEL_FILE,
+            )
+            self.model = OnnxModel(model_path)
+        elif self.runtime == CONTAINER_RUNTIME:
+            # The inference server runs as a sidecar of the deployment, the
+            # orchestrator passes its address in the environment.
+            self.endpoint = os.environ["EXTERNAL_MODEL_ENDPOINT"].rstrip("/")
+            self.session = Session()
+        else:
+            raise ValueError(f"Unsupported external model runtime '{self.runtime}'")
+
+        self.logger.info(
+            f"External model using runtime '{self.runtime}'", code=LogCode.MODEL_INIT
+        )
+
+        self.router = APIRouter()
+        self.router.add_api_route("/predict", self.predict, methods=["POST"])
+        self.router.add_api_route("/schema", self.schema, methods=["GET"])
+
+    def container_predict(self, body: Dict[str, Any]) -> Dict[str, Any]:
+        res = self.session.post(url=self.endpoint + "/predict", json=body, timeout=60)
+        if res.status_code != status.HTTP_200_OK:
+            raise RequestException(
+                f"inference server returned status code {res.status_code}: {res.text}"
+            )
+        return res.json()
+
+    @external_predict_metric.time()
+    def predict(
+        self,
+        body: Dict[str, Any] = Body(...),
+        _=Depends(Permissions.verify_permission("read")),
+    ):
+        start_time = time.perf_counter()
+
+        errors = schema_errors(self.request_schema, body)
+        if errors:
+            return response(
+                status_code=status.HTTP_422_UNPROCESSABLE_ENTITY,
+                message=f"Request does not match the model's request schema: {errors}",
+            )
+
+        if self.runtime == ONNX_RUNTIME:
+            try:
+                prediction = self.model.predict(body)
+            except ValueError as e:
+                return response(
+                    status_code=status.HTTP_422_UNPROCESSABLE_ENTITY,
+                    message=f"Invalid model inputs: {e}",
+                )
+        else:
+            try:
+                prediction = self.container_predict(body)
+            except (RequestException, ValueError) as e:
+                self.logger.error(
+                    f"External model inference server failed to predict: {e}",
+                    code=LogCode.MODEL_PREDICT,
+                )
+                return response(
+                    status_code=status.HTTP_502_BAD_GATEWAY,
+                    message=f"Unable to get prediction from the inference server: {e}",
+                )
+
+        errors = schema_errors(self.response_schema, prediction)
+        if errors:
+            self.logger.error(
+                f"External model prediction does not match the response schema: {errors}",
+                code=LogCode.MODEL_PREDICT,
+            )
+            return response(
+                status_code=status.HTTP_502_BAD_GATEWAY,
+                message=f"Model prediction does not match the model's response schema: {errors}",
+            )
+
+        return response(
+            status_code=status.HTTP_200_OK,
+            message="Successful",
+            data={
+                "prediction": prediction,
+                "time_taken": time.perf_counter() - start_time,
+            },
+        )
+
+    def schema(self, _=Depends(Permissions.verify_permission("read"))):
+        return response(
+            status_code=status.HTTP_200_OK,
+            message="Successful",
+            data={
+                "runtime": self.runtime,
+                "request_schema": json.loads(self.config.options["request_schema"]),
+                "response_schema": json.loads(self.config.options["response_schema"]),
+            },
+        )
@@ -9,8 +9,18 @@ from typing import List, Optional
 
 from deployment_job.permissions import Permissions
 from deployment_job.pydantic_models.inputs import DocumentList
+from deployment_job.report_export import EXPORT_MEDIA_TYPES, export_report
 from deployment_job.reporter import Reporter
-from fastapi import APIRouter, Depends, Form, HTTPException, Request, UploadFile, status
+from fastapi import (
+    APIRouter,
+    Depends,
+    Form,
+    HTTPException,
+    Request,
+    Response,
+    UploadFile,
+    status,
+)
 from fastapi.encoders import jsonable_encoder
 from platform_common.dependencies import is_on_low_disk
 from platform_common.file_handler import download_local_files
@@ -79,6 +89,9 @@ class KnowledgeExtractionRouter:
         self.router.add_api_route(
             "/report/{report_id}", self.delete_report, methods=["DELETE"]
         )
+        self.router.add_api_route(
+            "/report/{report_id}/export", self.export_report, methods=["GET"]
+        )
         self.router.add_api_route("/reports", self.list_reports, methods=["GET"])
         self.router.add_api_route("/questions", self.add_question, methods=["POST"])
         self.router.add_api_route(
@@ -217,6 +230,64 @@ class KnowledgeExtractionRouter:
                 data=jsonable_encoder(report_data),
             )
 
+    def export_report(
+        self,
+        report_id: str,
+        format: str = "json",
+        _=Depends(Permissions.verify_permission("read")),
+    ):
+        if format not in EXPORT_MEDIA_TYPES:
+            return response(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                message=f"Invalid export format '{format}', must be one of {list(EXPORT_MEDIA_TYPES)}.",
+            )
+
+        with self.get_session() as session:
+            report: Report = session.query(Report).get(report_id)
+
+            if not report:
+                return response(
+                    status_code=status.HTTP_404_NOT_FOUND,
+                    message=f"Report with ID '{report_id}' not found.",
+                )
+
+            if report.status != "complete":
+                return response(
+                    status_code=status.HTTP_400_BAD_REQUEST,
+                    message=f"Report with ID '{report_id}' has status '{report.status}', only complete reports can be exported.",
+                )
+
+            report_dir = self.reports_base_path / report_id
+            try:
+                with open(report_dir / "documents.json") as file:
+                    documents = json.load(file)
+                with open(report_dir / f"report_{report.attempt}.json") as file:
+                    results = json.load(file)["results"]
+
+                content = export_report(
+                    {
+                        "report_id": report.id,
+                        "submitted_at": report.submitted_at.isoformat(),
+                        "documents": documents,
+                        "results": results,
+                    },
+                    format=format,
+                )
+            except Exception as e:
+                self.logger.error(f"Failed to export report {report_id}: {e}")
+                return response(
+                    status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+                    message="failed to export report",
+                )
+
+        return Response(
+            content=content,
+            media_type=EXPORT_MEDIA_TYPES[format],
+            headers={
+                "Content-Disposition": f'attachment; filename="report_{report_id}.{format}"'
+            },
+        )
+
     def delete_report(
         self, report_id: str, _=Depends(Permissions.verify_permission("write"))
     ):
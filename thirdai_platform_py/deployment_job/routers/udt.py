@@ -1,19 +1,33 @@
 import os
 import time
+from typing import List
 
 from deployment_job.models.classification_models import (
     ClassificationModel,
     TextClassificationModel,
     TokenClassificationModel,
 )
-from deployment_job.permissions import Permissions
+from deployment_job.permissions import Permissions, websocket_token
 from deployment_job.pydantic_models.inputs import (
+    TextAnalysisExplainParams,
     TextAnalysisPredictParams,
     TokenAnalysisPredictParams,
+    TokenStreamMessage,
 )
 from deployment_job.reporter import Reporter
 from deployment_job.throughput import Throughput
-from fastapi import APIRouter, Depends, Query, UploadFile, status
+from deployment_job.token_stream import TokenStream
+from fastapi import (
+    APIRouter,
+    Depends,
+    HTTPException,
+    Query,
+    UploadFile,
+    WebSocket,
+    WebSocketDisconnect,
+    status,
+)
+from fastapi.concurrency import run_in_threadpool
 from fastapi.encoders import jsonable_encoder
 from platform_common.dependencies import is_on_low_disk
 from platform_common.logging import JobLogger, LogCode
@@ -30,13 +44,28 @@ from platform_common.thirdai_storage.data_types import (
     TokenClassificationData,
 )
 from platform_common.utils import response
-from prometheus_client import Summary
+from prometheus_client import Counter, Summary
+from pydantic import ValidationError
 from thirdai import neural_db as ndb
 
 udt_predict_metric = Summary("udt_predict", "UDT predictions")
 
 udt_query_length = Summary("udt_query_length", "Distribution of query lengths")
 
+udt_explain_metric = Summary("udt_explain", "UDT explanations")
+
+udt_stream_chunks_metric = Counter(
+    "udt_stream_chunks", "Chunks of text predicted by UDT streaming predictions"
+)
+
+# The abstention rate is udt_abstentions / udt_predictions. Predictions only
+# abstain if the deployment has confidence thresholds.
+udt_predictions_metric = Counter("udt_predictions", "UDT predictions")
+udt_abstentions_metric = Counter(
+    "udt_abstentions",
+    "UDT predictions that returned the abstain label because of low confidence",
+)
+
 
 class UDTBaseRouter:
     def __init__(self, config: DeploymentConfig, reporter: Reporter, logger: JobLogger):
@@ -48,6 +77,7 @@ class UDTBaseRouter:
         self.tokens_identified = Throughput()
         self.queries_ingested = Throughput()
         self.queries_ingested_bytes = Throughput()
+        self.abstentions = Throughput()
 
         self.router = APIRouter()
         self.router.add_api_route("/stats", self.stats, methods=["GET"])
@@ -57,6 +87,12 @@ class UDTBaseRouter:
     def get_model(config: DeploymentConfig, logger: JobLogger) -> ClassificationModel:
         raise NotImplementedError("Subclasses should implement this method")
 
+    def log_prediction(self, abstained: bool):
+        udt_predictions_metric.inc()
+        if abstained:
+            udt_abstentions_metric.inc()
+            self.abstentions.log(1)
+
     def get_text(
         self,
         file: UploadFile,
@@ -126,15 +162,19 @@ class UDTBaseRouter:
                 "tokens_identified": 125,
                 "queries_ingested": 12,
                 "queries_ingested_bytes": 7223,
+                "abstentions": 1,
             },
             "total": {
                 "tokens_identified": 1125,
                 "queries_ingested": 102,
                 "queries_ingested_bytes": 88101,
+                "abstentions": 9,
             },
             "uptime": 35991
         }
-        uptime is given in seconds.
+        uptime is given in seconds. abstentions is the number of predictions that
+        returned the abstain label because they were below the confidence thresholds
+        of the deployment.
         """
         return response(
             status_code=status.HTTP_200_OK,
@@ -144,11 +184,13 @@ class UDTBaseRouter:
                     "tokens_identified": self.tokens_identified.past_hour(),
                     "queries_ingested": self.queries_ingested.past_hour(),
                     "queries_ingested_bytes": self.queries_ingested_bytes.past_hour(),
+                    "abstentions": self.abstentions.past_hour(),
                 },
                 "total": {
                     "tokens_identified": self.tokens_identified.past_hour(),
                     "queries_ingested": self.queries_ingested.past_hour(),
                     "queries_ingested_bytes": self.queries_ingested_bytes.past_hour(),
+                    "abstentions": self.abstentions.past_hour(),
                 },
                 "uptime": int(time.time() - self.start_time),
             },
@@ -169,6 +211,7 @@ class UDTRouterTextClassification(UDTBaseRouter):
             "/get_recent_samples", self.get_recent_samples, methods=["GET"]
         )
         self.router.add_api_route("/predict", self.predict, methods=["POST"])
+        self.router.add_api_route("/explain", self.explain, methods=["POST"])
 
     @udt_predict_metric.time()
     def predict(
@@ -203,6 +246,7 @@ class UDTRouterTextClassification(UDTBaseRouter):
         results = self.model.predict(**params.model_dump())
         self.queries_ingested.log(1)
         self.queries_ingested_bytes.log(len(params.text))
+        self.log_prediction(results.abstained)
 
         end_time = time.perf_counter()
         time_taken = end_time - start_time
@@ -221,6 +265,54 @@ class UDTRouterTextClassification(UDTBaseRouter):
             data=response_data,
         )
 
+    @udt_explain_metric.time()
+    def explain(
+        self,
+        params: TextAnalysisExplainParams,
+        _=Depends(Permissions.verify_permission("read")),
+    ):
+        """
+        Explains the prediction of a class for the text with the attribution of
+        each token to the score of the class. The attribution of a token is how
+        much the score of the class decreases when the token is removed.
+
+        Parameters:
+        - text: str - The text to explain the prediction for.
+        - class_name: Optional[str] - The class to explain, defaults to the top predicted class.
+        - token: str - Authorization token (inferred from permissions dependency).
+
+        Returns:
+        - JSONResponse: The explained class, its score, and the token attributions.
+
+        Example Request Body:
+        ```
+        {
+            "text": "the product exceeded my expectations",
+            "class_name": "positive"
+        }
+        ```
+        """
+        start_time = time.perf_counter()
+
+        udt_query_length.observe(len(params.text.split()))
+
+        explanation = self.model.explain(**params.model_dump())
+        self.queries_ingested.log(1)
+        self.queries_ingested_bytes.log(len(params.text))
+
+        time_taken = time.perf_counter() - start_time
+
+        self.logger.debug(f"Explanation complete with time taken: {time_taken} seconds")
+
+        return response(
+            status_code=status.HTTP_200_OK,
+            message="Successful",
+            data={
+                "explanation": jsonable_encoder(explanation),
+                "time_taken": time_taken,
+            },
+        )
+
     @staticmethod
     def get_model(config: DeploymentConfig, logger: JobLogger) -> ClassificationModel:
         logger.info(f"Initializing Nlp Text Classification model")
@@ -266,6 +358,10 @@ class UDTRouterTokenClassification(UDTBaseRouter):
             "/get_recent_samples", self.get_recent_samples, methods=["GET"]
         )
         self.router.add_api_route("/predict", self.predict, methods=["POST"])
+        self.router.add_api_websocket_route("/predict/stream", self.predict_stream)
+
+        if reporter is not None:
+            self.model.entity_dictionary.start_sync(reporter)
 
     @staticmethod
     def get_model(config: DeploymentConfig, logger: JobLogger) -> ClassificationModel:
@@ -405,6 +501,7 @@ class UDTRouterTokenClassification(UDTBaseRouter):
         results = self.model.predict(**params.model_dump())
         self.queries_ingested.log(1)
         self.queries_ingested_bytes.log(len(params.text))
+        self.log_prediction(self.model.abstained(results))
 
         # TODO(pratik/geordie/yash): Add logging for search results text classification
         if isinstance(results, UnstructuredTokenClassificationResults):
@@ -440,3 +537,98 @@ class UDTRouterTokenClassification(UDTBaseRouter):
             message="Successful",
             data=response_data,
         )
+
+    def predict_tags(self, text: str) -> List[str]:
+        results = self.model.predict(text=text, data_type="unstructured")
+        return [tags[0] for tags in results.predicted_tags]
+
+    async def predict_stream(self, websocket: WebSocket):
+        """
+        Streams the entities in text that is sent in chunks, such as a chat
+        input that is being typed, so that it can be redacted before it is
+        submitted.
+
+        Each message is a json object with a chunk of the text. The response to
+        each message contains the entity spans that are complete, with the
+        character offsets of the span in the text sent so far. A token is only
+        predicted once whitespace follows it, and a span once the token after it
+        is predicted, so spans are never split across messages. The message
+        with "final" set returns the remaining spans, and the next message
+        starts a new text.
+
+        Browsers cannot set the headers of a websocket, so if there is no
+        Authorization or X-API-Key header the first message must contain the
+        access token in "token". The socket is closed with code 1008 if the
+        token does not have read permission, and with code 1003 after an error
+        message if a message is invalid.
+
+        Example Messages:
+        ```
+        {"text": "My name is John", "token": "..."}
+        {"text": " Smith and my email is "}
+        {"text": "john@thirdai.com", "final": true}
+        ```
+
+        Example Responses:
+        ```
+        {"spans": [], "final": false}
+        {"spans": [{"start": 11, "end": 21, "text": "John Smith", "tag": "NAME"}], "final": false}
+        {"spans": [{"start": 38, "end": 54, "text": "john@thirdai.com", "tag": "EMAIL"}], "final": true}
+        ```
+        """
+        await websocket.accept()
+
+        token, auth_scheme = websocket_token(websocket)
+        authorized = False
+        abstain_label = (
+            self.model.config.confidence_thresholds.abstain_label
+            if self.model.config.confidence_thresholds
+            else None
+        )
+
+        stream = TokenStream(self.predict_tags)
+        abstained = False
+        try:
+            while True:
+                message = TokenStreamMessage.model_validate(
+                    await websocket.receive_json()
+                )
+
+                if not authorized:
+                    if message.token:
+                        token, auth_scheme = message.token, "bearer"
+                    authorized = await run_in_threadpool(
+                        Permissions.check_permission, token, auth_scheme, "read"
+                    )
+                    if not authorized:
+                        await websocket.close(
+                            code=status.WS_1008_POLICY_VIOLATION,
+                            reason="Invalid access token.",
+                        )
+                        return
+
+                spans = await run_in_threadpool(
+                    stream.feed, message.text, message.final
+                )
+                udt_stream_chunks_metric.inc()
+                self.queries_ingested_bytes.log(len(message.text))
+                self.tokens_identified.log(sum(len(s.text.split()) for s in spans))
+                abstained = abstained or any(s.tag == abstain_label for s in spans)
+                if message.final:
+                    self.queries_ingested.log(1)
+                    self.log_prediction(abstained)
+                    abstained = False
+
+                await websocket.send_json(
+                    {"spans": jsonable_encoder(spans), "final": message.final}
+                )
+        except WebSocketDisconnect:
+            return
+        except (ValidationError, ValueError, HTTPException) as e:
+            error = e.detail if isinstance(e, HTTPException) else str(e)
+            self.logger.error(
+                f"Error processing streaming prediction: {error}",
+                code=LogCode.MODEL_PREDICT,
+            )
+            await websocket.send_json({"error": error})
+            await websocket.close(code=status.WS_1003_UNSUPPORTED_DATA)
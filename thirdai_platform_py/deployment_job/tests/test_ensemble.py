@@ -0,0 +1,58 @@
+import pytest
+from deployment_job.ensemble import combine_predictions
+
+
+def preds(*pairs):
+    return [{"class": label, "score": score} for label, score in pairs]
+
+
+MEMBER_PREDICTIONS = [
+    preds(("spam", 0.6), ("ham", 0.4)),
+    preds(("spam", 0.55), ("ham", 0.45)),
+    preds(("ham", 0.95), ("spam", 0.05)),
+]
+
+
+def test_score_average():
+    results = combine_predictions("score_average", MEMBER_PREDICTIONS, top_k=5)
+
+    assert [r["class"] for r in results] == ["ham", "spam"]
+    assert results[0]["score"] == pytest.approx(0.6)
+    assert results[1]["score"] == pytest.approx(0.4)
+
+
+def test_majority_vote():
+    results = combine_predictions("majority_vote", MEMBER_PREDICTIONS, top_k=5)
+
+    assert [r["class"] for r in results] == ["spam", "ham"]
+    assert results[0]["score"] == pytest.approx(2 / 3)
+    assert results[1]["score"] == pytest.approx(1 / 3)
+
+
+def test_majority_vote_tie_uses_average_score():
+    member_predictions = [
+        preds(("a", 0.9), ("b", 0.1)),
+        preds(("b", 0.6), ("a", 0.4)),
+    ]
+
+    results = combine_predictions("majority_vote", member_predictions, top_k=1)
+
+    assert results == [{"class": "a", "score": 0.5}]
+
+
+def test_missing_classes_and_top_k():
+    member_predictions = [
+        preds(("a", 0.8), ("b", 0.2)),
+        preds(("c", 0.7), ("a", 0.3)),
+    ]
+
+    results = combine_predictions("score_average", member_predictions, top_k=2)
+
+    assert [r["class"] for r in results] == ["a", "c"]
+    assert results[0]["score"] == pytest.approx(0.55)
+    assert results[1]["score"] == pytest.approx(0.35)
+
+
+def test_invalid_strategy():
+    with pytest.raises(ValueError):
+        combine_predictions("median", MEMBER_PREDICTIONS, top_k=1)
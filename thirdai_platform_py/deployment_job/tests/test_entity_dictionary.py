@@ -0,0 +1,82 @@
+from unittest.mock import MagicMock
+
+import pytest
+from deployment_job.entity_dictionary import EntityDictionary
+
+
+def make_dictionary(entries, version=1) -> EntityDictionary:
+    dictionary = EntityDictionary(MagicMock())
+    dictionary.load({"version": version, "entries": entries})
+    return dictionary
+
+
+@pytest.mark.unit
+def test_exact_patterns_match_at_word_boundaries():
+    dictionary = make_dictionary([{"pattern": "Project Falcon", "tag": "CODENAME"}])
+
+    text = "the project falcon launch, not project falconry"
+    tags = [["O"] for _ in text.split()]
+
+    assert dictionary.apply(text, tags) == [
+        ["O"],
+        ["CODENAME"],
+        ["CODENAME"],
+        ["O"],
+        ["O"],
+        ["O"],
+        ["O"],
+    ]
+
+
+@pytest.mark.unit
+def test_case_sensitive_regex_overrides_model_tags():
+    dictionary = make_dictionary(
+        [
+            {"pattern": r"TKT-\d{6}", "tag": "TICKET", "regex": True},
+            {
+                "pattern": "falcon",
+                "tag": "CODENAME",
+                "case_sensitive": True,
+            },
+        ]
+    )
+
+    text = "see (TKT-123456) for Falcon and falcon"
+    tags = [["O"], ["PHONENUMBER"], ["O"], ["NAME"], ["O"], ["O"]]
+
+    assert dictionary.apply(text, tags) == [
+        ["O"],
+        ["TICKET"],
+        ["O"],
+        ["NAME"],
+        ["O"],
+        ["CODENAME"],
+    ]
+
+
+@pytest.mark.unit
+def test_invalid_patterns_are_skipped():
+    dictionary = make_dictionary(
+        [
+            {"pattern": r"(?i:abc", "tag": "BAD", "regex": True},
+            {"pattern": "abc", "tag": "GOOD"},
+        ]
+    )
+
+    assert dictionary.apply("x abc", [["O"], ["O"]]) == [["O"], ["GOOD"]]
+
+
+@pytest.mark.unit
+def test_sync_keeps_entries_on_failure():
+    dictionary = make_dictionary([{"pattern": "abc", "tag": "GOOD"}])
+
+    reporter = MagicMock()
+    reporter.get_entity_dictionary.side_effect = Exception("unavailable")
+    dictionary.sync(reporter)
+    assert dictionary.apply("abc", [["O"]]) == [["GOOD"]]
+
+    reporter.get_entity_dictionary.side_effect = None
+    reporter.get_entity_dictionary.return_value = {"version": 2, "entries": []}
+    dictionary.sync(reporter)
+    assert dictionary.version == 2
+    assert dictionary.apply("abc", [["O"]]) == [["O"]]
@@ -0,0 +1,45 @@
+import json
+
+import pytest
+
+pytest.importorskip("jsonschema")
+
+from deployment_job.external_model import load_schema, schema_errors
+
+OPTIONS = {
+    "request_schema": json.dumps(
+        {
+            "type": "object",
+            "properties": {
+                "inputs": {
+                    "type": "object",
+                    "properties": {"x": {"type": "array"}},
+                    "required": ["x"],
+                }
+            },
+            "required": ["inputs"],
+        }
+    ),
+}
+
+
+def test_valid_request():
+    validator = load_schema(OPTIONS, "request_schema")
+
+    assert schema_errors(validator, {"inputs": {"x": [[1.0, 2.0]]}}) == ""
+
+
+def test_invalid_request():
+    validator = load_schema(OPTIONS, "request_schema")
+
+    assert "<root>: 'inputs' is a required property" in schema_errors(validator, {})
+    assert "inputs/x: 3 is not of type 'array'" in schema_errors(
+        validator, {"inputs": {"x": 3}}
+    )
+
+
+def test_invalid_schema():
+    from jsonschema.exceptions import SchemaError
+
+    with pytest.raises(SchemaError):
+        load_schema({"request_schema": json.dumps({"type": 7})}, "request_schema")
@@ -2,7 +2,9 @@ import shutil
 from pathlib import Path
 
 import pytest
-from deployment_job.guardrail import Guardrail, LabelMap
+from cryptography.fernet import Fernet
+from deployment_job.guardrail import Guardrail, LabelMap, RedactionPolicy
+from fastapi import HTTPException
 from platform_common.logging import JobLogger
 
 
@@ -78,3 +80,51 @@ def test_guardrail(test_logger):
         guardrail.unredact_pii("[NAME#4]", label_map.get_entities())
         == "[UNKNOWN ENTITY]"
     )
+
+
+def test_guardrail_redaction_policy(test_logger):
+    policy = RedactionPolicy.from_options(
+        {
+            "redaction_policy": '{"default": "mask", "entities": {"ADDRESS": "drop", "NAME": "tokenize"}}'
+        }
+    )
+    guardrail = Guardrail("", "", test_logger, policy=policy)
+    guardrail.query_pii_model = fake_ner_output
+
+    label_map = LabelMap()
+
+    assert (
+        guardrail.redact_pii("", label_map, "", "")
+        == "my neighbor is [NAME#0] on he has a cat named [NAME#1] we call him [NAME#1]"
+    )
+
+    guardrail.policy = RedactionPolicy(default="mask")
+    assert (
+        guardrail.redact_pii("", LabelMap(), "", "")
+        == "my neighbor is [NAME] on [ADDRESS] he has a cat named [NAME] we call him [NAME]"
+    )
+
+
+def test_guardrail_redaction_token(test_logger):
+    guardrail = Guardrail(
+        "", "", test_logger, redaction_key=Fernet.generate_key().decode()
+    )
+    guardrail.query_pii_model = fake_ner_output
+
+    label_map = LabelMap()
+    redacted = guardrail.redact_pii("", label_map, "", "")
+
+    redaction_token = guardrail.encrypt_entities(label_map.get_entities())
+    assert "rick" not in redaction_token
+
+    entities = guardrail.decrypt_entities(redaction_token)
+    assert (
+        guardrail.unredact_pii(redacted, entities)
+        == "my neighbor is rick on main street he has a cat named robert we call him robert"
+    )
+
+    other = Guardrail(
+        "", "", test_logger, redaction_key=Fernet.generate_key().decode()
+    )
+    with pytest.raises(HTTPException):
+        other.decrypt_entities(redaction_token)
@@ -0,0 +1,75 @@
+import io
+import json
+
+import docx
+import fitz
+import pytest
+from deployment_job.report_export import answer_confidence, export_report
+
+REPORT = {
+    "report_id": "report-1",
+    "submitted_at": "2024-11-01T12:00:00",
+    "documents": [{"path": "apple-10k.pdf", "location": "local"}],
+    "results": [
+        {
+            "question_id": "q1",
+            "question": "What was the revenue?",
+            "answer": "Revenue was $383 billion.",
+            "references": [
+                {"text": "Total net sales were $383B", "source": "apple-10k.pdf", "score": 0.4},
+                {"text": "Net sales by category", "source": "apple-10k.pdf", "score": 0.9},
+            ],
+        },
+        {
+            "question_id": "q2",
+            "question": "Who is the CEO?",
+            "answer": None,
+            "references": [{"text": "Tim Cook", "source": "apple-10k.pdf"}],
+        },
+    ],
+}
+
+
+def test_answer_confidence():
+    assert answer_confidence(REPORT["results"][0]["references"]) == pytest.approx(0.9)
+    assert answer_confidence(REPORT["results"][1]["references"]) is None
+    assert answer_confidence([]) is None
+
+
+def test_export_json():
+    exported = json.loads(export_report(REPORT, format="json"))
+
+    assert exported["report_id"] == "report-1"
+    assert exported["documents"] == ["apple-10k.pdf"]
+    assert [r["question"] for r in exported["results"]] == [
+        "What was the revenue?",
+        "Who is the CEO?",
+    ]
+    assert exported["results"][0]["confidence"] == pytest.approx(0.9)
+    assert len(exported["results"][0]["sources"]) == 2
+    assert exported["results"][1]["answer"] is None
+    assert exported["results"][1]["confidence"] is None
+
+
+def test_export_docx():
+    document = docx.Document(io.BytesIO(export_report(REPORT, format="docx")))
+    text = "\n".join(p.text for p in document.paragraphs)
+
+    assert "1. What was the revenue?" in text
+    assert "Revenue was $383 billion." in text
+    assert "Confidence: 0.900" in text
+    assert "apple-10k.pdf: Tim Cook" in text
+
+
+def test_export_pdf():
+    pdf = fitz.open(stream=export_report(REPORT, format="pdf"), filetype="pdf")
+    text = "".join(page.get_text() for page in pdf)
+
+    assert "What was the revenue?" in text
+    assert "Revenue was $383 billion." in text
+    assert "Who is the CEO?" in text
+
+
+def test_export_invalid_format():
+    with pytest.raises(ValueError):
+        export_report(REPORT, format="xlsx")
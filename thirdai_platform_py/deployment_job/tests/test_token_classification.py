@@ -7,6 +7,7 @@ from unittest.mock import patch
 import pytest
 from deployment_job.permissions import Permissions
 from deployment_job.routers.udt import UDTRouterTokenClassification
+from fastapi import WebSocketDisconnect, status
 from fastapi.testclient import TestClient
 from licensing.verify import verify_license
 from platform_common.logging import JobLogger
@@ -161,3 +162,53 @@ def test_deployment_token_classification_xml(tmp_dir):
             },
         }
     ]
+
+
+def mock_stream_permission(token: str, auth_scheme: str, permission_type: str):
+    return token == "valid"
+
+
+@pytest.mark.unit
+@patch.object(Permissions, "check_permission", mock_stream_permission)
+def test_deployment_token_classification_stream(tmp_dir):
+    config = create_config(tmp_dir)
+
+    router = UDTRouterTokenClassification(config, None, logger)
+    client = TestClient(router.router)
+
+    with client.websocket_connect("/predict/stream") as websocket:
+        websocket.send_json({"text": "My email is shubh@thirdai", "token": "valid"})
+        assert websocket.receive_json() == {"spans": [], "final": False}
+
+        # The email is held back until the next token is predicted.
+        websocket.send_json({"text": ".com and"})
+        assert websocket.receive_json() == {"spans": [], "final": False}
+
+        websocket.send_json({"text": " more", "final": True})
+        assert websocket.receive_json() == {
+            "spans": [
+                {"start": 12, "end": 29, "text": "shubh@thirdai.com", "tag": "EMAIL"}
+            ],
+            "final": True,
+        }
+
+        # The next text starts from offset 0.
+        websocket.send_json({"text": "shubh@thirdai.com", "final": True})
+        assert websocket.receive_json()["spans"] == [
+            {"start": 0, "end": 17, "text": "shubh@thirdai.com", "tag": "EMAIL"}
+        ]
+
+    with client.websocket_connect(
+        "/predict/stream", headers={"Authorization": "Bearer valid"}
+    ) as websocket:
+        websocket.send_json({"text": "hello", "final": True})
+        assert websocket.receive_json() == {"spans": [], "final": True}
+
+        websocket.send_json({"final": "not a bool"})
+        assert "error" in websocket.receive_json()
+
+    with client.websocket_connect("/predict/stream") as websocket:
+        websocket.send_json({"text": "hello", "token": "invalid"})
+        with pytest.raises(WebSocketDisconnect) as e:
+            websocket.receive_json()
+        assert e.value.code == status.WS_1008_POLICY_VIOLATION
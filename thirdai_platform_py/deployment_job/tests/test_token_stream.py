@@ -0,0 +1,79 @@
+from typing import List
+
+import pytest
+from deployment_job import token_stream
+from deployment_job.token_stream import TokenStream
+
+
+def predict_tags(text: str) -> List[str]:
+    tags = []
+    for token in text.split():
+        if "@" in token:
+            tags.append("EMAIL")
+        elif token.istitle() and token != "My":
+            tags.append("NAME")
+        else:
+            tags.append("O")
+    return tags
+
+
+def feed_all(stream: TokenStream, chunks: List[str]):
+    spans = []
+    for i, chunk in enumerate(chunks):
+        spans.extend(stream.feed(chunk, final=i == len(chunks) - 1))
+    return [(span.start, span.end, span.text, span.tag) for span in spans]
+
+
+@pytest.mark.unit
+def test_spans_are_not_split_across_chunks():
+    chunks = ["My na", "me is Jo", "hn Smi", "th, email a@b", ".com", " ok"]
+    text = "".join(chunks)
+
+    spans = feed_all(TokenStream(predict_tags), chunks)
+
+    assert spans == [
+        (11, 22, "John Smith,", "NAME"),
+        (29, 36, "a@b.com", "EMAIL"),
+    ]
+    for start, end, span_text, _ in spans:
+        assert text[start:end] == span_text
+
+
+@pytest.mark.unit
+def test_final_chunk_emits_held_spans():
+    stream = TokenStream(predict_tags)
+
+    assert stream.feed("hello Alice ") == []
+    spans = stream.feed("", final=True)
+    assert [(s.start, s.end, s.tag) for s in spans] == [(6, 11, "NAME")]
+
+    # The stream is reset for the next text.
+    spans = stream.feed("Bob", final=True)
+    assert [(s.start, s.end, s.tag) for s in spans] == [(0, 3, "NAME")]
+
+
+@pytest.mark.unit
+def test_decided_tokens_are_not_predicted_again(monkeypatch):
+    monkeypatch.setattr(token_stream, "CONTEXT_TOKENS", 2)
+
+    predicted = []
+
+    def predict(text: str) -> List[str]:
+        predicted.append(text)
+        return predict_tags(text)
+
+    stream = TokenStream(predict)
+    words = ["word"] * 20 + ["Alice", "x"] + ["word"] * 10
+    spans = feed_all(stream, [word + " " for word in words] + [""])
+
+    assert spans == [(100, 105, "Alice", "NAME")]
+    assert max(len(text.split()) for text in predicted) <= 4
+
+
+@pytest.mark.unit
+def test_long_text_without_whitespace_is_rejected(monkeypatch):
+    monkeypatch.setattr(token_stream, "MAX_PENDING_CHARS", 10)
+
+    stream = TokenStream(predict_tags)
+    with pytest.raises(ValueError):
+        stream.feed("a" * 11)
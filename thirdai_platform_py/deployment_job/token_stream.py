@@ -0,0 +1,154 @@
+import re
+from typing import Callable, List, Tuple
+
+from pydantic import BaseModel
+
+# The number of tokens before the undecided tokens that are predicted with them,
+# so that the tags of the new tokens depend on the preceding text.
+CONTEXT_TOKENS = 16
+
+# The stream is rejected once it has this many characters that have not been
+# decided, which only happens if the text has no whitespace.
+MAX_PENDING_CHARS = 64 * 1024
+
+TOKEN_RE = re.compile(r"\S+")
+
+
+class EntitySpan(BaseModel):
+    """
+    An entity detected in a stream. start and end are the character offsets of
+    the entity in the text of the stream so far.
+    """
+
+    start: int
+    end: int
+    text: str
+    tag: str
+
+
+class TokenStream:
+    """
+    Detects the entities in text that arrives in chunks, such as a chat input
+    that is being typed, so that they can be redacted before the text is
+    submitted.
+
+    Tokens are split by whitespace like the predict endpoint, so the last token
+    of the text is not predicted until whitespace follows it or the stream is
+    finished, since it may still be extended by the next chunk. A span is held
+    back while it ends at the last complete token, since the next token may
+    continue it, so spans are only emitted once and are never split across
+    chunks. Tokens before the last emitted span are not predicted again, except
+    for up to CONTEXT_TOKENS of them which are passed as context.
+
+    predict returns the tag of each whitespace separated token of a text.
+    """
+
+    def __init__(self, predict: Callable[[str], List[str]]):
+        self.predict = predict
+        self.reset()
+
+    def reset(self):
+        # text is the suffix of the stream starting at offset, earlier text is
+        # dropped once it is not needed as context.
+        self.text = ""
+        self.offset = 0
+        # The absolute offset before which the tags of every token are decided.
+        self.decided = 0
+
+    def feed(self, chunk: str, final: bool = False) -> List[EntitySpan]:
+        """
+        Adds a chunk to the stream and returns the spans that are complete. If
+        final is set the remaining spans are returned and the stream is reset,
+        so the offsets of the spans of the next text start from 0.
+        """
+        self.text += chunk
+        pending = len(self.text) - (self.decided - self.offset)
+        if not final and pending > MAX_PENDING_CHARS:
+            raise ValueError(
+                f"text exceeds the limit of {MAX_PENDING_CHARS} characters without whitespace"
+            )
+
+        tokens = [(m.start(), m.end()) for m in TOKEN_RE.finditer(self.text)]
+        if not final and tokens and tokens[-1][1] == len(self.text):
+            # The last token may be extended by the next chunk.
+            tokens = tokens[:-1]
+
+        first_undecided = next(
+            (
+                i
+                for i, (start, _) in enumerate(tokens)
+                if start + self.offset >= self.decided
+            ),
+            len(tokens),
+        )
+        if first_undecided == len(tokens):
+            spans = []
+        else:
+            spans = self._predict_spans(tokens, first_undecided)
+
+        # A span that ends at the last complete token may continue into the
+        # next one, so it is decided in a later chunk.
+        if not final and spans and spans[-1][1] == len(tokens) - 1:
+            held_start = tokens[spans[-1][0]][0]
+            spans = spans[:-1]
+            self.decided = held_start + self.offset
+        elif tokens:
+            self.decided = max(self.decided, tokens[-1][1] + self.offset)
+
+        results = [
+            EntitySpan(
+                start=tokens[first][0] + self.offset,
+                end=tokens[last][1] + self.offset,
+                text=self.text[tokens[first][0] : tokens[last][1]],
+                tag=tag,
+            )
+            for first, last, tag in spans
+        ]
+
+        if final:
+            self.reset()
+        else:
+            self._trim(tokens)
+
+        return results
+
+    def _predict_spans(
+        self, tokens: List[Tuple[int, int]], first_undecided: int
+    ) -> List[Tuple[int, int, str]]:
+        """
+        Returns the first token, last token, and tag of the runs of tokens with
+        the same tag, other than "O", starting from first_undecided.
+        """
+        first_context = max(0, first_undecided - CONTEXT_TOKENS)
+        window = tokens[first_context:]
+        tags = self.predict(" ".join(self.text[start:end] for start, end in window))
+        if len(tags) != len(window):
+            raise ValueError(
+                "Error parsing input text, this is likely because the input contains unsupported unicode characters."
+            )
+
+        spans = []
+        for i in range(first_undecided, len(tokens)):
+            tag = tags[i - first_context]
+            if tag == "O":
+                continue
+            if spans and spans[-1][1] == i - 1 and spans[-1][2] == tag:
+                spans[-1] = (spans[-1][0], i, tag)
+            else:
+                spans.append((i, i, tag))
+        return spans
+
+    def _trim(self, tokens: List[Tuple[int, int]]):
+        """
+        Drops the text before the context of the next prediction.
+        """
+        decided = [
+            i
+            for i, (start, _) in enumerate(tokens)
+            if start + self.offset < self.decided
+        ]
+        if len(decided) <= CONTEXT_TOKENS:
+            return
+        keep_from = tokens[decided[-CONTEXT_TOKENS]][0]
+        self.text = self.text[keep_from:]
+        self.offset += keep_from
@@ -177,7 +177,10 @@ class ReportProcessorWorker:
         search_results = db.search_batch(queries, top_k=5, rerank=self.rerank)
 
         search_results = [
-            [{"text": chunk.text, "source": chunk.document} for chunk, _ in refs]
+            [
+                {"text": chunk.text, "source": chunk.document, "score": float(score)}
+                for chunk, score in refs
+            ]
             for refs in search_results
         ]
 
@@ -24,11 +24,43 @@ class LLMBase(ABC):
     ) -> AsyncGenerator[str, None]:
         raise NotImplementedError("Subclasses must implement this method")
 
+    async def embed(self, texts: List[str], model: str) -> List[List[float]]:
+        raise HTTPException(
+            status_code=400,
+            detail=f"Embeddings are not supported by {type(self).__name__}",
+        )
+
+
+async def request_embeddings(
+    url: str, headers: dict, texts: List[str], model: str
+) -> List[List[float]]:
+    # Both openai and the on prem llm server return embeddings in the openai
+    # embeddings format.
+    body = {"input": texts, "model": model}
+    async with aiohttp.ClientSession(trust_env=True) as session:
+        async with session.post(url, headers=headers, json=body) as response:
+            if response.status != 200:
+                error_message = await response.text()
+                raise Exception(
+                    f"Embedding request failed with status {response.status}: {error_message}"
+                )
+            data = await response.json()
+    embeddings = sorted(data["data"], key=lambda embedding: embedding["index"])
+    return [embedding["embedding"] for embedding in embeddings]
+
 
 class OpenAILLM(LLMBase):
     def __init__(self, api_key: str):
         super().__init__(api_key)
         self.url = "https://api.openai.com/v1/chat/completions"
+        self.embeddings_url = "https://api.openai.com/v1/embeddings"
+
+    async def embed(self, texts: List[str], model: str) -> List[List[float]]:
+        headers = {
+            "Content-Type": "application/json",
+            "Authorization": f"Bearer {self.api_key}",
+        }
+        return await request_embeddings(self.embeddings_url, headers, texts, model)
 
     async def stream(
         self,
@@ -52,7 +84,7 @@ class OpenAILLM(LLMBase):
             ],
             "stream": True,
         }
-        async with aiohttp.ClientSession() as session:
+        async with aiohttp.ClientSession(trust_env=True) as session:
             async with session.post(self.url, headers=headers, json=body) as response:
                 if response.status == 200:
                     async for multi_chunk_bytes, _ in response.content.iter_chunks():
@@ -102,7 +134,7 @@ class CohereLLM(LLMBase):
             ],
             "stream": True,
         }
-        async with aiohttp.ClientSession() as session:
+        async with aiohttp.ClientSession(trust_env=True) as session:
             async with session.post(self.url, headers=headers, json=body) as response:
                 if response.status == 200:
                     async for line in response.content:
@@ -131,6 +163,13 @@ class OnPremLLM(LLMBase):
         if self.backend_endpoint is None:
             raise ValueError("Could not read MODEL_BAZAAR_ENDPOINT.")
         self.url = urljoin(self.backend_endpoint, "/on-prem-llm/v1/chat/completions")
+        self.embeddings_url = urljoin(
+            self.backend_endpoint, "/on-prem-llm/v1/embeddings"
+        )
+
+    async def embed(self, texts: List[str], model: str) -> List[List[float]]:
+        headers = {"Content-Type": "application/json"}
+        return await request_embeddings(self.embeddings_url, headers, texts, model)
 
     async def stream(
         self,
@@ -159,7 +198,7 @@ class OnPremLLM(LLMBase):
             # llama.cpp returns gpt-3.5-turbo for this value if not specified
             "model": model,
         }
-        async with aiohttp.ClientSession() as session:
+        async with aiohttp.ClientSession(trust_env=True) as session:
             async with session.post(self.url, headers=headers, json=data) as response:
                 if response.status != 200:
                     raise Exception(
@@ -17,7 +17,8 @@ from fastapi import Depends, FastAPI, Header, HTTPException, Request
 from fastapi.middleware.cors import CORSMiddleware
 from fastapi.responses import JSONResponse, StreamingResponse
 from llm_dispatch_job.llms import LLMBase, LLMFactory
-from llm_dispatch_job.utils import GenerateArgs
+from llm_dispatch_job.utils import EmbedArgs, GenerateArgs
+from prometheus_client import Counter, make_asgi_app
 
 app = FastAPI()
 
@@ -35,6 +36,12 @@ log_dir: Path = Path(model_bazaar_dir) / "logs"
 setup_logger(log_dir=log_dir, log_prefix="llm_generation")
 logger = logging.getLogger("llm_generation")
 
+generation_counter = Counter(
+    "llm_dispatch_generations",
+    "LLM generations by final status (completed, failed, or cancelled).",
+    ["status"],
+)
+
 
 @app.middleware("http")
 async def log_requests(request: Request, call_next):
@@ -68,7 +75,9 @@ def extract_token(authorization: Optional[str] = Header(None)) -> Optional[str]:
 
 @app.post("/llm-dispatch/generate")
 async def generate(
-    generate_args: GenerateArgs, token: Optional[str] = Depends(extract_token)
+    generate_args: GenerateArgs,
+    request: Request,
+    token: Optional[str] = Depends(extract_token),
 ):
     """
     Generate text using a specified generative AI model, with content streamed in real-time.
@@ -115,6 +124,9 @@ async def generate(
 
     Caching:
     - If `original_query` and `cache_access_token` are provided, the generated content will be cached after completion.
+
+    Cancellation:
+    - If the client disconnects before the generation completes, the upstream request to the provider is aborted.
     """
 
     llm: LLMBase = LLMFactory.create(
@@ -131,35 +143,108 @@ async def generate(
 
     async def generate_stream():
         generated_response = ""
+        stream = llm.stream(
+            query=generate_args.query,
+            task_prompt=generate_args.task_prompt,
+            references=generate_args.references,
+            model=generate_args.model,
+        )
         try:
-            async for next_word in llm.stream(
-                query=generate_args.query,
-                task_prompt=generate_args.task_prompt,
-                references=generate_args.references,
-                model=generate_args.model,
-            ):
+            async for next_word in stream:
+                if await request.is_disconnected():
+                    logger.info(
+                        f"Client disconnected, aborting generation for workflow '{generate_args.workflow_id}'.",
+                    )
+                    generation_counter.labels(status="cancelled").inc()
+                    return
                 generated_response += next_word
                 yield next_word
                 await asyncio.sleep(0)
             logger.info(
                 f"\nCompleted generation for workflow '{generate_args.workflow_id}'.",
             )
+        except asyncio.CancelledError:
+            logger.info(
+                f"Generation cancelled for workflow '{generate_args.workflow_id}'.",
+            )
+            generation_counter.labels(status="cancelled").inc()
+            raise
         except Exception as e:
             logger.error(f"Error during generation: {e}")
+            generation_counter.labels(status="failed").inc()
             raise HTTPException(
                 status_code=500, detail=f"Error while generating content: {e}"
             )
         else:
+            generation_counter.labels(status="completed").inc()
             if generate_args.cache_access_token is not None:
                 await insert_into_cache(
                     generate_args.query,
                     generated_response,
                     generate_args.cache_access_token,
                 )
+        finally:
+            # Closing the stream exits the provider's http session, which closes
+            # the upstream connection so that the provider stops generating.
+            await stream.aclose()
 
     return StreamingResponse(generate_stream(), media_type="text/plain")
 
 
+@app.post("/llm-dispatch/embeddings")
+async def embeddings(
+    embed_args: EmbedArgs,
+    token: Optional[str] = Depends(extract_token),
+):
+    """
+    Compute embeddings of texts with the specified provider, for example to rerank
+    the results of ndb queries.
+
+    Parameters:
+        - texts: List[str] - The texts to embed.
+        - key: Optional[str] - API key for the provider.
+        - model: str - The embedding model to use (default: "text-embedding-3-small").
+        - provider: str - The AI provider to use (default: "openai"). Embeddings are supported for openai and on-prem.
+
+    Returns:
+    - A json object with the embeddings of the texts, in the same order as the texts.
+
+    Example Request Body:
+    ```
+    {
+        "texts": ["how do I reset my password", "Passwords can be changed from the account page."],
+        "model": "text-embedding-3-small",
+        "provider": "openai"
+    }
+    ```
+
+    Example Response Body:
+    ```
+    {
+        "embeddings": [[0.013, -0.021, ...], [0.009, -0.017, ...]]
+    }
+    ```
+
+    Errors:
+    - HTTP 400:
+        - No API key provided for the provider.
+        - Unsupported provider, or the provider does not support embeddings.
+    - HTTP 500:
+        - Error computing the embeddings.
+    """
+
+    llm: LLMBase = LLMFactory.create(
+        provider=embed_args.provider.lower(),
+        api_key=embed_args.key,
+        access_token=token,
+        logger=logger,
+    )
+
+    embeddings = await llm.embed(texts=embed_args.texts, model=embed_args.model)
+
+    return {"embeddings": embeddings}
+
+
 async def insert_into_cache(
     original_query: str, generated_response: str, cache_access_token: str
 ):
@@ -182,6 +267,9 @@ async def insert_into_cache(
         logger.error("LLM Cache Insert Error", e)
 
 
+app.mount("/llm-dispatch/metrics", make_asgi_app())
+
+
 @app.get("/llm-dispatch/health")
 async def health_check():
     """
@@ -1,3 +1,4 @@
+import asyncio
 import os
 import shutil
 import tempfile
@@ -54,6 +55,60 @@ def test_generate_text_stream(references, prompt):
         assert response.text == "This is a test."
 
 
+def test_generation_aborted_on_disconnect():
+    from llm_dispatch_job.main import generate
+    from llm_dispatch_job.utils import GenerateArgs
+    from prometheus_client import REGISTRY
+
+    upstream_closed = False
+
+    async def mock_stream(*args, **kwargs):
+        nonlocal upstream_closed
+        try:
+            yield "This "
+            yield "is "
+            yield "a test."
+        finally:
+            upstream_closed = True
+
+    mock_llm_instance = AsyncMock()
+    mock_llm_instance.stream = mock_stream
+
+    class MockRequest:
+        def __init__(self):
+            self.checks = 0
+
+        async def is_disconnected(self):
+            # The client disconnects after receiving the first chunk.
+            self.checks += 1
+            return self.checks > 1
+
+    def cancelled_count():
+        labels = {"status": "cancelled"}
+        value = REGISTRY.get_sample_value("llm_dispatch_generations_total", labels)
+        return value or 0
+
+    cancelled_before = cancelled_count()
+
+    async def run():
+        response = await generate(
+            GenerateArgs(query="test query", provider="openai", key="dummy key"),
+            request=MockRequest(),
+            token=None,
+        )
+        return [chunk async for chunk in response.body_iterator]
+
+    with patch(
+        "llm_dispatch_job.llms.model_classes",
+        {"openai": lambda api_key: mock_llm_instance},
+    ):
+        chunks = asyncio.run(run())
+
+    assert chunks == ["This "]
+    assert upstream_closed
+    assert cancelled_count() == cancelled_before + 1
+
+
 def test_missing_api_key():
     from llm_dispatch_job.main import app
 
@@ -97,6 +152,42 @@ def test_invalid_request_body():
     assert response.status_code == 422
 
 
+def test_embeddings():
+    from llm_dispatch_job.main import app
+
+    client = TestClient(app)
+
+    async def mock_embed(texts, model):
+        return [[float(len(text)), 1.0] for text in texts]
+
+    mock_llm_instance = AsyncMock()
+    mock_llm_instance.embed = mock_embed
+
+    with patch(
+        "llm_dispatch_job.llms.model_classes",
+        {"openai": lambda api_key: mock_llm_instance},
+    ):
+        response = client.post(
+            "/llm-dispatch/embeddings",
+            json={"texts": ["a", "abc"], "provider": "openai", "key": "dummy key"},
+        )
+
+    assert response.status_code == 200
+    assert response.json() == {"embeddings": [[1.0, 1.0], [3.0, 1.0]]}
+
+
+def test_embeddings_unsupported_provider():
+    from llm_dispatch_job.main import app
+
+    client = TestClient(app)
+    response = client.post(
+        "/llm-dispatch/embeddings",
+        json={"texts": ["a"], "provider": "cohere", "key": "dummy key"},
+    )
+    assert response.status_code == 400
+    assert response.json() == {"detail": "Embeddings are not supported by CohereLLM"}
+
+
 def test_health_check():
     from llm_dispatch_job.main import app
 
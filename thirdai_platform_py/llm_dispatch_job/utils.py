@@ -23,6 +23,14 @@ class GenerateArgs(BaseModel):
     cache_access_token: Optional[str] = None
 
 
+class EmbedArgs(BaseModel):
+    texts: List[str]
+
+    key: Optional[str] = None
+    model: str = "text-embedding-3-small"
+    provider: str = "openai"
+
+
 DEFAULT_SYSTEM_PROMPT = (
     "Write a short answer for the user's query based on the provided context. "
     "If the context provides insufficient information, mention it but answer to "
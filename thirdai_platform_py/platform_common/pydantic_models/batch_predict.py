@@ -0,0 +1,26 @@
+from platform_common.pydantic_models.training import FileInfo, ModelType
+from pydantic import BaseModel, Field
+
+
+class BatchPredictOptions(BaseModel):
+    text_column: str = "text"
+    top_k: int = Field(1, ge=1)
+    batch_size: int = Field(1000, ge=1)
+
+
+class BatchPredictConfig(BaseModel):
+    job_id: str
+    model_id: str
+    model_type: ModelType
+    user_id: str
+    model_bazaar_dir: str
+    model_bazaar_endpoint: str
+    job_auth_token: str
+    license_key: str
+
+    input_file: FileInfo
+    output_path: str
+    options: BatchPredictOptions = Field(default_factory=BatchPredictOptions)
+
+    class Config:
+        protected_namespaces = ()
@@ -17,6 +17,23 @@ class KnowledgeExtractionOptions(BaseModel):
     generate_answers: bool
 
 
+class ConfidenceThresholds(BaseModel):
+    """
+    Predictions with a score below the threshold of their class are returned as
+    abstain_label so that they can be reviewed instead of acted on.
+    """
+
+    min_confidence: float = Field(0.0, ge=0, le=1)
+    class_thresholds: Dict[str, float] = Field(default_factory=dict)
+    abstain_label: str = "unsure"
+
+    def threshold(self, class_name: str) -> float:
+        return self.class_thresholds.get(class_name, self.min_confidence)
+
+    def should_abstain(self, class_name: str, score: float) -> bool:
+        return score < self.threshold(class_name)
+
+
 class DeploymentConfig(BaseModel):
     deployment_id: str = Field(default_factory=lambda: str(uuid.uuid4()))
     user_id: str
@@ -30,6 +47,8 @@ class DeploymentConfig(BaseModel):
 
     autoscaling_enabled: bool = False
 
+    confidence_thresholds: Optional[ConfidenceThresholds] = None
+
     options: Dict[str, Any]
 
     class Config:
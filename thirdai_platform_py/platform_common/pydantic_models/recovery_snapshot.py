@@ -31,6 +31,9 @@ class LocalBackupConfig(BaseModel):
     provider: Literal["local"] = "local"
 
 
+DEFAULT_FULL_BACKUP_EVERY = 6
+
+
 # The main BackupConfig class that uses Union with discriminator
 class BackupConfig(BaseModel):
     provider: Union[S3Config, AzureConfig, GCPConfig, LocalBackupConfig] = Field(
@@ -40,6 +43,13 @@ class BackupConfig(BaseModel):
         None, description="For scheduling backups at intervals"
     )
     backup_limit: Optional[int] = Field(5, description="Number of backups to retain")
+    incremental: bool = Field(
+        False, description="Only back up the models that changed since the last backup"
+    )
+    full_backup_every: Optional[int] = Field(
+        DEFAULT_FULL_BACKUP_EVERY,
+        description="Number of incremental backups to take before the next full backup",
+    )
 
     def save_backup_config(self, model_bazaar_dir):
         config_path = os.path.join(model_bazaar_dir, "backup_config.json")
@@ -50,3 +60,11 @@ class BackupConfig(BaseModel):
             json.dump(self.dict(), file, indent=4)
 
         return config_path
+
+
+class RestoreConfig(BaseModel):
+    provider: Union[S3Config, AzureConfig, GCPConfig, LocalBackupConfig] = Field(
+        ..., discriminator="provider"
+    )
+    backup: str = Field(..., description="Name of the backup to restore")
+    restore_id: str
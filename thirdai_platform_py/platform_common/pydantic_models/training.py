@@ -1,4 +1,6 @@
+import html
 import os
+import re
 from enum import Enum
 from typing import Any, Dict, List, Literal, Optional, Union
 
@@ -17,6 +19,8 @@ class ModelType(str, Enum):
     NLP_DOC = "nlp-doc"
     ENTERPRISE_SEARCH = "enterprise-search"
     KNOWLEDGE_EXTRACTION = "ke"
+    ENSEMBLE = "ensemble"
+    EXTERNAL = "external"
 
 
 class ModelDataType(str, Enum):
@@ -25,6 +29,13 @@ class ModelDataType(str, Enum):
     UDT_DATAGEN = "udt_datagen"
 
 
+class FileValidation(str, Enum):
+    # Fail the train job if any file is invalid.
+    strict = "strict"
+    # Skip invalid files and record the failures in the train report.
+    skip_and_report = "skip_and_report"
+
+
 class FileLocation(str, Enum):
     local = "local"
     nfs = "nfs"
@@ -138,6 +149,8 @@ class NDBData(BaseModel):
 
     deletions: List[str] = []
 
+    file_validation: FileValidation = FileValidation.skip_and_report
+
     class Config:
         protected_namespaces = ()
 
@@ -150,6 +163,43 @@ class NDBData(BaseModel):
         return self
 
 
+HTML_TAG = re.compile(r"<[^>]*>")
+
+PREPROCESSING_FILE = "preprocessing.json"
+
+
+class TextPreprocessing(BaseModel):
+    """
+    Preprocessing applied to the text of nlp training data. It is saved with
+    the model so that deployments apply the same preprocessing to queries.
+    """
+
+    lowercase: bool = False
+    strip_html: bool = False
+
+    def enabled(self) -> bool:
+        return self.lowercase or self.strip_html
+
+    @staticmethod
+    def load(model_dir: str) -> "TextPreprocessing":
+        path = os.path.join(model_dir, PREPROCESSING_FILE)
+        if not os.path.exists(path):
+            return TextPreprocessing()
+        with open(path) as f:
+            return TextPreprocessing.model_validate_json(f.read())
+
+    def save(self, model_dir: str):
+        with open(os.path.join(model_dir, PREPROCESSING_FILE), "w") as f:
+            f.write(self.model_dump_json())
+
+    def apply(self, text: str) -> str:
+        if self.strip_html:
+            text = " ".join(html.unescape(HTML_TAG.sub(" ", text)).split())
+        if self.lowercase:
+            text = text.lower()
+        return text
+
+
 class NlpTokenOptions(BaseModel):
     model_type: Literal[ModelType.NLP_TOKEN] = ModelType.NLP_TOKEN
 
@@ -157,6 +207,7 @@ class NlpTokenOptions(BaseModel):
     source_column: str
     target_column: str
     default_tag: str = "O"
+    preprocessing: TextPreprocessing = TextPreprocessing()
 
 
 class NlpTextOptions(BaseModel):
@@ -166,6 +217,20 @@ class NlpTextOptions(BaseModel):
     label_column: str
     n_target_classes: int
     delimiter: str = ","
+    preprocessing: TextPreprocessing = TextPreprocessing()
+
+
+class EvaluationOptions(BaseModel):
+    # Per group metrics computed by splitting the holdout data on the value of
+    # a metadata column, see ClassificationModel.evaluation_checks.
+    group_column: Optional[str] = None
+    min_group_size: int = 10
+    max_group_gap: Optional[float] = None
+
+    # Perturbation tests, see train_job.utils.perturb_text.
+    perturbations: List[str] = []
+    perturbation_seed: Optional[int] = None
+    max_perturbation_drop: Optional[float] = None
 
 
 class NlpTrainOptions(BaseModel):
@@ -174,6 +239,36 @@ class NlpTrainOptions(BaseModel):
     batch_size: int = 2048
     max_in_memory_batches: Optional[int] = None
     test_split: Optional[float] = None
+    test_split_seed: Optional[int] = None
+    # For stratified splits model bazaar splits the train files and passes the
+    # resulting test file in the test files.
+    test_split_strategy: str = "random"
+
+    # Minimum values for metrics of the holdout evaluation run after training.
+    eval_thresholds: Dict[str, float] = {}
+
+    # Optional fairness and robustness checks run on the holdout data after
+    # training, the results are written to the train report.
+    evaluation: Optional[EvaluationOptions] = None
+
+    # Class imbalance mitigation applied to the training data, see
+    # train_job.utils.rebalance_rows.
+    class_weighting: str = "none"
+    class_weights: Dict[str, float] = {}
+    oversampling: str = "none"
+    oversampling_ratio: float = 0.0
+
+    def rebalancing_enabled(self) -> bool:
+        return self.class_weighting in ("balanced", "custom") or (
+            self.oversampling == "random"
+        )
+
+
+class CSVOptions(BaseModel):
+    # Options that are not specified are detected from the file.
+    delimiter: Optional[str] = None
+    quote: Optional[str] = None
+    encoding: Optional[str] = None
 
 
 class UDTData(BaseModel):
@@ -182,6 +277,10 @@ class UDTData(BaseModel):
     supervised_files: List[FileInfo]
     test_files: List[FileInfo] = []
 
+    file_validation: FileValidation = FileValidation.strict
+
+    csv_options: Optional[CSVOptions] = None
+
     class Config:
         protected_namespaces = ()
 
@@ -257,6 +356,9 @@ class DatagenConfig(BaseModel):
 class JobOptions(BaseModel):
     allocation_cores: int = Field(1, gt=0)
     allocation_memory: int = Field(6800, gt=500)
+    # Used by model bazaar to schedule and preempt train jobs.
+    priority: int = Field(0, ge=0, le=100)
+    preempt: bool = False
 
 
 class TrainConfig(BaseModel):
@@ -0,0 +1,366 @@
+import datetime
+import json
+import logging
+import os
+import shutil
+import subprocess
+import zipfile
+from collections import defaultdict
+from pathlib import Path
+from typing import Dict, List, Optional, Tuple
+
+from platform_common.logging import setup_logger
+from platform_common.pydantic_models.recovery_snapshot import RestoreConfig
+from recovery_snapshot_job.run import (
+    DB_DUMP_FILE,
+    EXCLUDED_DIRS,
+    MANIFEST_FILE,
+    get_cloud_storage_handler,
+)
+
+model_bazaar_dir = os.getenv("MODEL_BAZAAR_DIR")
+
+log_dir: Path = Path(model_bazaar_dir) / "logs"
+
+setup_logger(log_dir=log_dir, log_prefix="recovery_restore")
+
+logger = logging.getLogger("recovery_restore")
+
+# How often to report progress while restoring files.
+PROGRESS_INTERVAL_FILES = 100
+
+
+class RestoreReporter:
+    """
+    Writes the status of the restore to the restore directory, where model
+    bazaar reads it from. The status is not stored in the database since the
+    database is replaced by the restore.
+    """
+
+    def __init__(self, model_bazaar_dir: str, config: RestoreConfig):
+        self.path = os.path.join(
+            model_bazaar_dir, "restores", config.restore_id, "status.json"
+        )
+        now = utc_now()
+        self.status = {
+            "restore_id": config.restore_id,
+            "backup": config.backup,
+            "status": "starting",
+            "backups": [],
+            "files_restored": 0,
+            "total_files": 0,
+            "started_at": now,
+            "updated_at": now,
+        }
+        if os.path.exists(self.path):
+            with open(self.path) as file:
+                self.status.update(json.load(file))
+
+    def update(self, **fields):
+        self.status.update(fields, updated_at=utc_now())
+        os.makedirs(os.path.dirname(self.path), exist_ok=True)
+        tmp_path = self.path + ".tmp"
+        with open(tmp_path, "w") as file:
+            json.dump(self.status, file)
+        os.replace(tmp_path, self.path)
+
+
+def utc_now() -> str:
+    return datetime.datetime.now(datetime.timezone.utc).isoformat().replace(
+        "+00:00", "Z"
+    )
+
+
+def read_manifest(archive_path: str) -> Optional[Dict]:
+    """Returns the manifest of the backup, or None for backups without one."""
+    with zipfile.ZipFile(archive_path) as archive:
+        if MANIFEST_FILE not in archive.namelist():
+            return None
+        return json.loads(archive.read(MANIFEST_FILE))
+
+
+def fetch_backups(
+    config: RestoreConfig, model_bazaar_dir: str, work_dir: str
+) -> List[Tuple[str, str, Optional[Dict]]]:
+    """
+    Returns the name, local path, and manifest of each backup needed to restore
+    the chosen backup, starting with the full backup that the chosen backup was
+    incremented from. Backups in cloud storage are downloaded to the work dir.
+    """
+    cloud_handler = get_cloud_storage_handler(config)
+
+    chain = []
+    name = config.backup
+    while name:
+        if cloud_handler:
+            path = os.path.join(work_dir, name)
+            cloud_handler.download_file(config.provider.bucket_name, name, path)
+        else:
+            path = os.path.join(model_bazaar_dir, "backups", name)
+        if not os.path.exists(path):
+            raise ValueError(f"backup {name} not found")
+
+        manifest = read_manifest(path)
+        chain.append((name, path, manifest))
+        logger.info(f"Fetched backup {name}")
+
+        name = manifest.get("parent") if manifest else None
+
+    chain.reverse()
+    return chain
+
+
+def restore_plan(chain: List[Tuple[str, str, Optional[Dict]]]) -> Dict[str, List[str]]:
+    """
+    Returns the files to extract from each backup. Files outside of the models
+    directory are taken from the last backup, and each model is taken from the
+    last backup that contains it.
+    """
+    _, last_path, last_manifest = chain[-1]
+
+    model_sources = {}
+    for _, path, manifest in chain:
+        with zipfile.ZipFile(path) as archive:
+            names = archive.namelist()
+
+        model_files = defaultdict(list)
+        other_files = []
+        for name in names:
+            parts = name.split("/")
+            if name in (DB_DUMP_FILE, MANIFEST_FILE) or parts[0] in EXCLUDED_DIRS:
+                continue
+            if parts[0] == "models" and len(parts) > 2:
+                model_files[parts[1]].append(name)
+            else:
+                other_files.append(name)
+
+        changed = manifest["changed_models"] if manifest else model_files.keys()
+        for model in changed:
+            model_sources[model] = (path, model_files[model])
+
+    if last_manifest:
+        model_sources = {
+            model: source
+            for model, source in model_sources.items()
+            if model in last_manifest["models"]
+        }
+
+    # Each backup contains all of the files outside of the models directory,
+    # so only the files from the last backup are restored.
+    plan = defaultdict(list)
+    plan[last_path].extend(other_files)
+    for path, files in model_sources.values():
+        plan[path].extend(files)
+    return plan
+
+
+# Statuses of train jobs and deployments that must not be running during a
+# restore, since they write to the database and the share directory.
+ACTIVE_STATUSES = ("queued", "starting", "in_progress")
+
+
+def psql(db_uri: str, *args: str, **kwargs) -> subprocess.CompletedProcess:
+    return subprocess.run(
+        ["psql", db_uri, "-v", "ON_ERROR_STOP=1", *args], check=True, **kwargs
+    )
+
+
+def check_quiesced(db_uri: str):
+    """
+    Raises an error if any train jobs or deployments are running. The restore
+    replaces the database and the models directory, so running jobs would write
+    to models that are replaced or report statuses to the wrong database.
+    """
+    statuses = ", ".join(f"'{status}'" for status in ACTIVE_STATUSES)
+    result = psql(
+        db_uri,
+        "-tA",
+        "-c",
+        f"SELECT count(*) FROM models WHERE train_status IN ({statuses}) OR deploy_status IN ({statuses});",
+        capture_output=True,
+        text=True,
+    )
+    active = int(result.stdout.strip() or 0)
+    if active > 0:
+        raise ValueError(
+            f"cannot restore while {active} models have running train jobs or deployments, stop them before restoring"
+        )
+
+
+def previous_schema_name(config: RestoreConfig) -> str:
+    return "public_before_restore_" + config.restore_id.replace("-", "_")
+
+
+def restore_database(db_uri: str, dump_path: str, previous_schema: str):
+    """
+    Loads the dump into a new public schema in a single transaction, so that the
+    database is unchanged if the restore fails. The current schema is renamed to
+    previous_schema rather than dropped, so that it can be swapped back if the
+    files cannot be restored. The restored schema is checked before the
+    transaction commits.
+    """
+    psql(
+        db_uri,
+        "--single-transaction",
+        "-c",
+        f'ALTER SCHEMA public RENAME TO "{previous_schema}"; CREATE SCHEMA public;',
+        "-f",
+        dump_path,
+        "-c",
+        "SELECT 'public.models'::regclass, 'public.users'::regclass;",
+    )
+
+
+def swap_database_back(db_uri: str, previous_schema: str):
+    psql(
+        db_uri,
+        "--single-transaction",
+        "-c",
+        f'DROP SCHEMA public CASCADE; ALTER SCHEMA "{previous_schema}" RENAME TO public;',
+    )
+
+
+def drop_previous_database(db_uri: str, previous_schema: str):
+    psql(db_uri, "-c", f'DROP SCHEMA IF EXISTS "{previous_schema}" CASCADE;')
+
+
+def stage_files(
+    plan: Dict[str, List[str]], staging_dir: str, reporter: RestoreReporter
+):
+    """
+    Extracts the files to the staging dir and checks that each file has the size
+    it has in the backup. The contents are checked against the checksums in the
+    backup as they are extracted.
+    """
+    restored = 0
+    for path, files in plan.items():
+        with zipfile.ZipFile(path) as archive:
+            for name in files:
+                info = archive.getinfo(name)
+                staged = archive.extract(info, staging_dir)
+                if not info.is_dir() and os.path.getsize(staged) != info.file_size:
+                    raise ValueError(f"file {name} from backup {path} is incomplete")
+                restored += 1
+                if restored % PROGRESS_INTERVAL_FILES == 0:
+                    reporter.update(files_restored=restored)
+    reporter.update(files_restored=restored)
+
+
+class FileSwap:
+    """
+    Moves the staged files into the share directory, keeping the files they
+    replace in the previous dir so that the swap can be undone. The models
+    directory is swapped with a single rename, so models that were created after
+    the backup are removed and files added to models after the backup do not
+    remain.
+    """
+
+    def __init__(self, model_bazaar_dir: str, staging_dir: str, previous_dir: str):
+        self.model_bazaar_dir = model_bazaar_dir
+        self.staging_dir = staging_dir
+        self.previous_dir = previous_dir
+        self.moved: List[Tuple[str, Optional[str]]] = []
+
+    def replace(self, src: str, dest: str):
+        rel_path = os.path.relpath(dest, self.model_bazaar_dir)
+        previous = None
+        if os.path.exists(dest):
+            previous = os.path.join(self.previous_dir, rel_path)
+            os.makedirs(os.path.dirname(previous), exist_ok=True)
+            os.rename(dest, previous)
+        self.moved.append((dest, previous))
+        os.makedirs(os.path.dirname(dest), exist_ok=True)
+        os.rename(src, dest)
+
+    def swap(self):
+        staged_models = os.path.join(self.staging_dir, "models")
+        os.makedirs(staged_models, exist_ok=True)
+        self.replace(staged_models, os.path.join(self.model_bazaar_dir, "models"))
+
+        for root, _, files in os.walk(self.staging_dir):
+            for name in files:
+                src = os.path.join(root, name)
+                rel_path = os.path.relpath(src, self.staging_dir)
+                self.replace(src, os.path.join(self.model_bazaar_dir, rel_path))
+
+    def undo(self):
+        for dest, previous in reversed(self.moved):
+            if os.path.isdir(dest):
+                shutil.rmtree(dest)
+            elif os.path.exists(dest):
+                os.remove(dest)
+            if previous:
+                os.rename(previous, dest)
+        self.moved = []
+
+
+def perform_restore(config: RestoreConfig, reporter: RestoreReporter):
+    model_bazaar_dir = os.getenv("MODEL_BAZAAR_DIR")
+    db_uri = os.getenv("DATABASE_URI")
+    work_dir = os.path.join(model_bazaar_dir, "restores", config.restore_id, "work")
+    staging_dir = os.path.join(work_dir, "staging")
+    previous_dir = os.path.join(work_dir, "previous")
+    os.makedirs(work_dir, exist_ok=True)
+
+    try:
+        reporter.update(status="in_progress", step="downloading")
+        check_quiesced(db_uri)
+        chain = fetch_backups(config, model_bazaar_dir, work_dir)
+        reporter.update(backups=[name for name, _, _ in chain])
+
+        # The files are extracted and checked before the database or the share
+        # directory are changed.
+        plan = restore_plan(chain)
+        reporter.update(
+            step="restoring_files",
+            total_files=sum(len(files) for files in plan.values()),
+        )
+        stage_files(plan, staging_dir, reporter)
+        logger.info("Extracted files")
+
+        _, last_path, _ = chain[-1]
+
+        reporter.update(step="restoring_database")
+        with zipfile.ZipFile(last_path) as archive:
+            dump_path = archive.extract(DB_DUMP_FILE, work_dir)
+        previous_schema = previous_schema_name(config)
+        restore_database(db_uri, dump_path, previous_schema)
+        logger.info("Restored database")
+
+        files = FileSwap(model_bazaar_dir, staging_dir, previous_dir)
+        try:
+            files.swap()
+        except Exception:
+            logger.error("Error restoring files, reverting the restore")
+            files.undo()
+            swap_database_back(db_uri, previous_schema)
+            raise
+        logger.info("Restored files")
+
+        try:
+            drop_previous_database(db_uri, previous_schema)
+        except subprocess.CalledProcessError as err:
+            logger.warning(f"Unable to drop schema {previous_schema}: {err}")
+
+        reporter.update(status="complete", step=None)
+    finally:
+        shutil.rmtree(work_dir, ignore_errors=True)
+
+
+if __name__ == "__main__":
+    config_file = os.getenv("CONFIG_PATH")
+    if not config_file or not os.path.exists(config_file):
+        logger.error("Config file not found.")
+        raise ValueError("Config file not found.")
+
+    config = RestoreConfig.parse_file(config_file)
+    reporter = RestoreReporter(model_bazaar_dir, config)
+
+    try:
+        logger.info(f"Starting restore of backup {config.backup}")
+        perform_restore(config, reporter)
+        logger.info(f"Restore of backup {config.backup} complete")
+    except Exception as err:
+        logger.error(f"Restore failed: {err}")
+        reporter.update(status="failed", error=str(err))
+        raise
@@ -1,9 +1,11 @@
 import datetime
+import json
 import logging
 import os
 import subprocess
 import zipfile
 from pathlib import Path
+from typing import List, Optional
 
 from apscheduler.schedulers.blocking import BlockingScheduler
 from apscheduler.triggers.interval import IntervalTrigger
@@ -15,6 +17,7 @@ from platform_common.file_handler import (
 )
 from platform_common.logging import setup_logger
 from platform_common.pydantic_models.recovery_snapshot import (
+    DEFAULT_FULL_BACKUP_EVERY,
     AzureConfig,
     BackupConfig,
     GCPConfig,
@@ -32,6 +35,15 @@ setup_logger(log_dir=log_dir, log_prefix="recovery_snapshot")
 
 logger = logging.getLogger("recovery_snapshot")
 
+DB_DUMP_FILE = "db_backup.sql"
+
+# Each backup contains a manifest that records the backup it was incremented
+# from, and the models that existed when it was taken.
+MANIFEST_FILE = "backup_manifest.json"
+
+# Directories in the model bazaar dir that are never backed up.
+EXCLUDED_DIRS = {"backups", "restores"}
+
 
 def get_cloud_storage_handler(config: BackupConfig):
     """Get the appropriate cloud storage handler based on the provider."""
@@ -62,6 +74,8 @@ def extract_timestamp(backup_name: str) -> datetime:
     Extract the timestamp from the backup filename and convert it to a datetime object.
     Assumes the backup format is 'backup_YYYYMMDD_HHMMSS.zip'.
     """
+    # Cloud storage handlers list backups with the bucket in the path.
+    backup_name = os.path.basename(backup_name)
     try:
         # Extract the timestamp part (e.g., '20241010_203418') from the backup filename
         timestamp_str = (
@@ -75,6 +89,66 @@ def extract_timestamp(backup_name: str) -> datetime:
         return datetime.datetime.min
 
 
+def is_incremental(backup_name: str) -> bool:
+    return os.path.basename(backup_name).endswith("_incremental.zip")
+
+
+def choose_parent_backup(
+    existing_backups: List[str], config: BackupConfig
+) -> Optional[str]:
+    """
+    Returns the backup that the next backup should be incremented from, or None
+    if the next backup should be a full backup.
+    """
+    if not config.incremental or not existing_backups:
+        return None
+
+    full_backup_every = config.full_backup_every or DEFAULT_FULL_BACKUP_EVERY
+
+    sorted_backups = sorted(existing_backups, key=extract_timestamp, reverse=True)
+    incremental_count = 0
+    for backup in sorted_backups:
+        if not is_incremental(backup):
+            break
+        incremental_count += 1
+    else:
+        # The full backup that the chain starts from was deleted.
+        return None
+
+    if incremental_count >= full_backup_every:
+        return None
+
+    return os.path.basename(sorted_backups[0])
+
+
+def backups_to_delete(backups: List[str], backup_limit: Optional[int]) -> List[str]:
+    """
+    Returns the backups that exceed the limit. An incremental backup can only be
+    restored with the backups before it back to the last full backup, so those
+    are kept even if they are over the limit.
+    """
+    sorted_backups = sorted(backups, key=extract_timestamp, reverse=True)
+    if not backup_limit or len(sorted_backups) <= backup_limit:
+        return []
+
+    keep = backup_limit
+    while keep < len(sorted_backups) and is_incremental(sorted_backups[keep - 1]):
+        keep += 1
+
+    return sorted_backups[keep:]
+
+
+def model_changed_since(model_dir: str, since: float) -> bool:
+    for root, _, files in os.walk(model_dir):
+        # The mtime of a directory changes when files are added or removed.
+        if os.path.getmtime(root) > since:
+            return True
+        for file in files:
+            if os.path.getmtime(os.path.join(root, file)) > since:
+                return True
+    return False
+
+
 def delete_local_backup(zip_file_path: str, dump_file_path: str):
     """Delete local backup files after successful backup."""
     try:
@@ -87,23 +161,56 @@ def delete_local_backup(zip_file_path: str, dump_file_path: str):
 
 
 def create_backup_files(
-    db_uri: str, model_bazaar_dir: str, backup_dir: str, timestamp: str
+    db_uri: str,
+    model_bazaar_dir: str,
+    backup_dir: str,
+    timestamp: str,
+    parent: Optional[str] = None,
 ):
+    """
+    Creates a backup of the database and the model bazaar dir. If a parent backup
+    is given, then the backup only contains the models that changed since the
+    parent backup was taken.
+    """
     # Create database dump file
-    dump_file_path = os.path.join(model_bazaar_dir, f"db_backup.sql")
+    dump_file_path = os.path.join(model_bazaar_dir, DB_DUMP_FILE)
     logger.info(f"Creating database dump at {dump_file_path}")
     # TODO(YASH): Only backup completed models.
     subprocess.run(["pg_dump", db_uri, "-f", dump_file_path], check=True)
 
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    models = sorted(os.listdir(models_dir)) if os.path.isdir(models_dir) else []
+    if parent:
+        since = extract_timestamp(parent).timestamp()
+        changed_models = [
+            model
+            for model in models
+            if model_changed_since(os.path.join(models_dir, model), since)
+        ]
+    else:
+        changed_models = models
+    unchanged_models = set(models) - set(changed_models)
+
+    suffix = "_incremental" if parent else ""
+    backup_name = f"backup_{timestamp}{suffix}.zip"
+    manifest = {
+        "name": backup_name,
+        "parent": parent,
+        "created_at": timestamp,
+        "models": models,
+        "changed_models": changed_models,
+    }
+
     # Path for the zip file in the backups folder
-    zip_file_path = os.path.join(backup_dir, f"backup_{timestamp}.zip")
+    zip_file_path = os.path.join(backup_dir, backup_name)
 
-    # Create a zip file excluding the 'backups' folder
     with zipfile.ZipFile(zip_file_path, "w", zipfile.ZIP_DEFLATED) as zipf:
-        for root, _, files in os.walk(model_bazaar_dir):
-            # Skip the 'backups' directory
-            if "backups" in root:
-                continue
+        for root, dirs, files in os.walk(model_bazaar_dir):
+            relative_root = os.path.relpath(root, model_bazaar_dir)
+            if relative_root == ".":
+                dirs[:] = [d for d in dirs if d not in EXCLUDED_DIRS]
+            elif relative_root == "models":
+                dirs[:] = [d for d in dirs if d not in unchanged_models]
 
             # Add files to the zip archive
             for file in files:
@@ -111,19 +218,21 @@ def create_backup_files(
                 relative_path = os.path.relpath(file_path, model_bazaar_dir)
                 zipf.write(file_path, relative_path)
 
-    logger.info(f"Backup zip file created at {zip_file_path}")
+        zipf.writestr(MANIFEST_FILE, json.dumps(manifest, indent=4))
+
+    logger.info(
+        f"Backup zip file created at {zip_file_path} with {len(changed_models)} of {len(models)} models"
+    )
     return zip_file_path, dump_file_path
 
 
 def manage_backup_limit_local(backup_dir: str, backup_limit: int):
     """Ensure that the number of local backups does not exceed the limit."""
     all_backups = [f for f in os.listdir(backup_dir) if f.startswith("backup_")]
-    sorted_backups = sorted(all_backups, key=extract_timestamp, reverse=True)
-    if backup_limit and len(sorted_backups) > backup_limit:
-        for backup in sorted_backups[backup_limit:]:
-            backup_path = os.path.join(backup_dir, backup)
-            os.remove(backup_path)
-            logger.info(f"Deleted old local backup: {backup_path}")
+    for backup in backups_to_delete(all_backups, backup_limit):
+        backup_path = os.path.join(backup_dir, backup)
+        os.remove(backup_path)
+        logger.info(f"Deleted old local backup: {backup_path}")
 
 
 def manage_backup_limit(
@@ -131,11 +240,9 @@ def manage_backup_limit(
 ):
     """Ensure that the number of backups in the cloud does not exceed the limit."""
     all_backups = cloud_handler.list_files(bucket_name, "backup_")
-    sorted_backups = sorted(all_backups, key=extract_timestamp, reverse=True)
-    if backup_limit and len(sorted_backups) > backup_limit:
-        for backup in sorted_backups[backup_limit:]:
-            cloud_handler.delete_path(bucket_name, backup)
-            logger.info(f"Deleted old cloud backup: {backup}")
+    for backup in backups_to_delete(all_backups, backup_limit):
+        cloud_handler.delete_path(bucket_name, backup)
+        logger.info(f"Deleted old cloud backup: {backup}")
 
 
 def perform_backup(config_file):
@@ -151,18 +258,28 @@ def perform_backup(config_file):
         db_uri = os.getenv("DATABASE_URI")
         backup_limit = config.backup_limit
 
+        cloud_handler = get_cloud_storage_handler(config)
+        if cloud_handler:
+            cloud_handler.create_bucket_if_not_exists(config.provider.bucket_name)
+            existing_backups = cloud_handler.list_files(
+                config.provider.bucket_name, "backup_"
+            )
+        else:
+            existing_backups = [
+                f for f in os.listdir(backup_dir) if f.startswith("backup_")
+            ]
+        parent = choose_parent_backup(existing_backups, config)
+
         zip_file_path, dump_file_path = create_backup_files(
-            db_uri, model_bazaar_dir, backup_dir, timestamp
+            db_uri, model_bazaar_dir, backup_dir, timestamp, parent=parent
         )
 
         # If a cloud provider is configured, upload the backup to the cloud
-        cloud_handler = get_cloud_storage_handler(config)
         if cloud_handler:
-            cloud_handler.create_bucket_if_not_exists(config.provider.bucket_name)
             cloud_handler.upload_file(
                 f"{zip_file_path}",
                 config.provider.bucket_name,
-                f"backup_{timestamp}.zip",
+                os.path.basename(zip_file_path),
             )
             manage_backup_limit(
                 cloud_handler, config.provider.bucket_name, backup_limit
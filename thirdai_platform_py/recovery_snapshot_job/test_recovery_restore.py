@@ -0,0 +1,272 @@
+import json
+import os
+import shutil
+import subprocess
+import tempfile
+import time
+from unittest.mock import MagicMock, patch
+
+import pytest
+from platform_common.pydantic_models.recovery_snapshot import (
+    BackupConfig,
+    LocalBackupConfig,
+    RestoreConfig,
+)
+
+
+@pytest.fixture(autouse=True)
+def model_bazaar_dir():
+    temp_dir = tempfile.mkdtemp()
+    os.environ["MODEL_BAZAAR_DIR"] = temp_dir
+    os.environ["DATABASE_URI"] = "random db_uri"
+
+    yield temp_dir
+
+    shutil.rmtree(temp_dir)
+
+
+def write_file(path, data):
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, "w") as f:
+        f.write(data)
+
+
+def read_file(path):
+    with open(path) as f:
+        return f.read()
+
+
+def backup(config, model_bazaar_dir):
+    from recovery_snapshot_job.run import perform_backup
+
+    config_path = os.path.join(model_bazaar_dir, "backup_config.json")
+    with open(config_path, "w") as f:
+        json.dump(config.dict(), f)
+
+    # Backups are named by the second they are created in.
+    time.sleep(1.1)
+    write_file(os.path.join(model_bazaar_dir, "db_backup.sql"), "dump")
+    perform_backup(config_path)
+
+
+def list_backups(model_bazaar_dir):
+    return sorted(os.listdir(os.path.join(model_bazaar_dir, "backups")))
+
+
+def fake_psql(active_models=0, fail_restore=False):
+    """
+    Returns a fake for subprocess.run, where psql reports active_models running
+    jobs, and loading the dump fails if fail_restore is set.
+    """
+
+    def run(args, **kwargs):
+        if fail_restore and "-f" in args:
+            raise subprocess.CalledProcessError(3, args)
+        return MagicMock(returncode=0, stdout=f"{active_models}\n")
+
+    return MagicMock(side_effect=run)
+
+
+def psql_commands(mock_subprocess_run):
+    return [
+        " ".join(call.args[0])
+        for call in mock_subprocess_run.call_args_list
+        if call.args[0][0] == "psql"
+    ]
+
+
+def backup_and_change(model_bazaar_dir):
+    """
+    Takes a full backup of models a and b, and then changes model a and adds
+    model c. Returns the name of the backup.
+    """
+    config = BackupConfig(provider=LocalBackupConfig())
+
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    write_file(os.path.join(models_dir, "a", "model.ndb"), "a-v1")
+    write_file(os.path.join(models_dir, "b", "model.ndb"), "b-v1")
+    with patch("subprocess.run", return_value=MagicMock(returncode=0)):
+        backup(config, model_bazaar_dir)
+
+    write_file(os.path.join(models_dir, "a", "model.ndb"), "a-v2")
+    write_file(os.path.join(models_dir, "c", "model.ndb"), "c-v1")
+
+    return list_backups(model_bazaar_dir)[0]
+
+
+@patch("subprocess.run", new_callable=fake_psql)
+def test_incremental_backup_and_restore(mock_subprocess_run, model_bazaar_dir):
+    from recovery_snapshot_job.restore import (
+        RestoreReporter,
+        perform_restore,
+        read_manifest,
+    )
+
+    config = BackupConfig(
+        provider=LocalBackupConfig(), incremental=True, full_backup_every=2
+    )
+
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    write_file(os.path.join(models_dir, "a", "model.ndb"), "a-v1")
+    write_file(os.path.join(models_dir, "b", "model.ndb"), "b-v1")
+    backup(config, model_bazaar_dir)
+
+    time.sleep(1.1)
+    write_file(os.path.join(models_dir, "b", "model.ndb"), "b-v2")
+    backup(config, model_bazaar_dir)
+
+    full, incremental = list_backups(model_bazaar_dir)
+    assert not full.endswith("_incremental.zip")
+    assert incremental.endswith("_incremental.zip")
+
+    manifest = read_manifest(os.path.join(model_bazaar_dir, "backups", incremental))
+    assert manifest["parent"] == full
+    assert manifest["models"] == ["a", "b"]
+    assert manifest["changed_models"] == ["b"]
+
+    # A full backup is taken after full_backup_every incremental backups.
+    backup(config, model_bazaar_dir)
+    backup(config, model_bazaar_dir)
+    backups = list_backups(model_bazaar_dir)
+    assert [b.endswith("_incremental.zip") for b in backups] == [
+        False,
+        True,
+        True,
+        False,
+    ]
+
+    # Changes after the backup are undone by the restore.
+    write_file(os.path.join(models_dir, "a", "model.ndb"), "a-v3")
+    write_file(os.path.join(models_dir, "a", "extra"), "extra")
+    write_file(os.path.join(models_dir, "c", "model.ndb"), "c-v1")
+
+    restore_config = RestoreConfig(
+        provider=LocalBackupConfig(), backup=incremental, restore_id="restore-1"
+    )
+    reporter = RestoreReporter(model_bazaar_dir, restore_config)
+    perform_restore(restore_config, reporter)
+
+    assert sorted(os.listdir(models_dir)) == ["a", "b"]
+    assert os.listdir(os.path.join(models_dir, "a")) == ["model.ndb"]
+    assert read_file(os.path.join(models_dir, "a", "model.ndb")) == "a-v1"
+    assert read_file(os.path.join(models_dir, "b", "model.ndb")) == "b-v2"
+
+    status = json.loads(
+        read_file(
+            os.path.join(model_bazaar_dir, "restores", "restore-1", "status.json")
+        )
+    )
+    assert status["status"] == "complete"
+    assert status["backups"] == [full, incremental]
+    assert status["files_restored"] == status["total_files"]
+    assert not os.path.exists(
+        os.path.join(model_bazaar_dir, "restores", "restore-1", "work")
+    )
+
+    # The database is swapped in a single transaction, and the previous schema
+    # is dropped once the files are restored.
+    commands = psql_commands(mock_subprocess_run)
+    assert "SELECT count(*) FROM models" in commands[0]
+    assert "--single-transaction" in commands[1] and "RENAME TO" in commands[1]
+    assert "DROP SCHEMA IF EXISTS" in commands[-1]
+
+
+def test_backup_limit_keeps_incremental_chains():
+    from recovery_snapshot_job.run import backups_to_delete
+
+    backups = [
+        "backup_20241010_100000.zip",
+        "backup_20241010_110000_incremental.zip",
+        "backup_20241010_120000.zip",
+        "backup_20241010_130000_incremental.zip",
+        "backup_20241010_140000_incremental.zip",
+    ]
+
+    assert backups_to_delete(backups, 5) == []
+    assert backups_to_delete(backups, 1) == [
+        "backup_20241010_110000_incremental.zip",
+        "backup_20241010_100000.zip",
+    ]
+    assert backups_to_delete(backups, 4) == []
+
+
+def test_restore_missing_backup(model_bazaar_dir):
+    from recovery_snapshot_job.restore import RestoreReporter, perform_restore
+
+    restore_config = RestoreConfig(
+        provider=LocalBackupConfig(),
+        backup="backup_20241010_100000.zip",
+        restore_id="restore-2",
+    )
+    reporter = RestoreReporter(model_bazaar_dir, restore_config)
+    with patch("subprocess.run", new_callable=fake_psql):
+        with pytest.raises(ValueError):
+            perform_restore(restore_config, reporter)
+
+    assert reporter.status["step"] == "downloading"
+
+
+def test_restore_requires_quiesced_platform(model_bazaar_dir):
+    from recovery_snapshot_job.restore import RestoreReporter, perform_restore
+
+    name = backup_and_change(model_bazaar_dir)
+
+    restore_config = RestoreConfig(
+        provider=LocalBackupConfig(), backup=name, restore_id="restore-3"
+    )
+    reporter = RestoreReporter(model_bazaar_dir, restore_config)
+    with patch("subprocess.run", new_callable=lambda: fake_psql(active_models=2)):
+        with pytest.raises(ValueError, match="running train jobs or deployments"):
+            perform_restore(restore_config, reporter)
+
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    assert sorted(os.listdir(models_dir)) == ["a", "b", "c"]
+    assert read_file(os.path.join(models_dir, "a", "model.ndb")) == "a-v2"
+
+
+def test_failed_database_restore_leaves_files_unchanged(model_bazaar_dir):
+    from recovery_snapshot_job.restore import RestoreReporter, perform_restore
+
+    name = backup_and_change(model_bazaar_dir)
+
+    restore_config = RestoreConfig(
+        provider=LocalBackupConfig(), backup=name, restore_id="restore-4"
+    )
+    reporter = RestoreReporter(model_bazaar_dir, restore_config)
+    with patch("subprocess.run", new_callable=lambda: fake_psql(fail_restore=True)):
+        with pytest.raises(subprocess.CalledProcessError):
+            perform_restore(restore_config, reporter)
+
+    assert reporter.status["step"] == "restoring_database"
+
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    assert sorted(os.listdir(models_dir)) == ["a", "b", "c"]
+    assert read_file(os.path.join(models_dir, "a", "model.ndb")) == "a-v2"
+    assert not os.path.exists(
+        os.path.join(model_bazaar_dir, "restores", "restore-4", "work")
+    )
+
+
+def test_failed_file_restore_reverts_database(model_bazaar_dir):
+    from recovery_snapshot_job.restore import RestoreReporter, perform_restore
+
+    name = backup_and_change(model_bazaar_dir)
+
+    restore_config = RestoreConfig(
+        provider=LocalBackupConfig(), backup=name, restore_id="restore-5"
+    )
+    reporter = RestoreReporter(model_bazaar_dir, restore_config)
+    with patch("subprocess.run", new_callable=fake_psql) as mock_subprocess_run:
+        with patch(
+            "recovery_snapshot_job.restore.FileSwap.swap",
+            side_effect=OSError("disk full"),
+        ):
+            with pytest.raises(OSError):
+                perform_restore(restore_config, reporter)
+
+    commands = psql_commands(mock_subprocess_run)
+    assert "DROP SCHEMA public CASCADE" in commands[-1]
+    assert "RENAME TO public" in commands[-1]
+
+    models_dir = os.path.join(model_bazaar_dir, "models")
+    assert sorted(os.listdir(models_dir)) == ["a", "b", "c"]
@@ -1,4 +1,3 @@
-import json
 import math
 import os
 import random
@@ -9,11 +8,11 @@ import typing
 from abc import abstractmethod
 from collections import defaultdict
 from dataclasses import dataclass
-from datetime import datetime, timezone
 from logging import Logger
 from pathlib import Path
-from typing import Any, Dict, List, Tuple
+from typing import Any, Callable, Dict, List, Optional, Tuple
 
+import numpy as np
 import pandas as pd
 import thirdai
 from platform_common.file_handler import (
@@ -23,9 +22,12 @@ from platform_common.file_handler import (
 from platform_common.logging import LogCode
 from platform_common.pii.udt_common_patterns import find_common_pattern
 from platform_common.pydantic_models.training import (
+    CSVOptions,
+    EvaluationOptions,
     NlpTextOptions,
     NlpTokenOptions,
     NlpTrainOptions,
+    TextPreprocessing,
     TrainConfig,
 )
 from platform_common.thirdai_storage.data_types import (
@@ -41,7 +43,16 @@ from platform_common.thirdai_storage.storage import DataStorage, SQLiteConnector
 from thirdai import bolt
 from train_job.models.model import Model
 from train_job.reporter import Reporter
-from train_job.utils import check_csv_only
+from train_job.utils import (
+    check_trainable_formats,
+    normalize_csv,
+    perturb_text,
+    perturb_tokens,
+    preprocess_text_column,
+    preprocess_token_columns,
+    rebalance_rows,
+    tabular_to_csv,
+)
 
 
 def get_split_filename(original_name: str, split: str) -> str:
@@ -49,48 +60,255 @@ def get_split_filename(original_name: str, split: str) -> str:
     return f"{path}_{split}{ext}"
 
 
+def summarize_eval_metrics(metrics) -> Dict[str, float]:
+    """
+    model.evaluate returns the values of each metric keyed by 'val_<metric>',
+    this returns the final value of each metric keyed by the metric name.
+    """
+    summary = {}
+    if not isinstance(metrics, dict):
+        return summary
+    for name, values in metrics.items():
+        if isinstance(values, list):
+            if not values:
+                continue
+            values = values[-1]
+        if isinstance(values, (int, float)):
+            summary[name.removeprefix("val_")] = float(values)
+    return summary
+
+
 class ClassificationModel(Model):
     report_failure_method = "report_status"
 
+    # Describes how the holdout data used for evaluation was created, this is
+    # set when the train and test files are loaded.
+    holdout_split: Optional[Dict[str, Any]] = None
+
+    # Describes the class rebalancing applied to the train files, if any.
+    class_balance: Optional[Dict[str, Any]] = None
+
     @property
     def model_save_path(self) -> Path:
         return self.model_dir / "model" / "model.udt"
 
+    def valid_files(self, model, files: List[str]) -> List[str]:
+        """
+        Returns the subset of files that pass verification. Files that fail are
+        handled according to the file validation mode of the train config.
+        """
+        valid = []
+        for file in files:
+            try:
+                self.verify_file(model, file)
+                valid.append(file)
+            except Exception as e:
+                self.handle_file_failure(file, e)
+        return valid
+
+    def verify_file(self, model, file: str):
+        pass
+
     @property
     def train_options(self) -> NlpTrainOptions:
         return self.config.train_options
 
+    def csv_columns(self) -> Tuple[List[str], str]:
+        """
+        Returns the columns used by the model and the delimiter it expects.
+        """
+        raise NotImplementedError
+
+    @property
+    def preprocessing(self) -> TextPreprocessing:
+        """
+        Models trained from a base model use the preprocessing of the base model.
+        """
+        if self.config.model_options is not None:
+            return self.config.model_options.preprocessing
+        if self.config.base_model_id:
+            return TextPreprocessing.load(
+                self.get_udt_path(self.config.base_model_id).parent
+            )
+        return TextPreprocessing()
+
+    def save_preprocessing(self):
+        if self.preprocessing.enabled():
+            self.preprocessing.save(self.model_save_path.parent)
+
+    def preprocess(self, df: pd.DataFrame, columns: List[str]) -> pd.DataFrame:
+        return preprocess_text_column(df, columns[0], self.preprocessing)
+
+    def row_classes(self, df: pd.DataFrame, columns: List[str]) -> pd.Series:
+        """
+        Returns the class of each row, which is used to rebalance the data.
+        """
+        return df[columns[1]].astype(str)
+
+    def rebalance_files(self, files: List[str]) -> List[str]:
+        options = self.train_options
+        if not options.rebalancing_enabled():
+            return files
+
+        columns, delimiter = self.csv_columns()
+        seed = options.test_split_seed
+        if seed is None:
+            seed = random.randint(0, 2**31 - 1)
+
+        class_column = "__class__"
+        before, after = defaultdict(int), defaultdict(int)
+        rebalanced = []
+        for i, file in enumerate(files):
+            df = pd.read_csv(file, sep=delimiter, dtype=str, keep_default_na=False)
+            df[class_column] = self.row_classes(df, columns)
+            for cls, count in df[class_column].value_counts().items():
+                before[cls] += int(count)
+
+            df = rebalance_rows(df, class_column, options, seed)
+            for cls, count in df[class_column].value_counts().items():
+                after[cls] += int(count)
+
+            output_path = os.path.join(
+                self.temp_train_dir, f"rebalanced_{i}_{os.path.basename(file)}"
+            )
+            df.drop(columns=[class_column]).to_csv(
+                output_path, sep=delimiter, index=False
+            )
+            rebalanced.append(output_path)
+
+        self.class_balance = {
+            "class_weighting": options.class_weighting,
+            "oversampling": options.oversampling,
+            "seed": seed,
+            "class_counts_before": dict(before),
+            "class_counts_after": dict(after),
+        }
+        self.logger.info(
+            f"Rebalanced train files: {self.class_balance}", code=LogCode.MODEL_TRAIN
+        )
+
+        return rebalanced
+
+    def write_train_report(self, train_report: Dict[str, Any]):
+        if self.class_balance is not None:
+            train_report = {**train_report, "class_balance": self.class_balance}
+        super().write_train_report(train_report)
+
+    def normalize_csvs(self, files: List[str], tmp_dir: str) -> List[str]:
+        csv_options = self.config.data.csv_options
+        preprocessing = self.preprocessing.enabled()
+        is_csv = [file.lower().endswith(".csv") for file in files]
+        if csv_options is None and not preprocessing and all(is_csv):
+            return files
+
+        columns, delimiter = self.csv_columns()
+        preprocess = None
+        if preprocessing:
+            preprocess = lambda df: self.preprocess(df, columns)
+
+        # The group column of the evaluation checks is a metadata column that
+        # is not used by the model, so it is only kept if the file has it.
+        optional_columns = []
+        evaluation = self.train_options.evaluation
+        if evaluation and evaluation.group_column:
+            optional_columns.append(evaluation.group_column)
+
+        normalized = []
+        for i, file in enumerate(files):
+            if is_csv[i] and csv_options is None and not preprocessing:
+                normalized.append(file)
+                continue
+
+            name, _ = os.path.splitext(os.path.basename(file))
+            output_path = os.path.join(tmp_dir, f"normalized_{i}_{name}.csv")
+            try:
+                if is_csv[i]:
+                    normalize_csv(
+                        file,
+                        output_path,
+                        csv_options=csv_options or CSVOptions(),
+                        columns=columns,
+                        output_delimiter=delimiter,
+                        preprocess=preprocess,
+                        optional_columns=optional_columns,
+                    )
+                else:
+                    tabular_to_csv(
+                        file,
+                        output_path,
+                        columns=columns,
+                        output_delimiter=delimiter,
+                        preprocess=preprocess,
+                        optional_columns=optional_columns,
+                    )
+                normalized.append(output_path)
+            except Exception as e:
+                self.handle_file_failure(file, e)
+
+        self.logger.debug(f"Normalized {len(normalized)} training files")
+
+        return normalized
+
     def train_test_files(self) -> Tuple[List[str], List[str]]:
         train_files = expand_cloud_buckets_and_directories(
             self.config.data.supervised_files
         )
-        check_csv_only(train_files)
+        check_trainable_formats(train_files)
         self.temp_train_dir = tempfile.mkdtemp()
         train_files = get_local_file_infos(train_files, self.temp_train_dir)
         train_files = [file.path for file in train_files]
+        train_files = self.normalize_csvs(train_files, self.temp_train_dir)
         self.logger.info(f"Train files: {train_files}", code=LogCode.MODEL_TRAIN)
         self.logger.debug(f"Found {len(train_files)} train files")
 
         test_files = expand_cloud_buckets_and_directories(self.config.data.test_files)
-        check_csv_only(test_files)
+        check_trainable_formats(test_files)
         self.temp_test_dir = tempfile.mkdtemp()
         test_files = get_local_file_infos(test_files, self.temp_test_dir)
         test_files = [file.path for file in test_files]
+        test_files = self.normalize_csvs(test_files, self.temp_test_dir)
         self.logger.info(f"Test files: {test_files}", code=LogCode.MODEL_TRAIN)
         self.logger.debug(f"Found {len(test_files)} test files")
 
+        stratified = self.train_options.test_split_strategy == "stratified"
+        if len(test_files) > 0 and stratified:
+            _, delimiter = self.csv_columns()
+            self.holdout_split = {
+                "test_split": self.train_options.test_split,
+                "seed": self.train_options.test_split_seed,
+                "strategy": "stratified",
+                "train_rows": sum(
+                    len(pd.read_csv(file, sep=delimiter)) for file in train_files
+                ),
+                "test_rows": sum(
+                    len(pd.read_csv(file, sep=delimiter)) for file in test_files
+                ),
+            }
+        elif len(test_files) > 0:
+            self.holdout_split = {
+                "test_files": [file.path for file in self.config.data.test_files]
+            }
+
         test_split = self.train_options.test_split
         if len(test_files) == 0 and test_split and test_split > 0:
+            # The seed is recorded with the split so that it can be reproduced.
+            seed = self.train_options.test_split_seed
+            if seed is None:
+                seed = random.randint(0, 2**31 - 1)
+
             self.logger.debug(
-                f"Test split {test_split} specified, splitting train files into train and test"
+                f"Test split {test_split} specified, splitting train files into train and test with seed {seed}"
             )
             new_train_files = []
             new_test_files = []
+            train_rows, test_rows = 0, 0
             for file in train_files:
                 self.logger.debug(f"Splitting {file} into train/test")
                 df = pd.read_csv(file)
-                test = df.sample(frac=test_split)
+                test = df.sample(frac=test_split, random_state=seed)
                 train = df.drop(test.index)
+                train_rows += len(train)
+                test_rows += len(test)
 
                 train_split_path = get_split_filename(file, "train")
                 test_split_path = get_split_filename(file, "test")
@@ -104,9 +322,18 @@ class ClassificationModel(Model):
                 new_train_files.append(train_split_path)
                 new_test_files.append(test_split_path)
 
-            return new_train_files, new_test_files
+            self.holdout_split = {
+                "test_split": test_split,
+                "seed": seed,
+                "train_rows": train_rows,
+                "test_rows": test_rows,
+            }
+
+            # The train files are rebalanced after the split so that duplicated
+            # rows do not end up in the holdout data.
+            return self.rebalance_files(new_train_files), new_test_files
 
-        return train_files, test_files
+        return self.rebalance_files(train_files), test_files
 
     @abstractmethod
     def initialize_model(self):
@@ -174,6 +401,7 @@ class ClassificationModel(Model):
     def save_model(self, model):
         try:
             model.save(str(self.model_save_path))
+            self.save_preprocessing()
             self.logger.info(
                 f"Model saved to {self.model_save_path}", code=LogCode.MODEL_SAVE
             )
@@ -214,14 +442,21 @@ class ClassificationModel(Model):
             )
             raise e
 
-    def evaluate(self, model, test_files: List[str]):
+    def evaluate(self, model, test_files: List[str]) -> Dict[str, float]:
+        """
+        Evaluates the model on the test files and returns the average of each
+        metric across the files.
+        """
         self.logger.info("Starting evaluation on test files.", code=LogCode.MODEL_EVAL)
+        results = defaultdict(list)
         for test_file in test_files:
             try:
                 self.logger.info(
                     f"Evaluating on test file: {test_file}", code=LogCode.MODEL_EVAL
                 )
-                model.evaluate(test_file, metrics=["precision@1", "recall@1"])
+                metrics = model.evaluate(test_file, metrics=["precision@1", "recall@1"])
+                for name, value in summarize_eval_metrics(metrics).items():
+                    results[name].append(value)
                 self.logger.info(
                     f"Evaluation completed on test file: {test_file}",
                     code=LogCode.MODEL_EVAL,
@@ -231,6 +466,195 @@ class ClassificationModel(Model):
                     f"Failed to evaluate on test file {test_file} with error {e}",
                     code=LogCode.MODEL_EVAL,
                 )
+        return {name: sum(values) / len(values) for name, values in results.items()}
+
+    def holdout_evaluation(self, metrics: Dict[str, float]) -> Optional[Dict]:
+        if self.holdout_split is None:
+            return None
+        return self.report_holdout_evaluation(self.holdout_split, metrics)
+
+    # The metric that is compared across groups and perturbations by the
+    # evaluation checks.
+    evaluation_metric = "precision@1"
+
+    def perturb(
+        self,
+        df: pd.DataFrame,
+        columns: List[str],
+        perturbation: str,
+        rng: np.random.Generator,
+    ) -> pd.DataFrame:
+        """
+        Returns a copy of the holdout data with the perturbation applied to the
+        input column.
+        """
+        df = df.copy()
+        df[columns[0]] = df[columns[0]].map(
+            lambda text: perturb_text(text, perturbation, rng)
+        )
+        return df
+
+    def evaluation_checks(
+        self,
+        test_files: List[str],
+        metrics_fn: Callable[[List[str]], Dict[str, float]],
+    ) -> Optional[Dict[str, Any]]:
+        """
+        Runs the fairness and robustness checks of the evaluation options on the
+        holdout data, where metrics_fn computes the metrics of the model on a
+        list of files. Checks that fail are reported as warnings, they do not
+        block deployment like the eval thresholds.
+        """
+        options = self.train_options.evaluation
+        if options is None:
+            return None
+        if not test_files:
+            self.logger.warning(
+                "Skipping evaluation checks since there is no holdout data",
+                code=LogCode.MODEL_EVAL,
+            )
+            return None
+
+        columns, delimiter = self.csv_columns()
+        df = pd.concat(
+            [
+                pd.read_csv(file, sep=delimiter, dtype=str, keep_default_na=False)
+                for file in test_files
+            ],
+            ignore_index=True,
+        )
+
+        eval_dir = tempfile.mkdtemp(dir=self.temp_test_dir)
+
+        def evaluate_rows(rows: pd.DataFrame, name: str) -> Dict[str, float]:
+            path = os.path.join(eval_dir, f"{name}.csv")
+            rows[columns].to_csv(path, sep=delimiter, index=False)
+            return metrics_fn([path])
+
+        self.logger.info(
+            f"Running evaluation checks on {len(df)} holdout rows",
+            code=LogCode.MODEL_EVAL,
+        )
+        metrics = evaluate_rows(df, "holdout")
+
+        evaluation = {"metric": self.evaluation_metric, "metrics": metrics}
+        if options.group_column:
+            evaluation["groups"] = self.group_evaluation(df, options, evaluate_rows)
+        if options.perturbations:
+            evaluation["perturbations"] = self.perturbation_evaluation(
+                df, columns, options, metrics, evaluate_rows
+            )
+
+        failed_checks = [
+            check
+            for check in ["groups", "perturbations"]
+            if check in evaluation and not evaluation[check]["passed"]
+        ]
+        for check in failed_checks:
+            msg = f"Model did not pass the {check} evaluation check: {evaluation[check]}"
+            self.logger.warning(msg, code=LogCode.MODEL_EVAL)
+            self.reporter.report_warning(self.config.model_id, msg)
+
+        evaluation["passed"] = len(failed_checks) == 0
+        evaluation["failed_checks"] = failed_checks
+        return evaluation
+
+    def group_evaluation(
+        self,
+        df: pd.DataFrame,
+        options: EvaluationOptions,
+        evaluate_rows: Callable[[pd.DataFrame, str], Dict[str, float]],
+    ) -> Dict[str, Any]:
+        """
+        Computes the metrics of each group of the holdout data, and the gap
+        between the best and worst groups for the evaluation metric.
+        """
+        column = options.group_column
+        result = {
+            "column": column,
+            "min_group_size": options.min_group_size,
+            "max_gap": options.max_group_gap,
+        }
+        if column not in df.columns:
+            return {
+                **result,
+                "error": f"holdout data does not have the group column '{column}'",
+                "passed": False,
+            }
+
+        groups, skipped_groups = {}, {}
+        for i, (value, rows) in enumerate(df.groupby(column, sort=True)):
+            if len(rows) < options.min_group_size:
+                skipped_groups[value] = len(rows)
+                continue
+            groups[value] = {
+                "rows": len(rows),
+                "metrics": evaluate_rows(rows, f"group_{i}"),
+            }
+
+        scores = {
+            value: group["metrics"][self.evaluation_metric]
+            for value, group in groups.items()
+            if self.evaluation_metric in group["metrics"]
+        }
+        gap, worst_group = None, None
+        if scores:
+            worst_group = min(scores, key=scores.get)
+            gap = round(max(scores.values()) - scores[worst_group], 4)
+
+        passed = options.max_group_gap is None or (
+            gap is not None and gap <= options.max_group_gap
+        )
+        return {
+            **result,
+            "groups": groups,
+            "skipped_groups": skipped_groups,
+            "gap": gap,
+            "worst_group": worst_group,
+            "passed": passed,
+        }
+
+    def perturbation_evaluation(
+        self,
+        df: pd.DataFrame,
+        columns: List[str],
+        options: EvaluationOptions,
+        metrics: Dict[str, float],
+        evaluate_rows: Callable[[pd.DataFrame, str], Dict[str, float]],
+    ) -> Dict[str, Any]:
+        """
+        Computes the metrics of the model on copies of the holdout data with
+        each perturbation applied, and the drop in the evaluation metric from
+        the unperturbed data.
+        """
+        # The seed is recorded with the results so that they can be reproduced.
+        seed = options.perturbation_seed
+        if seed is None:
+            seed = random.randint(0, 2**31 - 1)
+        rng = np.random.default_rng(seed)
+
+        metric = self.evaluation_metric
+        results, failed_perturbations = {}, []
+        for i, perturbation in enumerate(options.perturbations):
+            perturbed = self.perturb(df, columns, perturbation, rng)
+            perturbed_metrics = evaluate_rows(perturbed, f"perturbed_{i}")
+
+            drop = None
+            if metric in metrics and metric in perturbed_metrics:
+                drop = round(metrics[metric] - perturbed_metrics[metric], 4)
+            results[perturbation] = {"metrics": perturbed_metrics, "drop": drop}
+
+            max_drop = options.max_perturbation_drop
+            if max_drop is not None and (drop is None or drop > max_drop):
+                failed_perturbations.append(perturbation)
+
+        return {
+            "seed": seed,
+            "max_drop": options.max_perturbation_drop,
+            "results": results,
+            "failed_perturbations": failed_perturbations,
+            "passed": len(failed_perturbations) == 0,
+        }
 
     @abstractmethod
     def train(self, **kwargs):
@@ -270,6 +694,11 @@ class TextClassificationModel(ClassificationModel):
 
             train_files, test_files = self.train_test_files()
 
+            train_files = self.valid_files(model, train_files)
+            test_files = self.valid_files(model, test_files)
+            if not train_files:
+                raise ValueError("No valid training files were found.")
+
             start_time = time.time()
             for train_file in train_files:
                 try:
@@ -300,7 +729,21 @@ class TextClassificationModel(ClassificationModel):
 
             self.save_model(model)
 
-            self.evaluate(model, test_files)
+            metrics = self.evaluate(model, test_files)
+
+            train_report = {}
+            holdout_evaluation = self.holdout_evaluation(metrics)
+            if holdout_evaluation:
+                train_report["holdout_evaluation"] = holdout_evaluation
+
+            evaluation = self.evaluation_checks(
+                test_files, lambda files: self.evaluate(model, files)
+            )
+            if evaluation:
+                train_report["evaluation"] = evaluation
+
+            if train_report or self.file_failures or self.class_balance:
+                self.write_train_report(train_report)
 
             num_params = self.get_num_params(model)
             model_size = self.get_size()
@@ -328,6 +771,30 @@ class TextClassificationModel(ClassificationModel):
             # Ensure cleanup of temporary directories after training, even on failure
             self.cleanup_temp_dirs()
 
+    def csv_columns(self) -> Tuple[List[str], str]:
+        return [
+            self.txt_cls_vars.text_column,
+            self.txt_cls_vars.label_column,
+        ], self.txt_cls_vars.delimiter
+
+    def verify_file(self, model: bolt.UniversalDeepTransformer, file: str):
+        text_column = self.txt_cls_vars.text_column
+        label_column = self.txt_cls_vars.label_column
+
+        try:
+            header = pd.read_csv(
+                file, nrows=0, delimiter=self.txt_cls_vars.delimiter
+            ).columns
+        except Exception:
+            raise ValueError(
+                f"Unable to load csv file {file}. Please ensure that it is a valid csv"
+            )
+
+        if text_column not in header or label_column not in header:
+            raise ValueError(
+                f"Expected csv to have columns '{text_column}' and '{label_column}'"
+            )
+
     def get_latency(self, model) -> float:
         self.logger.debug("Measuring latency of the UDT instance.")
 
@@ -377,6 +844,11 @@ class DocClassificationModel(TextClassificationModel):
                         is_training=False,
                     )
                     test_files = [test_csv_path]
+                    self.holdout_split = {
+                        "test_files": [
+                            file.path for file in self.config.data.test_files
+                        ]
+                    }
                 else:
                     test_files = []
 
@@ -395,7 +867,11 @@ class DocClassificationModel(TextClassificationModel):
                 # Save model and evaluate
                 self.save_model(model)
                 if test_files:
-                    self.evaluate(model, test_files)
+                    metrics = self.evaluate(model, test_files)
+                    holdout_evaluation = self.holdout_evaluation(metrics)
+                    self.write_train_report(
+                        {"holdout_evaluation": holdout_evaluation}
+                    )
 
                 # Report metrics
                 num_params = self.get_num_params(model)
@@ -451,7 +927,7 @@ class DocClassificationModel(TextClassificationModel):
                     # Truncate if needed
                     word_limit = getattr(self.txt_cls_vars, "word_limit", 1000)
                     words = text.split()[:word_limit]
-                    truncated_text = " ".join(words)
+                    truncated_text = self.preprocessing.apply(" ".join(words))
 
                 processed_docs.append({text_col: truncated_text, label_col: category})
             except Exception as e:
@@ -482,6 +958,8 @@ class PerTagMetrics:
 
 
 class TokenClassificationModel(ClassificationModel):
+    evaluation_metric = "macro_fmeasure"
+
     def __init__(self, config: TrainConfig, reporter: Reporter, logger: Logger):
         super().__init__(config, reporter, logger)
         self.load_storage()
@@ -508,6 +986,7 @@ class TokenClassificationModel(ClassificationModel):
                 self.logger.debug("Metadata updated to latest state")
 
                 shutil.move(temp_model_path, self.model_save_path)
+                self.save_preprocessing()
                 self.logger.debug(
                     f"Model moved to final destination at {self.model_save_path}"
                 )
@@ -639,6 +1118,45 @@ class TokenClassificationModel(ClassificationModel):
             metadata=Metadata(name="tags_and_status", data=tag_metadata, status=status)
         )
 
+    def csv_columns(self) -> Tuple[List[str], str]:
+        return [
+            self.tkn_cls_vars.source_column,
+            self.tkn_cls_vars.target_column,
+        ], ","
+
+    def preprocess(self, df: pd.DataFrame, columns: List[str]) -> pd.DataFrame:
+        return preprocess_token_columns(df, columns[0], columns[1], self.preprocessing)
+
+    def perturb(
+        self,
+        df: pd.DataFrame,
+        columns: List[str],
+        perturbation: str,
+        rng: np.random.Generator,
+    ) -> pd.DataFrame:
+        df = df.copy()
+        df[columns[0]] = df[columns[0]].map(
+            lambda source: perturb_tokens(source, perturbation, rng)
+        )
+        return df
+
+    def row_classes(self, df: pd.DataFrame, columns: List[str]) -> pd.Series:
+        # The class of a row is its least frequent tag, or the default tag if
+        # the row has no other tags.
+        default_tag = self.tkn_cls_vars.default_tag
+        tags = df[columns[1]].astype(str).str.split(" ")
+        counts = defaultdict(int)
+        for row in tags:
+            for tag in row:
+                counts[tag] += 1
+        return tags.map(
+            lambda row: min(
+                (tag for tag in row if tag != default_tag),
+                key=lambda tag: counts[tag],
+                default=default_tag,
+            )
+        )
+
     def verify_file(self, model: bolt.UniversalDeepTransformer, file: str):
         source_column, target_column = model.source_target_columns()
 
@@ -700,8 +1218,10 @@ class TokenClassificationModel(ClassificationModel):
 
             train_files, test_files = self.train_test_files()
 
-            for file in train_files + test_files:
-                self.verify_file(model, file)
+            train_files = self.valid_files(model, train_files)
+            test_files = self.valid_files(model, test_files)
+            if not train_files:
+                raise ValueError("No valid training files were found.")
 
             if len(test_files):
                 before_train_metrics = self.per_tag_metrics(
@@ -808,7 +1328,20 @@ class TokenClassificationModel(ClassificationModel):
                 self.save_train_report(
                     before_train_metrics=before_train_metrics,
                     after_train_metrics=after_train_metrics,
+                    holdout_evaluation=self.holdout_evaluation(
+                        self.macro_metrics(after_train_metrics)
+                    ),
+                    evaluation=self.evaluation_checks(
+                        test_files,
+                        lambda files: self.macro_metrics(
+                            self.per_tag_metrics(
+                                model=model, test_files=files, samples_to_collect=0
+                            )
+                        ),
+                    ),
                 )
+            elif self.file_failures:
+                self.write_train_report({})
 
             # converts the status of all tags to trained and update in the storage
             for tag in tags.tag_status:
@@ -944,8 +1477,24 @@ class TokenClassificationModel(ClassificationModel):
             false_negatives=remove_null_tag(false_negative_samples),
         )
 
+    def macro_metrics(self, metrics: PerTagMetrics) -> Dict[str, float]:
+        """
+        Averages the per tag metrics across tags, ignoring tags where a metric is
+        undefined because the tag never occurs or is never predicted.
+        """
+        summary = {}
+        for name in ["precision", "recall", "fmeasure"]:
+            values = [m[name] for m in metrics.metrics.values() if m[name] != "NaN"]
+            if values:
+                summary[f"macro_{name}"] = round(sum(values) / len(values), 3)
+        return summary
+
     def save_train_report(
-        self, before_train_metrics: PerTagMetrics, after_train_metrics: PerTagMetrics
+        self,
+        before_train_metrics: PerTagMetrics,
+        after_train_metrics: PerTagMetrics,
+        holdout_evaluation: Optional[Dict[str, Any]] = None,
+        evaluation: Optional[Dict[str, Any]] = None,
     ):
         try:
             train_report = {
@@ -957,16 +1506,12 @@ class TokenClassificationModel(ClassificationModel):
                     "false_negatives": after_train_metrics.false_negatives,
                 },
             }
+            if holdout_evaluation:
+                train_report["holdout_evaluation"] = holdout_evaluation
+            if evaluation:
+                train_report["evaluation"] = evaluation
 
-            timestamp = int(datetime.now(timezone.utc).timestamp())
-            report_path = self.model_dir / "train_reports" / f"{timestamp}.json"
-            os.makedirs(os.path.dirname(report_path), exist_ok=True)
-            with open(report_path, "w") as file:
-                json.dump(train_report, file, indent=4)
-
-            self.logger.info(
-                f"Saved train report to {report_path}", code=LogCode.MODEL_TRAIN
-            )
+            self.write_train_report(train_report)
 
         except Exception as e:
             self.logger.error(
@@ -1,9 +1,13 @@
+import json
+import os
 from abc import ABC, abstractmethod
+from datetime import datetime, timezone
 from pathlib import Path
+from typing import Any, Dict, List
 
 import thirdai
-from platform_common.logging import JobLogger
-from platform_common.pydantic_models.training import TrainConfig
+from platform_common.logging import JobLogger, LogCode
+from platform_common.pydantic_models.training import FileValidation, TrainConfig
 from train_job.reporter import Reporter
 
 
@@ -51,8 +55,85 @@ class Model(ABC):
             self.model_dir / "checkpoints" / "supervised"
         )
 
+        # Training files skipped because of validation failures, these are only
+        # collected when the file validation mode is skip_and_report.
+        self.file_failures: List[Dict[str, str]] = []
+
         self.logger.info("Directory setup complete.")
 
+    @property
+    def file_validation(self) -> FileValidation:
+        return getattr(self.config.data, "file_validation", FileValidation.strict)
+
+    def handle_file_failure(self, path: str, error: Exception):
+        """
+        Handles a training file that failed validation or could not be processed.
+        In strict mode the error is raised so the job fails, otherwise the failure
+        is recorded for the train report and reported as a warning so that
+        training can continue with the remaining files.
+        """
+        if self.file_validation == FileValidation.strict:
+            raise error
+
+        msg = f"Skipping file {path} due to error: {error}"
+        self.logger.warning(msg, code=LogCode.FILE_VALIDATION)
+        self.reporter.report_warning(self.config.model_id, msg)
+        self.file_failures.append({"file": path, "error": str(error)})
+
+    def write_train_report(self, train_report: Dict[str, Any]):
+        """
+        Saves the train report for the job, including any skipped files.
+        """
+        train_report = {**train_report, "file_failures": self.file_failures}
+
+        timestamp = int(datetime.now(timezone.utc).timestamp())
+        report_path = self.model_dir / "train_reports" / f"{timestamp}.json"
+        os.makedirs(os.path.dirname(report_path), exist_ok=True)
+        with open(report_path, "w") as file:
+            json.dump(train_report, file, indent=4)
+
+        self.logger.info(
+            f"Saved train report to {report_path}", code=LogCode.MODEL_TRAIN
+        )
+
+    @property
+    def eval_thresholds(self) -> Dict[str, float]:
+        train_options = getattr(self.config, "train_options", None)
+        return getattr(train_options, "eval_thresholds", None) or {}
+
+    def report_holdout_evaluation(
+        self, split: Dict[str, Any], metrics: Dict[str, float]
+    ) -> Dict[str, Any]:
+        """
+        Reports the metrics computed on the holdout data to the platform, which
+        blocks deployment of the model if any metric is below its threshold.
+        Returns the evaluation so that it can be included in the train report.
+        """
+        thresholds = self.eval_thresholds
+        failed_metrics = sorted(
+            metric
+            for metric, threshold in thresholds.items()
+            if metric not in metrics or metrics[metric] < threshold
+        )
+
+        if failed_metrics:
+            self.logger.warning(
+                f"Model did not meet the holdout evaluation thresholds for metrics: {failed_metrics}",
+                code=LogCode.MODEL_EVAL,
+            )
+
+        self.reporter.report_evaluation(
+            self.config.model_id, split=split, metrics=metrics, thresholds=thresholds
+        )
+
+        return {
+            "split": split,
+            "metrics": metrics,
+            "thresholds": thresholds,
+            "passed": len(failed_metrics) == 0,
+            "failed_metrics": failed_metrics,
+        }
+
     @abstractmethod
     def train(self, **kwargs):
         """
@@ -15,7 +15,12 @@ from platform_common.ndb.ndbv2_parser import parse_doc
 from platform_common.ndb.utils import delete_docs_and_remove_files
 from platform_common.pydantic_models.feedback_logs import ActionType, FeedbackLog
 from platform_common.pydantic_models.llm_config import LLMProvider
-from platform_common.pydantic_models.training import FileInfo, FileLocation, TrainConfig
+from platform_common.pydantic_models.training import (
+    FileInfo,
+    FileLocation,
+    FileValidation,
+    TrainConfig,
+)
 from thirdai import neural_db_v2 as ndbv2
 from thirdai.neural_db_v2.chunk_stores import PandasChunkStore
 from thirdai.neural_db_v2.retrievers import FinetunableRetriever
@@ -146,6 +151,7 @@ class NeuralDBV2(Model):
         successfully_indexed_files = 0
 
         batches = [files[i : i + batch_size] for i in range(0, len(files), batch_size)]
+        indexing_start = time.perf_counter()
         with mp.Pool(processes=n_jobs) as pool:
             first_batch_start = time.perf_counter()
             curr_batch = pool.starmap(
@@ -172,12 +178,10 @@ class NeuralDBV2(Model):
                 docs = []
                 for doc_idx, doc in enumerate(curr_batch):
                     if not doc:
-                        msg = f"Unable to parse {batches[i][doc_idx].path}. Unsupported filetype."
+                        path = batches[i][doc_idx].path
+                        msg = f"Unable to parse {path}. Unsupported filetype."
                         self.logger.error(msg, code=LogCode.MODEL_INSERT)
-                        self.reporter.report_warning(
-                            model_id=self.config.model_id,
-                            message=msg,
-                        )
+                        self.handle_file_failure(path, ValueError(msg))
                     else:
                         docs.append(doc)
 
@@ -198,6 +202,15 @@ class NeuralDBV2(Model):
                     f"total documents indexed so far: {docs_indexed}"
                 )
 
+                seconds_per_batch = (end - indexing_start) / (i + 1)
+                self.reporter.report_progress(
+                    model_id=self.config.model_id,
+                    phase="indexing",
+                    step=i + 1,
+                    total_steps=len(batches),
+                    eta_seconds=seconds_per_batch * (len(batches) - i - 1),
+                )
+
         total_chunks = self.db.retriever.retriever.size()
         self.logger.info(
             f"Completed unsupervised training total_docs={docs_indexed} total_chunks={total_chunks}.",
@@ -304,10 +317,7 @@ class NeuralDBV2(Model):
                 except Exception as e:
                     msg = f"Failed to train on file {file.path} with error {e}"
                     self.logger.error(msg, code=LogCode.MODEL_RLHF)
-                    self.reporter.report_warning(
-                        model_id=self.config.model_id,
-                        message=msg,
-                    )
+                    self.handle_file_failure(file.path, e)
             else:
                 try:
                     supervised_dataset = ndbv2.supervised.CsvSupervised(
@@ -327,10 +337,7 @@ class NeuralDBV2(Model):
                 except Exception as e:
                     msg = f"Failed to train on file {file.path} with error {e}"
                     self.logger.error(msg, code=LogCode.MODEL_RLHF)
-                    self.reporter.report_warning(
-                        model_id=self.config.model_id,
-                        message=msg,
-                    )
+                    self.handle_file_failure(file.path, e)
 
         self.logger.info("Completed supervised training.", code=LogCode.MODEL_RLHF)
 
@@ -407,6 +414,14 @@ class NeuralDBV2(Model):
         train_time = (unsup_end - unsup_start) + (sup_end - sup_start)
         self.logger.debug(f"Total training time: {train_time:.4f} seconds")
 
+        if self.file_validation == FileValidation.skip_and_report:
+            self.write_train_report(
+                {
+                    "indexed_files": successfully_indexed_files,
+                    "trained_files": successfully_trained_files,
+                }
+            )
+
         if self.config.data.deletions:
             delete_docs_and_remove_files(
                 db=self.db,
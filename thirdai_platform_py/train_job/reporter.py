@@ -1,5 +1,5 @@
 from abc import ABC, abstractmethod
-from typing import Dict, Optional
+from typing import Any, Dict, Optional
 from urllib.parse import urljoin
 
 import requests
@@ -19,6 +19,27 @@ class Reporter(ABC):
     def report_warning(self, model_id: str, message: str):
         raise NotImplementedError
 
+    @abstractmethod
+    def report_progress(
+        self,
+        model_id: str,
+        phase: str,
+        step: int,
+        total_steps: int,
+        eta_seconds: Optional[float] = None,
+    ):
+        raise NotImplementedError
+
+    @abstractmethod
+    def report_evaluation(
+        self,
+        model_id: str,
+        split: Dict[str, Any],
+        metrics: Dict[str, float],
+        thresholds: Dict[str, float],
+    ):
+        raise NotImplementedError
+
 
 class HttpReporter(Reporter):
     def __init__(self, api_url: str, auth_token: str, logger: JobLogger):
@@ -89,7 +110,11 @@ class HttpReporter(Reporter):
         if status == "failed":
             self.report_error(message)
 
-        self._request("post", "api/v2/train/update-status", json={"status": status})
+        self._request(
+            "post",
+            "api/v2/train/update-status",
+            json={"status": status, "message": message},
+        )
 
     def report_error(self, message: str):
         self._request(
@@ -104,3 +129,53 @@ class HttpReporter(Reporter):
             "api/v2/train/log",
             json={"level": "warning", "message": message},
         )
+
+    def report_progress(
+        self,
+        model_id: str,
+        phase: str,
+        step: int,
+        total_steps: int,
+        eta_seconds: Optional[float] = None,
+    ):
+        """
+        Report the progress of a training job. Failures are logged but not raised
+        since progress updates are informational and should not fail training.
+        Args:
+            model_id (str): The ID of the model.
+            phase (str): The current phase of training, e.g. 'indexing'.
+            step (int): The number of steps completed in the current phase.
+            total_steps (int): The total number of steps in the current phase.
+            eta_seconds (float, optional): Estimated seconds until the phase completes.
+        """
+        json_data = {"phase": phase, "step": step, "total_steps": total_steps}
+        if eta_seconds is not None:
+            json_data["eta_seconds"] = eta_seconds
+        try:
+            self._request("post", "api/v2/train/update-progress", json=json_data)
+        except requests.exceptions.RequestException:
+            pass
+
+    def report_evaluation(
+        self,
+        model_id: str,
+        split: Dict[str, Any],
+        metrics: Dict[str, float],
+        thresholds: Dict[str, float],
+    ):
+        """
+        Report the results of the holdout evaluation run after training. The
+        platform checks the metrics against the eval thresholds of the train
+        config it saved to decide if the model can be deployed, the thresholds
+        reported here are ignored.
+        Args:
+            model_id (str): The ID of the model.
+            split (Dict[str, Any]): Description of how the holdout data was created.
+            metrics (Dict[str, float]): The metrics computed on the holdout data.
+            thresholds (Dict[str, float]): The minimum value for each metric.
+        """
+        self._request(
+            "post",
+            "api/v2/train/update-evaluation",
+            json={"split": split, "metrics": metrics, "thresholds": thresholds},
+        )
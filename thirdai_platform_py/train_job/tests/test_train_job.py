@@ -1,7 +1,8 @@
+import json
 import os
 import shutil
 from pathlib import Path
-from typing import Dict
+from typing import Any, Dict, Optional
 
 import pandas as pd
 import pytest
@@ -14,7 +15,9 @@ from platform_common.pydantic_models.feedback_logs import (
     UpvoteLog,
 )
 from platform_common.pydantic_models.training import (
+    EvaluationOptions,
     FileInfo,
+    FileValidation,
     JobOptions,
     NDBData,
     NDBOptions,
@@ -44,6 +47,25 @@ class DummyReporter(Reporter):
     def report_warning(self, model_id: str, message: str):
         pass
 
+    def report_progress(
+        self,
+        model_id: str,
+        phase: str,
+        step: int,
+        total_steps: int,
+        eta_seconds: Optional[float] = None,
+    ):
+        pass
+
+    def report_evaluation(
+        self,
+        model_id: str,
+        split: Dict[str, Any],
+        metrics: Dict[str, float],
+        thresholds: Dict[str, float],
+    ):
+        self.evaluation = {"split": split, "metrics": metrics, "thresholds": thresholds}
+
 
 MODEL_BAZAAR_DIR = "./model_bazaar_tmp"
 
@@ -83,7 +105,11 @@ def create_tmp_model_bazaar_dir():
     shutil.rmtree(MODEL_BAZAAR_DIR)
 
 
-def run_ndb_train_job(extra_supervised_files=[], on_disk=True):
+def run_ndb_train_job(
+    extra_supervised_files=[],
+    on_disk=True,
+    file_validation=FileValidation.skip_and_report,
+):
     verify_license.verify_and_activate(THIRDAI_LICENSE)
 
     source_id = ndb.CSV(
@@ -130,6 +156,7 @@ def run_ndb_train_job(extra_supervised_files=[], on_disk=True):
                 ),
                 *extra_supervised_files,
             ],
+            file_validation=file_validation,
             test_files=[
                 FileInfo(
                     path=os.path.join(file_dir(), "supervised.csv"),
@@ -188,6 +215,51 @@ def test_ndbv2_train(feedback_train_file, on_disk):
     assert len(db.documents()) == 3
 
 
+@pytest.fixture()
+def malformed_supervised_file():
+    filename = "./malformed_supervised.csv"
+    pd.DataFrame({"not_query": ["a query"], "not_id": [0]}).to_csv(filename)
+    yield filename
+    os.remove(filename)
+
+
+def test_ndbv2_train_skips_invalid_files(malformed_supervised_file):
+    run_ndb_train_job(
+        extra_supervised_files=[
+            FileInfo(
+                path=malformed_supervised_file,
+                location="local",
+                options={"csv_query_column": "query", "csv_id_column": "id"},
+            )
+        ],
+    )
+
+    report_dir = os.path.join(MODEL_BAZAAR_DIR, "models", "ndb_123", "train_reports")
+    reports = os.listdir(report_dir)
+    assert len(reports) == 1
+
+    with open(os.path.join(report_dir, reports[0])) as f:
+        report = json.load(f)
+
+    assert report["trained_files"] == 1
+    assert len(report["file_failures"]) == 1
+    assert report["file_failures"][0]["file"] == malformed_supervised_file
+
+
+def test_ndbv2_train_strict_file_validation(malformed_supervised_file):
+    with pytest.raises(Exception):
+        run_ndb_train_job(
+            extra_supervised_files=[
+                FileInfo(
+                    path=malformed_supervised_file,
+                    location="local",
+                    options={"csv_query_column": "query", "csv_id_column": "id"},
+                )
+            ],
+            file_validation=FileValidation.strict,
+        )
+
+
 def test_udt_text_train():
     verify_license.verify_and_activate(THIRDAI_LICENSE)
 
@@ -236,6 +308,131 @@ def test_udt_text_train():
     )
 
 
+def test_udt_text_train_holdout_evaluation():
+    verify_license.verify_and_activate(THIRDAI_LICENSE)
+
+    config = TrainConfig(
+        model_type="nlp-text",
+        user_id="user_123",
+        model_bazaar_dir=MODEL_BAZAAR_DIR,
+        license_key=THIRDAI_LICENSE,
+        model_bazaar_endpoint="",
+        model_id="udt_123",
+        data_id="data_123",
+        model_options=NlpTextOptions(
+            text_column="text", label_column="id", n_target_classes=100
+        ),
+        train_options=NlpTrainOptions(
+            test_split=0.25,
+            test_split_seed=7,
+            eval_thresholds={"precision@1": 0.0, "missing_metric": 0.5},
+        ),
+        data=UDTData(
+            supervised_files=[
+                FileInfo(
+                    path=os.path.join(file_dir(), "articles.csv"), location="local"
+                ),
+            ],
+        ),
+        job_options=JobOptions(),
+        job_auth_token="",
+    )
+
+    reporter = DummyReporter()
+    model = get_model(config, reporter, logger)
+
+    model.train()
+
+    split = reporter.evaluation["split"]
+    assert split["test_split"] == 0.25 and split["seed"] == 7
+    assert split["test_rows"] > 0 and split["train_rows"] > split["test_rows"]
+    assert "precision@1" in reporter.evaluation["metrics"]
+
+    report_dir = os.path.join(MODEL_BAZAAR_DIR, "models", "udt_123", "train_reports")
+    reports = os.listdir(report_dir)
+    assert len(reports) == 1
+
+    with open(os.path.join(report_dir, reports[0])) as f:
+        report = json.load(f)
+
+    assert not report["holdout_evaluation"]["passed"]
+    assert report["holdout_evaluation"]["failed_metrics"] == ["missing_metric"]
+
+
+@pytest.fixture()
+def grouped_articles_file():
+    df = pd.read_csv(os.path.join(file_dir(), "articles.csv"))
+    df["source"] = ["wire" if i % 3 else "blog" for i in range(len(df))]
+    file_path = os.path.join(file_dir(), "grouped_articles.csv")
+
+    df.to_csv(file_path, index=False)
+    yield file_path
+    os.remove(file_path)
+
+
+def test_udt_text_train_evaluation_checks(grouped_articles_file):
+    verify_license.verify_and_activate(THIRDAI_LICENSE)
+
+    config = TrainConfig(
+        model_type="nlp-text",
+        user_id="user_123",
+        model_bazaar_dir=MODEL_BAZAAR_DIR,
+        license_key=THIRDAI_LICENSE,
+        model_bazaar_endpoint="",
+        model_id="udt_123",
+        data_id="data_123",
+        model_options=NlpTextOptions(
+            text_column="text", label_column="id", n_target_classes=100
+        ),
+        train_options=NlpTrainOptions(
+            test_split=0.25,
+            test_split_seed=7,
+            evaluation=EvaluationOptions(
+                group_column="source",
+                min_group_size=1,
+                max_group_gap=1.0,
+                perturbations=["lowercase", "typos"],
+                perturbation_seed=3,
+                max_perturbation_drop=-1.0,
+            ),
+        ),
+        data=UDTData(
+            supervised_files=[
+                FileInfo(path=grouped_articles_file, location="local"),
+            ],
+        ),
+        job_options=JobOptions(),
+        job_auth_token="",
+    )
+
+    model = get_model(config, DummyReporter(), logger)
+
+    model.train()
+
+    report_dir = os.path.join(MODEL_BAZAAR_DIR, "models", "udt_123", "train_reports")
+    reports = os.listdir(report_dir)
+    assert len(reports) == 1
+
+    with open(os.path.join(report_dir, reports[0])) as f:
+        evaluation = json.load(f)["evaluation"]
+
+    assert evaluation["metric"] == "precision@1"
+    assert "precision@1" in evaluation["metrics"]
+
+    groups = evaluation["groups"]
+    assert groups["column"] == "source" and groups["passed"]
+    assert set(groups["groups"]) == {"blog", "wire"}
+    assert groups["gap"] is not None
+
+    # The perturbation check fails since the maximum drop is negative.
+    perturbations = evaluation["perturbations"]
+    assert perturbations["seed"] == 3
+    assert set(perturbations["results"]) == {"lowercase", "typos"}
+    assert perturbations["failed_perturbations"] == ["lowercase", "typos"]
+    assert not evaluation["passed"]
+    assert evaluation["failed_checks"] == ["perturbations"]
+
+
 @pytest.fixture()
 def doc_classification_files():
     base_dir = os.path.join(file_dir(), "doc_classification_test_data")
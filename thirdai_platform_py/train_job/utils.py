@@ -1,24 +1,289 @@
 import logging
 import os
 import shutil
+import string
 import sys
 from pathlib import Path
-from typing import List
+from typing import Callable, List, Optional
 
-from platform_common.pydantic_models.training import FileInfo, FileLocation
+import numpy as np
+import pandas as pd
+import pyarrow.parquet as pq
+from platform_common.pydantic_models.training import (
+    CSVOptions,
+    FileInfo,
+    FileLocation,
+    NlpTrainOptions,
+    TextPreprocessing,
+)
 from thirdai import neural_db as ndb
 
 GB_1 = 1024 * 1024 * 1024  # Define 1 GB in bytes
 
 
-def check_csv_only(all_files: List[FileInfo]):
+TRAINABLE_FORMATS = [".csv", ".parquet", ".jsonl"]
+
+
+def check_trainable_formats(all_files: List[FileInfo]):
     for file in all_files:
-        if file.ext() != ".csv":
+        if file.ext().lower() not in TRAINABLE_FORMATS:
             raise ValueError(
-                "Only CSV files are supported for supervised training and test."
+                "Only CSV, Parquet, and JSONL files are supported for supervised training and test."
             )
 
 
+# Maps the encoding names accepted by the platform to python codecs. utf-8-sig
+# is used so that a BOM at the start of the file is removed if present.
+CSV_ENCODINGS = {
+    "utf-8": "utf-8-sig",
+    "utf-16": "utf-16",
+    "latin-1": "latin-1",
+    "windows-1252": "cp1252",
+}
+
+
+def detect_csv_encoding(path: str) -> str:
+    with open(path, "rb") as file:
+        sample = file.read(64 * 1024)
+
+    if sample.startswith((b"\xff\xfe", b"\xfe\xff")):
+        return "utf-16"
+
+    try:
+        sample.decode("utf-8")
+        return "utf-8-sig"
+    except UnicodeDecodeError as e:
+        # The sample may end in the middle of a multi-byte character.
+        if e.start >= len(sample) - 3:
+            return "utf-8-sig"
+        return "cp1252"
+
+
+def normalize_csv(
+    path: str,
+    output_path: str,
+    csv_options: CSVOptions,
+    columns: List[str],
+    output_delimiter: str = ",",
+    preprocess: Optional[Callable[[pd.DataFrame], pd.DataFrame]] = None,
+    optional_columns: List[str] = [],
+):
+    """
+    Converts a csv file with an arbitrary delimiter, quote character, and
+    encoding into a utf-8 csv with the given delimiter, keeping only the
+    specified columns, and the optional columns if the file has them. If
+    preprocess is specified it is applied to the selected columns before
+    writing.
+    """
+    if csv_options.encoding:
+        encoding = CSV_ENCODINGS[csv_options.encoding]
+    else:
+        encoding = detect_csv_encoding(path)
+
+    df = pd.read_csv(
+        path,
+        sep=csv_options.delimiter or None,
+        quotechar=csv_options.quote or '"',
+        encoding=encoding,
+        engine="python",
+        dtype=str,
+        keep_default_na=False,
+    )
+    df.columns = [column.strip() for column in df.columns]
+
+    missing = [column for column in columns if column not in df.columns]
+    if missing:
+        raise ValueError(
+            f"Expected csv {path} to have columns {columns}, missing {missing}"
+        )
+
+    df = df[columns + present_columns(df, columns, optional_columns)]
+    if preprocess:
+        df = preprocess(df)
+
+    df.to_csv(output_path, sep=output_delimiter, index=False)
+
+
+def present_columns(
+    df: pd.DataFrame, columns: List[str], optional_columns: List[str]
+) -> List[str]:
+    return [
+        column
+        for column in optional_columns
+        if column in df.columns and column not in columns
+    ]
+
+
+def tabular_to_csv(
+    path: str,
+    output_path: str,
+    columns: List[str],
+    output_delimiter: str = ",",
+    preprocess: Optional[Callable[[pd.DataFrame], pd.DataFrame]] = None,
+    optional_columns: List[str] = [],
+):
+    """
+    Converts a parquet or jsonl file into a utf-8 csv with the given delimiter,
+    keeping only the specified columns, and the optional columns if the file has
+    them. Columns containing lists of tokens are joined with spaces, which is
+    the format expected for token classification.
+    """
+    if path.lower().endswith(".parquet"):
+        names = pq.read_schema(path).names
+        optional = [c for c in optional_columns if c in names and c not in columns]
+        df = pd.read_parquet(path, columns=columns + optional)
+    else:
+        df = pd.read_json(path, lines=True, dtype=False)
+
+    missing = [column for column in columns if column not in df.columns]
+    if missing:
+        raise ValueError(
+            f"Expected {path} to have columns {columns}, missing {missing}"
+        )
+
+    optional = present_columns(df, columns, optional_columns)
+    df = df[columns + optional]
+    for column in columns:
+        df[column] = df[column].map(
+            lambda value: (
+                " ".join(map(str, value))
+                if isinstance(value, (list, tuple, np.ndarray))
+                else value
+            )
+        )
+        if df[column].isna().any():
+            raise ValueError(f"Column '{column}' in {path} contains null values")
+
+    for column in optional:
+        df[column] = df[column].fillna("").astype(str)
+
+    if preprocess:
+        df = preprocess(df)
+
+    df.to_csv(output_path, sep=output_delimiter, index=False)
+
+
+def preprocess_text_column(
+    df: pd.DataFrame, column: str, preprocessing: TextPreprocessing
+) -> pd.DataFrame:
+    df = df.copy()
+    df[column] = df[column].astype(str).map(preprocessing.apply)
+    return df
+
+
+def preprocess_token_columns(
+    df: pd.DataFrame,
+    source_column: str,
+    target_column: str,
+    preprocessing: TextPreprocessing,
+) -> pd.DataFrame:
+    """
+    Applies the preprocessing to each source token. Tokens that are empty after
+    html tags are stripped are removed along with their tags so that the source
+    and target tokens stay aligned.
+    """
+
+    def process_row(source: str, target: str):
+        sources, targets = [], []
+        for token, tag in zip(str(source).split(" "), str(target).split(" ")):
+            token = preprocessing.apply(token).replace(" ", "")
+            if token or not preprocessing.strip_html:
+                sources.append(token)
+                targets.append(tag)
+        return " ".join(sources), " ".join(targets)
+
+    rows = [
+        process_row(source, target)
+        for source, target in zip(df[source_column], df[target_column])
+    ]
+    df = df.copy()
+    df[source_column] = [source for source, _ in rows]
+    df[target_column] = [target for _, target in rows]
+    return df
+
+
+# Fraction of the words with more than 3 characters that get a typo.
+TYPO_RATE = 0.2
+
+PUNCTUATION_TABLE = str.maketrans("", "", string.punctuation)
+
+
+def perturb_text(text: str, perturbation: str, rng: np.random.Generator) -> str:
+    """
+    Applies a perturbation to the text for the robustness tests of the train
+    report. Typos swap two adjacent characters of a random subset of the words.
+    """
+    if perturbation == "lowercase":
+        return text.lower()
+    if perturbation == "uppercase":
+        return text.upper()
+    if perturbation == "remove_punctuation":
+        return text.translate(PUNCTUATION_TABLE)
+    if perturbation == "typos":
+        words = text.split(" ")
+        for i, word in enumerate(words):
+            if len(word) > 3 and rng.random() < TYPO_RATE:
+                j = int(rng.integers(len(word) - 1))
+                words[i] = word[:j] + word[j + 1] + word[j] + word[j + 2 :]
+        return " ".join(words)
+    raise ValueError(f"Unknown perturbation '{perturbation}'")
+
+
+def perturb_tokens(source: str, perturbation: str, rng: np.random.Generator) -> str:
+    """
+    Applies the perturbation to each token separately so that the tokens stay
+    aligned with their tags. Tokens that would be empty are left unchanged.
+    """
+    tokens = str(source).split(" ")
+    return " ".join(perturb_text(token, perturbation, rng) or token for token in tokens)
+
+
+def rebalance_rows(
+    df: pd.DataFrame, class_column: str, options: NlpTrainOptions, seed: int
+) -> pd.DataFrame:
+    """
+    Mitigates class imbalance by resampling the rows of the dataframe, where
+    class_column gives the class of each row. Random oversampling samples rows
+    of the smaller classes with replacement until each class has at least
+    oversampling_ratio times as many rows as the largest class. Class weights
+    are then applied by repeating each row floor(weight) times, and once more
+    with probability equal to the fractional part of the weight, since the
+    models do not support weighted losses. Balanced weights are inversely
+    proportional to the size of each class, so the expected number of rows is
+    unchanged.
+    """
+    rng = np.random.default_rng(seed)
+    df = df.reset_index(drop=True)
+
+    if options.oversampling == "random":
+        counts = df[class_column].value_counts()
+        target = int(np.ceil(counts.max() * options.oversampling_ratio))
+        extra = []
+        for cls, count in counts.items():
+            if count < target:
+                rows = np.flatnonzero((df[class_column] == cls).to_numpy())
+                extra.append(rng.choice(rows, size=target - count, replace=True))
+        if extra:
+            df = df.iloc[np.concatenate([np.arange(len(df)), *extra])]
+            df = df.reset_index(drop=True)
+
+    if options.class_weighting == "balanced":
+        counts = df[class_column].value_counts()
+        weights = (len(df) / (len(counts) * counts)).to_dict()
+    elif options.class_weighting == "custom":
+        weights = options.class_weights
+    else:
+        weights = {}
+
+    if weights:
+        row_weights = df[class_column].map(lambda c: weights.get(c, 1.0)).to_numpy()
+        repeats = np.floor(row_weights).astype(int)
+        repeats += rng.random(len(row_weights)) < (row_weights - repeats)
+        df = df.iloc[np.repeat(np.arange(len(df)), repeats)]
+
+    return df.sample(frac=1, random_state=seed).reset_index(drop=True)
+
+
 def check_local_nfs_only(files: List[FileInfo]):
     for file in files:
         if file.location != FileLocation.local and file.location != FileLocation.nfs: